@@ -6,13 +6,15 @@
 {{- end }}
 {{- end }}
 
-{{- /* Shared machine section: type, nodeLabels (controlplane), kubelet, sysctls, kernel, certSANs, files, install */ -}}
+{{- /* Shared machine section: nodeLabels/nodeAnnotations/nodeTaints, type, kubelet, sysctls, kernel, certSANs, files, install */ -}}
 {{- define "talos.config.machine.common" }}
 machine:
+  {{- $deleteLabels := list }}
   {{- if eq .MachineType "controlplane" }}
-  nodeLabels:
-    node.kubernetes.io/exclude-from-external-load-balancers:
-      $patch: delete
+  {{- $deleteLabels = list "node.kubernetes.io/exclude-from-external-load-balancers" }}
+  {{- end }}
+  {{- with include "talm.config.machine.node_metadata" (dict "Values" .Values "deleteLabels" $deleteLabels) }}
+  {{- . | nindent 2 }}
   {{- end }}
   type: {{ .MachineType }}
   kubelet:
@@ -255,6 +257,7 @@ cluster:
 
 {{- /* Shared legacy network section for machine.network */ -}}
 {{- define "talos.config.network.legacy" }}
+{{- include "talm.validate.node_interfaces_legacy" . }}
 {{- /* Coerce floatingIP through toString and call the shared
        talm.validate_floatingIP partial so legacy renders fail at
        template time on a malformed value, same as the multi-doc
@@ -8,7 +8,29 @@
 
 {{- /* Shared machine section: type, kubelet, certSANs, install */ -}}
 {{- define "talos.config.machine.common" }}
+{{- /* Official system extensions resolve into the Image Factory
+       installer image that carries them, plus the kernel modules and
+       sysctls they need. The operator's own extraSysctls and
+       extraKernelModules entries win over the extension defaults. */ -}}
+{{- $ext := dict }}
+{{- with .Values.extensions }}
+{{- $ext = talosExtensions $.TalosVersion . }}
+{{- end }}
+{{- $sysctls := merge (dict) (.Values.extraSysctls | default dict) ($ext.sysctls | default dict) }}
+{{- $modules := .Values.extraKernelModules | default list }}
+{{- $moduleNames := list }}
+{{- range $modules }}
+{{- $moduleNames = append $moduleNames .name }}
+{{- end }}
+{{- range $ext.kernelModules }}
+{{- if not (has .name $moduleNames) }}
+{{- $modules = append $modules . }}
+{{- end }}
+{{- end }}
 machine:
+  {{- with include "talm.config.machine.node_metadata" (dict "Values" .Values "deleteLabels" (list)) }}
+  {{- . | nindent 2 }}
+  {{- end }}
   type: {{ .MachineType }}
   kubelet:
     nodeIP:
@@ -42,11 +64,11 @@ machine:
   certSANs:
   {{- toYaml . | nindent 2 }}
   {{- end }}
-  {{- with .Values.extraSysctls }}
+  {{- with $sysctls }}
   sysctls:
     {{- toYaml . | nindent 4 }}
   {{- end }}
-  {{- with .Values.extraKernelModules }}
+  {{- with $modules }}
   kernel:
     modules:
       {{- toYaml . | nindent 6 }}
@@ -58,6 +80,9 @@ machine:
   install:
     {{- (include "talm.discovered.disks_info" .) | nindent 4 }}
     disk: {{ include "talm.discovered.system_disk_name" . | quote }}
+    {{- with $ext.image }}
+    image: {{ . }}
+    {{- end }}
 {{- end }}
 
 {{- /* Shared cluster section */ -}}
@@ -105,6 +130,7 @@ cluster:
 {{- include "talm.config.network.multidoc" . }}
 {{- end }}
 {{- define "talos.config.network.legacy" }}
+{{- include "talm.validate.node_interfaces_legacy" . }}
 {{- /* Coerce floatingIP through toString and call the shared
        talm.validate_floatingIP partial so legacy renders fail at
        template time on a malformed value, same as the multi-doc
@@ -183,6 +183,65 @@
 {{- end }}
 {{- end }}
 
+{{- /* JSON object of the hardware facts of the node, for charts that
+       enable a feature only where the hardware has it:
+
+         gpus       PCI display controllers (class 0x03), each with its
+                    PCI address, vendor, product, IDs and bound driver
+         sriovNics  PCI network controllers (class 0x02) bound to a driver
+                    that implements SR-IOV virtual functions
+         cpuSockets populated CPU sockets, the lower bound of NUMA nodes
+         cpuCores   enabled cores over all sockets
+         cpuThreads threads over all sockets
+         memoryMiB  installed memory over all modules
+
+       Talos reports neither the SR-IOV capability of a device nor the NUMA
+       layout nor the CPU flags, so sriovNics goes by the driver, and a chart
+       sizes hugepages from memoryMiB: every amd64 and arm64 CPU Talos runs on
+       has 2 MiB pages. Offline, or on a node whose firmware publishes no SMBIOS
+       tables, the lists are empty and the counts zero. */ -}}
+{{- define "talm.discovered.hardware" -}}
+{{- $sriovDrivers := list "bnxt_en" "i40e" "iavf" "ice" "igb" "ixgbe" "mlx4_core" "mlx5_core" "qede" "sfc" -}}
+{{- $gpus := list -}}
+{{- $sriovNics := list -}}
+{{- range (lookup "pcidevices" "" "").items -}}
+{{- $device := dict
+      "address" (.metadata.id | toString)
+      "vendor" (.spec.vendor | default "" | toString)
+      "product" (.spec.product | default "" | toString)
+      "vendorID" (.spec.vendor_id | default "" | toString)
+      "productID" (.spec.product_id | default "" | toString)
+      "driver" (.spec.driver | default "" | toString) -}}
+{{- $class := .spec.class_id | default "" | toString | lower -}}
+{{- if eq $class "0x03" -}}
+{{- $gpus = append $gpus $device -}}
+{{- else if and (eq $class "0x02") (has $device.driver $sriovDrivers) -}}
+{{- $sriovNics = append $sriovNics $device -}}
+{{- end -}}
+{{- end -}}
+{{- $sockets := 0 -}}
+{{- $cores := 0 -}}
+{{- $threads := 0 -}}
+{{- range (lookup "cpus" "" "").items -}}
+{{- if gt (.spec.coreCount | default 0 | int) 0 -}}
+{{- $sockets = add1 $sockets -}}
+{{- $cores = add $cores (.spec.coreEnabled | default .spec.coreCount | int) -}}
+{{- $threads = add $threads (.spec.threadCount | default 0 | int) -}}
+{{- end -}}
+{{- end -}}
+{{- $memory := 0 -}}
+{{- range (lookup "memorymodules" "" "").items -}}
+{{- $memory = add $memory (.spec.sizeMiB | default 0 | int) -}}
+{{- end -}}
+{{- toJson (dict
+      "gpus" $gpus
+      "sriovNics" $sriovNics
+      "cpuSockets" $sockets
+      "cpuCores" $cores
+      "cpuThreads" $threads
+      "memoryMiB" $memory) -}}
+{{- end -}}
+
 {{- define "talm.discovered.default_link_name" }}
 {{- range (lookup "addresses" "" "").items }}
 {{- if has .spec.address (fromJsonArray (include "talm.discovered.default_addresses" .)) }}
@@ -701,6 +760,369 @@ busPath: {{ $link.spec.busPath }}
 {{- $value -}}
 {{- end -}}
 
+{{- /* talm.validate.node_metadata fails the render when an entry of
+       .Values.nodeLabels, .Values.nodeAnnotations or .Values.nodeTaints
+       breaks the Kubernetes syntax rules. Talos hands the maps to the
+       kubelet verbatim, and a rejected label or taint surfaces only in
+       the node's logs long after the apply succeeded.
+
+       Every key is a qualified name: an optional DNS-1123 subdomain
+       prefix of at most 253 characters and a slash, then a name of at
+       most 63 characters of [A-Za-z0-9-_.] starting and ending
+       alphanumeric. Annotation keys are checked lowercased, as the
+       apiserver does. A label value is empty or follows the name rule.
+       A taint is written as Talos takes it, "<value>:<effect>", with
+       an optional value and an effect of NoSchedule, PreferNoSchedule
+       or NoExecute.
+
+       Usage:
+           {{ include "talm.validate.node_metadata" .Values }}
+       */ -}}
+{{- define "talm.validate.node_metadata" -}}
+{{- $name := "^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$" -}}
+{{- $prefix := "^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$" -}}
+{{- $values := . -}}
+{{- range $field := list "nodeLabels" "nodeAnnotations" "nodeTaints" -}}
+{{- $entries := index $values $field -}}
+{{- if and $entries (not (kindIs "map" $entries)) -}}
+{{- fail (printf "values.yaml: %s must be a map of key: value entries" $field) -}}
+{{- end -}}
+{{- range $key, $value := $entries -}}
+{{- $checked := $key -}}
+{{- if eq $field "nodeAnnotations" -}}{{- $checked = lower $key -}}{{- end -}}
+{{- $keyName := regexReplaceAll "^.*/" $checked "" -}}
+{{- $keyPrefix := "" -}}
+{{- if contains "/" $checked -}}{{- $keyPrefix = regexReplaceAll "/[^/]*$" $checked "" -}}{{- end -}}
+{{- if or (gt (len $keyName) 63) (not (regexMatch $name $keyName)) (gt (len $keyPrefix) 253) (and (contains "/" $checked) (not (regexMatch $prefix $keyPrefix))) -}}
+{{- fail (printf "values.yaml: %s key %q is not a valid Kubernetes qualified name (an optional DNS-1123 subdomain prefix and '/', then at most 63 characters of [A-Za-z0-9-_.] starting and ending alphanumeric)" $field $key) -}}
+{{- end -}}
+{{- $label := toString $value -}}
+{{- if eq $field "nodeTaints" -}}
+{{- if not (regexMatch ":(NoSchedule|PreferNoSchedule|NoExecute)$" $label) -}}
+{{- fail (printf "values.yaml: nodeTaints.%s=%q must be written as \"<value>:<effect>\" with an effect of NoSchedule, PreferNoSchedule or NoExecute" $key $label) -}}
+{{- end -}}
+{{- $label = regexReplaceAll ":[^:]*$" $label "" -}}
+{{- end -}}
+{{- if and (ne $field "nodeAnnotations") (ne $label "") (or (gt (len $label) 63) (not (regexMatch $name $label))) -}}
+{{- fail (printf "values.yaml: %s.%s has the value %q, which is not a valid Kubernetes label value (at most 63 characters of [A-Za-z0-9-_.] starting and ending alphanumeric, or empty)" $field $key $label) -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+
+{{- /* talm.config.machine.node_metadata renders the machine.nodeLabels,
+       machine.nodeAnnotations and machine.nodeTaints blocks of
+       .Values.nodeLabels, .Values.nodeAnnotations and .Values.nodeTaints,
+       validated by talm.validate.node_metadata. Values are rendered as
+       strings, since Talos takes string maps only. deleteLabels names
+       the labels the preset removes with a `$patch: delete` entry; a
+       nodeLabels entry of the same name replaces the removal. Renders
+       nothing when there is nothing to set.
+
+       Usage:
+           {{- with include "talm.config.machine.node_metadata" (dict "Values" .Values "deleteLabels" (list)) }}
+           {{- . | nindent 2 }}
+           {{- end }}
+       */ -}}
+{{- define "talm.config.machine.node_metadata" -}}
+{{- include "talm.validate.node_metadata" .Values -}}
+{{- $meta := dict -}}
+{{- $labels := dict -}}
+{{- range .deleteLabels -}}{{- $_ := set $labels . (dict "$patch" "delete") -}}{{- end -}}
+{{- range $key, $value := .Values.nodeLabels -}}{{- $_ := set $labels $key (toString $value) -}}{{- end -}}
+{{- with $labels -}}{{- $_ := set $meta "nodeLabels" . -}}{{- end -}}
+{{- $annotations := dict -}}
+{{- range $key, $value := .Values.nodeAnnotations -}}{{- $_ := set $annotations $key (toString $value) -}}{{- end -}}
+{{- with $annotations -}}{{- $_ := set $meta "nodeAnnotations" . -}}{{- end -}}
+{{- $taints := dict -}}
+{{- range $key, $value := .Values.nodeTaints -}}{{- $_ := set $taints $key (toString $value) -}}{{- end -}}
+{{- with $taints -}}{{- $_ := set $meta "nodeTaints" . -}}{{- end -}}
+{{- with $meta -}}{{- toYaml . -}}{{- end -}}
+{{- end -}}
+
+{{- /* talm.validate.int_range fails the render unless value is an
+       integer from min to max, and returns it as a string. A quoted
+       number is refused, so the typed values stay typed.
+
+       Usage:
+           {{ include "talm.validate.int_range" (dict "value" .mtu "field" "nodeInterfaces.bonds[0].mtu" "min" 68 "max" 65535) }}
+       */ -}}
+{{- define "talm.validate.int_range" -}}
+{{- $text := toString .value -}}
+{{- if or (kindIs "string" .value) (not (regexMatch "^[0-9]+$" $text)) (lt (atoi $text) (int .min)) (gt (atoi $text) (int .max)) -}}
+{{- fail (printf "values.yaml: %s=%v must be an integer from %d to %d" .field .value (int .min) (int .max)) -}}
+{{- end -}}
+{{- $text -}}
+{{- end -}}
+
+{{- /* talm.config.network.node_interfaces checks .Values.nodeInterfaces,
+       the bonds, VLANs and bridges declared for the node, and returns
+       them as JSON for talm.config.network.multidoc: the entries of
+       each kind, "names" listing every declared link, and "members"
+       mapping each bond or bridge port to the link that takes it over.
+
+       Talos checks the BondConfig, VLANConfig and BridgeConfig
+       documents only when the config is applied, and a member that
+       does not exist on the node is not an error there at all: the
+       link simply never comes up. The checks here fail the render
+       instead:
+
+         - only the known fields of each kind, so a misspelled key is
+           not silently dropped;
+         - link names of at most 15 characters (IFNAMSIZ), unique
+           across the declared links;
+         - bond members that exist among the discovered links, bridge
+           ports that are discovered links or declared bonds and VLANs,
+           and no link used as a port of two bonds or bridges;
+         - VLAN IDs from 1 to 4094, once per parent, on a parent that
+           is discovered or declared and is not itself a port;
+         - MTUs from 68 to 65535, addresses in CIDR form and a known
+           bond mode.
+
+       Existence is checked against the links discovery returns, so an
+       offline render, which discovers none, skips it. A VLAN without
+       a name is named "<parent>.<vlanID>". */ -}}
+{{- define "talm.config.network.node_interfaces" -}}
+{{- $spec := .Values.nodeInterfaces -}}
+{{- if kindIs "invalid" $spec -}}{{- $spec = dict -}}{{- end -}}
+{{- if not (kindIs "map" $spec) -}}
+{{- fail "values.yaml: nodeInterfaces must be a map of bonds, vlans and bridges lists" -}}
+{{- end -}}
+{{- $kinds := list "bonds" "vlans" "bridges" -}}
+{{- range $key, $_ := $spec -}}
+{{- if not (has $key $kinds) -}}
+{{- fail (printf "values.yaml: nodeInterfaces.%s is not a known key (bonds, vlans, bridges)" $key) -}}
+{{- end -}}
+{{- end -}}
+{{- $fields := dict
+      "bonds" (list "name" "links" "mode" "xmitHashPolicy" "lacpRate" "miimon" "updelay" "downdelay" "mtu" "addresses")
+      "vlans" (list "name" "parent" "vlanID" "mtu" "addresses")
+      "bridges" (list "name" "links" "stp" "vlanFiltering" "mtu" "addresses") -}}
+{{- $bondModes := list "balance-rr" "active-backup" "balance-xor" "broadcast" "802.3ad" "balance-tlb" "balance-alb" -}}
+{{- $discovered := list -}}
+{{- range (lookup "links" "" "").items -}}
+{{- $discovered = append $discovered (.metadata.id | toString) -}}
+{{- end -}}
+{{- $out := dict "bonds" (list) "vlans" (list) "bridges" (list) "names" (list) "members" (dict) -}}
+{{- /* First pass: shape and names, so that a bridge port or a VLAN
+       parent can name a link declared further down. */ -}}
+{{- $entries := dict -}}
+{{- range $kind := $kinds -}}
+{{- $list := index $spec $kind -}}
+{{- if kindIs "invalid" $list -}}{{- $list = list -}}{{- end -}}
+{{- if not (kindIs "slice" $list) -}}
+{{- fail (printf "values.yaml: nodeInterfaces.%s must be a list" $kind) -}}
+{{- end -}}
+{{- $checked := list -}}
+{{- range $i, $entry := $list -}}
+{{- $path := printf "nodeInterfaces.%s[%d]" $kind $i -}}
+{{- if not (kindIs "map" $entry) -}}
+{{- fail (printf "values.yaml: %s must be a map" $path) -}}
+{{- end -}}
+{{- range $key, $_ := $entry -}}
+{{- if not (has $key (index $fields $kind)) -}}
+{{- fail (printf "values.yaml: %s.%s is not a field of a %s (%s)" $path $key (trimSuffix "s" $kind) (join ", " (index $fields $kind))) -}}
+{{- end -}}
+{{- end -}}
+{{- $item := dict "path" $path -}}
+{{- if eq $kind "vlans" -}}
+{{- $parent := toString ($entry.parent | default "") -}}
+{{- if eq $parent "" -}}
+{{- fail (printf "values.yaml: %s.parent must name the link the VLAN sits on" $path) -}}
+{{- end -}}
+{{- $vlanID := include "talm.validate.int_range" (dict "value" $entry.vlanID "field" (printf "%s.vlanID" $path) "min" 1 "max" 4094) -}}
+{{- $_ := set $item "parent" $parent -}}
+{{- $_ := set $item "vlanID" $vlanID -}}
+{{- $_ := set $item "name" (toString ($entry.name | default (printf "%s.%s" $parent $vlanID))) -}}
+{{- else -}}
+{{- if not $entry.name -}}
+{{- fail (printf "values.yaml: %s.name must be set" $path) -}}
+{{- end -}}
+{{- $_ := set $item "name" (toString $entry.name) -}}
+{{- end -}}
+{{- $name := $item.name -}}
+{{- if or (gt (len $name) 15) (not (regexMatch "^[A-Za-z0-9][-A-Za-z0-9_.]*$" $name)) -}}
+{{- fail (printf "values.yaml: %s names the link %q; a link name is at most 15 characters of [A-Za-z0-9-_.] starting alphanumeric" $path $name) -}}
+{{- end -}}
+{{- if has $name $out.names -}}
+{{- fail (printf "values.yaml: %s declares the link %q a second time" $path $name) -}}
+{{- end -}}
+{{- if has $name $discovered -}}
+{{- $found := dig "spec" "kind" "" (lookup "links" "" $name) | toString -}}
+{{- if ne $found (trimSuffix "s" $kind) -}}
+{{- fail (printf "values.yaml: %s declares a %s named %q, but the node has a %s link of that name" $path (trimSuffix "s" $kind) $name (ternary $found "physical" (has $found (list "bond" "vlan" "bridge")))) -}}
+{{- end -}}
+{{- end -}}
+{{- $_ := set $out "names" (append $out.names $name) -}}
+{{- $checked = append $checked (dict "item" $item "entry" $entry) -}}
+{{- end -}}
+{{- $_ := set $entries $kind $checked -}}
+{{- end -}}
+{{- /* Second pass: ports, then VLANs, which must not sit on a port. */ -}}
+{{- range $kind := list "bonds" "bridges" -}}
+{{- range index $entries $kind -}}
+{{- $item := .item -}}
+{{- $entry := .entry -}}
+{{- if or (not (kindIs "slice" $entry.links)) (not $entry.links) -}}
+{{- fail (printf "values.yaml: %s.links must list at least one link" $item.path) -}}
+{{- end -}}
+{{- $links := list -}}
+{{- range $entry.links -}}
+{{- $member := toString . -}}
+{{- if eq $member $item.name -}}
+{{- fail (printf "values.yaml: %s.links lists %q itself" $item.path $member) -}}
+{{- end -}}
+{{- with index $out.members $member -}}
+{{- fail (printf "values.yaml: %s.links lists %q, which is already a port of %s; a link can be a port of one bond or bridge only" $item.path $member .) -}}
+{{- end -}}
+{{- if eq $kind "bonds" -}}
+{{- if has $member $out.names -}}
+{{- fail (printf "values.yaml: %s.links lists %q, a declared link; bond members must be physical links" $item.path $member) -}}
+{{- end -}}
+{{- if and $discovered (not (has $member $discovered)) -}}
+{{- fail (printf "values.yaml: %s.links lists %q, which the node does not have (discovered links: %s)" $item.path $member (join ", " $discovered)) -}}
+{{- end -}}
+{{- else if and $discovered (not (has $member $discovered)) (not (has $member $out.names)) -}}
+{{- fail (printf "values.yaml: %s.links lists %q, which is neither a link of the node (%s) nor a declared bond or VLAN" $item.path $member (join ", " $discovered)) -}}
+{{- end -}}
+{{- $_ := set $out.members $member $item.name -}}
+{{- $links = append $links $member -}}
+{{- end -}}
+{{- $_ := set $item "links" $links -}}
+{{- if eq $kind "bonds" -}}
+{{- if not (has (toString ($entry.mode | default "")) $bondModes) -}}
+{{- fail (printf "values.yaml: %s.mode=%q must be one of %s" $item.path (toString ($entry.mode | default "")) (join ", " $bondModes)) -}}
+{{- end -}}
+{{- $_ := set $item "mode" (toString $entry.mode) -}}
+{{- range $field := list "xmitHashPolicy" "lacpRate" -}}
+{{- with index $entry $field -}}{{- $_ := set $item $field (toString .) -}}{{- end -}}
+{{- end -}}
+{{- range $field := list "miimon" "updelay" "downdelay" -}}
+{{- if hasKey $entry $field -}}
+{{- $_ := set $item $field (include "talm.validate.int_range" (dict "value" (index $entry $field) "field" (printf "%s.%s" $item.path $field) "min" 0 "max" 2147483647)) -}}
+{{- end -}}
+{{- end -}}
+{{- else -}}
+{{- range $field := list "stp" "vlanFiltering" -}}
+{{- if hasKey $entry $field -}}
+{{- if not (kindIs "bool" (index $entry $field)) -}}
+{{- fail (printf "values.yaml: %s.%s must be true or false" $item.path $field) -}}
+{{- end -}}
+{{- $_ := set $item $field (index $entry $field) -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- $vlanKeys := list -}}
+{{- range index $entries "vlans" -}}
+{{- $item := .item -}}
+{{- if and $discovered (not (has $item.parent $discovered)) (not (has $item.parent $out.names)) -}}
+{{- fail (printf "values.yaml: %s.parent is %q, which is neither a link of the node (%s) nor a declared bond or bridge" $item.path $item.parent (join ", " $discovered)) -}}
+{{- end -}}
+{{- with index $out.members $item.parent -}}
+{{- fail (printf "values.yaml: %s.parent is %q, a port of %s; put the VLAN on %s instead" $item.path $item.parent . .) -}}
+{{- end -}}
+{{- $key := printf "%s/%s" $item.parent $item.vlanID -}}
+{{- if has $key $vlanKeys -}}
+{{- fail (printf "values.yaml: %s declares VLAN %s on %s a second time" $item.path $item.vlanID $item.parent) -}}
+{{- end -}}
+{{- $vlanKeys = append $vlanKeys $key -}}
+{{- end -}}
+{{- /* Common fields, then the entries in declaration order. */ -}}
+{{- range $kind := $kinds -}}
+{{- range index $entries $kind -}}
+{{- $item := .item -}}
+{{- $entry := .entry -}}
+{{- if hasKey $entry "mtu" -}}
+{{- $_ := set $item "mtu" (include "talm.validate.int_range" (dict "value" $entry.mtu "field" (printf "%s.mtu" $item.path) "min" 68 "max" 65535)) -}}
+{{- end -}}
+{{- if hasKey $entry "addresses" -}}
+{{- if not (kindIs "slice" $entry.addresses) -}}
+{{- fail (printf "values.yaml: %s.addresses must be a list of addresses in CIDR form" $item.path) -}}
+{{- end -}}
+{{- $addresses := list -}}
+{{- range $entry.addresses -}}
+{{- $address := toString . -}}
+{{- if lt (cidrPrefixLen $address) 0 -}}
+{{- fail (printf "values.yaml: %s.addresses has %q, which is not an address in CIDR form such as 192.0.2.10/24" $item.path $address) -}}
+{{- end -}}
+{{- $addresses = append $addresses $address -}}
+{{- end -}}
+{{- $_ := set $item "addresses" $addresses -}}
+{{- end -}}
+{{- $_ := unset $item "path" -}}
+{{- $_ := set $out $kind (append (index $out $kind) $item) -}}
+{{- end -}}
+{{- end -}}
+{{- /* A discovered VLAN whose parent becomes a port would render with
+       a parent that no longer carries traffic. */ -}}
+{{- range $name := $discovered -}}
+{{- if and (eq (include "talm.discovered.is_vlan" $name) "true") (not (has $name $out.names)) -}}
+{{- $parent := include "talm.discovered.parent_link_name" $name -}}
+{{- with index $out.members $parent -}}
+{{- fail (printf "values.yaml: nodeInterfaces makes %s a port of %s, but the node's VLAN %s sits on it; declare the VLAN in nodeInterfaces.vlans with parent %s" $parent . $name .) -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- toJson $out -}}
+{{- end -}}
+
+{{- /* talm.config.network.declared_link renders the addresses, routes
+       and mtu of a link declared in nodeInterfaces. Without declared
+       addresses the link keeps the ones discovery reports for it and,
+       for a bond or bridge, for its ports, so that turning a NIC into
+       a bond member moves its addresses to the bond instead of
+       dropping them. The floatingIP is stripped as on discovered
+       links. The default route goes on the link carrying the
+       discovered gateway link, or on the bond or bridge that takes
+       it over.
+
+       Usage:
+           {{- include "talm.config.network.declared_link" (dict "entry" . "gatewayLink" $gatewayLink "gatewaySource" $defaultLinkName "fipStr" $fipStr "fipIsSet" $fipIsSet) }}
+       */ -}}
+{{- define "talm.config.network.declared_link" }}
+{{- $entry := .entry }}
+{{- $addresses := list }}
+{{- if hasKey $entry "addresses" }}
+{{- $addresses = $entry.addresses }}
+{{- else }}
+{{- range $source := prepend ($entry.links | default list) $entry.name }}
+{{- range fromJsonArray (include "talm.discovered.addresses_by_link" $source) }}
+{{- if not (and $.fipIsSet (hasPrefix (printf "%s/" $.fipStr) .)) }}
+{{- $addresses = append $addresses . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if $addresses }}
+addresses:
+{{- range uniq $addresses }}
+  - address: {{ . }}
+{{- end }}
+{{- end }}
+{{- if and .gatewaySource (eq $entry.name .gatewayLink) }}
+{{- with include "talm.discovered.gateway_by_link" .gatewaySource }}
+routes:
+  - gateway: {{ . }}
+{{- end }}
+{{- end }}
+{{- with $entry.mtu | default (dig "spec" "mtu" "" (lookup "links" "" $entry.name)) }}
+mtu: {{ . }}
+{{- end }}
+{{- end }}
+
+{{- /* talm.validate.node_interfaces_legacy fails a legacy (pre-v1.12)
+       render that declares nodeInterfaces: the links are rendered as
+       typed documents, which the legacy machine.network schema has no
+       place for. */ -}}
+{{- define "talm.validate.node_interfaces_legacy" -}}
+{{- $declared := fromJson (include "talm.config.network.node_interfaces" .) -}}
+{{- if $declared.names -}}
+{{- fail "values.yaml: nodeInterfaces renders Talos v1.12 BondConfig, VLANConfig and BridgeConfig documents; set templateOptions.talosVersion in Chart.yaml to v1.12 or later, or declare the links under machine.network.interfaces in the node file body" -}}
+{{- end -}}
+{{- end -}}
+
 {{- /* talm.config.network.multidoc reconstructs the v1.12+ multi-doc
        network config from discovery resources. Single source of truth
        used by both the cozystack and generic chart presets — each
@@ -782,9 +1204,21 @@ link: {{ .Values.vipLink }}
 {{- end }}
 {{- $defaultLinkName := include "talm.discovered.default_link_name_by_gateway" . }}
 {{- $configurableLinks := fromJsonArray (include "talm.discovered.configurable_link_names" .) }}
+{{- /* Links declared in .Values.nodeInterfaces replace their
+       discovered documents, and their ports get none, since a port
+       carries no addresses of its own. The default route follows the
+       gateway link into the bond or bridge that takes it over, one
+       level for a bond and two for a bond that is a bridge port. */}}
+{{- $declared := fromJson (include "talm.config.network.node_interfaces" .) }}
+{{- $gatewayLink := $defaultLinkName }}
+{{- range until 2 }}
+{{- with index $declared.members $gatewayLink }}
+{{- $gatewayLink = . }}
+{{- end }}
+{{- end }}
 {{- range $linkName := $configurableLinks }}
 {{- $link := lookup "links" "" $linkName }}
-{{- if $link }}
+{{- if and $link (not (has $linkName $declared.names)) (not (hasKey $declared.members $linkName)) }}
 {{- $kind := $link.spec.kind | toString }}
 {{- $isGatewayLink := eq $linkName $defaultLinkName }}
 {{- $rawAddresses := fromJsonArray (include "talm.discovered.addresses_by_link" $linkName) }}
@@ -979,6 +1413,52 @@ mtu: {{ $link.spec.mtu }}
 {{- end }}
 {{- end }}
 {{- end }}
+{{- range $declared.bonds }}
+---
+apiVersion: v1alpha1
+kind: BondConfig
+name: {{ .name }}
+links:
+{{- range .links }}
+  - {{ . }}
+{{- end }}
+bondMode: {{ .mode }}
+{{- $bond := . }}
+{{- range $field := list "xmitHashPolicy" "lacpRate" "miimon" "updelay" "downdelay" }}
+{{- with index $bond $field }}
+{{ $field }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- include "talm.config.network.declared_link" (dict "entry" . "gatewayLink" $gatewayLink "gatewaySource" $defaultLinkName "fipStr" $fipStr "fipIsSet" $fipIsSet) }}
+{{- end }}
+{{- range $declared.vlans }}
+---
+apiVersion: v1alpha1
+kind: VLANConfig
+name: {{ .name }}
+vlanID: {{ .vlanID }}
+parent: {{ .parent }}
+{{- include "talm.config.network.declared_link" (dict "entry" . "gatewayLink" $gatewayLink "gatewaySource" $defaultLinkName "fipStr" $fipStr "fipIsSet" $fipIsSet) }}
+{{- end }}
+{{- range $declared.bridges }}
+---
+apiVersion: v1alpha1
+kind: BridgeConfig
+name: {{ .name }}
+links:
+{{- range .links }}
+  - {{ . }}
+{{- end }}
+{{- if hasKey . "stp" }}
+stp:
+  enabled: {{ .stp }}
+{{- end }}
+{{- if hasKey . "vlanFiltering" }}
+vlan:
+  filtering: {{ .vlanFiltering }}
+{{- end }}
+{{- include "talm.config.network.declared_link" (dict "entry" . "gatewayLink" $gatewayLink "gatewaySource" $defaultLinkName "fipStr" $fipStr "fipIsSet" $fipIsSet) }}
+{{- end }}
 {{- /* Discovery-derived Layer2VIPConfig: skipped when the operator
        has set .Values.vipLink, since the override-path block above
        has already emitted the document with the operator's chosen
@@ -1004,10 +1484,17 @@ mtu: {{ $link.spec.mtu }}
        configurable-link gate link_name_for_address applies inside
        its own iteration. */ -}}
 {{- if not $vipLink }}
-{{- if has $defaultLinkName $configurableLinks }}
+{{- if or (has $defaultLinkName $configurableLinks) (hasKey $declared.members $defaultLinkName) }}
 {{- $vipLink = $defaultLinkName }}
 {{- end }}
 {{- end }}
+{{- /* A link that nodeInterfaces makes a port hands the VIP to
+       the bond or bridge taking it over, like the default route. */ -}}
+{{- range until 2 }}
+{{- with index $declared.members $vipLink }}
+{{- $vipLink = . }}
+{{- end }}
+{{- end }}
 {{- if $vipLink }}
 ---
 apiVersion: v1alpha1
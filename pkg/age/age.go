@@ -16,6 +16,7 @@ package age
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/yamltools"
 )
 
 // ErrLeftoverRotationBackup is returned by RotateKeys when it detects
@@ -46,11 +48,17 @@ var ErrLeftoverRotationBackup = errors.New("leftover rotation backup from a prev
 var errInternalInvariant = errors.New("internal invariant violation: recursive helper returned wrong kind for top-level input")
 
 const (
-	keyFileName          = "talm.key"
-	encryptedSecretsFile = "secrets.encrypted.yaml"
-	plainSecretsFile     = "secrets.yaml"
-	ageEncryptionPrefix  = "ENC[AGE,data:"
-	ageEncryptionSuffix  = "]"
+	// DefaultKeyFile, DefaultSecretsFile and DefaultValuesSecretFile
+	// are the project-relative names a Layout falls back to.
+	DefaultKeyFile          = "talm.key"
+	DefaultSecretsFile      = "secrets.yaml"
+	DefaultValuesSecretFile = "values-secret.yaml"
+
+	// keyDirMode is the mode of a directory created to hold the key.
+	keyDirMode = 0o700
+
+	ageEncryptionPrefix = "ENC[AGE,data:"
+	ageEncryptionSuffix = "]"
 
 	// EncryptedFileSuffix is the filename convention that marks a YAML
 	// file as age-encrypted (secrets.encrypted.yaml, talosconfig.encrypted
@@ -60,6 +68,75 @@ const (
 	EncryptedFileSuffix = ".encrypted.yaml"
 )
 
+// Layout names the age key and the secrets files of a project,
+// relative to its root. Empty fields fall back to the defaults, so the
+// zero value is the historical layout; projects that keep their secrets
+// elsewhere (say under secrets/) set the names through Chart.yaml
+// globalOptions. The encrypted sibling of a secrets file is derived from
+// its plain name (secrets/cluster.yaml -> secrets/cluster.encrypted.yaml).
+//
+// Recipients are the public keys every value is encrypted to besides
+// the project key, one per line as ParseRecipient reads them, so
+// operators holding the matching private keys can decrypt without a
+// copy of the key file.
+type Layout struct {
+	Key          string
+	Secrets      string
+	ValuesSecret string
+	Recipients   []string
+}
+
+// KeyFile is the project-relative path of the age key.
+func (l Layout) KeyFile() string {
+	return cmp.Or(l.Key, DefaultKeyFile)
+}
+
+// SecretsFile is the project-relative path of the plaintext Talos
+// secrets bundle.
+func (l Layout) SecretsFile() string {
+	return cmp.Or(l.Secrets, DefaultSecretsFile)
+}
+
+// EncryptedSecretsFile is the project-relative path of the encrypted
+// Talos secrets bundle.
+func (l Layout) EncryptedSecretsFile() string {
+	return EncryptedName(l.SecretsFile())
+}
+
+// ValuesSecretFile is the project-relative path of the plaintext
+// secret chart values.
+func (l Layout) ValuesSecretFile() string {
+	return cmp.Or(l.ValuesSecret, DefaultValuesSecretFile)
+}
+
+// EncryptedValuesSecretFile is the project-relative path of the
+// encrypted secret chart values.
+func (l Layout) EncryptedValuesSecretFile() string {
+	return EncryptedName(l.ValuesSecretFile())
+}
+
+// EncryptedName is the encrypted sibling of a plaintext YAML file:
+// the .yaml extension becomes EncryptedFileSuffix.
+func EncryptedName(plain string) string {
+	return strings.TrimSuffix(plain, ".yaml") + EncryptedFileSuffix
+}
+
+// projectLayout is the layout every rootDir-based helper of this
+// package resolves its file names through.
+//
+//nolint:gochecknoglobals // set once from Chart.yaml at startup, like the commands package Config.
+var projectLayout Layout
+
+// SetLayout makes every rootDir-based helper of this package use l.
+func SetLayout(l Layout) {
+	projectLayout = l
+}
+
+// CurrentLayout returns the layout set by SetLayout.
+func CurrentLayout() Layout {
+	return projectLayout
+}
+
 // ErrNoEncryptedValues is returned by DecryptYAMLToMap when a file the caller
 // declared encrypted (by its .encrypted.yaml name) parses cleanly but carries
 // no ENC[AGE,...] envelope at all. Treating it as plaintext would silently
@@ -67,10 +144,10 @@ const (
 // lets the operator fix the file rather than ship a hole.
 var ErrNoEncryptedValues = errors.New("file is named *.encrypted.yaml but contains no ENC[AGE,...] encrypted values")
 
-// GenerateKey generates a new age identity and saves it to talm.key file in age keygen format.
+// GenerateKey generates a new age identity and saves it to the layout's key file (talm.key by default) in age keygen format.
 // Returns true if a new key was created (not loaded from existing file).
 func GenerateKey(rootDir string) (*age.X25519Identity, bool, error) {
-	keyFile := filepath.Join(rootDir, keyFileName)
+	keyFile := filepath.Join(rootDir, projectLayout.KeyFile())
 
 	// Check if key already exists
 	_, statErr := os.Stat(keyFile)
@@ -90,6 +167,12 @@ func GenerateKey(rootDir string) (*age.X25519Identity, bool, error) {
 		return nil, false, errors.Wrap(err, "generate age identity")
 	}
 
+	// A key moved into a subdirectory (secrets/talm.key) may be the
+	// first file written there.
+	if err := os.MkdirAll(filepath.Dir(keyFile), keyDirMode); err != nil {
+		return nil, false, errors.Wrap(err, "create key directory")
+	}
+
 	writeErr := secureperm.WriteFile(keyFile, []byte(formatKeyFile(identity, time.Now())))
 	if writeErr != nil {
 		return nil, false, errors.Wrap(writeErr, "write key file")
@@ -112,10 +195,10 @@ func formatKeyFile(identity *age.X25519Identity, now time.Time) string {
 	)
 }
 
-// LoadKey loads age identity from talm.key file.
+// LoadKey loads age identity from the layout's key file (talm.key by default).
 // Supports both age keygen format (with comments) and plain format.
 func LoadKey(rootDir string) (*age.X25519Identity, error) {
-	keyFile := filepath.Join(rootDir, keyFileName)
+	keyFile := filepath.Join(rootDir, projectLayout.KeyFile())
 
 	keyData, err := os.ReadFile(keyFile)
 	if err != nil {
@@ -127,9 +210,10 @@ func LoadKey(rootDir string) (*age.X25519Identity, error) {
 			// paths the operator can take so the error reads as
 			// "what to do next", not "talm is broken".
 			//nolint:wrapcheck // cockroachdb/errors.WithHint is the project's wrapping/hinting idiom at boundaries.
-			return nil, errors.WithHint(
+			return nil, errors.WithHintf(
 				errors.Wrap(err, "read key file"),
-				"talm.key is required to decrypt secrets.encrypted.yaml. Restore your backed-up key, or re-run `talm init` to regenerate (this writes new secrets — the old secrets.encrypted.yaml will not be decryptable without the original key).",
+				"%[1]s is required to decrypt %[2]s. Restore your backed-up key, or re-run `talm init` to regenerate (this writes new secrets — the old %[2]s will not be decryptable without the original key).",
+				projectLayout.KeyFile(), projectLayout.EncryptedSecretsFile(),
 			)
 		}
 
@@ -178,9 +262,9 @@ func GetPublicKey(identity *age.X25519Identity) string {
 	return identity.Recipient().String()
 }
 
-// GetPublicKeyFromFile extracts the public key from talm.key file.
+// GetPublicKeyFromFile extracts the public key from the layout's key file.
 func GetPublicKeyFromFile(rootDir string) (string, error) {
-	keyFile := filepath.Join(rootDir, keyFileName)
+	keyFile := filepath.Join(rootDir, projectLayout.KeyFile())
 
 	keyData, err := os.ReadFile(keyFile)
 	if err != nil {
@@ -208,22 +292,22 @@ func GetPublicKeyFromFile(rootDir string) (string, error) {
 // EncryptSecretsFile encrypts secrets.yaml values and saves to secrets.encrypted.yaml.
 // Uses incremental encryption: only encrypts values that have changed.
 func EncryptSecretsFile(rootDir string) error {
-	return encryptYAMLPair(rootDir, plainSecretsFile, encryptedSecretsFile)
+	return encryptYAMLPair(rootDir, projectLayout.SecretsFile(), projectLayout.EncryptedSecretsFile())
 }
 
 // DecryptSecretsFile decrypts secrets.encrypted.yaml and saves to secrets.yaml.
 func DecryptSecretsFile(rootDir string) error {
-	return decryptYAMLPair(rootDir, encryptedSecretsFile, plainSecretsFile)
+	return decryptYAMLPair(rootDir, projectLayout.EncryptedSecretsFile(), projectLayout.SecretsFile())
 }
 
 // encryptYAMLValues recursively encrypts string values in YAML structure.
-func encryptYAMLValues(data any, recipient *age.X25519Recipient) (any, error) {
+func encryptYAMLValues(data any, recipients []age.Recipient) (any, error) {
 	switch typed := data.(type) {
 	case map[string]any:
 		result := make(map[string]any)
 
 		for key, value := range typed {
-			encryptedValue, err := encryptYAMLValues(value, recipient)
+			encryptedValue, err := encryptYAMLValues(value, recipients)
 			if err != nil {
 				return nil, err
 			}
@@ -236,7 +320,7 @@ func encryptYAMLValues(data any, recipient *age.X25519Recipient) (any, error) {
 		result := make([]any, len(typed))
 
 		for i, item := range typed {
-			encryptedItem, err := encryptYAMLValues(item, recipient)
+			encryptedItem, err := encryptYAMLValues(item, recipients)
 			if err != nil {
 				return nil, err
 			}
@@ -247,7 +331,7 @@ func encryptYAMLValues(data any, recipient *age.X25519Recipient) (any, error) {
 		return result, nil
 	case string:
 		// Encrypt string value
-		encrypted, err := encryptString(typed, recipient)
+		encrypted, err := encryptString(typed, recipients...)
 		if err != nil {
 			return nil, err
 		}
@@ -259,13 +343,13 @@ func encryptYAMLValues(data any, recipient *age.X25519Recipient) (any, error) {
 }
 
 // decryptYAMLValues recursively decrypts string values in YAML structure.
-func decryptYAMLValues(data any, identity *age.X25519Identity) (any, error) {
+func decryptYAMLValues(data any, identities []age.Identity) (any, error) {
 	switch typed := data.(type) {
 	case map[string]any:
 		result := make(map[string]any)
 
 		for key, value := range typed {
-			decryptedValue, err := decryptYAMLValues(value, identity)
+			decryptedValue, err := decryptYAMLValues(value, identities)
 			if err != nil {
 				return nil, err
 			}
@@ -278,7 +362,7 @@ func decryptYAMLValues(data any, identity *age.X25519Identity) (any, error) {
 		result := make([]any, len(typed))
 
 		for i, item := range typed {
-			decryptedItem, err := decryptYAMLValues(item, identity)
+			decryptedItem, err := decryptYAMLValues(item, identities)
 			if err != nil {
 				return nil, err
 			}
@@ -294,7 +378,7 @@ func decryptYAMLValues(data any, identity *age.X25519Identity) (any, error) {
 			encrypted := strings.TrimPrefix(typed, ageEncryptionPrefix)
 			encrypted = strings.TrimSuffix(encrypted, ageEncryptionSuffix)
 
-			decrypted, err := decryptString(encrypted, identity)
+			decrypted, err := decryptString(encrypted, identities...)
 			if err != nil {
 				return nil, err
 			}
@@ -309,12 +393,12 @@ func decryptYAMLValues(data any, identity *age.X25519Identity) (any, error) {
 }
 
 // decryptYAMLValuesString decrypts a single encrypted string value (helper for mergeAndEncryptYAMLValues).
-func decryptYAMLValuesString(encrypted string, identity *age.X25519Identity) (string, error) {
+func decryptYAMLValuesString(encrypted string, identities ...age.Identity) (string, error) {
 	if strings.HasPrefix(encrypted, ageEncryptionPrefix) && strings.HasSuffix(encrypted, ageEncryptionSuffix) {
 		encryptedData := strings.TrimPrefix(encrypted, ageEncryptionPrefix)
 		encryptedData = strings.TrimSuffix(encryptedData, ageEncryptionSuffix)
 
-		return decryptString(encryptedData, identity)
+		return decryptString(encryptedData, identities...)
 	}
 
 	return encrypted, nil
@@ -322,32 +406,32 @@ func decryptYAMLValuesString(encrypted string, identity *age.X25519Identity) (st
 
 // mergeAndEncryptYAMLValues merges plain and encrypted YAML, encrypting only changed values.
 // This ensures idempotency: unchanged values keep their encrypted form.
-func mergeAndEncryptYAMLValues(plain, encrypted any, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptYAMLValues(plain, encrypted any, keys keyring) (any, error) {
 	switch plainVal := plain.(type) {
 	case map[string]any:
-		return mergeAndEncryptMap(plainVal, encrypted, identity)
+		return mergeAndEncryptMap(plainVal, encrypted, keys)
 	case []any:
-		return mergeAndEncryptSlice(plainVal, encrypted, identity)
+		return mergeAndEncryptSlice(plainVal, encrypted, keys)
 	case string:
-		return mergeAndEncryptString(plainVal, encrypted, identity)
+		return mergeAndEncryptString(plainVal, encrypted, keys)
 	default:
-		return mergeAndEncryptScalar(plain, encrypted, identity)
+		return mergeAndEncryptScalar(plain, encrypted, keys)
 	}
 }
 
 // mergeAndEncryptMap walks the plain map keyed against the encrypted
 // map (when types align) and recurses for shared keys, full-encrypts
 // for new keys. Type mismatch falls through to a full encrypt.
-func mergeAndEncryptMap(plain map[string]any, encrypted any, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptMap(plain map[string]any, encrypted any, keys keyring) (any, error) {
 	encryptedMap, ok := encrypted.(map[string]any)
 	if !ok {
-		return encryptYAMLValues(plain, identity.Recipient())
+		return encryptYAMLValues(plain, keys.recipients)
 	}
 
 	result := make(map[string]any)
 
 	for key, plainValue := range plain {
-		merged, err := mergeAndEncryptMapEntry(plainValue, encryptedMap, key, identity)
+		merged, err := mergeAndEncryptMapEntry(plainValue, encryptedMap, key, keys)
 		if err != nil {
 			return nil, err
 		}
@@ -361,27 +445,27 @@ func mergeAndEncryptMap(plain map[string]any, encrypted any, identity *age.X2551
 // mergeAndEncryptMapEntry encrypts a single map value: recurse when
 // the key already exists on the encrypted side, full-encrypt when it
 // is new.
-func mergeAndEncryptMapEntry(plainValue any, encryptedMap map[string]any, key string, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptMapEntry(plainValue any, encryptedMap map[string]any, key string, keys keyring) (any, error) {
 	if encryptedValue, exists := encryptedMap[key]; exists {
-		return mergeAndEncryptYAMLValues(plainValue, encryptedValue, identity)
+		return mergeAndEncryptYAMLValues(plainValue, encryptedValue, keys)
 	}
 
-	return encryptYAMLValues(plainValue, identity.Recipient())
+	return encryptYAMLValues(plainValue, keys.recipients)
 }
 
 // mergeAndEncryptSlice merges per-element when slice lengths match;
 // otherwise full-encrypts (per-index merge requires a stable mapping
 // that a length change invalidates).
-func mergeAndEncryptSlice(plain []any, encrypted any, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptSlice(plain []any, encrypted any, keys keyring) (any, error) {
 	encryptedSlice, ok := encrypted.([]any)
 	if !ok || len(plain) != len(encryptedSlice) {
-		return encryptYAMLValues(plain, identity.Recipient())
+		return encryptYAMLValues(plain, keys.recipients)
 	}
 
 	result := make([]any, len(plain))
 
 	for i, plainItem := range plain {
-		merged, err := mergeAndEncryptYAMLValues(plainItem, encryptedSlice[i], identity)
+		merged, err := mergeAndEncryptYAMLValues(plainItem, encryptedSlice[i], keys)
 		if err != nil {
 			return nil, err
 		}
@@ -395,31 +479,31 @@ func mergeAndEncryptSlice(plain []any, encrypted any, identity *age.X25519Identi
 // mergeAndEncryptString implements the per-string idempotent rule:
 // when the prior ciphertext decrypts to the SAME plaintext, keep the
 // existing ciphertext byte-for-byte; otherwise re-encrypt.
-func mergeAndEncryptString(plain string, encrypted any, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptString(plain string, encrypted any, keys keyring) (any, error) {
 	encryptedStr, ok := encrypted.(string)
 	if !ok {
-		return encryptYAMLValues(plain, identity.Recipient())
+		return encryptYAMLValues(plain, keys.recipients)
 	}
 
-	if cipher, kept := tryReuseCiphertext(plain, encryptedStr, identity); kept {
+	if cipher, kept := tryReuseCiphertext(plain, encryptedStr, keys); kept {
 		return cipher, nil
 	}
 
-	return encryptYAMLValues(plain, identity.Recipient())
+	return encryptYAMLValues(plain, keys.recipients)
 }
 
 // tryReuseCiphertext returns (encryptedStr, true) when encryptedStr is
-// an envelope-wrapped ciphertext that decrypts to plain — the
-// idempotency guard. Any decryption error or plaintext mismatch
-// returns ("", false), telling the caller to fall through to a fresh
-// encrypt.
-func tryReuseCiphertext(plain, encryptedStr string, identity *age.X25519Identity) (string, bool) {
+// an envelope-wrapped ciphertext that decrypts to plain and was
+// encrypted to every recipient of keys — the idempotency guard. Any
+// decryption error, plaintext mismatch or change of recipients returns
+// ("", false), telling the caller to fall through to a fresh encrypt.
+func tryReuseCiphertext(plain, encryptedStr string, keys keyring) (string, bool) {
 	if !strings.HasPrefix(encryptedStr, ageEncryptionPrefix) || !strings.HasSuffix(encryptedStr, ageEncryptionSuffix) {
 		return "", false
 	}
 
-	decrypted, err := decryptYAMLValuesString(encryptedStr, identity)
-	if err != nil || decrypted != plain {
+	decrypted, err := decryptYAMLValuesString(encryptedStr, keys.identities...)
+	if err != nil || decrypted != plain || !keys.encryptedTo(encryptedStr) {
 		return "", false
 	}
 
@@ -430,7 +514,7 @@ func tryReuseCiphertext(plain, encryptedStr string, identity *age.X25519Identity
 // scalars (numbers, booleans, nil): keep the existing ciphertext when
 // equality holds AND the encrypted side is an envelope; otherwise
 // full-encrypt the plain value.
-func mergeAndEncryptScalar(plain, encrypted any, identity *age.X25519Identity) (any, error) {
+func mergeAndEncryptScalar(plain, encrypted any, keys keyring) (any, error) {
 	if plain == encrypted {
 		if encryptedStr, ok := encrypted.(string); ok {
 			if strings.HasPrefix(encryptedStr, ageEncryptionPrefix) && strings.HasSuffix(encryptedStr, ageEncryptionSuffix) {
@@ -439,14 +523,14 @@ func mergeAndEncryptScalar(plain, encrypted any, identity *age.X25519Identity) (
 		}
 	}
 
-	return encryptYAMLValues(plain, identity.Recipient())
+	return encryptYAMLValues(plain, keys.recipients)
 }
 
-// encryptString encrypts a string using age.
-func encryptString(plaintext string, recipient *age.X25519Recipient) (string, error) {
+// encryptString encrypts a string to every recipient using age.
+func encryptString(plaintext string, recipients ...age.Recipient) (string, error) {
 	var buf bytes.Buffer
 
-	writer, err := age.Encrypt(&buf, recipient)
+	writer, err := age.Encrypt(&buf, recipients...)
 	if err != nil {
 		return "", errors.Wrap(err, "create encrypt writer")
 	}
@@ -465,14 +549,15 @@ func encryptString(plaintext string, recipient *age.X25519Recipient) (string, er
 	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
 
-// decryptString decrypts a base64-encoded age-encrypted string.
-func decryptString(encryptedBase64 string, identity *age.X25519Identity) (string, error) {
+// decryptString decrypts a base64-encoded age-encrypted string with
+// whichever of identities it was encrypted to.
+func decryptString(encryptedBase64 string, identities ...age.Identity) (string, error) {
 	encrypted, err := base64.StdEncoding.DecodeString(encryptedBase64)
 	if err != nil {
 		return "", errors.Wrap(err, "decode base64")
 	}
 
-	reader, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+	reader, err := age.Decrypt(bytes.NewReader(encrypted), identities...)
 	if err != nil {
 		return "", errors.Wrap(err, "create decrypt reader")
 	}
@@ -515,8 +600,8 @@ func decryptString(encryptedBase64 string, identity *age.X25519Identity) (string
 // security layer, but world-readable secrets material on shared
 // workstations invites mistakes).
 func RotateKeys(rootDir string) error {
-	keyFile := filepath.Join(rootDir, keyFileName)
-	encryptedFile := filepath.Join(rootDir, encryptedSecretsFile)
+	keyFile := filepath.Join(rootDir, projectLayout.KeyFile())
+	encryptedFile := filepath.Join(rootDir, projectLayout.EncryptedSecretsFile())
 	keyBackup := keyFile + ".rotation-backup"
 	encryptedBackup := encryptedFile + ".rotation-backup"
 
@@ -615,7 +700,7 @@ func prepareRotationCiphertext(rootDir, encryptedFile string) (*age.X25519Identi
 		return nil, nil, errors.Wrap(err, "parse encrypted YAML")
 	}
 
-	decryptedSecrets, err := decryptYAMLValues(encryptedSecrets, oldIdentity)
+	decryptedSecrets, err := decryptYAMLValues(encryptedSecrets, []age.Identity{oldIdentity})
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "decrypt with old key")
 	}
@@ -625,7 +710,12 @@ func prepareRotationCiphertext(rootDir, encryptedFile string) (*age.X25519Identi
 		return nil, nil, errors.Wrap(err, "generate new identity")
 	}
 
-	encryptedSecretsNew, err := encryptYAMLValues(decryptedSecrets, newIdentity.Recipient())
+	newKeys, err := newKeyring(newIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptedSecretsNew, err := encryptYAMLValues(decryptedSecrets, newKeys.recipients)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "encrypt with new key")
 	}
@@ -763,6 +853,16 @@ func encryptYAMLPair(rootDir, plainFile, encryptedFile string) error {
 		return err
 	}
 
+	keys, err := newKeyring(identity)
+	if err != nil {
+		return err
+	}
+
+	plainData, err = yamltools.ExpandAnchors(plainData)
+	if err != nil {
+		return errors.Wrap(err, "expand anchors in plain YAML")
+	}
+
 	var plain map[string]any
 
 	err = yaml.Unmarshal(plainData, &plain)
@@ -770,7 +870,7 @@ func encryptYAMLPair(rootDir, plainFile, encryptedFile string) error {
 		return errors.Wrap(err, "parse plain YAML")
 	}
 
-	encryptedMap, err := incrementalEncryptMap(plain, encryptedFilePath, identity)
+	encryptedMap, err := incrementalEncryptMap(plain, encryptedFilePath, keys)
 	if err != nil {
 		return err
 	}
@@ -788,12 +888,12 @@ func encryptYAMLPair(rootDir, plainFile, encryptedFile string) error {
 	return nil
 }
 
-// loadOrGenerateIdentity loads the project's age identity from
-// `<rootDir>/talm.key` or creates one if the file does not exist. The
-// load-or-create semantics are what `talm init` and the encrypt
+// loadOrGenerateIdentity loads the project's age identity from the
+// layout's key file under rootDir or creates one if the file does not
+// exist. The load-or-create semantics are what `talm init` and the encrypt
 // helpers rely on across init/apply/talosconfig flows.
 func loadOrGenerateIdentity(rootDir string) (*age.X25519Identity, error) {
-	keyFile := filepath.Join(rootDir, keyFileName)
+	keyFile := filepath.Join(rootDir, projectLayout.KeyFile())
 
 	_, statErr := os.Stat(keyFile)
 	if os.IsNotExist(statErr) {
@@ -820,25 +920,25 @@ func loadOrGenerateIdentity(rootDir string) (*age.X25519Identity, error) {
 // unreadable, or unparseable destination drops to encryptYAMLMap on
 // the full plain tree, so the project recovers from a corrupted
 // destination on the next encrypt round.
-func incrementalEncryptMap(plain map[string]any, encryptedFilePath string, identity *age.X25519Identity) (map[string]any, error) {
+func incrementalEncryptMap(plain map[string]any, encryptedFilePath string, keys keyring) (map[string]any, error) {
 	_, statErr := os.Stat(encryptedFilePath)
 	if statErr != nil {
-		return encryptYAMLMap(plain, identity.Recipient())
+		return encryptYAMLMap(plain, keys.recipients)
 	}
 
 	encryptedData, err := os.ReadFile(encryptedFilePath)
 	if err != nil {
-		return encryptYAMLMap(plain, identity.Recipient())
+		return encryptYAMLMap(plain, keys.recipients)
 	}
 
 	var existing map[string]any
 
 	err = yaml.Unmarshal(encryptedData, &existing)
 	if err != nil {
-		return encryptYAMLMap(plain, identity.Recipient())
+		return encryptYAMLMap(plain, keys.recipients)
 	}
 
-	return mergeAndEncryptYAMLMap(plain, existing, identity)
+	return mergeAndEncryptYAMLMap(plain, existing, keys)
 }
 
 // encryptYAMLMap is a typed wrapper around encryptYAMLValues that
@@ -847,8 +947,8 @@ func incrementalEncryptMap(plain map[string]any, encryptedFilePath string, ident
 // rightly flags. encryptYAMLValues always returns the same kind it
 // received at the top level, so a non-map result on a map input would
 // indicate a bug rather than a runtime input shape.
-func encryptYAMLMap(plain map[string]any, recipient *age.X25519Recipient) (map[string]any, error) {
-	encrypted, err := encryptYAMLValues(plain, recipient)
+func encryptYAMLMap(plain map[string]any, recipients []age.Recipient) (map[string]any, error) {
+	encrypted, err := encryptYAMLValues(plain, recipients)
 	if err != nil {
 		return nil, errors.Wrap(err, "encrypt YAML values")
 	}
@@ -866,8 +966,8 @@ func encryptYAMLMap(plain map[string]any, recipient *age.X25519Recipient) (map[s
 // helpers exist to confine the any -> map[string]any boundary to one
 // site that emits an explicit invariant-violation error rather than
 // panicking.
-func mergeAndEncryptYAMLMap(plain, encrypted map[string]any, identity *age.X25519Identity) (map[string]any, error) {
-	merged, err := mergeAndEncryptYAMLValues(plain, encrypted, identity)
+func mergeAndEncryptYAMLMap(plain, encrypted map[string]any, keys keyring) (map[string]any, error) {
+	merged, err := mergeAndEncryptYAMLValues(plain, encrypted, keys)
 	if err != nil {
 		return nil, errors.Wrap(err, "merge and encrypt")
 	}
@@ -880,6 +980,97 @@ func mergeAndEncryptYAMLMap(plain, encrypted map[string]any, identity *age.X2551
 	return out, nil
 }
 
+// EncryptYAML encrypts the string values of the plaintext YAML document
+// plain with the project's key under rootDir, for callers that hold the
+// data in memory, such as git's clean filter. Values unchanged from
+// previous, an earlier ciphertext of the same document (nil when there is
+// none), keep their envelopes, so re-encrypting unchanged content yields
+// the same bytes. Unlike EncryptYAMLFile it never generates a key.
+func EncryptYAML(rootDir string, plain, previous []byte) ([]byte, error) {
+	identity, err := LoadKey(rootDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "load key")
+	}
+
+	keys, err := newKeyring(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err = yamltools.ExpandAnchors(plain)
+	if err != nil {
+		return nil, errors.Wrap(err, "expand anchors in plain YAML")
+	}
+
+	var plainMap map[string]any
+
+	if err := yaml.Unmarshal(plain, &plainMap); err != nil {
+		return nil, errors.Wrap(err, "parse plain YAML")
+	}
+
+	var (
+		existing  map[string]any
+		encrypted map[string]any
+	)
+
+	if yaml.Unmarshal(previous, &existing) == nil && existing != nil {
+		encrypted, err = mergeAndEncryptYAMLMap(plainMap, existing, keys)
+	} else {
+		encrypted, err = encryptYAMLMap(plainMap, keys.recipients)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := yaml.Marshal(encrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal encrypted YAML")
+	}
+
+	return out, nil
+}
+
+// DecryptYAML decrypts the string values of the YAML document encrypted
+// with the project's key under rootDir, the in-memory counterpart of
+// DecryptYAMLFile. Plaintext values pass through.
+func DecryptYAML(rootDir string, encrypted []byte) ([]byte, error) {
+	identities, err := loadIdentities(rootDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "load key")
+	}
+
+	var encryptedYAML map[string]any
+
+	if err := yaml.Unmarshal(encrypted, &encryptedYAML); err != nil {
+		return nil, errors.Wrap(err, "parse encrypted YAML")
+	}
+
+	decrypted, err := decryptYAMLValues(encryptedYAML, identities)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt YAML values")
+	}
+
+	out, err := yaml.Marshal(decrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal decrypted YAML")
+	}
+
+	return out, nil
+}
+
+// ContainsEncryptedValues reports whether the YAML document data holds
+// at least one ENC[AGE,...] envelope. Unparseable data holds none.
+func ContainsEncryptedValues(data []byte) bool {
+	var doc any
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+
+	return containsEncryptedValue(doc)
+}
+
 // DecryptYAMLFile decrypts an encrypted YAML file's values and saves to plain file.
 func DecryptYAMLFile(rootDir, encryptedFile, plainFile string) error {
 	return decryptYAMLPair(rootDir, encryptedFile, plainFile)
@@ -944,12 +1135,12 @@ func DecryptYAMLToMap(rootDir, filePath string) (map[string]any, error) {
 		return nil, errors.Wrapf(ErrNoEncryptedValues, "%q", filePath)
 	}
 
-	identity, err := LoadKey(rootDir)
+	identities, err := loadIdentities(rootDir)
 	if err != nil {
-		return nil, errors.Wrapf(err, "loading talm.key to decrypt %q", filePath)
+		return nil, errors.Wrapf(err, "loading %s to decrypt %q", projectLayout.KeyFile(), filePath)
 	}
 
-	decrypted, err := decryptYAMLValues(encryptedYAML, identity)
+	decrypted, err := decryptYAMLValues(encryptedYAML, identities)
 	if err != nil {
 		return nil, errors.Wrapf(err, "decrypt values in %q", filePath)
 	}
@@ -975,7 +1166,7 @@ func decryptYAMLPair(rootDir, encryptedFile, plainFile string) error {
 		return errors.Wrap(err, "read encrypted file")
 	}
 
-	identity, err := LoadKey(rootDir)
+	identities, err := loadIdentities(rootDir)
 	if err != nil {
 		return errors.Wrap(err, "load key")
 	}
@@ -987,7 +1178,7 @@ func decryptYAMLPair(rootDir, encryptedFile, plainFile string) error {
 		return errors.Wrap(err, "parse encrypted YAML")
 	}
 
-	decryptedYAML, err := decryptYAMLValues(encryptedYAML, identity)
+	decryptedYAML, err := decryptYAMLValues(encryptedYAML, identities)
 	if err != nil {
 		return errors.Wrap(err, "decrypt YAML values")
 	}
@@ -0,0 +1,120 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cerrors "github.com/cockroachdb/errors"
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/yamltools"
+	"gopkg.in/yaml.v3"
+)
+
+const anchorHeavyValues = `registry: &registry
+  username: robot
+  password: s3cr3t
+mirrors:
+  docker.io: *registry
+  ghcr.io:
+    <<: *registry
+    password: 0th3r
+backups: [*registry, *registry]
+`
+
+// TestContract_Age_AnchorsRoundTrip pins that a values file built on
+// anchors, aliases and merge keys decrypts to the same values it
+// means, with each aliased secret encrypted where it is used.
+func TestContract_Age_AnchorsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "values-secret.yaml"), []byte(anchorHeavyValues), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptYAMLFile(dir, "values-secret.yaml", "values-secret.encrypted.yaml"); err != nil {
+		t.Fatalf("EncryptYAMLFile: %v", err)
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(dir, "values-secret.encrypted.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(encrypted), "<<") || strings.Contains(string(encrypted), "s3cr3t") {
+		t.Errorf("encrypted file keeps a merge key or a plaintext secret:\n%s", encrypted)
+	}
+
+	if n := strings.Count(string(encrypted), "ENC[AGE"); n != 10 {
+		t.Errorf("want the 10 expanded values encrypted, got %d:\n%s", n, encrypted)
+	}
+
+	got, err := age.DecryptYAMLToMap(dir, filepath.Join(dir, "values-secret.encrypted.yaml"))
+	if err != nil {
+		t.Fatalf("DecryptYAMLToMap: %v", err)
+	}
+
+	var want map[string]any
+	if err := yaml.Unmarshal([]byte(anchorHeavyValues), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-trip mismatch\nwant: %v\ngot:  %v", want, got)
+	}
+}
+
+// TestContract_Age_EncryptYAML_AnchorsStable pins that re-encrypting an
+// unchanged anchor-heavy document keeps every envelope, as the git clean
+// filter needs.
+func TestContract_Age_EncryptYAML_AnchorsStable(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := age.EncryptYAML(dir, []byte(anchorHeavyValues), nil)
+	if err != nil {
+		t.Fatalf("EncryptYAML: %v", err)
+	}
+
+	second, err := age.EncryptYAML(dir, []byte(anchorHeavyValues), first)
+	if err != nil {
+		t.Fatalf("EncryptYAML: %v", err)
+	}
+
+	if string(first) != string(second) {
+		t.Errorf("re-encryption changed the ciphertext\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+}
+
+func TestContract_Age_AnchorCycleRejected(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "values-secret.yaml"), []byte("a: &x\n  b: *x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := age.EncryptYAMLFile(dir, "values-secret.yaml", "values-secret.encrypted.yaml")
+	if !cerrors.Is(err, yamltools.ErrAnchorCycle) {
+		t.Fatalf("want ErrAnchorCycle, got %v", err)
+	}
+
+	if _, statErr := os.Stat(filepath.Join(dir, "values-secret.encrypted.yaml")); !os.IsNotExist(statErr) {
+		t.Errorf("a rejected file must not be encrypted, stat err = %v", statErr)
+	}
+}
@@ -838,3 +838,69 @@ func lineWithPrefix(content, prefix string) string {
 	}
 	return ""
 }
+
+// === EncryptYAML / DecryptYAML ===
+
+// Contract: the in-memory pair round-trips, reuses the ciphertext of
+// unchanged values when given the previous encryption, and never
+// generates a key.
+func TestContract_Age_EncryptYAML_InMemory(t *testing.T) {
+	dir := t.TempDir()
+	plain := []byte("cluster:\n    secret: s3cr3t\n")
+
+	if _, err := age.EncryptYAML(dir, plain, nil); err == nil {
+		t.Fatal("EncryptYAML must not generate a missing key")
+	}
+
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	first, err := age.EncryptYAML(dir, plain, nil)
+	if err != nil {
+		t.Fatalf("EncryptYAML: %v", err)
+	}
+
+	if strings.Contains(string(first), "s3cr3t") || !age.ContainsEncryptedValues(first) {
+		t.Fatalf("not encrypted:\n%s", first)
+	}
+
+	second, err := age.EncryptYAML(dir, plain, first)
+	if err != nil || string(second) != string(first) {
+		t.Errorf("unchanged values must keep their ciphertext: err = %v\n%s\n---\n%s", err, first, second)
+	}
+
+	decrypted, err := age.DecryptYAML(dir, first)
+	if err != nil || string(decrypted) != string(plain) {
+		t.Errorf("DecryptYAML = %q, err = %v", decrypted, err)
+	}
+}
+
+// Contract: a plaintext checked out with CRLF line endings (Windows,
+// core.autocrlf) encrypts to the same values as its LF twin: a
+// multi-line secret carries no "\r" into its ciphertext, and the LF
+// ciphertext stays stable when re-encrypted from the CRLF form.
+func TestContract_Age_EncryptYAML_CRLF(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	plain := []byte("cluster:\n    ca: |\n        line1\n        line2\n    secret: s3cr3t\n")
+	crlf := []byte(strings.ReplaceAll(string(plain), "\n", "\r\n"))
+
+	first, err := age.EncryptYAML(dir, plain, nil)
+	if err != nil {
+		t.Fatalf("EncryptYAML: %v", err)
+	}
+
+	second, err := age.EncryptYAML(dir, crlf, first)
+	if err != nil || string(second) != string(first) {
+		t.Errorf("the CRLF checkout must keep the ciphertext of the LF one: err = %v\n%s\n---\n%s", err, first, second)
+	}
+
+	decrypted, err := age.DecryptYAML(dir, second)
+	if err != nil || string(decrypted) != string(plain) {
+		t.Errorf("DecryptYAML = %q, err = %v", decrypted, err)
+	}
+}
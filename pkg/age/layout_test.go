@@ -0,0 +1,117 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cerrors "github.com/cockroachdb/errors"
+	"github.com/cozystack/talm/pkg/age"
+)
+
+func TestLayout_Defaults(t *testing.T) {
+	t.Parallel()
+
+	var l age.Layout
+	for _, tc := range []struct{ got, want string }{
+		{l.KeyFile(), "talm.key"},
+		{l.SecretsFile(), "secrets.yaml"},
+		{l.EncryptedSecretsFile(), "secrets.encrypted.yaml"},
+		{l.ValuesSecretFile(), "values-secret.yaml"},
+		{l.EncryptedValuesSecretFile(), "values-secret.encrypted.yaml"},
+	} {
+		if got, want := tc.got, tc.want; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	moved := age.Layout{Key: "secrets/age.key", Secrets: "secrets/cluster.yaml"}
+	if moved.KeyFile() != "secrets/age.key" || moved.EncryptedSecretsFile() != "secrets/cluster.encrypted.yaml" {
+		t.Errorf("moved layout = %q, %q", moved.KeyFile(), moved.EncryptedSecretsFile())
+	}
+}
+
+// withLayout sets the package layout for one test and restores it.
+func withLayout(t *testing.T, l age.Layout) {
+	t.Helper()
+
+	orig := age.CurrentLayout()
+	t.Cleanup(func() { age.SetLayout(orig) })
+
+	age.SetLayout(l)
+}
+
+// TestSetLayout_MovesKeyAndSecrets pins that the rootDir-based helpers
+// follow the layout: the key is generated into a new subdirectory and
+// the secrets round-trip through the configured names.
+func TestSetLayout_MovesKeyAndSecrets(t *testing.T) {
+	withLayout(t, age.Layout{Key: "secrets/age.key", Secrets: "secrets/cluster.yaml"})
+
+	dir := t.TempDir()
+
+	if _, created, err := age.GenerateKey(dir); err != nil || !created {
+		t.Fatalf("GenerateKey: created = %v, err = %v", created, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "secrets", "age.key")); err != nil {
+		t.Fatalf("key not at the configured path: %v", err)
+	}
+
+	plain := filepath.Join(dir, "secrets", "cluster.yaml")
+	if err := os.WriteFile(plain, []byte("cluster:\n  secret: s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptSecretsFile(dir); err != nil {
+		t.Fatalf("EncryptSecretsFile: %v", err)
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(dir, "secrets", "cluster.encrypted.yaml"))
+	if err != nil || strings.Contains(string(encrypted), "s3cr3t") {
+		t.Fatalf("encrypted file: err = %v, content = %q", err, encrypted)
+	}
+
+	if err := os.Remove(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.DecryptSecretsFile(dir); err != nil {
+		t.Fatalf("DecryptSecretsFile: %v", err)
+	}
+
+	if data, err := os.ReadFile(plain); err != nil || !strings.Contains(string(data), "s3cr3t") {
+		t.Fatalf("decrypted file: err = %v, content = %q", err, data)
+	}
+
+	if err := age.RotateKeys(dir); err != nil {
+		t.Fatalf("RotateKeys: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "talm.key")); !os.IsNotExist(err) {
+		t.Errorf("no key may be written at the default path, stat err = %v", err)
+	}
+}
+
+func TestLoadKey_MissingHintNamesConfiguredKey(t *testing.T) {
+	withLayout(t, age.Layout{Key: "secrets/age.key"})
+
+	_, err := age.LoadKey(t.TempDir())
+	if err == nil || !strings.Contains(strings.Join(cerrors.GetAllHints(err), "\n"), "secrets/age.key") {
+		t.Errorf("the missing-key hint must name the configured key, got %v", err)
+	}
+}
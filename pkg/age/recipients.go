@@ -0,0 +1,330 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// SSHKeyType is the one SSH key type talm takes as a recipient or
+	// an identity.
+	SSHKeyType = "ssh-ed25519"
+
+	// SSHIdentityFile is the operator's SSH key, relative to the home
+	// directory, that stands in for the project key when it is absent.
+	SSHIdentityFile = ".ssh/id_ed25519"
+
+	// fileKeySize is the size of an age file key, wrapped once to learn
+	// the header stanza of a recipient.
+	fileKeySize = 16
+
+	// recipientSetStanza is the header stanza that records the digest of
+	// the recipients a value was encrypted to. Identities skip stanza
+	// types they do not know, so it is invisible to decryption.
+	recipientSetStanza = "talm-recipients"
+)
+
+// ParseRecipient parses one extra recipient: an age public key
+// (age1...) or an ssh-ed25519 public key as written in authorized_keys,
+// comment included.
+func ParseRecipient(line string) (age.Recipient, error) {
+	line = strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(line, "age1"):
+		recipient, err := age.ParseX25519Recipient(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse age recipient %q", line)
+		}
+
+		return recipient, nil
+	case strings.HasPrefix(line, SSHKeyType+" "):
+		recipient, err := agessh.ParseRecipient(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse SSH recipient %q", line)
+		}
+
+		return recipient, nil
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("unsupported recipient %q", line),
+			"use an age public key (age1...) or an %s public key", SSHKeyType,
+		)
+	}
+}
+
+// keyring is what the project encrypts to and decrypts with: the
+// recipients of the project key and of the layout's extra recipients,
+// and the identities to read existing ciphertext with.
+type keyring struct {
+	recipients []age.Recipient
+	identities []age.Identity
+	// stanzas are the header stanzas the recipients write, sorted, to
+	// tell whether an existing ciphertext was encrypted to all of them.
+	stanzas []string
+}
+
+// recipientSet is a recipient that wraps nothing: its stanza carries a
+// digest of the exact keys a value is encrypted to. X25519 stanzas do
+// not tell one key from another, so without it swapping one age
+// recipient for another would keep the ciphertext of the old one.
+type recipientSet struct {
+	digest string
+}
+
+// newRecipientSet returns the recipientSet of the public keys, in any
+// order.
+func newRecipientSet(keys []string) recipientSet {
+	keys = slices.Sorted(slices.Values(keys))
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+
+	return recipientSet{digest: base64.RawStdEncoding.EncodeToString(sum[:])}
+}
+
+// Wrap implements age.Recipient.
+func (r recipientSet) Wrap([]byte) ([]*age.Stanza, error) {
+	return []*age.Stanza{{Type: recipientSetStanza, Args: []string{r.digest}}}, nil
+}
+
+// newKeyring returns the keyring of the project key identity and the
+// layout's extra recipients. With extra recipients it also records
+// their exact set in every value, so any change to it re-encrypts.
+func newKeyring(identity *age.X25519Identity) (keyring, error) {
+	keys := keyring{
+		recipients: []age.Recipient{identity.Recipient()},
+		identities: []age.Identity{identity},
+	}
+
+	publicKeys := []string{identity.Recipient().String()}
+
+	for _, line := range projectLayout.Recipients {
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			return keyring{}, err
+		}
+
+		keys.recipients = append(keys.recipients, recipient)
+		publicKeys = append(publicKeys, recipientKey(line))
+	}
+
+	if len(projectLayout.Recipients) > 0 {
+		keys.recipients = append(keys.recipients, newRecipientSet(publicKeys))
+	}
+
+	for _, recipient := range keys.recipients {
+		stanza, err := recipientStanza(recipient)
+		if err != nil {
+			return keyring{}, err
+		}
+
+		keys.stanzas = append(keys.stanzas, stanza)
+	}
+
+	slices.Sort(keys.stanzas)
+
+	return keys, nil
+}
+
+// encryptedTo reports whether the envelope was encrypted to exactly
+// the keyring's recipients. A recipient added, removed or replaced
+// since makes it false, so the value is encrypted again for the new
+// set.
+func (k keyring) encryptedTo(envelope string) bool {
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(envelope, ageEncryptionPrefix), ageEncryptionSuffix))
+	if err != nil {
+		return false
+	}
+
+	stanzas, err := ciphertextStanzas(ciphertext)
+	if err != nil {
+		return false
+	}
+
+	return slices.Equal(stanzas, k.stanzas)
+}
+
+// recipientStanza names the header stanza recipient writes: its type,
+// and for an SSH key the key tag, which stays the same across
+// encryptions. An X25519 stanza carries only an ephemeral share, so
+// every X25519 recipient reads the same; the recipientSet stanza is
+// what tells one set of them from another.
+func recipientStanza(recipient age.Recipient) (string, error) {
+	stanzas, err := recipient.Wrap(make([]byte, fileKeySize))
+	if err != nil {
+		return "", errors.Wrap(err, "wrap a file key")
+	}
+
+	if len(stanzas) == 0 {
+		return "", errors.New("recipient wrote no header stanza")
+	}
+
+	return stanzaName(stanzas[0].Type, stanzas[0].Args), nil
+}
+
+func stanzaName(kind string, args []string) string {
+	if (kind == SSHKeyType || kind == recipientSetStanza) && len(args) > 0 {
+		return kind + " " + args[0]
+	}
+
+	return kind
+}
+
+// ciphertextStanzas lists the recipient stanzas in the header of an
+// age ciphertext as recipientStanza names them, sorted.
+func ciphertextStanzas(ciphertext []byte) ([]string, error) {
+	header, err := age.ExtractHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, errors.Wrap(err, "read age header")
+	}
+
+	var stanzas []string
+
+	for line := range strings.SplitSeq(string(header), "\n") {
+		stanza, ok := strings.CutPrefix(line, "-> ")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(stanza)
+		if len(fields) == 0 {
+			continue
+		}
+
+		stanzas = append(stanzas, stanzaName(fields[0], fields[1:]))
+	}
+
+	slices.Sort(stanzas)
+
+	return stanzas, nil
+}
+
+// recipientKey is the public key of a recipient line as the digest of
+// the recipient set takes it: an SSH key without its comment, which
+// may change without the key changing.
+func recipientKey(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// loadIdentities returns the identities that decrypt the project's
+// values: the project key or, when the key file is absent, the
+// operator's SSH key, provided the layout lists its public key among
+// the extra recipients.
+func loadIdentities(rootDir string) ([]age.Identity, error) {
+	identity, err := LoadKey(rootDir)
+	if err == nil {
+		return []age.Identity{identity}, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) || len(projectLayout.Recipients) == 0 {
+		return nil, err
+	}
+
+	sshIdentity, sshErr := loadSSHIdentity()
+	if sshErr != nil {
+		if errors.Is(sshErr, os.ErrNotExist) {
+			return nil, err
+		}
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(sshErr, "%s is absent and ~/%s cannot stand in for it", projectLayout.KeyFile(), SSHIdentityFile),
+			"talm reads an SSH key only without a passphrase; restore %s instead", projectLayout.KeyFile(),
+		)
+	}
+
+	listed, stanzaErr := listsRecipient(sshIdentity.Recipient())
+	if stanzaErr != nil {
+		return nil, stanzaErr
+	}
+
+	if !listed {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(err,
+			"~/%s is not among the project recipients; ask a teammate to add its public key to globalOptions.recipients and re-encrypt", SSHIdentityFile,
+		)
+	}
+
+	return []age.Identity{sshIdentity}, nil
+}
+
+// loadSSHIdentity reads the operator's ssh-ed25519 key from
+// ~/.ssh/id_ed25519.
+func loadSSHIdentity() (*agessh.Ed25519Identity, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "locate the home directory")
+	}
+
+	pemBytes, err := os.ReadFile(filepath.Join(home, filepath.FromSlash(SSHIdentityFile)))
+	if err != nil {
+		return nil, errors.Wrap(err, "read SSH key")
+	}
+
+	identity, err := agessh.ParseIdentity(pemBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse SSH key")
+	}
+
+	ed25519Identity, ok := identity.(*agessh.Ed25519Identity)
+	if !ok {
+		return nil, errors.Newf("~/%s is not an %s key", SSHIdentityFile, SSHKeyType)
+	}
+
+	return ed25519Identity, nil
+}
+
+// listsRecipient reports whether the layout's extra recipients include
+// recipient.
+func listsRecipient(recipient age.Recipient) (bool, error) {
+	want, err := recipientStanza(recipient)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range projectLayout.Recipients {
+		listed, err := ParseRecipient(line)
+		if err != nil {
+			return false, err
+		}
+
+		stanza, err := recipientStanza(listed)
+		if err != nil {
+			return false, err
+		}
+
+		if stanza == want {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,112 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package certexpiry reads the validity of the certificate authorities
+// in a Talos secrets bundle. The CAs in secrets.yaml are generated
+// with a ten-year lifetime and nothing in a running cluster warns
+// before they lapse; the chart engine and `talm secrets status` both
+// use this package to make the dates visible while there is still time
+// to run rotate-ca.
+package certexpiry
+
+import (
+	"math"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/crypto/x509"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// hoursPerDay converts a remaining duration to whole days.
+const hoursPerDay = 24
+
+// Entry is the validity of one secret in the bundle. Secrets that are
+// bare keys (the service account key) have no expiry; HasExpiry is
+// false and NotAfter is zero for them.
+type Entry struct {
+	// Key is the stable identifier exposed to templates, e.g. talosCA.
+	Key string
+	// Name is the human-readable name printed by the CLI.
+	Name      string
+	NotAfter  time.Time
+	HasExpiry bool
+}
+
+// FromBundle lists the secrets of bundle in a fixed order: Talos API
+// CA, Kubernetes CA, Kubernetes aggregator CA, etcd CA, service
+// account key. Entries missing from an older bundle are skipped.
+func FromBundle(bundle *secrets.Bundle) ([]Entry, error) {
+	if bundle == nil || bundle.Certs == nil {
+		return nil, errors.New("secrets bundle carries no certificates")
+	}
+
+	cas := []struct {
+		key, name string
+		ca        *x509.PEMEncodedCertificateAndKey
+	}{
+		{"talosCA", "Talos API CA", bundle.Certs.OS},
+		{"kubernetesCA", "Kubernetes CA", bundle.Certs.K8s},
+		{"kubernetesAggregatorCA", "Kubernetes aggregator CA", bundle.Certs.K8sAggregator},
+		{"etcdCA", "etcd CA", bundle.Certs.Etcd},
+	}
+
+	entries := make([]Entry, 0, len(cas)+1)
+
+	for _, ca := range cas {
+		if ca.ca == nil || len(ca.ca.Crt) == 0 {
+			continue
+		}
+
+		crt, err := ca.ca.GetCert()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s certificate", ca.name)
+		}
+
+		entries = append(entries, Entry{Key: ca.key, Name: ca.name, NotAfter: crt.NotAfter, HasExpiry: true})
+	}
+
+	if bundle.Certs.K8sServiceAccount != nil {
+		entries = append(entries, Entry{Key: "serviceAccountKey", Name: "Kubernetes service account key"})
+	}
+
+	return entries, nil
+}
+
+// DaysLeft is the number of whole days from now until notAfter,
+// rounded down; negative once notAfter has passed.
+func DaysLeft(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / hoursPerDay))
+}
+
+// TemplateValues shapes entries for the chart render context: a map
+// keyed by Entry.Key whose values carry notAfter (a time.Time, usable
+// with sprig's date functions) and daysLeft. Entries without expiry
+// are omitted.
+func TemplateValues(entries []Entry, now time.Time) map[string]any {
+	values := make(map[string]any, len(entries))
+
+	for _, entry := range entries {
+		if !entry.HasExpiry {
+			continue
+		}
+
+		values[entry.Key] = map[string]any{
+			"notAfter": entry.NotAfter,
+			"daysLeft": DaysLeft(entry.NotAfter, now),
+		}
+	}
+
+	return values
+}
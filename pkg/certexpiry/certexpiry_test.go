@@ -0,0 +1,102 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certexpiry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozystack/talm/pkg/certexpiry"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+func TestFromBundle(t *testing.T) {
+	t.Parallel()
+
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(issued), config.TalosVersionCurrent)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+
+	entries, err := certexpiry.FromBundle(bundle)
+	if err != nil {
+		t.Fatalf("FromBundle: %v", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+
+	want := []string{"talosCA", "kubernetesCA", "kubernetesAggregatorCA", "etcdCA", "serviceAccountKey"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys = %v, want %v", keys, want)
+		}
+	}
+
+	talosCA := entries[0]
+	if !talosCA.HasExpiry || talosCA.NotAfter.Before(issued.AddDate(9, 0, 0)) {
+		t.Errorf("talos CA must carry its multi-year expiry, got %+v", talosCA)
+	}
+
+	if entries[4].HasExpiry {
+		t.Errorf("the service account key has no expiry, got %+v", entries[4])
+	}
+
+	values := certexpiry.TemplateValues(entries, issued)
+	if _, ok := values["serviceAccountKey"]; ok {
+		t.Error("entries without expiry must not reach templates")
+	}
+
+	talos, ok := values["talosCA"].(map[string]any)
+	if !ok || talos["daysLeft"].(int) < 365*9 {
+		t.Errorf("talosCA template values = %v", values["talosCA"])
+	}
+}
+
+func TestFromBundle_NoCerts(t *testing.T) {
+	t.Parallel()
+
+	if _, err := certexpiry.FromBundle(&secrets.Bundle{}); err == nil {
+		t.Error("a bundle without certificates must be rejected")
+	}
+}
+
+func TestDaysLeft(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		notAfter time.Time
+		want     int
+	}{
+		{now.Add(49 * time.Hour), 2},
+		{now.Add(23 * time.Hour), 0},
+		{now.Add(-time.Hour), -1},
+	} {
+		if got := certexpiry.DaysLeft(tc.notAfter, now); got != tc.want {
+			t.Errorf("DaysLeft(%s) = %d, want %d", tc.notAfter.Sub(now), got, tc.want)
+		}
+	}
+}
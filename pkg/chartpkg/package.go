@@ -0,0 +1,412 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chartpkg turns a talm project into a versioned Helm chart
+// archive and publishes it to an OCI registry, so downstream clusters
+// can consume a reviewed base chart instead of copying project
+// directories around. The archive carries the chart only: cluster
+// secrets, client configs, node files and talm state never leave the
+// project.
+package chartpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v4/pkg/ignore"
+)
+
+const (
+	// ChartYAML is the chart metadata file at the project root.
+	ChartYAML = "Chart.yaml"
+
+	// archiveMode is the mode of every file in the archive; the
+	// chart is read, never executed.
+	archiveMode = 0o644
+
+	// chartYAMLIndent matches the 2-space indent talm writes.
+	chartYAMLIndent = 2
+)
+
+// excludedFiles are project files that must never be published,
+// wherever .helmignore says otherwise: the Talos secrets bundle, the
+// client configs, the age key and plaintext secret values, in both
+// their plain and encrypted form.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var excludedFiles = []string{
+	"secrets.yaml",
+	"secrets.encrypted.yaml",
+	"talosconfig",
+	"talosconfig.encrypted",
+	"kubeconfig",
+	"kubeconfig.encrypted",
+	"talm.key",
+	"values-secret.yaml",
+	"values-secret.encrypted.yaml",
+}
+
+// excludedDirs are top-level directories that describe one cluster
+// rather than the chart: node files and talm state, plus VCS metadata.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var excludedDirs = []string{"nodes", ".talm", ".git"}
+
+// semverRe is the SemVer 2 grammar Helm requires of a chart version.
+var semverRe = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// Metadata is the part of Chart.yaml that names and versions the
+// chart. It is also the config blob of the pushed OCI artifact.
+type Metadata struct {
+	APIVersion  string `json:"apiVersion"            yaml:"apiVersion"`
+	Name        string `json:"name"                  yaml:"name"`
+	Version     string `json:"version"               yaml:"version"`
+	AppVersion  string `json:"appVersion,omitempty"  yaml:"appVersion"`
+	Description string `json:"description,omitempty" yaml:"description"`
+	Type        string `json:"type,omitempty"        yaml:"type"`
+}
+
+// Options tune Package.
+type Options struct {
+	// Version overrides the Chart.yaml version in the archive. The
+	// project's Chart.yaml is left as is.
+	Version string
+	// Exclude lists further project-relative paths to leave out, such
+	// as a talosconfig or kubeconfig moved away from its default name.
+	Exclude []string
+}
+
+// Package builds the chart archive of the project at root. It returns
+// the archive, a gzipped tar whose entries sit under <name>/ as Helm
+// expects, and the chart metadata. File times are fixed, so the same
+// project content always packages to the same bytes and digest.
+func Package(root string, opts Options) ([]byte, Metadata, error) {
+	chartYAML, meta, err := loadChartYAML(root, opts.Version)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	files, err := Files(root, opts.Exclude)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, rel := range files {
+		data := chartYAML
+		if rel != ChartYAML {
+			data, err = os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+			if err != nil {
+				return nil, Metadata{}, errors.Wrapf(err, "reading %s", rel)
+			}
+		}
+
+		if err := writeTarFile(tw, path.Join(meta.Name, rel), data); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, Metadata{}, errors.Wrap(err, "finishing the chart archive")
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, Metadata{}, errors.Wrap(err, "compressing the chart archive")
+	}
+
+	return buf.Bytes(), meta, nil
+}
+
+// Files lists the chart files of the project at root, as Package
+// archives them: slash-separated paths relative to root, in lexical
+// order, without the cluster state, the files .helmignore names and
+// the further project-relative paths in exclude.
+func Files(root string, exclude []string) ([]string, error) {
+	rules, err := loadIgnoreRules(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectFiles(root, rules, exclude)
+}
+
+// ArchiveName is the conventional file name of a chart archive.
+func ArchiveName(meta Metadata) string {
+	return meta.Name + "-" + meta.Version + ".tgz"
+}
+
+// loadChartYAML reads Chart.yaml, applies the version override and
+// returns the bytes to archive together with the parsed metadata.
+func loadChartYAML(root, version string) ([]byte, Metadata, error) {
+	chartPath := filepath.Join(root, ChartYAML)
+
+	data, err := os.ReadFile(chartPath)
+	if err != nil {
+		return nil, Metadata{}, errors.Wrapf(err, "reading %s", chartPath)
+	}
+
+	if version != "" {
+		data, err = setChartVersion(data, version)
+		if err != nil {
+			return nil, Metadata{}, errors.Wrapf(err, "setting the version in %s", chartPath)
+		}
+	}
+
+	meta, err := parseMetadata(data)
+	if err != nil {
+		return nil, Metadata{}, errors.Wrapf(err, "%s", chartPath)
+	}
+
+	return data, meta, nil
+}
+
+// parseMetadata decodes Chart.yaml and checks the fields an OCI chart
+// reference is built from.
+func parseMetadata(data []byte) (Metadata, error) {
+	var meta Metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return Metadata{}, errors.Wrap(err, "parsing chart metadata")
+	}
+
+	if meta.Name == "" || strings.ContainsAny(meta.Name, "/\\:") {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return Metadata{}, errors.WithHint(
+			errors.Newf("invalid chart name %q", meta.Name),
+			"set name in Chart.yaml to a plain name such as the cluster name",
+		)
+	}
+
+	if !semverRe.MatchString(meta.Version) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return Metadata{}, errors.WithHint(
+			errors.Newf("chart version %q is not a semantic version", meta.Version),
+			"set version in Chart.yaml, or pass --version, to a version such as 1.4.0",
+		)
+	}
+
+	return meta, nil
+}
+
+// setChartVersion rewrites the version key of Chart.yaml through the
+// YAML document tree, so the talm keys and comments in it are kept.
+func setChartVersion(data []byte, version string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "parsing chart metadata")
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("Chart.yaml is not a mapping")
+	}
+
+	root := doc.Content[0]
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: version}
+
+	found := false
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "version" {
+			root.Content[i+1] = value
+			found = true
+		}
+	}
+
+	if !found {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}, value)
+	}
+
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(chartYAMLIndent)
+
+	if err := enc.Encode(&doc); err != nil {
+		return nil, errors.Wrap(err, "encoding chart metadata")
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, errors.Wrap(err, "encoding chart metadata")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadIgnoreRules reads the project's .helmignore, if any, with Helm's
+// own defaults added.
+func loadIgnoreRules(root string) (*ignore.Rules, error) {
+	rules := ignore.Empty()
+
+	ignorePath := filepath.Join(root, ignore.HelmIgnore)
+	if _, err := os.Stat(ignorePath); err == nil {
+		rules, err = ignore.ParseFile(ignorePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", ignorePath)
+		}
+	}
+
+	rules.AddDefaults()
+
+	return rules, nil
+}
+
+// collectFiles lists the project files that go into the archive as
+// slash-separated paths relative to root, in lexical order.
+func collectFiles(root string, rules *ignore.Rules, extra []string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped once below with the project root.
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped once below with the project root.
+		}
+
+		if excluded(rel, d.IsDir(), extra) || rules.Ignore(rel, info) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		switch {
+		case d.IsDir():
+			return nil
+		case !d.Type().IsRegular():
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("%s is not a regular file", rel),
+				"replace the link with a copy of its target, or list it in .helmignore",
+			)
+		}
+
+		files = append(files, rel)
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "collecting the chart files under %s", root)
+	}
+
+	if !slices.Contains(files, ChartYAML) {
+		return nil, errors.Newf("%s is excluded from the archive; a chart needs it", ChartYAML)
+	}
+
+	return files, nil
+}
+
+// excluded reports whether rel is one of the project files that never
+// leave the project: secrets and client configs anywhere, cluster
+// directories and earlier archives at the top level.
+func excluded(rel string, isDir bool, extra []string) bool {
+	if slices.Contains(extra, rel) {
+		return true
+	}
+
+	topLevel := !strings.Contains(rel, "/")
+
+	if isDir {
+		return topLevel && slices.Contains(excludedDirs, rel)
+	}
+
+	if slices.Contains(excludedFiles, path.Base(rel)) {
+		return true
+	}
+
+	return topLevel && strings.HasSuffix(rel, ".tgz")
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     archiveMode,
+		Size:     int64(len(data)),
+		ModTime:  time.Unix(0, 0).UTC(),
+		Format:   tar.FormatPAX,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "archiving %s", name)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return errors.Wrapf(err, "archiving %s", name)
+	}
+
+	return nil
+}
+
+// ReadMetadata returns the metadata of a chart archive built by
+// Package (or helm package) from its <name>/Chart.yaml entry.
+func ReadMetadata(archive []byte) (Metadata, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return Metadata{}, errors.Wrap(err, "opening the chart archive")
+	}
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return Metadata{}, errors.Newf("the archive has no top-level <chart>/%s", ChartYAML)
+		}
+
+		if err != nil {
+			return Metadata{}, errors.Wrap(err, "reading the chart archive")
+		}
+
+		dir, name := path.Split(header.Name)
+		if name != ChartYAML || strings.Count(strings.Trim(dir, "/"), "/") != 0 || dir == "" {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return Metadata{}, errors.Wrapf(err, "reading %s", header.Name)
+		}
+
+		return parseMetadata(data)
+	}
+}
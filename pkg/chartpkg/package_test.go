@@ -0,0 +1,243 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chartpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testChartYAML = `apiVersion: v2
+name: prod
+version: 0.1.0
+# talm settings stay in the archive.
+templateOptions:
+  talosVersion: "v1.13"
+`
+
+// writeProject lays out a talm project with every kind of file
+// Package has to keep or drop.
+func writeProject(t *testing.T, extra map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"Chart.yaml":                   testChartYAML,
+		"values.yaml":                  "endpoint: https://192.0.2.1:6443\n",
+		"templates/controlplane.yaml":  "{{ include \"talm.config\" . }}\n",
+		"charts/talm/Chart.yaml":       "apiVersion: v2\nname: talm\nversion: 0.1.0\ntype: library\n",
+		"charts/talm/templates/_x.tpl": "{{- define \"talm.config\" }}{{ end }}\n",
+		"files/ca.pem":                 "pem\n",
+		"secrets.yaml":                 "secret\n",
+		"secrets.encrypted.yaml":       "secret\n",
+		"talosconfig":                  "secret\n",
+		"talosconfig.encrypted":        "secret\n",
+		"kubeconfig":                   "secret\n",
+		"talm.key":                     "secret\n",
+		"values-secret.yaml":           "secret\n",
+		"values-secret.encrypted.yaml": "secret\n",
+		"nodes/cp1.yaml":               "node\n",
+		".talm/state/history.jsonl":    "state\n",
+		"prod-0.0.9.tgz":               "old archive\n",
+	}
+
+	for name, content := range extra {
+		files[name] = content
+	}
+
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// archiveEntries returns the entry names and contents of a chart archive.
+func archiveEntries(t *testing.T, archive []byte) map[string]string {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(gz)
+	entries := map[string]string{}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries[header.Name] = string(data)
+	}
+}
+
+func TestPackage_KeepsChartOnly(t *testing.T) {
+	t.Parallel()
+
+	root := writeProject(t, map[string]string{
+		".helmignore":         "*.md\n",
+		"README.md":           "docs\n",
+		"custom/talosconfig":  "secret\n",
+		"templates/.swp.yaml": "editor swap\n",
+	})
+
+	archive, meta, err := Package(root, Options{Exclude: []string{"files/ca.pem"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Name != "prod" || meta.Version != "0.1.0" || ArchiveName(meta) != "prod-0.1.0.tgz" {
+		t.Errorf("metadata = %+v", meta)
+	}
+
+	var names []string
+	for name := range archiveEntries(t, archive) {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	want := []string{
+		"prod/.helmignore",
+		"prod/Chart.yaml",
+		"prod/charts/talm/Chart.yaml",
+		"prod/charts/talm/templates/_x.tpl",
+		"prod/templates/controlplane.yaml",
+		"prod/values.yaml",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("entries = %v\nwant %v", names, want)
+	}
+}
+
+// TestFiles_ListsChartFiles pins that Files lists what Package
+// archives, as project-relative paths.
+func TestFiles_ListsChartFiles(t *testing.T) {
+	t.Parallel()
+
+	root := writeProject(t, nil)
+
+	files, err := Files(root, []string{"files/ca.pem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Chart.yaml",
+		"charts/talm/Chart.yaml",
+		"charts/talm/templates/_x.tpl",
+		"templates/controlplane.yaml",
+		"values.yaml",
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("files = %v\nwant %v", files, want)
+	}
+}
+
+func TestPackage_VersionOverride(t *testing.T) {
+	t.Parallel()
+
+	root := writeProject(t, nil)
+
+	archive, meta, err := Package(root, Options{Version: "1.4.0-rc.1+build.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Version != "1.4.0-rc.1+build.7" {
+		t.Errorf("version = %q", meta.Version)
+	}
+
+	chart := archiveEntries(t, archive)["prod/Chart.yaml"]
+	if !strings.Contains(chart, "version: 1.4.0-rc.1+build.7") || !strings.Contains(chart, "# talm settings stay in the archive.") {
+		t.Errorf("archived Chart.yaml:\n%s", chart)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(root, "Chart.yaml")); string(data) != testChartYAML {
+		t.Error("the project Chart.yaml must not change")
+	}
+
+	if got, err := ReadMetadata(archive); err != nil || got != meta {
+		t.Errorf("ReadMetadata = %+v, %v; want %+v", got, err, meta)
+	}
+
+	if _, _, err := Package(root, Options{Version: "1.4"}); err == nil || !strings.Contains(err.Error(), "not a semantic version") {
+		t.Errorf("invalid version: error = %v", err)
+	}
+}
+
+// TestPackage_Reproducible pins that unchanged content packages to
+// the same bytes, so a pushed digest can be checked against a rebuild.
+func TestPackage_Reproducible(t *testing.T) {
+	t.Parallel()
+
+	root := writeProject(t, nil)
+
+	first, _, err := Package(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _, err := Package(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Error("packaging the same project twice must give the same archive")
+	}
+}
+
+func TestPackage_RefusesSymlinks(t *testing.T) {
+	t.Parallel()
+
+	root := writeProject(t, nil)
+
+	if err := os.Symlink(filepath.Join(root, "secrets.yaml"), filepath.Join(root, "templates", "link.yaml")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	if _, _, err := Package(root, Options{}); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chartpkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/httpclient"
+)
+
+const (
+	// ConfigMediaType and ContentMediaType are the media types Helm
+	// gives the config and chart layer of an OCI chart artifact.
+	ConfigMediaType  = "application/vnd.cncf.helm.config.v1+json"
+	ContentMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+	// manifestMediaType is the OCI image manifest the artifact is
+	// described by.
+	manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// ociScheme prefixes a registry reference, as in helm push.
+	ociScheme = "oci://"
+
+	// pushTimeout bounds one registry request.
+	pushTimeout = 2 * time.Minute
+
+	// errorBodyLimit caps how much of an error response is quoted in
+	// the returned error.
+	errorBodyLimit = 512
+)
+
+// PushOptions tune Push.
+type PushOptions struct {
+	// PlainHTTP talks to the registry over http instead of https.
+	PlainHTTP bool
+	// InsecureSkipTLSVerify accepts any registry certificate.
+	InsecureSkipTLSVerify bool
+	// Username and Password authenticate to the registry. Without
+	// them the `auths` entry of the docker config is used.
+	Username string
+	Password string
+	// DockerConfig is the docker config file to read credentials
+	// from. Empty means $DOCKER_CONFIG/config.json or
+	// ~/.docker/config.json.
+	DockerConfig string
+	// Client replaces the HTTP client, for tests.
+	Client *http.Client
+}
+
+// Pushed describes an artifact written to the registry.
+type Pushed struct {
+	// Ref is registry/repository:tag.
+	Ref string
+	// Digest is the manifest digest, to pin consumers with @digest.
+	Digest string
+}
+
+// Push uploads a chart archive to the OCI registry location target
+// (oci://registry/path) the way helm push does: the chart lands in
+// repository path/<name>, tagged with its version ('+' becomes '_',
+// which tags cannot carry). There is deliberately no registry SDK
+// behind it: a push is three blob and manifest requests.
+func Push(ctx context.Context, archive []byte, target string, opts PushOptions) (Pushed, error) {
+	meta, err := ReadMetadata(archive)
+	if err != nil {
+		return Pushed{}, err
+	}
+
+	host, repoPath, err := parseOCITarget(target)
+	if err != nil {
+		return Pushed{}, err
+	}
+
+	reg := newRegistryClient(host, opts)
+	repo := strings.TrimPrefix(repoPath+"/"+meta.Name, "/")
+	tag := strings.ReplaceAll(meta.Version, "+", "_")
+
+	config, err := json.Marshal(meta)
+	if err != nil {
+		return Pushed{}, errors.Wrap(err, "encoding the chart config")
+	}
+
+	configDesc := describe(ConfigMediaType, config)
+	contentDesc := describe(ContentMediaType, archive)
+
+	for _, blob := range []struct {
+		desc descriptor
+		data []byte
+	}{{configDesc, config}, {contentDesc, archive}} {
+		if err := reg.pushBlob(ctx, repo, blob.desc.Digest, blob.data); err != nil {
+			return Pushed{}, err
+		}
+	}
+
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2, //nolint:mnd // OCI image manifest schema version.
+		"mediaType":     manifestMediaType,
+		"config":        configDesc,
+		"layers":        []descriptor{contentDesc},
+		"annotations": map[string]string{
+			"org.opencontainers.image.title":       meta.Name,
+			"org.opencontainers.image.version":     meta.Version,
+			"org.opencontainers.image.description": meta.Description,
+		},
+	})
+	if err != nil {
+		return Pushed{}, errors.Wrap(err, "encoding the manifest")
+	}
+
+	if err := reg.pushManifest(ctx, repo, tag, manifest); err != nil {
+		return Pushed{}, err
+	}
+
+	return Pushed{Ref: host + "/" + repo + ":" + tag, Digest: digestOf(manifest)}, nil
+}
+
+// parseOCITarget splits oci://registry/path into the registry host
+// and the repository path below it.
+func parseOCITarget(target string) (string, string, error) {
+	rest, ok := strings.CutPrefix(target, ociScheme)
+	host, repoPath, _ := strings.Cut(rest, "/")
+
+	if !ok || host == "" || strings.ContainsAny(repoPath, ":@") {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", "", errors.WithHint(
+			errors.Newf("invalid registry location %q", target),
+			"pass oci://<registry>/<path> without a tag, e.g. oci://ghcr.io/example/charts; the chart name and version become the repository and tag",
+		)
+	}
+
+	return host, strings.Trim(repoPath, "/"), nil
+}
+
+// descriptor is an OCI content descriptor.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int    `json:"size"`
+}
+
+func describe(mediaType string, data []byte) descriptor {
+	return descriptor{MediaType: mediaType, Digest: digestOf(data), Size: len(data)}
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// registryClient speaks the OCI distribution API to one registry,
+// answering Basic and Bearer token challenges as they come.
+type registryClient struct {
+	base     *url.URL
+	host     string
+	username string
+	password string
+	token    string
+	client   *http.Client
+}
+
+func newRegistryClient(host string, opts PushOptions) *registryClient {
+	scheme := "https"
+	if opts.PlainHTTP {
+		scheme = "http"
+	}
+
+	client := opts.Client
+	if client == nil {
+		transport := httpclient.Transport()
+		if opts.InsecureSkipTLSVerify {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // opt-in via --insecure-skip-tls-verify.
+		}
+
+		client = &http.Client{Timeout: pushTimeout, Transport: transport}
+	}
+
+	username, password := opts.Username, opts.Password
+	if username == "" && password == "" {
+		username, password = dockerConfigCredentials(opts.DockerConfig, host)
+	}
+
+	return &registryClient{
+		base:     &url.URL{Scheme: scheme, Host: host},
+		host:     host,
+		username: username,
+		password: password,
+		client:   client,
+	}
+}
+
+// pushBlob uploads data unless the registry already has it.
+func (r *registryClient) pushBlob(ctx context.Context, repo, digest string, data []byte) error {
+	resp, err := r.do(ctx, repo, http.MethodHead, r.url("/v2/"+repo+"/blobs/"+digest), nil, "")
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = r.do(ctx, repo, http.MethodPost, r.url("/v2/"+repo+"/blobs/uploads/"), nil, "")
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return r.statusError(resp, "starting the upload of "+digest)
+	}
+
+	location, err := r.base.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return errors.Newf("registry %s answered the upload of %s without a usable Location", r.host, digest)
+	}
+
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = r.do(ctx, repo, http.MethodPut, location, data, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // status is all that matters
+
+	if resp.StatusCode != http.StatusCreated {
+		return r.statusError(resp, "uploading "+digest)
+	}
+
+	return nil
+}
+
+func (r *registryClient) pushManifest(ctx context.Context, repo, tag string, manifest []byte) error {
+	resp, err := r.do(ctx, repo, http.MethodPut, r.url("/v2/"+repo+"/manifests/"+tag), manifest, manifestMediaType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // status is all that matters
+
+	if resp.StatusCode != http.StatusCreated {
+		return r.statusError(resp, "writing the manifest "+repo+":"+tag)
+	}
+
+	return nil
+}
+
+func (r *registryClient) url(p string) *url.URL {
+	u := *r.base
+	u.Path = p
+
+	return &u
+}
+
+// do sends a request, and on a 401 answers the challenge once and
+// sends it again. body is replayed from memory for the retry.
+func (r *registryClient) do(ctx context.Context, repo, method string, target *url.URL, body []byte, contentType string) (*http.Response, error) {
+	resp, err := r.send(ctx, method, target, body, contentType)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = resp.Body.Close()
+
+	if err := r.authorize(ctx, challenge, repo); err != nil {
+		return nil, err
+	}
+
+	return r.send(ctx, method, target, body, contentType)
+}
+
+func (r *registryClient) send(ctx context.Context, method string, target *url.URL, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "building %s %s", method, target.Path)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.username != "" || r.password != "":
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrapf(err, "%s %s", method, target.Redacted()),
+			"check the registry address; use --plain-http for a registry without TLS",
+		)
+	}
+
+	return resp, nil
+}
+
+// authorize answers a WWW-Authenticate challenge: Basic needs only the
+// credentials, Bearer a token from the named realm.
+func (r *registryClient) authorize(ctx context.Context, challenge, repo string) error {
+	scheme, params := parseChallenge(challenge)
+
+	if !strings.EqualFold(scheme, "bearer") {
+		if r.username == "" && r.password == "" {
+			return r.credentialsError()
+		}
+
+		// Basic: send's SetBasicAuth already covers the retry, unless
+		// the credentials were rejected, which the retry reports.
+		return nil
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Newf("registry %s sent a Bearer challenge without a usable realm", r.host)
+	}
+
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	query.Set("scope", "repository:"+repo+":pull,push")
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "building the token request")
+	}
+
+	if r.username != "" || r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "requesting a registry token from %s", realm.Host)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+
+	if resp.StatusCode != http.StatusOK {
+		if r.username == "" && r.password == "" {
+			return r.credentialsError()
+		}
+
+		return r.statusError(resp, "requesting a registry token")
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"` //nolint:tagliatelle // token endpoint wire format.
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrap(err, "decoding the registry token")
+	}
+
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+
+	if r.token == "" {
+		return errors.Newf("registry %s issued an empty token", r.host)
+	}
+
+	return nil
+}
+
+func (r *registryClient) credentialsError() error {
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("registry %s requires authentication", r.host),
+		"pass --username and --password-stdin, or log in with `docker login %s` (credential helpers are not consulted; talm reads the auths entries of the docker config)", r.host,
+	)
+}
+
+func (r *registryClient) statusError(resp *http.Response, action string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+
+	err := errors.Newf("%s on %s: %s: %s", action, r.host, resp.Status, strings.TrimSpace(string(body)))
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(err, "check that the credentials may push to this repository")
+	}
+
+	return err
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into its
+// scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+
+	for rest != "" {
+		var pair string
+
+		rest = strings.TrimLeft(rest, " ,")
+
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+
+			pair, rest = value[1:end+1], value[end+2:]
+		} else {
+			pair, rest, _ = strings.Cut(value, ",")
+		}
+
+		params[strings.ToLower(strings.TrimSpace(key))] = pair
+	}
+
+	return scheme, params
+}
+
+// dockerConfigCredentials reads the `auths` entry for host from the
+// docker config, the file `docker login` and `helm registry login`
+// write. Credential helpers are not run.
+func dockerConfigCredentials(configPath, host string) (string, string) {
+	if configPath == "" {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", ""
+			}
+
+			dir = filepath.Join(home, ".docker")
+		}
+
+		configPath = filepath.Join(dir, "config.json")
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", ""
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+
+	for key, entry := range config.Auths {
+		if strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://"), "/") != host {
+			continue
+		}
+
+		if entry.Username != "" || entry.Password != "" {
+			return entry.Username, entry.Password
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", ""
+		}
+
+		username, password, _ := strings.Cut(string(decoded), ":")
+
+		return username, password
+	}
+
+	return "", ""
+}
+
+// String renders the pushed reference pinned by digest.
+func (p Pushed) String() string {
+	return fmt.Sprintf("%s@%s", p.Ref, p.Digest)
+}
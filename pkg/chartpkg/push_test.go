@@ -0,0 +1,232 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chartpkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is a minimal OCI distribution endpoint behind Bearer
+// token auth: enough of the protocol to receive a push.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+
+	reg := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.server.Close)
+
+	return reg
+}
+
+func (f *fakeRegistry) host() string {
+	u, _ := url.Parse(f.server.URL)
+
+	return u.Host
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.URL.Query().Get("scope") != "repository:team/charts/prod:pull,push" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"token":"t0k3n"}`))
+
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer t0k3n" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+f.server.URL+`/token",service="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	path := r.URL.Path
+
+	switch {
+	case r.Method == http.MethodHead && strings.Contains(path, "/blobs/"):
+		if _, ok := f.blobs[path[strings.LastIndex(path, "/")+1:]]; ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/blobs/uploads/"):
+		f.uploads++
+		w.Header().Set("Location", "/upload/1?_state=abc")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && path == "/upload/1":
+		if r.URL.Query().Get("_state") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		f.blobs[r.URL.Query().Get("digest")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.Contains(path, "/manifests/"):
+		f.manifests[path] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPush(t *testing.T) {
+	t.Parallel()
+
+	reg := newFakeRegistry(t)
+
+	archive, meta, err := Package(writeProject(t, nil), Options{Version: "1.2.0+g1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := PushOptions{PlainHTTP: true, Username: "ci", Password: "s3cret"}
+
+	pushed, err := Push(context.Background(), archive, "oci://"+reg.host()+"/team/charts", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pushed.Ref != reg.host()+"/team/charts/prod:1.2.0_g1" || !strings.HasPrefix(pushed.Digest, "sha256:") {
+		t.Errorf("pushed = %+v", pushed)
+	}
+
+	manifest := reg.manifests["/v2/team/charts/prod/manifests/1.2.0_g1"]
+
+	var parsed struct {
+		Config descriptor   `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}
+
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		t.Fatalf("manifest %q: %v", manifest, err)
+	}
+
+	if digestOf(manifest) != pushed.Digest {
+		t.Error("the reported digest must be the manifest digest")
+	}
+
+	if parsed.Config.MediaType != ConfigMediaType || len(parsed.Layers) != 1 || parsed.Layers[0].MediaType != ContentMediaType {
+		t.Errorf("manifest = %s", manifest)
+	}
+
+	if string(reg.blobs[parsed.Layers[0].Digest]) != string(archive) {
+		t.Error("the chart layer must be the archive")
+	}
+
+	var config Metadata
+	if err := json.Unmarshal(reg.blobs[parsed.Config.Digest], &config); err != nil || config != meta {
+		t.Errorf("config blob = %s, %v", reg.blobs[parsed.Config.Digest], err)
+	}
+
+	// A second push finds both blobs and uploads nothing.
+	if _, err := Push(context.Background(), archive, "oci://"+reg.host()+"/team/charts", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if reg.uploads != 2 {
+		t.Errorf("uploads = %d, want 2 (blobs already present are skipped)", reg.uploads)
+	}
+}
+
+func TestPush_Credentials(t *testing.T) {
+	t.Parallel()
+
+	reg := newFakeRegistry(t)
+
+	archive, _, err := Package(writeProject(t, nil), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "oci://" + reg.host() + "/team/charts"
+
+	_, err = Push(context.Background(), archive, target, PushOptions{PlainHTTP: true, DockerConfig: filepath.Join(t.TempDir(), "none.json")})
+	if err == nil || !strings.Contains(err.Error(), "requires authentication") {
+		t.Errorf("anonymous push: error = %v", err)
+	}
+
+	dockerConfig := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("ci:s3cret"))
+
+	if err := os.WriteFile(dockerConfig, []byte(`{"auths":{"http://`+reg.host()+`":{"auth":"`+auth+`"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Push(context.Background(), archive, target, PushOptions{PlainHTTP: true, DockerConfig: dockerConfig}); err != nil {
+		t.Errorf("push with docker config credentials: %v", err)
+	}
+}
+
+func TestParseOCITarget(t *testing.T) {
+	t.Parallel()
+
+	host, repo, err := parseOCITarget("oci://ghcr.io/example/charts/")
+	if err != nil || host != "ghcr.io" || repo != "example/charts" {
+		t.Errorf("got %q %q %v", host, repo, err)
+	}
+
+	for _, bad := range []string{"ghcr.io/example", "oci://", "oci://ghcr.io/example/prod:1.0.0"} {
+		if _, _, err := parseOCITarget(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	t.Parallel()
+
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example/token",service="registry.example",scope="repository:a:pull"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example/token" || params["service"] != "registry.example" || params["scope"] != "repository:a:pull" {
+		t.Errorf("got %q %v", scheme, params)
+	}
+
+	if scheme, params := parseChallenge(`Basic realm=registry`); scheme != "Basic" || params["realm"] != "registry" {
+		t.Errorf("got %q %v", scheme, params)
+	}
+}
@@ -1,10 +1,11 @@
-package main
+package cli
 
 import (
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cozystack/talm/pkg/commands"
@@ -184,6 +185,51 @@ func TestLoadConfig_EmptyApplyTimeoutResolvesDefault(t *testing.T) {
 	}
 }
 
+// TestLoadConfig_PhaseTimeoutsParse pins the applyOptions.phaseTimeouts
+// channel: set entries parse into their durations, unset entries stay
+// zero (bounded by the per-node deadline only).
+func TestLoadConfig_PhaseTimeoutsParse(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := filepath.Join(dir, "Chart.yaml")
+	body := "apiVersion: v2\nname: test\nversion: 0.1.0\napplyOptions:\n  phaseTimeouts:\n    apply: \"20s\"\n    verify: \"1m\"\n"
+	if err := os.WriteFile(chartPath, []byte(body), 0o644); err != nil {
+		t.Fatalf("write Chart.yaml: %v", err)
+	}
+
+	snapshotConfigState(t)
+
+	if err := loadConfig(chartPath); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	phases := commands.Config.ApplyOptions.PhaseTimeouts
+	if phases.ApplyDuration.String() != "20s" || phases.VerifyDuration.String() != "1m0s" {
+		t.Errorf("apply/verify = %v/%v, want 20s/1m0s", phases.ApplyDuration, phases.VerifyDuration)
+	}
+	if phases.RenderDuration != 0 || phases.PreflightDuration != 0 {
+		t.Errorf("unset phases must stay zero, got render=%v preflight=%v", phases.RenderDuration, phases.PreflightDuration)
+	}
+}
+
+// TestLoadConfig_InvalidPhaseTimeoutNamesPhase pins that a malformed
+// phase timeout names the exact key, so the operator does not have to
+// guess which of the four entries is wrong.
+func TestLoadConfig_InvalidPhaseTimeoutNamesPhase(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := filepath.Join(dir, "Chart.yaml")
+	body := "apiVersion: v2\nname: test\nversion: 0.1.0\napplyOptions:\n  phaseTimeouts:\n    preflight: \"soon\"\n"
+	if err := os.WriteFile(chartPath, []byte(body), 0o644); err != nil {
+		t.Fatalf("write Chart.yaml: %v", err)
+	}
+
+	snapshotConfigState(t)
+
+	err := loadConfig(chartPath)
+	if err == nil || !strings.Contains(err.Error(), "applyOptions.phaseTimeouts.preflight") {
+		t.Fatalf("error must name applyOptions.phaseTimeouts.preflight, got %v", err)
+	}
+}
+
 // TestLoadConfig_StrictChartsParses pins the Chart.yaml → Config channel
 // of strict enforcement: `strictCharts: true` must land in
 // commands.Config.StrictCharts. This is the team/CI-wide form the README
@@ -319,6 +365,26 @@ func TestRegisterRootFlags_StrictChartsRendersWithoutValuePlaceholder(t *testing
 	}
 }
 
+// TestRegisterRootFlags_OutputHasNoShorthand pins that the global
+// --output leaves -o to the commands that register their own --output
+// (certs, healthcheck, talosctl get): a persistent -o would collide
+// with them.
+func TestRegisterRootFlags_OutputHasNoShorthand(t *testing.T) {
+	snapshotConfigState(t)
+
+	cmd := &cobra.Command{Use: "talm-test"}
+	registerRootFlags(cmd)
+
+	flag := cmd.PersistentFlags().Lookup("output")
+	if flag == nil {
+		t.Fatal("expected --output to be registered, got nil")
+	}
+
+	if flag.Shorthand != "" || flag.DefValue != "text" {
+		t.Errorf("--output: shorthand %q, default %q; want no shorthand and text", flag.Shorthand, flag.DefValue)
+	}
+}
+
 // writeDriftedTalmProject creates a project whose vendored charts/talm/
 // cannot match the binary's embedded library (the helpers template carries
 // a local edit), so CheckChartDrift reports drift.
@@ -675,6 +741,50 @@ func TestSkipConfigCommands(t *testing.T) {
 			cmdPath:  []string{"talm", "dmesg"},
 			expected: true,
 		},
+		{
+			// selftest builds its own throwaway project and must
+			// run from any directory.
+			name:     "selftest",
+			cmdPath:  []string{"talm", "selftest"},
+			expected: true,
+		},
+		{
+			// push uploads an archive and runs from any directory.
+			name:     "push",
+			cmdPath:  []string{"talm", "push"},
+			expected: true,
+		},
+		{
+			// git runs the filter mid-checkout, possibly before
+			// Chart.yaml is on disk.
+			name:     "git-filter smudge",
+			cmdPath:  []string{"talm", "git-filter", "smudge"},
+			expected: true,
+		},
+		{
+			// archetype apply creates the Chart.yaml it would load.
+			name:     "archetype apply",
+			cmdPath:  []string{"talm", "archetype", "apply"},
+			expected: true,
+		},
+		{
+			// docs functions documents the binary, not a project.
+			name:     "docs functions",
+			cmdPath:  []string{"talm", "docs", "functions"},
+			expected: true,
+		},
+		{
+			// scan runs before the project exists.
+			name:     "scan",
+			cmdPath:  []string{"talm", "scan"},
+			expected: true,
+		},
+		{
+			// docs lookups reads the node from the project config.
+			name:     "docs lookups should load config",
+			cmdPath:  []string{"talm", "docs", "lookups"},
+			expected: false,
+		},
 		{
 			name:     "apply command should load config",
 			cmdPath:  []string{"talm", "apply"},
@@ -690,7 +800,7 @@ func TestSkipConfigCommands(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			leaf := buildCommandHierarchy(tt.cmdPath)
-			// This uses the actual skipConfigCommands from main.go
+			// This uses the actual skipConfigCommands from cli.go
 			result := isCommandOrParent(leaf, skipConfigCommands...)
 			if result != tt.expected {
 				t.Errorf("skipConfigCommands check = %v, want %v (skipConfigCommands = %v)",
@@ -702,8 +812,8 @@ func TestSkipConfigCommands(t *testing.T) {
 
 // TestReleaseVersion pins that both release build paths are recognized.
 // goreleaser injects the version WITHOUT the "v" prefix (`-X
-// main.Version={{.Version}}` → "0.30.0") while the Makefile's `git describe
-// --tags` keeps it ("v0.30.0"). A previous gate accepted only the
+// github.com/cozystack/talm/pkg/cli.Version={{.Version}}` → "0.30.0")
+// while the Makefile's `git describe --tags` keeps it ("v0.30.0"). A previous gate accepted only the
 // "v"-prefixed form, which silently disabled the chart-drift check and the
 // init version stamp on every downloaded release. Both forms must parse to
 // the same version and report isRelease=true; dev/empty builds must not.
@@ -748,3 +858,46 @@ func TestReleaseVersion(t *testing.T) {
 		})
 	}
 }
+
+// TestLoadConfig_RebootTimeout pins applyOptions.rebootTimeout: a set
+// value parses, an empty one does not wait, and a malformed one names
+// the key.
+func TestLoadConfig_RebootTimeout(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := filepath.Join(dir, "Chart.yaml")
+
+	snapshotConfigState(t)
+
+	for _, tc := range []struct {
+		body    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{body: "applyOptions:\n  rebootTimeout: \"10m\"\n", want: 10 * time.Minute},
+		{body: "applyOptions: {}\n"},
+		{body: "applyOptions:\n  rebootTimeout: \"soon\"\n", wantErr: true},
+	} {
+		commands.Config.ApplyOptions.RebootTimeout = ""
+
+		if err := os.WriteFile(chartPath, []byte("apiVersion: v2\nname: test\nversion: 0.1.0\n"+tc.body), 0o644); err != nil {
+			t.Fatalf("write Chart.yaml: %v", err)
+		}
+
+		err := loadConfig(chartPath)
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "applyOptions.rebootTimeout") {
+				t.Errorf("%q: error = %v, want it to name applyOptions.rebootTimeout", tc.body, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q: loadConfig: %v", tc.body, err)
+		}
+
+		if got := commands.Config.ApplyOptions.RebootTimeoutDuration; got != tc.want {
+			t.Errorf("%q: RebootTimeoutDuration = %v, want %v", tc.body, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+
+	"github.com/siderolabs/talos/cmd/talosctl/cmd/common"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/cozystack/talm/pkg/commands"
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+// baselineConfig, baselineArgs and baselineFlags hold commands.Config,
+// commands.GlobalArgs and every flag value as init leaves them, for
+// Reset to restore.
+//
+//nolint:gochecknoglobals // snapshot of the commands package state, taken once at init.
+var (
+	baselineConfig = deepCopy(commands.Config)
+	baselineArgs   = deepCopy(commands.GlobalArgs)
+	baselineFlags  = flagBaseline{}
+)
+
+// saveBaseline records the state Reset restores. init calls it once
+// the root flags are registered, since registering a flag writes its
+// default to the variable it binds.
+func saveBaseline() {
+	baselineConfig = deepCopy(commands.Config)
+	baselineArgs = deepCopy(commands.GlobalArgs)
+
+	visitFlags(rootCmd, baselineFlags.save)
+}
+
+// Reset returns talm to the state init left it in: every flag of every
+// command back to its default and not set, commands.Config and
+// commands.GlobalArgs as they were, the per-invocation caches of the
+// commands and the engine dropped, and the root command reading and
+// writing the process streams again. talm's commands keep their flags
+// and the loaded Chart.yaml in package state, so a process executing
+// more than one invocation resets in between.
+func Reset() {
+	visitFlags(rootCmd, baselineFlags.reset)
+
+	commands.Config = deepCopy(baselineConfig)
+	commands.GlobalArgs = deepCopy(baselineArgs)
+	commands.ResetSession()
+	engine.ForgetSecretStores()
+	common.SuppressErrors = false
+	invocationArgs = nil
+
+	rootCmd.SetArgs(nil)
+	rootCmd.SetIn(nil)
+	rootCmd.SetOut(nil)
+	rootCmd.SetErr(nil)
+}
+
+// visitFlags calls fn for every flag of cmd and of every command below it.
+func visitFlags(cmd *cobra.Command, fn func(*pflag.Flag)) {
+	cmd.PersistentFlags().VisitAll(fn)
+	cmd.Flags().VisitAll(fn)
+
+	for _, sub := range cmd.Commands() {
+		visitFlags(sub, fn)
+	}
+}
+
+// flagBaseline is the value of a set of flags, by flag, in the form
+// their Set or Replace takes back.
+type flagBaseline map[*pflag.Flag]flagValue
+
+// flagValue is the saved value of one flag.
+type flagValue struct {
+	value string
+	slice []string
+}
+
+// save records the value of flag. A slice flag gets wrapped in
+// freshSlice, so that once reset it takes the next value it is set to
+// instead of adding to its default.
+func (b flagBaseline) save(flag *pflag.Flag) {
+	slice, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		b[flag] = flagValue{value: flag.Value.String()}
+
+		return
+	}
+
+	if _, wrapped := flag.Value.(*freshSlice); !wrapped {
+		flag.Value = &freshSlice{Value: flag.Value, SliceValue: slice}
+	}
+
+	b[flag] = flagValue{slice: append([]string{}, slice.GetSlice()...)}
+}
+
+// reset puts flag back to the value save recorded and marks it not
+// set. A flag registered after the baseline was taken falls back to its
+// rendered default. Map flags are not supported: pflag merges into
+// them once set, and talm registers none.
+func (b flagBaseline) reset(flag *pflag.Flag) {
+	saved, ok := b[flag]
+	if !ok {
+		saved = flagValue{value: flag.DefValue, slice: defaultSlice(flag.DefValue)}
+	}
+
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		_ = slice.Replace(append([]string{}, saved.slice...))
+	} else {
+		_ = flag.Value.Set(saved.value)
+	}
+
+	if fresh, ok := flag.Value.(*freshSlice); ok {
+		fresh.set = false
+	}
+
+	flag.Changed = false
+}
+
+// freshSlice is a slice flag value that takes the first value it is
+// set to after a reset in place of what it holds. pflag's slice values
+// remember being set and from then on append to what they hold, which
+// would add the next invocation's values to the default.
+type freshSlice struct {
+	pflag.Value
+	pflag.SliceValue
+
+	set bool
+}
+
+// Set implements pflag.Value.
+func (f *freshSlice) Set(value string) error {
+	if !f.set {
+		f.set = true
+
+		if err := f.Replace([]string{}); err != nil {
+			return err //nolint:wrapcheck // pflag's own parse error, as Set would return it.
+		}
+	}
+
+	return f.Value.Set(value) //nolint:wrapcheck // pflag's own parse error.
+}
+
+// deepCopy returns a copy of v that shares no slice, map or pointer
+// with it, so a later change to one does not reach the other. Only
+// exported fields are followed; unexported ones are copied as they are.
+func deepCopy[T any](v T) T {
+	out, _ := copyValue(reflect.ValueOf(&v).Elem()).Interface().(T)
+
+	return out
+}
+
+// copyValue returns a deep copy of v; see deepCopy.
+func copyValue(v reflect.Value) reflect.Value {
+	out := reflect.New(v.Type()).Elem()
+	out.Set(v)
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			elem := reflect.New(v.Type().Elem())
+			elem.Elem().Set(copyValue(v.Elem()))
+			out.Set(elem)
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			out.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+
+			for i := range v.Len() {
+				out.Index(i).Set(copyValue(v.Index(i)))
+			}
+		}
+	case reflect.Map:
+		if !v.IsNil() {
+			out.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+
+			for iter := v.MapRange(); iter.Next(); {
+				out.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+			}
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				out.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+	default:
+	}
+
+	return out
+}
+
+// defaultSlice parses the default of a slice flag from the "[a,b]"
+// form pflag renders it in, quoted elements included.
+func defaultSlice(def string) []string {
+	def = strings.TrimSuffix(strings.TrimPrefix(def, "["), "]")
+	if def == "" {
+		return []string{}
+	}
+
+	items, err := csv.NewReader(strings.NewReader(def)).Read()
+	if err != nil {
+		return strings.Split(def, ",")
+	}
+
+	return items
+}
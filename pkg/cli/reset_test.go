@@ -0,0 +1,196 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/cozystack/talm/pkg/commands"
+)
+
+func TestResetFlag(t *testing.T) {
+	t.Parallel()
+
+	var (
+		nodes    []string
+		files    []string
+		patches  []string
+		root     string
+		yes      bool
+		deadline time.Duration
+	)
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSliceVar(&nodes, "nodes", []string{}, "")
+	flags.StringArrayVar(&files, "file", []string{"a.yaml", "b.yaml"}, "")
+	flags.StringSliceVar(&patches, "patch", []string{"x,y", "z"}, "")
+	flags.StringVar(&root, "root", ".", "")
+	flags.BoolVar(&yes, "yes", false, "")
+	flags.DurationVar(&deadline, "timeout", time.Minute, "")
+
+	baseline := flagBaseline{}
+	flags.VisitAll(baseline.save)
+
+	if err := flags.Parse([]string{
+		"--nodes", "192.0.2.10,192.0.2.11", "--file", "c.yaml", "--file", "d.yaml", "--patch", "p",
+		"--root", "/project", "--yes", "--timeout", "5s",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(nodes, []string{"192.0.2.10", "192.0.2.11"}) || !reflect.DeepEqual(files, []string{"c.yaml", "d.yaml"}) {
+		t.Errorf("first parse: nodes %q, files %q", nodes, files)
+	}
+
+	flags.VisitAll(baseline.reset)
+
+	if !reflect.DeepEqual(nodes, []string{}) || !reflect.DeepEqual(files, []string{"a.yaml", "b.yaml"}) || root != "." || yes || deadline != time.Minute {
+		t.Errorf("after reset: nodes %q, files %q, root %q, yes %v, timeout %s", nodes, files, root, yes, deadline)
+	}
+
+	if !reflect.DeepEqual(patches, []string{"x,y", "z"}) {
+		t.Errorf("after reset: patches %q", patches)
+	}
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			t.Errorf("--%s must not be marked changed", flag.Name)
+		}
+	})
+
+	// Set again, slice flags take the new value instead of adding to
+	// their default.
+	if err := flags.Parse([]string{"--nodes", "192.0.2.12", "--file", "e.yaml", "--file", "f.yaml", "--patch", "q"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(nodes, []string{"192.0.2.12"}) || !reflect.DeepEqual(files, []string{"e.yaml", "f.yaml"}) || !reflect.DeepEqual(patches, []string{"q"}) {
+		t.Errorf("nodes = %q, files = %q, patches = %q", nodes, files, patches)
+	}
+
+	// The baseline is not shared with the values it was taken from.
+	flags.VisitAll(baseline.reset)
+
+	if !reflect.DeepEqual(files, []string{"a.yaml", "b.yaml"}) || !reflect.DeepEqual(patches, []string{"x,y", "z"}) {
+		t.Errorf("files = %q, patches = %q after a second reset", files, patches)
+	}
+
+	if got := flags.Lookup("file").Value.Type(); got != "stringArray" {
+		t.Errorf("a reset slice flag must keep its type, got %q", got)
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	t.Parallel()
+
+	type inner struct {
+		Items []string
+	}
+
+	type config struct {
+		Name  string
+		List  []string
+		Map   map[string][]string
+		Ptr   *inner
+		Inner inner
+	}
+
+	orig := config{
+		Name:  "a",
+		List:  []string{"x"},
+		Map:   map[string][]string{"k": {"v"}},
+		Ptr:   &inner{Items: []string{"p"}},
+		Inner: inner{Items: []string{"i"}},
+	}
+
+	copied := deepCopy(orig)
+	if !reflect.DeepEqual(copied, orig) {
+		t.Fatalf("copy = %+v, want %+v", copied, orig)
+	}
+
+	copied.List[0] = "changed"
+	copied.Map["k"][0] = "changed"
+	copied.Ptr.Items[0] = "changed"
+	copied.Inner.Items[0] = "changed"
+
+	if orig.List[0] != "x" || orig.Map["k"][0] != "v" || orig.Ptr.Items[0] != "p" || orig.Inner.Items[0] != "i" {
+		t.Errorf("the copy shares memory with the original: %+v", orig)
+	}
+}
+
+func TestDefaultSlice(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		def  string
+		want []string
+	}{
+		{"[]", []string{}},
+		{"[a,b]", []string{"a", "b"}},
+		{`["x,y",z]`, []string{"x,y", "z"}},
+	} {
+		if got := defaultSlice(tc.def); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("defaultSlice(%s) = %q, want %q", tc.def, got, tc.want)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	snapshotConfigState(t)
+
+	if err := rootCmd.PersistentFlags().Set("nodes", "192.0.2.10"); err != nil {
+		t.Fatal(err)
+	}
+
+	commands.Config.RootDir = "/elsewhere"
+	commands.Config.TemplateOptions.Offline = true
+	commands.Config.TemplateOptions.ValueFiles = append(commands.Config.TemplateOptions.ValueFiles, "values-prod.yaml")
+
+	Reset()
+
+	if len(commands.Config.TemplateOptions.ValueFiles) != 0 {
+		t.Errorf("Config.TemplateOptions.ValueFiles = %q after Reset", commands.Config.TemplateOptions.ValueFiles)
+	}
+
+	if len(commands.GlobalArgs.Nodes) != 0 || rootCmd.PersistentFlags().Changed("nodes") {
+		t.Errorf("--nodes = %q after Reset", commands.GlobalArgs.Nodes)
+	}
+
+	if commands.Config.RootDir != "." || commands.Config.TemplateOptions.Offline {
+		t.Errorf("Config.RootDir = %q, Offline = %v after Reset", commands.Config.RootDir, commands.Config.TemplateOptions.Offline)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("boom"), 1},
+		{errors.Wrap(commands.ErrApplyTimeout, "node 192.0.2.10"), commands.ExitCodeTimeout},
+	} {
+		if got := ExitCode(tc.err); got != tc.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
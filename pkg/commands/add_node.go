@@ -0,0 +1,365 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	addNodeCmdName = "add-node"
+
+	// defaultAddNodeTimeout bounds the wait for the node to answer in
+	// maintenance mode. add-node runs from scripts over nodes that are
+	// already up, so it gives up sooner than attach.
+	defaultAddNodeTimeout = 5 * time.Minute
+)
+
+// nodeFileNumberRegex matches the node files add-node names,
+// nodes/node<N>.yaml, and their JSON form.
+var nodeFileNumberRegex = regexp.MustCompile(`^node(\d+)\.(?:ya?ml|json)$`)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var addNodeCmdFlags struct {
+	ip               string
+	role             string
+	disk             string
+	iface            string
+	configFile       string
+	templates        []string
+	apply            bool
+	timeout          time.Duration
+	certFingerprints []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var addNodeCmd = &cobra.Command{
+	Use:   addNodeCmdName,
+	Short: "Write the node file of a node in maintenance mode without questions, and optionally apply it",
+	Long: `Onboard a node in maintenance mode from a script: talm attach without the
+questions.
+
+add-node reads the disks and physical interfaces of the node at --ip over the
+maintenance connection, waiting up to --timeout for it to answer, then:
+
+1. Picks the install disk: --disk, or the disk the chart would pick, the
+   first that reports a WWID or a model.
+2. Picks the interface the node is managed on: --interface, by name or MAC,
+   or the one holding --ip.
+3. Writes the node file: -f, or the next free nodes/node<N>.yaml. Its
+   modeline names --ip, the templates and --role, and its body sets the disk
+   as machine.install.disk. The templates are -t, or the ones Chart.yaml
+   templateOptions.roles lists for the role, or templates/<role>.yaml.
+4. Records the interface, with its MAC and addresses, under
+   nodes.<ip>.interfaces in values.yaml, as talm attach does.
+5. With --apply, renders and applies the node file over the maintenance
+   connection, as talm apply --insecure -f would.
+
+A node that already has a node file under nodes/ is refused, so a provisioning
+script can run add-node again over the same addresses.`,
+	Example: `  # Write nodes/node<N>.yaml for a worker, with the suggested disk and interface
+  talm add-node --ip 10.0.0.15 --role worker
+
+  # Pin the disk and interface, and apply right away
+  talm add-node --ip 10.0.0.15 --role worker --disk /dev/sda --interface eth0 --apply
+
+  # Onboard a rack of workers
+  for ip in 10.0.0.15 10.0.0.16 10.0.0.17; do talm add-node --ip "$ip" --role worker --apply; done`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runAddNode(cmd.Context())
+	},
+}
+
+// runAddNode onboards the node of addNodeCmdFlags.
+func runAddNode(ctx context.Context) error {
+	flags := &addNodeCmdFlags
+
+	if err := checkAddNodeInputs(flags.ip, flags.role, flags.configFile, flags.timeout); err != nil {
+		return err
+	}
+
+	configFile := flags.configFile
+	if configFile != "" {
+		if err := DetectAndSetRootFromFiles([]string{configFile}); err != nil {
+			return err
+		}
+	}
+
+	if err := checkNodeNotAdded(Config.RootDir, flags.ip); err != nil {
+		return err
+	}
+
+	if configFile == "" {
+		name, err := nextNodeFileName(Config.RootDir)
+		if err != nil {
+			return err
+		}
+
+		configFile = filepath.Join(Config.RootDir, name)
+	}
+
+	templates, err := addNodeTemplates(Config.TemplateOptions.Roles, Config.RootDir, flags.role, flags.templates)
+	if err != nil {
+		return err
+	}
+
+	GlobalArgs.Nodes = []string{flags.ip}
+	GlobalArgs.Endpoints = []string{flags.ip}
+
+	facts, err := waitForMaintenanceNode(ctx, maintenanceNodeProbe(flags.certFingerprints), os.Stderr, flags.ip, flags.timeout, attachPollInterval)
+	if err != nil {
+		return err
+	}
+
+	disk, err := pickInstallDisk(facts.Disks, flags.disk)
+	if err != nil {
+		return err
+	}
+
+	link, err := pickAttachLink(facts.Links, flags.iface, flags.ip)
+	if err != nil {
+		return err
+	}
+
+	nodeFile, err := attachNodeFile(flags.ip, templates, flags.role, disk.DevPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configFile), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating %s", filepath.Dir(configFile))
+	}
+
+	if err := secureperm.WriteFile(configFile, nodeFile); err != nil {
+		return errors.Wrapf(err, "writing %s", configFile)
+	}
+
+	ui.Successf(os.Stderr, "Wrote %s: %s on %s, interface %s", configFile, flags.role, describeDisk(disk), describeLink(link))
+
+	if err := recordAttachedLink(flags.ip, link); err != nil {
+		return err
+	}
+
+	if !flags.apply {
+		ui.Infof(os.Stderr, "Apply it with talm apply --insecure -f %s", configFile)
+
+		return nil
+	}
+
+	return applyAttachedNodeFile(configFile, flags.certFingerprints)
+}
+
+// checkAddNodeInputs rejects the invocations that would fail only after
+// the wait: a missing or malformed address, an unknown role, an
+// existing node file and a wait that cannot last.
+func checkAddNodeInputs(ip, role, configFile string, timeout time.Duration) error {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--ip must be the address of the node; got %q", ip),
+			"pass the address the node has in maintenance mode, e.g. --ip 10.0.0.15",
+		)
+	}
+
+	if !slices.Contains(engine.RenderRoles(), role) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("--role must be the machine type of the node; got %q", role),
+			"pass one of %s", strings.Join(engine.RenderRoles(), ", "),
+		)
+	}
+
+	if configFile != "" && fileExists(configFile) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("node file %s already exists", configFile),
+			"apply an existing node file to a node in maintenance mode with `talm apply --insecure -f %s`, or pass -f with a new file", configFile,
+		)
+	}
+
+	if timeout <= 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("--timeout must be a positive duration; got %s", timeout),
+			"pass a positive duration like 5m, the default is %s", defaultAddNodeTimeout,
+		)
+	}
+
+	return nil
+}
+
+// checkNodeNotAdded refuses an address a node file under nodes/
+// already targets.
+func checkNodeNotAdded(rootDir, ip string) error {
+	files, _, err := scanPruneNodeFiles(rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if slices.Contains(file.nodes, ip) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("%s already has the node file %s", ip, file.path),
+				"apply it with `talm apply --insecure -f %s`, or remove it to start over", file.path,
+			)
+		}
+	}
+
+	return nil
+}
+
+// nextNodeFileName returns nodes/node<N>.yaml, N one more than the
+// highest number a node file so named under rootDir has.
+func nextNodeFileName(rootDir string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(rootDir, nodesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "reading %s", filepath.Join(rootDir, nodesDirName))
+	}
+
+	highest := 0
+
+	for _, entry := range entries {
+		match := nodeFileNumberRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		if n, err := strconv.Atoi(match[1]); err == nil {
+			highest = max(highest, n)
+		}
+	}
+
+	return filepath.Join(nodesDirName, "node"+strconv.Itoa(highest+1)+".yaml"), nil
+}
+
+// addNodeTemplates returns the templates the node file renders:
+// templates when given, else the ones the roles matrix lists for
+// role, else templates/<role>.yaml of the chart.
+func addNodeTemplates(roles map[string][]string, rootDir, role string, templates []string) ([]string, error) {
+	if len(templates) > 0 {
+		return templates, nil
+	}
+
+	if len(roles) > 0 {
+		return engine.RoleTemplates(roles, role) //nolint:wrapcheck // engine.RoleTemplates attaches its own hint.
+	}
+
+	fallback := path.Join("templates", role+".yaml")
+	if fileExists(filepath.Join(rootDir, filepath.FromSlash(fallback))) {
+		return []string{fallback}, nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return nil, errors.WithHintf(
+		errors.Newf("the chart has no %s and declares no templateOptions.roles", fallback),
+		"pass the templates of a %s with -t, or list them under templateOptions.roles.%s in Chart.yaml", role, role,
+	)
+}
+
+// pickInstallDisk returns the install disk named by want, a device
+// path with or without /dev/, or suggestedInstallDisk when want is
+// empty.
+func pickInstallDisk(disks []applycheck.DiskInfo, want string) (applycheck.DiskInfo, error) {
+	candidates, err := installDiskCandidates(disks)
+	if err != nil {
+		return applycheck.DiskInfo{}, err
+	}
+
+	if want == "" {
+		return candidates[suggestedInstallDisk(candidates)], nil
+	}
+
+	paths := make([]string, len(candidates))
+
+	for i, disk := range candidates {
+		if disk.DevPath == want || disk.DevPath == "/dev/"+want {
+			return disk, nil
+		}
+
+		paths[i] = disk.DevPath
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return applycheck.DiskInfo{}, errors.WithHintf(
+		errors.Newf("the node has no disk %s to install Talos to", want),
+		"pass one of %s; CD-ROMs and read-only devices are not offered", strings.Join(paths, ", "),
+	)
+}
+
+// pickAttachLink returns the physical interface named by want, a link
+// name or MAC, or suggestedAttachLink for address when want is empty.
+func pickAttachLink(links []attachLink, want, address string) (attachLink, error) {
+	if err := checkAttachLinks(links); err != nil {
+		return attachLink{}, err
+	}
+
+	if want == "" {
+		return links[suggestedAttachLink(links, address)], nil
+	}
+
+	names := make([]string, len(links))
+
+	for i, link := range links {
+		if link.Name == want || (link.MAC != "" && strings.EqualFold(link.MAC, want)) {
+			return link, nil
+		}
+
+		names[i] = link.Name
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return attachLink{}, errors.WithHintf(
+		errors.Newf("the node has no physical interface %s", want),
+		"pass one of %s, by name or MAC", strings.Join(names, ", "),
+	)
+}
+
+func init() {
+	addNodeCmd.Flags().StringVar(&addNodeCmdFlags.ip, "ip", "", "address of the node in maintenance mode")
+	addNodeCmd.Flags().StringVar(&addNodeCmdFlags.role, "role", "", "machine type of the node: controlplane or worker")
+	addNodeCmd.Flags().StringVar(&addNodeCmdFlags.disk, "disk", "", "disk to install Talos to, e.g. /dev/sda (default: the first disk that reports a WWID or a model)")
+	addNodeCmd.Flags().StringVar(&addNodeCmdFlags.iface, "interface", "", "interface the node is managed on, by name or MAC (default: the one holding --ip)")
+	addNodeCmd.Flags().StringVarP(&addNodeCmdFlags.configFile, "file", "f", "", "node file to write; must not exist yet (default: the next free nodes/node<N>.yaml)")
+	addNodeCmd.Flags().StringSliceVarP(&addNodeCmdFlags.templates, "template", "t", nil, "templates the node file renders (default: the templates Chart.yaml templateOptions.roles lists for --role, or templates/<role>.yaml)")
+	addNodeCmd.Flags().BoolVar(&addNodeCmdFlags.apply, "apply", false, "apply the node file over the maintenance connection once written")
+	addNodeCmd.Flags().DurationVar(&addNodeCmdFlags.timeout, "timeout", defaultAddNodeTimeout, "how long to wait for the node to answer in maintenance mode")
+	addNodeCmd.Flags().StringSliceVar(&addNodeCmdFlags.certFingerprints, "cert-fingerprint", nil, "SPKI fingerprint of the maintenance certificate the node prints on its console (can specify multiple)")
+
+	_ = addNodeCmd.MarkFlagRequired("ip")
+	_ = addNodeCmd.MarkFlagRequired("role")
+	_ = addNodeCmd.RegisterFlagCompletionFunc("role", completeTemplateRole)
+	_ = addNodeCmd.RegisterFlagCompletionFunc("template", completeYAMLFiles)
+
+	addCommand(addNodeCmd)
+}
@@ -0,0 +1,238 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+)
+
+// TestCheckAddNodeInputs pins the invocations rejected before the wait.
+func TestCheckAddNodeInputs(t *testing.T) {
+	t.Parallel()
+
+	existing := filepath.Join(t.TempDir(), "node1.yaml")
+	if err := os.WriteFile(existing, []byte("machine: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		ip      string
+		role    string
+		file    string
+		timeout time.Duration
+		want    string
+	}{
+		{"ok", "10.0.0.15", "worker", "", time.Minute, ""},
+		{"ok with file", "10.0.0.15", "controlplane", filepath.Join(t.TempDir(), "cp.yaml"), time.Minute, ""},
+		{"bad address", "10.0.0.300", "worker", "", time.Minute, "--ip must be"},
+		{"unknown role", "10.0.0.15", "master", "", time.Minute, "--role must be"},
+		{"existing file", "10.0.0.15", "worker", existing, time.Minute, "already exists"},
+		{"zero timeout", "10.0.0.15", "worker", "", 0, "--timeout"},
+	}
+
+	for _, tc := range cases {
+		err := checkAddNodeInputs(tc.ip, tc.role, tc.file, tc.timeout)
+
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: err = %v, want it to mention %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestNextNodeFileName pins the numbering of the node files add-node
+// writes: one past the highest nodeN file, whatever else nodes/ holds.
+func TestNextNodeFileName(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	name, err := nextNodeFileName(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != filepath.Join("nodes", "node1.yaml") {
+		t.Errorf("empty project: %s", name)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "nodes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"node1.yaml", "node7.json", "node3.yml", "cp1.yaml", "node10.yaml.bak"} {
+		if err := os.WriteFile(filepath.Join(root, "nodes", file), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name, err = nextNodeFileName(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != filepath.Join("nodes", "node8.yaml") {
+		t.Errorf("next = %s, want nodes/node8.yaml", name)
+	}
+}
+
+// TestCheckNodeNotAdded pins that an address a node file already
+// targets is refused.
+func TestCheckNodeNotAdded(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "nodes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeFile := "# talm: nodes=[\"10.0.0.15\"], endpoints=[\"10.0.0.15\"], templates=[\"templates/worker.yaml\"]\n"
+	if err := os.WriteFile(filepath.Join(root, "nodes", "node1.yaml"), []byte(nodeFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkNodeNotAdded(root, "10.0.0.16"); err != nil {
+		t.Errorf("new address: %v", err)
+	}
+
+	err := checkNodeNotAdded(root, "10.0.0.15")
+	if err == nil || !strings.Contains(err.Error(), "already has the node file") {
+		t.Errorf("err = %v, want the existing node file", err)
+	}
+}
+
+// TestAddNodeTemplates pins where the templates of the node file come
+// from: -t, then the roles matrix, then templates/<role>.yaml.
+func TestAddNodeTemplates(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "templates", "worker.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	roles := map[string][]string{"worker": {"templates/node.yaml"}}
+
+	for _, tc := range []struct {
+		name      string
+		roles     map[string][]string
+		role      string
+		templates []string
+		want      string
+		wantErr   string
+	}{
+		{name: "flag", roles: roles, role: "worker", templates: []string{"templates/custom.yaml"}, want: "templates/custom.yaml"},
+		{name: "matrix", roles: roles, role: "worker", want: "templates/node.yaml"},
+		{name: "matrix without the role", roles: roles, role: "controlplane", wantErr: "declares no templates for role controlplane"},
+		{name: "chart file", role: "worker", want: "templates/worker.yaml"},
+		{name: "no chart file", role: "controlplane", wantErr: "has no templates/controlplane.yaml"},
+	} {
+		got, err := addNodeTemplates(tc.roles, root, tc.role, tc.templates)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: err = %v, want it to mention %q", tc.name, err, tc.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+
+			continue
+		}
+
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("%s: templates = %v, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestPickInstallDisk pins the disk add-node installs to: the
+// suggestion without --disk, and --disk with or without /dev/.
+func TestPickInstallDisk(t *testing.T) {
+	t.Parallel()
+
+	disks := []applycheck.DiskInfo{
+		{DevPath: "/dev/sr0", Size: 1 << 30, CDROM: true},
+		{DevPath: "/dev/sda", Size: 10 << 30},
+		{DevPath: "/dev/nvme0n1", Size: 512 << 30, Model: "Samsung SSD"},
+	}
+
+	for want, devPath := range map[string]string{"": "/dev/nvme0n1", "/dev/sda": "/dev/sda", "sda": "/dev/sda"} {
+		disk, err := pickInstallDisk(disks, want)
+		if err != nil {
+			t.Fatalf("--disk %q: %v", want, err)
+		}
+
+		if disk.DevPath != devPath {
+			t.Errorf("--disk %q: disk = %s, want %s", want, disk.DevPath, devPath)
+		}
+	}
+
+	_, err := pickInstallDisk(disks, "/dev/sr0")
+	if err == nil || !strings.Contains(err.Error(), "no disk /dev/sr0") {
+		t.Errorf("CD-ROM: err = %v", err)
+	}
+
+	if _, err := pickInstallDisk(disks[:1], ""); err == nil {
+		t.Error("expected an error without an installable disk")
+	}
+}
+
+// TestPickAttachLink pins the interface add-node records: the one
+// holding the address without --interface, and --interface by name or
+// MAC.
+func TestPickAttachLink(t *testing.T) {
+	t.Parallel()
+
+	links := []attachLink{
+		{Name: "eth0", MAC: "aa:bb:cc:00:00:01"},
+		{Name: "eth1", MAC: "aa:bb:cc:00:00:02", Addresses: []string{"10.0.0.15/24"}},
+	}
+
+	for want, name := range map[string]string{"": "eth1", "eth0": "eth0", "AA:BB:CC:00:00:01": "eth0"} {
+		link, err := pickAttachLink(links, want, "10.0.0.15")
+		if err != nil {
+			t.Fatalf("--interface %q: %v", want, err)
+		}
+
+		if link.Name != name {
+			t.Errorf("--interface %q: link = %s, want %s", want, link.Name, name)
+		}
+	}
+
+	_, err := pickAttachLink(links, "eth9", "10.0.0.15")
+	if err == nil || !strings.Contains(err.Error(), "no physical interface eth9") {
+		t.Errorf("unknown interface: err = %v", err)
+	}
+
+	if _, err := pickAttachLink(nil, "", "10.0.0.15"); err == nil {
+		t.Error("expected an error without a physical interface")
+	}
+}
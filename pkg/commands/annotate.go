@@ -0,0 +1,220 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// nodeNotePrefix opens an operator note in a node file. Notes live in
+// the comment block above the modeline, which `talm template -I`
+// re-emits on every rewrite, so they survive re-rendering. The prefix
+// tells them apart from the other comments there.
+const nodeNotePrefix = "# talm-note: "
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var annotateCmdFlags struct {
+	configFiles []string
+	message     string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var annotateCmd = &cobra.Command{
+	Use:   "annotate",
+	Short: "Attach operator notes to node files",
+	Long: `Add a dated note to each node file given with -f, or list the notes a
+node file carries when -m is omitted.
+
+A note is a "# talm-note:" comment line above the modeline, with the date
+and the operator (--as, or user@host) in front of the message:
+
+  # talm-note: 2026-10-16 alice@ops1: reset after OPS-1234
+  # talm: nodes=["192.0.2.10"], endpoints=[...], templates=[...]
+
+` + "`talm template -I`" + ` keeps the comment block above the modeline when it
+rewrites a node file, so notes survive re-rendering. JSON node files have
+no comments and cannot carry notes.`,
+	Example: `  talm annotate -f nodes/node1.yaml -m "reset after OPS-1234"
+  talm annotate -f nodes/node1.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if len(annotateCmdFlags.configFiles) == 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("no node file given"),
+				"pass the node file to annotate with -f nodes/<node>.yaml",
+			)
+		}
+
+		if strings.TrimSpace(annotateCmdFlags.message) == "" {
+			return printNodeNotes(cmd.OutOrStdout(), annotateCmdFlags.configFiles)
+		}
+
+		note := formatNodeNote(time.Now(), stateOperator(), annotateCmdFlags.message)
+
+		for _, file := range annotateCmdFlags.configFiles {
+			if err := annotateNodeFile(file, note); err != nil {
+				return err
+			}
+
+			ui.Infof(os.Stderr, "Annotated %s", file)
+		}
+
+		return nil
+	},
+}
+
+// formatNodeNote returns the note lines for message: one line per
+// non-blank line of message, each dated and signed, so a multi-line
+// message stays a run of comment lines.
+func formatNodeNote(now time.Time, operator, message string) []string {
+	var lines []string
+
+	for line := range strings.SplitSeq(strings.ReplaceAll(message, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%s %s: %s", nodeNotePrefix, now.UTC().Format(time.DateOnly), operator, strings.TrimSpace(line)))
+	}
+
+	return lines
+}
+
+// annotateNodeFile inserts note right above the modeline of the node
+// file at path, after the notes and comments already there, so notes
+// read oldest first. The rest of the file is written back byte for
+// byte, in the file's own line ending.
+func annotateNodeFile(path string, note []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", path)
+	}
+
+	if modeline.IsJSONNodeFile(data) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+		return errors.WithHintf(
+			errors.Newf("%s is a JSON node file, which has no place for notes", path),
+			"convert it with `talm template -f %s -I --format yaml` to annotate it", path,
+		)
+	}
+
+	leading, _, err := modeline.FindAndParseModeline(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading the modeline of %s", path)
+	}
+
+	// A byte order mark is not part of the first line; keep it in
+	// front of the file.
+	text := string(data)
+	bom := ""
+
+	if rest, ok := strings.CutPrefix(text, "\ufeff"); ok {
+		bom, text = "\ufeff", rest
+	}
+
+	eol := lineEnding(text)
+	lines := strings.SplitAfter(text, "\n")
+
+	var b strings.Builder
+
+	b.WriteString(bom)
+
+	for _, line := range lines[:len(leading)] {
+		b.WriteString(line)
+	}
+
+	for _, line := range note {
+		b.WriteString(line + eol)
+	}
+
+	for _, line := range lines[len(leading):] {
+		b.WriteString(line)
+	}
+
+	if err := secureperm.WriteFile(path, []byte(b.String())); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	return nil
+}
+
+// nodeNotes returns the notes in the comment block above the modeline
+// of the node file at path, without the note prefix.
+func nodeNotes(path string) ([]string, error) {
+	leading, _, err := modeline.FindAndParseModeline(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the modeline of %s", path)
+	}
+
+	var notes []string
+
+	for _, line := range leading {
+		if note, ok := strings.CutPrefix(strings.TrimSpace(line), strings.TrimSpace(nodeNotePrefix)); ok {
+			notes = append(notes, strings.TrimSpace(note))
+		}
+	}
+
+	return notes, nil
+}
+
+// printNodeNotes lists the notes of each file, under the file name
+// when there is more than one.
+func printNodeNotes(out io.Writer, files []string) error {
+	for i, file := range files {
+		notes, err := nodeNotes(file)
+		if err != nil {
+			return err
+		}
+
+		if len(files) > 1 {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+
+			fmt.Fprintf(out, "%s:\n", file)
+		}
+
+		if len(notes) == 0 {
+			ui.Infof(os.Stderr, "%s has no notes", file)
+		}
+
+		for _, note := range notes {
+			fmt.Fprintln(out, note)
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	annotateCmd.Flags().StringSliceVarP(&annotateCmdFlags.configFiles, "file", "f", nil, "node files to annotate (can specify multiple)")
+	annotateCmd.Flags().StringVarP(&annotateCmdFlags.message, "message", "m", "", "note to add; without it, the notes of the node files are listed")
+
+	_ = annotateCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	addCommand(annotateCmd)
+}
@@ -0,0 +1,177 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const annotateTestModeline = `# talm: nodes=["192.0.2.10"], endpoints=["192.0.2.10"], templates=["templates/worker.yaml"]`
+
+func TestFormatNodeNote(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 16, 23, 30, 0, 0, time.FixedZone("UTC+3", 3*3600))
+
+	got := formatNodeNote(now, "alice@ops1", "reset after OPS-1234\r\n\n  disk replaced  ")
+	want := []string{
+		"# talm-note: 2026-10-16 alice@ops1: reset after OPS-1234",
+		"# talm-note: 2026-10-16 alice@ops1: disk replaced",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formatNodeNote = %q, want %q", got, want)
+	}
+}
+
+// TestAnnotateNodeFile pins that notes go right above the modeline,
+// after the comments already there, and that the rest of the file is
+// kept byte for byte.
+func TestAnnotateNodeFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "node1.yaml")
+	body := annotateTestModeline + "\nmachine:\n  type: worker # keep me\n"
+
+	if err := os.WriteFile(path, []byte("# owned by team storage\n\n"+body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"first", "second"} {
+		if err := annotateNodeFile(path, []string{nodeNotePrefix + msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# owned by team storage\n\n# talm-note: first\n# talm-note: second\n" + body
+	if string(data) != want {
+		t.Errorf("annotated file:\n%s\nwant:\n%s", data, want)
+	}
+
+	notes, err := nodeNotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(notes, []string{"first", "second"}) {
+		t.Errorf("nodeNotes = %q", notes)
+	}
+}
+
+// TestAnnotateNodeFile_SurvivesInplaceRewrite pins the point of notes:
+// the leading block `talm template -I` re-emits carries them.
+func TestAnnotateNodeFile_SurvivesInplaceRewrite(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "node1.yaml")
+	if err := os.WriteFile(path, []byte(annotateTestModeline+"\nmachine: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := annotateNodeFile(path, formatNodeNote(time.Now(), "bob", "reset after OPS-1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, err := nodeNotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := annotateTestModeline + "\n# THIS FILE IS AUTOGENERATED\nmachine:\n  type: worker\n"
+
+	leading := make([]string, 0, len(notes))
+	for _, note := range notes {
+		leading = append(leading, nodeNotePrefix+note)
+	}
+
+	if err := writeInplaceRendered(path, prependLeadingComments(leading, rendered)); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := nodeNotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(after) != 1 || !strings.HasSuffix(after[0], "bob: reset after OPS-1234") {
+		t.Errorf("notes after the rewrite = %q", after)
+	}
+}
+
+func TestAnnotateNodeFile_KeepsCRLFAndBOM(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "node1.yaml")
+	if err := os.WriteFile(path, []byte("\ufeff"+annotateTestModeline+"\r\nmachine: {}\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := annotateNodeFile(path, []string{nodeNotePrefix + "note"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\ufeff# talm-note: note\r\n" + annotateTestModeline + "\r\nmachine: {}\r\n"
+	if string(data) != want {
+		t.Errorf("annotated file = %q, want %q", data, want)
+	}
+}
+
+func TestAnnotateNodeFile_Rejects(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "node1.json")
+	if err := os.WriteFile(jsonFile, []byte(`{"talm": {"nodes": ["192.0.2.10"]}, "documents": []}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := annotateNodeFile(jsonFile, []string{nodeNotePrefix + "x"}); err == nil || !strings.Contains(err.Error(), "JSON node file") {
+		t.Errorf("a JSON node file must be refused, got %v", err)
+	}
+
+	patch := filepath.Join(dir, "patch.yaml")
+	if err := os.WriteFile(patch, []byte("machine: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := annotateNodeFile(patch, []string{nodeNotePrefix + "x"}); err == nil {
+		t.Error("a file without a modeline must be refused")
+	}
+
+	data, err := os.ReadFile(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "machine: {}\n" {
+		t.Errorf("a refused file must be left alone, got %q", data)
+	}
+}
@@ -16,7 +16,6 @@ package commands
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -27,6 +26,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cozystack/talm/pkg/engine"
 	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -74,6 +74,7 @@ var applyCmdFlags struct {
 	jsonValues             []string // --set-json
 	literalValues          []string // --set-literal
 	talosVersion           string
+	talosVersionSet        bool // --talos-version given, values.yaml node pins do not apply
 	withSecrets            string
 	debug                  bool
 	kubernetesVersion      string
@@ -82,12 +83,30 @@ var applyCmdFlags struct {
 	stage                  bool
 	force                  bool
 	configTryTimeout       time.Duration
+	nodeTimeout            time.Duration
 	nodesFromArgs          bool
 	endpointsFromArgs      bool
 	skipResourceValidation bool
 	skipDriftPreview       bool
 	skipPostApplyVerify    bool
+	serverSideValidate     bool
 	showSecretsInDrift     bool
+	syncNodeMetadata       bool
+	strict                 bool
+	drain                  drainOptions
+	canary                 canaryOptions
+	ignoreWindow           bool
+	skipStateLock          bool
+	outputDir              string
+	rebootTimeout          time.Duration
+	release                string
+	valuesLock             string // resolved from --release
+	resume                 bool
+	continueOnError        bool
+	resumeApplied          []string // resolved from --resume
+	k8sManifests           string   // --k8s-manifests
+	wait                   bool
+	healthTimeout          time.Duration
 }
 
 //nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
@@ -117,7 +136,8 @@ Examples:
   # (the orphan path has no project to anchor on).`,
 	Args: cobra.NoArgs,
 	PreRunE: func(cmd *cobra.Command, _ []string) error {
-		if !cmd.Flags().Changed("talos-version") {
+		applyCmdFlags.talosVersionSet = cmd.Flags().Changed("talos-version")
+		if !applyCmdFlags.talosVersionSet {
 			applyCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
 
@@ -145,6 +165,37 @@ Examples:
 			applyCmdFlags.force = Config.UpgradeOptions.Force
 		}
 
+		if !cmd.Flags().Changed("node-timeout") {
+			applyCmdFlags.nodeTimeout = Config.ApplyOptions.TimeoutDuration
+		}
+
+		if !cmd.Flags().Changed("reboot-timeout") {
+			applyCmdFlags.rebootTimeout = Config.ApplyOptions.RebootTimeoutDuration
+		}
+
+		if !cmd.Flags().Changed("sync-node-metadata") {
+			applyCmdFlags.syncNodeMetadata = Config.ApplyOptions.SyncNodeMetadata
+		}
+
+		if !cmd.Flags().Changed("strict") {
+			applyCmdFlags.strict = Config.TemplateOptions.StrictDeprecations
+		}
+
+		if !cmd.Flags().Changed("drain") {
+			applyCmdFlags.drain.drain = Config.ApplyOptions.Drain
+		}
+
+		if !cmd.Flags().Changed("server-side-validate") {
+			applyCmdFlags.serverSideValidate = Config.ApplyOptions.ServerSideValidate
+		}
+
+		valuesLock, err := resolveReleaseValuesLock(cmd.Flags(), Config.RootDir, applyCmdFlags.release)
+		if err != nil {
+			return err
+		}
+
+		applyCmdFlags.valuesLock = valuesLock
+
 		applyCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
 		applyCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
 		// Set dummy endpoint to avoid errors on building client
@@ -154,8 +205,10 @@ Examples:
 
 		return nil
 	},
-	RunE: func(_ *cobra.Command, _ []string) error {
-		return apply()
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return withOutputReport(cmd.OutOrStdout(), "apply", applyCmdFlags.dryRun, func() error {
+			return withApplyWarnings(os.Stderr, apply)
+		})
 	},
 }
 
@@ -185,7 +238,68 @@ func apply() error {
 		return nil
 	}
 
-	return applyOneFile(expandedFiles[0], expandedFiles[1:])
+	if err := validateApplyCanary(); err != nil {
+		return err
+	}
+
+	if err := validateApplyResume(expandedFiles); err != nil {
+		return err
+	}
+
+	if err := validateApplyWait(); err != nil {
+		return err
+	}
+
+	if applyCmdFlags.resume {
+		done, err := resolveApplyResume(expandedFiles[0])
+		if err != nil || done {
+			return err
+		}
+	}
+
+	warnDuplicateNodeTargets(Config.RootDir, os.Stderr)
+	warnEndpointDrift(Config.RootDir, os.Stderr)
+
+	if applyCmdFlags.Mode.Mode == machineapi.ApplyConfigurationRequest_REBOOT && !applyCmdFlags.dryRun {
+		if err := enforceMaintenanceWindows(Config.RootDir, applyFileNodes(expandedFiles[0]), time.Now(), applyCmdFlags.ignoreWindow, os.Stderr); err != nil {
+			return err
+		}
+
+		if err := confirmDestructive(applyRebootConfirmation(expandedFiles[0])); err != nil {
+			return err
+		}
+	}
+
+	return withApplyState(expandedFiles[0], applyCmdFlags.skipStateLock, func() error {
+		return applyThenSyncNodeMetadata(func() error {
+			return applyOneFile(expandedFiles[0], expandedFiles[1:])
+		}, os.Stderr)
+	})
+}
+
+// applyFileNodes returns the nodes an apply of configFile targets.
+// The modeline has not been processed yet, so the nodes are read from
+// it here unless --nodes names them.
+func applyFileNodes(configFile string) []string {
+	if len(GlobalArgs.Nodes) > 0 {
+		return GlobalArgs.Nodes
+	}
+
+	if _, mc, err := modeline.FindAndParseModeline(configFile); err == nil && mc != nil {
+		return mc.Nodes
+	}
+
+	return nil
+}
+
+// applyRebootConfirmation summarises an apply with --mode=reboot for
+// the confirmation prompt.
+func applyRebootConfirmation(configFile string) confirmation {
+	c := confirmation{action: "Apply configuration and reboot the nodes"}
+	c.addTarget(applyFileNodes(configFile))
+	c.add("File", configFile)
+
+	return c
 }
 
 // resetGlobalArgsBetweenFiles wipes the per-file GlobalArgs.Nodes /
@@ -277,7 +391,12 @@ func applyOneFile(configFile string, sidePatches []string) error {
 		// context (resolved inside applyOneFileDirectPatchMode via
 		// client.GetConfigContext().Nodes). Defer the "no nodes
 		// anywhere" check to the apply path itself so the
-		// talosconfig-default flow stays reachable.
+		// talosconfig-default flow stays reachable. The Talos version
+		// pin of a previous node file does not carry over to it.
+		if !applyCmdFlags.talosVersionSet {
+			applyCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+
 		return applyOneFileDirectPatchMode(configFile, withSecretsPath)
 	}
 
@@ -291,6 +410,15 @@ func applyOneFile(configFile string, sidePatches []string) error {
 		return err
 	}
 
+	// A node pinned to another Talos version in values.yaml renders,
+	// and is pre-flight checked, against that version's contract.
+	if !applyCmdFlags.talosVersionSet {
+		applyCmdFlags.talosVersion, err = nodeTalosVersion(Config.RootDir, GlobalArgs.Nodes, Config.TemplateOptions.TalosVersion)
+		if err != nil {
+			return errors.Wrapf(err, "resolving the Talos version of %s", configFile)
+		}
+	}
+
 	if len(modelineTemplates) > 0 {
 		return applyOneFileTemplateMode(configFile, sidePatches, modelineTemplates, withSecretsPath)
 	}
@@ -420,6 +548,16 @@ func rejectModelinedSidePatches(sidePatches []string) error {
 func applyOneFileTemplateMode(configFile string, sidePatches, modelineTemplates []string, withSecretsPath string) error {
 	opts := buildApplyRenderOptions(modelineTemplates, withSecretsPath)
 
+	// processModelineAndUpdateGlobals hands back the templates only;
+	// the role of a node file rendered from a shared template sits in
+	// the same modeline.
+	_, modelineConfig, err := modeline.FindAndParseModeline(configFile)
+	if err != nil {
+		return errors.Wrapf(err, "parsing modeline in %s", configFile)
+	}
+
+	opts.Role = modelineConfig.Role
+
 	nodes := append([]string(nil), GlobalArgs.Nodes...)
 	// Elide the `side-patches=` segment in the single-file case so
 	// the conventional path's progress line stays uncluttered. The
@@ -433,23 +571,38 @@ func applyOneFileTemplateMode(configFile string, sidePatches, modelineTemplates
 	// > file.yaml` and keeps the `--debug` recipe stream
 	// uncontaminated.
 	if len(sidePatches) == 0 {
-		fmt.Fprintf(os.Stderr, "- talm: file=%s, nodes=[%s], endpoints=[%s]\n", configFile, strings.Join(nodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
+		ui.Infof(os.Stderr, "- talm: file=%s, nodes=[%s], endpoints=[%s]", configFile, strings.Join(nodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
 	} else {
-		fmt.Fprintf(os.Stderr, "- talm: file=%s, side-patches=[%s], nodes=[%s], endpoints=[%s]\n", configFile, strings.Join(sidePatches, ","), strings.Join(nodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
+		ui.Infof(os.Stderr, "- talm: file=%s, side-patches=[%s], nodes=[%s], endpoints=[%s]", configFile, strings.Join(sidePatches, ","), strings.Join(nodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
 	}
 
 	applyClosure := buildApplyClosure()
 
 	if applyCmdFlags.insecure {
+		pending, done := pendingApplyNodes(configFile, nodes)
+		if done {
+			return nil
+		}
+
 		openClient := openClientPerNodeMaintenance(applyCmdFlags.certFingerprints, WithClientMaintenance)
 
-		return applyTemplatesPerNode(opts, configFile, sidePatches, nodes, openClient, engine.Render, applyClosure)
+		return applyTemplatesPerNode(opts, configFile, sidePatches, pending, openClient, engine.Render, applyClosure)
 	}
 
 	return withApplyClientBare(func(parentCtx context.Context, c *client.Client) error {
-		resolved := resolveAuthTemplateNodes(nodes, c)
+		resolved, done := pendingApplyNodes(configFile, resolveAuthTemplateNodes(nodes, c))
+		if done {
+			return nil
+		}
+
 		openClient := openClientPerNodeAuth(parentCtx, c)
 
+		if applyCanaryEnabled() {
+			return applyCanary(parentCtx, c, resolved, configFile, sidePatches, func(group []string) error {
+				return applyTemplatesPerNode(opts, configFile, sidePatches, group, openClient, engine.Render, applyClosure)
+			})
+		}
+
 		return applyTemplatesPerNode(opts, configFile, sidePatches, resolved, openClient, engine.Render, applyClosure)
 	})
 }
@@ -480,31 +633,79 @@ func buildApplyClosure() applyFunc {
 			return err
 		}
 
-		preflightCheckTalosVersion(cosiCtx, cosiVersionReader(c), applyCmdFlags.talosVersion, os.Stderr)
+		timeouts := currentApplyTimeouts()
+
+		err = runApplyPhase(cosiCtx, applyPhasePreflight, timeouts, func(ctx context.Context) error {
+			preflightCheckTalosVersion(ctx, journalVersionReader(cosiVersionReader(c), nodeID, false), applyCmdFlags.talosVersion, os.Stderr)
+
+			return runPreApplyGates(ctx, c, data, nodeID, os.Stderr, true)
+		})
+		if err != nil {
+			return err
+		}
+
+		var before nodeBootState
+		if rebootWaitEnabled() && applyMayReboot() {
+			before = readBootBaseline(cosiCtx, talosBootReader(c))
+		}
 
-		if err := runPreApplyGates(cosiCtx, c, data, nodeID, os.Stderr, true); err != nil {
+		drain, err := beginApplyDrain([]string{nodeID})
+		if err != nil {
 			return err
 		}
 
-		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
-			Data:           data,
-			Mode:           applyCmdFlags.Mode.Mode,
-			DryRun:         applyCmdFlags.dryRun,
-			TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
+		applied := false
+		defer func() { drain.end(applied) }()
+
+		var resp *machineapi.ApplyConfigurationResponse
+
+		err = runApplyPhase(ctx, applyPhaseApply, timeouts, func(ctx context.Context) error {
+			var applyErr error
+
+			resp, applyErr = c.ApplyConfiguration(ctx, buildApplyConfigurationRequest(data))
+			if applyErr != nil {
+				return errors.Wrap(annotateApplyConfigError(applyErr), "applying new configuration")
+			}
+
+			return nil
 		})
 		if err != nil {
-			return errors.Wrap(annotateApplyConfigError(err), "applying new configuration")
+			return err
 		}
 
-		if err := emitApplyResults(resp, data, true); err != nil {
+		applied = true
+
+		if err := emitApplyResults(resp, data, true, nodeID); err != nil {
 			return err
 		}
 
-		if err := runPostApplyGate(cosiCtx, c, data, nodeID, os.Stderr, true); err != nil {
+		if rebootWaitEnabled() && len(rebootedNodes(resp, nodeID)) > 0 {
+			if err := awaitRebootedNode(cosiCtx, c, nodeID, before, ui.Progress(os.Stderr)); err != nil {
+				return err
+			}
+		}
+
+		err = runApplyPhase(cosiCtx, applyPhaseVerify, timeouts, func(ctx context.Context) error {
+			return runPostApplyGate(ctx, c, data, nodeID, os.Stderr, true)
+		})
+		if err != nil {
 			return err
 		}
 
-		return nil
+		return awaitHealthyNode(cosiCtx, c, nodeID, ui.Progress(os.Stderr))
+	}
+}
+
+// buildApplyConfigurationRequest is the ApplyConfiguration request
+// both apply paths send. TryModeTimeout is the node-side rollback
+// timer of --mode=try (the --timeout flag), unrelated to the
+// client-side deadlines in apply_timeout.go.
+func buildApplyConfigurationRequest(data []byte) *machineapi.ApplyConfigurationRequest {
+	return &machineapi.ApplyConfigurationRequest{
+		Data:           data,
+		Mode:           applyCmdFlags.Mode.Mode,
+		DryRun:         applyCmdFlags.dryRun,
+		TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
 	}
 }
 
@@ -555,6 +756,24 @@ func resolveDirectPatchTargetNodes(c *client.Client, configFile string) ([]strin
 }
 
 func applyOneFileDirectPatchMode(configFile, withSecretsPath string) error {
+	if applyCanaryEnabled() {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--strategy canary needs a node file that renders templates, and %s declares none", configFile),
+			"add `templates=[...]` to the modeline of the node file, or apply it with the default strategy",
+		)
+	}
+
+	// Without templates, one ApplyConfiguration fans out to every
+	// node, so there is no per-node apply to carry on past.
+	if applyCmdFlags.continueOnError {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--continue-on-error needs a node file that renders templates, and %s declares none", configFile),
+			"add `templates=[...]` to the modeline of the node file, or apply it without --continue-on-error",
+		)
+	}
+
 	opts := buildApplyPatchOptions(withSecretsPath)
 	patches := []string{"@" + configFile}
 
@@ -576,31 +795,64 @@ func applyOneFileDirectPatchMode(configFile, withSecretsPath string) error {
 		)
 	}
 
+	result, err = inlineK8sManifests(applyCmdFlags.k8sManifests, result)
+	if err != nil {
+		return err
+	}
+
 	return withApplyClient(func(ctx context.Context, c *client.Client) error {
 		targetNodes, err := resolveDirectPatchTargetNodes(c, configFile)
 		if err != nil {
 			return err
 		}
 
+		targetNodes, done := pendingApplyNodes(configFile, targetNodes)
+		if done {
+			return nil
+		}
+
 		// Progress line goes to stderr; stdout is reserved for rendered output.
-		fmt.Fprintf(os.Stderr, "- talm: file=%s, nodes=[%s], endpoints=[%s]\n", configFile, strings.Join(targetNodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
+		ui.Infof(os.Stderr, "- talm: file=%s, nodes=[%s], endpoints=[%s]", configFile, strings.Join(targetNodes, ","), strings.Join(GlobalArgs.Endpoints, ","))
 
-		read := cosiVersionReader(c)
+		timeouts := currentApplyTimeouts()
 
-		for _, node := range targetNodes {
-			nodeCtx := client.WithNode(ctx, node)
-			preflightCheckTalosVersion(nodeCtx, read, applyCmdFlags.talosVersion, os.Stderr)
+		before := make(map[string]nodeBootState, len(targetNodes))
 
-			if err := runPreApplyGates(nodeCtx, c, result, node, os.Stderr, false); err != nil {
+		for _, node := range targetNodes {
+			if err := runDirectPatchPreflight(ctx, c, result, node, timeouts); err != nil {
 				return err
 			}
+
+			if rebootWaitEnabled() && applyMayReboot() {
+				before[node] = readBootBaseline(client.WithNode(ctx, node), talosBootReader(c))
+			}
+		}
+
+		drain, err := beginApplyDrain(targetNodes)
+		if err != nil {
+			return err
 		}
 
-		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
-			Data:           result,
-			Mode:           applyCmdFlags.Mode.Mode,
-			DryRun:         applyCmdFlags.dryRun,
-			TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
+		applied := false
+		defer func() { drain.end(applied) }()
+
+		// One ApplyConfiguration fans out to every target node, so the
+		// call is bounded by a single per-node budget rather than one
+		// per target.
+		applyCtx, cancel := withNodeDeadline(ctx, timeouts)
+		defer cancel()
+
+		var resp *machineapi.ApplyConfigurationResponse
+
+		err = runApplyPhase(applyCtx, applyPhaseApply, timeouts, func(ctx context.Context) error {
+			var applyErr error
+
+			resp, applyErr = c.ApplyConfiguration(ctx, buildApplyConfigurationRequest(result))
+			if applyErr != nil {
+				return errors.Wrap(annotateApplyConfigError(applyErr), "applying new configuration")
+			}
+
+			return nil
 		})
 		if err != nil {
 			// Post-apply verify intentionally not run on this path:
@@ -611,14 +863,43 @@ func applyOneFileDirectPatchMode(configFile, withSecretsPath string) error {
 			// gate too) — running verify on possibly-partially-applied
 			// state would produce confusing per-node divergence noise
 			// on top of the actual failure.
-			return errors.Wrap(annotateApplyConfigError(err), "applying new configuration")
+			return err
+		}
+
+		applied = true
+
+		summaryNode := ""
+		if len(targetNodes) == 1 {
+			summaryNode = targetNodes[0]
 		}
 
-		if err := emitApplyResults(resp, result, false); err != nil {
+		if err := emitApplyResults(resp, result, false, summaryNode); err != nil {
 			return err
 		}
 
-		return runPostApplyGates(ctx, c, result, targetNodes, false)
+		if rebootWaitEnabled() {
+			for _, node := range rebootedNodes(resp, summaryNode) {
+				if err := awaitRebootedNode(client.WithNode(ctx, node), c, node, before[node], ui.Progress(os.Stderr)); err != nil {
+					return errors.Wrapf(err, "node %s", node)
+				}
+			}
+		}
+
+		if err := runPostApplyGates(ctx, c, result, targetNodes, false); err != nil {
+			return err
+		}
+
+		for _, node := range targetNodes {
+			if err := awaitHealthyNode(client.WithNode(ctx, node), c, node, ui.Progress(os.Stderr)); err != nil {
+				return err
+			}
+		}
+
+		for _, node := range targetNodes {
+			currentJournal().noteResult(node, nil)
+		}
+
+		return nil
 	})
 }
 
@@ -630,8 +911,10 @@ func applyOneFileDirectPatchMode(configFile, withSecretsPath string) error {
 func runPostApplyGates(ctx context.Context, c *client.Client, result []byte, targetNodes []string, rendersUserValues bool) error {
 	var perNodeErrs []error
 
+	timeouts := currentApplyTimeouts()
+
 	for _, node := range targetNodes {
-		if err := runPostApplyGate(client.WithNode(ctx, node), c, result, node, os.Stderr, rendersUserValues); err != nil {
+		if err := runPostApplyGateWithDeadline(client.WithNode(ctx, node), c, result, node, rendersUserValues, timeouts); err != nil {
 			perNodeErrs = append(perNodeErrs, errors.Wrapf(err, "node %s", node))
 		}
 	}
@@ -639,17 +922,50 @@ func runPostApplyGates(ctx context.Context, c *client.Client, result []byte, tar
 	return errors.Join(perNodeErrs...)
 }
 
+// runDirectPatchPreflight runs the pre-apply checks of the
+// direct-patch path for one node under that node's deadline.
+func runDirectPatchPreflight(ctx context.Context, c *client.Client, result []byte, node string, timeouts applyTimeouts) error {
+	nodeCtx, cancel := withNodeDeadline(client.WithNode(ctx, node), timeouts)
+	defer cancel()
+
+	err := runApplyPhase(nodeCtx, applyPhasePreflight, timeouts, func(ctx context.Context) error {
+		preflightCheckTalosVersion(ctx, journalVersionReader(cosiVersionReader(c), node, false), applyCmdFlags.talosVersion, os.Stderr)
+
+		return runPreApplyGates(ctx, c, result, node, os.Stderr, false)
+	})
+
+	return errors.Wrapf(err, "node %s", node)
+}
+
+// runPostApplyGateWithDeadline runs Phase 2B for one node of the
+// direct-patch path under that node's deadline.
+func runPostApplyGateWithDeadline(ctx context.Context, c *client.Client, result []byte, node string, rendersUserValues bool, timeouts applyTimeouts) error {
+	nodeCtx, cancel := withNodeDeadline(ctx, timeouts)
+	defer cancel()
+
+	return runApplyPhase(nodeCtx, applyPhaseVerify, timeouts, func(ctx context.Context) error {
+		return runPostApplyGate(ctx, c, result, node, os.Stderr, rendersUserValues)
+	})
+}
+
 // runPreApplyGates wires the two pre-apply safety gates against the
 // rendered MachineConfig. Phase 1 (resource existence) blocks on bad
 // refs unless --skip-resource-validation is set. Phase 2A (drift
 // preview) is informational and never blocks; --skip-drift-preview
-// suppresses the read entirely.
+// suppresses the read entirely. --server-side-validate puts the
+// node's own validation in front of both (see serverValidateConfig).
 //
 // Phase 2A intentionally runs on --dry-run: the diff is read-only,
 // and "show me what would change" is precisely what dry-run is for.
 // Skipping it would leave operators with no way to preview drift
 // short of a real apply.
 func runPreApplyGates(ctx context.Context, c *client.Client, rendered []byte, nodeID string, w io.Writer, rendersUserValues bool) error {
+	if shouldRunServerValidate(applyCmdFlags.serverSideValidate, applyCmdFlags.dryRun) {
+		if err := serverValidateConfig(ctx, talosConfigDryRunner(c), rendered, nodeID, w); err != nil {
+			return err
+		}
+	}
+
 	if !applyCmdFlags.skipResourceValidation {
 		if err := preflightValidateResources(ctx, cosiLinksDisksReader(c), rendered, w); err != nil {
 			return err
@@ -671,7 +987,7 @@ func runPreApplyGates(ctx context.Context, c *client.Client, rendered []byte, no
 // buildDriftRedactor assembles the redaction policy for the drift preview /
 // post-apply divergence output. --show-secrets-in-drift bypasses redaction
 // entirely. Otherwise the policy carries the user secret set decrypted from
-// the encrypted value files in scope, so a secret authored in
+// the encrypted value files in scope and the secret store, so a secret authored in
 // values-secret.encrypted.yaml is masked wherever it surfaces in the diff —
 // not just on the static Talos-bootstrap path allowlist.
 //
@@ -692,7 +1008,7 @@ func buildDriftRedactor(rendersUserValues bool) (secretRedactor, error) {
 		return secretRedactor{}, nil
 	}
 
-	secrets, err := collectEncryptedValueLeaves(applyValueFilePaths(), Config.RootDir)
+	secrets, err := collectSecretLeaves(applyValueFilePaths(), Config.RootDir)
 	if err != nil {
 		return secretRedactor{}, errors.Wrap(err, "collecting secret values for drift redaction")
 	}
@@ -869,13 +1185,30 @@ func applyTemplatesPerNode(
 		}
 	}
 
-	for _, node := range nodes {
+	results := make([]applyNodeResult, 0, len(nodes))
+
+	for i, node := range nodes {
 		err := openClient(node, func(ctx context.Context, c *client.Client) error {
-			return renderMergeAndApply(ctx, c, opts, configFile, sidePatches, render, apply)
+			// Render, preflight, apply and verify of one node share a
+			// single deadline; see withNodeDeadline.
+			nodeCtx, cancel := withNodeDeadline(ctx, currentApplyTimeouts())
+			defer cancel()
+
+			return renderMergeAndApply(nodeCtx, c, opts, configFile, sidePatches, render, apply)
 		})
-		if err != nil {
+		currentJournal().noteResult(node, err)
+
+		if err != nil && !applyCmdFlags.continueOnError {
+			warnUnattemptedNodes(os.Stderr, nodes[i+1:])
+
 			return errors.Wrapf(err, "node %s", node)
 		}
+
+		results = append(results, applyNodeResult{node: node, err: err})
+	}
+
+	if applyCmdFlags.continueOnError {
+		return reportApplyResults(os.Stderr, results)
 	}
 
 	return nil
@@ -1029,7 +1362,21 @@ func resolveAuthTemplateNodes(cliNodes []string, c *client.Client) []string {
 //
 //nolint:gocritic // opts taken by value to mirror applyTemplatesPerNode's test-injection signature
 func renderMergeAndApply(ctx context.Context, c *client.Client, opts engine.Options, configFile string, sidePatches []string, render renderFunc, apply applyFunc) error {
-	rendered, err := render(ctx, c, opts)
+	var rendered []byte
+
+	err := runApplyPhase(ctx, applyPhaseRender, currentApplyTimeouts(), func(ctx context.Context) error {
+		var renderErr error
+
+		rendered, renderErr = render(ctx, c, opts)
+
+		return renderErr
+	})
+	if errors.Is(err, ErrApplyTimeout) {
+		// The timeout hint already names the limit to raise; the
+		// generic render hint below would point at the chart instead.
+		return errors.Wrap(err, "template rendering")
+	}
+
 	if err != nil {
 		//nolint:wrapcheck // already wrapped via errors.Wrap, WithHint adds operator-facing guidance
 		return errors.WithHint(
@@ -1050,6 +1397,11 @@ func renderMergeAndApply(ctx context.Context, c *client.Client, opts engine.Opti
 		}
 	}
 
+	merged, err = inlineK8sManifests(applyCmdFlags.k8sManifests, merged)
+	if err != nil {
+		return err
+	}
+
 	return apply(ctx, c, merged)
 }
 
@@ -1060,17 +1412,14 @@ func renderMergeAndApply(ctx context.Context, c *client.Client, opts engine.Opti
 func buildApplyRenderOptions(modelineTemplates []string, withSecretsPath string) engine.Options {
 	resolvedTemplates := resolveTemplatePaths(modelineTemplates, Config.RootDir)
 
-	opts := engine.Options{
-		TalosVersion:      applyCmdFlags.talosVersion,
-		WithSecrets:       withSecretsPath,
-		KubernetesVersion: applyCmdFlags.kubernetesVersion,
-		Debug:             applyCmdFlags.debug,
-		Full:              true,
-		Root:              Config.RootDir,
-		TemplateFiles:     resolvedTemplates,
-		CommandName:       applyCommandName,
-		TalosEndpoints:    append([]string(nil), GlobalArgs.Endpoints...),
-	}
+	opts := projectRenderOptions(applyCommandName, applyCmdFlags.valuesLock)
+	opts.TalosVersion = applyCmdFlags.talosVersion
+	opts.WithSecrets = withSecretsPath
+	opts.KubernetesVersion = applyCmdFlags.kubernetesVersion
+	opts.Debug = applyCmdFlags.debug
+	opts.Full = true
+	opts.TemplateFiles = resolvedTemplates
+	opts.StrictDeprecations = applyCmdFlags.strict
 	setApplyValueOptions(&opts)
 
 	return opts
@@ -1094,9 +1443,14 @@ func buildApplyPatchOptions(withSecretsPath string) engine.Options {
 
 // applyValueFilePaths returns the resolved --values / templateOptions.valueFiles
 // set for an apply: Chart.yaml-declared files resolved against the project
-// root, then CLI --values (CWD-relative) appended. Shared by the render
-// options and the drift redactor so both consume the exact same file list.
+// root, then CLI --values (CWD-relative) appended. Under --release it is the
+// values lock alone. Shared by the render options and the drift redactor so
+// both consume the exact same file list.
 func applyValueFilePaths() []string {
+	if applyCmdFlags.valuesLock != "" {
+		return []string{applyCmdFlags.valuesLock}
+	}
+
 	return append(resolveProjectValueFiles(Config.TemplateOptions.ValueFiles, Config.RootDir), applyCmdFlags.valueFiles...)
 }
 
@@ -1106,8 +1460,13 @@ func applyValueFilePaths() []string {
 // appended): the engine's loadValues applies sources left-to-right, so the CLI
 // flags appended here win over the Chart.yaml defaults. Chart.yaml-declared
 // value files are resolved against the project root; CLI --values paths stay
-// CWD-relative.
+// CWD-relative. Under --release the values lock, which
+// projectRenderOptions already set, replaces all six.
 func setApplyValueOptions(opts *engine.Options) {
+	if opts.ValuesLock != "" {
+		return
+	}
+
 	opts.ValueFiles = applyValueFilePaths()
 	opts.Values = slices.Concat(Config.TemplateOptions.Values, applyCmdFlags.values)
 	opts.StringValues = slices.Concat(Config.TemplateOptions.StringValues, applyCmdFlags.stringValues)
@@ -1233,12 +1592,28 @@ func init() {
 	applyCmd.Flags().BoolVarP(&applyCmdFlags.debug, "debug", "", false, "show only rendered patches")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.dryRun, "dry-run", false, "check how the config change will be applied in dry-run mode")
 	applyCmd.Flags().DurationVar(&applyCmdFlags.configTryTimeout, "timeout", constants.ConfigTryTimeout, "the config will be rolled back after specified timeout (if try mode is selected)")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.skipStateLock, "skip-state-lock", false, "apply without taking the project apply lock (use only when the state backend is unreachable and nobody else is applying)")
+	applyCmd.Flags().DurationVar(&applyCmdFlags.nodeTimeout, "node-timeout", 0, "total deadline for rendering, preflight, applying and verifying one node; defaults to applyOptions.timeout from Chart.yaml, 0 disables it")
 	applyCmd.Flags().StringSliceVar(&applyCmdFlags.certFingerprints, "cert-fingerprint", nil, "list of server certificate fingeprints to accept (defaults to no check)")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.force, "force", false, "will overwrite existing files")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.skipResourceValidation, "skip-resource-validation", false, "skip the pre-apply check that declared host resources (links, disks) exist on the target node")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.skipDriftPreview, "skip-drift-preview", false, "skip the pre-apply diff of on-node vs rendered MachineConfig")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.serverSideValidate, "server-side-validate", false, "before applying, send the rendered config to each node as a dry run so the node's own Talos validates it, and fail on what it rejects (default from Chart.yaml applyOptions.serverSideValidate)")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.skipPostApplyVerify, "skip-post-apply-verify", true, "skip the post-apply structural verification of on-node vs sent MachineConfig (default skip until the Talos-mutated field allowlist lands)")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.showSecretsInDrift, "show-secrets-in-drift", false, "show secret-bearing field values verbatim in drift preview / post-apply verify output (default: redacted). Covers both the Talos bootstrap allowlist (cluster.token, cluster.ca.key, machine.token, Wireguard private keys, etc.) and values from encrypted value files (*.encrypted.yaml). Counterpart on template is --show-secrets, which governs the same values in template's stdout render.")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.syncNodeMetadata, "sync-node-metadata", false, "after a successful apply, patch the labels and annotations declared under nodes.<address> in values.yaml onto the matching Kubernetes Nodes via the project kubeconfig (default from Chart.yaml applyOptions.syncNodeMetadata)")
+	applyCmd.Flags().DurationVar(&applyCmdFlags.rebootTimeout, "reboot-timeout", 0, "wait this long for nodes that reboot into the new config to come back running and ready, and store the logs of any that do not under .talm/failures (default from Chart.yaml applyOptions.rebootTimeout, 0 does not wait)")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.strict, "strict", false, strictFlagUsage)
+	addDrainFlags(applyCmd.Flags(), &applyCmdFlags.drain)
+	addCanaryFlags(applyCmd.Flags(), &applyCmdFlags.canary)
+	applyCmd.Flags().BoolVar(&applyCmdFlags.ignoreWindow, ignoreWindowFlag, false, ignoreWindowFlagUsage)
+	applyCmd.Flags().StringVar(&applyCmdFlags.release, "release", "", "render with the values locked for this release tag by `talm snapshot values` (releases/<tag>/values.lock.yaml) instead of values.yaml and the value files; cannot be combined with --values or --set*")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.resume, "resume", false, "continue the last failed apply of the file from the history: skip the nodes it completed on and apply the rest")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.continueOnError, "continue-on-error", false, "keep applying to the remaining nodes when one fails, and print a summary of every node at the end")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.wait, "wait", false, "after each node, wait until it runs with every Talos condition met and, with a project kubeconfig, its Kubernetes Node is Ready, before applying the next; a node that rebooted must come back first")
+	applyCmd.Flags().DurationVar(&applyCmdFlags.healthTimeout, "health-timeout", defaultHealthTimeout, "how long --wait waits for each node to be healthy, and for a rebooted node to come back unless --reboot-timeout is set")
+	applyCmd.Flags().StringVar(&applyCmdFlags.k8sManifests, "k8s-manifests", "", "re-inline the Kubernetes manifests talm template --k8s-manifests split into this directory: each subdirectory becomes the cluster.inlineManifests entry of its name, replacing the rendered one")
+	applyCmd.Flags().StringVar(&applyCmdFlags.outputDir, "output-dir", "", "after a successful apply, write the applied config (<node>.yaml) and what the node reported for it (<node>.summary.json) to this directory")
 	helpers.AddModeFlags(&applyCmdFlags.Mode, applyCmd)
 
 	// Shell completion for `talm apply` flags. `--file` returns the
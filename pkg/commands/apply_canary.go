@@ -0,0 +1,89 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// validateApplyCanary rejects the canary strategy where it cannot
+// work. A maintenance-mode node has no config to save for the
+// rollback and no authenticated API for the health checks.
+func validateApplyCanary() error {
+	if err := validateCanaryOptions(applyCmdFlags.canary); err != nil {
+		return err
+	}
+
+	if applyCmdFlags.canary.enabled() && applyCmdFlags.insecure {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--strategy canary cannot be used with --insecure"),
+			"a node in maintenance mode has no config to roll back to and cannot be health-checked; apply it with the default strategy",
+		)
+	}
+
+	return nil
+}
+
+// applyCanaryEnabled reports whether this apply rolls out as a
+// canary. A dry run changes nothing, so it has nothing to roll back.
+func applyCanaryEnabled() bool {
+	return applyCmdFlags.canary.enabled() && !applyCmdFlags.dryRun
+}
+
+// applyCanary rolls the apply of the node group out canary first. The
+// rollback copy of each canary node is the MachineConfig it runs
+// before the apply, read from the node itself: it is what the node
+// goes back to, whatever the project history holds.
+func applyCanary(ctx context.Context, c *client.Client, nodes []string, configFile string, sidePatches []string, rollout func(nodes []string) error) error {
+	// applyTemplatesPerNode only guards against a per-node body for a
+	// group of more than one node, and a canary of one would slip past.
+	if len(nodes) > 1 {
+		if err := rejectMultiNodeOverlayFiles(configFile, sidePatches, nodes); err != nil {
+			return err
+		}
+	}
+
+	return runCanary(ctx, nodes, applyCmdFlags.canary, applyCanarySteps(c, rollout), os.Stderr)
+}
+
+func applyCanarySteps(c *client.Client, rollout func(nodes []string) error) canarySteps {
+	read := cosiMachineConfigReader(c, false)
+
+	return canarySteps{
+		save: func(ctx context.Context, node string) ([]byte, error) {
+			data, _, err := read(client.WithNode(ctx, node))
+
+			return data, err
+		},
+		rollout: rollout,
+		health:  canaryHealthCheck(c),
+		rollback: func(ctx context.Context, node string, saved []byte) error {
+			ui.Infof(os.Stderr, "Canary: re-applying the previous config of %s", node)
+
+			if _, err := c.ApplyConfiguration(client.WithNodes(ctx, node), buildApplyConfigurationRequest(saved)); err != nil {
+				return errors.Wrap(annotateApplyConfigError(err), "re-applying the previous configuration")
+			}
+
+			return nil
+		},
+	}
+}
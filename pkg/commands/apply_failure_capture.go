@@ -0,0 +1,328 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// failureCaptureDir is where apply keeps the evidence of a node that
+// did not come back from the reboot into a new config, relative to the
+// project root. Each failure gets its own <node>-<timestamp> directory.
+const failureCaptureDir = ".talm/failures"
+
+// Files written into one failure directory.
+const (
+	failureSummaryName = "summary.txt"
+	failureDmesgName   = "dmesg.log"
+)
+
+// failureCaptureTimestamp names a failure directory; sortable and free
+// of characters that are not allowed in Windows file names.
+const failureCaptureTimestamp = "20060102T150405Z"
+
+// nodeReturnPollInterval is how often the reboot wait polls the node,
+// and dmesgCaptureTimeout bounds the log fetch of a failed node.
+const (
+	nodeReturnPollInterval = 5 * time.Second
+	dmesgCaptureTimeout    = 30 * time.Second
+)
+
+// nodeBootState is what the reboot wait reads from a node: when the
+// kernel booted and how far the boot got.
+type nodeBootState struct {
+	bootTime uint64
+	stage    string
+	ready    bool
+	unmet    []string
+}
+
+// running reports whether the node finished booting: stage running and
+// every readiness condition met.
+func (s nodeBootState) running() bool {
+	return s.stage == runtime.MachineStageRunning.String() && s.ready
+}
+
+// describe renders the state for messages and the failure summary.
+func (s nodeBootState) describe() string {
+	if s.stage == "" {
+		return "unknown"
+	}
+
+	desc := "stage " + s.stage
+	if len(s.unmet) > 0 {
+		desc += ", waiting for " + strings.Join(s.unmet, "; ")
+	}
+
+	return desc
+}
+
+// nodeBootReader reads nodeBootState from one node. An error means the
+// node's API did not answer.
+type nodeBootReader func(ctx context.Context) (nodeBootState, error)
+
+// dmesgFetcher reads the node's kernel log buffer.
+type dmesgFetcher func(ctx context.Context) ([]byte, error)
+
+// talosBootReader reads the boot time from SystemStat and the stage
+// from the MachineStatus resource. ctx must target a single node: COSI
+// rejects the plural nodes key (see cosiPreflightContext).
+func talosBootReader(c *client.Client) nodeBootReader {
+	return func(ctx context.Context) (nodeBootState, error) {
+		ctx, cancel := context.WithTimeout(ctx, preflightCOSIReadTimeout)
+		defer cancel()
+
+		stat, err := c.MachineClient.SystemStat(ctx, &emptypb.Empty{})
+		if err != nil {
+			return nodeBootState{}, errors.Wrap(err, "reading the node boot time")
+		}
+
+		var state nodeBootState
+
+		for _, message := range stat.GetMessages() {
+			state.bootTime = message.GetBootTime()
+		}
+
+		status, err := safe.StateGetByID[*runtime.MachineStatus](ctx, c.COSI, runtime.MachineStatusID)
+		if err != nil {
+			return nodeBootState{}, errors.Wrap(err, "reading the node machine status")
+		}
+
+		spec := status.TypedSpec()
+		state.stage = spec.Stage.String()
+		state.ready = spec.Status.Ready
+
+		for _, condition := range spec.Status.UnmetConditions {
+			state.unmet = append(state.unmet, condition.Name+": "+condition.Reason)
+		}
+
+		return state, nil
+	}
+}
+
+// talosDmesg reads the kernel log buffer once, without following it.
+func talosDmesg(c *client.Client) dmesgFetcher {
+	return func(ctx context.Context) ([]byte, error) {
+		stream, err := c.Dmesg(ctx, false, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "requesting dmesg")
+		}
+
+		var buf bytes.Buffer
+
+		err = helpers.ReadGRPCStream(stream, func(data *common.Data, _ string, _ bool) error {
+			buf.Write(data.GetBytes())
+
+			return nil
+		})
+
+		return buf.Bytes(), errors.Wrap(err, "reading dmesg")
+	}
+}
+
+// readBootBaseline reads the node's boot time before the apply, so the
+// wait can tell the node that came back from the one that has not gone
+// down yet. Best-effort: a zero baseline makes the wait require an
+// observed outage instead.
+func readBootBaseline(ctx context.Context, read nodeBootReader) nodeBootState {
+	state, err := read(ctx)
+	if err != nil {
+		return nodeBootState{}
+	}
+
+	return state
+}
+
+// waitForNodeReturn polls the node until it has rebooted — a boot time
+// other than the baseline, or an outage seen when there is none — and
+// reached stage running with every condition met. It returns the last
+// state read, which on failure tells how far the boot got, and an error
+// naming that state or, when the node never answered again, the last
+// API error.
+func waitForNodeReturn(ctx context.Context, read nodeBootReader, before nodeBootState, timeout, interval time.Duration) (nodeBootState, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		last    nodeBootState
+		lastErr error
+		sawDown bool
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return last, errors.Wrapf(lastErr, "node did not come back within %s", timeout)
+			}
+
+			return last, errors.Newf("node did not come back within %s: %s", timeout, last.describe())
+		case <-time.After(interval):
+		}
+
+		state, err := read(ctx)
+		if err != nil {
+			// A read cut short by the wait's own deadline says
+			// nothing about the node; keep the last real answer.
+			if ctx.Err() == nil {
+				lastErr = err
+			}
+
+			sawDown = true
+
+			continue
+		}
+
+		last, lastErr = state, nil
+
+		rebooted := sawDown
+		if before.bootTime != 0 {
+			rebooted = state.bootTime != before.bootTime
+		}
+
+		if !state.running() {
+			sawDown = true
+		}
+
+		if rebooted && state.running() {
+			return state, nil
+		}
+	}
+}
+
+// captureNodeFailure stores the evidence of a node that did not come
+// back under rootDir/.talm/failures/<node>-<timestamp>/: a summary with
+// the cause and the last state read, and the kernel log when the node
+// still answers. The logs can carry addresses and hostnames, so the
+// files are owner-only. A failed log fetch is recorded in the summary
+// rather than returned: the summary alone is still evidence.
+func captureNodeFailure(ctx context.Context, rootDir, node string, now time.Time, state nodeBootState, cause error, dmesg dmesgFetcher) (string, error) {
+	dir := filepath.Join(rootDir, filepath.FromSlash(failureCaptureDir), appliedFileName(node)+"-"+now.UTC().Format(failureCaptureTimestamp))
+	if err := os.MkdirAll(dir, secureDirMode); err != nil {
+		return "", errors.Wrapf(err, "creating %s", dir)
+	}
+
+	dmesgCtx, cancel := context.WithTimeout(ctx, dmesgCaptureTimeout)
+	defer cancel()
+
+	logs, dmesgErr := dmesg(dmesgCtx)
+
+	var summary strings.Builder
+
+	fmt.Fprintf(&summary, "node: %s\n", node)
+	fmt.Fprintf(&summary, "captured: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&summary, "cause: %v\n", cause)
+	fmt.Fprintf(&summary, "last state: %s\n", state.describe())
+
+	if len(logs) > 0 {
+		if err := secureperm.WriteFile(filepath.Join(dir, failureDmesgName), logs); err != nil {
+			return "", errors.Wrapf(err, "writing %s", failureDmesgName)
+		}
+
+		fmt.Fprintf(&summary, "dmesg: %s\n", failureDmesgName)
+	} else if dmesgErr != nil {
+		fmt.Fprintf(&summary, "dmesg: unavailable: %v\n", dmesgErr)
+	}
+
+	if err := secureperm.WriteFile(filepath.Join(dir, failureSummaryName), []byte(summary.String())); err != nil {
+		return "", errors.Wrapf(err, "writing %s", failureSummaryName)
+	}
+
+	return dir, nil
+}
+
+// rebootedNodes lists the nodes whose apply response says they reboot
+// into the new config, in response order. AUTO resolves on the node,
+// so the mode each node reported is what counts, not --mode.
+func rebootedNodes(resp *machineapi.ApplyConfigurationResponse, node string) []string {
+	var nodes []string
+
+	for _, applied := range buildApplySummary(resp, nil, nil, node).Nodes {
+		if applied.Mode == applyModeName(machineapi.ApplyConfigurationRequest_REBOOT) {
+			nodes = append(nodes, applied.Node)
+		}
+	}
+
+	return nodes
+}
+
+// applyMayReboot reports whether the requested mode can reboot the
+// node, so the boot-time baseline is only read when it may be needed.
+func applyMayReboot() bool {
+	switch applyCmdFlags.Mode.Mode {
+	case machineapi.ApplyConfigurationRequest_REBOOT, machineapi.ApplyConfigurationRequest_AUTO:
+		return true
+	default:
+		return false
+	}
+}
+
+// rebootWaitEnabled reports whether apply waits for rebooted nodes:
+// --reboot-timeout or --wait is set, the apply is real, and the
+// connection is authenticated. The maintenance connection cannot reach
+// the node once it boots into its config.
+func rebootWaitEnabled() bool {
+	return rebootWaitTimeout() > 0 && !applyCmdFlags.dryRun && !applyCmdFlags.insecure
+}
+
+// awaitRebootedNode waits for one rebooted node and, when it does not
+// come back, captures its logs and returns the wait error with a hint
+// naming the failure directory. ctx must target the node alone. The
+// per-node apply deadline does not cover the wait, which has its own
+// rebootWaitTimeout budget.
+func awaitRebootedNode(ctx context.Context, c *client.Client, node string, before nodeBootState, w io.Writer) error {
+	ctx = context.WithoutCancel(ctx)
+	read := talosBootReader(c)
+	timeout := rebootWaitTimeout()
+
+	_, _ = fmt.Fprintf(w, "- talm: waiting up to %s for %s to come back\n", timeout, node)
+
+	state, err := waitForNodeReturn(ctx, read, before, timeout, nodeReturnPollInterval)
+	if err == nil {
+		return nil
+	}
+
+	dir, captureErr := captureNodeFailure(ctx, Config.RootDir, node, time.Now(), state, err, talosDmesg(c))
+	if captureErr != nil {
+		ui.Warnf(w, "could not store the failure evidence of %s: %v", node, captureErr)
+
+		return err
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(err,
+		"the node's last state and kernel log are saved in %s; the node may still be booting (raise --reboot-timeout) or stuck on the new config (roll back with `talm apply` of the previous node file once it answers)",
+		dir,
+	)
+}
@@ -0,0 +1,156 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+// scriptedBootReader answers each poll with the next entry of script,
+// repeating the last one once the script runs out. A nil state entry
+// stands for an unreachable node.
+func scriptedBootReader(script ...*nodeBootState) nodeBootReader {
+	calls := 0
+
+	return func(context.Context) (nodeBootState, error) {
+		entry := script[min(calls, len(script)-1)]
+		calls++
+
+		if entry == nil {
+			return nodeBootState{}, errors.New("connection refused")
+		}
+
+		return *entry, nil
+	}
+}
+
+func TestWaitForNodeReturn(t *testing.T) {
+	t.Parallel()
+
+	old := &nodeBootState{bootTime: 100, stage: "running", ready: true}
+	booting := &nodeBootState{bootTime: 200, stage: "booting", unmet: []string{"services: kubelet not healthy"}}
+	back := &nodeBootState{bootTime: 200, stage: "running", ready: true}
+
+	tests := []struct {
+		name    string
+		before  nodeBootState
+		script  []*nodeBootState
+		wantErr string
+	}{
+		{name: "new boot reaches running", before: *old, script: []*nodeBootState{old, nil, booting, back}},
+		{name: "no baseline needs an outage first", script: []*nodeBootState{nil, back}},
+		{name: "old instance still answering", before: *old, script: []*nodeBootState{old}, wantErr: "stage running"},
+		{name: "no baseline and never down", script: []*nodeBootState{old}, wantErr: "stage running"},
+		{name: "stuck booting", before: *old, script: []*nodeBootState{nil, booting}, wantErr: "stage booting, waiting for services: kubelet not healthy"},
+		{name: "never answers again", before: *old, script: []*nodeBootState{nil}, wantErr: "connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := waitForNodeReturn(context.Background(), scriptedBootReader(tt.script...), tt.before, 50*time.Millisecond, time.Millisecond)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "did not come back within 50ms") {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCaptureNodeFailure(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	now := time.Date(2026, 5, 1, 12, 0, 30, 0, time.UTC)
+	state := nodeBootState{stage: "booting", unmet: []string{"time: not in sync"}}
+
+	dir, err := captureNodeFailure(context.Background(), root, "2001:db8::10", now, state, errors.New("node did not come back within 5m0s"),
+		func(context.Context) ([]byte, error) { return []byte("[    0.000000] Linux version\n"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(root, ".talm", "failures", "2001_db8__10-20260501T120030Z"); dir != want {
+		t.Errorf("dir = %s, want %s", dir, want)
+	}
+
+	logs, err := os.ReadFile(filepath.Join(dir, failureDmesgName))
+	if err != nil || string(logs) != "[    0.000000] Linux version\n" {
+		t.Errorf("dmesg = %q, %v", logs, err)
+	}
+
+	summary, err := os.ReadFile(filepath.Join(dir, failureSummaryName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"node: 2001:db8::10", "captured: 2026-05-01T12:00:30Z", "cause: node did not come back within 5m0s", "last state: stage booting, waiting for time: not in sync", "dmesg: dmesg.log"} {
+		if !strings.Contains(string(summary), want) {
+			t.Errorf("summary missing %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestCaptureNodeFailure_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	dir, err := captureNodeFailure(context.Background(), t.TempDir(), "192.0.2.10", time.Now(), nodeBootState{}, errors.New("connection refused"),
+		func(context.Context) ([]byte, error) { return nil, errors.New("requesting dmesg: unavailable") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, failureDmesgName)); !os.IsNotExist(err) {
+		t.Errorf("no dmesg.log is written when the log fetch fails, stat = %v", err)
+	}
+
+	summary, err := os.ReadFile(filepath.Join(dir, failureSummaryName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(summary), "last state: unknown") || !strings.Contains(string(summary), "dmesg: unavailable: requesting dmesg: unavailable") {
+		t.Errorf("summary:\n%s", summary)
+	}
+}
+
+func TestRebootedNodes(t *testing.T) {
+	t.Parallel()
+
+	resp := &machineapi.ApplyConfigurationResponse{Messages: []*machineapi.ApplyConfiguration{
+		{Mode: machineapi.ApplyConfigurationRequest_REBOOT},
+		{Mode: machineapi.ApplyConfigurationRequest_NO_REBOOT},
+	}}
+
+	if got := rebootedNodes(resp, "192.0.2.10"); len(got) != 1 || got[0] != "192.0.2.10" {
+		t.Errorf("rebooted = %v, want only the node that reported reboot", got)
+	}
+}
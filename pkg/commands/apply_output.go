@@ -0,0 +1,185 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// appliedConfigSuffix and appliedSummarySuffix name the two files
+// --output-dir receives per node: the exact config that was sent and
+// what the node reported back for it.
+const (
+	appliedConfigSuffix  = ".yaml"
+	appliedSummarySuffix = ".summary.json"
+)
+
+// appliedNode is what one node reported back for an apply: the mode it
+// actually used (AUTO resolves to reboot or no-reboot on the node), the
+// mode details sentence and the warnings, which is where Talos reports
+// fields it accepted but ignored or deprecated.
+type appliedNode struct {
+	Node     string   `json:"node"`
+	Mode     string   `json:"mode"`
+	Details  string   `json:"details,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// applySummary ties the per-node outcomes to the rendered config they
+// answer, identified by its SHA-256 so it can be matched against a later
+// `talm template` render or the on-node config.
+type applySummary struct {
+	ConfigSHA256 string        `json:"configSHA256"`
+	Nodes        []appliedNode `json:"nodes"`
+}
+
+// buildApplySummary reads the apply response into a summary. Details and
+// warnings go through redactValuesInText with the same secret value set
+// as the printed results, so the summary never carries more than the
+// terminal output did. node names the target when a message carries no
+// metadata (the maintenance client and single-node calls); the
+// multi-node direct-patch call is told apart by each message's hostname.
+func buildApplySummary(resp *machineapi.ApplyConfigurationResponse, rendered []byte, values map[string]struct{}, node string) applySummary {
+	sum := sha256.Sum256(rendered)
+	summary := applySummary{ConfigSHA256: hex.EncodeToString(sum[:])}
+
+	for _, message := range resp.GetMessages() {
+		applied := appliedNode{
+			Node:    cmp.Or(message.GetMetadata().GetHostname(), node, "default node"),
+			Mode:    applyModeName(message.GetMode()),
+			Details: redactValuesInText(strings.TrimSpace(message.GetModeDetails()), values),
+		}
+
+		for _, warning := range message.GetWarnings() {
+			applied.Warnings = append(applied.Warnings, redactValuesInText(warning, values))
+		}
+
+		summary.Nodes = append(summary.Nodes, applied)
+	}
+
+	return summary
+}
+
+// applyModeName renders the mode the node used the way --mode spells
+// it: NO_REBOOT becomes no-reboot.
+func applyModeName(mode machineapi.ApplyConfigurationRequest_Mode) string {
+	return strings.ReplaceAll(strings.ToLower(mode.String()), "_", "-")
+}
+
+// writeApplySummary prints one line per node: the config hash, the mode
+// the node used and how many warnings it returned. The warnings and mode
+// details themselves were already printed by printApplyResultsRedacted
+// just above, so they are counted here rather than repeated.
+func writeApplySummary(w io.Writer, summary applySummary) {
+	for _, node := range summary.Nodes {
+		line := fmt.Sprintf("- talm: applied config sha256:%s on %s: mode %s", summary.ConfigSHA256, node.Node, node.Mode)
+
+		switch len(node.Warnings) {
+		case 0:
+		case 1:
+			line += ", 1 warning"
+		default:
+			line += fmt.Sprintf(", %d warnings", len(node.Warnings))
+		}
+
+		_, _ = fmt.Fprintln(w, line)
+	}
+}
+
+// writeApplyOutputDir writes, per node, the rendered config that was
+// sent as <node>.yaml and the summary as <node>.summary.json. The config
+// carries the cluster secrets, so both files are written owner-only via
+// secureperm. Node names are made file-name safe, so an IPv6 address
+// does not put a colon in the name.
+func writeApplyOutputDir(dir string, summary applySummary, rendered []byte) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "creating output directory %s", dir)
+	}
+
+	for _, node := range summary.Nodes {
+		base := filepath.Join(dir, appliedFileName(node.Node))
+
+		if err := secureperm.WriteFile(base+appliedConfigSuffix, rendered); err != nil {
+			return errors.Wrapf(err, "writing the applied config of %s", node.Node)
+		}
+
+		data, err := json.MarshalIndent(applySummary{ConfigSHA256: summary.ConfigSHA256, Nodes: []appliedNode{node}}, "", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "encoding the apply summary of %s", node.Node)
+		}
+
+		if err := secureperm.WriteFile(base+appliedSummarySuffix, append(data, '\n')); err != nil {
+			return errors.Wrapf(err, "writing the apply summary of %s", node.Node)
+		}
+	}
+
+	return nil
+}
+
+// appliedFileName maps a node name to a file name, replacing every
+// character outside letters, digits, dot, dash and underscore.
+func appliedFileName(node string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, node)
+}
+
+// reportApplied emits the post-apply summary to stderr and, with
+// --output-dir, the per-node files. A dry run changes nothing on the
+// node, so beyond the diff it printed it is only recorded for --output
+// json or yaml, where the mode the node would use is worth reporting.
+// Either way the warnings join the deprecations summary of the run.
+func reportApplied(resp *machineapi.ApplyConfigurationResponse, rendered []byte, values map[string]struct{}, node string) error {
+	summary := buildApplySummary(resp, rendered, values, node)
+	currentJournal().noteApplied(summary)
+	currentApplyWarnings().note(summary)
+
+	if applyCmdFlags.dryRun {
+		return nil
+	}
+
+	writeApplySummary(os.Stderr, summary)
+
+	if applyCmdFlags.outputDir == "" {
+		return nil
+	}
+
+	if err := writeApplyOutputDir(applyCmdFlags.outputDir, summary, rendered); err != nil {
+		return err
+	}
+
+	ui.Infof(os.Stderr, "- talm: applied config and summary written to %s", applyCmdFlags.outputDir)
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+// appliedResponse answers a two-node apply: one node applied without a
+// reboot and ignored a field, the other carries no metadata.
+func appliedResponse() *machineapi.ApplyConfigurationResponse {
+	return &machineapi.ApplyConfigurationResponse{Messages: []*machineapi.ApplyConfiguration{
+		{
+			Metadata:    &common.Metadata{Hostname: "2001:db8::10"},
+			Mode:        machineapi.ApplyConfigurationRequest_NO_REBOOT,
+			ModeDetails: "Applied configuration without a reboot\n",
+			Warnings:    []string{"machine.token s3cr3t is ignored", "cluster.proxy is deprecated"},
+		},
+		{Mode: machineapi.ApplyConfigurationRequest_REBOOT},
+	}}
+}
+
+func TestBuildApplySummary(t *testing.T) {
+	t.Parallel()
+
+	summary := buildApplySummary(appliedResponse(), []byte("machine: {}\n"), map[string]struct{}{"s3cr3t": {}}, "192.0.2.11")
+
+	// sha256 of "machine: {}\n".
+	if summary.ConfigSHA256 != "2a5c1276ba1c0a483d83c671b8276645063d9bdb257415a6b259223fffdeb619" {
+		t.Errorf("hash = %q", summary.ConfigSHA256)
+	}
+
+	if len(summary.Nodes) != 2 {
+		t.Fatalf("nodes = %+v", summary.Nodes)
+	}
+
+	first, second := summary.Nodes[0], summary.Nodes[1]
+	if first.Node != "2001:db8::10" || first.Mode != "no-reboot" || first.Details != "Applied configuration without a reboot" {
+		t.Errorf("first node = %+v", first)
+	}
+
+	if len(first.Warnings) != 2 || first.Warnings[0] != "machine.token *** is ignored" {
+		t.Errorf("warnings must be kept and redacted, got %q", first.Warnings)
+	}
+
+	if second.Node != "192.0.2.11" || second.Mode != "reboot" {
+		t.Errorf("a message without metadata takes the fallback node, got %+v", second)
+	}
+
+	if got := buildApplySummary(appliedResponse(), nil, nil, "").Nodes[1].Node; got != "default node" {
+		t.Errorf("no fallback node = %q", got)
+	}
+}
+
+func TestWriteApplySummary(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	writeApplySummary(&out, applySummary{ConfigSHA256: "abc", Nodes: []appliedNode{
+		{Node: "192.0.2.10", Mode: "no-reboot", Warnings: []string{"a", "b"}},
+		{Node: "192.0.2.11", Mode: "reboot", Warnings: []string{"a"}},
+		{Node: "192.0.2.12", Mode: "staged"},
+	}})
+
+	want := "- talm: applied config sha256:abc on 192.0.2.10: mode no-reboot, 2 warnings\n" +
+		"- talm: applied config sha256:abc on 192.0.2.11: mode reboot, 1 warning\n" +
+		"- talm: applied config sha256:abc on 192.0.2.12: mode staged\n"
+	if out.String() != want {
+		t.Errorf("summary = %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteApplyOutputDir(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "applied")
+	rendered := []byte("machine:\n  token: s3cr3t\n")
+	summary := buildApplySummary(appliedResponse(), rendered, nil, "192.0.2.11")
+
+	if err := writeApplyOutputDir(dir, summary, rendered); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := os.ReadFile(filepath.Join(dir, "2001_db8__10.yaml"))
+	if err != nil || !bytes.Equal(config, rendered) {
+		t.Fatalf("applied config = %q, %v", config, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "192.0.2.11.summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got applySummary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ConfigSHA256 != summary.ConfigSHA256 || len(got.Nodes) != 1 || got.Nodes[0].Node != "192.0.2.11" || got.Nodes[0].Mode != "reboot" {
+		t.Errorf("summary file = %s", data)
+	}
+
+	if !strings.HasSuffix(string(data), "\n") {
+		t.Error("the summary file must end with a newline")
+	}
+}
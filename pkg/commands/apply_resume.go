@@ -0,0 +1,214 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/state"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// applyResumeHistoryLimit is how many of the most recent history
+// records --resume searches for the apply it continues.
+const applyResumeHistoryLimit = 100
+
+// validateApplyResume rejects flag combinations --resume and
+// --continue-on-error cannot honour. --resume takes a single file: the
+// history keys an apply by its first file only, so it cannot tell
+// whether the nodes it completed on got the side-patches given now.
+func validateApplyResume(files []string) error {
+	if applyCmdFlags.resume && len(files) > 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("--resume takes a single node file, got %d", len(files)),
+			"the history does not record side-patches, so a resume could skip nodes that got a different config; rerun the apply of %s without --resume, or resume it without the side-patches", files[0],
+		)
+	}
+
+	if applyCmdFlags.continueOnError && applyCmdFlags.canary.enabled() {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--continue-on-error cannot be used with --strategy canary"),
+			"a canary rollout stops at the first failed group by design; drop one of the flags",
+		)
+	}
+
+	return nil
+}
+
+// resolveApplyResume finds the apply of file that --resume continues
+// and keeps the nodes it completed on in applyCmdFlags.resumeApplied.
+// It reports true when that apply succeeded, leaving nothing to do.
+func resolveApplyResume(file string) (bool, error) {
+	backend, err := openProjectState()
+	if err != nil {
+		return false, err
+	}
+
+	records, err := state.ReadHistory(context.Background(), backend, applyResumeHistoryLimit)
+	if err != nil {
+		return false, err //nolint:wrapcheck // state errors carry the key and backend location.
+	}
+
+	rec, ok := lastApplyRecord(records, file)
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return false, errors.WithHintf(
+			errors.Newf("--resume: no apply of %s in the last %d records of %s", file, applyResumeHistoryLimit, backend.Describe()),
+			"run the apply without --resume; `talm state history` lists the recorded applies",
+		)
+	}
+
+	if rec.Error == "" {
+		ui.Infof(os.Stderr, "The last apply of %s (%s) succeeded; nothing to resume", file, rec.ID)
+
+		return true, nil
+	}
+
+	applyCmdFlags.resumeApplied = appliedNodes(rec)
+
+	if len(applyCmdFlags.resumeApplied) == 0 {
+		ui.Infof(os.Stderr, "Resuming the apply of %s (%s): it completed on no node", file, rec.ID)
+	} else {
+		ui.Infof(os.Stderr, "Resuming the apply of %s (%s): skipping %s, which it completed on", file, rec.ID, strings.Join(applyCmdFlags.resumeApplied, ", "))
+	}
+
+	return false, nil
+}
+
+// lastApplyRecord returns the most recent apply of file in records,
+// which are oldest first. Paths are compared resolved, so a relative
+// and an absolute spelling of the same file match.
+func lastApplyRecord(records []state.Record, file string) (state.Record, bool) {
+	want := absPath(file)
+
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Operation == "apply" && absPath(records[i].File) == want {
+			return records[i], true
+		}
+	}
+
+	return state.Record{}, false
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return filepath.Clean(path)
+}
+
+// appliedNodes lists the nodes rec completed on, including those it
+// skipped as completed by the apply it resumed.
+func appliedNodes(rec state.Record) []string {
+	var nodes []string
+
+	for _, change := range rec.Changes {
+		if change.Applied {
+			nodes = append(nodes, change.Node)
+		}
+	}
+
+	return nodes
+}
+
+// pendingApplyNodes drops from nodes those the resumed apply completed
+// on and notes them in the journal as such. It reports true when a
+// resume leaves no node to apply.
+func pendingApplyNodes(configFile string, nodes []string) ([]string, bool) {
+	if len(applyCmdFlags.resumeApplied) == 0 {
+		return nodes, false
+	}
+
+	pending := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		if slices.Contains(applyCmdFlags.resumeApplied, node) {
+			currentJournal().noteResumed(node)
+
+			continue
+		}
+
+		pending = append(pending, node)
+	}
+
+	if len(pending) == 0 && len(nodes) > 0 {
+		ui.Infof(os.Stderr, "Every node of %s already has the config; nothing to resume", configFile)
+
+		return nil, true
+	}
+
+	return pending, false
+}
+
+// applyNodeResult is the outcome of one node of a multi-node apply.
+type applyNodeResult struct {
+	node string
+	err  error
+}
+
+// reportApplyResults prints the outcome of every node of a
+// --continue-on-error apply as a table and returns the failures.
+func reportApplyResults(w io.Writer, results []applyNodeResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tRESULT")
+
+	var failed []error
+
+	for _, res := range results {
+		result := "ok"
+		if res.err != nil {
+			result = "failed: " + firstLine(res.err.Error())
+			failed = append(failed, errors.Wrapf(res.err, "node %s", res.node))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\n", res.node, result)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "writing the apply summary")
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Wrapf(errors.Join(failed...), "%d of %d nodes failed", len(failed), len(results)),
+		"fix the failures and rerun with --resume to apply only the nodes that did not get the config",
+	)
+}
+
+// warnUnattemptedNodes points at --resume when an apply stopped at a
+// failed node before reaching the rest.
+func warnUnattemptedNodes(w io.Writer, rest []string) {
+	if len(rest) == 0 || applyCmdFlags.dryRun {
+		return
+	}
+
+	ui.Warnf(w, "%d node(s) were not attempted: %s; fix the failure and rerun with --resume to continue, or pass --continue-on-error to try every node", len(rest), strings.Join(rest, ", "))
+}
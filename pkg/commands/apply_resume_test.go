@@ -0,0 +1,318 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/state"
+)
+
+// withApplyResumeFlags restores the --resume and --continue-on-error
+// state of applyCmdFlags after the test.
+func withApplyResumeFlags(t *testing.T) {
+	t.Helper()
+
+	resume, continueOnError, applied, canary := applyCmdFlags.resume, applyCmdFlags.continueOnError, applyCmdFlags.resumeApplied, applyCmdFlags.canary
+
+	t.Cleanup(func() {
+		applyCmdFlags.resume, applyCmdFlags.continueOnError, applyCmdFlags.resumeApplied, applyCmdFlags.canary = resume, continueOnError, applied, canary
+	})
+
+	applyCmdFlags.resume = false
+	applyCmdFlags.continueOnError = false
+	applyCmdFlags.resumeApplied = nil
+	applyCmdFlags.canary = canaryOptions{}
+}
+
+// perNodeApply returns render and apply steps for applyTemplatesPerNode
+// whose apply fails on the nodes in failing and records the nodes it
+// was called for.
+func perNodeApply(t *testing.T, failing ...string) (renderFunc, applyFunc, *[]string) {
+	t.Helper()
+
+	var applied []string
+
+	render := func(_ context.Context, _ *client.Client, _ engine.Options) ([]byte, error) {
+		return []byte("version: v1alpha1\nmachine:\n  type: worker\n"), nil
+	}
+	apply := func(ctx context.Context, _ *client.Client, _ []byte) error {
+		node := nodesFromOutgoingCtx(ctx, t)[0]
+		applied = append(applied, node)
+
+		if slices.Contains(failing, node) {
+			return errors.New("connection refused")
+		}
+
+		return nil
+	}
+
+	return render, apply, &applied
+}
+
+func writeModelineOnlyNodeFile(t *testing.T) string {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "node.yaml")
+	if err := os.WriteFile(configFile, []byte("# talm: nodes=[\"a\",\"b\",\"c\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return configFile
+}
+
+func TestLastApplyRecord(t *testing.T) {
+	t.Parallel()
+
+	records := []state.Record{
+		{ID: "1", Operation: "apply", File: "nodes/cp1.yaml"},
+		{ID: "2", Operation: "upgrade", File: "nodes/cp1.yaml"},
+		{ID: "3", Operation: "apply", File: "nodes/cp2.yaml"},
+	}
+
+	abs, err := filepath.Abs("nodes/cp1.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"nodes/cp1.yaml", "./nodes/cp1.yaml", abs} {
+		if rec, ok := lastApplyRecord(records, file); !ok || rec.ID != "1" {
+			t.Errorf("lastApplyRecord(%q) = %+v, %v, want record 1", file, rec, ok)
+		}
+	}
+
+	if _, ok := lastApplyRecord(records, "nodes/w1.yaml"); ok {
+		t.Error("a file never applied must have no record")
+	}
+}
+
+// TestApplyResume pins the round trip of a failed multi-node apply: the
+// history keeps which nodes it completed on, --resume skips exactly
+// those, and the resumed run records them too so that it can be resumed
+// again or reported as complete.
+func TestApplyResume(t *testing.T) {
+	backend := withStateProject(t)
+	withApplyResumeFlags(t)
+
+	configFile := writeModelineOnlyNodeFile(t)
+	nodes := []string{testNodeAddrA, testNodeAddrB, testNodeAddrC}
+
+	render, apply, applied := perNodeApply(t, testNodeAddrB)
+
+	err := withApplyState(configFile, false, func() error {
+		GlobalArgs.Nodes = nodes
+
+		return applyTemplatesPerNode(engine.Options{}, configFile, nil, nodes, fakeAuthOpenClient(context.Background()), render, apply)
+	})
+	if err == nil || !strings.Contains(err.Error(), "node "+testNodeAddrB) {
+		t.Fatalf("err = %v, want the failure of %s", err, testNodeAddrB)
+	}
+
+	if want := []string{testNodeAddrA, testNodeAddrB}; !slices.Equal(*applied, want) {
+		t.Fatalf("applied to %v, want the apply to stop at the failed node", *applied)
+	}
+
+	done, err := resolveApplyResume(configFile)
+	if err != nil || done {
+		t.Fatalf("resolveApplyResume = %v, %v", done, err)
+	}
+
+	if want := []string{testNodeAddrA}; !slices.Equal(applyCmdFlags.resumeApplied, want) {
+		t.Fatalf("resumeApplied = %v, want %v", applyCmdFlags.resumeApplied, want)
+	}
+
+	render, apply, applied = perNodeApply(t)
+
+	err = withApplyState(configFile, false, func() error {
+		pending, done := pendingApplyNodes(configFile, nodes)
+		if done {
+			t.Fatal("two nodes are left to apply")
+		}
+
+		return applyTemplatesPerNode(engine.Options{}, configFile, nil, pending, fakeAuthOpenClient(context.Background()), render, apply)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{testNodeAddrB, testNodeAddrC}; !slices.Equal(*applied, want) {
+		t.Errorf("resumed apply went to %v, want %v", *applied, want)
+	}
+
+	records, err := state.ReadHistory(context.Background(), backend, 0)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	if got := appliedNodes(records[1]); !slices.Equal(got, nodes) {
+		t.Errorf("the resumed record completes on %v, want %v", got, nodes)
+	}
+
+	if !records[1].Changes[0].Resumed || records[1].Changes[1].Resumed {
+		t.Errorf("only the skipped node is marked resumed: %+v", records[1].Changes)
+	}
+
+	if done, err := resolveApplyResume(configFile); err != nil || !done {
+		t.Errorf("after a successful resume there is nothing left, got %v, %v", done, err)
+	}
+}
+
+func TestResolveApplyResume_NoHistory(t *testing.T) {
+	withStateProject(t)
+	withApplyResumeFlags(t)
+
+	_, err := resolveApplyResume("nodes/cp1.yaml")
+	if err == nil || !strings.Contains(err.Error(), "no apply of nodes/cp1.yaml") {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hint := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hint, "without --resume") {
+		t.Errorf("hint = %q", hint)
+	}
+}
+
+func TestPendingApplyNodes(t *testing.T) {
+	withApplyResumeFlags(t)
+
+	nodes := []string{testNodeAddrA, testNodeAddrB}
+
+	if got, done := pendingApplyNodes("node.yaml", nodes); done || !slices.Equal(got, nodes) {
+		t.Errorf("without --resume every node is pending, got %v, %v", got, done)
+	}
+
+	if got, done := pendingApplyNodes("node.yaml", nil); done || got != nil {
+		t.Errorf("no nodes must stay for applyTemplatesPerNode to reject, got %v, %v", got, done)
+	}
+
+	applyCmdFlags.resumeApplied = []string{testNodeAddrA, testNodeAddrB}
+
+	if got, done := pendingApplyNodes("node.yaml", nodes); !done || len(got) != 0 {
+		t.Errorf("a resume with every node applied is done, got %v, %v", got, done)
+	}
+}
+
+// TestApplyTemplatesPerNode_ContinueOnError pins that every node is
+// attempted and that the error names each failed one.
+func TestApplyTemplatesPerNode_ContinueOnError(t *testing.T) {
+	withApplyResumeFlags(t)
+
+	applyCmdFlags.continueOnError = true
+
+	configFile := writeModelineOnlyNodeFile(t)
+	nodes := []string{testNodeAddrA, testNodeAddrB, testNodeAddrC}
+	render, apply, applied := perNodeApply(t, testNodeAddrA, testNodeAddrC)
+
+	err := applyTemplatesPerNode(engine.Options{}, configFile, nil, nodes, fakeAuthOpenClient(context.Background()), render, apply)
+	if err == nil {
+		t.Fatal("expected the failures to be reported")
+	}
+
+	if !slices.Equal(*applied, nodes) {
+		t.Errorf("applied to %v, want every node attempted", *applied)
+	}
+
+	for _, want := range []string{"2 of 3 nodes failed", "node " + testNodeAddrA, "node " + testNodeAddrC} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to mention %q", err, want)
+		}
+	}
+
+	if strings.Contains(err.Error(), "node "+testNodeAddrB+":") {
+		t.Errorf("a node that succeeded must not be reported failed: %v", err)
+	}
+}
+
+func TestReportApplyResults(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	err := reportApplyResults(&out, []applyNodeResult{
+		{node: "192.0.2.10"},
+		{node: "192.0.2.11", err: errors.New("rendering: missing value\ndetails")},
+	})
+
+	want := "NODE         RESULT\n192.0.2.10   ok\n192.0.2.11   failed: rendering: missing value\n"
+	if out.String() != want {
+		t.Errorf("summary =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if hint := strings.Join(errors.GetAllHints(err), "\n"); err == nil || !strings.Contains(hint, "--resume") {
+		t.Errorf("err = %v, hint = %q; want the failure and a pointer at --resume", err, hint)
+	}
+
+	out.Reset()
+
+	if err := reportApplyResults(&out, []applyNodeResult{{node: "192.0.2.10"}}); err != nil {
+		t.Errorf("all nodes ok must not fail, got %v", err)
+	}
+}
+
+func TestValidateApplyResume(t *testing.T) {
+	withApplyResumeFlags(t)
+
+	files := []string{"nodes/cp1.yaml"}
+
+	applyCmdFlags.continueOnError = true
+	if err := validateApplyResume(files); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	applyCmdFlags.canary = canaryOptions{strategy: rolloutStrategyCanary}
+	if err := validateApplyResume(files); err == nil || !strings.Contains(err.Error(), "--strategy canary") {
+		t.Errorf("--continue-on-error with a canary must be refused, got %v", err)
+	}
+
+	applyCmdFlags.continueOnError = false
+	applyCmdFlags.resume = true
+
+	if err := validateApplyResume(files); err != nil {
+		t.Errorf("--resume with one file: unexpected error: %v", err)
+	}
+
+	if err := validateApplyResume(append(files, "patches/extra.yaml")); err == nil || !strings.Contains(err.Error(), "single node file") {
+		t.Errorf("--resume with side-patches must be refused, got %v", err)
+	}
+}
+
+func TestOperationJournal_NoteResult(t *testing.T) {
+	t.Parallel()
+
+	journal := newOperationJournal(nil)
+	journal.noteResumed("192.0.2.10")
+	journal.noteResult("192.0.2.11", errors.New("timeout"))
+	journal.noteResult("192.0.2.11", nil)
+	journal.noteResult("192.0.2.12", errors.New("timeout"))
+
+	want := []state.NodeChange{
+		{Node: "192.0.2.10", Applied: true, Resumed: true},
+		{Node: "192.0.2.11", Applied: true},
+		{Node: "192.0.2.12", Error: "timeout"},
+	}
+	if got := journal.nodeChanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// configDryRunner sends a dry-run ApplyConfiguration to the node ctx
+// targets and returns what the node answered.
+type configDryRunner func(ctx context.Context, req *machineapi.ApplyConfigurationRequest) (*machineapi.ApplyConfigurationResponse, error)
+
+// talosConfigDryRunner is the configDryRunner of a Talos client.
+func talosConfigDryRunner(c *client.Client) configDryRunner {
+	return func(ctx context.Context, req *machineapi.ApplyConfigurationRequest) (*machineapi.ApplyConfigurationResponse, error) {
+		//nolint:wrapcheck // the node's answer is surfaced verbatim by serverValidateConfig.
+		return c.ApplyConfiguration(ctx, req)
+	}
+}
+
+// serverValidateConfig runs the --server-side-validate gate: the
+// rendered config is sent to the node as a dry-run apply, so the
+// node's own Talos decodes and validates it before anything changes.
+// That catches what talm's vendored machinery accepts but the node's
+// version does not (a field added or removed between the two, a
+// stricter validation), and the reverse.
+//
+// A rejection fails the apply with the node's message verbatim, and
+// the node's warnings are printed as they are. A node whose API has no
+// dry-run apply is reported and skipped, since there is nothing to ask.
+func serverValidateConfig(ctx context.Context, dryRun configDryRunner, rendered []byte, nodeID string, w io.Writer) error {
+	req := buildApplyConfigurationRequest(rendered)
+	req.DryRun = true
+
+	resp, err := dryRun(ctx, req)
+	if err != nil {
+		st := status.Convert(err)
+		if st.Code() == codes.Unimplemented {
+			ui.Warnf(w, "node %s cannot validate the config server-side: %s; relying on local validation", nodeID, st.Message())
+
+			return nil
+		}
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("node %s rejected the rendered config: %s", nodeID, st.Message()),
+			"the node validates with the machinery of its own Talos version; fix the config, or render for that version with --talos-version",
+		)
+	}
+
+	for _, msg := range resp.GetMessages() {
+		for _, warning := range msg.GetWarnings() {
+			ui.Warnf(w, "node %s: %s", nodeID, warning)
+		}
+	}
+
+	return nil
+}
+
+// shouldRunServerValidate reports whether the gate runs: it is asked
+// for, and the apply is not already a dry run, whose own answer is the
+// same validation.
+func shouldRunServerValidate(enabled, dryRun bool) bool {
+	return enabled && !dryRun
+}
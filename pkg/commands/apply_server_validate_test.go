@@ -0,0 +1,118 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestServerValidateConfig_SendsDryRun pins the request: the rendered
+// bytes, as a dry run, and the node's warnings printed verbatim.
+func TestServerValidateConfig_SendsDryRun(t *testing.T) {
+	t.Parallel()
+
+	var sent *machineapi.ApplyConfigurationRequest
+
+	dryRun := func(_ context.Context, req *machineapi.ApplyConfigurationRequest) (*machineapi.ApplyConfigurationResponse, error) {
+		sent = req
+
+		return &machineapi.ApplyConfigurationResponse{Messages: []*machineapi.ApplyConfiguration{{
+			Warnings: []string{"\".machine.install.extensions\" is deprecated, use ExtensionServiceConfig"},
+		}}}, nil
+	}
+
+	var out bytes.Buffer
+
+	if err := serverValidateConfig(context.Background(), dryRun, []byte("version: v1alpha1\n"), "10.0.0.1", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent == nil || !sent.GetDryRun() || string(sent.GetData()) != "version: v1alpha1\n" {
+		t.Fatalf("request = %v, want the rendered config as a dry run", sent)
+	}
+
+	want := "Warning: node 10.0.0.1: \".machine.install.extensions\" is deprecated, use ExtensionServiceConfig\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+// TestServerValidateConfig_Rejected pins that the node's rejection
+// fails the gate with the node's own message and no gRPC wrapping.
+func TestServerValidateConfig_Rejected(t *testing.T) {
+	t.Parallel()
+
+	dryRun := func(context.Context, *machineapi.ApplyConfigurationRequest) (*machineapi.ApplyConfigurationResponse, error) {
+		return nil, status.Error(codes.InvalidArgument, "1 error occurred:\n\t* unknown keys found during decoding:\nmachine:\n  foo: bar\n")
+	}
+
+	err := serverValidateConfig(context.Background(), dryRun, nil, "10.0.0.1", &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("a rejected config must fail the gate")
+	}
+
+	if !strings.Contains(err.Error(), "node 10.0.0.1 rejected the rendered config: 1 error occurred") || strings.Contains(err.Error(), "rpc error") {
+		t.Errorf("error = %q, want the node message verbatim", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "--talos-version") {
+		t.Errorf("hints = %q", hints)
+	}
+}
+
+// TestServerValidateConfig_Unimplemented pins the graceful skip for a
+// node whose API has no dry-run apply.
+func TestServerValidateConfig_Unimplemented(t *testing.T) {
+	t.Parallel()
+
+	dryRun := func(context.Context, *machineapi.ApplyConfigurationRequest) (*machineapi.ApplyConfigurationResponse, error) {
+		return nil, status.Error(codes.Unimplemented, "dry run is not supported")
+	}
+
+	var out bytes.Buffer
+
+	if err := serverValidateConfig(context.Background(), dryRun, nil, "10.0.0.1", &out); err != nil {
+		t.Fatalf("an unsupported dry run must not fail the apply, got %v", err)
+	}
+
+	if !strings.Contains(out.String(), "relying on local validation") {
+		t.Errorf("output = %q, want the skip reported", out.String())
+	}
+}
+
+func TestShouldRunServerValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		enabled, dryRun, want bool
+	}{
+		{false, false, false},
+		{true, false, true},
+		{true, true, false},
+		{false, true, false},
+	} {
+		if got := shouldRunServerValidate(tc.enabled, tc.dryRun); got != tc.want {
+			t.Errorf("shouldRunServerValidate(%v, %v) = %v, want %v", tc.enabled, tc.dryRun, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ExitCodeTimeout is the process exit code for a command that failed
+// because an apply deadline expired. 124 matches coreutils timeout(1)
+// so wrapper scripts can tell "ran out of time" apart from "the node
+// rejected the config" (exit 1) without parsing stderr.
+const ExitCodeTimeout = 124
+
+// ErrApplyTimeout marks an apply that failed because the per-node
+// deadline (applyOptions.timeout / --node-timeout) or one of the
+// per-phase deadlines (applyOptions.phaseTimeouts) expired. main
+// checks it with errors.Is to exit with ExitCodeTimeout.
+var ErrApplyTimeout = errors.New("apply timed out")
+
+// Apply phases that carry their own deadline. Rendering is a phase
+// because chart lookups read live node state through the context.
+const (
+	applyPhaseRender    = "render"
+	applyPhasePreflight = "preflight"
+	applyPhaseApply     = "apply"
+	applyPhaseVerify    = "verify"
+)
+
+// applyTimeouts is the resolved deadline set for one apply run. A zero
+// duration disables the corresponding deadline.
+type applyTimeouts struct {
+	node      time.Duration
+	render    time.Duration
+	preflight time.Duration
+	apply     time.Duration
+	verify    time.Duration
+}
+
+// currentApplyTimeouts assembles the deadlines for this invocation:
+// the per-node budget from --node-timeout (seeded from
+// applyOptions.timeout) and the per-phase budgets from
+// applyOptions.phaseTimeouts.
+func currentApplyTimeouts() applyTimeouts {
+	return applyTimeouts{
+		node:      applyCmdFlags.nodeTimeout,
+		render:    Config.ApplyOptions.PhaseTimeouts.RenderDuration,
+		preflight: Config.ApplyOptions.PhaseTimeouts.PreflightDuration,
+		apply:     Config.ApplyOptions.PhaseTimeouts.ApplyDuration,
+		verify:    Config.ApplyOptions.PhaseTimeouts.VerifyDuration,
+	}
+}
+
+func (t applyTimeouts) phase(name string) time.Duration {
+	switch name {
+	case applyPhaseRender:
+		return t.render
+	case applyPhasePreflight:
+		return t.preflight
+	case applyPhaseApply:
+		return t.apply
+	case applyPhaseVerify:
+		return t.verify
+	default:
+		return 0
+	}
+}
+
+// withNodeDeadline derives the per-node context every phase of one
+// node's apply runs under. The deadline is total: render, preflight,
+// ApplyConfiguration and post-apply verification all draw from the
+// same budget, so a slow preflight leaves less time for the apply
+// rather than extending the node's wall-clock time.
+func withNodeDeadline(ctx context.Context, t applyTimeouts) (context.Context, context.CancelFunc) {
+	if t.node <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, t.node)
+}
+
+// runApplyPhase runs fn under the phase deadline derived from nodeCtx
+// and classifies a failure caused by an expired deadline. The node
+// deadline is checked first: when both expired, the per-node budget is
+// the limit the operator has to raise. Errors unrelated to a deadline
+// are returned unchanged. Callers add the node name, as they do for
+// every other per-node failure.
+func runApplyPhase(nodeCtx context.Context, phase string, t applyTimeouts, fn func(context.Context) error) error {
+	phaseCtx, cancel := nodeCtx, context.CancelFunc(func() {})
+	if limit := t.phase(phase); limit > 0 {
+		phaseCtx, cancel = context.WithTimeout(nodeCtx, limit)
+	}
+	defer cancel()
+
+	err := fn(phaseCtx)
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(nodeCtx.Err(), context.DeadlineExceeded) && t.node > 0:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Mark(errors.Wrapf(err, "%s phase ran past the %s per-node deadline", phase, t.node), ErrApplyTimeout),
+			"raise applyOptions.timeout in Chart.yaml or pass --node-timeout (0 disables the deadline); the budget covers every phase of one node's apply",
+		)
+	case errors.Is(phaseCtx.Err(), context.DeadlineExceeded):
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Mark(errors.Wrapf(err, "%s phase exceeded its %s deadline", phase, t.phase(phase)), ErrApplyTimeout),
+			"raise applyOptions.phaseTimeouts.%s in Chart.yaml (0 or unset disables the phase deadline)", phase,
+		)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// waitForDeadline blocks until ctx is done and returns its error, the
+// way a gRPC call does when its deadline expires.
+func waitForDeadline(ctx context.Context) error {
+	<-ctx.Done()
+
+	return errors.Wrap(ctx.Err(), "rpc")
+}
+
+// TestRunApplyPhase_PhaseDeadline pins that an expired phase deadline
+// is marked ErrApplyTimeout and the hint names the phase key.
+func TestRunApplyPhase_PhaseDeadline(t *testing.T) {
+	t.Parallel()
+
+	timeouts := applyTimeouts{apply: 10 * time.Millisecond}
+
+	err := runApplyPhase(context.Background(), applyPhaseApply, timeouts, waitForDeadline)
+	if !errors.Is(err, ErrApplyTimeout) {
+		t.Fatalf("expected ErrApplyTimeout, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "apply phase exceeded its 10ms deadline") {
+		t.Errorf("error must name the phase and its limit, got %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "applyOptions.phaseTimeouts.apply") {
+		t.Errorf("hint must name the phase key, got %q", hints)
+	}
+}
+
+// TestRunApplyPhase_NodeDeadline pins that an expired per-node
+// deadline is attributed to the node budget even when the phase has
+// its own, longer limit.
+func TestRunApplyPhase_NodeDeadline(t *testing.T) {
+	t.Parallel()
+
+	timeouts := applyTimeouts{node: 10 * time.Millisecond, verify: time.Hour}
+
+	nodeCtx, cancel := withNodeDeadline(context.Background(), timeouts)
+	defer cancel()
+
+	err := runApplyPhase(nodeCtx, applyPhaseVerify, timeouts, waitForDeadline)
+	if !errors.Is(err, ErrApplyTimeout) {
+		t.Fatalf("expected ErrApplyTimeout, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "per-node deadline") {
+		t.Errorf("error must blame the per-node deadline, got %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "--node-timeout") {
+		t.Errorf("hint must name --node-timeout, got %q", hints)
+	}
+}
+
+// TestRunApplyPhase_OtherErrorsUnchanged pins that failures unrelated
+// to a deadline keep their identity and never exit as a timeout.
+func TestRunApplyPhase_OtherErrorsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	rejected := errors.New("config rejected")
+
+	err := runApplyPhase(context.Background(), applyPhaseApply, applyTimeouts{apply: time.Hour}, func(context.Context) error {
+		return rejected
+	})
+	if !errors.Is(err, rejected) || errors.Is(err, ErrApplyTimeout) {
+		t.Errorf("non-deadline error must pass through unmarked, got %v", err)
+	}
+
+	if err := runApplyPhase(context.Background(), applyPhaseApply, applyTimeouts{}, func(context.Context) error { return nil }); err != nil {
+		t.Errorf("success must return nil, got %v", err)
+	}
+}
+
+// TestRunApplyPhase_UnboundedWithoutLimits pins that zero durations
+// leave the phase context without a deadline.
+func TestRunApplyPhase_UnboundedWithoutLimits(t *testing.T) {
+	t.Parallel()
+
+	timeouts := applyTimeouts{}
+
+	nodeCtx, cancel := withNodeDeadline(context.Background(), timeouts)
+	defer cancel()
+
+	err := runApplyPhase(nodeCtx, applyPhasePreflight, timeouts, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("phase context must carry no deadline when every limit is zero")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// defaultHealthTimeout is how long --wait waits for a node to become
+// healthy when --health-timeout is not given.
+const defaultHealthTimeout = 10 * time.Minute
+
+// kubeReadyReader reads the Ready condition of the Kubernetes Node of
+// a Talos node. An error means the Kubernetes API did not answer.
+type kubeReadyReader func(ctx context.Context, node string) (bool, string, error)
+
+// validateApplyWait rejects --wait where it cannot work: a node in
+// maintenance mode reboots into its config and is no longer reachable
+// through the maintenance connection.
+func validateApplyWait() error {
+	if !applyCmdFlags.wait {
+		return nil
+	}
+
+	if applyCmdFlags.healthTimeout <= 0 {
+		return errors.Newf("--health-timeout must be positive, got %s", applyCmdFlags.healthTimeout)
+	}
+
+	if applyCmdFlags.insecure {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--wait cannot be used with --insecure"),
+			"a node in maintenance mode cannot be polled once it boots into its config; apply without --wait, then run talm apply --wait with the node file once it is installed",
+		)
+	}
+
+	return nil
+}
+
+// applyWaitEnabled reports whether apply waits for each node to be
+// healthy before it moves on. A dry run changes nothing to wait for.
+func applyWaitEnabled() bool {
+	return applyCmdFlags.wait && !applyCmdFlags.dryRun && !applyCmdFlags.insecure
+}
+
+// rebootWaitTimeout is how long apply waits for a node that reboots
+// into the new config: --reboot-timeout, or with --wait alone the
+// health timeout, since a node still going down would pass the health
+// check of its old boot.
+func rebootWaitTimeout() time.Duration {
+	if applyCmdFlags.rebootTimeout > 0 {
+		return applyCmdFlags.rebootTimeout
+	}
+
+	if applyCmdFlags.wait {
+		return applyCmdFlags.healthTimeout
+	}
+
+	return 0
+}
+
+// projectKubeReadyReader reads Node readiness through the project
+// kubeconfig. Without one there is no Kubernetes Node to wait for, and
+// it returns nil.
+func projectKubeReadyReader() kubeReadyReader {
+	if _, err := os.Stat(projectKubeconfigPath()); err != nil {
+		return nil
+	}
+
+	return func(ctx context.Context, node string) (bool, string, error) {
+		ctx, cancel := context.WithTimeout(ctx, preflightCOSIReadTimeout)
+		defer cancel()
+
+		nodes, err := listKubernetesNodes(ctx)
+		if err != nil {
+			return false, "", err
+		}
+
+		for _, kube := range nodes {
+			health := kubeNodeReadiness(kube)
+			if !matchesLiveNode(node, health.node) {
+				continue
+			}
+
+			if health.ready {
+				return true, "", nil
+			}
+
+			return false, fmt.Sprintf("Node %s is not Ready: %s", health.node.name, health.reason), nil
+		}
+
+		return false, "not registered as a Kubernetes Node", nil
+	}
+}
+
+// waitForNodeHealthy polls the node until it is in stage running with
+// every condition met and, when kube is set, its Kubernetes Node is
+// Ready. The error names what was still missing at the timeout.
+func waitForNodeHealthy(ctx context.Context, node string, read nodeBootReader, kube kubeReadyReader, timeout, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	last := "no answer yet"
+
+	for {
+		pending, err := nodeHealthPending(ctx, node, read, kube)
+		if err == nil && pending == "" {
+			return nil
+		}
+
+		// A read cut short by the wait's own deadline says nothing
+		// about the node; keep the last real answer.
+		if ctx.Err() == nil {
+			if err != nil {
+				last = err.Error()
+			} else {
+				last = pending
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Newf("node %s was not healthy within %s: %s", node, timeout, last)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// nodeHealthPending reads the node once and returns what keeps it
+// from being healthy, empty when nothing does.
+func nodeHealthPending(ctx context.Context, node string, read nodeBootReader, kube kubeReadyReader) (string, error) {
+	state, err := read(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if !state.running() {
+		return state.describe(), nil
+	}
+
+	if kube == nil {
+		return "", nil
+	}
+
+	ready, reason, err := kube(ctx, node)
+	if err != nil {
+		return "", errors.Wrap(err, "reading the Kubernetes Node")
+	}
+
+	if !ready {
+		return reason, nil
+	}
+
+	return "", nil
+}
+
+// awaitHealthyNode holds the rollout until the node just applied is
+// healthy, when --wait is set. ctx must target the node alone. Like
+// the reboot wait, it is not bounded by the per-node apply deadline
+// but by its own --health-timeout.
+func awaitHealthyNode(ctx context.Context, c *client.Client, node string, w io.Writer) error {
+	if !applyWaitEnabled() {
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(w, "- talm: waiting up to %s for %s to be healthy\n", applyCmdFlags.healthTimeout, node)
+
+	err := waitForNodeHealthy(context.WithoutCancel(ctx), node, talosBootReader(c), projectKubeReadyReader(), applyCmdFlags.healthTimeout, nodeReturnPollInterval)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(err,
+			"the config is applied but the rollout stopped here; check the node with talm healthcheck --nodes %s, raise --health-timeout if it is still settling, and continue with talm apply --resume --wait once it is healthy",
+			node,
+		)
+	}
+
+	return nil
+}
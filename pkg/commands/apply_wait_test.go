@@ -0,0 +1,157 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// withApplyWaitFlags restores the --wait state of applyCmdFlags after
+// the test.
+func withApplyWaitFlags(t *testing.T) {
+	t.Helper()
+
+	wait, healthTimeout, rebootTimeout, insecure, dryRun := applyCmdFlags.wait, applyCmdFlags.healthTimeout, applyCmdFlags.rebootTimeout, applyCmdFlags.insecure, applyCmdFlags.dryRun
+
+	t.Cleanup(func() {
+		applyCmdFlags.wait, applyCmdFlags.healthTimeout, applyCmdFlags.rebootTimeout, applyCmdFlags.insecure, applyCmdFlags.dryRun = wait, healthTimeout, rebootTimeout, insecure, dryRun
+	})
+
+	applyCmdFlags.wait = false
+	applyCmdFlags.healthTimeout = defaultHealthTimeout
+	applyCmdFlags.rebootTimeout = 0
+	applyCmdFlags.insecure = false
+	applyCmdFlags.dryRun = false
+}
+
+// scriptedKubeReader answers each poll with the next Ready state of
+// script, repeating the last one. A nil entry stands for an API error.
+func scriptedKubeReader(script ...*bool) kubeReadyReader {
+	calls := 0
+
+	return func(context.Context, string) (bool, string, error) {
+		entry := script[min(calls, len(script)-1)]
+		calls++
+
+		if entry == nil {
+			return false, "", errors.New("apiserver unavailable")
+		}
+
+		if !*entry {
+			return false, "Node node1 is not Ready: KubeletNotReady", nil
+		}
+
+		return true, "", nil
+	}
+}
+
+func TestWaitForNodeHealthy(t *testing.T) {
+	t.Parallel()
+
+	running := &nodeBootState{bootTime: 200, stage: "running", ready: true}
+	booting := &nodeBootState{bootTime: 200, stage: "booting", unmet: []string{"services: kubelet not healthy"}}
+	ready, notReady := true, false
+
+	tests := []struct {
+		name    string
+		talos   []*nodeBootState
+		kube    []*bool
+		wantErr string
+	}{
+		{name: "running without kubeconfig", talos: []*nodeBootState{nil, booting, running}},
+		{name: "running and Ready", talos: []*nodeBootState{running}, kube: []*bool{nil, &notReady, &ready}},
+		{name: "stuck booting", talos: []*nodeBootState{booting}, wantErr: "stage booting, waiting for services: kubelet not healthy"},
+		{name: "never answers", talos: []*nodeBootState{nil}, wantErr: "connection refused"},
+		{name: "Node not Ready", talos: []*nodeBootState{running}, kube: []*bool{&notReady}, wantErr: "KubeletNotReady"},
+		{name: "Kubernetes API down", talos: []*nodeBootState{running}, kube: []*bool{nil}, wantErr: "reading the Kubernetes Node: apiserver unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var kube kubeReadyReader
+			if tt.kube != nil {
+				kube = scriptedKubeReader(tt.kube...)
+			}
+
+			err := waitForNodeHealthy(context.Background(), "192.0.2.10", scriptedBootReader(tt.talos...), kube, 50*time.Millisecond, time.Millisecond)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "192.0.2.10 was not healthy within 50ms") {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateApplyWait(t *testing.T) {
+	withApplyWaitFlags(t)
+
+	applyCmdFlags.insecure = true
+	if err := validateApplyWait(); err != nil {
+		t.Errorf("--insecure without --wait must pass, got %v", err)
+	}
+
+	applyCmdFlags.wait = true
+	if err := validateApplyWait(); err == nil || !strings.Contains(err.Error(), "--insecure") {
+		t.Errorf("--wait with --insecure must be refused, got %v", err)
+	}
+
+	applyCmdFlags.insecure = false
+	applyCmdFlags.healthTimeout = 0
+
+	if err := validateApplyWait(); err == nil || !strings.Contains(err.Error(), "--health-timeout") {
+		t.Errorf("a zero --health-timeout must be refused, got %v", err)
+	}
+}
+
+// TestRebootWaitTimeout pins that --wait waits for a rebooted node on
+// its own, and that --reboot-timeout keeps its budget when both are set.
+func TestRebootWaitTimeout(t *testing.T) {
+	withApplyWaitFlags(t)
+
+	if rebootWaitEnabled() {
+		t.Error("no flag must not wait for a reboot")
+	}
+
+	applyCmdFlags.wait = true
+	applyCmdFlags.healthTimeout = 3 * time.Minute
+
+	if got := rebootWaitTimeout(); got != 3*time.Minute || !rebootWaitEnabled() {
+		t.Errorf("--wait alone: timeout = %s, enabled = %v", got, rebootWaitEnabled())
+	}
+
+	applyCmdFlags.rebootTimeout = 20 * time.Minute
+	if got := rebootWaitTimeout(); got != 20*time.Minute {
+		t.Errorf("--reboot-timeout: timeout = %s", got)
+	}
+
+	applyCmdFlags.dryRun = true
+	if rebootWaitEnabled() || applyWaitEnabled() {
+		t.Error("a dry run must not wait")
+	}
+}
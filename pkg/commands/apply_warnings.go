@@ -0,0 +1,139 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// applyWarnings gathers the warnings the nodes of one apply run return
+// (deprecated fields, values Talos accepted but ignores), deduplicated,
+// with the nodes that returned each. Printed once the run ends, it
+// shows config debt shared across the fleet in one place rather than
+// scattered between the per-node output.
+type applyWarnings struct {
+	mu       sync.Mutex
+	warnings []string
+	nodes    map[string][]string
+}
+
+//nolint:gochecknoglobals // set for the duration of one command, like activeJournal.
+var activeApplyWarnings struct {
+	mu       sync.Mutex
+	warnings *applyWarnings
+}
+
+// currentApplyWarnings returns the warnings of the running apply or
+// nil. note is a no-op on nil, so callers record unconditionally.
+func currentApplyWarnings() *applyWarnings {
+	activeApplyWarnings.mu.Lock()
+	defer activeApplyWarnings.mu.Unlock()
+
+	return activeApplyWarnings.warnings
+}
+
+// withApplyWarnings runs run while collecting the warnings the nodes
+// return, then writes the deprecations summary to w, failed runs
+// included: the nodes applied before the failure still answered.
+func withApplyWarnings(w io.Writer, run func() error) error {
+	warnings := &applyWarnings{}
+
+	activeApplyWarnings.mu.Lock()
+	activeApplyWarnings.warnings = warnings
+	activeApplyWarnings.mu.Unlock()
+
+	defer func() {
+		activeApplyWarnings.mu.Lock()
+		activeApplyWarnings.warnings = nil
+		activeApplyWarnings.mu.Unlock()
+	}()
+
+	err := run()
+
+	warnings.write(w)
+
+	return err
+}
+
+// note records the warnings of every node in summary. They are
+// already redacted by buildApplySummary.
+func (a *applyWarnings) note(summary applySummary) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.nodes == nil {
+		a.nodes = map[string][]string{}
+	}
+
+	for _, node := range summary.Nodes {
+		for _, warning := range node.Warnings {
+			warning = strings.TrimSpace(warning)
+			if warning == "" {
+				continue
+			}
+
+			nodes, seen := a.nodes[warning]
+			if !seen {
+				a.warnings = append(a.warnings, warning)
+			}
+
+			if !slices.Contains(nodes, node.Node) {
+				a.nodes[warning] = append(nodes, node.Node)
+			}
+		}
+	}
+}
+
+// write prints the deprecations summary: each distinct warning in the
+// order first returned, with the nodes that returned it. Nothing is
+// printed when no node warned. Quiet mode drops it, like the per-node
+// warnings.
+func (a *applyWarnings) write(w io.Writer) {
+	if a == nil || ui.Quiet() {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.warnings) == 0 {
+		return
+	}
+
+	var nodes []string
+	for _, warning := range a.warnings {
+		for _, node := range a.nodes[warning] {
+			if !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	ui.Warnf(w, "deprecations summary: %s from %s", countNoun(len(a.warnings), "warning"), countNoun(len(nodes), "node"))
+
+	for _, warning := range a.warnings {
+		_, _ = fmt.Fprintf(w, "  - %s\n    nodes: %s\n", warning, strings.Join(a.nodes[warning], ", "))
+	}
+}
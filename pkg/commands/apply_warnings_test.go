@@ -0,0 +1,88 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// TestApplyWarnings_Write pins the deprecations summary: each warning
+// once, in the order first returned, with every node that returned it.
+func TestApplyWarnings_Write(t *testing.T) {
+	t.Parallel()
+
+	warnings := &applyWarnings{}
+	warnings.note(applySummary{Nodes: []appliedNode{
+		{Node: "192.0.2.10", Warnings: []string{"cluster.proxy is deprecated", "machine.token *** is ignored"}},
+		{Node: "192.0.2.11", Warnings: []string{"cluster.proxy is deprecated\n"}},
+	}})
+	warnings.note(applySummary{Nodes: []appliedNode{
+		{Node: "192.0.2.12", Warnings: []string{"cluster.proxy is deprecated", " "}},
+		{Node: "192.0.2.10", Warnings: []string{"cluster.proxy is deprecated"}},
+		{Node: "192.0.2.13"},
+	}})
+
+	var out bytes.Buffer
+	warnings.write(&out)
+
+	want := "Warning: deprecations summary: 2 warnings from 3 nodes\n" +
+		"  - cluster.proxy is deprecated\n" +
+		"    nodes: 192.0.2.10, 192.0.2.11, 192.0.2.12\n" +
+		"  - machine.token *** is ignored\n" +
+		"    nodes: 192.0.2.10\n"
+	if out.String() != want {
+		t.Errorf("summary = %q, want %q", out.String(), want)
+	}
+}
+
+// TestWithApplyWarnings pins that the summary is printed once the run
+// ends, a failed run included, and that nothing is printed when no
+// node warned.
+func TestWithApplyWarnings(t *testing.T) {
+	runErr := errors.New("node 192.0.2.11: connection refused")
+
+	var out bytes.Buffer
+
+	err := withApplyWarnings(&out, func() error {
+		currentApplyWarnings().note(applySummary{Nodes: []appliedNode{
+			{Node: "192.0.2.10", Warnings: []string{"cluster.proxy is deprecated"}},
+		}})
+
+		return runErr
+	})
+	if !errors.Is(err, runErr) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if want := "Warning: deprecations summary: 1 warning from 1 node\n  - cluster.proxy is deprecated\n    nodes: 192.0.2.10\n"; out.String() != want {
+		t.Errorf("summary = %q, want %q", out.String(), want)
+	}
+
+	if currentApplyWarnings() != nil {
+		t.Error("the warnings must not outlive the run")
+	}
+
+	out.Reset()
+
+	if err := withApplyWarnings(&out, func() error { return nil }); err != nil || out.Len() != 0 {
+		t.Errorf("a run without warnings printed %q, err = %v", out.String(), err)
+	}
+
+	// Recording outside a run is a no-op.
+	currentApplyWarnings().note(applySummary{Nodes: []appliedNode{{Node: "192.0.2.10", Warnings: []string{"x"}}}})
+}
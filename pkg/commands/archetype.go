@@ -0,0 +1,703 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/chartpkg"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// archetypeManifestName is the file at the top of an archetype
+	// that names its preset and parameters.
+	archetypeManifestName = "archetype.yaml"
+
+	// archetypeManifestHeader is prepended to the written manifest.
+	archetypeManifestHeader = "# Written by talm archetype export. Instantiate with\n" +
+		"# `talm archetype apply <this directory> --name <cluster>`.\n" +
+		"# Parameters may be given a description and a default here.\n"
+
+	// archetypeDirMode is the mode of the directories an export creates.
+	archetypeDirMode os.FileMode = 0o755
+)
+
+// archetypeManifest is the on-disk shape of archetypeManifestName.
+type archetypeManifest struct {
+	Preset     string               `yaml:"preset"`
+	Parameters []archetypeParameter `yaml:"parameters,omitempty"`
+}
+
+// archetypeParameter is a values.yaml key an archetype leaves blank
+// for each new project to fill.
+type archetypeParameter struct {
+	Path        string `yaml:"path"`
+	Description string `yaml:"description,omitempty"`
+	Default     string `yaml:"default,omitempty"`
+	// String keeps the answer a string, as the exported value was,
+	// rather than reading it as YAML: a version such as 1.10 stays
+	// "1.10".
+	String bool `yaml:"string,omitempty"`
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var archetypeExportCmdFlags struct {
+	params []string
+	preset string
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var archetypeApplyCmdFlags struct {
+	name  string
+	set   []string
+	force bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var archetypeCmd = &cobra.Command{
+	Use:   "archetype",
+	Short: "Export a project as a reusable skeleton and create new projects from it",
+	Long: `An archetype is the chart of a project without its cluster: Chart.yaml,
+values.yaml, the templates, the vendored charts and any other chart files, with
+the cluster-specific values left blank as parameters. Organizations keep their
+standard cluster layout in one and create every new cluster project from it,
+beyond what the built-in presets offer.`,
+	Args: cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var archetypeExportCmd = &cobra.Command{
+	Use:   "export <dir>",
+	Short: "Write the project chart to <dir> as an archetype",
+	Long: `Write the chart of the project to <dir> as an archetype, together with an
+archetype.yaml manifest naming its preset and parameters.
+
+The files are the ones talm package would archive. Cluster state never goes
+into an archetype: secrets.yaml, talosconfig, kubeconfig, talm.key and
+values-secret.yaml (plain or encrypted), the nodes/ and .talm/ directories, the
+recipients lock and every other encrypted file, which only this project's keys
+open. The nodes map of values.yaml, per-node inventory data, is dropped too.
+
+Each --param names a values.yaml key, optionally followed by =<description>.
+Its value is blanked in the archetype and asked for, or taken from --set, when
+a project is created from it. Edit archetype.yaml to give a parameter a
+default.`,
+	Example: `  talm archetype export ../archetypes/baremetal \
+    --param endpoint="Kubernetes API URL, e.g. https://192.0.2.10:6443" \
+    --param floatingIP="control-plane VIP"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		params, err := parseArchetypeParams(archetypeExportCmdFlags.params)
+		if err != nil {
+			return err
+		}
+
+		count, err := exportArchetype(Config.RootDir, args[0], archetypeExportCmdFlags.preset, params)
+		if err != nil {
+			return err
+		}
+
+		ui.Successf(os.Stderr, "Exported %d file(s) and %d parameter(s) to %s", count, len(params), args[0])
+
+		return nil
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var archetypeApplyCmd = &cobra.Command{
+	Use:   "apply <dir>",
+	Short: "Create a new project from the archetype in <dir>",
+	Long: `Create a new project in the current directory (or --root) from the
+archetype in <dir>. The archetype's chart files are written first, with the
+chart named --name and every parameter filled into values.yaml; the rest is
+talm init: a new secrets bundle, talosconfig, encryption key, .gitignore and
+nodes/ directory.
+
+A parameter takes its value from --set <path>=<value>. Otherwise talm asks for
+it on a terminal, offering the default from archetype.yaml; without a terminal
+the default is used, and a parameter with no default is an error.`,
+	Example: `  mkdir prod-2 && cd prod-2
+  talm archetype apply ../archetypes/baremetal --name prod-2 \
+    --set endpoint=https://192.0.2.10:6443 --set floatingIP=192.0.2.10`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return applyArchetype(args[0])
+	},
+}
+
+// parseArchetypeParams reads the --param specs of export.
+func parseArchetypeParams(specs []string) ([]archetypeParameter, error) {
+	params := make([]archetypeParameter, 0, len(specs))
+
+	for _, spec := range specs {
+		path, description, _ := strings.Cut(spec, "=")
+		if _, err := parseValuesPath(path); err != nil {
+			return nil, err
+		}
+
+		if slices.ContainsFunc(params, func(p archetypeParameter) bool { return p.Path == path }) {
+			return nil, errors.Newf("--param %s is given twice", path)
+		}
+
+		params = append(params, archetypeParameter{Path: path, Description: description})
+	}
+
+	return params, nil
+}
+
+// exportArchetype writes the chart of the project at root to dest as
+// an archetype and returns the number of chart files written. params
+// get their String flag from the values they blank.
+func exportArchetype(root, dest, preset string, params []archetypeParameter) (int, error) {
+	if err := checkArchetypeDestination(root, dest); err != nil {
+		return 0, err
+	}
+
+	if !fileExists(filepath.Join(root, chartYamlName)) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return 0, errors.WithHint(
+			errors.Newf("%s has no %s", root, chartYamlName),
+			"run talm archetype export from the project to export, or pass --root",
+		)
+	}
+
+	if preset == "" {
+		var err error
+
+		preset, err = projectPreset(root)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	opts := readProjectGlobalOptions(root)
+	exclude := append(clientConfigPaths(projectSecretsLayout(root), opts.Talosconfig, opts.Kubeconfig), recipientsLockName, archetypeManifestName)
+
+	files, err := chartpkg.Files(root, exclude)
+	if err != nil {
+		return 0, err //nolint:wrapcheck // chartpkg names the file and attaches hints.
+	}
+
+	// values.yaml is sanitized first, so a parameter that is not set
+	// fails the export before anything is written.
+	var values []byte
+
+	switch {
+	case slices.Contains(files, valuesYamlName):
+		data, err := os.ReadFile(filepath.Join(root, valuesYamlName))
+		if err != nil {
+			return 0, errors.Wrapf(err, "reading %s", valuesYamlName)
+		}
+
+		values, err = sanitizeArchetypeValues(data, params)
+		if err != nil {
+			return 0, errors.Wrapf(err, "exporting %s", valuesYamlName)
+		}
+	case len(params) > 0:
+		return 0, errors.Newf("--param needs %s, and the project has none", valuesYamlName)
+	}
+
+	count := 0
+
+	for _, rel := range files {
+		if strings.HasSuffix(rel, age.EncryptedFileSuffix) {
+			ui.Warnf(os.Stderr, "Leaving out %s: it is encrypted to this project's keys", rel)
+
+			continue
+		}
+
+		data := values
+		if rel != valuesYamlName {
+			var err error
+
+			data, err = os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+			if err != nil {
+				return count, errors.Wrapf(err, "reading %s", rel)
+			}
+		}
+
+		if err := writeArchetypeFile(filepath.Join(dest, filepath.FromSlash(rel)), data); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	manifest, err := yaml.Marshal(archetypeManifest{Preset: preset, Parameters: params})
+	if err != nil {
+		return count, errors.Wrap(err, "encoding the archetype manifest")
+	}
+
+	return count, writeArchetypeFile(filepath.Join(dest, archetypeManifestName), append([]byte(archetypeManifestHeader), manifest...))
+}
+
+// checkArchetypeDestination refuses a destination that already has
+// files, and one inside the project, which the next export would
+// copy into itself.
+func checkArchetypeDestination(root, dest string) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", root)
+	}
+
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", dest)
+	}
+
+	if rel, err := filepath.Rel(absRoot, absDest); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("%s is inside the project at %s", dest, root),
+			"export to a directory outside the project, e.g. ../archetypes/%s", filepath.Base(absRoot),
+		)
+	}
+
+	entries, err := os.ReadDir(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "reading %s", dest)
+	}
+
+	if len(entries) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%s is not empty", dest),
+			"export to a new directory, or remove the old archetype first",
+		)
+	}
+
+	return nil
+}
+
+// projectPreset reads the preset the project at root was created from
+// out of its preset lock.
+func projectPreset(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, presetLockName))
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "reading %s", presetLockName)
+	}
+
+	var lock presetLock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return "", errors.Wrapf(err, "parsing %s", presetLockName)
+	}
+
+	if lock.Preset == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("the project has no %s naming its preset", presetLockName),
+			"pass the preset the project was created from with --preset",
+		)
+	}
+
+	return lock.Preset, nil
+}
+
+// sanitizeArchetypeValues drops the nodes map from values.yaml and
+// blanks every parameter, keeping the rest of the file and its
+// comments. A parameter that is not set in the file is an error, so a
+// typo does not export the value it meant to blank.
+func sanitizeArchetypeValues(data []byte, params []archetypeParameter) ([]byte, error) {
+	return editYAMLFile(data, func(root *yaml.Node) error {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == valuesNodesKey {
+				root.Content = slices.Delete(root.Content, i, i+2)
+
+				break
+			}
+		}
+
+		for i := range params {
+			segments, err := parseValuesPath(params[i].Path)
+			if err != nil {
+				return err
+			}
+
+			node, err := lookupValuesPath(root, segments)
+			if err != nil {
+				return errors.Wrapf(err, "--param %s", params[i].Path)
+			}
+
+			if node == nil {
+				return errors.Newf("--param %s is not set in %s", params[i].Path, valuesYamlName)
+			}
+
+			blank := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+
+			params[i].String = node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str"
+			if params[i].String {
+				blank = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: ""}
+			}
+
+			replaceYAMLNode(node, blank)
+		}
+
+		return nil
+	})
+}
+
+// editYAMLFile applies edit to the top-level mapping of the YAML in
+// data and returns the re-encoded file, in the line endings data had.
+func editYAMLFile(data []byte, edit func(root *yaml.Node) error) ([]byte, error) {
+	docs, err := decodeAllYAMLDocs(toLF(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing YAML")
+	}
+
+	if len(docs) == 0 || len(docs[0].Content) == 0 {
+		docs = []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}}
+	}
+
+	root := docs[0].Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.Newf("expected a mapping at the top, got %s", yamlKindName(root.Kind))
+	}
+
+	if err := edit(root); err != nil {
+		return nil, err
+	}
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding YAML")
+	}
+
+	return keepLineEnding(out, data), nil
+}
+
+// writeArchetypeFile writes a chart file, creating its directory.
+func writeArchetypeFile(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), archetypeDirMode); err != nil {
+		return errors.Wrapf(err, "creating the directory of %s", file)
+	}
+
+	if err := os.WriteFile(file, data, presetFileMode); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+
+	return nil
+}
+
+// readArchetype reads the manifest of the archetype in dir and lists
+// its chart files. The files go through the same filter as an export,
+// so secrets copied into an archetype by hand are not instantiated.
+func readArchetype(dir string) (archetypeManifest, []string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, archetypeManifestName))
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return archetypeManifest{}, nil, errors.WithHint(
+			errors.Wrapf(err, "reading the archetype manifest in %s", dir),
+			"pass a directory written by `talm archetype export`",
+		)
+	}
+
+	var manifest archetypeManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return archetypeManifest{}, nil, errors.Wrapf(err, "parsing %s", filepath.Join(dir, archetypeManifestName))
+	}
+
+	if manifest.Preset == "" {
+		return archetypeManifest{}, nil, errors.Newf("%s names no preset", filepath.Join(dir, archetypeManifestName))
+	}
+
+	opts := readProjectGlobalOptions(dir)
+
+	files, err := chartpkg.Files(dir, append(clientConfigPaths(projectSecretsLayout(dir), opts.Talosconfig, opts.Kubeconfig), archetypeManifestName))
+	if err != nil {
+		return archetypeManifest{}, nil, err //nolint:wrapcheck // chartpkg names the file and attaches hints.
+	}
+
+	return manifest, files, nil
+}
+
+// resolveArchetypeParameters picks the value of every parameter: from
+// sets, else from ask, else the default. ask is nil without a
+// terminal. A set for a key that is not a parameter is an error, as is
+// a parameter left without a value.
+func resolveArchetypeParameters(params []archetypeParameter, sets []string, ask func(archetypeParameter) (string, error)) (map[string]string, error) {
+	values := make(map[string]string, len(params))
+
+	for _, spec := range sets {
+		path, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Newf("--set %q has no value", spec),
+				"pass --set <path>=<value>",
+			)
+		}
+
+		if !slices.ContainsFunc(params, func(p archetypeParameter) bool { return p.Path == path }) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("%s is not a parameter of the archetype", path),
+				"the parameters are: %s; change other values in values.yaml after the project is created", strings.Join(archetypeParameterPaths(params), ", "),
+			)
+		}
+
+		values[path] = value
+	}
+
+	var missing []string
+
+	for _, param := range params {
+		if _, ok := values[param.Path]; ok {
+			continue
+		}
+
+		if ask != nil {
+			answer, err := ask(param)
+			if err != nil {
+				return nil, err
+			}
+
+			values[param.Path] = answer
+
+			continue
+		}
+
+		if param.Default != "" {
+			values[param.Path] = param.Default
+
+			continue
+		}
+
+		missing = append(missing, param.Path)
+	}
+
+	if len(missing) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("no value for the archetype parameter(s) %s", strings.Join(missing, ", ")),
+			"pass --set %s=<value>, or run on a terminal to be asked", missing[0],
+		)
+	}
+
+	return values, nil
+}
+
+func archetypeParameterPaths(params []archetypeParameter) []string {
+	paths := make([]string, len(params))
+	for i, param := range params {
+		paths[i] = param.Path
+	}
+
+	return paths
+}
+
+// askArchetypeParameter returns the ask function of
+// resolveArchetypeParameters for a terminal: it prints the parameter
+// with its description and default, and Enter takes the default.
+func askArchetypeParameter(in *bufio.Reader, out io.Writer) func(archetypeParameter) (string, error) {
+	return func(param archetypeParameter) (string, error) {
+		for {
+			label := param.Path
+			if param.Description != "" {
+				label = fmt.Sprintf("%s (%s)", param.Path, param.Description)
+			}
+
+			if param.Default != "" {
+				label = fmt.Sprintf("%s [%s]", label, param.Default)
+			}
+
+			fmt.Fprintf(out, "Enter %s: ", label)
+
+			answer, err := in.ReadString('\n')
+			if err != nil && (!errors.Is(err, io.EOF) || answer == "") {
+				return "", errors.Wrapf(err, "reading %s", param.Path)
+			}
+
+			answer = strings.TrimRight(answer, "\r\n")
+			if answer == "" {
+				answer = param.Default
+			}
+
+			if answer != "" {
+				return answer, nil
+			}
+
+			if err != nil {
+				return "", errors.Wrapf(err, "reading %s", param.Path)
+			}
+
+			fmt.Fprintf(out, "%s has no default; enter a value.\n", param.Path)
+		}
+	}
+}
+
+// writeArchetypeChart writes the chart files of the archetype in dir
+// into root: Chart.yaml named name, values.yaml with values filled in.
+func writeArchetypeChart(dir, root string, files []string, params []archetypeParameter, values map[string]string, name string) error {
+	for _, rel := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", rel)
+		}
+
+		switch rel {
+		case chartYamlName:
+			data, err = editYAMLFile(data, func(chart *yaml.Node) error {
+				return setValuesPath(chart, []string{"name"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name})
+			})
+		case valuesYamlName:
+			data, err = fillArchetypeValues(data, params, values)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "writing %s", rel)
+		}
+
+		if err := writeArchetypeFile(filepath.Join(root, filepath.FromSlash(rel)), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fillArchetypeValues sets every parameter in values.yaml to its value.
+func fillArchetypeValues(data []byte, params []archetypeParameter, values map[string]string) ([]byte, error) {
+	return editYAMLFile(data, func(root *yaml.Node) error {
+		for _, param := range params {
+			segments, err := parseValuesPath(param.Path)
+			if err != nil {
+				return err
+			}
+
+			value, err := parseValuesArgument(values[param.Path], param.String)
+			if err != nil {
+				return errors.Wrap(err, param.Path)
+			}
+
+			if err := setValuesPath(root, segments, value); err != nil {
+				return errors.Wrap(err, param.Path)
+			}
+		}
+
+		return nil
+	})
+}
+
+// archetypeConflicts lists the files of the archetype that already
+// exist under root.
+func archetypeConflicts(root string, files []string) []string {
+	var conflicts []string
+
+	for _, rel := range files {
+		if dest := filepath.Join(root, filepath.FromSlash(rel)); fileExists(dest) {
+			conflicts = append(conflicts, dest)
+		}
+	}
+
+	return conflicts
+}
+
+// archetypeTalosVersion reads templateOptions.talosVersion from the
+// archetype's Chart.yaml: the secrets bundle of the new project is
+// generated for that contract, as talm init does for its own project.
+func archetypeTalosVersion(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, chartYamlName))
+	if err != nil {
+		return ""
+	}
+
+	var chart struct {
+		TemplateOptions struct {
+			TalosVersion string `yaml:"talosVersion"`
+		} `yaml:"templateOptions"`
+	}
+
+	if err := yaml.Unmarshal(data, &chart); err != nil {
+		return ""
+	}
+
+	return chart.TemplateOptions.TalosVersion
+}
+
+// applyArchetype creates a project in Config.RootDir from the
+// archetype in dir. Parameters, the cluster name and conflicting files
+// are checked before anything is written; init then runs on the
+// written chart, so its secrets layout and recipients are honored.
+func applyArchetype(dir string) error {
+	manifest, files, err := readArchetype(dir)
+	if err != nil {
+		return err
+	}
+
+	var ask func(archetypeParameter) (string, error)
+	if stdinIsTTY() {
+		ask = askArchetypeParameter(bufio.NewReader(stdinReader), os.Stderr)
+	}
+
+	values, err := resolveArchetypeParameters(manifest.Parameters, archetypeApplyCmdFlags.set, ask)
+	if err != nil {
+		return err
+	}
+
+	initCmdFlags.preset = manifest.Preset
+	initCmdFlags.name = archetypeApplyCmdFlags.name
+	initCmdFlags.force = archetypeApplyCmdFlags.force
+	initCmdFlags.fromArchetype = true
+
+	if err := initCmd.PreRunE(initCmd, nil); err != nil {
+		return err
+	}
+
+	initCmdFlags.talosVersion = archetypeTalosVersion(dir)
+
+	if conflicts := archetypeConflicts(Config.RootDir, files); len(conflicts) > 0 && !archetypeApplyCmdFlags.force {
+		slices.Sort(conflicts)
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("refusing to apply the archetype: %d file(s) already exist in the target directory:\n  - %s", len(conflicts), strings.Join(conflicts, "\n  - ")),
+			"apply the archetype in an empty directory, or rerun with --force to overwrite",
+		)
+	}
+
+	if err := writeArchetypeChart(dir, Config.RootDir, files, manifest.Parameters, values, initCmdFlags.name); err != nil {
+		return err
+	}
+
+	ui.Infof(os.Stderr, "Wrote %d chart file(s) from %s", len(files), dir)
+
+	return initCmd.RunE(initCmd, nil)
+}
+
+func init() {
+	archetypeExportCmd.Flags().StringArrayVar(&archetypeExportCmdFlags.params, "param", nil, "values.yaml key to blank and ask for when the archetype is applied, as path or path=description (can specify multiple)")
+	archetypeExportCmd.Flags().StringVar(&archetypeExportCmdFlags.preset, "preset", "", "preset the project was created from (default: the one in .talm-preset.lock)")
+
+	archetypeApplyCmd.Flags().StringVarP(&archetypeApplyCmdFlags.name, "name", "N", "", "cluster name of the new project")
+	archetypeApplyCmd.Flags().StringArrayVar(&archetypeApplyCmdFlags.set, "set", nil, "value of an archetype parameter, as path=value (can specify multiple)")
+	archetypeApplyCmd.Flags().BoolVar(&archetypeApplyCmdFlags.force, "force", false, "overwrite files that already exist in the target directory")
+
+	_ = archetypeExportCmd.RegisterFlagCompletionFunc("preset", completePresetNames)
+
+	archetypeCmd.AddCommand(archetypeExportCmd, archetypeApplyCmd)
+	addCommand(archetypeCmd)
+}
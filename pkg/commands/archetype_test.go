@@ -0,0 +1,310 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeArchetypeProject lays out a project with chart files and every
+// kind of cluster state an export must leave behind.
+func writeArchetypeProject(t *testing.T) string {
+	t.Helper()
+
+	return writePruneProject(t, map[string]string{
+		"Chart.yaml":                  "apiVersion: v2\nname: prod-1\nversion: 0.1.0\ntemplateOptions:\n  talosVersion: \"v1.12\"\n",
+		"values.yaml":                 "# control-plane URL\nendpoint: \"https://192.0.2.10:6443\"\nkubernetesVersion: \"1.10\"\nreplicas: 3\nnodes:\n  192.0.2.11:\n    hostname: cp1\n",
+		"templates/controlplane.yaml": "{{ include \"talm.config\" . }}\n",
+		"charts/talm/Chart.yaml":      "apiVersion: v2\nname: talm\nversion: 0.1.0\ntype: library\n",
+		".talm-preset.lock":           "preset: cozystack\npresetHash: abc\n",
+		".talm-recipients.lock":       "github:alice: age1...\n",
+		"values-prod.encrypted.yaml":  "age ciphertext\n",
+		"secrets.yaml":                "secret\n",
+		"talosconfig":                 "secret\n",
+		"talm.key":                    "secret\n",
+		"nodes/cp1.yaml":              "node\n",
+	})
+}
+
+// TestExportArchetype_KeepsChartOnly pins what an export writes: the
+// chart files, values.yaml without nodes and with the parameters
+// blanked, and a manifest naming the preset.
+func TestExportArchetype_KeepsChartOnly(t *testing.T) {
+	root := writeArchetypeProject(t)
+	dest := filepath.Join(t.TempDir(), "baremetal")
+
+	params, err := parseArchetypeParams([]string{"endpoint=Kubernetes API URL", "kubernetesVersion", "replicas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := exportArchetype(root, dest, "", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []string
+
+	err = filepath.WalkDir(dest, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dest, p)
+		written = append(written, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".talm-preset.lock", "Chart.yaml", "archetype.yaml", "charts/talm/Chart.yaml", "templates/controlplane.yaml", "values.yaml"}
+	if !slices.Equal(written, want) || count != len(want)-1 {
+		t.Errorf("written = %v (count %d)\nwant %v", written, count, want)
+	}
+
+	values, err := os.ReadFile(filepath.Join(dest, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(values); got != "# control-plane URL\nendpoint: \"\"\nkubernetesVersion: \"\"\nreplicas: null\n" {
+		t.Errorf("values.yaml = %q", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dest, archetypeManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest archetypeManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	wantParams := []archetypeParameter{
+		{Path: "endpoint", Description: "Kubernetes API URL", String: true},
+		{Path: "kubernetesVersion", String: true},
+		{Path: "replicas"},
+	}
+	if manifest.Preset != "cozystack" || !slices.Equal(manifest.Parameters, wantParams) {
+		t.Errorf("manifest = %+v", manifest)
+	}
+}
+
+// TestExportArchetype_Refusals pins the exports refused before a file
+// is written.
+func TestExportArchetype_Refusals(t *testing.T) {
+	root := writeArchetypeProject(t)
+
+	nonEmpty := t.TempDir()
+	if err := os.WriteFile(filepath.Join(nonEmpty, "x"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		dest   string
+		params []archetypeParameter
+		want   string
+	}{
+		{"inside the project", filepath.Join(root, "archetype"), nil, "inside the project"},
+		{"not empty", nonEmpty, nil, "is not empty"},
+		{"unknown parameter", filepath.Join(t.TempDir(), "a"), []archetypeParameter{{Path: "floatingIP"}}, "floatingIP is not set"},
+	}
+
+	for _, tc := range cases {
+		_, err := exportArchetype(root, tc.dest, "", tc.params)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", tc.name, err, tc.want)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(root, presetLockName)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := exportArchetype(root, filepath.Join(t.TempDir(), "a"), "", nil); err == nil || !strings.Contains(err.Error(), presetLockName) {
+		t.Errorf("without a preset lock: err = %v", err)
+	}
+
+	if _, err := exportArchetype(root, filepath.Join(t.TempDir(), "a"), "generic", nil); err != nil {
+		t.Errorf("with --preset: %v", err)
+	}
+}
+
+// TestParseArchetypeParams pins the --param forms.
+func TestParseArchetypeParams(t *testing.T) {
+	t.Parallel()
+
+	params, err := parseArchetypeParams([]string{"endpoint", "vip.address=VIP, e.g. 192.0.2.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []archetypeParameter{{Path: "endpoint"}, {Path: "vip.address", Description: "VIP, e.g. 192.0.2.5"}}
+	if !slices.Equal(params, want) {
+		t.Errorf("params = %+v", params)
+	}
+
+	for _, specs := range [][]string{{"a..b"}, {"endpoint", "endpoint=again"}} {
+		if _, err := parseArchetypeParams(specs); err == nil {
+			t.Errorf("%q: expected an error", specs)
+		}
+	}
+}
+
+// TestResolveArchetypeParameters pins where a value comes from: --set
+// first, then the terminal, then the default.
+func TestResolveArchetypeParameters(t *testing.T) {
+	t.Parallel()
+
+	params := []archetypeParameter{
+		{Path: "endpoint"},
+		{Path: "image", Default: "ghcr.io/example/installer:v1.12.0"},
+	}
+
+	values, err := resolveArchetypeParameters(params, []string{"endpoint=https://192.0.2.10:6443"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values["endpoint"] != "https://192.0.2.10:6443" || values["image"] != "ghcr.io/example/installer:v1.12.0" {
+		t.Errorf("values = %v", values)
+	}
+
+	var asked []string
+
+	ask := func(param archetypeParameter) (string, error) {
+		asked = append(asked, param.Path)
+
+		return "answer", nil
+	}
+
+	values, err = resolveArchetypeParameters(params, []string{"endpoint=x"}, ask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(asked, []string{"image"}) || values["image"] != "answer" {
+		t.Errorf("asked = %v, values = %v", asked, values)
+	}
+
+	for sets, want := range map[string]string{
+		"":                "no value for the archetype parameter(s) endpoint",
+		"floatingIP=1":    "floatingIP is not a parameter",
+		"endpoint-no-val": "has no value",
+	} {
+		var list []string
+		if sets != "" {
+			list = []string{sets}
+		}
+
+		if _, err := resolveArchetypeParameters(params, list, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want it to mention %q", sets, err, want)
+		}
+	}
+}
+
+// TestAskArchetypeParameter pins the terminal answers: Enter takes the
+// default, and a parameter without one is asked again.
+func TestAskArchetypeParameter(t *testing.T) {
+	t.Parallel()
+
+	ask := askArchetypeParameter(bufio.NewReader(strings.NewReader("\n\nhttps://192.0.2.10:6443\n")), io.Discard)
+
+	got, err := ask(archetypeParameter{Path: "image", Default: "installer:v1"})
+	if err != nil || got != "installer:v1" {
+		t.Errorf("default: got %q, %v", got, err)
+	}
+
+	got, err = ask(archetypeParameter{Path: "endpoint"})
+	if err != nil || got != "https://192.0.2.10:6443" {
+		t.Errorf("asked again: got %q, %v", got, err)
+	}
+
+	if _, err := ask(archetypeParameter{Path: "endpoint"}); err == nil {
+		t.Error("expected an error on closed input")
+	}
+}
+
+// TestWriteArchetypeChart pins an instantiation: the chart renamed,
+// the parameters filled with their types, the other files as exported.
+func TestWriteArchetypeChart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "baremetal")
+
+	params, err := parseArchetypeParams([]string{"endpoint", "kubernetesVersion", "replicas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := exportArchetype(writeArchetypeProject(t), dir, "", params); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, files, err := readArchetype(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(files, archetypeManifestName) || !slices.Contains(files, "templates/controlplane.yaml") {
+		t.Errorf("files = %v", files)
+	}
+
+	values, err := resolveArchetypeParameters(manifest.Parameters, []string{"endpoint=https://198.51.100.10:6443", "kubernetesVersion=1.20", "replicas=5"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	if err := writeArchetypeChart(dir, root, files, manifest.Parameters, values, "prod-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	chart, err := os.ReadFile(filepath.Join(root, chartYamlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(chart), "name: prod-2\n") || !strings.Contains(string(chart), "talosVersion: \"v1.12\"") {
+		t.Errorf("Chart.yaml = %q", chart)
+	}
+
+	got, err := os.ReadFile(filepath.Join(root, valuesYamlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "# control-plane URL\nendpoint: \"https://198.51.100.10:6443\"\nkubernetesVersion: \"1.20\"\nreplicas: 5\n" {
+		t.Errorf("values.yaml = %q", got)
+	}
+
+	if conflicts := archetypeConflicts(root, files); len(conflicts) != len(files) {
+		t.Errorf("conflicts = %v", conflicts)
+	}
+
+	if got := archetypeTalosVersion(dir); got != "v1.12" {
+		t.Errorf("talos version = %q", got)
+	}
+}
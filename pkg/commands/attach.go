@@ -0,0 +1,620 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/dustin/go-humanize"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/nethelpers"
+	"github.com/siderolabs/talos/pkg/machinery/resources/network"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	attachCmdName = "attach"
+
+	// defaultAttachTimeout bounds the wait for the node to answer in
+	// maintenance mode. A PXE boot with a slow firmware POST and image
+	// download takes several minutes; half an hour covers a machine
+	// that is still being racked when the session starts.
+	defaultAttachTimeout = 30 * time.Minute
+
+	// attachPollInterval is the delay between maintenance-API probes
+	// while waiting for the node.
+	attachPollInterval = 5 * time.Second
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var attachCmdFlags struct {
+	configFile       string
+	templates        []string
+	timeout          time.Duration
+	certFingerprints []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var attachCmd = &cobra.Command{
+	Use:   attachCmdName + " <address>",
+	Short: "Wait for a node in maintenance mode, pick its install disk and interface, and apply its config",
+	Long: `Attach to a bare-metal node on its first boot, from the moment it comes up
+in maintenance mode to the applied config.
+
+The session:
+
+1. Polls the maintenance API at <address> until the node answers, so it can
+   be started before the machine finished its PXE or ISO boot.
+2. Lists the node's disks and physical interfaces, read live from the node,
+   and asks which disk to install Talos to and which interface the node is
+   managed on. Enter takes the suggested choice.
+3. Writes the -f node file: a modeline naming <address> and the --template
+   files, and the chosen disk as machine.install.disk. The chosen interface,
+   with its MAC and addresses, is recorded under nodes.<address>.interfaces
+   in values.yaml, as talm inventory import does.
+4. Asks for confirmation, then renders and applies the node file through the
+   maintenance connection, as talm apply --insecure -f would.
+
+The session asks questions, so it needs a terminal. The node file must not
+exist yet; for a node that already has one, use talm apply --insecure -f.`,
+	Example: `  # Attach to a worker that is PXE-booting and will take 192.0.2.50
+  talm attach 192.0.2.50 -f nodes/worker-5.yaml -t templates/worker.yaml
+
+  # Pin the maintenance certificate the node prints on its console
+  talm attach 192.0.2.50 -f nodes/worker-5.yaml -t templates/worker.yaml --cert-fingerprint <fingerprint>`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAttach(cmd.Context(), args[0])
+	},
+}
+
+// attachFacts is what the session reads from the node in maintenance
+// mode to offer its choices.
+type attachFacts struct {
+	Disks []applycheck.DiskInfo
+	Links []attachLink
+}
+
+// attachLink is one physical interface of the node, with the global
+// addresses it holds.
+type attachLink struct {
+	Name      string
+	MAC       string
+	Addresses []string
+}
+
+// runAttach runs the attach session for the node at address.
+func runAttach(ctx context.Context, address string) error {
+	if err := checkAttachInputs(address, attachCmdFlags.configFile, attachCmdFlags.templates, attachCmdFlags.timeout, stdinIsTTY()); err != nil {
+		return err
+	}
+
+	if err := DetectAndSetRootFromFiles([]string{attachCmdFlags.configFile}); err != nil {
+		return err
+	}
+
+	GlobalArgs.Nodes = []string{address}
+	GlobalArgs.Endpoints = []string{address}
+
+	facts, err := waitForMaintenanceNode(ctx, maintenanceNodeProbe(attachCmdFlags.certFingerprints), os.Stderr, address, attachCmdFlags.timeout, attachPollInterval)
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(stdinReader)
+
+	disk, err := chooseInstallDisk(in, os.Stderr, facts.Disks)
+	if err != nil {
+		return err
+	}
+
+	link, err := chooseAttachLink(in, os.Stderr, facts.Links, address)
+	if err != nil {
+		return err
+	}
+
+	nodeFile, err := attachNodeFile(address, attachCmdFlags.templates, "", disk.DevPath)
+	if err != nil {
+		return err
+	}
+
+	if err := secureperm.WriteFile(attachCmdFlags.configFile, nodeFile); err != nil {
+		return errors.Wrapf(err, "writing %s", attachCmdFlags.configFile)
+	}
+
+	ui.Successf(os.Stderr, "Wrote %s", attachCmdFlags.configFile)
+
+	if err := recordAttachedLink(address, link); err != nil {
+		return err
+	}
+
+	c := confirmation{action: fmt.Sprintf("Install Talos on %s and apply %s over the maintenance connection", address, attachCmdFlags.configFile)}
+	c.add("Node file", attachCmdFlags.configFile)
+	c.add("Install disk", describeDisk(disk))
+	c.add("Interface", describeLink(link))
+
+	if err := confirmDestructive(c); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(err, "the node file is written; apply it later with `talm apply --insecure -f %s`", attachCmdFlags.configFile)
+	}
+
+	return applyAttachedNodeFile(attachCmdFlags.configFile, attachCmdFlags.certFingerprints)
+}
+
+// checkAttachInputs rejects the invocations that would fail only after
+// the wait: a missing node file or template, an existing node file the
+// session would overwrite, and a session without a terminal to ask on.
+func checkAttachInputs(address, configFile string, templates []string, timeout time.Duration, interactive bool) error {
+	if strings.TrimSpace(address) == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("attach needs the address of the node"),
+			"pass the address the node gets on boot, e.g. `talm attach 192.0.2.50 -f nodes/worker-5.yaml -t templates/worker.yaml`",
+		)
+	}
+
+	if configFile == "" || len(templates) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("attach needs the node file to write and the templates it renders"),
+			"pass -f with the new node file and -t with its templates, e.g. `-f nodes/worker-5.yaml -t templates/worker.yaml`",
+		)
+	}
+
+	if fileExists(configFile) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("node file %s already exists", configFile),
+			"apply an existing node file to a node in maintenance mode with `talm apply --insecure -f %s`, or pass -f with a new file", configFile,
+		)
+	}
+
+	if timeout <= 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("--timeout must be a positive duration; got %s", timeout),
+			"pass a positive duration like 30m, the default is %s", defaultAttachTimeout,
+		)
+	}
+
+	if !interactive {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("talm %s requires an interactive terminal; stdin is not a tty", attachCmdName),
+			"run it from a regular terminal; to apply without questions, write the node file yourself and run `talm apply --insecure -f <node file>`",
+		)
+	}
+
+	return nil
+}
+
+// waitForMaintenanceNode probes the node until it answers and returns
+// what it read. Probe errors are retried: until the node booted, the
+// connection is refused or times out.
+func waitForMaintenanceNode(ctx context.Context, probe func(context.Context) (attachFacts, error), out io.Writer, address string, timeout, interval time.Duration) (attachFacts, error) {
+	ui.Infof(out, "Waiting up to %s for %s to answer in maintenance mode", timeout, address)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+
+	for {
+		facts, err := probe(waitCtx)
+		if err == nil {
+			ui.Successf(out, "%s is up: %d disk(s), %d interface(s)", address, len(facts.Disks), len(facts.Links))
+
+			return facts, nil
+		}
+
+		lastErr = err
+
+		select {
+		case <-waitCtx.Done():
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return attachFacts{}, errors.WithHintf(
+				errors.Wrapf(lastErr, "%s did not answer in maintenance mode within %s", address, timeout),
+				"check that the machine booted the Talos image and got %s; a node that already has a config does not answer in maintenance mode", address,
+			)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// maintenanceNodeProbe returns the probe that reads the disks and
+// interfaces of the node in GlobalArgs.Nodes over a maintenance
+// connection pinned to certFingerprints.
+func maintenanceNodeProbe(certFingerprints []string) func(context.Context) (attachFacts, error) {
+	return func(ctx context.Context) (attachFacts, error) {
+		var facts attachFacts
+
+		err := WithClientMaintenance(certFingerprints, func(_ context.Context, c *client.Client) error {
+			var err error
+
+			facts, err = readAttachFacts(ctx, c)
+
+			return err
+		})
+
+		return facts, err
+	}
+}
+
+// readAttachFacts lists the node's disks, its physical links and the
+// global addresses on them. All three resources are NonSensitive, so
+// the maintenance connection reads them.
+func readAttachFacts(ctx context.Context, c *client.Client) (attachFacts, error) {
+	snapshot, _, err := cosiLinksDisksReader(c)(ctx)
+	if err != nil {
+		return attachFacts{}, err
+	}
+
+	links, err := readWithFreshTimeout(ctx, preflightCOSIReadTimeout, func(ctx context.Context) (safe.List[*network.LinkStatus], error) {
+		return safe.StateListAll[*network.LinkStatus](ctx, c.COSI)
+	})
+	if err != nil {
+		return attachFacts{}, errors.Wrap(err, "listing LinkStatus resources")
+	}
+
+	addresses, err := readWithFreshTimeout(ctx, preflightCOSIReadTimeout, func(ctx context.Context) (safe.List[*network.AddressStatus], error) {
+		return safe.StateListAll[*network.AddressStatus](ctx, c.COSI)
+	})
+	if err != nil {
+		return attachFacts{}, errors.Wrap(err, "listing AddressStatus resources")
+	}
+
+	facts := attachFacts{Disks: snapshot.Disks}
+
+	for link := range links.All() {
+		spec := link.TypedSpec()
+		if !spec.Physical() {
+			continue
+		}
+
+		entry := attachLink{Name: link.Metadata().ID(), MAC: spec.HardwareAddr.String()}
+
+		for address := range addresses.All() {
+			addr := address.TypedSpec()
+			if addr.LinkName == entry.Name && addr.Scope == nethelpers.ScopeGlobal {
+				entry.Addresses = append(entry.Addresses, addr.Address.String())
+			}
+		}
+
+		facts.Links = append(facts.Links, entry)
+	}
+
+	return facts, nil
+}
+
+// installableDisks drops the devices Talos does not install to:
+// CD-ROMs, read-only and empty devices.
+func installableDisks(disks []applycheck.DiskInfo) []applycheck.DiskInfo {
+	out := make([]applycheck.DiskInfo, 0, len(disks))
+
+	for _, disk := range disks {
+		if disk.CDROM || disk.Readonly || disk.Size == 0 {
+			continue
+		}
+
+		out = append(out, disk)
+	}
+
+	return out
+}
+
+// installDiskCandidates returns the disks Talos can be installed to,
+// and an error when the node has none.
+func installDiskCandidates(disks []applycheck.DiskInfo) ([]applycheck.DiskInfo, error) {
+	candidates := installableDisks(disks)
+	if len(candidates) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("the node reports no disk to install Talos to"),
+			"check the disk controller in the firmware setup; CD-ROMs and read-only devices are not offered",
+		)
+	}
+
+	return candidates, nil
+}
+
+// suggestedInstallDisk returns the index of the disk the chart picks
+// when it discovers one: the first that reports a WWID or a model,
+// otherwise the first.
+func suggestedInstallDisk(candidates []applycheck.DiskInfo) int {
+	for i, disk := range candidates {
+		if disk.WWID != "" || disk.Model != "" {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// chooseInstallDisk asks for the install disk, suggesting
+// suggestedInstallDisk.
+func chooseInstallDisk(in *bufio.Reader, out io.Writer, disks []applycheck.DiskInfo) (applycheck.DiskInfo, error) {
+	candidates, err := installDiskCandidates(disks)
+	if err != nil {
+		return applycheck.DiskInfo{}, err
+	}
+
+	options := make([]string, len(candidates))
+	for i, disk := range candidates {
+		options[i] = describeDisk(disk)
+	}
+
+	choice, err := chooseAttachOption(in, out, "Install disk", options, suggestedInstallDisk(candidates)+1)
+	if err != nil {
+		return applycheck.DiskInfo{}, err
+	}
+
+	return candidates[choice-1], nil
+}
+
+// checkAttachLinks returns an error when the node has no physical
+// interface to manage it on.
+func checkAttachLinks(links []attachLink) error {
+	if len(links) > 0 {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.New("the node reports no physical interface"),
+		"check the network card in the firmware setup",
+	)
+}
+
+// suggestedAttachLink returns the index of the link holding address,
+// the address the node was reached at, otherwise the first.
+func suggestedAttachLink(links []attachLink, address string) int {
+	suggested := 0
+
+	for i, link := range links {
+		if linkHoldsAddress(link, address) {
+			suggested = i
+		}
+	}
+
+	return suggested
+}
+
+// chooseAttachLink asks for the interface the node is managed on,
+// suggesting suggestedAttachLink.
+func chooseAttachLink(in *bufio.Reader, out io.Writer, links []attachLink, address string) (attachLink, error) {
+	if err := checkAttachLinks(links); err != nil {
+		return attachLink{}, err
+	}
+
+	options := make([]string, len(links))
+	for i, link := range links {
+		options[i] = describeLink(link)
+	}
+
+	choice, err := chooseAttachOption(in, out, "Interface", options, suggestedAttachLink(links, address)+1)
+	if err != nil {
+		return attachLink{}, err
+	}
+
+	return links[choice-1], nil
+}
+
+// linkHoldsAddress reports whether one of the link's CIDR addresses is
+// address.
+func linkHoldsAddress(link attachLink, address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(link.Addresses, func(cidr string) bool {
+		prefix, err := netip.ParsePrefix(cidr)
+
+		return err == nil && prefix.Addr() == addr
+	})
+}
+
+// chooseAttachOption prints options numbered from 1 and reads the
+// operator's pick; an empty answer takes suggested. An answer that is
+// not one of the numbers asks again.
+func chooseAttachOption(in *bufio.Reader, out io.Writer, label string, options []string, suggested int) (int, error) {
+	fmt.Fprintf(out, "%s:\n", label)
+
+	for i, option := range options {
+		marker := " "
+		if i+1 == suggested {
+			marker = "*"
+		}
+
+		fmt.Fprintf(out, " %s %d) %s\n", marker, i+1, option)
+	}
+
+	for {
+		fmt.Fprintf(out, "Choose %s [%d]: ", strings.ToLower(label), suggested)
+
+		answer, err := in.ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || answer == "") {
+			return 0, errors.Wrapf(err, "reading the %s choice", strings.ToLower(label))
+		}
+
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return suggested, nil
+		}
+
+		choice, convErr := strconv.Atoi(answer)
+		if convErr == nil && choice >= 1 && choice <= len(options) {
+			return choice, nil
+		}
+
+		fmt.Fprintf(out, "Enter a number from 1 to %d.\n", len(options))
+
+		if err != nil {
+			return 0, errors.Wrapf(err, "reading the %s choice", strings.ToLower(label))
+		}
+	}
+}
+
+// describeDisk is the one-line form of a disk in the choices and the
+// confirmation.
+func describeDisk(disk applycheck.DiskInfo) string {
+	parts := []string{disk.DevPath, humanize.Bytes(disk.Size)}
+
+	for _, extra := range []string{disk.Transport, disk.Model, disk.Serial} {
+		if extra != "" {
+			parts = append(parts, extra)
+		}
+	}
+
+	if disk.Rotational {
+		parts = append(parts, "rotational")
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// describeLink is the one-line form of an interface in the choices and
+// the confirmation.
+func describeLink(link attachLink) string {
+	parts := []string{link.Name}
+
+	if link.MAC != "" {
+		parts = append(parts, link.MAC)
+	}
+
+	if len(link.Addresses) == 0 {
+		parts = append(parts, "no address")
+	} else {
+		parts = append(parts, strings.Join(link.Addresses, ", "))
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// attachNodeFile renders the node file the session writes: a modeline
+// for address, templates and role, and the install disk as the body.
+func attachNodeFile(address string, templates []string, role, disk string) ([]byte, error) {
+	line, err := modeline.GenerateRoleModeline([]string{address}, []string{address}, templates, role)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating the modeline")
+	}
+
+	buffer := bytes.NewBufferString(line + "\n")
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(2)
+
+	err = encoder.Encode(map[string]any{
+		"machine": map[string]any{
+			"install": map[string]any{"disk": disk},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding the node file body")
+	}
+
+	_ = encoder.Close()
+
+	return buffer.Bytes(), nil
+}
+
+// recordAttachedLink records the chosen interface of the node under
+// nodes.<address>.interfaces in values.yaml, the key talm inventory
+// import writes. An encrypted values file is left alone with a
+// warning: the record is a convenience, not a step the apply needs.
+func recordAttachedLink(address string, link attachLink) error {
+	file := valuesFilePath(valuesYamlName)
+
+	if !fileExists(file) {
+		ui.Warnf(os.Stderr, "%s not found; the interface of %s is not recorded", file, address)
+
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	updated, err := mergeInventoryNodes(data, []inventoryNode{{
+		Address:    address,
+		Interfaces: []inventoryInterface{{Name: link.Name, MAC: link.MAC, Addresses: link.Addresses}},
+	}})
+	if err != nil {
+		return errors.Wrapf(err, "updating %s", file)
+	}
+
+	if bytes.Equal(updated, data) {
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	if err := os.WriteFile(file, updated, info.Mode().Perm()); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+
+	ui.Successf(os.Stderr, "Recorded %s as the interface of %s in %s", link.Name, address, file)
+
+	return nil
+}
+
+// applyAttachedNodeFile renders and applies the node file through the
+// maintenance connection, the way `talm apply --insecure -f` does.
+// applyCmd's PreRunE seeds the apply flags from Chart.yaml first.
+func applyAttachedNodeFile(file string, certFingerprints []string) error {
+	GlobalArgs.Nodes = []string{}
+	GlobalArgs.Endpoints = []string{}
+
+	if err := applyCmd.PreRunE(applyCmd, nil); err != nil {
+		return err
+	}
+
+	applyCmdFlags.insecure = true
+	applyCmdFlags.certFingerprints = certFingerprints
+
+	return applyOneFile(file, nil)
+}
+
+func init() {
+	attachCmd.Flags().StringVarP(&attachCmdFlags.configFile, "file", "f", "", "node file to write for the node; must not exist yet")
+	attachCmd.Flags().StringSliceVarP(&attachCmdFlags.templates, "template", "t", nil, "templates the node file renders, e.g. templates/worker.yaml (can specify multiple)")
+	attachCmd.Flags().DurationVar(&attachCmdFlags.timeout, "timeout", defaultAttachTimeout, "how long to wait for the node to answer in maintenance mode")
+	attachCmd.Flags().StringSliceVar(&attachCmdFlags.certFingerprints, "cert-fingerprint", nil, "SPKI fingerprint of the maintenance certificate the node prints on its console (can specify multiple)")
+
+	_ = attachCmd.RegisterFlagCompletionFunc("template", completeYAMLFiles)
+
+	addCommand(attachCmd)
+}
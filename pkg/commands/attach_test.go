@@ -0,0 +1,283 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+// TestCheckAttachInputs pins the invocations rejected before the wait.
+func TestCheckAttachInputs(t *testing.T) {
+	t.Parallel()
+
+	existing := filepath.Join(t.TempDir(), "worker-1.yaml")
+	if err := os.WriteFile(existing, []byte("machine: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := filepath.Join(t.TempDir(), "worker-5.yaml")
+	tpls := []string{"templates/worker.yaml"}
+
+	cases := []struct {
+		name        string
+		address     string
+		file        string
+		templates   []string
+		timeout     time.Duration
+		interactive bool
+		want        string
+	}{
+		{"ok", "192.0.2.50", fresh, tpls, time.Minute, true, ""},
+		{"no address", " ", fresh, tpls, time.Minute, true, "needs the address"},
+		{"no file", "192.0.2.50", "", tpls, time.Minute, true, "needs the node file"},
+		{"no template", "192.0.2.50", fresh, nil, time.Minute, true, "needs the node file"},
+		{"existing file", "192.0.2.50", existing, tpls, time.Minute, true, "already exists"},
+		{"zero timeout", "192.0.2.50", fresh, tpls, 0, true, "--timeout"},
+		{"no terminal", "192.0.2.50", fresh, tpls, time.Minute, false, "interactive terminal"},
+	}
+
+	for _, tc := range cases {
+		err := checkAttachInputs(tc.address, tc.file, tc.templates, tc.timeout, tc.interactive)
+
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: err = %v, want it to mention %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestWaitForMaintenanceNode_RetriesUntilUp pins that probe errors
+// are retried until the node answers.
+func TestWaitForMaintenanceNode_RetriesUntilUp(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	probe := func(context.Context) (attachFacts, error) {
+		calls++
+		if calls < 3 {
+			return attachFacts{}, errors.New("connection refused")
+		}
+
+		return attachFacts{Links: []attachLink{{Name: "eth0"}}}, nil
+	}
+
+	var out bytes.Buffer
+
+	facts, err := waitForMaintenanceNode(context.Background(), probe, &out, "192.0.2.50", time.Minute, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 || len(facts.Links) != 1 {
+		t.Errorf("calls = %d, facts = %+v", calls, facts)
+	}
+
+	if !strings.Contains(out.String(), "192.0.2.50 is up") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+// TestWaitForMaintenanceNode_Timeout pins that the wait gives up with
+// the last probe error.
+func TestWaitForMaintenanceNode_Timeout(t *testing.T) {
+	t.Parallel()
+
+	probe := func(context.Context) (attachFacts, error) {
+		return attachFacts{}, errors.New("connection refused")
+	}
+
+	_, err := waitForMaintenanceNode(context.Background(), probe, io.Discard, "192.0.2.50", 20*time.Millisecond, time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	for _, want := range []string{"did not answer in maintenance mode", "connection refused"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to mention %q", err, want)
+		}
+	}
+}
+
+// TestChooseInstallDisk pins the offered disks and the suggestion: the
+// first disk with a WWID or a model, as the chart picks.
+func TestChooseInstallDisk(t *testing.T) {
+	t.Parallel()
+
+	disks := []applycheck.DiskInfo{
+		{DevPath: "/dev/sr0", Size: 1 << 30, CDROM: true},
+		{DevPath: "/dev/loop0", Size: 0},
+		{DevPath: "/dev/vda", Size: 10 << 30},
+		{DevPath: "/dev/nvme0n1", Size: 512 << 30, Model: "Samsung SSD"},
+		{DevPath: "/dev/sdb", Size: 1 << 40, Readonly: true, WWID: "naa.1"},
+	}
+
+	var out bytes.Buffer
+
+	disk, err := chooseInstallDisk(bufio.NewReader(strings.NewReader("\n")), &out, disks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if disk.DevPath != "/dev/nvme0n1" {
+		t.Errorf("default disk = %s, want /dev/nvme0n1", disk.DevPath)
+	}
+
+	for _, hidden := range []string{"/dev/sr0", "/dev/loop0", "/dev/sdb"} {
+		if strings.Contains(out.String(), hidden) {
+			t.Errorf("%s offered:\n%s", hidden, out.String())
+		}
+	}
+
+	disk, err = chooseInstallDisk(bufio.NewReader(strings.NewReader("1\n")), io.Discard, disks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if disk.DevPath != "/dev/vda" {
+		t.Errorf("chosen disk = %s, want /dev/vda", disk.DevPath)
+	}
+
+	if _, err := chooseInstallDisk(bufio.NewReader(strings.NewReader("\n")), io.Discard, disks[:2]); err == nil {
+		t.Error("expected an error without an installable disk")
+	}
+}
+
+// TestChooseAttachLink pins that the suggested interface is the one
+// holding the address the node was reached at.
+func TestChooseAttachLink(t *testing.T) {
+	t.Parallel()
+
+	links := []attachLink{
+		{Name: "eno1", MAC: "aa:bb:cc:00:00:01"},
+		{Name: "eno2", MAC: "aa:bb:cc:00:00:02", Addresses: []string{"192.0.2.50/24"}},
+	}
+
+	link, err := chooseAttachLink(bufio.NewReader(strings.NewReader("\n")), io.Discard, links, "192.0.2.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link.Name != "eno2" {
+		t.Errorf("default link = %s, want eno2", link.Name)
+	}
+
+	link, err = chooseAttachLink(bufio.NewReader(strings.NewReader("\n")), io.Discard, links, "198.51.100.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link.Name != "eno1" {
+		t.Errorf("default link for an unknown address = %s, want eno1", link.Name)
+	}
+}
+
+// TestChooseAttachOption pins the answers: Enter takes the suggestion,
+// a wrong answer asks again, and a closed input is an error.
+func TestChooseAttachOption(t *testing.T) {
+	t.Parallel()
+
+	options := []string{"one", "two", "three"}
+
+	cases := []struct {
+		input string
+		want  int
+	}{
+		{"\n", 2},
+		{"3\n", 3},
+		{"9\nx\n1\n", 1},
+		{"1", 1},
+	}
+
+	for _, tc := range cases {
+		var out bytes.Buffer
+
+		got, err := chooseAttachOption(bufio.NewReader(strings.NewReader(tc.input)), &out, "Disk", options, 2)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%q: choice = %d, want %d", tc.input, got, tc.want)
+		}
+
+		if !strings.Contains(out.String(), " * 2) two") {
+			t.Errorf("%q: suggestion not marked:\n%s", tc.input, out.String())
+		}
+	}
+
+	for _, input := range []string{"", "9"} {
+		if _, err := chooseAttachOption(bufio.NewReader(strings.NewReader(input)), io.Discard, "Disk", options, 2); err == nil {
+			t.Errorf("%q: expected an error on closed input", input)
+		}
+	}
+}
+
+// TestAttachNodeFile pins the written node file: a modeline that
+// targets the node and names the templates, and the install disk.
+func TestAttachNodeFile(t *testing.T) {
+	t.Parallel()
+
+	data, err := attachNodeFile("192.0.2.50", []string{"templates/worker.yaml"}, "", "/dev/nvme0n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, body, _ := strings.Cut(string(data), "\n")
+
+	config, err := modeline.ParseModeline(first)
+	if err != nil {
+		t.Fatalf("modeline %q: %v", first, err)
+	}
+
+	if strings.Join(config.Nodes, ",") != "192.0.2.50" || strings.Join(config.Endpoints, ",") != "192.0.2.50" || strings.Join(config.Templates, ",") != "templates/worker.yaml" {
+		t.Errorf("modeline = %+v", config)
+	}
+
+	if body != "machine:\n  install:\n    disk: /dev/nvme0n1\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+// TestDescribeDiskAndLink pins the one-line forms shown in the choices.
+func TestDescribeDiskAndLink(t *testing.T) {
+	t.Parallel()
+
+	disk := describeDisk(applycheck.DiskInfo{DevPath: "/dev/sda", Size: 2_000_000_000_000, Transport: "sata", Model: "HDD", Rotational: true})
+	if disk != "/dev/sda  2.0 TB  sata  HDD  rotational" {
+		t.Errorf("disk = %q", disk)
+	}
+
+	link := describeLink(attachLink{Name: "eno1", MAC: "aa:bb:cc:00:00:01"})
+	if link != "eno1  aa:bb:cc:00:00:01  no address" {
+		t.Errorf("link = %q", link)
+	}
+}
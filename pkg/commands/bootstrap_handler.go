@@ -0,0 +1,206 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	bootstrapCmdName = "bootstrap"
+
+	// bootstrapWaitFlag waits for etcd to run after the bootstrap call.
+	bootstrapWaitFlag = "wait"
+
+	// bootstrapWaitTimeoutFlag bounds the etcd wait.
+	bootstrapWaitTimeoutFlag = "wait-timeout"
+
+	// bootstrapKubeconfigFlag downloads the admin kubeconfig once etcd
+	// runs.
+	bootstrapKubeconfigFlag = "fetch-kubeconfig"
+
+	// defaultBootstrapWaitTimeout bounds the wait for etcd after the
+	// bootstrap call. A fresh member comes up in well under a minute;
+	// slow disks and image pulls on first boot take longer.
+	defaultBootstrapWaitTimeout = 10 * time.Minute
+
+	// bootstrapEtcdPollInterval is the delay between etcd service-state
+	// reads while waiting for the bootstrapped member.
+	bootstrapEtcdPollInterval = 5 * time.Second
+)
+
+const bootstrapLong = `
+
+talm bootstrap reads the node and endpoints from the modeline of the -f
+node file, which must name exactly one control-plane node. After the
+bootstrap call it waits until etcd on the node is running and healthy;
+--wait=false returns right after the call. --fetch-kubeconfig then
+downloads the admin kubeconfig into the project root, to the path
+Chart.yaml globalOptions.kubeconfig names, as talm kubeconfig does. When
+the project's secrets are encrypted the kubeconfig is encrypted too.`
+
+const bootstrapExample = `  # Bootstrap the cluster on the first control-plane node
+  talm bootstrap -f nodes/cp01.yaml
+
+  # Bootstrap and download the kubeconfig once etcd is healthy
+  talm bootstrap -f nodes/cp01.yaml --fetch-kubeconfig`
+
+// wrapBootstrapCommand turns the upstream bootstrap call into the
+// project's bootstrap step: one node from the node file, a wait for
+// etcd, and optionally the kubeconfig.
+func wrapBootstrapCommand(wrappedCmd *cobra.Command, originalRunE func(*cobra.Command, []string) error) {
+	wrappedCmd.Long += bootstrapLong
+	wrappedCmd.Example = bootstrapExample
+
+	wrappedCmd.Flags().Bool(bootstrapWaitFlag, true, "wait until etcd on the node is running and healthy")
+	wrappedCmd.Flags().Duration(bootstrapWaitTimeoutFlag, defaultBootstrapWaitTimeout, "how long to wait for etcd after the bootstrap call")
+	wrappedCmd.Flags().Bool(bootstrapKubeconfigFlag, false, "download the admin kubeconfig into the project root once etcd runs")
+
+	wrappedCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		wait, _ := cmd.Flags().GetBool(bootstrapWaitFlag)
+		waitTimeout, _ := cmd.Flags().GetDuration(bootstrapWaitTimeoutFlag)
+		fetchKubeconfig, _ := cmd.Flags().GetBool(bootstrapKubeconfigFlag)
+
+		if err := checkBootstrapInputs(GlobalArgs.Nodes, waitTimeout); err != nil {
+			return err
+		}
+
+		if err := originalRunE(cmd, args); err != nil {
+			return err
+		}
+
+		return WithClient(func(ctx context.Context, c *client.Client) error {
+			node := strings.Join(GlobalArgs.Nodes, ",")
+
+			ui.Successf(os.Stderr, "Bootstrapped etcd on %s", node)
+
+			if wait {
+				if err := waitForBootstrapEtcd(ctx, c, os.Stderr, node, waitTimeout, bootstrapEtcdPollInterval); err != nil {
+					return err
+				}
+			}
+
+			if !fetchKubeconfig {
+				return nil
+			}
+
+			kubeconfig, err := c.Kubeconfig(ctx)
+			if err != nil {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHint(
+					errors.Wrapf(err, "downloading the kubeconfig from %s", node),
+					"the cluster is bootstrapped; do not bootstrap it again, download the kubeconfig with `talm kubeconfig -f <node file>`",
+				)
+			}
+
+			return writeBootstrapKubeconfig(kubeconfig)
+		})
+	}
+}
+
+// checkBootstrapInputs rejects a bootstrap that would target several
+// nodes: etcd is bootstrapped on exactly one, and a copied multi-node
+// modeline is the usual way to get there.
+func checkBootstrapInputs(nodes []string, waitTimeout time.Duration) error {
+	if len(nodes) > 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("bootstrap targets exactly one node, but %d nodes were resolved (%s)", len(nodes), strings.Join(nodes, ", ")),
+			"point -f at the node file of one control-plane node, e.g. `talm bootstrap -f nodes/cp01.yaml`, or pick one with --nodes",
+		)
+	}
+
+	if waitTimeout <= 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("--%s must be a positive duration; got %s", bootstrapWaitTimeoutFlag, waitTimeout),
+			"pass a positive duration like 10m, or --%s=false to skip the wait", bootstrapWaitFlag,
+		)
+	}
+
+	return nil
+}
+
+// waitForBootstrapEtcd waits for the freshly bootstrapped member to run
+// and be healthy. Bootstrap must not be repeated, so a timeout points
+// at the checks that follow instead.
+func waitForBootstrapEtcd(ctx context.Context, c etcdServiceClient, out io.Writer, node string, timeout, interval time.Duration) error {
+	ui.Infof(out, "Waiting up to %s for etcd on %s", timeout, node)
+
+	lastState, err := pollEtcdRunning(ctx, c, timeout, interval)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("etcd on %s did not become healthy within %s (last state %q)", node, timeout, lastState),
+			"do not bootstrap again; check `talm service etcd --nodes %s` and `talm logs etcd --nodes %s`", node, node,
+		)
+	}
+
+	ui.Successf(out, "etcd on %s is running", node)
+
+	return nil
+}
+
+// writeBootstrapKubeconfig writes the admin kubeconfig to the project
+// kubeconfig path and post-processes it as `talm kubeconfig` does. A
+// project whose secrets are encrypted gets an encrypted copy of it as
+// well, the first time included.
+func writeBootstrapKubeconfig(kubeconfig []byte) error {
+	kubeconfigPath := projectKubeconfigPath()
+
+	if err := secureperm.WriteFile(kubeconfigPath, kubeconfig); err != nil {
+		return errors.Wrapf(err, "writing %s", kubeconfigPath)
+	}
+
+	ui.Successf(os.Stderr, "Wrote %s", kubeconfigPath)
+
+	if err := finishProjectKubeconfig(kubeconfigPath, false); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(Config.RootDir, kubeconfigPath)
+	if err != nil || isOutsideRoot(rel) || !projectSecretsEncrypted() || fileExists(kubeconfigPath+".encrypted") {
+		return nil
+	}
+
+	if err := age.EncryptYAMLFile(Config.RootDir, rel, rel+".encrypted"); err != nil {
+		return errors.Wrapf(err, "encrypting %s", rel)
+	}
+
+	ui.Infof(os.Stderr, "Encrypted %s -> %s.encrypted", rel, rel)
+
+	return nil
+}
+
+// projectSecretsEncrypted reports whether the project keeps its secrets
+// encrypted: the encrypted secrets file and the key are both present.
+func projectSecretsEncrypted() bool {
+	layout := secretsLayout()
+
+	return fileExists(projectPath(layout.EncryptedSecretsFile())) && fileExists(projectPath(layout.KeyFile()))
+}
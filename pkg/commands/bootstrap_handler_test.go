@@ -0,0 +1,163 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+const testBootstrapKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+  - name: demo
+    cluster:
+      server: https://192.0.2.10:6443
+contexts:
+  - name: admin@demo
+    context:
+      cluster: demo
+      user: admin@demo
+current-context: admin@demo
+users:
+  - name: admin@demo
+    user:
+      token: t0ken
+`
+
+func TestCheckBootstrapInputs(t *testing.T) {
+	t.Parallel()
+
+	if err := checkBootstrapInputs([]string{"192.0.2.10"}, time.Minute); err != nil {
+		t.Errorf("one node: %v", err)
+	}
+
+	if err := checkBootstrapInputs(nil, time.Minute); err != nil {
+		t.Errorf("nodes from the talosconfig context: %v", err)
+	}
+
+	err := checkBootstrapInputs([]string{"192.0.2.10", "192.0.2.11"}, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "bootstrap targets exactly one node, but 2 nodes were resolved (192.0.2.10, 192.0.2.11)") {
+		t.Errorf("err = %v", err)
+	}
+
+	err = checkBootstrapInputs([]string{"192.0.2.10"}, 0)
+	if err == nil || !strings.Contains(err.Error(), "--wait-timeout must be a positive duration") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestWaitForBootstrapEtcd pins that the wait polls until the member
+// runs, and that a timeout tells the operator not to bootstrap again.
+func TestWaitForBootstrapEtcd(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeEtcdRecoveryClient{states: []string{etcdStatePreparing, etcdStatePreparing, etcdStateRunning}}
+
+	var out bytes.Buffer
+	if err := waitForBootstrapEtcd(context.Background(), fake, &out, "192.0.2.10", time.Second, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.calls) != 3 || !strings.Contains(out.String(), "etcd on 192.0.2.10 is running") {
+		t.Errorf("calls = %v, output = %q", fake.calls, out.String())
+	}
+
+	fake = &fakeEtcdRecoveryClient{states: []string{etcdStatePreparing}}
+
+	err := waitForBootstrapEtcd(context.Background(), fake, &out, "192.0.2.10", 20*time.Millisecond, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), `etcd on 192.0.2.10 did not become healthy within 20ms (last state "Preparing")`) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "do not bootstrap again") {
+		t.Errorf("hint = %q", hints)
+	}
+}
+
+func TestWriteBootstrapKubeconfig(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+
+	origEndpoints := GlobalArgs.Endpoints
+	t.Cleanup(func() { GlobalArgs.Endpoints = origEndpoints })
+
+	GlobalArgs.Endpoints = nil
+
+	if err := writeBootstrapKubeconfig([]byte(testBootstrapKubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "kubeconfig"))
+	if err != nil || string(data) != testBootstrapKubeconfig {
+		t.Errorf("kubeconfig = %q, %v", data, err)
+	}
+
+	if fileExists(filepath.Join(dir, "kubeconfig.encrypted")) {
+		t.Error("a project with plain secrets must not get an encrypted kubeconfig")
+	}
+}
+
+// TestWriteBootstrapKubeconfig_Encrypted pins that a project keeping
+// its secrets encrypted gets the kubeconfig encrypted from the start.
+func TestWriteBootstrapKubeconfig_Encrypted(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+	age.SetLayout(age.Layout{})
+
+	origEndpoints := GlobalArgs.Endpoints
+	t.Cleanup(func() { GlobalArgs.Endpoints = origEndpoints })
+
+	GlobalArgs.Endpoints = nil
+	Config.GlobalOptions.Kubeconfig = "admin.kubeconfig"
+
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "secrets.yaml"), []byte("cluster:\n  secret: s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptSecretsFile(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeBootstrapKubeconfig([]byte(testBootstrapKubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"admin.kubeconfig", "admin.kubeconfig.encrypted"} {
+		if !fileExists(filepath.Join(dir, name)) {
+			t.Errorf("%s was not written", name)
+		}
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(dir, "admin.kubeconfig.encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(encrypted, []byte("t0ken")) {
+		t.Error("the encrypted kubeconfig carries the token in the clear")
+	}
+}
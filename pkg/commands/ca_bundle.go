@@ -0,0 +1,108 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"k8s.io/client-go/rest"
+
+	"github.com/cozystack/talm/pkg/httpclient"
+)
+
+// caBundleKey names the CA bundle in Chart.yaml, for messages.
+const caBundleKey = "globalOptions.caBundle"
+
+// ApplyCABundle makes every outbound HTTP client trust the CA bundle
+// Chart.yaml names, besides the system roots. Called once Chart.yaml
+// is loaded, before any client is built.
+func ApplyCABundle() error {
+	path, err := caBundlePath(Config.GlobalOptions.CABundle, Config.RootDir)
+	if err != nil {
+		return err
+	}
+
+	if err := httpclient.SetCABundle(path); err != nil {
+		return errors.Wrapf(err, "loading %s", caBundleKey)
+	}
+
+	return nil
+}
+
+// caBundlePath resolves the caBundle entry like the shared
+// talosconfig: `~/` is the home directory, environment variables are
+// expanded, and a relative path is read from the project root. An
+// empty entry is "".
+func caBundlePath(value, rootDir string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	path := os.ExpandEnv(value)
+
+	if relative, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "expanding %s %q", caBundleKey, value)
+		}
+
+		path = filepath.Join(home, relative)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+
+	return path, nil
+}
+
+// trustCABundle makes a Kubernetes client config trust the CA bundle
+// too; client-go already takes the proxy from the environment. The
+// bundle joins the CA the kubeconfig pins. A kubeconfig without one
+// keeps the system roots, which a CA set here would replace.
+func trustCABundle(config *rest.Config) error {
+	bundle := httpclient.CABundle()
+	if bundle == nil || config.Insecure {
+		return nil
+	}
+
+	tlsConfig := &config.TLSClientConfig
+
+	if tlsConfig.CAFile != "" && len(tlsConfig.CAData) == 0 {
+		data, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return errors.Wrap(err, "reading the kubeconfig certificate authority")
+		}
+
+		tlsConfig.CAData = data
+	}
+
+	if len(tlsConfig.CAData) == 0 {
+		return nil
+	}
+
+	ca := append([]byte(nil), tlsConfig.CAData...)
+	if !strings.HasSuffix(string(ca), "\n") {
+		ca = append(ca, '\n')
+	}
+
+	tlsConfig.CAData = append(ca, bundle...)
+	tlsConfig.CAFile = ""
+
+	return nil
+}
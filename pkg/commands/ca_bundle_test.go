@@ -0,0 +1,154 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/cozystack/talm/pkg/httpclient"
+)
+
+// writeBundleCA writes a self-signed CA certificate as PEM to path.
+func writeBundleCA(t *testing.T, path, name string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestCABundlePath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	t.Setenv("TALM_TEST_CA_DIR", "/etc/pki")
+
+	for _, tc := range []struct {
+		value, want string
+	}{
+		{value: "", want: ""},
+		{value: "certs/corp.pem", want: filepath.Join("/project", "certs", "corp.pem")},
+		{value: "/etc/ssl/corp.pem", want: "/etc/ssl/corp.pem"},
+		{value: "$TALM_TEST_CA_DIR/corp.pem", want: "/etc/pki/corp.pem"},
+		{value: "~/corp.pem", want: filepath.Join(home, "corp.pem")},
+	} {
+		got, err := caBundlePath(tc.value, "/project")
+		if err != nil || got != tc.want {
+			t.Errorf("caBundlePath(%q) = %q, %v; want %q", tc.value, got, err, tc.want)
+		}
+	}
+}
+
+// TestApplyCABundle pins that Chart.yaml globalOptions.caBundle reaches
+// the shared clients, and that a broken bundle names the key.
+func TestApplyCABundle(t *testing.T) {
+	dir := t.TempDir()
+	setRoot(t, dir)
+
+	caBundle := Config.GlobalOptions.CABundle
+
+	t.Cleanup(func() {
+		Config.GlobalOptions.CABundle = caBundle
+		_ = httpclient.SetCABundle("")
+	})
+
+	writeBundleCA(t, filepath.Join(dir, "corp.pem"), "corp")
+
+	Config.GlobalOptions.CABundle = "corp.pem"
+	if err := ApplyCABundle(); err != nil {
+		t.Fatal(err)
+	}
+
+	if httpclient.CABundle() == nil {
+		t.Error("the bundle was not loaded")
+	}
+
+	Config.GlobalOptions.CABundle = "missing.pem"
+	if err := ApplyCABundle(); err == nil || !strings.Contains(err.Error(), caBundleKey) {
+		t.Errorf("err = %v, want it to name %s", err, caBundleKey)
+	}
+}
+
+// TestTrustCABundle pins that the bundle joins the CA a kubeconfig
+// pins, from data or from a file, and leaves a config without one on
+// the system roots.
+func TestTrustCABundle(t *testing.T) {
+	dir := t.TempDir()
+	corp := writeBundleCA(t, filepath.Join(dir, "corp.pem"), "corp")
+	cluster := writeBundleCA(t, filepath.Join(dir, "cluster.pem"), "cluster")
+
+	t.Cleanup(func() { _ = httpclient.SetCABundle("") })
+
+	config := &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: cluster}}
+	if err := trustCABundle(config); err != nil || string(config.CAData) != string(cluster) {
+		t.Errorf("without a bundle the config changed: %v", err)
+	}
+
+	if err := httpclient.SetCABundle(filepath.Join(dir, "corp.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trustCABundle(config); err != nil || string(config.CAData) != string(cluster)+string(corp) {
+		t.Errorf("CAData = %q, %v", config.CAData, err)
+	}
+
+	fromFile := &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: filepath.Join(dir, "cluster.pem")}}
+	if err := trustCABundle(fromFile); err != nil || fromFile.CAFile != "" || string(fromFile.CAData) != string(cluster)+string(corp) {
+		t.Errorf("CAFile = %q, CAData = %q, %v", fromFile.CAFile, fromFile.CAData, err)
+	}
+
+	systemRoots := &rest.Config{}
+	if err := trustCABundle(systemRoots); err != nil || systemRoots.CAData != nil {
+		t.Errorf("a config on the system roots got CAData %q, %v", systemRoots.CAData, err)
+	}
+}
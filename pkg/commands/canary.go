@@ -0,0 +1,266 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/pflag"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	rolloutStrategyAll    = "all"
+	rolloutStrategyCanary = "canary"
+
+	// defaultCanarySettle is how long the canary runs on its new
+	// config or image before the health checks judge it: long enough
+	// for services restarted by the change to come back.
+	defaultCanarySettle = 30 * time.Second
+)
+
+// canaryOptions are the rollout flags apply and upgrade share. The
+// default strategy updates every node in one pass; the canary
+// strategy updates the first size nodes of the group, checks their
+// health and only then updates the rest.
+type canaryOptions struct {
+	strategy string
+	size     int
+	settle   time.Duration
+}
+
+func (o canaryOptions) enabled() bool {
+	return o.strategy == rolloutStrategyCanary
+}
+
+func addCanaryFlags(flags *pflag.FlagSet, opts *canaryOptions) {
+	flags.StringVar(&opts.strategy, "strategy", rolloutStrategyAll, "rollout strategy: all updates every node at once, canary updates --canary nodes first and rolls them back if their health checks fail")
+	flags.IntVar(&opts.size, "canary", 1, "number of nodes the canary strategy updates first")
+	flags.DurationVar(&opts.settle, "canary-settle", defaultCanarySettle, "how long the canary runs before its health checks")
+}
+
+// validateCanaryOptions rejects an unknown strategy and a canary of
+// no nodes before anything is changed.
+func validateCanaryOptions(opts canaryOptions) error {
+	switch opts.strategy {
+	case rolloutStrategyAll:
+		return nil
+	case rolloutStrategyCanary:
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown --strategy %q", opts.strategy),
+			"use all or canary",
+		)
+	}
+
+	if opts.size < 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--canary %d updates no node first", opts.size),
+			"use --canary 1 or more",
+		)
+	}
+
+	return nil
+}
+
+// canarySteps are the operation-specific parts of a canary rollout.
+// save is nil when the rollback needs nothing read beforehand.
+type canarySteps struct {
+	save     func(ctx context.Context, node string) ([]byte, error)
+	rollout  func(nodes []string) error
+	health   func(ctx context.Context, nodes []string) []healthResult
+	rollback func(ctx context.Context, node string, saved []byte) error
+}
+
+// Canary decisions, as reported.
+const (
+	canaryProceed  = "proceed"
+	canaryRollBack = "roll back"
+	canaryHalt     = "halt"
+)
+
+// canaryDecision is the outcome of a canary rollout: what the canary
+// nodes were, how their health checks went and what was done next.
+type canaryDecision struct {
+	canary     []string
+	rest       []string
+	health     healthReport
+	decision   string
+	rolledBack []string
+}
+
+// splitCanary returns the first size nodes as the canary and the
+// others as the rest of the group.
+func splitCanary(nodes []string, size int) ([]string, []string) {
+	if size >= len(nodes) {
+		return nodes, nil
+	}
+
+	return nodes[:size], nodes[size:]
+}
+
+// runCanary updates the canary nodes, lets them settle, runs the
+// health checks on them and proceeds with the rest of the group only
+// when every check passes. A failed check rolls the canary back with
+// what save read before the change. A failed canary rollout halts
+// without a rollback: the operation's own gates have already reported
+// what happened on the node, and a rollback on top of a change that
+// may not have happened would be a second, unreviewed change.
+func runCanary(ctx context.Context, nodes []string, opts canaryOptions, steps canarySteps, w io.Writer) error {
+	if len(nodes) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("the canary strategy needs the list of nodes to update"),
+			"name the nodes with --nodes or the `# talm: nodes=[...]` modeline of the node file",
+		)
+	}
+
+	canary, rest := splitCanary(nodes, opts.size)
+	decision := canaryDecision{canary: canary, rest: rest}
+
+	saved := make(map[string][]byte, len(canary))
+
+	if steps.save != nil {
+		for _, node := range canary {
+			data, err := steps.save(ctx, node)
+			if err != nil {
+				return errors.Wrapf(err, "saving the current config of canary node %s", node)
+			}
+
+			saved[node] = data
+		}
+	}
+
+	ui.Infof(w, "Canary: updating %s first (%d of %d nodes)", strings.Join(canary, ", "), len(canary), len(nodes))
+
+	if err := steps.rollout(canary); err != nil {
+		decision.decision = canaryHalt
+		writeCanaryDecision(w, decision)
+
+		return errors.Wrap(err, "canary")
+	}
+
+	if err := sleepContext(ctx, opts.settle); err != nil {
+		return errors.Wrap(err, "waiting for the canary to settle")
+	}
+
+	decision.health = buildHealthReport(time.Now(), canary, steps.health(ctx, canary))
+
+	if decision.health.Summary.Failed == 0 {
+		decision.decision = canaryProceed
+		writeCanaryDecision(w, decision)
+
+		if len(rest) == 0 {
+			return nil
+		}
+
+		return steps.rollout(rest)
+	}
+
+	decision.decision = canaryRollBack
+
+	var rollbackErrs []error
+
+	for _, node := range canary {
+		if err := steps.rollback(ctx, node, saved[node]); err != nil {
+			rollbackErrs = append(rollbackErrs, errors.Wrapf(err, "rolling back %s", node))
+
+			continue
+		}
+
+		decision.rolledBack = append(decision.rolledBack, node)
+	}
+
+	writeCanaryDecision(w, decision)
+
+	err := errors.Newf("%d health check(s) failed on the canary; rolled back %s, left %s untouched",
+		decision.health.Summary.Failed, joinOrNone(decision.rolledBack), joinOrNone(rest))
+	if len(rollbackErrs) > 0 {
+		err = errors.Wrapf(errors.Join(rollbackErrs...), "%d health check(s) failed on the canary and its rollback failed", decision.health.Summary.Failed)
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(err, "see the canary report above for the failing checks; run `talm healthcheck` on the canary to follow up")
+}
+
+// writeCanaryDecision prints the canary report: the health checks of
+// the canary and the decision taken on them.
+func writeCanaryDecision(w io.Writer, d canaryDecision) {
+	if len(d.health.Results) > 0 {
+		_ = writeHealthReport(w, healthOutputText, d.health) //nolint:errcheck // best-effort report on the progress stream
+	}
+
+	switch d.decision {
+	case canaryProceed:
+		if len(d.rest) == 0 {
+			ui.Successf(w, "Canary decision: proceed; %s healthy, no other node in the group", strings.Join(d.canary, ", "))
+
+			return
+		}
+
+		ui.Successf(w, "Canary decision: proceed; %s healthy, updating %s", strings.Join(d.canary, ", "), strings.Join(d.rest, ", "))
+	case canaryRollBack:
+		ui.Warnf(w, "Canary decision: roll back; %s failed the health checks, rolled back %s, %s left untouched",
+			strings.Join(d.canary, ", "), joinOrNone(d.rolledBack), joinOrNone(d.rest))
+	case canaryHalt:
+		ui.Warnf(w, "Canary decision: halt; updating %s failed, %s left untouched", strings.Join(d.canary, ", "), joinOrNone(d.rest))
+	}
+}
+
+// canaryHealthCheck runs the healthcheck suite on the canary through
+// c. The thresholds are the `talm healthcheck` flag defaults, which
+// pflag has stored in healthcheckCmdFlags by the time a command runs.
+func canaryHealthCheck(c *client.Client) func(ctx context.Context, nodes []string) []healthResult {
+	return func(ctx context.Context, nodes []string) []healthResult {
+		suite := healthSuite{
+			checks:         slices.Clone(healthCheckNames),
+			minFreePercent: healthcheckCmdFlags.minFreePercent,
+			certWarn:       time.Duration(healthcheckCmdFlags.certWarnDays) * 24 * time.Hour,
+			timeout:        healthcheckCmdFlags.timeout,
+			parallel:       healthcheckCmdFlags.parallel,
+			now:            time.Now(),
+			probe:          talosHealthProbe{c: c},
+		}
+
+		suite.loadKubernetesNodes(ctx)
+
+		return suite.run(ctx, nodes)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck // wrapped by the caller.
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// fakeCanary records what runCanary asks of the operation.
+type fakeCanary struct {
+	rollouts   [][]string
+	rolledBack map[string]string
+	unhealthy  map[string]bool
+	rolloutErr error
+}
+
+func (f *fakeCanary) steps() canarySteps {
+	f.rolledBack = map[string]string{}
+
+	return canarySteps{
+		save: func(_ context.Context, node string) ([]byte, error) {
+			return []byte("config of " + node), nil
+		},
+		rollout: func(nodes []string) error {
+			f.rollouts = append(f.rollouts, nodes)
+
+			return f.rolloutErr
+		},
+		health: func(_ context.Context, nodes []string) []healthResult {
+			var results []healthResult
+
+			for _, node := range nodes {
+				status := healthStatusPass
+				if f.unhealthy[node] {
+					status = healthStatusFail
+				}
+
+				results = append(results, healthResult{Node: node, Check: healthCheckAPI, Status: status})
+			}
+
+			return results
+		},
+		rollback: func(_ context.Context, node string, saved []byte) error {
+			f.rolledBack[node] = string(saved)
+
+			return nil
+		},
+	}
+}
+
+func TestRunCanary_Proceeds(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{}
+
+	var out bytes.Buffer
+
+	err := runCanary(context.Background(), []string{"a", "b", "c"}, canaryOptions{strategy: rolloutStrategyCanary, size: 1}, fake.steps(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := [][]string{{"a"}, {"b", "c"}}; !reflect.DeepEqual(fake.rollouts, want) {
+		t.Errorf("rollouts = %v, want %v", fake.rollouts, want)
+	}
+
+	if len(fake.rolledBack) != 0 {
+		t.Errorf("a healthy canary must not be rolled back, got %v", fake.rolledBack)
+	}
+
+	if !strings.Contains(out.String(), "Canary decision: proceed; a healthy, updating b, c") {
+		t.Errorf("report = %q", out.String())
+	}
+}
+
+// TestRunCanary_RollsBack pins the rollback: the canary gets back what
+// save read before the change, and the rest of the group is never
+// touched.
+func TestRunCanary_RollsBack(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{unhealthy: map[string]bool{"b": true}}
+
+	var out bytes.Buffer
+
+	err := runCanary(context.Background(), []string{"a", "b", "c"}, canaryOptions{strategy: rolloutStrategyCanary, size: 2}, fake.steps(), &out)
+	if err == nil || !strings.Contains(err.Error(), "1 health check(s) failed on the canary; rolled back a, b, left c untouched") {
+		t.Fatalf("error = %v", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "talm healthcheck") {
+		t.Errorf("hints = %q", hints)
+	}
+
+	if want := [][]string{{"a", "b"}}; !reflect.DeepEqual(fake.rollouts, want) {
+		t.Errorf("rollouts = %v, want only the canary", fake.rollouts)
+	}
+
+	if want := map[string]string{"a": "config of a", "b": "config of b"}; !reflect.DeepEqual(fake.rolledBack, want) {
+		t.Errorf("rolled back = %v, want %v", fake.rolledBack, want)
+	}
+
+	for _, line := range []string{"NODE", "FAIL", "Canary decision: roll back"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("report lacks %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestRunCanary_RollbackFails(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{unhealthy: map[string]bool{"a": true}}
+	steps := fake.steps()
+	steps.rollback = func(context.Context, string, []byte) error {
+		return errors.New("connection refused")
+	}
+
+	err := runCanary(context.Background(), []string{"a", "b"}, canaryOptions{strategy: rolloutStrategyCanary, size: 1}, steps, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "its rollback failed") || !strings.Contains(err.Error(), "rolling back a: connection refused") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+// TestRunCanary_HaltsOnRolloutError pins that a failed canary update
+// stops the rollout without a rollback.
+func TestRunCanary_HaltsOnRolloutError(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{rolloutErr: errors.New("node a: applying new configuration")}
+
+	var out bytes.Buffer
+
+	err := runCanary(context.Background(), []string{"a", "b"}, canaryOptions{strategy: rolloutStrategyCanary, size: 1}, fake.steps(), &out)
+	if err == nil || !strings.Contains(err.Error(), "applying new configuration") {
+		t.Fatalf("error = %v", err)
+	}
+
+	if len(fake.rollouts) != 1 || len(fake.rolledBack) != 0 {
+		t.Errorf("rollouts = %v, rolled back = %v", fake.rollouts, fake.rolledBack)
+	}
+
+	if !strings.Contains(out.String(), "Canary decision: halt; updating a failed, b left untouched") {
+		t.Errorf("report = %q", out.String())
+	}
+}
+
+func TestRunCanary_SaveFailsBeforeAnyChange(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{}
+	steps := fake.steps()
+	steps.save = func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("permission denied")
+	}
+
+	err := runCanary(context.Background(), []string{"a", "b"}, canaryOptions{strategy: rolloutStrategyCanary, size: 1}, steps, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "saving the current config of canary node a") {
+		t.Errorf("error = %v", err)
+	}
+
+	if len(fake.rollouts) != 0 {
+		t.Errorf("nothing may change when the rollback copy cannot be read, got %v", fake.rollouts)
+	}
+}
+
+func TestRunCanary_NoNodes(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeCanary{}
+
+	err := runCanary(context.Background(), nil, canaryOptions{strategy: rolloutStrategyCanary, size: 1}, fake.steps(), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "needs the list of nodes") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestSplitCanary(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		nodes        []string
+		size         int
+		canary, rest []string
+	}{
+		{[]string{"a", "b", "c"}, 1, []string{"a"}, []string{"b", "c"}},
+		{[]string{"a", "b", "c"}, 2, []string{"a", "b"}, []string{"c"}},
+		{[]string{"a", "b"}, 2, []string{"a", "b"}, nil},
+		{[]string{"a"}, 3, []string{"a"}, nil},
+	} {
+		canary, rest := splitCanary(tc.nodes, tc.size)
+		if !reflect.DeepEqual(canary, tc.canary) || !reflect.DeepEqual(rest, tc.rest) {
+			t.Errorf("splitCanary(%v, %d) = %v, %v; want %v, %v", tc.nodes, tc.size, canary, rest, tc.canary, tc.rest)
+		}
+	}
+}
+
+func TestValidateCanaryOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		opts canaryOptions
+		want string
+	}{
+		{canaryOptions{strategy: rolloutStrategyAll}, ""},
+		{canaryOptions{strategy: rolloutStrategyAll, size: 0}, ""},
+		{canaryOptions{strategy: rolloutStrategyCanary, size: 1}, ""},
+		{canaryOptions{strategy: rolloutStrategyCanary, size: 0}, "--canary 0"},
+		{canaryOptions{strategy: "blue-green", size: 1}, `unknown --strategy "blue-green"`},
+	} {
+		err := validateCanaryOptions(tc.opts)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error %v", tc.opts, err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error = %v, want %q", tc.opts, err, tc.want)
+		}
+	}
+}
+
+func TestValidateUpgradeCanary(t *testing.T) {
+	original := upgradeCmdFlags.canary
+	t.Cleanup(func() { upgradeCmdFlags.canary = original })
+
+	upgradeCmdFlags.canary = canaryOptions{strategy: rolloutStrategyAll, size: 1}
+	if err := validateUpgradeCanary(true, true); err != nil {
+		t.Errorf("the default strategy takes every flag, got %v", err)
+	}
+
+	upgradeCmdFlags.canary.strategy = rolloutStrategyCanary
+	if err := validateUpgradeCanary(false, false); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := validateUpgradeCanary(true, false); err == nil || !strings.Contains(err.Error(), "--insecure") {
+		t.Errorf("error = %v, want --insecure refused", err)
+	}
+
+	if err := validateUpgradeCanary(false, true); err == nil || !strings.Contains(err.Error(), "--stage") {
+		t.Errorf("error = %v, want --stage refused", err)
+	}
+}
+
+func TestValidateApplyCanary(t *testing.T) {
+	originalCanary, originalInsecure, originalDryRun := applyCmdFlags.canary, applyCmdFlags.insecure, applyCmdFlags.dryRun
+	t.Cleanup(func() {
+		applyCmdFlags.canary, applyCmdFlags.insecure, applyCmdFlags.dryRun = originalCanary, originalInsecure, originalDryRun
+	})
+
+	applyCmdFlags.canary = canaryOptions{strategy: rolloutStrategyCanary, size: 1}
+	applyCmdFlags.insecure = true
+
+	if err := validateApplyCanary(); err == nil || !strings.Contains(err.Error(), "--insecure") {
+		t.Errorf("error = %v, want --insecure refused", err)
+	}
+
+	applyCmdFlags.insecure = false
+	applyCmdFlags.dryRun = true
+
+	if err := validateApplyCanary(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if applyCanaryEnabled() {
+		t.Error("a dry run has nothing to roll back and must not run as a canary")
+	}
+}
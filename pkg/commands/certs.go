@@ -0,0 +1,586 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/resource/meta"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/cosi-project/runtime/pkg/state"
+	"github.com/siderolabs/crypto/x509"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	generatesecrets "github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/resources/secrets"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/cozystack/talm/pkg/certexpiry"
+)
+
+// Sources of the certificates the inventory lists, besides the nodes,
+// which are named by address.
+const (
+	certSourceSecrets     = "secrets.yaml"
+	certSourceTalosconfig = "talosconfig"
+	certSourceKubeconfig  = "kubeconfig"
+)
+
+// Keys of the CAs in secrets.yaml, spelled as certexpiry.Entry.Key.
+const (
+	certKeyTalosCA      = "talosCA"
+	certKeyKubernetesCA = "kubernetesCA"
+	certKeyAggregatorCA = "kubernetesAggregatorCA"
+	certKeyEtcdCA       = "etcdCA"
+)
+
+// Certificate statuses other than a mismatch.
+const (
+	certStatusOK      = "ok"
+	certStatusSoon    = "expires soon"
+	certStatusExpired = "EXPIRED"
+)
+
+// defaultCertsWarnDays is the remaining validity below which a
+// certificate is marked as expiring soon. Admin and client certs are
+// issued for a year, so this is shorter than the CA threshold of
+// `talm secrets status`.
+const defaultCertsWarnDays = 30
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var certsCmdFlags struct {
+	local    bool
+	output   string
+	warnDays int
+	timeout  time.Duration
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "List the cluster certificates in local files and on the nodes",
+	Long: `List every certificate talm knows about, with subject, issuer and expiry:
+
+  secrets.yaml  the Talos API, Kubernetes, Kubernetes aggregator and etcd CAs
+  talosconfig   the CA and the admin client certificate of every context
+  kubeconfig    the cluster CA and the client certificate of every user
+  nodes         the CAs each node runs with and its Talos API server
+                certificate, read through the Talos API
+
+Nodes are taken from the modelines of the node files under nodes/, or from
+--nodes; --local skips them.
+
+A certificate is flagged as a mismatch when it does not agree with
+secrets.yaml: a node serving a different CA than the project holds, or a
+talosconfig or kubeconfig certificate that the project CA did not sign.
+That is the state a half-finished rotate-ca or a stale kubeconfig leaves
+behind. The command exits non-zero when there is a mismatch.`,
+	Example: `  # Inventory of local files and every node
+  talm certs
+
+  # Only the local files, as JSON
+  talm certs --local -o json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runCerts(cmd.OutOrStdout(), os.Stderr)
+	},
+}
+
+// certRecord is one certificate of the inventory. Problem is set when
+// the certificate disagrees with secrets.yaml.
+type certRecord struct {
+	Source      string    `json:"source"`
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"notAfter"`
+	SHA256      string    `json:"sha256"`
+	Status      string    `json:"status"`
+	Problem     string    `json:"problem,omitempty"`
+	certificate *stdx509.Certificate
+}
+
+// projectCAs are the CAs of secrets.yaml, by key, that the other
+// sources are checked against.
+type projectCAs map[string]*stdx509.Certificate
+
+// nodeCertReader reads the certificates of one node; tests substitute
+// a fake for talosNodeCerts.
+type nodeCertReader func(ctx context.Context, node string) ([]certRecord, error)
+
+func runCerts(out, progress io.Writer) error {
+	if certsCmdFlags.output != healthOutputText && certsCmdFlags.output != healthOutputJSON {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown --output %q", certsCmdFlags.output),
+			"use one of: text, json",
+		)
+	}
+
+	records, cas, err := localCertRecords(progress)
+	if err != nil {
+		return err
+	}
+
+	if !certsCmdFlags.local {
+		nodes, err := resolveHealthNodes(progress)
+		if err != nil {
+			return err
+		}
+
+		err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			records = append(records, collectNodeCerts(ctx, progress, nodes, cas, talosNodeCerts(c))...)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for i := range records {
+		records[i].Status = certStatus(records[i], now, certsCmdFlags.warnDays)
+	}
+
+	if err := writeCertInventory(out, certsCmdFlags.output, records, now); err != nil {
+		return err
+	}
+
+	if mismatches := countCertMismatches(records); mismatches > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%d certificates do not match secrets.yaml", mismatches),
+			"a node serving another CA is usually a rotate-ca that did not finish; a client certificate from another CA needs a fresh `talm talosconfig` or `talm kubeconfig`",
+		)
+	}
+
+	return nil
+}
+
+// localCertRecords lists the certificates of secrets.yaml, the
+// talosconfig and the project kubeconfig. Only secrets.yaml is
+// required; a missing talosconfig or kubeconfig is reported on
+// progress and skipped.
+func localCertRecords(progress io.Writer) ([]certRecord, projectCAs, error) {
+	secretsPath := ResolveSecretsPath(Config.TemplateOptions.WithSecrets)
+	if !fileExists(secretsPath) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, nil, errors.WithHint(
+			errors.Newf("secrets.yaml not found at %s", secretsPath),
+			"run 'talm init' or restore secrets.yaml (decrypt it with `talm init --decrypt`)",
+		)
+	}
+
+	bundle, err := generatesecrets.LoadBundle(secretsPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load secrets bundle")
+	}
+
+	records, cas, err := secretsCertRecords(bundle)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading %s", secretsPath)
+	}
+
+	talosconfig, err := loadTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		fmt.Fprintf(progress, "Skipping the talosconfig: %v\n", err)
+	} else {
+		records = append(records, talosconfigCertRecords(talosconfig, cas)...)
+	}
+
+	kubeconfig, err := clientcmd.LoadFromFile(projectKubeconfigPath())
+	if err != nil {
+		fmt.Fprintf(progress, "Skipping the kubeconfig: %v\n", err)
+	} else {
+		records = append(records, kubeconfigCertRecords(kubeconfig, cas)...)
+	}
+
+	return records, cas, nil
+}
+
+// secretsCertRecords lists the CAs of the bundle in the order of
+// `talm secrets status` and returns them keyed for the checks.
+func secretsCertRecords(bundle *generatesecrets.Bundle) ([]certRecord, projectCAs, error) {
+	if bundle == nil || bundle.Certs == nil {
+		return nil, nil, errors.New("secrets bundle carries no certificates")
+	}
+
+	cas := projectCAs{}
+
+	var records []certRecord
+
+	for _, ca := range []struct {
+		key, name string
+		pem       *x509.PEMEncodedCertificateAndKey
+	}{
+		{certKeyTalosCA, "Talos API CA", bundle.Certs.OS},
+		{certKeyKubernetesCA, "Kubernetes CA", bundle.Certs.K8s},
+		{certKeyAggregatorCA, "Kubernetes aggregator CA", bundle.Certs.K8sAggregator},
+		{certKeyEtcdCA, "etcd CA", bundle.Certs.Etcd},
+	} {
+		if ca.pem == nil || len(ca.pem.Crt) == 0 {
+			continue
+		}
+
+		record, err := certRecordFromPEM(certSourceSecrets, ca.name, ca.pem.Crt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cas[ca.key] = record.certificate
+		records = append(records, record)
+	}
+
+	return records, cas, nil
+}
+
+// talosconfigCertRecords lists the CA and admin certificate of every
+// context, in context name order. The admin certificate must be signed
+// by the project Talos CA and the context CA must be that CA.
+func talosconfigCertRecords(cfg *clientconfig.Config, cas projectCAs) []certRecord {
+	var records []certRecord
+
+	for _, name := range sortedKeys(cfg.Contexts) {
+		configContext := cfg.Contexts[name]
+
+		if record, ok := base64CertRecord(certSourceTalosconfig, "CA (context "+name+")", configContext.CA); ok {
+			records = append(records, checkSameCA(record, cas, certKeyTalosCA))
+		}
+
+		if record, ok := base64CertRecord(certSourceTalosconfig, "admin cert (context "+name+")", configContext.Crt); ok {
+			records = append(records, checkSignedBy(record, cas, certKeyTalosCA))
+		}
+	}
+
+	return records
+}
+
+// kubeconfigCertRecords lists the CA of every cluster and the client
+// certificate of every user, in name order, checked against the
+// project Kubernetes CA.
+func kubeconfigCertRecords(cfg *clientcmdapi.Config, cas projectCAs) []certRecord {
+	var records []certRecord
+
+	for _, name := range sortedKeys(cfg.Clusters) {
+		if data := cfg.Clusters[name].CertificateAuthorityData; len(data) > 0 {
+			records = append(records, checkSameCA(pemCertRecord(certSourceKubeconfig, "CA (cluster "+name+")", data), cas, certKeyKubernetesCA))
+		}
+	}
+
+	for _, name := range sortedKeys(cfg.AuthInfos) {
+		if data := cfg.AuthInfos[name].ClientCertificateData; len(data) > 0 {
+			records = append(records, checkSignedBy(pemCertRecord(certSourceKubeconfig, "client cert (user "+name+")", data), cas, certKeyKubernetesCA))
+		}
+	}
+
+	return records
+}
+
+// collectNodeCerts reads every node in turn. A node that cannot be
+// read is reported on progress and left out; the inventory of the
+// others is still useful.
+func collectNodeCerts(ctx context.Context, progress io.Writer, nodes []string, cas projectCAs, read nodeCertReader) []certRecord {
+	var records []certRecord
+
+	for _, node := range nodes {
+		nodeCtx, cancel := ctx, context.CancelFunc(func() {})
+		if certsCmdFlags.timeout > 0 {
+			nodeCtx, cancel = context.WithTimeout(ctx, certsCmdFlags.timeout)
+		}
+
+		nodeRecords, err := read(nodeCtx, node)
+
+		cancel()
+
+		if err != nil {
+			fmt.Fprintf(progress, "Skipping node %s: %v\n", node, err)
+
+			continue
+		}
+
+		for _, record := range nodeRecords {
+			records = append(records, checkNodeCert(record, cas))
+		}
+	}
+
+	return records
+}
+
+// nodeCertCA maps the name of a node CA record to the project CA it
+// must equal. The API server certificate is checked by signature.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var nodeCertCA = map[string]string{
+	"Talos API CA":             certKeyTalosCA,
+	"Kubernetes CA":            certKeyKubernetesCA,
+	"Kubernetes aggregator CA": certKeyAggregatorCA,
+	"etcd CA":                  certKeyEtcdCA,
+}
+
+// nodeAPIServerCertName names the Talos API server certificate of a
+// node.
+const nodeAPIServerCertName = "Talos API server cert"
+
+func checkNodeCert(record certRecord, cas projectCAs) certRecord {
+	if key, ok := nodeCertCA[record.Name]; ok {
+		return checkSameCA(record, cas, key)
+	}
+
+	if record.Name == nodeAPIServerCertName {
+		return checkSignedBy(record, cas, certKeyTalosCA)
+	}
+
+	return record
+}
+
+// talosNodeCerts reads the CAs a node runs with from the secrets
+// roots and its API server certificate. The Kubernetes and etcd roots
+// exist on control-plane nodes only; their absence is not an error.
+func talosNodeCerts(c *client.Client) nodeCertReader {
+	return func(ctx context.Context, node string) ([]certRecord, error) {
+		ctx = client.WithNode(ctx, node)
+		source := "node " + node
+
+		var records []certRecord
+
+		add := func(name string, crt []byte) {
+			if len(crt) > 0 {
+				records = append(records, pemCertRecord(source, name, crt))
+			}
+		}
+
+		osRoot, err := safe.StateGetByID[*secrets.OSRoot](ctx, c.COSI, secrets.OSRootID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the Talos API CA")
+		}
+
+		if ca := osRoot.TypedSpec().IssuingCA; ca != nil {
+			add("Talos API CA", ca.Crt)
+		}
+
+		k8sRoot, err := getOptionalRoot[*secrets.KubernetesRoot](ctx, c, secrets.KubernetesRootID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the Kubernetes CAs")
+		}
+
+		if k8sRoot != nil {
+			if ca := k8sRoot.TypedSpec().IssuingCA; ca != nil {
+				add("Kubernetes CA", ca.Crt)
+			}
+
+			if ca := k8sRoot.TypedSpec().AggregatorCA; ca != nil {
+				add("Kubernetes aggregator CA", ca.Crt)
+			}
+		}
+
+		etcdRoot, err := getOptionalRoot[*secrets.EtcdRoot](ctx, c, secrets.EtcdRootID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the etcd CA")
+		}
+
+		if etcdRoot != nil && etcdRoot.TypedSpec().EtcdCA != nil {
+			add("etcd CA", etcdRoot.TypedSpec().EtcdCA.Crt)
+		}
+
+		api, err := safe.StateGetByID[*secrets.API](ctx, c.COSI, secrets.APIID)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the Talos API certificate")
+		}
+
+		if server := api.TypedSpec().Server; server != nil {
+			add(nodeAPIServerCertName, server.Crt)
+		}
+
+		return records, nil
+	}
+}
+
+// getOptionalRoot reads a secrets root that only control-plane nodes
+// carry, returning nil on a worker.
+func getOptionalRoot[T meta.ResourceWithRD](ctx context.Context, c *client.Client, id resource.ID) (T, error) {
+	res, err := safe.StateGetByID[T](ctx, c.COSI, id)
+	if state.IsNotFoundError(err) {
+		var zero T
+
+		return zero, nil
+	}
+
+	return res, err //nolint:wrapcheck // wrapped by the caller, which names the root.
+}
+
+// certRecordFromPEM parses the first certificate of a PEM block.
+func certRecordFromPEM(source, name string, data []byte) (certRecord, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return certRecord{}, errors.Newf("%s: no PEM certificate", name)
+	}
+
+	crt, err := stdx509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return certRecord{}, errors.Wrapf(err, "parsing %s", name)
+	}
+
+	sum := sha256.Sum256(crt.Raw)
+
+	return certRecord{
+		Source:      source,
+		Name:        name,
+		Subject:     crt.Subject.String(),
+		Issuer:      crt.Issuer.String(),
+		NotAfter:    crt.NotAfter,
+		SHA256:      hex.EncodeToString(sum[:]),
+		certificate: crt,
+	}, nil
+}
+
+// pemCertRecord is certRecordFromPEM for sources outside the project:
+// an unparseable certificate becomes a record carrying the problem
+// rather than failing the whole inventory.
+func pemCertRecord(source, name string, data []byte) certRecord {
+	record, err := certRecordFromPEM(source, name, data)
+	if err != nil {
+		return certRecord{Source: source, Name: name, Problem: err.Error()}
+	}
+
+	return record
+}
+
+// base64CertRecord decodes a talosconfig field (base64 of the PEM);
+// an empty field has no certificate.
+func base64CertRecord(source, name, value string) (certRecord, bool) {
+	if value == "" {
+		return certRecord{}, false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return certRecord{Source: source, Name: name, Problem: "not base64: " + err.Error()}, true
+	}
+
+	return pemCertRecord(source, name, data), true
+}
+
+// checkSameCA flags record when it is not the project CA under key.
+func checkSameCA(record certRecord, cas projectCAs, key string) certRecord {
+	ca, ok := cas[key]
+	if record.Problem != "" || record.certificate == nil || !ok {
+		return record
+	}
+
+	if !bytes.Equal(record.certificate.Raw, ca.Raw) {
+		record.Problem = "differs from the CA in " + certSourceSecrets
+	}
+
+	return record
+}
+
+// checkSignedBy flags record when the project CA under key did not
+// sign it.
+func checkSignedBy(record certRecord, cas projectCAs, key string) certRecord {
+	ca, ok := cas[key]
+	if record.Problem != "" || record.certificate == nil || !ok {
+		return record
+	}
+
+	if err := record.certificate.CheckSignatureFrom(ca); err != nil {
+		record.Problem = "not signed by the CA in " + certSourceSecrets
+	}
+
+	return record
+}
+
+// certStatus renders the status column: the problem when there is
+// one, otherwise the expiry state against warnDays.
+func certStatus(record certRecord, now time.Time, warnDays int) string {
+	if record.Problem != "" {
+		return "MISMATCH: " + record.Problem
+	}
+
+	switch daysLeft := certexpiry.DaysLeft(record.NotAfter, now); {
+	case daysLeft < 0:
+		return certStatusExpired
+	case daysLeft < warnDays:
+		return certStatusSoon
+	default:
+		return certStatusOK
+	}
+}
+
+// countCertMismatches counts the records that disagree with
+// secrets.yaml.
+func countCertMismatches(records []certRecord) int {
+	count := 0
+
+	for _, record := range records {
+		if record.Problem != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// writeCertInventory writes the records as a table or as JSON.
+func writeCertInventory(w io.Writer, format string, records []certRecord, now time.Time) error {
+	if format == healthOutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return errors.Wrap(enc.Encode(records), "encoding the certificate inventory")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tCERTIFICATE\tSUBJECT\tISSUER\tEXPIRES\tDAYS LEFT\tSTATUS")
+
+	for _, record := range records {
+		if record.certificate == nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t-\t%s\n", record.Source, record.Name, record.Status)
+
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			record.Source, record.Name, record.Subject, record.Issuer,
+			record.NotAfter.UTC().Format(time.DateOnly), certexpiry.DaysLeft(record.NotAfter, now), record.Status)
+	}
+
+	return errors.Wrap(tw.Flush(), "writing the certificate inventory")
+}
+
+func init() {
+	certsCmd.Flags().BoolVar(&certsCmdFlags.local, "local", false, "list only the certificates in local files, without reading the nodes")
+	certsCmd.Flags().StringVarP(&certsCmdFlags.output, "output", "o", healthOutputText, "output format: text or json")
+	certsCmd.Flags().IntVar(&certsCmdFlags.warnDays, "warn-days", defaultCertsWarnDays, "mark certificates with fewer days left than this as expiring soon")
+	certsCmd.Flags().DurationVar(&certsCmdFlags.timeout, "timeout", 15*time.Second, "time limit for reading the certificates of each node")
+
+	addCommand(certsCmd)
+}
@@ -0,0 +1,285 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/crypto/x509"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	generatesecrets "github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testCert is a generated certificate with its key, for signing.
+type testCert struct {
+	crt *stdx509.Certificate
+	key *ecdsa.PrivateKey
+	pem []byte
+}
+
+// newTestCert issues a certificate for cn, self-signed when parent is
+// nil. It is valid for validFor from now.
+func newTestCert(t *testing.T, cn string, parent *testCert, validFor time.Duration) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &stdx509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              stdx509.KeyUsageCertSign | stdx509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.crt, parent.key
+	}
+
+	der, err := stdx509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := stdx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{crt: crt, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// testProjectCAs builds a bundle with a Talos and a Kubernetes CA and
+// returns the CAs with the records read from it.
+func testProjectCAs(t *testing.T) (*testCert, *testCert, []certRecord, projectCAs) {
+	t.Helper()
+
+	talosCA := newTestCert(t, "talos", nil, 10*365*24*time.Hour)
+	k8sCA := newTestCert(t, "kubernetes", nil, 10*365*24*time.Hour)
+
+	bundle := &generatesecrets.Bundle{Certs: &generatesecrets.Certs{
+		OS:  &x509.PEMEncodedCertificateAndKey{Crt: talosCA.pem},
+		K8s: &x509.PEMEncodedCertificateAndKey{Crt: k8sCA.pem},
+	}}
+
+	records, cas, err := secretsCertRecords(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return talosCA, k8sCA, records, cas
+}
+
+func TestSecretsCertRecords(t *testing.T) {
+	t.Parallel()
+
+	_, _, records, cas := testProjectCAs(t)
+
+	if len(records) != 2 || records[0].Name != "Talos API CA" || records[1].Name != "Kubernetes CA" {
+		t.Fatalf("records = %+v", records)
+	}
+
+	if records[0].Subject != "CN=talos" || records[0].Issuer != "CN=talos" || records[0].Source != certSourceSecrets || len(records[0].SHA256) != 64 {
+		t.Errorf("talos CA record = %+v", records[0])
+	}
+
+	if cas[certKeyTalosCA] == nil || cas[certKeyKubernetesCA] == nil || cas[certKeyEtcdCA] != nil {
+		t.Errorf("project CAs = %v", cas)
+	}
+
+	if _, _, err := secretsCertRecords(&generatesecrets.Bundle{}); err == nil {
+		t.Error("a bundle without certificates must be rejected")
+	}
+}
+
+func TestTalosconfigCertRecords(t *testing.T) {
+	t.Parallel()
+
+	talosCA, _, _, cas := testProjectCAs(t)
+	otherCA := newTestCert(t, "other", nil, time.Hour)
+
+	b64 := func(c *testCert) string { return base64.StdEncoding.EncodeToString(c.pem) }
+
+	cfg := &clientconfig.Config{Contexts: map[string]*clientconfig.Context{
+		"prod":  {CA: b64(talosCA), Crt: b64(newTestCert(t, "admin", talosCA, time.Hour))},
+		"stale": {CA: b64(otherCA), Crt: b64(newTestCert(t, "admin", otherCA, time.Hour))},
+		"empty": {},
+	}}
+
+	records := talosconfigCertRecords(cfg, cas)
+	if len(records) != 4 {
+		t.Fatalf("records = %+v", records)
+	}
+
+	for _, record := range records[:2] {
+		if record.Problem != "" || !strings.Contains(record.Name, "context prod") {
+			t.Errorf("prod record = %+v", record)
+		}
+	}
+
+	if records[2].Problem != "differs from the CA in secrets.yaml" || records[3].Problem != "not signed by the CA in secrets.yaml" {
+		t.Errorf("stale records = %+v, %+v", records[2], records[3])
+	}
+}
+
+func TestKubeconfigCertRecords(t *testing.T) {
+	t.Parallel()
+
+	_, k8sCA, _, cas := testProjectCAs(t)
+
+	cfg := &clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{"cozy": {CertificateAuthorityData: k8sCA.pem}},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"admin":  {ClientCertificateData: newTestCert(t, "admin", k8sCA, time.Hour).pem},
+			"broken": {ClientCertificateData: []byte("not a certificate")},
+			"token":  {Token: "abc"},
+		},
+	}
+
+	records := kubeconfigCertRecords(cfg, cas)
+	if len(records) != 3 {
+		t.Fatalf("records = %+v", records)
+	}
+
+	if records[0].Problem != "" || records[1].Problem != "" || records[1].Name != "client cert (user admin)" {
+		t.Errorf("records = %+v", records[:2])
+	}
+
+	if records[2].Problem == "" || records[2].certificate != nil {
+		t.Errorf("an unparseable certificate must be flagged, got %+v", records[2])
+	}
+}
+
+func TestCollectNodeCerts(t *testing.T) {
+	t.Parallel()
+
+	talosCA, _, _, cas := testProjectCAs(t)
+	otherCA := newTestCert(t, "other", nil, time.Hour)
+
+	read := func(_ context.Context, node string) ([]certRecord, error) {
+		source := "node " + node
+
+		switch node {
+		case "192.0.2.10":
+			return []certRecord{
+				pemCertRecord(source, "Talos API CA", talosCA.pem),
+				pemCertRecord(source, nodeAPIServerCertName, newTestCert(t, "server", talosCA, time.Hour).pem),
+			}, nil
+		case "192.0.2.11":
+			return []certRecord{pemCertRecord(source, "Talos API CA", otherCA.pem)}, nil
+		default:
+			return nil, errors.New("connection refused")
+		}
+	}
+
+	var progress bytes.Buffer
+
+	records := collectNodeCerts(context.Background(), &progress, []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"}, cas, read)
+	if len(records) != 3 {
+		t.Fatalf("records = %+v", records)
+	}
+
+	if records[0].Problem != "" || records[1].Problem != "" {
+		t.Errorf("the matching node must not be flagged: %+v", records[:2])
+	}
+
+	if records[2].Problem != "differs from the CA in secrets.yaml" {
+		t.Errorf("a node serving another CA must be flagged, got %+v", records[2])
+	}
+
+	if countCertMismatches(records) != 1 {
+		t.Errorf("mismatches = %d", countCertMismatches(records))
+	}
+
+	if !strings.Contains(progress.String(), "Skipping node 192.0.2.12: connection refused") {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
+
+func TestCertStatusAndInventory(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		record certRecord
+		want   string
+	}{
+		{certRecord{NotAfter: now.AddDate(1, 0, 0)}, certStatusOK},
+		{certRecord{NotAfter: now.AddDate(0, 0, 10)}, certStatusSoon},
+		{certRecord{NotAfter: now.AddDate(0, 0, -1)}, certStatusExpired},
+		{certRecord{NotAfter: now.AddDate(1, 0, 0), Problem: "differs"}, "MISMATCH: differs"},
+	} {
+		if got := certStatus(tc.record, now, defaultCertsWarnDays); got != tc.want {
+			t.Errorf("certStatus(%v, %q) = %q, want %q", tc.record.NotAfter, tc.record.Problem, got, tc.want)
+		}
+	}
+
+	record := pemCertRecord(certSourceSecrets, "Talos API CA", newTestCert(t, "talos", nil, time.Hour).pem)
+	record.NotAfter = now.AddDate(0, 0, 40)
+	record.Status = certStatusOK
+
+	broken := certRecord{Source: certSourceKubeconfig, Name: "client cert (user x)", Status: "MISMATCH: no PEM certificate"}
+
+	var out bytes.Buffer
+	if err := writeCertInventory(&out, healthOutputText, []certRecord{record, broken}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "SOURCE") {
+		t.Fatalf("inventory:\n%s", out.String())
+	}
+
+	for _, want := range []string{"Talos API CA", "CN=talos", "2026-02-10", "40", "ok"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("row %q is missing %q", lines[1], want)
+		}
+	}
+
+	if !strings.HasSuffix(lines[2], "MISMATCH: no PEM certificate") {
+		t.Errorf("row %q", lines[2])
+	}
+
+	out.Reset()
+
+	if err := writeCertInventory(&out, healthOutputJSON, []certRecord{record}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), `"subject": "CN=talos"`) || strings.Contains(out.String(), "certificate\"") {
+		t.Errorf("JSON inventory:\n%s", out.String())
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/crypto/x509"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// encryptedTalosconfigSuffix names the age-encrypted sibling of a
+// talosconfig that `talm init --encrypt` writes.
+const encryptedTalosconfigSuffix = ".encrypted"
+
+// talosClientRequest describes the Talos client a code path needs.
+// Every talm-native path that talks to a node builds its client from
+// one of these through withTalosClient, so the global connection flags
+// (--talosconfig, --context, --endpoints, --cluster, --skip-verify,
+// --siderov1-keys-dir) mean the same thing everywhere instead of each
+// wrapper honoring its own subset.
+type talosClientRequest struct {
+	// maintenance connects straight to GlobalArgs.Nodes without client
+	// credentials, for nodes that have no machine config yet. The
+	// talosconfig is not read.
+	maintenance bool
+	// fingerprints pins the maintenance server certificate by SPKI
+	// fingerprint. Empty accepts any certificate.
+	fingerprints []string
+	// skipVerify disables server certificate verification on an
+	// authenticated connection while keeping the client certificate.
+	// Maintenance connections never verify a chain (the node has no
+	// PKI yet), so it changes nothing there; pinned fingerprints are
+	// an explicit request and stay enforced.
+	skipVerify bool
+	// dialOptions are appended to the default gRPC dial options.
+	dialOptions []grpc.DialOption
+	// endpoints, when set, replace the endpoints of --endpoints and the
+	// talosconfig context, for paths that must go through a given
+	// node's apid. On a maintenance connection they replace
+	// GlobalArgs.Nodes, for probes of several nodes at once.
+	endpoints []string
+}
+
+// withTalosClient builds the client described by req and runs action
+// with it under a context cancelled on SIGINT/SIGTERM. It sets no node
+// metadata on the context; callers that target nodes add it themselves.
+//
+// The gRPC dialer honors HTTPS_PROXY and NO_PROXY from the environment
+// on every path, the same as talosctl.
+func withTalosClient(req talosClientRequest, action func(context.Context, *client.Client) error) error {
+	ctx, stop := signalContext()
+	defer stop()
+
+	opts, err := talosClientOptions(req)
+	if err != nil {
+		return err
+	}
+
+	c, err := client.New(ctx, opts...)
+	if err != nil {
+		return errors.Wrap(err, "constructing Talos client")
+	}
+	defer func() { _ = c.Close() }()
+
+	return action(ctx, c)
+}
+
+// talosClientOptions resolves req against the global flags into the
+// client options withTalosClient passes to client.New.
+func talosClientOptions(req talosClientRequest) ([]client.OptionFunc, error) {
+	if req.maintenance {
+		tlsConfig, err := maintenanceTLSConfig(req.fingerprints)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := maintenanceClientOptions(tlsConfig, req.dialOptions)
+		if len(req.endpoints) > 0 {
+			opts = append(opts, client.WithEndpoints(req.endpoints...))
+		}
+
+		return opts, nil
+	}
+
+	cfg, err := loadTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	contextName, configContext, err := selectConfigContext(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+
+	if req.skipVerify {
+		tlsConfig, err = skipVerifyTLSConfig(configContext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The config and context name are not needed to connect — the
+	// resolved context is pinned — but the client reads the cluster
+	// name and the SideroV1 auth context name from them.
+	opts := append(
+		[]client.OptionFunc{client.WithConfig(cfg), client.WithContextName(contextName)},
+		authenticatedClientOptions(configContext, tlsConfig, req.dialOptions)...,
+	)
+
+	if len(req.endpoints) > 0 {
+		opts = append(opts, client.WithEndpoints(req.endpoints...))
+	}
+
+	return opts, nil
+}
+
+// authenticatedClientOptions assembles the client options for a
+// connection authenticated with the talosconfig context. It mirrors
+// upstream global.Args.WithClientNoNodes: it pins the already-resolved
+// config context (so client.GetConfigContext honors --talosconfig /
+// --context instead of falling back to the default config), forwards
+// caller dial options, threads the --cluster proxy override when set,
+// and selects endpoints from flags or the talosconfig context. A nil
+// tlsConfig lets the client build a verifying one from the context; a
+// non-nil one (--skip-verify) replaces it.
+func authenticatedClientOptions(configContext *clientconfig.Context, tlsConfig *tls.Config, dialOptions []grpc.DialOption) []client.OptionFunc {
+	opts := []client.OptionFunc{
+		client.WithConfigContext(configContext),
+		client.WithDefaultGRPCDialOptions(),
+		// Only consumed on the verifying path: getConn short-circuits on
+		// an explicit TLS config before the SideroV1 interceptor, so
+		// skip-verify + Omni SaaS-key auth is not a real combination.
+		client.WithSideroV1KeysDir(clientconfig.CustomSideroV1KeysDirPath(GlobalArgs.SideroV1KeysDir)),
+	}
+
+	if tlsConfig != nil {
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+	}
+
+	if len(dialOptions) > 0 {
+		opts = append(opts, client.WithGRPCDialOptions(dialOptions...))
+	}
+
+	// Preserve the --cluster proxy header, so `--skip-verify --cluster X`
+	// still reaches nodes behind an Omni/Sidero proxy.
+	if GlobalArgs.Cluster != "" {
+		opts = append(opts, client.WithCluster(GlobalArgs.Cluster))
+	}
+
+	if len(GlobalArgs.Endpoints) > 0 {
+		opts = append(opts, client.WithEndpoints(GlobalArgs.Endpoints...))
+	} else if len(configContext.Endpoints) > 0 {
+		opts = append(opts, client.WithEndpoints(configContext.Endpoints...))
+	}
+
+	return opts
+}
+
+// maintenanceClientOptions assembles the client options for a
+// maintenance connection. Unlike upstream global.Args.WithClientMaintenance
+// it keeps caller dial options and the --cluster proxy override, so a
+// maintenance-mode node behind an Omni/Sidero proxy is reachable the
+// same way as a configured one.
+func maintenanceClientOptions(tlsConfig *tls.Config, dialOptions []grpc.DialOption) []client.OptionFunc {
+	opts := []client.OptionFunc{
+		client.WithTLSConfig(tlsConfig),
+		client.WithDefaultGRPCDialOptions(),
+		// Maintenance mode reads its endpoints from the node list: the
+		// node itself answers, there is no apid proxy in front of it.
+		client.WithEndpoints(GlobalArgs.Nodes...),
+	}
+
+	if len(dialOptions) > 0 {
+		opts = append(opts, client.WithGRPCDialOptions(dialOptions...))
+	}
+
+	if GlobalArgs.Cluster != "" {
+		opts = append(opts, client.WithCluster(GlobalArgs.Cluster))
+	}
+
+	return opts
+}
+
+// maintenanceTLSConfig builds the TLS config of a maintenance
+// connection: no chain verification, since the node serves a
+// self-signed certificate, optionally pinned to the given SPKI
+// fingerprints.
+func maintenanceTLSConfig(fingerprints []string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // maintenance mode: the node has no PKI yet; see fingerprints.
+	}
+
+	if len(fingerprints) == 0 {
+		return tlsConfig, nil
+	}
+
+	parsed := make([]x509.Fingerprint, 0, len(fingerprints))
+
+	for _, fingerprint := range fingerprints {
+		fp, err := x509.ParseFingerprint(fingerprint)
+		if err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Wrapf(err, "parsing certificate fingerprint %q", fingerprint),
+				"pass the SPKI fingerprint the node prints on its console in maintenance mode",
+			)
+		}
+
+		parsed = append(parsed, fp)
+	}
+
+	tlsConfig.VerifyConnection = x509.MatchSPKIFingerprints(parsed...)
+
+	return tlsConfig, nil
+}
+
+// loadTalosconfig reads the talosconfig at path. When only the
+// age-encrypted sibling (<path>.encrypted) exists, it is decrypted in
+// memory with the project's talm.key, so an encrypted project can talk
+// to its nodes without a plaintext talosconfig on disk. Unlike
+// clientconfig.Open it never creates a missing file.
+func loadTalosconfig(path string) (*clientconfig.Config, error) {
+	if path == "" {
+		return openTalosconfig(path)
+	}
+
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return openTalosconfig(path)
+	}
+
+	encryptedPath := path + encryptedTalosconfigSuffix
+
+	if _, err := os.Stat(encryptedPath); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("talosconfig %q not found", path),
+			"run `talm init` to generate it, or pass --talosconfig; an encrypted project needs %s and its key (%s)", filepath.Base(encryptedPath), secretsLayout().KeyFile(),
+		)
+	}
+
+	decrypted, err := age.DecryptYAMLToMap(cmp.Or(Config.RootDir, filepath.Dir(path)), encryptedPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting %s", encryptedPath)
+	}
+
+	data, err := yaml.Marshal(decrypted)
+	if err != nil {
+		return nil, errors.Wrapf(err, "re-encoding decrypted %s", encryptedPath)
+	}
+
+	cfg, err := clientconfig.FromBytes(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing decrypted %s", encryptedPath)
+	}
+
+	return cfg, nil
+}
+
+// openTalosconfig opens a plaintext talosconfig; an empty path means
+// the talosctl default location.
+func openTalosconfig(path string) (*clientconfig.Config, error) {
+	cfg, err := clientconfig.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening talosconfig %q", path)
+	}
+
+	return cfg, nil
+}
+
+// selectConfigContext returns the talosconfig context named by
+// --context, or the config's default context.
+func selectConfigContext(cfg *clientconfig.Config) (string, *clientconfig.Context, error) {
+	contextName := cmp.Or(GlobalArgs.CmdContext, cfg.Context)
+
+	configContext, ok := cfg.Contexts[contextName]
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", nil, errors.WithHint(
+			errors.Wrapf(errContextNotFound, "%q", contextName),
+			"verify the context name against `talosctl config contexts`",
+		)
+	}
+
+	return contextName, configContext, nil
+}
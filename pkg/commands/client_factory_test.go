@@ -0,0 +1,207 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// TestMaintenanceClientOptions_ClusterAndDialThreaded pins that the
+// maintenance path no longer drops --cluster and caller dial options the
+// way upstream global.Args.WithClientMaintenance does: each adds exactly
+// one option on top of the TLS + default gRPC + endpoints baseline.
+func TestMaintenanceClientOptions_ClusterAndDialThreaded(t *testing.T) {
+	withGlobalArgsReset(t)
+
+	base := maintenanceClientOptions(&tls.Config{}, nil)
+	if len(base) != 3 {
+		t.Fatalf("baseline expected 3 options (TLS + default gRPC + endpoints), got %d", len(base))
+	}
+
+	withDial := maintenanceClientOptions(&tls.Config{}, []grpc.DialOption{grpc.WithUserAgent("talm-test")})
+	if len(withDial) != len(base)+1 {
+		t.Errorf("caller dial options must add one option: baseline %d, with dial %d", len(base), len(withDial))
+	}
+
+	GlobalArgs.Cluster = "proxy-cluster"
+
+	withCluster := maintenanceClientOptions(&tls.Config{}, nil)
+	if len(withCluster) != len(base)+1 {
+		t.Errorf("--cluster must add one option: baseline %d, with cluster %d", len(base), len(withCluster))
+	}
+}
+
+// TestTalosClientOptions_MaintenanceEndpoints pins that the endpoints
+// of a maintenance request add one option that replaces the node list,
+// so one process probes several maintenance nodes at once.
+func TestTalosClientOptions_MaintenanceEndpoints(t *testing.T) {
+	withGlobalArgsReset(t)
+
+	base, err := talosClientOptions(talosClientRequest{maintenance: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withEndpoints, err := talosClientOptions(talosClientRequest{maintenance: true, endpoints: []string{"192.0.2.10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(withEndpoints) != len(base)+1 {
+		t.Errorf("endpoints must add one option: baseline %d, with endpoints %d", len(base), len(withEndpoints))
+	}
+}
+
+// TestAuthenticatedClientOptions_VerifyingPath pins that without a
+// --skip-verify TLS config the factory leaves TLS to the client, which
+// builds a verifying config from the talosconfig context.
+func TestAuthenticatedClientOptions_VerifyingPath(t *testing.T) {
+	withGlobalArgsReset(t)
+
+	verifying := authenticatedClientOptions(&clientconfig.Context{}, nil, nil)
+	skipping := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
+
+	if len(skipping) != len(verifying)+1 {
+		t.Errorf("only the skip-verify path may set a TLS config: verifying %d, skipping %d", len(verifying), len(skipping))
+	}
+}
+
+func TestMaintenanceTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	open, err := maintenanceTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !open.InsecureSkipVerify || open.VerifyConnection != nil {
+		t.Error("without fingerprints any maintenance certificate is accepted")
+	}
+
+	pinned, err := maintenanceTLSConfig([]string{"c2hhMjU2LWZpbmdlcnByaW50LXBsYWNlaG9sZGVyLWJ5dGVz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pinned.VerifyConnection == nil {
+		t.Error("a fingerprint must pin the certificate")
+	}
+
+	if err := pinned.VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Error("a connection without a matching peer certificate must be refused")
+	}
+
+	if _, err := maintenanceTLSConfig([]string{"not base64!"}); err == nil || !strings.Contains(err.Error(), "not base64!") {
+		t.Errorf("invalid fingerprint error = %v", err)
+	}
+}
+
+// TestTalosClientOptions_MaintenanceSkipsTalosconfig pins that a
+// maintenance client is buildable before any talosconfig exists — the
+// bootstrap case it is for.
+func TestTalosClientOptions_MaintenanceSkipsTalosconfig(t *testing.T) {
+	origTalosconfig, origNodes := GlobalArgs.Talosconfig, GlobalArgs.Nodes
+	t.Cleanup(func() { GlobalArgs.Talosconfig, GlobalArgs.Nodes = origTalosconfig, origNodes })
+
+	GlobalArgs.Talosconfig = filepath.Join(t.TempDir(), "talosconfig")
+	GlobalArgs.Nodes = []string{"192.0.2.10"}
+
+	if _, err := talosClientOptions(talosClientRequest{maintenance: true}); err != nil {
+		t.Fatalf("maintenance options must not need a talosconfig: %v", err)
+	}
+
+	if _, err := os.Stat(GlobalArgs.Talosconfig); !os.IsNotExist(err) {
+		t.Error("building maintenance options must not create a talosconfig")
+	}
+}
+
+// TestLoadTalosconfig_EncryptedInMemory pins that a project holding only
+// talosconfig.encrypted is usable: the config is decrypted in memory and
+// no plaintext talosconfig appears on disk.
+func TestLoadTalosconfig_EncryptedInMemory(t *testing.T) {
+	root := t.TempDir()
+
+	origRoot := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = origRoot })
+
+	Config.RootDir = root
+
+	if _, _, err := age.GenerateKey(root); err != nil {
+		t.Fatal(err)
+	}
+
+	plainPath := filepath.Join(root, talosconfigName)
+
+	cfg := &clientconfig.Config{
+		Context: "prod",
+		Contexts: map[string]*clientconfig.Context{
+			"prod": {Endpoints: []string{"192.0.2.10"}, Nodes: []string{"192.0.2.11"}},
+		},
+	}
+	if err := cfg.Save(plainPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptYAMLFile(root, talosconfigName, talosconfigName+encryptedTalosconfigSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(plainPath); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadTalosconfig(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Context != "prod" || !slices.Equal(loaded.Contexts["prod"].Endpoints, []string{"192.0.2.10"}) {
+		t.Errorf("decrypted talosconfig = %+v", loaded)
+	}
+
+	if _, err := os.Stat(plainPath); !os.IsNotExist(err) {
+		t.Error("the decrypted talosconfig must stay in memory")
+	}
+}
+
+// TestLoadTalosconfig_MissingNotCreated pins that, unlike
+// clientconfig.Open, a missing talosconfig is reported rather than
+// created empty.
+func TestLoadTalosconfig_MissingNotCreated(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), talosconfigName)
+
+	_, err := loadTalosconfig(path)
+	if err == nil || !strings.Contains(strings.Join(errors.GetAllHints(err), "\n"), "talm init") {
+		t.Fatalf("error = %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("a missing talosconfig must not be created")
+	}
+}
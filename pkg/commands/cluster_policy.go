@@ -0,0 +1,298 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// clusterPolicyFileName is the policy file of a monorepo holding
+// several talm projects. It lives in the project root or any directory
+// above it, up to the root of the git repository.
+const clusterPolicyFileName = "talm-policy.yaml"
+
+// clusterPolicyTeamPrefix marks a policy member that names a team.
+const clusterPolicyTeamPrefix = "@"
+
+// clusterPolicyEveryone is the policy member that lets anyone in.
+const clusterPolicyEveryone = "*"
+
+// policyGuardedCommands are the commands that change a cluster, by
+// their path below the root command. The policy is checked before any
+// of them runs, except as a dry run.
+//
+//nolint:gochecknoglobals // immutable lookup table consulted by EnforceClusterPolicy; init-time literal.
+var policyGuardedCommands = []string{
+	"apply",
+	bootstrapCmdName,
+	"edit",
+	"etcd defrag",
+	"etcd forfeit-leadership",
+	"etcd leave",
+	"etcd remove-member",
+	"meta delete",
+	"meta write",
+	"reboot",
+	recoverCmdName,
+	"reset",
+	"restart",
+	"rollback",
+	"rotate-ca",
+	"shutdown",
+	"upgrade",
+	"wipe",
+}
+
+// clusterPolicy maps the cluster directories of a monorepo to the
+// operators allowed to run mutating commands against them.
+type clusterPolicy struct {
+	// Teams maps a team name to its members.
+	Teams map[string][]string `yaml:"teams"`
+	// Clusters maps a directory, relative to the policy file, to its
+	// members: a git user.email, a git user.name, "@<team>" or "*".
+	Clusters map[string][]string `yaml:"clusters"`
+}
+
+// gitIdentity is who the operator is to git: the user.email and
+// user.name git config resolves in dir.
+type gitIdentity struct {
+	email string
+	name  string
+}
+
+func (id gitIdentity) String() string {
+	switch {
+	case id.email != "" && id.name != "":
+		return id.name + " <" + id.email + ">"
+	case id.email != "":
+		return id.email
+	default:
+		return id.name
+	}
+}
+
+// resolveGitIdentity reads the operator's git identity in dir. An
+// unset key, or no git at all, leaves that part empty. A var so tests
+// can pin an identity.
+//
+//nolint:gochecknoglobals // function-type indirection for test injection, like stdinIsTTY.
+var resolveGitIdentity = func(dir string) gitIdentity {
+	value := func(key string) string {
+		out, err := gitOutput(dir, "config", "--get", key)
+		if err != nil {
+			return ""
+		}
+
+		return strings.TrimSpace(string(out))
+	}
+
+	return gitIdentity{email: value("user.email"), name: value("user.name")}
+}
+
+// findClusterPolicy returns the path of the policy file covering the
+// project at rootDir: the nearest one in rootDir or above, not looking
+// past the root of the git repository. A project outside any policy
+// returns an empty path.
+func findClusterPolicy(rootDir string) (string, error) {
+	dir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving %s", rootDir)
+	}
+
+	for {
+		candidate := filepath.Join(dir, clusterPolicyFileName)
+		if fileExists(candidate) {
+			return candidate, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || fileExists(filepath.Join(dir, ".git")) {
+			return "", nil
+		}
+
+		dir = parent
+	}
+}
+
+// loadClusterPolicy reads and checks the policy file at path.
+func loadClusterPolicy(path string) (*clusterPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	var policy clusterPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	for dir, members := range policy.Clusters {
+		for _, member := range members {
+			team, isTeam := strings.CutPrefix(member, clusterPolicyTeamPrefix)
+			if _, ok := policy.Teams[team]; isTeam && !ok {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return nil, errors.WithHintf(
+					errors.Newf("%s: cluster %s names unknown team %q", path, dir, team),
+					"declare the team under teams: in %s", clusterPolicyFileName,
+				)
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+// clusterFor returns the policy entry covering project, a path relative
+// to the policy file's directory: the longest cluster directory that is
+// project or contains it. ok is false when no entry covers project.
+func (p *clusterPolicy) clusterFor(project string) (string, []string, bool) {
+	project = filepath.ToSlash(filepath.Clean(project))
+
+	var (
+		best    string
+		members []string
+		found   bool
+	)
+
+	for dir, dirMembers := range p.Clusters {
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(dir)))
+
+		covers := clean == "." || project == clean || strings.HasPrefix(project, clean+"/")
+		if covers && (!found || len(clean) > len(best)) {
+			best, members, found = clean, dirMembers, true
+		}
+	}
+
+	return best, members, found
+}
+
+// allows reports whether id is one of members, directly, through a
+// team, or because members lets everyone in. Emails compare
+// case-insensitively, names exactly.
+func (p *clusterPolicy) allows(members []string, id gitIdentity) bool {
+	matches := func(member string) bool {
+		switch {
+		case member == clusterPolicyEveryone:
+			return true
+		case id.email != "" && strings.EqualFold(member, id.email):
+			return true
+		default:
+			return id.name != "" && member == id.name
+		}
+	}
+
+	for _, member := range members {
+		if team, isTeam := strings.CutPrefix(member, clusterPolicyTeamPrefix); isTeam {
+			if slices.ContainsFunc(p.Teams[team], matches) {
+				return true
+			}
+
+			continue
+		}
+
+		if matches(member) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkClusterPolicy refuses when the policy covering the project at
+// rootDir does not list the operator's git identity for it. A project
+// no policy file or policy entry covers is not restricted.
+func checkClusterPolicy(rootDir string) error {
+	path, err := findClusterPolicy(rootDir)
+	if err != nil || path == "" {
+		return err
+	}
+
+	policy, err := loadClusterPolicy(path)
+	if err != nil {
+		return err
+	}
+
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", rootDir)
+	}
+
+	project, err := filepath.Rel(filepath.Dir(path), absRoot)
+	if err != nil {
+		return errors.Wrapf(err, "locating %s relative to %s", rootDir, path)
+	}
+
+	cluster, members, ok := policy.clusterFor(project)
+	if !ok {
+		return nil
+	}
+
+	id := resolveGitIdentity(absRoot)
+	if policy.allows(members, id) {
+		return nil
+	}
+
+	if id.email == "" && id.name == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("%s restricts cluster %s, and git has no identity for you", path, cluster),
+			"set one with `git config user.email <email>`; %s matches it against the cluster's members", clusterPolicyFileName,
+		)
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("%s does not list %s for cluster %s", path, id, cluster),
+		"check that you are in the directory of the cluster you meant; if you should have access, add yourself or your team to clusters.%s in %s", cluster, clusterPolicyFileName,
+	)
+}
+
+// commandPathBelowRoot returns the names of cmd and its parents below
+// the root command, space-separated, as policyGuardedCommands lists
+// them.
+func commandPathBelowRoot(cmd *cobra.Command) string {
+	var names []string
+
+	for c := cmd; c != nil && c.HasParent(); c = c.Parent() {
+		names = append([]string{c.Name()}, names...)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// EnforceClusterPolicy refuses to run a command that changes a cluster
+// when the project's talm-policy.yaml does not list the operator for
+// it. It is a guard against running in the wrong directory of a
+// monorepo, not access control: the identity is the operator's own
+// git config. A dry run is never refused. Called once the project root
+// is known.
+func EnforceClusterPolicy(cmd *cobra.Command) error {
+	if !slices.Contains(policyGuardedCommands, commandPathBelowRoot(cmd)) {
+		return nil
+	}
+
+	if dryRun := cmd.Flags().Lookup("dry-run"); dryRun != nil && dryRun.Value.String() == "true" {
+		return nil
+	}
+
+	return checkClusterPolicy(Config.RootDir)
+}
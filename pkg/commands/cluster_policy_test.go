@@ -0,0 +1,218 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+)
+
+const testClusterPolicy = `teams:
+  platform:
+    - alice@example.com
+    - Bob Builder
+clusters:
+  clusters/prod:
+    - "@platform"
+  clusters/prod/edge:
+    - carol@example.com
+  clusters/lab:
+    - "*"
+`
+
+// writePolicyMonorepo lays out a git repository holding the policy and
+// the given cluster directories, and returns its root.
+func writePolicyMonorepo(t *testing.T, clusters ...string) string {
+	t.Helper()
+
+	files := map[string]string{
+		".git/HEAD":           "ref: refs/heads/main\n",
+		clusterPolicyFileName: testClusterPolicy,
+	}
+
+	for _, cluster := range clusters {
+		files[filepath.Join(cluster, "Chart.yaml")] = "name: cluster\n"
+	}
+
+	return writePruneProject(t, files)
+}
+
+// withGitIdentity makes the policy see id as the operator.
+func withGitIdentity(t *testing.T, id gitIdentity) {
+	t.Helper()
+
+	orig := resolveGitIdentity
+	t.Cleanup(func() { resolveGitIdentity = orig })
+
+	resolveGitIdentity = func(string) gitIdentity { return id }
+}
+
+func TestClusterPolicy_ClusterFor(t *testing.T) {
+	t.Parallel()
+
+	policy := &clusterPolicy{Clusters: map[string][]string{
+		"clusters/prod":      {"a"},
+		"clusters/prod/edge": {"b"},
+		"clusters/lab/":      {"c"},
+	}}
+
+	for project, want := range map[string]string{
+		"clusters/prod":             "clusters/prod",
+		"clusters/prod/eu":          "clusters/prod",
+		"clusters/prod/edge":        "clusters/prod/edge",
+		"clusters/prod/edge/site-1": "clusters/prod/edge",
+		"clusters/lab":              "clusters/lab",
+		"clusters/production":       "",
+		"clusters":                  "",
+	} {
+		got, _, ok := policy.clusterFor(filepath.FromSlash(project))
+		if got != want || ok != (want != "") {
+			t.Errorf("clusterFor(%s) = %q, %v; want %q", project, got, ok, want)
+		}
+	}
+}
+
+func TestClusterPolicy_Allows(t *testing.T) {
+	t.Parallel()
+
+	policy := &clusterPolicy{Teams: map[string][]string{"platform": {"alice@example.com", "Bob Builder"}}}
+
+	for _, tc := range []struct {
+		members []string
+		id      gitIdentity
+		want    bool
+	}{
+		{[]string{"@platform"}, gitIdentity{email: "ALICE@example.com"}, true},
+		{[]string{"@platform"}, gitIdentity{name: "Bob Builder"}, true},
+		{[]string{"@platform"}, gitIdentity{name: "bob builder"}, false},
+		{[]string{"@platform"}, gitIdentity{email: "carol@example.com", name: "Carol"}, false},
+		{[]string{"carol@example.com"}, gitIdentity{email: "carol@example.com"}, true},
+		{[]string{"*"}, gitIdentity{}, true},
+		{[]string{"@platform"}, gitIdentity{}, false},
+	} {
+		if got := policy.allows(tc.members, tc.id); got != tc.want {
+			t.Errorf("allows(%v, %+v) = %v, want %v", tc.members, tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestCheckClusterPolicy pins the guard end to end: the nearest policy
+// above the project decides, the most specific cluster entry wins, and
+// a refusal names the identity and the cluster.
+func TestCheckClusterPolicy(t *testing.T) {
+	repo := writePolicyMonorepo(t, "clusters/prod", "clusters/prod/edge", "clusters/lab", "clusters/other")
+
+	withGitIdentity(t, gitIdentity{email: "alice@example.com", name: "Alice"})
+
+	for _, allowed := range []string{"clusters/prod", "clusters/lab", "clusters/other"} {
+		if err := checkClusterPolicy(filepath.Join(repo, allowed)); err != nil {
+			t.Errorf("%s: %v", allowed, err)
+		}
+	}
+
+	err := checkClusterPolicy(filepath.Join(repo, "clusters", "prod", "edge"))
+	if err == nil || !strings.Contains(err.Error(), "does not list Alice <alice@example.com> for cluster clusters/prod/edge") {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "clusters.clusters/prod/edge") {
+		t.Errorf("hint = %q", hints)
+	}
+
+	withGitIdentity(t, gitIdentity{})
+
+	err = checkClusterPolicy(filepath.Join(repo, "clusters", "prod"))
+	if err == nil || !strings.Contains(err.Error(), "git has no identity for you") {
+		t.Errorf("an unknown operator must be refused, got %v", err)
+	}
+}
+
+// TestFindClusterPolicy_StopsAtRepository pins that a policy file above
+// the git repository does not reach into it.
+func TestFindClusterPolicy_StopsAtRepository(t *testing.T) {
+	t.Parallel()
+
+	outer := writePruneProject(t, map[string]string{
+		clusterPolicyFileName:     testClusterPolicy,
+		"repo/.git/HEAD":          "ref: refs/heads/main\n",
+		"repo/cluster/Chart.yaml": "name: cluster\n",
+	})
+
+	path, err := findClusterPolicy(filepath.Join(outer, "repo", "cluster"))
+	if err != nil || path != "" {
+		t.Errorf("findClusterPolicy = %q, %v; want no policy", path, err)
+	}
+}
+
+func TestLoadClusterPolicy_UnknownTeam(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		clusterPolicyFileName: "clusters:\n  prod:\n    - \"@platfrom\"\n",
+	})
+
+	_, err := loadClusterPolicy(filepath.Join(dir, clusterPolicyFileName))
+	if err == nil || !strings.Contains(err.Error(), `unknown team "platfrom"`) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestEnforceClusterPolicy_Commands pins which commands the policy
+// guards: those that change a cluster, unless they run as a dry run.
+func TestEnforceClusterPolicy_Commands(t *testing.T) {
+	repo := writePolicyMonorepo(t, "clusters/prod")
+
+	origRoot := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = origRoot })
+
+	Config.RootDir = filepath.Join(repo, "clusters", "prod")
+
+	withGitIdentity(t, gitIdentity{email: "mallory@example.com"})
+
+	root := &cobra.Command{Use: "talm"}
+	apply := &cobra.Command{Use: "apply"}
+	apply.Flags().Bool("dry-run", false, "")
+	etcd := &cobra.Command{Use: "etcd"}
+	etcdLeave := &cobra.Command{Use: "leave"}
+	etcdMembers := &cobra.Command{Use: "members"}
+	get := &cobra.Command{Use: "get"}
+
+	etcd.AddCommand(etcdLeave, etcdMembers)
+	root.AddCommand(apply, etcd, get)
+
+	for _, cmd := range []*cobra.Command{apply, etcdLeave} {
+		if err := EnforceClusterPolicy(cmd); err == nil {
+			t.Errorf("%s must be refused", cmd.CommandPath())
+		}
+	}
+
+	for _, cmd := range []*cobra.Command{etcdMembers, get} {
+		if err := EnforceClusterPolicy(cmd); err != nil {
+			t.Errorf("%s does not change the cluster and must run: %v", cmd.CommandPath(), err)
+		}
+	}
+
+	if err := apply.Flags().Set("dry-run", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EnforceClusterPolicy(apply); err != nil {
+		t.Errorf("a dry run must run: %v", err)
+	}
+}
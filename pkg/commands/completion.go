@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cozystack/talm/pkg/engine"
 	"github.com/cozystack/talm/pkg/generated"
 	"github.com/cozystack/talm/pkg/modeline"
 	"github.com/siderolabs/talos/pkg/machinery/client/config"
@@ -33,6 +34,7 @@ const (
 	flagNameEndpoints = "endpoints"
 	yamlExt           = "yaml"
 	ymlExt            = "yml"
+	jsonExt           = "json"
 	nodesDirName      = "nodes"
 )
 
@@ -66,6 +68,19 @@ func completeApplyMode(_ *cobra.Command, _ []string, _ string) ([]string, cobra.
 	return applyModeOptions, cobra.ShellCompDirectiveNoFileComp
 }
 
+// completeNodeFileFormat implements shell completion for the
+// `--format` flag of `talm template`. Fixed enum, no file fallback.
+func completeNodeFileFormat(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return []string{nodeFileFormatYAML, nodeFileFormatJSON}, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTemplateRole implements shell completion for the `--role`
+// flag of `talm template` and `talm add-node`. Fixed enum, no file
+// fallback.
+func completeTemplateRole(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return engine.RenderRoles(), cobra.ShellCompDirectiveNoFileComp
+}
+
 // completeYAMLFiles implements shell completion for flags that
 // accept YAML file paths (`-f / --file`, `--values`, `-t / --template`,
 // `--with-secrets`). The directive narrows the file-completion
@@ -76,8 +91,8 @@ func completeYAMLFiles(_ *cobra.Command, _ []string, _ string) ([]string, cobra.
 
 // completeNodeFiles is the ValidArgsFunction for `apply` / `template`
 // / `upgrade`'s positional file argument. Walks `nodes/` under the
-// detected project root, filters to YAML files whose first line is
-// a talm modeline. Operator types `talm upgrade <TAB>` and gets the
+// detected project root, filters to node files (YAML, or JSON node
+// files) that carry a talm modeline. Operator types `talm upgrade <TAB>` and gets the
 // list of modelined node files, not every yaml in the project.
 func completeNodeFiles(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	root := Config.RootDir
@@ -100,7 +115,7 @@ func completeNodeFiles(_ *cobra.Command, _ []string, toComplete string) ([]strin
 		// `nodes/` may not exist on a brand-new project; fall
 		// back to default file completion so the operator can
 		// still pick files manually.
-		return []string{yamlExt, ymlExt}, cobra.ShellCompDirectiveFilterFileExt
+		return []string{yamlExt, ymlExt, jsonExt}, cobra.ShellCompDirectiveFilterFileExt
 	}
 
 	var matches []string
@@ -111,7 +126,7 @@ func completeNodeFiles(_ *cobra.Command, _ []string, toComplete string) ([]strin
 		}
 
 		name := entry.Name()
-		if !strings.HasSuffix(name, "."+yamlExt) && !strings.HasSuffix(name, "."+ymlExt) {
+		if !isNodeFileCandidate(filepath.Join(nodesDir, name)) {
 			continue
 		}
 
@@ -139,7 +154,7 @@ func completeNodeFiles(_ *cobra.Command, _ []string, toComplete string) ([]strin
 }
 
 // CompleteTalosconfigNodes is the exported entry point for
-// completion of the root `--nodes` persistent flag. main.go wires
+// completion of the root `--nodes` persistent flag. pkg/cli wires
 // it via cobra.RegisterFlagCompletionFunc.
 //
 //nolint:gochecknoglobals // package-level var holding the closure produced by completeTalosconfigField; the alternative (per-call construction) re-allocates on every shell completion invocation.
@@ -236,3 +251,20 @@ func resolveTalosconfigPathForCompletion() string {
 
 	return ""
 }
+
+// isNodeFileCandidate reports whether path can be a node file: any
+// .yaml or .yml file, or a .json file that is a JSON node file (see
+// modeline.IsJSONNodeFile). Other JSON files are left out so a stray
+// JSON document under nodes/ does not turn into a patch.
+func isNodeFileCandidate(path string) bool {
+	switch strings.TrimPrefix(filepath.Ext(path), ".") {
+	case yamlExt, ymlExt:
+		return true
+	case jsonExt:
+		data, err := os.ReadFile(path)
+
+		return err == nil && modeline.IsJSONNodeFile(data)
+	default:
+		return false
+	}
+}
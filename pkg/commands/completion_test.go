@@ -118,9 +118,18 @@ func TestComplete_PositionalNodeFiles_ReturnsModelinedYamlFromNodesDir(t *testin
 		t.Fatal(err)
 	}
 
+	// JSON node file — should appear; a plain JSON patch must not.
+	jsonNode := `{"talm": {"nodes": ["1.2.3.5"], "templates": ["templates/cp.yaml"]}, "documents": []}`
+	if err := os.WriteFile(filepath.Join(nodesDir, "cp02.json"), []byte(jsonNode), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(nodesDir, "patch.json"), []byte(`{"machine": {}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
 	got, directive := completeNodeFiles(nil, nil, "")
 
-	want := []string{filepath.Join("nodes", "cp01.yaml")}
+	want := []string{filepath.Join("nodes", "cp01.yaml"), filepath.Join("nodes", "cp02.json")}
 	if !slices.Equal(got, want) {
 		t.Errorf("positional node-file completion = %v, want %v", got, want)
 	}
@@ -261,7 +270,7 @@ func TestComplete_AnchorCommands_FileFlagUsesModelinedCompleter(t *testing.T) {
 // absence so a future "add --talosconfig completion" change must
 // be intentional, not accidental.
 func TestComplete_RootTalosconfig_NoExplicitCompletion(t *testing.T) {
-	// Replicate the registration done in main.go's
+	// Replicate the registration done in pkg/cli's
 	// registerRootFlags so the test exercises the same surface
 	// without booting the rootCmd itself.
 	root := &cobra.Command{Use: "talm-test"}
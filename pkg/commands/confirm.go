@@ -0,0 +1,164 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// AssumeYes, when set via --yes, answers every confirmation prompt of a
+// destructive command with yes, for automation.
+//
+//nolint:gochecknoglobals // bound to a root persistent flag, like SkipVerify.
+var AssumeYes bool
+
+// errNotConfirmed is returned when the operator answers no.
+var errNotConfirmed = errors.New("aborted: not confirmed")
+
+// stderrColorEnabled reports whether the confirmation summary may use
+// ANSI colors, by the same rules as the rest of the output: stderr is
+// a terminal and neither --no-color nor NO_COLOR is set. A var so tests
+// can pin the plain rendering.
+//
+//nolint:gochecknoglobals // function-type indirection for test injection, like stdinIsTTY.
+var stderrColorEnabled = func() bool {
+	return ui.ColorEnabled(os.Stderr)
+}
+
+const (
+	ansiBoldRed = "\x1b[1;31m"
+	ansiBold    = "\x1b[1m"
+	ansiReset   = "\x1b[0m"
+)
+
+// confirmation is what a destructive command shows before it acts:
+// a one-line action and the facts the operator should check, in order.
+type confirmation struct {
+	action  string
+	details []confirmationDetail
+}
+
+type confirmationDetail struct {
+	label string
+	value string
+}
+
+// add appends a detail; empty values are left out so callers can add
+// optional facts unconditionally.
+func (c *confirmation) add(label, value string) {
+	if value != "" {
+		c.details = append(c.details, confirmationDetail{label: label, value: value})
+	}
+}
+
+// addTarget appends the talosconfig context and the nodes the command
+// will act on, the two facts most worth a second look before a
+// destructive call. Nodes default to the context's own list, as the
+// client does.
+func (c *confirmation) addTarget(nodes []string) {
+	contextName, contextNodes := confirmationContext()
+	if len(nodes) == 0 {
+		nodes = contextNodes
+	}
+
+	c.add("Context", contextName)
+	c.add("Nodes", strings.Join(nodes, ", "))
+}
+
+// confirmationContext reads the talosconfig context a client would use.
+// Best-effort: a talosconfig that cannot be read shows no context line,
+// and the command itself reports the problem when it connects.
+func confirmationContext() (string, []string) {
+	cfg, err := loadTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		return "", nil
+	}
+
+	name, configContext, err := selectConfigContext(cfg)
+	if err != nil {
+		return "", nil
+	}
+
+	return name, configContext.Nodes
+}
+
+// confirmDestructive shows c and asks the operator to go ahead. --yes
+// skips the question. Without a terminal to ask on, it refuses rather
+// than act unconfirmed, pointing at --yes.
+func confirmDestructive(c confirmation) error {
+	return confirmDestructiveTo(os.Stderr, c, AssumeYes, stdinIsTTY(), stderrColorEnabled())
+}
+
+// confirmDestructiveTo is confirmDestructive with its inputs explicit.
+func confirmDestructiveTo(w io.Writer, c confirmation, assumeYes, interactive, color bool) error {
+	if assumeYes {
+		return nil
+	}
+
+	if !interactive {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%s: confirmation needed, but stdin is not a terminal to ask on", c.action),
+			"pass --yes to confirm non-interactively",
+		)
+	}
+
+	writeConfirmation(w, c, color)
+	fmt.Fprint(w, "Proceed? [y/N]: ")
+
+	response, err := bufio.NewReader(stdinReader).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "reading the confirmation")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(response)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errNotConfirmed
+	}
+}
+
+// writeConfirmation renders the summary: the action, then the details
+// with their labels aligned.
+func writeConfirmation(w io.Writer, c confirmation, color bool) {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+
+		return code + s + ansiReset
+	}
+
+	fmt.Fprintln(w, paint(ansiBoldRed, c.action))
+
+	width := 0
+	for _, d := range c.details {
+		width = max(width, len(d.label))
+	}
+
+	for _, d := range c.details {
+		label := fmt.Sprintf("%-*s", width+1, d.label+":")
+		fmt.Fprintf(w, "  %s %s\n", paint(ansiBold, label), d.value)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+)
+
+// withConfirmationTarget points the talosconfig lookup of the
+// confirmation summary at a missing file, so the summary shows only
+// the nodes passed in, and restores the globals afterwards.
+func withConfirmationTarget(t *testing.T, nodes ...string) {
+	t.Helper()
+
+	origTalosconfig, origNodes, origReader := GlobalArgs.Talosconfig, GlobalArgs.Nodes, stdinReader
+	t.Cleanup(func() { GlobalArgs.Talosconfig, GlobalArgs.Nodes, stdinReader = origTalosconfig, origNodes, origReader })
+
+	GlobalArgs.Talosconfig = filepath.Join(t.TempDir(), "talosconfig")
+	GlobalArgs.Nodes = nodes
+}
+
+func TestConfirmDestructiveTo(t *testing.T) {
+	withConfirmationTarget(t)
+
+	c := confirmation{action: "Reset Talos nodes"}
+	c.add("Nodes", "192.0.2.10")
+
+	for _, tc := range []struct {
+		answer string
+		want   error
+	}{
+		{answer: "y\n"},
+		{answer: "YES\n"},
+		{answer: "n\n", want: errNotConfirmed},
+		{answer: "\n", want: errNotConfirmed},
+		{answer: "", want: errNotConfirmed},
+	} {
+		stdinReader = strings.NewReader(tc.answer)
+
+		var out bytes.Buffer
+		if err := confirmDestructiveTo(&out, c, false, true, false); !errors.Is(err, tc.want) {
+			t.Errorf("answer %q: error = %v, want %v", tc.answer, err, tc.want)
+		}
+
+		if !strings.Contains(out.String(), "Reset Talos nodes\n  Nodes: 192.0.2.10\nProceed? [y/N]: ") {
+			t.Errorf("answer %q: prompt = %q", tc.answer, out.String())
+		}
+	}
+}
+
+func TestConfirmDestructiveTo_NonInteractive(t *testing.T) {
+	withConfirmationTarget(t)
+
+	stdinReader = strings.NewReader("y\n")
+
+	var out bytes.Buffer
+
+	err := confirmDestructiveTo(&out, confirmation{action: "Upgrade Talos and reboot the nodes"}, false, false, false)
+	if err == nil || !strings.Contains(err.Error(), "Upgrade Talos and reboot the nodes") {
+		t.Fatalf("a non-interactive run must refuse, got %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "--yes") {
+		t.Errorf("hint must name --yes, got %q", hints)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("nothing must be shown when there is no one to ask, got %q", out.String())
+	}
+
+	if err := confirmDestructiveTo(&out, confirmation{action: "Reset Talos nodes"}, true, false, false); err != nil || out.Len() != 0 {
+		t.Errorf("--yes must confirm silently, got %v, %q", err, out.String())
+	}
+}
+
+func TestWriteConfirmation(t *testing.T) {
+	t.Parallel()
+
+	c := confirmation{action: "Reset Talos nodes"}
+	c.add("Nodes", "192.0.2.10")
+	c.add("Skipped", "")
+	c.add("Afterwards", "reboot")
+
+	var plain bytes.Buffer
+
+	writeConfirmation(&plain, c, false)
+
+	if want := "Reset Talos nodes\n  Nodes:      192.0.2.10\n  Afterwards: reboot\n"; plain.String() != want {
+		t.Errorf("plain summary = %q, want %q", plain.String(), want)
+	}
+
+	var colored bytes.Buffer
+
+	writeConfirmation(&colored, c, true)
+
+	if !strings.HasPrefix(colored.String(), ansiBoldRed+"Reset Talos nodes"+ansiReset+"\n") || !strings.Contains(colored.String(), ansiBold+"Nodes:     "+ansiReset) {
+		t.Errorf("colored summary = %q", colored.String())
+	}
+}
+
+func TestResetConfirmation(t *testing.T) {
+	withConfirmationTarget(t, "192.0.2.10", "192.0.2.11")
+
+	var (
+		wipeMode string
+		labels   []string
+		reboot   bool
+	)
+
+	cmd := &cobra.Command{Use: resetCmdName}
+	registerResetFlagsForTest(cmd, &wipeMode, &labels)
+	cmd.Flags().BoolVar(&reboot, "reboot", false, "")
+
+	var out bytes.Buffer
+
+	writeConfirmation(&out, resetConfirmation(cmd), false)
+
+	for _, want := range []string{"Nodes:      192.0.2.10, 192.0.2.11", "Wipe:       wipe mode all", "Afterwards: power off"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := cmd.Flags().Set("system-labels-to-wipe", resetSafeDefaultLabels); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	writeConfirmation(&out, resetConfirmation(cmd), false)
+
+	if !strings.Contains(out.String(), "partitions STATE,EPHEMERAL") {
+		t.Errorf("labels take precedence over the wipe mode:\n%s", out.String())
+	}
+}
+
+// TestWrapResetCommand_ConfirmsBeforeUpstream pins that the reset
+// reaches upstream only once confirmed.
+func TestWrapResetCommand_ConfirmsBeforeUpstream(t *testing.T) {
+	withConfirmationTarget(t, "192.0.2.10")
+
+	origAssumeYes, origTTY := AssumeYes, stdinIsTTY
+	t.Cleanup(func() { AssumeYes, stdinIsTTY = origAssumeYes, origTTY })
+
+	stdinIsTTY = func() bool { return true }
+
+	ran := false
+
+	var (
+		wipeMode string
+		labels   []string
+	)
+
+	cmd := &cobra.Command{Use: resetCmdName, RunE: func(*cobra.Command, []string) error {
+		ran = true
+
+		return nil
+	}}
+	registerResetFlagsForTest(cmd, &wipeMode, &labels)
+	wrapResetCommand(cmd)
+
+	stdinReader = strings.NewReader("n\n")
+
+	if err := cmd.RunE(cmd, nil); !errors.Is(err, errNotConfirmed) || ran {
+		t.Fatalf("declined reset: error = %v, ran = %v", err, ran)
+	}
+
+	AssumeYes = true
+
+	if err := cmd.RunE(cmd, nil); err != nil || !ran {
+		t.Fatalf("--yes reset: error = %v, ran = %v", err, ran)
+	}
+}
+
+func TestUpgradeAndApplyConfirmations(t *testing.T) {
+	withConfirmationTarget(t)
+
+	var out bytes.Buffer
+
+	writeConfirmation(&out, upgradeConfirmation("", true), false)
+
+	if !strings.HasPrefix(out.String(), "Stage a Talos upgrade") || !strings.Contains(out.String(), "Image: the install image in the node configuration") {
+		t.Errorf("staged upgrade summary:\n%s", out.String())
+	}
+
+	dir := t.TempDir()
+	nodeFile := filepath.Join(dir, "cp1.yaml")
+	writeFile(t, dir, "cp1.yaml", "# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\nmachine: {}\n")
+
+	out.Reset()
+	writeConfirmation(&out, applyRebootConfirmation(nodeFile), false)
+
+	if !strings.Contains(out.String(), "Nodes: 192.0.2.10") {
+		t.Errorf("the apply summary must read the nodes from the modeline:\n%s", out.String())
+	}
+}
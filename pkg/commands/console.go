@@ -0,0 +1,265 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// consoleCommandName names console in value errors.
+	consoleCommandName = "talm console"
+	// consoleValuesKey is the key under nodes.<address> in the values
+	// that describes the node's BMC.
+	consoleValuesKey = "bmc"
+	// ipmiPasswordEnv is the variable ipmitool -E reads the password
+	// from, which keeps it off the ipmitool command line.
+	ipmiPasswordEnv = "IPMI_PASSWORD"
+	// defaultIPMIInterface is the ipmitool interface for IPMI v2.0 over
+	// the network, the one Serial-over-LAN needs.
+	defaultIPMIInterface = "lanplus"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var consoleCmdFlags struct {
+	configFile string
+	ipmitool   string
+	deactivate bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var consoleCmd = &cobra.Command{
+	Use:   "console",
+	Short: "Attach to a node's serial console through its BMC",
+	Long: `Attach the terminal to the serial console of the node of a node file,
+through Serial-over-LAN on the node's BMC, so bring-up does not need a
+separate IPMI tool context. The Talos API has no console stream of its
+own; the kernel log is available from talm logs kernel --follow.
+
+The BMC of a node is read from the values, under the node's address in
+the nodes map (the one talm inventory import fills):
+
+  nodes:
+    192.0.2.10:
+      bmc:
+        address: 192.0.2.110
+        username: admin
+        password: ...        # better kept in an encrypted value file
+        interface: lanplus   # the default
+
+Without a password in the values, the IPMI_PASSWORD environment
+variable is used. The values are those of Chart.yaml templateOptions,
+as for talm template. The session runs ipmitool sol activate, which
+must be installed; type ~. to detach.`,
+	Example: `  talm console -f nodes/node1.yaml
+  IPMI_PASSWORD=secret talm console -f nodes/node1.yaml --deactivate`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		file := consoleCmdFlags.configFile
+
+		if err := DetectAndSetRootFromFiles([]string{file}); err != nil {
+			return err
+		}
+
+		node, err := consoleNode(file)
+		if err != nil {
+			return err
+		}
+
+		values, err := engine.EffectiveValues(projectRenderOptions(consoleCommandName, ""))
+		if err != nil {
+			return errors.Wrap(err, "resolving the effective values")
+		}
+
+		bmc, err := nodeBMC(values, node)
+		if err != nil {
+			return err
+		}
+
+		if bmc.password == "" && os.Getenv(ipmiPasswordEnv) == "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("no BMC password for node %s", node),
+				"set nodes.%s.%s.password in an encrypted value file, or export %s", node, consoleValuesKey, ipmiPasswordEnv,
+			)
+		}
+
+		tool, err := exec.LookPath(consoleCmdFlags.ipmitool)
+		if err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Wrapf(err, "looking up %s", consoleCmdFlags.ipmitool),
+				"install ipmitool, or pass its path with --ipmitool",
+			)
+		}
+
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		if consoleCmdFlags.deactivate {
+			// A session left open by a dropped connection blocks a new
+			// one until it is deactivated; a BMC without one answers
+			// with an error that is of no interest.
+			_ = bmc.command(ctx, tool, "sol", "deactivate").Run()
+		}
+
+		ui.Infof(os.Stderr, "Attaching to the serial console of %s through the BMC at %s; type ~. to detach", node, bmc.address)
+
+		session := bmc.command(ctx, tool, "sol", "activate")
+		session.Stdin = os.Stdin
+		session.Stdout = cmd.OutOrStdout()
+		session.Stderr = os.Stderr
+
+		if err := session.Run(); err != nil {
+			return errors.Wrapf(err, "serial console session of %s", node)
+		}
+
+		return nil
+	},
+}
+
+// consoleNode returns the one node the modeline of file names, or the
+// one --nodes names.
+func consoleNode(file string) (string, error) {
+	nodes := GlobalArgs.Nodes
+
+	if len(nodes) == 0 {
+		_, modelineConfig, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return "", errors.Wrapf(err, "parsing modeline in %s", file)
+		}
+
+		if modelineConfig == nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return "", errors.WithHint(
+				errors.Newf("no talm modeline in %s", file),
+				"pass a node file generated by `talm template -I`, or name the node with --nodes",
+			)
+		}
+
+		nodes = modelineConfig.Nodes
+	}
+
+	if len(nodes) != 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("a console attaches to one node, %s names %d", file, len(nodes)),
+			"pass the node with --nodes",
+		)
+	}
+
+	return nodes[0], nil
+}
+
+// bmcTarget is the BMC of a node, as the values describe it.
+type bmcTarget struct {
+	address  string
+	username string
+	password string
+	iface    string
+}
+
+// nodeBMC reads nodes.<node>.bmc from the effective values.
+func nodeBMC(values map[string]any, node string) (bmcTarget, error) {
+	raw, ok := valueAtPath(values, []string{"nodes", node, consoleValuesKey})
+	if !ok || raw == nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return bmcTarget{}, errors.WithHintf(
+			errors.Newf("no BMC configured for node %s", node),
+			"set nodes.%s.%s.address and .username in the values; the kernel log is available without one from talm logs kernel --follow", node, consoleValuesKey,
+		)
+	}
+
+	fields, ok := raw.(map[string]any)
+	if !ok {
+		return bmcTarget{}, errors.Newf("values: nodes.%s.%s must be a map, got %T", node, consoleValuesKey, raw)
+	}
+
+	text := func(key string) (string, error) {
+		switch v := fields[key].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		default:
+			return "", errors.Newf("values: nodes.%s.%s.%s must be a string, got %T", node, consoleValuesKey, key, v)
+		}
+	}
+
+	var (
+		bmc bmcTarget
+		err error
+	)
+
+	for _, field := range []struct {
+		key string
+		dst *string
+	}{
+		{"address", &bmc.address},
+		{"username", &bmc.username},
+		{"password", &bmc.password},
+		{"interface", &bmc.iface},
+	} {
+		if *field.dst, err = text(field.key); err != nil {
+			return bmcTarget{}, err
+		}
+	}
+
+	if bmc.address == "" || bmc.username == "" {
+		return bmcTarget{}, errors.Newf("values: nodes.%s.%s needs an address and a username", node, consoleValuesKey)
+	}
+
+	if bmc.iface == "" {
+		bmc.iface = defaultIPMIInterface
+	}
+
+	return bmc, nil
+}
+
+// command returns the ipmitool invocation of args against the BMC. The
+// password goes through the environment, never the command line.
+func (b bmcTarget) command(ctx context.Context, tool string, args ...string) *exec.Cmd {
+	//nolint:gosec // the tool is the operator's ipmitool; the arguments are the values' BMC and fixed sol subcommands.
+	cmd := exec.CommandContext(ctx, tool, append([]string{"-I", b.iface, "-H", b.address, "-U", b.username, "-E"}, args...)...)
+	cmd.Stderr = io.Discard
+
+	if b.password != "" {
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", ipmiPasswordEnv, b.password))
+	}
+
+	return cmd
+}
+
+func init() {
+	consoleCmd.Flags().StringVarP(&consoleCmdFlags.configFile, "file", "f", "", "node file of the node to attach to")
+	consoleCmd.Flags().StringVar(&consoleCmdFlags.ipmitool, "ipmitool", "ipmitool", "ipmitool binary to run the Serial-over-LAN session with")
+	consoleCmd.Flags().BoolVar(&consoleCmdFlags.deactivate, "deactivate", false, "deactivate a Serial-over-LAN session left open on the BMC before attaching")
+
+	_ = consoleCmd.MarkFlagRequired("file")
+	_ = consoleCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	addCommand(consoleCmd)
+}
@@ -0,0 +1,140 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestNodeBMC(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]any{"nodes": map[string]any{
+		"192.0.2.10": map[string]any{"hostname": "cp01", "bmc": map[string]any{"address": "192.0.2.110", "username": "admin", "password": "s3cret"}},
+		"192.0.2.11": map[string]any{"bmc": map[string]any{"address": "192.0.2.111", "username": "admin", "interface": "lan"}},
+	}}
+
+	got, err := nodeBMC(values, "192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (bmcTarget{address: "192.0.2.110", username: "admin", password: "s3cret", iface: defaultIPMIInterface}); got != want {
+		t.Errorf("bmc = %+v, want %+v", got, want)
+	}
+
+	got, err = nodeBMC(values, "192.0.2.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.iface != "lan" || got.password != "" {
+		t.Errorf("bmc = %+v, want the lan interface and no password", got)
+	}
+}
+
+func TestNodeBMC_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		node  any
+		wants string
+	}{
+		{"no bmc", map[string]any{"hostname": "cp01"}, "no BMC configured for node 192.0.2.10"},
+		{"not a map", map[string]any{"bmc": "192.0.2.110"}, "nodes.192.0.2.10.bmc must be a map"},
+		{"no username", map[string]any{"bmc": map[string]any{"address": "192.0.2.110"}}, "needs an address and a username"},
+		{"not a string", map[string]any{"bmc": map[string]any{"address": "192.0.2.110", "username": 7}}, "nodes.192.0.2.10.bmc.username must be a string"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := nodeBMC(map[string]any{"nodes": map[string]any{"192.0.2.10": tc.node}}, "192.0.2.10")
+			if err == nil || !strings.Contains(err.Error(), tc.wants) {
+				t.Errorf("err = %v, want it to mention %q", err, tc.wants)
+			}
+		})
+	}
+
+	_, err := nodeBMC(map[string]any{}, "192.0.2.10")
+	if hint := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hint, "nodes.192.0.2.10.bmc.address") {
+		t.Errorf("a missing BMC must say where to configure it, got hint %q", hint)
+	}
+}
+
+// TestBMCTarget_Command pins that the password reaches ipmitool through
+// IPMI_PASSWORD, never its command line.
+func TestBMCTarget_Command(t *testing.T) {
+	t.Parallel()
+
+	bmc := bmcTarget{address: "192.0.2.110", username: "admin", password: "s3cret", iface: defaultIPMIInterface}
+	cmd := bmc.command(context.Background(), "/usr/bin/ipmitool", "sol", "activate")
+
+	want := []string{"/usr/bin/ipmitool", "-I", "lanplus", "-H", "192.0.2.110", "-U", "admin", "-E", "sol", "activate"}
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args = %q, want %q", cmd.Args, want)
+	}
+
+	if !slices.Contains(cmd.Env, "IPMI_PASSWORD=s3cret") {
+		t.Error("the password must be passed in IPMI_PASSWORD")
+	}
+
+	bmc.password = ""
+	if cmd := bmc.command(context.Background(), "ipmitool", "sol", "activate"); cmd.Env != nil {
+		t.Errorf("without a password the environment must be inherited as is, got %q", cmd.Env)
+	}
+}
+
+func TestConsoleNode(t *testing.T) {
+	withConfigSnapshot(t)
+
+	nodes := GlobalArgs.Nodes
+	t.Cleanup(func() { GlobalArgs.Nodes = nodes })
+	GlobalArgs.Nodes = nil
+
+	dir := t.TempDir()
+	single := filepath.Join(dir, "node1.yaml")
+	pair := filepath.Join(dir, "pair.yaml")
+
+	for path, content := range map[string]string{
+		single: "# talm: nodes=[\"192.0.2.10\"], endpoints=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		pair:   "# talm: nodes=[\"192.0.2.10\",\"192.0.2.11\"], endpoints=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if node, err := consoleNode(single); err != nil || node != "192.0.2.10" {
+		t.Errorf("node = %q, err = %v", node, err)
+	}
+
+	if _, err := consoleNode(pair); err == nil || !strings.Contains(err.Error(), "names 2") {
+		t.Errorf("a modeline with two nodes must be refused, got %v", err)
+	}
+
+	GlobalArgs.Nodes = []string{"192.0.2.11"}
+	if node, err := consoleNode(pair); err != nil || node != "192.0.2.11" {
+		t.Errorf("--nodes must pick the node, got %q, %v", node, err)
+	}
+}
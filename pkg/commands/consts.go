@@ -36,4 +36,8 @@ const (
 	// initSubcommand is the canonical name of the init subcommand,
 	// used when the dispatcher needs to special-case it.
 	initSubcommand = "init"
+
+	// strictFlagUsage is the help text of --strict on the commands
+	// that render the chart.
+	strictFlagUsage = "fail the render when a value the chart's values.schema.json marks deprecated is set, instead of warning (default from Chart.yaml templateOptions.strictDeprecations)"
 )
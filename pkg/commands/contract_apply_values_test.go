@@ -170,3 +170,39 @@ func assertConfigThenCLI(t *testing.T, field string, got []string, configVal, cl
 		t.Errorf("%s must merge config-first then CLI (so CLI wins in loadValues):\n got=%v\nwant=%v", field, got, want)
 	}
 }
+
+// TestSetApplyValueOptions_ReleaseLock pins that under --release the
+// values lock replaces every value source, Chart.yaml's included.
+func TestSetApplyValueOptions_ReleaseLock(t *testing.T) {
+	restore := snapshotApplyValueState()
+	defer restore()
+
+	Config.RootDir = testProjectRoot
+	Config.TemplateOptions.ValueFiles = []string{"values-prod.yaml"}
+	Config.TemplateOptions.Values = []string{"a=fromconfig"}
+	applyCmdFlags.valuesLock = "/p/releases/v1/values.lock.yaml"
+
+	opts := buildApplyRenderOptions([]string{testTemplateControlplaneRel}, testProjectRoot+"/secrets.yaml")
+
+	if opts.ValuesLock != applyCmdFlags.valuesLock || len(opts.ValueFiles) != 0 || len(opts.Values) != 0 {
+		t.Errorf("opts = %+v", opts)
+	}
+
+	if got := applyValueFilePaths(); !slices.Equal(got, []string{applyCmdFlags.valuesLock}) {
+		t.Errorf("the drift redactor must read the lock, got %v", got)
+	}
+}
+
+// TestSnapshotValuesFlags_MatchTemplate pins that snapshot values takes
+// the value sources the way template does, so a snapshot resolves what
+// the same template invocation would render.
+func TestSnapshotValuesFlags_MatchTemplate(t *testing.T) {
+	for _, name := range applyValueFlagNames {
+		snapshotFlag := snapshotValuesCmd.Flags().Lookup(name)
+		templateFlag := templateCmd.Flags().Lookup(name)
+
+		if snapshotFlag == nil || templateFlag == nil || snapshotFlag.Value.Type() != templateFlag.Value.Type() {
+			t.Errorf("--%s: snapshot values and template must register it with the same type", name)
+		}
+	}
+}
@@ -475,3 +475,28 @@ func TestCaptureStderr_RestoresOsStderrPerCall(t *testing.T) {
 		t.Errorf("os.Stderr is no longer usable after captureStderr returned: %v", err)
 	}
 }
+
+// Contract: entries appended to a CRLF .gitignore (a Windows checkout)
+// use CRLF too, so the file does not end up with mixed line endings.
+func TestContract_WriteGitignoreFile_KeepsCRLF(t *testing.T) {
+	dir := t.TempDir()
+	setRoot(t, dir)
+	originalKube := Config.GlobalOptions.Kubeconfig
+	t.Cleanup(func() { Config.GlobalOptions.Kubeconfig = originalKube })
+	Config.GlobalOptions.Kubeconfig = ""
+
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("# Custom rules\r\nnotes/\r\nsecrets.yaml\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeGitignoreFile(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	content := string(data)
+	if strings.Count(content, "\n") != strings.Count(content, "\r\n") {
+		t.Errorf(".gitignore has mixed line endings: %q", content)
+	}
+	if strings.Count(content, "secrets.yaml") != 1 || !strings.Contains(content, "talm.key\r\n") {
+		t.Errorf(".gitignore = %q", content)
+	}
+}
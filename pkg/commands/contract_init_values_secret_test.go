@@ -116,6 +116,7 @@ func TestContract_SecurityInfoBox_NamesEveryGitignoredFile(t *testing.T) {
 func resetInitFlags() {
 	initCmdFlags.encrypt = false
 	initCmdFlags.decrypt = false
+	initCmdFlags.migrateSecrets = false
 	initCmdFlags.force = false
 	initCmdFlags.update = false
 	initCmdFlags.preset = ""
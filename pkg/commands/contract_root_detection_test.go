@@ -168,6 +168,82 @@ func TestContract_DetectProjectRootForFile(t *testing.T) {
 	}
 }
 
+// makeAmbiguousProject creates a talm project with a nested directory
+// holding a Chart.yaml but no secrets bundle, and a nodes/n.yaml file
+// inside it. Returns the absolute project and nested directories.
+func makeAmbiguousProject(t *testing.T) (string, string) {
+	t.Helper()
+	outer, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeProjectRoot(t, outer)
+	inner := filepath.Join(outer, "prod")
+	if err := os.MkdirAll(filepath.Join(inner, "nodes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(inner, "Chart.yaml"), []byte("name: prod\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(inner, "nodes", "n.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return outer, inner
+}
+
+// Contract: the nearest Chart.yaml above a file is preferred. When it
+// has no secrets bundle but a project sits further up, the file's
+// project is ambiguous: the error lists both candidates and points at
+// --root / TALM_ROOT instead of silently picking the outer project.
+func TestContract_DetectProjectRootForFile_NearestChartWithoutSecretsIsAmbiguous(t *testing.T) {
+	outer, inner := makeAmbiguousProject(t)
+
+	_, err := DetectProjectRootForFile(filepath.Join(inner, "nodes", "n.yaml"))
+	if err == nil {
+		t.Fatal("expected an ambiguous root error")
+	}
+	var ambiguous *ambiguousRootError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("error must be an *ambiguousRootError, got %T: %v", err, err)
+	}
+	if !strings.Contains(err.Error(), inner) || !strings.Contains(err.Error(), outer) {
+		t.Errorf("error must name both candidates, got: %v", err)
+	}
+	hint := strings.Join(errors.GetAllHints(err), "\n")
+	for _, want := range []string{"--root", "TALM_ROOT", inner + " (Chart.yaml without a secrets bundle)", outer + " (talm project)"} {
+		if !strings.Contains(hint, want) {
+			t.Errorf("hint must mention %q, got:\n%s", want, hint)
+		}
+	}
+}
+
+// Contract: a library chart, such as the talm library vendored under
+// charts/, is not a candidate: a file inside it belongs to the project
+// around it.
+func TestContract_DetectProjectRootForFile_LibraryChartSkipped(t *testing.T) {
+	root, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeProjectRoot(t, root)
+	library := filepath.Join(root, "charts", "talm")
+	if err := os.MkdirAll(filepath.Join(library, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(library, "Chart.yaml"), []byte("apiVersion: v2\ntype: library\nname: talm\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DetectProjectRootForFile(filepath.Join(library, "templates", "_helpers.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != root {
+		t.Errorf("got %q, want the project %q", got, root)
+	}
+}
+
 // === ValidateAndDetectRootsForFiles ===
 
 // Contract: empty input returns ("", nil). Caller treats this as
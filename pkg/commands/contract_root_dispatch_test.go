@@ -28,6 +28,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -71,14 +72,19 @@ func crossPlatformAbs(parts ...string) string {
 // process.
 func withConfigSnapshot(t *testing.T) {
 	t.Helper()
+	// A TALM_ROOT in the developer's shell would pin every root the
+	// tests detect.
+	t.Setenv(rootEnvVar, "")
 	rootDir := Config.RootDir
 	rootDirExplicit := Config.RootDirExplicit
+	rootDirOrigin := Config.RootDirOrigin
 	talosconfig := GlobalArgs.Talosconfig
 	talosconfigCfg := Config.GlobalOptions.Talosconfig
 	initOptions := Config.InitOptions
 	t.Cleanup(func() {
 		Config.RootDir = rootDir
 		Config.RootDirExplicit = rootDirExplicit
+		Config.RootDirOrigin = rootDirOrigin
 		GlobalArgs.Talosconfig = talosconfig
 		Config.GlobalOptions.Talosconfig = talosconfigCfg
 		Config.InitOptions = initOptions
@@ -294,6 +300,24 @@ func TestContract_CheckRootConflict_ExplicitConflict(t *testing.T) {
 	}
 }
 
+// Contract: a root pinned by TALM_ROOT is named as such when files
+// conflict with it, so the operator knows what to unset.
+func TestContract_CheckRootConflict_NamesTalmRoot(t *testing.T) {
+	withConfigSnapshot(t)
+	Config.RootDir = crossPlatformAbs("explicit", "root")
+	Config.RootDirOrigin = rootEnvVar
+	err := checkRootConflict(crossPlatformAbs("detected", "root"), true)
+	if err == nil {
+		t.Fatal("expected error for conflicting roots")
+	}
+	if !strings.Contains(err.Error(), "global TALM_ROOT=") {
+		t.Errorf("error must name TALM_ROOT, got: %v", err)
+	}
+	if hint := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hint, "drop TALM_ROOT") {
+		t.Errorf("hint must name TALM_ROOT, got: %q", hint)
+	}
+}
+
 // === DetectAndSetRoot ===
 
 // Contract: when --root is declared as a PERSISTENT flag on a
@@ -316,7 +340,7 @@ func TestContract_DetectAndSetRoot_RootInheritedPersistentFlag(t *testing.T) {
 	}
 	t.Chdir(subdir)
 
-	// Mirror main.go's flag layout: --root declared as persistent on
+	// Mirror pkg/cli's flag layout: --root declared as persistent on
 	// the parent command via StringVar(&Config.RootDir, ...), the
 	// subcommand inherits it through cmd.Flags() but NOT
 	// cmd.PersistentFlags().
@@ -418,6 +442,54 @@ func TestContract_DetectAndSetRoot_FilesInDifferentRoots_FirstFileWins(t *testin
 	}
 }
 
+// Contract: TALM_ROOT pins the root like --root does: the CWD walk-up
+// does not run and the root counts as explicit.
+func TestContract_DetectAndSetRoot_TalmRootEnv(t *testing.T) {
+	withConfigSnapshot(t)
+
+	root := t.TempDir()
+	makeProjectRoot(t, root)
+	other := t.TempDir()
+	makeProjectRoot(t, other)
+	t.Chdir(other)
+	t.Setenv(rootEnvVar, root)
+
+	cmd := &cobra.Command{Use: fixtureTestCmdUse}
+	cmd.PersistentFlags().String("root", "", "")
+	withOSArgs(t, []string{fixtureBinaryName})
+
+	if err := DetectAndSetRoot(cmd, nil); err != nil {
+		t.Fatalf("DetectAndSetRoot: %v", err)
+	}
+	if Config.RootDir != root || !Config.RootDirExplicit || Config.RootDirOrigin != rootEnvVar {
+		t.Errorf("RootDir = %q, explicit = %v, origin = %q; want TALM_ROOT %q pinned", Config.RootDir, Config.RootDirExplicit, Config.RootDirOrigin, root)
+	}
+}
+
+// Contract: --root wins over TALM_ROOT.
+func TestContract_DetectAndSetRoot_RootFlagWinsOverTalmRoot(t *testing.T) {
+	withConfigSnapshot(t)
+
+	root := t.TempDir()
+	t.Setenv(rootEnvVar, t.TempDir())
+
+	parent := &cobra.Command{Use: fixtureBinaryName}
+	parent.PersistentFlags().StringVar(&Config.RootDir, "root", ".", "")
+	child := &cobra.Command{Use: fixtureTestCmdUse}
+	parent.AddCommand(child)
+	if err := parent.PersistentFlags().Set("root", root); err != nil {
+		t.Fatal(err)
+	}
+	withOSArgs(t, []string{fixtureBinaryName, fixtureTestCmdUse})
+
+	if err := DetectAndSetRoot(child, nil); err != nil {
+		t.Fatalf("DetectAndSetRoot: %v", err)
+	}
+	if Config.RootDir != root || Config.RootDirOrigin != "" {
+		t.Errorf("RootDir = %q, origin = %q; want the --root value %q", Config.RootDir, Config.RootDirOrigin, root)
+	}
+}
+
 // Contract: with no flags and no project markers reachable from
 // CWD, DetectAndSetRoot returns nil and leaves Config.RootDir
 // untouched (or sets it to whatever the CWD walk-up yielded — the
@@ -512,6 +584,30 @@ func TestContract_DetectAndSetRootFromFiles_ExplicitConflict(t *testing.T) {
 	}
 }
 
+// Contract: an explicit root that is one of the candidates of an
+// ambiguous file settles the ambiguity instead of failing on it.
+func TestContract_DetectAndSetRootFromFiles_ExplicitRootSettlesAmbiguity(t *testing.T) {
+	withConfigSnapshot(t)
+
+	outer, inner := makeAmbiguousProject(t)
+	file := filepath.Join(inner, "nodes", "n.yaml")
+
+	Config.RootDir = outer
+	Config.RootDirExplicit = true
+
+	if err := DetectAndSetRootFromFiles([]string{file}); err != nil {
+		t.Fatalf("an explicit root among the candidates must settle the ambiguity, got: %v", err)
+	}
+	if Config.RootDir != outer {
+		t.Errorf("Config.RootDir = %q, want the explicit %q", Config.RootDir, outer)
+	}
+
+	Config.RootDirExplicit = false
+	if err := DetectAndSetRootFromFiles([]string{file}); err == nil {
+		t.Error("without an explicit root the ambiguity must be an error")
+	}
+}
+
 // === EnsureTalosconfigPath ===
 
 // Contract: when --talosconfig was explicitly set, EnsureTalosconfigPath
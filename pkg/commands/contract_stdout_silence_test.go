@@ -32,6 +32,12 @@
 //     assert stdout contains only the rendered config (modeline +
 //     warn banner + body) while the `- talm: file=…` progress line
 //     lands on stderr.
+//
+// Beyond the prefix, TestContract_StdoutWriteSites pins where stdout
+// is written at all: the process stdout only by the template render,
+// and never through the ui helpers, whose lines are logs. Commands
+// with a report (listings, health, history) write it to
+// cmd.OutOrStdout() and their notes to a separate progress writer.
 
 package commands
 
@@ -42,6 +48,7 @@ import (
 	"go/token"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -99,6 +106,131 @@ func TestContract_NoTalmProgressOnStdout(t *testing.T) {
 	}
 }
 
+// stdoutArtifactFiles are the files allowed to write the process
+// stdout directly: the rendered config of `talm template`.
+//
+//nolint:gochecknoglobals // test-only allowlist.
+var stdoutArtifactFiles = map[string]bool{"template.go": true}
+
+// TestContract_StdoutWriteSites walks pkg/commands and rejects any
+// direct write to the process stdout outside stdoutArtifactFiles, and
+// any ui helper call aimed at stdout or cmd.OutOrStdout(): Infof,
+// Successf, Warnf and the error printers produce log lines, which
+// belong on stderr whatever the command. A note or warning line
+// written with fmt is rejected whatever the writer: it would land in a
+// report when the writer is one, and it skips --quiet when it is not.
+func TestContract_StdoutWriteSites(t *testing.T) {
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatalf("read pkg/commands directory: %v", err)
+	}
+
+	fset := token.NewFileSet()
+
+	var violations []string
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		file, parseErr := parser.ParseFile(fset, entry.Name(), nil, parser.SkipObjectResolution)
+		if parseErr != nil {
+			t.Fatalf("parse %s: %v", entry.Name(), parseErr)
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+
+			pos := fset.Position(call.Pos()).String()
+
+			switch {
+			case pkg.Name == "fmt" && strings.HasPrefix(sel.Sel.Name, "Print"),
+				pkg.Name == "fmt" && strings.HasPrefix(sel.Sel.Name, "Fprint") && len(call.Args) > 0 && isOsStdout(call.Args[0]):
+				if !stdoutArtifactFiles[entry.Name()] {
+					violations = append(violations, pos+": fmt."+sel.Sel.Name+" writes the process stdout")
+				}
+			case pkg.Name == "ui" && len(call.Args) > 0 && (isOsStdout(call.Args[0]) || isOutOrStdout(call.Args[0])):
+				violations = append(violations, pos+": ui."+sel.Sel.Name+" aimed at stdout")
+			case pkg.Name == "fmt" && strings.HasPrefix(sel.Sel.Name, "Fprint") && len(call.Args) > 1 && isDiagnosticLine(call.Args[1]):
+				violations = append(violations, pos+": fmt."+sel.Sel.Name+" writes a note or warning line by hand")
+			}
+
+			return true
+		})
+	}
+
+	if len(violations) > 0 {
+		t.Errorf("found %d stdout write(s) outside the artifact sites:\n%s\nfix: write logs to os.Stderr (or ui.Progress(os.Stderr)), notes and warnings through ui.Infof and ui.Warnf, and reports to cmd.OutOrStdout()",
+			len(violations), strings.Join(violations, "\n"))
+	}
+}
+
+// diagnosticPrefixes open the note and warning lines that belong on
+// stderr through the ui helpers, not in a report.
+//
+//nolint:gochecknoglobals // test-only table.
+var diagnosticPrefixes = []string{"note:", "warning:", "hint:"}
+
+// isDiagnosticLine reports whether e, the first argument after the
+// writer, is a literal or a concatenation starting with a literal that
+// opens with one of diagnosticPrefixes. An indented line, like the
+// hint under a pre-flight finding, belongs to the block above it.
+func isDiagnosticLine(e ast.Expr) bool {
+	for {
+		bin, ok := e.(*ast.BinaryExpr)
+		if !ok {
+			break
+		}
+
+		e = bin.X
+	}
+
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return false
+	}
+
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return false
+	}
+
+	value = strings.ToLower(value)
+
+	for _, prefix := range diagnosticPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isOutOrStdout(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+
+	return ok && sel.Sel.Name == "OutOrStdout"
+}
+
 // violationForFmtCall returns a human-readable position+description
 // when `call` matches the forbidden shape: a fmt.{Print,Printf,Println}
 // invocation or fmt.{Fprint,Fprintf,Fprintln}(os.Stdout, …) where the
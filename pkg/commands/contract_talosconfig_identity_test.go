@@ -0,0 +1,157 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+// Contract: per-operator identities live under talosconfigs/<name>,
+// carry the operator name as the client certificate common name,
+// inherit endpoints from the project talosconfig, and are selected
+// through ResolveIdentityTalosconfig (the --as flag).
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+)
+
+func TestContract_ValidateIdentityName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"alice", "ci-deploy", "ops.bob", "a"} {
+		if err := validateIdentityName(name); err != nil {
+			t.Errorf("%q must be accepted: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "Alice", "../alice", "alice/x", "-alice", "alice-", ".hidden"} {
+		if err := validateIdentityName(name); err == nil {
+			t.Errorf("%q must be rejected", name)
+		}
+	}
+}
+
+func TestContract_MintIdentity_WritesNamedCertificate(t *testing.T) {
+	clusterName := "identity-cluster"
+
+	dir := stageTalosconfigFixture(t, clusterName)
+	withGlobalEndpoints(t, []string{"10.0.80.201"})
+
+	if err := regenerateTalosconfig(); err != nil {
+		t.Fatalf("regenerateTalosconfig: %v", err)
+	}
+
+	if err := mintIdentityTalosconfig("alice", []string{string(role.Reader)}, 48*time.Hour, false); err != nil {
+		t.Fatalf("mint: %v", err)
+	}
+
+	path := filepath.Join(dir, talosconfigsDirName, "alice")
+
+	info, err := readIdentity(path)
+	if err != nil {
+		t.Fatalf("readIdentity: %v", err)
+	}
+
+	if info.subject != "alice" {
+		t.Errorf("certificate common name = %q, want %q", info.subject, "alice")
+	}
+
+	if strings.Join(info.roles, ",") != string(role.Reader) {
+		t.Errorf("certificate roles = %v, want [%s]", info.roles, role.Reader)
+	}
+
+	if left := time.Until(info.notAfter); left <= 0 || left > 48*time.Hour {
+		t.Errorf("certificate must expire within the requested ttl, expires in %s", left)
+	}
+
+	identityConfig, err := config.Open(path)
+	if err != nil {
+		t.Fatalf("open identity talosconfig: %v", err)
+	}
+
+	identityCtx, ok := identityConfig.Contexts[clusterName]
+	if !ok {
+		t.Fatalf("identity must reuse the project context %q, got contexts %v", clusterName, mapKeys(identityConfig.Contexts))
+	}
+
+	if got := identityCtx.Endpoints; len(got) != 1 || got[0] != "10.0.80.201" {
+		t.Errorf("identity must inherit project endpoints, got %v", got)
+	}
+
+	gitignore, err := os.ReadFile(filepath.Join(dir, talosconfigsDirName, ".gitignore"))
+	if err != nil || !strings.HasPrefix(string(gitignore), "*\n") {
+		t.Errorf("talosconfigs/.gitignore must ignore the identities, got %q (%v)", gitignore, err)
+	}
+
+	if err := mintIdentityTalosconfig("alice", []string{string(role.Reader)}, time.Hour, false); err == nil {
+		t.Error("re-minting an existing identity without --force must fail")
+	}
+
+	resolved, err := ResolveIdentityTalosconfig(dir, "alice")
+	if err != nil || resolved != path {
+		t.Errorf("ResolveIdentityTalosconfig = %q, %v; want %q", resolved, err, path)
+	}
+
+	if _, err := ResolveIdentityTalosconfig(dir, "bob"); err == nil {
+		t.Error("an unknown identity must not fall back to the project talosconfig")
+	}
+
+	var out bytes.Buffer
+	if err := listIdentities(&out, &bytes.Buffer{}, filepath.Join(dir, talosconfigsDirName), time.Now()); err != nil {
+		t.Fatalf("listIdentities: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "alice") || strings.Contains(out.String(), ".gitignore") {
+		t.Errorf("identities listing must show alice and skip dotfiles:\n%s", out.String())
+	}
+}
+
+// A project without identities prints its note on progress, so the
+// listing on stdout stays empty for scripts.
+func TestContract_ListIdentities_NoneKeepsStdoutEmpty(t *testing.T) {
+	t.Parallel()
+
+	var out, progress bytes.Buffer
+	if err := listIdentities(&out, &progress, filepath.Join(t.TempDir(), talosconfigsDirName), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Len() != 0 || !strings.Contains(progress.String(), "no identities in") {
+		t.Errorf("stdout = %q, progress = %q", out.String(), progress.String())
+	}
+}
+
+// Mint needs the Talos CA key, so a project without secrets.yaml must
+// fail rather than write a half-formed identity.
+func TestContract_MintIdentity_RequiresSecrets(t *testing.T) {
+	dir := t.TempDir()
+
+	prevRoot := Config.RootDir
+	Config.RootDir = dir
+
+	t.Cleanup(func() { Config.RootDir = prevRoot })
+
+	if err := mintIdentityTalosconfig("alice", []string{string(role.Admin)}, time.Hour, false); err == nil {
+		t.Fatal("expected error without secrets.yaml")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, talosconfigsDirName, "alice")); !os.IsNotExist(err) {
+		t.Errorf("no identity may be written without secrets, stat err = %v", err)
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/cozystack/talm/pkg/engine"
 	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
 	"gopkg.in/yaml.v3"
 )
@@ -187,16 +188,30 @@ func TestContract_GenerateOutput_ComposesModelineWarningAndRender(t *testing.T)
 		jsonValues        []string
 		literalValues     []string
 		talosVersion      string
+		talosVersionSet   bool
 		withSecrets       string
 		full              bool
 		debug             bool
 		offline           bool
+		strict            bool
 		kubernetesVersion string
 		inplace           bool
 		showSecrets       bool
 		nodesFromArgs     bool
 		endpointsFromArgs bool
 		templatesFromArgs bool
+		sinceRef          string
+		snapshot          string
+		format            string
+		release           string
+		valuesLock        string
+		profile           bool
+		renderProfile     *engine.RenderProfile
+		showSources       bool
+		role              string
+		roleFromArgs      bool
+		k8sManifests      string
+		outputDir         string
 	}{
 		offline:       true,
 		templateFiles: []string{testTemplateConfig},
@@ -0,0 +1,171 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+)
+
+const copyCmdName = "copy"
+
+// wrapCopyCommand lets `talm copy` (alias `cp`) name the source node in
+// the path, scp-style, instead of through -f or --nodes:
+//
+//	talm cp cp01:/var/log/containers ./logs
+//	talm cp nodes/cp01.yaml:/var/log ./logs
+//	talm cp 10.0.0.1:/var/log - > logs.tar.gz
+//
+// The part before `:/` is a node file (a `.yaml` path, or the name of a
+// file under nodes/ without the extension), whose modeline picks the
+// node and endpoints as -f would, or else a node address used as
+// --nodes would be. An IPv6 address is written in brackets:
+// `[fd00::1]:/var/log`. Upstream streams the remote tree back as a
+// .tar.gz, extracted into the local directory or written to stdout for
+// `-`, so the plain `talm copy -f nodes/cp01.yaml /var/log ./logs`
+// form keeps working.
+//
+// Copying to a node is refused: the Talos API has no call that writes
+// node files. The error points at machine.files, which is how files
+// reach a node.
+//
+// Chain order: the wrapper PreRunE runs BEFORE the wrapTalosCommand
+// PreRunE, so the node file it adds to -f is processed with the other
+// modelines and the node address is in GlobalArgs.Nodes before the
+// sync to upstream. RunE hands upstream the path without the node.
+func wrapCopyCommand(wrappedCmd *cobra.Command) {
+	wrappedCmd.Use = "copy [<node>:]<src-path> -|<local-path>"
+	wrappedCmd.Example = `  # Grab a log directory from the node of nodes/cp01.yaml
+  talm cp cp01:/var/log/containers ./logs
+
+  # Stream the archive of a node address to stdout
+  talm cp 10.0.0.1:/var/log - > logs.tar.gz`
+
+	originalPreRunE := wrappedCmd.PreRunE
+
+	wrappedCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := settleCopySource(cmd, args); err != nil {
+			return err
+		}
+
+		if originalPreRunE != nil {
+			return originalPreRunE(cmd, args)
+		}
+
+		return nil
+	}
+
+	originalRunE := wrappedCmd.RunE
+
+	wrappedCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			if _, remotePath, ok := splitCopyRemotePath(args[0]); ok {
+				args = append([]string{remotePath}, args[1:]...)
+			}
+		}
+
+		return originalRunE(cmd, args)
+	}
+}
+
+// settleCopySource resolves the node named in the source path of
+// `talm copy` into -f or GlobalArgs.Nodes.
+func settleCopySource(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		if target, _, ok := splitCopyRemotePath(args[1]); ok {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("cannot copy to %s: the Talos API does not write files on a node", target),
+				"declare the file under machine.files in the node's config and run `talm apply`, or ship it in a system extension",
+			)
+		}
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	target, _, ok := splitCopyRemotePath(args[0])
+	if !ok {
+		return nil
+	}
+
+	if files, _ := cmd.Flags().GetStringSlice("file"); len(files) > 0 || len(GlobalArgs.Nodes) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("the source %s names node %s, and -f or --nodes names another", args[0], target),
+			"drop %q from the source path, or drop -f and --nodes", target+":",
+		)
+	}
+
+	if nodeFile := copyNodeFile(target); nodeFile != "" {
+		//nolint:wrapcheck // pflag.Set typed error surfaced verbatim.
+		return cmd.Flags().Set("file", nodeFile)
+	}
+
+	GlobalArgs.Nodes = []string{target}
+
+	return nil
+}
+
+// splitCopyRemotePath splits `<node>:<path>` into the node and the
+// absolute node path. A local path, or a node without an absolute path
+// after the colon, is not split.
+func splitCopyRemotePath(arg string) (string, string, bool) {
+	var target, remotePath string
+
+	if rest, ok := strings.CutPrefix(arg, "["); ok {
+		address, after, found := strings.Cut(rest, "]:")
+		if !found {
+			return "", "", false
+		}
+
+		target, remotePath = address, after
+	} else {
+		before, after, found := strings.Cut(arg, ":")
+		if !found {
+			return "", "", false
+		}
+
+		target, remotePath = before, after
+	}
+
+	if target == "" || !strings.HasPrefix(remotePath, "/") {
+		return "", "", false
+	}
+
+	return target, remotePath, true
+}
+
+// copyNodeFile returns the node file target names, or "" when target is
+// a node address: a `.yaml`/`.yml` path as given, or the name of a file
+// under nodes/ in the project root.
+func copyNodeFile(target string) string {
+	if ext := filepath.Ext(target); ext == ".yaml" || ext == ".yml" {
+		return target
+	}
+
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(Config.RootDir, nodesDirName, target+ext)
+		if fileExists(path) {
+			return path
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,190 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSplitCopyRemotePath(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		arg, target, path string
+		ok                bool
+	}{
+		{"cp01:/var/log", "cp01", "/var/log", true},
+		{"nodes/cp01.yaml:/var/log/containers", "nodes/cp01.yaml", "/var/log/containers", true},
+		{"10.0.0.1:/var", "10.0.0.1", "/var", true},
+		{"[fd00::1]:/var/log", "fd00::1", "/var/log", true},
+		{"/var/log", "", "", false},
+		{"./logs", "", "", false},
+		{"-", "", "", false},
+		{":/var/log", "", "", false},
+		{"cp01:var/log", "", "", false},
+		{"fd00::1:/var/log", "", "", false},
+		{"[fd00::1]/var/log", "", "", false},
+	} {
+		target, path, ok := splitCopyRemotePath(tc.arg)
+		if target != tc.target || path != tc.path || ok != tc.ok {
+			t.Errorf("%q: got (%q, %q, %v), want (%q, %q, %v)", tc.arg, target, path, ok, tc.target, tc.path, tc.ok)
+		}
+	}
+}
+
+// newCopyCommandForTest builds a copy command with the -f flag the
+// talosctl wrapper adds, wrapped the way talm wraps it. RunE records
+// the arguments upstream receives.
+func newCopyCommandForTest(t *testing.T, got *[]string) *cobra.Command {
+	t.Helper()
+
+	originalRoot := Config.RootDir
+	originalNodes := GlobalArgs.Nodes
+
+	t.Cleanup(func() {
+		Config.RootDir = originalRoot
+		GlobalArgs.Nodes = originalNodes
+	})
+
+	Config.RootDir = t.TempDir()
+	GlobalArgs.Nodes = nil
+
+	cmd := &cobra.Command{
+		Use: copyCmdName,
+		RunE: func(_ *cobra.Command, args []string) error {
+			*got = args
+
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceP("file", "f", nil, "node files")
+
+	wrapCopyCommand(cmd)
+
+	return cmd
+}
+
+// TestWrapCopyCommand_NodeFile pins that a node name resolves to the
+// node file under nodes/ and reaches the modeline processing as -f.
+func TestWrapCopyCommand_NodeFile(t *testing.T) {
+	var got []string
+
+	cmd := newCopyCommandForTest(t, &got)
+
+	nodeFile := filepath.Join(Config.RootDir, nodesDirName, "cp01.yaml")
+	if err := os.MkdirAll(filepath.Dir(nodeFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(nodeFile, []byte("# talm: nodes=[\"10.0.0.1\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"cp01:/var/log", "./logs"}
+
+	if err := cmd.PreRunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := cmd.Flags().GetStringSlice("file"); !reflect.DeepEqual(files, []string{nodeFile}) {
+		t.Errorf("-f = %v, want [%s]", files, nodeFile)
+	}
+
+	if len(GlobalArgs.Nodes) != 0 {
+		t.Errorf("a node file must leave --nodes to its modeline, got %v", GlobalArgs.Nodes)
+	}
+
+	if err := cmd.RunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, []string{"/var/log", "./logs"}) {
+		t.Errorf("upstream args = %v, want the path without the node", got)
+	}
+}
+
+func TestWrapCopyCommand_NodeAddress(t *testing.T) {
+	var got []string
+
+	cmd := newCopyCommandForTest(t, &got)
+
+	if err := cmd.PreRunE(cmd, []string{"[fd00::1]:/var/log", "-"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(GlobalArgs.Nodes, []string{"fd00::1"}) {
+		t.Errorf("nodes = %v, want the address from the source", GlobalArgs.Nodes)
+	}
+
+	if files, _ := cmd.Flags().GetStringSlice("file"); len(files) != 0 {
+		t.Errorf("an address must not add -f, got %v", files)
+	}
+}
+
+// TestWrapCopyCommand_PlainPaths pins that the upstream form with -f is
+// passed through untouched.
+func TestWrapCopyCommand_PlainPaths(t *testing.T) {
+	var got []string
+
+	cmd := newCopyCommandForTest(t, &got)
+
+	if err := cmd.Flags().Set("file", "nodes/cp01.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"/var/log", "./logs"}
+
+	if err := cmd.PreRunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.RunE(cmd, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, args) || len(GlobalArgs.Nodes) != 0 {
+		t.Errorf("upstream args = %v, nodes = %v, want them untouched", got, GlobalArgs.Nodes)
+	}
+}
+
+func TestWrapCopyCommand_Conflicts(t *testing.T) {
+	var got []string
+
+	cmd := newCopyCommandForTest(t, &got)
+	GlobalArgs.Nodes = []string{"10.0.0.2"}
+
+	if err := cmd.PreRunE(cmd, []string{"10.0.0.1:/var/log", "./logs"}); err == nil || !strings.Contains(err.Error(), "names another") {
+		t.Errorf("a source node next to --nodes must fail, got %v", err)
+	}
+}
+
+// TestWrapCopyCommand_RefusesUpload pins that a node destination fails
+// with the machine.files pointer instead of reaching upstream.
+func TestWrapCopyCommand_RefusesUpload(t *testing.T) {
+	var got []string
+
+	cmd := newCopyCommandForTest(t, &got)
+
+	err := cmd.PreRunE(cmd, []string{"./motd", "cp01:/etc/motd"})
+	if err == nil || !strings.Contains(err.Error(), "cannot copy to cp01") {
+		t.Fatalf("error = %v, want an upload refusal", err)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// diffCommandName names diff in render errors.
+const diffCommandName = "talm diff"
+
+// ANSI colors of the diff lines, by change.
+const (
+	diffColorAdd    = "\x1b[32m"
+	diffColorRemove = "\x1b[31m"
+	diffColorUpdate = "\x1b[33m"
+	diffColorReset  = "\x1b[0m"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var diffCmdFlags struct {
+	configFile  string
+	release     string
+	showSecrets bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the rendered config of a node file with the config on the node",
+	Long: `Render the templates of a node file, as talm template does, merge the node
+file body over them, and compare the result with the MachineConfig the
+node runs, read through the Talos API. The differences are printed per
+document and field:
+
+  + a document the node lacks
+  - a document the node has and the render does not
+  ~ a document that differs, with one line per changed field
+
+The comparison is structural, so key order and formatting do not count.
+It is what talm apply previews before applying, without applying. Each
+node of the modeline is compared in turn; secrets, from the Talos
+bootstrap fields and the encrypted value files, are shown as *** unless
+--show-secrets is set. The render uses the values, value files and --set
+options of Chart.yaml templateOptions, or with --release the values
+locked for that release.`,
+	Example: `  talm diff -f nodes/node1.yaml
+  talm diff -f nodes/node1.yaml --nodes 192.0.2.11`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		file := diffCmdFlags.configFile
+
+		if err := DetectAndSetRootFromFiles([]string{file}); err != nil {
+			return err
+		}
+
+		_, modelineConfig, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return errors.Wrapf(err, "parsing modeline in %s", file)
+		}
+
+		if modelineConfig == nil || len(modelineConfig.Templates) == 0 {
+			//nolint:wrapcheck // sentinel constructed in-place; WithHint attaches operator guidance
+			return errors.WithHint(
+				errors.Newf("the modeline of %s does not name templates", file),
+				"add a `# talm: templates=[...]` modeline at the top of the node file; a node file without templates is a patch, which talm apply --dry-run previews",
+			)
+		}
+
+		if len(GlobalArgs.Nodes) == 0 {
+			GlobalArgs.Nodes = modelineConfig.Nodes
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = modelineConfig.Endpoints
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = []string{defaultLocalEndpoint}
+		}
+
+		valuesLock, err := resolveReleaseValuesLock(cmd.Flags(), Config.RootDir, diffCmdFlags.release)
+		if err != nil {
+			return err
+		}
+
+		opts := diffRenderOptions(modelineConfig.Templates, valuesLock)
+
+		redactor, err := diffRedactor(opts)
+		if err != nil {
+			return err
+		}
+
+		opts.Role = modelineConfig.Role
+		out := cmd.OutOrStdout()
+
+		return WithClient(func(ctx context.Context, c *client.Client) error {
+			nodes := resolveAuthTemplateNodes(GlobalArgs.Nodes, c)
+			if len(nodes) == 0 {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHint(
+					errors.Newf("no nodes to compare %s with", file),
+					"set the targets via --nodes, a `# talm: nodes=[...]` modeline at the top of the node file, or the talosconfig context",
+				)
+			}
+
+			for _, node := range nodes {
+				if err := diffNode(ctx, c, opts, file, node, out, redactor); err != nil {
+					return errors.Wrapf(err, "node %s", node)
+				}
+			}
+
+			return nil
+		})
+	},
+}
+
+// diffRenderOptions renders the full config of templates with the
+// project render options, from valuesLock when it is set.
+func diffRenderOptions(templates []string, valuesLock string) engine.Options {
+	opts := projectRenderOptions(diffCommandName, valuesLock)
+	opts.Full = true
+	opts.TemplateFiles = resolveEngineTemplatePaths(templates, Config.RootDir)
+
+	return opts
+}
+
+// diffRedactor masks the Talos bootstrap secrets and the values of the
+// encrypted value files opts renders from, unless --show-secrets is set.
+func diffRedactor(opts engine.Options) (secretRedactor, error) {
+	if diffCmdFlags.showSecrets {
+		return secretRedactor{show: true}, nil
+	}
+
+	valueFiles := opts.ValueFiles
+	if opts.ValuesLock != "" {
+		valueFiles = []string{opts.ValuesLock}
+	}
+
+	secrets, err := collectSecretLeaves(valueFiles, Config.RootDir)
+	if err != nil {
+		return secretRedactor{}, errors.Wrap(err, "collecting secret values to redact")
+	}
+
+	return secretRedactor{userSecrets: secrets}, nil
+}
+
+// diffNode renders the config of file for node and writes how the
+// node's MachineConfig differs from it.
+//
+//nolint:gocritic // hugeParam: engine.Options is passed by value like engine.Render takes it.
+func diffNode(ctx context.Context, c *client.Client, opts engine.Options, file, node string, out io.Writer, redactor secretRedactor) error {
+	desired, err := renderNodeConfig(ctx, c, opts, file, node)
+	if err != nil {
+		return err
+	}
+
+	current, _, err := cosiMachineConfigReader(c, false)(client.WithNode(ctx, node))
+	if err != nil {
+		return err
+	}
+
+	changes, err := applycheck.Diff(current, desired)
+	if err != nil {
+		return errors.Wrap(err, "comparing the configs")
+	}
+
+	printConfigDiff(out, node, changes, redactor, ui.ColorEnabled(out))
+
+	return nil
+}
+
+// renderNodeConfig renders the config of file for node, as talm apply
+// would send it: the templates for the Talos version of the node, with
+// the node file body merged over them.
+//
+//nolint:gocritic // hugeParam: engine.Options is passed by value like engine.Render takes it.
+func renderNodeConfig(ctx context.Context, c *client.Client, opts engine.Options, file, node string) ([]byte, error) {
+	talosVersion, err := nodeTalosVersion(Config.RootDir, []string{node}, opts.TalosVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TalosVersion = talosVersion
+
+	// Lookups in the templates read the plural key, the COSI read of
+	// the MachineConfig the singular one; see cosiPreflightContext.
+	rendered, err := engine.Render(client.WithNodes(ctx, node), c, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "template rendering")
+	}
+
+	desired, err := engine.MergeFileAsPatch(rendered, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "merging node file %q as patch", file)
+	}
+
+	return desired, nil
+}
+
+// printConfigDiff writes the changes of node in the layout of the apply
+// drift preview, each change in the color of its op when color is set.
+// OpEqual entries are only counted in the trailing summary.
+func printConfigDiff(w io.Writer, node string, changes []applycheck.Change, redactor secretRedactor, color bool) {
+	_, _ = fmt.Fprintf(w, "node %s:\n", node)
+
+	var adds, removes, updates, equals int
+
+	for i := range changes {
+		change := &changes[i]
+
+		var code string
+
+		switch change.Op {
+		case applycheck.OpEqual:
+			equals++
+
+			continue
+		case applycheck.OpAdd:
+			adds++
+			code = diffColorAdd
+		case applycheck.OpRemove:
+			removes++
+			code = diffColorRemove
+		case applycheck.OpUpdate:
+			updates++
+			code = diffColorUpdate
+		}
+
+		lines := []string{applycheck.FormatChange(change)}
+		for j := range change.Fields {
+			lines = append(lines, "      "+formatFieldChangeLine(&change.Fields[j], redactor))
+		}
+
+		for _, line := range lines {
+			if color {
+				line = code + line + diffColorReset
+			}
+
+			_, _ = fmt.Fprintln(w, line)
+		}
+	}
+
+	if adds+removes+updates == 0 {
+		_, _ = fmt.Fprintf(w, "no differences, %d unchanged.\n", equals)
+
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "%d addition, %d removal, %d update, %d unchanged.\n", adds, removes, updates, equals)
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffCmdFlags.configFile, "file", "f", "", "node file whose rendered config to compare with the node")
+	diffCmd.Flags().StringVar(&diffCmdFlags.release, "release", "", "render with the values locked for this release tag by `talm snapshot values` instead of values.yaml and the value files")
+	diffCmd.Flags().BoolVar(&diffCmdFlags.showSecrets, "show-secrets", false, "show secret values verbatim instead of ***: the Talos bootstrap secrets and the values of encrypted value files")
+
+	_ = diffCmd.MarkFlagRequired("file")
+	_ = diffCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	addCommand(diffCmd)
+}
@@ -0,0 +1,117 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+)
+
+func testConfigDiffChanges() []applycheck.Change {
+	return []applycheck.Change{
+		{ID: applycheck.DocID{Kind: "MachineConfig"}, Op: applycheck.OpUpdate, Fields: []applycheck.FieldChange{
+			{Path: "machine.install.image", Old: "installer:v1.11.0", New: "installer:v1.11.1", HasOld: true, HasNew: true},
+			{Path: "machine.token", Old: "old-token", New: "new-token", HasOld: true, HasNew: true},
+		}},
+		{ID: applycheck.DocID{Kind: "LinkAliasConfig", Name: "uplink"}, Op: applycheck.OpAdd},
+		{ID: applycheck.DocID{Kind: "HostnameConfig"}, Op: applycheck.OpRemove},
+		{ID: applycheck.DocID{Kind: "ResolverConfig"}, Op: applycheck.OpEqual},
+	}
+}
+
+func TestPrintConfigDiff(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	printConfigDiff(&out, "192.0.2.10", testConfigDiffChanges(), secretRedactor{}, false)
+
+	got := out.String()
+
+	for _, want := range []string{
+		"node 192.0.2.10:\n",
+		"  ~ MachineConfig\n",
+		"      machine.install.image: installer:v1.11.0 -> installer:v1.11.1\n",
+		"  + LinkAliasConfig{name: uplink}\n",
+		"  - HostnameConfig\n",
+		"1 addition, 1 removal, 1 update, 1 unchanged.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+
+	if strings.Contains(got, "ResolverConfig") {
+		t.Errorf("an unchanged document must only be counted:\n%s", got)
+	}
+
+	if strings.Contains(got, "old-token") || strings.Contains(got, "new-token") {
+		t.Errorf("machine.token must be redacted:\n%s", got)
+	}
+
+	if strings.Contains(got, "\x1b[") {
+		t.Errorf("no colors were asked for:\n%q", got)
+	}
+}
+
+func TestPrintConfigDiff_Color(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	printConfigDiff(&out, "192.0.2.10", testConfigDiffChanges(), secretRedactor{}, true)
+
+	got := out.String()
+
+	for _, want := range []string{
+		diffColorUpdate + "  ~ MachineConfig" + diffColorReset + "\n",
+		diffColorUpdate + "      machine.install.image: installer:v1.11.0 -> installer:v1.11.1" + diffColorReset + "\n",
+		diffColorAdd + "  + LinkAliasConfig{name: uplink}" + diffColorReset + "\n",
+		diffColorRemove + "  - HostnameConfig" + diffColorReset + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%q", want, got)
+		}
+	}
+
+	if !strings.HasPrefix(got, "node 192.0.2.10:\n") {
+		t.Errorf("the header must stay uncolored:\n%q", got)
+	}
+}
+
+func TestPrintConfigDiff_ShowSecrets(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	printConfigDiff(&out, "192.0.2.10", testConfigDiffChanges(), secretRedactor{show: true}, false)
+
+	if !strings.Contains(out.String(), "machine.token: old-token -> new-token") {
+		t.Errorf("--show-secrets must show the token:\n%s", out.String())
+	}
+}
+
+func TestPrintConfigDiff_NoDifferences(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	printConfigDiff(&out, "192.0.2.10", []applycheck.Change{
+		{ID: applycheck.DocID{Kind: "MachineConfig"}, Op: applycheck.OpEqual},
+	}, secretRedactor{}, true)
+
+	if want := "node 192.0.2.10:\nno differences, 1 unchanged.\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
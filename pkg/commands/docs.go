@@ -0,0 +1,176 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/resource/meta"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+// docsCmd groups the reference pages for chart authors.
+//
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "Reference for chart authors: template functions and lookup kinds",
+	Long: `Print reference documentation for writing talm chart templates.
+
+  talm docs functions   the template functions talm adds to Sprig
+  talm docs lookups     the resource kinds a node answers to lookup`,
+	Args: cobra.NoArgs,
+}
+
+// docsFunctionsCmd prints the template functions of the engine.
+//
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var docsFunctionsCmd = &cobra.Command{
+	Use:   "functions",
+	Short: "List the template functions talm adds to Sprig",
+	Long: `List the template functions talm adds to the Sprig library, and the Sprig
+functions whose behavior it changes, with their arguments.
+
+The list comes from the engine itself, so it matches the talm binary
+that runs it. It needs no project and no node.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return writeFunctionDocs(cmd.OutOrStdout(), helmEngine.Functions())
+	},
+}
+
+// docsLookupsCmd prints the resource kinds a node answers to lookup.
+//
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var docsLookupsCmd = &cobra.Command{
+	Use:   "lookups",
+	Short: "List the resource kinds a node answers to lookup",
+	Long: `List the Talos resource kinds a template can read with lookup, as
+served by the node: the kind and its aliases, any of which lookup
+accepts as KIND, and the namespace an empty NAMESPACE stands for.
+
+The kinds depend on the Talos version of the node, so the command asks
+one node, from --nodes or the talosconfig context.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return WithClient(func(ctx context.Context, c *client.Client) error {
+			if len(GlobalArgs.Nodes) != 1 {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHint(
+					errors.Newf("talm docs lookups asks one node, got %d", len(GlobalArgs.Nodes)),
+					"pass a single node with --nodes",
+				)
+			}
+
+			kinds, err := listLookupKinds(client.WithNode(ctx, GlobalArgs.Nodes[0]), c)
+			if err != nil {
+				return err
+			}
+
+			return writeLookupKinds(cmd.OutOrStdout(), kinds)
+		})
+	},
+}
+
+// lookupKind is one resource kind a node answers to lookup.
+type lookupKind struct {
+	kind      string
+	aliases   []string
+	namespace string
+	sensitive bool
+}
+
+// listLookupKinds reads the resource definitions of the node ctx
+// targets, sorted by kind.
+func listLookupKinds(ctx context.Context, c *client.Client) ([]lookupKind, error) {
+	defs, err := safe.StateListAll[*meta.ResourceDefinition](ctx, c.COSI)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing the resource definitions of the node")
+	}
+
+	var kinds []lookupKind
+
+	for def := range defs.All() {
+		spec := def.TypedSpec()
+
+		kinds = append(kinds, lookupKind{
+			kind:      spec.Type,
+			aliases:   spec.Aliases,
+			namespace: spec.DefaultNamespace,
+			sensitive: spec.Sensitivity == meta.Sensitive,
+		})
+	}
+
+	slices.SortFunc(kinds, func(a, b lookupKind) int { return cmp.Compare(a.kind, b.kind) })
+
+	return kinds, nil
+}
+
+// writeFunctionDocs prints each function as its usage line followed by
+// the indented description.
+func writeFunctionDocs(w io.Writer, docs []helmEngine.FunctionDoc) error {
+	var b strings.Builder
+
+	for i, doc := range docs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "%s\n    %s\n", doc.Usage, doc.Description)
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return errors.Wrap(err, "writing the function reference")
+}
+
+// writeLookupKinds prints the kinds as a table. A sensitive kind is
+// answered only to a talosconfig with the os:admin role, so the table
+// says which ones are.
+func writeLookupKinds(w io.Writer, kinds []lookupKind) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tALIASES\tNAMESPACE\tSENSITIVE")
+
+	for _, k := range kinds {
+		sensitive := ""
+		if k.sensitive {
+			sensitive = "yes"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.kind, strings.Join(k.aliases, ","), k.namespace, sensitive)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "writing the lookup kinds")
+	}
+
+	return nil
+}
+
+func init() {
+	docsCmd.AddCommand(docsFunctionsCmd, docsLookupsCmd)
+	addCommand(docsCmd)
+}
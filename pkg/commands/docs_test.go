@@ -0,0 +1,91 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+// TestWriteFunctionDocs pins the layout: the usage line, then the
+// description indented under it, with a blank line between functions.
+func TestWriteFunctionDocs(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	err := writeFunctionDocs(&buf, []helmEngine.FunctionDoc{
+		{Name: "fail", Usage: "fail MESSAGE", Description: "Fails the render with MESSAGE."},
+		{Name: "lookup", Usage: "lookup KIND NAMESPACE ID", Description: "Reads Talos resources."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "fail MESSAGE\n    Fails the render with MESSAGE.\n\nlookup KIND NAMESPACE ID\n    Reads Talos resources.\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// TestWriteFunctionDocs_Engine pins that the engine's own reference
+// prints, and includes lookup.
+func TestWriteFunctionDocs_Engine(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	if err := writeFunctionDocs(&buf, helmEngine.Functions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "\nlookup KIND NAMESPACE ID\n") {
+		t.Errorf("lookup is missing from:\n%s", buf.String())
+	}
+}
+
+// TestWriteLookupKinds pins the table: aliases joined, the default
+// namespace, and only sensitive kinds marked.
+func TestWriteLookupKinds(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	err := writeLookupKinds(&buf, []lookupKind{
+		{kind: "Links.net.talos.dev", aliases: []string{"link", "links"}, namespace: "network"},
+		{kind: "MachineConfigs.config.talos.dev", aliases: []string{"mc"}, namespace: "config", sensitive: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header and two kinds:\n%s", len(lines), buf.String())
+	}
+
+	for i, want := range [][]string{
+		{"KIND", "ALIASES", "NAMESPACE", "SENSITIVE"},
+		{"Links.net.talos.dev", "link,links", "network"},
+		{"MachineConfigs.config.talos.dev", "mc", "config", "yes"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("line %d = %q, want fields %v", i, lines[i], want)
+		}
+	}
+}
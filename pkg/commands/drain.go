@@ -0,0 +1,388 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// defaultDrainTimeout bounds the eviction of a node's pods and,
+	// separately, the wait for the node to turn Ready after its reboot.
+	defaultDrainTimeout = 5 * time.Minute
+	drainPollInterval   = 5 * time.Second
+
+	controlPlaneRoleLabel = "node-role.kubernetes.io/control-plane"
+)
+
+// drainOptions are the drain flags of apply. Draining is opt-in:
+// --drain or the Chart.yaml default turns it on, and --skip-drain
+// turns it off for one run. Upgrade drains through upstream's own
+// --drain instead; see applyUpgradeDrain.
+type drainOptions struct {
+	drain   bool
+	skip    bool
+	timeout time.Duration
+}
+
+func (o drainOptions) enabled() bool {
+	return o.drain && !o.skip
+}
+
+func addDrainFlags(flags *pflag.FlagSet, opts *drainOptions) {
+	flags.BoolVar(&opts.drain, "drain", false, "cordon and drain the Kubernetes worker nodes that reboot, honoring PodDisruptionBudgets, and uncordon them once they are Ready again; uses the project kubeconfig (default from Chart.yaml applyOptions.drain)")
+	flags.BoolVar(&opts.skip, "skip-drain", false, "do not drain, even when Chart.yaml applyOptions.drain enables it")
+	flags.DurationVar(&opts.timeout, "drain-timeout", defaultDrainTimeout, "how long to wait for the pods of a drained node to be evicted, and for the node to report Ready after its reboot")
+}
+
+// drainer cordons, drains and uncordons Kubernetes Nodes.
+type drainer struct {
+	clientset kubernetes.Interface
+	timeout   time.Duration
+	poll      time.Duration
+	w         io.Writer
+}
+
+// drainedNode is a Node cordoned for a reboot. bootID tells the
+// rebooted kubelet apart from the one still reporting Ready while
+// the machine goes down. A Node that was already cordoned before the
+// drain stays cordoned afterwards.
+type drainedNode struct {
+	target      string
+	name        string
+	bootID      string
+	wasCordoned bool
+}
+
+// drain cordons the worker Node of every target and evicts its pods.
+// Targets that have not registered as Nodes and control-plane Nodes
+// are skipped with a notice: the former run nothing to evict, and
+// draining the latter would evict the apiserver's own neighbours for
+// no scheduling benefit. When a drain fails, the Nodes cordoned so
+// far are uncordoned again, since the reboot will not happen.
+func (d drainer) drain(ctx context.Context, targets []string) ([]drainedNode, error) {
+	nodeList, err := d.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing Kubernetes nodes")
+	}
+
+	var drained []drainedNode
+
+	for _, target := range targets {
+		name, ok := findKubernetesNodeName(nodeList.Items, target)
+		if !ok {
+			fmt.Fprintf(d.w, "- talm: drain: %s has not registered in Kubernetes, nothing to drain\n", target)
+
+			continue
+		}
+
+		node := kubernetesNodeByName(nodeList.Items, name)
+
+		if _, ok := node.Labels[controlPlaneRoleLabel]; ok {
+			fmt.Fprintf(d.w, "- talm: drain: %s (%s) is a control-plane node, not drained\n", name, target)
+
+			continue
+		}
+
+		fmt.Fprintf(d.w, "- talm: draining %s (%s)\n", name, target)
+
+		if !node.Spec.Unschedulable {
+			if err := d.setUnschedulable(ctx, name, true); err != nil {
+				return nil, errors.Join(err, d.release(ctx, drained, false))
+			}
+		}
+
+		drained = append(drained, drainedNode{target: target, name: name, bootID: node.Status.NodeInfo.BootID, wasCordoned: node.Spec.Unschedulable})
+
+		if err := d.evictPods(ctx, name); err != nil {
+			return nil, errors.Join(err, d.release(ctx, drained, false))
+		}
+	}
+
+	return drained, nil
+}
+
+func kubernetesNodeByName(nodes []corev1.Node, name string) *corev1.Node {
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// evictPods evicts the evictable pods of a Node through the Eviction
+// API, so PodDisruptionBudgets are honored: a refused eviction is
+// retried until the budget allows it or the drain timeout expires.
+// It returns once no evictable pod is left on the Node.
+func (d drainer) evictPods(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	evicted := map[types.UID]bool{}
+
+	for {
+		pods, err := d.evictablePods(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if len(pods) == 0 {
+			return nil
+		}
+
+		var blocked []string
+
+		for i := range pods {
+			pod := &pods[i]
+			if evicted[pod.UID] {
+				continue
+			}
+
+			err := d.clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+
+			switch {
+			case err == nil:
+				evicted[pod.UID] = true
+			case apierrors.IsNotFound(err):
+			case apierrors.IsTooManyRequests(err):
+				blocked = append(blocked, pod.Namespace+"/"+pod.Name)
+			default:
+				return errors.Wrapf(err, "evicting pod %s/%s from %s", pod.Namespace, pod.Name, name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return drainTimeoutError(name, pods, blocked, d.timeout)
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+func drainTimeoutError(name string, pods []corev1.Pod, blocked []string, timeout time.Duration) error {
+	left := make([]string, 0, len(pods))
+	for i := range pods {
+		left = append(left, pods[i].Namespace+"/"+pods[i].Name)
+	}
+
+	err := errors.Newf("draining %s: %d pods still on the node after %s: %s", name, len(pods), timeout, strings.Join(left, ", "))
+	if len(blocked) > 0 {
+		err = errors.Newf("draining %s: eviction refused by a PodDisruptionBudget after %s: %s", name, timeout, strings.Join(blocked, ", "))
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(err,
+		"the node was not rebooted and is uncordoned again; raise --drain-timeout, free up the disruption budget, or pass --skip-drain to reboot without draining",
+	)
+}
+
+// evictablePods lists the pods on a Node that a drain evicts: not
+// mirror pods, which the kubelet owns, not DaemonSet pods, which
+// would be recreated on the cordoned Node anyway, and not pods that
+// already finished.
+func (d drainer) evictablePods(ctx context.Context, name string) ([]corev1.Pod, error) {
+	podList, err := d.clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing pods on %s", name)
+	}
+
+	var pods []corev1.Pod
+
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != name || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+
+		pods = append(pods, pod)
+	}
+
+	return pods, nil
+}
+
+// release uncordons the drained Nodes. After a reboot each Node is
+// first waited for: it must come back with a new boot ID and report
+// Ready. A Node that does not stays cordoned, and the failures are
+// returned together after the other Nodes are released.
+func (d drainer) release(ctx context.Context, drained []drainedNode, rebooted bool) error {
+	var errs []error
+
+	for _, node := range drained {
+		if node.wasCordoned {
+			fmt.Fprintf(d.w, "- talm: %s was cordoned before the drain, leaving it cordoned\n", node.name)
+
+			continue
+		}
+
+		if rebooted {
+			if err := d.awaitReady(ctx, node); err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+		}
+
+		if err := d.setUnschedulable(ctx, node.name, false); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		fmt.Fprintf(d.w, "- talm: uncordoned %s\n", node.name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d drainer) awaitReady(ctx context.Context, node drainedNode) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	for {
+		// Errors are retried: a flaky apiserver connection should not
+		// leave a healthy Node cordoned.
+		current, err := d.clientset.CoreV1().Nodes().Get(ctx, node.name, metav1.GetOptions{})
+		if err == nil && current.Status.NodeInfo.BootID != node.bootID && kubernetesNodeReady(current) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("Kubernetes node %s (%s) did not report Ready after its reboot within %s", node.name, node.target, d.timeout),
+				"the node stays cordoned; run `kubectl uncordon %s` once it is healthy", node.name,
+			)
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+func kubernetesNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func (d drainer) setUnschedulable(ctx context.Context, name string, unschedulable bool) error {
+	patch := fmt.Appendf(nil, `{"spec":{"unschedulable":%t}}`, unschedulable)
+
+	if _, err := d.clientset.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		if unschedulable {
+			return errors.Wrapf(err, "cordoning Kubernetes node %s", name)
+		}
+
+		return errors.Wrapf(err, "uncordoning Kubernetes node %s", name)
+	}
+
+	return nil
+}
+
+// drainSession is the drain around one disruptive operation: begun
+// before the nodes reboot and ended after. The zero session drains
+// nothing, so callers end it unconditionally.
+type drainSession struct {
+	drainer drainer
+	nodes   []drainedNode
+}
+
+// beginDrain drains the worker nodes among targets when opts enable
+// it. Unlike the node metadata sync, a drain that cannot run fails
+// the operation: the operator asked for the pods to be moved before
+// the reboot.
+func beginDrain(opts drainOptions, targets []string, w io.Writer) (*drainSession, error) {
+	if !opts.enabled() || len(targets) == 0 {
+		return &drainSession{}, nil
+	}
+
+	clientset, err := projectKubeClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "draining before the reboot")
+	}
+
+	d := drainer{clientset: clientset, timeout: opts.timeout, poll: drainPollInterval, w: w}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	nodes, err := d.drain(ctx, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &drainSession{drainer: d, nodes: nodes}, nil
+}
+
+// end uncordons the drained nodes; rebooted says whether they went
+// down, in which case each is awaited first. The operation itself is
+// over, so failures are reported as warnings.
+func (s *drainSession) end(rebooted bool) {
+	if len(s.nodes) == 0 {
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if err := s.drainer.release(ctx, s.nodes, rebooted); err != nil {
+		ui.WarnError(s.drainer.w, err)
+	}
+}
+
+// beginApplyDrain drains the apply targets when the apply reboots
+// them. Only --mode=reboot is known to reboot up front; under auto
+// the node decides after the apply, too late to drain.
+func beginApplyDrain(targets []string) (*drainSession, error) {
+	if applyCmdFlags.Mode.Mode != machineapi.ApplyConfigurationRequest_REBOOT || applyCmdFlags.dryRun || applyCmdFlags.insecure {
+		return &drainSession{}, nil
+	}
+
+	return beginDrain(applyCmdFlags.drain, targets, ui.Progress(os.Stderr))
+}
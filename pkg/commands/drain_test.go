@@ -0,0 +1,252 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func drainTestNode(name, address, bootID string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: v1.NodeStatus{
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}},
+			NodeInfo:   v1.NodeSystemInfo{BootID: bootID},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func drainTestPod(name, node string, mutate func(*v1.Pod)) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{NodeName: node},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+
+	if mutate != nil {
+		mutate(pod)
+	}
+
+	return pod
+}
+
+// evictByDeleting makes evictions delete the pod, as the apiserver
+// does, except that pods named in refusals are refused that many
+// times with 429, as a PodDisruptionBudget refuses them; a negative
+// count refuses for good. Evicted pod names are recorded in order.
+func evictByDeleting(clientset *fake.Clientset, refusals map[string]int, evicted *[]string) {
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(k8stesting.CreateAction)
+		if !ok || action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		meta, _ := create.GetObject().(metav1.Object)
+		name := meta.GetName()
+
+		if refusals[name] != 0 {
+			refusals[name]--
+
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+
+		*evicted = append(*evicted, name)
+
+		return true, nil, clientset.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), name)
+	})
+}
+
+func newTestDrainer(clientset *fake.Clientset, out *bytes.Buffer, timeout time.Duration) drainer {
+	return drainer{clientset: clientset, timeout: timeout, poll: time.Millisecond, w: out}
+}
+
+// TestDrainer_Drain pins what a drain evicts and whom it skips:
+// control-plane nodes and unregistered targets are left alone, and on
+// the worker only its own regular pods are evicted, the one guarded by
+// a disruption budget once the budget allows it.
+func TestDrainer_Drain(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(
+		drainTestNode("cp01", "192.0.2.10", "boot-cp", map[string]string{controlPlaneRoleLabel: ""}),
+		drainTestNode("worker01", "192.0.2.21", "boot-1", nil),
+		drainTestPod("web", "worker01", nil),
+		drainTestPod("db", "worker01", nil),
+		drainTestPod("agent", "worker01", func(p *v1.Pod) {
+			controller := true
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: &controller}}
+		}),
+		drainTestPod("static", "worker01", func(p *v1.Pod) {
+			p.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+		}),
+		drainTestPod("job", "worker01", func(p *v1.Pod) { p.Status.Phase = v1.PodSucceeded }),
+		drainTestPod("elsewhere", "cp01", nil),
+	)
+
+	var evicted []string
+
+	evictByDeleting(clientset, map[string]int{"db": 2}, &evicted)
+
+	var out bytes.Buffer
+
+	drained, err := newTestDrainer(clientset, &out, time.Minute).drain(context.Background(), []string{"192.0.2.10", "192.0.2.21", "192.0.2.99"})
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	if len(drained) != 1 || drained[0].name != "worker01" || drained[0].bootID != "boot-1" || drained[0].wasCordoned {
+		t.Fatalf("drained = %+v, want worker01 only", drained)
+	}
+
+	if !slices.Equal(evicted, []string{"web", "db"}) {
+		t.Errorf("evicted = %v, want web, then db once its budget allows", evicted)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "worker01", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !node.Spec.Unschedulable {
+		t.Error("worker01 must be cordoned")
+	}
+
+	cp, err := clientset.CoreV1().Nodes().Get(context.Background(), "cp01", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cp.Spec.Unschedulable {
+		t.Error("control-plane node must not be cordoned")
+	}
+
+	for _, want := range []string{
+		"cp01 (192.0.2.10) is a control-plane node, not drained",
+		"draining worker01 (192.0.2.21)",
+		"192.0.2.99 has not registered in Kubernetes",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestDrainer_Drain_BudgetTimeout pins that a budget that never allows
+// the eviction fails the drain within the timeout, names the pod, and
+// uncordons the node again since the reboot will not happen.
+func TestDrainer_Drain_BudgetTimeout(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(
+		drainTestNode("worker01", "192.0.2.21", "boot-1", nil),
+		drainTestPod("db", "worker01", nil),
+	)
+
+	var evicted []string
+
+	evictByDeleting(clientset, map[string]int{"db": -1}, &evicted)
+
+	var out bytes.Buffer
+
+	_, err := newTestDrainer(clientset, &out, 20*time.Millisecond).drain(context.Background(), []string{"192.0.2.21"})
+	if err == nil || !strings.Contains(err.Error(), "PodDisruptionBudget") || !strings.Contains(err.Error(), "default/db") {
+		t.Fatalf("drain error = %v, want a budget refusal naming default/db", err)
+	}
+
+	node, getErr := clientset.CoreV1().Nodes().Get(context.Background(), "worker01", metav1.GetOptions{})
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+
+	if node.Spec.Unschedulable {
+		t.Error("a failed drain must uncordon the node again")
+	}
+}
+
+// TestDrainer_Release pins that after a reboot a node is uncordoned
+// only once it reports Ready with a new boot ID, and that a node the
+// operator had cordoned before the drain stays cordoned.
+func TestDrainer_Release(t *testing.T) {
+	t.Parallel()
+
+	rebooted := drainTestNode("worker01", "192.0.2.21", "boot-2", nil)
+	rebooted.Spec.Unschedulable = true
+
+	notBack := drainTestNode("worker02", "192.0.2.22", "boot-1", nil)
+	notBack.Spec.Unschedulable = true
+
+	manual := drainTestNode("worker03", "192.0.2.23", "boot-2", nil)
+	manual.Spec.Unschedulable = true
+
+	clientset := fake.NewClientset(rebooted, notBack, manual)
+
+	var out bytes.Buffer
+
+	err := newTestDrainer(clientset, &out, 20*time.Millisecond).release(context.Background(), []drainedNode{
+		{target: "192.0.2.21", name: "worker01", bootID: "boot-1"},
+		{target: "192.0.2.22", name: "worker02", bootID: "boot-1"},
+		{target: "192.0.2.23", name: "worker03", bootID: "boot-1", wasCordoned: true},
+	}, true)
+	if err == nil || !strings.Contains(err.Error(), "worker02 (192.0.2.22) did not report Ready") {
+		t.Fatalf("release error = %v, want worker02 not Ready", err)
+	}
+
+	for name, want := range map[string]bool{"worker01": false, "worker02": true, "worker03": true} {
+		node, getErr := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if getErr != nil {
+			t.Fatal(getErr)
+		}
+
+		if node.Spec.Unschedulable != want {
+			t.Errorf("%s unschedulable = %v, want %v", name, node.Spec.Unschedulable, want)
+		}
+	}
+
+	if !strings.Contains(out.String(), "uncordoned worker01") || !strings.Contains(out.String(), "leaving it cordoned") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestDrainOptionsEnabled(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		opts drainOptions
+		want bool
+	}{
+		{drainOptions{}, false},
+		{drainOptions{drain: true}, true},
+		{drainOptions{drain: true, skip: true}, false},
+		{drainOptions{skip: true}, false},
+	} {
+		if got := tc.opts.enabled(); got != tc.want {
+			t.Errorf("%+v.enabled() = %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+}
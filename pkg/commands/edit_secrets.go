@@ -0,0 +1,351 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/certexpiry"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var editSecretsCmd = &cobra.Command{
+	Use:   "edit-secrets [file]",
+	Short: "Edit an encrypted secrets file in $EDITOR",
+	Long: `Decrypt an encrypted YAML file into a private temporary directory,
+open it in $VISUAL or $EDITOR, and encrypt it back when the editor exits.
+The file defaults to secrets.encrypted.yaml; values-secret.encrypted.yaml
+and any other file encrypted with talm.key work the same. A plain name
+(secrets.yaml) stands for its encrypted sibling.
+
+The plaintext never enters the project directory: it is written to a
+memory-backed directory ($XDG_RUNTIME_DIR or /dev/shm) when there is one,
+and removed when talm exits. Edits that are not valid YAML, or a secrets
+bundle whose certificates do not parse, are refused; on a terminal the
+editor reopens to fix them. Values left unchanged keep their ciphertext,
+so the diff shows only what was edited.`,
+	Args: cobra.MaximumNArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		file := ""
+		if len(args) == 1 {
+			file = args[0]
+		}
+
+		target := resolveSecretsEditTarget(file)
+
+		_, err := editSecrets(os.Stderr, target, runSecretsEditor, askReopenEditor)
+
+		return err
+	},
+}
+
+// secretsEditTarget is a file edit-secrets works on.
+type secretsEditTarget struct {
+	rootDir   string
+	encrypted string
+	// plain is the decrypted sibling in the project. It is only
+	// written when it already exists, to keep it in step.
+	plain string
+	// bundle marks the Talos secrets bundle, which is validated as
+	// one rather than as any YAML mapping.
+	bundle bool
+}
+
+// resolveSecretsEditTarget resolves the file argument of edit-secrets,
+// the project secrets file when empty. A name without the encrypted
+// suffix stands for its encrypted sibling.
+func resolveSecretsEditTarget(file string) secretsEditTarget {
+	layout := secretsLayout()
+
+	target := secretsEditTarget{
+		rootDir:   Config.RootDir,
+		encrypted: projectPath(layout.EncryptedSecretsFile()),
+		plain:     projectPath(layout.SecretsFile()),
+		bundle:    true,
+	}
+
+	if file == "" {
+		return target
+	}
+
+	if !strings.HasSuffix(file, age.EncryptedFileSuffix) {
+		file = age.EncryptedName(file)
+	}
+
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+
+	target.encrypted = file
+	target.plain = strings.TrimSuffix(file, age.EncryptedFileSuffix) + ".yaml"
+	target.bundle = filepath.Clean(file) == filepath.Clean(projectPath(layout.EncryptedSecretsFile()))
+
+	return target
+}
+
+// editSecrets decrypts target into a private temporary directory, runs
+// edit on the plaintext and encrypts the result back. Edits that fail
+// validation go back to edit as long as reopen says so; otherwise
+// nothing is written. It reports whether the file changed.
+func editSecrets(w io.Writer, target secretsEditTarget, edit func(path string) error, reopen func(error) bool) (bool, error) {
+	encrypted, err := os.ReadFile(target.encrypted)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return false, errors.WithHint(
+			errors.Wrapf(err, "reading %s", target.encrypted),
+			"encrypt the plain file first with `talm init --encrypt`",
+		)
+	}
+
+	plain, err := age.DecryptYAML(target.rootDir, encrypted)
+	if err != nil {
+		return false, errors.Wrapf(err, "decrypting %s", target.encrypted)
+	}
+
+	dir, inMemory, err := makeSecretsEditDir(target.rootDir, secretsEditDirs(), os.TempDir())
+	if err != nil {
+		return false, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	if !inMemory {
+		ui.Warnf(w, "no memory-backed directory is available; the plaintext goes to %s until the editor exits.", dir)
+	}
+
+	path := filepath.Join(dir, filepath.Base(target.plain))
+	if err := secureperm.WriteFile(path, plain); err != nil {
+		return false, errors.Wrap(err, "writing the plaintext for editing")
+	}
+
+	var edited []byte
+
+	for {
+		if err := edit(path); err != nil {
+			return false, errors.Wrap(err, "running the editor")
+		}
+
+		edited, err = os.ReadFile(path)
+		if err != nil {
+			return false, errors.Wrap(err, "reading the edited file")
+		}
+
+		if bytes.Equal(edited, plain) {
+			ui.Infof(w, "no changes to %s.", target.encrypted)
+
+			return false, nil
+		}
+
+		validationErr := validateEditedSecrets(edited, target.bundle)
+		if validationErr == nil {
+			break
+		}
+
+		if !reopen(validationErr) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return false, errors.WithHint(
+				errors.Wrapf(validationErr, "%s was left unchanged", target.encrypted),
+				"run `talm edit-secrets` again to redo the edit",
+			)
+		}
+	}
+
+	reencrypted, err := age.EncryptYAML(target.rootDir, edited, encrypted)
+	if err != nil {
+		return false, errors.Wrapf(err, "encrypting %s", target.encrypted)
+	}
+
+	if err := secureperm.WriteFile(target.encrypted, reencrypted); err != nil {
+		return false, errors.Wrapf(err, "writing %s", target.encrypted)
+	}
+
+	ui.Successf(w, "updated %s.", target.encrypted)
+
+	if fileExists(target.plain) {
+		if err := secureperm.WriteFile(target.plain, edited); err != nil {
+			return true, errors.Wrapf(err, "updating %s", target.plain)
+		}
+
+		ui.Infof(w, "updated the decrypted copy %s too.", target.plain)
+	}
+
+	return true, nil
+}
+
+// validateEditedSecrets checks an edit before it is encrypted: a YAML
+// mapping, and for the secrets bundle a bundle whose certificates
+// parse, so a bad paste fails here rather than at the next render.
+func validateEditedSecrets(data []byte, bundle bool) error {
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "the edited file is not valid YAML")
+	}
+
+	if doc == nil {
+		return errors.New("the edited file is empty")
+	}
+
+	if !bundle {
+		return nil
+	}
+
+	var parsed secrets.Bundle
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return errors.Wrap(err, "the edited file is not a Talos secrets bundle")
+	}
+
+	if _, err := certexpiry.FromBundle(&parsed); err != nil {
+		return errors.Wrap(err, "the edited secrets bundle")
+	}
+
+	return nil
+}
+
+// secretsEditDirs are the memory-backed directories the plaintext may
+// go to, most private first: the per-user runtime directory, then
+// /dev/shm on Linux.
+func secretsEditDirs() []string {
+	var dirs []string
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+
+	if runtime.GOOS == "linux" {
+		dirs = append(dirs, "/dev/shm")
+	}
+
+	return dirs
+}
+
+// makeSecretsEditDir creates the private directory the plaintext is
+// edited in: under the first of candidates that takes it, else under
+// fallback, which is not memory-backed. A directory inside the project
+// is refused, so the plaintext cannot be committed by accident.
+func makeSecretsEditDir(rootDir string, candidates []string, fallback string) (string, bool, error) {
+	for _, candidate := range candidates {
+		if dir, err := os.MkdirTemp(candidate, "talm-edit-"); err == nil {
+			return dir, true, nil
+		}
+	}
+
+	dir, err := os.MkdirTemp(fallback, "talm-edit-")
+	if err != nil {
+		return "", false, errors.Wrap(err, "creating a temporary directory for editing")
+	}
+
+	if rel, err := filepath.Rel(rootDir, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		_ = os.RemoveAll(dir)
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", false, errors.WithHint(
+			errors.Newf("the temporary directory %s is inside the project", dir),
+			"point TMPDIR outside the project so the plaintext cannot be committed",
+		)
+	}
+
+	return dir, false, nil
+}
+
+// secretsEditorCommand is the editor to run: $VISUAL, else $EDITOR,
+// else the platform default, split into its arguments.
+func secretsEditorCommand(visual, editor string) []string {
+	for _, value := range []string{visual, editor} {
+		if fields := strings.Fields(value); len(fields) > 0 {
+			return fields
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+
+	return []string{"vi"}
+}
+
+// runSecretsEditor opens path in the editor on the terminal. A var so
+// tests can edit the file themselves.
+//
+//nolint:gochecknoglobals // function-type indirection for test injection, like stdinIsTTY.
+var runSecretsEditor = func(path string) error {
+	editor := secretsEditorCommand(os.Getenv("VISUAL"), os.Getenv("EDITOR"))
+
+	cmd := exec.Command(editor[0], append(editor[1:], path)...) //nolint:gosec // the operator's own editor.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// Ctrl-C in the editor reaches talm too; talm must outlive the
+	// editor to remove the plaintext.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+	defer signal.Stop(interrupts)
+
+	return errors.Wrapf(cmd.Run(), "%s", strings.Join(editor, " "))
+}
+
+// askReopenEditor reports a failed validation and asks whether to fix
+// it in the editor. Without a terminal to ask on, the answer is no.
+func askReopenEditor(validationErr error) bool {
+	if !stdinIsTTY() {
+		return false
+	}
+
+	ui.Warnf(os.Stderr, "%v", validationErr)
+	fmt.Fprint(os.Stderr, "Reopen the editor to fix it? [Y/n]: ")
+
+	response, err := bufio.NewReader(stdinReader).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(response)) {
+	case "", "y", "yes":
+		return err == nil
+	default:
+		return false
+	}
+}
+
+func init() {
+	addCommand(editSecretsCmd)
+}
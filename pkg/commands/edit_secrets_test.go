@@ -0,0 +1,325 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// editSecretsValues is the plaintext of the values file the tests edit,
+// laid out as DecryptYAML writes it back.
+const editSecretsValues = "token: s3cr3t\nvault:\n    password: hunter2\n"
+
+// withEditSecretsProject roots a project with a key and an encrypted
+// values-secret file, and sends the plaintext to a runtime directory
+// of the test. It returns the target and the runtime directory.
+func withEditSecretsProject(t *testing.T) (secretsEditTarget, string) {
+	t.Helper()
+
+	dir := withGitFilterProject(t)
+
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	encrypted, err := age.EncryptYAML(dir, []byte(editSecretsValues), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := secretsEditTarget{
+		rootDir:   dir,
+		encrypted: filepath.Join(dir, "values-secret.encrypted.yaml"),
+		plain:     filepath.Join(dir, "values-secret.yaml"),
+	}
+
+	if err := os.WriteFile(target.encrypted, encrypted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return target, runtimeDir
+}
+
+// replaceInFile is an editor that replaces old with replacement in
+// the file, recording the path it was handed in seen.
+func replaceInFile(t *testing.T, old, replacement string, seen *string) func(string) error {
+	t.Helper()
+
+	return func(path string) error {
+		*seen = path
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(path, []byte(strings.Replace(string(data), old, replacement, 1)), 0o600)
+	}
+}
+
+func neverReopen(error) bool { return false }
+
+// TestEditSecrets_ReencryptsEdit pins the round trip: the plaintext is
+// edited outside the project and removed afterwards, the edit is
+// encrypted back, and an untouched value keeps its envelope.
+func TestEditSecrets_ReencryptsEdit(t *testing.T) {
+	target, runtimeDir := withEditSecretsProject(t)
+
+	before, err := os.ReadFile(target.encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var edited string
+
+	changed, err := editSecrets(io.Discard, target, replaceInFile(t, "s3cr3t", "rotated", &edited), neverReopen)
+	if err != nil || !changed {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+
+	if !strings.HasPrefix(edited, runtimeDir+string(filepath.Separator)) {
+		t.Errorf("the plaintext was edited at %s, outside the runtime directory", edited)
+	}
+
+	if _, err := os.Stat(filepath.Dir(edited)); !os.IsNotExist(err) {
+		t.Errorf("the temporary directory was left behind: %v", err)
+	}
+
+	after, err := os.ReadFile(target.encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(after), "rotated") || !age.ContainsEncryptedValues(after) {
+		t.Fatalf("the file was not encrypted back:\n%s", after)
+	}
+
+	plain, err := age.DecryptYAML(target.rootDir, after)
+	if err != nil || !strings.Contains(string(plain), "token: rotated") {
+		t.Fatalf("decrypted = %s, %v", plain, err)
+	}
+
+	if envelope := envelopeLine(t, before, "password"); envelope != envelopeLine(t, after, "password") {
+		t.Errorf("the untouched password was re-encrypted: %s", envelope)
+	}
+
+	if fileExists(target.plain) {
+		t.Error("a decrypted copy was created in the project")
+	}
+}
+
+// envelopeLine returns the line of data holding key.
+func envelopeLine(t *testing.T, data []byte, key string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, key+":") {
+			return line
+		}
+	}
+
+	t.Fatalf("no %s in:\n%s", key, data)
+
+	return ""
+}
+
+func TestEditSecrets_NoChanges(t *testing.T) {
+	target, _ := withEditSecretsProject(t)
+
+	before, err := os.ReadFile(target.encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := editSecrets(io.Discard, target, func(string) error { return nil }, neverReopen)
+	if err != nil || changed {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+
+	if after, _ := os.ReadFile(target.encrypted); string(after) != string(before) {
+		t.Error("an unchanged edit rewrote the file")
+	}
+}
+
+// TestEditSecrets_InvalidEdit pins that broken YAML is never written:
+// refused outright without a reopen, fixed in a second pass with one.
+func TestEditSecrets_InvalidEdit(t *testing.T) {
+	target, _ := withEditSecretsProject(t)
+
+	before, err := os.ReadFile(target.encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var edited string
+
+	breakYAML := replaceInFile(t, "token: s3cr3t", "token: [unclosed", &edited)
+
+	changed, err := editSecrets(io.Discard, target, breakYAML, neverReopen)
+	if err == nil || changed || !strings.Contains(err.Error(), "not valid YAML") || !strings.Contains(err.Error(), "left unchanged") {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+
+	if after, _ := os.ReadFile(target.encrypted); string(after) != string(before) {
+		t.Error("an invalid edit was written")
+	}
+
+	passes, reopened := 0, 0
+	fixOnSecondPass := func(path string) error {
+		passes++
+		if passes == 1 {
+			return breakYAML(path)
+		}
+
+		return replaceInFile(t, "token: [unclosed", "token: fixed", &edited)(path)
+	}
+
+	changed, err = editSecrets(io.Discard, target, fixOnSecondPass, func(error) bool {
+		reopened++
+
+		return true
+	})
+	if err != nil || !changed || reopened != 1 || passes != 2 {
+		t.Fatalf("changed = %v, reopened = %d, passes = %d, err = %v", changed, reopened, passes, err)
+	}
+}
+
+// TestEditSecrets_UpdatesPlainCopy pins that a decrypted copy already
+// in the project follows the edit rather than going stale.
+func TestEditSecrets_UpdatesPlainCopy(t *testing.T) {
+	target, _ := withEditSecretsProject(t)
+
+	if err := os.WriteFile(target.plain, []byte(editSecretsValues), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var edited string
+	if _, err := editSecrets(io.Discard, target, replaceInFile(t, "hunter2", "correct-horse", &edited), neverReopen); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain, _ := os.ReadFile(target.plain); !strings.Contains(string(plain), "password: correct-horse") {
+		t.Errorf("the decrypted copy was not updated:\n%s", plain)
+	}
+}
+
+func TestValidateEditedSecrets(t *testing.T) {
+	bundle := string(loadSharedSecretsYAML(t))
+
+	tests := []struct {
+		name    string
+		data    string
+		bundle  bool
+		wantErr string
+	}{
+		{name: "mapping", data: editSecretsValues},
+		{name: "secrets bundle", data: bundle, bundle: true},
+		{name: "not YAML", data: "token: [unclosed\n", wantErr: "not valid YAML"},
+		{name: "not a mapping", data: "- token\n", wantErr: "not valid YAML"},
+		{name: "empty", data: "", wantErr: "empty"},
+		{name: "mapping as a bundle", data: editSecretsValues, bundle: true, wantErr: "no certificates"},
+		{name: "broken certificate", data: strings.Replace(bundle, "crt: LS0t", "crt: AAAA", 1), bundle: true, wantErr: "certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEditedSecrets([]byte(tt.data), tt.bundle)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestMakeSecretsEditDir pins the fallback off a memory-backed
+// directory, and that a temporary directory inside the project is
+// refused.
+func TestMakeSecretsEditDir(t *testing.T) {
+	t.Parallel()
+
+	root, memory, fallback := t.TempDir(), t.TempDir(), t.TempDir()
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	dir, inMemory, err := makeSecretsEditDir(root, []string{missing, memory}, fallback)
+	if err != nil || !inMemory || filepath.Dir(dir) != memory {
+		t.Errorf("dir = %s, inMemory = %v, err = %v; want under %s", dir, inMemory, err, memory)
+	}
+
+	dir, inMemory, err = makeSecretsEditDir(root, []string{missing}, fallback)
+	if err != nil || inMemory || filepath.Dir(dir) != fallback {
+		t.Errorf("dir = %s, inMemory = %v, err = %v; want under %s", dir, inMemory, err, fallback)
+	}
+
+	inProject := filepath.Join(root, "tmp")
+	if err := os.Mkdir(inProject, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := makeSecretsEditDir(root, nil, inProject); err == nil || !strings.Contains(err.Error(), "inside the project") {
+		t.Errorf("err = %v, want the project directory refused", err)
+	}
+
+	if entries, _ := os.ReadDir(inProject); len(entries) != 0 {
+		t.Error("the refused directory was left behind")
+	}
+}
+
+func TestSecretsEditorCommand(t *testing.T) {
+	t.Parallel()
+
+	if got := secretsEditorCommand("code --wait", "nano"); strings.Join(got, " ") != "code --wait" {
+		t.Errorf("VISUAL: %q", got)
+	}
+
+	if got := secretsEditorCommand("  ", "nano -w"); strings.Join(got, " ") != "nano -w" {
+		t.Errorf("EDITOR: %q", got)
+	}
+
+	if got := secretsEditorCommand("", ""); len(got) != 1 || got[0] == "" {
+		t.Errorf("default: %q", got)
+	}
+}
+
+func TestResolveSecretsEditTarget(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+
+	target := resolveSecretsEditTarget("")
+	if target.encrypted != filepath.Join(dir, "secrets.encrypted.yaml") || target.plain != filepath.Join(dir, "secrets.yaml") || !target.bundle {
+		t.Errorf("default target = %+v", target)
+	}
+
+	target = resolveSecretsEditTarget(filepath.Join(dir, "secrets.yaml"))
+	if target.encrypted != filepath.Join(dir, "secrets.encrypted.yaml") || !target.bundle {
+		t.Errorf("plain name target = %+v", target)
+	}
+
+	target = resolveSecretsEditTarget(filepath.Join(dir, "values-secret.encrypted.yaml"))
+	if target.plain != filepath.Join(dir, "values-secret.yaml") || target.bundle {
+		t.Errorf("values target = %+v", target)
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	etcdresource "github.com/siderolabs/talos/pkg/machinery/resources/etcd"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// Upstream `talosctl etcd` subcommands that change etcd membership or
+// leadership. The wrapper checks their quorum impact first.
+const (
+	etcdRemoveMemberCmdName      = "remove-member"
+	etcdLeaveCmdName             = "leave"
+	etcdForfeitLeadershipCmdName = "forfeit-leadership"
+
+	// etcdForceFlag lets an operator go ahead with a change the
+	// quorum check refuses, e.g. to remove a member while another
+	// one is down for good.
+	etcdForceFlag = "force"
+)
+
+// etcdQuorumClient is the slice of the Talos client the quorum check
+// reads. Narrowed to an interface so tests can script membership and
+// member health.
+type etcdQuorumClient interface {
+	EtcdMemberList(ctx context.Context, req *machineapi.EtcdMemberListRequest, callOptions ...grpc.CallOption) (*machineapi.EtcdMemberListResponse, error)
+	EtcdStatus(ctx context.Context, opts ...grpc.CallOption) (*machineapi.EtcdStatusResponse, error)
+}
+
+// etcdMember is one etcd member as the quorum check sees it. healthy
+// is false for a member whose status could not be read or reported
+// errors: the check counts only members known to be serving.
+type etcdMember struct {
+	id       uint64
+	hostname string
+	address  string
+	learner  bool
+	healthy  bool
+}
+
+// etcdClusterView is the etcd membership with the health of each
+// member and the current leader (0 when unknown).
+type etcdClusterView struct {
+	members []etcdMember
+	leader  uint64
+}
+
+// etcdQuorum is the number of voting members etcd needs to agree on a
+// change: a majority.
+func etcdQuorum(voters int) int {
+	return voters/2 + 1
+}
+
+// voters counts the voting members and how many of them are healthy.
+// Learners do not vote and do not count toward quorum.
+func (v etcdClusterView) voters() (int, int) {
+	total, healthy := 0, 0
+
+	for _, m := range v.members {
+		if m.learner {
+			continue
+		}
+
+		total++
+
+		if m.healthy {
+			healthy++
+		}
+	}
+
+	return total, healthy
+}
+
+func (v etcdClusterView) member(id uint64) (etcdMember, bool) {
+	for _, m := range v.members {
+		if m.id == id {
+			return m, true
+		}
+	}
+
+	return etcdMember{}, false
+}
+
+// describe summarises the voting membership for messages.
+func (v etcdClusterView) describe() string {
+	total, healthy := v.voters()
+
+	return fmt.Sprintf("%d voting members, %d healthy, quorum %d", total, healthy, etcdQuorum(total))
+}
+
+// resolveMember turns the remove-member argument into a member ID. It
+// accepts the hex member ID `talm etcd members` prints and, for
+// convenience, a member hostname.
+func (v etcdClusterView) resolveMember(arg string) (uint64, error) {
+	if id, err := etcdresource.ParseMemberID(arg); err == nil {
+		if _, ok := v.member(id); ok {
+			return id, nil
+		}
+	}
+
+	for _, m := range v.members {
+		if m.hostname == arg {
+			return m.id, nil
+		}
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return 0, errors.WithHint(
+		errors.Newf("%s is not a member of the etcd cluster", arg),
+		"list the members with `talm etcd members` and pass a member ID or hostname from it",
+	)
+}
+
+// memberName labels a member in messages: hostname and ID.
+func (m etcdMember) memberName() string {
+	return fmt.Sprintf("%s (%s)", m.hostname, etcdresource.FormatMemberID(m.id))
+}
+
+// checkEtcdMemberRemoval refuses to remove member id when the healthy
+// voting members left afterwards would not form a quorum of the
+// smaller cluster, and returns the quorum impact otherwise. Removing
+// a learner never affects quorum.
+func checkEtcdMemberRemoval(v etcdClusterView, id uint64) (string, error) {
+	m, ok := v.member(id)
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("member %s is not part of the etcd cluster", etcdresource.FormatMemberID(id)),
+			"list the members with `talm etcd members`",
+		)
+	}
+
+	if m.learner {
+		return "learner " + m.memberName() + " does not vote; quorum is unaffected", nil
+	}
+
+	total, healthy := v.voters()
+	after, healthyAfter := total-1, healthy
+
+	if m.healthy {
+		healthyAfter--
+	}
+
+	if after == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("%s is the last voting etcd member; removing it destroys the cluster", m.memberName()),
+			"to rebuild etcd, use `talm recover` from a snapshot instead",
+		)
+	}
+
+	impact := fmt.Sprintf("%d -> %d voting members, quorum %d, tolerates %d failure(s) afterwards",
+		total, after, etcdQuorum(after), max(healthyAfter-etcdQuorum(after), 0))
+
+	if healthyAfter < etcdQuorum(after) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+		return "", errors.WithHintf(
+			errors.Newf("removing %s would leave %d healthy voting member(s), below the quorum of %d the remaining %d members need (now: %s)",
+				m.memberName(), healthyAfter, etcdQuorum(after), after, v.describe()),
+			"bring the unhealthy members back first (`talm etcd status`), or pass --%s if they are gone for good and you accept the risk", etcdForceFlag,
+		)
+	}
+
+	return impact, nil
+}
+
+// checkEtcdForfeitLeadership refuses to make the leader step down when
+// no other healthy voting member could take over, or when the healthy
+// members are too few to elect one.
+func checkEtcdForfeitLeadership(v etcdClusterView, id uint64) error {
+	m, ok := v.member(id)
+	if !ok || id != v.leader {
+		return nil
+	}
+
+	total, healthy := v.voters()
+
+	if healthy-1 < 1 || healthy < etcdQuorum(total) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+		return errors.WithHintf(
+			errors.Newf("%s cannot hand etcd leadership over: no quorum of healthy members would elect a new leader (%s)", m.memberName(), v.describe()),
+			"bring the unhealthy members back first (`talm etcd status`), or pass --%s to forfeit anyway", etcdForceFlag,
+		)
+	}
+
+	return nil
+}
+
+// readEtcdClusterView reads the membership through node and the status
+// of every member through its peer address. A member whose status
+// cannot be read is counted unhealthy.
+func readEtcdClusterView(ctx context.Context, c etcdQuorumClient, node string) (etcdClusterView, error) {
+	resp, err := c.EtcdMemberList(client.WithNode(ctx, node), &machineapi.EtcdMemberListRequest{})
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+		return etcdClusterView{}, errors.WithHintf(
+			errors.Wrapf(err, "listing etcd members through %s", node),
+			"the quorum check needs a control-plane node with running etcd; point --nodes or -f at one, or pass --%s to skip the check", etcdForceFlag,
+		)
+	}
+
+	var view etcdClusterView
+
+	for _, msg := range resp.GetMessages() {
+		if len(msg.GetMembers()) == 0 {
+			continue
+		}
+
+		for _, m := range msg.GetMembers() {
+			view.members = append(view.members, etcdMember{
+				id:       m.GetId(),
+				hostname: m.GetHostname(),
+				address:  etcdPeerHost(m.GetPeerUrls()),
+				learner:  m.GetIsLearner(),
+			})
+		}
+
+		break
+	}
+
+	var addresses []string
+
+	for _, m := range view.members {
+		if m.address != "" {
+			addresses = append(addresses, m.address)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return view, nil
+	}
+
+	// A partial response comes with an error naming the members that
+	// did not answer; those stay unhealthy.
+	status, _ := c.EtcdStatus(client.WithNodes(ctx, addresses...))
+
+	for _, msg := range status.GetMessages() {
+		st := msg.GetMemberStatus()
+		if st == nil {
+			continue
+		}
+
+		if st.GetLeader() != 0 {
+			view.leader = st.GetLeader()
+		}
+
+		for i := range view.members {
+			if view.members[i].id == st.GetMemberId() {
+				view.members[i].healthy = len(st.GetErrors()) == 0
+			}
+		}
+	}
+
+	return view, nil
+}
+
+// localEtcdMemberID returns the member ID of the etcd running on node.
+func localEtcdMemberID(ctx context.Context, c etcdQuorumClient, node string) (uint64, error) {
+	status, err := c.EtcdStatus(client.WithNode(ctx, node))
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return 0, errors.WithHint(
+			errors.Wrapf(err, "reading the etcd member of %s", node),
+			"the node must be a control-plane node with running etcd",
+		)
+	}
+
+	for _, msg := range status.GetMessages() {
+		if id := msg.GetMemberStatus().GetMemberId(); id != 0 {
+			return id, nil
+		}
+	}
+
+	return 0, errors.Newf("%s reported no etcd member", node)
+}
+
+// etcdPeerHost is the host of the first peer URL of a member: the
+// address the member's node is reachable on from the cluster.
+func etcdPeerHost(peerURLs []string) string {
+	for _, raw := range peerURLs {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+
+	return ""
+}
+
+// guardEtcdChange runs the quorum check of cmdName against the nodes
+// on ctx and returns the arguments to hand to upstream: remove-member
+// gets its member resolved to an ID. A refused change is reported as a
+// warning instead when force is set.
+func guardEtcdChange(ctx context.Context, c etcdQuorumClient, cmdName string, nodes, args []string, force bool) ([]string, error) {
+	refuse := func(err error) error {
+		if err == nil || !force {
+			return err
+		}
+
+		ui.Warnf(os.Stderr, "going ahead with --%s: %v", etcdForceFlag, err)
+
+		return nil
+	}
+
+	// Upstream reports the missing nodes itself.
+	if len(nodes) == 0 {
+		return args, nil
+	}
+
+	view, err := readEtcdClusterView(ctx, c, nodes[0])
+	if err != nil {
+		return args, refuse(err)
+	}
+
+	switch cmdName {
+	case etcdRemoveMemberCmdName:
+		id, err := view.resolveMember(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		m, _ := view.member(id)
+
+		impact, err := checkEtcdMemberRemoval(view, id)
+		if err := refuse(err); err != nil {
+			return nil, err
+		}
+
+		return []string{etcdresource.FormatMemberID(id)}, confirmEtcdChange("Remove etcd member "+m.memberName(), view, impact)
+	case etcdLeaveCmdName, etcdForfeitLeadershipCmdName:
+		for _, node := range nodes {
+			id, err := localEtcdMemberID(ctx, c, node)
+			if err != nil {
+				if err := refuse(err); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			if cmdName == etcdForfeitLeadershipCmdName {
+				if err := refuse(checkEtcdForfeitLeadership(view, id)); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			impact, err := checkEtcdMemberRemoval(view, id)
+			if err := refuse(err); err != nil {
+				return nil, err
+			}
+
+			if err := confirmEtcdChange("Make "+node+" leave the etcd cluster", view, impact); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return args, nil
+}
+
+// confirmEtcdChange asks before a membership change, showing the
+// cluster as it is and the quorum impact.
+func confirmEtcdChange(action string, view etcdClusterView, impact string) error {
+	c := confirmation{action: action}
+	c.add("Cluster", view.describe())
+	c.add("Afterwards", impact)
+
+	return confirmDestructive(c)
+}
+
+// wrapEtcdQuorumCommand adds the quorum check to the upstream etcd
+// membership and leadership commands. It runs in RunE, after the
+// wrapper PreRunE resolved the target nodes from the node files, and
+// talks to the nodes through the same talosconfig upstream uses.
+// --force turns a refusal into a warning.
+func wrapEtcdQuorumCommand(wrappedCmd *cobra.Command, cmdName string) {
+	wrappedCmd.Flags().Bool(etcdForceFlag, false, "go ahead even if the change would leave etcd without a quorum of healthy members")
+
+	if cmdName == etcdRemoveMemberCmdName {
+		wrappedCmd.Use = "remove-member <member ID or hostname>"
+	}
+
+	originalRunE := wrappedCmd.RunE
+
+	wrappedCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool(etcdForceFlag)
+
+		err := WithClient(func(ctx context.Context, c *client.Client) error {
+			var err error
+
+			args, err = guardEtcdChange(ctx, c, cmdName, GlobalArgs.Nodes, args, force)
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return originalRunE(cmd, args)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+// fakeEtcdQuorumClient serves a three-member cluster whose members sit
+// on 192.0.2.11-13. down lists the peer addresses that do not answer
+// the status call; leader is the member every answer names.
+type fakeEtcdQuorumClient struct {
+	down   map[string]bool
+	leader uint64
+}
+
+//nolint:gochecknoglobals // fixture table shared by the etcd quorum tests.
+var fakeEtcdMembers = []*machineapi.EtcdMember{
+	{Id: 0x11, Hostname: "cp1", PeerUrls: []string{"https://192.0.2.11:2380"}},
+	{Id: 0x12, Hostname: "cp2", PeerUrls: []string{"https://192.0.2.12:2380"}},
+	{Id: 0x13, Hostname: "cp3", PeerUrls: []string{"https://192.0.2.13:2380"}},
+}
+
+func (f *fakeEtcdQuorumClient) EtcdMemberList(_ context.Context, _ *machineapi.EtcdMemberListRequest, _ ...grpc.CallOption) (*machineapi.EtcdMemberListResponse, error) {
+	return &machineapi.EtcdMemberListResponse{Messages: []*machineapi.EtcdMembers{{Members: fakeEtcdMembers}}}, nil
+}
+
+func (f *fakeEtcdQuorumClient) EtcdStatus(ctx context.Context, _ ...grpc.CallOption) (*machineapi.EtcdStatusResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	nodes := md.Get("nodes")
+	if node := md.Get("node"); len(node) > 0 {
+		nodes = node
+	}
+
+	resp := &machineapi.EtcdStatusResponse{}
+
+	var err error
+
+	for _, node := range nodes {
+		if f.down[node] {
+			err = errors.Newf("%s: connection refused", node)
+
+			continue
+		}
+
+		id := uint64(0x10) + uint64(node[len(node)-1]-'0')
+		resp.Messages = append(resp.Messages, &machineapi.EtcdStatus{
+			MemberStatus: &machineapi.EtcdMemberStatus{MemberId: id, Leader: f.leader},
+		})
+	}
+
+	return resp, err
+}
+
+func testEtcdView(healthy map[uint64]bool, leader uint64) etcdClusterView {
+	view := etcdClusterView{leader: leader}
+
+	for _, m := range fakeEtcdMembers {
+		view.members = append(view.members, etcdMember{id: m.GetId(), hostname: m.GetHostname(), healthy: healthy[m.GetId()]})
+	}
+
+	return view
+}
+
+func TestCheckEtcdMemberRemoval(t *testing.T) {
+	t.Parallel()
+
+	allHealthy := testEtcdView(map[uint64]bool{0x11: true, 0x12: true, 0x13: true}, 0x11)
+
+	impact, err := checkEtcdMemberRemoval(allHealthy, 0x13)
+	if err != nil || impact != "3 -> 2 voting members, quorum 2, tolerates 0 failure(s) afterwards" {
+		t.Errorf("healthy cluster: %q, %v", impact, err)
+	}
+
+	// cp3 is down: removing it is how the operator recovers.
+	oneDown := testEtcdView(map[uint64]bool{0x11: true, 0x12: true}, 0x11)
+	if _, err := checkEtcdMemberRemoval(oneDown, 0x13); err != nil {
+		t.Errorf("removing the broken member must be allowed: %v", err)
+	}
+
+	// Removing a healthy member while cp3 is down leaves one healthy
+	// member of two: no quorum.
+	_, err = checkEtcdMemberRemoval(oneDown, 0x12)
+	if err == nil || !strings.Contains(err.Error(), "below the quorum of 2") {
+		t.Errorf("removal below quorum must be refused, got %v", err)
+	}
+
+	if !strings.Contains(strings.Join(errors.GetAllHints(err), " "), "--force") {
+		t.Errorf("the refusal must point at --force: %v", errors.GetAllHints(err))
+	}
+
+	last := etcdClusterView{members: []etcdMember{{id: 0x11, hostname: "cp1", healthy: true}}}
+	if _, err := checkEtcdMemberRemoval(last, 0x11); err == nil || !strings.Contains(err.Error(), "last voting etcd member") {
+		t.Errorf("removing the last member must be refused, got %v", err)
+	}
+
+	learner := allHealthy
+	learner.members = append(append([]etcdMember(nil), allHealthy.members...), etcdMember{id: 0x14, hostname: "cp4", learner: true})
+
+	if impact, err := checkEtcdMemberRemoval(learner, 0x14); err != nil || !strings.Contains(impact, "quorum is unaffected") {
+		t.Errorf("learner removal: %q, %v", impact, err)
+	}
+
+	if _, err := checkEtcdMemberRemoval(allHealthy, 0x99); err == nil {
+		t.Error("an unknown member must be refused")
+	}
+}
+
+func TestCheckEtcdForfeitLeadership(t *testing.T) {
+	t.Parallel()
+
+	if err := checkEtcdForfeitLeadership(testEtcdView(map[uint64]bool{0x11: true, 0x12: true, 0x13: true}, 0x11), 0x11); err != nil {
+		t.Errorf("healthy cluster: %v", err)
+	}
+
+	if err := checkEtcdForfeitLeadership(testEtcdView(map[uint64]bool{0x11: true}, 0x11), 0x12); err != nil {
+		t.Errorf("a follower has no leadership to forfeit: %v", err)
+	}
+
+	if err := checkEtcdForfeitLeadership(testEtcdView(map[uint64]bool{0x11: true}, 0x11), 0x11); err == nil {
+		t.Error("forfeiting with no healthy follower must be refused")
+	}
+
+	single := etcdClusterView{members: []etcdMember{{id: 0x11, hostname: "cp1", healthy: true}}, leader: 0x11}
+	if err := checkEtcdForfeitLeadership(single, 0x11); err == nil {
+		t.Error("a single member cannot hand leadership over")
+	}
+}
+
+func TestEtcdClusterView_ResolveMember(t *testing.T) {
+	t.Parallel()
+
+	view := testEtcdView(nil, 0)
+
+	for arg, want := range map[string]uint64{"0000000000000012": 0x12, "12": 0x12, "cp3": 0x13} {
+		if got, err := view.resolveMember(arg); err != nil || got != want {
+			t.Errorf("resolveMember(%q) = %x, %v", arg, got, err)
+		}
+	}
+
+	if _, err := view.resolveMember("cp9"); err == nil {
+		t.Error("an unknown hostname must be refused")
+	}
+}
+
+// TestReadEtcdClusterView pins that a member whose status call fails
+// is counted unhealthy rather than failing the check.
+func TestReadEtcdClusterView(t *testing.T) {
+	t.Parallel()
+
+	c := &fakeEtcdQuorumClient{down: map[string]bool{"192.0.2.13": true}, leader: 0x11}
+
+	view, err := readEtcdClusterView(context.Background(), c, "192.0.2.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthy := map[string]bool{}
+	for _, m := range view.members {
+		healthy[m.hostname] = m.healthy
+	}
+
+	if !reflect.DeepEqual(healthy, map[string]bool{"cp1": true, "cp2": true, "cp3": false}) || view.leader != 0x11 {
+		t.Errorf("view = %+v", view)
+	}
+
+	if view.members[0].address != "192.0.2.11" {
+		t.Errorf("address = %q", view.members[0].address)
+	}
+}
+
+func TestGuardEtcdChange(t *testing.T) {
+	withConfirmationTarget(t, "192.0.2.11")
+
+	origAssumeYes := AssumeYes
+	t.Cleanup(func() { AssumeYes = origAssumeYes })
+
+	AssumeYes = true
+
+	c := &fakeEtcdQuorumClient{down: map[string]bool{"192.0.2.13": true}, leader: 0x11}
+	ctx := context.Background()
+
+	args, err := guardEtcdChange(ctx, c, etcdRemoveMemberCmdName, []string{"192.0.2.11"}, []string{"cp3"}, false)
+	if err != nil || !reflect.DeepEqual(args, []string{"0000000000000013"}) {
+		t.Errorf("remove-member cp3: %v, %v", args, err)
+	}
+
+	if _, err := guardEtcdChange(ctx, c, etcdLeaveCmdName, []string{"192.0.2.12"}, nil, false); err == nil {
+		t.Error("cp2 leaving while cp3 is down must be refused")
+	}
+
+	if _, err := guardEtcdChange(ctx, c, etcdLeaveCmdName, []string{"192.0.2.12"}, nil, true); err != nil {
+		t.Errorf("--force must turn the refusal into a warning: %v", err)
+	}
+
+	if _, err := guardEtcdChange(ctx, c, etcdForfeitLeadershipCmdName, []string{"192.0.2.11"}, nil, false); err != nil {
+		t.Errorf("forfeit with a healthy follower: %v", err)
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+// explainCommandName names explain in render errors.
+const explainCommandName = "talm explain"
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var explainCmdFlags struct {
+	configFile string
+	path       string
+	release    string
+	offline    bool
+	insecure   bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var explainCmd = &cobra.Command{
+	Use:     "explain [path]",
+	Aliases: []string{"why"},
+	Short:   "Trace a field of a node's config back to its template, node file and values",
+	Long: `Render the templates of a node file, as talm template does, and show where the
+field at the path comes from, in every document that has it:
+
+  - the template file and the named template (define) that produced the
+    document;
+  - the value the templates render, and the value the node file body sets
+    over it, if any;
+  - the values the rendered value matches, with the values file, or --set,
+    that supplies each of them.
+
+The values are matched by content: a rendered scalar equal to a value of
+the merged values is listed with its path under .Values, so a value the
+chart computes from several values does not show up. The render uses the
+values, value files and --set options of Chart.yaml templateOptions, or
+with --release the values locked for that release.
+
+The path is given as the argument or with --path. It is a dot-separated
+list of keys, as for talm values get, and may start with a dot as in jq and
+yq; a number selects a list item. A path the templates and the node file
+leave unset keeps its Talos default. talm why is the same command.`,
+	Example: `  talm explain -f nodes/node1.yaml --path machine.network
+  talm why -f nodes/node1.yaml .machine.install.disk
+  talm explain -f nodes/node1.yaml machine.install.disk --offline`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := explainFieldPath(explainCmdFlags.path, args)
+		if err != nil {
+			return err
+		}
+
+		segments, err := parseValuesPath(path)
+		if err != nil {
+			return err
+		}
+
+		file := explainCmdFlags.configFile
+
+		if err := DetectAndSetRootFromFiles([]string{file}); err != nil {
+			return err
+		}
+
+		_, modelineConfig, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return errors.Wrap(err, "modeline parsing failed")
+		}
+
+		if len(modelineConfig.Templates) == 0 {
+			//nolint:wrapcheck // sentinel constructed in-place; WithHint attaches operator guidance
+			return errors.WithHint(
+				errors.New("modeline does not contain templates information"),
+				"add a `# talm: templates=[...]` modeline at the top of the node file",
+			)
+		}
+
+		if len(GlobalArgs.Nodes) == 0 {
+			GlobalArgs.Nodes = modelineConfig.Nodes
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = modelineConfig.Endpoints
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = []string{defaultLocalEndpoint}
+		}
+
+		valuesLock, err := resolveReleaseValuesLock(cmd.Flags(), Config.RootDir, explainCmdFlags.release)
+		if err != nil {
+			return err
+		}
+
+		opts := projectRenderOptions(explainCommandName, valuesLock)
+		opts.Offline = explainCmdFlags.offline
+		opts.TemplateFiles = resolveEngineTemplatePaths(modelineConfig.Templates, Config.RootDir)
+		opts.Role = modelineConfig.Role
+
+		opts.TalosVersion, err = nodeTalosVersion(Config.RootDir, GlobalArgs.Nodes, opts.TalosVersion)
+		if err != nil {
+			return err
+		}
+
+		run := func(ctx context.Context, c *client.Client) error {
+			return runExplain(ctx, c, opts, file, segments, cmd.OutOrStdout())
+		}
+
+		switch {
+		case explainCmdFlags.offline:
+			return run(cmd.Context(), nil)
+		case explainCmdFlags.insecure:
+			return WithClientMaintenance(nil, run)
+		default:
+			return WithClient(run)
+		}
+	},
+}
+
+// explainFieldPath picks the path to trace from --path or the argument,
+// which must not both be given, and drops the leading dot of the jq
+// spelling.
+func explainFieldPath(flag string, args []string) (string, error) {
+	path := flag
+
+	switch {
+	case len(args) > 0 && flag != "":
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("the path is given both as an argument (%s) and with --path (%s)", args[0], flag),
+			"pass the path once, e.g. `talm why -f nodes/node1.yaml .machine.install.disk`",
+		)
+	case len(args) > 0:
+		path = args[0]
+	case flag == "":
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.New("no field path to trace"),
+			"pass the path as the argument or with --path, e.g. `talm why -f nodes/node1.yaml .machine.install.disk`",
+		)
+	}
+
+	return strings.TrimPrefix(path, "."), nil
+}
+
+// fieldTrace is what explain found at the path in one document.
+type fieldTrace struct {
+	document engine.DocumentOrigin
+	// rendered is the value the templates render, nil when they do not
+	// set the path; body is the value the node file sets over it.
+	rendered, body *yaml.Node
+	values         []valueTrace
+}
+
+// valueTrace is a rendered scalar that equals a value of .Values.
+type valueTrace struct {
+	field, value, valuesPath, layer string
+}
+
+// valueSource is where a scalar of the merged values comes from.
+type valueSource struct {
+	path, layer string
+}
+
+// runExplain renders opts, traces segments through the rendered
+// documents and the body of file, and writes the traces on out.
+//
+//nolint:gocritic // hugeParam: engine.Options is passed by value like engine.Render takes it.
+func runExplain(ctx context.Context, c *client.Client, opts engine.Options, file string, segments []string, out io.Writer) error {
+	ownership := engine.NewOwnership()
+	opts.Ownership = ownership
+
+	rendered, err := engine.Render(ctx, c, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to render templates")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	body, err := modeline.NodeFileBody(data)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	merged, err := engine.EffectiveValues(opts)
+	if err != nil {
+		return errors.Wrap(err, "resolving the effective values")
+	}
+
+	layers, err := engine.ValueLayers(opts)
+	if err != nil {
+		return errors.Wrap(err, "reading the value layers")
+	}
+
+	traces, err := explainPath(rendered, body, ownership.Documents(), segments, indexValueSources(merged, layers))
+	if err != nil {
+		return errors.Wrapf(err, "tracing %s", file)
+	}
+
+	path := strings.Join(segments, ".")
+
+	if len(traces) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("neither the templates nor %s set %s", file, path),
+			"the field keeps its Talos default; `talm template -f %s --full` shows the complete config", file,
+		)
+	}
+
+	return writeFieldTraces(out, path, traces)
+}
+
+// explainPath looks segments up in every rendered document and in the
+// node file body documents. documents are the origins of the rendered
+// documents, in their order; a body document that matches no rendered
+// one is the node file's own.
+func explainPath(rendered, body []byte, documents []engine.DocumentOrigin, segments []string, sources map[string][]valueSource) ([]fieldTrace, error) {
+	renderedDocs, err := decodeAllYAMLDocs(rendered)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the rendered config")
+	}
+
+	bodyDocs, err := decodeAllYAMLDocs(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the node file body")
+	}
+
+	var traces []fieldTrace
+
+	matched := make([]bool, len(bodyDocs))
+
+	for i, doc := range renderedDocs {
+		origin := yamlDocumentOrigin(doc)
+		if len(documents) == len(renderedDocs) {
+			origin = documents[i]
+		}
+
+		trace := fieldTrace{document: origin, rendered: lookupDocumentPath(doc, segments)}
+
+		for j, bodyDoc := range bodyDocs {
+			if !matched[j] && sameDocument(yamlDocumentOrigin(bodyDoc), origin) {
+				matched[j] = true
+				trace.body = lookupDocumentPath(bodyDoc, segments)
+
+				break
+			}
+		}
+
+		if trace.rendered == nil && trace.body == nil {
+			continue
+		}
+
+		if trace.rendered != nil {
+			trace.values = traceRenderedValues(trace.rendered, segments, sources)
+		}
+
+		traces = append(traces, trace)
+	}
+
+	for j, bodyDoc := range bodyDocs {
+		if matched[j] {
+			continue
+		}
+
+		if value := lookupDocumentPath(bodyDoc, segments); value != nil {
+			traces = append(traces, fieldTrace{document: yamlDocumentOrigin(bodyDoc), body: value})
+		}
+	}
+
+	return traces, nil
+}
+
+// lookupDocumentPath returns the node at segments in doc, or nil when
+// doc does not have the path.
+func lookupDocumentPath(doc *yaml.Node, segments []string) *yaml.Node {
+	if doc == nil || len(doc.Content) == 0 {
+		return nil
+	}
+
+	node, err := lookupValuesPath(doc.Content[0], segments)
+	if err != nil {
+		return nil
+	}
+
+	return node
+}
+
+// yamlDocumentOrigin names doc by its kind and name fields, a document
+// without a kind being the machine config.
+func yamlDocumentOrigin(doc *yaml.Node) engine.DocumentOrigin {
+	origin := engine.DocumentOrigin{Kind: engine.MachineConfigKind}
+
+	if doc == nil || len(doc.Content) == 0 {
+		return origin
+	}
+
+	if kind := lookupDocumentPath(doc, []string{"kind"}); kind != nil && kind.Kind == yaml.ScalarNode {
+		origin.Kind = kind.Value
+	}
+
+	if name := lookupDocumentPath(doc, []string{"name"}); name != nil && name.Kind == yaml.ScalarNode {
+		origin.Name = name.Value
+	}
+
+	return origin
+}
+
+func sameDocument(a, b engine.DocumentOrigin) bool {
+	return a.Kind == b.Kind && a.Name == b.Name
+}
+
+// traceRenderedValues lists the scalars under node, found at segments,
+// that equal a value of .Values.
+func traceRenderedValues(node *yaml.Node, segments []string, sources map[string][]valueSource) []valueTrace {
+	var traces []valueTrace
+
+	walkYAMLScalars(node, segments, func(field []string, scalar *yaml.Node) {
+		if !traceableScalar(scalar.ShortTag(), scalar.Value) {
+			return
+		}
+
+		for _, source := range sources[scalar.Value] {
+			traces = append(traces, valueTrace{
+				field:      strings.Join(field, "."),
+				value:      scalar.Value,
+				valuesPath: source.path,
+				layer:      source.layer,
+			})
+		}
+	})
+
+	return traces
+}
+
+func walkYAMLScalars(node *yaml.Node, path []string, visit func([]string, *yaml.Node)) {
+	node = resolveYAMLAlias(node)
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		visit(path, node)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkYAMLScalars(node.Content[i+1], append(slices.Clip(path), node.Content[i].Value), visit)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			walkYAMLScalars(item, append(slices.Clip(path), strconv.Itoa(i)), visit)
+		}
+	}
+}
+
+// traceableScalar reports whether a scalar is distinctive enough to
+// match against the values: booleans, nulls and single characters
+// would match half of them.
+func traceableScalar(tag, value string) bool {
+	return tag != "!!bool" && tag != "!!null" && len(value) > 1
+}
+
+// indexValueSources maps every traceable scalar of the merged values
+// to the paths that hold it, each with the last layer that sets the
+// path to that value. A value no layer sets as merged is left out.
+func indexValueSources(merged map[string]any, layers []engine.ValueLayer) map[string][]valueSource {
+	index := map[string][]valueSource{}
+
+	walkValueScalars(merged, nil, func(path []string, value any) {
+		text := fmt.Sprint(value)
+
+		tag := "!!str"
+		if _, ok := value.(bool); ok {
+			tag = "!!bool"
+		}
+
+		if value == nil || !traceableScalar(tag, text) {
+			return
+		}
+
+		for _, layer := range slices.Backward(layers) {
+			if got, ok := valueAtPath(layer.Values, path); ok && fmt.Sprint(got) == text {
+				index[text] = append(index[text], valueSource{path: strings.Join(path, "."), layer: layer.Name})
+
+				return
+			}
+		}
+	})
+
+	return index
+}
+
+func walkValueScalars(value any, path []string, visit func([]string, any)) {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(v) {
+			walkValueScalars(v[key], append(slices.Clip(path), key), visit)
+		}
+	case []any:
+		for i, item := range v {
+			walkValueScalars(item, append(slices.Clip(path), strconv.Itoa(i)), visit)
+		}
+	default:
+		visit(path, v)
+	}
+}
+
+// valueAtPath returns the value at path below value, list items being
+// addressed by their index.
+func valueAtPath(value any, path []string) (any, bool) {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+
+			value = child
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// writeFieldTraces writes one block per document that has the field.
+func writeFieldTraces(w io.Writer, path string, traces []fieldTrace) error {
+	var buf strings.Builder
+
+	for i, trace := range traces {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		fmt.Fprintf(&buf, "%s in %s\n", path, trace.document)
+
+		switch {
+		case len(trace.document.Sources) > 0:
+			for _, source := range trace.document.Sources {
+				fmt.Fprintf(&buf, "  Source:    %s\n", source)
+			}
+		case trace.rendered == nil:
+			buf.WriteString("  Source:    the node file adds this document\n")
+		}
+
+		if trace.rendered != nil {
+			writeTracedNode(&buf, "Rendered:", trace.rendered)
+		} else {
+			buf.WriteString("  Rendered:  not set by the templates\n")
+		}
+
+		if trace.body != nil {
+			writeTracedNode(&buf, "Node file:", trace.body)
+		} else {
+			buf.WriteString("  Node file: not set\n")
+		}
+
+		if len(trace.values) > 0 {
+			buf.WriteString("  Values:\n")
+		}
+
+		for _, value := range trace.values {
+			fmt.Fprintf(&buf, "    %s = %s  <- .Values.%s (%s)\n", value.field, value.value, value.valuesPath, displayValueLayer(value.layer))
+		}
+	}
+
+	_, err := io.WriteString(w, buf.String())
+
+	return errors.Wrap(err, "writing the explanation")
+}
+
+// writeTracedNode writes a scalar on the label's line and a map or list
+// as indented YAML below it.
+func writeTracedNode(buf *strings.Builder, label string, node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		fmt.Fprintf(buf, "  %-10s %s\n", label, node.Value)
+
+		return
+	}
+
+	fmt.Fprintf(buf, "  %s\n", label)
+
+	out, err := encodeAllYAMLDocs([]*yaml.Node{node})
+	if err != nil {
+		fmt.Fprintf(buf, "    (%v)\n", err)
+
+		return
+	}
+
+	for line := range strings.Lines(string(out)) {
+		fmt.Fprintf(buf, "    %s", line)
+	}
+}
+
+// displayValueLayer shows a value file relative to the project root.
+func displayValueLayer(layer string) string {
+	if layer == engine.SetValuesLayer || !filepath.IsAbs(layer) {
+		return layer
+	}
+
+	if rel, err := filepath.Rel(Config.RootDir, layer); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return layer
+}
+
+func init() {
+	explainCmd.Flags().StringVarP(&explainCmdFlags.configFile, "file", "f", "", "node file whose config to explain")
+	explainCmd.Flags().StringVar(&explainCmdFlags.path, "path", "", "dot-separated path of the field to trace, e.g. machine.network; the same as the argument")
+	explainCmd.Flags().StringVar(&explainCmdFlags.release, "release", "", "trace the values locked for this release tag by `talm snapshot values` instead of values.yaml and the value files")
+	explainCmd.Flags().BoolVar(&explainCmdFlags.offline, "offline", false, "render without connecting to the node; lookups return nothing")
+	explainCmd.Flags().BoolVarP(&explainCmdFlags.insecure, "insecure", "i", false, "render against the insecure (encrypted with no auth) maintenance service")
+
+	_ = explainCmd.MarkFlagRequired("file")
+	_ = explainCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	addCommand(explainCmd)
+}
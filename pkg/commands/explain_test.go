@@ -0,0 +1,219 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+const explainRendered = `machine:
+  type: controlplane
+  network:
+    hostname: cp01
+    interfaces:
+      - interface: eth0
+        mtu: 9000
+---
+apiVersion: v1alpha1
+kind: LinkConfig
+name: eth0
+mtu: 9000
+`
+
+func explainDocuments() []engine.DocumentOrigin {
+	return []engine.DocumentOrigin{
+		{Kind: engine.MachineConfigKind, Sources: []engine.DocumentSource{{Template: "talm/templates/controlplane.yaml", Helper: "talos.config"}}},
+		{Kind: "LinkConfig", Name: "eth0", Sources: []engine.DocumentSource{{Template: "talm/templates/controlplane.yaml", Helper: "talos.config.network"}}},
+	}
+}
+
+func TestExplainPath(t *testing.T) {
+	t.Parallel()
+
+	sources := indexValueSources(
+		map[string]any{"mtu": 9000, "hostname": "cp01", "advertisedSubnets": []any{"192.0.2.0/24"}},
+		[]engine.ValueLayer{
+			{Name: "/project/values.yaml", Values: map[string]any{"mtu": float64(1500), "hostname": "cp01"}},
+			{Name: "/project/values-prod.yaml", Values: map[string]any{"mtu": 9000}},
+		},
+	)
+
+	body := []byte("machine:\n  network:\n    hostname: cp01-override\n")
+
+	traces, err := explainPath([]byte(explainRendered), body, explainDocuments(), []string{"machine", "network", "hostname"}, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(traces) != 1 {
+		t.Fatalf("traces = %+v, want the machine config alone", traces)
+	}
+
+	trace := traces[0]
+	if trace.document.String() != engine.MachineConfigKind || trace.rendered.Value != "cp01" || trace.body.Value != "cp01-override" {
+		t.Errorf("trace = %+v", trace)
+	}
+
+	if want := []valueTrace{{field: "machine.network.hostname", value: "cp01", valuesPath: "hostname", layer: "/project/values.yaml"}}; !reflect.DeepEqual(trace.values, want) {
+		t.Errorf("values = %+v, want %+v", trace.values, want)
+	}
+
+	traces, err = explainPath([]byte(explainRendered), nil, explainDocuments(), []string{"mtu"}, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(traces) != 1 || traces[0].document.String() != "LinkConfig/eth0" || traces[0].body != nil {
+		t.Fatalf("traces = %+v, want the LinkConfig alone", traces)
+	}
+
+	// The last layer setting mtu to the merged value wins.
+	if want := []valueTrace{{field: "mtu", value: "9000", valuesPath: "mtu", layer: "/project/values-prod.yaml"}}; !reflect.DeepEqual(traces[0].values, want) {
+		t.Errorf("values = %+v, want %+v", traces[0].values, want)
+	}
+}
+
+// TestExplainPath_NodeFileDocument pins that a document only the node
+// file carries is reported without a template source, and one the
+// templates also render is matched by kind and name.
+func TestExplainPath_NodeFileDocument(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`apiVersion: v1alpha1
+kind: LinkConfig
+name: eth0
+mtu: 1400
+---
+apiVersion: v1alpha1
+kind: LinkConfig
+name: eth1
+mtu: 1400
+`)
+
+	traces, err := explainPath([]byte(explainRendered), body, explainDocuments(), []string{"mtu"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(traces) != 2 {
+		t.Fatalf("traces = %+v, want eth0 and eth1", traces)
+	}
+
+	if traces[0].document.String() != "LinkConfig/eth0" || traces[0].rendered.Value != "9000" || traces[0].body.Value != "1400" {
+		t.Errorf("eth0 = %+v", traces[0])
+	}
+
+	if traces[1].document.String() != "LinkConfig/eth1" || traces[1].rendered != nil || len(traces[1].document.Sources) != 0 {
+		t.Errorf("eth1 = %+v", traces[1])
+	}
+}
+
+func TestExplainPath_Unset(t *testing.T) {
+	t.Parallel()
+
+	traces, err := explainPath([]byte(explainRendered), nil, explainDocuments(), []string{"machine", "install", "disk"}, nil)
+	if err != nil || len(traces) != 0 {
+		t.Errorf("traces = %+v, err = %v, want none", traces, err)
+	}
+}
+
+// TestIndexValueSources_SkipsAmbiguous pins that booleans, nulls and
+// single characters are not indexed: they would match half the
+// rendered config.
+func TestIndexValueSources_SkipsAmbiguous(t *testing.T) {
+	t.Parallel()
+
+	merged := map[string]any{"enabled": true, "replicas": 3, "none": nil, "name": "cp01"}
+	layers := []engine.ValueLayer{{Name: "values.yaml", Values: merged}}
+
+	got := indexValueSources(merged, layers)
+	if want := map[string][]valueSource{"cp01": {{path: "name", layer: "values.yaml"}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteFieldTraces(t *testing.T) {
+	t.Parallel()
+
+	sources := map[string][]valueSource{"9000": {{path: "mtu", layer: engine.SetValuesLayer}}}
+
+	traces, err := explainPath([]byte(explainRendered), nil, explainDocuments(), []string{"machine", "network", "interfaces"}, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeFieldTraces(&buf, "machine.network.interfaces", traces); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `machine.network.interfaces in v1alpha1
+  Source:    talm/templates/controlplane.yaml (talos.config)
+  Rendered:
+    - interface: eth0
+      mtu: 9000
+  Node file: not set
+  Values:
+    machine.network.interfaces.0.mtu = 9000  <- .Values.mtu (--set)
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+
+	if strings.Contains(buf.String(), "LinkConfig") {
+		t.Error("a document without the path must not be listed")
+	}
+}
+
+func TestExplainFieldPath(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		flag string
+		args []string
+		want string
+	}{
+		{"machine.network", nil, "machine.network"},
+		{"", []string{".machine.install.disk"}, "machine.install.disk"},
+		{"", []string{"machine.install.disk"}, "machine.install.disk"},
+		{".cluster.network", nil, "cluster.network"},
+	} {
+		got, err := explainFieldPath(tc.flag, tc.args)
+		if err != nil || got != tc.want {
+			t.Errorf("explainFieldPath(%q, %v) = %q, %v; want %q", tc.flag, tc.args, got, err, tc.want)
+		}
+	}
+
+	_, err := explainFieldPath("machine.network", []string{".machine.install.disk"})
+	if err == nil || !strings.Contains(err.Error(), "the path is given both as an argument (.machine.install.disk) and with --path (machine.network)") {
+		t.Errorf("err = %v", err)
+	}
+
+	_, err = explainFieldPath("", nil)
+	if err == nil || !strings.Contains(err.Error(), "no field path to trace") {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "talm why -f nodes/node1.yaml .machine.install.disk") {
+		t.Errorf("hint = %q", hints)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/secureperm"
+)
+
+// exportPatchesCommandName names export patches in render errors.
+const exportPatchesCommandName = "talm export patches"
+
+// exportedPatchName matches the file names export patches writes, the
+// ones --force replaces.
+var exportedPatchName = regexp.MustCompile(`^[0-9]{2}-.+\.yaml$`)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var exportPatchesCmdFlags struct {
+	configFile string
+	outputDir  string
+	offline    bool
+	force      bool
+}
+
+// exportCmd groups the exports of a project to formats other tools
+// read.
+//
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export what talm renders in formats plain Talos tools read",
+	Args:  cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var exportPatchesCmd = &cobra.Command{
+	Use:   "patches",
+	Short: "Write the config patches of a node file for talosctl --config-patch",
+	Long: `Render the templates of a node file, as talm apply does, and write the
+patches the config is merged from to the output directory instead of
+merging them: the output of each template, then the node file body,
+numbered in the order they apply.
+
+  01-controlplane.yaml   the output of templates/controlplane.yaml
+  02-node1.yaml          the body of nodes/node1.yaml
+
+The body keeps only what the templates do not already set, as talm
+apply merges it. Each file is a patch plain talosctl takes with
+--config-patch @file, so a node can be configured without talm in an
+emergency, while the chart stays the source of truth:
+
+  talosctl apply-config --nodes 192.0.2.10 --file base.yaml \
+    --config-patch @patches/01-controlplane.yaml \
+    --config-patch @patches/02-node1.yaml
+
+base.yaml is the config talosctl gen config --with-secrets makes from
+the secrets of the project, of the machine type of the node. The
+command prints the talosctl command for the files it wrote.
+
+The render reads the node with lookup, like talm apply, unless --offline
+is set. The files are written owner-only: the patches may hold secrets.
+An output directory that already holds files is refused unless --force
+is set, which replaces the numbered patches of an earlier export.`,
+	Example: `  talm export patches -f nodes/node1.yaml -o patches/node1
+  talm export patches -f nodes/node1.yaml -o patches/node1 --offline --force`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		file := exportPatchesCmdFlags.configFile
+
+		if err := DetectAndSetRootFromFiles([]string{file}); err != nil {
+			return err
+		}
+
+		_, modelineConfig, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return errors.Wrapf(err, "parsing modeline in %s", file)
+		}
+
+		if modelineConfig == nil || len(modelineConfig.Templates) == 0 {
+			//nolint:wrapcheck // sentinel constructed in-place; WithHint attaches operator guidance
+			return errors.WithHint(
+				errors.Newf("the modeline of %s does not name templates", file),
+				"add a `# talm: templates=[...]` modeline at the top of the node file; a node file without templates is a patch already",
+			)
+		}
+
+		if len(GlobalArgs.Nodes) == 0 {
+			GlobalArgs.Nodes = modelineConfig.Nodes
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = modelineConfig.Endpoints
+		}
+
+		if len(GlobalArgs.Endpoints) == 0 {
+			GlobalArgs.Endpoints = []string{defaultLocalEndpoint}
+		}
+
+		if err := prepareExportDir(exportPatchesCmdFlags.outputDir, exportPatchesCmdFlags.force); err != nil {
+			return err
+		}
+
+		opts := diffRenderOptions(modelineConfig.Templates, "")
+		opts.Role = modelineConfig.Role
+		opts.CommandName = exportPatchesCommandName
+		opts.Offline = exportPatchesCmdFlags.offline
+
+		talosVersion, err := nodeTalosVersion(Config.RootDir, GlobalArgs.Nodes, opts.TalosVersion)
+		if err != nil {
+			return err
+		}
+
+		opts.TalosVersion = talosVersion
+
+		var patches []engine.ExportedPatch
+
+		export := func(ctx context.Context, c *client.Client) error {
+			patches, err = engine.ExportPatches(ctx, c, opts, file)
+			if err != nil {
+				return errors.Wrap(err, "template rendering")
+			}
+
+			return nil
+		}
+
+		if opts.Offline {
+			err = export(cmd.Context(), nil)
+		} else {
+			err = WithClient(export)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		written, err := writeExportedPatches(exportPatchesCmdFlags.outputDir, patches)
+		if err != nil {
+			return err
+		}
+
+		printExportedPatchesUsage(cmd.OutOrStdout(), GlobalArgs.Nodes, written)
+
+		return nil
+	},
+}
+
+// prepareExportDir makes dir ready for an export: created when it does
+// not exist, and refused when it holds files, unless force is set, in
+// which case the patches of an earlier export are removed so none of
+// them outlives a template the node no longer uses.
+func prepareExportDir(dir string, force bool) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(os.MkdirAll(dir, 0o755), "creating %s", dir)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", dir)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if !force {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%s is not empty", dir),
+			"pass --force to replace the patches of an earlier export, or choose an empty directory",
+		)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !exportedPatchName.MatchString(entry.Name()) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return errors.Wrapf(err, "removing the earlier export %s", entry.Name())
+		}
+	}
+
+	return nil
+}
+
+// writeExportedPatches writes each patch to dir as NN-<name>.yaml, NN
+// its position and name the base name of its template or node file,
+// and returns the paths written.
+func writeExportedPatches(dir string, patches []engine.ExportedPatch) ([]string, error) {
+	written := make([]string, 0, len(patches))
+
+	for i, patch := range patches {
+		base := path.Base(filepath.ToSlash(patch.Source))
+		name := fmt.Sprintf("%02d-%s.yaml", i+1, strings.TrimSuffix(base, path.Ext(base)))
+		target := filepath.Join(dir, name)
+
+		if err := secureperm.WriteFile(target, patch.Data); err != nil {
+			return nil, errors.Wrapf(err, "writing %s", target)
+		}
+
+		written = append(written, target)
+	}
+
+	return written, nil
+}
+
+// printExportedPatchesUsage prints the files written and the talosctl
+// command that applies them.
+func printExportedPatchesUsage(w io.Writer, nodes, written []string) {
+	for _, file := range written {
+		_, _ = fmt.Fprintf(w, "wrote %s\n", file)
+	}
+
+	nodeList := "<node>"
+	if len(nodes) > 0 {
+		nodeList = strings.Join(nodes, ",")
+	}
+
+	_, _ = fmt.Fprintf(w, "\nApply with plain talosctl, base.yaml being the talosctl gen config output for the node:\n\n  talosctl apply-config --nodes %s --file base.yaml", nodeList)
+
+	for _, file := range written {
+		_, _ = fmt.Fprintf(w, " \\\n    --config-patch @%s", file)
+	}
+
+	_, _ = fmt.Fprintln(w)
+}
+
+func init() {
+	exportPatchesCmd.Flags().StringVarP(&exportPatchesCmdFlags.configFile, "file", "f", "", "node file whose patches to export")
+	exportPatchesCmd.Flags().StringVarP(&exportPatchesCmdFlags.outputDir, "output-dir", "o", "", "directory to write the patches to")
+	exportPatchesCmd.Flags().BoolVar(&exportPatchesCmdFlags.offline, "offline", false, "render without connecting to the node; lookups return nothing")
+	exportPatchesCmd.Flags().BoolVar(&exportPatchesCmdFlags.force, "force", false, "write to a directory that is not empty, replacing the patches of an earlier export")
+
+	_ = exportPatchesCmd.MarkFlagRequired("file")
+	_ = exportPatchesCmd.MarkFlagRequired("output-dir")
+	_ = exportPatchesCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	exportCmd.AddCommand(exportPatchesCmd)
+	addCommand(exportCmd)
+}
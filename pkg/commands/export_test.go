@@ -0,0 +1,119 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+// TestWriteExportedPatches pins the layout: numbered in patch order,
+// named after the template or node file.
+func TestWriteExportedPatches(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	written, err := writeExportedPatches(dir, []engine.ExportedPatch{
+		{Source: "cozystack/templates/controlplane.yaml", Data: []byte("machine:\n  type: controlplane\n")},
+		{Source: filepath.Join("nodes", "node1.yaml"), Data: []byte("machine:\n  install:\n    disk: /dev/sda\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(dir, "01-controlplane.yaml"), filepath.Join(dir, "02-node1.yaml")}
+	if !slices.Equal(written, want) {
+		t.Fatalf("written = %v, want %v", written, want)
+	}
+
+	data, err := os.ReadFile(want[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "machine:\n  install:\n    disk: /dev/sda\n" {
+		t.Errorf("%s = %q", want[1], data)
+	}
+}
+
+// TestPrepareExportDir pins the directory handling: a missing one is
+// created, a used one is refused without --force, and --force removes
+// only the patches of an earlier export.
+func TestPrepareExportDir(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "patches")
+
+	if err := prepareExportDir(dir, false); err != nil {
+		t.Fatalf("missing dir: %v", err)
+	}
+
+	for _, name := range []string{"01-controlplane.yaml", "02-node1.yaml", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := prepareExportDir(dir, false); err == nil {
+		t.Fatal("expected a directory with files to be refused without --force")
+	}
+
+	if err := prepareExportDir(dir, true); err != nil {
+		t.Fatalf("--force: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "README.md" {
+		t.Errorf("entries after --force = %v, want README.md only", entries)
+	}
+}
+
+// TestPrintExportedPatchesUsage pins that the printed talosctl command
+// names the nodes and every patch in order.
+func TestPrintExportedPatchesUsage(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	printExportedPatchesUsage(&buf, []string{"192.0.2.10"}, []string{"patches/01-controlplane.yaml", "patches/02-node1.yaml"})
+
+	out := buf.String()
+	for _, want := range []string{
+		"wrote patches/01-controlplane.yaml\n",
+		"talosctl apply-config --nodes 192.0.2.10 --file base.yaml \\\n    --config-patch @patches/01-controlplane.yaml \\\n    --config-patch @patches/02-node1.yaml\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	printExportedPatchesUsage(&buf, nil, nil)
+
+	if !strings.Contains(buf.String(), "--nodes <node> --file base.yaml\n") {
+		t.Errorf("without nodes:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+// externalTalosconfigKey names the Chart.yaml setting in messages.
+const externalTalosconfigKey = "globalOptions.externalTalosconfig"
+
+// ExternalTalosconfigPath returns the absolute path of the talosconfig
+// Chart.yaml shares from outside the project, or "" when the project
+// keeps its own. A leading `~/` is the home directory, `$VAR` and
+// `${VAR}` are expanded, and a relative path is read from the project
+// root, so a sibling checkout works as `../ops/talosconfig`.
+func ExternalTalosconfigPath() (string, error) {
+	external := Config.GlobalOptions.ExternalTalosconfig
+	if external.Path == "" {
+		if external.Context != "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return "", errors.WithHintf(
+				errors.Newf("%s.context is set without a path", externalTalosconfigKey),
+				"set %s.path to the shared talosconfig, or use --context to pick a context of the project talosconfig", externalTalosconfigKey,
+			)
+		}
+
+		return "", nil
+	}
+
+	if Config.GlobalOptions.Talosconfig != "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHintf(
+			errors.Newf("Chart.yaml sets both globalOptions.talosconfig and %s", externalTalosconfigKey),
+			"keep globalOptions.talosconfig for a talosconfig the project owns and %s for a shared one; drop one of them", externalTalosconfigKey,
+		)
+	}
+
+	path := os.ExpandEnv(external.Path)
+
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "expanding %s.path %q", externalTalosconfigKey, external.Path)
+		}
+
+		path = filepath.Join(home, rest)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(Config.RootDir, path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving %s.path %q", externalTalosconfigKey, external.Path)
+	}
+
+	return abs, nil
+}
+
+// CheckExternalTalosconfigContext verifies that the shared talosconfig
+// at path has the context Chart.yaml names, so a renamed context fails
+// with the list of the ones that exist instead of a client error. A
+// missing file is left to the command that opens it.
+func CheckExternalTalosconfigContext(path string) error {
+	name := Config.GlobalOptions.ExternalTalosconfig.Context
+	if name == "" || !fileExists(path) {
+		return nil
+	}
+
+	cfg, err := clientconfig.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading the shared talosconfig %s", path)
+	}
+
+	if _, ok := cfg.Contexts[name]; ok {
+		return nil
+	}
+
+	contexts := make([]string, 0, len(cfg.Contexts))
+	for contextName := range cfg.Contexts {
+		contexts = append(contexts, contextName)
+	}
+
+	sort.Strings(contexts)
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("the shared talosconfig %s has no context %q", path, name),
+		"set %s.context to one of: %s", externalTalosconfigKey, strings.Join(contexts, ", "),
+	)
+}
+
+// refuseExternalTalosconfigWrite fails a command that would write the
+// talosconfig at path when that is the shared one: talm only reads a
+// talosconfig it does not own, so one project cannot rewrite the
+// credentials of every other project that shares them.
+func refuseExternalTalosconfigWrite(path, action string) error {
+	external, err := ExternalTalosconfigPath()
+	if err != nil || external == "" {
+		return err
+	}
+
+	if abs, err := filepath.Abs(path); err != nil || abs != external {
+		return nil //nolint:nilerr // a path that cannot be made absolute is not the shared one.
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("%s would write the shared talosconfig %s, which talm only reads", action, external),
+		"update it in the repository that maintains it, or drop %s from Chart.yaml to give the project its own talosconfig", externalTalosconfigKey,
+	)
+}
+
+// ApplyExternalTalosconfig points the talosctl client at the shared
+// talosconfig Chart.yaml names, and selects its context unless
+// --context picks another. It does nothing for a project that keeps
+// its own talosconfig.
+func ApplyExternalTalosconfig() error {
+	path, err := ExternalTalosconfigPath()
+	if err != nil || path == "" {
+		return err
+	}
+
+	if err := CheckExternalTalosconfigContext(path); err != nil {
+		return err
+	}
+
+	GlobalArgs.Talosconfig = path
+
+	if GlobalArgs.CmdContext == "" {
+		GlobalArgs.CmdContext = Config.GlobalOptions.ExternalTalosconfig.Context
+	}
+
+	return nil
+}
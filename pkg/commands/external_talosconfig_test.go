@@ -0,0 +1,220 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+// withExternalTalosconfig points Config at a fresh project root with
+// the given Chart.yaml settings and restores the globals afterwards.
+// Tests that call it must not run in parallel.
+func withExternalTalosconfig(t *testing.T, path, context string) string {
+	t.Helper()
+
+	originalRoot := Config.RootDir
+	originalOptions := Config.GlobalOptions
+	originalTalosconfig := GlobalArgs.Talosconfig
+	originalContext := GlobalArgs.CmdContext
+
+	t.Cleanup(func() {
+		Config.RootDir = originalRoot
+		Config.GlobalOptions = originalOptions
+		GlobalArgs.Talosconfig = originalTalosconfig
+		GlobalArgs.CmdContext = originalContext
+	})
+
+	root := t.TempDir()
+	Config.RootDir = root
+	Config.GlobalOptions.Talosconfig = ""
+	Config.GlobalOptions.ExternalTalosconfig.Path = path
+	Config.GlobalOptions.ExternalTalosconfig.Context = context
+	GlobalArgs.Talosconfig = ""
+	GlobalArgs.CmdContext = ""
+
+	return root
+}
+
+// writeSharedTalosconfig writes a talosconfig with the named contexts.
+func writeSharedTalosconfig(t *testing.T, path string, contexts ...string) {
+	t.Helper()
+
+	cfg := &clientconfig.Config{Context: contexts[0], Contexts: map[string]*clientconfig.Context{}}
+	for _, name := range contexts {
+		cfg.Contexts[name] = &clientconfig.Context{Endpoints: []string{"10.0.0.1"}}
+	}
+
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalTalosconfigPath_NotConfigured(t *testing.T) {
+	withExternalTalosconfig(t, "", "")
+
+	path, err := ExternalTalosconfigPath()
+	if err != nil || path != "" {
+		t.Errorf("path = %q, err = %v, want neither", path, err)
+	}
+}
+
+// TestExternalTalosconfigPath_Resolution pins how the Chart.yaml path
+// is read: relative to the project root, `~/` from the home directory
+// and environment variables expanded.
+func TestExternalTalosconfigPath_Resolution(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TALM_SHARED", "/srv/ops")
+
+	for _, tc := range []struct {
+		path string
+		want func(root string) string
+	}{
+		{"../ops/talosconfig", func(root string) string { return filepath.Join(filepath.Dir(root), "ops", "talosconfig") }},
+		{"~/.talos/mgmt", func(string) string { return filepath.Join(home, ".talos", "mgmt") }},
+		{"$TALM_SHARED/talosconfig", func(string) string { return "/srv/ops/talosconfig" }},
+		{"/etc/talos/config", func(string) string { return "/etc/talos/config" }},
+	} {
+		root := withExternalTalosconfig(t, tc.path, "mgmt")
+
+		got, err := ExternalTalosconfigPath()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+
+		if want := tc.want(root); got != want {
+			t.Errorf("%s: path = %q, want %q", tc.path, got, want)
+		}
+	}
+}
+
+// TestExternalTalosconfigPath_Conflicts pins the Chart.yaml settings
+// that cannot work together.
+func TestExternalTalosconfigPath_Conflicts(t *testing.T) {
+	withExternalTalosconfig(t, "/srv/ops/talosconfig", "")
+	Config.GlobalOptions.Talosconfig = "talosconfig"
+
+	if _, err := ExternalTalosconfigPath(); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("a project talosconfig next to a shared one must fail, got %v", err)
+	}
+
+	withExternalTalosconfig(t, "", "mgmt")
+
+	if _, err := ExternalTalosconfigPath(); err == nil || len(errors.GetAllHints(err)) == 0 {
+		t.Errorf("a context without a path must fail with a hint, got %v", err)
+	}
+}
+
+// TestApplyExternalTalosconfig pins that the shared talosconfig and its
+// context become the client defaults, and that --context still wins.
+func TestApplyExternalTalosconfig(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "talosconfig")
+	writeSharedTalosconfig(t, shared, "admin", "mgmt")
+
+	withExternalTalosconfig(t, shared, "mgmt")
+
+	if err := ApplyExternalTalosconfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if GlobalArgs.Talosconfig != shared || GlobalArgs.CmdContext != "mgmt" {
+		t.Errorf("talosconfig = %q, context = %q, want %q and mgmt", GlobalArgs.Talosconfig, GlobalArgs.CmdContext, shared)
+	}
+
+	withExternalTalosconfig(t, shared, "mgmt")
+	GlobalArgs.CmdContext = "admin"
+
+	if err := ApplyExternalTalosconfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if GlobalArgs.CmdContext != "admin" {
+		t.Errorf("context = %q, --context must win", GlobalArgs.CmdContext)
+	}
+}
+
+func TestApplyExternalTalosconfig_UnknownContext(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "talosconfig")
+	writeSharedTalosconfig(t, shared, "admin", "mgmt")
+
+	withExternalTalosconfig(t, shared, "prod")
+
+	err := ApplyExternalTalosconfig()
+	if err == nil || !strings.Contains(err.Error(), `no context "prod"`) {
+		t.Fatalf("error = %v, want the missing context named", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "admin, mgmt") {
+		t.Errorf("hints = %q, want the contexts that exist", hints)
+	}
+}
+
+// TestRefuseExternalTalosconfigWrite pins the read-only contract: a
+// write aimed at the shared file fails, any other path is allowed.
+func TestRefuseExternalTalosconfigWrite(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "talosconfig")
+	root := withExternalTalosconfig(t, shared, "")
+
+	if err := refuseExternalTalosconfigWrite(shared, "rotate-ca"); err == nil || !strings.Contains(err.Error(), "only reads") {
+		t.Errorf("writing the shared talosconfig must fail, got %v", err)
+	}
+
+	if err := refuseExternalTalosconfigWrite(filepath.Join(root, "talosconfig"), "rotate-ca"); err != nil {
+		t.Errorf("writing another talosconfig must be allowed, got %v", err)
+	}
+
+	withExternalTalosconfig(t, "", "")
+
+	if err := refuseExternalTalosconfigWrite(shared, "rotate-ca"); err != nil {
+		t.Errorf("without a shared talosconfig every path is allowed, got %v", err)
+	}
+}
+
+// TestTalosconfigCmd_RefusesExternal pins that `talm talosconfig` does
+// not regenerate credentials for a project that reads shared ones.
+func TestTalosconfigCmd_RefusesExternal(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "talosconfig")
+	writeSharedTalosconfig(t, shared, "mgmt")
+
+	before, err := os.ReadFile(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := withExternalTalosconfig(t, shared, "mgmt")
+
+	if err := talosconfigCmd.RunE(talosconfigCmd, nil); err == nil || !strings.Contains(err.Error(), "shared talosconfig") {
+		t.Errorf("error = %v, want a refusal", err)
+	}
+
+	after, err := os.ReadFile(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(after) != string(before) {
+		t.Error("the shared talosconfig must be left untouched")
+	}
+
+	if fileExists(filepath.Join(root, talosconfigName)) {
+		t.Error("no project talosconfig may be written")
+	}
+}
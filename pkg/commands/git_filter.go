@@ -0,0 +1,528 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// gitFilterDriver names the filter and diff driver in git config
+	// and .gitattributes.
+	gitFilterDriver = "talm"
+	// gitattributesName is the attributes file the filter is declared
+	// in, at the project root.
+	gitattributesName = ".gitattributes"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterCmd = &cobra.Command{
+	Use:   "git-filter",
+	Short: "Encrypt secrets transparently on git commit",
+	Long: `Set up git clean/smudge filters that encrypt secrets.yaml, talosconfig and
+values-secret.yaml when they are staged and decrypt them on checkout, using the
+project key. The working tree keeps the plaintext files, the repository only
+ever holds ciphertext, and there is no separate encrypt step to forget.
+
+This is an alternative to talm init --encrypt/--decrypt. Every clone must run
+talm git-filter install, with the key in place, before the files decrypt; talm
+must be on PATH for git to run the filter.`,
+	Args: cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Configure the filter in this clone and mark the secret files",
+	Long: `Configure the talm filter and diff driver in the repository's git config, mark
+the secret files with filter=talm in the project's .gitattributes, drop them
+from .gitignore so they can be committed, and decrypt tracked files that are
+still encrypted in the working tree.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
+		return runGitFilterInstall(ui.Progress(os.Stderr), Config.RootDir)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the filter from this clone and unmark the secret files",
+	Long: `Remove the talm filter and diff driver from the repository's git config and
+the filter=talm lines from .gitattributes, and ignore the plaintext files in
+.gitignore again. Files already committed stay in the repository, encrypted.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
+		return runGitFilterUninstall(ui.Progress(os.Stderr), Config.RootDir)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterCleanCmd = &cobra.Command{
+	Use:    "clean <path>",
+	Short:  "Encrypt a file git stages (run by git)",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
+		return gitFilterClean(cmd.OutOrStdout(), cmd.InOrStdin(), Config.RootDir, args[0], gitIndexBlob)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterSmudgeCmd = &cobra.Command{
+	Use:    "smudge <path>",
+	Short:  "Decrypt a file git checks out (run by git)",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
+		return gitFilterSmudge(cmd.OutOrStdout(), os.Stderr, cmd.InOrStdin(), Config.RootDir, args[0])
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var gitFilterTextconvCmd = &cobra.Command{
+	Use:    "textconv <file>",
+	Short:  "Print a file decrypted for git diff (run by git)",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "opening %s", args[0])
+		}
+		defer f.Close() //nolint:errcheck // read-only
+
+		return gitFilterSmudge(cmd.OutOrStdout(), io.Discard, f, Config.RootDir, args[0])
+	},
+}
+
+// gitFilteredFiles lists the project-relative files the filter
+// encrypts: the secret files the registry marks gitFiltered, as the
+// project's Chart.yaml names them.
+func gitFilteredFiles(rootDir string) []string {
+	var files []string
+
+	for _, file := range gitFilteredSecretFiles(rootDir) {
+		files = append(files, file.plain)
+	}
+
+	return files
+}
+
+// gitFilteredSecretFiles are the entries of projectSecretFiles for the
+// project at rootDir that the filter encrypts.
+func gitFilteredSecretFiles(rootDir string) []secretFile {
+	options := readProjectGlobalOptions(rootDir)
+
+	return slices.DeleteFunc(projectSecretFiles(secretsLayout(), options.Talosconfig, options.Kubeconfig), func(file secretFile) bool {
+		return !file.gitFiltered
+	})
+}
+
+// gitFilterConfig is the git config the filter needs. Git runs the
+// filter from the top of the work tree, so the project root is passed
+// relative to it; %f is the path of the file being filtered.
+func gitFilterConfig(rootFromTop string) [][2]string {
+	talm := "talm --root " + shellQuote(filepath.ToSlash(rootFromTop)) + " git-filter "
+
+	return [][2]string{
+		{"filter." + gitFilterDriver + ".clean", talm + "clean %f"},
+		{"filter." + gitFilterDriver + ".smudge", talm + "smudge %f"},
+		// A failing filter aborts the git command instead of letting
+		// git store the file as is, so plaintext is never committed.
+		{"filter." + gitFilterDriver + ".required", "true"},
+		{"diff." + gitFilterDriver + ".textconv", talm + "textconv"},
+	}
+}
+
+// shellQuote quotes s for the POSIX shell git runs filters with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runGitFilterInstall is `talm git-filter install` for the project at
+// rootDir.
+func runGitFilterInstall(w io.Writer, rootDir string) error {
+	top, err := gitOutput(rootDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Wrap(err, "locating the git work tree"),
+			"run talm git-filter install in a project that is inside a git repository",
+		)
+	}
+
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", rootDir)
+	}
+
+	// Compare through EvalSymlinks: git reports the resolved top
+	// (/private/var on macOS for /var).
+	resolvedRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", absRoot)
+	}
+
+	rootFromTop, err := filepath.Rel(strings.TrimSpace(string(top)), resolvedRoot)
+	if err != nil {
+		return errors.Wrap(err, "locating the project in the git work tree")
+	}
+
+	for _, kv := range gitFilterConfig(rootFromTop) {
+		if _, err := gitOutput(rootDir, "config", "--local", kv[0], kv[1]); err != nil {
+			return errors.Wrapf(err, "setting %s", kv[0])
+		}
+	}
+
+	files := gitFilteredFiles(rootDir)
+
+	if err := updateGitattributes(rootDir, files, true); err != nil {
+		return err
+	}
+
+	var ignored []string
+	for _, file := range gitFilteredSecretFiles(rootDir) {
+		ignored = append(ignored, file.plain, file.ignore)
+	}
+
+	removed, err := unignoreGitFilteredFiles(rootDir, ignored)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Configured the %s git filter for %s\n", gitFilterDriver, strings.Join(files, ", "))
+
+	if len(removed) > 0 {
+		fmt.Fprintf(w, "Removed %s from .gitignore: the filter encrypts them on commit\n", strings.Join(removed, ", "))
+	}
+
+	return decryptCheckedOutFiles(w, rootDir, files)
+}
+
+// runGitFilterUninstall is `talm git-filter uninstall` for the project
+// at rootDir.
+func runGitFilterUninstall(w io.Writer, rootDir string) error {
+	for _, section := range []string{"filter." + gitFilterDriver, "diff." + gitFilterDriver} {
+		// Exit status 128 means the section was never there.
+		if _, err := gitOutput(rootDir, "config", "--local", "--remove-section", section); err != nil && !isGitExitCode(err, 128) { //nolint:mnd // git's fatal-error status.
+			return errors.Wrapf(err, "removing %s", section)
+		}
+	}
+
+	if err := updateGitattributes(rootDir, gitFilteredFiles(rootDir), false); err != nil {
+		return err
+	}
+
+	if err := writeGitignoreFile(); err != nil {
+		return errors.Wrap(err, "failed to update .gitignore")
+	}
+
+	fmt.Fprintf(w, "Removed the %s git filter; use talm init --encrypt to encrypt the secret files again\n", gitFilterDriver)
+
+	return nil
+}
+
+// gitFilterClean encrypts the plaintext read from in onto out, for
+// git's clean filter. Values unchanged since the staged version keep
+// their ciphertext, so an untouched file does not show as modified.
+// Input that is already fully encrypted (a checkout made without the
+// key) passes through; plaintext without the key is refused, which
+// aborts the git command rather than commit secrets.
+func gitFilterClean(out io.Writer, in io.Reader, rootDir, path string, staged func(path string) []byte) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", path)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		_, err := out.Write(data)
+
+		return errors.Wrap(err, "writing the filtered file")
+	}
+
+	keyExists := fileExists(filepath.Join(rootDir, filepath.FromSlash(secretsLayout().KeyFile())))
+
+	if age.ContainsEncryptedValues(data) {
+		if !keyExists {
+			_, err := out.Write(data)
+
+			return errors.Wrap(err, "writing the filtered file")
+		}
+
+		// Decrypt first so a file mixing envelopes and edited
+		// plaintext values is encrypted once, value by value.
+		if data, err = age.DecryptYAML(rootDir, data); err != nil {
+			return errors.Wrapf(err, "decrypting %s", path)
+		}
+	}
+
+	encrypted, err := age.EncryptYAML(rootDir, data, staged(path))
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Wrapf(err, "encrypting %s for git", path),
+			"git refuses to stage the file unencrypted; restore %s, or run talm git-filter uninstall", secretsLayout().KeyFile(),
+		)
+	}
+
+	_, err = out.Write(encrypted)
+
+	return errors.Wrap(err, "writing the filtered file")
+}
+
+// gitFilterSmudge decrypts the file read from in onto out, for git's
+// smudge filter and diff textconv. It never fails a checkout: a file
+// without envelopes passes through, and one that cannot be decrypted,
+// for want of the key, is checked out encrypted with a warning on
+// warn.
+func gitFilterSmudge(out, warn io.Writer, in io.Reader, rootDir, path string) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", path)
+	}
+
+	if age.ContainsEncryptedValues(data) {
+		decrypted, err := age.DecryptYAML(rootDir, data)
+		if err == nil {
+			data = decrypted
+		} else {
+			ui.Warnf(warn, "%s is checked out encrypted: %v", path, err)
+		}
+	}
+
+	_, err = out.Write(data)
+
+	return errors.Wrap(err, "writing the filtered file")
+}
+
+// gitIndexBlob returns the staged content of path, relative to the top
+// of the work tree git runs the filter from, or nil when it is not
+// staged.
+func gitIndexBlob(path string) []byte {
+	data, err := gitOutput(".", "cat-file", "blob", ":"+path)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// decryptCheckedOutFiles decrypts, in place, the filtered files that
+// are tracked but still encrypted in the working tree: checked out
+// before the filter was installed. Their content then matches what the
+// smudge filter would have written.
+func decryptCheckedOutFiles(w io.Writer, rootDir string, files []string) error {
+	if !fileExists(filepath.Join(rootDir, filepath.FromSlash(secretsLayout().KeyFile()))) {
+		fmt.Fprintf(w, "No key at %s yet: tracked files stay encrypted until it is in place and install is re-run\n", secretsLayout().KeyFile())
+
+		return nil
+	}
+
+	for _, file := range files {
+		path := filepath.Join(rootDir, filepath.FromSlash(file))
+
+		data, err := os.ReadFile(path)
+		if err != nil || !age.ContainsEncryptedValues(data) {
+			continue
+		}
+
+		decrypted, err := age.DecryptYAML(rootDir, data)
+		if err != nil {
+			return errors.Wrapf(err, "decrypting %s", file)
+		}
+
+		if err := secureperm.WriteFile(path, decrypted); err != nil {
+			return errors.Wrapf(err, "writing %s", file)
+		}
+
+		fmt.Fprintf(w, "Decrypted %s\n", file)
+	}
+
+	return nil
+}
+
+// updateGitattributes adds (or, with add false, removes) the
+// `/<file> filter=talm diff=talm` line of every file in the project's
+// .gitattributes, keeping the other lines.
+func updateGitattributes(rootDir string, files []string, add bool) error {
+	path := filepath.Join(rootDir, gitattributesName)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "reading %s", gitattributesName)
+	}
+
+	text := strings.ReplaceAll(string(data), lineEndingCRLF, lineEndingLF)
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	wanted := make([]string, 0, len(files))
+	for _, file := range files {
+		wanted = append(wanted, "/"+file+" filter="+gitFilterDriver+" diff="+gitFilterDriver)
+	}
+
+	kept := slices.DeleteFunc(lines, func(line string) bool { return slices.Contains(wanted, strings.TrimSpace(line)) })
+	if add {
+		kept = append(kept, wanted...)
+	}
+
+	out := strings.Join(kept, "\n")
+	if out != "" {
+		out += "\n"
+	}
+
+	out = withLineEnding(out, lineEnding(string(data)))
+
+	if out == string(data) {
+		return nil
+	}
+
+	// .gitattributes is committed and read by every clone, like .gitignore.
+	if err := os.WriteFile(path, []byte(out), presetFileMode); err != nil { //nolint:gosec // .gitattributes is world-readable by design
+		return errors.Wrapf(err, "writing %s", gitattributesName)
+	}
+
+	return nil
+}
+
+// gitFilterCovered reports the project-relative files the project's
+// .gitattributes hands to the talm filter, so .gitignore can leave
+// them trackable.
+func gitFilterCovered(rootDir string) []string {
+	data, err := os.ReadFile(filepath.Join(rootDir, gitattributesName))
+	if err != nil {
+		return nil
+	}
+
+	var covered []string
+
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && slices.Contains(fields[1:], "filter="+gitFilterDriver) {
+			covered = append(covered, strings.TrimPrefix(fields[0], "/"))
+		}
+	}
+
+	return covered
+}
+
+// unignoreGitFilteredFiles drops the .gitignore lines that are one of
+// entries, the path of a filtered file or the entry writeGitignoreFile
+// writes for it, and returns the entries it dropped. Other patterns
+// are the operator's and stay.
+func unignoreGitFilteredFiles(rootDir string, entries []string) ([]string, error) {
+	path := filepath.Join(rootDir, ".gitignore")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "reading .gitignore")
+	}
+
+	var removed []string
+
+	lines := slices.DeleteFunc(strings.Split(string(data), "\n"), func(line string) bool {
+		entry := strings.TrimSpace(line)
+		if slices.Contains(entries, entry) {
+			removed = append(removed, entry)
+
+			return true
+		}
+
+		return false
+	})
+
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), presetFileMode); err != nil { //nolint:gosec // .gitignore is world-readable by design
+		return nil, errors.Wrap(err, "writing .gitignore")
+	}
+
+	return removed, nil
+}
+
+// gitOutput runs git in dir and returns its standard output as is.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...) //nolint:gosec // fixed git subcommands with talm-built arguments.
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "git %s: %s", args[0], msg)
+		}
+
+		return nil, errors.Wrapf(err, "git %s", args[0])
+	}
+
+	return out, nil
+}
+
+// isGitExitCode reports whether err is git exiting with code.
+func isGitExitCode(err error, code int) bool {
+	var exitErr *exec.ExitError
+
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == code
+}
+
+func init() {
+	gitFilterCmd.AddCommand(gitFilterInstallCmd, gitFilterUninstallCmd, gitFilterCleanCmd, gitFilterSmudgeCmd, gitFilterTextconvCmd)
+	addCommand(gitFilterCmd)
+}
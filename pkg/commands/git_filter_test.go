@@ -0,0 +1,255 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// gitFilterSecrets is laid out as the filter writes YAML back.
+const gitFilterSecrets = "cluster:\n    id: abc\n    secret: s3cr3t\n"
+
+// withGitFilterProject roots a project with a key at a temp dir.
+func withGitFilterProject(t *testing.T) string {
+	t.Helper()
+
+	dir := withSecretsLayout(t, age.Layout{})
+	age.SetLayout(age.Layout{})
+
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestGitFilter_CleanSmudgeRoundTrip(t *testing.T) {
+	dir := withGitFilterProject(t)
+	noStaged := func(string) []byte { return nil }
+
+	var first bytes.Buffer
+	if err := gitFilterClean(&first, strings.NewReader(gitFilterSecrets), dir, "secrets.yaml", noStaged); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(first.String(), "s3cr3t") || !age.ContainsEncryptedValues(first.Bytes()) {
+		t.Fatalf("the staged file must be encrypted:\n%s", first.String())
+	}
+
+	// Unchanged plaintext cleans to the same bytes, so git does not
+	// see the file as modified.
+	var second bytes.Buffer
+	if err := gitFilterClean(&second, strings.NewReader(gitFilterSecrets), dir, "secrets.yaml", func(string) []byte { return first.Bytes() }); err != nil {
+		t.Fatal(err)
+	}
+
+	if second.String() != first.String() {
+		t.Errorf("re-cleaning unchanged content changed it:\n%s\n---\n%s", first.String(), second.String())
+	}
+
+	// A working file that is still encrypted is not encrypted twice.
+	var again bytes.Buffer
+	if err := gitFilterClean(&again, bytes.NewReader(first.Bytes()), dir, "secrets.yaml", func(string) []byte { return first.Bytes() }); err != nil {
+		t.Fatal(err)
+	}
+
+	if again.String() != first.String() {
+		t.Errorf("cleaning ciphertext must keep it:\n%s", again.String())
+	}
+
+	var smudged bytes.Buffer
+	if err := gitFilterSmudge(&smudged, &bytes.Buffer{}, bytes.NewReader(first.Bytes()), dir, "secrets.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if smudged.String() != gitFilterSecrets {
+		t.Errorf("smudge = %q, want %q", smudged.String(), gitFilterSecrets)
+	}
+}
+
+func TestGitFilter_WithoutKey(t *testing.T) {
+	dir := withGitFilterProject(t)
+
+	var encrypted bytes.Buffer
+	if err := gitFilterClean(&encrypted, strings.NewReader(gitFilterSecrets), dir, "secrets.yaml", func(string) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, talmKeyName)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	err := gitFilterClean(&out, strings.NewReader(gitFilterSecrets), dir, "secrets.yaml", func(string) []byte { return nil })
+	if err == nil || out.Len() != 0 {
+		t.Fatalf("plaintext must never be staged without the key: err = %v, out = %q", err, out.String())
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "git-filter uninstall") {
+		t.Errorf("hint = %q", hints)
+	}
+
+	out.Reset()
+
+	if err := gitFilterClean(&out, bytes.NewReader(encrypted.Bytes()), dir, "secrets.yaml", func(string) []byte { return nil }); err != nil || out.String() != encrypted.String() {
+		t.Errorf("ciphertext checked out without the key must stage as is: err = %v", err)
+	}
+
+	out.Reset()
+
+	var warn bytes.Buffer
+	if err := gitFilterSmudge(&out, &warn, bytes.NewReader(encrypted.Bytes()), dir, "secrets.yaml"); err != nil {
+		t.Fatalf("a checkout must not fail for want of the key: %v", err)
+	}
+
+	if out.String() != encrypted.String() || !strings.Contains(warn.String(), "checked out encrypted") {
+		t.Errorf("smudge without the key: out = %q, warn = %q", out.String(), warn.String())
+	}
+}
+
+func TestUpdateGitattributes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, dir, gitattributesName, "*.tgz binary\n")
+
+	files := []string{"secrets.yaml", "talosconfig"}
+
+	for range 2 {
+		if err := updateGitattributes(dir, files, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, gitattributesName))
+	if want := "*.tgz binary\n/secrets.yaml filter=talm diff=talm\n/talosconfig filter=talm diff=talm\n"; string(data) != want {
+		t.Errorf(".gitattributes = %q, want %q", data, want)
+	}
+
+	if covered := gitFilterCovered(dir); strings.Join(covered, ",") != "secrets.yaml,talosconfig" {
+		t.Errorf("covered = %v", covered)
+	}
+
+	if err := updateGitattributes(dir, files, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, gitattributesName)); string(data) != "*.tgz binary\n" {
+		t.Errorf("after removal .gitattributes = %q", data)
+	}
+}
+
+// TestGitignore_LeavesFilteredFilesTrackable pins that install drops
+// the generated .gitignore entries of filtered files and that a later
+// init does not add them back.
+func TestGitignore_LeavesFilteredFilesTrackable(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+
+	if err := writeGitignoreFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{secretsYamlName, talosconfigName, valuesSecretYamlName}
+	if err := updateGitattributes(dir, files, true); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := unignoreGitFilteredFiles(dir, files)
+	if err != nil || len(removed) != 3 {
+		t.Fatalf("removed = %v, err = %v", removed, err)
+	}
+
+	if err := writeGitignoreFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, file := range files {
+			if line == file {
+				t.Errorf(".gitignore ignores the filtered %s again:\n%s", file, data)
+			}
+		}
+	}
+
+	if !strings.Contains(string(data), "\n"+talmKeyName+"\n") {
+		t.Errorf("the key must stay ignored:\n%s", data)
+	}
+}
+
+func TestRunGitFilterInstall(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := withGitFilterProject(t)
+	project := filepath.Join(dir, "clusters", "o'prod")
+
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	runGit(t, dir, "init", "-q")
+
+	Config.RootDir = project
+
+	if _, _, err := age.GenerateKey(project); err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	if err := gitFilterClean(&encrypted, strings.NewReader(gitFilterSecrets), project, "secrets.yaml", func(string) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Checked out before the filter existed: still encrypted.
+	writeFile(t, project, secretsYamlName, encrypted.String())
+
+	var out bytes.Buffer
+	if err := runGitFilterInstall(&out, project); err != nil {
+		t.Fatal(err)
+	}
+
+	clean, err := gitOutput(dir, "config", "--get", "filter.talm.clean")
+	if err != nil || strings.TrimSpace(string(clean)) != `talm --root 'clusters/o'\''prod' git-filter clean %f` {
+		t.Errorf("filter.talm.clean = %q, err = %v", clean, err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(project, secretsYamlName)); string(data) != gitFilterSecrets {
+		t.Errorf("install must decrypt the checked-out file, got %q\n%s", data, out.String())
+	}
+
+	if err := runGitFilterUninstall(&out, project); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gitOutput(dir, "config", "--get", "filter.talm.clean"); err == nil {
+		t.Error("uninstall must remove the filter")
+	}
+
+	if err := runGitFilterUninstall(&out, project); err != nil {
+		t.Errorf("a second uninstall must succeed: %v", err)
+	}
+}
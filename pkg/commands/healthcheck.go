@@ -0,0 +1,763 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/secrets"
+	talostime "github.com/siderolabs/talos/pkg/machinery/resources/time"
+)
+
+// Health check names, in the order they run on each node. The Talos
+// API check runs first: when it fails, the checks that need the API
+// are reported as skipped instead of repeating the same error.
+const (
+	healthCheckAPI     = "api"
+	healthCheckDisk    = "disk"
+	healthCheckTime    = "time"
+	healthCheckCerts   = "certs"
+	healthCheckKubelet = "kubelet"
+)
+
+// Health check outcomes.
+const (
+	healthStatusPass = "pass"
+	healthStatusFail = "fail"
+	healthStatusSkip = "skip"
+)
+
+// Report formats accepted by --output.
+const (
+	healthOutputText  = "text"
+	healthOutputJSON  = "json"
+	healthOutputJUnit = "junit"
+)
+
+// healthDiskMount is the mount the disk check measures: the EPHEMERAL
+// partition, where containerd images, pod logs and etcd data grow.
+const healthDiskMount = "/var"
+
+//nolint:gochecknoglobals // immutable lookup table.
+var healthCheckNames = []string{healthCheckAPI, healthCheckDisk, healthCheckTime, healthCheckCerts, healthCheckKubelet}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var healthcheckCmdFlags struct {
+	checks         []string
+	output         string
+	outputFile     string
+	minFreePercent float64
+	certWarnDays   int
+	timeout        time.Duration
+	parallel       int
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Run health checks across all project nodes and report in text, JSON or JUnit XML",
+	Long: `Run a suite of health checks against every node of the project in parallel:
+
+  api      the Talos API answers a version request
+  disk     the /var filesystem has at least --min-free-percent free
+  time     the node clock is synchronized
+  certs    the Talos API server certificate is valid for more than
+           --cert-warn-days days
+  kubelet  the Kubernetes Node is Ready (read through the project kubeconfig)
+
+Nodes are taken from the modelines of the node files under nodes/, or from
+--nodes. The report is written as a table, as JSON or as JUnit XML so CI
+pipelines can gate on fleet health and keep it as a test artifact. The
+command exits non-zero when any check fails; skipped checks do not fail it.`,
+	Example: `  # Check the whole fleet
+  talm healthcheck
+
+  # Gate a CI job and keep the report as a JUnit artifact
+  talm healthcheck --output junit --output-file health.xml
+
+  # Only the checks that do not need Kubernetes
+  talm healthcheck --checks api,disk,time,certs`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runHealthcheck(cmd.OutOrStdout(), os.Stderr)
+	},
+}
+
+// healthResult is the outcome of one check on one node.
+type healthResult struct {
+	Node     string  `json:"node"`
+	Check    string  `json:"check"`
+	Status   string  `json:"status"`
+	Message  string  `json:"message"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// healthSummary counts results by status.
+type healthSummary struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// healthReport is the JSON form of a healthcheck run.
+type healthReport struct {
+	Time    time.Time      `json:"time"`
+	Nodes   []string       `json:"nodes"`
+	Summary healthSummary  `json:"summary"`
+	Results []healthResult `json:"results"`
+}
+
+// healthProbe reads what the checks need from one node. The Talos
+// client implementation is talosHealthProbe; tests substitute a fake.
+type healthProbe interface {
+	Version(ctx context.Context, node string) (string, error)
+	Mounts(ctx context.Context, node string) ([]*machineapi.MountStat, error)
+	TimeStatus(ctx context.Context, node string) (*talostime.StatusSpec, error)
+	APICertNotAfter(ctx context.Context, node string) (time.Time, error)
+}
+
+// kubeNodeHealth is a Kubernetes Node with its Ready condition.
+type kubeNodeHealth struct {
+	node   liveNode
+	ready  bool
+	reason string
+}
+
+// healthSuite is one configured run of the checks. kubeNodes and
+// kubeErr hold the Kubernetes view, read once for all nodes;
+// kubeSkip is set when there is no kubeconfig to read it through.
+type healthSuite struct {
+	checks         []string
+	minFreePercent float64
+	certWarn       time.Duration
+	timeout        time.Duration
+	parallel       int
+	now            time.Time
+	probe          healthProbe
+	kubeNodes      []kubeNodeHealth
+	kubeErr        error
+	kubeSkip       string
+}
+
+func runHealthcheck(out, progress io.Writer) error {
+	checks, err := parseHealthChecks(healthcheckCmdFlags.checks)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains([]string{healthOutputText, healthOutputJSON, healthOutputJUnit}, healthcheckCmdFlags.output) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown --output %q", healthcheckCmdFlags.output),
+			"use one of: text, json, junit",
+		)
+	}
+
+	nodes, err := resolveHealthNodes(progress)
+	if err != nil {
+		return err
+	}
+
+	suite := healthSuite{
+		checks:         checks,
+		minFreePercent: healthcheckCmdFlags.minFreePercent,
+		certWarn:       time.Duration(healthcheckCmdFlags.certWarnDays) * 24 * time.Hour,
+		timeout:        healthcheckCmdFlags.timeout,
+		parallel:       healthcheckCmdFlags.parallel,
+		now:            time.Now(),
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if slices.Contains(checks, healthCheckKubelet) {
+		suite.loadKubernetesNodes(ctx)
+	}
+
+	var results []healthResult
+
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		suite.probe = talosHealthProbe{c: c}
+		results = suite.run(ctx, nodes)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report := buildHealthReport(suite.now, nodes, results)
+
+	if err := writeHealthReportTo(out, progress, report); err != nil {
+		return err
+	}
+
+	if report.Summary.Failed > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%d of %d health checks failed", report.Summary.Failed, len(report.Results)),
+			"see the report above for the failing node and check",
+		)
+	}
+
+	return nil
+}
+
+// writeHealthReportTo writes the report to --output-file when one is
+// given, with a one-line summary on progress, and to out otherwise.
+func writeHealthReportTo(out, progress io.Writer, report healthReport) error {
+	if healthcheckCmdFlags.outputFile == "" {
+		return writeHealthReport(out, healthcheckCmdFlags.output, report)
+	}
+
+	f, err := os.Create(healthcheckCmdFlags.outputFile)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", healthcheckCmdFlags.outputFile)
+	}
+
+	if err := writeHealthReport(f, healthcheckCmdFlags.output, report); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", healthcheckCmdFlags.outputFile)
+	}
+
+	fmt.Fprintf(progress, "Health report written to %s: %s\n", healthcheckCmdFlags.outputFile, report.Summary)
+
+	return nil
+}
+
+// parseHealthChecks validates --checks and returns the selection in
+// run order, without duplicates. An empty selection means all checks.
+func parseHealthChecks(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return slices.Clone(healthCheckNames), nil
+	}
+
+	for _, name := range requested {
+		if !slices.Contains(healthCheckNames, name) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("unknown health check %q", name),
+				"available checks: %s", strings.Join(healthCheckNames, ", "),
+			)
+		}
+	}
+
+	var checks []string
+
+	for _, name := range healthCheckNames {
+		if slices.Contains(requested, name) {
+			checks = append(checks, name)
+		}
+	}
+
+	return checks, nil
+}
+
+// resolveHealthNodes returns the nodes to check: --nodes when given,
+// otherwise every node the node files target. The first node file's
+// modeline supplies the endpoints unless --endpoints is given.
+func resolveHealthNodes(progress io.Writer) ([]string, error) {
+	nodesFromArgs := len(GlobalArgs.Nodes) > 0
+	endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+
+	files, skipped, err := scanPruneNodeFiles(Config.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range skipped {
+		fmt.Fprintf(progress, "Skipping %s: no talm modeline\n", path)
+	}
+
+	nodes := slices.Clone(GlobalArgs.Nodes)
+	if !nodesFromArgs {
+		for _, file := range files {
+			nodes = appendUnique(nodes, file.nodes...)
+		}
+	}
+
+	if len(nodes) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("no nodes to check"),
+			"add node files under nodes/ or pass --nodes",
+		)
+	}
+
+	if !endpointsFromArgs && len(files) > 0 {
+		if _, err := processModelineAndUpdateGlobals(filepath.Join(Config.RootDir, files[0].path), nodesFromArgs, endpointsFromArgs, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+// loadKubernetesNodes reads the Kubernetes Nodes and their Ready
+// conditions once for the whole run. Without a project kubeconfig the
+// kubelet check is skipped; any other failure fails it on every node.
+func (s *healthSuite) loadKubernetesNodes(ctx context.Context) {
+	if _, err := os.Stat(projectKubeconfigPath()); err != nil {
+		s.kubeSkip = "no project kubeconfig; run `talm kubeconfig -f <control-plane node file>` to enable this check"
+
+		return
+	}
+
+	nodes, err := listKubernetesNodes(ctx)
+	if err != nil {
+		s.kubeErr = err
+
+		return
+	}
+
+	for _, node := range nodes {
+		s.kubeNodes = append(s.kubeNodes, kubeNodeReadiness(node))
+	}
+}
+
+// kubeNodeReadiness extracts the Ready condition of a Node.
+func kubeNodeReadiness(node corev1.Node) kubeNodeHealth {
+	health := kubeNodeHealth{node: kubernetesLiveNode(node), reason: "no Ready condition reported"}
+
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != corev1.NodeReady {
+			continue
+		}
+
+		health.ready = cond.Status == corev1.ConditionTrue
+		health.reason = strings.TrimSpace(cond.Reason + ": " + cond.Message)
+
+		break
+	}
+
+	return health
+}
+
+// run checks every node, at most s.parallel at a time, and returns
+// the results grouped by node in the order of nodes.
+func (s *healthSuite) run(ctx context.Context, nodes []string) []healthResult {
+	perNode := make([][]healthResult, len(nodes))
+	sem := make(chan struct{}, max(s.parallel, 1))
+
+	var wg sync.WaitGroup
+
+	for i, node := range nodes {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			perNode[i] = s.checkNode(ctx, node)
+		})
+	}
+
+	wg.Wait()
+
+	var results []healthResult
+	for _, r := range perNode {
+		results = append(results, r...)
+	}
+
+	return results
+}
+
+// checkNode runs the selected checks on one node, each bounded by
+// s.timeout.
+func (s *healthSuite) checkNode(ctx context.Context, node string) []healthResult {
+	results := make([]healthResult, 0, len(s.checks))
+	apiDown := false
+
+	for _, name := range s.checks {
+		start := time.Now()
+		result := healthResult{Node: node, Check: name}
+
+		if apiDown && name != healthCheckKubelet {
+			result.Status, result.Message = healthStatusSkip, "Talos API unreachable"
+		} else {
+			checkCtx, cancel := ctx, context.CancelFunc(func() {})
+			if s.timeout > 0 {
+				checkCtx, cancel = context.WithTimeout(ctx, s.timeout)
+			}
+
+			result.Status, result.Message = s.check(checkCtx, node, name)
+
+			cancel()
+		}
+
+		if name == healthCheckAPI && result.Status == healthStatusFail {
+			apiDown = true
+		}
+
+		result.Duration = time.Since(start).Seconds()
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (s *healthSuite) check(ctx context.Context, node, name string) (string, string) {
+	switch name {
+	case healthCheckAPI:
+		version, err := s.probe.Version(ctx, node)
+		if err != nil {
+			return healthStatusFail, fmt.Sprintf("Talos API unreachable: %v", err)
+		}
+
+		return healthStatusPass, "Talos " + version
+	case healthCheckDisk:
+		mounts, err := s.probe.Mounts(ctx, node)
+		if err != nil {
+			return healthStatusFail, fmt.Sprintf("reading mounts: %v", err)
+		}
+
+		return evaluateDiskHealth(mounts, s.minFreePercent)
+	case healthCheckTime:
+		spec, err := s.probe.TimeStatus(ctx, node)
+		if err != nil {
+			return healthStatusFail, fmt.Sprintf("reading time status: %v", err)
+		}
+
+		return evaluateTimeHealth(spec)
+	case healthCheckCerts:
+		notAfter, err := s.probe.APICertNotAfter(ctx, node)
+		if err != nil {
+			return healthStatusFail, fmt.Sprintf("reading the API certificate: %v", err)
+		}
+
+		return evaluateCertHealth(notAfter, s.now, s.certWarn)
+	case healthCheckKubelet:
+		return s.evaluateKubeletHealth(node)
+	}
+
+	return healthStatusFail, "unknown check"
+}
+
+// evaluateDiskHealth checks the free space of healthDiskMount against
+// minFreePercent.
+func evaluateDiskHealth(mounts []*machineapi.MountStat, minFreePercent float64) (string, string) {
+	for _, mount := range mounts {
+		if mount.GetMountedOn() != healthDiskMount || mount.GetSize() == 0 {
+			continue
+		}
+
+		free := float64(mount.GetAvailable()) / float64(mount.GetSize()) * 100
+		detail := fmt.Sprintf("%s has %.1f%% free (%s of %s)", healthDiskMount, free, humanize.IBytes(mount.GetAvailable()), humanize.IBytes(mount.GetSize()))
+
+		if free < minFreePercent {
+			return healthStatusFail, fmt.Sprintf("%s, below the %g%% threshold", detail, minFreePercent)
+		}
+
+		return healthStatusPass, detail
+	}
+
+	return healthStatusSkip, "no " + healthDiskMount + " mount reported"
+}
+
+func evaluateTimeHealth(spec *talostime.StatusSpec) (string, string) {
+	switch {
+	case spec.SyncDisabled:
+		return healthStatusSkip, "time sync is disabled in the machine config"
+	case spec.Synced:
+		return healthStatusPass, "time is synchronized"
+	default:
+		return healthStatusFail, "time is not synchronized"
+	}
+}
+
+// evaluateCertHealth fails a certificate that has expired or expires
+// within warn of now.
+func evaluateCertHealth(notAfter, now time.Time, warn time.Duration) (string, string) {
+	date := notAfter.UTC().Format(time.DateOnly)
+	left := notAfter.Sub(now)
+
+	switch {
+	case left <= 0:
+		return healthStatusFail, "API certificate expired on " + date
+	case left < warn:
+		return healthStatusFail, fmt.Sprintf("API certificate expires in %d days, on %s", int(left.Hours()/24), date)
+	default:
+		return healthStatusPass, "API certificate valid until " + date
+	}
+}
+
+func (s *healthSuite) evaluateKubeletHealth(node string) (string, string) {
+	if s.kubeSkip != "" {
+		return healthStatusSkip, s.kubeSkip
+	}
+
+	if s.kubeErr != nil {
+		return healthStatusFail, s.kubeErr.Error()
+	}
+
+	for _, kube := range s.kubeNodes {
+		if !matchesLiveNode(node, kube.node) {
+			continue
+		}
+
+		if kube.ready {
+			return healthStatusPass, fmt.Sprintf("Node %s is Ready", kube.node.name)
+		}
+
+		return healthStatusFail, fmt.Sprintf("Node %s is not Ready: %s", kube.node.name, kube.reason)
+	}
+
+	return healthStatusFail, "not registered as a Kubernetes Node"
+}
+
+func buildHealthReport(now time.Time, nodes []string, results []healthResult) healthReport {
+	report := healthReport{Time: now.UTC(), Nodes: nodes, Results: results}
+
+	for _, r := range results {
+		switch r.Status {
+		case healthStatusPass:
+			report.Summary.Passed++
+		case healthStatusFail:
+			report.Summary.Failed++
+		default:
+			report.Summary.Skipped++
+		}
+	}
+
+	return report
+}
+
+func (s healthSummary) String() string {
+	return fmt.Sprintf("%d passed, %d failed, %d skipped", s.Passed, s.Failed, s.Skipped)
+}
+
+func writeHealthReport(w io.Writer, format string, report healthReport) error {
+	switch format {
+	case healthOutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return errors.Wrap(enc.Encode(report), "writing JSON report")
+	case healthOutputJUnit:
+		return writeHealthJUnit(w, report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tCHECK\tSTATUS\tMESSAGE")
+
+	for _, r := range report.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Node, r.Check, strings.ToUpper(r.Status), r.Message)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "writing report table")
+	}
+
+	fmt.Fprintf(w, "\n%s\n", report.Summary)
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeHealthJUnit renders the report as JUnit XML: one testsuite per
+// node and one testcase per check, so CI test views group findings by
+// node.
+func writeHealthJUnit(w io.Writer, report healthReport) error {
+	root := junitTestSuites{Name: "talm healthcheck"}
+	timestamp := report.Time.Format(time.RFC3339)
+
+	var total float64
+
+	for _, node := range report.Nodes {
+		suite := junitTestSuite{Name: node, Timestamp: timestamp}
+
+		var elapsed float64
+
+		for _, r := range report.Results {
+			if r.Node != node {
+				continue
+			}
+
+			tc := junitTestCase{Name: r.Check, ClassName: "healthcheck." + node, Time: junitSeconds(r.Duration)}
+
+			switch r.Status {
+			case healthStatusFail:
+				tc.Failure = &junitMessage{Message: r.Message}
+				suite.Failures++
+			case healthStatusSkip:
+				tc.Skipped = &junitMessage{Message: r.Message}
+				suite.Skipped++
+			default:
+				tc.SystemOut = r.Message
+			}
+
+			suite.Tests++
+			suite.Cases = append(suite.Cases, tc)
+			elapsed += r.Duration
+		}
+
+		suite.Time = junitSeconds(elapsed)
+		total += elapsed
+
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Skipped += suite.Skipped
+		root.Suites = append(root.Suites, suite)
+	}
+
+	root.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return errors.Wrap(err, "writing JUnit report")
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(root); err != nil {
+		return errors.Wrap(err, "writing JUnit report")
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return errors.Wrap(err, "writing JUnit report")
+}
+
+func junitSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// talosHealthProbe reads the checks' inputs through the Talos API,
+// addressing each node through the endpoints.
+type talosHealthProbe struct {
+	c *client.Client
+}
+
+func (p talosHealthProbe) Version(ctx context.Context, node string) (string, error) {
+	resp, err := p.c.Version(client.WithNode(ctx, node))
+	if err != nil {
+		return "", err //nolint:wrapcheck // reported verbatim in the check message.
+	}
+
+	msgs := resp.GetMessages()
+	if len(msgs) == 0 {
+		return "", errors.New("empty version response")
+	}
+
+	return msgs[0].GetVersion().GetTag(), nil
+}
+
+func (p talosHealthProbe) Mounts(ctx context.Context, node string) ([]*machineapi.MountStat, error) {
+	resp, err := p.c.Mounts(client.WithNode(ctx, node))
+	if err != nil {
+		return nil, err //nolint:wrapcheck // reported verbatim in the check message.
+	}
+
+	var stats []*machineapi.MountStat
+	for _, msg := range resp.GetMessages() {
+		stats = append(stats, msg.GetStats()...)
+	}
+
+	return stats, nil
+}
+
+func (p talosHealthProbe) TimeStatus(ctx context.Context, node string) (*talostime.StatusSpec, error) {
+	status, err := safe.StateGetByID[*talostime.Status](client.WithNode(ctx, node), p.c.COSI, talostime.StatusID)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // reported verbatim in the check message.
+	}
+
+	return status.TypedSpec(), nil
+}
+
+func (p talosHealthProbe) APICertNotAfter(ctx context.Context, node string) (time.Time, error) {
+	certs, err := safe.StateGetByID[*secrets.API](client.WithNode(ctx, node), p.c.COSI, secrets.APIID)
+	if err != nil {
+		return time.Time{}, err //nolint:wrapcheck // reported verbatim in the check message.
+	}
+
+	server := certs.TypedSpec().Server
+	if server == nil {
+		return time.Time{}, errors.New("no server certificate issued")
+	}
+
+	crt, err := server.GetCert()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parsing the server certificate")
+	}
+
+	return crt.NotAfter, nil
+}
+
+func init() {
+	healthcheckCmd.Flags().StringSliceVar(&healthcheckCmdFlags.checks, "checks", nil, "checks to run (default all): "+strings.Join(healthCheckNames, ", "))
+	healthcheckCmd.Flags().StringVarP(&healthcheckCmdFlags.output, "output", "o", healthOutputText, "report format: text, json or junit")
+	healthcheckCmd.Flags().StringVar(&healthcheckCmdFlags.outputFile, "output-file", "", "write the report to this file instead of stdout")
+	healthcheckCmd.Flags().Float64Var(&healthcheckCmdFlags.minFreePercent, "min-free-percent", 10, "fail the disk check when "+healthDiskMount+" has less free space than this percentage")
+	healthcheckCmd.Flags().IntVar(&healthcheckCmdFlags.certWarnDays, "cert-warn-days", 30, "fail the certs check when the API certificate expires within this many days")
+	healthcheckCmd.Flags().DurationVar(&healthcheckCmdFlags.timeout, "timeout", 15*time.Second, "time limit for each check on each node")
+	healthcheckCmd.Flags().IntVar(&healthcheckCmdFlags.parallel, "parallel", 8, "number of nodes checked at the same time")
+
+	addCommand(healthcheckCmd)
+}
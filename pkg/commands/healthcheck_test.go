@@ -0,0 +1,347 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	talostime "github.com/siderolabs/talos/pkg/machinery/resources/time"
+)
+
+// fakeHealthProbe answers every node the same way, except nodes listed
+// in down, whose Talos API is unreachable.
+type fakeHealthProbe struct {
+	down     map[string]bool
+	mounts   []*machineapi.MountStat
+	timeSpec talostime.StatusSpec
+	notAfter time.Time
+}
+
+var errNodeDown = errors.New("connection refused")
+
+func (f *fakeHealthProbe) Version(_ context.Context, node string) (string, error) {
+	if f.down[node] {
+		return "", errNodeDown
+	}
+
+	return "v1.13.7", nil
+}
+
+func (f *fakeHealthProbe) Mounts(_ context.Context, node string) ([]*machineapi.MountStat, error) {
+	if f.down[node] {
+		return nil, errNodeDown
+	}
+
+	return f.mounts, nil
+}
+
+func (f *fakeHealthProbe) TimeStatus(_ context.Context, node string) (*talostime.StatusSpec, error) {
+	if f.down[node] {
+		return nil, errNodeDown
+	}
+
+	return &f.timeSpec, nil
+}
+
+func (f *fakeHealthProbe) APICertNotAfter(_ context.Context, node string) (time.Time, error) {
+	if f.down[node] {
+		return time.Time{}, errNodeDown
+	}
+
+	return f.notAfter, nil
+}
+
+var healthTestNow = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestHealthSuite(probe healthProbe) healthSuite {
+	return healthSuite{
+		checks:         healthCheckNames,
+		minFreePercent: 10,
+		certWarn:       30 * 24 * time.Hour,
+		timeout:        time.Second,
+		parallel:       2,
+		now:            healthTestNow,
+		probe:          probe,
+		kubeNodes: []kubeNodeHealth{
+			{node: liveNode{name: "cp1", addresses: []string{"192.0.2.10"}}, ready: true},
+			{node: liveNode{name: "cp2", addresses: []string{"192.0.2.11"}}, reason: "KubeletNotReady: container runtime is down"},
+		},
+	}
+}
+
+func healthyProbe() *fakeHealthProbe {
+	return &fakeHealthProbe{
+		mounts:   []*machineapi.MountStat{{MountedOn: "/var", Size: 100 << 30, Available: 50 << 30}},
+		timeSpec: talostime.StatusSpec{Synced: true},
+		notAfter: healthTestNow.Add(365 * 24 * time.Hour),
+	}
+}
+
+// TestHealthSuite_Run pins the per-node results: every check runs on
+// a healthy node, results keep the node order despite running in
+// parallel, and a node whose Talos API is down reports the Talos
+// checks as skipped while the kubelet check still runs.
+func TestHealthSuite_Run(t *testing.T) {
+	t.Parallel()
+
+	probe := healthyProbe()
+	probe.down = map[string]bool{"192.0.2.11": true}
+
+	suite := newTestHealthSuite(probe)
+	results := suite.run(context.Background(), []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"})
+
+	got := make([]string, 0, len(results))
+	for _, r := range results {
+		got = append(got, r.Node+" "+r.Check+" "+r.Status)
+	}
+
+	want := []string{
+		"192.0.2.10 api pass", "192.0.2.10 disk pass", "192.0.2.10 time pass", "192.0.2.10 certs pass", "192.0.2.10 kubelet pass",
+		"192.0.2.11 api fail", "192.0.2.11 disk skip", "192.0.2.11 time skip", "192.0.2.11 certs skip", "192.0.2.11 kubelet fail",
+		"192.0.2.12 api pass", "192.0.2.12 disk pass", "192.0.2.12 time pass", "192.0.2.12 certs pass", "192.0.2.12 kubelet fail",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if msg := results[5].Message; !strings.Contains(msg, "connection refused") {
+		t.Errorf("api failure must carry the error, got %q", msg)
+	}
+
+	if msg := results[9].Message; !strings.Contains(msg, "container runtime is down") {
+		t.Errorf("kubelet failure must carry the condition reason, got %q", msg)
+	}
+
+	if msg := results[14].Message; msg != "not registered as a Kubernetes Node" {
+		t.Errorf("unregistered node message = %q", msg)
+	}
+}
+
+// TestHealthSuite_KubeletSources pins the kubelet check without a
+// kubeconfig (skipped) and with an unreachable Kubernetes API (failed).
+func TestHealthSuite_KubeletSources(t *testing.T) {
+	t.Parallel()
+
+	suite := newTestHealthSuite(healthyProbe())
+	suite.checks = []string{healthCheckKubelet}
+
+	suite.kubeSkip = "no project kubeconfig"
+	if r := suite.checkNode(context.Background(), "192.0.2.10"); r[0].Status != healthStatusSkip {
+		t.Errorf("without a kubeconfig the kubelet check must skip, got %+v", r)
+	}
+
+	suite.kubeSkip = ""
+	suite.kubeErr = errors.New("listing Kubernetes nodes: timeout")
+
+	if r := suite.checkNode(context.Background(), "192.0.2.10"); r[0].Status != healthStatusFail || !strings.Contains(r[0].Message, "timeout") {
+		t.Errorf("an unreachable Kubernetes API must fail the check, got %+v", r)
+	}
+}
+
+func TestEvaluateDiskHealth(t *testing.T) {
+	t.Parallel()
+
+	mounts := []*machineapi.MountStat{
+		{MountedOn: "/", Size: 1 << 30, Available: 0},
+		{MountedOn: "/var", Size: 100 << 30, Available: 5 << 30},
+	}
+
+	status, msg := evaluateDiskHealth(mounts, 10)
+	if status != healthStatusFail || !strings.Contains(msg, "5.0% free") || !strings.Contains(msg, "10% threshold") {
+		t.Errorf("low /var = %s %q", status, msg)
+	}
+
+	if status, _ := evaluateDiskHealth(mounts, 5); status != healthStatusPass {
+		t.Errorf("/var at the threshold must pass, got %s", status)
+	}
+
+	if status, _ := evaluateDiskHealth(mounts[:1], 10); status != healthStatusSkip {
+		t.Errorf("a node without /var must skip, got %s", status)
+	}
+}
+
+func TestEvaluateTimeHealth(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		spec talostime.StatusSpec
+		want string
+	}{
+		{talostime.StatusSpec{Synced: true}, healthStatusPass},
+		{talostime.StatusSpec{}, healthStatusFail},
+		{talostime.StatusSpec{SyncDisabled: true}, healthStatusSkip},
+	} {
+		if got, _ := evaluateTimeHealth(&tc.spec); got != tc.want {
+			t.Errorf("%+v = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateCertHealth(t *testing.T) {
+	t.Parallel()
+
+	warn := 30 * 24 * time.Hour
+
+	if status, msg := evaluateCertHealth(healthTestNow.Add(-time.Hour), healthTestNow, warn); status != healthStatusFail || !strings.Contains(msg, "expired on 2026-05-01") {
+		t.Errorf("expired = %s %q", status, msg)
+	}
+
+	if status, msg := evaluateCertHealth(healthTestNow.Add(10*24*time.Hour), healthTestNow, warn); status != healthStatusFail || !strings.Contains(msg, "expires in 10 days") {
+		t.Errorf("expiring = %s %q", status, msg)
+	}
+
+	if status, msg := evaluateCertHealth(healthTestNow.Add(90*24*time.Hour), healthTestNow, warn); status != healthStatusPass || !strings.Contains(msg, "2026-07-30") {
+		t.Errorf("valid = %s %q", status, msg)
+	}
+}
+
+func TestKubeNodeReadiness(t *testing.T) {
+	t.Parallel()
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.0.2.10"}},
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Reason: "KubeletNotReady", Message: "PLEG is not healthy"},
+			},
+		},
+	}
+
+	health := kubeNodeReadiness(node)
+	if health.ready || health.reason != "KubeletNotReady: PLEG is not healthy" || !matchesLiveNode("192.0.2.10", health.node) {
+		t.Errorf("not ready node = %+v", health)
+	}
+
+	node.Status.Conditions[1].Status = corev1.ConditionTrue
+	if !kubeNodeReadiness(node).ready {
+		t.Error("a Ready=True node must be ready")
+	}
+
+	node.Status.Conditions = nil
+	if health := kubeNodeReadiness(node); health.ready || health.reason == "" {
+		t.Errorf("a node without a Ready condition must not be ready, got %+v", health)
+	}
+}
+
+func TestParseHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	all, err := parseHealthChecks(nil)
+	if err != nil || !reflect.DeepEqual(all, healthCheckNames) {
+		t.Errorf("default = %v, %v", all, err)
+	}
+
+	got, err := parseHealthChecks([]string{"kubelet", "api", "kubelet"})
+	if err != nil || !reflect.DeepEqual(got, []string{"api", "kubelet"}) {
+		t.Errorf("selection must run in canonical order without duplicates, got %v, %v", got, err)
+	}
+
+	_, err = parseHealthChecks([]string{"etcd"})
+	if err == nil || !strings.Contains(strings.Join(errors.GetAllHints(err), "\n"), "api, disk, time, certs, kubelet") {
+		t.Errorf("unknown check error must list the checks, got %v", err)
+	}
+}
+
+func testHealthReport() healthReport {
+	return buildHealthReport(healthTestNow, []string{"192.0.2.10", "192.0.2.11"}, []healthResult{
+		{Node: "192.0.2.10", Check: "api", Status: healthStatusPass, Message: "Talos v1.13.7", Duration: 0.01},
+		{Node: "192.0.2.10", Check: "disk", Status: healthStatusFail, Message: "/var has 5.0% free", Duration: 0.02},
+		{Node: "192.0.2.11", Check: "api", Status: healthStatusFail, Message: "Talos API unreachable", Duration: 1},
+		{Node: "192.0.2.11", Check: "disk", Status: healthStatusSkip, Message: "Talos API unreachable"},
+	})
+}
+
+// TestWriteHealthJUnit pins the JUnit layout CI systems read: one
+// testsuite per node, failures and skips counted at both levels.
+func TestWriteHealthJUnit(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	if err := writeHealthReport(&out, healthOutputJUnit, testHealthReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(out.String(), "<?xml") {
+		t.Errorf("report must start with an XML header:\n%s", out.String())
+	}
+
+	var parsed junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Tests != 4 || parsed.Failures != 2 || parsed.Skipped != 1 || len(parsed.Suites) != 2 {
+		t.Fatalf("totals = %+v", parsed)
+	}
+
+	second := parsed.Suites[1]
+	if second.Name != "192.0.2.11" || second.Failures != 1 || second.Skipped != 1 || second.Time != "1.000" {
+		t.Errorf("second suite = %+v", second)
+	}
+
+	if tc := second.Cases[0]; tc.Name != "api" || tc.ClassName != "healthcheck.192.0.2.11" || tc.Failure == nil || tc.Failure.Message != "Talos API unreachable" {
+		t.Errorf("failed case = %+v", tc)
+	}
+
+	if second.Cases[1].Skipped == nil {
+		t.Errorf("skipped case = %+v", second.Cases[1])
+	}
+}
+
+func TestWriteHealthReport_JSONAndText(t *testing.T) {
+	t.Parallel()
+
+	report := testHealthReport()
+
+	var jsonOut bytes.Buffer
+	if err := writeHealthReport(&jsonOut, healthOutputJSON, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed healthReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Summary != (healthSummary{Passed: 1, Failed: 2, Skipped: 1}) || len(parsed.Results) != 4 || !parsed.Time.Equal(healthTestNow) {
+		t.Errorf("JSON report = %+v", parsed)
+	}
+
+	var textOut bytes.Buffer
+	if err := writeHealthReport(&textOut, healthOutputText, report); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"NODE", "192.0.2.10", "FAIL", "/var has 5.0% free", "1 passed, 2 failed, 1 skipped"} {
+		if !strings.Contains(textOut.String(), want) {
+			t.Errorf("text report missing %q:\n%s", want, textOut.String())
+		}
+	}
+}
@@ -17,6 +17,7 @@ package commands
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"fmt"
 	"io"
 	"io/fs"
@@ -33,6 +34,7 @@ import (
 	"github.com/cozystack/talm/pkg/age"
 	"github.com/cozystack/talm/pkg/generated"
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
@@ -47,26 +49,21 @@ import (
 // (initSubcommand, chartYamlName, defaultKubeconfigName,
 // defaultLocalEndpoint) live in consts.go.
 const (
-	// secretsYamlName is the unencrypted secrets manifest written
-	// during init and consumed by chart rendering.
-	secretsYamlName = "secrets.yaml"
-	// secretsEncryptedYamlName is the age-encrypted secrets manifest
-	// committed to git in encrypted form.
-	secretsEncryptedYamlName = "secrets.encrypted.yaml"
-	// valuesSecretYamlName is the plaintext file the operator authors to
-	// hold arbitrary user secret values consumed by chart templates (e.g.
-	// a registry password, a KMS secret-id). It is git-ignored; only its
-	// encrypted sibling is committed.
-	valuesSecretYamlName = "values-secret.yaml"
-	// valuesSecretEncryptedYamlName is the age-encrypted sibling of
-	// values-secret.yaml, committed to git and referenced from
-	// templateOptions.valueFiles; the engine decrypts it in memory at
-	// template / apply time (it never writes plaintext back to disk).
-	valuesSecretEncryptedYamlName = "values-secret.encrypted.yaml"
-	// talmKeyName is the age private-key file the init flow generates
-	// alongside the encrypted bundle; it is what `.gitignore` excludes
-	// and what tests pin as a sensitive artefact name.
-	talmKeyName = "talm.key"
+	// secretsYamlName is the default name of the unencrypted secrets
+	// manifest written during init and consumed by chart rendering;
+	// globalOptions.secrets moves it (see secretsLayout).
+	secretsYamlName = age.DefaultSecretsFile
+	// valuesSecretYamlName is the default name of the plaintext file the
+	// operator authors to hold arbitrary user secret values consumed by
+	// chart templates (e.g. a registry password, a KMS secret-id). It is
+	// git-ignored; only its encrypted sibling, referenced from
+	// templateOptions.valueFiles, is committed.
+	valuesSecretYamlName = age.DefaultValuesSecretFile
+	// talmKeyName is the default name of the age private-key file the
+	// init flow generates alongside the encrypted bundle; it is what
+	// `.gitignore` excludes and what tests pin as a sensitive artefact
+	// name.
+	talmKeyName = age.DefaultKeyFile
 	// talosconfigName is the talosctl client-config filename written
 	// during init and rotated by --encrypt/--decrypt.
 	talosconfigName = "talosconfig"
@@ -134,9 +131,21 @@ var initCmdFlags struct {
 	talosVersion    string
 	image           string
 	clusterEndpoint string
+	floatingIP      string
+	podSubnets      []string
+	serviceSubnets  []string
 	update          bool
 	encrypt         bool
 	decrypt         bool
+	migrateSecrets  bool
+	talosCA         []string
+	kubernetesCA    []string
+	etcdCA          []string
+
+	// fromArchetype is set by talm archetype apply, not by a flag: the
+	// archetype's chart files are already in place, so init writes no
+	// preset files and only adds the cluster state.
+	fromArchetype bool
 }
 
 // initCmd represents the `init` command.
@@ -175,6 +184,27 @@ var initCmd = &cobra.Command{
 			)
 		}
 
+		// The network flags rewrite values.yaml at write time too.
+		if hasInitNetworkFlags() && (initCmdFlags.encrypt || initCmdFlags.decrypt || initCmdFlags.update) {
+			return errors.WithHint(
+				errors.New("--floating-ip, --pod-subnets and --service-subnets are honored on initial init only; not valid with --encrypt, --decrypt, or --update"),
+				"drop the flags and edit values.yaml to change the network of an existing project",
+			)
+		}
+
+		if err := validateInitNetworkFlags(initCmdFlags.floatingIP, initCmdFlags.clusterEndpoint, initCmdFlags.podSubnets, initCmdFlags.serviceSubnets); err != nil {
+			return err
+		}
+
+		// The --with-*-ca flags seed the secrets bundle, which only an
+		// initial init generates.
+		if hasExternalCAs() && (initCmdFlags.encrypt || initCmdFlags.decrypt || initCmdFlags.update || initCmdFlags.migrateSecrets) {
+			return errors.WithHint(
+				errors.New("--with-talos-ca, --with-k8s-ca and --with-etcd-ca are honored on initial init only; not valid with --encrypt, --decrypt, --update, or --migrate-secrets"),
+				"drop the --with-*-ca flags; to replace a CA of an existing cluster, use talm rotate-ca",
+			)
+		}
+
 		// Validate the URL shape of --cluster-endpoint up front so a
 		// malformed value short-circuits before any files are
 		// written. The same validation runs again at the
@@ -187,8 +217,8 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		// For -e, -d, and -u flags, always check that we're in a project root
-		if initCmdFlags.encrypt || initCmdFlags.decrypt || initCmdFlags.update {
+		// For -e, -d, -u and --migrate-secrets, always check that we're in a project root
+		if initCmdFlags.encrypt || initCmdFlags.decrypt || initCmdFlags.update || initCmdFlags.migrateSecrets {
 			// Verify that Config.RootDir is actually a project root
 			detectedRoot, err := DetectProjectRoot(Config.RootDir)
 			if err != nil {
@@ -210,8 +240,8 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		// Preset and name are not required when using --encrypt or --decrypt flags
-		if initCmdFlags.encrypt || initCmdFlags.decrypt {
+		// Preset and name are not required when using --encrypt, --decrypt or --migrate-secrets
+		if initCmdFlags.encrypt || initCmdFlags.decrypt || initCmdFlags.migrateSecrets {
 			return nil
 		}
 		// For --update flag, only preset is required (name is not needed)
@@ -301,10 +331,18 @@ var initCmd = &cobra.Command{
 			err             error
 		)
 
+		if err := loadProjectSecretsLayout(); err != nil {
+			return err
+		}
+
 		if initCmdFlags.update {
 			return updateTalmLibraryChart()
 		}
 
+		if initCmdFlags.migrateSecrets {
+			return runMigrateSecrets()
+		}
+
 		if initCmdFlags.talosVersion != "" {
 			versionContract, err = config.ParseContractFromVersion(initCmdFlags.talosVersion)
 			if err != nil {
@@ -319,6 +357,10 @@ var initCmd = &cobra.Command{
 			return errors.Wrap(err, "failed to create secrets bundle")
 		}
 
+		if err := applyExternalCAs(secretsBundle, time.Now(), os.Stderr); err != nil {
+			return err
+		}
+
 		var genOptions []generate.Option
 
 		// Validate preset only if not using --encrypt or --decrypt
@@ -369,8 +411,10 @@ var initCmd = &cobra.Command{
 		// also early-return below before the write loop reaches
 		// presetFiles, so loading the map at all is wasted work
 		// under those flags — gate the load on the same condition.
+		// An archetype brings its own chart files, which talm archetype
+		// apply checked for conflicts and wrote before init runs.
 		var presetFiles map[string]string
-		if !initCmdFlags.encrypt && !initCmdFlags.decrypt {
+		if !initCmdFlags.encrypt && !initCmdFlags.decrypt && !initCmdFlags.fromArchetype {
 			presetFiles, err = generated.PresetFiles()
 			if err != nil {
 				return errors.Wrap(err, "failed to get preset files")
@@ -429,29 +473,36 @@ var initCmd = &cobra.Command{
 		}
 
 		// Handle age encryption logic
-		secretsFile := filepath.Join(Config.RootDir, secretsYamlName)
-		encryptedSecretsFile := filepath.Join(Config.RootDir, secretsEncryptedYamlName)
-		keyFile := filepath.Join(Config.RootDir, talmKeyName)
+		layout := secretsLayout()
+		secretsFile := projectPath(layout.SecretsFile())
+		encryptedSecretsFile := projectPath(layout.EncryptedSecretsFile())
+		keyFile := projectPath(layout.KeyFile())
 
 		secretsFileExists := fileExists(secretsFile)
 		encryptedSecretsFileExists := fileExists(encryptedSecretsFile)
 		keyFileExists := fileExists(keyFile)
 		keyWasCreated := false // Track if key was created during this init
 
+		// An existing secrets bundle is kept as is, so external CAs
+		// would silently not make it into the project.
+		if hasExternalCAs() && (secretsFileExists || encryptedSecretsFileExists) {
+			return errors.WithHintf(
+				errors.Newf("%s already exists; the --with-*-ca flags only seed a new secrets bundle", layout.SecretsFile()),
+				"init the project in an empty directory, or replace the CA of the existing cluster with talm %s", rotateCACmdName,
+			)
+		}
+
 		// Check for invalid state: encrypted file exists but secrets.yaml and key don't
 		if encryptedSecretsFileExists && !secretsFileExists && !keyFileExists {
-			return errors.WithHint(
-				errors.New("secrets.encrypted.yaml exists but secrets.yaml and talm.key are missing. Cannot decrypt without key"),
-				"restore talm.key from your backup, or recreate the project from scratch if the key is unrecoverable",
+			return errors.WithHintf(
+				errors.Newf("%s exists but %s and %s are missing. Cannot decrypt without key", layout.EncryptedSecretsFile(), layout.SecretsFile(), layout.KeyFile()),
+				"restore %s from your backup, or recreate the project from scratch if the key is unrecoverable", layout.KeyFile(),
 			)
 		}
 
 		// Handle --encrypt flag (early return, doesn't need preset)
 		if initCmdFlags.encrypt {
 			// Ensure key exists before encryption
-			keyFile := filepath.Join(Config.RootDir, talmKeyName)
-			keyFileExists := fileExists(keyFile)
-
 			if !keyFileExists {
 				_, keyCreated, err := age.GenerateKey(Config.RootDir)
 				if err != nil {
@@ -459,13 +510,12 @@ var initCmd = &cobra.Command{
 				}
 
 				if keyCreated {
-					fmt.Fprintf(os.Stderr, "Generated new encryption key: talm.key\n")
+					ui.Infof(os.Stderr, "Generated new encryption key: %s", layout.KeyFile())
 					printSecretsWarning()
 				}
 			}
 
 			// Encrypt all sensitive files
-			secretsFile := filepath.Join(Config.RootDir, secretsYamlName)
 			talosconfigFile := filepath.Join(Config.RootDir, "talosconfig")
 
 			kubeconfigPath := Config.GlobalOptions.Kubeconfig
@@ -479,7 +529,7 @@ var initCmd = &cobra.Command{
 
 			// Encrypt secrets.yaml
 			if fileExists(secretsFile) {
-				fmt.Fprintf(os.Stderr, "Encrypting secrets.yaml -> secrets.encrypted.yaml\n")
+				ui.Infof(os.Stderr, "Encrypting %s -> %s", layout.SecretsFile(), layout.EncryptedSecretsFile())
 
 				err := age.EncryptSecretsFile(Config.RootDir)
 				if err != nil {
@@ -491,7 +541,7 @@ var initCmd = &cobra.Command{
 
 			// Encrypt talosconfig
 			if fileExists(talosconfigFile) {
-				fmt.Fprintf(os.Stderr, "Encrypting talosconfig -> talosconfig.encrypted\n")
+				ui.Infof(os.Stderr, "Encrypting talosconfig -> talosconfig.encrypted")
 
 				err := age.EncryptYAMLFile(Config.RootDir, "talosconfig", "talosconfig.encrypted")
 				if err != nil {
@@ -500,12 +550,12 @@ var initCmd = &cobra.Command{
 
 				encryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping talosconfig (file not found)\n")
+				ui.Infof(os.Stderr, "Skipping talosconfig (file not found)")
 			}
 
 			// Encrypt kubeconfig
 			if fileExists(kubeconfigFile) {
-				fmt.Fprintf(os.Stderr, "Encrypting %s -> %s.encrypted\n", kubeconfigPath, kubeconfigPath)
+				ui.Infof(os.Stderr, "Encrypting %s -> %s.encrypted", kubeconfigPath, kubeconfigPath)
 
 				err = age.EncryptYAMLFile(Config.RootDir, kubeconfigPath, kubeconfigPath+".encrypted")
 				if err != nil {
@@ -514,21 +564,21 @@ var initCmd = &cobra.Command{
 
 				encryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping %s (file not found)\n", kubeconfigPath)
+				ui.Infof(os.Stderr, "Skipping %s (file not found)", kubeconfigPath)
 			}
 
 			// Encrypt values-secret.yaml (arbitrary user secret values)
-			valuesSecretFile := filepath.Join(Config.RootDir, valuesSecretYamlName)
+			valuesSecretFile := projectPath(layout.ValuesSecretFile())
 			if fileExists(valuesSecretFile) {
-				fmt.Fprintf(os.Stderr, "Encrypting %s -> %s\n", valuesSecretYamlName, valuesSecretEncryptedYamlName)
+				ui.Infof(os.Stderr, "Encrypting %s -> %s", layout.ValuesSecretFile(), layout.EncryptedValuesSecretFile())
 
-				if err := age.EncryptYAMLFile(Config.RootDir, valuesSecretYamlName, valuesSecretEncryptedYamlName); err != nil {
+				if err := age.EncryptYAMLFile(Config.RootDir, layout.ValuesSecretFile(), layout.EncryptedValuesSecretFile()); err != nil {
 					return errors.Wrap(err, "failed to encrypt values-secret.yaml")
 				}
 
 				encryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping %s (file not found)\n", valuesSecretYamlName)
+				ui.Infof(os.Stderr, "Skipping %s (file not found)", layout.ValuesSecretFile())
 			}
 
 			// Update .gitignore file
@@ -538,9 +588,9 @@ var initCmd = &cobra.Command{
 			}
 
 			if encryptedCount > 0 {
-				fmt.Fprintf(os.Stderr, "Encryption completed successfully. %d file(s) encrypted.\n", encryptedCount)
+				ui.Successf(os.Stderr, "Encryption completed successfully. %d file(s) encrypted.", encryptedCount)
 			} else {
-				fmt.Fprintf(os.Stderr, "No files to encrypt.\n")
+				ui.Infof(os.Stderr, "No files to encrypt.")
 			}
 
 			return nil
@@ -549,7 +599,6 @@ var initCmd = &cobra.Command{
 		// Handle --decrypt flag (early return, doesn't need preset)
 		if initCmdFlags.decrypt {
 			// Decrypt all encrypted files
-			encryptedSecretsFile := filepath.Join(Config.RootDir, secretsEncryptedYamlName)
 			encryptedTalosconfigFile := filepath.Join(Config.RootDir, "talosconfig.encrypted")
 
 			kubeconfigPath := Config.GlobalOptions.Kubeconfig
@@ -563,7 +612,7 @@ var initCmd = &cobra.Command{
 
 			// Decrypt secrets.encrypted.yaml
 			if fileExists(encryptedSecretsFile) {
-				fmt.Fprintf(os.Stderr, "Decrypting secrets.encrypted.yaml -> secrets.yaml\n")
+				ui.Infof(os.Stderr, "Decrypting %s -> %s", layout.EncryptedSecretsFile(), layout.SecretsFile())
 
 				if err := age.DecryptSecretsFile(Config.RootDir); err != nil {
 					return errors.Wrap(err, "failed to decrypt secrets")
@@ -571,12 +620,12 @@ var initCmd = &cobra.Command{
 
 				decryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping secrets.encrypted.yaml (file not found)\n")
+				ui.Infof(os.Stderr, "Skipping %s (file not found)", layout.EncryptedSecretsFile())
 			}
 
 			// Decrypt talosconfig.encrypted
 			if fileExists(encryptedTalosconfigFile) {
-				fmt.Fprintf(os.Stderr, "Decrypting talosconfig.encrypted -> talosconfig\n")
+				ui.Infof(os.Stderr, "Decrypting talosconfig.encrypted -> talosconfig")
 
 				if err := age.DecryptYAMLFile(Config.RootDir, "talosconfig.encrypted", "talosconfig"); err != nil {
 					return errors.Wrap(err, "failed to decrypt talosconfig")
@@ -584,12 +633,12 @@ var initCmd = &cobra.Command{
 
 				decryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping talosconfig.encrypted (file not found)\n")
+				ui.Infof(os.Stderr, "Skipping talosconfig.encrypted (file not found)")
 			}
 
 			// Decrypt kubeconfig.encrypted
 			if fileExists(encryptedKubeconfigFile) {
-				fmt.Fprintf(os.Stderr, "Decrypting %s.encrypted -> %s\n", kubeconfigPath, kubeconfigPath)
+				ui.Infof(os.Stderr, "Decrypting %s.encrypted -> %s", kubeconfigPath, kubeconfigPath)
 
 				if err := age.DecryptYAMLFile(Config.RootDir, kubeconfigPath+".encrypted", kubeconfigPath); err != nil {
 					return errors.Wrap(err, "failed to decrypt kubeconfig")
@@ -597,21 +646,21 @@ var initCmd = &cobra.Command{
 
 				decryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping %s.encrypted (file not found)\n", kubeconfigPath)
+				ui.Infof(os.Stderr, "Skipping %s.encrypted (file not found)", kubeconfigPath)
 			}
 
 			// Decrypt values-secret.encrypted.yaml
-			encryptedValuesSecretFile := filepath.Join(Config.RootDir, valuesSecretEncryptedYamlName)
+			encryptedValuesSecretFile := projectPath(layout.EncryptedValuesSecretFile())
 			if fileExists(encryptedValuesSecretFile) {
-				fmt.Fprintf(os.Stderr, "Decrypting %s -> %s\n", valuesSecretEncryptedYamlName, valuesSecretYamlName)
+				ui.Infof(os.Stderr, "Decrypting %s -> %s", layout.EncryptedValuesSecretFile(), layout.ValuesSecretFile())
 
-				if err := age.DecryptYAMLFile(Config.RootDir, valuesSecretEncryptedYamlName, valuesSecretYamlName); err != nil {
+				if err := age.DecryptYAMLFile(Config.RootDir, layout.EncryptedValuesSecretFile(), layout.ValuesSecretFile()); err != nil {
 					return errors.Wrap(err, "failed to decrypt values-secret.yaml")
 				}
 
 				decryptedCount++
 			} else {
-				fmt.Fprintf(os.Stderr, "Skipping %s (file not found)\n", valuesSecretEncryptedYamlName)
+				ui.Infof(os.Stderr, "Skipping %s (file not found)", layout.EncryptedValuesSecretFile())
 			}
 
 			// Update .gitignore file
@@ -620,9 +669,9 @@ var initCmd = &cobra.Command{
 			}
 
 			if decryptedCount > 0 {
-				fmt.Fprintf(os.Stderr, "Decryption completed successfully. %d file(s) decrypted.\n", decryptedCount)
+				ui.Successf(os.Stderr, "Decryption completed successfully. %d file(s) decrypted.", decryptedCount)
 			} else {
-				fmt.Fprintf(os.Stderr, "No files to decrypt.\n")
+				ui.Infof(os.Stderr, "No files to decrypt.")
 			}
 
 			return nil
@@ -770,11 +819,19 @@ var initCmd = &cobra.Command{
 		// here (malformed --cluster-endpoint) short-circuits before
 		// any files are written so the project tree never lands in
 		// a half-initialised state.
-		clusterEndpoint, err := resolveClusterEndpoint(initCmdFlags.clusterEndpoint, GlobalArgs.Endpoints)
+		// A floating IP is the endpoint the nodes share, so it wins
+		// over deriving one from a single --endpoints entry.
+		clusterEndpoint, err := resolveClusterEndpoint(cmp.Or(initCmdFlags.clusterEndpoint, floatingIPEndpoint(initCmdFlags.floatingIP)), GlobalArgs.Endpoints)
 		if err != nil {
 			return err
 		}
 
+		// Check the network flags against the preset values.yaml
+		// before any file is written, as --image is above.
+		if _, err := applyInitNetworkOverrides([]byte(presetFiles[initCmdFlags.preset+"/"+valuesYamlName])); err != nil {
+			return err
+		}
+
 		for path, content := range presetFiles {
 			parts := strings.SplitN(path, "/", 2)
 			chartName := parts[0]
@@ -794,6 +851,11 @@ var initCmd = &cobra.Command{
 
 					rendered = applyEndpointOverride(rendered, clusterEndpoint)
 
+					rendered, err = applyInitNetworkOverrides(rendered)
+					if err != nil {
+						return err
+					}
+
 					err = writeToDestination(rendered, file, presetFileMode)
 				default:
 					err = writeToDestination([]byte(content), file, presetFileMode)
@@ -823,8 +885,13 @@ var initCmd = &cobra.Command{
 		// CheckPresetDrift. The baseline is the pristine embedded preset
 		// hash; templates/ is operator-editable and never consulted, so
 		// the check stays false-positive-free.
-		if err := WritePresetLock(Config.RootDir, initCmdFlags.preset); err != nil {
-			return err
+		//
+		// An archetype that carries the lock of the project it was
+		// exported from keeps it: its templates derive from that preset.
+		if !initCmdFlags.fromArchetype || !fileExists(filepath.Join(Config.RootDir, presetLockName)) {
+			if err := WritePresetLock(Config.RootDir, initCmdFlags.preset); err != nil {
+				return err
+			}
 		}
 
 		// Print warning about secrets and key backup (only once, at the end, if key was created)
@@ -851,7 +918,7 @@ func writeSecretsBundleToFile(bundle *secrets.Bundle) error {
 		return errors.Wrap(err, "marshalling secrets bundle")
 	}
 
-	secretsFile := filepath.Join(Config.RootDir, secretsYamlName)
+	secretsFile := projectPath(secretsLayout().SecretsFile())
 	// validateFileExists is invoked inside writeSecureToDestination;
 	// no need to duplicate the --force / existing-file gate here.
 	return writeSecureToDestination(bundleBytes, secretsFile)
@@ -908,7 +975,7 @@ func pruneVendoredTalmLibrary(presetFiles map[string]string) error {
 			return errors.Wrapf(err, "pruning extraneous vendored file %q", filePath)
 		}
 
-		fmt.Fprintf(os.Stderr, "%s %s\n", reportVerbRemoved, filepath.Join("charts", "talm", filepath.FromSlash(filePath)))
+		ui.Infof(os.Stderr, "%s %s", reportVerbRemoved, filepath.Join("charts", "talm", filepath.FromSlash(filePath)))
 
 		return nil
 	})
@@ -925,7 +992,7 @@ func pruneVendoredTalmLibrary(presetFiles map[string]string) error {
 
 	for _, dir := range seenDirs {
 		if err := root.Remove(dir); err == nil {
-			fmt.Fprintf(os.Stderr, "%s %s\n", reportVerbRemoved, filepath.Join("charts", "talm", filepath.FromSlash(dir)))
+			ui.Infof(os.Stderr, "%s %s", reportVerbRemoved, filepath.Join("charts", "talm", filepath.FromSlash(dir)))
 		}
 	}
 
@@ -1276,7 +1343,7 @@ func askUserOverwrite(filePath string, policy overwritePolicy) (bool, error) {
 
 	switch policy {
 	case overwritePolicyForce:
-		fmt.Fprintf(os.Stderr, "Overwriting %s (--force)\n", relPath)
+		ui.Infof(os.Stderr, "Overwriting %s (--force)", relPath)
 
 		return true, nil
 	case overwritePolicyNonInteractive:
@@ -1350,7 +1417,7 @@ func createReportedFile(filePath string, newContent []byte, permissions os.FileM
 		relPath = filePath
 	}
 
-	fmt.Fprintf(os.Stderr, "%s %s\n", reportVerbCreated, relPath)
+	ui.Infof(os.Stderr, "%s %s", reportVerbCreated, relPath)
 
 	return nil
 }
@@ -1379,7 +1446,7 @@ func updateFileWithConfirmation(filePath string, newContent []byte, permissions
 	}
 
 	if !overwrite {
-		fmt.Fprintf(os.Stderr, "Skipping %s\n", filePath)
+		ui.Infof(os.Stderr, "Skipping %s", filePath)
 
 		return nil
 	}
@@ -1400,7 +1467,7 @@ func updateFileWithConfirmation(filePath string, newContent []byte, permissions
 		relPath = filePath
 	}
 
-	fmt.Fprintf(os.Stderr, "%s %s\n", reportVerbUpdated, relPath)
+	ui.Infof(os.Stderr, "%s %s", reportVerbUpdated, relPath)
 
 	return nil
 }
@@ -1483,7 +1550,7 @@ func updateTalmLibraryChart() error {
 	}
 
 	// Step 1: Update talm library chart files (without interactive confirmation)
-	fmt.Fprintf(os.Stderr, "Updating talm library chart...\n")
+	ui.Infof(os.Stderr, "Updating talm library chart...")
 
 	for path, content := range presetFiles {
 		parts := strings.SplitN(path, "/", 2)
@@ -1513,7 +1580,7 @@ func updateTalmLibraryChart() error {
 			}
 
 			relPath, _ := filepath.Rel(Config.RootDir, file)
-			fmt.Fprintf(os.Stderr, "%s %s\n", reportVerbUpdated, relPath)
+			ui.Infof(os.Stderr, "%s %s", reportVerbUpdated, relPath)
 		}
 	}
 
@@ -1528,7 +1595,7 @@ func updateTalmLibraryChart() error {
 
 	// Step 2: Update preset template files (with interactive confirmation)
 	if presetName != "" {
-		fmt.Fprintf(os.Stderr, "Updating preset templates...\n")
+		ui.Infof(os.Stderr, "Updating preset templates...")
 
 		for path, content := range presetFiles {
 			parts := strings.SplitN(path, "/", 2)
@@ -1584,6 +1651,9 @@ func init() {
 	initCmd.Flags().StringVarP(&initCmdFlags.name, "name", "N", "", "cluster name (not required with --encrypt, --decrypt, or --update)")
 	initCmd.Flags().StringVar(&initCmdFlags.image, "image", "", "override the Talos installer image written to the preset's values.yaml (e.g. factory.talos.dev/installer/<sha256>:<version>)")
 	initCmd.Flags().StringVar(&initCmdFlags.clusterEndpoint, "cluster-endpoint", "", "Kubernetes control-plane URL written to values.yaml::endpoint (e.g. https://10.0.0.1:6443 or https://vip.example.test:6443). Takes precedence over the single-endpoint auto-derive heuristic; required for multi-control-plane setups where the operator picks a VIP or load balancer.")
+	initCmd.Flags().StringVar(&initCmdFlags.floatingIP, initFloatingIPFlag, "", "layer-2 VIP the control-plane nodes share, written to values.yaml::floatingIP; also the host of the cluster endpoint unless --cluster-endpoint is set")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.podSubnets, initPodSubnetsFlag, nil, "pod network CIDRs written to values.yaml::podSubnets, comma-separated (default: the preset's)")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.serviceSubnets, initServiceSubnetsFlag, nil, "service network CIDRs written to values.yaml::serviceSubnets, comma-separated (default: the preset's)")
 	initCmd.Flags().BoolVar(&initCmdFlags.force, "force", false, "overwrite existing files; on --update also auto-accepts every preset-template diff without the interactive prompt")
 	initCmd.Flags().BoolVarP(&initCmdFlags.update, "update", "u", false, "update Talm library chart")
 	// Override persistent -e flag for init command to use for encrypt
@@ -1591,6 +1661,10 @@ func init() {
 	initCmd.Flags().StringSliceVarP(&GlobalArgs.Endpoints, "endpoints", "", []string{}, "override default endpoints in Talos configuration")
 	initCmd.Flags().BoolVarP(&initCmdFlags.encrypt, "encrypt", "e", false, "encrypt all sensitive files (secrets.yaml, talosconfig, kubeconfig, values-secret.yaml)")
 	initCmd.Flags().BoolVarP(&initCmdFlags.decrypt, "decrypt", "d", false, "decrypt all encrypted files (does not require preset)")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.talosCA, initTalosCAFlag, nil, "certificate and key files of an externally issued Talos API CA to use instead of a generated one, as ca.crt,ca.key")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.kubernetesCA, initKubernetesCAFlag, nil, "certificate and key files of an externally issued Kubernetes CA to use instead of a generated one, as ca.crt,ca.key")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.etcdCA, initEtcdCAFlag, nil, "certificate and key files of an externally issued etcd CA to use instead of a generated one, as ca.crt,ca.key")
+	initCmd.Flags().BoolVar(&initCmdFlags.migrateSecrets, "migrate-secrets", false, "move talm.key, secrets.yaml and values-secret.yaml (plain and encrypted) to the paths set in Chart.yaml globalOptions (does not require preset)")
 
 	// Shell completion for `talm init --preset`: preset names are
 	// baked in at build time via pkg/generated.
@@ -1618,30 +1692,28 @@ func validateFileExists(file string) error {
 	return nil
 }
 
-// gitignoreEntryCount is the size of the requiredEntries slice in
-// writeGitignoreFile: four secret-bearing artefacts (secrets.yaml,
-// talosconfig, talm.key, values-secret.yaml) plus the kubeconfig base
-// name. Hoisting it into a const sidesteps mnd's magic-number lint
-// without inlining the comment at every call site.
-const gitignoreEntryCount = 5
-
 //nolint:funlen // wrapping the secrets-list assembly in helpers buys nothing in clarity
 func writeGitignoreFile() error {
-	// Capacity gitignoreEntryCount: four secret-bearing artefacts
-	// (secrets.yaml, talosconfig, talm.key, values-secret.yaml) plus the
-	// kubeconfig base name appended just below. Preallocating avoids the
-	// slice growth prealloc flags.
-	requiredEntries := make([]string, 0, gitignoreEntryCount)
-	requiredEntries = append(requiredEntries, secretsYamlName, talosconfigName, talmKeyName, valuesSecretYamlName)
-
-	// Add kubeconfig to required entries (use path from config or default)
-	kubeconfigPath := Config.GlobalOptions.Kubeconfig
-	if kubeconfigPath == "" {
-		kubeconfigPath = defaultKubeconfigName
-	}
-	// Only add base name (not full path) to gitignore
-	kubeconfigBase := filepath.Base(kubeconfigPath)
-	requiredEntries = append(requiredEntries, kubeconfigBase)
+	// The entries come from the secret files registry, so a secret
+	// file talm learns about is ignored by every project on its next
+	// write. The secrets files follow the project's secrets layout; a
+	// moved file (secrets/cluster.yaml) is ignored by its
+	// project-relative path.
+	secretFiles := projectSecretFiles(secretsLayout(), Config.GlobalOptions.Talosconfig, Config.GlobalOptions.Kubeconfig)
+
+	// Files the talm git filter encrypts on commit are meant to be
+	// tracked; ignoring them would undo `talm git-filter install`.
+	covered := gitFilterCovered(Config.RootDir)
+
+	requiredEntries := make([]string, 0, len(secretFiles))
+
+	for _, file := range secretFiles {
+		if file.plain != "" && slices.Contains(covered, file.plain) {
+			continue
+		}
+
+		requiredEntries = append(requiredEntries, file.ignore)
+	}
 
 	gitignoreFile := filepath.Join(Config.RootDir, ".gitignore")
 
@@ -1658,8 +1730,10 @@ func writeGitignoreFile() error {
 		existingStr = "# Sensitive files\n"
 	}
 
-	// Check which entries are missing
+	// Check which entries are missing. Appended entries use the line
+	// ending of the existing file, so a CRLF .gitignore stays CRLF.
 	needsUpdate := false
+	eol := lineEnding(existingStr)
 
 	for _, entry := range requiredEntries {
 		// Check if entry exists (as whole line or with comment)
@@ -1678,10 +1752,10 @@ func writeGitignoreFile() error {
 
 		if !found {
 			if !strings.HasSuffix(existingStr, "\n") {
-				existingStr += "\n"
+				existingStr += eol
 			}
 
-			existingStr += entry + "\n"
+			existingStr += entry + eol
 			needsUpdate = true
 		}
 	}
@@ -1717,7 +1791,7 @@ func writeGitignoreFile() error {
 	// who clones the project — 0o644 is the standard, not a leak.
 	err := os.WriteFile(gitignoreFile, []byte(existingStr), presetFileMode) //nolint:gosec // .gitignore is world-readable by design
 	if err == nil {
-		fmt.Fprintf(os.Stderr, "%s %s\n", gitignoreReportVerb(statErrBefore), gitignoreFile)
+		ui.Infof(os.Stderr, "%s %s", gitignoreReportVerb(statErrBefore), gitignoreFile)
 	}
 
 	return errors.Wrap(err, "writing .gitignore")
@@ -1774,37 +1848,40 @@ func printClusterEndpointHintIfEmpty(valuesPath string) {
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "\nNext: set values.yaml::endpoint to your cluster's control-plane URL (e.g. `https://<vip>:6443` for a VIP setup, or `https://<LB-host>:6443` for an external load balancer).\nThe chart leaves this empty by design — `--endpoints` populates talosconfig (talosctl client routing), not the kube-apiserver URL nodes dial. Use `--cluster-endpoint` on `talm init` to set both in one go.\n")
+	fmt.Fprintf(ui.Progress(os.Stderr), "\nNext: set values.yaml::endpoint to your cluster's control-plane URL (e.g. `https://<vip>:6443` for a VIP setup, or `https://<LB-host>:6443` for an external load balancer).\nThe chart leaves this empty by design — `--endpoints` populates talosconfig (talosctl client routing), not the kube-apiserver URL nodes dial. Use `--cluster-endpoint` on `talm init` to set both in one go.\n")
 }
 
 func printSecretsWarning() {
-	keyFile := filepath.Join(Config.RootDir, talmKeyName)
+	keyName := secretsLayout().KeyFile()
+	keyFile := projectPath(keyName)
 	keyFileExists := fileExists(keyFile)
 
 	if !keyFileExists {
 		return // No key file, no warning needed
 	}
 
-	fmt.Fprintf(os.Stderr, "\n")
-	fmt.Fprintf(os.Stderr, "┌──────────────────────────────────────────────────────────────────────────────┐\n")
-	fmt.Fprintf(os.Stderr, "│  Security Information                                                        │\n")
-	fmt.Fprintf(os.Stderr, "├──────────────────────────────────────────────────────────────────────────────┤\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "│  Sensitive files (secrets.yaml, talosconfig, talm.key, kubeconfig,           │\n")
-	fmt.Fprintf(os.Stderr, "│  values-secret.yaml) have been added to .gitignore and won't be tracked.     │\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "│  Important: Please make a backup of your talm.key file.                      │\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "│  The talm.key file is required to decrypt secrets.encrypted.yaml. Without it,│\n")
-	fmt.Fprintf(os.Stderr, "│  you won't be able to decrypt your encrypted secrets.                        │\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "│  Key location: talm.key                                                      |\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "│  Recommended: Store the backup in a secure location (password manager,       │\n")
-	fmt.Fprintf(os.Stderr, "│  encrypted storage, or other secure backup solution).                        │\n")
-	fmt.Fprintf(os.Stderr, "│                                                                              │\n")
-	fmt.Fprintf(os.Stderr, "└──────────────────────────────────────────────────────────────────────────────┘\n")
-	fmt.Fprintf(os.Stderr, "\n")
+	w := ui.Progress(os.Stderr)
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "┌──────────────────────────────────────────────────────────────────────────────┐\n")
+	fmt.Fprintf(w, "│  Security Information                                                        │\n")
+	fmt.Fprintf(w, "├──────────────────────────────────────────────────────────────────────────────┤\n")
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "│  Sensitive files (secrets.yaml, talosconfig, talm.key, kubeconfig,           │\n")
+	fmt.Fprintf(w, "│  values-secret.yaml) have been added to .gitignore and won't be tracked.     │\n")
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "│  Important: Please make a backup of your talm.key file.                      │\n")
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "│  The talm.key file is required to decrypt secrets.encrypted.yaml. Without it,│\n")
+	fmt.Fprintf(w, "│  you won't be able to decrypt your encrypted secrets.                        │\n")
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "│  Key location: %-62s│\n", keyName)
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "│  Recommended: Store the backup in a secure location (password manager,       │\n")
+	fmt.Fprintf(w, "│  encrypted storage, or other secure backup solution).                        │\n")
+	fmt.Fprintf(w, "│                                                                              │\n")
+	fmt.Fprintf(w, "└──────────────────────────────────────────────────────────────────────────────┘\n")
+	fmt.Fprintf(w, "\n")
 }
 
 // handleTalosconfigEncryption handles encryption/decryption logic for
@@ -1825,7 +1902,7 @@ func handleTalosconfigEncryption(requireKeyForDecrypt bool) (bool, error) {
 	encryptedTalosconfigFile := filepath.Join(Config.RootDir, "talosconfig.encrypted")
 	talosconfigFileExists := fileExists(talosconfigFile)
 	encryptedTalosconfigFileExists := fileExists(encryptedTalosconfigFile)
-	keyFile := filepath.Join(Config.RootDir, talmKeyName)
+	keyFile := projectPath(secretsLayout().KeyFile())
 	keyFileExists := fileExists(keyFile)
 	keyWasCreated := false
 
@@ -1843,7 +1920,7 @@ func handleTalosconfigEncryption(requireKeyForDecrypt bool) (bool, error) {
 			return false, nil
 		}
 
-		fmt.Fprintf(os.Stderr, "Decrypting talosconfig.encrypted -> talosconfig\n")
+		ui.Infof(os.Stderr, "Decrypting talosconfig.encrypted -> talosconfig")
 
 		if err := age.DecryptYAMLFile(Config.RootDir, "talosconfig.encrypted", "talosconfig"); err != nil {
 			return false, errors.Wrap(err, "failed to decrypt talosconfig")
@@ -1863,12 +1940,12 @@ func handleTalosconfigEncryption(requireKeyForDecrypt bool) (bool, error) {
 
 			keyWasCreated = keyCreated
 			if keyCreated {
-				fmt.Fprintf(os.Stderr, "Generated new encryption key: talm.key\n")
+				ui.Infof(os.Stderr, "Generated new encryption key: %s", secretsLayout().KeyFile())
 			}
 		}
 
 		// Encrypt talosconfig
-		fmt.Fprintf(os.Stderr, "Encrypting talosconfig -> talosconfig.encrypted\n")
+		ui.Infof(os.Stderr, "Encrypting talosconfig -> talosconfig.encrypted")
 
 		if err := age.EncryptYAMLFile(Config.RootDir, "talosconfig", "talosconfig.encrypted"); err != nil {
 			return false, errors.Wrap(err, "failed to encrypt talosconfig")
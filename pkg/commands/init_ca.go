@@ -0,0 +1,182 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"crypto"
+	stdx509 "crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/crypto/x509"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+
+	"github.com/cozystack/talm/pkg/certexpiry"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// Flags of `talm init` that seed the secrets bundle with an externally
+// issued CA instead of a generated one.
+const (
+	initTalosCAFlag      = "with-talos-ca"
+	initKubernetesCAFlag = "with-k8s-ca"
+	initEtcdCAFlag       = "with-etcd-ca"
+)
+
+// externalCA is one --with-*-ca flag: the CA it replaces in the bundle
+// and the certificate and key files it was given.
+type externalCA struct {
+	flag  string
+	name  string
+	files []string
+	dst   **x509.PEMEncodedCertificateAndKey
+}
+
+// initExternalCAs lists the --with-*-ca flags of init against the CAs
+// of bundle they replace.
+func initExternalCAs(bundle *secrets.Bundle) []externalCA {
+	return []externalCA{
+		{initTalosCAFlag, "Talos API CA", initCmdFlags.talosCA, &bundle.Certs.OS},
+		{initKubernetesCAFlag, "Kubernetes CA", initCmdFlags.kubernetesCA, &bundle.Certs.K8s},
+		{initEtcdCAFlag, "etcd CA", initCmdFlags.etcdCA, &bundle.Certs.Etcd},
+	}
+}
+
+// hasExternalCAs reports whether any --with-*-ca flag is set.
+func hasExternalCAs() bool {
+	return len(initCmdFlags.talosCA) > 0 || len(initCmdFlags.kubernetesCA) > 0 || len(initCmdFlags.etcdCA) > 0
+}
+
+// applyExternalCAs replaces the CAs of a freshly generated bundle with
+// the ones the --with-*-ca flags name, after checking each. Warnings
+// about CAs close to expiry go to w.
+func applyExternalCAs(bundle *secrets.Bundle, now time.Time, w io.Writer) error {
+	for _, ca := range initExternalCAs(bundle) {
+		if len(ca.files) == 0 {
+			continue
+		}
+
+		pair, err := loadExternalCA(ca.flag, ca.files, now)
+		if err != nil {
+			return err
+		}
+
+		if certexpiry.DaysLeft(pair.notAfter, now) < defaultSecretsWarnDays {
+			ui.Warnf(w, "the %s from --%s expires on %s; plan a `talm %s` before it does", ca.name, ca.flag, pair.notAfter.Format(time.DateOnly), rotateCACmdName)
+		}
+
+		*ca.dst = pair.pem
+	}
+
+	return nil
+}
+
+// loadedCA is a checked certificate and key pair.
+type loadedCA struct {
+	pem      *x509.PEMEncodedCertificateAndKey
+	notAfter time.Time
+}
+
+// loadExternalCA reads the certificate and key files of a --with-*-ca
+// flag and checks that they make a CA usable now: a CA certificate that
+// is valid at now, and the key of that certificate.
+func loadExternalCA(flag string, files []string, now time.Time) (loadedCA, error) {
+	if len(files) != 2 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return loadedCA{}, errors.WithHintf(
+			errors.Newf("--%s takes the certificate and the key of the CA, got %d file(s)", flag, len(files)),
+			"pass them as --%s ca.crt,ca.key", flag,
+		)
+	}
+
+	crtPEM, err := os.ReadFile(files[0])
+	if err != nil {
+		return loadedCA{}, errors.Wrapf(err, "--%s: reading the certificate", flag)
+	}
+
+	keyPEM, err := os.ReadFile(files[1])
+	if err != nil {
+		return loadedCA{}, errors.Wrapf(err, "--%s: reading the key", flag)
+	}
+
+	crt, err := parseCACertificate(crtPEM)
+	if err != nil {
+		return loadedCA{}, errors.Wrapf(err, "--%s: %s", flag, files[0])
+	}
+
+	switch {
+	case now.Before(crt.NotBefore):
+		return loadedCA{}, errors.Newf("--%s: %s is not valid until %s", flag, files[0], crt.NotBefore.Format(time.RFC3339))
+	case now.After(crt.NotAfter):
+		return loadedCA{}, errors.Newf("--%s: %s expired on %s", flag, files[0], crt.NotAfter.Format(time.RFC3339))
+	}
+
+	pair := &x509.PEMEncodedCertificateAndKey{Crt: crtPEM, Key: keyPEM}
+
+	key, err := pair.GetKey()
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return loadedCA{}, errors.WithHint(
+			errors.Wrapf(err, "--%s: %s", flag, files[1]),
+			"pass an unencrypted PEM key: PKCS#8, PKCS#1 RSA or SEC 1 EC",
+		)
+	}
+
+	if !keyMatchesCertificate(key, crt) {
+		return loadedCA{}, errors.Newf("--%s: %s is not the key of %s", flag, files[1], files[0])
+	}
+
+	return loadedCA{pem: pair, notAfter: crt.NotAfter}, nil
+}
+
+// parseCACertificate parses the first certificate of data and checks it
+// may sign certificates.
+func parseCACertificate(data []byte) (*stdx509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate")
+	}
+
+	crt, err := stdx509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the certificate")
+	}
+
+	if !crt.BasicConstraintsValid || !crt.IsCA {
+		return nil, errors.Newf("%s is not a CA certificate", crt.Subject)
+	}
+
+	if crt.KeyUsage != 0 && crt.KeyUsage&stdx509.KeyUsageCertSign == 0 {
+		return nil, errors.Newf("%s may not sign certificates: its key usage lacks certSign", crt.Subject)
+	}
+
+	return crt, nil
+}
+
+// keyMatchesCertificate reports whether key is the private key of the
+// public key crt certifies.
+func keyMatchesCertificate(key any, crt *stdx509.Certificate) bool {
+	private, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+
+	public, ok := private.Public().(interface{ Equal(x crypto.PublicKey) bool })
+
+	return ok && public.Equal(crt.PublicKey)
+}
@@ -0,0 +1,252 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// testCA describes a certificate writeTestCA issues.
+type testCA struct {
+	isCA      bool
+	notBefore time.Time
+	notAfter  time.Time
+	ed25519   bool
+}
+
+// writeTestCA writes a self-signed certificate and its PKCS#8 key to
+// dir and returns their paths.
+func writeTestCA(t *testing.T, dir, name string, ca testCA) (string, string) {
+	t.Helper()
+
+	var (
+		public  crypto.PublicKey
+		private crypto.Signer
+	)
+
+	if ca.ed25519 {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, private = pub, priv
+	} else {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, private = &priv.PublicKey, priv
+	}
+
+	template := &stdx509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             ca.notBefore,
+		NotAfter:              ca.notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca.isCA,
+		KeyUsage:              stdx509.KeyUsageDigitalSignature,
+	}
+	if ca.isCA {
+		template.KeyUsage |= stdx509.KeyUsageCertSign
+	}
+
+	der, err := stdx509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := stdx509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt := filepath.Join(dir, name+".crt")
+	key := filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return crt, key
+}
+
+func TestLoadExternalCA(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	valid := testCA{isCA: true, notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(10, 0, 0)}
+	dir := t.TempDir()
+
+	for _, ca := range []testCA{valid, {isCA: true, ed25519: true, notBefore: valid.notBefore, notAfter: valid.notAfter}} {
+		crt, key := writeTestCA(t, dir, "good", ca)
+
+		got, err := loadExternalCA(initTalosCAFlag, []string{crt, key}, now)
+		if err != nil {
+			t.Fatalf("ed25519=%v: %v", ca.ed25519, err)
+		}
+
+		if !got.notAfter.Equal(valid.notAfter) {
+			t.Errorf("notAfter = %s, want %s", got.notAfter, valid.notAfter)
+		}
+
+		if _, err := got.pem.GetCert(); err != nil {
+			t.Errorf("the loaded pair must hold the certificate: %v", err)
+		}
+	}
+}
+
+func TestLoadExternalCA_Invalid(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	goodCrt, goodKey := writeTestCA(t, dir, "good", testCA{isCA: true, notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(10, 0, 0)})
+	_, otherKey := writeTestCA(t, dir, "other", testCA{isCA: true, notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(10, 0, 0)})
+	expiredCrt, expiredKey := writeTestCA(t, dir, "expired", testCA{isCA: true, notBefore: now.AddDate(-2, 0, 0), notAfter: now.AddDate(-1, 0, 0)})
+	futureCrt, futureKey := writeTestCA(t, dir, "future", testCA{isCA: true, notBefore: now.AddDate(0, 1, 0), notAfter: now.AddDate(10, 0, 0)})
+	leafCrt, leafKey := writeTestCA(t, dir, "leaf", testCA{notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(10, 0, 0)})
+
+	for _, tc := range []struct {
+		name  string
+		files []string
+		wants string
+	}{
+		{"one file", []string{goodCrt}, "got 1 file(s)"},
+		{"missing key", []string{goodCrt, filepath.Join(dir, "missing.key")}, "reading the key"},
+		{"key of another CA", []string{goodCrt, otherKey}, "other.key is not the key of"},
+		{"expired", []string{expiredCrt, expiredKey}, "expired on 2025-06-01"},
+		{"not yet valid", []string{futureCrt, futureKey}, "is not valid until 2026-07-01"},
+		{"not a CA", []string{leafCrt, leafKey}, "CN=leaf is not a CA certificate"},
+		{"key as certificate", []string{goodKey, goodKey}, "no PEM certificate"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadExternalCA(initKubernetesCAFlag, tc.files, now)
+			if err == nil || !strings.Contains(err.Error(), tc.wants) {
+				t.Fatalf("err = %v, want it to mention %q", err, tc.wants)
+			}
+
+			if !strings.Contains(err.Error(), "--"+initKubernetesCAFlag) {
+				t.Errorf("the error must name the flag, got %v", err)
+			}
+		})
+	}
+
+	_, err := loadExternalCA(initKubernetesCAFlag, []string{goodCrt}, now)
+	if hint := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hint, "ca.crt,ca.key") {
+		t.Errorf("a wrong file count must show the expected form, got hint %q", hint)
+	}
+}
+
+// TestApplyExternalCAs pins that only the CAs with a flag are replaced,
+// and that a CA close to expiry is seeded with a warning.
+func TestApplyExternalCAs(t *testing.T) {
+	talosCA, kubernetesCA, etcdCA := initCmdFlags.talosCA, initCmdFlags.kubernetesCA, initCmdFlags.etcdCA
+	t.Cleanup(func() {
+		initCmdFlags.talosCA, initCmdFlags.kubernetesCA, initCmdFlags.etcdCA = talosCA, kubernetesCA, etcdCA
+	})
+
+	now := time.Now()
+	dir := t.TempDir()
+
+	crt, key := writeTestCA(t, dir, "talos", testCA{isCA: true, notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(0, 0, 30)})
+
+	initCmdFlags.talosCA = []string{crt, key}
+	initCmdFlags.kubernetesCA = nil
+	initCmdFlags.etcdCA = nil
+
+	if !hasExternalCAs() {
+		t.Fatal("--with-talos-ca must count as an external CA")
+	}
+
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(now), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generatedK8s := bundle.Certs.K8s
+
+	var warnings bytes.Buffer
+	if err := applyExternalCAs(bundle, now, &warnings); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bundle.Certs.OS.Crt, want) {
+		t.Error("the Talos API CA must be the one from --with-talos-ca")
+	}
+
+	if bundle.Certs.K8s != generatedK8s {
+		t.Error("a CA without a flag must stay generated")
+	}
+
+	if !strings.Contains(warnings.String(), "expires on") || !strings.Contains(warnings.String(), rotateCACmdName) {
+		t.Errorf("a CA expiring within %d days must be warned about, got %q", defaultSecretsWarnDays, warnings.String())
+	}
+}
+
+func TestInitPreRunRejectsExternalCAsWithExclusiveModes(t *testing.T) {
+	talosCA, encrypt, migrate := initCmdFlags.talosCA, initCmdFlags.encrypt, initCmdFlags.migrateSecrets
+	t.Cleanup(func() {
+		initCmdFlags.talosCA, initCmdFlags.encrypt, initCmdFlags.migrateSecrets = talosCA, encrypt, migrate
+	})
+
+	for name, set := range map[string]func(){
+		"encrypt":         func() { initCmdFlags.encrypt = true },
+		"migrate-secrets": func() { initCmdFlags.migrateSecrets = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			initCmdFlags.talosCA = []string{"ca.crt", "ca.key"}
+			initCmdFlags.encrypt = false
+			initCmdFlags.migrateSecrets = false
+			set()
+
+			err := initCmd.PreRunE(initCmd, nil)
+			if err == nil || !strings.Contains(err.Error(), "--with-talos-ca") {
+				t.Errorf("expected --with-talos-ca with --%s to be refused, got %v", name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Flags of `talm init` that fill the cluster network of the preset
+// values.yaml, so a project is scaffolded without editing it.
+const (
+	initFloatingIPFlag     = "floating-ip"
+	initPodSubnetsFlag     = "pod-subnets"
+	initServiceSubnetsFlag = "service-subnets"
+)
+
+// Top-level values.yaml keys the network flags of init rewrite.
+const (
+	valuesKeyFloatingIP     = "floatingIP"
+	valuesKeyPodSubnets     = "podSubnets"
+	valuesKeyServiceSubnets = "serviceSubnets"
+)
+
+// floatingIPLineRe matches the top-level `floatingIP:` line of a
+// preset values.yaml, in the shape of endpointLineRe.
+var floatingIPLineRe = regexp.MustCompile(`(?m)^floatingIP:(\s|$).*$`)
+
+// hasInitNetworkFlags reports whether any network flag of init is set.
+func hasInitNetworkFlags() bool {
+	return initCmdFlags.floatingIP != "" || len(initCmdFlags.podSubnets) > 0 || len(initCmdFlags.serviceSubnets) > 0
+}
+
+// validateInitNetworkFlags checks the network flags of init before any
+// file is written: the floating IP is an address, the subnets are
+// CIDRs, and a floating IP agrees with a --cluster-endpoint whose host
+// is an address, as the presets require.
+func validateInitNetworkFlags(floatingIP, clusterEndpoint string, podSubnets, serviceSubnets []string) error {
+	if floatingIP != "" {
+		addr, err := netip.ParseAddr(floatingIP)
+		if err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Wrapf(err, "--%s %q is not an IP address", initFloatingIPFlag, floatingIP),
+				"pass the bare address the nodes share, e.g. 192.0.2.5, without a prefix length",
+			)
+		}
+
+		if host := endpointAddr(clusterEndpoint); host.IsValid() && host != addr {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("--%s %s differs from the host of --cluster-endpoint %s", initFloatingIPFlag, floatingIP, clusterEndpoint),
+				"the control-plane endpoint must point at the floating IP the nodes claim; drop --cluster-endpoint to derive it from --floating-ip",
+			)
+		}
+	}
+
+	for _, list := range []struct {
+		flag    string
+		subnets []string
+	}{{initPodSubnetsFlag, podSubnets}, {initServiceSubnetsFlag, serviceSubnets}} {
+		for _, subnet := range list.subnets {
+			if _, err := netip.ParsePrefix(subnet); err != nil {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHint(
+					errors.Wrapf(err, "--%s %q is not a CIDR", list.flag, subnet),
+					"pass subnets as address/prefix, e.g. 10.244.0.0/16, comma-separated for dual stack",
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// endpointAddr returns the host of the endpoint URL when it is an IP
+// address, and the zero address otherwise.
+func endpointAddr(endpoint string) netip.Addr {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	addr, err := netip.ParseAddr(parsed.Hostname())
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr
+}
+
+// floatingIPEndpoint is the control-plane endpoint of a cluster whose
+// nodes share floatingIP, or empty without one.
+func floatingIPEndpoint(floatingIP string) string {
+	if floatingIP == "" {
+		return ""
+	}
+
+	return "https://" + net.JoinHostPort(floatingIP, "6443")
+}
+
+// applyInitNetworkOverrides rewrites the values.yaml keys of the
+// network flags of init that are set. A preset that does not declare
+// a key a flag sets is an error, as for --image, so a flag is never
+// dropped silently.
+func applyInitNetworkOverrides(values []byte) ([]byte, error) {
+	if initCmdFlags.floatingIP != "" {
+		if !floatingIPLineRe.Match(values) {
+			return nil, missingValuesKeyError(initFloatingIPFlag, valuesKeyFloatingIP)
+		}
+
+		replacement := fmt.Appendf(nil, "%s: %q", valuesKeyFloatingIP, initCmdFlags.floatingIP)
+		values = floatingIPLineRe.ReplaceAllFunc(values, func([]byte) []byte {
+			return replacement
+		})
+	}
+
+	var err error
+
+	values, err = applyListOverride(values, initPodSubnetsFlag, valuesKeyPodSubnets, initCmdFlags.podSubnets)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyListOverride(values, initServiceSubnetsFlag, valuesKeyServiceSubnets, initCmdFlags.serviceSubnets)
+}
+
+// applyListOverride replaces the top-level list key of values, in
+// block or flow style, with a block list of items. No items leave
+// values unchanged.
+func applyListOverride(values []byte, flag, key string, items []string) ([]byte, error) {
+	if len(items) == 0 {
+		return values, nil
+	}
+
+	re := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(key) + `:[^\n]*\n(?:[ \t]*-[^\n]*(?:\n|$))*`)
+	if !re.Match(values) {
+		return nil, missingValuesKeyError(flag, key)
+	}
+
+	var replacement bytes.Buffer
+
+	replacement.WriteString(key + ":\n")
+
+	for _, item := range items {
+		fmt.Fprintf(&replacement, "- %s\n", item)
+	}
+
+	return re.ReplaceAllFunc(values, func([]byte) []byte {
+		return replacement.Bytes()
+	}), nil
+}
+
+// missingValuesKeyError is the error of a network flag whose key the
+// preset values.yaml does not declare.
+func missingValuesKeyError(flag, key string) error {
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("--%s was set but the preset values.yaml does not declare a top-level %s: field", flag, key),
+		"choose a preset that exposes %s, or omit --%s and set it in the chart yourself", key, flag,
+	)
+}
@@ -0,0 +1,169 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/generated"
+)
+
+const initNetworkValues = `endpoint: ""
+
+# Layer-2 VIP.
+floatingIP: ""
+vipLink: ""
+image: "ghcr.io/cozystack/cozystack/talos:v1.12.6"
+podSubnets:
+- 10.244.0.0/16
+serviceSubnets: [10.96.0.0/16]
+
+advertisedSubnets: []
+`
+
+func TestValidateInitNetworkFlags(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name                   string
+		floatingIP, endpoint   string
+		podSubnets, svcSubnets []string
+		wantErr                string
+	}{
+		{name: "none"},
+		{name: "valid", floatingIP: "192.0.2.5", endpoint: "https://192.0.2.5:6443", podSubnets: []string{"10.244.0.0/16", "fd00:10:244::/56"}, svcSubnets: []string{"10.96.0.0/16"}},
+		{name: "endpoint by name", floatingIP: "192.0.2.5", endpoint: "https://api.example.test:6443"},
+		{name: "floating IP with prefix", floatingIP: "192.0.2.5/24", wantErr: "--floating-ip"},
+		{name: "floating IP not the endpoint", floatingIP: "192.0.2.5", endpoint: "https://192.0.2.6:6443", wantErr: "differs"},
+		{name: "pod subnet", podSubnets: []string{"10.244.0.0"}, wantErr: "--pod-subnets"},
+		{name: "service subnet", svcSubnets: []string{"10.96.0.0/33"}, wantErr: "--service-subnets"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateInitNetworkFlags(tc.floatingIP, tc.endpoint, tc.podSubnets, tc.svcSubnets)
+
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("error = %v, want one mentioning %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestFloatingIPEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]string{
+		"":            "",
+		"192.0.2.5":   "https://192.0.2.5:6443",
+		"2001:db8::5": "https://[2001:db8::5]:6443",
+	} {
+		if got := floatingIPEndpoint(in); got != want {
+			t.Errorf("floatingIPEndpoint(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestApplyListOverride pins the rewrite of a top-level list, block or
+// flow style, leaving the keys around it as they were.
+func TestApplyListOverride(t *testing.T) {
+	t.Parallel()
+
+	got, err := applyListOverride([]byte(initNetworkValues), initPodSubnetsFlag, valuesKeyPodSubnets, []string{"10.1.0.0/16", "fd00:1::/56"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = applyListOverride(got, initServiceSubnetsFlag, valuesKeyServiceSubnets, []string{"10.2.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values struct {
+		Image             string   `yaml:"image"`
+		PodSubnets        []string `yaml:"podSubnets"`
+		ServiceSubnets    []string `yaml:"serviceSubnets"`
+		AdvertisedSubnets []string `yaml:"advertisedSubnets"`
+	}
+	if err := yaml.Unmarshal(got, &values); err != nil {
+		t.Fatalf("rewritten values do not parse: %v\n%s", err, got)
+	}
+
+	if strings.Join(values.PodSubnets, ",") != "10.1.0.0/16,fd00:1::/56" || strings.Join(values.ServiceSubnets, ",") != "10.2.0.0/16" {
+		t.Errorf("subnets = %v / %v:\n%s", values.PodSubnets, values.ServiceSubnets, got)
+	}
+
+	if values.Image == "" || values.AdvertisedSubnets == nil {
+		t.Errorf("the keys around the lists changed:\n%s", got)
+	}
+
+	if unchanged, err := applyListOverride([]byte(initNetworkValues), initPodSubnetsFlag, valuesKeyPodSubnets, nil); err != nil || string(unchanged) != initNetworkValues {
+		t.Errorf("no items must leave values unchanged, got err %v:\n%s", err, unchanged)
+	}
+
+	if _, err := applyListOverride([]byte("endpoint: \"\"\n"), initPodSubnetsFlag, valuesKeyPodSubnets, []string{"10.1.0.0/16"}); err == nil {
+		t.Error("expected an error for a preset without podSubnets")
+	}
+}
+
+// TestApplyInitNetworkOverrides runs the flags end to end, on the
+// shape of a preset values.yaml and on every embedded preset.
+//
+//nolint:paralleltest // mutates initCmdFlags.
+func TestApplyInitNetworkOverrides(t *testing.T) {
+	saved := initCmdFlags
+	t.Cleanup(func() { initCmdFlags = saved })
+
+	initCmdFlags.floatingIP = "192.0.2.5"
+	initCmdFlags.podSubnets = []string{"10.1.0.0/16"}
+	initCmdFlags.serviceSubnets = nil
+
+	got, err := applyInitNetworkOverrides([]byte(initNetworkValues))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"# Layer-2 VIP.\nfloatingIP: \"192.0.2.5\"\n", "podSubnets:\n- 10.1.0.0/16\nserviceSubnets: [10.96.0.0/16]\n"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("values lack %q:\n%s", want, got)
+		}
+	}
+
+	if _, err := applyInitNetworkOverrides([]byte("podSubnets: []\n")); err == nil {
+		t.Error("expected an error for a preset without floatingIP")
+	}
+
+	presetFiles, err := generated.PresetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range presetFiles {
+		preset, file, _ := strings.Cut(path, "/")
+		if file != valuesYamlName || preset == presetTalmLibrary {
+			continue
+		}
+
+		if _, err := applyInitNetworkOverrides([]byte(content)); err != nil {
+			t.Errorf("preset %s: %v", preset, err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// inventoryNode is one machine as an inventory source describes it,
+// keyed in values.yaml by its address.
+type inventoryNode struct {
+	Address    string
+	Hostname   string
+	Rack       string
+	Serial     string
+	Interfaces []inventoryInterface
+}
+
+// inventoryInterface is one network interface of an inventoryNode.
+// The `interface` key matches the merge key the values layers use for
+// `nodes.*.interfaces`.
+type inventoryInterface struct {
+	Name      string   `yaml:"interface"`
+	MAC       string   `yaml:"mac,omitempty"`
+	Addresses []string `yaml:"addresses,omitempty"`
+}
+
+// inventorySource reads the nodes of a hardware inventory that match
+// every filter, a key=value pair whose meaning is the source's own.
+type inventorySource interface {
+	Nodes(ctx context.Context, filters map[string]string) ([]inventoryNode, error)
+}
+
+// inventorySources maps the --source names to their constructors. A
+// new source registers here.
+//
+//nolint:gochecknoglobals // static registry of inventory sources.
+var inventorySources = map[string]func() (inventorySource, error){
+	inventorySourceCSV:    newCSVInventorySource,
+	inventorySourceNetBox: newNetBoxInventorySource,
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var inventoryImportCmdFlags struct {
+	source    string
+	filters   []string
+	file      string
+	csvFile   string
+	netboxURL string
+	dryRun    bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Import nodes from a hardware inventory into values.yaml",
+	Args:  cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var inventoryImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Create or update the nodes map of values.yaml from an inventory",
+	Long: `Read the machines of a hardware inventory and write them to the nodes map
+of values.yaml, keyed by address, so the CMDB stays the source of truth for
+hardware while talm renders from it.
+
+For every node, hostname, rack, serial and interfaces are set from the
+inventory. Other keys of an entry (labels, reset policies, maintenance windows)
+and nodes the inventory does not list are left alone, and comments are kept.
+
+Sources:
+  csv     a CSV file (--csv) with a header row. The address column is required;
+          hostname, rack, serial, interface, mac and ip are read when present.
+          A node with several interfaces takes one row per interface, and ip
+          holds the interface addresses separated by spaces. --filter matches
+          any column.
+  netbox  the NetBox API at --netbox-url (or NETBOX_URL), with the token in
+          NETBOX_TOKEN. --filter is passed as a query parameter of the device
+          list, so role=talos or site=ams1 work as in the NetBox UI. The node
+          address is the device's primary IP.`,
+	Example: `  talm inventory import --source csv --csv hosts.csv
+  talm inventory import --source netbox --filter role=talos --filter site=ams1
+  talm inventory import --source netbox --filter role=talos --dry-run`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runInventoryImport(cmd.Context(), cmd.OutOrStdout())
+	},
+}
+
+func runInventoryImport(ctx context.Context, out io.Writer) error {
+	newSource, ok := inventorySources[inventoryImportCmdFlags.source]
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("unknown inventory source %q", inventoryImportCmdFlags.source),
+			"use one of: %s", strings.Join(inventorySourceNames(), ", "),
+		)
+	}
+
+	filters, err := parseInventoryFilters(inventoryImportCmdFlags.filters)
+	if err != nil {
+		return err
+	}
+
+	source, err := newSource()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := source.Nodes(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	if len(nodes) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("the %s inventory has no nodes matching the filters", inventoryImportCmdFlags.source),
+			"check the --filter values; nothing was written",
+		)
+	}
+
+	file := valuesFilePath(inventoryImportCmdFlags.file)
+	if err := refuseEncryptedValuesFile(file); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	updated, err := mergeInventoryNodes(data, nodes)
+	if err != nil {
+		return errors.Wrapf(err, "updating %s", file)
+	}
+
+	if inventoryImportCmdFlags.dryRun {
+		_, err := out.Write(updated)
+
+		return errors.Wrap(err, "writing values")
+	}
+
+	if bytes.Equal(updated, data) {
+		ui.Infof(os.Stderr, "%s is up to date with the %s inventory (%d node(s))", file, inventoryImportCmdFlags.source, len(nodes))
+
+		return nil
+	}
+
+	mode := presetFileMode
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(file, updated, mode); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+
+	ui.Successf(os.Stderr, "Imported %d node(s) from the %s inventory into %s", len(nodes), inventoryImportCmdFlags.source, file)
+
+	return nil
+}
+
+// mergeInventoryNodes returns the values file data with every node's
+// inventory keys set under nodes.<address>. The rest of the document
+// is kept as it is, comments and line endings included.
+func mergeInventoryNodes(data []byte, nodes []inventoryNode) ([]byte, error) {
+	docs, err := decodeAllYAMLDocs(toLF(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing")
+	}
+
+	if len(docs) == 0 {
+		docs = []*yaml.Node{{Kind: yaml.DocumentNode}}
+	}
+
+	if len(docs[0].Content) == 0 {
+		docs[0].Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	sorted := append([]inventoryNode(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Address < sorted[j].Address })
+
+	for _, node := range sorted {
+		for _, field := range []struct{ key, value string }{
+			{"hostname", node.Hostname},
+			{"rack", node.Rack},
+			{"serial", node.Serial},
+		} {
+			if field.value == "" {
+				continue
+			}
+
+			value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.value}
+			if err := setValuesPath(docs[0].Content[0], []string{valuesNodesKey, node.Address, field.key}, value); err != nil {
+				return nil, errors.Wrapf(err, "%s.%s.%s", valuesNodesKey, node.Address, field.key)
+			}
+		}
+
+		if len(node.Interfaces) == 0 {
+			continue
+		}
+
+		var interfaces yaml.Node
+		if err := interfaces.Encode(node.Interfaces); err != nil {
+			return nil, errors.Wrapf(err, "encoding the interfaces of %s", node.Address)
+		}
+
+		if err := setValuesPath(docs[0].Content[0], []string{valuesNodesKey, node.Address, "interfaces"}, &interfaces); err != nil {
+			return nil, errors.Wrapf(err, "%s.%s.interfaces", valuesNodesKey, node.Address)
+		}
+	}
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding")
+	}
+
+	return keepLineEnding(out, data), nil
+}
+
+// parseInventoryFilters turns the --filter key=value pairs into a map.
+func parseInventoryFilters(pairs []string) (map[string]string, error) {
+	filters := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Newf("invalid --filter %q", pair),
+				"write filters as key=value, for example --filter role=talos",
+			)
+		}
+
+		filters[key] = value
+	}
+
+	return filters, nil
+}
+
+func inventorySourceNames() []string {
+	names := make([]string, 0, len(inventorySources))
+	for name := range inventorySources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	inventoryImportCmd.Flags().StringVar(&inventoryImportCmdFlags.source, "source", "", "inventory to read: "+strings.Join(inventorySourceNames(), ", "))
+	inventoryImportCmd.Flags().StringArrayVar(&inventoryImportCmdFlags.filters, "filter", nil, "only import nodes matching key=value (can be repeated)")
+	inventoryImportCmd.Flags().StringVarP(&inventoryImportCmdFlags.file, "file", "f", valuesYamlName, "values file to update, relative to the project root")
+	inventoryImportCmd.Flags().StringVar(&inventoryImportCmdFlags.csvFile, "csv", "", "CSV file of the csv source")
+	inventoryImportCmd.Flags().StringVar(&inventoryImportCmdFlags.netboxURL, "netbox-url", "", "base URL of the netbox source (default from NETBOX_URL)")
+	inventoryImportCmd.Flags().BoolVar(&inventoryImportCmdFlags.dryRun, "dry-run", false, "print the updated values file instead of writing it")
+	_ = inventoryImportCmd.MarkFlagRequired("source")
+
+	inventoryCmd.AddCommand(inventoryImportCmd)
+	addCommand(inventoryCmd)
+}
@@ -0,0 +1,172 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+const inventorySourceCSV = "csv"
+
+// CSV columns read into an inventoryNode; any other column is only
+// available to --filter.
+const (
+	csvColumnAddress   = "address"
+	csvColumnHostname  = "hostname"
+	csvColumnRack      = "rack"
+	csvColumnSerial    = "serial"
+	csvColumnInterface = "interface"
+	csvColumnMAC       = "mac"
+	csvColumnIP        = "ip"
+)
+
+// csvInventorySource reads nodes from a CSV file with a header row.
+type csvInventorySource struct {
+	path string
+}
+
+func newCSVInventorySource() (inventorySource, error) {
+	if inventoryImportCmdFlags.csvFile == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("the csv source needs a file"),
+			"pass it with --csv hosts.csv",
+		)
+	}
+
+	return csvInventorySource{path: inventoryImportCmdFlags.csvFile}, nil
+}
+
+func (s csvInventorySource) Nodes(_ context.Context, filters map[string]string) ([]inventoryNode, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", s.path)
+	}
+	defer f.Close() //nolint:errcheck // read-only file
+
+	nodes, err := readCSVInventory(f, filters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", s.path)
+	}
+
+	return nodes, nil
+}
+
+// readCSVInventory groups the rows matching filters by address, one
+// interface per row, in the order the addresses first appear. Column
+// names are matched case-insensitively.
+func readCSVInventory(r io.Reader, filters map[string]string) ([]inventoryNode, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the header row")
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columns[csvColumnAddress]; !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("no address column"),
+			"the header row must name an address column; hostname, rack, serial, interface, mac and ip are optional",
+		)
+	}
+
+	for key := range filters {
+		if _, ok := columns[strings.ToLower(key)]; !ok {
+			return nil, errors.Newf("--filter %s names no column of the header row", key)
+		}
+	}
+
+	var (
+		nodes []inventoryNode
+		index = map[string]int{}
+	)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "reading a row")
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+
+			return ""
+		}
+
+		if !csvRowMatches(field, filters) {
+			continue
+		}
+
+		address := field(csvColumnAddress)
+		if address == "" {
+			return nil, errors.Newf("line %d has no address", line)
+		}
+
+		i, seen := index[address]
+		if !seen {
+			i = len(nodes)
+			index[address] = i
+
+			nodes = append(nodes, inventoryNode{Address: address})
+		}
+
+		node := &nodes[i]
+		node.Hostname = cmp.Or(node.Hostname, field(csvColumnHostname))
+		node.Rack = cmp.Or(node.Rack, field(csvColumnRack))
+		node.Serial = cmp.Or(node.Serial, field(csvColumnSerial))
+
+		if name := field(csvColumnInterface); name != "" {
+			node.Interfaces = append(node.Interfaces, inventoryInterface{
+				Name:      name,
+				MAC:       strings.ToLower(field(csvColumnMAC)),
+				Addresses: strings.Fields(field(csvColumnIP)),
+			})
+		}
+	}
+
+	return nodes, nil
+}
+
+func csvRowMatches(field func(string) string, filters map[string]string) bool {
+	for key, value := range filters {
+		if field(strings.ToLower(key)) != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,268 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/httpclient"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	inventorySourceNetBox = "netbox"
+
+	// netboxURLEnv and netboxTokenEnv configure the netbox source;
+	// the token is never taken from a flag, so it stays out of shell
+	// history.
+	netboxURLEnv   = "NETBOX_URL"
+	netboxTokenEnv = "NETBOX_TOKEN"
+
+	// netboxTimeout bounds one API request.
+	netboxTimeout = 30 * time.Second
+
+	// netboxPageSize is the page size asked of every list endpoint.
+	netboxPageSize = 200
+
+	// netboxErrorBodyLimit caps the response body quoted in an error.
+	netboxErrorBodyLimit = 512
+)
+
+// netboxInventorySource reads devices from the NetBox REST API: the
+// device list for name, rack, serial and primary IP, then per device
+// its interfaces and the IP addresses assigned to them.
+type netboxInventorySource struct {
+	baseURL *url.URL
+	token   string
+	client  *http.Client
+}
+
+func newNetBoxInventorySource() (inventorySource, error) {
+	raw := cmp.Or(inventoryImportCmdFlags.netboxURL, os.Getenv(netboxURLEnv))
+	if raw == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.New("the netbox source needs the NetBox URL"),
+			"pass --netbox-url https://netbox.example.com or set %s", netboxURLEnv,
+		)
+	}
+
+	baseURL, err := url.Parse(raw)
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("invalid NetBox URL %q", raw),
+			"use the base URL of the NetBox UI, for example https://netbox.example.com",
+		)
+	}
+
+	token := os.Getenv(netboxTokenEnv)
+	if token == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.New("the netbox source needs an API token"),
+			"set %s to a NetBox API token with read access to devices, interfaces and IP addresses", netboxTokenEnv,
+		)
+	}
+
+	return &netboxInventorySource{baseURL: baseURL, token: token, client: httpclient.New(netboxTimeout)}, nil
+}
+
+// netboxDevice is the part of a NetBox device the import reads.
+type netboxDevice struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Serial string `json:"serial"`
+	Rack   *struct {
+		Name string `json:"name"`
+	} `json:"rack"`
+	PrimaryIP *netboxIP `json:"primary_ip"`
+}
+
+type netboxIP struct {
+	Address          string `json:"address"`
+	AssignedObjectID *int   `json:"assigned_object_id"`
+}
+
+type netboxInterface struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	MACAddress string `json:"mac_address"`
+}
+
+func (s *netboxInventorySource) Nodes(ctx context.Context, filters map[string]string) ([]inventoryNode, error) {
+	query := url.Values{}
+	for key, value := range filters {
+		query.Add(key, value)
+	}
+
+	devices, err := netboxList[netboxDevice](ctx, s, "/api/dcim/devices/", query)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]inventoryNode, 0, len(devices))
+
+	for _, device := range devices {
+		if device.PrimaryIP == nil || device.PrimaryIP.Address == "" {
+			ui.Warnf(os.Stderr, "NetBox device %s has no primary IP; skipped", device.Name)
+
+			continue
+		}
+
+		node := inventoryNode{
+			Address:  stripPrefixLength(device.PrimaryIP.Address),
+			Hostname: device.Name,
+			Serial:   device.Serial,
+		}
+
+		if device.Rack != nil {
+			node.Rack = device.Rack.Name
+		}
+
+		node.Interfaces, err = s.interfaces(ctx, device.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "device %s", device.Name)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// interfaces reads the interfaces of one device with the addresses
+// assigned to each.
+func (s *netboxInventorySource) interfaces(ctx context.Context, deviceID int) ([]inventoryInterface, error) {
+	query := url.Values{"device_id": {strconv.Itoa(deviceID)}}
+
+	ifaces, err := netboxList[netboxInterface](ctx, s, "/api/dcim/interfaces/", query)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := netboxList[netboxIP](ctx, s, "/api/ipam/ip-addresses/", query)
+	if err != nil {
+		return nil, err
+	}
+
+	byInterface := map[int][]string{}
+
+	for _, address := range addresses {
+		if address.AssignedObjectID != nil {
+			byInterface[*address.AssignedObjectID] = append(byInterface[*address.AssignedObjectID], address.Address)
+		}
+	}
+
+	result := make([]inventoryInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		result = append(result, inventoryInterface{
+			Name:      iface.Name,
+			MAC:       strings.ToLower(iface.MACAddress),
+			Addresses: byInterface[iface.ID],
+		})
+	}
+
+	return result, nil
+}
+
+// netboxList reads every page of a NetBox list endpoint.
+func netboxList[T any](ctx context.Context, s *netboxInventorySource, path string, query url.Values) ([]T, error) {
+	target := s.baseURL.JoinPath(path)
+
+	values := url.Values{}
+	for key, list := range query {
+		values[key] = list
+	}
+
+	values.Set("limit", strconv.Itoa(netboxPageSize))
+	target.RawQuery = values.Encode()
+
+	next := target.String()
+
+	var items []T
+
+	for next != "" {
+		var page struct {
+			Next    *string `json:"next"`
+			Results []T     `json:"results"`
+		}
+
+		if err := s.get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		items = append(items, page.Results...)
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+
+	return items, nil
+}
+
+func (s *netboxInventorySource) get(ctx context.Context, target string, into any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Wrapf(err, "building the NetBox request %s", target)
+	}
+
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "contacting NetBox at %s", s.baseURL.Host)
+	}
+	defer resp.Body.Close() //nolint:errcheck // response body
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, netboxErrorBodyLimit)) //nolint:errcheck // best-effort detail for the error message
+
+		err := errors.Newf("NetBox answered %s for %s: %s", resp.Status, req.URL.Path, strings.TrimSpace(string(body)))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(err, "check that %s holds a valid token with read access", netboxTokenEnv)
+		}
+
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return errors.Wrapf(err, "decoding the NetBox answer for %s", req.URL.Path)
+	}
+
+	return nil
+}
+
+// stripPrefixLength drops the `/24` NetBox keeps on every address.
+func stripPrefixLength(address string) string {
+	host, _, _ := strings.Cut(address, "/")
+
+	return host
+}
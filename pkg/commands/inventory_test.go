@@ -0,0 +1,308 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const inventoryTestCSV = `address,hostname,rack,serial,role,interface,mac,ip
+10.0.0.2,worker01,r2,SN2,worker,eth0,AA:BB:CC:00:00:02,10.0.0.2/24
+10.0.0.1,cp01,r1,SN1,talos,eth0,AA:BB:CC:00:00:01,10.0.0.1/24 fd00::1/64
+10.0.0.1,,,,talos,eth1,AA:BB:CC:00:00:11,
+`
+
+func TestReadCSVInventory(t *testing.T) {
+	t.Parallel()
+
+	nodes, err := readCSVInventory(strings.NewReader(inventoryTestCSV), map[string]string{"role": "talos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []inventoryNode{{
+		Address:  "10.0.0.1",
+		Hostname: "cp01",
+		Rack:     "r1",
+		Serial:   "SN1",
+		Interfaces: []inventoryInterface{
+			{Name: "eth0", MAC: "aa:bb:cc:00:00:01", Addresses: []string{"10.0.0.1/24", "fd00::1/64"}},
+			{Name: "eth1", MAC: "aa:bb:cc:00:00:11", Addresses: []string{}},
+		},
+	}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes = %+v, want %+v", nodes, want)
+	}
+}
+
+func TestReadCSVInventory_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name, csv string
+		filters   map[string]string
+		want      string
+	}{
+		{"no address column", "hostname\ncp01\n", nil, "no address column"},
+		{"empty address", "address,hostname\n,cp01\n", nil, "line 2 has no address"},
+		{"unknown filter", "address\n10.0.0.1\n", map[string]string{"site": "ams1"}, "--filter site"},
+	} {
+		_, err := readCSVInventory(strings.NewReader(tc.csv), tc.filters)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestMergeInventoryNodes pins the write: inventory keys are set under
+// nodes.<address>, while other keys, other nodes and comments stay.
+func TestMergeInventoryNodes(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`# cluster values
+endpoint: https://10.0.0.10:6443
+nodes:
+  10.0.0.1:
+    hostname: old-name # renamed in the CMDB
+    labels:
+      zone: a
+  10.0.0.9:
+    hostname: kept
+`)
+
+	nodes := []inventoryNode{
+		{Address: "10.0.0.2", Hostname: "worker01"},
+		{Address: "10.0.0.1", Hostname: "cp01", Rack: "r1", Interfaces: []inventoryInterface{
+			{Name: "eth0", MAC: "aa:bb:cc:00:00:01", Addresses: []string{"10.0.0.1/24"}},
+		}},
+	}
+
+	out, err := mergeInventoryNodes(data, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# cluster values
+endpoint: https://10.0.0.10:6443
+nodes:
+  10.0.0.1:
+    hostname: cp01 # renamed in the CMDB
+    labels:
+      zone: a
+    rack: r1
+    interfaces:
+      - interface: eth0
+        mac: aa:bb:cc:00:00:01
+        addresses:
+          - 10.0.0.1/24
+  10.0.0.9:
+    hostname: kept
+  10.0.0.2:
+    hostname: worker01
+`
+	if string(out) != want {
+		t.Errorf("values =\n%s\nwant\n%s", out, want)
+	}
+
+	again, err := mergeInventoryNodes(out, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(again, out) {
+		t.Errorf("a second import of the same inventory must change nothing, got\n%s", again)
+	}
+}
+
+func TestMergeInventoryNodes_EmptyFile(t *testing.T) {
+	t.Parallel()
+
+	out, err := mergeInventoryNodes(nil, []inventoryNode{{Address: "10.0.0.1", Serial: "SN1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "nodes:\n  10.0.0.1:\n    serial: SN1\n" {
+		t.Errorf("values = %q", out)
+	}
+}
+
+func TestParseInventoryFilters(t *testing.T) {
+	t.Parallel()
+
+	filters, err := parseInventoryFilters([]string{"role=talos", "tag=a=b"})
+	if err != nil || !reflect.DeepEqual(filters, map[string]string{"role": "talos", "tag": "a=b"}) {
+		t.Errorf("filters = %v, err = %v", filters, err)
+	}
+
+	for _, bad := range []string{"role", "=talos"} {
+		if _, err := parseInventoryFilters([]string{bad}); err == nil {
+			t.Errorf("%q must be rejected", bad)
+		}
+	}
+}
+
+// newNetBoxTestServer serves a two-page device list, one device without
+// a primary IP, and the interfaces and addresses of device 1.
+func newNetBoxTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"detail":"Invalid token"}`)
+
+			return
+		}
+
+		query := r.URL.Query()
+
+		switch r.URL.Path {
+		case "/api/dcim/devices/":
+			if query.Get("role") != "talos" {
+				t.Errorf("device query = %s, want the filter passed", r.URL.RawQuery)
+			}
+
+			if query.Get("offset") == "" {
+				next := server.URL + "/api/dcim/devices/?" + url.Values{"role": {"talos"}, "offset": {"1"}}.Encode()
+				fmt.Fprintf(w, `{"next":%q,"results":[{"id":1,"name":"cp01","serial":"SN1","rack":{"name":"r1"},"primary_ip":{"address":"10.0.0.1/24"}}]}`, next)
+
+				return
+			}
+
+			fmt.Fprint(w, `{"next":null,"results":[{"id":2,"name":"spare","primary_ip":null}]}`)
+		case "/api/dcim/interfaces/":
+			fmt.Fprint(w, `{"next":null,"results":[{"id":10,"name":"eth0","mac_address":"AA:BB:CC:00:00:01"},{"id":11,"name":"ipmi","mac_address":null}]}`)
+		case "/api/ipam/ip-addresses/":
+			fmt.Fprint(w, `{"next":null,"results":[{"address":"10.0.0.1/24","assigned_object_id":10},{"address":"fd00::1/64","assigned_object_id":10}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestNetBoxInventorySource(t *testing.T) {
+	t.Parallel()
+
+	server := newNetBoxTestServer(t)
+	baseURL, _ := url.Parse(server.URL)
+
+	source := &netboxInventorySource{baseURL: baseURL, token: "secret", client: server.Client()}
+
+	nodes, err := source.Nodes(context.Background(), map[string]string{"role": "talos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []inventoryNode{{
+		Address:  "10.0.0.1",
+		Hostname: "cp01",
+		Rack:     "r1",
+		Serial:   "SN1",
+		Interfaces: []inventoryInterface{
+			{Name: "eth0", MAC: "aa:bb:cc:00:00:01", Addresses: []string{"10.0.0.1/24", "fd00::1/64"}},
+			{Name: "ipmi"},
+		},
+	}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes = %+v, want %+v", nodes, want)
+	}
+}
+
+func TestNetBoxInventorySource_BadToken(t *testing.T) {
+	t.Parallel()
+
+	server := newNetBoxTestServer(t)
+	baseURL, _ := url.Parse(server.URL)
+
+	source := &netboxInventorySource{baseURL: baseURL, token: "wrong", client: server.Client()}
+
+	_, err := source.Nodes(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("error = %v, want the NetBox answer", err)
+	}
+}
+
+// TestRunInventoryImport_CSV runs the command path end to end against
+// a project root: the values file is written, and --dry-run prints
+// instead.
+func TestRunInventoryImport_CSV(t *testing.T) {
+	root := t.TempDir()
+	csvPath := filepath.Join(root, "hosts.csv")
+
+	if err := os.WriteFile(csvPath, []byte(inventoryTestCSV), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalRoot, originalFlags := Config.RootDir, inventoryImportCmdFlags
+	t.Cleanup(func() { Config.RootDir, inventoryImportCmdFlags = originalRoot, originalFlags })
+
+	Config.RootDir = root
+	inventoryImportCmdFlags.source = inventorySourceCSV
+	inventoryImportCmdFlags.csvFile = csvPath
+	inventoryImportCmdFlags.file = valuesYamlName
+	inventoryImportCmdFlags.filters = []string{"role=worker"}
+	inventoryImportCmdFlags.dryRun = true
+
+	var out bytes.Buffer
+
+	if err := runInventoryImport(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "hostname: worker01") {
+		t.Errorf("--dry-run output = %q", out.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(root, valuesYamlName)); !os.IsNotExist(err) {
+		t.Errorf("--dry-run must not write the values file, stat = %v", err)
+	}
+
+	inventoryImportCmdFlags.dryRun = false
+
+	if err := runInventoryImport(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, valuesYamlName))
+	if err != nil || !strings.Contains(string(data), "10.0.0.2:") {
+		t.Errorf("values.yaml = %q, err = %v", data, err)
+	}
+
+	inventoryImportCmdFlags.filters = []string{"role=storage"}
+	if err := runInventoryImport(context.Background(), &out); err == nil || !strings.Contains(err.Error(), "no nodes") {
+		t.Errorf("an empty match must fail, got %v", err)
+	}
+
+	inventoryImportCmdFlags.source = "ldap"
+	if err := runInventoryImport(context.Background(), &out); err == nil || !strings.Contains(err.Error(), "unknown inventory source") {
+		t.Errorf("an unknown source must fail, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// k8sManifestFileName matches the file names --k8s-manifests writes,
+// the ones a later render replaces and apply reads back.
+var k8sManifestFileName = regexp.MustCompile(`^[0-9]{2,}-.+\.yaml$`)
+
+// k8sManifestNamePart is what a kind or object name may keep in a
+// manifest file name; anything else becomes a dash.
+var k8sManifestNamePart = regexp.MustCompile(`[^a-z0-9.]+`)
+
+// splitK8sManifests moves the cluster.inlineManifests of a rendered
+// config to dir, and returns the config without them.
+func splitK8sManifests(dir string, rendered []byte, progress io.Writer) ([]byte, error) {
+	config, manifests, err := engine.SplitInlineManifests(rendered)
+	if err != nil {
+		return nil, errors.Wrap(err, "splitting the inline manifests")
+	}
+
+	if err := writeK8sManifests(dir, manifests, progress); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// writeK8sManifests writes each inline manifest to its own directory
+// of dir, one file per Kubernetes object, named NN-<kind>-<name>.yaml
+// by its position, kind and metadata.name. The files an earlier run
+// wrote there are removed first, so none outlives an object the chart
+// no longer renders.
+func writeK8sManifests(dir string, manifests []engine.InlineManifest, progress io.Writer) error {
+	for _, manifest := range manifests {
+		if err := validateK8sManifestName(manifest.Name); err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, manifest.Name)
+		if err := clearK8sManifestDir(target); err != nil {
+			return err
+		}
+
+		documents := engine.SplitManifestDocuments(manifest.Contents)
+		width := max(2, len(strconv.Itoa(len(documents))))
+
+		for i, document := range documents {
+			file := filepath.Join(target, k8sManifestFile(i, width, document))
+			if err := secureperm.WriteFile(file, []byte(document)); err != nil {
+				return errors.Wrapf(err, "writing %s", file)
+			}
+		}
+
+		ui.Infof(progress, "Wrote %d manifests of %s to %s", len(documents), manifest.Name, target)
+	}
+
+	return nil
+}
+
+// readK8sManifests reads back what writeK8sManifests wrote: an inline
+// manifest per directory of dir, named after it, of its files in name
+// order. Other files are ignored.
+func readK8sManifests(dir string) ([]engine.InlineManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrapf(err, "reading the manifests directory %s", dir),
+			"write it with talm template --k8s-manifests first",
+		)
+	}
+
+	var manifests []engine.InlineManifest
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", filepath.Join(dir, entry.Name()))
+		}
+
+		var documents []string
+
+		for _, file := range files {
+			if file.IsDir() || !k8sManifestFileName.MatchString(file.Name()) {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name(), file.Name())
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %s", path)
+			}
+
+			documents = append(documents, strings.TrimSpace(string(data))+"\n")
+		}
+
+		if len(documents) == 0 {
+			continue
+		}
+
+		manifests = append(manifests, engine.InlineManifest{Name: entry.Name(), Contents: strings.Join(documents, "---\n")})
+	}
+
+	return manifests, nil
+}
+
+// inlineK8sManifests puts the manifests of dir back into a rendered
+// config as cluster.inlineManifests, in place of those of the same
+// name. An empty dir leaves the config as is.
+func inlineK8sManifests(dir string, rendered []byte) ([]byte, error) {
+	if dir == "" {
+		return rendered, nil
+	}
+
+	manifests, err := readK8sManifests(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := engine.InlineManifests(rendered, manifests)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inlining the manifests of %s", dir)
+	}
+
+	return config, nil
+}
+
+// validateK8sManifestName refuses an inline manifest name that cannot
+// be a directory of its own.
+func validateK8sManifestName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("inline manifest name %q cannot be written as a directory", name),
+			"rename the cluster.inlineManifests entry in the chart, or render without --k8s-manifests",
+		)
+	}
+
+	return nil
+}
+
+// clearK8sManifestDir creates dir, or removes the manifest files an
+// earlier run wrote to it.
+func clearK8sManifestDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(os.MkdirAll(dir, 0o755), "creating %s", dir)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", dir)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !k8sManifestFileName.MatchString(entry.Name()) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return errors.Wrapf(err, "removing the earlier manifest %s", entry.Name())
+		}
+	}
+
+	return nil
+}
+
+// k8sManifestFile names the file of the i-th document of a manifest
+// after its kind and metadata.name, NN-<kind>.yaml without a name and
+// NN-object.yaml when the document is not an object. NN is padded to
+// width, so the files of a manifest sort in their order.
+func k8sManifestFile(i, width int, document string) string {
+	var object struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+
+	_ = yaml.Unmarshal([]byte(document), &object)
+
+	parts := []string{fmt.Sprintf("%0*d", width, i+1)}
+
+	for _, part := range []string{object.Kind, object.Metadata.Name} {
+		if part = strings.Trim(k8sManifestNamePart.ReplaceAllString(strings.ToLower(part), "-"), "-."); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 1 {
+		parts = append(parts, "object")
+	}
+
+	return strings.Join(parts, "-") + ".yaml"
+}
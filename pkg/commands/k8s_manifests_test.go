@@ -0,0 +1,196 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+const k8sManifestsTestConfig = `version: v1alpha1
+machine:
+  type: controlplane
+cluster:
+  clusterName: test
+  inlineManifests:
+    - name: cilium
+      contents: |
+        apiVersion: v1
+        kind: Namespace
+        metadata:
+          name: cilium
+        ---
+        apiVersion: rbac.authorization.k8s.io/v1
+        kind: ClusterRole
+        metadata:
+          name: cilium:operator
+`
+
+// TestSplitK8sManifests pins the layout: a directory per manifest, a
+// file per object named after its kind and name, and a config without
+// the manifests.
+func TestSplitK8sManifests(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	var progress bytes.Buffer
+
+	config, err := splitK8sManifests(dir, []byte(k8sManifestsTestConfig), &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(config), "inlineManifests") {
+		t.Errorf("config keeps the manifests:\n%s", config)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "cilium", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "cilium", "01-namespace-cilium.yaml"),
+		filepath.Join(dir, "cilium", "02-clusterrole-cilium-operator.yaml"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+
+	if !strings.Contains(progress.String(), "Wrote 2 manifests of cilium") {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
+
+// TestSplitK8sManifests_ReplacesEarlierFiles pins that a render drops
+// the files of objects the chart no longer renders, and keeps files it
+// did not write.
+func TestSplitK8sManifests_ReplacesEarlierFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "cilium"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"07-configmap-gone.yaml", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, "cilium", name), []byte("kind: ConfigMap\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := splitK8sManifests(dir, []byte(k8sManifestsTestConfig), &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "cilium", "07-configmap-gone.yaml")); !os.IsNotExist(err) {
+		t.Errorf("the earlier manifest survived: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "cilium", "README.md")); err != nil {
+		t.Errorf("README.md was removed: %v", err)
+	}
+}
+
+// TestInlineK8sManifests pins the round trip: the split manifests, an
+// edited file included, go back into the config under their name.
+func TestInlineK8sManifests(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	config, err := splitK8sManifests(dir, []byte(k8sManifestsTestConfig), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := filepath.Join(dir, "cilium", "01-namespace-cilium.yaml")
+	if err := os.WriteFile(edited, []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: kube-cilium\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inlined, err := inlineK8sManifests(dir, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, manifests, err := engine.SplitInlineManifests(inlined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []engine.InlineManifest{{
+		Name: "cilium",
+		Contents: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: kube-cilium\n---\n" +
+			"apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: cilium:operator\n",
+	}}
+	if !reflect.DeepEqual(manifests, want) {
+		t.Errorf("manifests = %+v, want %+v", manifests, want)
+	}
+
+	if same, err := inlineK8sManifests("", config); err != nil || !bytes.Equal(same, config) {
+		t.Errorf("no directory changed the config: %v", err)
+	}
+}
+
+// TestInlineK8sManifests_MissingDir pins the hint of a directory
+// template never wrote.
+func TestInlineK8sManifests_MissingDir(t *testing.T) {
+	t.Parallel()
+
+	_, err := inlineK8sManifests(filepath.Join(t.TempDir(), "missing"), []byte(k8sManifestsTestConfig))
+	if err == nil || !strings.Contains(err.Error(), "reading the manifests directory") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestK8sManifestFile pins the file names of objects without a name,
+// of documents that are not objects, and the padding of long manifests.
+func TestK8sManifestFile(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		i, width int
+		document string
+		want     string
+	}{
+		{i: 0, width: 2, document: "kind: Namespace\nmetadata:\n  name: Kube_System\n", want: "01-namespace-kube-system.yaml"},
+		{i: 4, width: 2, document: "kind: List\n", want: "05-list.yaml"},
+		{i: 9, width: 2, document: "- a\n- b\n", want: "10-object.yaml"},
+		{i: 9, width: 3, document: "kind: Secret\nmetadata:\n  name: token\n", want: "010-secret-token.yaml"},
+	} {
+		if got := k8sManifestFile(tc.i, tc.width, tc.document); got != tc.want {
+			t.Errorf("k8sManifestFile(%d, %d, %q) = %s, want %s", tc.i, tc.width, tc.document, got, tc.want)
+		}
+	}
+}
+
+// TestWriteK8sManifests_BadName pins the refusal of a manifest name
+// that would escape its directory.
+func TestWriteK8sManifests_BadName(t *testing.T) {
+	t.Parallel()
+
+	err := writeK8sManifests(t.TempDir(), []engine.InlineManifest{{Name: "../escape", Contents: "kind: A\n"}}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "cannot be written as a directory") {
+		t.Errorf("err = %v", err)
+	}
+}
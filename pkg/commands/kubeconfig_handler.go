@@ -15,13 +15,13 @@
 package commands
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cozystack/talm/pkg/age"
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/spf13/cobra"
 )
 
@@ -70,15 +70,7 @@ Otherwise, kubeconfig will be written to PWD.`
 			}
 		} else {
 			// Always use kubeconfig path from Chart.yaml globalOptions
-			kubeconfigPath = Config.GlobalOptions.Kubeconfig
-			if kubeconfigPath == "" {
-				// Default to defaultKubeconfigName if not specified in Chart.yaml
-				kubeconfigPath = defaultKubeconfigName
-			}
-			// Resolve to absolute path relative to project root
-			if !filepath.IsAbs(kubeconfigPath) {
-				kubeconfigPath = filepath.Join(Config.RootDir, kubeconfigPath)
-			}
+			kubeconfigPath = projectKubeconfigPath()
 			// Replace args with absolute path from Chart.yaml
 			newArgs = []string{kubeconfigPath}
 		}
@@ -92,83 +84,7 @@ Otherwise, kubeconfig will be written to PWD.`
 			wrappedCmd.Run(cmd, newArgs)
 		}
 
-		// After command execution, set secure permissions and check if kubeconfig path is in project root
-		// Resolve to absolute path
-		var (
-			absPath string
-			err     error
-		)
-		if !filepath.IsAbs(kubeconfigPath) {
-			absPath, err = filepath.Abs(filepath.Join(Config.RootDir, kubeconfigPath))
-		} else {
-			absPath, err = filepath.Abs(kubeconfigPath)
-		}
-
-		if err != nil {
-			return errors.Wrap(err, "failed to resolve kubeconfig path")
-		}
-
-		// Set secure permissions (600) on kubeconfig file. On Windows
-		// this lays down an NTFS DACL; os.Chmod would have been a no-op.
-		if err := secureperm.LockDown(absPath); err != nil {
-			// Don't fail the command if the tighten fails, but log warning
-			fmt.Fprintf(os.Stderr, "Warning: failed to set permissions on kubeconfig: %v\n", err)
-		}
-
-		rootAbs, err := filepath.Abs(Config.RootDir)
-		if err == nil {
-			relPath, err := filepath.Rel(rootAbs, absPath)
-			if err == nil && !isOutsideRoot(relPath) {
-				// Path is within project root, add to .gitignore
-				fileName := filepath.Base(kubeconfigPath)
-				if fileName == defaultKubeconfigName {
-					if err := addToGitignore(defaultKubeconfigName); err != nil {
-						// Don't fail the command if gitignore update fails
-						fmt.Fprintf(os.Stderr, "Warning: failed to update .gitignore: %v\n", err)
-					}
-				}
-			}
-		}
-
-		// Update kubeconfig server endpoint if endpoint is available
-		if len(GlobalArgs.Endpoints) > 0 {
-			endpoint := GlobalArgs.Endpoints[0]
-			if err := updateKubeconfigServer(absPath, endpoint); err != nil {
-				// Don't fail the command if update fails, but log warning
-				fmt.Fprintf(os.Stderr, "Warning: failed to update kubeconfig server endpoint: %v\n", err)
-			}
-		}
-
-		// Automatically update kubeconfig.encrypted if it exists and talm.key exists
-		// Skip this if --login flag is set
-		if !loginFlagValue {
-			// Get relative path from project root for encryption
-			rootAbs, err := filepath.Abs(Config.RootDir)
-			if err == nil {
-				relKubeconfigPath, err := filepath.Rel(rootAbs, absPath)
-				if err == nil && !isOutsideRoot(relKubeconfigPath) {
-					// Path is within project root
-					encryptedKubeconfigPath := relKubeconfigPath + ".encrypted"
-					encryptedKubeconfigFile := filepath.Join(Config.RootDir, encryptedKubeconfigPath)
-					keyFile := filepath.Join(Config.RootDir, "talm.key")
-
-					encryptedExists := fileExists(encryptedKubeconfigFile)
-					keyExists := fileExists(keyFile)
-
-					if encryptedExists && keyExists {
-						// Both files exist, encrypt kubeconfig
-						if err := age.EncryptYAMLFile(Config.RootDir, relKubeconfigPath, encryptedKubeconfigPath); err != nil {
-							// Don't fail the command if encryption fails, but log warning
-							fmt.Fprintf(os.Stderr, "Warning: failed to encrypt kubeconfig: %v\n", err)
-						} else {
-							fmt.Fprintf(os.Stderr, "Updated %s\n", encryptedKubeconfigPath)
-						}
-					}
-				}
-			}
-		}
-
-		return nil
+		return finishProjectKubeconfig(kubeconfigPath, loginFlagValue)
 	}
 	// Set custom Args validation: allow no args by default, but allow
 	// args when --login is set. The error message describes the
@@ -187,3 +103,91 @@ Otherwise, kubeconfig will be written to PWD.`
 		return nil
 	}
 }
+
+// finishProjectKubeconfig post-processes a kubeconfig written to
+// kubeconfigPath: it locks the file down, keeps a project kubeconfig out
+// of git, points it at the modeline endpoint and refreshes its encrypted
+// copy. Past resolving the path every step only warns on failure, the
+// kubeconfig is already written. login skips the encrypted copy, the
+// file is the system one.
+//
+//nolint:gocognit,nestif // linear sequence of best-effort steps, each guarded by its own path checks.
+func finishProjectKubeconfig(kubeconfigPath string, login bool) error {
+	// After command execution, set secure permissions and check if kubeconfig path is in project root
+	// Resolve to absolute path
+	var (
+		absPath string
+		err     error
+	)
+	if !filepath.IsAbs(kubeconfigPath) {
+		absPath, err = filepath.Abs(filepath.Join(Config.RootDir, kubeconfigPath))
+	} else {
+		absPath, err = filepath.Abs(kubeconfigPath)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve kubeconfig path")
+	}
+
+	// Set secure permissions (600) on kubeconfig file. On Windows
+	// this lays down an NTFS DACL; os.Chmod would have been a no-op.
+	if err := secureperm.LockDown(absPath); err != nil {
+		// Don't fail the command if the tighten fails, but log warning
+		ui.Warnf(os.Stderr, "failed to set permissions on kubeconfig: %v", err)
+	}
+
+	rootAbs, err := filepath.Abs(Config.RootDir)
+	if err == nil {
+		relPath, err := filepath.Rel(rootAbs, absPath)
+		if err == nil && !isOutsideRoot(relPath) {
+			// Path is within project root, add to .gitignore
+			fileName := filepath.Base(kubeconfigPath)
+			if fileName == defaultKubeconfigName {
+				if err := addToGitignore(defaultKubeconfigName); err != nil {
+					// Don't fail the command if gitignore update fails
+					ui.Warnf(os.Stderr, "failed to update .gitignore: %v", err)
+				}
+			}
+		}
+	}
+
+	// Update kubeconfig server endpoint if endpoint is available
+	if len(GlobalArgs.Endpoints) > 0 {
+		endpoint := GlobalArgs.Endpoints[0]
+		if err := updateKubeconfigServer(absPath, endpoint); err != nil {
+			// Don't fail the command if update fails, but log warning
+			ui.Warnf(os.Stderr, "failed to update kubeconfig server endpoint: %v", err)
+		}
+	}
+
+	// Automatically update kubeconfig.encrypted if it exists and talm.key exists
+	// Skip this if --login flag is set
+	if !login {
+		// Get relative path from project root for encryption
+		rootAbs, err := filepath.Abs(Config.RootDir)
+		if err == nil {
+			relKubeconfigPath, err := filepath.Rel(rootAbs, absPath)
+			if err == nil && !isOutsideRoot(relKubeconfigPath) {
+				// Path is within project root
+				encryptedKubeconfigPath := relKubeconfigPath + ".encrypted"
+				encryptedKubeconfigFile := filepath.Join(Config.RootDir, encryptedKubeconfigPath)
+				keyFile := projectPath(secretsLayout().KeyFile())
+
+				encryptedExists := fileExists(encryptedKubeconfigFile)
+				keyExists := fileExists(keyFile)
+
+				if encryptedExists && keyExists {
+					// Both files exist, encrypt kubeconfig
+					if err := age.EncryptYAMLFile(Config.RootDir, relKubeconfigPath, encryptedKubeconfigPath); err != nil {
+						// Don't fail the command if encryption fails, but log warning
+						ui.Warnf(os.Stderr, "failed to encrypt kubeconfig: %v", err)
+					} else {
+						ui.Infof(os.Stderr, "Updated %s", encryptedKubeconfigPath)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// KubectlPluginBinaryName is the executable name kubectl looks up
+	// on PATH for `kubectl talm`. Installing talm under this name (a
+	// copy or a symlink) switches it into plugin mode.
+	KubectlPluginBinaryName = "kubectl-talm"
+
+	// kubectlPluginCmdName is the subcommand managing the context
+	// registry. Plugin mode does not resolve a project for it, so a
+	// context can be registered from inside the project it maps to.
+	kubectlPluginCmdName = "kubectl-plugin"
+
+	// pluginRegistryEnvVar overrides the registry file location.
+	pluginRegistryEnvVar = "TALM_PLUGIN_REGISTRY"
+
+	// pluginRegistryDirName and pluginRegistryFileName locate the
+	// registry under the user config directory: talm/contexts.yaml.
+	pluginRegistryDirName  = "talm"
+	pluginRegistryFileName = "contexts.yaml"
+)
+
+// pluginRegistry maps kubeconfig context names to talm project
+// directories. Stored as YAML so operators can edit it by hand.
+type pluginRegistry struct {
+	Contexts map[string]string `yaml:"contexts"`
+}
+
+// IsKubectlPluginInvocation reports whether talm was started as the
+// kubectl plugin binary, i.e. argv[0] is kubectl-talm (with the .exe
+// suffix on Windows).
+func IsKubectlPluginInvocation(argv0 string) bool {
+	return strings.TrimSuffix(filepath.Base(argv0), ".exe") == KubectlPluginBinaryName
+}
+
+// EnterKubectlPluginProject resolves the current kubeconfig context
+// to its registered talm project and changes into it, so every talm
+// command — including relative `-f nodes/...` paths — runs as if the
+// operator had cd'ed into the project. args are the plugin arguments
+// (os.Args[1:]); the registry-management subcommand, help and
+// completion run without a project.
+func EnterKubectlPluginProject(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case kubectlPluginCmdName, "help", "-h", "--help", "--version", "completion", "__complete":
+			return nil
+		}
+	}
+
+	kubeContext, err := currentKubeContext()
+	if err != nil {
+		return err
+	}
+
+	registry, registryPath, err := loadPluginRegistry()
+	if err != nil {
+		return err
+	}
+
+	projectDir, ok := registry.Contexts[kubeContext]
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("kubeconfig context %q is not mapped to a talm project in %s", kubeContext, registryPath),
+			"cd into the project and run `talm %s register --kube-context %s`", kubectlPluginCmdName, kubeContext,
+		)
+	}
+
+	if err := os.Chdir(projectDir); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Wrapf(err, "entering talm project %s for context %q", projectDir, kubeContext),
+			"the project moved or was deleted; re-register it with `talm %s register --kube-context %s`", kubectlPluginCmdName, kubeContext,
+		)
+	}
+
+	return nil
+}
+
+// currentKubeContext returns the current-context of the kubeconfig
+// kubectl itself would use (KUBECONFIG or ~/.kube/config). kubectl
+// does not forward its own --kubeconfig / --context flags to plugins,
+// so the environment is the only shared source of truth.
+func currentKubeContext() (string, error) {
+	rawConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).RawConfig()
+	if err != nil {
+		return "", errors.Wrap(err, "loading kubeconfig")
+	}
+
+	if rawConfig.CurrentContext == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.New("kubeconfig has no current context"),
+			"select one with `kubectl config use-context <name>`",
+		)
+	}
+
+	return rawConfig.CurrentContext, nil
+}
+
+// pluginRegistryPath returns the registry location: $TALM_PLUGIN_REGISTRY
+// when set, otherwise <user config dir>/talm/contexts.yaml.
+func pluginRegistryPath() (string, error) {
+	if path := os.Getenv(pluginRegistryEnvVar); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "resolving user config directory")
+	}
+
+	return filepath.Join(configDir, pluginRegistryDirName, pluginRegistryFileName), nil
+}
+
+// loadPluginRegistry reads the registry; a missing file is an empty
+// registry.
+func loadPluginRegistry() (*pluginRegistry, string, error) {
+	path, err := pluginRegistryPath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	registry := &pluginRegistry{Contexts: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registry, path, nil
+		}
+
+		return nil, "", errors.Wrapf(err, "reading %s", path)
+	}
+
+	if err := yaml.Unmarshal(data, registry); err != nil {
+		return nil, "", errors.Wrapf(err, "parsing %s", path)
+	}
+
+	if registry.Contexts == nil {
+		registry.Contexts = map[string]string{}
+	}
+
+	return registry, path, nil
+}
+
+func savePluginRegistry(registry *pluginRegistry, path string) error {
+	data, err := yaml.Marshal(registry)
+	if err != nil {
+		return errors.Wrap(err, "encoding plugin registry")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), secureDirMode); err != nil {
+		return errors.Wrapf(err, "creating %s", filepath.Dir(path))
+	}
+
+	return errors.Wrapf(secureperm.WriteFile(path, data), "writing %s", path)
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var kubectlPluginRegisterCmdFlags struct {
+	context string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var kubectlPluginCmd = &cobra.Command{
+	Use:   kubectlPluginCmdName,
+	Short: "Manage the kubeconfig context to project mapping used by kubectl talm",
+	Long: `Install talm on PATH as kubectl-talm (a copy or a symlink) to use it as a
+kubectl plugin. In plugin mode talm reads the current kubeconfig context,
+looks up the talm project registered for it and runs the command from
+that project directory:
+
+  kubectl config use-context prod-eu
+  kubectl talm get members
+
+The registry lives in $TALM_PLUGIN_REGISTRY, or contexts.yaml under the
+talm directory of the user config directory.`,
+	Args: cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var kubectlPluginRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Map a kubeconfig context to the current talm project",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		kubeContext := kubectlPluginRegisterCmdFlags.context
+		if kubeContext == "" {
+			current, err := currentKubeContext()
+			if err != nil {
+				return err
+			}
+
+			kubeContext = current
+		}
+
+		return registerPluginContext(kubeContext, Config.RootDir)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var kubectlPluginListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered kubeconfig contexts and their projects",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		registry, _, err := loadPluginRegistry()
+		if err != nil {
+			return err
+		}
+
+		contexts := make([]string, 0, len(registry.Contexts))
+		for name := range registry.Contexts {
+			contexts = append(contexts, name)
+		}
+
+		sort.Strings(contexts)
+
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "CONTEXT\tPROJECT")
+
+		for _, name := range contexts {
+			fmt.Fprintf(tw, "%s\t%s\n", name, registry.Contexts[name])
+		}
+
+		return errors.Wrap(tw.Flush(), "writing registry table")
+	},
+}
+
+// registerPluginContext maps kubeContext to the project at rootDir.
+// The directory is stored absolute and must be a talm project, so a
+// plugin invocation never lands in a directory without Chart.yaml.
+func registerPluginContext(kubeContext, rootDir string) error {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", rootDir)
+	}
+
+	if !fileExists(filepath.Join(absRoot, chartYamlName)) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%s is not a talm project (no %s)", absRoot, chartYamlName),
+			"run the command from the project directory or pass --root <project>",
+		)
+	}
+
+	registry, path, err := loadPluginRegistry()
+	if err != nil {
+		return err
+	}
+
+	registry.Contexts[kubeContext] = absRoot
+
+	if err := savePluginRegistry(registry, path); err != nil {
+		return err
+	}
+
+	ui.Successf(os.Stderr, "Registered kubeconfig context %q -> %s in %s", kubeContext, absRoot, path)
+
+	return nil
+}
+
+func init() {
+	kubectlPluginRegisterCmd.Flags().StringVar(&kubectlPluginRegisterCmdFlags.context, "kube-context", "", "kubeconfig context to register (defaults to the current context)")
+
+	kubectlPluginCmd.AddCommand(kubectlPluginRegisterCmd, kubectlPluginListCmd)
+	addCommand(kubectlPluginCmd)
+}
@@ -0,0 +1,137 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestIsKubectlPluginInvocation(t *testing.T) {
+	t.Parallel()
+
+	for argv0, want := range map[string]bool{
+		"/usr/local/bin/kubectl-talm":  true,
+		"kubectl-talm.exe":             true,
+		"/usr/local/bin/talm":          false,
+		"/usr/local/bin/kubectl-talmx": false,
+	} {
+		if got := IsKubectlPluginInvocation(argv0); got != want {
+			t.Errorf("IsKubectlPluginInvocation(%q) = %v, want %v", argv0, got, want)
+		}
+	}
+}
+
+// writePluginKubeconfig points KUBECONFIG at a minimal kubeconfig
+// whose current context is name.
+func writePluginKubeconfig(t *testing.T, name string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	body := `apiVersion: v1
+kind: Config
+clusters:
+- name: c
+  cluster:
+    server: https://192.0.2.1:6443
+users:
+- name: u
+  user: {}
+contexts:
+- name: ` + name + `
+  context:
+    cluster: c
+    user: u
+current-context: ` + name + "\n"
+
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KUBECONFIG", path)
+}
+
+// TestKubectlPlugin_RegisterAndEnter pins the round trip: register
+// stores the absolute project path for the context, and plugin mode
+// changes into it when that context is current.
+func TestKubectlPlugin_RegisterAndEnter(t *testing.T) {
+	t.Setenv(pluginRegistryEnvVar, filepath.Join(t.TempDir(), "registry", "contexts.yaml"))
+	writePluginKubeconfig(t, "prod-eu")
+
+	project := t.TempDir()
+	if err := os.WriteFile(filepath.Join(project, chartYamlName), []byte("apiVersion: v2\nname: prod\nversion: 0.1.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registerPluginContext("prod-eu", project); err != nil {
+		t.Fatalf("registerPluginContext: %v", err)
+	}
+
+	prevWD, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.Chdir(prevWD) })
+
+	if err := EnterKubectlPluginProject([]string{"get", "members"}); err != nil {
+		t.Fatalf("EnterKubectlPluginProject: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantWD, _ := filepath.EvalSymlinks(project)
+	if gotWD, _ := filepath.EvalSymlinks(wd); gotWD != wantWD {
+		t.Errorf("working directory = %s, want %s", gotWD, wantWD)
+	}
+}
+
+// TestKubectlPlugin_UnregisteredContext pins the failure for a
+// context without a project: the error names the context and the
+// hint names the register command.
+func TestKubectlPlugin_UnregisteredContext(t *testing.T) {
+	t.Setenv(pluginRegistryEnvVar, filepath.Join(t.TempDir(), "contexts.yaml"))
+	writePluginKubeconfig(t, "staging")
+
+	err := EnterKubectlPluginProject([]string{"get", "members"})
+	if err == nil || !strings.Contains(err.Error(), `"staging"`) {
+		t.Fatalf("error must name the context, got %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "kubectl-plugin register --kube-context staging") {
+		t.Errorf("hint must name the register command, got %q", hints)
+	}
+
+	if err := EnterKubectlPluginProject([]string{kubectlPluginCmdName, "list"}); err != nil {
+		t.Errorf("the registry subcommand must run without a project: %v", err)
+	}
+}
+
+// TestKubectlPlugin_RegisterRejectsNonProject pins that only a
+// directory with Chart.yaml can be registered.
+func TestKubectlPlugin_RegisterRejectsNonProject(t *testing.T) {
+	t.Setenv(pluginRegistryEnvVar, filepath.Join(t.TempDir(), "contexts.yaml"))
+
+	if err := registerPluginContext("prod-eu", t.TempDir()); err == nil {
+		t.Error("expected error for a directory without Chart.yaml")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// labelAbsent stands for a label a Node does not carry, or one the
+// node file removes with a `$patch: delete` entry.
+const labelAbsent = "<absent>"
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var labelsDiffCmdFlags struct {
+	configFiles []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var labelsCmd = &cobra.Command{
+	Use:   "labels",
+	Short: "Compare the declared Kubernetes node labels with the cluster",
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var labelsDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the declared node labels the Kubernetes Nodes lack or carry with another value",
+	Long: `Compare the labels each node file declares with the labels of its Kubernetes
+Node, read through the project kubeconfig.
+
+A node file declares the labels under machine.nodeLabels, as the chart
+renders them from values.yaml nodeLabels and as its body adds them, and a
+label it deletes with a "$patch: delete" entry must be absent. The labels
+under nodes.<address>.labels in values.yaml, which apply --sync-node-metadata
+patches onto the Node, are declared too. Labels the Node carries that nothing
+declares, such as those kubelet sets, are not reported.
+
+The node files are read as written: run talm template -I first when
+values.yaml changed since they were rendered. The command fails when a label
+differs, so it can gate a pipeline.`,
+	Example: `  talm labels diff -f nodes/
+  talm labels diff -f nodes/node1.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if len(labelsDiffCmdFlags.configFiles) == 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("no node file given"),
+				"pass the node files to compare with -f, e.g. talm labels diff -f nodes/",
+			)
+		}
+
+		files, err := ExpandFilePaths(labelsDiffCmdFlags.configFiles)
+		if err != nil {
+			return err
+		}
+
+		if err := DetectAndSetRootFromFiles(files); err != nil {
+			return err
+		}
+
+		meta, err := loadNodeMetadata(Config.RootDir)
+		if err != nil {
+			return err
+		}
+
+		declarations, err := declaredNodeLabels(files, meta)
+		if err != nil {
+			return err
+		}
+
+		clientset, err := projectKubeClient()
+		if err != nil {
+			return err
+		}
+
+		return runLabelsDiff(cmd.Context(), clientset, declarations, cmd.OutOrStdout(), os.Stderr)
+	},
+}
+
+// labelDeclaration is what the project declares for the labels of one
+// node: the labels it must carry, and the labels its node file deletes,
+// which it must not carry.
+type labelDeclaration struct {
+	node    string
+	labels  map[string]string
+	removed map[string]bool
+}
+
+// labelDrift is one declared label the Node does not match.
+type labelDrift struct {
+	node, name, label, declared, live string
+}
+
+// declaredNodeLabels reads the label declarations of every node of
+// the node files: machine.nodeLabels of the file, then the labels of
+// the node's values.yaml nodes entry on top, as apply applies them in
+// that order.
+func declaredNodeLabels(files []string, meta map[string]nodeMetadata) ([]labelDeclaration, error) {
+	var declarations []labelDeclaration
+
+	for _, file := range files {
+		_, config, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading the modeline of %s", file)
+		}
+
+		labels, removed, err := nodeFileLabels(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range config.Nodes {
+			declaration := labelDeclaration{node: node, labels: maps.Clone(labels), removed: maps.Clone(removed)}
+
+			for label, value := range meta[node].Labels {
+				declaration.labels[label] = value
+				delete(declaration.removed, label)
+			}
+
+			declarations = append(declarations, declaration)
+		}
+	}
+
+	return declarations, nil
+}
+
+// nodeFileLabels returns machine.nodeLabels of the node file at path,
+// split into the labels it sets and the labels it deletes with a
+// `$patch: delete` entry.
+func nodeFileLabels(path string) (map[string]string, map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	body, err := modeline.NodeFileBody(data)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	labels := map[string]string{}
+	removed := map[string]bool{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		var doc struct {
+			Machine struct {
+				NodeLabels map[string]any `yaml:"nodeLabels"`
+			} `yaml:"machine"`
+		}
+
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return labels, removed, nil
+			}
+
+			return nil, nil, errors.Wrapf(err, "parsing %s", path)
+		}
+
+		for label, value := range doc.Machine.NodeLabels {
+			if directive, ok := value.(map[string]any); ok && directive["$patch"] == "delete" {
+				removed[label] = true
+
+				continue
+			}
+
+			if value == nil {
+				value = ""
+			}
+
+			labels[label] = fmt.Sprint(value)
+		}
+	}
+}
+
+// runLabelsDiff compares every declaration with the labels of its
+// Node, prints the differences as a table on out, and fails when there
+// are any. A node that has not registered in Kubernetes yet is
+// reported on w and skipped.
+func runLabelsDiff(ctx context.Context, clientset kubernetes.Interface, declarations []labelDeclaration, out, w io.Writer) error {
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing Kubernetes nodes")
+	}
+
+	var (
+		drifts   []labelDrift
+		compared int
+	)
+
+	for _, declaration := range declarations {
+		name, ok := findKubernetesNodeName(nodeList.Items, declaration.node)
+		if !ok {
+			ui.Warnf(w, "%s has not registered in Kubernetes yet, its labels are not compared", declaration.node)
+
+			continue
+		}
+
+		compared++
+
+		drifts = append(drifts, diffNodeLabels(declaration, name, kubernetesNodeByName(nodeList.Items, name).Labels)...)
+	}
+
+	if len(drifts) == 0 {
+		ui.Successf(w, "The labels of %d node(s) match their declaration", compared)
+
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tLABEL\tDECLARED\tLIVE")
+
+	for _, drift := range drifts {
+		fmt.Fprintf(tw, "%s (%s)\t%s\t%s\t%s\n", drift.name, drift.node, drift.label, drift.declared, drift.live)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "writing the label differences")
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Newf("%d label(s) differ from their declaration", len(drifts)),
+		"run `talm apply -f <node file>` to apply the node file labels, with --sync-node-metadata for those under nodes.<address> in values.yaml",
+	)
+}
+
+// diffNodeLabels lists the labels of declaration that live does not
+// match, in label order.
+func diffNodeLabels(declaration labelDeclaration, name string, live map[string]string) []labelDrift {
+	var drifts []labelDrift
+
+	for _, label := range sortedKeys(declaration.labels) {
+		want := declaration.labels[label]
+
+		got, ok := live[label]
+		if !ok {
+			got = labelAbsent
+		}
+
+		if !ok || got != want {
+			drifts = append(drifts, labelDrift{node: declaration.node, name: name, label: label, declared: want, live: got})
+		}
+	}
+
+	for _, label := range sortedKeys(declaration.removed) {
+		if got, ok := live[label]; ok {
+			drifts = append(drifts, labelDrift{node: declaration.node, name: name, label: label, declared: labelAbsent, live: got})
+		}
+	}
+
+	return drifts
+}
+
+func init() {
+	labelsDiffCmd.Flags().StringSliceVarP(&labelsDiffCmdFlags.configFiles, "file", "f", nil, "node files, or directories of them, whose declared labels to compare (can specify multiple)")
+
+	_ = labelsDiffCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	labelsCmd.AddCommand(labelsDiffCmd)
+	addCommand(labelsCmd)
+}
@@ -0,0 +1,186 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testLabelsNodeFile = `# talm: nodes=["192.0.2.10"], endpoints=["192.0.2.10"], templates=["templates/controlplane.yaml"]
+machine:
+  nodeLabels:
+    node.kubernetes.io/exclude-from-external-load-balancers:
+      $patch: delete
+    topology.kubernetes.io/zone: eu-1a
+    example.com/tier: db
+  type: controlplane
+---
+apiVersion: v1alpha1
+kind: HostnameConfig
+hostname: cp01
+`
+
+func writeLabelsNodeFile(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "node.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestDeclaredNodeLabels pins what counts as declared: the node file's
+// machine.nodeLabels, a `$patch: delete` entry as a label that must be
+// absent, and the values.yaml nodes entry on top of both.
+func TestDeclaredNodeLabels(t *testing.T) {
+	t.Parallel()
+
+	file := writeLabelsNodeFile(t, testLabelsNodeFile)
+	meta := map[string]nodeMetadata{
+		"192.0.2.10": {Labels: map[string]string{
+			"example.com/tier": "cache",
+			"node.kubernetes.io/exclude-from-external-load-balancers": "",
+		}},
+		"192.0.2.99": {Labels: map[string]string{"example.com/other": "x"}},
+	}
+
+	declarations, err := declaredNodeLabels([]string{file}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []labelDeclaration{{
+		node: "192.0.2.10",
+		labels: map[string]string{
+			"topology.kubernetes.io/zone":                             "eu-1a",
+			"example.com/tier":                                        "cache",
+			"node.kubernetes.io/exclude-from-external-load-balancers": "",
+		},
+		removed: map[string]bool{},
+	}}
+	if !reflect.DeepEqual(declarations, want) {
+		t.Errorf("declarations = %+v, want %+v", declarations, want)
+	}
+
+	declarations, err = declaredNodeLabels([]string{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !declarations[0].removed["node.kubernetes.io/exclude-from-external-load-balancers"] {
+		t.Errorf("a $patch: delete entry must declare the label absent, got %+v", declarations[0])
+	}
+}
+
+func TestNodeFileLabels_JSONNodeFile(t *testing.T) {
+	t.Parallel()
+
+	file := writeLabelsNodeFile(t, `{"talm": {"nodes": ["192.0.2.10"], "templates": ["templates/worker.yaml"]},
+"documents": [{"machine": {"nodeLabels": {"topology.kubernetes.io/zone": "eu-1b"}}}]}
+`)
+
+	labels, _, err := nodeFileLabels(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if labels["topology.kubernetes.io/zone"] != "eu-1b" {
+		t.Errorf("labels = %v", labels)
+	}
+}
+
+func TestRunLabelsDiff(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(
+		drainTestNode("cp01", "192.0.2.10", "boot-1", map[string]string{
+			"topology.kubernetes.io/zone":                             "eu-1a",
+			"example.com/tier":                                        "web",
+			"node.kubernetes.io/exclude-from-external-load-balancers": "",
+			"kubernetes.io/hostname":                                  "cp01",
+		}),
+	)
+
+	declarations := []labelDeclaration{
+		{
+			node: "192.0.2.10",
+			labels: map[string]string{
+				"topology.kubernetes.io/zone": "eu-1a",
+				"example.com/tier":            "db",
+				"example.com/rack":            "r12",
+			},
+			removed: map[string]bool{"node.kubernetes.io/exclude-from-external-load-balancers": true},
+		},
+		{node: "192.0.2.99", labels: map[string]string{"example.com/tier": "db"}},
+	}
+
+	var out, progress bytes.Buffer
+
+	err := runLabelsDiff(context.Background(), clientset, declarations, &out, &progress)
+	if err == nil || !strings.Contains(err.Error(), "3 label(s) differ") {
+		t.Fatalf("error = %v", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "talm apply") {
+		t.Errorf("hints = %q", hints)
+	}
+
+	var rows [][]string
+	for line := range strings.Lines(out.String()) {
+		rows = append(rows, strings.Fields(line))
+	}
+
+	want := [][]string{
+		{"NODE", "LABEL", "DECLARED", "LIVE"},
+		{"cp01", "(192.0.2.10)", "example.com/rack", "r12", labelAbsent},
+		{"cp01", "(192.0.2.10)", "example.com/tier", "db", "web"},
+		{"cp01", "(192.0.2.10)", "node.kubernetes.io/exclude-from-external-load-balancers", labelAbsent},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("output =\n%s", out.String())
+	}
+
+	if !strings.Contains(progress.String(), "192.0.2.99 has not registered in Kubernetes yet") {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
+
+func TestRunLabelsDiff_Match(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(drainTestNode("cp01", "192.0.2.10", "boot-1", map[string]string{"example.com/tier": "db"}))
+
+	var out, progress bytes.Buffer
+
+	declarations := []labelDeclaration{{node: "192.0.2.10", labels: map[string]string{"example.com/tier": "db"}}}
+	if err := runLabelsDiff(context.Background(), clientset, declarations, &out, &progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Len() != 0 || !strings.Contains(progress.String(), "The labels of 1 node(s) match") {
+		t.Errorf("out = %q, progress = %q", out.String(), progress.String())
+	}
+}
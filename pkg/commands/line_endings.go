@@ -0,0 +1,62 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Line endings of project files. talm writes LF; a file checked out on
+// Windows with core.autocrlf, or saved by a Windows editor, has CRLF.
+const (
+	lineEndingLF   = "\n"
+	lineEndingCRLF = "\r\n"
+)
+
+// lineEnding returns the line ending text uses, judged by its first
+// line break: CRLF or LF. Text without a line break is LF.
+func lineEnding(text string) string {
+	i := strings.IndexByte(text, '\n')
+	if i > 0 && text[i-1] == '\r' {
+		return lineEndingCRLF
+	}
+
+	return lineEndingLF
+}
+
+// withLineEnding returns text, whose line breaks talm generated as LF,
+// with eol line breaks instead. Rewriting a CRLF file with its own line
+// ending keeps git from seeing every line as changed.
+func withLineEnding(text, eol string) string {
+	if eol == lineEndingLF {
+		return text
+	}
+
+	return strings.ReplaceAll(strings.ReplaceAll(text, lineEndingCRLF, lineEndingLF), lineEndingLF, eol)
+}
+
+// toLF returns data with CRLF line breaks turned into LF. Decode a file
+// through it before a yaml.Node round trip: yaml.v3 re-encodes the
+// comments of a CRLF document with a spurious blank line after each.
+func toLF(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte(lineEndingCRLF), []byte(lineEndingLF))
+}
+
+// keepLineEnding returns out, the LF rewrite of the file content
+// previous, with the line ending of previous.
+func keepLineEnding(out, previous []byte) []byte {
+	return []byte(withLineEnding(string(out), lineEnding(string(previous))))
+}
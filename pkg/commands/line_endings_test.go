@@ -0,0 +1,94 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineEnding(t *testing.T) {
+	t.Parallel()
+
+	for text, want := range map[string]string{
+		"":                lineEndingLF,
+		"a":               lineEndingLF,
+		"a\nb\r\n":        lineEndingLF,
+		"a\r\nb\n":        lineEndingCRLF,
+		"\r\n":            lineEndingCRLF,
+		"# x\r\nkey: v\n": lineEndingCRLF,
+	} {
+		if got := lineEnding(text); got != want {
+			t.Errorf("lineEnding(%q) = %q, want %q", text, got, want)
+		}
+	}
+
+	if got := withLineEnding("a\nb\r\nc\n", lineEndingCRLF); got != "a\r\nb\r\nc\r\n" {
+		t.Errorf("withLineEnding = %q", got)
+	}
+
+	if got := string(keepLineEnding([]byte("a: 1\n"), []byte("a: 0\r\n"))); got != "a: 1\r\n" {
+		t.Errorf("keepLineEnding = %q", got)
+	}
+}
+
+// TestWriteInplaceRendered_KeepsCRLF pins that template -I rewrites a
+// node file checked out with CRLF line endings as CRLF.
+func TestWriteInplaceRendered_KeepsCRLF(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cp1.yaml")
+
+	writeFile(t, dir, "cp1.yaml", "# talm: nodes=[\"192.0.2.10\"]\r\nmachine: {}\r\n")
+
+	if err := writeInplaceRendered(path, "# talm: nodes=[\"192.0.2.10\"]\nmachine:\n  type: controlplane\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "# talm: nodes=[\"192.0.2.10\"]\r\nmachine:\r\n  type: controlplane\r\n" {
+		t.Errorf("rewritten = %q", data)
+	}
+}
+
+func TestUpdateGitattributes_KeepsCRLF(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, dir, gitattributesName, "*.tgz binary\r\n")
+
+	if err := updateGitattributes(dir, []string{"secrets.yaml"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, gitattributesName))
+	if want := "*.tgz binary\r\n/secrets.yaml filter=talm diff=talm\r\n"; string(data) != want {
+		t.Errorf(".gitattributes = %q, want %q", data, want)
+	}
+
+	if err := updateGitattributes(dir, []string{"secrets.yaml"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, gitattributesName)); string(data) != "*.tgz binary\r\n" {
+		t.Errorf("after removal .gitattributes = %q", data)
+	}
+}
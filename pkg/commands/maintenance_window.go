@@ -0,0 +1,261 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/maintwindow"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// maintenanceWindowsKey is the key of a values.yaml `nodes` entry that
+// lists the node's maintenance windows.
+const maintenanceWindowsKey = "maintenanceWindows"
+
+// ignoreWindowFlag lets an operator run a reboot-requiring command on
+// a node outside its maintenance windows.
+const ignoreWindowFlag = "ignore-window"
+
+const ignoreWindowFlagUsage = "run even when a target node is outside its maintenance windows declared in values.yaml; the command warns instead of refusing"
+
+// maintenanceWindowTimeFormat renders window opening and closing
+// times, in the zone of the node's first window.
+const maintenanceWindowTimeFormat = "2006-01-02 15:04 MST"
+
+// maintenanceWindowSpec is one entry of a values.yaml
+// `nodes.<node>.maintenanceWindows` list.
+type maintenanceWindowSpec struct {
+	// Schedule is a five-field cron expression for when the window
+	// opens, e.g. `0 2 * * sat`.
+	Schedule string `yaml:"schedule"`
+	// Duration is how long the window stays open, e.g. `4h`.
+	Duration string `yaml:"duration"`
+	// Timezone is the IANA zone the schedule is read in; UTC when
+	// empty.
+	Timezone string `yaml:"timezone"`
+}
+
+// loadMaintenanceWindows reads the `maintenanceWindows` entries of the
+// values.yaml `nodes` map, keyed by canonical node address. A node
+// without the entry has no windows and is never refused.
+func loadMaintenanceWindows(rootDir string) (map[string][]maintwindow.Window, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string][]maintwindow.Window{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	var values struct {
+		Nodes map[string]struct {
+			MaintenanceWindows []maintenanceWindowSpec `yaml:"maintenanceWindows"`
+		} `yaml:"nodes"`
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(err, "parsing `%s` in %s", valuesNodesKey, valuesPath),
+			"a `%s.<node>.%s` entry is a list of windows, each with schedule, duration and timezone strings", valuesNodesKey, maintenanceWindowsKey,
+		)
+	}
+
+	windows := make(map[string][]maintwindow.Window, len(values.Nodes))
+
+	for node, entry := range values.Nodes {
+		for i, spec := range entry.MaintenanceWindows {
+			w, err := maintwindow.Parse(spec.Schedule, spec.Duration, spec.Timezone)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: %s.%s.%s[%d]", valuesPath, valuesNodesKey, node, maintenanceWindowsKey, i)
+			}
+
+			key := canonicalNodeTarget(node)
+			windows[key] = append(windows[key], w)
+		}
+	}
+
+	return windows, nil
+}
+
+// nodeWindowState is where a node stands against its maintenance
+// windows at one moment.
+type nodeWindowState struct {
+	node    string
+	windows []maintwindow.Window
+	open    bool
+	// until is when the open window closes.
+	until time.Time
+	// next is the next opening of a closed window; zero when none
+	// opens within a year.
+	next time.Time
+}
+
+// evaluateMaintenanceWindows returns the state of every node in nodes
+// that declares windows, in the order of nodes.
+func evaluateMaintenanceWindows(windows map[string][]maintwindow.Window, nodes []string, now time.Time) []nodeWindowState {
+	var states []nodeWindowState
+
+	for _, node := range nodes {
+		declared := windows[canonicalNodeTarget(node)]
+		if len(declared) == 0 {
+			continue
+		}
+
+		state := nodeWindowState{node: node, windows: declared}
+		state.until, state.open = maintwindow.OpenUntil(declared, now)
+
+		if !state.open {
+			state.next, _ = maintwindow.Next(declared, now)
+		}
+
+		states = append(states, state)
+	}
+
+	return states
+}
+
+// describe renders the state: when the window closes, or when the next
+// one opens.
+func (s nodeWindowState) describe() string {
+	loc := s.windows[0].Location
+
+	switch {
+	case s.open:
+		return "open until " + s.until.In(loc).Format(maintenanceWindowTimeFormat)
+	case s.next.IsZero():
+		return "closed, no window opens within a year"
+	default:
+		return "closed, next opens " + s.next.In(loc).Format(maintenanceWindowTimeFormat)
+	}
+}
+
+// enforceMaintenanceWindows refuses a reboot-requiring command when any
+// of nodes is outside all of its maintenance windows. With ignore set
+// it warns on w and lets the command run. Nodes without windows are
+// not restricted.
+func enforceMaintenanceWindows(rootDir string, nodes []string, now time.Time, ignore bool, w io.Writer) error {
+	windows, err := loadMaintenanceWindows(rootDir)
+	if err != nil {
+		return err
+	}
+
+	var closed []string
+
+	for _, state := range evaluateMaintenanceWindows(windows, nodes, now) {
+		if !state.open {
+			closed = append(closed, state.node+": "+state.describe())
+		}
+	}
+
+	if len(closed) == 0 {
+		return nil
+	}
+
+	if ignore {
+		for _, line := range closed {
+			ui.Warnf(w, "outside the maintenance windows, going ahead because of --%s: %s", ignoreWindowFlag, line)
+		}
+
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("nodes are outside their maintenance windows:\n  - %s", strings.Join(closed, "\n  - ")),
+		"run again once the window opens (`talm maintenance-windows` lists them), or pass --%s to run now", ignoreWindowFlag,
+	)
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var maintenanceWindowsCmd = &cobra.Command{
+	Use:   "maintenance-windows",
+	Short: "Show the maintenance windows of the nodes and whether they are open",
+	Long: `List the maintenance windows declared for nodes in values.yaml, with
+whether each node's window is open now and, if not, when the next one
+opens.
+
+A window is declared under the node's entry of the values.yaml nodes
+map, as a cron schedule for when it opens, how long it stays open and
+the time zone the schedule is read in:
+
+  nodes:
+    192.0.2.10:
+      maintenanceWindows:
+        - schedule: "0 2 * * sat"
+          duration: 4h
+          timezone: Europe/Berlin
+
+apply --mode=reboot, upgrade and reset refuse to run on a node outside
+all of its windows unless --ignore-window is passed. Nodes without
+windows are not restricted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runMaintenanceWindows(cmd.OutOrStdout(), ui.Progress(os.Stderr), Config.RootDir, time.Now())
+	},
+}
+
+func runMaintenanceWindows(out, progress io.Writer, rootDir string, now time.Time) error {
+	windows, err := loadMaintenanceWindows(rootDir)
+	if err != nil {
+		return err
+	}
+
+	if len(windows) == 0 {
+		fmt.Fprintf(progress, "No maintenance windows are declared; add %s.<node>.%s entries to values.yaml.\n", valuesNodesKey, maintenanceWindowsKey)
+
+		return nil
+	}
+
+	nodes := make([]string, 0, len(windows))
+	for node := range windows {
+		nodes = append(nodes, node)
+	}
+
+	sort.Strings(nodes)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tWINDOWS\tSTATUS")
+
+	for _, state := range evaluateMaintenanceWindows(windows, nodes, now) {
+		specs := make([]string, 0, len(state.windows))
+		for _, window := range state.windows {
+			specs = append(specs, window.String())
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", state.node, strings.Join(specs, "; "), state.describe())
+	}
+
+	return errors.Wrap(w.Flush(), "writing maintenance windows table")
+}
+
+func init() {
+	addCommand(maintenanceWindowsCmd)
+}
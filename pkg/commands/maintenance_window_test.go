@@ -0,0 +1,152 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const maintenanceWindowValues = `nodes:
+  192.0.2.10:
+    maintenanceWindows:
+      - schedule: "0 2 * * sat"
+        duration: 4h
+        timezone: Europe/Berlin
+  192.0.2.20:
+    maintenanceWindows:
+      - schedule: "0 * * * *"
+        duration: 30m
+      - schedule: "0 12 * * *"
+        duration: 2h
+  192.0.2.30:
+    labels:
+      role: worker
+`
+
+// maintenanceWindowNow is a Friday, 12:45 UTC: 192.0.2.20 is inside
+// its noon window, 192.0.2.10 waits for Saturday night in Berlin.
+func maintenanceWindowNow() time.Time {
+	return time.Date(2026, 10, 16, 12, 45, 0, 0, time.UTC)
+}
+
+func TestLoadMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{"values.yaml": maintenanceWindowValues})
+
+	windows, err := loadMaintenanceWindows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(windows) != 2 || len(windows["192.0.2.10"]) != 1 || len(windows["192.0.2.20"]) != 2 {
+		t.Errorf("windows = %v, want one for 192.0.2.10 and two for 192.0.2.20", windows)
+	}
+
+	if windows, err := loadMaintenanceWindows(t.TempDir()); err != nil || len(windows) != 0 {
+		t.Errorf("a project without values.yaml declares no windows, got %v, %v", windows, err)
+	}
+
+	bad := writePruneProject(t, map[string]string{"values.yaml": "nodes:\n  192.0.2.10:\n    maintenanceWindows:\n      - schedule: \"0 2 * *\"\n        duration: 4h\n"})
+
+	_, err = loadMaintenanceWindows(bad)
+	if err == nil || !strings.Contains(err.Error(), "nodes.192.0.2.10.maintenanceWindows[0]") {
+		t.Errorf("a bad window must fail naming its entry, got %v", err)
+	}
+
+	if len(errors.GetAllHints(err)) == 0 {
+		t.Error("a bad schedule must carry a hint")
+	}
+}
+
+// TestEnforceMaintenanceWindows pins that only nodes outside all of
+// their windows are refused, that nodes without windows are never
+// restricted, and that --ignore-window turns the refusal into a
+// warning.
+func TestEnforceMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{"values.yaml": maintenanceWindowValues})
+
+	var out bytes.Buffer
+
+	if err := enforceMaintenanceWindows(dir, []string{"192.0.2.20", "192.0.2.30", "192.0.2.99"}, maintenanceWindowNow(), false, &out); err != nil {
+		t.Errorf("open and unrestricted nodes must pass, got %v", err)
+	}
+
+	err := enforceMaintenanceWindows(dir, []string{"192.0.2.10", "192.0.2.20"}, maintenanceWindowNow(), false, &out)
+	if err == nil {
+		t.Fatal("a node outside its window must be refused")
+	}
+
+	if want := "192.0.2.10: closed, next opens 2026-10-17 02:00 CEST"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+
+	if strings.Contains(err.Error(), "192.0.2.20") {
+		t.Errorf("a node inside its window must not be listed: %v", err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("a refusal must not warn as well, got %q", out.String())
+	}
+
+	if err := enforceMaintenanceWindows(dir, []string{"192.0.2.10"}, maintenanceWindowNow(), true, &out); err != nil {
+		t.Errorf("--ignore-window must let the command run, got %v", err)
+	}
+
+	if !strings.Contains(out.String(), "Warning: outside the maintenance windows, going ahead because of --ignore-window: 192.0.2.10") {
+		t.Errorf("--ignore-window must warn, got %q", out.String())
+	}
+}
+
+func TestRunMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{"values.yaml": maintenanceWindowValues})
+
+	var out, progress bytes.Buffer
+
+	if err := runMaintenanceWindows(&out, &progress, dir, maintenanceWindowNow()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"NODE        WINDOWS",
+		"192.0.2.10  0 2 * * sat for 4h (Europe/Berlin)",
+		"closed, next opens 2026-10-17 02:00 CEST",
+		"192.0.2.20  0 * * * * for 30m (UTC); 0 12 * * * for 2h (UTC)",
+		"open until 2026-10-16 14:00 UTC",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+
+	if err := runMaintenanceWindows(&out, &progress, t.TempDir(), maintenanceWindowNow()); err != nil || !strings.Contains(progress.String(), "No maintenance windows") {
+		t.Errorf("a project without windows = %q, %v", progress.String(), err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("a project without windows must print no listing, got %q", out.String())
+	}
+}
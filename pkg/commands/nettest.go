@@ -0,0 +1,545 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	"github.com/siderolabs/talos/pkg/machinery/resources/config"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// CNIs accepted by --cni. auto reads the CNI from each node's machine
+// config, which names flannel when Talos installs it and custom or none
+// when the CNI comes from elsewhere, as with the cozystack preset.
+const (
+	nettestCNIAuto    = "auto"
+	nettestCNIFlannel = constants.FlannelCNI
+	nettestCNICilium  = "cilium"
+	nettestCNIKubeOVN = "kube-ovn"
+	nettestCNINone    = constants.NoneCNI
+)
+
+// Port protocols, as Netstat reports them without the IPv6 suffix.
+const (
+	nettestTCP = "tcp"
+	nettestUDP = "udp"
+)
+
+// nettestPort is a port a node must listen on.
+type nettestPort struct {
+	port         uint32
+	proto        string
+	service      string
+	controlPlane bool
+}
+
+func (p nettestPort) String() string {
+	return strconv.FormatUint(uint64(p.port), 10) + "/" + p.proto
+}
+
+// nettestBasePorts are the ports of Talos and Kubernetes themselves;
+// controlPlane marks the ones only control-plane nodes serve.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var nettestBasePorts = []nettestPort{
+	{port: 50000, proto: nettestTCP, service: "Talos API (apid)"},
+	{port: 50001, proto: nettestTCP, service: "trustd", controlPlane: true},
+	{port: 2379, proto: nettestTCP, service: "etcd client", controlPlane: true},
+	{port: 2380, proto: nettestTCP, service: "etcd peer", controlPlane: true},
+	{port: 6443, proto: nettestTCP, service: "kube-apiserver", controlPlane: true},
+	{port: 10250, proto: nettestTCP, service: "kubelet"},
+}
+
+// nettestCNIPorts are the overlay ports of each CNI, at the CNI's
+// default settings.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var nettestCNIPorts = map[string][]nettestPort{
+	nettestCNIFlannel: {{port: 8472, proto: nettestUDP, service: "flannel VXLAN"}},
+	nettestCNICilium: {
+		{port: 8472, proto: nettestUDP, service: "cilium VXLAN"},
+		{port: 4240, proto: nettestTCP, service: "cilium health"},
+	},
+	nettestCNIKubeOVN: {{port: 6081, proto: nettestUDP, service: "kube-ovn Geneve"}},
+	nettestCNINone:    nil,
+}
+
+//nolint:gochecknoglobals // immutable lookup table.
+var nettestKubeSpanPort = nettestPort{port: 51820, proto: nettestUDP, service: "KubeSpan WireGuard"}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var nettestCmdFlags struct {
+	cni       string
+	listeners bool
+	timeout   time.Duration
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var nettestCmd = &cobra.Command{
+	Use:   "nettest",
+	Short: "Check the network paths between nodes and print a connectivity matrix",
+	Long: `Check that the nodes of the project can reach each other, before a
+firewall between them turns into a bootstrap that never finishes.
+
+The matrix is measured from the nodes themselves: talm connects through
+the Talos API of each node in turn and has it forward a version request
+to every other node, so a cell shows whether the row node reaches the
+column node on the Talos API port, 50000/tcp.
+
+Talos has no API to open an arbitrary connection from a node, so the
+other ports are checked on the receiving side: each node must listen on
+the ports its role needs. Those are 50000 and kubelet 10250 on every
+node, plus 50001 trustd, etcd 2379 and 2380 and kube-apiserver 6443 on
+control-plane nodes, the CNI overlay port and, with KubeSpan enabled,
+51820/udp. The CNI is read from the machine config of each node;
+--cni names it when it is installed outside Talos (cilium, kube-ovn,
+flannel or none). etcd, kube-apiserver and the CNI only listen once
+the cluster is bootstrapped; before that, run with --listeners=false.
+
+Nodes are taken from the modelines of the node files under nodes/, or
+from --nodes. The command exits non-zero when any check fails.`,
+	Example: `  # Before bootstrap: can every node reach every other one?
+  talm nettest --listeners=false
+
+  # After bootstrap, on a cluster running kube-ovn
+  talm nettest --cni kube-ovn`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if _, ok := nettestCNIPorts[nettestCmdFlags.cni]; !ok && nettestCmdFlags.cni != nettestCNIAuto {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("unknown --cni %q", nettestCmdFlags.cni),
+				"use one of: auto, flannel, cilium, kube-ovn, none",
+			)
+		}
+
+		nodes, err := resolveHealthNodes(ui.Progress(os.Stderr))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		run := nettestRun{
+			nodes:     nodes,
+			cni:       nettestCmdFlags.cni,
+			listeners: nettestCmdFlags.listeners,
+			timeout:   nettestCmdFlags.timeout,
+			connect:   talosNettestConnect,
+		}
+
+		return run.execute(ctx).report(cmd.OutOrStdout(), os.Stderr)
+	},
+}
+
+// nettestProbe reads what the checks need through one node's Talos
+// API. talosNettestProbe is the Talos client implementation; tests
+// substitute a fake.
+type nettestProbe interface {
+	// Version asks the node the probe goes through to forward a
+	// version request to node.
+	Version(ctx context.Context, node string) error
+	NodeInfo(ctx context.Context, node string) (nettestNodeInfo, error)
+	Listening(ctx context.Context, node string) ([]nettestSocket, error)
+}
+
+// nettestConnector runs action with a probe that goes through the
+// Talos API of via.
+type nettestConnector func(via string, action func(context.Context, nettestProbe) error) error
+
+// nettestNodeInfo is what the machine config of a node says about the
+// ports it serves.
+type nettestNodeInfo struct {
+	controlPlane bool
+	cni          string
+	kubeSpan     bool
+}
+
+// nettestSocket is a listening socket of a node.
+type nettestSocket struct {
+	proto string
+	port  uint32
+}
+
+// nettestListener is the listener check of one port on one node.
+type nettestListener struct {
+	node      string
+	port      nettestPort
+	listening bool
+}
+
+// nettestRun is one configured run of the checks.
+type nettestRun struct {
+	nodes     []string
+	cni       string
+	listeners bool
+	timeout   time.Duration
+	connect   nettestConnector
+}
+
+// nettestResult holds the outcome of a run. reach[i][j] is the error
+// of nodes[i] forwarding to nodes[j], nil when it got through.
+// listenerErrs holds the nodes whose listeners could not be read, and
+// notes the remarks printed under the tables.
+type nettestResult struct {
+	nodes        []string
+	reach        [][]error
+	listeners    []nettestListener
+	listenerErrs map[string]error
+	notes        []string
+}
+
+// execute runs the checks from every node at once. Each node forwards
+// to all nodes in parallel, so one unreachable target costs a single
+// timeout per row.
+func (r nettestRun) execute(ctx context.Context) nettestResult {
+	res := nettestResult{
+		nodes:        r.nodes,
+		reach:        make([][]error, len(r.nodes)),
+		listenerErrs: map[string]error{},
+	}
+
+	listeners := make([][]nettestListener, len(r.nodes))
+	notes := make([]string, len(r.nodes))
+	listenerErrs := make([]error, len(r.nodes))
+
+	var wg sync.WaitGroup
+
+	for i, via := range r.nodes {
+		res.reach[i] = make([]error, len(r.nodes))
+
+		wg.Go(func() {
+			err := r.connect(via, func(ctx context.Context, p nettestProbe) error {
+				r.probeReach(ctx, p, res.reach[i])
+
+				if r.listeners {
+					listeners[i], notes[i], listenerErrs[i] = r.probeListeners(ctx, p, via)
+				}
+
+				return nil
+			})
+			if err != nil {
+				for j := range res.reach[i] {
+					res.reach[i][j] = err
+				}
+
+				listenerErrs[i] = err
+			}
+		})
+	}
+
+	wg.Wait()
+
+	for i, node := range r.nodes {
+		res.listeners = append(res.listeners, listeners[i]...)
+
+		if notes[i] != "" {
+			res.notes = append(res.notes, notes[i])
+		}
+
+		if r.listeners && listenerErrs[i] != nil {
+			res.listenerErrs[node] = listenerErrs[i]
+		}
+	}
+
+	return res
+}
+
+// probeReach fills row with the outcome of forwarding to every node.
+func (r nettestRun) probeReach(ctx context.Context, p nettestProbe, row []error) {
+	var wg sync.WaitGroup
+
+	for j, target := range r.nodes {
+		wg.Go(func() {
+			callCtx, cancel := r.callContext(ctx)
+			defer cancel()
+
+			row[j] = p.Version(callCtx, target)
+		})
+	}
+
+	wg.Wait()
+}
+
+// probeListeners checks the ports node must listen on. The note says
+// why no CNI port was checked when the CNI is unknown.
+func (r nettestRun) probeListeners(ctx context.Context, p nettestProbe, node string) ([]nettestListener, string, error) {
+	callCtx, cancel := r.callContext(ctx)
+	defer cancel()
+
+	info, err := p.NodeInfo(callCtx, node)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "reading the machine config")
+	}
+
+	sockets, err := p.Listening(callCtx, node)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "listing listening sockets")
+	}
+
+	ports, note := nettestRequiredPorts(info, r.cni)
+
+	if note != "" {
+		note = node + ": " + note
+	}
+
+	checks := make([]nettestListener, 0, len(ports))
+	for _, port := range ports {
+		checks = append(checks, nettestListener{node: node, port: port, listening: slices.Contains(sockets, nettestSocket{proto: port.proto, port: port.port})})
+	}
+
+	return checks, note, nil
+}
+
+func (r nettestRun) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout > 0 {
+		return context.WithTimeout(ctx, r.timeout)
+	}
+
+	return ctx, func() {}
+}
+
+// nettestRequiredPorts returns the ports a node with info must listen
+// on. cni is --cni; under auto the machine config decides, and a CNI
+// Talos does not install adds no port, with a note saying so.
+func nettestRequiredPorts(info nettestNodeInfo, cni string) ([]nettestPort, string) {
+	var (
+		ports []nettestPort
+		note  string
+	)
+
+	for _, port := range nettestBasePorts {
+		if !port.controlPlane || info.controlPlane {
+			ports = append(ports, port)
+		}
+	}
+
+	if cni == nettestCNIAuto {
+		cni = info.cni
+
+		if _, ok := nettestCNIPorts[cni]; !ok || cni == nettestCNINone {
+			cni = nettestCNINone
+			note = fmt.Sprintf("the CNI is installed outside Talos (cni %q); pass --cni to check its overlay port", info.cni)
+		}
+	}
+
+	ports = append(ports, nettestCNIPorts[cni]...)
+
+	if info.kubeSpan {
+		ports = append(ports, nettestKubeSpanPort)
+	}
+
+	return ports, note
+}
+
+// failures counts the failed checks.
+func (res nettestResult) failures() int {
+	n := len(res.listenerErrs)
+
+	for _, row := range res.reach {
+		for _, err := range row {
+			if err != nil {
+				n++
+			}
+		}
+	}
+
+	for _, l := range res.listeners {
+		if !l.listening {
+			n++
+		}
+	}
+
+	return n
+}
+
+// report prints the matrix, the listener table and the failures to
+// out and the notes to progress, and returns an error when anything
+// failed.
+func (res nettestResult) report(out, progress io.Writer) error {
+	fmt.Fprintln(out, "Talos API (50000/tcp), from each row node to each column node:")
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "FROM \\ TO\t%s\n", strings.Join(res.nodes, "\t"))
+
+	for i, node := range res.nodes {
+		cells := make([]string, len(res.nodes))
+		for j, err := range res.reach[i] {
+			cells[j] = "ok"
+			if err != nil {
+				cells[j] = "FAIL"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\n", node, strings.Join(cells, "\t"))
+	}
+
+	_ = w.Flush()
+
+	if len(res.listeners) > 0 {
+		fmt.Fprintln(out)
+
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tPORT\tSERVICE\tSTATUS")
+
+		for _, l := range res.listeners {
+			status := "listening"
+			if !l.listening {
+				status = "NOT LISTENING"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.node, l.port, l.port.service, status)
+		}
+
+		_ = w.Flush()
+	}
+
+	res.reportDetails(out)
+
+	for _, note := range res.notes {
+		ui.Infof(progress, "Note: %s", note)
+	}
+
+	if n := res.failures(); n > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%d network checks failed", n),
+			"a failing row points at traffic leaving that node, a failing column at traffic reaching it; check the firewalls in between",
+		)
+	}
+
+	return nil
+}
+
+// reportDetails prints the reason of every failure.
+func (res nettestResult) reportDetails(out io.Writer) {
+	var lines []string
+
+	for i, from := range res.nodes {
+		for j, err := range res.reach[i] {
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("%s -> %s: %v", from, res.nodes[j], err))
+			}
+		}
+	}
+
+	for _, node := range res.nodes {
+		if err, ok := res.listenerErrs[node]; ok {
+			lines = append(lines, fmt.Sprintf("%s: listeners not checked: %v", node, err))
+		}
+	}
+
+	if len(lines) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Failures:")
+
+		for _, line := range lines {
+			fmt.Fprintln(out, "  "+line)
+		}
+	}
+}
+
+// talosNettestConnect connects through the Talos API of via alone, so
+// every request it forwards leaves from via.
+func talosNettestConnect(via string, action func(context.Context, nettestProbe) error) error {
+	req := talosClientRequest{skipVerify: SkipVerify, endpoints: []string{via}}
+
+	return withTalosClient(req, func(ctx context.Context, c *client.Client) error {
+		return action(ctx, talosNettestProbe{c: c})
+	})
+}
+
+// talosNettestProbe reads the checks' inputs through the Talos API.
+type talosNettestProbe struct {
+	c *client.Client
+}
+
+func (p talosNettestProbe) Version(ctx context.Context, node string) error {
+	_, err := p.c.Version(client.WithNode(ctx, node))
+
+	return err //nolint:wrapcheck // reported verbatim in the failure list.
+}
+
+func (p talosNettestProbe) NodeInfo(ctx context.Context, node string) (nettestNodeInfo, error) {
+	res, err := safe.StateGet[*config.MachineConfig](
+		client.WithNode(ctx, node),
+		p.c.COSI,
+		resource.NewMetadata(config.NamespaceName, config.MachineConfigType, config.ActiveID, resource.VersionUndefined),
+	)
+	if err != nil {
+		return nettestNodeInfo{}, err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	cfg := res.Provider()
+	info := nettestNodeInfo{
+		controlPlane: cfg.Machine().Type().IsControlPlane(),
+		cni:          cfg.Cluster().Network().CNI().Name(),
+	}
+
+	if kubeSpan := cfg.NetworkKubeSpanConfig(); kubeSpan != nil {
+		info.kubeSpan = kubeSpan.Enabled()
+	}
+
+	return info, nil
+}
+
+func (p talosNettestProbe) Listening(ctx context.Context, node string) ([]nettestSocket, error) {
+	resp, err := p.c.Netstat(client.WithNode(ctx, node), &machineapi.NetstatRequest{
+		Filter:  machineapi.NetstatRequest_LISTENING,
+		L4Proto: &machineapi.NetstatRequest_L4Proto{Tcp: true, Tcp6: true, Udp: true, Udp6: true},
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the caller.
+	}
+
+	var sockets []nettestSocket
+
+	for _, msg := range resp.GetMessages() {
+		for _, record := range msg.GetConnectrecord() {
+			sockets = append(sockets, nettestSocket{proto: strings.TrimSuffix(record.GetL4Proto(), "6"), port: record.GetLocalport()})
+		}
+	}
+
+	return sockets, nil
+}
+
+func init() {
+	nettestCmd.Flags().StringVar(&nettestCmdFlags.cni, "cni", nettestCNIAuto, "CNI whose overlay port to check: auto, flannel, cilium, kube-ovn or none")
+	nettestCmd.Flags().BoolVar(&nettestCmdFlags.listeners, "listeners", true, "check the ports each node must listen on; turn off before bootstrap")
+	nettestCmd.Flags().DurationVar(&nettestCmdFlags.timeout, "timeout", 10*time.Second, "time limit for each check")
+
+	addCommand(nettestCmd)
+}
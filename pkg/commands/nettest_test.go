@@ -0,0 +1,210 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// fakeNettestNetwork is a cluster whose blocked paths fail to forward
+// and whose nodes listen on the sockets listed for them.
+type fakeNettestNetwork struct {
+	blocked   map[[2]string]bool
+	down      map[string]bool
+	info      map[string]nettestNodeInfo
+	listening map[string][]nettestSocket
+}
+
+type fakeNettestProbe struct {
+	net *fakeNettestNetwork
+	via string
+}
+
+func (n *fakeNettestNetwork) connect(via string, action func(context.Context, nettestProbe) error) error {
+	if n.down[via] {
+		return errors.Newf("dial %s:50000: connection refused", via)
+	}
+
+	return action(context.Background(), fakeNettestProbe{net: n, via: via})
+}
+
+func (p fakeNettestProbe) Version(_ context.Context, node string) error {
+	if p.net.blocked[[2]string{p.via, node}] || p.net.down[node] {
+		return errors.Newf("%s: i/o timeout", node)
+	}
+
+	return nil
+}
+
+func (p fakeNettestProbe) NodeInfo(_ context.Context, node string) (nettestNodeInfo, error) {
+	return p.net.info[node], nil
+}
+
+func (p fakeNettestProbe) Listening(_ context.Context, node string) ([]nettestSocket, error) {
+	return p.net.listening[node], nil
+}
+
+func nettestSockets(ports ...nettestPort) []nettestSocket {
+	sockets := make([]nettestSocket, 0, len(ports))
+	for _, port := range ports {
+		sockets = append(sockets, nettestSocket{proto: port.proto, port: port.port})
+	}
+
+	return sockets
+}
+
+func TestNettestRequiredPorts(t *testing.T) {
+	t.Parallel()
+
+	names := func(ports []nettestPort) []string {
+		var out []string
+		for _, p := range ports {
+			out = append(out, p.String())
+		}
+
+		return out
+	}
+
+	ports, note := nettestRequiredPorts(nettestNodeInfo{controlPlane: true, cni: nettestCNIFlannel}, nettestCNIAuto)
+	if want := []string{"50000/tcp", "50001/tcp", "2379/tcp", "2380/tcp", "6443/tcp", "10250/tcp", "8472/udp"}; !reflect.DeepEqual(names(ports), want) || note != "" {
+		t.Errorf("control plane with flannel: %v, %q", names(ports), note)
+	}
+
+	ports, note = nettestRequiredPorts(nettestNodeInfo{cni: "none", kubeSpan: true}, nettestCNIAuto)
+	if want := []string{"50000/tcp", "10250/tcp", "51820/udp"}; !reflect.DeepEqual(names(ports), want) || !strings.Contains(note, "--cni") {
+		t.Errorf("worker with an external CNI: %v, %q", names(ports), note)
+	}
+
+	ports, note = nettestRequiredPorts(nettestNodeInfo{cni: "none"}, nettestCNIKubeOVN)
+	if want := []string{"50000/tcp", "10250/tcp", "6081/udp"}; !reflect.DeepEqual(names(ports), want) || note != "" {
+		t.Errorf("worker with --cni kube-ovn: %v, %q", names(ports), note)
+	}
+}
+
+// TestNettestRun pins the matrix orientation: a path blocked from cp1
+// to w1 fails the cp1 row in the w1 column only.
+func TestNettestRun(t *testing.T) {
+	t.Parallel()
+
+	cpPorts, _ := nettestRequiredPorts(nettestNodeInfo{controlPlane: true, cni: nettestCNIFlannel}, nettestCNIAuto)
+	workerPorts, _ := nettestRequiredPorts(nettestNodeInfo{cni: nettestCNIFlannel}, nettestCNIAuto)
+
+	network := &fakeNettestNetwork{
+		blocked: map[[2]string]bool{{"192.0.2.11", "192.0.2.21"}: true},
+		info: map[string]nettestNodeInfo{
+			"192.0.2.11": {controlPlane: true, cni: nettestCNIFlannel},
+			"192.0.2.21": {cni: nettestCNIFlannel},
+		},
+		listening: map[string][]nettestSocket{
+			"192.0.2.11": nettestSockets(cpPorts...),
+			// The worker has no VXLAN socket.
+			"192.0.2.21": nettestSockets(workerPorts[:len(workerPorts)-1]...),
+		},
+	}
+
+	run := nettestRun{nodes: []string{"192.0.2.11", "192.0.2.21"}, cni: nettestCNIAuto, listeners: true, connect: network.connect}
+	res := run.execute(context.Background())
+
+	var failed [][2]int
+
+	for i, row := range res.reach {
+		for j, err := range row {
+			if err != nil {
+				failed = append(failed, [2]int{i, j})
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(failed, [][2]int{{0, 1}}) {
+		t.Errorf("failed cells = %v, want only cp1 -> w1", failed)
+	}
+
+	var notListening []string
+
+	for _, l := range res.listeners {
+		if !l.listening {
+			notListening = append(notListening, l.node+" "+l.port.String())
+		}
+	}
+
+	if !reflect.DeepEqual(notListening, []string{"192.0.2.21 8472/udp"}) {
+		t.Errorf("not listening = %v", notListening)
+	}
+
+	var out bytes.Buffer
+
+	err := res.report(&out, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "2 network checks failed") {
+		t.Errorf("report error = %v", err)
+	}
+
+	for _, want := range []string{
+		"192.0.2.11  ok          FAIL",
+		"192.0.2.21  8472/udp   flannel VXLAN     NOT LISTENING",
+		"192.0.2.11 -> 192.0.2.21: 192.0.2.21: i/o timeout",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestNettestRun_UnreachableSource pins that a node whose own API is
+// down fails its whole row and its column, and that with listeners off
+// only the matrix is reported.
+func TestNettestRun_UnreachableSource(t *testing.T) {
+	t.Parallel()
+
+	network := &fakeNettestNetwork{down: map[string]bool{"192.0.2.12": true}}
+	run := nettestRun{nodes: []string{"192.0.2.11", "192.0.2.12"}, cni: nettestCNIAuto, connect: network.connect}
+
+	res := run.execute(context.Background())
+
+	if res.failures() != 3 || len(res.listeners) != 0 || len(res.listenerErrs) != 0 {
+		t.Errorf("failures = %d, listeners = %v, listenerErrs = %v", res.failures(), res.listeners, res.listenerErrs)
+	}
+
+	if !strings.Contains(res.reach[1][0].Error(), "connection refused") {
+		t.Errorf("row of the down node = %v", res.reach[1][0])
+	}
+}
+
+// TestNettestReport_NotesOnProgress pins that the notes under the
+// tables are log lines on progress, not part of the report.
+func TestNettestReport_NotesOnProgress(t *testing.T) {
+	t.Parallel()
+
+	res := nettestResult{
+		nodes: []string{"192.0.2.11"},
+		reach: [][]error{{nil}},
+		notes: []string{"192.0.2.11: the CNI is installed outside Talos"},
+	}
+
+	var out, progress bytes.Buffer
+
+	if err := res.report(&out, &progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), "CNI") || !strings.Contains(progress.String(), "Note: 192.0.2.11: the CNI is installed outside Talos") {
+		t.Errorf("report:\n%s\nprogress:\n%s", out.String(), progress.String())
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+// Node file formats `talm template --format` writes. YAML is the
+// default; JSON is the modeline.EncodeJSONNodeFile form, which every
+// reader (apply, upgrade, completion, template itself) detects by
+// content, not by extension.
+const (
+	nodeFileFormatYAML = "yaml"
+	nodeFileFormatJSON = "json"
+)
+
+// validateNodeFileFormat rejects a --format value other than yaml or
+// json. Empty means "same as the source".
+func validateNodeFileFormat(format string) error {
+	switch format {
+	case "", nodeFileFormatYAML, nodeFileFormatJSON:
+		return nil
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown node file format %q", format),
+			"use --format yaml or --format json",
+		)
+	}
+}
+
+// resolveNodeFileFormat picks the output format of one render: the
+// --format value when set, otherwise the format of the node file the
+// render came from, so `talm template -f nodes/cp.json -I` keeps the
+// file JSON. Renders without a node file default to YAML.
+func resolveNodeFileFormat(format, configFile string) string {
+	if format != "" {
+		return format
+	}
+
+	if configFile == "" {
+		return nodeFileFormatYAML
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err == nil && modeline.IsJSONNodeFile(data) {
+		return nodeFileFormatJSON
+	}
+
+	return nodeFileFormatYAML
+}
+
+// encodeNodeFileOutput converts the YAML output of generateOutput to
+// format. The modeline on its first line becomes the "talm" object and
+// the rest the "documents"; the autogenerated-file warning and every
+// other comment have no JSON form and are dropped.
+func encodeNodeFileOutput(output, format string) (string, error) {
+	if format != nodeFileFormatJSON {
+		return output, nil
+	}
+
+	firstLine, _, _ := strings.Cut(output, "\n")
+
+	config, err := modeline.ParseModeline(firstLine)
+	if err != nil {
+		return "", errors.Wrap(err, "reading the modeline of the rendered output")
+	}
+
+	data, err := modeline.EncodeJSONNodeFile(config, []byte(output))
+	if err != nil {
+		return "", errors.Wrap(err, "encoding the rendered output as JSON")
+	}
+
+	return string(data), nil
+}
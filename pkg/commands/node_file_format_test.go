@@ -0,0 +1,152 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+const testJSONNodeBody = `{"talm": {"nodes": ["192.0.2.10"], "templates": ["templates/controlplane.yaml"]}, "documents": [{"machine": {"install": {"image": "` + testOldImage + `"}}}]}` + "\n"
+
+func TestValidateNodeFileFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{"", nodeFileFormatYAML, nodeFileFormatJSON} {
+		if err := validateNodeFileFormat(format); err != nil {
+			t.Errorf("%q: %v", format, err)
+		}
+	}
+
+	if err := validateNodeFileFormat("toml"); err == nil {
+		t.Error("an unknown format must be rejected")
+	}
+}
+
+func TestResolveNodeFileFormat(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "cp1.yaml")
+	yamlFile := filepath.Join(dir, "cp2.yaml")
+
+	writeFile(t, dir, "cp1.yaml", testJSONNodeBody)
+	writeFile(t, dir, "cp2.yaml", "# talm: nodes=[\"192.0.2.11\"]\nmachine: {}\n")
+
+	for _, tc := range []struct {
+		flag, file, want string
+	}{
+		{flag: "", file: "", want: nodeFileFormatYAML},
+		{flag: "", file: jsonFile, want: nodeFileFormatJSON},
+		{flag: "", file: yamlFile, want: nodeFileFormatYAML},
+		{flag: "", file: filepath.Join(dir, "missing.yaml"), want: nodeFileFormatYAML},
+		{flag: nodeFileFormatYAML, file: jsonFile, want: nodeFileFormatYAML},
+		{flag: nodeFileFormatJSON, file: yamlFile, want: nodeFileFormatJSON},
+	} {
+		if got := resolveNodeFileFormat(tc.flag, tc.file); got != tc.want {
+			t.Errorf("resolveNodeFileFormat(%q, %q) = %q, want %q", tc.flag, tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeNodeFileOutput(t *testing.T) {
+	t.Parallel()
+
+	output := "# talm: nodes=[\"192.0.2.10\"], endpoints=[\"192.0.2.10\"], templates=[\"templates/worker.yaml\"]\n" +
+		"# THIS FILE IS AUTOGENERATED. PREFER TEMPLATE EDITS OVER MANUAL ONES.\n" +
+		"machine:\n  type: worker\n"
+
+	if got, err := encodeNodeFileOutput(output, nodeFileFormatYAML); err != nil || got != output {
+		t.Errorf("YAML output must pass through, got %q, %v", got, err)
+	}
+
+	got, err := encodeNodeFileOutput(output, nodeFileFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(got, "AUTOGENERATED") {
+		t.Errorf("comments have no JSON form:\n%s", got)
+	}
+
+	config, body, err := modeline.ParseJSONNodeFile([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(config.Endpoints, ",") != "192.0.2.10" || strings.Join(config.Templates, ",") != "templates/worker.yaml" {
+		t.Errorf("modeline = %+v", config)
+	}
+
+	if string(body) != "machine:\n  type: worker\n" {
+		t.Errorf("documents = %q", body)
+	}
+}
+
+func TestIsNodeFileCandidate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeFile(t, dir, "cp1.json", testJSONNodeBody)
+	writeFile(t, dir, "patch.json", `{"machine": {}}`)
+
+	for name, want := range map[string]bool{
+		"cp1.yaml":   true,
+		"cp1.yml":    true,
+		"cp1.json":   true,
+		"patch.json": false,
+		"gone.json":  false,
+		"notes.txt":  false,
+	} {
+		if got := isNodeFileCandidate(filepath.Join(dir, name)); got != want {
+			t.Errorf("isNodeFileCandidate(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestWriteBackInstallImage_JSONNodeFile pins that the upgrade
+// writeback keeps a JSON node file JSON.
+func TestWriteBackInstallImage_JSONNodeFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cp1.json")
+
+	writeFile(t, dir, "cp1.json", testJSONNodeBody)
+
+	patched, err := writeBackInstallImageToNodeBody(path, testNewImage)
+	if err != nil || !patched {
+		t.Fatalf("patched = %v, err = %v", patched, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, body, err := modeline.ParseJSONNodeFile(data)
+	if err != nil {
+		t.Fatalf("the file must stay a JSON node file: %v\n%s", err, data)
+	}
+
+	if strings.Join(config.Nodes, ",") != "192.0.2.10" || !strings.Contains(string(body), "image: "+testNewImage) {
+		t.Errorf("written back =\n%s", data)
+	}
+}
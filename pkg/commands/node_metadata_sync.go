@@ -0,0 +1,320 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// valuesNodesKey is the values.yaml key carrying per-node project
+// metadata, keyed by the node address used in node-file modelines.
+const valuesNodesKey = "nodes"
+
+// nodeMetadata is one entry of the values.yaml `nodes` map: the
+// Kubernetes Node labels and annotations the project declares for a
+// machine. Only these two fields are read; anything else under the
+// entry is left to the chart.
+type nodeMetadata struct {
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// loadNodeMetadata reads the `nodes` map from the project values.yaml.
+// A missing values.yaml or a missing `nodes` key yields an empty map —
+// the sync is opt-in and a project that declares nothing has nothing
+// to sync. Label keys and values are validated up front so a typo
+// surfaces with the node it belongs to rather than as an opaque
+// apiserver rejection halfway through the sync.
+func loadNodeMetadata(rootDir string) (map[string]nodeMetadata, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]nodeMetadata{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	var values struct {
+		Nodes map[string]nodeMetadata `yaml:"nodes"`
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(err, "parsing `%s` in %s", valuesNodesKey, valuesPath),
+			"each `%s` entry is keyed by node address and may carry `labels` and `annotations` string maps", valuesNodesKey,
+		)
+	}
+
+	for node, meta := range values.Nodes {
+		if err := validateNodeMetadata(meta); err != nil {
+			return nil, errors.Wrapf(err, "%s: %s.%s", valuesPath, valuesNodesKey, node)
+		}
+	}
+
+	if values.Nodes == nil {
+		return map[string]nodeMetadata{}, nil
+	}
+
+	return values.Nodes, nil
+}
+
+// validateNodeMetadata applies the Kubernetes label and annotation
+// key/value rules.
+func validateNodeMetadata(meta nodeMetadata) error {
+	for key, value := range meta.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return errors.Newf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return errors.Newf("invalid value %q for label %q: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+
+	for key := range meta.Annotations {
+		if errs := validation.IsQualifiedName(strings.ToLower(key)); len(errs) > 0 {
+			return errors.Newf("invalid annotation key %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+
+	return nil
+}
+
+// nodeMetadataPatch builds the JSON merge patch that sets meta on a
+// Node. A merge patch only adds or overwrites the listed keys; labels
+// and annotations owned by kubelet, Talos or other controllers are
+// left alone, and keys removed from values.yaml stay on the Node until
+// removed by hand.
+func nodeMetadataPatch(meta nodeMetadata) ([]byte, error) {
+	patch := map[string]any{
+		"metadata": map[string]any{
+			"labels":      meta.Labels,
+			"annotations": meta.Annotations,
+		},
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding node metadata patch")
+	}
+
+	return data, nil
+}
+
+// findKubernetesNodeName maps a talm node address onto the name of the
+// Kubernetes Node it registered as. Node names are hostnames, while
+// modelines carry addresses, so both the name and every status address
+// are compared.
+func findKubernetesNodeName(nodes []v1.Node, address string) (string, bool) {
+	for i := range nodes {
+		if nodes[i].Name == address {
+			return nodes[i].Name, true
+		}
+
+		for _, addr := range nodes[i].Status.Addresses {
+			if addr.Address == address {
+				return nodes[i].Name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// syncNodeMetadata patches the declared metadata onto the Kubernetes
+// Node of every target that has an entry in meta. Targets without an
+// entry are skipped silently; targets whose Node has not registered
+// yet (a fresh machine still installing) get a notice rather than an
+// error, because re-running apply after the kubelet joins completes
+// the sync. Patch failures are collected per node so one bad node
+// does not hide the outcome of the rest.
+func syncNodeMetadata(ctx context.Context, clientset kubernetes.Interface, targets []string, meta map[string]nodeMetadata, w io.Writer) error {
+	pending := make([]string, 0, len(targets))
+
+	for _, target := range targets {
+		if _, ok := meta[target]; ok {
+			pending = append(pending, target)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing Kubernetes nodes")
+	}
+
+	var perNodeErrs []error
+
+	for _, target := range pending {
+		name, ok := findKubernetesNodeName(nodeList.Items, target)
+		if !ok {
+			fmt.Fprintf(w, "  node metadata: %s has not registered in Kubernetes yet; re-run apply once it joins\n", target)
+
+			continue
+		}
+
+		patch, err := nodeMetadataPatch(meta[target])
+		if err != nil {
+			return err
+		}
+
+		if _, err := clientset.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			perNodeErrs = append(perNodeErrs, errors.Wrapf(err, "patching Kubernetes node %s (%s)", name, target))
+
+			continue
+		}
+
+		fmt.Fprintf(w, "  node metadata: %s (%s) labels=[%s] annotations=[%s]\n",
+			name, target, strings.Join(sortedKeys(meta[target].Labels), ","), strings.Join(sortedKeys(meta[target].Annotations), ","))
+	}
+
+	return errors.Join(perNodeErrs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// projectKubeconfigPath resolves the project kubeconfig the same way
+// init and the .gitignore writer do: Chart.yaml
+// globalOptions.kubeconfig, defaulting to `kubeconfig`, relative to
+// the project root.
+func projectKubeconfigPath() string {
+	kubeconfigPath := Config.GlobalOptions.Kubeconfig
+	if kubeconfigPath == "" {
+		kubeconfigPath = defaultKubeconfigName
+	}
+
+	if filepath.IsAbs(kubeconfigPath) {
+		return kubeconfigPath
+	}
+
+	return filepath.Join(Config.RootDir, kubeconfigPath)
+}
+
+// applyThenSyncNodeMetadata runs apply and, once it succeeded, the
+// post-apply sync for the nodes it applied to. The targets come from
+// --nodes, the modeline or the talosconfig context, so they are taken
+// from the operation journal, which saw every node the apply reached.
+func applyThenSyncNodeMetadata(apply func() error, w io.Writer) error {
+	if err := apply(); err != nil {
+		return err
+	}
+
+	syncNodeMetadataAfterApply(currentJournal().appliedNodes(), w)
+
+	return nil
+}
+
+// syncNodeMetadataAfterApply runs the opt-in post-apply sync for the
+// nodes the apply just targeted. It is skipped on --dry-run (nothing
+// was applied) and on --insecure (a maintenance-mode machine is not a
+// cluster member yet). The apply itself already succeeded, so every
+// failure here is reported as a warning instead of failing the
+// command.
+func syncNodeMetadataAfterApply(targets []string, w io.Writer) {
+	if !applyCmdFlags.syncNodeMetadata || applyCmdFlags.dryRun || applyCmdFlags.insecure || len(targets) == 0 {
+		return
+	}
+
+	if err := runNodeMetadataSync(targets, w); err != nil {
+		ui.WarnError(w, errors.Wrap(err, "node metadata sync failed"))
+	}
+}
+
+func runNodeMetadataSync(targets []string, w io.Writer) error {
+	meta, err := loadNodeMetadata(Config.RootDir)
+	if err != nil {
+		return err
+	}
+
+	if len(meta) == 0 {
+		return nil
+	}
+
+	clientset, err := nodeMetadataKubeClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	return syncNodeMetadata(ctx, clientset, targets, meta, w)
+}
+
+// nodeMetadataKubeClient builds the client the post-apply sync patches
+// Nodes through.
+//
+//nolint:gochecknoglobals // function-type indirection for test injection, like stdinIsTTY.
+var nodeMetadataKubeClient = projectKubeClient
+
+// projectKubeClient builds a Kubernetes client from the project
+// kubeconfig.
+func projectKubeClient() (kubernetes.Interface, error) {
+	kubeconfigPath := projectKubeconfigPath()
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(err, "loading kubeconfig %s", kubeconfigPath),
+			"run `talm kubeconfig -f <control-plane node file>` to fetch the project kubeconfig, or set globalOptions.kubeconfig in %s", chartYamlName,
+		)
+	}
+
+	if err := trustCABundle(restConfig); err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating Kubernetes client")
+	}
+
+	return clientset, nil
+}
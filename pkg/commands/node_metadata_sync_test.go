@@ -0,0 +1,233 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func writeNodeMetadataValues(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, valuesYamlName), []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// TestLoadNodeMetadata_ReadsNodesMap pins the values.yaml shape: a
+// `nodes` map keyed by node address carrying labels and annotations,
+// with unrelated top-level keys ignored.
+func TestLoadNodeMetadata_ReadsNodesMap(t *testing.T) {
+	t.Parallel()
+
+	dir := writeNodeMetadataValues(t, `endpoint: https://192.0.2.1:6443
+nodes:
+  192.0.2.10:
+    labels:
+      topology.kubernetes.io/zone: eu-1a
+    annotations:
+      example.com/rack: r12
+`)
+
+	meta, err := loadNodeMetadata(dir)
+	if err != nil {
+		t.Fatalf("loadNodeMetadata: %v", err)
+	}
+
+	got := meta["192.0.2.10"]
+	if got.Labels["topology.kubernetes.io/zone"] != "eu-1a" || got.Annotations["example.com/rack"] != "r12" {
+		t.Errorf("unexpected metadata: %+v", got)
+	}
+}
+
+// TestLoadNodeMetadata_AbsentIsEmpty pins that a project without a
+// values.yaml or without the `nodes` key has nothing to sync.
+func TestLoadNodeMetadata_AbsentIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	for name, dir := range map[string]string{
+		"no values.yaml": t.TempDir(),
+		"no nodes key":   writeNodeMetadataValues(t, "endpoint: https://192.0.2.1:6443\n"),
+	} {
+		meta, err := loadNodeMetadata(dir)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		if len(meta) != 0 {
+			t.Errorf("%s: expected empty metadata, got %v", name, meta)
+		}
+	}
+}
+
+// TestLoadNodeMetadata_RejectsInvalidLabel pins the up-front
+// validation: the error names the node entry carrying the bad key.
+func TestLoadNodeMetadata_RejectsInvalidLabel(t *testing.T) {
+	t.Parallel()
+
+	dir := writeNodeMetadataValues(t, `nodes:
+  192.0.2.10:
+    labels:
+      "bad key!": x
+`)
+
+	_, err := loadNodeMetadata(dir)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	if !strings.Contains(err.Error(), "nodes.192.0.2.10") || !strings.Contains(err.Error(), "bad key!") {
+		t.Errorf("error must name the node entry and key, got %q", err.Error())
+	}
+}
+
+// TestSyncNodeMetadata_PatchesMatchingNode pins the address-to-Node
+// mapping (modelines carry addresses, Nodes are named by hostname)
+// and that the patch merges rather than replaces existing labels.
+func TestSyncNodeMetadata_PatchesMatchingNode(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "cp01",
+			Labels: map[string]string{"kubernetes.io/hostname": "cp01"},
+		},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.0.2.10"}}},
+	})
+
+	meta := map[string]nodeMetadata{
+		"192.0.2.10": {
+			Labels:      map[string]string{"topology.kubernetes.io/zone": "eu-1a"},
+			Annotations: map[string]string{"example.com/rack": "r12"},
+		},
+		"192.0.2.99": {Labels: map[string]string{"unused": "true"}},
+	}
+
+	var out bytes.Buffer
+
+	if err := syncNodeMetadata(context.Background(), clientset, []string{"192.0.2.10", "192.0.2.11"}, meta, &out); err != nil {
+		t.Fatalf("syncNodeMetadata: %v", err)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "cp01", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.Labels["topology.kubernetes.io/zone"] != "eu-1a" {
+		t.Errorf("zone label not synced: %v", node.Labels)
+	}
+
+	if node.Labels["kubernetes.io/hostname"] != "cp01" {
+		t.Errorf("existing labels must survive the merge patch: %v", node.Labels)
+	}
+
+	if node.Annotations["example.com/rack"] != "r12" {
+		t.Errorf("rack annotation not synced: %v", node.Annotations)
+	}
+
+	if !strings.Contains(out.String(), "cp01 (192.0.2.10)") {
+		t.Errorf("progress line must name the node, got %q", out.String())
+	}
+}
+
+// TestSyncNodeMetadata_UnregisteredNodeIsNotice pins that a target
+// whose Node has not joined yet produces a notice, not an error.
+func TestSyncNodeMetadata_UnregisteredNodeIsNotice(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewClientset()
+	meta := map[string]nodeMetadata{"192.0.2.10": {Labels: map[string]string{"zone": "a"}}}
+
+	var out bytes.Buffer
+
+	if err := syncNodeMetadata(context.Background(), clientset, []string{"192.0.2.10"}, meta, &out); err != nil {
+		t.Fatalf("unregistered node must not fail the sync: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "has not registered") {
+		t.Errorf("expected a not-registered notice, got %q", out.String())
+	}
+}
+
+// TestApplyThenSyncNodeMetadata_NodesFromModeline pins the sync
+// targets for an apply without --nodes: the nodes came from the
+// modeline, so the sync patches the ones the journal saw applied, and
+// skips the ones that failed.
+func TestApplyThenSyncNodeMetadata_NodesFromModeline(t *testing.T) {
+	withStateProject(t)
+
+	origSync, origClient := applyCmdFlags.syncNodeMetadata, nodeMetadataKubeClient
+
+	t.Cleanup(func() {
+		applyCmdFlags.syncNodeMetadata, nodeMetadataKubeClient = origSync, origClient
+	})
+
+	Config.RootDir = writeNodeMetadataValues(t, `nodes:
+  192.0.2.10:
+    labels:
+      zone: a
+  192.0.2.11:
+    labels:
+      zone: b
+`)
+	GlobalArgs.Nodes = nil
+	applyCmdFlags.syncNodeMetadata = true
+
+	clientset := fake.NewClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp1"}, Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.0.2.10"}}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp2"}, Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.0.2.11"}}}},
+	)
+	nodeMetadataKubeClient = func() (kubernetes.Interface, error) { return clientset, nil }
+
+	var out bytes.Buffer
+
+	err := withApplyState("nodes/cp.yaml", false, func() error {
+		return applyThenSyncNodeMetadata(func() error {
+			currentJournal().noteResult("192.0.2.10", nil)
+			currentJournal().noteResult("192.0.2.11", errors.New("connection refused"))
+
+			return nil
+		}, &out)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"cp1": "a", "cp2": ""} {
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := node.Labels["zone"]; got != want {
+			t.Errorf("node %s: zone label = %q, want %q (output %q)", name, got, want, out.String())
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	machineryconfig "github.com/siderolabs/talos/pkg/machinery/config"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeTalosVersionKey is the key of a values.yaml `nodes` entry that
+// pins the Talos version the node's config is rendered for.
+const nodeTalosVersionKey = "talosVersion"
+
+// nodeTalosVersionPin is a node file whose nodes render for another
+// Talos version contract than the project default.
+type nodeTalosVersionPin struct {
+	path    string
+	version string
+}
+
+// nodeTalosVersionProblem is a node file whose Talos version cannot be
+// resolved.
+type nodeTalosVersionProblem struct {
+	path string
+	err  error
+}
+
+// loadNodeTalosVersions reads the talosVersion of every values.yaml
+// `nodes` entry that sets one, keyed by the canonical node address. A
+// project without values.yaml or without pins has none.
+func loadNodeTalosVersions(rootDir string) (map[string]string, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	var values struct {
+		Nodes map[string]struct {
+			TalosVersion string `yaml:"talosVersion"`
+		} `yaml:"nodes"`
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrapf(err, "parsing `%s` in %s", valuesNodesKey, valuesPath)
+	}
+
+	pins := map[string]string{}
+
+	for node, entry := range values.Nodes {
+		version := strings.TrimSpace(entry.TalosVersion)
+		if version == "" {
+			continue
+		}
+
+		if _, err := machineryconfig.ParseContractFromVersion(version); err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("%s.%s.%s in %s is not a Talos version: %q", valuesNodesKey, node, nodeTalosVersionKey, valuesYamlName, version),
+				"write the version the node runs, e.g. %s: v1.9.5", nodeTalosVersionKey,
+			)
+		}
+
+		pins[canonicalNodeTarget(node)] = version
+	}
+
+	return pins, nil
+}
+
+// resolveNodeTalosVersion returns the Talos version a node file that
+// targets nodes renders for: the version values.yaml pins for them, or
+// fallback, the project default. One render serves every node of the
+// file, so the nodes must agree on the contract.
+func resolveNodeTalosVersion(pins map[string]string, nodes []string, fallback string) (string, error) {
+	var (
+		versions []string
+		details  []string
+	)
+
+	for _, node := range nodes {
+		version, pinned := pins[canonicalNodeTarget(node)]
+		if !pinned {
+			version = fallback
+		}
+
+		if !slices.ContainsFunc(versions, func(v string) bool { return sameTalosContract(v, version) }) {
+			versions = append(versions, version)
+		}
+
+		if pinned {
+			details = append(details, fmt.Sprintf("%s %s", node, version))
+		} else {
+			details = append(details, fmt.Sprintf("%s %s (project default)", node, cmp.Or(version, "current")))
+		}
+	}
+
+	switch len(versions) {
+	case 0:
+		return fallback, nil
+	case 1:
+		return versions[0], nil
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHintf(
+			errors.Newf("the nodes of one node file render for different Talos versions: %s", strings.Join(details, ", ")),
+			"give those nodes node files of their own, or set the same %s.<node>.%s in %s", valuesNodesKey, nodeTalosVersionKey, valuesYamlName,
+		)
+	}
+}
+
+// nodeTalosVersion resolves the Talos version for the nodes of a node
+// file in the project at rootDir.
+func nodeTalosVersion(rootDir string, nodes []string, fallback string) (string, error) {
+	pins, err := loadNodeTalosVersions(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	return resolveNodeTalosVersion(pins, nodes, fallback)
+}
+
+// sameTalosContract reports whether versions a and b render configs
+// for the same contract; an empty version is the current one.
+func sameTalosContract(a, b string) bool {
+	contract := func(version string) string {
+		if version == "" {
+			return machineryconfig.TalosVersionCurrent.String()
+		}
+
+		parsed, err := machineryconfig.ParseContractFromVersion(version)
+		if err != nil {
+			return version
+		}
+
+		return parsed.String()
+	}
+
+	return contract(a) == contract(b)
+}
+
+// collectNodeTalosVersions resolves the Talos version of every node
+// file. It returns the files that render for another contract than
+// fallback, and those whose version cannot be resolved.
+func collectNodeTalosVersions(rootDir string, files []pruneNodeFile, fallback string) ([]nodeTalosVersionPin, []nodeTalosVersionProblem, error) {
+	pins, err := loadNodeTalosVersions(rootDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		pinned   []nodeTalosVersionPin
+		problems []nodeTalosVersionProblem
+	)
+
+	for _, file := range files {
+		version, err := resolveNodeTalosVersion(pins, file.nodes, fallback)
+		if err != nil {
+			problems = append(problems, nodeTalosVersionProblem{path: file.path, err: err})
+
+			continue
+		}
+
+		if !sameTalosContract(version, fallback) {
+			pinned = append(pinned, nodeTalosVersionPin{path: file.path, version: version})
+		}
+	}
+
+	return pinned, problems, nil
+}
+
+func printNodeTalosVersions(w io.Writer, pinned []nodeTalosVersionPin, problems []nodeTalosVersionProblem, fallback string) {
+	if len(pinned) > 0 {
+		fmt.Fprintf(w, "node files rendered for another Talos version than the project's %s:\n", cmp.Or(fallback, "current"))
+
+		for _, pin := range pinned {
+			fmt.Fprintf(w, "  %s: %s\n", pin.path, pin.version)
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintln(w, "node files without a single Talos version:")
+
+		for _, problem := range problems {
+			fmt.Fprintf(w, "  %s: %v\n", problem.path, problem.err)
+		}
+	}
+}
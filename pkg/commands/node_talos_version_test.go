@@ -0,0 +1,154 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testNodeTalosVersionValues = `nodes:
+  "2001:0db8::1":
+    talosVersion: v1.8.3
+  192.0.2.11:
+    talosVersion: v1.9.2
+    labels:
+      zone: a
+  192.0.2.12:
+    labels:
+      zone: b
+`
+
+func TestLoadNodeTalosVersions(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{"values.yaml": testNodeTalosVersionValues})
+
+	pins, err := loadNodeTalosVersions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pins) != 2 || pins["2001:db8::1"] != "v1.8.3" || pins["192.0.2.11"] != "v1.9.2" {
+		t.Errorf("pins = %v", pins)
+	}
+
+	if pins, err := loadNodeTalosVersions(t.TempDir()); err != nil || len(pins) != 0 {
+		t.Errorf("a project without values.yaml pins nothing, got %v, %v", pins, err)
+	}
+}
+
+func TestLoadNodeTalosVersions_Invalid(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{"values.yaml": "nodes:\n  192.0.2.10:\n    talosVersion: latest\n"})
+
+	_, err := loadNodeTalosVersions(dir)
+	if err == nil || !strings.Contains(err.Error(), `nodes.192.0.2.10.talosVersion in values.yaml is not a Talos version: "latest"`) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestResolveNodeTalosVersion(t *testing.T) {
+	t.Parallel()
+
+	pins := map[string]string{"2001:db8::1": "v1.8.3", "192.0.2.11": "v1.9.2", "192.0.2.13": "v1.9.2", "192.0.2.14": "v1.9.5"}
+
+	for _, tc := range []struct {
+		nodes []string
+		want  string
+	}{
+		{[]string{"2001:0db8::1"}, "v1.8.3"},
+		{[]string{"192.0.2.11", "192.0.2.13"}, "v1.9.2"},
+		{[]string{"192.0.2.11", "192.0.2.14"}, "v1.9.2"},
+		{[]string{"192.0.2.12"}, "v1.10.0"},
+		{nil, "v1.10.0"},
+	} {
+		got, err := resolveNodeTalosVersion(pins, tc.nodes, "v1.10.0")
+		if err != nil || got != tc.want {
+			t.Errorf("resolveNodeTalosVersion(%v) = %q, %v; want %q", tc.nodes, got, err, tc.want)
+		}
+	}
+
+	_, err := resolveNodeTalosVersion(pins, []string{"192.0.2.11", "192.0.2.12"}, "")
+	if err == nil || !strings.Contains(err.Error(), "192.0.2.11 v1.9.2, 192.0.2.12 current (project default)") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestSameTalosContract(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.9.0", "1.9.5", true},
+		{"v1.9.0", "v1.10.0", false},
+		{"", "", true},
+		{"", "v1.9.0", false},
+	} {
+		if got := sameTalosContract(tc.a, tc.b); got != tc.want {
+			t.Errorf("sameTalosContract(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// TestRunValidate_TalosVersions pins that validate lists the node files
+// pinned to another contract, and fails on a file whose nodes disagree.
+func TestRunValidate_TalosVersions(t *testing.T) {
+	origRoot, origVersion := Config.RootDir, Config.TemplateOptions.TalosVersion
+	t.Cleanup(func() { Config.RootDir, Config.TemplateOptions.TalosVersion = origRoot, origVersion })
+
+	Config.TemplateOptions.TalosVersion = "v1.9.0"
+	Config.RootDir = writePruneProject(t, map[string]string{
+		"values.yaml":    testNodeTalosVersionValues,
+		"nodes/cp1.yaml": "# talm: nodes=[\"2001:db8::1\"]\n",
+		"nodes/cp2.yaml": "# talm: nodes=[\"192.0.2.11\"]\n",
+		"nodes/w1.yaml":  "# talm: nodes=[\"192.0.2.12\"]\n",
+	})
+
+	var out bytes.Buffer
+	if err := runValidate(&out, &bytes.Buffer{}); err != nil {
+		t.Fatalf("pins that agree per file must pass: %v", err)
+	}
+
+	want := "node files rendered for another Talos version than the project's v1.9.0:\n  " + filepath.Join("nodes", "cp1.yaml") + ": v1.8.3\n"
+	if !strings.Contains(out.String(), want) || strings.Contains(out.String(), "cp2.yaml") {
+		t.Errorf("output =\n%s\nwant it to list cp1 alone:\n%s", out.String(), want)
+	}
+
+	if err := os.Remove(filepath.Join(Config.RootDir, "nodes", "w1.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(Config.RootDir, "nodes", "cp1.yaml"), []byte("# talm: nodes=[\"2001:db8::1\", \"192.0.2.12\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+
+	err := runValidate(&out, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "1 node file(s) target nodes pinned to different Talos versions") {
+		t.Errorf("err = %v", err)
+	}
+
+	if !strings.Contains(out.String(), "node files without a single Talos version:") {
+		t.Errorf("output =\n%s", out.String())
+	}
+}
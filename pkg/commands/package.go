@@ -0,0 +1,189 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/chartpkg"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var packageCmdFlags struct {
+	version     string
+	destination string
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var pushCmdFlags struct {
+	plainHTTP             bool
+	insecureSkipTLSVerify bool
+	username              string
+	passwordStdin         bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var packageCmd = &cobra.Command{
+	Use:   "package",
+	Short: "Package the project chart into a versioned chart archive",
+	Long: `Package the project chart (Chart.yaml, values.yaml, templates, charts and
+any other chart files) into <name>-<version>.tgz, ready for talm push.
+
+Cluster state never goes into the archive: secrets.yaml, talosconfig,
+kubeconfig, talm.key and values-secret.yaml (plain or encrypted), the nodes/
+and .talm/ directories, and earlier archives are always left out. A
+.helmignore in the project excludes further files. File times are fixed, so
+the same content always packages to the same archive.`,
+	Example: `  talm package --version 1.4.0
+  talm package --destination dist/`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		archive, meta, err := chartpkg.Package(Config.RootDir, chartpkg.Options{
+			Version: packageCmdFlags.version,
+			Exclude: projectClientConfigs(),
+		})
+		if err != nil {
+			return err //nolint:wrapcheck // chartpkg names the file and attaches hints.
+		}
+
+		dest := filepath.Join(packageCmdFlags.destination, chartpkg.ArchiveName(meta))
+
+		if err := os.MkdirAll(packageCmdFlags.destination, 0o755); err != nil { //nolint:mnd // conventional directory mode.
+			return errors.Wrapf(err, "creating %s", packageCmdFlags.destination)
+		}
+
+		if err := os.WriteFile(dest, archive, presetFileMode); err != nil {
+			return errors.Wrapf(err, "writing %s", dest)
+		}
+
+		ui.Successf(os.Stderr, "Packaged %s %s", meta.Name, meta.Version)
+		fmt.Fprintln(cmd.OutOrStdout(), dest)
+
+		return nil
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var pushCmd = &cobra.Command{
+	Use:   "push <chart.tgz> <oci://registry/path>",
+	Short: "Push a chart archive to an OCI registry",
+	Long: `Push a chart archive built by talm package to an OCI registry. As with helm
+push, the chart lands in <path>/<name>, tagged with its version. The pushed
+reference, pinned by digest, is printed on stdout.
+
+Credentials come from --username/--password-stdin or from the auths entries
+that docker login and helm registry login write to the docker config.`,
+	Example: `  talm push prod-1.4.0.tgz oci://ghcr.io/example/charts
+  echo "$TOKEN" | talm push prod-1.4.0.tgz oci://registry.example/charts --username ci --password-stdin`,
+	Args: cobra.ExactArgs(2), //nolint:mnd // <chart.tgz> <oci://registry/path>
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archive, err := os.ReadFile(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", args[0])
+		}
+
+		password, err := readPushPassword(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		pushed, err := chartpkg.Push(ctx, archive, args[1], chartpkg.PushOptions{
+			PlainHTTP:             pushCmdFlags.plainHTTP,
+			InsecureSkipTLSVerify: pushCmdFlags.insecureSkipTLSVerify,
+			Username:              pushCmdFlags.username,
+			Password:              password,
+		})
+		if err != nil {
+			return err //nolint:wrapcheck // chartpkg names the registry and attaches hints.
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), pushed.String())
+
+		return nil
+	},
+}
+
+// projectClientConfigs lists the project-relative talosconfig and
+// kubeconfig paths Chart.yaml points at, and the key and secrets files
+// of its secrets layout, so a renamed client config or a moved secret
+// is kept out of the archive like the default names are.
+func projectClientConfigs() []string {
+	return clientConfigPaths(secretsLayout(), Config.GlobalOptions.Talosconfig, Config.GlobalOptions.Kubeconfig)
+}
+
+// clientConfigPaths is projectClientConfigs for an explicit secrets
+// layout and client config paths: every secret file of the registry
+// inside the project, plain and encrypted.
+func clientConfigPaths(layout age.Layout, talosconfig, kubeconfig string) []string {
+	var paths []string
+
+	for _, file := range projectSecretFiles(layout, talosconfig, kubeconfig) {
+		for _, path := range []string{file.plain, file.encrypted} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths
+}
+
+// readPushPassword reads the registry password from stdin when
+// --password-stdin is set.
+func readPushPassword(stdin io.Reader) (string, error) {
+	if !pushCmdFlags.passwordStdin {
+		return "", nil
+	}
+
+	if pushCmdFlags.username == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.New("--password-stdin needs --username"),
+			"pass the registry user name with --username",
+		)
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", errors.Wrap(err, "reading the password from stdin")
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func init() {
+	packageCmd.Flags().StringVar(&packageCmdFlags.version, "version", "", "chart version to package (default: version in Chart.yaml); the project Chart.yaml is not changed")
+	packageCmd.Flags().StringVarP(&packageCmdFlags.destination, "destination", "d", ".", "directory to write the archive to")
+
+	pushCmd.Flags().BoolVar(&pushCmdFlags.plainHTTP, "plain-http", false, "use http instead of https to reach the registry")
+	pushCmd.Flags().BoolVar(&pushCmdFlags.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "skip verification of the registry certificate")
+	pushCmd.Flags().StringVar(&pushCmdFlags.username, "username", "", "registry user name")
+	pushCmd.Flags().BoolVar(&pushCmdFlags.passwordStdin, "password-stdin", false, "read the registry password from stdin")
+
+	addCommand(packageCmd)
+	addCommand(pushCmd)
+}
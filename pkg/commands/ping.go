@@ -0,0 +1,430 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var pingCmdFlags struct {
+	configFiles  []string
+	timeout      time.Duration
+	certWarnDays int
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var pingCmd = &cobra.Command{
+	Use:   "ping",
+	Short: "Check quickly that every node answers an authenticated Talos API request",
+	Long: `Send each node the cheapest authenticated request, a version request,
+and print whether it answered, how long the round trip took and until
+when the certificate the Talos API presented is valid. Use it to triage
+before a heavier command such as apply, upgrade or healthcheck.
+
+The connection is built the same way as for every other talm command:
+the talosconfig (or the --as identity), --context, --endpoints,
+--cluster, --skip-verify and --siderov1-keys-dir all apply. The client
+certificate of the talosconfig context is checked as well.
+
+Nodes and endpoints are taken from the modelines of the -f files
+(directories are expanded), or of every node file under nodes/ when no
+file is given. --nodes and --endpoints override the modelines. Each node
+gets --timeout to answer; the command exits non-zero when any node does
+not.`,
+	Example: `  # Every node of the project
+  talm ping
+
+  # The nodes of some files, with a tighter deadline
+  talm ping -f nodes/ --timeout 1s
+  talm ping -f nodes/cp01.yaml -f nodes/worker01.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		targets, err := resolvePingTargets(pingCmdFlags.configFiles, ui.Progress(os.Stderr))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		run := pingRun{targets: targets, timeout: pingCmdFlags.timeout, ping: talosPing}
+		report := pingReport{
+			results:  run.execute(ctx),
+			client:   talosconfigClientCert(),
+			now:      time.Now(),
+			warnDays: pingCmdFlags.certWarnDays,
+		}
+
+		return report.write(cmd.OutOrStdout())
+	},
+}
+
+// pingTarget is one node to ping. endpoints are those of the node
+// file's modeline; empty leaves the choice to --endpoints and the
+// talosconfig context.
+type pingTarget struct {
+	node      string
+	endpoints []string
+}
+
+// pingReply is what a node that answered told about itself.
+type pingReply struct {
+	latency time.Duration
+	version string
+	// endpoint is the address that served the request, the node
+	// itself or the apid proxying to it.
+	endpoint string
+	// certNotAfter is the expiry of the certificate endpoint presented;
+	// zero when the connection carried none.
+	certNotAfter time.Time
+}
+
+// pingFunc sends one request to target within timeout. talosPing is
+// the Talos client implementation; tests substitute a fake.
+type pingFunc func(ctx context.Context, target pingTarget, timeout time.Duration) (pingReply, error)
+
+// pingResult is the outcome for one target; err is nil when it
+// answered.
+type pingResult struct {
+	target pingTarget
+	reply  pingReply
+	err    error
+}
+
+// pingRun is one configured ping of a set of nodes.
+type pingRun struct {
+	targets []pingTarget
+	timeout time.Duration
+	ping    pingFunc
+}
+
+// execute pings every target at once, so the run takes as long as the
+// slowest node, at most one timeout. Results keep the target order.
+func (r pingRun) execute(ctx context.Context) []pingResult {
+	results := make([]pingResult, len(r.targets))
+
+	var wg sync.WaitGroup
+
+	for i, target := range r.targets {
+		wg.Go(func() {
+			reply, err := r.ping(ctx, target, r.timeout)
+			results[i] = pingResult{target: target, reply: reply, err: err}
+		})
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// pingClientCert is the client certificate talm authenticates with.
+// problem is set when it could not be read.
+type pingClientCert struct {
+	context  string
+	notAfter time.Time
+	problem  string
+}
+
+// pingReport renders the results of a run.
+type pingReport struct {
+	results []pingResult
+	// client is nil when the talosconfig context carries no client
+	// certificate, as with SideroV1 key auth.
+	client   *pingClientCert
+	now      time.Time
+	warnDays int
+}
+
+// write prints the client certificate, one row per node and the
+// reason of every failure, and returns an error when any node did not
+// answer.
+func (r pingReport) write(out io.Writer) error {
+	if r.client != nil {
+		state := r.client.problem
+		if state == "" {
+			state = pingCertState(r.client.notAfter, r.now, r.warnDays)
+		}
+
+		fmt.Fprintf(out, "Client certificate (context %s): %s\n\n", r.client.context, state)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATUS\tLATENCY\tVERSION\tVIA\tSERVER CERT")
+
+	var failures []string
+
+	for _, res := range r.results {
+		if res.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", res.target.node, res.err))
+			fmt.Fprintf(w, "%s\tFAIL\t-\t-\t-\t-\n", res.target.node)
+
+			continue
+		}
+
+		via, cert := cmp.Or(res.reply.endpoint, "-"), "-"
+		if !res.reply.certNotAfter.IsZero() {
+			cert = pingCertState(res.reply.certNotAfter, r.now, r.warnDays)
+		}
+
+		fmt.Fprintf(w, "%s\tok\t%s\t%s\t%s\t%s\n", res.target.node, res.reply.latency.Round(time.Millisecond), res.reply.version, via, cert)
+	}
+
+	_ = w.Flush()
+
+	if len(failures) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Failures:")
+
+		for _, line := range failures {
+			fmt.Fprintln(out, "  "+line)
+		}
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%d of %d nodes did not answer", len(failures), len(r.results)),
+			"a timeout points at the network or a node that is down, a TLS or permission error at the talosconfig; a node still in maintenance mode only answers `talm apply --insecure`",
+		)
+	}
+
+	return nil
+}
+
+// pingCertState renders a certificate expiry, calling out one that has
+// expired or expires within warnDays of now.
+func pingCertState(notAfter, now time.Time, warnDays int) string {
+	date := notAfter.UTC().Format(time.DateOnly)
+	left := notAfter.Sub(now)
+
+	switch {
+	case left <= 0:
+		return "EXPIRED on " + date
+	case left < time.Duration(warnDays)*24*time.Hour:
+		return fmt.Sprintf("EXPIRES in %d days, on %s", int(left.Hours()/24), date)
+	default:
+		return "valid until " + date
+	}
+}
+
+// resolvePingTargets reads the nodes and endpoints of every node file.
+// Without files it reads the node files under nodes/ and skips those
+// without a modeline; a file given with -f must have one. --nodes and
+// --endpoints replace what the modelines say.
+func resolvePingTargets(files []string, progress io.Writer) ([]pingTarget, error) {
+	nodeFiles, err := pingNodeFiles(files, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without node files --nodes alone names the targets, reached
+	// through --endpoints or the talosconfig context.
+	if len(nodeFiles) == 0 {
+		nodeFiles = []pruneNodeFile{{}}
+	}
+
+	var (
+		targets []pingTarget
+		seen    = map[string]bool{}
+	)
+
+	for _, file := range nodeFiles {
+		nodes := file.nodes
+		if len(GlobalArgs.Nodes) > 0 {
+			nodes = GlobalArgs.Nodes
+		}
+
+		endpoints := file.endpoints
+		if len(GlobalArgs.Endpoints) > 0 {
+			endpoints = nil
+		}
+
+		for _, node := range nodes {
+			if !seen[node] {
+				seen[node] = true
+				targets = append(targets, pingTarget{node: node, endpoints: endpoints})
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("no nodes to ping"),
+			"add node files under nodes/, pass them with -f, or pass --nodes",
+		)
+	}
+
+	return targets, nil
+}
+
+// pingNodeFiles reads the modelines of the -f files, directories
+// expanded, or of the node files under nodes/ when none is given.
+func pingNodeFiles(files []string, progress io.Writer) ([]pruneNodeFile, error) {
+	if len(files) == 0 {
+		nodeFiles, skipped, err := scanPruneNodeFiles(Config.RootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range skipped {
+			fmt.Fprintf(progress, "Skipping %s: no talm modeline\n", path)
+		}
+
+		return nodeFiles, nil
+	}
+
+	expanded, err := ExpandFilePaths(files)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeFiles := make([]pruneNodeFile, 0, len(expanded))
+
+	for _, path := range expanded {
+		_, modelineConfig, err := modeline.FindAndParseModeline(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing modeline in %s", path)
+		}
+
+		if modelineConfig == nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Newf("no talm modeline in %s", path),
+				"pass node files generated by `talm template -I`, or name the nodes with --nodes",
+			)
+		}
+
+		nodeFiles = append(nodeFiles, pruneNodeFile{path: path, nodes: modelineConfig.Nodes, endpoints: modelineConfig.Endpoints})
+	}
+
+	return nodeFiles, nil
+}
+
+// talosPing sends a version request to target through a client built
+// like every other talm connection, and reads the certificate of the
+// endpoint that served it from the TLS handshake.
+func talosPing(_ context.Context, target pingTarget, timeout time.Duration) (pingReply, error) {
+	var reply pingReply
+
+	req := talosClientRequest{skipVerify: SkipVerify, endpoints: target.endpoints}
+
+	err := withTalosClient(req, func(ctx context.Context, c *client.Client) error {
+		callCtx, cancel := context.WithTimeout(client.WithNode(ctx, target.node), timeout)
+		defer cancel()
+
+		var p peer.Peer
+
+		start := time.Now()
+
+		resp, err := c.Version(callCtx, grpc.Peer(&p))
+		if err != nil {
+			return err //nolint:wrapcheck // reported verbatim in the failure list.
+		}
+
+		reply.latency = time.Since(start)
+
+		if msgs := resp.GetMessages(); len(msgs) > 0 {
+			reply.version = msgs[0].GetVersion().GetTag()
+		}
+
+		if p.Addr != nil {
+			reply.endpoint = p.Addr.String()
+		}
+
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			reply.certNotAfter = info.State.PeerCertificates[0].NotAfter
+		}
+
+		return nil
+	})
+
+	return reply, err
+}
+
+// talosconfigClientCert reads the client certificate of the selected
+// talosconfig context. It returns nil when the context has none; a
+// talosconfig that cannot be read is left to the pings to report.
+func talosconfigClientCert() *pingClientCert {
+	cfg, err := loadTalosconfig(GlobalArgs.Talosconfig)
+	if err != nil {
+		return nil
+	}
+
+	name, configContext, err := selectConfigContext(cfg)
+	if err != nil || configContext.Crt == "" {
+		return nil
+	}
+
+	cert := &pingClientCert{context: name}
+
+	notAfter, err := certNotAfterFromBase64PEM(configContext.Crt)
+	if err != nil {
+		cert.problem = err.Error()
+	}
+
+	cert.notAfter = notAfter
+
+	return cert
+}
+
+// certNotAfterFromBase64PEM returns the expiry of a certificate stored
+// the talosconfig way, as base64 of the PEM.
+func certNotAfterFromBase64PEM(value string) (time.Time, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "decoding the certificate")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, errors.New("the certificate is not PEM")
+	}
+
+	crt, err := stdx509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parsing the certificate")
+	}
+
+	return crt.NotAfter, nil
+}
+
+func init() {
+	pingCmd.Flags().StringSliceVarP(&pingCmdFlags.configFiles, "file", "f", nil, "node files or directories whose modelines name the nodes (default: every node file under nodes/)")
+	pingCmd.Flags().DurationVar(&pingCmdFlags.timeout, "timeout", 3*time.Second, "time limit for each node to answer")
+	pingCmd.Flags().IntVar(&pingCmdFlags.certWarnDays, "cert-warn-days", 30, "flag certificates that expire within this many days")
+
+	addCommand(pingCmd)
+}
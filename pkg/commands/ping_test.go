@@ -0,0 +1,249 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// TestPingRun pins that every target is pinged with the run's timeout,
+// that results keep the target order whatever order the answers come
+// in, and that the report lists the nodes that did not answer and
+// fails the run.
+func TestPingRun(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	run := pingRun{
+		targets: []pingTarget{{node: "192.0.2.10"}, {node: "192.0.2.20"}, {node: "192.0.2.30"}},
+		timeout: 2 * time.Second,
+		ping: func(_ context.Context, target pingTarget, timeout time.Duration) (pingReply, error) {
+			if timeout != 2*time.Second {
+				t.Errorf("timeout = %v, want the run's", timeout)
+			}
+
+			switch target.node {
+			case "192.0.2.10":
+				time.Sleep(10 * time.Millisecond)
+
+				return pingReply{latency: 12 * time.Millisecond, version: "v1.13.7", endpoint: "192.0.2.10:50000", certNotAfter: now.AddDate(1, 0, 0)}, nil
+			case "192.0.2.20":
+				return pingReply{latency: 3 * time.Millisecond, version: "v1.13.7", endpoint: "192.0.2.10:50000", certNotAfter: now.AddDate(0, 0, 5)}, nil
+			default:
+				return pingReply{}, context.DeadlineExceeded
+			}
+		},
+	}
+
+	results := run.execute(context.Background())
+	if len(results) != 3 || results[0].target.node != "192.0.2.10" || results[2].err == nil {
+		t.Fatalf("results = %+v, want the target order with the third failed", results)
+	}
+
+	var out bytes.Buffer
+
+	err := pingReport{
+		results:  results,
+		client:   &pingClientCert{context: "tc", notAfter: now.AddDate(0, 6, 0)},
+		now:      now,
+		warnDays: 30,
+	}.write(&out)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 nodes did not answer") {
+		t.Fatalf("write error = %v, want one node unanswered", err)
+	}
+
+	if len(errors.GetAllHints(err)) == 0 {
+		t.Error("the error must carry a triage hint")
+	}
+
+	for _, want := range []string{
+		"Client certificate (context tc): valid until 2026-12-01",
+		"192.0.2.10  ok      12ms     v1.13.7  192.0.2.10:50000  valid until 2027-06-01",
+		"192.0.2.20  ok      3ms      v1.13.7  192.0.2.10:50000  EXPIRES in 5 days, on 2026-06-06",
+		"192.0.2.30  FAIL    -        -        -                 -",
+		"Failures:\n  192.0.2.30: context deadline exceeded",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPingReport_AllAnswered(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	err := pingReport{
+		results: []pingResult{{target: pingTarget{node: "192.0.2.10"}, reply: pingReply{version: "v1.13.7"}}},
+		now:     time.Now(),
+	}.write(&out)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if strings.Contains(out.String(), "Client certificate") || strings.Contains(out.String(), "Failures") {
+		t.Errorf("a context without a client certificate and a clean run print neither:\n%s", out.String())
+	}
+}
+
+func TestPingCertState(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		notAfter time.Time
+		want     string
+	}{
+		{now.AddDate(0, 0, -1), "EXPIRED on 2026-05-31"},
+		{now.AddDate(0, 0, 10), "EXPIRES in 10 days, on 2026-06-11"},
+		{now.AddDate(0, 0, 30), "valid until 2026-07-01"},
+	} {
+		if got := pingCertState(tc.notAfter, now, 30); got != tc.want {
+			t.Errorf("pingCertState(%v) = %q, want %q", tc.notAfter, got, tc.want)
+		}
+	}
+}
+
+// TestResolvePingTargets pins where the targets come from: the node
+// files under nodes/ by default, each node with its file's endpoints,
+// the -f files when given, and --nodes and --endpoints over the
+// modelines.
+func TestResolvePingTargets(t *testing.T) {
+	dir := writePruneProject(t, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: c\nversion: 0.1.0\n",
+		"nodes/cp01.yaml":    "# talm: nodes=[\"192.0.2.10\"], endpoints=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		"nodes/worker01.yml": "# talm: nodes=[\"192.0.2.20\",\"192.0.2.10\"], endpoints=[\"192.0.2.11\"], templates=[\"templates/worker.yaml\"]\n",
+		"nodes/notes.yaml":   "foo: bar\n",
+		"extra/plain.yaml":   "foo: bar\n",
+	})
+
+	origRoot, origNodes, origEndpoints := Config.RootDir, GlobalArgs.Nodes, GlobalArgs.Endpoints
+	t.Cleanup(func() {
+		Config.RootDir, GlobalArgs.Nodes, GlobalArgs.Endpoints = origRoot, origNodes, origEndpoints
+	})
+
+	Config.RootDir = dir
+	GlobalArgs.Nodes, GlobalArgs.Endpoints = nil, nil
+
+	var progress bytes.Buffer
+
+	got, err := resolvePingTargets(nil, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pingTarget{
+		{node: "192.0.2.10", endpoints: []string{"192.0.2.10"}},
+		{node: "192.0.2.20", endpoints: []string{"192.0.2.11"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %+v, want %+v", got, want)
+	}
+
+	if !strings.Contains(progress.String(), "Skipping "+filepath.Join("nodes", "notes.yaml")) {
+		t.Errorf("a node file without a modeline must be reported, got %q", progress.String())
+	}
+
+	got, err = resolvePingTargets([]string{filepath.Join(dir, "nodes", "worker01.yml")}, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []pingTarget{
+		{node: "192.0.2.20", endpoints: []string{"192.0.2.11"}},
+		{node: "192.0.2.10", endpoints: []string{"192.0.2.11"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("-f targets = %+v, want %+v", got, want)
+	}
+
+	if _, err := resolvePingTargets([]string{filepath.Join(dir, "extra", "plain.yaml")}, &progress); err == nil {
+		t.Error("a -f file without a modeline must fail")
+	}
+
+	GlobalArgs.Nodes, GlobalArgs.Endpoints = []string{"192.0.2.99"}, []string{"192.0.2.1"}
+
+	got, err = resolvePingTargets(nil, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []pingTarget{{node: "192.0.2.99"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("--nodes targets = %+v, want %+v", got, want)
+	}
+
+	Config.RootDir = t.TempDir()
+
+	got, err = resolvePingTargets(nil, &progress)
+	if err != nil || len(got) != 1 || got[0].node != "192.0.2.99" {
+		t.Errorf("--nodes without node files = %+v, %v", got, err)
+	}
+
+	GlobalArgs.Nodes = nil
+
+	if _, err := resolvePingTargets(nil, &progress); err == nil {
+		t.Error("no node files and no --nodes must fail")
+	}
+}
+
+func TestCertNotAfterFromBase64PEM(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter := time.Date(2027, 1, 2, 3, 4, 5, 0, time.UTC)
+	template := &stdx509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notAfter.AddDate(-1, 0, 0), NotAfter: notAfter}
+
+	der, err := stdx509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	got, err := certNotAfterFromBase64PEM(value)
+	if err != nil || !got.Equal(notAfter) {
+		t.Errorf("certNotAfterFromBase64PEM = %v, %v, want %v", got, err, notAfter)
+	}
+
+	if _, err := certNotAfterFromBase64PEM("not base64!"); err == nil {
+		t.Error("a value that is not base64 must fail")
+	}
+
+	if _, err := certNotAfterFromBase64PEM(base64.StdEncoding.EncodeToString([]byte("plain"))); err == nil {
+		t.Error("a value that is not PEM must fail")
+	}
+}
@@ -16,7 +16,6 @@ package commands
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -27,6 +26,8 @@ import (
 	"github.com/siderolabs/talos/pkg/machinery/client"
 	machineryconfig "github.com/siderolabs/talos/pkg/machinery/config"
 	"github.com/siderolabs/talos/pkg/machinery/resources/runtime"
+
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 // preflightCOSIReadTimeout caps the COSI read latency so a slow or
@@ -42,7 +43,8 @@ const preflightCOSIReadTimeout = 2 * time.Second
 // includes the concrete numbers.
 const preflightVersionMismatchHint = "the generated config may include fields the node's machinery doesn't know; " +
 	"either reboot the node into a maintenance image matching templateOptions.talosVersion / --talos-version, " +
-	"or lower templateOptions.talosVersion / --talos-version to match the running Talos."
+	"or lower templateOptions.talosVersion / --talos-version to match the running Talos; " +
+	"a node mid-way through a rolling upgrade can keep its version with nodes.<node>.talosVersion in values.yaml."
 
 // applyConfigDecodeHint is the hint attached when the node's strict decoder
 // rejects the applied config because of an unknown field. It points at the
@@ -135,10 +137,7 @@ func preflightCheckTalosVersion(ctx context.Context, read versionReader, configu
 		return
 	}
 
-	_, _ = fmt.Fprintln(w, "warning:", warning.Error())
-	for _, hint := range errors.GetAllHints(warning) {
-		_, _ = fmt.Fprintf(w, "hint: %s\n", hint)
-	}
+	ui.WarnError(w, warning)
 }
 
 // evaluateVersionMismatch returns a hint-bearing warning error if the
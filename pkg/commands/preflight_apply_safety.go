@@ -203,6 +203,7 @@ func previewDrift(
 		return nil
 	}
 
+	currentJournal().noteDrift(nodeID, changes, redactor)
 	printDriftPreview(w, headerWithNode("talm: drift preview", nodeID), changes, redactor)
 
 	return nil
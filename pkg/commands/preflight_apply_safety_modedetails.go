@@ -189,11 +189,15 @@ func printApplyResultsRedacted(resp *machineapi.ApplyConfigurationResponse, valu
 // path feeds value files into the applied config, so only it can leak a user
 // secret through ModeDetails; the direct-patch path renders none, so collecting
 // them there would be pure overhead and would wrongly require a talm.key.
-func emitApplyResults(resp *machineapi.ApplyConfigurationResponse, rendered []byte, rendersUserValues bool) error {
+//
+// The results are followed by the post-apply summary (reportApplied),
+// redacted with the same value set; node names the target for messages
+// that carry no node metadata.
+func emitApplyResults(resp *machineapi.ApplyConfigurationResponse, rendered []byte, rendersUserValues bool, node string) error {
 	if applyCmdFlags.showSecretsInDrift {
 		printApplyResultsRedacted(resp, nil, os.Stderr)
 
-		return nil
+		return reportApplied(resp, rendered, nil, node)
 	}
 
 	values, err := collectConfigSecretValues(rendered)
@@ -202,7 +206,7 @@ func emitApplyResults(resp *machineapi.ApplyConfigurationResponse, rendered []by
 	}
 
 	if rendersUserValues {
-		userSecrets, err := collectEncryptedValueLeaves(applyValueFilePaths(), Config.RootDir)
+		userSecrets, err := collectSecretLeaves(applyValueFilePaths(), Config.RootDir)
 		if err != nil {
 			return errors.Wrap(err, "collecting user secret values for dry-run diff redaction")
 		}
@@ -214,5 +218,5 @@ func emitApplyResults(resp *machineapi.ApplyConfigurationResponse, rendered []by
 
 	printApplyResultsRedacted(resp, values, os.Stderr)
 
-	return nil
+	return reportApplied(resp, rendered, values, node)
 }
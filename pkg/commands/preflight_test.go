@@ -143,7 +143,7 @@ func TestPreflightCheckTalosVersion(t *testing.T) {
 			configured:     "v1.12",
 			readerVersion:  "v1.11.6",
 			readerOK:       true,
-			wantWarnPrefix: "warning: pre-flight: configured talosVersion=v1.12 is newer",
+			wantWarnPrefix: "Warning: pre-flight: configured talosVersion=v1.12 is newer",
 			wantHint:       true,
 		},
 		{
@@ -152,7 +152,7 @@ func TestPreflightCheckTalosVersion(t *testing.T) {
 			configured:     "",
 			readerVersion:  "v1.11.6",
 			readerOK:       true,
-			wantWarnPrefix: "warning: pre-flight: configured talosVersion=current is newer",
+			wantWarnPrefix: "Warning: pre-flight: configured talosVersion=current is newer",
 			wantHint:       true,
 		},
 		{
@@ -16,13 +16,14 @@ package commands
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"strings"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	machineryconfig "github.com/siderolabs/talos/pkg/machinery/config"
+
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 const postUpgradeVersionMismatchHint = "two hypotheses produce this symptom: " +
@@ -93,14 +94,14 @@ func verifyPostUpgradeVersion(
 		// an err). cosiVersionReader does not produce this shape,
 		// but the contract leaves room for future readers that need
 		// to surrender silently without an error.
-		_, _ = fmt.Fprintln(w, "warning: post-upgrade verification skipped, could not read running version from the node")
+		ui.Warnf(w, "post-upgrade verification skipped, could not read running version from the node")
 
 		return nil
 	}
 
 	runningContract, err := machineryconfig.ParseContractFromVersion(running)
 	if err != nil {
-		_, _ = fmt.Fprintf(w, "warning: post-upgrade verification skipped, could not parse running version %q\n", running)
+		ui.Warnf(w, "post-upgrade verification skipped, could not parse running version %q", running)
 
 		return nil //nolint:nilerr // best-effort: unparseable running version is a soft warning.
 	}
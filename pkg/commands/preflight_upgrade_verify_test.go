@@ -272,7 +272,7 @@ func TestVerifyPostUpgradeVersion_ReaderFails_SoftWarning_NoBlock(t *testing.T)
 	}
 
 	// Soft warning visible to the operator.
-	if !strings.Contains(buf.String(), "warning") {
+	if !strings.Contains(buf.String(), "Warning: ") {
 		t.Errorf("soft warning expected in output, got %q", buf.String())
 	}
 
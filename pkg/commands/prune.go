@@ -0,0 +1,658 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+const (
+	// liveSourceKubernetes and liveSourceDiscovery label where a live
+	// node was observed, so the report tells the operator which view
+	// of the cluster a finding came from.
+	liveSourceKubernetes = "kubernetes"
+	liveSourceDiscovery  = "discovery"
+)
+
+// staleArtifactPatterns match files in the project root and nodes/
+// that no talm command reads and that are safe to delete: editor and
+// merge leftovers next to node files, and `talosctl support` bundles.
+// Matched against the base name with filepath.Match.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var staleArtifactPatterns = []string{"*~", "*.bak", "*.orig", "*.rej", "*.swp", "support*.zip"}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var pruneCmdFlags struct {
+	deleteStale    bool
+	skipKubernetes bool
+	skipDiscovery  bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Report orphaned node files, unmanaged nodes and stale project artifacts",
+	Long: `Cross-reference the node files under nodes/, the values.yaml nodes map
+and live cluster membership, and report:
+
+  - node files whose machines are no longer part of the cluster,
+  - cluster members that have no node file,
+  - values.yaml nodes entries that no node file targets,
+  - stale artifacts (editor backups, merge leftovers, support bundles)
+    eligible for cleanup.
+
+Live membership is read from the Kubernetes API through the project
+kubeconfig and from Talos cluster discovery through one of the project
+nodes. Either source can be skipped; when neither is available, only the
+offline checks run.
+
+prune never deletes node files. --delete-stale removes the stale artifacts
+it lists.`,
+	Example: `  # Report findings
+  talm prune
+
+  # Report from Kubernetes only and remove stale artifacts
+  talm prune --skip-discovery --delete-stale`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runPrune(cmd.OutOrStdout(), ui.Progress(os.Stderr))
+	},
+}
+
+// pruneNodeFile is a node file under nodes/ together with the node
+// addresses its modeline targets and the endpoints it reaches them
+// through.
+type pruneNodeFile struct {
+	path      string
+	nodes     []string
+	endpoints []string
+}
+
+// liveNode is one machine observed in the running cluster. name is
+// the Kubernetes Node name or the Talos discovery hostname; addresses
+// are every address the source reported for it.
+type liveNode struct {
+	name      string
+	addresses []string
+	sources   []string
+}
+
+// pruneReport is the outcome of cross-referencing the project with
+// the cluster. membershipKnown is false when no live source answered;
+// orphanedFiles and unmanagedNodes are meaningless in that case and
+// stay empty.
+type pruneReport struct {
+	membershipKnown bool
+	orphanedFiles   []pruneNodeFile
+	unmanagedNodes  []liveNode
+	orphanedValues  []string
+	staleArtifacts  []string
+}
+
+func runPrune(out, progress io.Writer) error {
+	rootDir := Config.RootDir
+
+	files, skipped, err := scanPruneNodeFiles(rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range skipped {
+		fmt.Fprintf(progress, "Skipping %s: no talm modeline\n", path)
+	}
+
+	valuesNodes, err := loadValuesNodeKeys(rootDir)
+	if err != nil {
+		return err
+	}
+
+	artifacts, err := findStaleArtifacts(rootDir)
+	if err != nil {
+		return err
+	}
+
+	var live []liveNode
+
+	membershipKnown := false
+
+	if !pruneCmdFlags.skipKubernetes {
+		nodes, err := kubernetesLiveNodes()
+		if err != nil {
+			ui.Warnf(progress, "skipping Kubernetes membership: %v", err)
+		} else {
+			live = mergeLiveNodes(live, nodes)
+			membershipKnown = true
+		}
+	}
+
+	if !pruneCmdFlags.skipDiscovery {
+		nodes, err := discoveryLiveNodes(files)
+		if err != nil {
+			ui.Warnf(progress, "skipping Talos discovery membership: %v", err)
+		} else {
+			live = mergeLiveNodes(live, nodes)
+			membershipKnown = true
+		}
+	}
+
+	report := buildPruneReport(files, valuesNodes, live, membershipKnown)
+	report.staleArtifacts = artifacts
+
+	if structuredOutput() {
+		if err := writeStructuredOutput(out, report.output()); err != nil {
+			return err
+		}
+	} else {
+		printPruneReport(out, report)
+	}
+
+	if !pruneCmdFlags.deleteStale {
+		return nil
+	}
+
+	return deleteStaleArtifacts(rootDir, report.staleArtifacts, progress)
+}
+
+// scanPruneNodeFiles lists the node files under nodes/ with the nodes
+// their modelines target. YAML files without a parseable modeline are
+// returned separately so the operator sees them without prune
+// mistaking them for orphans.
+func scanPruneNodeFiles(rootDir string) ([]pruneNodeFile, []string, error) {
+	entries, err := os.ReadDir(filepath.Join(rootDir, nodesDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+
+		return nil, nil, errors.Wrapf(err, "reading %s", filepath.Join(rootDir, nodesDirName))
+	}
+
+	var (
+		files   []pruneNodeFile
+		skipped []string
+	)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isNodeFileCandidate(filepath.Join(rootDir, nodesDirName, name)) {
+			continue
+		}
+
+		path := filepath.Join(nodesDirName, name)
+
+		_, modelineConfig, err := modeline.FindAndParseModeline(filepath.Join(rootDir, path))
+		if err != nil || modelineConfig == nil {
+			skipped = append(skipped, path)
+
+			continue
+		}
+
+		files = append(files, pruneNodeFile{path: path, nodes: modelineConfig.Nodes, endpoints: modelineConfig.Endpoints})
+	}
+
+	return files, skipped, nil
+}
+
+// loadValuesNodeKeys returns the keys of the values.yaml `nodes` map,
+// sorted. Only the keys matter to prune; the entries themselves are
+// validated by the consumers that read them.
+func loadValuesNodeKeys(rootDir string) ([]string, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	var values struct {
+		Nodes map[string]any `yaml:"nodes"`
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrapf(err, "parsing `%s` in %s", valuesNodesKey, valuesPath)
+	}
+
+	keys := make([]string, 0, len(values.Nodes))
+	for key := range values.Nodes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// findStaleArtifacts lists the files in the project root and nodes/
+// matching staleArtifactPatterns, as paths relative to rootDir.
+func findStaleArtifacts(rootDir string) ([]string, error) {
+	var artifacts []string
+
+	for _, dir := range []string{".", nodesDirName} {
+		entries, err := os.ReadDir(filepath.Join(rootDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, errors.Wrapf(err, "reading %s", filepath.Join(rootDir, dir))
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !isStaleArtifact(entry.Name()) {
+				continue
+			}
+
+			artifacts = append(artifacts, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return artifacts, nil
+}
+
+func isStaleArtifact(name string) bool {
+	for _, pattern := range staleArtifactPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeLiveNodes folds observed into live, merging entries that share
+// a name or an address: the same machine usually shows up under both
+// Kubernetes and Talos discovery.
+func mergeLiveNodes(live, observed []liveNode) []liveNode {
+	for _, node := range observed {
+		merged := false
+
+		for i := range live {
+			if live[i].name != node.name && !sharesAddress(live[i].addresses, node.addresses) {
+				continue
+			}
+
+			live[i].addresses = appendUnique(live[i].addresses, node.addresses...)
+			live[i].sources = appendUnique(live[i].sources, node.sources...)
+			merged = true
+
+			break
+		}
+
+		if !merged {
+			live = append(live, node)
+		}
+	}
+
+	return live
+}
+
+func sharesAddress(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+
+		for _, existing := range list {
+			if existing == item {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// matchesLiveNode reports whether a modeline target names node, by
+// Node name / hostname or by any of its addresses.
+func matchesLiveNode(target string, node liveNode) bool {
+	if target == node.name {
+		return true
+	}
+
+	for _, addr := range node.addresses {
+		if addr == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildPruneReport cross-references the offline and live views. A
+// node file is orphaned when none of its targets matches a live node;
+// a live node is unmanaged when no node file targets it; a values.yaml
+// nodes entry is orphaned when no node file targets its key.
+func buildPruneReport(files []pruneNodeFile, valuesNodes []string, live []liveNode, membershipKnown bool) pruneReport {
+	report := pruneReport{membershipKnown: membershipKnown}
+
+	targeted := map[string]bool{}
+
+	for _, file := range files {
+		for _, target := range file.nodes {
+			targeted[target] = true
+		}
+	}
+
+	for _, key := range valuesNodes {
+		if !targeted[key] {
+			report.orphanedValues = append(report.orphanedValues, key)
+		}
+	}
+
+	if !membershipKnown {
+		return report
+	}
+
+	for _, file := range files {
+		alive := false
+
+		for _, target := range file.nodes {
+			for _, node := range live {
+				if matchesLiveNode(target, node) {
+					alive = true
+				}
+			}
+		}
+
+		if !alive {
+			report.orphanedFiles = append(report.orphanedFiles, file)
+		}
+	}
+
+	for _, node := range live {
+		managed := false
+
+		for target := range targeted {
+			if matchesLiveNode(target, node) {
+				managed = true
+
+				break
+			}
+		}
+
+		if !managed {
+			report.unmanagedNodes = append(report.unmanagedNodes, node)
+		}
+	}
+
+	sort.Slice(report.unmanagedNodes, func(i, j int) bool {
+		return report.unmanagedNodes[i].name < report.unmanagedNodes[j].name
+	})
+
+	return report
+}
+
+func printPruneReport(w io.Writer, report pruneReport) {
+	if !report.membershipKnown {
+		fmt.Fprintln(w, "Live membership unavailable: orphaned node files and unmanaged nodes were not checked.")
+	}
+
+	printPruneSection(w, "Node files for machines no longer in the cluster", len(report.orphanedFiles), func() {
+		for _, file := range report.orphanedFiles {
+			fmt.Fprintf(w, "  %s (%s)\n", file.path, strings.Join(file.nodes, ", "))
+		}
+	})
+
+	printPruneSection(w, "Cluster nodes without a node file", len(report.unmanagedNodes), func() {
+		for _, node := range report.unmanagedNodes {
+			fmt.Fprintf(w, "  %s (%s) [%s]\n", node.name, strings.Join(node.addresses, ", "), strings.Join(node.sources, ", "))
+		}
+	})
+
+	printPruneSection(w, "values.yaml nodes entries without a node file", len(report.orphanedValues), func() {
+		for _, key := range report.orphanedValues {
+			fmt.Fprintf(w, "  %s.%s\n", valuesNodesKey, key)
+		}
+	})
+
+	printPruneSection(w, "Stale artifacts eligible for cleanup (remove with --delete-stale)", len(report.staleArtifacts), func() {
+		for _, path := range report.staleArtifacts {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+	})
+}
+
+// pruneReportOutput is the --output json or yaml form of a
+// pruneReport. The lists are always present, empty when there is
+// nothing to report, so a consumer need not tell a missing key from
+// a clean project.
+type pruneReportOutput struct {
+	MembershipKnown bool                  `json:"membershipKnown"`
+	OrphanedFiles   []pruneFileOutput     `json:"orphanedFiles"`
+	UnmanagedNodes  []pruneLiveNodeOutput `json:"unmanagedNodes"`
+	OrphanedValues  []string              `json:"orphanedValues"`
+	StaleArtifacts  []string              `json:"staleArtifacts"`
+}
+
+type pruneFileOutput struct {
+	File  string   `json:"file"`
+	Nodes []string `json:"nodes"`
+}
+
+type pruneLiveNodeOutput struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+	Sources   []string `json:"sources"`
+}
+
+func (r pruneReport) output() pruneReportOutput {
+	out := pruneReportOutput{
+		MembershipKnown: r.membershipKnown,
+		OrphanedFiles:   []pruneFileOutput{},
+		UnmanagedNodes:  []pruneLiveNodeOutput{},
+		OrphanedValues:  append([]string{}, r.orphanedValues...),
+		StaleArtifacts:  append([]string{}, r.staleArtifacts...),
+	}
+
+	for _, file := range r.orphanedFiles {
+		out.OrphanedFiles = append(out.OrphanedFiles, pruneFileOutput{File: file.path, Nodes: file.nodes})
+	}
+
+	for _, node := range r.unmanagedNodes {
+		out.UnmanagedNodes = append(out.UnmanagedNodes, pruneLiveNodeOutput{Name: node.name, Addresses: node.addresses, Sources: node.sources})
+	}
+
+	return out
+}
+
+func printPruneSection(w io.Writer, title string, count int, body func()) {
+	if count == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s:\n", title)
+	body()
+}
+
+func deleteStaleArtifacts(rootDir string, artifacts []string, progress io.Writer) error {
+	for _, path := range artifacts {
+		if err := os.Remove(filepath.Join(rootDir, path)); err != nil {
+			return errors.Wrapf(err, "removing %s", path)
+		}
+
+		fmt.Fprintf(progress, "Removed %s\n", path)
+	}
+
+	return nil
+}
+
+// kubernetesLiveNodes lists the Nodes registered in the project
+// cluster through the project kubeconfig.
+func kubernetesLiveNodes() ([]liveNode, error) {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	nodes, err := listKubernetesNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make([]liveNode, 0, len(nodes))
+
+	for _, node := range nodes {
+		live = append(live, kubernetesLiveNode(node))
+	}
+
+	return live, nil
+}
+
+// kubernetesLiveNode converts a Kubernetes Node into the liveNode
+// view shared by prune and healthcheck.
+func kubernetesLiveNode(node corev1.Node) liveNode {
+	entry := liveNode{name: node.Name, sources: []string{liveSourceKubernetes}}
+
+	for _, addr := range node.Status.Addresses {
+		entry.addresses = appendUnique(entry.addresses, addr.Address)
+	}
+
+	return entry
+}
+
+// listKubernetesNodes reads the Nodes registered in the project
+// cluster through the project kubeconfig.
+func listKubernetesNodes(ctx context.Context) ([]corev1.Node, error) {
+	kubeconfigPath := projectKubeconfigPath()
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading kubeconfig %s", kubeconfigPath)
+	}
+
+	if err := trustCABundle(restConfig); err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating Kubernetes client")
+	}
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing Kubernetes nodes")
+	}
+
+	return nodeList.Items, nil
+}
+
+// discoveryLiveNodes reads the Talos discovery members through the
+// first node file's first node, with that file's endpoints. Any
+// healthy member sees the whole membership, so one query suffices.
+// An empty member list means discovery is disabled on the cluster and
+// is reported as an error rather than as "no members".
+func discoveryLiveNodes(files []pruneNodeFile) ([]liveNode, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no node files to reach the cluster through")
+	}
+
+	nodesFromArgs := len(GlobalArgs.Nodes) > 0
+	endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+
+	if _, err := processModelineAndUpdateGlobals(filepath.Join(Config.RootDir, files[0].path), nodesFromArgs, endpointsFromArgs, true); err != nil {
+		return nil, err
+	}
+
+	node := GlobalArgs.Nodes[0]
+
+	var live []liveNode
+
+	err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		members, err := safe.StateListAll[*cluster.Member](client.WithNode(ctx, node), c.COSI)
+		if err != nil {
+			return errors.Wrapf(err, "listing discovery members on %s", node)
+		}
+
+		for member := range members.All() {
+			spec := member.TypedSpec()
+			entry := liveNode{name: spec.Hostname, sources: []string{liveSourceDiscovery}}
+
+			for _, addr := range spec.Addresses {
+				entry.addresses = appendUnique(entry.addresses, addr.String())
+			}
+
+			live = append(live, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(live) == 0 {
+		return nil, errors.Newf("no discovery members reported by %s; is cluster discovery enabled?", node)
+	}
+
+	return live, nil
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneCmdFlags.deleteStale, "delete-stale", false, "remove the stale artifacts listed in the report (node files are never removed)")
+	pruneCmd.Flags().BoolVar(&pruneCmdFlags.skipKubernetes, "skip-kubernetes", false, "do not read cluster membership from the Kubernetes API")
+	pruneCmd.Flags().BoolVar(&pruneCmdFlags.skipDiscovery, "skip-discovery", false, "do not read cluster membership from Talos discovery")
+
+	addCommand(pruneCmd)
+}
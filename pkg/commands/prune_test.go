@@ -0,0 +1,178 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writePruneProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// TestScanPruneNodeFiles pins that only modelined YAML files count as
+// node files; YAML without a modeline is surfaced as skipped, and
+// non-YAML files are ignored entirely.
+func TestScanPruneNodeFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		"nodes/cp01.yaml":      "# talm: nodes=[\"192.0.2.10\"], endpoints=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		"nodes/notes.yaml":     "foo: bar\n",
+		"nodes/cp01.yaml.bak":  "old\n",
+		"nodes/readme.txt":     "text\n",
+		"nodes/subdir/x.yaml":  "# talm: nodes=[\"192.0.2.99\"]\n",
+		"values.yaml":          "nodes: {}\n",
+		"support-cp01.zip":     "zip",
+		"templates/cp.yaml~":   "not scanned",
+		"Chart.yaml":           "apiVersion: v2\nname: c\nversion: 0.1.0\n",
+		"nodes/worker01.yml":   "# talm: nodes=[\"192.0.2.20\"], templates=[\"templates/worker.yaml\"]\n",
+		"nodes/.cp01.yaml.swp": "swap",
+	})
+
+	files, skipped, err := scanPruneNodeFiles(dir)
+	if err != nil {
+		t.Fatalf("scanPruneNodeFiles: %v", err)
+	}
+
+	want := []pruneNodeFile{
+		{path: filepath.Join("nodes", "cp01.yaml"), nodes: []string{"192.0.2.10"}, endpoints: []string{"192.0.2.10"}},
+		{path: filepath.Join("nodes", "worker01.yml"), nodes: []string{"192.0.2.20"}},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %+v, want %+v", files, want)
+	}
+
+	if !reflect.DeepEqual(skipped, []string{filepath.Join("nodes", "notes.yaml")}) {
+		t.Errorf("skipped = %v", skipped)
+	}
+
+	artifacts, err := findStaleArtifacts(dir)
+	if err != nil {
+		t.Fatalf("findStaleArtifacts: %v", err)
+	}
+
+	wantArtifacts := []string{
+		"support-cp01.zip",
+		filepath.Join("nodes", ".cp01.yaml.swp"),
+		filepath.Join("nodes", "cp01.yaml.bak"),
+	}
+	if !reflect.DeepEqual(artifacts, wantArtifacts) {
+		t.Errorf("artifacts = %v, want %v", artifacts, wantArtifacts)
+	}
+}
+
+// TestBuildPruneReport pins the three cross-references, including
+// matching a node file by address against a Node known by hostname.
+func TestBuildPruneReport(t *testing.T) {
+	t.Parallel()
+
+	files := []pruneNodeFile{
+		{path: "nodes/cp01.yaml", nodes: []string{"192.0.2.10"}},
+		{path: "nodes/cp02.yaml", nodes: []string{"192.0.2.11"}},
+	}
+
+	live := mergeLiveNodes(nil, []liveNode{
+		{name: "cp01", addresses: []string{"192.0.2.10"}, sources: []string{liveSourceKubernetes}},
+		{name: "worker09", addresses: []string{"192.0.2.30"}, sources: []string{liveSourceKubernetes}},
+	})
+	live = mergeLiveNodes(live, []liveNode{
+		{name: "cp01", addresses: []string{"192.0.2.10", "fd00::10"}, sources: []string{liveSourceDiscovery}},
+	})
+
+	if len(live) != 2 || !reflect.DeepEqual(live[0].sources, []string{liveSourceKubernetes, liveSourceDiscovery}) {
+		t.Fatalf("the same machine seen by both sources must merge, got %+v", live)
+	}
+
+	report := buildPruneReport(files, []string{"192.0.2.10", "192.0.2.50"}, live, true)
+
+	if len(report.orphanedFiles) != 1 || report.orphanedFiles[0].path != "nodes/cp02.yaml" {
+		t.Errorf("orphanedFiles = %+v", report.orphanedFiles)
+	}
+
+	if len(report.unmanagedNodes) != 1 || report.unmanagedNodes[0].name != "worker09" {
+		t.Errorf("unmanagedNodes = %+v", report.unmanagedNodes)
+	}
+
+	if !reflect.DeepEqual(report.orphanedValues, []string{"192.0.2.50"}) {
+		t.Errorf("orphanedValues = %v", report.orphanedValues)
+	}
+}
+
+// TestBuildPruneReport_NoMembership pins that without a live source
+// no node file is reported as orphaned — an unreachable cluster must
+// not read as "every machine is gone".
+func TestBuildPruneReport_NoMembership(t *testing.T) {
+	t.Parallel()
+
+	report := buildPruneReport([]pruneNodeFile{{path: "nodes/cp01.yaml", nodes: []string{"192.0.2.10"}}}, nil, nil, false)
+
+	if len(report.orphanedFiles) != 0 || len(report.unmanagedNodes) != 0 {
+		t.Errorf("membership checks must not run without a live source: %+v", report)
+	}
+
+	var out bytes.Buffer
+	printPruneReport(&out, report)
+
+	if !strings.Contains(out.String(), "Live membership unavailable") {
+		t.Errorf("report must say membership was not checked, got %q", out.String())
+	}
+}
+
+func TestDeleteStaleArtifacts(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		"nodes/cp01.yaml":     "# talm: nodes=[\"192.0.2.10\"]\n",
+		"nodes/cp01.yaml.bak": "old\n",
+	})
+
+	artifacts, err := findStaleArtifacts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteStaleArtifacts(dir, artifacts, &bytes.Buffer{}); err != nil {
+		t.Fatalf("deleteStaleArtifacts: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "nodes", "cp01.yaml.bak")); !os.IsNotExist(err) {
+		t.Errorf("backup must be removed, stat err = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "nodes", "cp01.yaml")); err != nil {
+		t.Errorf("node file must survive: %v", err)
+	}
+}
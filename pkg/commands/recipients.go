@@ -0,0 +1,328 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/httpclient"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// recipientsLockName is the project-root file that pins the keys
+	// fetched for the github: entries of globalOptions.recipients. Like
+	// .talm-preset.lock it is committed: every encryption uses the keys
+	// the team reviewed, not whatever the forge serves that day.
+	recipientsLockName = ".talm-recipients.lock"
+
+	// recipientsLockHeader is prepended to the written lock so an
+	// operator who opens it understands it is machine-managed.
+	recipientsLockHeader = "# Managed by talm. Pins the SSH keys of the github: entries of\n" +
+		"# globalOptions.recipients. Commit it, and run\n" +
+		"# `talm secrets recipients --refresh` after a teammate changes their keys.\n"
+
+	githubRecipientPrefix = "github:"
+
+	// githubURL serves the public SSH keys of a user at /<user>.keys.
+	githubURL = "https://github.com"
+
+	// githubKeysTimeout bounds the fetch of one user's keys.
+	githubKeysTimeout = 30 * time.Second
+
+	// githubKeysLimit caps the key list read for one user.
+	githubKeysLimit = 64 << 10
+)
+
+// githubLoginRe matches a GitHub login, so a recipient entry cannot
+// point the fetch anywhere but a user's key list.
+var githubLoginRe = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var secretsRecipientsCmdFlags struct {
+	refresh bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var secretsRecipientsCmd = &cobra.Command{
+	Use:   "recipients",
+	Short: "List the extra recipients secrets are encrypted to",
+	Long: `List the public keys every secret value is encrypted to besides the project
+key, as globalOptions.recipients in Chart.yaml configures them:
+
+  globalOptions:
+    recipients:
+      - github:alice
+      - ssh-ed25519 AAAAC3Nza... bob@laptop
+      - age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+
+A github:<user> entry stands for the user's ssh-ed25519 keys on GitHub. They
+are fetched once and pinned in .talm-recipients.lock; commit it with
+Chart.yaml. --refresh fetches them again, after a teammate added or
+replaced a key.
+
+An operator listed as a recipient decrypts with ~/.ssh/id_ed25519 when the
+project key is absent. Re-encrypting still needs the project key.
+talm secrets recipients add and remove change the list and re-encrypt the
+project in one step; after editing the list by hand, run talm init
+--encrypt to encrypt every value to the new set.`,
+	Example: `  talm secrets recipients
+  talm secrets recipients --refresh`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runSecretsRecipients(cmd.Context(), cmd.OutOrStdout(), Config.RootDir, Config.GlobalOptions.Recipients, githubKeys(githubURL, httpclient.New(githubKeysTimeout)))
+	},
+}
+
+func runSecretsRecipients(ctx context.Context, out io.Writer, rootDir string, specs []string, fetch recipientKeyFetcher) error {
+	if len(specs) == 0 {
+		ui.Infof(os.Stderr, "No extra recipients: secrets are encrypted to the project key only. Add them under globalOptions.recipients in %s.", chartYamlName)
+
+		return nil
+	}
+
+	resolved, err := resolveRecipientSpecs(ctx, ui.Progress(os.Stderr), rootDir, specs, fetch, secretsRecipientsCmdFlags.refresh)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "RECIPIENT\tKEY")
+
+	for _, spec := range specs {
+		for _, key := range resolved[spec] {
+			fmt.Fprintf(tw, "%s\t%s\n", spec, key)
+		}
+	}
+
+	return errors.Wrap(tw.Flush(), "writing recipients")
+}
+
+// recipientKeyFetcher reads the public keys of a forge user.
+type recipientKeyFetcher func(ctx context.Context, user string) ([]string, error)
+
+// resolveRecipients turns the globalOptions.recipients entries into
+// the public key lines the age helpers encrypt to.
+func resolveRecipients(ctx context.Context, w io.Writer, rootDir string, specs []string, fetch recipientKeyFetcher) ([]string, error) {
+	resolved, err := resolveRecipientSpecs(ctx, w, rootDir, specs, fetch, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, spec := range specs {
+		keys = append(keys, resolved[spec]...)
+	}
+
+	return keys, nil
+}
+
+// resolveRecipientSpecs maps every recipient entry to its public keys.
+// An age or SSH public key stands for itself. A github:<user> entry
+// takes the keys pinned in the lock, fetched and pinned on first use
+// and fetched again on refresh. The lock is rewritten only when it
+// changes, and then holds the configured github: entries only.
+func resolveRecipientSpecs(ctx context.Context, w io.Writer, rootDir string, specs []string, fetch recipientKeyFetcher, refresh bool) (map[string][]string, error) {
+	lock, err := readRecipientsLock(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string][]string, len(specs))
+	pinned := map[string][]string{}
+
+	for _, spec := range specs {
+		user, isGitHub := strings.CutPrefix(spec, githubRecipientPrefix)
+		if !isGitHub {
+			if _, err := age.ParseRecipient(spec); err != nil {
+				return nil, errors.Wrap(err, "globalOptions.recipients")
+			}
+
+			resolved[spec] = []string{spec}
+
+			continue
+		}
+
+		keys, ok := lock[spec]
+		if !ok || refresh {
+			if keys, err = fetchGitHubRecipient(ctx, user, fetch); err != nil {
+				return nil, err
+			}
+
+			ui.Infof(w, "Pinned %d key(s) of %s in %s", len(keys), spec, recipientsLockName)
+		}
+
+		for _, key := range keys {
+			if _, err := age.ParseRecipient(key); err != nil {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return nil, errors.WithHintf(
+					errors.Wrapf(err, "%s in %s", spec, recipientsLockName),
+					"run `talm secrets recipients --refresh` to fetch the keys of %s again", spec,
+				)
+			}
+		}
+
+		resolved[spec] = keys
+		pinned[spec] = keys
+	}
+
+	if !maps.EqualFunc(lock, pinned, slices.Equal) {
+		if err := writeRecipientsLock(rootDir, pinned); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+func fetchGitHubRecipient(ctx context.Context, user string, fetch recipientKeyFetcher) ([]string, error) {
+	if !githubLoginRe.MatchString(user) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("globalOptions.recipients: %q is not a GitHub login", githubRecipientPrefix+user),
+			"write a GitHub recipient as github:<login>, for example github:alice",
+		)
+	}
+
+	keys, err := fetch(ctx, user)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching the keys of %s%s", githubRecipientPrefix, user)
+	}
+
+	if len(keys) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("%s%s has no %s key on GitHub", githubRecipientPrefix, user, age.SSHKeyType),
+			"ask them to add one at https://github.com/settings/keys, or list their age public key instead",
+		)
+	}
+
+	return keys, nil
+}
+
+// githubKeys fetches the public SSH keys GitHub serves for a user and
+// keeps the ssh-ed25519 ones, the only SSH keys talm encrypts to.
+func githubKeys(baseURL string, client *http.Client) recipientKeyFetcher {
+	return func(ctx context.Context, user string) ([]string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/"+user+".keys", nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "building the request")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "requesting the key list")
+		}
+		defer resp.Body.Close() //nolint:errcheck // response body
+
+		if resp.StatusCode == http.StatusNotFound {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Newf("%s has no user %s", baseURL, user),
+				"check the login in globalOptions.recipients",
+			)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Newf("%s answered %s", baseURL, resp.Status)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, githubKeysLimit))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the key list")
+		}
+
+		var keys []string
+
+		for line := range strings.Lines(string(body)) {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, age.SSHKeyType+" ") {
+				keys = append(keys, line)
+			}
+		}
+
+		return keys, nil
+	}
+}
+
+// readRecipientsLock reads the pinned keys under rootDir. A project
+// without the lock has nothing pinned.
+func readRecipientsLock(rootDir string) (map[string][]string, error) {
+	lock := map[string][]string{}
+
+	data, err := os.ReadFile(filepath.Join(rootDir, recipientsLockName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", recipientsLockName)
+	}
+
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", recipientsLockName)
+	}
+
+	if lock == nil {
+		lock = map[string][]string{}
+	}
+
+	return lock, nil
+}
+
+func writeRecipientsLock(rootDir string, pinned map[string][]string) error {
+	dest := filepath.Join(rootDir, recipientsLockName)
+
+	if len(pinned) == 0 {
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "removing %s", recipientsLockName)
+		}
+
+		return nil
+	}
+
+	body, err := yaml.Marshal(pinned)
+	if err != nil {
+		return errors.Wrapf(err, "marshaling %s", recipientsLockName)
+	}
+
+	if err := os.WriteFile(dest, append([]byte(recipientsLockHeader), body...), presetFileMode); err != nil {
+		return errors.Wrapf(err, "writing %s", recipientsLockName)
+	}
+
+	return nil
+}
+
+func init() {
+	secretsRecipientsCmd.Flags().BoolVar(&secretsRecipientsCmdFlags.refresh, "refresh", false, "fetch the keys of every github: recipient again and update "+recipientsLockName)
+
+	secretsCmd.AddCommand(secretsRecipientsCmd)
+}
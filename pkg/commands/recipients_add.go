@@ -0,0 +1,232 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/httpclient"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var secretsRecipientsAddCmd = &cobra.Command{
+	Use:   "add <recipient>...",
+	Short: "Add recipients and encrypt the project secrets to them",
+	Long: `Append recipients to globalOptions.recipients in Chart.yaml and encrypt
+every encrypted file of the project again, so the new recipients can
+decrypt it. A recipient is written as in Chart.yaml: github:<login>, an
+ssh-ed25519 public key or an age public key.
+
+The encrypted files are decrypted and encrypted in place; no plaintext
+file is needed, but the project key is. Commit Chart.yaml,
+.talm-recipients.lock and the re-encrypted files together.`,
+	Example: `  talm secrets recipients add github:alice
+  talm secrets recipients add "ssh-ed25519 AAAAC3Nza... bob@laptop"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fetch := githubKeys(githubURL, httpclient.New(githubKeysTimeout))
+
+		return runSecretsRecipientsAdd(cmd.Context(), ui.Progress(os.Stderr), Config.RootDir, args, fetch)
+	},
+}
+
+// runSecretsRecipientsAdd adds specs to the project recipients. The
+// new keys are resolved and every encrypted file is re-encrypted in
+// memory first, so a bad recipient or a missing key leaves the project
+// untouched; only then are Chart.yaml and the files written.
+func runSecretsRecipientsAdd(ctx context.Context, progress io.Writer, rootDir string, specs []string, fetch recipientKeyFetcher) error {
+	current := Config.GlobalOptions.Recipients
+
+	var added []string
+
+	for _, spec := range specs {
+		if slices.Contains(current, spec) || slices.Contains(added, spec) {
+			ui.Infof(progress, "%s is already a recipient", spec)
+
+			continue
+		}
+
+		added = append(added, spec)
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	all := append(slices.Clone(current), added...)
+
+	keys, err := resolveRecipients(ctx, progress, rootDir, all, fetch)
+	if err != nil {
+		return err
+	}
+
+	previous := age.CurrentLayout()
+
+	layout := previous
+	layout.Recipients = keys
+
+	if err := setSecretsLayout(layout); err != nil {
+		return err
+	}
+
+	reencrypted, err := reencryptProjectFiles(rootDir)
+	if err != nil {
+		age.SetLayout(previous)
+
+		return err
+	}
+
+	if err := addChartRecipients(filepath.Join(rootDir, chartYamlName), added); err != nil {
+		return err
+	}
+
+	Config.GlobalOptions.Recipients = all
+
+	for _, file := range reencrypted {
+		if err := secureperm.WriteFile(filepath.Join(rootDir, file.path), file.data); err != nil {
+			return errors.Wrapf(err, "writing %s", file.path)
+		}
+
+		ui.Infof(progress, "Encrypted %s to the new recipients", file.path)
+	}
+
+	ui.Successf(progress, "Added %d recipient(s); %d file(s) re-encrypted", len(added), len(reencrypted))
+
+	return nil
+}
+
+// reencryptedFile is the new content of an encrypted project file.
+type reencryptedFile struct {
+	path string
+	data []byte
+}
+
+// projectEncryptedFiles are the encrypted files of the project, as
+// the secret files registry names them, relative to the root.
+func projectEncryptedFiles() []string {
+	var files []string
+
+	for _, file := range projectSecretFiles(age.CurrentLayout(), Config.GlobalOptions.Talosconfig, Config.GlobalOptions.Kubeconfig) {
+		if file.encrypted != "" {
+			files = append(files, file.encrypted)
+		}
+	}
+
+	return files
+}
+
+// reencryptProjectFiles decrypts every encrypted file of the project
+// at rootDir and encrypts it again to the recipients of the current
+// layout, in memory. Files the project does not have are skipped.
+func reencryptProjectFiles(rootDir string) ([]reencryptedFile, error) {
+	var files []reencryptedFile
+
+	for _, rel := range projectEncryptedFiles() {
+		path := filepath.Join(rootDir, filepath.FromSlash(rel))
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, errors.Wrapf(err, "reading %s", rel)
+		}
+
+		plain, err := age.DecryptYAML(rootDir, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decrypting %s", rel)
+		}
+
+		encrypted, err := age.EncryptYAML(rootDir, plain, nil)
+		if err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Wrapf(err, "encrypting %s", rel),
+				"encrypting to new recipients needs the project key (%s); run the command where it is available", age.CurrentLayout().KeyFile(),
+			)
+		}
+
+		files = append(files, reencryptedFile{path: rel, data: encrypted})
+	}
+
+	return files, nil
+}
+
+// addChartRecipients appends specs to globalOptions.recipients in the
+// Chart.yaml at path, creating the list when it is absent and keeping
+// the rest of the file, comments included, as it is.
+func addChartRecipients(path string, specs []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", chartYamlName)
+	}
+
+	docs, err := decodeAllYAMLDocs(toLF(data))
+	if err != nil || len(docs) == 0 || len(docs[0].Content) == 0 {
+		return errors.Wrapf(cmp.Or(err, errors.New("empty document")), "parsing %s", chartYamlName)
+	}
+
+	root := docs[0].Content[0]
+	segments := []string{"globalOptions", "recipients"}
+
+	list, err := lookupValuesPath(root, segments)
+	if err != nil {
+		return errors.Wrapf(err, "globalOptions.recipients in %s", chartYamlName)
+	}
+
+	items := make([]*yaml.Node, 0, len(specs))
+	for _, spec := range specs {
+		items = append(items, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: spec})
+	}
+
+	switch {
+	case list == nil || list.ShortTag() == "!!null":
+		if err := setValuesPath(root, segments, &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: items}); err != nil {
+			return errors.Wrapf(err, "globalOptions.recipients in %s", chartYamlName)
+		}
+	case list.Kind == yaml.SequenceNode:
+		list.Content = append(list.Content, items...)
+	default:
+		return errors.Newf("globalOptions.recipients in %s is a %s, not a list", chartYamlName, yamlKindName(list.Kind))
+	}
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s", chartYamlName)
+	}
+
+	if err := os.WriteFile(path, keepLineEnding(out, data), presetFileMode); err != nil { //nolint:gosec // G306: Chart.yaml is committed and world-readable by design.
+		return errors.Wrapf(err, "writing %s", chartYamlName)
+	}
+
+	return nil
+}
+
+func init() {
+	secretsRecipientsCmd.AddCommand(secretsRecipientsAddCmd)
+}
@@ -0,0 +1,196 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	filippoage "filippo.io/age"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+const testRecipientsChart = `# project chart
+apiVersion: v2
+name: cluster
+globalOptions:
+  # teammates
+  recipients:
+    - github:alice
+`
+
+// withRecipientsProject roots an encrypted project with Chart.yaml
+// listing github:alice and returns its root.
+func withRecipientsProject(t *testing.T) string {
+	t.Helper()
+
+	dir := withSecretsLayout(t, age.Layout{})
+	age.SetLayout(age.Layout{})
+
+	Config.GlobalOptions.Recipients = []string{"github:alice"}
+
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string]string{
+		chartYamlName:  testRecipientsChart,
+		"secrets.yaml": "cluster:\n  secret: s3cr3t\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := age.EncryptSecretsFile(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "secrets.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// decryptEnvelopeWith opens the ENC[AGE,...] envelope value with id.
+func decryptEnvelopeWith(t *testing.T, value string, id filippoage.Identity) string {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, "ENC[AGE,data:"), "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := filippoage.Decrypt(bytes.NewReader(data), id)
+	if err != nil {
+		t.Fatalf("decrypting with the new recipient: %v", err)
+	}
+
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(plain)
+}
+
+// TestRunSecretsRecipientsAdd pins the flow: the new recipient lands in
+// Chart.yaml after the existing one with the comments kept, and the
+// encrypted secrets, without a plaintext copy on disk, are encrypted
+// again so the new recipient's key opens them.
+func TestRunSecretsRecipientsAdd(t *testing.T) {
+	dir := withRecipientsProject(t)
+	github := &fakeGitHub{keys: map[string][]string{"alice": {testRecipientAliceKey}}}
+
+	bob, err := filippoage.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+
+	if err := runSecretsRecipientsAdd(context.Background(), &progress, dir, []string{bob.Recipient().String(), "github:alice"}, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	chart, err := os.ReadFile(filepath.Join(dir, chartYamlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantChart := strings.Replace(testRecipientsChart, "    - github:alice\n", "    - github:alice\n    - "+bob.Recipient().String()+"\n", 1)
+	if string(chart) != wantChart {
+		t.Errorf("Chart.yaml =\n%s\nwant\n%s", chart, wantChart)
+	}
+
+	if !strings.Contains(progress.String(), "github:alice is already a recipient") {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(dir, "secrets.encrypted.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets struct {
+		Cluster struct {
+			Secret string `yaml:"secret"`
+		} `yaml:"cluster"`
+	}
+
+	if err := yaml.Unmarshal(encrypted, &secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decryptEnvelopeWith(t, secrets.Cluster.Secret, bob); got != "s3cr3t" {
+		t.Errorf("the new recipient decrypts %q, want s3cr3t", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "secrets.yaml")); !os.IsNotExist(err) {
+		t.Errorf("no plaintext file may be left behind, stat err = %v", err)
+	}
+}
+
+// TestRunSecretsRecipientsAdd_InvalidLeavesProject pins that a
+// recipient that does not parse changes nothing.
+func TestRunSecretsRecipientsAdd_InvalidLeavesProject(t *testing.T) {
+	dir := withRecipientsProject(t)
+	github := &fakeGitHub{keys: map[string][]string{"alice": {testRecipientAliceKey}}}
+
+	before, err := os.ReadFile(filepath.Join(dir, "secrets.encrypted.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runSecretsRecipientsAdd(context.Background(), io.Discard, dir, []string{"ssh-rsa AAAAB3Nza"}, github.fetch); err == nil {
+		t.Fatal("an unparsable recipient must fail")
+	}
+
+	chart, _ := os.ReadFile(filepath.Join(dir, chartYamlName))
+	after, _ := os.ReadFile(filepath.Join(dir, "secrets.encrypted.yaml"))
+
+	if string(chart) != testRecipientsChart || !bytes.Equal(before, after) {
+		t.Errorf("a failed add must leave Chart.yaml and the encrypted files alone")
+	}
+}
+
+func TestAddChartRecipients_CreatesList(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), chartYamlName)
+	if err := os.WriteFile(path, []byte("name: cluster\nglobalOptions:\n  talosconfig: talosconfig\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addChartRecipients(path, []string{testRecipientAgeKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(path)
+
+	want := "name: cluster\nglobalOptions:\n  talosconfig: talosconfig\n  recipients:\n    - " + testRecipientAgeKey + "\n"
+	if string(got) != want {
+		t.Errorf("Chart.yaml =\n%s\nwant\n%s", got, want)
+	}
+}
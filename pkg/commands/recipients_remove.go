@@ -0,0 +1,171 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/httpclient"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var secretsRecipientsRemoveCmd = &cobra.Command{
+	Use:     "remove <recipient>...",
+	Aliases: []string{"rm"},
+	Short:   "Remove recipients and encrypt the project secrets without them",
+	Long: `Drop recipients from globalOptions.recipients in Chart.yaml and encrypt
+every encrypted file of the project again to the recipients that remain,
+so the removed keys cannot decrypt the new files. A recipient is written
+exactly as it appears in Chart.yaml; the keys pinned for a removed
+github: entry leave .talm-recipients.lock.
+
+The removed keys still open the files in version control history, and
+whatever their owners decrypted before; rotate the secrets they could
+read. Commit Chart.yaml, .talm-recipients.lock and the re-encrypted files
+together.`,
+	Example: `  talm secrets recipients remove github:alice
+  talm secrets recipients rm age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fetch := githubKeys(githubURL, httpclient.New(githubKeysTimeout))
+
+		return runSecretsRecipientsRemove(cmd.Context(), ui.Progress(os.Stderr), Config.RootDir, args, fetch)
+	},
+}
+
+// runSecretsRecipientsRemove removes specs from the project recipients.
+// As with add, every encrypted file is re-encrypted in memory first, so
+// a missing key leaves the project untouched; only then are Chart.yaml
+// and the files written.
+func runSecretsRecipientsRemove(ctx context.Context, progress io.Writer, rootDir string, specs []string, fetch recipientKeyFetcher) error {
+	current := Config.GlobalOptions.Recipients
+
+	var removed []string
+
+	for _, spec := range specs {
+		if !slices.Contains(current, spec) {
+			ui.Infof(progress, "%s is not a recipient", spec)
+
+			continue
+		}
+
+		if !slices.Contains(removed, spec) {
+			removed = append(removed, spec)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(current), func(spec string) bool {
+		return slices.Contains(removed, spec)
+	})
+
+	keys, err := resolveRecipients(ctx, progress, rootDir, remaining, fetch)
+	if err != nil {
+		return err
+	}
+
+	previous := age.CurrentLayout()
+
+	layout := previous
+	layout.Recipients = keys
+
+	if err := setSecretsLayout(layout); err != nil {
+		return err
+	}
+
+	reencrypted, err := reencryptProjectFiles(rootDir)
+	if err != nil {
+		age.SetLayout(previous)
+
+		return err
+	}
+
+	if err := removeChartRecipients(filepath.Join(rootDir, chartYamlName), removed); err != nil {
+		return err
+	}
+
+	Config.GlobalOptions.Recipients = remaining
+
+	for _, file := range reencrypted {
+		if err := secureperm.WriteFile(filepath.Join(rootDir, file.path), file.data); err != nil {
+			return errors.Wrapf(err, "writing %s", file.path)
+		}
+
+		ui.Infof(progress, "Encrypted %s without the removed recipients", file.path)
+	}
+
+	ui.Successf(progress, "Removed %d recipient(s); %d file(s) re-encrypted", len(removed), len(reencrypted))
+	ui.Warnf(progress, "the removed keys still decrypt earlier copies of the files; rotate the secrets they could read")
+
+	return nil
+}
+
+// removeChartRecipients drops specs from globalOptions.recipients in
+// the Chart.yaml at path, keeping the rest of the file, comments
+// included, as it is.
+func removeChartRecipients(path string, specs []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", chartYamlName)
+	}
+
+	docs, err := decodeAllYAMLDocs(toLF(data))
+	if err != nil || len(docs) == 0 || len(docs[0].Content) == 0 {
+		return errors.Wrapf(cmp.Or(err, errors.New("empty document")), "parsing %s", chartYamlName)
+	}
+
+	list, err := lookupValuesPath(docs[0].Content[0], []string{"globalOptions", "recipients"})
+	if err != nil {
+		return errors.Wrapf(err, "globalOptions.recipients in %s", chartYamlName)
+	}
+
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return errors.Newf("globalOptions.recipients in %s is not a list", chartYamlName)
+	}
+
+	list.Content = slices.DeleteFunc(list.Content, func(item *yaml.Node) bool {
+		return item.Kind == yaml.ScalarNode && slices.Contains(specs, item.Value)
+	})
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s", chartYamlName)
+	}
+
+	if err := os.WriteFile(path, keepLineEnding(out, data), presetFileMode); err != nil { //nolint:gosec // G306: Chart.yaml is committed and world-readable by design.
+		return errors.Wrapf(err, "writing %s", chartYamlName)
+	}
+
+	return nil
+}
+
+func init() {
+	secretsRecipientsCmd.AddCommand(secretsRecipientsRemoveCmd)
+}
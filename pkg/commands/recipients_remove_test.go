@@ -0,0 +1,109 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	filippoage "filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// TestRunSecretsRecipientsRemove pins the flow: a recipient added and
+// then removed leaves Chart.yaml as it was, and the re-encrypted
+// secrets no longer open with the removed key.
+func TestRunSecretsRecipientsRemove(t *testing.T) {
+	dir := withRecipientsProject(t)
+	github := &fakeGitHub{keys: map[string][]string{"alice": {testRecipientAliceKey}}}
+
+	bob, err := filippoage.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runSecretsRecipientsAdd(context.Background(), io.Discard, dir, []string{bob.Recipient().String()}, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+
+	if err := runSecretsRecipientsRemove(context.Background(), &progress, dir, []string{bob.Recipient().String(), "github:carol"}, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	chart, err := os.ReadFile(filepath.Join(dir, chartYamlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(chart) != testRecipientsChart {
+		t.Errorf("Chart.yaml =\n%s\nwant\n%s", chart, testRecipientsChart)
+	}
+
+	if !strings.Contains(progress.String(), "github:carol is not a recipient") {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(dir, "secrets.encrypted.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets struct {
+		Cluster struct {
+			Secret string `yaml:"secret"`
+		} `yaml:"cluster"`
+	}
+
+	if err := yaml.Unmarshal(encrypted, &secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(secrets.Cluster.Secret, "ENC[AGE,data:"), "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := filippoage.Decrypt(bytes.NewReader(data), bob); err == nil {
+		t.Error("the removed recipient still decrypts the secrets")
+	}
+}
+
+func TestRemoveChartRecipients_KeepsOthers(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), chartYamlName)
+	chart := strings.Replace(testRecipientsChart, "    - github:alice\n", "    - github:alice\n    - "+testRecipientAgeKey+"\n", 1)
+
+	if err := os.WriteFile(path, []byte(chart), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeChartRecipients(path, []string{testRecipientAgeKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(path)
+	if string(got) != testRecipientsChart {
+		t.Errorf("Chart.yaml =\n%s\nwant\n%s", got, testRecipientsChart)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	testRecipientAliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAdKJv2+HdQWCG0JZWZYUToo5gIw2Oa9HGnv0udreaf6"
+	testRecipientAgeKey   = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
+)
+
+// fakeGitHub serves fixed key lists and counts the fetches.
+type fakeGitHub struct {
+	keys    map[string][]string
+	fetches int
+}
+
+func (f *fakeGitHub) fetch(_ context.Context, user string) ([]string, error) {
+	f.fetches++
+
+	keys, ok := f.keys[user]
+	if !ok {
+		return nil, errors.Newf("no user %s", user)
+	}
+
+	return keys, nil
+}
+
+// TestResolveRecipientSpecs_PinsGitHubKeys pins the lock: a github:
+// entry is fetched once and then read from the lock, --refresh fetches
+// it again, and dropping the entry drops it from the lock.
+func TestResolveRecipientSpecs_PinsGitHubKeys(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	github := &fakeGitHub{keys: map[string][]string{"alice": {testRecipientAliceKey}}}
+	specs := []string{"github:alice", testRecipientAgeKey}
+
+	var progress bytes.Buffer
+
+	keys, err := resolveRecipients(context.Background(), &progress, root, specs, github.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{testRecipientAliceKey, testRecipientAgeKey}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+
+	if !strings.Contains(progress.String(), "Pinned 1 key(s) of github:alice in "+recipientsLockName) {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	lock, err := os.ReadFile(filepath.Join(root, recipientsLockName))
+	if err != nil || !strings.HasPrefix(string(lock), recipientsLockHeader) || !strings.Contains(string(lock), testRecipientAliceKey) {
+		t.Fatalf("lock = %q, err = %v", lock, err)
+	}
+
+	github.keys["alice"] = []string{testRecipientAliceKey, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ5Aun8uFZCyhhmrKfSHejPfAcmRc2V1X4n4OlCqiz9o"}
+
+	if _, err := resolveRecipients(context.Background(), &progress, root, specs, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	if github.fetches != 1 {
+		t.Errorf("a pinned recipient must not be fetched again, got %d fetches", github.fetches)
+	}
+
+	resolved, err := resolveRecipientSpecs(context.Background(), &progress, root, specs, github.fetch, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if github.fetches != 2 || len(resolved["github:alice"]) != 2 {
+		t.Errorf("refresh: fetches = %d, resolved = %v", github.fetches, resolved)
+	}
+
+	if _, err := resolveRecipients(context.Background(), &progress, root, []string{testRecipientAgeKey}, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, recipientsLockName)); !os.IsNotExist(err) {
+		t.Errorf("a lock without github: entries must be removed, stat = %v", err)
+	}
+}
+
+func TestResolveRecipientSpecs_Errors(t *testing.T) {
+	t.Parallel()
+
+	github := &fakeGitHub{keys: map[string][]string{"nokeys": nil}}
+
+	for _, tc := range []struct {
+		spec, want string
+	}{
+		{"github:../../evil", "is not a GitHub login"},
+		{"github:nokeys", "has no ssh-ed25519 key on GitHub"},
+		{"github:ghost", "fetching the keys of github:ghost"},
+		{"ssh-rsa AAAAB3NzaC1yc2E= bob", "unsupported recipient"},
+	} {
+		_, err := resolveRecipients(context.Background(), &bytes.Buffer{}, t.TempDir(), []string{tc.spec}, github.fetch)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.spec, err, tc.want)
+		}
+	}
+}
+
+func TestResolveRecipientSpecs_CorruptLock(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, recipientsLockName), []byte("github:alice:\n  - ssh-ed25519 AAAA\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := resolveRecipients(context.Background(), &bytes.Buffer{}, root, []string{"github:alice"}, (&fakeGitHub{}).fetch)
+	if err == nil || !strings.Contains(err.Error(), recipientsLockName) {
+		t.Fatalf("error = %v", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "--refresh") {
+		t.Errorf("hints = %q", hints)
+	}
+}
+
+func TestGitHubKeys(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/alice.keys" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		fmt.Fprintf(w, "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ\n%s\necdsa-sha2-nistp256 AAAAE2VjZHNh\n", testRecipientAliceKey)
+	}))
+	t.Cleanup(server.Close)
+
+	fetch := githubKeys(server.URL, server.Client())
+
+	keys, err := fetch(context.Background(), "alice")
+	if err != nil || !reflect.DeepEqual(keys, []string{testRecipientAliceKey}) {
+		t.Errorf("keys = %v, err = %v; want the ssh-ed25519 key only", keys, err)
+	}
+
+	if _, err := fetch(context.Background(), "ghost"); err == nil || !strings.Contains(err.Error(), "has no user ghost") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRunSecretsRecipients(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	github := &fakeGitHub{keys: map[string][]string{"alice": {testRecipientAliceKey}}}
+
+	var out bytes.Buffer
+
+	if err := runSecretsRecipients(context.Background(), &out, root, []string{"github:alice", testRecipientAgeKey}, github.fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows [][]string
+	for line := range strings.Lines(out.String()) {
+		rows = append(rows, strings.Fields(line))
+	}
+
+	want := [][]string{
+		{"RECIPIENT", "KEY"},
+		append([]string{"github:alice"}, strings.Fields(testRecipientAliceKey)...),
+		{testRecipientAgeKey, testRecipientAgeKey},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("output =\n%s", out.String())
+	}
+
+	out.Reset()
+
+	if err := runSecretsRecipients(context.Background(), &out, root, nil, github.fetch); err != nil || out.Len() != 0 {
+		t.Errorf("no recipients must print nothing on stdout, got %q, err = %v", out.String(), err)
+	}
+}
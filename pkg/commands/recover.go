@@ -0,0 +1,443 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+const (
+	// recoverCmdName is the cobra name of the disaster-recovery
+	// command. Hoisted so the step banners and the hints agree.
+	recoverCmdName = "recover"
+
+	// etcdServiceID is the Talos service id queried for the
+	// recovery node's etcd state.
+	etcdServiceID = "etcd"
+
+	// etcdStatePreparing is the state the etcd service sits in on a
+	// control-plane node whose EPHEMERAL partition was wiped: the
+	// service waits for a bootstrap call, which is the only moment
+	// Talos accepts a snapshot to recover from.
+	etcdStatePreparing = "Preparing"
+
+	// etcdStateRunning is the state the etcd service reaches once the
+	// recovered member is serving.
+	etcdStateRunning = "Running"
+
+	// defaultRecoverEtcdWaitTimeout bounds how long recover waits for
+	// etcd to come up after the recovery bootstrap. Restoring a large
+	// snapshot on slow disks takes a few minutes; ten leaves margin.
+	defaultRecoverEtcdWaitTimeout = 10 * time.Minute
+
+	// recoverEtcdPollInterval is the delay between etcd service-state
+	// reads while waiting for the recovered member.
+	recoverEtcdPollInterval = 5 * time.Second
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var recoverCmdFlags struct {
+	configFile      string
+	snapshot        string
+	skipHashCheck   bool
+	reapplyFiles    []string
+	dryRun          bool
+	etcdWaitTimeout time.Duration
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var recoverCmd = &cobra.Command{
+	Use:   recoverCmdName,
+	Short: "Recover a cluster that lost etcd quorum from an etcd snapshot",
+	Long: `Recover a cluster whose control plane lost etcd quorum.
+
+The command sequences the Talos disaster-recovery procedure with a check
+between each step:
+
+1. Verify etcd on the recovery node is waiting for bootstrap (state
+   "Preparing"). A node whose etcd still runs cannot accept a snapshot;
+   wipe its EPHEMERAL partition first:
+   talm reset -f nodes/cp01.yaml --graceful=false --reboot --system-labels-to-wipe=EPHEMERAL
+2. Upload the etcd snapshot to the recovery node.
+3. Bootstrap the recovery node from the uploaded snapshot.
+4. Wait until etcd on the recovery node is running and healthy.
+5. Re-render and re-apply every --reapply node file, in order, through
+   the regular apply pipeline so the remaining nodes rejoin.
+
+The recovery node is the single node named by the -f file's modeline.
+
+The command runs in dry-run mode by default: it performs the read-only
+check of step 1 and prints the plan. Use --dry-run=false to recover.`,
+	Example: `  # Show the recovery plan and check the recovery node
+  talm recover -f nodes/cp01.yaml --snapshot db.snapshot
+
+  # Recover cp01 from a snapshot, then re-apply the other control planes
+  talm recover -f nodes/cp01.yaml --snapshot db.snapshot \
+    --reapply nodes/cp02.yaml --reapply nodes/cp03.yaml --dry-run=false
+
+  # Recover from a snapshot copied straight out of the etcd data directory
+  talm recover -f nodes/cp01.yaml --snapshot member/snap/db --skip-hash-check --dry-run=false`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return validateRecoverInputs(recoverCmdFlags.configFile, recoverCmdFlags.snapshot, recoverCmdFlags.reapplyFiles, recoverCmdFlags.etcdWaitTimeout)
+	},
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runRecover()
+	},
+}
+
+// validateRecoverInputs rejects the argument shapes that would fail
+// halfway through the procedure: a missing or empty snapshot would
+// surface only after the operator already wiped the recovery node, and
+// listing the recovery node's own file under --reapply would re-apply
+// it while etcd is still settling.
+func validateRecoverInputs(configFile, snapshot string, reapplyFiles []string, waitTimeout time.Duration) error {
+	if configFile == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("recover requires the recovery node's config file"),
+			"pass the control-plane node file to bootstrap from via -f, e.g. `talm recover -f nodes/cp01.yaml --snapshot db.snapshot`",
+		)
+	}
+
+	if snapshot == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("recover requires an etcd snapshot"),
+			"take one with `talm etcd snapshot db.snapshot -f nodes/cp01.yaml` while the cluster is healthy, or copy member/snap/db from a control-plane node's etcd data directory and pass --skip-hash-check",
+		)
+	}
+
+	info, err := os.Stat(snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "reading etcd snapshot %s", snapshot)
+	}
+
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("etcd snapshot %s is empty or not a regular file", snapshot),
+			"pass the path of the snapshot file itself; %s must be a non-empty file", snapshot,
+		)
+	}
+
+	for _, file := range reapplyFiles {
+		if file == configFile {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("--reapply lists the recovery node file %s", file),
+				"the recovery node keeps its config through the recovery; list only the remaining nodes under --reapply",
+			)
+		}
+	}
+
+	if waitTimeout <= 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--etcd-wait-timeout must be a positive duration; got %s", waitTimeout),
+			"pass a positive duration like 10m — the default is 10m",
+		)
+	}
+
+	return nil
+}
+
+// runRecover resolves the recovery node from the -f modeline, runs the
+// etcd half of the procedure against it, and then re-applies the
+// remaining node files. The re-apply loop runs outside the recovery
+// node's client so each apply builds its own connection from its own
+// modeline, exactly as `talm apply -f` would.
+func runRecover() error {
+	nodesFromArgs := len(GlobalArgs.Nodes) > 0
+	endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+
+	if _, err := processModelineAndUpdateGlobals(recoverCmdFlags.configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
+		return err
+	}
+
+	if len(GlobalArgs.Nodes) != 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("recover requires exactly one recovery node, but %d nodes were resolved (%v)", len(GlobalArgs.Nodes), GlobalArgs.Nodes),
+			"etcd is restored onto a single control-plane node; point -f at a node file whose modeline names one node, and list the others under --reapply",
+		)
+	}
+
+	runner := etcdRecoveryRunner{
+		out:           os.Stderr,
+		node:          GlobalArgs.Nodes[0],
+		snapshot:      recoverCmdFlags.snapshot,
+		skipHashCheck: recoverCmdFlags.skipHashCheck,
+		dryRun:        recoverCmdFlags.dryRun,
+		waitTimeout:   recoverCmdFlags.etcdWaitTimeout,
+		pollInterval:  recoverEtcdPollInterval,
+		reapplyFiles:  recoverCmdFlags.reapplyFiles,
+	}
+
+	err := WithClient(func(ctx context.Context, c *client.Client) error {
+		return runner.recoverEtcd(ctx, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	return runner.reapply(reapplyNodeFile)
+}
+
+// etcdRecoveryClient is the slice of the Talos client the recovery
+// sequence drives. Narrowed to an interface so tests can script the
+// etcd service states and assert on the exact call order.
+type etcdRecoveryClient interface {
+	etcdServiceClient
+	EtcdRecover(ctx context.Context, snapshot io.Reader, callOptions ...grpc.CallOption) (*machineapi.EtcdRecoverResponse, error)
+	Bootstrap(ctx context.Context, req *machineapi.BootstrapRequest) error
+}
+
+// etcdServiceClient reads Talos service states, narrowed for the etcd
+// waits.
+type etcdServiceClient interface {
+	ServiceInfo(ctx context.Context, id string, callOptions ...grpc.CallOption) ([]client.ServiceInfo, error)
+}
+
+// etcdRecoveryRunner carries the resolved inputs of one recovery run.
+// Step banners go to out (stderr in production) so stdout stays free
+// for the output of the re-apply steps.
+type etcdRecoveryRunner struct {
+	out           io.Writer
+	node          string
+	snapshot      string
+	skipHashCheck bool
+	dryRun        bool
+	waitTimeout   time.Duration
+	pollInterval  time.Duration
+	reapplyFiles  []string
+}
+
+// recoverStepCount is the number of numbered steps in the banner
+// sequence; matches the list in recoverCmd.Long.
+const recoverStepCount = 5
+
+func (r *etcdRecoveryRunner) step(n int, format string, args ...any) {
+	fmt.Fprintf(r.out, "> [%d/%d] %s\n", n, recoverStepCount, fmt.Sprintf(format, args...))
+}
+
+// recoverEtcd runs steps 1–4: the read-only etcd state check, the
+// snapshot upload, the recovery bootstrap and the wait for the
+// recovered member. In dry-run mode only the check runs; the
+// mutating steps are printed as the plan.
+func (r *etcdRecoveryRunner) recoverEtcd(ctx context.Context, c etcdRecoveryClient) error {
+	r.step(1, "checking etcd on %s is waiting for bootstrap", r.node)
+
+	state, err := etcdServiceState(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err := checkEtcdAwaitingBootstrap(r.node, state); err != nil {
+		return err
+	}
+
+	if r.dryRun {
+		r.step(2, "would upload etcd snapshot %s to %s", r.snapshot, r.node)
+		r.step(3, "would bootstrap %s from the snapshot (skip hash check: %t)", r.node, r.skipHashCheck)
+		r.step(4, "would wait up to %s for etcd on %s to run", r.waitTimeout, r.node)
+		r.step(5, "would re-apply %d node file(s): %v", len(r.reapplyFiles), r.reapplyFiles)
+		fmt.Fprintf(r.out, "dry-run: no changes made; re-run with --dry-run=false to recover\n")
+
+		return nil
+	}
+
+	r.step(2, "uploading etcd snapshot %s to %s", r.snapshot, r.node)
+
+	if err := uploadEtcdSnapshot(ctx, c, r.snapshot); err != nil {
+		return err
+	}
+
+	r.step(3, "bootstrapping %s from the snapshot", r.node)
+
+	if err := c.Bootstrap(ctx, &machineapi.BootstrapRequest{
+		RecoverEtcd:          true,
+		RecoverSkipHashCheck: r.skipHashCheck,
+	}); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Wrapf(err, "bootstrapping %s from the etcd snapshot", r.node),
+			"a snapshot copied from the etcd data directory has no integrity hash; re-run with --skip-hash-check for such snapshots",
+		)
+	}
+
+	r.step(4, "waiting up to %s for etcd on %s to run", r.waitTimeout, r.node)
+
+	return r.waitForEtcdRunning(ctx, c)
+}
+
+// reapply runs step 5: each --reapply file goes through applyFn in
+// order and the first failure stops the loop, so the operator sees
+// which node is left to fix before the rest are touched.
+func (r *etcdRecoveryRunner) reapply(applyFn func(string) error) error {
+	if r.dryRun {
+		return nil
+	}
+
+	r.step(5, "re-applying %d node file(s)", len(r.reapplyFiles))
+
+	for _, file := range r.reapplyFiles {
+		if err := applyFn(file); err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Wrapf(err, "re-applying %s", file),
+				"etcd is already recovered; fix the failure and finish with `talm apply -f %s` for this and every later --reapply file", file,
+			)
+		}
+	}
+
+	return nil
+}
+
+// etcdServiceState reads the etcd service state on the single node
+// carried by ctx.
+func etcdServiceState(ctx context.Context, c etcdRecoveryClient) (string, error) {
+	services, err := c.ServiceInfo(ctx, etcdServiceID)
+	if err != nil {
+		return "", errors.Wrap(err, "reading etcd service state")
+	}
+
+	if len(services) == 0 || services[0].Service == nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.New("etcd service not found on the recovery node"),
+			"the recovery node must be a control-plane node; point -f at a control-plane node file",
+		)
+	}
+
+	return services[0].Service.GetState(), nil
+}
+
+// checkEtcdAwaitingBootstrap gates the snapshot upload on the one
+// state Talos accepts it in. Running etcd means the node still holds
+// (possibly stale) member data and would ignore the snapshot.
+func checkEtcdAwaitingBootstrap(node, state string) error {
+	if state == etcdStatePreparing {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("etcd on %s is in state %q, not %q; the node cannot accept a snapshot", node, state, etcdStatePreparing),
+		"wipe the node's etcd data first: `talm reset --nodes %s --graceful=false --reboot --system-labels-to-wipe=EPHEMERAL`, wait for it to come back, then re-run recover", node,
+	)
+}
+
+// uploadEtcdSnapshot streams the snapshot file to the recovery node.
+func uploadEtcdSnapshot(ctx context.Context, c etcdRecoveryClient, path string) error {
+	snapshot, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "opening etcd snapshot %s", path)
+	}
+
+	defer func() { _ = snapshot.Close() }()
+
+	if _, err := c.EtcdRecover(ctx, snapshot); err != nil {
+		return errors.Wrapf(err, "uploading etcd snapshot %s", path)
+	}
+
+	return nil
+}
+
+// waitForEtcdRunning waits for the recovered member to run and be
+// healthy.
+func (r *etcdRecoveryRunner) waitForEtcdRunning(ctx context.Context, c etcdRecoveryClient) error {
+	lastState, err := pollEtcdRunning(ctx, c, r.waitTimeout, r.pollInterval)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("etcd on %s did not become healthy within %s (last state %q)", r.node, r.waitTimeout, lastState),
+			"check `talm service etcd --nodes %s` and `talm logs etcd --nodes %s`; once etcd runs, re-apply the remaining nodes with `talm apply -f`", r.node, r.node,
+		)
+	}
+
+	fmt.Fprintf(r.out, "  etcd on %s is running\n", r.node)
+
+	return nil
+}
+
+// pollEtcdRunning polls the etcd service on the node carried by ctx
+// until it reports running and healthy or timeout elapses. Read errors
+// are retried: the apid connection blips while etcd and kube-apiserver
+// restart. It returns the last state read, and an error on timeout.
+func pollEtcdRunning(ctx context.Context, c etcdServiceClient, timeout, interval time.Duration) (string, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lastState := ""
+
+	for {
+		services, err := c.ServiceInfo(waitCtx, etcdServiceID)
+		if err == nil && len(services) > 0 && services[0].Service != nil {
+			svc := services[0].Service
+			lastState = svc.GetState()
+
+			if lastState == etcdStateRunning && (svc.GetHealth() == nil || svc.GetHealth().GetHealthy()) {
+				return lastState, nil
+			}
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return lastState, errors.Wrap(waitCtx.Err(), "waiting for etcd")
+		case <-time.After(interval):
+		}
+	}
+}
+
+// reapplyNodeFile re-renders and applies one node file through the
+// regular apply pipeline. GlobalArgs still carries the recovery node
+// at this point, so it is cleared first and applyCmd's PreRunE seeds
+// the apply flags from Chart.yaml the same way `talm apply` does.
+func reapplyNodeFile(file string) error {
+	GlobalArgs.Nodes = []string{}
+	GlobalArgs.Endpoints = []string{}
+
+	if err := applyCmd.PreRunE(applyCmd, nil); err != nil {
+		return err
+	}
+
+	return applyOneFile(file, nil)
+}
+
+func init() {
+	recoverCmd.Flags().StringVarP(&recoverCmdFlags.configFile, "file", "f", "", "node file of the control-plane node to restore etcd onto; its modeline must name exactly one node")
+	recoverCmd.Flags().StringVar(&recoverCmdFlags.snapshot, "snapshot", "", "path to the etcd snapshot to recover from")
+	recoverCmd.Flags().BoolVar(&recoverCmdFlags.skipHashCheck, "skip-hash-check", false, "skip the snapshot integrity hash check (required for a db file copied from the etcd data directory)")
+	recoverCmd.Flags().StringSliceVar(&recoverCmdFlags.reapplyFiles, "reapply", nil, "node files to re-render and re-apply after etcd is recovered, in order (can specify multiple)")
+	recoverCmd.Flags().BoolVar(&recoverCmdFlags.dryRun, "dry-run", true, "check the recovery node and print the plan without changing anything")
+	recoverCmd.Flags().DurationVar(&recoverCmdFlags.etcdWaitTimeout, "etcd-wait-timeout", defaultRecoverEtcdWaitTimeout, "how long to wait for etcd to run after the recovery bootstrap")
+
+	_ = recoverCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+	_ = recoverCmd.RegisterFlagCompletionFunc("reapply", completeNodeFiles)
+
+	addCommand(recoverCmd)
+}
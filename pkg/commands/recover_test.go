@@ -0,0 +1,276 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// fakeEtcdRecoveryClient scripts the etcd service states returned by
+// successive ServiceInfo calls and records every call by name so the
+// tests can pin the recovery sequence order.
+type fakeEtcdRecoveryClient struct {
+	states       []string
+	calls        []string
+	uploaded     []byte
+	bootstrapReq *machineapi.BootstrapRequest
+}
+
+func (f *fakeEtcdRecoveryClient) ServiceInfo(_ context.Context, _ string, _ ...grpc.CallOption) ([]client.ServiceInfo, error) {
+	f.calls = append(f.calls, "ServiceInfo")
+
+	state := f.states[0]
+	if len(f.states) > 1 {
+		f.states = f.states[1:]
+	}
+
+	return []client.ServiceInfo{{Service: &machineapi.ServiceInfo{Id: etcdServiceID, State: state}}}, nil
+}
+
+func (f *fakeEtcdRecoveryClient) EtcdRecover(_ context.Context, snapshot io.Reader, _ ...grpc.CallOption) (*machineapi.EtcdRecoverResponse, error) {
+	f.calls = append(f.calls, "EtcdRecover")
+
+	data, err := io.ReadAll(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+
+	f.uploaded = data
+
+	return &machineapi.EtcdRecoverResponse{}, nil
+}
+
+func (f *fakeEtcdRecoveryClient) Bootstrap(_ context.Context, req *machineapi.BootstrapRequest) error {
+	f.calls = append(f.calls, "Bootstrap")
+	f.bootstrapReq = req
+
+	return nil
+}
+
+func writeRecoverSnapshot(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "db.snapshot")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func newTestRecoveryRunner(snapshot string, dryRun bool) *etcdRecoveryRunner {
+	return &etcdRecoveryRunner{
+		out:          &bytes.Buffer{},
+		node:         "192.0.2.10",
+		snapshot:     snapshot,
+		dryRun:       dryRun,
+		waitTimeout:  time.Second,
+		pollInterval: time.Millisecond,
+		reapplyFiles: []string{"nodes/cp02.yaml", "nodes/cp03.yaml"},
+	}
+}
+
+// TestRecoverEtcd_FullSequence pins the order of the mutating steps:
+// state check, snapshot upload, recovery bootstrap, then polling
+// until etcd runs. Reordering upload and bootstrap would have Talos
+// bootstrap a fresh cluster instead of restoring the snapshot.
+func TestRecoverEtcd_FullSequence(t *testing.T) {
+	t.Parallel()
+
+	snapshot := writeRecoverSnapshot(t, "etcd-bytes")
+	fake := &fakeEtcdRecoveryClient{states: []string{etcdStatePreparing, "Waiting", etcdStateRunning}}
+	runner := newTestRecoveryRunner(snapshot, false)
+	runner.skipHashCheck = true
+
+	if err := runner.recoverEtcd(context.Background(), fake); err != nil {
+		t.Fatalf("recoverEtcd: %v", err)
+	}
+
+	want := []string{"ServiceInfo", "EtcdRecover", "Bootstrap", "ServiceInfo", "ServiceInfo"}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("calls = %v, want %v", fake.calls, want)
+	}
+
+	if string(fake.uploaded) != "etcd-bytes" {
+		t.Errorf("uploaded snapshot = %q, want %q", fake.uploaded, "etcd-bytes")
+	}
+
+	if !fake.bootstrapReq.GetRecoverEtcd() || !fake.bootstrapReq.GetRecoverSkipHashCheck() {
+		t.Errorf("bootstrap request must carry RecoverEtcd and RecoverSkipHashCheck, got %+v", fake.bootstrapReq)
+	}
+}
+
+// TestRecoverEtcd_DryRunIsReadOnly pins the dry-run contract: only
+// the etcd state read fires, and the plan names every later step.
+func TestRecoverEtcd_DryRunIsReadOnly(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeEtcdRecoveryClient{states: []string{etcdStatePreparing}}
+	runner := newTestRecoveryRunner(writeRecoverSnapshot(t, "x"), true)
+
+	if err := runner.recoverEtcd(context.Background(), fake); err != nil {
+		t.Fatalf("recoverEtcd: %v", err)
+	}
+
+	if !reflect.DeepEqual(fake.calls, []string{"ServiceInfo"}) {
+		t.Errorf("dry-run must only read etcd state, got calls %v", fake.calls)
+	}
+
+	out := runner.out.(*bytes.Buffer).String()
+	for _, want := range []string{"[2/5] would upload", "[3/5] would bootstrap", "[5/5] would re-apply 2 node file(s)", "--dry-run=false"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry-run output missing %q:\n%s", want, out)
+		}
+	}
+
+	applied := 0
+	if err := runner.reapply(func(string) error { applied++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied != 0 {
+		t.Errorf("dry-run must not re-apply, applied %d files", applied)
+	}
+}
+
+// TestRecoverEtcd_RefusesRunningEtcd pins the gate that protects a
+// node whose etcd still holds member data: no upload, and a hint
+// pointing at the EPHEMERAL wipe.
+func TestRecoverEtcd_RefusesRunningEtcd(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeEtcdRecoveryClient{states: []string{etcdStateRunning}}
+	runner := newTestRecoveryRunner(writeRecoverSnapshot(t, "x"), false)
+
+	err := runner.recoverEtcd(context.Background(), fake)
+	if err == nil {
+		t.Fatal("expected error for running etcd")
+	}
+
+	if !reflect.DeepEqual(fake.calls, []string{"ServiceInfo"}) {
+		t.Errorf("no mutating call may fire when etcd runs, got %v", fake.calls)
+	}
+
+	hints := strings.Join(errors.GetAllHints(err), "\n")
+	if !strings.Contains(hints, "--system-labels-to-wipe=EPHEMERAL") {
+		t.Errorf("hint must point at the EPHEMERAL wipe, got %q", hints)
+	}
+}
+
+// TestRecoverEtcd_WaitTimeout pins that a member which never reaches
+// Running surfaces a timeout naming the last observed state.
+func TestRecoverEtcd_WaitTimeout(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeEtcdRecoveryClient{states: []string{etcdStatePreparing, "Failed"}}
+	runner := newTestRecoveryRunner(writeRecoverSnapshot(t, "x"), false)
+	runner.waitTimeout = 20 * time.Millisecond
+
+	err := runner.recoverEtcd(context.Background(), fake)
+	if err == nil {
+		t.Fatal("expected wait timeout")
+	}
+
+	if !strings.Contains(err.Error(), `last state "Failed"`) {
+		t.Errorf("timeout must name the last state, got %q", err.Error())
+	}
+}
+
+// TestRecoverReapply_StopsAtFirstFailure pins the re-apply loop order
+// and that a failure names the file the operator has to finish from.
+func TestRecoverReapply_StopsAtFirstFailure(t *testing.T) {
+	t.Parallel()
+
+	runner := newTestRecoveryRunner("", false)
+
+	var applied []string
+
+	err := runner.reapply(func(file string) error {
+		applied = append(applied, file)
+
+		return errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected re-apply error")
+	}
+
+	if !reflect.DeepEqual(applied, []string{"nodes/cp02.yaml"}) {
+		t.Errorf("re-apply must stop at the first failure, applied %v", applied)
+	}
+
+	if !strings.Contains(err.Error(), "nodes/cp02.yaml") {
+		t.Errorf("error must name the failing file, got %q", err.Error())
+	}
+}
+
+// TestValidateRecoverInputs covers the argument shapes rejected
+// before any RPC fires.
+func TestValidateRecoverInputs(t *testing.T) {
+	t.Parallel()
+
+	good := writeRecoverSnapshot(t, "x")
+	empty := writeRecoverSnapshot(t, "")
+
+	tests := []struct {
+		name     string
+		file     string
+		snapshot string
+		reapply  []string
+		timeout  time.Duration
+		wantErr  string
+	}{
+		{"valid", "nodes/cp01.yaml", good, []string{"nodes/cp02.yaml"}, time.Minute, ""},
+		{"missing file", "", good, nil, time.Minute, "config file"},
+		{"missing snapshot flag", "nodes/cp01.yaml", "", nil, time.Minute, "requires an etcd snapshot"},
+		{"snapshot not found", "nodes/cp01.yaml", filepath.Join(t.TempDir(), "nope"), nil, time.Minute, "reading etcd snapshot"},
+		{"empty snapshot", "nodes/cp01.yaml", empty, nil, time.Minute, "empty or not a regular file"},
+		{"snapshot is a directory", "nodes/cp01.yaml", t.TempDir(), nil, time.Minute, "empty or not a regular file"},
+		{"recovery node under reapply", "nodes/cp01.yaml", good, []string{"nodes/cp01.yaml"}, time.Minute, "--reapply lists the recovery node"},
+		{"non-positive timeout", "nodes/cp01.yaml", good, nil, 0, "--etcd-wait-timeout"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateRecoverInputs(tc.file, tc.snapshot, tc.reapply, tc.timeout)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want substring %q", err, tc.wantErr)
+			}
+		})
+	}
+}
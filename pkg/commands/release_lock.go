@@ -0,0 +1,175 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// releasesDir is the project-relative directory holding one
+// subdirectory per release tag, each with the values lock of that
+// release.
+const releasesDir = "releases"
+
+// valuesLockName is the values lock of a release. When a value file of
+// the snapshot was age-encrypted, the lock is written encrypted, as
+// age.EncryptedName(valuesLockName), so the secrets merged into it do
+// not land in git in plaintext.
+const valuesLockName = "values.lock.yaml"
+
+// valuesLockHeader opens a plaintext values lock.
+const valuesLockHeader = "# Managed by talm. The complete values release %s was rendered with, written\n" +
+	"# by `talm snapshot values`; `talm template --release` and `talm apply --release`\n" +
+	"# render with exactly these values. Do not edit by hand; take a new snapshot.\n"
+
+// validateReleaseTag rejects a tag that cannot name a single directory
+// under releases/.
+func validateReleaseTag(tag string) error {
+	if tag == "" || tag == "." || tag == ".." || strings.ContainsAny(tag, `/\`) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("invalid release tag %q", tag),
+			"a release tag names one directory under releases/, e.g. v1.4.0 or 2026-10-16",
+		)
+	}
+
+	return nil
+}
+
+// valuesLockPaths returns the plaintext and the encrypted path of the
+// values lock of tag.
+func valuesLockPaths(rootDir, tag string) (string, string) {
+	dir := filepath.Join(rootDir, releasesDir, tag)
+
+	return filepath.Join(dir, valuesLockName), filepath.Join(dir, age.EncryptedName(valuesLockName))
+}
+
+// findValuesLock returns the existing values lock of tag, plaintext or
+// encrypted.
+func findValuesLock(rootDir, tag string) (string, error) {
+	if err := validateReleaseTag(tag); err != nil {
+		return "", err
+	}
+
+	plain, encrypted := valuesLockPaths(rootDir, tag)
+
+	for _, path := range []string{plain, encrypted} {
+		if fileExists(path) {
+			return path, nil
+		}
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+	return "", errors.WithHintf(
+		errors.Newf("release %s has no values lock at %s", tag, plain),
+		"take one with `talm snapshot values %s`", tag,
+	)
+}
+
+// resolveReleaseValuesLock returns the values lock a --release render
+// reads, or "" without --release. The lock holds the complete values,
+// so mixing it with the value flags of the command would render
+// something that was never shipped; that is refused.
+func resolveReleaseValuesLock(flags *pflag.FlagSet, rootDir, release string) (string, error) {
+	if release == "" {
+		return "", nil
+	}
+
+	for _, name := range []string{"values", "set", "set-string", "set-file", "set-json", "set-literal"} {
+		if flags.Changed(name) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+			return "", errors.WithHintf(
+				errors.Newf("--release cannot be combined with --%s", name),
+				"the values lock of release %s is the complete set of values; to change them, take a new snapshot with `talm snapshot values <tag> --%s ...`", release, name,
+			)
+		}
+	}
+
+	return findValuesLock(rootDir, release)
+}
+
+// writeValuesLock writes values as the values lock of tag and returns
+// its path. encrypt writes the age-encrypted form instead, and removes
+// a plaintext lock left from an earlier snapshot, and vice versa. An
+// existing lock is kept unless force is set: a release is meant to be
+// frozen.
+func writeValuesLock(rootDir, tag string, values map[string]any, encrypt, force bool) (string, error) {
+	if err := validateReleaseTag(tag); err != nil {
+		return "", err
+	}
+
+	plain, encrypted := valuesLockPaths(rootDir, tag)
+
+	if !force {
+		for _, path := range []string{plain, encrypted} {
+			if fileExists(path) {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return "", errors.WithHint(
+					errors.Newf("release %s is already locked in %s", tag, path),
+					"pick a new release tag, or pass --force to replace the lock",
+				)
+			}
+		}
+	}
+
+	body, err := yaml.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling the values lock")
+	}
+
+	dest, stale := plain, encrypted
+	data := append([]byte(fmt.Sprintf(valuesLockHeader, tag)), body...)
+
+	if encrypt {
+		dest, stale = encrypted, plain
+
+		data, err = age.EncryptYAML(rootDir, body, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "encrypting the values lock")
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", errors.Wrapf(err, "creating %s", filepath.Dir(dest))
+	}
+
+	if err := os.WriteFile(dest, data, presetFileMode); err != nil {
+		return "", errors.Wrapf(err, "writing values lock %q", dest)
+	}
+
+	if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "removing the previous values lock %q", stale)
+	}
+
+	return dest, nil
+}
+
+// hasEncryptedValueFile reports whether any of files is an age-encrypted
+// values file, whose plaintext a values lock must not expose.
+func hasEncryptedValueFile(files []string) bool {
+	return slices.ContainsFunc(files, func(file string) bool {
+		return strings.HasSuffix(file, age.EncryptedFileSuffix)
+	})
+}
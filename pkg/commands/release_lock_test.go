@@ -0,0 +1,175 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+func TestValidateReleaseTag(t *testing.T) {
+	t.Parallel()
+
+	for _, tag := range []string{"v1.4.0", "2026-10-16", "prod_1"} {
+		if err := validateReleaseTag(tag); err != nil {
+			t.Errorf("%q: %v", tag, err)
+		}
+	}
+
+	for _, tag := range []string{"", ".", "..", "v1/rc1", `v1\rc1`} {
+		if err := validateReleaseTag(tag); err == nil {
+			t.Errorf("%q must be rejected", tag)
+		}
+	}
+}
+
+func TestWriteValuesLock(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	values := map[string]any{"endpoint": "https://192.0.2.1:6443", "nodes": map[string]any{"cp1": map[string]any{"disk": "/dev/sda"}}}
+
+	path, err := writeValuesLock(root, "v1", values, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != filepath.Join(root, releasesDir, "v1", valuesLockName) {
+		t.Errorf("path = %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(data), "# Managed by talm. The complete values release v1 was rendered with") {
+		t.Errorf("the lock must open with its header:\n%s", data)
+	}
+
+	var got map[string]any
+	if err := yaml.Unmarshal(data, &got); err != nil || got["endpoint"] != "https://192.0.2.1:6443" {
+		t.Errorf("lock = %v, %v", got, err)
+	}
+
+	if _, err := writeValuesLock(root, "v1", values, false, false); err == nil || !strings.Contains(err.Error(), "already locked") {
+		t.Errorf("an existing lock must be kept without --force, got %v", err)
+	}
+
+	if _, err := writeValuesLock(root, "v1", map[string]any{"endpoint": "changed"}, false, true); err != nil {
+		t.Fatalf("--force must replace the lock: %v", err)
+	}
+
+	if found, err := findValuesLock(root, "v1"); err != nil || found != path {
+		t.Errorf("findValuesLock = %s, %v", found, err)
+	}
+}
+
+// TestWriteValuesLock_Encrypted pins that a snapshot of encrypted
+// values is written encrypted and replaces a plaintext lock.
+func TestWriteValuesLock_Encrypted(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if _, _, err := age.GenerateKey(root); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := writeValuesLock(root, "v2", map[string]any{"token": "plain"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := writeValuesLock(root, "v2", map[string]any{"token": "s3cr3t"}, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(path, "values.lock.encrypted.yaml") || fileExists(plain) {
+		t.Errorf("path = %s, plaintext lock left behind: %v", path, fileExists(plain))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "s3cr3t") || !age.ContainsEncryptedValues(data) {
+		t.Errorf("the lock must be encrypted:\n%s", data)
+	}
+
+	if found, err := findValuesLock(root, "v2"); err != nil || found != path {
+		t.Errorf("findValuesLock = %s, %v", found, err)
+	}
+}
+
+func TestResolveReleaseValuesLock(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	lock, err := writeValuesLock(root, "v1", map[string]any{"a": 1}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newFlags := func() *pflag.FlagSet {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringSlice("values", nil, "")
+		flags.StringArray("set", nil, "")
+
+		return flags
+	}
+
+	if got, err := resolveReleaseValuesLock(newFlags(), root, ""); err != nil || got != "" {
+		t.Errorf("without --release: %q, %v", got, err)
+	}
+
+	if got, err := resolveReleaseValuesLock(newFlags(), root, "v1"); err != nil || got != lock {
+		t.Errorf("--release v1: %q, %v", got, err)
+	}
+
+	if _, err := resolveReleaseValuesLock(newFlags(), root, "v9"); err == nil || !strings.Contains(err.Error(), "has no values lock") {
+		t.Errorf("an unknown release must fail, got %v", err)
+	}
+
+	flags := newFlags()
+	if err := flags.Parse([]string{"--set", "a=2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := resolveReleaseValuesLock(flags, root, "v1"); err == nil || !strings.Contains(err.Error(), "--set") {
+		t.Errorf("--release with --set must be refused, got %v", err)
+	}
+}
+
+func TestHasEncryptedValueFile(t *testing.T) {
+	t.Parallel()
+
+	if hasEncryptedValueFile([]string{"values.yaml", "prod.yaml"}) {
+		t.Error("plaintext value files only")
+	}
+
+	if !hasEncryptedValueFile([]string{"values.yaml", "/p/values-secret.encrypted.yaml"}) {
+		t.Error("an encrypted value file must be detected")
+	}
+}
@@ -0,0 +1,521 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// renderHookCommandName names render-hook in render errors.
+	renderHookCommandName = "talm render-hook"
+
+	// renderHookEnvPrefix, argoCDEnvPrefix and argoCDParamPrefix are
+	// where render-hook parameters are read from: TALM_<NAME> as set by
+	// hand or by a Flux CI job, ARGOCD_ENV_TALM_<NAME> as an Application
+	// passes plugin env, and PARAM_<NAME> as an ArgoCD Config
+	// Management Plugin passes its declared parameters.
+	renderHookEnvPrefix = "TALM_"
+	argoCDEnvPrefix     = "ARGOCD_ENV_TALM_"
+	argoCDParamPrefix   = "PARAM_"
+
+	// renderHookSecretKey is the key of the rendered machine config in
+	// the emitted Secret.
+	renderHookSecretKey = "machineconfig.yaml"
+
+	// renderHookNodeFileAnnotation and renderHookNodesAnnotation record
+	// where a Secret comes from and which nodes it is for.
+	renderHookNodeFileAnnotation = "talm.cozystack.io/node-file"
+	renderHookNodesAnnotation    = "talm.cozystack.io/nodes"
+
+	// renderHookDefaultPrefix names the Secrets when neither the
+	// parameters nor the project directory give a usable name.
+	renderHookDefaultPrefix = "talm"
+
+	// kustomizationFileName is the file Flux and kustomize build a
+	// directory from.
+	kustomizationFileName = "kustomization.yaml"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var renderHookCmd = &cobra.Command{
+	Use:   "render-hook",
+	Short: "Render node files offline as Kubernetes manifests for ArgoCD or Flux",
+	Long: `Render every node file offline, as talm apply would send it, and emit one
+Kubernetes Secret per node file holding the machine config under the
+` + renderHookSecretKey + ` key. The command takes no flags: it follows the
+contract of an ArgoCD Config Management Plugin and of a Flux build step
+and reads its parameters from the environment, each as TALM_<NAME>,
+ARGOCD_ENV_TALM_<NAME> or PARAM_<NAME>, in that order:
+
+  FILES        comma-separated node files or directories, relative to the
+               project root (default: nodes)
+  OUTPUT       a directory to write the Secrets and a kustomization.yaml
+               to, for a Flux Kustomization; empty or "-" writes the
+               manifests to stdout, as ArgoCD expects (default: stdout)
+  NAMESPACE    namespace of the Secrets (default: $ARGOCD_APP_NAMESPACE,
+               else none)
+  NAME_PREFIX  prefix of the Secret names (default: $ARGOCD_APP_NAME, else
+               the project directory name)
+  SNAPSHOT     a talm snapshot cluster directory that answers the lookups
+               of the templates
+
+The output is deterministic: the node files are rendered in path order,
+the render never contacts a node, and the same tree renders the same
+bytes. The secrets file is read in plain text or, when only its encrypted
+form is checked out, decrypted in memory with the project key.`,
+	Example: `  talm render-hook
+  TALM_OUTPUT=deploy/ TALM_NAMESPACE=talos talm render-hook`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		params := loadRenderHookParams(os.Getenv, Config.RootDir)
+
+		return runRenderHook(cmd.Context(), cmd.OutOrStdout(), ui.Progress(os.Stderr), Config.RootDir, params, renderNodeFileOffline)
+	},
+}
+
+// renderHookParams are the render-hook parameters read from the
+// environment.
+type renderHookParams struct {
+	files      []string
+	output     string
+	namespace  string
+	namePrefix string
+	snapshot   string
+}
+
+// renderedNodeFile is the machine config a node file renders to.
+type renderedNodeFile struct {
+	nodes  []string
+	config []byte
+}
+
+// nodeFileRenderer renders the node file at path, absolute, with
+// secretsPath as the cluster secrets.
+type nodeFileRenderer func(ctx context.Context, path, secretsPath, snapshot string) (renderedNodeFile, error)
+
+// renderHookSecret is a Kubernetes Secret manifest. Field order and
+// the sorted map keys make its encoding stable.
+type renderHookSecret struct {
+	APIVersion string               `yaml:"apiVersion"`
+	Kind       string               `yaml:"kind"`
+	Metadata   renderHookObjectMeta `yaml:"metadata"`
+	Type       string               `yaml:"type"`
+	Data       map[string]string    `yaml:"data"`
+}
+
+type renderHookObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// kustomization is the kustomization.yaml render-hook writes next to
+// the Secrets.
+type kustomization struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Resources  []string `yaml:"resources"`
+}
+
+// renderHookParam returns parameter name from the first of its
+// environment variables that is set.
+func renderHookParam(getenv func(string) string, name string) string {
+	return strings.TrimSpace(cmp.Or(
+		getenv(renderHookEnvPrefix+name),
+		getenv(argoCDEnvPrefix+name),
+		getenv(argoCDParamPrefix+name),
+	))
+}
+
+// loadRenderHookParams reads the render-hook parameters of the project
+// at rootDir, filling in the defaults.
+func loadRenderHookParams(getenv func(string) string, rootDir string) renderHookParams {
+	var files []string
+
+	for file := range strings.SplitSeq(renderHookParam(getenv, "FILES"), ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		files = []string{nodesDirName}
+	}
+
+	output := renderHookParam(getenv, "OUTPUT")
+	if output == "-" {
+		output = ""
+	}
+
+	prefix := kubernetesName(cmp.Or(renderHookParam(getenv, "NAME_PREFIX"), getenv("ARGOCD_APP_NAME"), filepath.Base(rootDir)))
+
+	return renderHookParams{
+		files:      files,
+		output:     output,
+		namespace:  cmp.Or(renderHookParam(getenv, "NAMESPACE"), getenv("ARGOCD_APP_NAMESPACE")),
+		namePrefix: cmp.Or(prefix, renderHookDefaultPrefix),
+		snapshot:   renderHookParam(getenv, "SNAPSHOT"),
+	}
+}
+
+// kubernetesName turns s into a lowercase DNS label: runs of characters
+// other than letters and digits become one dash, and leading and
+// trailing dashes are dropped.
+func kubernetesName(s string) string {
+	var b strings.Builder
+
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+
+			dash = false
+
+			continue
+		}
+
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+
+			dash = true
+		}
+	}
+
+	return strings.TrimRight(b.String(), "-")
+}
+
+// renderHookSecretName names the Secret of the node file rel, relative
+// to the project root: the prefix and the path without nodes/ and the
+// extension, so nodes/site-a/cp1.yaml becomes <prefix>-site-a-cp1.
+func renderHookSecretName(prefix, rel string) string {
+	rel = filepath.ToSlash(rel)
+	rel = strings.TrimPrefix(rel, nodesDirName+"/")
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+
+	return kubernetesName(prefix + "-" + rel)
+}
+
+// runRenderHook renders the node files of params with render and writes
+// their Secrets to out, or to the output directory with a
+// kustomization.yaml listing them.
+func runRenderHook(ctx context.Context, out, progress io.Writer, rootDir string, params renderHookParams, render nodeFileRenderer) error {
+	paths := make([]string, 0, len(params.files))
+	for _, file := range params.files {
+		paths = append(paths, filepath.Join(rootDir, filepath.FromSlash(file)))
+	}
+
+	files, err := ExpandFilePaths(paths)
+	if err != nil {
+		return err
+	}
+
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	secretsPath, cleanup, err := renderHookSecretsFile(rootDir)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	snapshot := params.snapshot
+	if snapshot != "" && !filepath.IsAbs(snapshot) {
+		snapshot = filepath.Join(rootDir, filepath.FromSlash(snapshot))
+	}
+
+	manifests := make([]renderHookSecret, 0, len(files))
+	sources := map[string]string{}
+
+	for _, path := range files {
+		rel, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return errors.Wrapf(err, "locating %s in the project", path)
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		rendered, err := render(ctx, path, secretsPath, snapshot)
+		if err != nil {
+			return errors.Wrapf(err, "rendering %s", rel)
+		}
+
+		manifest := newRenderHookSecret(params, rel, rendered)
+
+		if other, ok := sources[manifest.Metadata.Name]; ok {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("%s and %s both render to the Secret %s", other, rel, manifest.Metadata.Name),
+				"rename one of the node files so their names differ in more than case and punctuation",
+			)
+		}
+
+		sources[manifest.Metadata.Name] = rel
+		manifests = append(manifests, manifest)
+
+		ui.Infof(progress, "Rendered %s as Secret %s", rel, manifest.Metadata.Name)
+	}
+
+	if params.output == "" {
+		return writeRenderHookStream(out, manifests)
+	}
+
+	outputDir := params.output
+	if !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(rootDir, filepath.FromSlash(outputDir))
+	}
+
+	return writeRenderHookDir(progress, outputDir, manifests)
+}
+
+// newRenderHookSecret wraps the config rendered from the node file rel
+// in a Secret.
+func newRenderHookSecret(params renderHookParams, rel string, rendered renderedNodeFile) renderHookSecret {
+	return renderHookSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: renderHookObjectMeta{
+			Name:      renderHookSecretName(params.namePrefix, rel),
+			Namespace: params.namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "talm"},
+			Annotations: map[string]string{
+				renderHookNodeFileAnnotation: rel,
+				renderHookNodesAnnotation:    strings.Join(rendered.nodes, ","),
+			},
+		},
+		Type: "Opaque",
+		Data: map[string]string{renderHookSecretKey: base64.StdEncoding.EncodeToString(rendered.config)},
+	}
+}
+
+// encodeRenderHookManifest encodes v as a YAML document.
+func encodeRenderHookManifest(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, errors.Wrap(err, "encoding manifest")
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, errors.Wrap(err, "encoding manifest")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeRenderHookStream writes manifests to out as one multi-document
+// YAML stream.
+func writeRenderHookStream(out io.Writer, manifests []renderHookSecret) error {
+	for i, manifest := range manifests {
+		data, err := encodeRenderHookManifest(manifest)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			data = append([]byte("---\n"), data...)
+		}
+
+		if _, err := out.Write(data); err != nil {
+			return errors.Wrap(err, "writing manifests")
+		}
+	}
+
+	return nil
+}
+
+// writeRenderHookDir writes each manifest to <name>.yaml in dir and a
+// kustomization.yaml listing them. The files hold the cluster secrets
+// and are written owner-only.
+func writeRenderHookDir(progress io.Writer, dir string, manifests []renderHookSecret) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrapf(err, "creating %s", dir)
+	}
+
+	resources := make([]string, 0, len(manifests))
+
+	for _, manifest := range manifests {
+		data, err := encodeRenderHookManifest(manifest)
+		if err != nil {
+			return err
+		}
+
+		name := manifest.Metadata.Name + "." + yamlExt
+		if err := secureperm.WriteFile(filepath.Join(dir, name), data); err != nil {
+			return errors.Wrapf(err, "writing %s", name)
+		}
+
+		resources = append(resources, name)
+	}
+
+	data, err := encodeRenderHookManifest(kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := secureperm.WriteFile(filepath.Join(dir, kustomizationFileName), data); err != nil {
+		return errors.Wrapf(err, "writing %s", kustomizationFileName)
+	}
+
+	ui.Successf(progress, "Wrote %d Secret(s) and %s to %s", len(manifests), kustomizationFileName, dir)
+
+	return nil
+}
+
+// renderHookSecretsFile returns the path of the plain secrets file of
+// the project at rootDir. A GitOps checkout carries only the encrypted
+// file, so that is decrypted to a temporary owner-only file, which
+// cleanup removes. Rendering without secrets would generate new ones
+// on every run, so a project with neither file is an error.
+func renderHookSecretsFile(rootDir string) (string, func(), error) {
+	layout := secretsLayout()
+
+	plain := cmp.Or(Config.TemplateOptions.WithSecrets, filepath.FromSlash(layout.SecretsFile()))
+	if !filepath.IsAbs(plain) {
+		plain = filepath.Join(rootDir, plain)
+	}
+
+	if fileExists(plain) {
+		return plain, func() {}, nil
+	}
+
+	encrypted := filepath.Join(rootDir, filepath.FromSlash(layout.EncryptedSecretsFile()))
+
+	data, err := os.ReadFile(encrypted)
+	if err != nil {
+		if os.IsNotExist(err) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return "", nil, errors.WithHintf(
+				errors.Newf("neither %s nor %s exists", layout.SecretsFile(), layout.EncryptedSecretsFile()),
+				"a render without the cluster secrets is not reproducible; commit %s", layout.EncryptedSecretsFile(),
+			)
+		}
+
+		return "", nil, errors.Wrapf(err, "reading %s", layout.EncryptedSecretsFile())
+	}
+
+	decrypted, err := age.DecryptYAML(rootDir, data)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", nil, errors.WithHintf(
+			errors.Wrapf(err, "decrypting %s", layout.EncryptedSecretsFile()),
+			"place the project key at %s before the render, e.g. in the plugin's init command", layout.KeyFile(),
+		)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "talm-render-hook-")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "creating a directory for the decrypted secrets")
+	}
+
+	cleanup := func() { _ = os.RemoveAll(tmpDir) }
+
+	path := filepath.Join(tmpDir, filepath.Base(layout.SecretsFile()))
+	if err := secureperm.WriteFile(path, decrypted); err != nil {
+		cleanup()
+
+		return "", nil, errors.Wrap(err, "writing the decrypted secrets")
+	}
+
+	return path, cleanup, nil
+}
+
+// renderNodeFileOffline renders the node file at path as talm apply
+// would send it, the templates of its modeline merged with its body,
+// without contacting a node.
+func renderNodeFileOffline(ctx context.Context, path, secretsPath, snapshotDir string) (renderedNodeFile, error) {
+	_, modelineConfig, err := modeline.FindAndParseModeline(path)
+	if err != nil {
+		return renderedNodeFile{}, errors.Wrap(err, "modeline parsing failed")
+	}
+
+	if len(modelineConfig.Templates) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return renderedNodeFile{}, errors.WithHint(
+			errors.New("modeline does not contain templates information"),
+			"add a `# talm: templates=[...]` modeline at the top of the node file",
+		)
+	}
+
+	talosVersion, err := nodeTalosVersion(Config.RootDir, modelineConfig.Nodes, Config.TemplateOptions.TalosVersion)
+	if err != nil {
+		return renderedNodeFile{}, err
+	}
+
+	endpoints := modelineConfig.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{defaultLocalEndpoint}
+	}
+
+	opts := projectRenderOptions(renderHookCommandName, "")
+	opts.TalosVersion = talosVersion
+	opts.WithSecrets = secretsPath
+	opts.Full = true
+	opts.Offline = true
+	opts.TemplateFiles = resolveEngineTemplatePaths(modelineConfig.Templates, Config.RootDir)
+	opts.TalosEndpoints = endpoints
+	opts.Role = modelineConfig.Role
+
+	if snapshotDir != "" {
+		opts.Snapshot, err = loadTemplateSnapshot(snapshotDir, modelineConfig.Nodes)
+		if err != nil {
+			return renderedNodeFile{}, err
+		}
+	}
+
+	rendered, err := engine.Render(ctx, nil, opts)
+	if err != nil {
+		return renderedNodeFile{}, errors.Wrap(err, "template rendering")
+	}
+
+	merged, err := engine.MergeFileAsPatch(rendered, path)
+	if err != nil {
+		return renderedNodeFile{}, errors.Wrapf(err, "merging node file %q as patch", path)
+	}
+
+	return renderedNodeFile{nodes: modelineConfig.Nodes, config: merged}, nil
+}
+
+func init() {
+	addCommand(renderHookCmd)
+}
@@ -0,0 +1,274 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// fakeNodeFileRender renders a node file to its own body, recording
+// the secrets file it was given.
+func fakeNodeFileRender(secrets *[]string) nodeFileRenderer {
+	return func(_ context.Context, path, secretsPath, _ string) (renderedNodeFile, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return renderedNodeFile{}, err
+		}
+
+		*secrets = append(*secrets, secretsPath)
+
+		return renderedNodeFile{nodes: []string{"10.0.0." + strings.TrimSuffix(filepath.Base(path), ".yaml")}, config: data}, nil
+	}
+}
+
+func writeRenderHookProject(t *testing.T) string {
+	t.Helper()
+
+	return writePruneProject(t, map[string]string{
+		"secrets.yaml":   "cluster:\n  id: abc\n",
+		"nodes/2.yaml":   "# talm: nodes=[\"10.0.0.2\"]\nmachine: {}\n",
+		"nodes/1.yaml":   "# talm: nodes=[\"10.0.0.1\"]\nmachine: {}\n",
+		"nodes/README":   "not a node file\n",
+		"other/skip.yml": "ignored: true\n",
+	})
+}
+
+func TestLoadRenderHookParams(t *testing.T) {
+	t.Parallel()
+
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	got := loadRenderHookParams(env(nil), "/srv/Prod_Cluster")
+	if !slices.Equal(got.files, []string{"nodes"}) || got.output != "" || got.namespace != "" || got.namePrefix != "prod-cluster" {
+		t.Errorf("defaults = %+v", got)
+	}
+
+	got = loadRenderHookParams(env(map[string]string{
+		"TALM_FILES":             " nodes/cp1.yaml, ,nodes/workers ",
+		"ARGOCD_ENV_TALM_FILES":  "ignored",
+		"ARGOCD_ENV_TALM_OUTPUT": "-",
+		"PARAM_NAMESPACE":        "talos",
+		"ARGOCD_APP_NAMESPACE":   "argocd",
+		"ARGOCD_APP_NAME":        "Edge.Site",
+		"PARAM_SNAPSHOT":         "snapshots",
+	}), "/srv/cluster")
+
+	want := renderHookParams{
+		files:      []string{"nodes/cp1.yaml", "nodes/workers"},
+		namespace:  "talos",
+		namePrefix: "edge-site",
+		snapshot:   "snapshots",
+	}
+	if !slices.Equal(got.files, want.files) || got.output != want.output || got.namespace != want.namespace ||
+		got.namePrefix != want.namePrefix || got.snapshot != want.snapshot {
+		t.Errorf("params = %+v, want %+v", got, want)
+	}
+
+	if got := loadRenderHookParams(env(map[string]string{"TALM_NAME_PREFIX": "--"}), "/"); got.namePrefix != renderHookDefaultPrefix {
+		t.Errorf("an unusable prefix must fall back to %q, got %q", renderHookDefaultPrefix, got.namePrefix)
+	}
+}
+
+func TestRenderHookSecretName(t *testing.T) {
+	t.Parallel()
+
+	for rel, want := range map[string]string{
+		"nodes/cp1.yaml":          "prod-cp1",
+		"nodes/site-a/Worker.yml": "prod-site-a-worker",
+		"nodes/10.0.0.1.json":     "prod-10-0-0-1",
+		"extra/cp_1.yaml":         "prod-extra-cp-1",
+	} {
+		if got := renderHookSecretName("prod", rel); got != want {
+			t.Errorf("renderHookSecretName(%s) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+// TestRunRenderHook_Stream pins the ArgoCD output: one Secret per node
+// file on stdout, in path order, rendered with the plain secrets file.
+func TestRunRenderHook_Stream(t *testing.T) {
+	t.Parallel()
+
+	dir := writeRenderHookProject(t)
+
+	var (
+		out, progress bytes.Buffer
+		secrets       []string
+	)
+
+	params := renderHookParams{files: []string{"nodes"}, namespace: "talos", namePrefix: "prod"}
+
+	if err := runRenderHook(context.Background(), &out, &progress, dir, params, fakeNodeFileRender(&secrets)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `apiVersion: v1
+kind: Secret
+metadata:
+  name: prod-1
+  namespace: talos
+  labels:
+    app.kubernetes.io/managed-by: talm
+  annotations:
+    talm.cozystack.io/node-file: nodes/1.yaml
+    talm.cozystack.io/nodes: 10.0.0.1
+type: Opaque
+data:
+  machineconfig.yaml: IyB0YWxtOiBub2Rlcz1bIjEwLjAuMC4xIl0KbWFjaGluZToge30K
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: prod-2
+  namespace: talos
+  labels:
+    app.kubernetes.io/managed-by: talm
+  annotations:
+    talm.cozystack.io/node-file: nodes/2.yaml
+    talm.cozystack.io/nodes: 10.0.0.2
+type: Opaque
+data:
+  machineconfig.yaml: IyB0YWxtOiBub2Rlcz1bIjEwLjAuMC4yIl0KbWFjaGluZToge30K
+`
+	if out.String() != want {
+		t.Errorf("stream =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	plain := filepath.Join(dir, "secrets.yaml")
+	if !slices.Equal(secrets, []string{plain, plain}) {
+		t.Errorf("secrets files = %v, want %s for both", secrets, plain)
+	}
+}
+
+// TestRunRenderHook_Directory pins the Flux output: the Secrets and a
+// kustomization.yaml listing them, owner-only, in the output directory.
+func TestRunRenderHook_Directory(t *testing.T) {
+	t.Parallel()
+
+	dir := writeRenderHookProject(t)
+
+	var secrets []string
+
+	params := renderHookParams{files: []string{"nodes/2.yaml"}, output: "deploy", namePrefix: "prod"}
+
+	if err := runRenderHook(context.Background(), io.Discard, io.Discard, dir, params, fakeNodeFileRender(&secrets)); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization, err := os.ReadFile(filepath.Join(dir, "deploy", kustomizationFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n  - prod-2.yaml\n"
+	if string(kustomization) != want {
+		t.Errorf("kustomization.yaml =\n%s\nwant\n%s", kustomization, want)
+	}
+
+	secret, err := os.ReadFile(filepath.Join(dir, "deploy", "prod-2.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(secret), "name: prod-2\n") || strings.Contains(string(secret), "namespace:") {
+		t.Errorf("Secret =\n%s", secret)
+	}
+}
+
+func TestRunRenderHook_NameCollision(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		"secrets.yaml":     "cluster:\n  id: abc\n",
+		"nodes/cp_1.yaml":  "machine: {}\n",
+		"nodes/cp-1.yaml":  "machine: {}\n",
+		"nodes/other.yaml": "machine: {}\n",
+	})
+
+	var secrets []string
+
+	err := runRenderHook(context.Background(), io.Discard, io.Discard, dir, renderHookParams{files: []string{"nodes"}, namePrefix: "p"}, fakeNodeFileRender(&secrets))
+	if err == nil || !strings.Contains(err.Error(), "nodes/cp-1.yaml and nodes/cp_1.yaml both render to the Secret p-cp-1") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRenderHookSecretsFile_Missing(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+
+	_, _, err := renderHookSecretsFile(dir)
+	if err == nil || !strings.Contains(err.Error(), "neither secrets.yaml nor secrets.encrypted.yaml exists") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestRenderHookSecretsFile_Encrypted pins that a checkout with only
+// the encrypted secrets renders from a decrypted copy outside the
+// project, which cleanup removes.
+func TestRenderHookSecretsFile_Encrypted(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{})
+	age.SetLayout(age.Layout{})
+
+	if _, _, err := age.GenerateKey(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "secrets.yaml"), []byte("cluster:\n  secret: s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptSecretsFile(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "secrets.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, cleanup, err := renderHookSecretsFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasPrefix(path, dir) {
+		t.Errorf("the decrypted secrets must not land in the project, got %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "secret: s3cr3t") {
+		t.Errorf("decrypted secrets = %q", data)
+	}
+
+	cleanup()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cleanup must remove the decrypted secrets, stat err = %v", err)
+	}
+}
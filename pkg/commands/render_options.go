@@ -0,0 +1,59 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+// projectRenderOptions returns the engine options every command that
+// renders the project starts from: the value sources and render
+// settings of Chart.yaml templateOptions, the project root and the
+// endpoints of the talosconfig context. A non-empty valuesLock, from
+// --release, stands in for the value sources. Commands set the
+// templates and override what their own flags choose on the result,
+// so a templateOptions setting reaches every command that renders.
+func projectRenderOptions(commandName, valuesLock string) engine.Options {
+	opts := engine.Options{
+		TalosVersion:       Config.TemplateOptions.TalosVersion,
+		WithSecrets:        ResolveSecretsPath(Config.TemplateOptions.WithSecrets),
+		KubernetesVersion:  Config.TemplateOptions.KubernetesVersion,
+		Root:               Config.RootDir,
+		CommandName:        commandName,
+		TalosEndpoints:     append([]string(nil), GlobalArgs.Endpoints...),
+		AllowEnv:           Config.TemplateOptions.AllowEnv,
+		SecretStore:        Config.TemplateOptions.SecretStore,
+		MergeRules:         Config.TemplateOptions.MergeRules,
+		PrewarmLookups:     Config.TemplateOptions.PrewarmLookups,
+		Roles:              Config.TemplateOptions.Roles,
+		Prompt:             interactiveValuePrompt(),
+		StrictDeprecations: Config.TemplateOptions.StrictDeprecations,
+	}
+
+	if valuesLock != "" {
+		opts.ValuesLock = valuesLock
+
+		return opts
+	}
+
+	opts.ValueFiles = resolveProjectValueFiles(Config.TemplateOptions.ValueFiles, Config.RootDir)
+	opts.Values = Config.TemplateOptions.Values
+	opts.StringValues = Config.TemplateOptions.StringValues
+	opts.FileValues = Config.TemplateOptions.FileValues
+	opts.JsonValues = Config.TemplateOptions.JsonValues
+	opts.LiteralValues = Config.TemplateOptions.LiteralValues
+
+	return opts
+}
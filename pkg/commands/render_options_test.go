@@ -0,0 +1,80 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestProjectRenderOptions_CarriesTemplateOptions pins that the shared
+// builder hands every templateOptions render setting to the engine, so
+// a command built on it cannot silently drop one.
+func TestProjectRenderOptions_CarriesTemplateOptions(t *testing.T) {
+	origConfig := Config
+	t.Cleanup(func() { Config = origConfig })
+
+	Config.RootDir = testProjectRoot
+	Config.TemplateOptions.ValueFiles = []string{"values-prod.yaml"}
+	Config.TemplateOptions.Values = []string{"mtu=1500"}
+	Config.TemplateOptions.StrictDeprecations = true
+	Config.TemplateOptions.PrewarmLookups = []string{"links"}
+
+	opts := projectRenderOptions(explainCommandName, "")
+
+	if want := []string{filepath.Join(testProjectRoot, "values-prod.yaml")}; !slices.Equal(opts.ValueFiles, want) {
+		t.Errorf("ValueFiles = %v, want %v", opts.ValueFiles, want)
+	}
+
+	if !slices.Equal(opts.Values, []string{"mtu=1500"}) {
+		t.Errorf("Values = %v", opts.Values)
+	}
+
+	if !opts.StrictDeprecations {
+		t.Error("StrictDeprecations was dropped")
+	}
+
+	if !slices.Equal(opts.PrewarmLookups, []string{"links"}) {
+		t.Errorf("PrewarmLookups = %v", opts.PrewarmLookups)
+	}
+
+	if opts.CommandName != explainCommandName || opts.Root != testProjectRoot {
+		t.Errorf("CommandName = %q, Root = %q", opts.CommandName, opts.Root)
+	}
+}
+
+// TestProjectRenderOptions_ValuesLockReplacesSources pins that a values
+// lock stands in for the Chart.yaml value sources rather than joining
+// them.
+func TestProjectRenderOptions_ValuesLockReplacesSources(t *testing.T) {
+	origConfig := Config
+	t.Cleanup(func() { Config = origConfig })
+
+	Config.RootDir = testProjectRoot
+	Config.TemplateOptions.ValueFiles = []string{"values-prod.yaml"}
+	Config.TemplateOptions.Values = []string{"mtu=1500"}
+
+	lock := filepath.Join(testProjectRoot, "releases", "v1.0.0", valuesLockName)
+	opts := projectRenderOptions(explainCommandName, lock)
+
+	if opts.ValuesLock != lock {
+		t.Errorf("ValuesLock = %q, want %q", opts.ValuesLock, lock)
+	}
+
+	if len(opts.ValueFiles) != 0 || len(opts.Values) != 0 {
+		t.Errorf("value sources kept next to the lock: %v %v", opts.ValueFiles, opts.Values)
+	}
+}
@@ -0,0 +1,512 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/state"
+)
+
+const (
+	reportFormatMarkdown = "markdown"
+	reportFormatHTML     = "html"
+)
+
+// reportDateLayout is the date-only form --since and --until accept
+// next to RFC 3339 timestamps and relative durations.
+const reportDateLayout = "2006-01-02"
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var reportCmdFlags struct {
+	since  string
+	until  string
+	id     string
+	format string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write a change report of recorded applies and upgrades",
+	Long: `Write a human-readable report of the applies and upgrades recorded in the
+project history, for attaching to a change ticket: who ran what and when,
+how long it took, whether it succeeded, the nodes it touched with their
+Talos version before and after, and how many configuration documents and
+fields the drift preview found changing on each node.
+
+The report is built from the history alone; nothing is sent anywhere and the
+cluster is not contacted. Configuration values never appear in it, only
+counts. Select the operations with --id (as listed by talm state history, a
+prefix is enough) or with --since/--until, which take an RFC 3339 time, a
+date or a duration back from now.`,
+	Example: `  talm report --since 2026-05-01 --until 2026-05-07 > change-4711.md
+  talm report --since 24h --format html > change.html
+  talm report --id 20260501T120000`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		now := time.Now().UTC()
+
+		sel, err := parseReportSelection(reportCmdFlags.id, reportCmdFlags.since, reportCmdFlags.until, now)
+		if err != nil {
+			return err
+		}
+
+		backend, err := openProjectState()
+		if err != nil {
+			return err
+		}
+
+		records, err := state.ReadHistory(cmd.Context(), backend, 0)
+		if err != nil {
+			return err //nolint:wrapcheck // state errors carry the key and backend location.
+		}
+
+		report := buildChangeReport(sel.filter(records), sel, backend.Describe(), now)
+
+		return writeChangeReport(cmd.OutOrStdout(), reportCmdFlags.format, report)
+	},
+}
+
+// reportSelection is the set of history records a report covers.
+type reportSelection struct {
+	id    string
+	since time.Time
+	until time.Time
+}
+
+// parseReportSelection validates the selection flags against now.
+func parseReportSelection(id, since, until string, now time.Time) (reportSelection, error) {
+	sel := reportSelection{id: id}
+
+	var err error
+
+	if sel.since, err = parseReportTime("--since", since, now, false); err != nil {
+		return sel, err
+	}
+
+	if sel.until, err = parseReportTime("--until", until, now, true); err != nil {
+		return sel, err
+	}
+
+	if !sel.since.IsZero() && !sel.until.IsZero() && !sel.until.After(sel.since) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return sel, errors.WithHint(
+			errors.Newf("--until %s is not after --since %s", until, since),
+			"swap the two values",
+		)
+	}
+
+	return sel, nil
+}
+
+// parseReportTime reads an RFC 3339 time, a date or a duration back
+// from now. A date given to --until covers that whole day.
+func parseReportTime(flag, value string, now time.Time, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	if t, err := time.Parse(reportDateLayout, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return time.Time{}, errors.WithHintf(
+		errors.Newf("%s %q is neither a time, a date nor a duration", flag, value),
+		"use an RFC 3339 time (2026-05-01T12:00:00Z), a date (%s) or a duration back from now (24h)", reportDateLayout,
+	)
+}
+
+// filter returns the records the selection covers, oldest first.
+// Operations are placed in time by when they started.
+func (s reportSelection) filter(records []state.Record) []state.Record {
+	var selected []state.Record
+
+	for _, rec := range records {
+		if s.id != "" && !strings.HasPrefix(rec.ID, s.id) {
+			continue
+		}
+
+		if !s.since.IsZero() && rec.Started().Before(s.since) {
+			continue
+		}
+
+		if !s.until.IsZero() && !rec.Started().Before(s.until) {
+			continue
+		}
+
+		selected = append(selected, rec)
+	}
+
+	return selected
+}
+
+// describe names the selection in the report header.
+func (s reportSelection) describe() string {
+	var parts []string
+
+	if s.id != "" {
+		parts = append(parts, "operation "+s.id)
+	}
+
+	switch {
+	case !s.since.IsZero() && !s.until.IsZero():
+		parts = append(parts, s.since.Format(time.RFC3339)+" to "+s.until.Format(time.RFC3339))
+	case !s.since.IsZero():
+		parts = append(parts, "since "+s.since.Format(time.RFC3339))
+	case !s.until.IsZero():
+		parts = append(parts, "until "+s.until.Format(time.RFC3339))
+	}
+
+	if len(parts) == 0 {
+		return "all recorded operations"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// changeReport is the format-independent content of a report.
+type changeReport struct {
+	Selection    string
+	Source       string
+	Generated    string
+	Failed       int
+	NodesTouched []string
+	Operations   []reportOperation
+}
+
+type reportOperation struct {
+	Number   int
+	ID       string
+	Title    string
+	Operator string
+	Started  string
+	Finished string
+	Duration string
+	Result   string
+	Failed   bool
+	Image    string
+	Nodes    []reportNode
+}
+
+type reportNode struct {
+	Node    string
+	Version string
+	Changes string
+}
+
+// buildChangeReport turns history records into report content.
+func buildChangeReport(records []state.Record, sel reportSelection, source string, generated time.Time) changeReport {
+	report := changeReport{
+		Selection: sel.describe(),
+		Source:    source,
+		Generated: generated.Format(time.RFC3339),
+	}
+
+	for _, rec := range records {
+		op := reportOperation{
+			Number:   len(report.Operations) + 1,
+			ID:       rec.ID,
+			Title:    strings.TrimSpace(rec.Operation + " " + rec.File),
+			Operator: rec.Operator,
+			Started:  "not recorded",
+			Finished: rec.Time.UTC().Format(time.RFC3339),
+			Duration: "not recorded",
+			Result:   "succeeded",
+			Image:    rec.Image,
+		}
+
+		if rec.Duration > 0 {
+			op.Started = rec.Started().UTC().Format(time.RFC3339)
+			op.Duration = formatReportDuration(rec.Duration)
+		}
+
+		if rec.Error != "" {
+			op.Result = "failed: " + firstLine(rec.Error)
+			op.Failed = true
+			report.Failed++
+		}
+
+		op.Nodes = reportNodes(rec)
+
+		for _, node := range op.Nodes {
+			if !slices.Contains(report.NodesTouched, node.Node) {
+				report.NodesTouched = append(report.NodesTouched, node.Node)
+			}
+		}
+
+		report.Operations = append(report.Operations, op)
+	}
+
+	slices.Sort(report.NodesTouched)
+
+	return report
+}
+
+// reportNodes lists the nodes of one record: those the journal saw,
+// then any targeted node it did not. A journal entry without a node
+// comes from an apply that talked to the single default node.
+func reportNodes(rec state.Record) []reportNode {
+	var nodes []reportNode
+
+	seen := map[string]bool{}
+
+	for _, change := range rec.Changes {
+		name := change.Node
+		if name == "" {
+			name = strings.Join(rec.Nodes, ", ")
+		}
+
+		if name == "" {
+			name = "default node"
+		}
+
+		seen[name] = true
+		nodes = append(nodes, reportNode{Node: name, Version: formatReportVersions(change), Changes: formatReportChanges(change)})
+	}
+
+	for _, name := range rec.Nodes {
+		if !seen[name] {
+			nodes = append(nodes, reportNode{Node: name, Version: "not recorded", Changes: "not recorded"})
+		}
+	}
+
+	return nodes
+}
+
+func formatReportDuration(d time.Duration) string {
+	if d >= time.Second {
+		return d.Round(time.Second).String()
+	}
+
+	return d.String()
+}
+
+// formatReportVersions renders the Talos version column: one version
+// when it did not change or only one side was read, before → after
+// when it did.
+func formatReportVersions(change state.NodeChange) string {
+	switch {
+	case change.VersionBefore != "" && change.VersionAfter != "" && change.VersionBefore != change.VersionAfter:
+		return change.VersionBefore + " → " + change.VersionAfter
+	case change.VersionBefore != "":
+		return change.VersionBefore
+	case change.VersionAfter != "":
+		return "→ " + change.VersionAfter
+	default:
+		return "not recorded"
+	}
+}
+
+// formatReportChanges renders the configuration column from the drift
+// preview counts.
+func formatReportChanges(change state.NodeChange) string {
+	if !change.Previewed {
+		return "not compared"
+	}
+
+	var parts []string
+
+	if change.Added > 0 {
+		parts = append(parts, countNoun(change.Added, "document")+" added")
+	}
+
+	if change.Removed > 0 {
+		parts = append(parts, countNoun(change.Removed, "document")+" removed")
+	}
+
+	if change.Updated > 0 {
+		parts = append(parts, fmt.Sprintf("%s updated (%s)", countNoun(change.Updated, "document"), countNoun(change.Fields, "field")))
+	}
+
+	if len(parts) == 0 {
+		return "no changes"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func countNoun(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// writeChangeReport renders report in format.
+func writeChangeReport(w io.Writer, format string, report changeReport) error {
+	switch format {
+	case reportFormatMarkdown:
+		return writeMarkdownReport(w, report)
+	case reportFormatHTML:
+		return errors.Wrap(htmlReportTemplate.Execute(w, report), "writing the report")
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("unknown report format %q", format),
+			"use --format %s or --format %s", reportFormatMarkdown, reportFormatHTML,
+		)
+	}
+}
+
+func writeMarkdownReport(w io.Writer, report changeReport) error {
+	var b strings.Builder
+
+	b.WriteString("# Change report\n\n")
+	fmt.Fprintf(&b, "- **Covers:** %s\n", markdownText(report.Selection))
+	fmt.Fprintf(&b, "- **Operations:** %d (%d failed)\n", len(report.Operations), report.Failed)
+	fmt.Fprintf(&b, "- **Nodes touched:** %s\n", markdownText(joinOrNone(report.NodesTouched)))
+	fmt.Fprintf(&b, "- **History:** %s\n", markdownText(report.Source))
+	fmt.Fprintf(&b, "- **Generated:** %s\n", report.Generated)
+
+	if len(report.Operations) == 0 {
+		b.WriteString("\nNo operations were recorded in this period.\n")
+	}
+
+	for _, op := range report.Operations {
+		fmt.Fprintf(&b, "\n## %d. %s\n\n", op.Number, markdownText(op.Title))
+		fmt.Fprintf(&b, "- **ID:** %s\n", markdownText(op.ID))
+		fmt.Fprintf(&b, "- **Operator:** %s\n", markdownText(op.Operator))
+		fmt.Fprintf(&b, "- **Started:** %s\n", op.Started)
+		fmt.Fprintf(&b, "- **Finished:** %s\n", op.Finished)
+		fmt.Fprintf(&b, "- **Duration:** %s\n", op.Duration)
+		fmt.Fprintf(&b, "- **Result:** %s\n", markdownText(op.Result))
+
+		if op.Image != "" {
+			fmt.Fprintf(&b, "- **Image:** %s\n", markdownText(op.Image))
+		}
+
+		if len(op.Nodes) == 0 {
+			continue
+		}
+
+		b.WriteString("\n| Node | Talos version | Configuration changes |\n|---|---|---|\n")
+
+		for _, node := range op.Nodes {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", markdownCell(node.Node), markdownCell(node.Version), markdownCell(node.Changes))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return errors.Wrap(err, "writing the report")
+}
+
+func joinOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+
+	return strings.Join(items, ", ")
+}
+
+// markdownText keeps recorded text (operator names, file names, error
+// messages) from being read as markdown.
+func markdownText(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// markdownCell is markdownText for a table cell, which also ends at a
+// pipe.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(markdownText(s), "|", `\|`)
+}
+
+//nolint:gochecknoglobals // immutable lookup table.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "\n", " ",
+)
+
+//nolint:gochecknoglobals // immutable lookup table.
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Change report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-top: 0.5em; }
+th, td { border: 1px solid #999; padding: 0.25em 0.75em; text-align: left; }
+.failed { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Change report</h1>
+<ul>
+<li><strong>Covers:</strong> {{.Selection}}</li>
+<li><strong>Operations:</strong> {{len .Operations}} ({{.Failed}} failed)</li>
+<li><strong>Nodes touched:</strong> {{if .NodesTouched}}{{range $i, $n := .NodesTouched}}{{if $i}}, {{end}}{{$n}}{{end}}{{else}}none{{end}}</li>
+<li><strong>History:</strong> {{.Source}}</li>
+<li><strong>Generated:</strong> {{.Generated}}</li>
+</ul>
+{{- if not .Operations}}
+<p>No operations were recorded in this period.</p>
+{{- end}}
+{{- range $op := .Operations}}
+<h2>{{$op.Number}}. {{$op.Title}}</h2>
+<ul>
+<li><strong>ID:</strong> {{$op.ID}}</li>
+<li><strong>Operator:</strong> {{$op.Operator}}</li>
+<li><strong>Started:</strong> {{$op.Started}}</li>
+<li><strong>Finished:</strong> {{$op.Finished}}</li>
+<li><strong>Duration:</strong> {{$op.Duration}}</li>
+<li><strong>Result:</strong> <span{{if $op.Failed}} class="failed"{{end}}>{{$op.Result}}</span></li>
+{{- if $op.Image}}
+<li><strong>Image:</strong> {{$op.Image}}</li>
+{{- end}}
+</ul>
+{{- if $op.Nodes}}
+<table>
+<tr><th>Node</th><th>Talos version</th><th>Configuration changes</th></tr>
+{{- range $op.Nodes}}
+<tr><td>{{.Node}}</td><td>{{.Version}}</td><td>{{.Changes}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func init() {
+	reportCmd.Flags().StringVar(&reportCmdFlags.since, "since", "", "include operations started at or after this time, date or duration back from now")
+	reportCmd.Flags().StringVar(&reportCmdFlags.until, "until", "", "include operations started before this time, date (inclusive) or duration back from now")
+	reportCmd.Flags().StringVar(&reportCmdFlags.id, "id", "", "include only the operation with this history ID or ID prefix")
+	reportCmd.Flags().StringVar(&reportCmdFlags.format, "format", reportFormatMarkdown, "report format: markdown or html")
+
+	addCommand(reportCmd)
+}
@@ -0,0 +1,220 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozystack/talm/pkg/state"
+)
+
+// reportRecords is a small history: a successful apply with a drift
+// preview, an upgrade that changed the version, and a failed apply
+// recorded before durations and journals existed.
+func reportRecords() []state.Record {
+	return []state.Record{
+		{
+			ID:        "20260501T120030.000000000Z-0a0b0c0d",
+			Time:      time.Date(2026, 5, 1, 12, 0, 30, 0, time.UTC),
+			Duration:  30 * time.Second,
+			Operator:  "alice",
+			Operation: "apply",
+			File:      "nodes/cp1.yaml",
+			Nodes:     []string{"192.0.2.10", "192.0.2.11"},
+			Changes: []state.NodeChange{
+				{Node: "192.0.2.10", VersionBefore: "v1.13.0", Previewed: true, Updated: 1, Fields: 3},
+				{Node: "192.0.2.11", VersionBefore: "v1.13.0", Previewed: true},
+			},
+		},
+		{
+			ID:        "20260502T090500.000000000Z-01020304",
+			Time:      time.Date(2026, 5, 2, 9, 5, 0, 0, time.UTC),
+			Duration:  5 * time.Minute,
+			Operator:  "bob",
+			Operation: "upgrade",
+			File:      "nodes/cp1.yaml",
+			Image:     "ghcr.io/siderolabs/installer:v1.13.1",
+			Nodes:     []string{"192.0.2.10"},
+			Changes:   []state.NodeChange{{Node: "192.0.2.10", VersionBefore: "v1.13.0", VersionAfter: "v1.13.1"}},
+		},
+		{
+			ID:        "20260503T080000.000000000Z-a1b2c3d4",
+			Time:      time.Date(2026, 5, 3, 8, 0, 0, 0, time.UTC),
+			Operator:  "carol_ops",
+			Operation: "apply",
+			File:      "nodes/w1.yaml",
+			Nodes:     []string{"192.0.2.20"},
+			Error:     "applying new configuration: rpc error | denied\ndetail",
+		},
+	}
+}
+
+func TestReportSelection(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 3, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		id, since, until string
+		want             []string
+	}{
+		{name: "everything", want: []string{"nodes/cp1.yaml", "nodes/cp1.yaml", "nodes/w1.yaml"}},
+		{name: "by id prefix", id: "20260502T", want: []string{"nodes/cp1.yaml"}},
+		{name: "date range covers the until day", since: "2026-05-02", until: "2026-05-02", want: []string{"nodes/cp1.yaml"}},
+		{name: "by start time", since: "2026-05-01T12:00:00Z", until: "2026-05-01T12:00:01Z", want: []string{"nodes/cp1.yaml"}},
+		{name: "relative", since: "36h", want: []string{"nodes/cp1.yaml", "nodes/w1.yaml"}},
+		{name: "nothing", since: "2026-06-01", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			sel, err := parseReportSelection(tt.id, tt.since, tt.until, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, rec := range sel.filter(reportRecords()) {
+				got = append(got, rec.File)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportSelection_Invalid(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	if _, err := parseReportSelection("", "last week", "", now); err == nil || !strings.Contains(err.Error(), "--since") {
+		t.Errorf("unparseable --since: error = %v", err)
+	}
+
+	if _, err := parseReportSelection("", "2026-05-02", "2026-05-01T00:00:00Z", now); err == nil {
+		t.Error("--until before --since must be rejected")
+	}
+}
+
+func TestWriteChangeReport_Markdown(t *testing.T) {
+	t.Parallel()
+
+	report := buildChangeReport(reportRecords(), reportSelection{}, ".talm/state", time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC))
+
+	var out bytes.Buffer
+	if err := writeChangeReport(&out, reportFormatMarkdown, report); err != nil {
+		t.Fatal(err)
+	}
+
+	md := out.String()
+
+	for _, want := range []string{
+		"# Change report",
+		"- **Operations:** 3 (1 failed)",
+		"- **Nodes touched:** 192.0.2.10, 192.0.2.11, 192.0.2.20",
+		"- **Generated:** 2026-05-04T00:00:00Z",
+		"## 1. apply nodes/cp1.yaml",
+		"- **Started:** 2026-05-01T12:00:00Z",
+		"- **Duration:** 30s",
+		"| 192.0.2.10 | v1.13.0 | 1 document updated (3 fields) |",
+		"| 192.0.2.11 | v1.13.0 | no changes |",
+		"## 2. upgrade nodes/cp1.yaml",
+		"- **Image:** ghcr.io/siderolabs/installer:v1.13.1",
+		"| 192.0.2.10 | v1.13.0 → v1.13.1 | not compared |",
+		"- **Operator:** carol\\_ops",
+		"- **Duration:** not recorded",
+		"- **Result:** failed: applying new configuration: rpc error | denied",
+		"| 192.0.2.20 | not recorded | not recorded |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("report missing %q:\n%s", want, md)
+		}
+	}
+
+	if strings.Contains(md, "detail") {
+		t.Errorf("only the first line of an error belongs in the report:\n%s", md)
+	}
+}
+
+func TestWriteChangeReport_HTML(t *testing.T) {
+	t.Parallel()
+
+	records := reportRecords()
+	records[0].Operator = "<script>alice</script>"
+
+	report := buildChangeReport(records, reportSelection{}, ".talm/state", time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC))
+
+	var out bytes.Buffer
+	if err := writeChangeReport(&out, reportFormatHTML, report); err != nil {
+		t.Fatal(err)
+	}
+
+	page := out.String()
+
+	for _, want := range []string{
+		"<h2>2. upgrade nodes/cp1.yaml</h2>",
+		"<tr><td>192.0.2.10</td><td>v1.13.0 → v1.13.1</td><td>not compared</td></tr>",
+		`<span class="failed">failed: applying new configuration: rpc error | denied</span>`,
+		"&lt;script&gt;alice&lt;/script&gt;",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report missing %q:\n%s", want, page)
+		}
+	}
+
+	if strings.Contains(page, "<script>") {
+		t.Error("recorded text must be escaped")
+	}
+}
+
+func TestWriteChangeReport_Empty(t *testing.T) {
+	t.Parallel()
+
+	sel, err := parseReportSelection("", "2026-06-01", "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeChangeReport(&out, reportFormatMarkdown, buildChangeReport(nil, sel, ".talm/state", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "No operations were recorded") || !strings.Contains(out.String(), "since 2026-06-01T00:00:00Z") {
+		t.Errorf("empty report:\n%s", out.String())
+	}
+
+	if err := writeChangeReport(&out, "pdf", changeReport{}); err == nil {
+		t.Error("an unknown format must be rejected")
+	}
+}
+
+func TestReportNodes_DefaultNode(t *testing.T) {
+	t.Parallel()
+
+	nodes := reportNodes(state.Record{Changes: []state.NodeChange{{VersionAfter: "v1.13.1", Previewed: true, Removed: 2}}})
+	if len(nodes) != 1 || nodes[0].Node != "default node" || nodes[0].Version != "→ v1.13.1" || nodes[0].Changes != "2 documents removed" {
+		t.Errorf("nodes = %+v", nodes)
+	}
+}
@@ -15,8 +15,14 @@
 package commands
 
 import (
+	"os"
+	"strings"
+	"time"
+
 	"github.com/cockroachdb/errors"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 const (
@@ -58,14 +64,23 @@ const (
 //     default — operators choosing a narrower scope are doing so
 //     deliberately.
 //
+// A reset policy declared for the targeted nodes in values.yaml
+// (`nodes.<address>.reset`, see resetPolicy) takes the place of all
+// three cases: its flags are applied, and an operator who passes a
+// flag the policy controls is refused unless --ignore-reset-policy is
+// set. The safe default still fills in the wipe scope when the policy
+// leaves it open.
+//
 // Help-text overrides on both flags spell out the divergence so
 // `talm reset --help` carries the operator-facing story.
 //
-// Chain order: capture the wrapTalosCommand-installed PreRunE first,
-// run the flip BEFORE chaining. Order is not load-bearing here
-// (modeline does not touch wipe flags), but matching the shape of
-// the crashdump / rotate-ca wrappers keeps the dispatch site
-// readable.
+// Chain order: the wrapTalosCommand-installed PreRunE runs first. It
+// processes the node file modelines, and the policy is looked up by
+// the nodes they target, so the wipe flags are settled after it.
+// RunE then refuses nodes outside their maintenance windows (see
+// enforceMaintenanceWindows), shows the settled reset and asks for
+// confirmation before handing over to upstream; --yes skips the
+// question.
 func wrapResetCommand(wrappedCmd *cobra.Command) {
 	if wipeFlag := wrappedCmd.Flag("wipe-mode"); wipeFlag != nil {
 		wipeFlag.Usage = "disk reset mode (talm default: --system-labels-to-wipe=" + resetSafeDefaultLabels +
@@ -77,22 +92,111 @@ func wrapResetCommand(wrappedCmd *cobra.Command) {
 			resetSafeDefaultLabels + ")"
 	}
 
+	wrappedCmd.Flags().Bool(ignoreResetPolicyFlag, false, "reset with the flags given on the command line instead of the nodes' reset policy in values.yaml")
+	wrappedCmd.Flags().Bool(ignoreWindowFlag, false, ignoreWindowFlagUsage)
+
 	originalPreRunE := wrappedCmd.PreRunE
 
 	wrappedCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
-		if !cmd.Flags().Changed("wipe-mode") && !cmd.Flags().Changed("system-labels-to-wipe") {
-			if err := cmd.Flags().Set("system-labels-to-wipe", resetSafeDefaultLabels); err != nil {
-				return errors.WithHint(
-					errors.Wrap(err, "applying talm safe-default wipe labels"),
-					"this should not happen at runtime; if it does, fall back to passing --system-labels-to-wipe=STATE,EPHEMERAL explicitly",
-				)
+		if originalPreRunE != nil {
+			if err := originalPreRunE(cmd, args); err != nil {
+				return err
 			}
 		}
 
-		if originalPreRunE != nil {
-			return originalPreRunE(cmd, args)
+		return settleResetWipeFlags(cmd)
+	}
+
+	// The window check and the confirmation run last, once the policy
+	// and the safe default have settled what the reset will do.
+	originalRunE := wrappedCmd.RunE
+
+	wrappedCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		ignoreWindow, _ := cmd.Flags().GetBool(ignoreWindowFlag)
+		if err := enforceMaintenanceWindows(Config.RootDir, GlobalArgs.Nodes, time.Now(), ignoreWindow, os.Stderr); err != nil {
+			return err
+		}
+
+		if err := confirmDestructive(resetConfirmation(cmd)); err != nil {
+			return err
 		}
 
-		return nil
+		return originalRunE(cmd, args)
 	}
 }
+
+// settleResetWipeFlags applies the nodes' reset policy, or the safe
+// default wipe scope when neither the policy nor the operator chose one.
+func settleResetWipeFlags(cmd *cobra.Command) error {
+	policy, ok, err := resolveResetPolicy(Config.RootDir, GlobalArgs.Nodes)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		applied, err := applyResetPolicy(cmd, policy)
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			ui.Infof(os.Stderr, "talm: resetting %s under the reset policy from values.yaml: %s",
+				strings.Join(GlobalArgs.Nodes, ", "), policy.describe())
+
+			return nil
+		}
+	}
+
+	if !cmd.Flags().Changed("wipe-mode") && !cmd.Flags().Changed("system-labels-to-wipe") {
+		if err := cmd.Flags().Set("system-labels-to-wipe", resetSafeDefaultLabels); err != nil {
+			return errors.WithHint(
+				errors.Wrap(err, "applying talm safe-default wipe labels"),
+				"this should not happen at runtime; if it does, fall back to passing --system-labels-to-wipe=STATE,EPHEMERAL explicitly",
+			)
+		}
+	}
+
+	return nil
+}
+
+// resetConfirmation summarises a reset for the confirmation prompt:
+// what gets wiped on which nodes and whether they come back.
+func resetConfirmation(cmd *cobra.Command) confirmation {
+	c := confirmation{action: "Reset Talos nodes"}
+	c.addTarget(GlobalArgs.Nodes)
+
+	// Upstream takes the label-driven path whenever labels are given.
+	if labels := resetFlagValue(cmd, "system-labels-to-wipe"); labels != "" {
+		c.add("Wipe", "partitions "+labels)
+	} else {
+		c.add("Wipe", "wipe mode "+resetFlagValue(cmd, "wipe-mode"))
+	}
+
+	c.add("User disks", resetFlagValue(cmd, "user-disks-to-wipe"))
+
+	if reboot, err := cmd.Flags().GetBool("reboot"); err == nil {
+		afterwards := "power off"
+		if reboot {
+			afterwards = "reboot"
+		}
+
+		c.add("Afterwards", afterwards)
+	}
+
+	return c
+}
+
+// resetFlagValue is the value of an upstream reset flag as the
+// operator would type it, without pflag's slice brackets.
+func resetFlagValue(cmd *cobra.Command, name string) string {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		return ""
+	}
+
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		return strings.Join(slice.GetSlice(), ",")
+	}
+
+	return flag.Value.String()
+}
@@ -0,0 +1,362 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// resetPolicyKey is the key of a values.yaml `nodes` entry that holds
+// the node's reset policy.
+const resetPolicyKey = "reset"
+
+// ignoreResetPolicyFlag lets an operator pass reset flags that differ
+// from the declared policy.
+const ignoreResetPolicyFlag = "ignore-reset-policy"
+
+// resetWipeModes are the upstream `--wipe-mode` values.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var resetWipeModes = []string{"all", "system-disk", "user-disks"}
+
+// resetSystemLabels are the system partition labels a policy may list
+// under systemLabelsToWipe.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var resetSystemLabels = []string{
+	constants.StatePartitionLabel,
+	constants.EphemeralPartitionLabel,
+	constants.MetaPartitionLabel,
+	constants.ImageCachePartitionLabel,
+	constants.BootPartitionLabel,
+	constants.EFIPartitionLabel,
+	constants.BIOSGrubPartitionLabel,
+}
+
+// resetMaintenanceLabels is the wipe scope of a policy that asks for
+// maintenance mode without naming one: talm's safe default plus META,
+// so the node cannot rejoin from its META-stored config.
+const resetMaintenanceLabels = resetSafeDefaultLabels + "," + constants.MetaPartitionLabel
+
+// resetPolicy is the `reset` entry of a values.yaml `nodes` entry: how
+// `talm reset` treats the node, decided when the project is written
+// rather than at the prompt. The wipe and reboot fields map onto the
+// upstream reset flags of the same name; an unset field leaves talm's
+// default for that flag.
+type resetPolicy struct {
+	// WipeMode is --wipe-mode: all, system-disk or user-disks.
+	WipeMode string `yaml:"wipeMode"`
+	// SystemLabelsToWipe is --system-labels-to-wipe. With neither
+	// this nor WipeMode set, talm's META-preserving default applies
+	// (or the maintenance scope, see Maintenance).
+	SystemLabelsToWipe []string `yaml:"systemLabelsToWipe"`
+	// UserDisksToWipe is --user-disks-to-wipe.
+	UserDisksToWipe []string `yaml:"userDisksToWipe"`
+	// Reboot is --reboot; otherwise the node powers off.
+	Reboot *bool `yaml:"reboot"`
+	// Graceful is --graceful: cordon and drain the node and leave
+	// etcd first.
+	Graceful *bool `yaml:"graceful"`
+	// Maintenance states where the node ends up. true: it reboots
+	// into maintenance mode, waiting for a config, which needs META
+	// wiped and a reboot; without a wipe scope, STATE, EPHEMERAL and
+	// META are wiped. false: it must keep META and rejoin the cluster
+	// on its own, so a wipe scope that destroys META is refused.
+	Maintenance *bool `yaml:"maintenance"`
+}
+
+// wipesMeta reports whether the policy's wipe scope destroys META.
+func (p resetPolicy) wipesMeta() bool {
+	return p.WipeMode == "all" || p.WipeMode == "system-disk" || slices.Contains(p.SystemLabelsToWipe, constants.MetaPartitionLabel)
+}
+
+// hasWipeScope reports whether the policy names a wipe scope itself.
+func (p resetPolicy) hasWipeScope() bool {
+	return p.WipeMode != "" || len(p.SystemLabelsToWipe) > 0
+}
+
+// validate rejects a policy talosctl would refuse, or that could not do
+// what it says, before any node is touched.
+func (p resetPolicy) validate() error {
+	if reflect.DeepEqual(p, resetPolicy{}) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("reset policy is empty"),
+			"set wipeMode, systemLabelsToWipe, userDisksToWipe, reboot, graceful or maintenance, or drop the entry",
+		)
+	}
+
+	if p.WipeMode != "" && !slices.Contains(resetWipeModes, p.WipeMode) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown wipeMode %q", p.WipeMode),
+			"use one of "+strings.Join(resetWipeModes, ", "),
+		)
+	}
+
+	for _, label := range p.SystemLabelsToWipe {
+		if !slices.Contains(resetSystemLabels, label) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("unknown system partition label %q", label),
+				"labels are upper case: "+strings.Join(resetSystemLabels, ", "),
+			)
+		}
+	}
+
+	if p.WipeMode != "" && len(p.SystemLabelsToWipe) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("wipeMode and systemLabelsToWipe are both set"),
+			"talosctl reset takes either a wipe mode or a list of labels; keep one of them",
+		)
+	}
+
+	return p.validateMaintenance()
+}
+
+// validateMaintenance checks that the wipe scope and reboot agree
+// with where the policy says the node ends up.
+func (p resetPolicy) validateMaintenance() error {
+	if p.Maintenance == nil {
+		return nil
+	}
+
+	if !*p.Maintenance {
+		if p.wipesMeta() {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("maintenance: false, but the wipe scope destroys META"),
+				"wipeMode all and system-disk wipe META, so the node cannot rejoin on its own; wipe STATE and EPHEMERAL by label instead",
+			)
+		}
+
+		return nil
+	}
+
+	if p.Reboot != nil && !*p.Reboot {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("maintenance: true, but reboot: false"),
+			"a node only reaches maintenance mode by rebooting; drop reboot or set it to true",
+		)
+	}
+
+	if p.hasWipeScope() && !p.wipesMeta() {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("maintenance: true, but the wipe scope keeps META"),
+			"with META kept the node rejoins the cluster from it instead of waiting in maintenance mode; add META to systemLabelsToWipe",
+		)
+	}
+
+	return nil
+}
+
+// describe renders the policy as the reset flags it sets, for the
+// notice printed before a reset.
+func (p resetPolicy) describe() string {
+	parts := make([]string, 0, len(p.flags()))
+
+	for _, flag := range p.flags() {
+		parts = append(parts, "--"+flag[0]+"="+flag[1])
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// flags returns the reset flag values the policy sets, in a stable
+// order, including the wipe scope and reboot that maintenance implies.
+func (p resetPolicy) flags() [][2]string {
+	var out [][2]string
+
+	if p.WipeMode != "" {
+		out = append(out, [2]string{"wipe-mode", p.WipeMode})
+	}
+
+	switch {
+	case len(p.SystemLabelsToWipe) > 0:
+		out = append(out, [2]string{"system-labels-to-wipe", strings.Join(p.SystemLabelsToWipe, ",")})
+	case p.WipeMode != "":
+		// The mode is the scope; a label list would override it.
+	case p.Maintenance != nil && *p.Maintenance:
+		out = append(out, [2]string{"system-labels-to-wipe", resetMaintenanceLabels})
+	default:
+		out = append(out, [2]string{"system-labels-to-wipe", resetSafeDefaultLabels})
+	}
+
+	if len(p.UserDisksToWipe) > 0 {
+		out = append(out, [2]string{"user-disks-to-wipe", strings.Join(p.UserDisksToWipe, ",")})
+	}
+
+	switch {
+	case p.Reboot != nil:
+		out = append(out, [2]string{"reboot", strconv.FormatBool(*p.Reboot)})
+	case p.Maintenance != nil && *p.Maintenance:
+		out = append(out, [2]string{"reboot", "true"})
+	}
+
+	if p.Graceful != nil {
+		out = append(out, [2]string{"graceful", strconv.FormatBool(*p.Graceful)})
+	}
+
+	return out
+}
+
+// resetPolicyFlagNames are the reset flags a policy controls; an
+// operator passing any of them contradicts the policy.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var resetPolicyFlagNames = []string{"wipe-mode", "system-labels-to-wipe", "user-disks-to-wipe", "reboot", "graceful"}
+
+// loadResetPolicies reads the `reset` entries of the values.yaml
+// `nodes` map, keyed by canonical node address. A missing values.yaml
+// or an entry without `reset` declares no policy.
+func loadResetPolicies(rootDir string) (map[string]resetPolicy, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]resetPolicy{}, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	var values struct {
+		Nodes map[string]struct {
+			Reset *resetPolicy `yaml:"reset"`
+		} `yaml:"nodes"`
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(err, "parsing `%s` in %s", valuesNodesKey, valuesPath),
+			"a `%s.<node>.%s` entry holds wipeMode (string), systemLabelsToWipe and userDisksToWipe (string lists), reboot, graceful and maintenance (booleans)", valuesNodesKey, resetPolicyKey,
+		)
+	}
+
+	policies := make(map[string]resetPolicy, len(values.Nodes))
+
+	for node, entry := range values.Nodes {
+		if entry.Reset == nil {
+			continue
+		}
+
+		if err := entry.Reset.validate(); err != nil {
+			return nil, errors.Wrapf(err, "%s: %s.%s.%s", valuesPath, valuesNodesKey, node, resetPolicyKey)
+		}
+
+		policies[canonicalNodeTarget(node)] = *entry.Reset
+	}
+
+	return policies, nil
+}
+
+// resolveResetPolicy returns the reset policy shared by nodes. ok is
+// false when none of the nodes declares one. Reset flags apply to a
+// whole invocation, so nodes with different policies, or a mix of
+// nodes with and without one, are refused rather than reset under a
+// policy some of them did not declare.
+func resolveResetPolicy(rootDir string, nodes []string) (resetPolicy, bool, error) {
+	if len(nodes) == 0 {
+		return resetPolicy{}, false, nil
+	}
+
+	policies, err := loadResetPolicies(rootDir)
+	if err != nil {
+		return resetPolicy{}, false, err
+	}
+
+	var (
+		policy   resetPolicy
+		with     []string
+		without  []string
+		conflict bool
+	)
+
+	for _, node := range nodes {
+		p, ok := policies[canonicalNodeTarget(node)]
+		if !ok {
+			without = append(without, node)
+
+			continue
+		}
+
+		if len(with) > 0 && !reflect.DeepEqual(p, policy) {
+			conflict = true
+		}
+
+		policy = p
+		with = append(with, node)
+	}
+
+	switch {
+	case len(with) == 0:
+		return resetPolicy{}, false, nil
+	case conflict || len(without) > 0:
+		sort.Strings(with)
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return resetPolicy{}, false, errors.WithHint(
+			errors.Newf("the targeted nodes do not share one reset policy (declared for %s)", strings.Join(with, ", ")),
+			"reset the nodes one at a time (talm reset -f nodes/<node>.yaml), or declare the same nodes.<node>.reset entry for all of them in values.yaml",
+		)
+	}
+
+	return policy, true, nil
+}
+
+// applyResetPolicy sets the policy's flags on the reset command. An
+// operator who also passed one of those flags is refused unless
+// --ignore-reset-policy is set, in which case the policy is skipped
+// and the CLI flags stand. The bool result reports whether the policy
+// was applied.
+func applyResetPolicy(cmd *cobra.Command, policy resetPolicy) (bool, error) {
+	if ignore, _ := cmd.Flags().GetBool(ignoreResetPolicyFlag); ignore {
+		return false, nil
+	}
+
+	for _, name := range resetPolicyFlagNames {
+		if cmd.Flags().Changed(name) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return false, errors.WithHintf(
+				errors.Newf("--%s contradicts the reset policy declared in values.yaml (%s)", name, policy.describe()),
+				"drop --%s to reset as declared, or pass --%s to override the policy for this run", name, ignoreResetPolicyFlag,
+			)
+		}
+	}
+
+	for _, flag := range policy.flags() {
+		if err := cmd.Flags().Set(flag[0], flag[1]); err != nil {
+			return false, errors.Wrapf(err, "applying the reset policy: --%s=%s", flag[0], flag[1])
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,256 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+)
+
+const resetPolicyValues = `nodes:
+  192.0.2.10:
+    labels:
+      role: storage
+    reset:
+      maintenance: true
+  192.0.2.11:
+    reset:
+      maintenance: true
+  2001:db8::1:
+    reset:
+      wipeMode: all
+      graceful: false
+  192.0.2.20:
+    labels:
+      role: worker
+`
+
+func TestResetPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	yes, no := true, false
+
+	for _, tc := range []struct {
+		name   string
+		policy resetPolicy
+		want   string
+	}{
+		{"labels", resetPolicy{SystemLabelsToWipe: []string{"STATE", "EPHEMERAL"}}, ""},
+		{"mode and disks", resetPolicy{WipeMode: "user-disks", UserDisksToWipe: []string{"/dev/sdb"}}, ""},
+		{"maintenance only", resetPolicy{Maintenance: &yes}, ""},
+		{"unknown mode", resetPolicy{WipeMode: "everything"}, "unknown wipeMode"},
+		{"unknown label", resetPolicy{SystemLabelsToWipe: []string{"state"}}, "unknown system partition label"},
+		{"mode and labels", resetPolicy{WipeMode: "all", SystemLabelsToWipe: []string{"STATE"}}, "both set"},
+		{"empty", resetPolicy{}, "empty"},
+		{"maintenance wiping META", resetPolicy{SystemLabelsToWipe: []string{"STATE", "META"}, Maintenance: &yes}, ""},
+		{"maintenance keeping META", resetPolicy{SystemLabelsToWipe: []string{"STATE"}, Maintenance: &yes}, "keeps META"},
+		{"maintenance without reboot", resetPolicy{Maintenance: &yes, Reboot: &no}, "reboot: false"},
+		{"rejoin wiping META", resetPolicy{WipeMode: "all", Maintenance: &no}, "destroys META"},
+		{"rejoin by label", resetPolicy{SystemLabelsToWipe: []string{"EPHEMERAL"}, Maintenance: &no}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.policy.validate()
+
+			switch {
+			case tc.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Errorf("error = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadResetPolicies(t *testing.T) {
+	t.Parallel()
+
+	root := writePruneProject(t, map[string]string{valuesYamlName: resetPolicyValues})
+
+	policies, err := loadResetPolicies(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policies) != 3 {
+		t.Fatalf("policies = %+v, want the three nodes with a reset entry", policies)
+	}
+
+	if p := policies["2001:db8::1"]; p.WipeMode != "all" || p.Graceful == nil || *p.Graceful {
+		t.Errorf("IPv6 policy = %+v", p)
+	}
+
+	root = writePruneProject(t, map[string]string{valuesYamlName: "nodes:\n  192.0.2.10:\n    reset:\n      wipeMode: nuke\n"})
+
+	if _, err := loadResetPolicies(root); err == nil || !strings.Contains(err.Error(), "nodes.192.0.2.10.reset") {
+		t.Errorf("invalid policy error = %v", err)
+	}
+
+	if policies, err := loadResetPolicies(t.TempDir()); err != nil || len(policies) != 0 {
+		t.Errorf("a project without values.yaml declares nothing, got %+v, %v", policies, err)
+	}
+}
+
+func TestResolveResetPolicy(t *testing.T) {
+	t.Parallel()
+
+	root := writePruneProject(t, map[string]string{valuesYamlName: resetPolicyValues})
+
+	policy, ok, err := resolveResetPolicy(root, []string{"192.0.2.10", "192.0.2.11"})
+	if err != nil || !ok {
+		t.Fatalf("shared policy: ok=%v err=%v", ok, err)
+	}
+
+	if policy.Maintenance == nil || !*policy.Maintenance {
+		t.Errorf("policy = %+v", policy)
+	}
+
+	if _, ok, err := resolveResetPolicy(root, []string{"2001:0db8::1"}); err != nil || !ok {
+		t.Errorf("an IPv6 spelling must find the policy: ok=%v err=%v", ok, err)
+	}
+
+	if _, ok, err := resolveResetPolicy(root, []string{"192.0.2.20"}); err != nil || ok {
+		t.Errorf("a node without a policy: ok=%v err=%v", ok, err)
+	}
+
+	for _, nodes := range [][]string{
+		{"192.0.2.10", "2001:db8::1"},
+		{"192.0.2.10", "192.0.2.20"},
+	} {
+		_, _, err := resolveResetPolicy(root, nodes)
+		if err == nil || !strings.Contains(strings.Join(errors.GetAllHints(err), "\n"), "one at a time") {
+			t.Errorf("nodes %v: error = %v", nodes, err)
+		}
+	}
+}
+
+// newPolicyResetCmd builds a synthetic reset command with every flag
+// a policy can set, wrapped by wrapResetCommand, and points the
+// package state at a project declaring resetPolicyValues.
+func newPolicyResetCmd(t *testing.T, nodes ...string) *cobra.Command {
+	t.Helper()
+
+	origRoot, origNodes := Config.RootDir, GlobalArgs.Nodes
+	t.Cleanup(func() { Config.RootDir, GlobalArgs.Nodes = origRoot, origNodes })
+
+	Config.RootDir = writePruneProject(t, map[string]string{valuesYamlName: resetPolicyValues})
+	GlobalArgs.Nodes = nodes
+
+	var (
+		wipeMode  string
+		labels    []string
+		userDisks []string
+		reboot    bool
+		graceful  bool
+	)
+
+	cmd := &cobra.Command{Use: resetCmdName}
+	registerResetFlagsForTest(cmd, &wipeMode, &labels)
+	cmd.Flags().StringSliceVar(&userDisks, "user-disks-to-wipe", nil, "")
+	cmd.Flags().BoolVar(&reboot, "reboot", false, "")
+	cmd.Flags().BoolVar(&graceful, "graceful", true, "")
+
+	wrapResetCommand(cmd)
+
+	return cmd
+}
+
+// TestWrapResetCommand_PolicyApplied pins that a declared policy sets
+// its flags: maintenance implies a reboot and a scope that wipes META,
+// and a policy choosing a wipe mode gets no label list on top.
+func TestWrapResetCommand_PolicyApplied(t *testing.T) {
+	cmd := newPolicyResetCmd(t, "192.0.2.10")
+
+	if err := cmd.PreRunE(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, _ := cmd.Flags().GetStringSlice("system-labels-to-wipe")
+	reboot, _ := cmd.Flags().GetBool("reboot")
+
+	if !reflect.DeepEqual(labels, []string{"STATE", "EPHEMERAL", "META"}) || !reboot {
+		t.Errorf("labels = %v, reboot = %v; want STATE,EPHEMERAL,META and true for maintenance", labels, reboot)
+	}
+
+	cmd = newPolicyResetCmd(t, "2001:db8::1")
+
+	if err := cmd.PreRunE(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mode, _ := cmd.Flags().GetString("wipe-mode")
+	graceful, _ := cmd.Flags().GetBool("graceful")
+
+	if !cmd.Flags().Changed("wipe-mode") || mode != "all" || graceful {
+		t.Errorf("wipe-mode = %q, graceful = %v; want the policy's all and false", mode, graceful)
+	}
+
+	if cmd.Flags().Changed("system-labels-to-wipe") {
+		t.Error("a policy choosing a wipe mode must not get the label default, which would override the mode")
+	}
+}
+
+// TestWrapResetCommand_PolicyConflict pins that a CLI flag the policy
+// controls is refused, and that --ignore-reset-policy lets the CLI
+// flags stand.
+func TestWrapResetCommand_PolicyConflict(t *testing.T) {
+	cmd := newPolicyResetCmd(t, "192.0.2.10")
+
+	if err := cmd.Flags().Set("wipe-mode", "all"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cmd.PreRunE(cmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "--wipe-mode contradicts the reset policy") {
+		t.Fatalf("error = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "--"+ignoreResetPolicyFlag) {
+		t.Errorf("hint = %q", hints)
+	}
+
+	if err := cmd.Flags().Set(ignoreResetPolicyFlag, "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.PreRunE(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reboot, _ := cmd.Flags().GetBool("reboot")
+	if cmd.Flags().Changed("system-labels-to-wipe") || reboot {
+		t.Error("--ignore-reset-policy must leave the operator's flags alone")
+	}
+}
+
+// TestWrapResetCommand_NoPolicy_SafeDefault pins that a node without a
+// policy keeps the existing safe default.
+func TestWrapResetCommand_NoPolicy_SafeDefault(t *testing.T) {
+	cmd := newPolicyResetCmd(t, "192.0.2.20")
+
+	if err := cmd.PreRunE(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, _ := cmd.Flags().GetStringSlice("system-labels-to-wipe")
+	if strings.Join(labels, ",") != resetSafeDefaultLabels {
+		t.Errorf("labels = %v, want the safe default", labels)
+	}
+}
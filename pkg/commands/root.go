@@ -18,16 +18,22 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/engine"
 	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/state"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"gopkg.in/yaml.v3"
 
 	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
 	"github.com/siderolabs/talos/pkg/machinery/client"
@@ -52,7 +58,7 @@ var SkipVerify bool
 // talosconfig.
 var errContextNotFound = errors.New("context not found in talosconfig")
 
-// signalContext returns a context cancelled on SIGINT/SIGTERM so a --skip-verify
+// signalContext returns a context cancelled on SIGINT/SIGTERM so a Talos
 // client connection can be interrupted cleanly, mirroring talosctl's own wrappers.
 func signalContext() (context.Context, context.CancelFunc) {
 	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -99,7 +105,8 @@ func skipVerifyTLSConfig(configContext *clientconfig.Context) (*tls.Config, erro
 //nolint:gochecknoglobals // cobra CLI architecture: persistent flags bind to package-level config; mirrors GlobalArgs and is read by every subcommand for project-root-relative path resolution.
 var Config struct {
 	RootDir         string
-	RootDirExplicit bool // true if --root was explicitly set
+	RootDirExplicit bool   // true if --root or TALM_ROOT was explicitly set
+	RootDirOrigin   string // what set RootDir explicitly, --root or TALM_ROOT; empty means --root
 	// StrictCharts turns vendored-chart drift into a hard error instead of a
 	// warning. Opt-in per project via Chart.yaml (strictCharts: true) so a
 	// whole team/CI inherits it; absent means a warning only (the historical
@@ -108,6 +115,33 @@ var Config struct {
 	GlobalOptions struct {
 		Talosconfig string `yaml:"talosconfig"`
 		Kubeconfig  string `yaml:"kubeconfig"`
+		// Key, Secrets and ValuesSecret move the age key, the Talos
+		// secrets bundle and the secret chart values away from their
+		// default names (talm.key, secrets.yaml, values-secret.yaml),
+		// relative to the project root. Encrypted files follow the
+		// plain name: secrets/cluster.yaml -> secrets/cluster.encrypted.yaml.
+		Key          string `yaml:"key"`
+		Secrets      string `yaml:"secrets"`
+		ValuesSecret string `yaml:"valuesSecret"`
+		// Recipients are public keys every secret value is encrypted
+		// to besides the project key: age1... keys, ssh-ed25519
+		// authorized_keys lines, or github:<login> for the user's
+		// ssh-ed25519 keys, pinned in .talm-recipients.lock.
+		Recipients []string `yaml:"recipients"`
+		// ExternalTalosconfig reads operator credentials from a
+		// talosconfig kept outside the project, such as one shared by
+		// every project of a management cluster. Path is relative to
+		// the project root unless absolute or `~/`; Context selects a
+		// context in it. talm never writes that file.
+		ExternalTalosconfig struct {
+			Path    string `yaml:"path"`
+			Context string `yaml:"context"`
+		} `yaml:"externalTalosconfig"`
+		// CABundle is a PEM file of certificates every outbound HTTP
+		// fetch trusts besides the system roots, for networks that
+		// intercept TLS with a corporate CA. Resolved like
+		// ExternalTalosconfig.Path.
+		CABundle string `yaml:"caBundle"`
 	} `yaml:"globalOptions"`
 	TemplateOptions struct {
 		Offline           bool     `yaml:"offline"`
@@ -122,17 +156,75 @@ var Config struct {
 		KubernetesVersion string   `yaml:"kubernetesVersion"`
 		Full              bool     `yaml:"full"`
 		Debug             bool     `yaml:"debug"`
+		// AllowEnv names the environment variables chart templates may
+		// read through `env`. Empty means the function rejects every name.
+		AllowEnv []string `yaml:"allowEnv"`
+		// MergeRules choose, per values path, whether a later values
+		// layer replaces, appends to or merges by key into a list.
+		MergeRules []engine.MergeRule `yaml:"mergeRules"`
+		// StrictDeprecations fails renders that set a value the chart
+		// schema marks deprecated, instead of warning.
+		StrictDeprecations bool `yaml:"strictDeprecations"`
+		// SecretStore is where the chart `secretRef` function reads
+		// secrets from: an age or sops encrypted file, or Vault.
+		SecretStore engine.SecretStore `yaml:"secretStore"`
+		// PrewarmLookups are the lookup kinds, such as links,
+		// addresses and disks, an online render fetches from the node
+		// together before the templates run.
+		PrewarmLookups []string `yaml:"prewarmLookups"`
+		// Roles maps a role, controlplane or worker, to the templates
+		// that render its nodes. One template can serve both roles and
+		// branch on .MachineType instead of being kept in two copies.
+		Roles map[string][]string `yaml:"roles"`
 	} `yaml:"templateOptions"`
 	ApplyOptions struct {
-		DryRun           bool   `yaml:"preserve"`
+		DryRun bool `yaml:"preserve"`
+		// Timeout is the total per-node deadline of `talm apply`:
+		// render, preflight, ApplyConfiguration and post-apply
+		// verification of one node share it. "0" disables it.
 		Timeout          string `yaml:"timeout"`
 		TimeoutDuration  time.Duration
 		CertFingerprints []string `yaml:"certFingerprints"`
+		// PhaseTimeouts caps individual apply phases inside the
+		// per-node deadline. Empty entries leave a phase bounded by
+		// the per-node deadline only.
+		PhaseTimeouts struct {
+			Render            string `yaml:"render"`
+			Preflight         string `yaml:"preflight"`
+			Apply             string `yaml:"apply"`
+			Verify            string `yaml:"verify"`
+			RenderDuration    time.Duration
+			PreflightDuration time.Duration
+			ApplyDuration     time.Duration
+			VerifyDuration    time.Duration
+		} `yaml:"phaseTimeouts"`
+		// SyncNodeMetadata turns on the post-apply Kubernetes Node
+		// label/annotation sync from values.yaml `nodes`.
+		SyncNodeMetadata bool `yaml:"syncNodeMetadata"`
+		// Drain turns on cordoning and draining the worker nodes
+		// that `talm apply --mode=reboot` reboots.
+		Drain bool `yaml:"drain"`
+		// ServerSideValidate turns on the dry-run apply that lets
+		// each node validate the rendered config before the apply.
+		ServerSideValidate bool `yaml:"serverSideValidate"`
+		// RebootTimeout is how long `talm apply` waits for a node
+		// that rebooted into the new config to come back running and
+		// ready; a node that does not gets its logs captured under
+		// .talm/failures. Empty or "0" does not wait.
+		RebootTimeout         string `yaml:"rebootTimeout"`
+		RebootTimeoutDuration time.Duration
 	} `yaml:"applyOptions"`
+	// State configures where apply history and locks are kept; see
+	// package state. Empty keeps them under .talm/state.
+	State          state.Config `yaml:"state"`
 	UpgradeOptions struct {
 		Preserve bool `yaml:"preserve"`
 		Stage    bool `yaml:"stage"`
 		Force    bool `yaml:"force"`
+		// Drain is the default of upstream's --drain, which cordons
+		// and drains each node before the upgrade reboots it. Unset
+		// keeps talosctl's default, on.
+		Drain *bool `yaml:"drain"`
 	} `yaml:"upgradeOptions"`
 	InitOptions struct {
 		Version string
@@ -143,19 +235,14 @@ var Config struct {
 //
 // WithClientNoNodes doesn't set any node information on the request context.
 //
-// This is the single choke point every talm-native command funnels through
-// (directly or via WithClient), so routing --skip-verify here restores the
-// coverage the dropped cozystack/talos fork used to provide at the library
-// level for the whole CLI. Wrapped talosctl passthrough commands run upstream
-// RunE code that never reaches this function, so they are handled separately
-// (and cannot honor --skip-verify without the fork — see talosctl_wrapper.go).
+// This is the choke point every talm-native command funnels through
+// (directly or via WithClient). It builds the client with withTalosClient,
+// so --skip-verify and the other global connection flags apply to every
+// such command. Wrapped talosctl passthrough commands run upstream RunE code
+// that never reaches this function, so they are handled separately (and
+// cannot honor --skip-verify without the fork — see talosctl_wrapper.go).
 func WithClientNoNodes(action func(context.Context, *client.Client) error, dialOptions ...grpc.DialOption) error {
-	if SkipVerify {
-		return WithClientSkipVerify(action, dialOptions...)
-	}
-
-	//nolint:wrapcheck // thin pass-through to talos global.Args; error already carries Talos context
-	return GlobalArgs.WithClientNoNodes(action, dialOptions...)
+	return withTalosClient(talosClientRequest{skipVerify: SkipVerify, dialOptions: dialOptions}, action)
 }
 
 // WithClient builds upon WithClientNoNodes to provide set of nodes on request context based on config & flags.
@@ -183,100 +270,48 @@ func WithClient(action func(context.Context, *client.Client) error, dialOptions
 }
 
 // WithClientMaintenance wraps common code to initialize Talos client in maintenance (insecure mode).
+//
+// The client connects to GlobalArgs.Nodes directly. Unlike upstream
+// global.Args.WithClientMaintenance it honors --cluster, so maintenance
+// nodes behind an Omni/Sidero proxy are reachable too.
 func WithClientMaintenance(enforceFingerprints []string, action func(context.Context, *client.Client) error) error {
-	//nolint:wrapcheck // thin pass-through to talos global.Args; error already carries Talos context
-	return GlobalArgs.WithClientMaintenance(enforceFingerprints, action)
-}
-
-// skipVerifyClientOptions assembles the client options for a --skip-verify
-// connection. It mirrors upstream global.Args.WithClientNoNodes so the skip
-// path does not silently lose behavior the normal path has: it pins the
-// already-resolved config context (so client.GetConfigContext honors
-// --talosconfig / --context instead of falling back to the default config),
-// forwards caller dial options, threads the --cluster proxy override when set,
-// and selects endpoints from flags or the talosconfig context — all carrying
-// the verification-skipping TLS config built from that context.
-func skipVerifyClientOptions(configContext *clientconfig.Context, tlsConfig *tls.Config, dialOptions []grpc.DialOption) []client.OptionFunc {
-	opts := []client.OptionFunc{
-		client.WithConfigContext(configContext),
-		client.WithTLSConfig(tlsConfig),
-		client.WithDefaultGRPCDialOptions(),
-		// Kept for structural parity with upstream WithClientNoNodes. It is
-		// inert on the skip-verify path — getConn short-circuits on the explicit
-		// TLS config before the SideroV1 interceptor — so it never actually
-		// consumes the keys dir here; skip-verify + Omni SaaS-key auth is not a
-		// real combination.
-		client.WithSideroV1KeysDir(clientconfig.CustomSideroV1KeysDirPath(GlobalArgs.SideroV1KeysDir)),
-	}
-
-	if len(dialOptions) > 0 {
-		opts = append(opts, client.WithGRPCDialOptions(dialOptions...))
-	}
-
-	// Preserve the --cluster proxy header the non-skip path sets, so
-	// `--skip-verify --cluster X` still reaches nodes behind an Omni/Sidero proxy.
-	if GlobalArgs.Cluster != "" {
-		opts = append(opts, client.WithCluster(GlobalArgs.Cluster))
-	}
-
-	if len(GlobalArgs.Endpoints) > 0 {
-		opts = append(opts, client.WithEndpoints(GlobalArgs.Endpoints...))
-	} else if len(configContext.Endpoints) > 0 {
-		opts = append(opts, client.WithEndpoints(configContext.Endpoints...))
-	}
-
-	return opts
+	return withTalosClient(talosClientRequest{maintenance: true, fingerprints: enforceFingerprints, skipVerify: SkipVerify}, action)
 }
 
 // WithClientSkipVerify wraps common code to initialize Talos client with TLS verification disabled
 // but with client certificate authentication preserved.
 // This is useful when connecting to nodes via IP addresses not listed in the server certificate's SANs.
+//
+// Deliberately no client.WithNodes here: this is the skip-verify backing
+// for the no-nodes constructors (WithClientNoNodes, withApplyClientBare),
+// mirroring upstream where WithClientNoNodes never sets node metadata.
+// Callers that want nodes (WithClient, the per-node apply loop) inject
+// them in their own wrapper layer. Injecting here would attach a plural
+// `nodes` key that apid's director rejects for COSI reads (e.g. rotate-ca).
 func WithClientSkipVerify(action func(context.Context, *client.Client) error, dialOptions ...grpc.DialOption) error {
-	ctx, stop := signalContext()
-	defer stop()
-
-	cfg, err := clientconfig.Open(GlobalArgs.Talosconfig)
-	if err != nil {
-		return errors.Wrapf(err, "opening talosconfig %q", GlobalArgs.Talosconfig)
-	}
-
-	contextName := GlobalArgs.CmdContext
-	if contextName == "" {
-		contextName = cfg.Context
-	}
-
-	configContext, ok := cfg.Contexts[contextName]
-	if !ok {
-		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
-		return errors.WithHint(
-			errors.Wrapf(errContextNotFound, "%q", contextName),
-			"verify the context name against `talosctl config contexts`",
-		)
-	}
-
-	tlsConfig, err := skipVerifyTLSConfig(configContext)
-	if err != nil {
-		return err
-	}
+	return withTalosClient(talosClientRequest{skipVerify: true, dialOptions: dialOptions}, action)
+}
 
-	c, err := client.New(ctx, skipVerifyClientOptions(configContext, tlsConfig, dialOptions)...)
-	if err != nil {
-		return errors.Wrap(err, "constructing Talos client")
-	}
-	defer func() { _ = c.Close() }()
-
-	// Deliberately no client.WithNodes here: this is the skip-verify backing
-	// for the no-nodes constructors (WithClientNoNodes, withApplyClientBare),
-	// mirroring upstream where WithClientNoNodes never sets node metadata.
-	// Callers that want nodes (WithClient, the per-node apply loop) inject
-	// them in their own wrapper layer. Injecting here would attach a plural
-	// `nodes` key that apid's director rejects for COSI reads (e.g. rotate-ca).
-	return action(ctx, c)
+// ResetSession drops what one invocation leaves behind besides its
+// flags and Config: the prompt answers, bound to that invocation's
+// stdin, and a journal or warnings collector a panicking command did
+// not get to clear. A process running several commands calls it in
+// between.
+func ResetSession() {
+	sessionValuePrompter = nil
+
+	activeJournal.mu.Lock()
+	activeJournal.journal = nil
+	activeJournal.mu.Unlock()
+
+	activeApplyWarnings.mu.Lock()
+	activeApplyWarnings.warnings = nil
+	activeApplyWarnings.mu.Unlock()
 }
 
 // Commands is a list of commands published by the package.
 //
-//nolint:gochecknoglobals // command registry: each subcommand's init() registers itself via addCommand(); main.go iterates the slice to attach all commands to the root cobra command.
+//nolint:gochecknoglobals // command registry: each subcommand's init() registers itself via addCommand(); pkg/cli iterates the slice to attach all commands to the root cobra command.
 var Commands []*cobra.Command
 
 func addCommand(cmd *cobra.Command) {
@@ -284,7 +319,8 @@ func addCommand(cmd *cobra.Command) {
 }
 
 // DetectProjectRoot automatically detects the project root directory by looking
-// for Chart.yaml and secrets.yaml (or secrets.encrypted.yaml) files in the current directory and parent directories.
+// for Chart.yaml and secrets.yaml (or secrets.encrypted.yaml, or the secrets file
+// Chart.yaml configures) in the current directory and parent directories.
 // Returns the absolute path to the project root, or empty string if not found.
 func DetectProjectRoot(startDir string) (string, error) {
 	absStartDir, err := filepath.Abs(startDir)
@@ -295,25 +331,8 @@ func DetectProjectRoot(startDir string) (string, error) {
 	currentDir := absStartDir
 	for {
 		chartYaml := filepath.Join(currentDir, chartYamlName)
-		secretsYaml := filepath.Join(currentDir, "secrets.yaml")
-		secretsEncryptedYaml := filepath.Join(currentDir, "secrets.encrypted.yaml")
-
-		chartExists := false
-		secretsExists := false
-
-		if _, err := os.Stat(chartYaml); err == nil {
-			chartExists = true
-		}
-
-		if _, err := os.Stat(secretsYaml); err == nil {
-			secretsExists = true
-		}
-
-		if _, err := os.Stat(secretsEncryptedYaml); err == nil {
-			secretsExists = true
-		}
 
-		if chartExists && secretsExists {
+		if _, err := os.Stat(chartYaml); err == nil && projectHasSecrets(currentDir) {
 			return currentDir, nil
 		}
 
@@ -329,18 +348,145 @@ func DetectProjectRoot(startDir string) (string, error) {
 	return "", nil
 }
 
+// projectHasSecrets reports whether the secrets bundle, plain or
+// encrypted, exists in dir under the name its Chart.yaml configures or
+// under the default name, so a project is still found halfway through
+// moving its secrets.
+func projectHasSecrets(dir string) bool {
+	var defaults age.Layout
+
+	layout := projectSecretsLayout(dir)
+
+	for _, name := range []string{
+		layout.SecretsFile(), layout.EncryptedSecretsFile(),
+		defaults.SecretsFile(), defaults.EncryptedSecretsFile(),
+	} {
+		if fileExists(filepath.Join(dir, filepath.FromSlash(name))) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // DetectProjectRootForFile detects the project root for a given file path.
 // It finds the directory containing the file, then searches up for Chart.yaml and secrets.yaml.
+//
+// The nearest Chart.yaml above the file is preferred: when it has no
+// secrets bundle but a project sits further up, the file's project is
+// ambiguous and an *ambiguousRootError lists the candidates, rather
+// than the outer project being picked silently.
 func DetectProjectRootForFile(filePath string) (string, error) {
 	absFilePath, err := filepath.Abs(filePath)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get absolute path")
 	}
 
-	// Get directory containing the file
-	fileDir := filepath.Dir(absFilePath)
+	candidates := chartRootCandidates(filepath.Dir(absFilePath))
+
+	for i, candidate := range candidates {
+		if !candidate.project {
+			continue
+		}
+
+		if i > 0 {
+			return "", newAmbiguousRootError(absFilePath, candidates[:i+1])
+		}
+
+		return candidate.dir, nil
+	}
+
+	return "", nil
+}
+
+// rootCandidate is a directory above a file that holds a Chart.yaml.
+type rootCandidate struct {
+	dir string
+	// project is set when the secrets bundle sits next to Chart.yaml,
+	// which is what makes the directory a talm project.
+	project bool
+}
+
+// chartRootCandidates lists the directories from startDir up to the
+// filesystem root that hold an application Chart.yaml, nearest first.
+// Library charts, such as the talm library vendored under charts/,
+// are never a project and are skipped.
+func chartRootCandidates(startDir string) []rootCandidate {
+	var candidates []rootCandidate
+
+	currentDir := startDir
+	for {
+		if data, err := os.ReadFile(filepath.Join(currentDir, chartYamlName)); err == nil && !isLibraryChart(data) {
+			candidates = append(candidates, rootCandidate{dir: currentDir, project: projectHasSecrets(currentDir)})
+		}
+
+		parentDir := filepath.Dir(currentDir)
+		if parentDir == currentDir {
+			return candidates
+		}
+
+		currentDir = parentDir
+	}
+}
+
+// isLibraryChart reports whether a Chart.yaml declares `type: library`.
+// An unreadable Chart.yaml counts as an application chart.
+func isLibraryChart(data []byte) bool {
+	var chart struct {
+		Type string `yaml:"type"`
+	}
+
+	return yaml.Unmarshal(data, &chart) == nil && chart.Type == "library"
+}
+
+// ambiguousRootError is returned when the nearest Chart.yaml above a
+// file is not a talm project but one further up is.
+type ambiguousRootError struct {
+	file       string
+	candidates []rootCandidate
+}
+
+func newAmbiguousRootError(file string, candidates []rootCandidate) error {
+	var lines []string
+
+	for _, candidate := range candidates {
+		kind := "Chart.yaml without a secrets bundle"
+		if candidate.project {
+			kind = "talm project"
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s (%s)", candidate.dir, kind))
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		&ambiguousRootError{file: file, candidates: candidates},
+		"pass --root or set %s to the project the file belongs to, one of:\n%s\nor run `talm init` in %s to make it a project of its own",
+		rootEnvVar, strings.Join(lines, "\n"), candidates[0].dir,
+	)
+}
+
+func (e *ambiguousRootError) Error() string {
+	return fmt.Sprintf("ambiguous project root for %s: the nearest Chart.yaml, in %s, has no secrets bundle, but %s further up is a talm project",
+		e.file, e.candidates[0].dir, e.candidates[len(e.candidates)-1].dir)
+}
+
+// resolvesTo reports whether root, the --root or TALM_ROOT the
+// operator chose, is one of the candidates, which settles the
+// ambiguity.
+func (e *ambiguousRootError) resolvesTo(root string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+
+	for _, candidate := range e.candidates {
+		if candidate.dir == absRoot {
+			return true
+		}
+	}
 
-	return DetectProjectRoot(fileDir)
+	return false
 }
 
 // ValidateAndDetectRootsForFiles resolves the project root for a
@@ -21,19 +21,25 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/age"
 )
 
-// localSecretsYamlName is the on-disk name of the plaintext secrets file
-// used by ResolveSecretsPath when the operator did not pass --with-secrets.
-// Defined as a file-local const so the goconst linter has a single
-// canonical reference for the seven occurrences in this file alone.
-const localSecretsYamlName = "secrets.yaml"
+// localSecretsYamlName is the default on-disk name of the plaintext
+// secrets file, which ResolveSecretsPath falls back to when neither
+// --with-secrets nor globalOptions.secrets names another.
+const localSecretsYamlName = age.DefaultSecretsFile
 
 // talosconfigFlagName is the persistent --talosconfig flag name and the
 // default basename used by EnsureTalosconfigPath when neither the flag
 // nor Chart.yaml's globalOptions.talosconfig declares a value.
 const talosconfigFlagName = "talosconfig"
 
+// rootEnvVar names the environment variable that pins the project root
+// like --root does, for shells and CI jobs that work on one project
+// from elsewhere. --root wins over it.
+const rootEnvVar = "TALM_ROOT"
+
 // parseFlagFromArgs parses a flag value from command line arguments.
 // Supports both -flag value and -flag=value formats, as well as comma-separated values.
 func parseFlagFromArgs(args []string, shortFlag, longFlag string) []string {
@@ -141,9 +147,9 @@ func checkRootConflict(detectedRoot string, rootDirExplicit bool) error {
 	absDetectedRoot, _ := filepath.Abs(detectedRoot)
 	if absConfigRoot != absDetectedRoot {
 		//nolint:wrapcheck // cockroachdb/errors.WithHint multi-return; ignore-sigs cover single-return only.
-		return errors.WithHint(
-			errors.Newf("conflicting project roots: global --root=%s, but detected root=%s", absConfigRoot, absDetectedRoot),
-			"drop --root or move the files so they live under the explicit root",
+		return errors.WithHintf(
+			errors.Newf("conflicting project roots: global %s=%s, but detected root=%s", explicitRootOrigin(), absConfigRoot, absDetectedRoot),
+			"drop %s or move the files so they live under the explicit root", explicitRootOrigin(),
 		)
 	}
 
@@ -151,13 +157,15 @@ func checkRootConflict(detectedRoot string, rootDirExplicit bool) error {
 }
 
 // DetectAndSetRoot detects and sets the project root using fallback strategy:
+// 0. --root, or TALM_ROOT, pins the root; files must then live under it
 // 1. From -f/--file flag (if files specified)
 // 2. From -t/--template flag (if templates specified)
 // 3. From current working directory
 //
 // args is part of the cobra.PositionalArgs / PreRunE signature; the
 // function does not consult positional arguments — root selection is
-// driven entirely by --root, --file, --template, and the CWD walk-up.
+// driven entirely by --root, TALM_ROOT, --file, --template, and the
+// CWD walk-up.
 func DetectAndSetRoot(cmd *cobra.Command, _ []string) error {
 	// Check if --root was explicitly set. Use cmd.Flag(name).Changed
 	// rather than cmd.PersistentFlags().Changed("root"):
@@ -171,10 +179,14 @@ func DetectAndSetRoot(cmd *cobra.Command, _ []string) error {
 	// (local -> persistent -> parent persistent) and returns the
 	// merged flag definition with its real Changed state.
 	Config.RootDirExplicit = false
+	Config.RootDirOrigin = ""
+
 	if flag := cmd.Flag("root"); flag != nil {
 		Config.RootDirExplicit = flag.Changed
 	}
 
+	applyRootFromEnv()
+
 	configFiles := lookupFileArg(cmd, "file", "-f", "--file")
 	templateFiles := lookupFileArg(cmd, "template", "-t", "--template")
 
@@ -204,6 +216,41 @@ func DetectAndSetRoot(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 
+// applyRootFromEnv pins Config.RootDir to TALM_ROOT when --root was
+// not passed, so the root counts as explicit from then on.
+func applyRootFromEnv() {
+	if Config.RootDirExplicit {
+		return
+	}
+
+	root := os.Getenv(rootEnvVar)
+	if root == "" {
+		return
+	}
+
+	Config.RootDir = root
+	Config.RootDirExplicit = true
+	Config.RootDirOrigin = rootEnvVar
+}
+
+// explicitRootOrigin names what pinned the root, for messages.
+func explicitRootOrigin() string {
+	if Config.RootDirOrigin == "" {
+		return "--root"
+	}
+
+	return Config.RootDirOrigin
+}
+
+// explicitRootSettles reports whether err is an ambiguous root that the
+// explicit root is one of the candidates of, in which case the
+// explicit root stands.
+func explicitRootSettles(err error) bool {
+	var ambiguous *ambiguousRootError
+
+	return Config.RootDirExplicit && errors.As(err, &ambiguous) && ambiguous.resolvesTo(Config.RootDir)
+}
+
 // lookupFileArg fetches the named flag value from cobra and falls back
 // to scanning os.Args[1:] for short/long forms if cobra returns nothing.
 // Split out so DetectAndSetRoot stays linear instead of repeating the
@@ -228,6 +275,10 @@ func applyFileBasedRoot(configFiles []string) (bool, error) {
 	}
 
 	detectedRoot, err := detectRootFromFiles(configFiles)
+	if explicitRootSettles(err) {
+		return true, nil
+	}
+
 	if err != nil {
 		return false, err
 	}
@@ -278,6 +329,10 @@ func applyExplicitFilesRoot(filePaths []string) (bool, error) {
 	}
 
 	detectedRoot, err := ValidateAndDetectRootsForFiles(filePaths)
+	if explicitRootSettles(err) {
+		return true, nil
+	}
+
 	if err != nil {
 		return false, err
 	}
@@ -293,9 +348,9 @@ func applyExplicitFilesRoot(filePaths []string) (bool, error) {
 	// must win over the file-derived guess.
 	if absConfigRoot != absDetectedRoot && Config.RootDirExplicit {
 		//nolint:wrapcheck // cockroachdb/errors.WithHint multi-return; ignore-sigs cover single-return only.
-		return false, errors.WithHint(
-			errors.Newf("conflicting project roots: global --root=%s, but files belong to root=%s", absConfigRoot, absDetectedRoot),
-			"drop --root or pass files that live under the explicit root",
+		return false, errors.WithHintf(
+			errors.Newf("conflicting project roots: global %s=%s, but files belong to root=%s", explicitRootOrigin(), absConfigRoot, absDetectedRoot),
+			"drop %s or pass files that live under the explicit root", explicitRootOrigin(),
 		)
 	}
 
@@ -338,9 +393,10 @@ func resolveProjectValueFiles(files []string, rootDir string) []string {
 }
 
 // ResolveSecretsPath resolves secrets.yaml path relative to project root if not absolute.
+// An empty path is the secrets file of the project's secrets layout.
 func ResolveSecretsPath(withSecrets string) string {
 	if withSecrets == "" {
-		withSecrets = localSecretsYamlName
+		withSecrets = filepath.FromSlash(secretsLayout().SecretsFile())
 	}
 
 	if !filepath.IsAbs(withSecrets) {
@@ -405,7 +461,7 @@ func ExpandFilePaths(paths []string) ([]string, error) {
 				//nolint:wrapcheck // cockroachdb/errors.WithHint multi-return; ignore-sigs cover single-return only.
 				return nil, errors.WithHint(
 					errors.Newf("no YAML files found in directory %s", path),
-					"point at a directory that contains .yaml or .yml files, or pass individual files",
+					"point at a directory that contains .yaml or .yml files (or JSON node files), or pass individual files",
 				)
 			}
 
@@ -419,7 +475,8 @@ func ExpandFilePaths(paths []string) ([]string, error) {
 	return expanded, nil
 }
 
-// findYAMLFiles recursively finds all YAML files in a directory.
+// findYAMLFiles recursively finds all YAML files in a directory, and
+// the JSON node files next to them.
 func findYAMLFiles(dir string) ([]string, error) {
 	var yamlFiles []string
 
@@ -429,8 +486,7 @@ func findYAMLFiles(dir string) ([]string, error) {
 		}
 
 		if !info.IsDir() {
-			ext := filepath.Ext(path)
-			if ext == ".yaml" || ext == ".yml" {
+			if isNodeFileCandidate(path) {
 				absPath, err := filepath.Abs(path)
 				if err != nil {
 					return errors.Wrapf(err, "failed to get absolute path for %s", path)
@@ -16,7 +16,6 @@ package commands
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -25,6 +24,7 @@ import (
 	"github.com/cosi-project/runtime/pkg/safe"
 	"github.com/cozystack/talm/pkg/age"
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/siderolabs/crypto/x509"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -148,7 +148,7 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 		workerNodes, _ := cmd.Flags().GetStringSlice("worker-nodes")
 
 		if len(controlPlaneNodes) == 0 && len(workerNodes) == 0 {
-			fmt.Fprintf(os.Stderr, "> Auto-discovering cluster nodes...\n")
+			ui.Infof(os.Stderr, "> Auto-discovering cluster nodes...")
 
 			cpNodes, wNodes, err := discoverClusterNodes()
 			if err != nil {
@@ -165,8 +165,8 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 				}
 			}
 
-			fmt.Fprintf(os.Stderr, "  Control plane: %v\n", cpNodes)
-			fmt.Fprintf(os.Stderr, "  Workers: %v\n", wNodes)
+			ui.Infof(os.Stderr, "  Control plane: %v", cpNodes)
+			ui.Infof(os.Stderr, "  Workers: %v", wNodes)
 		}
 
 		// Set --output to project talosconfig
@@ -181,6 +181,12 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 			}
 		}
 
+		if output, _ := cmd.Flags().GetString("output"); output != "" {
+			if err := refuseExternalTalosconfigWrite(output, "rotate-ca"); err != nil {
+				return err
+			}
+		}
+
 		// Set --k8s-endpoint from GlobalArgs.Endpoints. Delegate to
 		// normalizeEndpoint so the canonical form (including the IPv6
 		// `[host]` no-port branch) matches the rest of the package
@@ -193,6 +199,12 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 			}
 		}
 
+		if !dryRun {
+			if err := confirmDestructive(rotateCAConfirmation(cmd, rotateTalos, rotateKubernetes)); err != nil {
+				return err
+			}
+		}
+
 		// Run the original rotate-ca command
 		if err := originalRunE(cmd, args); err != nil {
 			return err
@@ -203,7 +215,7 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 			return nil
 		}
 
-		fmt.Fprintf(os.Stderr, "\n> Updating local configuration files...\n")
+		ui.Infof(os.Stderr, "\n> Updating local configuration files...")
 
 		// Use control plane node for COSI requests (not the external endpoint)
 		cpNodes, _ := cmd.Flags().GetStringSlice("control-plane-nodes")
@@ -227,19 +239,44 @@ The command runs in dry-run mode by default. Use --dry-run=false to perform actu
 
 		// Update kubeconfig using talm kubeconfig
 		if rotateKubernetes {
-			fmt.Fprintf(os.Stderr, "> Updating kubeconfig...\n")
+			ui.Infof(os.Stderr, "> Updating kubeconfig...")
 
 			if err := runKubeconfigCmd(); err != nil {
 				return errors.Wrap(err, "failed to update kubeconfig")
 			}
 		}
 
-		fmt.Fprintf(os.Stderr, "\n> CA rotation completed successfully!\n")
+		ui.Successf(os.Stderr, "\n> CA rotation completed successfully!")
 
 		return nil
 	}
 }
 
+// rotateCAConfirmation summarises a CA rotation for the confirmation
+// prompt: which CAs change and the nodes that get new certificates.
+func rotateCAConfirmation(cmd *cobra.Command, rotateTalos, rotateKubernetes bool) confirmation {
+	var cas []string
+
+	if rotateTalos {
+		cas = append(cas, "Talos API")
+	}
+
+	if rotateKubernetes {
+		cas = append(cas, "Kubernetes API")
+	}
+
+	controlPlane, _ := cmd.Flags().GetStringSlice("control-plane-nodes")
+	workers, _ := cmd.Flags().GetStringSlice("worker-nodes")
+
+	c := confirmation{action: "Rotate the cluster certificate authorities"}
+	c.addTarget(GlobalArgs.Nodes)
+	c.add("CAs", strings.Join(cas, ", "))
+	c.add("Control plane", strings.Join(controlPlane, ", "))
+	c.add("Workers", strings.Join(workers, ", "))
+
+	return c
+}
+
 // discoverClusterNodes discovers control plane and worker nodes from the Kubernetes API.
 //
 //nolint:nonamedreturns // named returns document semantics (control-plane vs workers); naked returns are not used so renaming would only lose the documentation.
@@ -264,6 +301,10 @@ func discoverClusterNodes() (controlPlane, workers []string, err error) {
 		return nil, nil, errors.Wrap(err, "failed to create kubernetes config")
 	}
 
+	if err := trustCABundle(config); err != nil {
+		return nil, nil, err
+	}
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "failed to create kubernetes client")
@@ -369,7 +410,7 @@ func updateSecretsFromCluster(updateTalos, updateKubernetes bool, _ string) erro
 	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
 		// Fetch Talos CA if needed
 		if updateTalos {
-			fmt.Fprintf(os.Stderr, "  Fetching Talos CA from cluster...\n")
+			ui.Infof(os.Stderr, "  Fetching Talos CA from cluster...")
 
 			osRoot, err := safe.StateGetByID[*secretsres.OSRoot](ctx, c.COSI, secretsres.OSRootID)
 			if err != nil {
@@ -384,7 +425,7 @@ func updateSecretsFromCluster(updateTalos, updateKubernetes bool, _ string) erro
 
 		// Fetch Kubernetes CA if needed
 		if updateKubernetes {
-			fmt.Fprintf(os.Stderr, "  Fetching Kubernetes CA from cluster...\n")
+			ui.Infof(os.Stderr, "  Fetching Kubernetes CA from cluster...")
 
 			k8sRoot, err := safe.StateGetByID[*secretsres.KubernetesRoot](ctx, c.COSI, secretsres.KubernetesRootID)
 			if err != nil {
@@ -413,18 +454,19 @@ func updateSecretsFromCluster(updateTalos, updateKubernetes bool, _ string) erro
 		return errors.Wrap(err, "failed to write secrets.yaml")
 	}
 
-	fmt.Fprintf(os.Stderr, "  Updated secrets.yaml\n")
+	ui.Infof(os.Stderr, "  Updated secrets.yaml")
 
 	// Update secrets.encrypted.yaml if it exists
-	encryptedPath := filepath.Join(Config.RootDir, "secrets.encrypted.yaml")
+	layout := secretsLayout()
+	encryptedPath := projectPath(layout.EncryptedSecretsFile())
 
-	keyFile := filepath.Join(Config.RootDir, "talm.key")
+	keyFile := projectPath(layout.KeyFile())
 	if fileExists(encryptedPath) && fileExists(keyFile) {
 		if err := age.EncryptSecretsFile(Config.RootDir); err != nil {
 			return errors.Wrap(err, "failed to encrypt secrets.yaml")
 		}
 
-		fmt.Fprintf(os.Stderr, "  Updated secrets.encrypted.yaml\n")
+		ui.Infof(os.Stderr, "  Updated %s", layout.EncryptedSecretsFile())
 	}
 
 	return nil
@@ -433,13 +475,13 @@ func updateSecretsFromCluster(updateTalos, updateKubernetes bool, _ string) erro
 // updateTalosconfigEncryption updates talosconfig.encrypted if it exists.
 func updateTalosconfigEncryption() error {
 	encryptedPath := filepath.Join(Config.RootDir, "talosconfig.encrypted")
-	keyFile := filepath.Join(Config.RootDir, "talm.key")
+	keyFile := projectPath(secretsLayout().KeyFile())
 
 	if !fileExists(encryptedPath) || !fileExists(keyFile) {
 		return nil
 	}
 
-	fmt.Fprintf(os.Stderr, "  Updating talosconfig.encrypted...\n")
+	ui.Infof(os.Stderr, "  Updating talosconfig.encrypted...")
 
 	if err := age.EncryptYAMLFile(Config.RootDir, "talosconfig", "talosconfig.encrypted"); err != nil {
 		return errors.Wrap(err, "failed to encrypt talosconfig")
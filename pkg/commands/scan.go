@@ -0,0 +1,486 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/dustin/go-humanize"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/hardware"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	// scanTalosAPIPort is the port apid, and the maintenance API before
+	// it, listen on.
+	scanTalosAPIPort = 50000
+
+	// scanMaxAddresses bounds the addresses one scan probes: a /16 of
+	// IPv4. A larger range is almost always a typo for a smaller one.
+	scanMaxAddresses = 1 << 16
+)
+
+// The statuses of a node that answered on the Talos API port.
+const (
+	scanStatusMaintenance = "maintenance"
+	scanStatusConfigured  = "configured"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var scanCmdFlags struct {
+	cidrs            []string
+	timeout          time.Duration
+	concurrency      int
+	certFingerprints []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Find the Talos nodes of a network and the hardware of those in maintenance mode",
+	Long: `Probe every address of the --cidr ranges on the Talos API port, 50000/tcp,
+and list the nodes that answered.
+
+A node in maintenance mode, booted from the Talos image without a config,
+answers without credentials: the scan reads its Talos version, its system
+vendor and model, its processors and memory, its disks and its physical
+interfaces, over the same maintenance connection as talm apply --insecure.
+A node that already has a config refuses the unauthenticated connection
+and is listed as configured, without details.
+
+The scan runs from any directory and needs no talosconfig. Each address
+gets --timeout to accept the connection; --concurrency addresses are
+probed at once. --output json or yaml prints the nodes as a report for
+tooling, in place of the table.`,
+	Example: `  # The nodes of a /24
+  talm scan --cidr 192.0.2.0/24
+
+  # Two ranges, as JSON
+  talm scan --cidr 192.0.2.0/24 --cidr 198.51.100.0/25 --output json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		addresses, err := scanAddresses(scanCmdFlags.cidrs)
+		if err != nil {
+			return err
+		}
+
+		if scanCmdFlags.timeout <= 0 || scanCmdFlags.concurrency <= 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("--timeout and --concurrency must be positive; got %s and %d", scanCmdFlags.timeout, scanCmdFlags.concurrency),
+				"pass a duration like 2s and a number of parallel probes like 64",
+			)
+		}
+
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		ui.Infof(os.Stderr, "Scanning %d address(es) on port %d", len(addresses), scanTalosAPIPort)
+
+		run := scanRun{
+			addresses:   addresses,
+			timeout:     scanCmdFlags.timeout,
+			concurrency: scanCmdFlags.concurrency,
+			dial:        dialTalosAPI,
+			probe:       probeScanNode,
+		}
+
+		nodes := run.execute(ctx)
+		if len(nodes) == 0 {
+			ui.Warnf(os.Stderr, "No Talos API answered in %s", strings.Join(scanCmdFlags.cidrs, ", "))
+		}
+
+		for _, node := range nodes {
+			if node.Error != "" {
+				ui.Warnf(os.Stderr, "%s: %s", node.Address, node.Error)
+			}
+		}
+
+		if structuredOutput() {
+			return writeStructuredOutput(cmd.OutOrStdout(), scanReport{CIDRs: scanCmdFlags.cidrs, Nodes: nodes})
+		}
+
+		writeScanTable(cmd.OutOrStdout(), nodes)
+
+		return nil
+	},
+}
+
+// scanReport is the document --output json|yaml prints.
+type scanReport struct {
+	CIDRs []string   `json:"cidrs"`
+	Nodes []scanNode `json:"nodes"`
+}
+
+// scanNode is one address that answered on the Talos API port, with
+// what it told about itself in maintenance mode.
+type scanNode struct {
+	Address      string          `json:"address"`
+	Status       string          `json:"status"`
+	Version      string          `json:"version,omitempty"`
+	Manufacturer string          `json:"manufacturer,omitempty"`
+	Product      string          `json:"product,omitempty"`
+	CPUSockets   int             `json:"cpuSockets,omitempty"`
+	CPUCores     int             `json:"cpuCores,omitempty"`
+	CPUThreads   int             `json:"cpuThreads,omitempty"`
+	MemoryMiB    uint64          `json:"memoryMiB,omitempty"`
+	Disks        []scanDisk      `json:"disks,omitempty"`
+	Interfaces   []scanInterface `json:"interfaces,omitempty"`
+	// Error is why a maintenance node's hardware could not be read in
+	// full; what was read is still reported.
+	Error string `json:"error,omitempty"`
+}
+
+// scanDisk is one disk of a node in maintenance mode.
+type scanDisk struct {
+	Device    string `json:"device"`
+	SizeBytes uint64 `json:"sizeBytes"`
+	Transport string `json:"transport,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Serial    string `json:"serial,omitempty"`
+	WWID      string `json:"wwid,omitempty"`
+}
+
+// scanInterface is one physical interface of a node in maintenance
+// mode, with its global addresses.
+type scanInterface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// scanRun is one configured scan. dial reports whether the Talos API
+// port accepts a connection; probe reads a node that does. Tests
+// substitute both.
+type scanRun struct {
+	addresses   []netip.Addr
+	timeout     time.Duration
+	concurrency int
+	dial        func(ctx context.Context, address netip.Addr, timeout time.Duration) bool
+	probe       func(ctx context.Context, address netip.Addr, timeout time.Duration) scanNode
+}
+
+// execute probes the addresses, concurrency at a time, and returns the
+// nodes that answered in address order.
+func (r scanRun) execute(ctx context.Context) []scanNode {
+	results := make([]*scanNode, len(r.addresses))
+	slots := make(chan struct{}, r.concurrency)
+
+	var wg sync.WaitGroup
+
+	for i, address := range r.addresses {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+			wg.Go(func() {
+				defer func() { <-slots }()
+
+				if !r.dial(ctx, address, r.timeout) {
+					return
+				}
+
+				node := r.probe(ctx, address, r.timeout)
+				results[i] = &node
+			})
+		}
+	}
+
+	wg.Wait()
+
+	nodes := make([]scanNode, 0, len(results))
+
+	for _, node := range results {
+		if node != nil {
+			nodes = append(nodes, *node)
+		}
+	}
+
+	return nodes
+}
+
+// scanAddresses expands the CIDRs into the addresses to probe, in
+// order and without repeats. The network and broadcast addresses of an
+// IPv4 range wider than /31 are skipped; a bare address is probed as
+// itself.
+func scanAddresses(cidrs []string) ([]netip.Addr, error) {
+	if len(cidrs) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("no range to scan"),
+			"pass the network the nodes boot in, e.g. --cidr 192.0.2.0/24",
+		)
+	}
+
+	var (
+		addresses []netip.Addr
+		seen      = map[netip.Addr]bool{}
+	)
+
+	for _, cidr := range cidrs {
+		prefix, err := parseScanCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits > 16 || len(addresses)+1<<hostBits > scanMaxAddresses {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHint(
+				errors.Newf("--cidr %s holds more addresses than one scan probes, %d at most", cidr, scanMaxAddresses),
+				"split the network into the smaller ranges the nodes boot in",
+			)
+		}
+
+		first, last := prefix.Addr(), scanLastAddr(prefix)
+		if prefix.Addr().Is4() && prefix.Bits() < 31 {
+			first, last = first.Next(), last.Prev()
+		}
+
+		for addr := first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+			if !seen[addr] {
+				seen[addr] = true
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+
+	return addresses, nil
+}
+
+// parseScanCIDR parses a --cidr value, a prefix or a bare address,
+// into the masked prefix.
+func parseScanCIDR(cidr string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(cidr); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return netip.Prefix{}, errors.WithHint(
+			errors.Wrapf(err, "--cidr %q is not a CIDR", cidr),
+			"pass a range as address/prefix, e.g. 192.0.2.0/24",
+		)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// scanLastAddr is the last address of the masked prefix.
+func scanLastAddr(prefix netip.Prefix) netip.Addr {
+	raw := prefix.Addr().AsSlice()
+
+	for bit := prefix.Bits(); bit < len(raw)*8; bit++ {
+		raw[bit/8] |= 1 << (7 - bit%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(raw)
+
+	return addr
+}
+
+// dialTalosAPI reports whether address accepts a TCP connection on the
+// Talos API port within timeout.
+func dialTalosAPI(ctx context.Context, address netip.Addr, timeout time.Duration) bool {
+	dialer := net.Dialer{Timeout: timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address.String(), strconv.Itoa(scanTalosAPIPort)))
+	if err != nil {
+		return false
+	}
+
+	_ = conn.Close()
+
+	return true
+}
+
+// probeScanNode reads the node at address over a maintenance
+// connection. A node that refuses the version request has a config, so
+// it is reported as configured; once it answered, a hardware read that
+// fails is recorded on the node and the rest is still read.
+func probeScanNode(_ context.Context, address netip.Addr, timeout time.Duration) scanNode {
+	node := scanNode{Address: address.String(), Status: scanStatusConfigured}
+	req := talosClientRequest{maintenance: true, fingerprints: scanCmdFlags.certFingerprints, endpoints: []string{address.String()}}
+
+	_ = withTalosClient(req, func(ctx context.Context, c *client.Client) error {
+		versionCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		resp, err := c.Version(versionCtx)
+		if err != nil {
+			return err //nolint:wrapcheck // a refused version request is the configured status.
+		}
+
+		node.Status = scanStatusMaintenance
+
+		if msgs := resp.GetMessages(); len(msgs) > 0 {
+			node.Version = msgs[0].GetVersion().GetTag()
+		}
+
+		var problems []string
+
+		if err := readScanHardware(ctx, c, &node); err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		facts, err := readAttachFacts(ctx, c)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		for _, disk := range installableDisks(facts.Disks) {
+			node.Disks = append(node.Disks, scanDisk{
+				Device:    disk.DevPath,
+				SizeBytes: disk.Size,
+				Transport: disk.Transport,
+				Model:     disk.Model,
+				Serial:    disk.Serial,
+				WWID:      disk.WWID,
+			})
+		}
+
+		for _, link := range facts.Links {
+			node.Interfaces = append(node.Interfaces, scanInterface(link))
+		}
+
+		node.Error = strings.Join(problems, "; ")
+
+		return nil
+	})
+
+	return node
+}
+
+// readScanHardware fills the system, processor and memory facts of
+// node. All three resources are NonSensitive, so the maintenance
+// connection reads them; a machine without SMBIOS tables has none.
+func readScanHardware(ctx context.Context, c *client.Client, node *scanNode) error {
+	info, err := readWithFreshTimeout(ctx, preflightCOSIReadTimeout, func(ctx context.Context) (*hardware.SystemInformation, error) {
+		return safe.StateGetByID[*hardware.SystemInformation](ctx, c.COSI, hardware.SystemInformationID)
+	})
+	if err == nil {
+		node.Manufacturer = info.TypedSpec().Manufacturer
+		node.Product = info.TypedSpec().ProductName
+	}
+
+	processors, err := readWithFreshTimeout(ctx, preflightCOSIReadTimeout, func(ctx context.Context) (safe.List[*hardware.Processor], error) {
+		return safe.StateListAll[*hardware.Processor](ctx, c.COSI)
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing Processor resources")
+	}
+
+	for processor := range processors.All() {
+		spec := processor.TypedSpec()
+		if spec.CoreCount == 0 {
+			continue
+		}
+
+		node.CPUSockets++
+		node.CPUCores += int(cmp.Or(spec.CoreEnabled, spec.CoreCount))
+		node.CPUThreads += int(spec.ThreadCount)
+	}
+
+	modules, err := readWithFreshTimeout(ctx, preflightCOSIReadTimeout, func(ctx context.Context) (safe.List[*hardware.MemoryModule], error) {
+		return safe.StateListAll[*hardware.MemoryModule](ctx, c.COSI)
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing MemoryModule resources")
+	}
+
+	for module := range modules.All() {
+		node.MemoryMiB += uint64(module.TypedSpec().Size)
+	}
+
+	return nil
+}
+
+// writeScanTable prints one row per node that answered.
+func writeScanTable(out io.Writer, nodes []scanNode) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSTATUS\tVERSION\tSYSTEM\tCPU\tMEMORY\tDISKS\tINTERFACES")
+
+	for _, node := range nodes {
+		if node.Status != scanStatusMaintenance {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t-\n", node.Address, node.Status)
+
+			continue
+		}
+
+		system := strings.TrimSpace(node.Manufacturer + " " + node.Product)
+
+		cpu := "-"
+		if node.CPUSockets > 0 {
+			cpu = fmt.Sprintf("%dx, %d cores, %d threads", node.CPUSockets, node.CPUCores, node.CPUThreads)
+		}
+
+		memory := "-"
+		if node.MemoryMiB > 0 {
+			memory = humanize.IBytes(node.MemoryMiB * humanize.MiByte)
+		}
+
+		disks := make([]string, 0, len(node.Disks))
+		for _, disk := range node.Disks {
+			disks = append(disks, fmt.Sprintf("%s %s", strings.TrimPrefix(disk.Device, "/dev/"), humanize.Bytes(disk.SizeBytes)))
+		}
+
+		interfaces := make([]string, 0, len(node.Interfaces))
+		for _, link := range node.Interfaces {
+			interfaces = append(interfaces, strings.TrimSpace(link.Name+" "+link.MAC))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			node.Address, node.Status, scanCell(node.Version), scanCell(system), cpu, memory,
+			scanCell(strings.Join(disks, ", ")), scanCell(strings.Join(interfaces, ", ")))
+	}
+
+	_ = w.Flush()
+}
+
+// scanCell is value, or a dash when it is empty.
+func scanCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+func init() {
+	scanCmd.Flags().StringSliceVar(&scanCmdFlags.cidrs, "cidr", nil, "ranges to scan, as address/prefix or a single address; repeat or comma-separate for several")
+	scanCmd.Flags().DurationVar(&scanCmdFlags.timeout, "timeout", 2*time.Second, "time limit for each address to accept the connection and answer")
+	scanCmd.Flags().IntVar(&scanCmdFlags.concurrency, "concurrency", 64, "addresses probed at once")
+	scanCmd.Flags().StringSliceVar(&scanCmdFlags.certFingerprints, "cert-fingerprint", nil, "accept only maintenance certificates with these SPKI fingerprints")
+
+	_ = scanCmd.MarkFlagRequired("cidr")
+
+	addCommand(scanCmd)
+}
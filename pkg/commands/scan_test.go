@@ -0,0 +1,183 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestScanAddresses pins the expansion of the ranges: network and
+// broadcast skipped on IPv4, /31, /32 and bare addresses probed whole,
+// repeats dropped, and oversized or malformed ranges refused.
+func TestScanAddresses(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		cidrs   []string
+		want    []string
+		wantErr string
+	}{
+		{name: "ipv4 /30", cidrs: []string{"192.0.2.0/30"}, want: []string{"192.0.2.1", "192.0.2.2"}},
+		{name: "unmasked", cidrs: []string{"192.0.2.9/30"}, want: []string{"192.0.2.9", "192.0.2.10"}},
+		{name: "ipv4 /31", cidrs: []string{"192.0.2.4/31"}, want: []string{"192.0.2.4", "192.0.2.5"}},
+		{name: "bare address and repeat", cidrs: []string{"192.0.2.7", "192.0.2.6/31"}, want: []string{"192.0.2.7", "192.0.2.6"}},
+		{name: "ipv6 /126", cidrs: []string{"2001:db8::/126"}, want: []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{name: "none", wantErr: "no range"},
+		{name: "not a cidr", cidrs: []string{"192.0.2.0/33"}, wantErr: "is not a CIDR"},
+		{name: "too large", cidrs: []string{"10.0.0.0/15"}, wantErr: "more addresses"},
+		{name: "too large together", cidrs: []string{"10.0.0.0/16", "10.1.0.0/24"}, wantErr: "more addresses"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := scanAddresses(tc.cidrs)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error = %v, want one mentioning %q", err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotStrings := make([]string, len(got))
+			for i, addr := range got {
+				gotStrings[i] = addr.String()
+			}
+
+			if strings.Join(gotStrings, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("addresses = %v, want %v", gotStrings, tc.want)
+			}
+		})
+	}
+}
+
+// TestScanRun pins that only the addresses that accept the connection
+// are probed, that at most concurrency probes run at once, and that the
+// nodes come back in address order.
+func TestScanRun(t *testing.T) {
+	t.Parallel()
+
+	addresses, err := scanAddresses([]string{"192.0.2.0/28"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := map[string]bool{"192.0.2.3": true, "192.0.2.9": true, "192.0.2.14": true}
+
+	var running, peak atomic.Int32
+
+	run := scanRun{
+		addresses:   addresses,
+		timeout:     time.Second,
+		concurrency: 3,
+		dial: func(_ context.Context, address netip.Addr, timeout time.Duration) bool {
+			if timeout != time.Second {
+				t.Errorf("timeout = %v, want the run's", timeout)
+			}
+
+			n := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			return open[address.String()]
+		},
+		probe: func(_ context.Context, address netip.Addr, _ time.Duration) scanNode {
+			if address.String() == "192.0.2.3" {
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			return scanNode{Address: address.String(), Status: scanStatusMaintenance}
+		},
+	}
+
+	nodes := run.execute(context.Background())
+
+	got := make([]string, len(nodes))
+	for i, node := range nodes {
+		got[i] = node.Address
+	}
+
+	if strings.Join(got, ",") != "192.0.2.3,192.0.2.9,192.0.2.14" {
+		t.Errorf("nodes = %v, want the open addresses in order", got)
+	}
+
+	if peak.Load() > 3 {
+		t.Errorf("%d probes ran at once, want at most 3", peak.Load())
+	}
+}
+
+// TestWriteScanTable pins the row of a maintenance node and of a node
+// that already has a config.
+func TestWriteScanTable(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	writeScanTable(&buf, []scanNode{
+		{
+			Address:      "192.0.2.10",
+			Status:       scanStatusMaintenance,
+			Version:      "v1.13.7",
+			Manufacturer: "Supermicro",
+			Product:      "SYS-1029P",
+			CPUSockets:   2,
+			CPUCores:     40,
+			CPUThreads:   80,
+			MemoryMiB:    262144,
+			Disks:        []scanDisk{{Device: "/dev/nvme0n1", SizeBytes: 960_000_000_000}},
+			Interfaces:   []scanInterface{{Name: "enp1s0f0", MAC: "aa:bb:cc:dd:ee:01"}},
+		},
+		{Address: "192.0.2.11", Status: scanStatusConfigured},
+	})
+
+	out := buf.String()
+	for _, want := range []string{
+		"ADDRESS",
+		"v1.13.7",
+		"Supermicro SYS-1029P",
+		"2x, 40 cores, 80 threads",
+		"256 GiB",
+		"nvme0n1 960 GB",
+		"enp1s0f0 aa:bb:cc:dd:ee:01",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table lacks %q:\n%s", want, out)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "192.0.2.11") || !strings.Contains(lines[2], scanStatusConfigured) {
+		t.Errorf("configured row:\n%s", out)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"path/filepath"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// secretFile is one secret-bearing artefact of a project. Every tool
+// that keeps secrets out of git reads its list from projectSecretFiles:
+// .gitignore, the git filter, the chart package and the re-encryption
+// of `talm secrets recipients add`. A new kind of secret file is added
+// there once and every one of them covers it.
+type secretFile struct {
+	// plain is the project-relative path of the plaintext file, empty
+	// when Chart.yaml points the file outside the project.
+	plain string
+	// ignore is the .gitignore entry that keeps the plaintext file out
+	// of git.
+	ignore string
+	// encrypted is the project-relative path of the age-encrypted
+	// sibling, empty for a file that is never encrypted.
+	encrypted string
+	// gitFiltered marks the files the talm git filter encrypts on
+	// commit instead of leaving them ignored.
+	gitFiltered bool
+}
+
+// projectSecretFiles lists the secret-bearing files of a project with
+// the secrets layout and the talosconfig and kubeconfig paths of its
+// Chart.yaml; an empty path is the default name. The order is the one
+// .gitignore lists them in.
+func projectSecretFiles(layout age.Layout, talosconfig, kubeconfig string) []secretFile {
+	return []secretFile{
+		layoutSecretFile(layout.SecretsFile(), layout.EncryptedSecretsFile(), true),
+		clientConfigSecretFile(cmp.Or(talosconfig, talosconfigName), true),
+		layoutSecretFile(layout.KeyFile(), "", false),
+		layoutSecretFile(layout.ValuesSecretFile(), layout.EncryptedValuesSecretFile(), true),
+		clientConfigSecretFile(cmp.Or(kubeconfig, defaultKubeconfigName), false),
+	}
+}
+
+// layoutSecretFile is a file of the secrets layout, ignored by its
+// project-relative path.
+func layoutSecretFile(plain, encrypted string, gitFiltered bool) secretFile {
+	file := secretFile{plain: slashPath(plain), gitFiltered: gitFiltered}
+	file.ignore = file.plain
+
+	if encrypted != "" {
+		file.encrypted = slashPath(encrypted)
+	}
+
+	return file
+}
+
+// clientConfigSecretFile is a talosconfig or kubeconfig. It is ignored
+// by its base name, since .gitignore has no use for a path outside the
+// project, and its encrypted sibling appends encryptedTalosconfigSuffix.
+// One outside the project is neither encrypted nor filtered by talm.
+func clientConfigSecretFile(path string, gitFiltered bool) secretFile {
+	file := secretFile{ignore: filepath.Base(path)}
+	if filepath.IsAbs(path) {
+		return file
+	}
+
+	file.plain = slashPath(path)
+	file.encrypted = file.plain + encryptedTalosconfigSuffix
+	file.gitFiltered = gitFiltered
+
+	return file
+}
+
+// slashPath is path cleaned and with forward slashes, the form
+// .gitignore, .gitattributes and the chart archive use.
+func slashPath(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
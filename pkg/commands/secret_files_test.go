@@ -0,0 +1,104 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// TestProjectSecretFiles_Defaults pins the registry of a project with
+// the default names, in .gitignore order.
+func TestProjectSecretFiles_Defaults(t *testing.T) {
+	t.Parallel()
+
+	want := []secretFile{
+		{plain: "secrets.yaml", ignore: "secrets.yaml", encrypted: "secrets.encrypted.yaml", gitFiltered: true},
+		{plain: "talosconfig", ignore: "talosconfig", encrypted: "talosconfig.encrypted", gitFiltered: true},
+		{plain: "talm.key", ignore: "talm.key"},
+		{plain: "values-secret.yaml", ignore: "values-secret.yaml", encrypted: "values-secret.encrypted.yaml", gitFiltered: true},
+		{plain: "kubeconfig", ignore: "kubeconfig", encrypted: "kubeconfig.encrypted"},
+	}
+
+	if got := projectSecretFiles(age.Layout{}, "", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("projectSecretFiles() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+// TestProjectSecretFiles_MovedFiles pins the entries of a project that
+// moves its secrets and client configs: the layout files are ignored
+// by path, the client configs by base name, and a client config outside
+// the project is neither encrypted nor filtered.
+func TestProjectSecretFiles_MovedFiles(t *testing.T) {
+	t.Parallel()
+
+	files := projectSecretFiles(
+		age.Layout{Secrets: "secrets/cluster.yaml", Key: "secrets/age.key"},
+		"./clusters/prod/talosconfig",
+		"/etc/kubernetes/admin.kubeconfig",
+	)
+
+	if files[0].ignore != "secrets/cluster.yaml" || files[0].encrypted != "secrets/cluster.encrypted.yaml" {
+		t.Errorf("secrets = %+v", files[0])
+	}
+
+	if files[2].ignore != "secrets/age.key" || files[2].encrypted != "" {
+		t.Errorf("key = %+v", files[2])
+	}
+
+	want := secretFile{plain: "clusters/prod/talosconfig", ignore: "talosconfig", encrypted: "clusters/prod/talosconfig.encrypted", gitFiltered: true}
+	if files[1] != want {
+		t.Errorf("talosconfig = %+v, want %+v", files[1], want)
+	}
+
+	if want := (secretFile{ignore: "admin.kubeconfig"}); files[4] != want {
+		t.Errorf("kubeconfig = %+v, want %+v", files[4], want)
+	}
+}
+
+// TestSecretFileConsumers pins that the package exclusions and the
+// re-encryption cover every file of the registry, so a new secret file
+// needs no change in either.
+func TestSecretFileConsumers(t *testing.T) {
+	withSecretsLayout(t, age.Layout{ValuesSecret: "secrets/values.yaml"})
+	age.SetLayout(age.Layout{ValuesSecret: "secrets/values.yaml"})
+
+	Config.GlobalOptions.Talosconfig = ""
+	Config.GlobalOptions.Kubeconfig = ""
+
+	excluded := clientConfigPaths(secretsLayout(), "", "")
+	encrypted := projectEncryptedFiles()
+
+	for _, file := range projectSecretFiles(secretsLayout(), "", "") {
+		if !slices.Contains(excluded, file.plain) {
+			t.Errorf("package does not exclude %s: %v", file.plain, excluded)
+		}
+
+		if file.encrypted == "" {
+			continue
+		}
+
+		if !slices.Contains(excluded, file.encrypted) {
+			t.Errorf("package does not exclude %s: %v", file.encrypted, excluded)
+		}
+
+		if !slices.Contains(encrypted, file.encrypted) {
+			t.Errorf("recipients add does not re-encrypt %s: %v", file.encrypted, encrypted)
+		}
+	}
+}
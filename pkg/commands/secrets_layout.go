@@ -0,0 +1,362 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/httpclient"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// secretsLayout is the project's key and secrets file layout, from
+// Chart.yaml globalOptions (key, secrets, valuesSecret).
+func secretsLayout() age.Layout {
+	return age.Layout{
+		Key:          Config.GlobalOptions.Key,
+		Secrets:      Config.GlobalOptions.Secrets,
+		ValuesSecret: Config.GlobalOptions.ValuesSecret,
+	}
+}
+
+// projectPath joins a project-relative path from the secrets layout
+// onto the project root.
+func projectPath(rel string) string {
+	return filepath.Join(Config.RootDir, filepath.FromSlash(rel))
+}
+
+// ApplySecretsLayout validates the secrets layout Chart.yaml declares
+// and makes the age helpers use it, extra recipients included. A
+// project still holding its files under the default names is pointed
+// at `talm init --migrate-secrets`. Called once Chart.yaml is loaded.
+func ApplySecretsLayout() error {
+	layout := secretsLayout()
+
+	recipients, err := resolveProjectRecipients(Config.GlobalOptions.Recipients)
+	if err != nil {
+		return err
+	}
+
+	layout.Recipients = recipients
+
+	if err := setSecretsLayout(layout); err != nil {
+		return err
+	}
+
+	if pending := pendingSecretsMoves(Config.RootDir, layout); len(pending) > 0 {
+		ui.Warnf(os.Stderr, "%s is still at its default location; run `talm init --migrate-secrets` to move it to %s", pending[0].from, pending[0].to)
+	}
+
+	return nil
+}
+
+// setSecretsLayout validates layout and hands it to the age helpers.
+func setSecretsLayout(layout age.Layout) error {
+	if err := validateSecretsLayout(layout); err != nil {
+		return err
+	}
+
+	age.SetLayout(layout)
+
+	return nil
+}
+
+// loadProjectSecretsLayout picks the secrets layout up from the
+// Chart.yaml of an existing project, for the commands that skip the
+// Chart.yaml load every other command gets yet work on the configured
+// files: init --encrypt, --decrypt and --migrate-secrets, and the git
+// filter.
+func loadProjectSecretsLayout() error {
+	layout := projectSecretsLayout(Config.RootDir)
+	specs := readProjectGlobalOptions(Config.RootDir).Recipients
+
+	Config.GlobalOptions.Key = layout.Key
+	Config.GlobalOptions.Secrets = layout.Secrets
+	Config.GlobalOptions.ValuesSecret = layout.ValuesSecret
+	Config.GlobalOptions.Recipients = specs
+
+	recipients, err := resolveProjectRecipients(specs)
+	if err != nil {
+		return err
+	}
+
+	layout.Recipients = recipients
+
+	return setSecretsLayout(layout)
+}
+
+// resolveProjectRecipients resolves the globalOptions.recipients of
+// the project, fetching and pinning the keys of a github: entry seen
+// for the first time.
+func resolveProjectRecipients(specs []string) ([]string, error) {
+	return resolveRecipients(context.Background(), ui.Progress(os.Stderr), Config.RootDir, specs, githubKeys(githubURL, httpclient.New(githubKeysTimeout)))
+}
+
+// validateSecretsLayout checks that every configured path stays inside
+// the project, and that the secrets files are YAML so their encrypted
+// siblings can be named after them.
+func validateSecretsLayout(layout age.Layout) error {
+	for _, option := range []struct {
+		name, value string
+		yaml        bool
+	}{
+		{"globalOptions.key", layout.Key, false},
+		{"globalOptions.secrets", layout.Secrets, true},
+		{"globalOptions.valuesSecret", layout.ValuesSecret, true},
+	} {
+		if option.value == "" {
+			continue
+		}
+
+		clean := filepath.Clean(filepath.FromSlash(option.value))
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("%s %q must be a path inside the project", option.name, option.value),
+				"set %s in %s relative to the project root, e.g. secrets/%s", option.name, chartYamlName, filepath.Base(clean),
+			)
+		}
+
+		if option.yaml && (!strings.HasSuffix(clean, ".yaml") || strings.HasSuffix(clean, age.EncryptedFileSuffix)) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("%s %q must name the plaintext .yaml file", option.name, option.value),
+				"the encrypted file is derived from it: secrets/cluster.yaml is encrypted to secrets/cluster.encrypted.yaml",
+			)
+		}
+	}
+
+	return nil
+}
+
+// projectSecretsLayout reads the secrets layout of the Chart.yaml in
+// dir, for root detection, which runs before Config is loaded. A
+// missing or unreadable Chart.yaml yields the default layout.
+func projectSecretsLayout(dir string) age.Layout {
+	opts := readProjectGlobalOptions(dir)
+
+	return age.Layout{Key: opts.Key, Secrets: opts.Secrets, ValuesSecret: opts.ValuesSecret}
+}
+
+// projectGlobalOptions is the part of Chart.yaml globalOptions read
+// without loading the whole configuration.
+type projectGlobalOptions struct {
+	Talosconfig  string   `yaml:"talosconfig"`
+	Kubeconfig   string   `yaml:"kubeconfig"`
+	Key          string   `yaml:"key"`
+	Secrets      string   `yaml:"secrets"`
+	ValuesSecret string   `yaml:"valuesSecret"`
+	Recipients   []string `yaml:"recipients"`
+}
+
+// readProjectGlobalOptions reads globalOptions from the Chart.yaml in
+// dir. A missing or unreadable Chart.yaml yields the zero value.
+func readProjectGlobalOptions(dir string) projectGlobalOptions {
+	data, err := os.ReadFile(filepath.Join(dir, chartYamlName))
+	if err != nil {
+		return projectGlobalOptions{}
+	}
+
+	var chart struct {
+		GlobalOptions projectGlobalOptions `yaml:"globalOptions"`
+	}
+
+	if err := yaml.Unmarshal(data, &chart); err != nil {
+		return projectGlobalOptions{}
+	}
+
+	return chart.GlobalOptions
+}
+
+// secretsMove is one file of the default layout and where the
+// configured layout keeps it.
+type secretsMove struct {
+	from, to string
+}
+
+// secretsMoves pairs every file of the default layout with its place
+// in layout, leaving out the ones layout does not move.
+func secretsMoves(layout age.Layout) []secretsMove {
+	var defaults age.Layout
+
+	moves := make([]secretsMove, 0, 5) //nolint:mnd // the five files of a layout below.
+
+	for _, pair := range [][2]string{
+		{defaults.KeyFile(), layout.KeyFile()},
+		{defaults.SecretsFile(), layout.SecretsFile()},
+		{defaults.EncryptedSecretsFile(), layout.EncryptedSecretsFile()},
+		{defaults.ValuesSecretFile(), layout.ValuesSecretFile()},
+		{defaults.EncryptedValuesSecretFile(), layout.EncryptedValuesSecretFile()},
+	} {
+		if filepath.Clean(pair[0]) != filepath.Clean(filepath.FromSlash(pair[1])) {
+			moves = append(moves, secretsMove{from: pair[0], to: pair[1]})
+		}
+	}
+
+	return moves
+}
+
+// pendingSecretsMoves lists the moves a migration would make under
+// rootDir: the default-named file exists and its configured place is
+// still free.
+func pendingSecretsMoves(rootDir string, layout age.Layout) []secretsMove {
+	var pending []secretsMove
+
+	for _, move := range secretsMoves(layout) {
+		if fileExists(filepath.Join(rootDir, move.from)) && !fileExists(filepath.Join(rootDir, filepath.FromSlash(move.to))) {
+			pending = append(pending, move)
+		}
+	}
+
+	return pending
+}
+
+// migrateSecretsLayout moves the files still at their default location
+// under rootDir to the places layout configures, creating directories
+// as needed. A file whose configured place is already taken is left
+// alone and reported, so a migration never overwrites a secret. It
+// returns the number of files moved.
+func migrateSecretsLayout(w io.Writer, rootDir string, layout age.Layout) (int, error) {
+	moved := 0
+
+	for _, move := range secretsMoves(layout) {
+		from := filepath.Join(rootDir, move.from)
+		to := filepath.Join(rootDir, filepath.FromSlash(move.to))
+
+		if !fileExists(from) {
+			continue
+		}
+
+		if fileExists(to) {
+			fmt.Fprintf(w, "Skipping %s: %s already exists\n", move.from, move.to)
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), secureDirMode); err != nil {
+			return moved, errors.Wrapf(err, "creating the directory of %s", move.to)
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			return moved, errors.Wrapf(err, "moving %s to %s", move.from, move.to)
+		}
+
+		fmt.Fprintf(w, "Moved %s -> %s\n", move.from, move.to)
+
+		moved++
+	}
+
+	return moved, nil
+}
+
+// runMigrateSecrets is `talm init --migrate-secrets`: it moves the
+// files still at their default location to the places Chart.yaml
+// configures, points templateOptions.valueFiles at the moved values
+// file and refreshes .gitignore for the new paths.
+func runMigrateSecrets() error {
+	layout := secretsLayout()
+
+	moves := secretsMoves(layout)
+	if len(moves) == 0 {
+		ui.Infof(os.Stderr, "Nothing to migrate: %s keeps the default secrets layout. Set globalOptions.key, globalOptions.secrets or globalOptions.valuesSecret first.", chartYamlName)
+
+		return nil
+	}
+
+	moved, err := migrateSecretsLayout(ui.Progress(os.Stderr), Config.RootDir, layout)
+	if err != nil {
+		return err
+	}
+
+	renames := make(map[string]string, len(moves))
+	for _, move := range moves {
+		renames[move.from] = move.to
+	}
+
+	rewritten, err := renameChartValueFiles(filepath.Join(Config.RootDir, chartYamlName), renames)
+	if err != nil {
+		return err
+	}
+
+	if rewritten {
+		ui.Infof(os.Stderr, "Updated templateOptions.valueFiles in %s", chartYamlName)
+	}
+
+	if err := writeGitignoreFile(); err != nil {
+		return errors.Wrap(err, "failed to update .gitignore")
+	}
+
+	ui.Successf(os.Stderr, "Migration completed. %d file(s) moved.", moved)
+
+	return nil
+}
+
+// renameChartValueFiles rewrites the templateOptions.valueFiles entries
+// of the Chart.yaml at file that renames maps to a new path, keeping
+// the rest of the file and its comments. It reports whether the file
+// changed.
+func renameChartValueFiles(file string, renames map[string]string) (bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false, errors.Wrapf(err, "reading %s", file)
+	}
+
+	docs, err := decodeAllYAMLDocs(data)
+	if err != nil {
+		return false, errors.Wrapf(err, "parsing %s", file)
+	}
+
+	if len(docs) == 0 || len(docs[0].Content) == 0 {
+		return false, nil
+	}
+
+	valueFiles, err := lookupValuesPath(docs[0].Content[0], []string{"templateOptions", "valueFiles"})
+	if err != nil || valueFiles == nil || valueFiles.Kind != yaml.SequenceNode {
+		return false, nil //nolint:nilerr // a Chart.yaml without a valueFiles list has nothing to rename.
+	}
+
+	changed := false
+
+	for _, entry := range valueFiles.Content {
+		if to, ok := renames[filepath.Clean(entry.Value)]; ok && entry.Kind == yaml.ScalarNode {
+			entry.Value = to
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return false, errors.Wrapf(err, "encoding %s", file)
+	}
+
+	if err := os.WriteFile(file, out, presetFileMode); err != nil {
+		return false, errors.Wrapf(err, "writing %s", file)
+	}
+
+	return true, nil
+}
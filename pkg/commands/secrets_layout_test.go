@@ -0,0 +1,218 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/age"
+)
+
+// withSecretsLayout roots the project at a temp dir with layout as its
+// Chart.yaml globalOptions, and restores the globals afterwards.
+func withSecretsLayout(t *testing.T, layout age.Layout) string {
+	t.Helper()
+
+	origRoot, origOptions, origLayout := Config.RootDir, Config.GlobalOptions, age.CurrentLayout()
+	t.Cleanup(func() {
+		Config.RootDir, Config.GlobalOptions = origRoot, origOptions
+		age.SetLayout(origLayout)
+	})
+
+	dir := t.TempDir()
+	Config.RootDir = dir
+	Config.GlobalOptions.Key = layout.Key
+	Config.GlobalOptions.Secrets = layout.Secrets
+	Config.GlobalOptions.ValuesSecret = layout.ValuesSecret
+
+	return dir
+}
+
+func TestValidateSecretsLayout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		layout  age.Layout
+		wantErr string
+	}{
+		{name: "defaults", layout: age.Layout{}},
+		{name: "moved", layout: age.Layout{Key: "secrets/talm.key", Secrets: "secrets/secrets.yaml", ValuesSecret: "secrets/values.yaml"}},
+		{name: "absolute key", layout: age.Layout{Key: "/etc/talm.key"}, wantErr: "globalOptions.key"},
+		{name: "escaping secrets", layout: age.Layout{Secrets: "../secrets.yaml"}, wantErr: "inside the project"},
+		{name: "not yaml", layout: age.Layout{Secrets: "secrets/bundle.json"}, wantErr: "plaintext .yaml"},
+		{name: "encrypted name", layout: age.Layout{ValuesSecret: "values-secret.encrypted.yaml"}, wantErr: "globalOptions.valuesSecret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateSecretsLayout(tt.layout)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDetectProjectRoot_MovedSecrets pins that a project keeping its
+// secrets where Chart.yaml says is still found as a project root.
+func TestDetectProjectRoot_MovedSecrets(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, dir, chartYamlName, "name: demo\nglobalOptions:\n  secrets: secrets/cluster.yaml\n")
+
+	if err := os.MkdirAll(filepath.Join(dir, "secrets", "nested"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if root, err := DetectProjectRoot(filepath.Join(dir, "secrets", "nested")); err != nil || root != "" {
+		t.Fatalf("without a secrets file: root = %q, err = %v", root, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "secrets"), "cluster.encrypted.yaml", "cluster: {}\n")
+
+	if root, err := DetectProjectRoot(filepath.Join(dir, "secrets", "nested")); err != nil || root != dir {
+		t.Fatalf("root = %q, err = %v, want %q", root, err, dir)
+	}
+}
+
+func TestResolveSecretsPath_Layout(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{Secrets: "secrets/cluster.yaml"})
+
+	if got, want := ResolveSecretsPath(""), filepath.Join(dir, "secrets", "cluster.yaml"); got != want {
+		t.Errorf("ResolveSecretsPath(\"\") = %q, want %q", got, want)
+	}
+
+	if got, want := ResolveSecretsPath("other.yaml"), filepath.Join(dir, "other.yaml"); got != want {
+		t.Errorf("an explicit path wins: got %q, want %q", got, want)
+	}
+}
+
+func TestMigrateSecretsLayout(t *testing.T) {
+	layout := age.Layout{Key: "secrets/talm.key", Secrets: "secrets/secrets.yaml"}
+	dir := withSecretsLayout(t, layout)
+
+	writeFile(t, dir, talmKeyName, "AGE-SECRET-KEY-1PLACEHOLDER\n")
+	writeFile(t, dir, secretsYamlName, "cluster: {}\n")
+	writeFile(t, dir, "secrets.encrypted.yaml", "cluster: {}\n")
+	writeFile(t, dir, valuesSecretYamlName, "password: x\n")
+
+	if pending := pendingSecretsMoves(dir, layout); len(pending) != 3 {
+		t.Fatalf("pending moves = %+v, want the key and both secrets files", pending)
+	}
+
+	// A file already at its configured place is never overwritten.
+	if err := os.MkdirAll(filepath.Join(dir, "secrets"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "secrets"), "secrets.encrypted.yaml", "newer: {}\n")
+
+	var out bytes.Buffer
+
+	moved, err := migrateSecretsLayout(&out, dir, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moved != 2 || !strings.Contains(out.String(), "Skipping secrets.encrypted.yaml: secrets/secrets.encrypted.yaml already exists") {
+		t.Errorf("moved = %d, output:\n%s", moved, out.String())
+	}
+
+	for _, name := range []string{"secrets/talm.key", "secrets/secrets.yaml", "secrets.encrypted.yaml", valuesSecretYamlName} {
+		if !fileExists(filepath.Join(dir, filepath.FromSlash(name))) {
+			t.Errorf("%s must exist after the migration", name)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "secrets", "secrets.encrypted.yaml")); string(data) != "newer: {}\n" {
+		t.Errorf("the configured file was overwritten: %q", data)
+	}
+}
+
+func TestRenameChartValueFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	chart := "name: demo\ntemplateOptions:\n  # secret values, decrypted in memory\n  valueFiles:\n    - values-secret.encrypted.yaml\n    - values-extra.yaml\n"
+	writeFile(t, dir, chartYamlName, chart)
+
+	renames := map[string]string{"values-secret.encrypted.yaml": "secrets/values.encrypted.yaml"}
+
+	changed, err := renameChartValueFiles(filepath.Join(dir, chartYamlName), renames)
+	if err != nil || !changed {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, chartYamlName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"- secrets/values.encrypted.yaml", "- values-extra.yaml", "# secret values, decrypted in memory"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Chart.yaml missing %q:\n%s", want, data)
+		}
+	}
+
+	if changed, err := renameChartValueFiles(filepath.Join(dir, chartYamlName), renames); err != nil || changed {
+		t.Errorf("a second run must leave Chart.yaml alone: changed = %v, err = %v", changed, err)
+	}
+}
+
+func TestWriteGitignoreFile_Layout(t *testing.T) {
+	dir := withSecretsLayout(t, age.Layout{Key: "secrets/talm.key", Secrets: "secrets/cluster.yaml"})
+
+	if err := writeGitignoreFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"\nsecrets/cluster.yaml\n", "\nsecrets/talm.key\n", "\nvalues-secret.yaml\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf(".gitignore missing %q:\n%s", strings.TrimSpace(want), data)
+		}
+	}
+}
+
+func TestProjectClientConfigs_ExcludesMovedSecrets(t *testing.T) {
+	withSecretsLayout(t, age.Layout{Key: "secrets/age.key", ValuesSecret: "secrets/values.yaml"})
+
+	excluded := strings.Join(projectClientConfigs(), ",")
+	for _, want := range []string{"secrets/age.key", "secrets/values.yaml", "secrets/values.encrypted.yaml"} {
+		if !strings.Contains(excluded, want) {
+			t.Errorf("package must exclude %s, got %s", want, excluded)
+		}
+	}
+}
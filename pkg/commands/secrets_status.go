@@ -0,0 +1,142 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/certexpiry"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// defaultSecretsWarnDays is the remaining validity below which
+// `talm secrets status` flags a CA for rotation. rotate-ca is a
+// rolling operation across every node; half a year leaves room to
+// schedule it.
+const defaultSecretsWarnDays = 180
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var secretsStatusCmdFlags struct {
+	warnDays int
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Inspect the project secrets bundle",
+	Args:  cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var secretsStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show days to expiry for the CAs in secrets.yaml",
+	Long: `Show the expiry of every certificate authority in the project
+secrets.yaml: the Talos API CA, the Kubernetes CA, the Kubernetes
+aggregator CA and the etcd CA. The Kubernetes service account key is
+listed too; it is a bare key and does not expire.
+
+CAs with fewer than --warn-days days left are marked for rotation. Rotate
+the Talos API and Kubernetes CAs with talm rotate-ca before they expire;
+an expired CA locks talm and kubectl out of the cluster.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		secretsPath := ResolveSecretsPath(Config.TemplateOptions.WithSecrets)
+		if !fileExists(secretsPath) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("secrets.yaml not found at %s", secretsPath),
+				"run 'talm init' or restore secrets.yaml (decrypt it with `talm init --decrypt`)",
+			)
+		}
+
+		bundle, err := secrets.LoadBundle(secretsPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to load secrets bundle")
+		}
+
+		entries, err := certexpiry.FromBundle(bundle)
+		if err != nil {
+			return errors.Wrapf(err, "reading certificate expiry from %s", secretsPath)
+		}
+
+		if printSecretsStatus(cmd.OutOrStdout(), entries, time.Now(), secretsStatusCmdFlags.warnDays) {
+			ui.Warnf(os.Stderr, "one or more CAs expire within %d days; plan a `talm %s` before they do.", secretsStatusCmdFlags.warnDays, rotateCACmdName)
+		}
+
+		return nil
+	},
+}
+
+// printSecretsStatus writes the expiry table and reports whether any
+// entry is expired or within warnDays of expiring.
+func printSecretsStatus(w io.Writer, entries []certexpiry.Entry, now time.Time, warnDays int) bool {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "SECRET\tEXPIRES\tDAYS LEFT\tSTATUS")
+
+	needsRotation := false
+
+	for _, entry := range entries {
+		if !entry.HasExpiry {
+			fmt.Fprintf(tw, "%s\t-\t-\tno expiry\n", entry.Name)
+
+			continue
+		}
+
+		daysLeft := certexpiry.DaysLeft(entry.NotAfter, now)
+
+		status := "ok"
+
+		switch {
+		case daysLeft < 0:
+			status = "EXPIRED"
+			needsRotation = true
+		case daysLeft < warnDays:
+			status = "rotate soon"
+			needsRotation = true
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", entry.Name, entry.NotAfter.UTC().Format(time.DateOnly), daysLeft, status)
+	}
+
+	_ = tw.Flush()
+
+	return needsRotation
+}
+
+func init() {
+	secretsStatusCmd.Flags().IntVar(&secretsStatusCmdFlags.warnDays, "warn-days", defaultSecretsWarnDays, "mark CAs with fewer days left than this for rotation")
+
+	secretsCmd.AddCommand(secretsStatusCmd)
+	addCommand(secretsCmd)
+}
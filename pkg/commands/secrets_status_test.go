@@ -0,0 +1,75 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozystack/talm/pkg/certexpiry"
+)
+
+// TestPrintSecretsStatus pins the status column thresholds and that
+// the rotation signal fires for both expiring and expired CAs but not
+// for the key without expiry.
+func TestPrintSecretsStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		notAfter   time.Time
+		wantStatus string
+		wantRotate bool
+	}{
+		{"healthy", now.AddDate(5, 0, 0), "ok", false},
+		{"expiring", now.AddDate(0, 0, 30), "rotate soon", true},
+		{"expired", now.AddDate(0, 0, -1), "EXPIRED", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries := []certexpiry.Entry{
+				{Key: "talosCA", Name: "Talos API CA", NotAfter: tc.notAfter, HasExpiry: true},
+				{Key: "serviceAccountKey", Name: "Kubernetes service account key"},
+			}
+
+			var out bytes.Buffer
+
+			rotate := printSecretsStatus(&out, entries, now, defaultSecretsWarnDays)
+			if rotate != tc.wantRotate {
+				t.Errorf("needsRotation = %v, want %v", rotate, tc.wantRotate)
+			}
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("expected header plus two rows, got:\n%s", out.String())
+			}
+
+			if !strings.HasSuffix(lines[1], tc.wantStatus) {
+				t.Errorf("CA row %q must end with status %q", lines[1], tc.wantStatus)
+			}
+
+			if !strings.HasSuffix(lines[2], "no expiry") {
+				t.Errorf("service account key row %q must read no expiry", lines[2])
+			}
+		})
+	}
+}
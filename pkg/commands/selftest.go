@@ -0,0 +1,111 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/e2e"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var selftestCmdFlags struct {
+	talosctl     string
+	provisioner  string
+	cidr         string
+	preset       string
+	upgradeImage string
+	createArgs   []string
+	workDir      string
+	keep         bool
+	timeout      time.Duration
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run talm end to end against a throwaway local Talos cluster",
+	Long: `Provision a one-node Talos cluster with talosctl cluster create and run
+this talm binary against it: init a project, render the configs, boot the
+cluster from them, re-template the node file against the live node, apply a
+patch, fetch the kubeconfig, wait for talm healthcheck to pass and, with
+--upgrade-image, upgrade the node. The cluster is destroyed afterwards.
+
+It checks that this machine can provision and manage a cluster with talm:
+talosctl must be installed, and the default docker provisioner needs a
+running docker daemon. Nothing outside the temporary work directory and the
+test cluster is touched; selftest does not need a talm project.`,
+	Example: `  # Run the suite on docker
+  talm selftest
+
+  # Keep the cluster for inspection after the run
+  talm selftest --keep --workdir ./selftest
+
+  # Include the upgrade step on a VM provisioner
+  sudo -E talm selftest --provisioner qemu --upgrade-image ghcr.io/siderolabs/installer:v1.13.7`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		ctx, stop := signalContext()
+		defer stop()
+
+		ctx, cancel := context.WithTimeout(ctx, selftestCmdFlags.timeout)
+		defer cancel()
+
+		return runSelftest(ctx, cmd)
+	},
+}
+
+func runSelftest(ctx context.Context, cmd *cobra.Command) error {
+	talm, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "locating the talm binary")
+	}
+
+	results, err := e2e.Run(ctx, e2e.Options{
+		Talm:         talm,
+		Talosctl:     selftestCmdFlags.talosctl,
+		WorkDir:      selftestCmdFlags.workDir,
+		Provisioner:  selftestCmdFlags.provisioner,
+		CIDR:         selftestCmdFlags.cidr,
+		Preset:       selftestCmdFlags.preset,
+		UpgradeImage: selftestCmdFlags.upgradeImage,
+		CreateArgs:   selftestCmdFlags.createArgs,
+		Keep:         selftestCmdFlags.keep,
+		Log:          cmd.ErrOrStderr(),
+	})
+
+	e2e.WriteSummary(cmd.OutOrStdout(), results)
+
+	return err //nolint:wrapcheck // e2e.Run names the failed step and attaches hints.
+}
+
+func init() {
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.talosctl, "talosctl", "talosctl", "talosctl binary that provisions the test cluster")
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.provisioner, "provisioner", e2e.DefaultProvisioner, "talosctl cluster provisioner: docker or qemu")
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.cidr, "cidr", e2e.DefaultCIDR, "network of the test cluster; pick another one if it collides with a local network")
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.preset, "preset", e2e.DefaultPreset, "preset the test project is initialized with")
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.upgradeImage, "upgrade-image", "", "installer image to upgrade the node to; enables the upgrade step, which needs a VM provisioner")
+	selftestCmd.Flags().StringArrayVar(&selftestCmdFlags.createArgs, "create-arg", nil, "extra argument for talosctl cluster create (repeatable), e.g. --create-arg=--image=ghcr.io/siderolabs/talos:v1.13.7")
+	selftestCmd.Flags().StringVar(&selftestCmdFlags.workDir, "workdir", "", "directory for the test project and talosctl state (default: a temporary directory, removed afterwards)")
+	selftestCmd.Flags().BoolVar(&selftestCmdFlags.keep, "keep", false, "keep the cluster and the work directory after the run")
+	selftestCmd.Flags().DurationVar(&selftestCmdFlags.timeout, "timeout", 30*time.Minute, "time limit for the whole run; the cluster is still destroyed when it expires")
+
+	addCommand(selftestCmd)
+}
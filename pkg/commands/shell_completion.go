@@ -0,0 +1,303 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// completionCmdName takes the name of cobra's default completion
+// command, which cobra then leaves out: talm's own adds --install.
+const completionCmdName = "completion"
+
+// Completion scripts are read by the operator's shell, not secrets:
+// they get the modes any file a package manager installs would.
+const (
+	completionFileMode os.FileMode = 0o644
+	completionDirMode  os.FileMode = 0o755
+)
+
+// completionShell describes one shell talm generates a completion
+// script for: how to write the script and where --install puts it.
+type completionShell struct {
+	name     string
+	generate func(root *cobra.Command, w io.Writer, descriptions bool) error
+	// installPath returns the per-user file the shell loads
+	// completions from, for the binary named binary.
+	installPath func(binary string) (string, error)
+	// activate, when set, makes the shell load the installed script
+	// (PowerShell has no completion directory to drop it into).
+	activate func(script string) (string, error)
+	// hint tells the operator what, if anything, is left to do after
+	// an install.
+	hint string
+}
+
+//nolint:gochecknoglobals // immutable table of the supported shells, consulted when the subcommands are built.
+var completionShells = []completionShell{
+	{
+		name: "bash",
+		generate: func(root *cobra.Command, w io.Writer, descriptions bool) error {
+			return root.GenBashCompletionV2(w, descriptions) //nolint:wrapcheck // wrapped by the caller.
+		},
+		installPath: func(binary string) (string, error) {
+			if dir := os.Getenv("BASH_COMPLETION_USER_DIR"); dir != "" {
+				return filepath.Join(dir, "completions", binary), nil
+			}
+
+			dir, err := xdgDir("XDG_DATA_HOME", ".local", "share")
+
+			return filepath.Join(dir, "bash-completion", "completions", binary), err
+		},
+		hint: "bash loads it in new shells once the bash-completion package is installed",
+	},
+	{
+		name: "zsh",
+		generate: func(root *cobra.Command, w io.Writer, descriptions bool) error {
+			if descriptions {
+				return root.GenZshCompletion(w) //nolint:wrapcheck // wrapped by the caller.
+			}
+
+			return root.GenZshCompletionNoDesc(w) //nolint:wrapcheck // wrapped by the caller.
+		},
+		installPath: func(binary string) (string, error) {
+			dir, err := xdgDir("XDG_DATA_HOME", ".local", "share")
+
+			return filepath.Join(dir, "zsh", "site-functions", "_"+binary), err
+		},
+		hint: "zsh loads it in new shells when its directory is on fpath: add `fpath=(<directory> $fpath)` before compinit in ~/.zshrc",
+	},
+	{
+		name: "fish",
+		generate: func(root *cobra.Command, w io.Writer, descriptions bool) error {
+			return root.GenFishCompletion(w, descriptions) //nolint:wrapcheck // wrapped by the caller.
+		},
+		installPath: func(binary string) (string, error) {
+			dir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+
+			return filepath.Join(dir, "fish", "completions", binary+".fish"), err
+		},
+		hint: "fish loads it in new shells",
+	},
+	{
+		name: "powershell",
+		generate: func(root *cobra.Command, w io.Writer, descriptions bool) error {
+			if descriptions {
+				return root.GenPowerShellCompletionWithDesc(w) //nolint:wrapcheck // wrapped by the caller.
+			}
+
+			return root.GenPowerShellCompletion(w) //nolint:wrapcheck // wrapped by the caller.
+		},
+		installPath: func(binary string) (string, error) {
+			profile, err := powerShellProfilePath()
+
+			return filepath.Join(filepath.Dir(profile), binary+"-completion.ps1"), err
+		},
+		activate: activatePowerShellCompletion,
+		hint:     "PowerShell loads it in new sessions through the profile",
+	},
+}
+
+// xdgDir returns the directory the XDG variable env names, or the
+// fallback path elements under the home directory when it is unset.
+func xdgDir(env string, fallback ...string) (string, error) {
+	if dir := os.Getenv(env); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "resolving home directory")
+	}
+
+	return filepath.Join(append([]string{home}, fallback...)...), nil
+}
+
+// powerShellProfilePath returns the current user's PowerShell profile
+// for the current host, the file $PROFILE names in pwsh.
+func powerShellProfilePath() (string, error) {
+	const profileName = "Microsoft.PowerShell_profile.ps1"
+
+	if runtime.GOOS == "windows" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "resolving home directory")
+		}
+
+		return filepath.Join(home, "Documents", "PowerShell", profileName), nil
+	}
+
+	dir, err := xdgDir("XDG_CONFIG_HOME", ".config")
+
+	return filepath.Join(dir, "powershell", profileName), err
+}
+
+// activatePowerShellCompletion dot-sources script from the PowerShell
+// profile, once: a second install leaves the profile alone. Returns
+// the profile path.
+func activatePowerShellCompletion(script string) (string, error) {
+	profile, err := powerShellProfilePath()
+	if err != nil {
+		return "", err
+	}
+
+	line := ". '" + strings.ReplaceAll(script, "'", "''") + "'"
+
+	current, err := os.ReadFile(profile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "reading %s", profile)
+	}
+
+	for existing := range strings.SplitSeq(string(current), "\n") {
+		if strings.TrimSpace(existing) == line {
+			return profile, nil
+		}
+	}
+
+	var block bytes.Buffer
+	if len(current) > 0 && !bytes.HasSuffix(current, []byte("\n")) {
+		block.WriteString("\n")
+	}
+
+	block.WriteString("# talm shell completion\n" + line + "\n")
+
+	file, err := os.OpenFile(profile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, completionFileMode) //nolint:gosec // G302/G304: the operator's own profile, world-readable like any shell startup file.
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", profile)
+	}
+
+	if _, err := file.Write(block.Bytes()); err != nil {
+		_ = file.Close()
+
+		return "", errors.Wrapf(err, "writing %s", profile)
+	}
+
+	return profile, errors.Wrapf(file.Close(), "writing %s", profile)
+}
+
+// installCompletion writes the completion script for shell to its
+// per-user location, activating it where the shell needs that, and
+// returns the script path.
+func installCompletion(root *cobra.Command, shell completionShell, descriptions bool) (string, error) {
+	path, err := shell.installPath(root.Name())
+	if err != nil {
+		return "", err
+	}
+
+	var script bytes.Buffer
+	if err := shell.generate(root, &script, descriptions); err != nil {
+		return "", errors.Wrapf(err, "generating %s completion", shell.name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), completionDirMode); err != nil {
+		return "", errors.Wrapf(err, "creating %s", filepath.Dir(path))
+	}
+
+	if err := os.WriteFile(path, script.Bytes(), completionFileMode); err != nil { //nolint:gosec // G306: completion scripts are world-readable like any other shell completion file.
+		return "", errors.Wrapf(err, "writing %s", path)
+	}
+
+	if shell.activate != nil {
+		if _, err := shell.activate(path); err != nil {
+			return "", err
+		}
+	}
+
+	return path, nil
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var completionCmdFlags struct {
+	install        bool
+	noDescriptions bool
+}
+
+// newCompletionShellCmd builds the `talm completion <shell>` subcommand.
+func newCompletionShellCmd(shell completionShell) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               shell.name,
+		Short:             "Generate the autocompletion script for " + shell.name,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			root := cmd.Root()
+			descriptions := !completionCmdFlags.noDescriptions
+
+			if !completionCmdFlags.install {
+				return errors.Wrapf(shell.generate(root, cmd.OutOrStdout(), descriptions), "generating %s completion", shell.name)
+			}
+
+			path, err := installCompletion(root, shell, descriptions)
+			if err != nil {
+				return err
+			}
+
+			ui.Successf(os.Stderr, "Installed %s completion in %s", shell.name, path)
+			ui.Infof(os.Stderr, "%s", shell.hint)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&completionCmdFlags.install, "install", false, "write the script to the shell's per-user completion location instead of stdout")
+	cmd.Flags().BoolVar(&completionCmdFlags.noDescriptions, "no-descriptions", false, "disable completion descriptions")
+
+	return cmd
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var completionCmd = &cobra.Command{
+	Use:   completionCmdName,
+	Short: "Generate the autocompletion script for the specified shell",
+	Long: `Generate the autocompletion script for bash, zsh, fish or PowerShell.
+
+Without --install the script goes to stdout, to load or save as you see
+fit. With --install talm writes it where the shell looks for per-user
+completions:
+
+  bash        $BASH_COMPLETION_USER_DIR/completions/talm, or
+              $XDG_DATA_HOME/bash-completion/completions/talm
+              (~/.local/share/bash-completion/completions/talm)
+  zsh         $XDG_DATA_HOME/zsh/site-functions/_talm, a directory to
+              add to fpath
+  fish        $XDG_CONFIG_HOME/fish/completions/talm.fish
+              (~/.config/fish/completions/talm.fish)
+  powershell  talm-completion.ps1 next to the profile, which
+              dot-sources it
+
+The scripts ask talm itself for completions, so node files, presets,
+talosconfig nodes and endpoints, and the other dynamic candidates
+complete the same way in every shell. Re-run the install after
+upgrading talm.`,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	for _, shell := range completionShells {
+		completionCmd.AddCommand(newCompletionShellCmd(shell))
+	}
+
+	addCommand(completionCmd)
+}
@@ -0,0 +1,189 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// isolateCompletionHome points every location --install resolves at
+// a fresh home directory and returns it.
+func isolateCompletionHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("BASH_COMPLETION_USER_DIR", "")
+
+	return home
+}
+
+func completionShellByName(t *testing.T, name string) completionShell {
+	t.Helper()
+
+	for _, shell := range completionShells {
+		if shell.name == name {
+			return shell
+		}
+	}
+
+	t.Fatalf("no completion shell %q", name)
+
+	return completionShell{}
+}
+
+func testCompletionRoot() *cobra.Command {
+	root := &cobra.Command{Use: "talm"}
+	root.AddCommand(&cobra.Command{Use: "apply", Run: func(*cobra.Command, []string) {}})
+
+	return root
+}
+
+func TestCompletionCmd_Shells(t *testing.T) {
+	var names []string
+	for _, sub := range completionCmd.Commands() {
+		names = append(names, sub.Name())
+	}
+
+	slices.Sort(names)
+
+	if want := []string{"bash", "fish", "powershell", "zsh"}; !slices.Equal(names, want) {
+		t.Errorf("completion subcommands = %v, want %v", names, want)
+	}
+}
+
+func TestCompletionInstallPath_Defaults(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the PowerShell profile lives under Documents on Windows")
+	}
+
+	home := isolateCompletionHome(t)
+
+	for shell, want := range map[string]string{
+		"bash":       filepath.Join(home, ".local", "share", "bash-completion", "completions", "talm"),
+		"zsh":        filepath.Join(home, ".local", "share", "zsh", "site-functions", "_talm"),
+		"fish":       filepath.Join(home, ".config", "fish", "completions", "talm.fish"),
+		"powershell": filepath.Join(home, ".config", "powershell", "talm-completion.ps1"),
+	} {
+		got, err := completionShellByName(t, shell).installPath("talm")
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+
+		if got != want {
+			t.Errorf("%s install path = %s, want %s", shell, got, want)
+		}
+	}
+}
+
+func TestCompletionInstallPath_Overrides(t *testing.T) {
+	isolateCompletionHome(t)
+
+	data, config, bashDir := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	t.Setenv("XDG_CONFIG_HOME", config)
+
+	for shell, want := range map[string]string{
+		"bash": filepath.Join(data, "bash-completion", "completions", "talm"),
+		"zsh":  filepath.Join(data, "zsh", "site-functions", "_talm"),
+		"fish": filepath.Join(config, "fish", "completions", "talm.fish"),
+	} {
+		if got, _ := completionShellByName(t, shell).installPath("talm"); got != want {
+			t.Errorf("%s install path = %s, want %s", shell, got, want)
+		}
+	}
+
+	t.Setenv("BASH_COMPLETION_USER_DIR", bashDir)
+
+	want := filepath.Join(bashDir, "completions", "talm")
+	if got, _ := completionShellByName(t, "bash").installPath("talm"); got != want {
+		t.Errorf("bash install path = %s, want %s", got, want)
+	}
+}
+
+// TestInstallCompletion_WritesDynamicScripts pins that every shell
+// gets a script that asks talm for candidates, so ValidArgsFunctions
+// and flag completions work the same everywhere.
+func TestInstallCompletion_WritesDynamicScripts(t *testing.T) {
+	isolateCompletionHome(t)
+
+	for _, shell := range completionShells {
+		path, err := installCompletion(testCompletionRoot(), shell, true)
+		if err != nil {
+			t.Fatalf("%s: %v", shell.name, err)
+		}
+
+		script, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", shell.name, err)
+		}
+
+		if !strings.Contains(string(script), "__complete") {
+			t.Errorf("%s script does not call talm __complete:\n%s", shell.name, script)
+		}
+	}
+}
+
+func TestInstallCompletion_PowerShellProfileOnce(t *testing.T) {
+	isolateCompletionHome(t)
+
+	profile, err := powerShellProfilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(profile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(profile, []byte("Set-PSReadLineOption -EditMode Emacs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	shell := completionShellByName(t, "powershell")
+
+	var script string
+	for range 2 {
+		if script, err = installCompletion(testCompletionRoot(), shell, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(data)
+	line := ". '" + script + "'"
+
+	if !strings.HasPrefix(got, "Set-PSReadLineOption -EditMode Emacs\n") {
+		t.Errorf("the existing profile must be kept:\n%s", got)
+	}
+
+	if n := strings.Count(got, line); n != 1 {
+		t.Errorf("profile dot-sources the script %d times, want once:\n%s", n, got)
+	}
+}
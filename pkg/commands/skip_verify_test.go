@@ -146,10 +146,11 @@ func TestSkipVerifyTLSConfig_MismatchedKeyPair(t *testing.T) {
 // "present", points GlobalArgs at it while requesting the absent context
 // "absent", and toggles SkipVerify — restoring every mutated global on cleanup.
 //
-// It is a routing probe: a wrapper that reaches the local WithClientSkipVerify
-// fails with errContextNotFound before dialing, whereas one that falls through
-// to upstream global.Args surfaces a different error. That lets a test assert
-// which path a client wrapper took without needing a live node.
+// It is a routing probe: a wrapper that reaches the local client factory
+// (withTalosClient) fails with errContextNotFound before dialing, whereas one
+// that falls through to upstream global.Args surfaces a different error. That
+// lets a test assert which path a client wrapper took without needing a live
+// node.
 func stageMissingContextTalosconfig(t *testing.T, skipVerify bool) {
 	t.Helper()
 
@@ -197,21 +198,21 @@ func TestWithClientSkipVerify_ContextNotFound(t *testing.T) {
 }
 
 // TestWithClientNoNodes_SkipVerifyRoutes proves that with SkipVerify set the
-// shared talm choke point reroutes through WithClientSkipVerify. This is the
-// coverage the dropped cozystack/talos fork used to provide at the library
+// shared talm choke point builds its client through the local factory. This is
+// the coverage the dropped cozystack/talos fork used to provide at the library
 // level for every talm-native command; errContextNotFound is emitted only by
-// the local WithClientSkipVerify, so seeing it proves the route was taken.
+// the local factory, so seeing it proves the route was taken.
 func TestWithClientNoNodes_SkipVerifyRoutes(t *testing.T) {
 	stageMissingContextTalosconfig(t, true)
 
 	err := WithClientNoNodes(func(context.Context, *client.Client) error {
-		t.Fatal("action must not run — routing should hit WithClientSkipVerify's missing-context guard")
+		t.Fatal("action must not run — routing should hit the client factory's missing-context guard")
 
 		return nil
 	})
 
 	if !errors.Is(err, errContextNotFound) {
-		t.Fatalf("expected WithClientNoNodes to route through WithClientSkipVerify (errContextNotFound), got %v", err)
+		t.Fatalf("expected WithClientNoNodes to route through the client factory (errContextNotFound), got %v", err)
 	}
 }
 
@@ -222,13 +223,13 @@ func TestWithClient_SkipVerifyRoutes(t *testing.T) {
 	stageMissingContextTalosconfig(t, true)
 
 	err := WithClient(func(context.Context, *client.Client) error {
-		t.Fatal("action must not run — routing should hit WithClientSkipVerify's missing-context guard")
+		t.Fatal("action must not run — routing should hit the client factory's missing-context guard")
 
 		return nil
 	})
 
 	if !errors.Is(err, errContextNotFound) {
-		t.Fatalf("expected WithClient to route through WithClientSkipVerify (errContextNotFound), got %v", err)
+		t.Fatalf("expected WithClient to route through the client factory (errContextNotFound), got %v", err)
 	}
 }
 
@@ -256,14 +257,14 @@ func withGlobalArgsReset(t *testing.T) {
 func TestSkipVerifyClientOptions_ClusterThreaded(t *testing.T) {
 	withGlobalArgsReset(t)
 
-	base := skipVerifyClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
+	base := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
 	if len(base) != 4 {
 		t.Fatalf("baseline expected 4 options (config context + TLS + default gRPC + SideroV1 keys dir), got %d", len(base))
 	}
 
 	GlobalArgs.Cluster = "proxy-cluster"
 
-	withCluster := skipVerifyClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
+	withCluster := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
 	if len(withCluster) != len(base)+1 {
 		t.Errorf("--cluster must add one option: baseline %d, with cluster %d", len(base), len(withCluster))
 	}
@@ -274,8 +275,8 @@ func TestSkipVerifyClientOptions_ClusterThreaded(t *testing.T) {
 func TestSkipVerifyClientOptions_DialOptionsThreaded(t *testing.T) {
 	withGlobalArgsReset(t)
 
-	base := skipVerifyClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
-	withDial := skipVerifyClientOptions(&clientconfig.Context{}, &tls.Config{}, []grpc.DialOption{grpc.WithUserAgent("talm-test")})
+	base := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
+	withDial := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, []grpc.DialOption{grpc.WithUserAgent("talm-test")})
 
 	if len(withDial) != len(base)+1 {
 		t.Errorf("caller dial options must add one option: baseline %d, with dial %d", len(base), len(withDial))
@@ -287,13 +288,13 @@ func TestSkipVerifyClientOptions_DialOptionsThreaded(t *testing.T) {
 func TestSkipVerifyClientOptions_Endpoints(t *testing.T) {
 	withGlobalArgsReset(t)
 
-	none := skipVerifyClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
+	none := authenticatedClientOptions(&clientconfig.Context{}, &tls.Config{}, nil)
 
 	GlobalArgs.Endpoints = []string{"192.0.2.1"}
-	fromFlag := skipVerifyClientOptions(&clientconfig.Context{Endpoints: []string{"192.0.2.2"}}, &tls.Config{}, nil)
+	fromFlag := authenticatedClientOptions(&clientconfig.Context{Endpoints: []string{"192.0.2.2"}}, &tls.Config{}, nil)
 
 	GlobalArgs.Endpoints = nil
-	fromContext := skipVerifyClientOptions(&clientconfig.Context{Endpoints: []string{"192.0.2.2"}}, &tls.Config{}, nil)
+	fromContext := authenticatedClientOptions(&clientconfig.Context{Endpoints: []string{"192.0.2.2"}}, &tls.Config{}, nil)
 
 	if len(fromFlag) != len(none)+1 || len(fromContext) != len(none)+1 {
 		t.Errorf("endpoints must add exactly one option: none=%d flag=%d context=%d", len(none), len(fromFlag), len(fromContext))
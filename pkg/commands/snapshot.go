@@ -0,0 +1,119 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// snapshotValuesCommandName names snapshot values in value errors.
+const snapshotValuesCommandName = "talm snapshot values"
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var snapshotValuesCmdFlags struct {
+	valueFiles    []string // --values
+	stringValues  []string // --set-string
+	values        []string // --set
+	fileValues    []string // --set-file
+	jsonValues    []string // --set-json
+	literalValues []string // --set-literal
+	force         bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Freeze project inputs: release values and cluster lookups",
+	Args:  cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var snapshotValuesCmd = &cobra.Command{
+	Use:   "values <tag>",
+	Short: "Freeze the effective values of a release",
+	Long: `Resolve the complete values a render sees — the chart's values.yaml with
+Chart.yaml templateOptions and the --values / --set* flags merged on top —
+and write them to releases/<tag>/values.lock.yaml.
+
+` + "`talm template --release <tag>` and `talm apply --release <tag>`" + ` then render
+with exactly these values, ignoring values.yaml and the value files, so a
+re-render months later matches what was shipped even if the chart defaults
+changed since. Commit the lock with the release.
+
+When a value file is age-encrypted (*.encrypted.yaml), the lock is written
+encrypted as releases/<tag>/values.lock.encrypted.yaml.`,
+	Example: `  talm snapshot values v1.4.0
+  talm snapshot values v1.4.0 --values values-prod.yaml
+  talm template -f nodes/cp1.yaml --release v1.4.0`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return runSnapshotValues(args[0])
+	},
+}
+
+// runSnapshotValues resolves the effective values with the same layers
+// `talm template` merges and writes them as the values lock of tag.
+func runSnapshotValues(tag string) error {
+	if err := validateReleaseTag(tag); err != nil {
+		return err
+	}
+
+	// The lock freezes the values as they are; a deprecated value in it
+	// keeps warning on every render from the lock, so the snapshot
+	// honors the project's strictness, which projectRenderOptions sets.
+	opts := projectRenderOptions(snapshotValuesCommandName, "")
+	opts.ValueFiles = append(opts.ValueFiles, snapshotValuesCmdFlags.valueFiles...)
+	opts.Values = slices.Concat(opts.Values, snapshotValuesCmdFlags.values)
+	opts.StringValues = slices.Concat(opts.StringValues, snapshotValuesCmdFlags.stringValues)
+	opts.FileValues = slices.Concat(opts.FileValues, snapshotValuesCmdFlags.fileValues)
+	opts.JsonValues = slices.Concat(opts.JsonValues, snapshotValuesCmdFlags.jsonValues)
+	opts.LiteralValues = slices.Concat(opts.LiteralValues, snapshotValuesCmdFlags.literalValues)
+
+	values, err := engine.EffectiveValues(opts)
+	if err != nil {
+		return errors.Wrap(err, "resolving the effective values")
+	}
+
+	path, err := writeValuesLock(Config.RootDir, tag, values, hasEncryptedValueFile(opts.ValueFiles), snapshotValuesCmdFlags.force)
+	if err != nil {
+		return err
+	}
+
+	ui.Successf(os.Stderr, "Locked the values of release %s in %s", tag, path)
+
+	return nil
+}
+
+func init() {
+	snapshotValuesCmd.Flags().StringSliceVar(&snapshotValuesCmdFlags.valueFiles, "values", []string{}, "specify values in a YAML file (can specify multiple), merged over Chart.yaml templateOptions.valueFiles as in `talm template`")
+	snapshotValuesCmd.Flags().StringArrayVar(&snapshotValuesCmdFlags.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2). For IP / CIDR / version literals use --set-string — dots in --set values are interpreted as YAML key nesting.")
+	snapshotValuesCmd.Flags().StringArrayVar(&snapshotValuesCmdFlags.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	snapshotValuesCmd.Flags().StringArrayVar(&snapshotValuesCmdFlags.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	snapshotValuesCmd.Flags().StringArrayVar(&snapshotValuesCmdFlags.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
+	snapshotValuesCmd.Flags().StringArrayVar(&snapshotValuesCmdFlags.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
+	snapshotValuesCmd.Flags().BoolVar(&snapshotValuesCmdFlags.force, "force", false, "replace the values lock of a release that is already locked")
+
+	_ = snapshotValuesCmd.RegisterFlagCompletionFunc("values", completeYAMLFiles)
+
+	snapshotCmd.AddCommand(snapshotValuesCmd)
+	addCommand(snapshotCmd)
+}
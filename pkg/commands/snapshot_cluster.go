@@ -0,0 +1,129 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var snapshotClusterCmdFlags struct {
+	configFiles []string
+	kinds       []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var snapshotClusterCmd = &cobra.Command{
+	Use:   "cluster <dir>",
+	Short: "Record what chart lookups read from every node",
+	Long: `Read from every node the resources the chart lookup function reads —
+links, addresses, routes, disks, node names and the rest of the kinds the
+bundled charts look up — and write them to <dir>/<node>.yaml.
+
+` + "`talm template --snapshot <dir>`" + ` then renders offline with lookups answered
+from the recording, so a machine with no access to the cluster renders
+the same config the nodes would get. Record again after the hardware or
+the network of a node changes.
+
+Nodes and endpoints are taken from the modelines of the -f files
+(directories are expanded), or of every node file under nodes/ when no
+file is given; --nodes and --endpoints override them. The machine config
+is never recorded: it carries the cluster secrets. --kind adds a kind a
+custom chart looks up, named as the chart names it.`,
+	Example: `  talm snapshot cluster snapshots/
+  talm snapshot cluster snapshots/ -f nodes/cp01.yaml --kind blockdevices
+  talm template -f nodes/cp01.yaml --snapshot snapshots/`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		targets, err := resolvePingTargets(snapshotClusterCmdFlags.configFiles, ui.Progress(os.Stderr))
+		if err != nil {
+			return err
+		}
+
+		kinds := slices.Clone(engine.DefaultSnapshotKinds)
+		for _, kind := range snapshotClusterCmdFlags.kinds {
+			if !slices.Contains(kinds, kind) {
+				kinds = append(kinds, kind)
+			}
+		}
+
+		for _, target := range targets {
+			if err := snapshotNode(args[0], target, kinds); err != nil {
+				return errors.Wrapf(err, "node %s", target.node)
+			}
+		}
+
+		return nil
+	},
+}
+
+// snapshotNode records the lookups of target into dir.
+func snapshotNode(dir string, target pingTarget, kinds []string) error {
+	req := talosClientRequest{skipVerify: SkipVerify, endpoints: target.endpoints}
+
+	return withTalosClient(req, func(ctx context.Context, c *client.Client) error {
+		endpoints := target.endpoints
+		if len(endpoints) == 0 {
+			endpoints = GlobalArgs.Endpoints
+		}
+
+		snapshot, err := engine.CaptureNodeSnapshot(client.WithNodes(ctx, target.node), c, target.node, kinds, endpoints)
+		if err != nil {
+			return err //nolint:wrapcheck // the lookup error names the kind and the endpoints already.
+		}
+
+		path, err := engine.WriteNodeSnapshot(dir, snapshot)
+		if err != nil {
+			return err //nolint:wrapcheck // WriteNodeSnapshot names the file.
+		}
+
+		ui.Successf(os.Stderr, "Recorded node %s in %s", target.node, path)
+
+		return nil
+	})
+}
+
+// loadTemplateSnapshot reads the snapshot of the node a --snapshot
+// render targets. A snapshot belongs to one node, so the render must
+// target exactly one.
+func loadTemplateSnapshot(dir string, nodes []string) (*engine.NodeSnapshot, error) {
+	if len(nodes) != 1 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("--snapshot renders one node at a time, and the render targets %d", len(nodes)),
+			"give each node its own node file, or pick one with --nodes",
+		)
+	}
+
+	return engine.LoadNodeSnapshot(dir, nodes[0]) //nolint:wrapcheck // LoadNodeSnapshot attaches its own hint.
+}
+
+func init() {
+	snapshotClusterCmd.Flags().StringSliceVarP(&snapshotClusterCmdFlags.configFiles, "file", "f", nil, "node files or directories whose modelines name the nodes to record (default: every node file under nodes/)")
+	snapshotClusterCmd.Flags().StringSliceVar(&snapshotClusterCmdFlags.kinds, "kind", nil, "also record this lookup kind (can specify multiple)")
+
+	_ = snapshotClusterCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	snapshotCmd.AddCommand(snapshotClusterCmd)
+}
@@ -0,0 +1,59 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+// TestLoadTemplateSnapshot_OneNode pins that a --snapshot render
+// refuses a modeline naming several nodes: each node has its own
+// snapshot, and rendering one for the others would be wrong.
+func TestLoadTemplateSnapshot_OneNode(t *testing.T) {
+	t.Parallel()
+
+	for _, nodes := range [][]string{nil, {"192.0.2.10", "192.0.2.11"}} {
+		_, err := loadTemplateSnapshot(t.TempDir(), nodes)
+		if err == nil || !strings.Contains(err.Error(), "--snapshot renders one node at a time") {
+			t.Errorf("nodes %v: err = %v", nodes, err)
+		}
+	}
+}
+
+func TestLoadTemplateSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	if _, err := engine.WriteNodeSnapshot(dir, &engine.NodeSnapshot{Node: "192.0.2.10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := loadTemplateSnapshot(dir, []string{"192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Node != "192.0.2.10" {
+		t.Errorf("snapshot of %s, want 192.0.2.10", snapshot.Node)
+	}
+
+	if _, err := loadTemplateSnapshot(dir, []string{"192.0.2.11"}); err == nil {
+		t.Error("a node without a snapshot must fail")
+	}
+}
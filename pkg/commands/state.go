@@ -0,0 +1,560 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/state"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// applyLockName is the project-wide lock `talm apply` holds. One lock
+// per project rather than per node: side-patch chains and multi-node
+// modelines make the node set of a run unknown until rendering.
+const applyLockName = "apply"
+
+// defaultStateHistoryLimit is how many records `talm state history`
+// prints when --limit is not given.
+const defaultStateHistoryLimit = 20
+
+// openProjectState opens the state backend configured in Chart.yaml
+// for the current project root.
+func openProjectState() (state.Backend, error) {
+	return state.Open(Config.State, Config.RootDir, os.Stderr) //nolint:wrapcheck // state.Open attaches hints at its boundary.
+}
+
+// stateOperator names the operator in locks and history records: the
+// --as identity when one is used, otherwise user@host.
+func stateOperator() string {
+	if AsIdentity != "" {
+		return AsIdentity
+	}
+
+	name := "unknown"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil && host != "" {
+		name += "@" + host
+	}
+
+	return name
+}
+
+// withApplyState runs apply under the project apply lock and records
+// its outcome in the history. Dry runs change nothing on the nodes and
+// are neither locked nor recorded. skipLock runs without the lock, for
+// the case where the remote backend is unreachable and the operator
+// has made sure nobody else is applying.
+func withApplyState(file string, skipLock bool, apply func() error) error {
+	if applyCmdFlags.dryRun {
+		return apply()
+	}
+
+	backend, err := openProjectState()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	operation := "apply " + file
+
+	if !skipLock {
+		ttl, err := Config.State.LockTTLDuration()
+		if err != nil {
+			return err //nolint:wrapcheck // LockTTLDuration attaches hints at its boundary.
+		}
+
+		lock, err := state.AcquireLock(ctx, backend, applyLockName, stateOperator(), operation, ttl, time.Now())
+		if err != nil {
+			return stateLockError(err)
+		}
+
+		defer func() {
+			if err := lock.Release(ctx); err != nil {
+				ui.Warnf(os.Stderr, "releasing the apply lock in %s: %v", backend.Describe(), err)
+			}
+		}()
+
+		defer renewLock(ctx, lock, ttl, backend.Describe())()
+	}
+
+	return recordOperation(ctx, backend, state.Record{Operation: "apply", File: file}, apply)
+}
+
+// lockRenewalsPerTTL is how often a held apply lock is renewed within
+// its TTL, so one or two failed renewals still leave it live.
+const lockRenewalsPerTTL = 3
+
+// renewLock keeps lock from expiring while the operation holding it
+// runs longer than ttl, and returns the function that stops renewing.
+// A renewal that fails is a warning; a lock found taken over is not
+// renewed again.
+func renewLock(ctx context.Context, lock *state.Lock, ttl time.Duration, location string) func() {
+	if ttl/lockRenewalsPerTTL <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(ttl / lockRenewalsPerTTL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			err := lock.Renew(ctx, ttl, time.Now())
+			if err == nil {
+				continue
+			}
+
+			ui.Warnf(os.Stderr, "renewing the apply lock in %s: %v", location, err)
+
+			if errors.Is(err, state.ErrLockLost) {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// withUpgradeHistory records an upgrade in the history. Unlike apply,
+// upgrade has never depended on the state backend, so a backend that
+// cannot be opened costs the record, not the upgrade.
+func withUpgradeHistory(file, image string, upgrade func() error) error {
+	backend, err := openProjectState()
+	if err != nil {
+		ui.Warnf(os.Stderr, "the upgrade will not be recorded in the history: %v", err)
+
+		return upgrade()
+	}
+
+	return recordOperation(context.Background(), backend, state.Record{Operation: "upgrade", File: file, Image: image}, upgrade)
+}
+
+// recordOperation runs op with a fresh operation journal and appends
+// rec, completed with the timing, operator, nodes, journal and
+// outcome, to the history. A failure to record is a warning: the
+// operation has already happened on the nodes.
+func recordOperation(ctx context.Context, backend state.Backend, rec state.Record, op func() error) error {
+	journal := startOperationJournal()
+	defer stopOperationJournal(journal)
+
+	started := time.Now()
+	opErr := op()
+
+	rec.Time = time.Now().UTC()
+	rec.Duration = time.Since(started).Round(time.Millisecond)
+	rec.Operator = stateOperator()
+	rec.Nodes = append([]string(nil), GlobalArgs.Nodes...)
+	rec.Changes = journal.nodeChanges()
+
+	if opErr != nil {
+		rec.Error = opErr.Error()
+	}
+
+	if err := state.AppendHistory(ctx, backend, rec); err != nil {
+		ui.Warnf(os.Stderr, "%v", err)
+	}
+
+	return opErr
+}
+
+// operationJournal collects what a running command observes per node:
+// the Talos version the gates read, the drift preview, what the node
+// answered to the apply or upgrade and how it ended. It feeds the
+// history record, so `talm report` can describe an operation without
+// contacting the cluster again, and the --output json|yaml report,
+// which also lists the rendered files and the redacted drift itself.
+//
+// Journals nest: the report spans a whole command while each recorded
+// apply or upgrade inside it gets its own journal, and every note
+// reaches the enclosing ones as well.
+type operationJournal struct {
+	mu     sync.Mutex
+	parent *operationJournal
+	nodes  map[string]*state.NodeChange
+	order  []string
+	drift  map[string][]driftDocument
+	files  []fileReport
+}
+
+// activeJournal is the innermost journal of the command in progress;
+// nil when nothing is being recorded (dry runs and commands without
+// history, unless --output asks for a report).
+//
+//nolint:gochecknoglobals // set for the duration of one recorded operation, like GlobalArgs.
+var activeJournal struct {
+	mu      sync.Mutex
+	journal *operationJournal
+}
+
+// startOperationJournal makes a new journal, nested in the active one,
+// the active journal.
+func startOperationJournal() *operationJournal {
+	activeJournal.mu.Lock()
+	defer activeJournal.mu.Unlock()
+
+	journal := newOperationJournal(activeJournal.journal)
+	activeJournal.journal = journal
+
+	return journal
+}
+
+func newOperationJournal(parent *operationJournal) *operationJournal {
+	return &operationJournal{
+		parent: parent,
+		nodes:  map[string]*state.NodeChange{},
+		drift:  map[string][]driftDocument{},
+	}
+}
+
+// stopOperationJournal makes the journal journal was nested in the
+// active one again.
+func stopOperationJournal(journal *operationJournal) {
+	activeJournal.mu.Lock()
+	activeJournal.journal = journal.parent
+	activeJournal.mu.Unlock()
+}
+
+// currentJournal returns the active journal or nil. The journal
+// methods are no-ops on nil, so gates record unconditionally.
+func currentJournal() *operationJournal {
+	activeJournal.mu.Lock()
+	defer activeJournal.mu.Unlock()
+
+	return activeJournal.journal
+}
+
+// each runs fn on j and every journal it is nested in, holding the
+// lock of the one fn is given.
+func (j *operationJournal) each(fn func(*operationJournal)) {
+	for ; j != nil; j = j.parent {
+		j.mu.Lock()
+		fn(j)
+		j.mu.Unlock()
+	}
+}
+
+// update runs fn on the entry for node in j and every journal it is
+// nested in.
+func (j *operationJournal) update(node string, fn func(*state.NodeChange)) {
+	j.each(func(j *operationJournal) { fn(j.node(node)) })
+}
+
+// node returns the entry for node, creating it in first-seen order.
+// The caller holds j.mu.
+func (j *operationJournal) node(node string) *state.NodeChange {
+	entry, ok := j.nodes[node]
+	if !ok {
+		entry = &state.NodeChange{Node: node}
+		j.nodes[node] = entry
+		j.order = append(j.order, node)
+	}
+
+	return entry
+}
+
+// noteVersion records the Talos version read from node before or
+// after the operation. The first reading of each side wins.
+func (j *operationJournal) noteVersion(node, version string, after bool) {
+	if version == "" {
+		return
+	}
+
+	j.update(node, func(entry *state.NodeChange) {
+		switch {
+		case after && entry.VersionAfter == "":
+			entry.VersionAfter = version
+		case !after && entry.VersionBefore == "":
+			entry.VersionBefore = version
+		}
+	})
+}
+
+// noteDrift records the drift preview of node: its size, and the
+// changed documents rendered through redactor as the text preview is.
+func (j *operationJournal) noteDrift(node string, changes []applycheck.Change, redactor secretRedactor) {
+	if j == nil {
+		return
+	}
+
+	var added, removed, updated, fields int
+
+	var docs []driftDocument
+
+	for i := range changes {
+		change := &changes[i]
+
+		switch change.Op {
+		case applycheck.OpAdd:
+			added++
+		case applycheck.OpRemove:
+			removed++
+		case applycheck.OpUpdate:
+			updated++
+			fields += len(change.Fields)
+		case applycheck.OpEqual:
+			continue
+		}
+
+		doc := driftDocument{Op: driftOpName(change.Op), Kind: change.ID.Kind, Name: change.ID.Name}
+
+		for k := range change.Fields {
+			doc.Fields = append(doc.Fields, formatFieldChangeLine(&change.Fields[k], redactor))
+		}
+
+		docs = append(docs, doc)
+	}
+
+	j.each(func(j *operationJournal) {
+		entry := j.node(node)
+		entry.Previewed = true
+		entry.Added, entry.Removed, entry.Updated, entry.Fields = added, removed, updated, fields
+		j.drift[node] = docs
+	})
+}
+
+// noteApplied records what the nodes answered to an apply.
+func (j *operationJournal) noteApplied(summary applySummary) {
+	for _, applied := range summary.Nodes {
+		j.update(applied.Node, func(entry *state.NodeChange) {
+			entry.ConfigSHA256 = summary.ConfigSHA256
+			entry.Mode = applied.Mode
+			entry.Details = applied.Details
+			entry.Warnings = applied.Warnings
+		})
+	}
+}
+
+// noteUpgrade records the image nodes were upgraded to, and the error
+// the upgrade of those nodes failed with.
+func (j *operationJournal) noteUpgrade(nodes []string, image string, err error) {
+	for _, node := range nodes {
+		j.update(node, func(entry *state.NodeChange) {
+			entry.Image = image
+
+			if err != nil {
+				entry.Error = err.Error()
+			}
+		})
+	}
+}
+
+// noteResult records how the apply of node ended.
+func (j *operationJournal) noteResult(node string, err error) {
+	j.update(node, func(entry *state.NodeChange) {
+		entry.Applied = err == nil
+		entry.Error = ""
+
+		if err != nil {
+			entry.Error = err.Error()
+		}
+	})
+}
+
+// noteResumed records node as applied by the operation this one
+// resumes, so a later --resume skips it as well.
+func (j *operationJournal) noteResumed(node string) {
+	j.update(node, func(entry *state.NodeChange) {
+		entry.Applied = true
+		entry.Resumed = true
+	})
+}
+
+// appliedNodes returns the nodes whose apply completed, in first-seen
+// order; none on a nil journal.
+func (j *operationJournal) appliedNodes() []string {
+	if j == nil {
+		return nil
+	}
+
+	var nodes []string
+
+	for _, change := range j.nodeChanges() {
+		if change.Applied {
+			nodes = append(nodes, change.Node)
+		}
+	}
+
+	return nodes
+}
+
+// nodeChanges returns the journal entries in first-seen order.
+func (j *operationJournal) nodeChanges() []state.NodeChange {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	changes := make([]state.NodeChange, 0, len(j.order))
+	for _, node := range j.order {
+		changes = append(changes, *j.nodes[node])
+	}
+
+	return changes
+}
+
+// journalVersionReader wraps read so that every version it returns
+// for node is noted in the active journal.
+func journalVersionReader(read versionReader, node string, after bool) versionReader {
+	return func(ctx context.Context) (string, bool, error) {
+		version, ok, err := read(ctx)
+		if ok {
+			currentJournal().noteVersion(node, version, after)
+		}
+
+		return version, ok, err
+	}
+}
+
+// stateLockError attaches operator guidance to a failed lock
+// acquisition. A held lock and an unreachable backend need different
+// next steps.
+func stateLockError(err error) error {
+	if errors.Is(err, state.ErrLocked) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Wrap(err, "another apply is in progress"),
+			"wait for it to finish; if the holder crashed, remove the lock with `talm state unlock` or wait for state.lockTTL to expire",
+		)
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Wrap(err, "taking the apply lock"),
+		"check the state backend credentials and connectivity; pass --skip-state-lock to apply without the lock once you have made sure nobody else is applying",
+	)
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var stateHistoryCmdFlags struct {
+	limit int
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect the project state: apply history and locks",
+	Long: `talm keeps an apply history and a project-wide apply lock. By default they
+live under .talm/state in the project; configure an S3 or GCS bucket under
+"state:" in Chart.yaml to keep them out of git and share them across a team.`,
+	Args: cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var stateHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show recent applies and upgrades",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		backend, err := openProjectState()
+		if err != nil {
+			return err
+		}
+
+		records, err := state.ReadHistory(cmd.Context(), backend, stateHistoryCmdFlags.limit)
+		if err != nil {
+			return err //nolint:wrapcheck // state errors carry the key and backend location.
+		}
+
+		return printStateHistory(cmd.OutOrStdout(), records)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var stateUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove the apply lock left behind by a crashed apply",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		backend, err := openProjectState()
+		if err != nil {
+			return err
+		}
+
+		holder, err := state.ReadLock(cmd.Context(), backend, applyLockName)
+		if errors.Is(err, state.ErrNotFound) {
+			ui.Infof(os.Stderr, "No apply lock is held in %s", backend.Describe())
+
+			return nil
+		}
+
+		if err != nil {
+			return err //nolint:wrapcheck // state errors carry the key and backend location.
+		}
+
+		if err := backend.Delete(cmd.Context(), state.LockKey(applyLockName)); err != nil {
+			return err //nolint:wrapcheck // state errors carry the key and backend location.
+		}
+
+		ui.Successf(os.Stderr, "Removed the apply lock held by %s for %s since %s", holder.Operator, holder.Operation, holder.Acquired.Format(time.RFC3339))
+
+		return nil
+	},
+}
+
+func printStateHistory(w io.Writer, records []state.Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tOPERATOR\tOPERATION\tFILE\tNODES\tRESULT")
+
+	for _, rec := range records {
+		result := "ok"
+		if rec.Error != "" {
+			result = "failed: " + firstLine(rec.Error)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.ID, rec.Time.Format(time.RFC3339), rec.Operator, rec.Operation, rec.File, strings.Join(rec.Nodes, ","), result)
+	}
+
+	return errors.Wrap(tw.Flush(), "writing history table")
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+
+	return line
+}
+
+func init() {
+	stateHistoryCmd.Flags().IntVar(&stateHistoryCmdFlags.limit, "limit", defaultStateHistoryLimit, "number of most recent records to show (0 shows all)")
+
+	stateCmd.AddCommand(stateHistoryCmd, stateUnlockCmd)
+	addCommand(stateCmd)
+}
@@ -0,0 +1,303 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/state"
+)
+
+// withStateProject points Config at a fresh project root with the
+// default local backend and restores the globals afterwards.
+func withStateProject(t *testing.T) state.Backend {
+	t.Helper()
+
+	origConfig, origNodes, origDryRun := Config, GlobalArgs.Nodes, applyCmdFlags.dryRun
+
+	t.Cleanup(func() {
+		Config, GlobalArgs.Nodes, applyCmdFlags.dryRun = origConfig, origNodes, origDryRun
+	})
+
+	Config.RootDir = t.TempDir()
+	Config.State = state.Config{}
+	applyCmdFlags.dryRun = false
+
+	backend, err := openProjectState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backend
+}
+
+// TestWithApplyState_LocksAndRecords pins the apply wrapper: the lock
+// is held while apply runs and released afterwards, and both outcomes
+// land in the history with the targeted nodes.
+func TestWithApplyState_LocksAndRecords(t *testing.T) {
+	backend := withStateProject(t)
+	ctx := context.Background()
+
+	err := withApplyState("nodes/cp1.yaml", false, func() error {
+		GlobalArgs.Nodes = []string{"192.0.2.10"}
+
+		_, err := state.AcquireLock(ctx, backend, applyLockName, "bob", "apply", time.Hour, time.Now())
+		if !errors.Is(err, state.ErrLocked) {
+			t.Errorf("the apply lock must be held during apply, got %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.ReadLock(ctx, backend, applyLockName); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("the lock must be released after apply, got %v", err)
+	}
+
+	applyErr := errors.New("node rejected the config")
+	if err := withApplyState("nodes/cp2.yaml", false, func() error { return applyErr }); !errors.Is(err, applyErr) {
+		t.Fatalf("the apply error must pass through, got %v", err)
+	}
+
+	records, err := state.ReadHistory(ctx, backend, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected two history records, got %+v", records)
+	}
+
+	if records[0].File != "nodes/cp1.yaml" || records[0].Error != "" || strings.Join(records[0].Nodes, ",") != "192.0.2.10" {
+		t.Errorf("success record = %+v", records[0])
+	}
+
+	if records[1].Error != "node rejected the config" {
+		t.Errorf("failure record = %+v", records[1])
+	}
+}
+
+// TestWithApplyState_RecordsJournal pins that what the gates observe
+// during an apply (versions read, drift preview size) and the apply's
+// duration end up in its history record, and that nothing is
+// journalled once the apply is over.
+func TestWithApplyState_RecordsJournal(t *testing.T) {
+	backend := withStateProject(t)
+
+	read := func(context.Context) (string, bool, error) { return "v1.13.0", true, nil }
+
+	err := withApplyState("nodes/cp1.yaml", false, func() error {
+		GlobalArgs.Nodes = []string{"192.0.2.10", "192.0.2.11"}
+
+		for _, node := range GlobalArgs.Nodes {
+			if _, _, err := journalVersionReader(read, node, false)(context.Background()); err != nil {
+				return err
+			}
+		}
+
+		currentJournal().noteDrift("192.0.2.11", []applycheck.Change{
+			{Op: applycheck.OpUpdate, Fields: make([]applycheck.FieldChange, 3)},
+			{Op: applycheck.OpAdd},
+			{Op: applycheck.OpEqual},
+		}, secretRedactor{})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if currentJournal() != nil {
+		t.Error("the journal must be cleared after the apply")
+	}
+
+	records, err := state.ReadHistory(context.Background(), backend, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	want := []state.NodeChange{
+		{Node: "192.0.2.10", VersionBefore: "v1.13.0"},
+		{Node: "192.0.2.11", VersionBefore: "v1.13.0", Previewed: true, Added: 1, Updated: 1, Fields: 3},
+	}
+	if !reflect.DeepEqual(records[0].Changes, want) {
+		t.Errorf("changes = %+v\nwant %+v", records[0].Changes, want)
+	}
+
+	if records[0].Operation != "apply" || records[0].Started().After(records[0].Time) {
+		t.Errorf("record = %+v", records[0])
+	}
+}
+
+// TestWithUpgradeHistory pins that an upgrade is recorded with its
+// target image and the versions read before and after, and that the
+// upgrade error passes through.
+func TestWithUpgradeHistory(t *testing.T) {
+	backend := withStateProject(t)
+
+	upgradeErr := errors.New("rolled back")
+
+	err := withUpgradeHistory("nodes/cp1.yaml", "ghcr.io/siderolabs/installer:v1.13.1", func() error {
+		GlobalArgs.Nodes = []string{"192.0.2.10"}
+
+		currentJournal().noteVersion("192.0.2.10", "v1.13.0", false)
+		currentJournal().noteVersion("192.0.2.10", "v1.13.0", true)
+		currentJournal().noteVersion("192.0.2.10", "v1.13.1", true)
+
+		return upgradeErr
+	})
+	if !errors.Is(err, upgradeErr) {
+		t.Fatalf("the upgrade error must pass through, got %v", err)
+	}
+
+	records, err := state.ReadHistory(context.Background(), backend, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	rec := records[0]
+	if rec.Operation != "upgrade" || rec.Image != "ghcr.io/siderolabs/installer:v1.13.1" || rec.Error != "rolled back" {
+		t.Errorf("record = %+v", rec)
+	}
+
+	want := []state.NodeChange{{Node: "192.0.2.10", VersionBefore: "v1.13.0", VersionAfter: "v1.13.0"}}
+	if !reflect.DeepEqual(rec.Changes, want) {
+		t.Errorf("changes = %+v, want the first reading of each side", rec.Changes)
+	}
+}
+
+// TestOperationJournal_NilIsNoop pins that gates running outside a
+// recorded operation (dry runs, talm template) can note freely.
+func TestOperationJournal_NilIsNoop(t *testing.T) {
+	t.Parallel()
+
+	var journal *operationJournal
+
+	journal.noteVersion("192.0.2.10", "v1.13.0", false)
+	journal.noteDrift("192.0.2.10", nil, secretRedactor{})
+	journal.noteApplied(applySummary{Nodes: []appliedNode{{Node: "192.0.2.10"}}})
+	journal.noteUpgrade([]string{"192.0.2.10"}, "image", nil)
+	journal.noteResult("192.0.2.10", nil)
+	journal.noteFile("nodes/cp1.yaml", nil, nodeFileFormatYAML, "", false, nil)
+	journal.noteWrittenFile("nodes/cp1.yaml", "rendered/nodes/cp1.yaml", nil, nodeFileFormatYAML, "")
+}
+
+// TestWithApplyState_HeldLockBlocks pins that a second operator is
+// turned away with a hint toward `talm state unlock`, and that apply
+// does not run.
+func TestWithApplyState_HeldLockBlocks(t *testing.T) {
+	backend := withStateProject(t)
+
+	if _, err := state.AcquireLock(context.Background(), backend, applyLockName, "alice", "apply nodes/cp1.yaml", time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := false
+
+	err := withApplyState("nodes/cp2.yaml", false, func() error {
+		ran = true
+
+		return nil
+	})
+	if !errors.Is(err, state.ErrLocked) || ran {
+		t.Fatalf("expected ErrLocked without running apply, got %v (ran=%v)", err, ran)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "talm state unlock") {
+		t.Errorf("hint must name talm state unlock, got %q", hints)
+	}
+
+	if err := withApplyState("nodes/cp2.yaml", true, func() error { return nil }); err != nil {
+		t.Errorf("--skip-state-lock must bypass the lock, got %v", err)
+	}
+}
+
+// TestWithApplyState_RenewsLock pins that an apply running past
+// state.lockTTL keeps its lock: another operator is still turned away
+// after the TTL the lock was taken with has run out.
+func TestWithApplyState_RenewsLock(t *testing.T) {
+	backend := withStateProject(t)
+	Config.State.LockTTL = "300ms"
+
+	err := withApplyState("nodes/cp1.yaml", false, func() error {
+		time.Sleep(time.Second)
+
+		_, err := state.AcquireLock(context.Background(), backend, applyLockName, "bob", "apply", time.Hour, time.Now())
+		if !errors.Is(err, state.ErrLocked) {
+			t.Errorf("a lock past its original TTL must still be held, got %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.ReadLock(context.Background(), backend, applyLockName); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("the renewed lock must be released after apply, got %v", err)
+	}
+}
+
+// TestWithApplyState_DryRunUntracked pins that a dry run is neither
+// locked nor recorded.
+func TestWithApplyState_DryRunUntracked(t *testing.T) {
+	backend := withStateProject(t)
+	applyCmdFlags.dryRun = true
+
+	if err := withApplyState("nodes/cp1.yaml", false, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := backend.List(context.Background(), ""); err != nil || len(keys) != 0 {
+		t.Errorf("dry run left state behind: %v, %v", keys, err)
+	}
+}
+
+func TestPrintStateHistory(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	err := printStateHistory(&out, []state.Record{{
+		Time:     time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC),
+		Operator: "alice",
+		File:     "nodes/cp1.yaml",
+		Nodes:    []string{"192.0.2.10", "192.0.2.11"},
+		Error:    "applying new configuration: rpc error\nmore detail",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"2026-05-01T12:00:00Z", "alice", "192.0.2.10,192.0.2.11", "failed: applying new configuration: rpc error"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "more detail") {
+		t.Errorf("only the first line of an error belongs in the table:\n%s", out.String())
+	}
+}
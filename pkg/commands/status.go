@@ -0,0 +1,394 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/cosi-project/runtime/pkg/state"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/resources/config"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+// The states of a node in the status report.
+const (
+	nodeStateInSync      = "in-sync"
+	nodeStateDrifted     = "drifted"
+	nodeStateNeedsReboot = "needs-reboot"
+	nodeStateUnreachable = "unreachable"
+	nodeStateError       = "error"
+)
+
+// statusSectionDepth is how many leading segments of a changed field
+// path name its section: machine.network, cluster.proxy.
+const statusSectionDepth = 2
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var statusCmdFlags struct {
+	configFiles []string
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report which nodes run the config their node files render",
+	Long: `Render every node file under nodes/, as talm diff does, read the
+MachineConfig each node of its modeline runs, and report one state per
+node:
+
+  in-sync       the node runs the rendered config
+  drifted       the node runs another config; the changed sections are listed
+  needs-reboot  the node staged the rendered config and applies it on reboot
+  unreachable   the node could not be read through the Talos API
+  error         the node file could not be rendered
+
+The comparison is structural, like talm diff; run talm diff -f on a
+drifted node file for the changed fields. Node files without templates
+are patches and are skipped. --output json or yaml prints the report for
+tooling, in place of the table.`,
+	Example: `  talm status
+  talm status -f nodes/node1.yaml -f nodes/node2.yaml
+  talm status --output json`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if len(statusCmdFlags.configFiles) > 0 {
+			return DetectAndSetRootFromFiles(statusCmdFlags.configFiles)
+		}
+
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		files, err := statusNodeFiles(statusCmdFlags.configFiles, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		if len(files) == 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("no node files under %s", filepath.Join(Config.RootDir, nodesDirName)),
+				"write one with talm template -I, talm attach or talm add-node, or pass -f",
+			)
+		}
+
+		var report statusReport
+
+		for _, file := range files {
+			report.Nodes = append(report.Nodes, statusFile(file, os.Stderr)...)
+		}
+
+		if structuredOutput() {
+			return writeStructuredOutput(cmd.OutOrStdout(), report)
+		}
+
+		writeStatusTable(cmd.OutOrStdout(), report.Nodes)
+
+		return nil
+	},
+}
+
+// statusReport is the document --output json|yaml prints.
+type statusReport struct {
+	Nodes []nodeStatus `json:"nodes"`
+}
+
+// nodeStatus is the state of one node of a node file.
+type nodeStatus struct {
+	File  string `json:"file"`
+	Node  string `json:"node"`
+	State string `json:"state"`
+	// Sections are the config sections a drifted node, or one that
+	// needs a reboot, has to change to run the render.
+	Sections []string `json:"sections,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// statusNodeFiles returns the node files to report on: files when
+// given, otherwise the node files under nodes/ that carry a modeline.
+func statusNodeFiles(files []string, progress io.Writer) ([]string, error) {
+	if len(files) > 0 {
+		return files, nil
+	}
+
+	nodeFiles, skipped, err := scanPruneNodeFiles(Config.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range skipped {
+		fmt.Fprintf(progress, "Skipping %s: no talm modeline\n", path)
+	}
+
+	paths := make([]string, 0, len(nodeFiles))
+	for _, file := range nodeFiles {
+		paths = append(paths, filepath.Join(Config.RootDir, file.path))
+	}
+
+	return paths, nil
+}
+
+// statusFile reports the nodes of the modeline of file. The render
+// and the client use the nodes and endpoints of the modeline, as talm
+// diff does; GlobalArgs is restored afterwards for the next file.
+func statusFile(file string, progress io.Writer) []nodeStatus {
+	display := statusDisplayPath(file)
+
+	_, modelineConfig, err := modeline.FindAndParseModeline(file)
+	if err != nil {
+		return []nodeStatus{{File: display, State: nodeStateError, Error: errors.Wrap(err, "parsing modeline").Error()}}
+	}
+
+	if modelineConfig == nil || len(modelineConfig.Templates) == 0 {
+		fmt.Fprintf(progress, "Skipping %s: the modeline names no templates\n", display)
+
+		return nil
+	}
+
+	if len(modelineConfig.Nodes) == 0 {
+		return []nodeStatus{{File: display, State: nodeStateError, Error: "the modeline names no nodes"}}
+	}
+
+	savedNodes, savedEndpoints := GlobalArgs.Nodes, GlobalArgs.Endpoints
+	defer func() { GlobalArgs.Nodes, GlobalArgs.Endpoints = savedNodes, savedEndpoints }()
+
+	GlobalArgs.Nodes = modelineConfig.Nodes
+	GlobalArgs.Endpoints = modelineConfig.Endpoints
+
+	if len(GlobalArgs.Endpoints) == 0 {
+		GlobalArgs.Endpoints = []string{defaultLocalEndpoint}
+	}
+
+	opts := diffRenderOptions(modelineConfig.Templates, "")
+	opts.Role = modelineConfig.Role
+
+	statuses := make([]nodeStatus, 0, len(modelineConfig.Nodes))
+
+	err = WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		for _, node := range modelineConfig.Nodes {
+			status := statusNode(ctx, c, opts, file, node)
+			status.File = display
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+	if err != nil {
+		for _, node := range modelineConfig.Nodes[len(statuses):] {
+			statuses = append(statuses, nodeStatus{File: display, Node: node, State: nodeStateUnreachable, Error: err.Error()})
+		}
+	}
+
+	return statuses
+}
+
+// statusNode reads the active and the staged MachineConfig of node and
+// compares them with the render of file. A node whose config cannot be
+// read is unreachable; a render that fails is an error of the node
+// file.
+//
+//nolint:gocritic // hugeParam: engine.Options is passed by value like engine.Render takes it.
+func statusNode(ctx context.Context, c *client.Client, opts engine.Options, file, node string) nodeStatus {
+	status := nodeStatus{Node: node}
+
+	nodeCtx := client.WithNode(ctx, node)
+
+	active, err := readNodeMachineConfig(nodeCtx, c, config.ActiveID)
+	if err != nil {
+		status.State, status.Error = nodeStateUnreachable, err.Error()
+
+		return status
+	}
+
+	persistent, err := readNodeMachineConfig(nodeCtx, c, config.PersistentID)
+	if err != nil {
+		status.State, status.Error = nodeStateUnreachable, err.Error()
+
+		return status
+	}
+
+	desired, err := renderNodeConfig(ctx, c, opts, file, node)
+	if err != nil {
+		status.State, status.Error = nodeStateError, err.Error()
+
+		return status
+	}
+
+	status.State, status.Sections, err = classifyNodeState(desired, active, persistent)
+	if err != nil {
+		status.State, status.Error = nodeStateError, err.Error()
+	}
+
+	return status
+}
+
+// readNodeMachineConfig reads the MachineConfig of the node of ctx by
+// id. A node without a persistent config yet yields nil.
+func readNodeMachineConfig(ctx context.Context, c *client.Client, id resource.ID) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, preflightCOSIReadTimeout)
+	defer cancel()
+
+	res, err := safe.StateGetByID[*config.MachineConfig](ctx, c.COSI, id)
+	if err != nil {
+		if state.IsNotFoundError(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "reading the %s MachineConfig", id)
+	}
+
+	data, err := res.Provider().Bytes()
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshaling the %s MachineConfig", id)
+	}
+
+	return data, nil
+}
+
+// classifyNodeState compares the desired config of a node with the
+// config it runs, active, and the one it boots with next, persistent.
+// A node that runs another config but staged the desired one needs a
+// reboot; one that staged nothing, or something else, drifted. A nil
+// persistent config is the active one.
+func classifyNodeState(desired, active, persistent []byte) (string, []string, error) {
+	changes, err := applycheck.Diff(active, desired)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "comparing the running config")
+	}
+
+	changed := applycheck.FilterChanged(changes)
+	if len(changed) == 0 {
+		return nodeStateInSync, nil, nil
+	}
+
+	sections := driftSections(changed)
+
+	if persistent == nil {
+		return nodeStateDrifted, sections, nil
+	}
+
+	staged, err := applycheck.Diff(persistent, desired)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "comparing the staged config")
+	}
+
+	if len(applycheck.FilterChanged(staged)) == 0 {
+		return nodeStateNeedsReboot, sections, nil
+	}
+
+	return nodeStateDrifted, sections, nil
+}
+
+// driftSections summarizes changes by section: an added or removed
+// document by its kind and name, a changed one by the leading segments
+// of its changed fields.
+func driftSections(changes []applycheck.Change) []string {
+	var sections []string
+
+	for i := range changes {
+		change := &changes[i]
+
+		document := change.ID.Kind
+		if change.ID.Name != "" {
+			document += "/" + change.ID.Name
+		}
+
+		switch change.Op {
+		case applycheck.OpEqual:
+		case applycheck.OpAdd:
+			sections = append(sections, document+" (added)")
+		case applycheck.OpRemove:
+			sections = append(sections, document+" (removed)")
+		case applycheck.OpUpdate:
+			var fields []string
+
+			for _, field := range change.Fields {
+				segments := strings.SplitN(field.Path, ".", statusSectionDepth+1)
+				section := strings.Join(segments[:min(len(segments), statusSectionDepth)], ".")
+
+				if !slices.Contains(fields, section) {
+					fields = append(fields, section)
+				}
+			}
+
+			if len(fields) == 0 {
+				sections = append(sections, document)
+
+				continue
+			}
+
+			sections = append(sections, document+": "+strings.Join(fields, ", "))
+		}
+	}
+
+	return sections
+}
+
+// writeStatusTable prints the report as a table, one row per node.
+func writeStatusTable(out io.Writer, nodes []nodeStatus) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tNODE\tSTATE\tDETAILS")
+
+	for _, node := range nodes {
+		details := node.Error
+		if details == "" {
+			details = strings.Join(node.Sections, "; ")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", node.File, node.Node, node.State, scanCell(details))
+	}
+
+	_ = w.Flush()
+}
+
+// statusDisplayPath names a node file relative to the project root
+// when it lies inside it, as the report shows it.
+func statusDisplayPath(file string) string {
+	rel, err := filepath.Rel(Config.RootDir, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+
+	return rel
+}
+
+func init() {
+	statusCmd.Flags().StringSliceVarP(&statusCmdFlags.configFiles, "file", "f", nil, "node files to report on (default: every node file under nodes/)")
+
+	_ = statusCmd.RegisterFlagCompletionFunc("file", completeNodeFiles)
+
+	addCommand(statusCmd)
+}
@@ -0,0 +1,225 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+)
+
+const (
+	statusTestConfig = `version: v1alpha1
+machine:
+  type: worker
+  network:
+    hostname: node1
+  install:
+    disk: /dev/sda
+`
+	statusTestDrifted = `version: v1alpha1
+machine:
+  type: worker
+  network:
+    hostname: node2
+  install:
+    disk: /dev/sdb
+`
+	statusTestHostname = `apiVersion: v1alpha1
+kind: HostnameConfig
+hostname: node1
+`
+)
+
+// TestClassifyNodeState pins the state of a node by how its active and
+// staged configs compare with the render.
+func TestClassifyNodeState(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		active     string
+		persistent string
+		want       string
+		sections   string
+	}{
+		{name: "in sync", active: statusTestConfig, persistent: statusTestConfig, want: nodeStateInSync},
+		{name: "in sync without a staged config", active: statusTestConfig, want: nodeStateInSync},
+		{
+			name: "drifted", active: statusTestDrifted, persistent: statusTestDrifted,
+			want: nodeStateDrifted, sections: "MachineConfig: machine.install, machine.network",
+		},
+		{
+			name: "drifted without a staged config", active: statusTestDrifted,
+			want: nodeStateDrifted, sections: "MachineConfig: machine.install, machine.network",
+		},
+		{
+			name: "staged", active: statusTestDrifted, persistent: statusTestConfig,
+			want: nodeStateNeedsReboot, sections: "MachineConfig: machine.install, machine.network",
+		},
+		{
+			name: "staged something else", active: statusTestDrifted, persistent: statusTestDrifted + "  certSANs: [a]\n",
+			want: nodeStateDrifted, sections: "MachineConfig: machine.install, machine.network",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var persistent []byte
+			if tc.persistent != "" {
+				persistent = []byte(tc.persistent)
+			}
+
+			state, sections, err := classifyNodeState([]byte(statusTestConfig), []byte(tc.active), persistent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if state != tc.want {
+				t.Errorf("state = %s, want %s", state, tc.want)
+			}
+
+			if got := strings.Join(sections, "; "); got != tc.sections {
+				t.Errorf("sections = %q, want %q", got, tc.sections)
+			}
+		})
+	}
+}
+
+// TestDriftSections pins the summary of the changes: added and removed
+// documents by name, changed ones by the sections of their fields.
+func TestDriftSections(t *testing.T) {
+	t.Parallel()
+
+	changes, err := applycheck.Diff(
+		[]byte(statusTestDrifted),
+		[]byte(statusTestConfig+"---\n"+statusTestHostname),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(driftSections(changes), "; ")
+	want := "HostnameConfig (added); MachineConfig: machine.install, machine.network"
+
+	if got != want {
+		t.Errorf("sections = %q, want %q", got, want)
+	}
+
+	removed := driftSections([]applycheck.Change{{ID: applycheck.DocID{Kind: "LinkConfig", Name: "eth0"}, Op: applycheck.OpRemove}})
+	if strings.Join(removed, "") != "LinkConfig/eth0 (removed)" {
+		t.Errorf("removed = %v", removed)
+	}
+}
+
+// TestWriteStatusTable pins the table: the sections of a drifted node,
+// the error of an unreachable one.
+func TestWriteStatusTable(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	writeStatusTable(&buf, []nodeStatus{
+		{File: "nodes/node1.yaml", Node: "192.0.2.11", State: nodeStateInSync},
+		{File: "nodes/node2.yaml", Node: "192.0.2.12", State: nodeStateDrifted, Sections: []string{"MachineConfig: machine.network", "HostnameConfig (added)"}},
+		{File: "nodes/node3.yaml", Node: "192.0.2.13", State: nodeStateUnreachable, Error: "connection refused"},
+	})
+
+	for _, want := range []string{
+		"FILE",
+		"nodes/node1.yaml  192.0.2.11  in-sync",
+		"drifted      MachineConfig: machine.network; HostnameConfig (added)",
+		"unreachable  connection refused",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+// TestStatusFile_ModelineOnly pins the node files reported without a
+// connection: a patch is skipped, a modeline without nodes is an error.
+func TestStatusFile_ModelineOnly(t *testing.T) {
+	dir := t.TempDir()
+	setRoot(t, dir)
+
+	patch := filepath.Join(dir, "patch.yaml")
+	if err := os.WriteFile(patch, []byte("# talm: nodes=[\"192.0.2.11\"]\nmachine: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+	if statuses := statusFile(patch, &progress); len(statuses) != 0 {
+		t.Errorf("a patch was reported: %+v", statuses)
+	}
+
+	if !strings.Contains(progress.String(), "Skipping patch.yaml") {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	noNodes := filepath.Join(dir, "no-nodes.yaml")
+	if err := os.WriteFile(noNodes, []byte("# talm: templates=[\"templates/worker.yaml\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := statusFile(noNodes, &progress)
+	if len(statuses) != 1 || statuses[0].State != nodeStateError || statuses[0].File != "no-nodes.yaml" {
+		t.Errorf("statuses = %+v, want one error of no-nodes.yaml", statuses)
+	}
+}
+
+// TestStatusNodeFiles pins that without -f every node file with a
+// modeline is reported, and the others are named as skipped.
+func TestStatusNodeFiles(t *testing.T) {
+	dir := t.TempDir()
+	setRoot(t, dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "nodes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"node1.yaml": "# talm: nodes=[\"192.0.2.11\"], templates=[\"templates/worker.yaml\"]\n",
+		"notes.yaml": "machine: {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "nodes", name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var progress bytes.Buffer
+
+	files, err := statusNodeFiles(nil, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0] != filepath.Join(dir, "nodes", "node1.yaml") {
+		t.Errorf("files = %v", files)
+	}
+
+	if !strings.Contains(progress.String(), filepath.Join("nodes", "notes.yaml")) {
+		t.Errorf("progress = %q, want the skipped notes.yaml", progress.String())
+	}
+
+	files, err = statusNodeFiles([]string{"a.yaml"}, &progress)
+	if err != nil || len(files) != 1 || files[0] != "a.yaml" {
+		t.Errorf("-f files = %v, err = %v", files, err)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/state"
+)
+
+// The values of the global --output flag.
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+	outputFormatYAML = "yaml"
+)
+
+// The statuses of a rendered file in the structured template report.
+const (
+	renderStatusRendered = "rendered"
+	renderStatusUpdated  = "updated"
+	renderStatusWritten  = "written"
+	renderStatusFailed   = "failed"
+)
+
+// OutputFormat is the value of the global --output flag: text, the
+// default, or json or yaml for the commands that report structured
+// results on stdout. A command with an --output flag of its own
+// (certs, healthcheck, rotate-ca, the talosctl get family) shadows it.
+//
+//nolint:gochecknoglobals // bound to a persistent root flag, like GlobalArgs.
+var OutputFormat = outputFormatText
+
+// ValidateOutputFormat rejects an unknown --output value before the
+// command runs, so a typo does not surface after an apply went through.
+func ValidateOutputFormat() error {
+	switch OutputFormat {
+	case outputFormatText, outputFormatJSON, outputFormatYAML:
+		return nil
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("unknown output format %q", OutputFormat),
+			"use --output %s, %s or %s", outputFormatText, outputFormatJSON, outputFormatYAML,
+		)
+	}
+}
+
+// CompleteOutputFormat completes the values of the global --output flag.
+func CompleteOutputFormat(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return []string{outputFormatText, outputFormatJSON, outputFormatYAML}, cobra.ShellCompDirectiveNoFileComp
+}
+
+// structuredOutput reports whether --output asks for a JSON or YAML
+// report instead of the text output.
+func structuredOutput() bool {
+	return OutputFormat == outputFormatJSON || OutputFormat == outputFormatYAML
+}
+
+// writeStructuredOutput encodes v to w in the --output format. YAML is
+// produced from the JSON form, so both carry the same field names.
+func writeStructuredOutput(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding the output")
+	}
+
+	if OutputFormat == outputFormatYAML {
+		data, err = yaml.JSONToYAML(data)
+		if err != nil {
+			return errors.Wrap(err, "encoding the output")
+		}
+	} else {
+		data = append(data, '\n')
+	}
+
+	_, err = w.Write(data)
+
+	return errors.Wrap(err, "writing the output")
+}
+
+// stdoutToStderr points os.Stdout at stderr while --output asks for a
+// report, and returns the function that restores it. The wrapped
+// talosctl commands print straight to os.Stdout, which would otherwise
+// interleave with the report.
+func stdoutToStderr() func() {
+	if !structuredOutput() {
+		return func() {}
+	}
+
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	return func() { os.Stdout = stdout }
+}
+
+// outputReport is the document --output json|yaml prints once a
+// command finishes: what happened per rendered file and per node.
+// Error carries the failure the command exits with, so a consumer
+// reading stdout alone still sees that the run failed.
+type outputReport struct {
+	Command string       `json:"command"`
+	DryRun  bool         `json:"dryRun,omitempty"`
+	Files   []fileReport `json:"files,omitempty"`
+	Nodes   []nodeReport `json:"nodes,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// fileReport is the render of one node file. Config is the rendered
+// config when it was written to stdout rather than over the file, with
+// the same redaction the text output applies. Output is the file
+// --output-dir wrote it to.
+type fileReport struct {
+	File         string   `json:"file,omitempty"`
+	Output       string   `json:"output,omitempty"`
+	Nodes        []string `json:"nodes,omitempty"`
+	Status       string   `json:"status"`
+	Format       string   `json:"format,omitempty"`
+	ConfigSHA256 string   `json:"configSHA256,omitempty"`
+	Config       string   `json:"config,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// nodeReport is what a command did on one node: the journal entry the
+// history keeps, plus the drift previewed before the apply, which the
+// history leaves out.
+type nodeReport struct {
+	state.NodeChange
+
+	Drift []driftDocument `json:"drift,omitempty"`
+}
+
+// driftDocument is one changed document of a drift preview. Fields
+// are the lines the text preview prints, secrets redacted the same way.
+type driftDocument struct {
+	Op     string   `json:"op"`
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// withOutputReport runs run and, with --output json or yaml, records
+// it in an operation journal and writes the report built from the
+// journal to w afterwards, failed runs included. With --output text it
+// only runs run.
+func withOutputReport(w io.Writer, command string, dryRun bool, run func() error) error {
+	if !structuredOutput() {
+		return run()
+	}
+
+	journal := startOperationJournal()
+	runErr := func() error {
+		defer stopOperationJournal(journal)
+
+		return run()
+	}()
+
+	report := journal.report(command, dryRun)
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+
+	if err := writeStructuredOutput(w, report); err != nil && runErr == nil {
+		return err
+	}
+
+	return runErr
+}
+
+// report builds the --output document of command from the journal. A
+// dry run applies nothing, so no node is reported as applied.
+func (j *operationJournal) report(command string, dryRun bool) outputReport {
+	report := outputReport{Command: command, DryRun: dryRun}
+
+	j.mu.Lock()
+	report.Files = slices.Clone(j.files)
+	drift := maps.Clone(j.drift)
+	j.mu.Unlock()
+
+	for _, change := range j.nodeChanges() {
+		if dryRun {
+			change.Applied = false
+		}
+
+		report.Nodes = append(report.Nodes, nodeReport{NodeChange: change, Drift: drift[change.Node]})
+	}
+
+	return report
+}
+
+// noteFile records the render of one node file. The rendered config
+// is identified by its SHA-256 and carried in full unless inPlace
+// wrote it over the file.
+func (j *operationJournal) noteFile(file string, nodes []string, format, config string, inPlace bool, err error) {
+	entry := fileReport{File: file, Nodes: slices.Clone(nodes), Format: format}
+
+	switch {
+	case err != nil:
+		entry.Status = renderStatusFailed
+		entry.Error = err.Error()
+	case inPlace:
+		entry.Status = renderStatusUpdated
+	default:
+		entry.Status = renderStatusRendered
+		entry.Config = config
+	}
+
+	if config != "" {
+		sum := sha256.Sum256([]byte(config))
+		entry.ConfigSHA256 = hex.EncodeToString(sum[:])
+	}
+
+	j.each(func(j *operationJournal) { j.files = append(j.files, entry) })
+}
+
+// noteWrittenFile records the render of one node file that
+// --output-dir wrote to output. Like an in-place render, the config is
+// identified by its SHA-256 only.
+func (j *operationJournal) noteWrittenFile(file, output string, nodes []string, format, config string) {
+	sum := sha256.Sum256([]byte(config))
+
+	entry := fileReport{
+		File:         file,
+		Output:       output,
+		Nodes:        slices.Clone(nodes),
+		Status:       renderStatusWritten,
+		Format:       format,
+		ConfigSHA256: hex.EncodeToString(sum[:]),
+	}
+
+	j.each(func(j *operationJournal) { j.files = append(j.files, entry) })
+}
+
+// driftOpName spells a changed document's op as a word.
+func driftOpName(op applycheck.ChangeOp) string {
+	switch op {
+	case applycheck.OpAdd:
+		return "add"
+	case applycheck.OpRemove:
+		return "remove"
+	case applycheck.OpUpdate:
+		return "update"
+	case applycheck.OpEqual:
+	}
+
+	return "equal"
+}
@@ -0,0 +1,347 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cozystack/talm/pkg/applycheck"
+	"github.com/cozystack/talm/pkg/state"
+)
+
+// withOutputFormat sets --output for the duration of the test.
+func withOutputFormat(t *testing.T, format string) {
+	t.Helper()
+
+	orig := OutputFormat
+	t.Cleanup(func() { OutputFormat = orig })
+
+	OutputFormat = format
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	for _, format := range []string{outputFormatText, outputFormatJSON, outputFormatYAML} {
+		withOutputFormat(t, format)
+
+		if err := ValidateOutputFormat(); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+
+	withOutputFormat(t, "xml")
+
+	err := ValidateOutputFormat()
+	if err == nil || !strings.Contains(err.Error(), `unknown output format "xml"`) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "use --output text, json or yaml") {
+		t.Errorf("hint = %q", hints)
+	}
+}
+
+// TestWithOutputReport_Text pins that the default text output runs the
+// command as before and prints no report.
+func TestWithOutputReport_Text(t *testing.T) {
+	withOutputFormat(t, outputFormatText)
+
+	var out bytes.Buffer
+
+	err := withOutputReport(&out, "apply", false, func() error {
+		if currentJournal() != nil {
+			t.Error("text output must not collect a report")
+		}
+
+		return nil
+	})
+	if err != nil || out.Len() != 0 {
+		t.Errorf("err = %v, output = %q", err, out.String())
+	}
+}
+
+func TestWithOutputReport_JSON(t *testing.T) {
+	withOutputFormat(t, outputFormatJSON)
+
+	var out bytes.Buffer
+
+	err := withOutputReport(&out, "apply", true, func() error {
+		journal := currentJournal()
+		journal.noteDrift("192.0.2.10", []applycheck.Change{
+			{ID: applycheck.DocID{Kind: "MachineConfig"}, Op: applycheck.OpEqual},
+			{ID: applycheck.DocID{Kind: "LinkConfig", Name: "eth1"}, Op: applycheck.OpAdd},
+			{ID: applycheck.DocID{Kind: "HostnameConfig"}, Op: applycheck.OpUpdate, Fields: []applycheck.FieldChange{
+				{Path: "hostname", Old: "old", New: "new", HasOld: true, HasNew: true},
+			}},
+		}, secretRedactor{})
+		journal.noteApplied(applySummary{ConfigSHA256: "abc", Nodes: []appliedNode{
+			{Node: "192.0.2.10", Mode: "no-reboot", Warnings: []string{"cluster.proxy is deprecated"}},
+			{Node: "192.0.2.11", Mode: "reboot"},
+		}})
+		journal.noteResult("192.0.2.11", nil)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got outputReport
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+
+	if got.Command != "apply" || !got.DryRun || got.Error != "" || len(got.Nodes) != 2 {
+		t.Fatalf("report = %+v", &got)
+	}
+
+	first := got.Nodes[0]
+	if first.Node != "192.0.2.10" || first.Mode != "no-reboot" || first.ConfigSHA256 != "abc" || len(first.Warnings) != 1 {
+		t.Errorf("first node = %+v", first)
+	}
+
+	if !first.Previewed || first.Added != 1 || first.Updated != 1 || first.Fields != 1 {
+		t.Errorf("first node drift counts = %+v", first.NodeChange)
+	}
+
+	wantDrift := []driftDocument{
+		{Op: "add", Kind: "LinkConfig", Name: "eth1"},
+		{Op: "update", Kind: "HostnameConfig", Fields: []string{"hostname: old -> new"}},
+	}
+
+	driftJSON, _ := json.Marshal(first.Drift)
+	wantJSON, _ := json.Marshal(wantDrift)
+
+	if !bytes.Equal(driftJSON, wantJSON) {
+		t.Errorf("drift = %s, want %s", driftJSON, wantJSON)
+	}
+
+	if second := got.Nodes[1]; second.Mode != "reboot" || second.Error != "" || second.Applied || len(second.Drift) != 0 {
+		t.Errorf("second node = %+v, a dry run must not report it applied", second)
+	}
+
+	if currentJournal() != nil {
+		t.Error("the report must not outlive the command")
+	}
+}
+
+// TestWithOutputReport_RecordedApply pins that an apply recorded in
+// the history inside a report feeds both from the same notes: the
+// history keeps the counts, the report the drift itself as well, and
+// the report journal is the active one again once the apply is over.
+func TestWithOutputReport_RecordedApply(t *testing.T) {
+	withOutputFormat(t, outputFormatJSON)
+
+	backend := withStateProject(t)
+
+	var out bytes.Buffer
+
+	err := withOutputReport(&out, "apply", false, func() error {
+		report := currentJournal()
+
+		err := withApplyState("nodes/cp1.yaml", false, func() error {
+			GlobalArgs.Nodes = []string{"192.0.2.10"}
+
+			currentJournal().noteDrift("192.0.2.10", []applycheck.Change{
+				{ID: applycheck.DocID{Kind: "LinkConfig", Name: "eth1"}, Op: applycheck.OpAdd},
+			}, secretRedactor{})
+			currentJournal().noteResult("192.0.2.10", nil)
+
+			return nil
+		})
+
+		if currentJournal() != report {
+			t.Error("the report journal must be active again after the apply")
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := state.ReadHistory(context.Background(), backend, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	want := []state.NodeChange{{Node: "192.0.2.10", Previewed: true, Added: 1, Applied: true}}
+	if !reflect.DeepEqual(records[0].Changes, want) {
+		t.Errorf("changes = %+v\nwant %+v", records[0].Changes, want)
+	}
+
+	var got outputReport
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+
+	if len(got.Nodes) != 1 || !reflect.DeepEqual(got.Nodes[0].NodeChange, want[0]) || len(got.Nodes[0].Drift) != 1 {
+		t.Errorf("nodes = %+v", got.Nodes)
+	}
+}
+
+// TestWithOutputReport_Failed pins that a failed run still prints its
+// report, with the error, and returns the error unchanged.
+func TestWithOutputReport_Failed(t *testing.T) {
+	withOutputFormat(t, outputFormatYAML)
+
+	var out bytes.Buffer
+
+	runErr := errors.New("node 192.0.2.11: connection refused")
+
+	err := withOutputReport(&out, "template", false, func() error {
+		journal := currentJournal()
+		journal.noteFile("nodes/cp1.yaml", []string{"192.0.2.10"}, nodeFileFormatYAML, "machine: {}\n", false, nil)
+		journal.noteFile("nodes/cp2.yaml", []string{"192.0.2.11"}, nodeFileFormatYAML, "machine: {}\n", true, nil)
+		journal.noteFile("nodes/cp3.yaml", nil, nodeFileFormatYAML, "", false, runErr)
+
+		return runErr
+	})
+	if !errors.Is(err, runErr) {
+		t.Fatalf("err = %v", err)
+	}
+
+	var got outputReport
+	if err := yaml.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not YAML: %v\n%s", err, out.String())
+	}
+
+	if got.Command != "template" || got.Error != runErr.Error() || len(got.Files) != 3 {
+		t.Fatalf("report = %+v", &got)
+	}
+
+	// sha256 of "machine: {}\n".
+	const sum = "2a5c1276ba1c0a483d83c671b8276645063d9bdb257415a6b259223fffdeb619"
+
+	rendered, updated, failed := got.Files[0], got.Files[1], got.Files[2]
+	if rendered.Status != renderStatusRendered || rendered.Config != "machine: {}\n" || rendered.ConfigSHA256 != sum || rendered.Format != nodeFileFormatYAML {
+		t.Errorf("rendered = %+v", rendered)
+	}
+
+	if updated.Status != renderStatusUpdated || updated.Config != "" || updated.ConfigSHA256 != sum {
+		t.Errorf("an in-place render is reported without the config it wrote: %+v", updated)
+	}
+
+	if failed.Status != renderStatusFailed || failed.Error != runErr.Error() || failed.ConfigSHA256 != "" {
+		t.Errorf("failed = %+v", failed)
+	}
+}
+
+// TestOperationJournal_NoteDriftRedacts pins that the report carries
+// no more of a secret than the text drift preview does.
+func TestOperationJournal_NoteDriftRedacts(t *testing.T) {
+	t.Parallel()
+
+	journal := newOperationJournal(nil)
+	journal.noteDrift("192.0.2.10", []applycheck.Change{
+		{ID: applycheck.DocID{Kind: "MachineConfig"}, Op: applycheck.OpUpdate, Fields: []applycheck.FieldChange{
+			{Path: "machine.install.image", Old: "s3cr3t", New: "factory", HasOld: true, HasNew: true},
+		}},
+	}, secretRedactor{userSecrets: map[string]struct{}{"s3cr3t": {}}})
+
+	fields := strings.Join(journal.report("apply", false).Nodes[0].Drift[0].Fields, "\n")
+	if strings.Contains(fields, "s3cr3t") || !strings.Contains(fields, "factory") {
+		t.Errorf("fields = %q", fields)
+	}
+}
+
+func TestOperationJournal_NoteUpgrade(t *testing.T) {
+	t.Parallel()
+
+	journal := newOperationJournal(nil)
+	journal.noteVersion("192.0.2.10", "v1.9.5", false)
+	journal.noteUpgrade([]string{"192.0.2.10", "192.0.2.11"}, "ghcr.io/siderolabs/installer:v1.10.0", nil)
+	journal.noteVersion("192.0.2.10", "v1.10.0", true)
+	journal.noteVersion("192.0.2.10", "v1.10.1", true)
+	journal.noteUpgrade([]string{"192.0.2.11"}, "ghcr.io/siderolabs/installer:v1.10.0", errors.New("rolled back"))
+
+	nodes := journal.report("upgrade", false).Nodes
+	if len(nodes) != 2 {
+		t.Fatalf("nodes = %+v", nodes)
+	}
+
+	first, second := nodes[0], nodes[1]
+	if first.VersionBefore != "v1.9.5" || first.VersionAfter != "v1.10.0" || first.Image != "ghcr.io/siderolabs/installer:v1.10.0" || first.Error != "" {
+		t.Errorf("first = %+v", first)
+	}
+
+	if second.Error != "rolled back" {
+		t.Errorf("second = %+v", second)
+	}
+}
+
+// TestOperationJournal_NoteWrittenFile pins that an --output-dir
+// render names the file it went to and, like -I, leaves the config out.
+func TestOperationJournal_NoteWrittenFile(t *testing.T) {
+	t.Parallel()
+
+	journal := newOperationJournal(nil)
+	journal.noteWrittenFile("nodes/cp1.yaml", "rendered/nodes/cp1.yaml", []string{"192.0.2.10"}, nodeFileFormatYAML, "machine: {}\n")
+
+	// sha256 of "machine: {}\n".
+	const sum = "2a5c1276ba1c0a483d83c671b8276645063d9bdb257415a6b259223fffdeb619"
+
+	files := journal.report("template", false).Files
+	if len(files) != 1 {
+		t.Fatalf("files = %+v", files)
+	}
+
+	if got := files[0]; got.Status != renderStatusWritten || got.Output != "rendered/nodes/cp1.yaml" || got.Config != "" || got.ConfigSHA256 != sum {
+		t.Errorf("written = %+v", got)
+	}
+}
+
+// TestPruneReportOutput pins that every list is present in the JSON
+// form, empty when there is nothing to report.
+func TestPruneReportOutput(t *testing.T) {
+	withOutputFormat(t, outputFormatJSON)
+
+	var out bytes.Buffer
+
+	report := pruneReport{
+		membershipKnown: true,
+		orphanedFiles:   []pruneNodeFile{{path: "nodes/old.yaml", nodes: []string{"192.0.2.20"}}},
+	}
+
+	if err := writeStructuredOutput(&out, report.output()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "membershipKnown": true,
+  "orphanedFiles": [
+    {
+      "file": "nodes/old.yaml",
+      "nodes": [
+        "192.0.2.20"
+      ]
+    }
+  ],
+  "unmanagedNodes": [],
+  "orphanedValues": [],
+  "staleArtifacts": []
+}
+`
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -15,12 +15,12 @@
 package commands
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/siderolabs/talos/cmd/talosctl/cmd/mgmt/gen"
 	"github.com/siderolabs/talos/pkg/machinery/client/config"
 	machineconfig "github.com/siderolabs/talos/pkg/machinery/config"
@@ -58,10 +58,25 @@ Use this command when your client certificate has expired.`,
 		return nil
 	},
 	RunE: func(_ *cobra.Command, _ []string) error {
+		// A project that reads a shared talosconfig has none of its own
+		// to regenerate, and the shared one is not talm's to rewrite.
+		external, err := ExternalTalosconfigPath()
+		if err != nil {
+			return err
+		}
+
+		if external != "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("the project reads the shared talosconfig %s, which talm only reads", external),
+				"renew the client certificate in the repository that maintains it, or drop %s from Chart.yaml to give the project its own talosconfig", externalTalosconfigKey,
+			)
+		}
+
 		// First, decrypt talosconfig if encrypted version exists
 		if _, err := handleTalosconfigEncryption(false); err != nil {
 			// If decryption fails, continue - we may be able to regenerate
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			ui.Warnf(os.Stderr, "%v", err)
 		}
 
 		// Regenerate talosconfig from secrets.yaml
@@ -77,7 +92,7 @@ Use this command when your client certificate has expired.`,
 		// Update .gitignore if needed
 		if err := writeGitignoreFile(); err != nil {
 			// Don't fail the command if gitignore update fails, but log warning
-			fmt.Fprintf(os.Stderr, "Warning: failed to update .gitignore: %v\n", err)
+			ui.Warnf(os.Stderr, "failed to update .gitignore: %v", err)
 		}
 
 		return nil
@@ -151,7 +166,7 @@ func regenerateTalosconfig() error {
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "Regenerating talosconfig from secrets.yaml...\n")
+	ui.Infof(os.Stderr, "Regenerating talosconfig from secrets.yaml...")
 
 	// Generate new config bundle
 	configBundle, err := gen.GenerateConfigBundle(
@@ -186,7 +201,7 @@ func regenerateTalosconfig() error {
 		// Preserve the current context setting
 		newConfig.Context = oldConfig.Context
 
-		fmt.Fprintf(os.Stderr, "Preserved endpoints and nodes from existing config\n")
+		ui.Infof(os.Stderr, "Preserved endpoints and nodes from existing config")
 	} else {
 		// No old config — seed the context's endpoint list from
 		// --endpoints if the operator supplied it, otherwise fall
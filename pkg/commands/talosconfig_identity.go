@@ -0,0 +1,396 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	stdx509 "crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+	"github.com/siderolabs/crypto/x509"
+	"github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/role"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// talosconfigsDirName is the project directory holding one
+	// talosconfig per operator identity, selected with --as.
+	talosconfigsDirName = "talosconfigs"
+
+	// defaultIdentityTTL is the validity of a minted per-operator
+	// client certificate. Shorter than the project talosconfig's
+	// upstream default so a departed operator's access lapses on its
+	// own within a quarter.
+	defaultIdentityTTL = 90 * 24 * time.Hour
+
+	// identityGitignore keeps minted identities out of git: each file
+	// carries a private key. Written next to the identities rather
+	// than appended to the project .gitignore so the directory is
+	// safe regardless of how the project ignore file was curated.
+	identityGitignore = "*\n!.gitignore\n"
+
+	// hoursPerDay converts remaining certificate validity to days.
+	hoursPerDay = 24
+)
+
+// AsIdentity is bound to the root --as flag. When set, commands use
+// talosconfigs/<name> instead of the project talosconfig so the
+// client certificate — and with it the subject in the node audit
+// log — names the operator.
+//
+//nolint:gochecknoglobals // cobra persistent flag binds to package-level state, consistent with GlobalArgs / SkipVerify.
+var AsIdentity string
+
+// identityNameRegex bounds identity names to a single path segment
+// that is also a sane certificate common name.
+var identityNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// validateIdentityName rejects names that would escape talosconfigs/
+// or read badly as a certificate subject.
+func validateIdentityName(name string) error {
+	if identityNameRegex.MatchString(name) {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Newf("invalid identity name %q", name),
+		"use lowercase letters, digits, '.', '_' or '-', starting and ending with a letter or digit (e.g. alice or ci-deploy)",
+	)
+}
+
+// ResolveIdentityTalosconfig returns the talosconfig path for --as
+// name inside the project rooted at rootDir. The file must already
+// exist; a typo in --as must not silently fall back to the shared
+// project talosconfig.
+func ResolveIdentityTalosconfig(rootDir, name string) (string, error) {
+	if err := validateIdentityName(name); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(rootDir, talosconfigsDirName, name)
+	if !fileExists(path) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHintf(
+			errors.Newf("no talosconfig for identity %q at %s", name, path),
+			"mint one with `talm talosconfig mint %s`, or list existing identities with `talm talosconfig identities`", name,
+		)
+	}
+
+	return path, nil
+}
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var talosconfigMintCmdFlags struct {
+	ttl   time.Duration
+	roles []string
+	force bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var talosconfigMintCmd = &cobra.Command{
+	Use:   "mint <name>",
+	Short: "Mint a per-operator talosconfig from the project secrets",
+	Long: `Mint a talosconfig for one operator under talosconfigs/<name>.
+
+The client certificate is signed by the Talos CA from secrets.yaml, carries
+the operator name as its common name so node audit logs can tell operators
+apart, and expires after --ttl. Endpoints and nodes are copied from the
+project talosconfig when it exists.
+
+Use the identity with the root --as flag:
+
+  talm --as alice apply -f nodes/cp01.yaml`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		return mintIdentityTalosconfig(args[0], talosconfigMintCmdFlags.roles, talosconfigMintCmdFlags.ttl, talosconfigMintCmdFlags.force)
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var talosconfigIdentitiesCmd = &cobra.Command{
+	Use:   "identities",
+	Short: "List per-operator talosconfigs and their certificate expiry",
+	Args:  cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return listIdentities(cmd.OutOrStdout(), ui.Progress(os.Stderr), filepath.Join(Config.RootDir, talosconfigsDirName), time.Now())
+	},
+}
+
+// mintIdentityTalosconfig writes talosconfigs/<name> with a fresh
+// client certificate for name.
+func mintIdentityTalosconfig(name string, roleNames []string, ttl time.Duration, force bool) error {
+	if err := validateIdentityName(name); err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("--ttl must be a positive duration; got %s", ttl),
+			"pass a duration like 720h — the default is 2160h (90 days)",
+		)
+	}
+
+	roles, unknownRoles := role.Parse(roleNames)
+	if len(unknownRoles) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("unknown roles %v", unknownRoles),
+			"valid roles are os:admin, os:operator, os:reader and os:etcd:backup",
+		)
+	}
+
+	dir := filepath.Join(Config.RootDir, talosconfigsDirName)
+	path := filepath.Join(dir, name)
+
+	if fileExists(path) && !force {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("identity %q already exists at %s", name, path),
+			"pass --force to re-mint it (the old certificate stays valid until it expires; rotate the Talos CA to revoke it early)",
+		)
+	}
+
+	secretsPath := ResolveSecretsPath(Config.TemplateOptions.WithSecrets)
+	if !fileExists(secretsPath) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("secrets.yaml not found at %s", secretsPath),
+			"run 'talm init' or restore secrets.yaml (decrypt it with `talm init --decrypt`)",
+		)
+	}
+
+	bundle, err := secrets.LoadBundle(secretsPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load secrets bundle")
+	}
+
+	now := time.Now()
+
+	cert, err := newIdentityCertificate(bundle.Certs.OS, name, roles, now, ttl)
+	if err != nil {
+		return err
+	}
+
+	identityConfig := buildIdentityTalosconfig(name, bundle.Certs.OS.Crt, cert)
+
+	data, err := identityConfig.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal talosconfig")
+	}
+
+	if err := os.MkdirAll(dir, secureDirMode); err != nil {
+		return errors.Wrapf(err, "creating %s", dir)
+	}
+
+	if err := secureperm.WriteFile(filepath.Join(dir, ".gitignore"), []byte(identityGitignore)); err != nil {
+		return errors.Wrap(err, "writing talosconfigs/.gitignore")
+	}
+
+	if err := secureperm.WriteFile(path, data); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	ui.Infof(os.Stderr, "Minted talosconfig for %q at %s (roles %s, expires %s)",
+		name, path, strings.Join(roles.Strings(), ","), now.Add(ttl).UTC().Format(time.RFC3339))
+
+	return nil
+}
+
+// newIdentityCertificate signs a Talos API client certificate for
+// name. Mirrors upstream's admin certificate options and adds the
+// common name the audit log needs.
+func newIdentityCertificate(ca *x509.PEMEncodedCertificateAndKey, name string, roles role.Set, now time.Time, ttl time.Duration) (*x509.PEMEncodedCertificateAndKey, error) {
+	talosCA, err := x509.NewCertificateAuthorityFromCertificateAndKey(ca)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading Talos CA from secrets")
+	}
+
+	keyPair, err := x509.NewKeyPair(talosCA,
+		x509.CommonName(name),
+		x509.Organization(roles.Strings()...),
+		x509.NotBefore(now),
+		x509.NotAfter(now.Add(ttl)),
+		x509.KeyUsage(stdx509.KeyUsageDigitalSignature),
+		x509.ExtKeyUsage([]stdx509.ExtKeyUsage{stdx509.ExtKeyUsageClientAuth}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing client certificate")
+	}
+
+	return x509.NewCertificateAndKeyFromKeyPair(keyPair), nil
+}
+
+// buildIdentityTalosconfig wraps cert in a single-context talosconfig.
+// The context name and its endpoints and nodes come from the project
+// talosconfig when one exists, so `--as` changes who is talking and
+// nothing about where.
+func buildIdentityTalosconfig(name string, caCrt []byte, cert *x509.PEMEncodedCertificateAndKey) *config.Config {
+	contextName := getClusterNameFromChart()
+	endpoints := resolveTalosconfigEndpoints(GlobalArgs.Endpoints)
+
+	var nodes []string
+
+	if projectConfig, err := config.Open(filepath.Join(Config.RootDir, talosconfigName)); err == nil && projectConfig.Context != "" {
+		if projectCtx, ok := projectConfig.Contexts[projectConfig.Context]; ok {
+			contextName = projectConfig.Context
+			endpoints = projectCtx.Endpoints
+			nodes = projectCtx.Nodes
+		}
+	}
+
+	if contextName == "" {
+		contextName = name
+	}
+
+	identityConfig := config.NewConfig(contextName, endpoints, caCrt, cert)
+	identityConfig.Contexts[contextName].Nodes = nodes
+
+	return identityConfig
+}
+
+// identityInfo is one row of `talm talosconfig identities`.
+type identityInfo struct {
+	name     string
+	subject  string
+	roles    []string
+	notAfter time.Time
+}
+
+// readIdentity extracts the client certificate from a talosconfig's
+// current context.
+func readIdentity(path string) (identityInfo, error) {
+	cfg, err := config.Open(path)
+	if err != nil {
+		return identityInfo{}, errors.Wrapf(err, "reading %s", path)
+	}
+
+	ctx, ok := cfg.Contexts[cfg.Context]
+	if !ok {
+		return identityInfo{}, errors.Newf("%s: current context %q not found", path, cfg.Context)
+	}
+
+	crtPEM, err := base64.StdEncoding.DecodeString(ctx.Crt)
+	if err != nil {
+		return identityInfo{}, errors.Wrapf(err, "%s: decoding client certificate", path)
+	}
+
+	block, _ := pem.Decode(crtPEM)
+	if block == nil {
+		return identityInfo{}, errors.Newf("%s: client certificate is not PEM-encoded", path)
+	}
+
+	crt, err := stdx509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return identityInfo{}, errors.Wrapf(err, "%s: parsing client certificate", path)
+	}
+
+	return identityInfo{
+		name:     filepath.Base(path),
+		subject:  crt.Subject.CommonName,
+		roles:    crt.Subject.Organization,
+		notAfter: crt.NotAfter,
+	}, nil
+}
+
+// listIdentities prints every identity under dir with its remaining
+// validity. Unreadable entries are reported inline rather than
+// aborting the listing; a missing dir is reported on progress, so the
+// listing itself stays empty.
+func listIdentities(w, progress io.Writer, dir string, now time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(progress, "no identities in %s; mint one with `talm talosconfig mint <name>`\n", dir)
+
+			return nil
+		}
+
+		return errors.Wrapf(err, "reading %s", dir)
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSUBJECT\tROLES\tEXPIRES\tDAYS LEFT")
+
+	for _, name := range names {
+		info, err := readIdentity(filepath.Join(dir, name))
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\terror: %v\n", name, err)
+
+			continue
+		}
+
+		daysLeft := int(info.notAfter.Sub(now).Hours() / hoursPerDay)
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", info.name, info.subject, strings.Join(info.roles, ","), info.notAfter.UTC().Format(time.DateOnly), daysLeft)
+	}
+
+	return errors.Wrap(tw.Flush(), "writing identities table")
+}
+
+func init() {
+	talosconfigMintCmd.Flags().DurationVar(&talosconfigMintCmdFlags.ttl, "ttl", defaultIdentityTTL, "validity of the client certificate")
+	talosconfigMintCmd.Flags().StringSliceVar(&talosconfigMintCmdFlags.roles, "roles", []string{string(role.Admin)}, "Talos API roles granted to the identity")
+	talosconfigMintCmd.Flags().BoolVar(&talosconfigMintCmdFlags.force, "force", false, "re-mint an existing identity")
+
+	talosconfigCmd.AddCommand(talosconfigMintCmd, talosconfigIdentitiesCmd)
+}
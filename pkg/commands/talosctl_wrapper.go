@@ -15,7 +15,6 @@
 package commands
 
 import (
-	"fmt"
 	"io"
 	"net"
 	"os"
@@ -27,6 +26,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 // crashdumpCmdName labels the upstream crashdump subcommand both
@@ -153,10 +154,10 @@ func warnSkipVerifyUnsupported(w io.Writer, cmdName string) {
 		return
 	}
 
-	fmt.Fprintf(w,
-		"Warning: --skip-verify is not supported for the wrapped talosctl command %q; "+
+	ui.Warnf(w,
+		"--skip-verify is not supported for the wrapped talosctl command %q; "+
 			"it applies only to talm-native commands (apply, template, upgrade, rotate-ca). "+
-			"Connecting with full TLS verification.\n",
+			"Connecting with full TLS verification.",
 		cmdName)
 }
 
@@ -306,6 +307,12 @@ func wrapTalosCommand(cmd *cobra.Command, cmdName string) *cobra.Command {
 		wrapUpgradeCommand(wrappedCmd, originalRunE)
 	}
 
+	// Special handling for bootstrap: wait for etcd and optionally
+	// fetch the kubeconfig. See wrapBootstrapCommand godoc.
+	if baseCmdName == bootstrapCmdName {
+		wrapBootstrapCommand(wrappedCmd, originalRunE)
+	}
+
 	// Special handling for rotate-ca command
 	if baseCmdName == rotateCACmdName {
 		wrapRotateCACommand(wrappedCmd, originalRunE)
@@ -321,6 +328,13 @@ func wrapTalosCommand(cmd *cobra.Command, cmdName string) *cobra.Command {
 		wrapCrashdumpCommand(wrappedCmd)
 	}
 
+	// Special handling for copy: accept the scp-style `<node>:<path>`
+	// source, resolved from a node file or taken as a node address.
+	// See wrapCopyCommand godoc.
+	if baseCmdName == copyCmdName {
+		wrapCopyCommand(wrappedCmd)
+	}
+
 	// Special handling for the interactive-only commands
 	// (dashboard, edit): refuse non-tty stdin up front so the
 	// operator gets a clear hint instead of a no-output failure.
@@ -341,6 +355,14 @@ func wrapTalosCommand(cmd *cobra.Command, cmdName string) *cobra.Command {
 		wrapResetCommand(wrappedCmd)
 	}
 
+	// Special handling for the etcd membership and leadership
+	// changes: check their quorum impact before upstream acts. See
+	// wrapEtcdQuorumCommand godoc.
+	switch baseCmdName {
+	case etcdRemoveMemberCmdName, etcdLeaveCmdName, etcdForfeitLeadershipCmdName:
+		wrapEtcdQuorumCommand(wrappedCmd, baseCmdName)
+	}
+
 	// Copy all subcommands
 	for _, subCmd := range cmd.Commands() {
 		wrappedCmd.AddCommand(wrapTalosCommand(subCmd, subCmd.Name()))
@@ -446,7 +468,7 @@ func updateKubeconfigServer(kubeconfigPath, endpoint string) error {
 			cluster.Server = normalizedEndpoint
 			updated = true
 
-			fmt.Fprintf(os.Stderr, "Updated cluster %s server to %s\n", clusterName, normalizedEndpoint)
+			ui.Infof(os.Stderr, "Updated cluster %s server to %s", clusterName, normalizedEndpoint)
 		}
 	}
 
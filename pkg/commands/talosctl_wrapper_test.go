@@ -362,7 +362,7 @@ func TestDmesgRemoved_StubSurfacesMigrationHint(t *testing.T) {
 
 	// Find the registered talm `dmesg` stub among package
 	// commands. Iterate Commands rather than rootCmd.Commands
-	// because rootCmd assembly happens in main.go's init, which
+	// because rootCmd assembly happens in pkg/cli's init, which
 	// the test package doesn't drive.
 	var stub *cobra.Command
 
@@ -25,6 +25,7 @@ import (
 	"github.com/cozystack/talm/pkg/engine"
 	"github.com/cozystack/talm/pkg/modeline"
 	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
 	"github.com/spf13/cobra"
 
 	"github.com/siderolabs/talos/pkg/machinery/client"
@@ -43,16 +44,30 @@ var templateCmdFlags struct {
 	jsonValues        []string // --set-json
 	literalValues     []string // --set-literal
 	talosVersion      string
+	talosVersionSet   bool // --talos-version given, values.yaml node pins do not apply
 	withSecrets       string
 	full              bool
 	debug             bool
 	offline           bool
+	strict            bool
 	kubernetesVersion string
 	inplace           bool
 	showSecrets       bool
 	nodesFromArgs     bool
 	endpointsFromArgs bool
 	templatesFromArgs bool
+	sinceRef          string
+	snapshot          string // --snapshot
+	format            string
+	release           string
+	valuesLock        string // resolved from --release
+	profile           bool
+	renderProfile     *engine.RenderProfile // set by --profile
+	showSources       bool
+	role              string // --role
+	roleFromArgs      bool
+	k8sManifests      string // --k8s-manifests
+	outputDir         string // --output-dir
 }
 
 //nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
@@ -81,7 +96,8 @@ single MachineConfig and apply it once".`,
 		templateCmdFlags.jsonValues = append(Config.TemplateOptions.JsonValues, templateCmdFlags.jsonValues...)
 
 		templateCmdFlags.literalValues = append(Config.TemplateOptions.LiteralValues, templateCmdFlags.literalValues...)
-		if !cmd.Flags().Changed("talos-version") {
+		templateCmdFlags.talosVersionSet = cmd.Flags().Changed("talos-version")
+		if !templateCmdFlags.talosVersionSet {
 			templateCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
 
@@ -105,9 +121,36 @@ single MachineConfig and apply it once".`,
 			templateCmdFlags.offline = Config.TemplateOptions.Offline
 		}
 
+		// A snapshot stands in for the node: never connect to it.
+		if templateCmdFlags.snapshot != "" {
+			templateCmdFlags.offline = true
+		}
+
+		if !cmd.Flags().Changed("strict") {
+			templateCmdFlags.strict = Config.TemplateOptions.StrictDeprecations
+		}
+
+		valuesLock, err := resolveReleaseValuesLock(cmd.Flags(), Config.RootDir, templateCmdFlags.release)
+		if err != nil {
+			return err
+		}
+
+		templateCmdFlags.valuesLock = valuesLock
+
 		templateCmdFlags.templatesFromArgs = len(templateCmdFlags.templateFiles) > 0
 		templateCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
 		templateCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
+		templateCmdFlags.roleFromArgs = templateCmdFlags.role != ""
+
+		// --role alone renders the templates Chart.yaml lists for the
+		// role; node files name theirs in the modeline.
+		if templateCmdFlags.roleFromArgs && !templateCmdFlags.templatesFromArgs && len(templateCmdFlags.configFiles) == 0 {
+			templateCmdFlags.templateFiles, err = engine.RoleTemplates(Config.TemplateOptions.Roles, templateCmdFlags.role)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Set dummy endpoint to avoid errors on building clinet
 		if len(GlobalArgs.Endpoints) == 0 {
 			GlobalArgs.Endpoints = append(GlobalArgs.Endpoints, defaultLocalEndpoint)
@@ -116,24 +159,55 @@ single MachineConfig and apply it once".`,
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateNodeFileFormat(templateCmdFlags.format); err != nil {
+			return err
+		}
+
+		if templateCmdFlags.sinceRef != "" && len(templateCmdFlags.configFiles) == 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("--since-ref selects among node files and needs --file"),
+				"pass the node files or the nodes/ directory, e.g. talm template -f nodes/ --since-ref origin/main -I",
+			)
+		}
+
+		if templateCmdFlags.showSources && templateCmdFlags.inplace {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("--show-sources annotates stdout output and cannot be combined with --in-place"),
+				"run talm template -f <node file> --show-sources to read the sources, or talm explain to trace one field",
+			)
+		}
+
+		if err := validateTemplateOutputDir(); err != nil {
+			return err
+		}
+
+		if templateCmdFlags.profile {
+			templateCmdFlags.renderProfile = engine.NewRenderProfile()
+			defer writeRenderProfile(templateCmdFlags.renderProfile)
+		}
+
 		templateFunc := template
 		if len(templateCmdFlags.configFiles) > 0 {
 			templateFunc = templateWithFiles
 		}
 
-		if templateCmdFlags.offline {
-			return templateFunc(args)(cmd.Context(), nil)
-		}
+		return withOutputReport(cmd.OutOrStdout(), "template", false, func() error {
+			if templateCmdFlags.offline {
+				return templateFunc(args)(cmd.Context(), nil)
+			}
 
-		if templateCmdFlags.insecure {
-			return WithClientMaintenance(nil, templateFunc(args))
-		}
+			if templateCmdFlags.insecure {
+				return WithClientMaintenance(nil, templateFunc(args))
+			}
 
-		// WithClient routes through WithClientSkipVerify when --skip-verify is
-		// set, and also injects node metadata that template `lookup` needs —
-		// so the skip-verify path must go through it, not the bare no-nodes
-		// WithClientSkipVerify directly.
-		return WithClient(templateFunc(args))
+			// WithClient routes through WithClientSkipVerify when --skip-verify is
+			// set, and also injects node metadata that template `lookup` needs —
+			// so the skip-verify path must go through it, not the bare no-nodes
+			// WithClientSkipVerify directly.
+			return WithClient(templateFunc(args))
+		})
 	},
 }
 
@@ -144,6 +218,19 @@ func template(args []string) func(ctx context.Context, c *client.Client) error {
 			return err
 		}
 
+		format := resolveNodeFileFormat(templateCmdFlags.format, "")
+
+		output, err = encodeNodeFileOutput(output, format)
+		if err != nil {
+			return err
+		}
+
+		if structuredOutput() {
+			currentJournal().noteFile("", GlobalArgs.Nodes, format, output, false, nil)
+
+			return nil
+		}
+
 		//nolint:forbidigo // CLI command output is the user-facing rendered config
 		fmt.Println(output)
 
@@ -165,11 +252,20 @@ func templateWithFiles(args []string) func(ctx context.Context, c *client.Client
 			return err
 		}
 
+		if templateCmdFlags.sinceRef != "" {
+			expandedFiles, err = filterFilesSinceRef(expandedFiles, templateCmdFlags.sinceRef, ui.Progress(os.Stderr))
+			if err != nil {
+				return err
+			}
+		}
+
 		firstFileProcessed := false
 
 		for _, configFile := range expandedFiles {
 			err = templateOneFile(ctx, args, configFile, &firstFileProcessed)
 			if err != nil {
+				currentJournal().noteFile(configFile, GlobalArgs.Nodes, resolveNodeFileFormat(templateCmdFlags.format, configFile), "", false, err)
+
 				return err
 			}
 
@@ -202,8 +298,23 @@ func templateOneFile(ctx context.Context, args []string, configFile string, firs
 		return errors.Wrap(err, "modeline parsing failed")
 	}
 
+	if !templateCmdFlags.roleFromArgs {
+		templateCmdFlags.role = modelineConfig.Role
+	}
+
 	if !templateCmdFlags.templatesFromArgs {
-		if len(modelineConfig.Templates) == 0 {
+		templates := modelineConfig.Templates
+
+		// A modeline with a role but no templates takes the ones the
+		// chart lists for the role.
+		if len(templates) == 0 && templateCmdFlags.role != "" {
+			templates, err = engine.RoleTemplates(Config.TemplateOptions.Roles, templateCmdFlags.role)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(templates) == 0 {
 			//nolint:wrapcheck // sentinel constructed in-place; WithHint attaches operator guidance
 			return errors.WithHint(
 				errors.New("modeline does not contain templates information"),
@@ -211,7 +322,7 @@ func templateOneFile(ctx context.Context, args []string, configFile string, firs
 			)
 		}
 
-		templateCmdFlags.templateFiles = modelineConfig.Templates
+		templateCmdFlags.templateFiles = templates
 	}
 
 	if !templateCmdFlags.nodesFromArgs {
@@ -227,7 +338,7 @@ func templateOneFile(ctx context.Context, args []string, configFile string, firs
 	}
 
 	// Progress line goes to stderr; stdout is reserved for the rendered config stream so `talm template --file X > Y` produces a clean Y.
-	fmt.Fprintf(os.Stderr, "- talm: file=%s, nodes=%s, endpoints=%s, templates=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+	ui.Infof(os.Stderr, "- talm: file=%s, nodes=%s, endpoints=%s, templates=%s", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
 
 	if len(GlobalArgs.Nodes) < 1 {
 		//nolint:wrapcheck // sentinel constructed in-place; WithHint attaches operator guidance
@@ -255,9 +366,10 @@ func templateOneFile(ctx context.Context, args []string, configFile string, firs
 // can stay flat. leadingComments is the slice of operator-authored
 // `#`-prefixed / blank lines that lived above the modeline in the
 // source file; in-place mode prepends them to the rewritten file so
-// the operator's documentation survives the regeneration.
-// Non in-place renders ignore leadingComments because the original
-// file is left untouched.
+// the operator's documentation survives the regeneration, and
+// --output-dir to the copy it writes. Renders to stdout ignore
+// leadingComments because the original file is left untouched. A JSON render has no place for comments, so
+// converting a commented YAML file drops them, with a warning.
 func buildTemplateRunner(args []string, configFile string, leadingComments []string, firstFileProcessed *bool) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
 		output, err := generateOutput(ctx, c, args)
@@ -265,11 +377,55 @@ func buildTemplateRunner(args []string, configFile string, leadingComments []str
 			return err
 		}
 
+		format := resolveNodeFileFormat(templateCmdFlags.format, configFile)
+
+		output, err = encodeNodeFileOutput(output, format)
+		if err != nil {
+			return err
+		}
+
 		if templateCmdFlags.inplace {
-			return writeInplaceRendered(configFile, prependLeadingComments(leadingComments, output))
+			if format != nodeFileFormatJSON {
+				output = prependLeadingComments(leadingComments, output)
+			} else if len(leadingComments) > 0 {
+				ui.Warnf(os.Stderr, "%s: the comments above the modeline have no JSON form and are dropped", configFile)
+			}
+
+			if err := writeInplaceRendered(configFile, output); err != nil {
+				return err
+			}
+
+			currentJournal().noteFile(configFile, GlobalArgs.Nodes, format, output, true, nil)
+
+			return nil
+		}
+
+		if templateCmdFlags.outputDir != "" {
+			if format != nodeFileFormatJSON {
+				output = prependLeadingComments(leadingComments, output)
+			}
+
+			path, err := writeTemplateOutputFile(templateCmdFlags.outputDir, Config.RootDir, configFile, output)
+			if err != nil {
+				return err
+			}
+
+			currentJournal().noteWrittenFile(configFile, path, GlobalArgs.Nodes, format, output)
+
+			return nil
+		}
+
+		// --output json or yaml reports the render instead of
+		// printing it.
+		if structuredOutput() {
+			currentJournal().noteFile(configFile, GlobalArgs.Nodes, format, output, false, nil)
+
+			return nil
 		}
 
-		if *firstFileProcessed {
+		// JSON renders are a stream of objects, one per file, with
+		// no YAML document separator between them.
+		if *firstFileProcessed && format != nodeFileFormatJSON {
 			//nolint:forbidigo // multi-document YAML separator is part of the user-facing output stream
 			fmt.Println("---")
 		}
@@ -307,45 +463,87 @@ func generateOutput(ctx context.Context, c *client.Client, _ []string) (string,
 	// Resolve template file paths relative to project root
 	resolvedTemplateFiles := resolveEngineTemplatePaths(templateCmdFlags.templateFiles, Config.RootDir)
 
-	opts := engine.Options{
-		ValueFiles:        templateCmdFlags.valueFiles,
-		StringValues:      templateCmdFlags.stringValues,
-		Values:            templateCmdFlags.values,
-		FileValues:        templateCmdFlags.fileValues,
-		JsonValues:        templateCmdFlags.jsonValues,
-		LiteralValues:     templateCmdFlags.literalValues,
-		TalosVersion:      templateCmdFlags.talosVersion,
-		WithSecrets:       withSecretsPath,
-		Full:              templateCmdFlags.full,
-		Debug:             templateCmdFlags.debug,
-		Root:              Config.RootDir,
-		Offline:           templateCmdFlags.offline,
-		KubernetesVersion: templateCmdFlags.kubernetesVersion,
-		TemplateFiles:     resolvedTemplateFiles,
-		CommandName:       engine.CommandNameTemplate,
-		TalosEndpoints:    append([]string(nil), GlobalArgs.Endpoints...),
+	talosVersion := templateCmdFlags.talosVersion
+
+	if !templateCmdFlags.talosVersionSet {
+		var err error
+
+		talosVersion, err = nodeTalosVersion(Config.RootDir, GlobalArgs.Nodes, talosVersion)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var snapshot *engine.NodeSnapshot
+
+	if templateCmdFlags.snapshot != "" {
+		var err error
+
+		snapshot, err = loadTemplateSnapshot(templateCmdFlags.snapshot, GlobalArgs.Nodes)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	opts := projectRenderOptions(engine.CommandNameTemplate, templateCmdFlags.valuesLock)
+	if opts.ValuesLock == "" {
+		opts.ValueFiles = templateCmdFlags.valueFiles
+		opts.Values = templateCmdFlags.values
+		opts.StringValues = templateCmdFlags.stringValues
+		opts.FileValues = templateCmdFlags.fileValues
+		opts.JsonValues = templateCmdFlags.jsonValues
+		opts.LiteralValues = templateCmdFlags.literalValues
 	}
 
+	opts.TalosVersion = talosVersion
+	opts.WithSecrets = withSecretsPath
+	opts.KubernetesVersion = templateCmdFlags.kubernetesVersion
+	opts.Full = templateCmdFlags.full
+	opts.Debug = templateCmdFlags.debug
+	opts.Offline = templateCmdFlags.offline
+	opts.TemplateFiles = resolvedTemplateFiles
+	opts.Role = templateCmdFlags.role
+	opts.StrictDeprecations = templateCmdFlags.strict
+	opts.Profile = templateCmdFlags.renderProfile
+	opts.AnnotateSources = templateCmdFlags.showSources
+	opts.Snapshot = snapshot
+
 	result, err := engine.Render(ctx, c, opts)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to render templates")
 	}
 
+	// The manifests leave before the sealing: their files are what
+	// apply --k8s-manifests inlines back, so they hold the render.
+	if templateCmdFlags.k8sManifests != "" {
+		result, err = splitK8sManifests(templateCmdFlags.k8sManifests, result, os.Stderr)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// persistedValueFiles is the Chart.yaml-declared subset that `talm apply`
 	// re-reads on its own (resolved the same way the PreRunE merge resolved
 	// them). An encrypted file outside this set, passed only via
 	// `template --values`, would have its omitted secret lost at apply — so
-	// sealRenderedSecrets warns about it in -I mode.
+	// sealRenderedSecrets warns about it in -I mode. Under --release the
+	// values lock is the only value file, and apply --release re-reads it.
+	valueFiles := templateCmdFlags.valueFiles
 	persistedValueFiles := resolveProjectValueFiles(Config.TemplateOptions.ValueFiles, Config.RootDir)
 
-	result, err = sealRenderedSecrets(result, templateCmdFlags.valueFiles, persistedValueFiles, Config.RootDir, templateCmdFlags.inplace, templateCmdFlags.showSecrets)
+	if templateCmdFlags.valuesLock != "" {
+		valueFiles = []string{templateCmdFlags.valuesLock}
+		persistedValueFiles = valueFiles
+	}
+
+	result, err = sealRenderedSecrets(result, valueFiles, persistedValueFiles, Config.RootDir, templateCmdFlags.inplace, templateCmdFlags.showSecrets)
 	if err != nil {
 		return "", err
 	}
 
 	templatePathsForModeline := buildModelineTemplatePaths(templateCmdFlags.templateFiles, Config.RootDir)
 
-	mline, err := modeline.GenerateModeline(GlobalArgs.Nodes, GlobalArgs.Endpoints, templatePathsForModeline)
+	mline, err := modeline.GenerateRoleModeline(GlobalArgs.Nodes, GlobalArgs.Endpoints, templatePathsForModeline, templateCmdFlags.role)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to generate modeline")
 	}
@@ -357,6 +555,17 @@ func generateOutput(ctx context.Context, c *client.Client, _ []string) (string,
 	return output, nil
 }
 
+// writeRenderProfile prints the --profile report to stderr, after the
+// rendered config on stdout. It runs on a failed render too: the
+// phases and lookups that did run are what point at the slow part.
+func writeRenderProfile(profile *engine.RenderProfile) {
+	ui.Infof(os.Stderr, "Render profile:")
+
+	if err := profile.WriteReport(os.Stderr); err != nil {
+		ui.Warnf(os.Stderr, "%v", err)
+	}
+}
+
 // buildModelineTemplatePaths converts each template path into the
 // forward-slash, root-relative form to be embedded in the generated
 // modeline. Splits the per-path branching out of generateOutput so
@@ -486,7 +695,7 @@ func canonicalizeInsideRootPath(templatePath, absTemplatePath, absRootDir, relPa
 
 func init() {
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.insecure, "insecure", "i", false, "template using the insecure (encrypted with no auth) maintenance service")
-	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.configFiles, "file", "f", nil, "node config files for in-place update (`.yaml` / `.yml`; shell completion narrows to these extensions). Each file's modeline drives the per-file render.")
+	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.configFiles, "file", "f", nil, "node config files for in-place update (`.yaml` / `.yml`, or JSON node files; shell completion narrows to these extensions). Each file's modeline drives the per-file render.")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.inplace, "in-place", "I", false, "re-template and update generated files in place (overwrite them)")
 	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.valueFiles, "values", "", []string{}, "specify values in a YAML file (can specify multiple)")
 	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.templateFiles, "template", "t", []string{}, "specify templates to render manifest from (can specify multiple)")
@@ -500,8 +709,18 @@ func init() {
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.full, "full", "", false, "show full resulting config, not only patch")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.debug, "debug", "", false, "show only rendered patches")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.offline, "offline", "", false, "disable gathering information and lookup functions")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.strict, "strict", false, strictFlagUsage)
 	templateCmd.Flags().BoolVar(&templateCmdFlags.showSecrets, "show-secrets", false, "print values from encrypted value files (*.encrypted.yaml) verbatim in stdout output (default: redacted to ***; never affects -I, which always omits them). Counterpart on apply is --show-secrets-in-drift, which governs the same values in apply's drift preview.")
 	templateCmd.Flags().StringVar(&templateCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	templateCmd.Flags().StringVar(&templateCmdFlags.format, "format", "", "node file format to write: yaml or json (default: the format of the --file being rendered, yaml without one). JSON node files keep the modeline as a \"talm\" object and drop comments")
+	templateCmd.Flags().StringVar(&templateCmdFlags.release, "release", "", "render with the values locked for this release tag by `talm snapshot values` (releases/<tag>/values.lock.yaml) instead of values.yaml and the value files; cannot be combined with --values or --set*")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.profile, "profile", false, "report to stderr where the render spent its time: per phase (chart load, values, templates, patches), per template and included helper, and per lookup resource kind")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.showSources, "show-sources", false, "head every rendered document with \"# Source:\" comments naming the template file and the named template (define) that produced it; the machine config lists every template that patches it. Cannot be combined with --in-place")
+	templateCmd.Flags().StringVar(&templateCmdFlags.snapshot, "snapshot", "", "render offline with the chart lookups answered from the recording talm snapshot cluster wrote to this directory for the node; implies --offline")
+	templateCmd.Flags().StringVar(&templateCmdFlags.role, "role", "", "render for this machine type, controlplane or worker, as .MachineType; without --template, renders the templates Chart.yaml templateOptions.roles lists for it. The rendered config must declare the role, and the generated modeline records it")
+	templateCmd.Flags().StringVar(&templateCmdFlags.k8sManifests, "k8s-manifests", "", "move the cluster.inlineManifests of the rendered config to this directory, one subdirectory per manifest and one file per Kubernetes object; talm apply --k8s-manifests puts them back")
+	templateCmd.Flags().StringVar(&templateCmdFlags.outputDir, "output-dir", "", "with --file, write each rendered node config to this directory, at the path of its node file relative to the project root, instead of printing it; the modeline is kept and the node files are left untouched. Cannot be combined with --in-place")
+	templateCmd.Flags().StringVar(&templateCmdFlags.sinceRef, "since-ref", "", "with --file, render only the node files whose inputs (node file, its templates, values, charts, secrets) changed since this git ref; the selection is printed to stderr")
 
 	// Shell completion for `talm template` flags. `--file` uses the
 	// modelined-yaml lister (same as apply); other yaml-shaped flags
@@ -512,6 +731,8 @@ func init() {
 	_ = templateCmd.RegisterFlagCompletionFunc("values", completeYAMLFiles)
 	_ = templateCmd.RegisterFlagCompletionFunc("template", completeYAMLFiles)
 	_ = templateCmd.RegisterFlagCompletionFunc("with-secrets", completeYAMLFiles)
+	_ = templateCmd.RegisterFlagCompletionFunc("format", completeNodeFileFormat)
+	_ = templateCmd.RegisterFlagCompletionFunc("role", completeTemplateRole)
 
 	addCommand(templateCmd)
 }
@@ -545,11 +766,16 @@ func prependLeadingComments(leading []string, output string) string {
 // exactly the material that must not end up readable by other users
 // on Windows (inherited DACL) or Unix (0o644).
 func writeInplaceRendered(configFile, output string) error {
+	// Keep the line ending of the file being replaced.
+	if previous, err := os.ReadFile(configFile); err == nil {
+		output = withLineEnding(output, lineEnding(string(previous)))
+	}
+
 	if err := secureperm.WriteFile(configFile, []byte(output)); err != nil {
 		return errors.Wrapf(err, "failed to write file %s", configFile)
 	}
 
-	_, _ = fmt.Fprintf(os.Stderr, "Updated.\n")
+	ui.Successf(os.Stderr, "Updated.")
 
 	return nil
 }
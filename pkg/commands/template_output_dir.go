@@ -0,0 +1,104 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/secureperm"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// validateTemplateOutputDir rejects the --output-dir combinations that
+// have no meaning: it names one output file per node file, so it needs
+// --file, and it is the alternative to --in-place.
+func validateTemplateOutputDir() error {
+	if templateCmdFlags.outputDir == "" {
+		return nil
+	}
+
+	if templateCmdFlags.inplace {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--output-dir and --in-place both choose where the render goes"),
+			"drop --in-place to leave the node files untouched, or --output-dir to update them",
+		)
+	}
+
+	if len(templateCmdFlags.configFiles) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--output-dir names its files after the node files and needs --file"),
+			"pass the node files or the nodes/ directory, e.g. talm template -f nodes/ --output-dir rendered/",
+		)
+	}
+
+	return nil
+}
+
+// templateOutputPath is where --output-dir puts the render of
+// configFile: its path relative to the project root, under outputDir,
+// so nodes/cp1.yaml lands at <outputDir>/nodes/cp1.yaml. A node file
+// outside the project keeps only its name.
+func templateOutputPath(outputDir, rootDir, configFile string) string {
+	rel := filepath.Base(configFile)
+
+	absRoot, rootErr := filepath.Abs(rootDir)
+	absFile, fileErr := filepath.Abs(configFile)
+
+	if rootErr == nil && fileErr == nil {
+		if r, err := filepath.Rel(absRoot, absFile); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			rel = r
+		}
+	}
+
+	return filepath.Join(outputDir, rel)
+}
+
+// writeTemplateOutputFile writes the render of configFile under
+// outputDir and returns the path written. The render embeds the
+// cluster PKI, so it goes through secureperm like an in-place write.
+// An output path that is the node file itself is refused: the node
+// files must stay as they are.
+func writeTemplateOutputFile(outputDir, rootDir, configFile, output string) (string, error) {
+	path := templateOutputPath(outputDir, rootDir, configFile)
+
+	absPath, pathErr := filepath.Abs(path)
+	absFile, fileErr := filepath.Abs(configFile)
+
+	if pathErr == nil && fileErr == nil && absPath == absFile {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Newf("--output-dir %s would write over the node file %s", outputDir, configFile),
+			"pick a directory outside the project root, or use --in-place to update the node files",
+		)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", errors.Wrapf(err, "creating %s", filepath.Dir(path))
+	}
+
+	if err := secureperm.WriteFile(path, []byte(output)); err != nil {
+		return "", errors.Wrapf(err, "failed to write file %s", path)
+	}
+
+	ui.Successf(os.Stderr, "Wrote %s.", path)
+
+	return path, nil
+}
@@ -0,0 +1,118 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateOutputPath(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "edge.yaml")
+
+	for _, tc := range []struct {
+		name, file, want string
+	}{
+		{name: "top-level node file", file: filepath.Join(root, "node1.yaml"), want: filepath.Join("out", "node1.yaml")},
+		{name: "nested node file", file: filepath.Join(root, "nodes", "cp", "cp1.yaml"), want: filepath.Join("out", "nodes", "cp", "cp1.yaml")},
+		{name: "outside the project", file: outside, want: filepath.Join("out", "edge.yaml")},
+	} {
+		if got := templateOutputPath("out", root, tc.file); got != tc.want {
+			t.Errorf("%s: templateOutputPath = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestWriteTemplateOutputFile pins that the render lands under the
+// output directory with the modeline and the node file stays as it
+// was, and that an output directory mapping onto the node file itself
+// is refused.
+func TestWriteTemplateOutputFile(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	nodeFile := filepath.Join(root, "nodes", "cp1.yaml")
+
+	if err := os.MkdirAll(filepath.Dir(nodeFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	const tracked = "# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n"
+	if err := os.WriteFile(nodeFile, []byte(tracked), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const rendered = tracked + "machine:\n  type: controlplane\n"
+
+	outDir := filepath.Join(t.TempDir(), "rendered")
+
+	path, err := writeTemplateOutputFile(outDir, root, nodeFile, rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(outDir, "nodes", "cp1.yaml"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+
+	if got, _ := os.ReadFile(path); string(got) != rendered {
+		t.Errorf("written = %q, want the render with its modeline", got)
+	}
+
+	if got, _ := os.ReadFile(nodeFile); string(got) != tracked {
+		t.Errorf("the node file changed: %q", got)
+	}
+
+	if _, err := writeTemplateOutputFile(root, root, nodeFile, rendered); err == nil || !strings.Contains(err.Error(), "write over the node file") {
+		t.Errorf("err = %v, want the node file protected", err)
+	}
+
+	if got, _ := os.ReadFile(nodeFile); string(got) != tracked {
+		t.Errorf("the refused write changed the node file: %q", got)
+	}
+}
+
+func TestValidateTemplateOutputDir(t *testing.T) {
+	outputDir, inplace, configFiles := templateCmdFlags.outputDir, templateCmdFlags.inplace, templateCmdFlags.configFiles
+
+	t.Cleanup(func() {
+		templateCmdFlags.outputDir, templateCmdFlags.inplace, templateCmdFlags.configFiles = outputDir, inplace, configFiles
+	})
+
+	templateCmdFlags.outputDir, templateCmdFlags.inplace, templateCmdFlags.configFiles = "", true, nil
+	if err := validateTemplateOutputDir(); err != nil {
+		t.Errorf("no --output-dir must pass, got %v", err)
+	}
+
+	templateCmdFlags.outputDir = "rendered"
+	if err := validateTemplateOutputDir(); err == nil || !strings.Contains(err.Error(), "--in-place") {
+		t.Errorf("--output-dir with --in-place must be refused, got %v", err)
+	}
+
+	templateCmdFlags.inplace = false
+	if err := validateTemplateOutputDir(); err == nil || !strings.Contains(err.Error(), "needs --file") {
+		t.Errorf("--output-dir without --file must be refused, got %v", err)
+	}
+
+	templateCmdFlags.configFiles = []string{"nodes/"}
+	if err := validateTemplateOutputDir(); err != nil {
+		t.Errorf("--output-dir with --file must pass, got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/modeline"
+)
+
+// sinceRefIgnoredNames and sinceRefIgnoredDirs list project paths
+// that never feed a render: documentation, git metadata, talm's local
+// state and the client configs talm writes. Names match the base name;
+// dirs match a path prefix. A change to any other path outside nodes/
+// and templates/ is treated as an input of every node, because values,
+// charts, secrets and files embedded with fileContent can live
+// anywhere in the project.
+//
+//nolint:gochecknoglobals // immutable lookup table.
+var (
+	sinceRefIgnoredNames = []string{"*.md", ".gitignore", ".gitattributes", "talosconfig", "kubeconfig"}
+	sinceRefIgnoredDirs  = []string{".talm/", "talosconfigs/"}
+)
+
+// sinceNodeFile is a node file considered by --since-ref: its path as
+// given on the command line, its project-relative slash path, and the
+// project-relative templates it renders.
+type sinceNodeFile struct {
+	file      string
+	rel       string
+	templates []string
+}
+
+// sinceAffected is a node file selected by --since-ref and the first
+// changed path that selected it.
+type sinceAffected struct {
+	file   string
+	reason string
+}
+
+// filterFilesSinceRef narrows files to the node files whose render
+// inputs changed between ref and the working tree, and prints the
+// selection to progress.
+func filterFilesSinceRef(files []string, ref string, progress io.Writer) ([]string, error) {
+	changed, err := changedSinceRef(Config.RootDir, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeFiles := make([]sinceNodeFile, 0, len(files))
+
+	for _, file := range files {
+		nodeFiles = append(nodeFiles, describeSinceNodeFile(file))
+	}
+
+	affected := selectAffectedNodeFiles(nodeFiles, changed)
+
+	fmt.Fprintf(progress, "- talm: %d of %d node file(s) affected since %s\n", len(affected), len(files), ref)
+
+	selected := make([]string, 0, len(affected))
+
+	for _, a := range affected {
+		fmt.Fprintf(progress, "  %s (%s)\n", a.file, a.reason)
+		selected = append(selected, a.file)
+	}
+
+	return selected, nil
+}
+
+// describeSinceNodeFile reads the templates a node file renders: the
+// --template list when given, otherwise the modeline's. A file whose
+// modeline cannot be parsed has no templates; it is selected only
+// when it changed itself, and the render reports the modeline error.
+func describeSinceNodeFile(file string) sinceNodeFile {
+	nf := sinceNodeFile{file: file, rel: projectRelativeSlash(file)}
+
+	templates := templateCmdFlags.templateFiles
+	if !templateCmdFlags.templatesFromArgs {
+		if _, mc, err := modeline.FindAndParseModeline(file); err == nil && mc != nil {
+			templates = mc.Templates
+		}
+	}
+
+	for _, tmpl := range templates {
+		if !filepath.IsAbs(tmpl) {
+			tmpl = filepath.Join(Config.RootDir, tmpl)
+		}
+
+		nf.templates = append(nf.templates, projectRelativeSlash(tmpl))
+	}
+
+	return nf
+}
+
+// projectRelativeSlash returns p relative to the project root in
+// forward-slash form, matching git's output.
+func projectRelativeSlash(p string) string {
+	absRoot, err := filepath.Abs(Config.RootDir)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// selectAffectedNodeFiles applies the impact rules to the changed
+// project-relative paths:
+//
+//   - a changed node file selects itself; other files under nodes/ are
+//     not inputs of anything;
+//   - a changed template under templates/ selects the node files that
+//     render it; a template no given node file renders is skipped when
+//     it is a .yaml/.yml output template, and otherwise (helpers,
+//     .tpl partials) selects every node file;
+//   - a path matching sinceRefIgnoredNames or sinceRefIgnoredDirs
+//     selects nothing;
+//   - any other path (Chart.yaml, values, charts/, secrets, embedded
+//     files) selects every node file.
+//
+// Node files keep their command-line order.
+func selectAffectedNodeFiles(files []sinceNodeFile, changed []string) []sinceAffected {
+	reasons := make(map[string]string, len(files))
+
+	mark := func(nf sinceNodeFile, reason string) {
+		if _, ok := reasons[nf.rel]; !ok {
+			reasons[nf.rel] = reason
+		}
+	}
+
+	for _, p := range changed {
+		switch {
+		case slices.ContainsFunc(files, func(nf sinceNodeFile) bool { return nf.rel == p }):
+			for _, nf := range files {
+				if nf.rel == p {
+					mark(nf, "node file changed")
+				}
+			}
+		case strings.HasPrefix(p, nodesDirName+"/"), sinceRefIsIgnored(p):
+			// Not a render input of the given node files.
+		case strings.HasPrefix(p, "templates/"):
+			users := 0
+
+			for _, nf := range files {
+				if slices.Contains(nf.templates, p) {
+					mark(nf, p+" changed")
+
+					users++
+				}
+			}
+
+			if users == 0 && !isOutputTemplate(p) {
+				for _, nf := range files {
+					mark(nf, p+" changed")
+				}
+			}
+		default:
+			for _, nf := range files {
+				mark(nf, p+" changed")
+			}
+		}
+	}
+
+	var affected []sinceAffected
+
+	for _, nf := range files {
+		if reason, ok := reasons[nf.rel]; ok {
+			affected = append(affected, sinceAffected{file: nf.file, reason: reason})
+		}
+	}
+
+	return affected
+}
+
+func sinceRefIsIgnored(p string) bool {
+	for _, dir := range sinceRefIgnoredDirs {
+		if strings.HasPrefix(p, dir) {
+			return true
+		}
+	}
+
+	for _, pattern := range sinceRefIgnoredNames {
+		if ok, _ := path.Match(pattern, path.Base(p)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isOutputTemplate reports whether a templates/ path is a top-level
+// manifest template (one a modeline can name) rather than a helper.
+func isOutputTemplate(p string) bool {
+	base := path.Base(p)
+	ext := path.Ext(base)
+
+	return !strings.HasPrefix(base, "_") && (ext == "."+yamlExt || ext == "."+ymlExt)
+}
+
+// changedSinceRef lists the project-relative paths that differ between
+// ref and the working tree, staged or not, plus untracked files that
+// are not ignored. Paths outside the project root are left out, so a
+// monorepo change elsewhere does not count.
+func changedSinceRef(rootDir, ref string) ([]string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("invalid --since-ref %q", ref),
+			"pass a branch, tag or commit, e.g. --since-ref origin/main",
+		)
+	}
+
+	diff, err := gitLines(rootDir, "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Wrapf(err, "listing files changed since %s", ref),
+			"--since-ref needs the project inside a git work tree and a ref git can resolve; in CI, fetch enough history (e.g. fetch-depth: 0) for %s to exist", ref,
+		)
+	}
+
+	untracked, err := gitLines(rootDir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing untracked files")
+	}
+
+	return append(diff, untracked...), nil
+}
+
+// gitLines runs git in dir and returns its non-empty output lines.
+func gitLines(dir string, args ...string) ([]string, error) {
+	// core.quotePath=off keeps non-ASCII paths verbatim instead of
+	// octal-escaped and quoted.
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "core.quotePath=off"}, args...)...) //nolint:gosec // fixed git subcommands; the ref is passed as a single argument.
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "git %s: %s", args[0], msg)
+		}
+
+		return nil, errors.Wrapf(err, "git %s", args[0])
+	}
+
+	var lines []string
+
+	for line := range strings.SplitSeq(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
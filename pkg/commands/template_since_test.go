@@ -0,0 +1,215 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func sinceTestFiles() []sinceNodeFile {
+	return []sinceNodeFile{
+		{file: "nodes/cp1.yaml", rel: "nodes/cp1.yaml", templates: []string{"templates/controlplane.yaml"}},
+		{file: "nodes/cp2.yaml", rel: "nodes/cp2.yaml", templates: []string{"templates/controlplane.yaml"}},
+		{file: "nodes/w1.yaml", rel: "nodes/w1.yaml", templates: []string{"templates/worker.yaml"}},
+	}
+}
+
+func selectedFiles(affected []sinceAffected) []string {
+	out := make([]string, 0, len(affected))
+	for _, a := range affected {
+		out = append(out, a.file)
+	}
+
+	return out
+}
+
+// TestSelectAffectedNodeFiles pins the impact rules for each kind of
+// changed path.
+func TestSelectAffectedNodeFiles(t *testing.T) {
+	t.Parallel()
+
+	all := []string{"nodes/cp1.yaml", "nodes/cp2.yaml", "nodes/w1.yaml"}
+
+	for _, tc := range []struct {
+		name    string
+		changed []string
+		want    []string
+	}{
+		{"nothing changed", nil, nil},
+		{"node file", []string{"nodes/cp2.yaml"}, []string{"nodes/cp2.yaml"}},
+		{"template used by some nodes", []string{"templates/controlplane.yaml"}, []string{"nodes/cp1.yaml", "nodes/cp2.yaml"}},
+		{"helper", []string{"templates/_helpers.tpl"}, all},
+		{"unused output template", []string{"templates/other.yaml"}, nil},
+		{"values", []string{"values.yaml"}, all},
+		{"library chart", []string{"charts/talm/templates/_helpers.tpl"}, all},
+		{"embedded file", []string{"files/ca.pem"}, all},
+		{"docs, state and other node files", []string{"README.md", "docs/ops.md", ".talm/state/history/1.json", "talosconfig", "nodes/w9.yaml"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := selectedFiles(selectAffectedNodeFiles(sinceTestFiles(), tc.changed)); !slices.Equal(got, tc.want) {
+				t.Errorf("selected = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSelectAffectedNodeFiles_FirstReasonWins pins that each node file
+// is listed once, with the first changed path that selected it.
+func TestSelectAffectedNodeFiles_FirstReasonWins(t *testing.T) {
+	t.Parallel()
+
+	got := selectAffectedNodeFiles(sinceTestFiles(), []string{"nodes/w1.yaml", "values.yaml"})
+
+	want := []sinceAffected{
+		{file: "nodes/cp1.yaml", reason: "values.yaml changed"},
+		{file: "nodes/cp2.yaml", reason: "values.yaml changed"},
+		{file: "nodes/w1.yaml", reason: "node file changed"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("affected = %+v, want %+v", got, want)
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// TestChangedSinceRef pins the git side: committed, unstaged and
+// untracked changes all count, paths are relative to a project that
+// sits in a subdirectory of the repository, and changes outside the
+// project are left out.
+func TestChangedSinceRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	project := filepath.Join(repo, "clusters", "prod")
+
+	for _, dir := range []string{filepath.Join(project, "nodes"), filepath.Join(repo, "other")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write := func(p, body string) {
+		t.Helper()
+
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(project, "values.yaml"), "a: 1\n")
+	write(filepath.Join(project, "nodes", "cp1.yaml"), "# talm: nodes=[\"192.0.2.10\"]\n")
+	write(filepath.Join(repo, "other", "x.txt"), "x\n")
+
+	runGit(t, repo, "init", "-q")
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-q", "-m", "base")
+	runGit(t, repo, "tag", "base")
+
+	write(filepath.Join(project, "values.yaml"), "a: 2\n")
+	write(filepath.Join(project, "nodes", "cp2.yaml"), "# talm: nodes=[\"192.0.2.11\"]\n")
+	write(filepath.Join(repo, "other", "x.txt"), "y\n")
+
+	got, err := changedSinceRef(project, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(got)
+
+	if want := []string{"nodes/cp2.yaml", "values.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changed = %v, want %v", got, want)
+	}
+
+	_, err = changedSinceRef(project, "no-such-ref")
+	if err == nil || !strings.Contains(strings.Join(errors.GetAllHints(err), "\n"), "fetch-depth") {
+		t.Errorf("unknown ref error = %v", err)
+	}
+
+	if _, err := changedSinceRef(project, "--output=/tmp/x"); err == nil || !strings.Contains(err.Error(), "invalid --since-ref") {
+		t.Errorf("option-like ref error = %v", err)
+	}
+}
+
+// TestFilterFilesSinceRef pins the end-to-end selection and the
+// affected-set report on stderr.
+func TestFilterFilesSinceRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	origRoot, origTemplates, origFromArgs := Config.RootDir, templateCmdFlags.templateFiles, templateCmdFlags.templatesFromArgs
+	t.Cleanup(func() {
+		Config.RootDir, templateCmdFlags.templateFiles, templateCmdFlags.templatesFromArgs = origRoot, origTemplates, origFromArgs
+	})
+
+	root := writePruneProject(t, map[string]string{
+		"nodes/cp1.yaml":              "# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		"nodes/w1.yaml":               "# talm: nodes=[\"192.0.2.20\"], templates=[\"templates/worker.yaml\"]\n",
+		"templates/controlplane.yaml": "cp\n",
+		"templates/worker.yaml":       "w\n",
+	})
+
+	runGit(t, root, "init", "-q")
+	runGit(t, root, "add", "-A")
+	runGit(t, root, "commit", "-q", "-m", "base")
+
+	if err := os.WriteFile(filepath.Join(root, "templates", "worker.yaml"), []byte("w2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	Config.RootDir = root
+	templateCmdFlags.templatesFromArgs = false
+
+	files := []string{filepath.Join(root, "nodes", "cp1.yaml"), filepath.Join(root, "nodes", "w1.yaml")}
+
+	var progress bytes.Buffer
+
+	got, err := filterFilesSinceRef(files, "HEAD", &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := files[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected = %v, want %v", got, want)
+	}
+
+	if !strings.Contains(progress.String(), "1 of 2 node file(s) affected since HEAD") || !strings.Contains(progress.String(), "templates/worker.yaml changed") {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// validateUpgradeCanary rejects the canary strategy where it cannot
+// work. A maintenance-mode node cannot be health-checked, and a
+// staged upgrade only runs after the next reboot, so the canary
+// would be checked on the image it already had.
+func validateUpgradeCanary(insecure, staged bool) error {
+	if !upgradeCmdFlags.canary.enabled() {
+		return nil
+	}
+
+	switch {
+	case insecure:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--strategy canary cannot be used with --insecure"),
+			"a node in maintenance mode cannot be health-checked; upgrade it with the default strategy",
+		)
+	case staged:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.New("--strategy canary cannot be used with --stage"),
+			"a staged upgrade takes effect on the next reboot, after the canary has been checked; drop --stage or use the default strategy",
+		)
+	}
+
+	return nil
+}
+
+// upgradeCanary runs upgradeTargets canary first over the upgrade
+// targets, narrowing GlobalArgs.Nodes to each part of the group in
+// turn. A canary that fails its health checks is rolled back with the
+// machine Rollback API, which boots the Talos image the node ran
+// before the upgrade. It returns what the last upgradeTargets call
+// reported about syncing the node bodies.
+func upgradeCanary(upgradeTargets func() (bool, error)) (bool, error) {
+	saved := append([]string(nil), GlobalArgs.Nodes...)
+	defer func() { GlobalArgs.Nodes = saved }()
+
+	var syncBodies bool
+
+	err := WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		ctxNodes := []string(nil)
+		if cfg := c.GetConfigContext(); cfg != nil {
+			ctxNodes = cfg.Nodes
+		}
+
+		nodes := resolveUpgradeTargetNodes(saved, ctxNodes)
+
+		return runCanary(ctx, nodes, upgradeCmdFlags.canary, canarySteps{
+			rollout: func(group []string) error {
+				GlobalArgs.Nodes = append([]string(nil), group...)
+
+				var err error
+
+				syncBodies, err = upgradeTargets()
+
+				return err
+			},
+			health: canaryHealthCheck(c),
+			rollback: func(ctx context.Context, node string, _ []byte) error {
+				ui.Infof(os.Stderr, "Canary: rolling %s back to its previous Talos image", node)
+
+				return errors.Wrap(c.Rollback(client.WithNode(ctx, node)), "rolling back the upgrade")
+			},
+		}, os.Stderr)
+	})
+
+	return syncBodies, err
+}
@@ -15,15 +15,21 @@
 package commands
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	taloscommands "github.com/siderolabs/talos/cmd/talosctl/cmd/talos"
 	"github.com/siderolabs/talos/pkg/machinery/client"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 const (
@@ -47,7 +53,12 @@ const (
 //nolint:gochecknoglobals // command-scoped flag struct, mirrors applyCmdFlags pattern.
 var upgradeCmdFlags struct {
 	skipPostUpgradeVerify      bool
+	skipImagePullCheck         bool
+	imagePullTimeout           time.Duration
 	postUpgradeReconcileWindow time.Duration
+	skipDrain                  bool
+	canary                     canaryOptions
+	ignoreWindow               bool
 }
 
 // validatePostUpgradeReconcileWindow rejects non-positive durations.
@@ -70,6 +81,34 @@ func validatePostUpgradeReconcileWindow(window time.Duration) error {
 	return nil
 }
 
+// applyUpgradeDrain settles upstream's --drain before the upgrade
+// runs. talosctl drains by default; upgradeOptions.drain in Chart.yaml
+// replaces that default, an explicit --drain still wins over it, and
+// --skip-drain turns draining off for one run whatever the rest say.
+// A talosctl without the flag has no drain to steer.
+func applyUpgradeDrain(flags *pflag.FlagSet, skip bool, chartDefault *bool) error {
+	if flags.Lookup("drain") == nil {
+		return nil
+	}
+
+	var value string
+
+	switch {
+	case skip:
+		value = "false"
+	case !flags.Changed("drain") && chartDefault != nil:
+		value = strconv.FormatBool(*chartDefault)
+	default:
+		return nil
+	}
+
+	if err := flags.Set("drain", value); err != nil {
+		return errors.Wrap(err, "setting --drain")
+	}
+
+	return nil
+}
+
 // wrapUpgradeCommand adds special handling for upgrade command: extract image from config and set --image flag
 //
 //nolint:gocognit,gocyclo,cyclop,funlen // cobra wrapper branching over (image extraction, file paths, modeline) for the upgrade flow; each branch is short.
@@ -93,6 +132,23 @@ secrets.yaml); its modeline supplies the nodes / endpoints. The
 node body's machine.install.image is no longer consulted by the
 upgrade flow.
 
+Rolling upgrades (several -f files, or a directory, without --nodes):
+  - the node files are upgraded one at a time: those whose body sets
+    machine.type controlplane (or whose modeline names
+    controlplane.yaml) first, then the workers, each group in path
+    order. Each file's post-upgrade verify waits for its nodes
+    before the next file starts, and the first failure stops the
+    rollout.
+  - talosctl's --stage and --preserve apply to every file.
+
+Image pull check (unless --insecure or --skip-image-pull-check):
+  - before a node is drained or upgraded, it pulls the target image
+    through its own Talos API, with its registry mirrors and
+    credentials. An unreachable registry, a missing credential or a
+    digest mismatch fails the upgrade then, instead of leaving the
+    node to fail the pull mid-upgrade. The pulled image is reused by
+    the upgrade.
+
 Post-upgrade sync (when the upgrade succeeds):
   - talm point-patches machine.install.image in every -f node body
     to the image that was applied. Keeps the body consistent with
@@ -113,6 +169,17 @@ Post-upgrade sync (when the upgrade succeeds):
 	wrappedCmd.Flags().DurationVar(&upgradeCmdFlags.postUpgradeReconcileWindow, "post-upgrade-reconcile-window", defaultPostUpgradeReconcileWindow,
 		"how long to wait after upgrade returns before re-reading the running version; widen for slow hardware / large image pulls")
 
+	wrappedCmd.Flags().BoolVar(&upgradeCmdFlags.skipImagePullCheck, "skip-image-pull-check", false,
+		"skip pulling the target image on every node before the upgrade starts (catches an unreachable registry, missing credentials or a digest mismatch before the node reboots)")
+
+	wrappedCmd.Flags().DurationVar(&upgradeCmdFlags.imagePullTimeout, "image-pull-timeout", defaultUpgradeImagePullTimeout,
+		"how long each node may take to pull the target image before the upgrade")
+
+	wrappedCmd.Flags().BoolVar(&upgradeCmdFlags.skipDrain, "skip-drain", false,
+		"do not drain the Kubernetes nodes before they reboot; overrides --drain and Chart.yaml upgradeOptions.drain")
+	addCanaryFlags(wrappedCmd.Flags(), &upgradeCmdFlags.canary)
+	wrappedCmd.Flags().BoolVar(&upgradeCmdFlags.ignoreWindow, ignoreWindowFlag, false, ignoreWindowFlagUsage)
+
 	// Shell completion for `talm upgrade --file`: returns modelined
 	// yaml files under <root>/nodes/. ValidArgsFunction is NOT
 	// wired because upstream's upgrade command declares no
@@ -132,6 +199,18 @@ Post-upgrade sync (when the upgrade succeeds):
 			return err
 		}
 
+		if err := validateCanaryOptions(upgradeCmdFlags.canary); err != nil {
+			return err
+		}
+
+		if upgradeCmdFlags.imagePullTimeout <= 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("--image-pull-timeout must be a positive duration; got %s", upgradeCmdFlags.imagePullTimeout),
+				"pass a positive duration like 5m, or --skip-image-pull-check to skip the pull",
+			)
+		}
+
 		// Get config files from --file flag
 		var filesToProcess []string
 
@@ -185,9 +264,21 @@ Post-upgrade sync (when the upgrade succeeds):
 				// Flag might not exist (extremely unlikely given
 				// upgradeCmd registers it); fall through with a
 				// warning rather than aborting.
-				fmt.Fprintf(os.Stderr, "Warning: failed to set --image flag: %v\n", err)
+				ui.Warnf(os.Stderr, "failed to set --image flag: %v", err)
 			} else {
-				fmt.Fprintf(os.Stderr, "Using image from values.yaml: %s\n", image)
+				ui.Infof(os.Stderr, "Using image from values.yaml: %s", image)
+			}
+		}
+
+		// Several node files without --nodes roll out one file at a
+		// time, the control plane first; each file's post-upgrade
+		// verify waits for its nodes before the next file starts.
+		var rollout []upgradeRolloutStep
+
+		if len(filesToProcess) > 1 && !cmd.Flags().Changed("nodes") {
+			rollout, err = planUpgradeRollout(filesToProcess)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -204,64 +295,180 @@ Post-upgrade sync (when the upgrade succeeds):
 		insecure, _ := cmd.Flags().GetBool("insecure")
 		staged, _ := cmd.Flags().GetBool("stage")
 
-		// Execute original command
-		var execErr error
+		if err := validateUpgradeCanary(insecure, staged); err != nil {
+			return err
+		}
 
-		switch {
-		case originalRunE != nil:
-			execErr = originalRunE(cmd, args)
-		case wrappedCmd.Run != nil:
-			wrappedCmd.Run(cmd, args)
+		// A staged upgrade only takes effect on a later reboot, which
+		// is where the window matters.
+		if !staged {
+			if err := enforceMaintenanceWindows(Config.RootDir, GlobalArgs.Nodes, time.Now(), upgradeCmdFlags.ignoreWindow, os.Stderr); err != nil {
+				return err
+			}
 		}
 
-		if execErr != nil {
-			return execErr
+		if err := confirmDestructive(upgradeConfirmation(targetImage, staged)); err != nil {
+			return err
 		}
 
-		// Phase 2C: post-upgrade version verify. Detects the silent
-		// auto-rollback case: talosctl upgrade acks the RPC, Talos
-		// pulls + writes the new install, A/B boot fails its
-		// readiness check, Talos rolls back to the prior partition,
-		// and the operator's "successful" upgrade silently no-ops.
-		// Skip predicate documents the cases where this gate cannot
-		// produce a meaningful result.
-		if !shouldRunPostUpgradeVerify(insecure, staged, upgradeCmdFlags.skipPostUpgradeVerify) {
-			// Verify skipped (operator opt-out / insecure / staged).
-			// Still sync node bodies: the RPC was acked, and skipping
-			// the verify is an explicit operator choice — the body
-			// must track what talosctl was asked to install so the
-			// next `talm apply` does not silently revert install.image
-			// over the chart-rendered new value.
-			return writeBackInstallImageToFiles(filesToProcess, targetImage)
+		if err := applyUpgradeDrain(cmd.Flags(), upgradeCmdFlags.skipDrain, Config.UpgradeOptions.Drain); err != nil {
+			return err
 		}
 
-		if targetImage == "" {
-			fmt.Fprintln(os.Stderr, "post-upgrade verify: skipped, no target image to compare against")
+		// upgradeTargets upgrades GlobalArgs.Nodes and verifies the
+		// result. It reports whether the node bodies should follow the
+		// target image.
+		upgradeTargets := func() (bool, error) {
+			// The nodes pull the image before anything else happens to
+			// them: a registry they cannot reach fails the upgrade
+			// here, not after a drain and a reboot.
+			if shouldRunUpgradeImagePullCheck(insecure, upgradeCmdFlags.skipImagePullCheck, targetImage) {
+				if err := runUpgradeImagePullCheck(targetImage); err != nil {
+					return false, err
+				}
+			}
+
+			// Execute original command
+			var execErr error
+
+			restoreStdout := stdoutToStderr()
+
+			switch {
+			case originalRunE != nil:
+				execErr = originalRunE(cmd, args)
+			case wrappedCmd.Run != nil:
+				wrappedCmd.Run(cmd, args)
+			}
+
+			restoreStdout()
+
+			if execErr != nil {
+				return false, execErr
+			}
+
+			// Phase 2C: post-upgrade version verify. Detects the silent
+			// auto-rollback case: talosctl upgrade acks the RPC, Talos
+			// pulls + writes the new install, A/B boot fails its
+			// readiness check, Talos rolls back to the prior partition,
+			// and the operator's "successful" upgrade silently no-ops.
+			// Skip predicate documents the cases where this gate cannot
+			// produce a meaningful result.
+			if !shouldRunPostUpgradeVerify(insecure, staged, upgradeCmdFlags.skipPostUpgradeVerify) {
+				// Verify skipped (operator opt-out / insecure / staged).
+				// Still sync node bodies: the RPC was acked, and skipping
+				// the verify is an explicit operator choice — the body
+				// must track what talosctl was asked to install so the
+				// next `talm apply` does not silently revert install.image
+				// over the chart-rendered new value.
+				return true, nil
+			}
+
+			if targetImage == "" {
+				ui.Infof(os.Stderr, "post-upgrade verify: skipped, no target image to compare against")
 
-			return nil
+				return false, nil
+			}
+
+			if err := runPostUpgradeVersionVerify(cmd.Context(), targetImage); err != nil {
+				return false, err
+			}
+
+			// Verify did not block — patch the node body. The verify
+			// helper returns nil on three shapes: (a) running version
+			// matches the target (the common case), (b) zero nodes were
+			// resolved (rare — the talosctl upgrade RPC above would have
+			// also no-op'd, so the patch is vacuously consistent with
+			// what hit the cluster), (c) the version reader surrendered
+			// silently (reserved future contract). A failed verify
+			// (auto-rollback detected) returns non-nil and was already
+			// returned above, intentionally leaving the body untouched:
+			// the body still points at the pre-upgrade image, which
+			// matches what the node ended up running. An operator who
+			// fixes the rollback cause and re-runs upgrade will sync the
+			// body on the next pass that clears verify.
+			return true, nil
 		}
 
-		if err := runPostUpgradeVersionVerify(cmd.Context(), targetImage); err != nil {
-			return err
+		upgradeFiles := func(files []string) error {
+			var (
+				syncBodies bool
+				err        error
+			)
+
+			if upgradeCmdFlags.canary.enabled() {
+				syncBodies, err = upgradeCanary(upgradeTargets)
+			} else {
+				syncBodies, err = upgradeTargets()
+			}
+
+			currentJournal().noteUpgrade(GlobalArgs.Nodes, targetImage, err)
+
+			if err != nil || !syncBodies {
+				return err
+			}
+
+			return writeBackInstallImageToFiles(files, targetImage)
 		}
 
-		// Verify did not block — patch the node body. The verify
-		// helper returns nil on three shapes: (a) running version
-		// matches the target (the common case), (b) zero nodes were
-		// resolved (rare — the talosctl upgrade RPC above would have
-		// also no-op'd, so the patch is vacuously consistent with
-		// what hit the cluster), (c) the version reader surrendered
-		// silently (reserved future contract). A failed verify
-		// (auto-rollback detected) returns non-nil and was already
-		// returned above, intentionally leaving the body untouched:
-		// the body still points at the pre-upgrade image, which
-		// matches what the node ended up running. An operator who
-		// fixes the rollback cause and re-runs upgrade will sync the
-		// body on the next pass that clears verify.
-		return writeBackInstallImageToFiles(filesToProcess, targetImage)
+		run := func() error {
+			if len(rollout) == 0 {
+				return upgradeFiles(filesToProcess)
+			}
+
+			return runUpgradeRollout(rollout, func(step upgradeRolloutStep) error {
+				restore := narrowUpgradeNodes(step.nodes)
+				defer restore()
+
+				return upgradeFiles([]string{step.file})
+			}, os.Stderr)
+		}
+
+		return withOutputReport(cmd.OutOrStdout(), "upgrade", false, func() error {
+			// Upgrades anchored in a project (-f) are recorded in its
+			// history; without one there is no project state to write to.
+			if len(filesToProcess) == 0 {
+				return run()
+			}
+
+			return withUpgradeHistory(filesToProcess[0], targetImage, func() error {
+				if !insecure {
+					readUpgradeVersionsBefore()
+				}
+
+				return run()
+			})
+		})
+	}
+}
+
+// narrowUpgradeNodes points the upgrade at nodes, in talm's arguments
+// and in the copy the wrapped talosctl command reads, and returns the
+// function that restores both.
+func narrowUpgradeNodes(nodes []string) func() {
+	saved, savedTalos := GlobalArgs.Nodes, taloscommands.GlobalArgs.Nodes
+
+	GlobalArgs.Nodes = append([]string(nil), nodes...)
+	taloscommands.GlobalArgs.Nodes = append([]string(nil), nodes...)
+
+	return func() {
+		GlobalArgs.Nodes, taloscommands.GlobalArgs.Nodes = saved, savedTalos
 	}
 }
 
+// upgradeConfirmation summarises an upgrade for the confirmation
+// prompt.
+func upgradeConfirmation(image string, staged bool) confirmation {
+	c := confirmation{action: "Upgrade Talos and reboot the nodes"}
+	if staged {
+		c.action = "Stage a Talos upgrade for the next reboot"
+	}
+
+	c.addTarget(GlobalArgs.Nodes)
+	c.add("Image", cmp.Or(image, "the install image in the node configuration"))
+
+	return c
+}
+
 // shouldRunPostUpgradeVerify is the pure predicate for Phase 2C
 // scheduling. The gate cannot produce a meaningful result when:
 //
@@ -327,6 +534,27 @@ func runPostUpgradeVersionVerify(parentCtx context.Context, image string) error
 	})
 }
 
+// readUpgradeVersionsBefore notes the Talos version every upgrade
+// target runs before the upgrade, for the history record. Best-effort:
+// a node that cannot be read has no "before" version in the record,
+// and the upgrade itself surfaces connection problems.
+func readUpgradeVersionsBefore() {
+	_ = WithClient(func(ctx context.Context, c *client.Client) error {
+		ctxNodes := []string(nil)
+		if cfg := c.GetConfigContext(); cfg != nil {
+			ctxNodes = cfg.Nodes
+		}
+
+		read := cosiVersionReader(c)
+
+		for _, node := range resolveUpgradeTargetNodes(GlobalArgs.Nodes, ctxNodes) {
+			_, _, _ = journalVersionReader(read, node, false)(client.WithNode(ctx, node))
+		}
+
+		return nil
+	})
+}
+
 // resolveUpgradeTargetNodes picks the per-node target list for the
 // post-upgrade verify. CLI `--nodes` wins outright when non-empty;
 // otherwise the talosconfig context's pre-configured node list is
@@ -383,7 +611,7 @@ func runPostUpgradeVersionVerifyInner(
 
 	for _, node := range nodes {
 		nodeCtx := client.WithNode(clientCtx, node)
-		if err := verifyPostUpgradeVersion(nodeCtx, read, image, reconcileWindow, stderr); err != nil {
+		if err := verifyPostUpgradeVersion(nodeCtx, journalVersionReader(read, node, true), image, reconcileWindow, stderr); err != nil {
 			perNodeErrs = append(perNodeErrs, errors.Wrapf(err, "node %s", node))
 		}
 	}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // TestResolveUpgradeTargetNodes_CLINodesWin pins the resolution
@@ -303,7 +304,13 @@ func TestUpgradeFlag_PostUpgradeReconcileWindow_Registered(t *testing.T) {
 	// Usage string also carries "(default ...)", `--help` renders
 	// two confusing clauses. Pin that there is exactly one literal
 	// "(default" substring in the rendered line.
-	rendered := cmd.UsageString()
+	var rendered string
+
+	for line := range strings.Lines(cmd.UsageString()) {
+		if strings.Contains(line, "--post-upgrade-reconcile-window") {
+			rendered = line
+		}
+	}
 
 	if count := strings.Count(rendered, "(default"); count != 1 {
 		t.Errorf("--post-upgrade-reconcile-window: rendered Usage has %d '(default' clauses; want exactly 1 (cobra auto-appends, inline Usage must not duplicate)", count)
@@ -481,3 +488,62 @@ func TestPostUpgradeVersionMismatchHint_NoHardcoded90s(t *testing.T) {
 		t.Errorf("hint must not hardcode 90s — operators passing --post-upgrade-reconcile-window=<custom> see misleading copy; got: %q", postUpgradeVersionMismatchHint)
 	}
 }
+
+// TestApplyUpgradeDrain pins how talm steers upstream's --drain: on by
+// default as in talosctl, upgradeOptions.drain replaces the default, an
+// explicit --drain beats Chart.yaml and --skip-drain beats both.
+func TestApplyUpgradeDrain(t *testing.T) {
+	t.Parallel()
+
+	off, on := false, true
+
+	tests := []struct {
+		name  string
+		args  []string
+		skip  bool
+		chart *bool
+		want  bool
+	}{
+		{name: "upstream default", want: true},
+		{name: "chart turns it off", chart: &off, want: false},
+		{name: "chart turns it on", chart: &on, want: true},
+		{name: "flag beats chart", args: []string{"--drain"}, chart: &off, want: true},
+		{name: "skip-drain", skip: true, want: false},
+		{name: "skip-drain beats flag", args: []string{"--drain=true"}, skip: true, chart: &on, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var drain bool
+
+			flags := pflag.NewFlagSet(upgradeCmdName, pflag.ContinueOnError)
+			flags.BoolVar(&drain, "drain", true, "")
+
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyUpgradeDrain(flags, tt.skip, tt.chart); err != nil {
+				t.Fatal(err)
+			}
+
+			if drain != tt.want {
+				t.Errorf("--drain = %v, want %v", drain, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyUpgradeDrain_NoUpstreamFlag pins that a talosctl without
+// --drain is left alone rather than failing the upgrade.
+func TestApplyUpgradeDrain_NoUpstreamFlag(t *testing.T) {
+	t.Parallel()
+
+	flags := pflag.NewFlagSet(upgradeCmdName, pflag.ContinueOnError)
+
+	if err := applyUpgradeDrain(flags, true, nil); err != nil {
+		t.Errorf("applyUpgradeDrain = %v, want nil", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/api/common"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// defaultUpgradeImagePullTimeout bounds the pull of the installer
+// image on one node. An installer is a few hundred megabytes; a
+// registry that has not delivered it in this time would not deliver
+// it to the upgrade either.
+const defaultUpgradeImagePullTimeout = 5 * time.Minute
+
+// imagePuller pulls image into the system containerd of the node ctx
+// targets.
+type imagePuller func(ctx context.Context, image string) error
+
+// talosImagePuller is the imagePuller of a Talos client.
+func talosImagePuller(c *client.Client) imagePuller {
+	return func(ctx context.Context, image string) error {
+		//nolint:staticcheck,wrapcheck // SA1019: the MachineService pull also reaches nodes older than the ImageService; the node's error is surfaced by checkUpgradeImagePull.
+		return c.ImagePull(ctx, common.ContainerdNamespace_NS_SYSTEM, image)
+	}
+}
+
+// shouldRunUpgradeImagePullCheck reports whether the pre-pull gate
+// runs. It needs a target image, and the authenticated API a node in
+// maintenance mode (--insecure) does not serve.
+func shouldRunUpgradeImagePullCheck(insecure, skip bool, image string) bool {
+	return !skip && !insecure && image != ""
+}
+
+// runUpgradeImagePullCheck pulls image on every upgrade target before
+// the upgrade starts.
+//
+//nolint:contextcheck // intentional ctx boundary at WithClient.
+func runUpgradeImagePullCheck(image string) error {
+	return WithClient(func(ctx context.Context, c *client.Client) error {
+		ctxNodes := []string(nil)
+		if cfg := c.GetConfigContext(); cfg != nil {
+			ctxNodes = cfg.Nodes
+		}
+
+		nodes := resolveUpgradeTargetNodes(GlobalArgs.Nodes, ctxNodes)
+
+		return checkUpgradeImagePull(ctx, nodes, image, talosImagePuller(c), upgradeCmdFlags.imagePullTimeout, os.Stderr)
+	})
+}
+
+// checkUpgradeImagePull is the pre-pull gate of talm upgrade: each
+// node pulls the installer image through its own Talos API, with its
+// own registry mirrors and credentials, before it is told to upgrade.
+// The upgrade would otherwise find an unreachable registry, a missing
+// credential or a digest that does not match only after the node
+// started it. The pulled image stays in the node's image store, so the
+// upgrade does not download it again.
+//
+// Every node is tried, and the failures are reported together. A node
+// whose API cannot pull images is reported and skipped.
+func checkUpgradeImagePull(ctx context.Context, nodes []string, image string, pull imagePuller, timeout time.Duration, w io.Writer) error {
+	var failed []error
+
+	for _, node := range nodes {
+		ui.Infof(w, "Pulling %s on %s before the upgrade", image, node)
+
+		nodeCtx, cancel := context.WithTimeout(client.WithNode(ctx, node), timeout)
+		err := pull(nodeCtx, image)
+		timedOut := errors.Is(nodeCtx.Err(), context.DeadlineExceeded)
+
+		cancel()
+
+		if err == nil {
+			continue
+		}
+
+		if timedOut {
+			failed = append(failed, errors.Newf("node %s: the pull did not finish in %s", node, timeout))
+
+			continue
+		}
+
+		st := status.Convert(err)
+		if st.Code() == codes.Unimplemented {
+			ui.Warnf(w, "node %s cannot pull images through its API: %s; skipping the pull check", node, st.Message())
+
+			continue
+		}
+
+		failed = append(failed, errors.Newf("node %s: %s", node, st.Message()))
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Wrapf(errors.Join(failed...), "the upgrade image %s cannot be pulled; the nodes were not upgraded", image),
+		"check that the registry is reachable from the nodes, that machine.registries holds its credentials or mirror, and that a digest-pinned image matches the registry; pass --skip-image-pull-check to upgrade anyway",
+	)
+}
@@ -0,0 +1,168 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testUpgradeImage = "ghcr.io/cozystack/cozystack/talos:v1.12.1"
+
+// nodePuller answers each node with the error in errs, recording the
+// nodes it was asked on.
+func nodePuller(errs map[string]error, asked *[]string) imagePuller {
+	return func(ctx context.Context, image string) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		node := strings.Join(md.Get("node"), ",")
+
+		*asked = append(*asked, node)
+
+		if image != testUpgradeImage {
+			return errors.Newf("unexpected image %s", image)
+		}
+
+		return errs[node]
+	}
+}
+
+// TestShouldRunUpgradeImagePullCheck pins when the gate runs.
+func TestShouldRunUpgradeImagePullCheck(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		insecure, skip bool
+		image          string
+		want           bool
+	}{
+		{false, false, testUpgradeImage, true},
+		{true, false, testUpgradeImage, false},
+		{false, true, testUpgradeImage, false},
+		{false, false, "", false},
+	}
+
+	for _, tc := range cases {
+		if got := shouldRunUpgradeImagePullCheck(tc.insecure, tc.skip, tc.image); got != tc.want {
+			t.Errorf("insecure=%v skip=%v image=%q: got %v, want %v", tc.insecure, tc.skip, tc.image, got, tc.want)
+		}
+	}
+}
+
+// TestCheckUpgradeImagePull_AllPull pins the quiet success on every
+// node.
+func TestCheckUpgradeImagePull_AllPull(t *testing.T) {
+	t.Parallel()
+
+	var (
+		asked []string
+		out   bytes.Buffer
+	)
+
+	err := checkUpgradeImagePull(context.Background(), []string{"192.0.2.11", "192.0.2.12"}, testUpgradeImage, nodePuller(nil, &asked), time.Minute, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(asked, " ") != "192.0.2.11 192.0.2.12" {
+		t.Errorf("asked = %v", asked)
+	}
+
+	if !strings.Contains(out.String(), "Pulling "+testUpgradeImage+" on 192.0.2.12") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+// TestCheckUpgradeImagePull_ReportsEveryFailure pins that every node
+// is tried and the registry errors are reported together, with the
+// escape hatch in the hint.
+func TestCheckUpgradeImagePull_ReportsEveryFailure(t *testing.T) {
+	t.Parallel()
+
+	var asked []string
+
+	errs := map[string]error{
+		"192.0.2.11": status.Error(codes.Unknown, "failed to resolve reference: 401 Unauthorized"),
+		"192.0.2.13": status.Error(codes.Unknown, "dial tcp: lookup ghcr.io: no such host"),
+	}
+
+	err := checkUpgradeImagePull(context.Background(), []string{"192.0.2.11", "192.0.2.12", "192.0.2.13"}, testUpgradeImage, nodePuller(errs, &asked), time.Minute, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if len(asked) != 3 {
+		t.Errorf("asked = %v, want every node", asked)
+	}
+
+	for _, want := range []string{"node 192.0.2.11: failed to resolve reference: 401 Unauthorized", "node 192.0.2.13: dial tcp", "the nodes were not upgraded"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to mention %q", err, want)
+		}
+	}
+
+	if strings.Contains(err.Error(), "192.0.2.12") {
+		t.Errorf("err = %v names the node that pulled", err)
+	}
+
+	if hint := strings.Join(errors.GetAllHints(err), " "); !strings.Contains(hint, "--skip-image-pull-check") {
+		t.Errorf("hint = %q", hint)
+	}
+}
+
+// TestCheckUpgradeImagePull_UnimplementedSkips pins that a node whose
+// API has no image pull is warned about, not failed.
+func TestCheckUpgradeImagePull_UnimplementedSkips(t *testing.T) {
+	t.Parallel()
+
+	var (
+		asked []string
+		out   bytes.Buffer
+	)
+
+	errs := map[string]error{"192.0.2.11": status.Error(codes.Unimplemented, "unknown method ImagePull")}
+
+	if err := checkUpgradeImagePull(context.Background(), []string{"192.0.2.11"}, testUpgradeImage, nodePuller(errs, &asked), time.Minute, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "cannot pull images through its API") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+// TestCheckUpgradeImagePull_Timeout pins the message of a pull that
+// outlives its timeout.
+func TestCheckUpgradeImagePull_Timeout(t *testing.T) {
+	t.Parallel()
+
+	slow := func(ctx context.Context, _ string) error {
+		<-ctx.Done()
+
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	err := checkUpgradeImagePull(context.Background(), []string{"192.0.2.11"}, testUpgradeImage, slow, 10*time.Millisecond, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "node 192.0.2.11: the pull did not finish in 10ms") {
+		t.Errorf("err = %v", err)
+	}
+}
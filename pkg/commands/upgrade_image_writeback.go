@@ -22,6 +22,9 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 // nodeBodyYAMLIndent pins the YAML indent the writeback emits at.
@@ -67,7 +70,20 @@ func writeBackInstallImageToNodeBody(filePath, newImage string) (bool, error) {
 		return false, errors.Wrapf(err, "reading node body %s", filePath)
 	}
 
-	docs, err := decodeAllYAMLDocs(data)
+	original := data
+
+	// A JSON node file is patched through its YAML form and written
+	// back as JSON; there are no comments to keep.
+	var jsonModeline *modeline.Config
+
+	if modeline.IsJSONNodeFile(data) {
+		jsonModeline, data, err = modeline.ParseJSONNodeFile(data)
+		if err != nil {
+			return false, errors.Wrapf(err, "parsing node body %s", filePath)
+		}
+	}
+
+	docs, err := decodeAllYAMLDocs(toLF(data))
 	if err != nil {
 		return false, errors.Wrapf(err, "parsing node body %s", filePath)
 	}
@@ -81,7 +97,7 @@ func writeBackInstallImageToNodeBody(filePath, newImage string) (bool, error) {
 		// No document carries the key — orphan / side-patch /
 		// truncated file. Surface the skip explicitly so an operator
 		// who passed a file by mistake sees the no-op.
-		fmt.Fprintf(os.Stderr, "Skipped %s: no machine.install.image key (orphan or side-patch shape)\n", filePath)
+		ui.Infof(os.Stderr, "Skipped %s: no machine.install.image key (orphan or side-patch shape)", filePath)
 
 		return false, nil
 	}
@@ -99,6 +115,15 @@ func writeBackInstallImageToNodeBody(filePath, newImage string) (bool, error) {
 		return false, errors.Wrapf(err, "re-marshalling node body %s", filePath)
 	}
 
+	if jsonModeline != nil {
+		out, err = modeline.EncodeJSONNodeFile(jsonModeline, out)
+		if err != nil {
+			return false, errors.Wrapf(err, "re-marshalling node body %s", filePath)
+		}
+	}
+
+	out = keepLineEnding(out, original)
+
 	// Resolve the file's mode bits. os.WriteFile applies its mode
 	// argument ONLY when it creates the file; on the truncate-and-
 	// rewrite path (the common case here — the file already exists)
@@ -306,7 +331,7 @@ func writeBackInstallImageToFiles(files []string, newImage string) error {
 		// signal needed). A "Skipped … Synced …" pair on the same
 		// file would be misleading.
 		if patched {
-			fmt.Fprintf(os.Stderr, "Synced machine.install.image in %s to %s\n", filePath, newImage)
+			ui.Infof(os.Stderr, "Synced machine.install.image in %s to %s", filePath, newImage)
 		}
 	}
 
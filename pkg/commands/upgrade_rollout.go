@@ -0,0 +1,167 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/modeline"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+const (
+	machineTypeControlPlane = "controlplane"
+	machineTypeInit         = "init"
+	machineTypeWorker       = "worker"
+)
+
+// upgradeRolloutStep is one node file of a rolling upgrade and the
+// nodes its modeline targets.
+type upgradeRolloutStep struct {
+	file        string
+	machineType string
+	nodes       []string
+}
+
+// planUpgradeRollout orders files for a rolling upgrade: the control
+// plane node files first, then the others, each group in the order
+// given, so the workers only move once the control plane runs the new
+// image.
+func planUpgradeRollout(files []string) ([]upgradeRolloutStep, error) {
+	steps := make([]upgradeRolloutStep, 0, len(files))
+
+	for _, file := range files {
+		_, modelineConfig, err := modeline.FindAndParseModeline(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing modeline in %s", file)
+		}
+
+		if len(modelineConfig.Nodes) == 0 {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("the modeline of %s targets no nodes", file),
+				"add the node to `# talm: nodes=[...]` in %s, or leave the file out of the upgrade", file,
+			)
+		}
+
+		machineType, err := nodeFileMachineType(file, modelineConfig.Templates)
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, upgradeRolloutStep{file: file, machineType: machineType, nodes: modelineConfig.Nodes})
+	}
+
+	slices.SortStableFunc(steps, func(a, b upgradeRolloutStep) int {
+		return upgradeRolloutRank(a.machineType) - upgradeRolloutRank(b.machineType)
+	})
+
+	return steps, nil
+}
+
+// upgradeRolloutRank is the position of a machine type in a rolling
+// upgrade: the control plane before everything else.
+func upgradeRolloutRank(machineType string) int {
+	if machineType == machineTypeControlPlane || machineType == machineTypeInit {
+		return 0
+	}
+
+	return 1
+}
+
+// nodeFileMachineType returns the machine.type the node file at path
+// sets. A file without one, such as a bare patch, falls back to the
+// template its modeline names: controlplane.yaml or worker.yaml, as the
+// presets call them. The result is empty when neither tells.
+func nodeFileMachineType(path string, templates []string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", path)
+	}
+
+	body, err := modeline.NodeFileBody(data)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", path)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		var doc struct {
+			Machine struct {
+				Type string `yaml:"type"`
+			} `yaml:"machine"`
+		}
+
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return "", errors.Wrapf(err, "parsing %s", path)
+		}
+
+		if doc.Machine.Type != "" {
+			return doc.Machine.Type, nil
+		}
+	}
+
+	for _, template := range templates {
+		switch strings.TrimSuffix(filepath.Base(filepath.FromSlash(template)), filepath.Ext(template)) {
+		case machineTypeControlPlane:
+			return machineTypeControlPlane, nil
+		case machineTypeWorker:
+			return machineTypeWorker, nil
+		}
+	}
+
+	return "", nil
+}
+
+// runUpgradeRollout upgrades the steps one after the other with
+// upgrade and stops at the first that fails, leaving the files after
+// it on their old image.
+func runUpgradeRollout(steps []upgradeRolloutStep, upgrade func(step upgradeRolloutStep) error, progress io.Writer) error {
+	for i, step := range steps {
+		role := step.machineType
+		if role == "" {
+			role = "unknown role"
+		}
+
+		ui.Infof(progress, "Rolling upgrade %d/%d: %s (%s), nodes %s", i+1, len(steps), step.file, role, strings.Join(step.nodes, ", "))
+
+		if err := upgrade(step); err != nil {
+			if rest := len(steps) - i - 1; rest > 0 {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHintf(
+					errors.Wrapf(err, "upgrading %s", step.file),
+					"the %d node file(s) after it were not upgraded; fix the failure and run the upgrade again", rest,
+				)
+			}
+
+			return errors.Wrapf(err, "upgrading %s", step.file)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// TestPlanUpgradeRollout pins the rollout order: control-plane files
+// first, by machine.type or by the modeline template, then the rest,
+// each group in the order given.
+func TestPlanUpgradeRollout(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		"nodes/a-worker.yaml": "# talm: nodes=[\"10.0.0.11\"], templates=[\"templates/worker.yaml\"]\nmachine:\n  type: worker\n",
+		"nodes/b-cp.yaml":     "# talm: nodes=[\"10.0.0.1\"], templates=[\"templates/controlplane.yaml\"]\nmachine:\n  network: {}\n",
+		"nodes/c-patch.yaml":  "# talm: nodes=[\"10.0.0.12\"]\nmachine:\n  network: {}\n",
+		"nodes/d-cp.yaml":     "# talm: nodes=[\"10.0.0.2\", \"10.0.0.3\"]\nmachine:\n  type: controlplane\n---\napiVersion: v1alpha1\nkind: HostnameConfig\n",
+	})
+
+	var files []string
+	for _, name := range []string{"a-worker", "b-cp", "c-patch", "d-cp"} {
+		files = append(files, filepath.Join(dir, "nodes", name+".yaml"))
+	}
+
+	steps, err := planUpgradeRollout(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, step := range steps {
+		got = append(got, filepath.Base(step.file)+":"+step.machineType+":"+strings.Join(step.nodes, ","))
+	}
+
+	want := []string{
+		"b-cp.yaml:controlplane:10.0.0.1",
+		"d-cp.yaml:controlplane:10.0.0.2,10.0.0.3",
+		"a-worker.yaml:worker:10.0.0.11",
+		"c-patch.yaml::10.0.0.12",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("rollout = %v, want %v", got, want)
+	}
+}
+
+func TestPlanUpgradeRollout_NoNodes(t *testing.T) {
+	t.Parallel()
+
+	dir := writePruneProject(t, map[string]string{
+		"nodes/cp.yaml": "# talm: nodes=[], templates=[\"templates/controlplane.yaml\"]\n",
+	})
+
+	_, err := planUpgradeRollout([]string{filepath.Join(dir, "nodes", "cp.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "targets no nodes") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestRunUpgradeRollout_StopsAtFailure pins that the files after a
+// failed one are left alone and the error says how many.
+func TestRunUpgradeRollout_StopsAtFailure(t *testing.T) {
+	t.Parallel()
+
+	steps := []upgradeRolloutStep{
+		{file: "nodes/cp.yaml", machineType: machineTypeControlPlane, nodes: []string{"10.0.0.1"}},
+		{file: "nodes/w1.yaml", machineType: machineTypeWorker, nodes: []string{"10.0.0.11"}},
+		{file: "nodes/w2.yaml", nodes: []string{"10.0.0.12"}},
+	}
+
+	var (
+		upgraded []string
+		progress bytes.Buffer
+	)
+
+	err := runUpgradeRollout(steps, func(step upgradeRolloutStep) error {
+		upgraded = append(upgraded, step.file)
+
+		if step.file == "nodes/w1.yaml" {
+			return errors.New("rolled back")
+		}
+
+		return nil
+	}, &progress)
+	if err == nil || !strings.Contains(err.Error(), "upgrading nodes/w1.yaml: rolled back") {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "the 1 node file(s) after it were not upgraded") {
+		t.Errorf("hint = %q", hints)
+	}
+
+	if !slices.Equal(upgraded, []string{"nodes/cp.yaml", "nodes/w1.yaml"}) {
+		t.Errorf("upgraded = %v", upgraded)
+	}
+
+	if !strings.Contains(progress.String(), "Rolling upgrade 1/3: nodes/cp.yaml (controlplane), nodes 10.0.0.1") {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// userConfigEnvVar overrides the location of the user defaults file.
+	userConfigEnvVar = "TALM_USER_CONFIG"
+
+	// userConfigFileName is the user defaults file, next to the kubectl
+	// plugin registry under the user config directory.
+	userConfigFileName = "config.yaml"
+)
+
+// userConfig is the per-user defaults file: flag values an operator
+// would otherwise type on every run. Flags applies to every command
+// that has the flag; Commands, keyed by the command path without the
+// binary name ("scan", "export patches"), to one command and wins over
+// Flags. A value is a scalar or, for a flag that repeats, a list.
+type userConfig struct {
+	Flags    map[string]any            `yaml:"flags"`
+	Commands map[string]map[string]any `yaml:"commands"`
+}
+
+// userConfigPath returns the defaults file: $TALM_USER_CONFIG when set,
+// otherwise <user config dir>/talm/config.yaml.
+func userConfigPath() (string, error) {
+	if path := os.Getenv(userConfigEnvVar); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "resolving user config directory")
+	}
+
+	return filepath.Join(configDir, pluginRegistryDirName, userConfigFileName), nil
+}
+
+// loadUserConfig reads the defaults file; a missing file, or a user
+// without a config directory, has no defaults.
+func loadUserConfig() (*userConfig, string, error) {
+	path, err := userConfigPath()
+	if err != nil {
+		return &userConfig{}, "", nil //nolint:nilerr // no config directory, no defaults.
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &userConfig{}, path, nil
+		}
+
+		return nil, "", errors.Wrapf(err, "reading %s", path)
+	}
+
+	config := &userConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, "", errors.WithHintf(
+			errors.Wrapf(err, "parsing %s", path),
+			"fix the file or move it away; set %s to use another one", userConfigEnvVar,
+		)
+	}
+
+	return config, path, nil
+}
+
+// ApplyUserDefaults sets the flags of cmd the user defaults file names
+// and the command line left unset. The values replace the built-in
+// defaults: the command line still wins, and a command that checks
+// whether a flag was given still sees it as not given.
+func ApplyUserDefaults(cmd *cobra.Command) error {
+	config, path, err := loadUserConfig()
+	if err != nil {
+		return err
+	}
+
+	return config.apply(cmd, path)
+}
+
+// apply sets the defaults of config on the flags of cmd. A flag named
+// for cmd under Commands that cmd does not have is an error, a typo
+// that would otherwise be ignored on every run; one under Flags is
+// skipped on the commands without it.
+func (c *userConfig) apply(cmd *cobra.Command, path string) error {
+	commandPath := userConfigCommandPath(cmd)
+
+	for name, value := range c.Flags {
+		if _, ok := c.Commands[commandPath][name]; ok {
+			continue
+		}
+
+		if flag := cmd.Flags().Lookup(name); flag != nil {
+			if err := setUserDefault(flag, value, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, value := range c.Commands[commandPath] {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("%s sets --%s for talm %s, which has no such flag", path, name, commandPath),
+				"run talm %s --help for its flags, and fix or remove the entry", commandPath,
+			)
+		}
+
+		if err := setUserDefault(flag, value, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setUserDefault sets value on flag unless the command line set it. A
+// list sets each item in turn, which replaces the built-in default of
+// a repeatable flag and then appends. Value.Set leaves flag.Changed
+// alone, so the value stays a default.
+func setUserDefault(flag *pflag.Flag, value any, path string) error {
+	if flag.Changed {
+		return nil
+	}
+
+	items, isList := value.([]any)
+	if !isList {
+		items = []any{value}
+	}
+
+	for _, item := range items {
+		if err := flag.Value.Set(fmt.Sprint(item)); err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Wrapf(err, "%s sets --%s to %v", path, flag.Name, value),
+				"the flag takes a %s; fix the entry", flag.Value.Type(),
+			)
+		}
+	}
+
+	return nil
+}
+
+// userConfigCommandPath is the path of cmd below the root command,
+// the key of its entry under Commands.
+func userConfigCommandPath(cmd *cobra.Command) string {
+	var names []string
+
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		names = append(names, c.Name())
+	}
+
+	slices.Reverse(names)
+
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,152 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// userConfigTestCommand is a root with a persistent --no-color and an
+// `export patches` subcommand with the flags of a scan, parsed from
+// args. It returns the subcommand and the variables its flags bind.
+func userConfigTestCommand(t *testing.T, args ...string) (*cobra.Command, *bool, *[]string, *time.Duration) {
+	t.Helper()
+
+	var (
+		noColor bool
+		cidrs   []string
+		timeout time.Duration
+	)
+
+	root := &cobra.Command{Use: "talm"}
+	root.PersistentFlags().BoolVar(&noColor, "no-color", false, "")
+
+	export := &cobra.Command{Use: "export"}
+	patches := &cobra.Command{Use: "patches"}
+	patches.Flags().StringSliceVar(&cidrs, "cidr", []string{"198.51.100.0/24"}, "")
+	patches.Flags().DurationVar(&timeout, "timeout", 2*time.Second, "")
+
+	export.AddCommand(patches)
+	root.AddCommand(export)
+
+	cmd, rest, err := root.Find([]string{"export", "patches"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.ParseFlags(append(rest, args...)); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd, &noColor, &cidrs, &timeout
+}
+
+func writeUserConfig(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(userConfigEnvVar, path)
+}
+
+// TestApplyUserDefaults pins the precedence: the command line over the
+// command's entry over the flags for every command over the built-in
+// default, with a list replacing a repeatable flag's default.
+//
+//nolint:paralleltest // t.Setenv.
+func TestApplyUserDefaults(t *testing.T) {
+	writeUserConfig(t, `flags:
+  no-color: true
+  timeout: 9s
+  as: alice
+commands:
+  export patches:
+    cidr: [192.0.2.0/24, 203.0.113.0/24]
+    timeout: 5s
+`)
+
+	cmd, noColor, cidrs, timeout := userConfigTestCommand(t)
+	if err := ApplyUserDefaults(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if !*noColor || *timeout != 5*time.Second || strings.Join(*cidrs, ",") != "192.0.2.0/24,203.0.113.0/24" {
+		t.Errorf("no-color %v, timeout %v, cidr %v", *noColor, *timeout, *cidrs)
+	}
+
+	if cmd.Flags().Changed("cidr") {
+		t.Error("a user default must not read as given on the command line")
+	}
+
+	cmd, _, cidrs, timeout = userConfigTestCommand(t, "--cidr", "10.0.0.0/24", "--timeout", "1s")
+	if err := ApplyUserDefaults(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if *timeout != time.Second || strings.Join(*cidrs, ",") != "10.0.0.0/24" {
+		t.Errorf("the command line must win: timeout %v, cidr %v", *timeout, *cidrs)
+	}
+}
+
+// TestApplyUserDefaults_NoFile pins that a missing file changes nothing.
+//
+//nolint:paralleltest // t.Setenv.
+func TestApplyUserDefaults_NoFile(t *testing.T) {
+	t.Setenv(userConfigEnvVar, filepath.Join(t.TempDir(), "missing.yaml"))
+
+	cmd, noColor, cidrs, _ := userConfigTestCommand(t)
+	if err := ApplyUserDefaults(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if *noColor || strings.Join(*cidrs, ",") != "198.51.100.0/24" {
+		t.Errorf("no-color %v, cidr %v, want the built-in defaults", *noColor, *cidrs)
+	}
+}
+
+// TestApplyUserDefaults_Errors pins the entries refused with the file
+// named: a flag the command lacks, a value the flag does not take and
+// a file that does not parse.
+//
+//nolint:paralleltest // t.Setenv.
+func TestApplyUserDefaults_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, want string
+	}{
+		{name: "unknown flag", content: "commands:\n  export patches:\n    cidrs: [192.0.2.0/24]\n", want: "has no such flag"},
+		{name: "bad value", content: "commands:\n  export patches:\n    timeout: soon\n", want: "sets --timeout"},
+		{name: "bad yaml", content: "commands: [\n", want: "parsing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			writeUserConfig(t, tc.content)
+
+			cmd, _, _, _ := userConfigTestCommand(t)
+
+			err := ApplyUserDefaults(cmd)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
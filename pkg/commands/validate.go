@@ -0,0 +1,220 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the project's node files for conflicts and endpoint drift",
+	Long: `Run offline checks over the node files under nodes/:
+
+  - every node address is targeted by at most one node file, so two
+    files cannot apply conflicting configs to the same machine;
+  - YAML files without a talm modeline are listed, since no talm
+    command treats them as node files;
+  - the endpoints in the modelines and in the talosconfig context are
+    each a node address, the host of the values.yaml endpoint or its
+    floatingIP, and the endpoint and floatingIP agree. Anything else is
+    a warning: usually a VIP changed in values.yaml and not in the node
+    files or the talosconfig;
+  - the nodes of each node file render for one Talos version: the
+    talosVersion their values.yaml nodes entries pin, or the project
+    default. Node files pinned to another version contract than the
+    default are listed.
+
+The duplicate and endpoint checks also run before every talm apply, as
+warnings.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		if !Config.RootDirExplicit {
+			detectedRoot, err := detectRootFromCWD()
+			if err == nil && detectedRoot != "" {
+				Config.RootDir = detectedRoot
+			}
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runValidate(cmd.OutOrStdout(), ui.Progress(os.Stderr))
+	},
+}
+
+// duplicateNodeTarget is a node address that more than one node file
+// targets.
+type duplicateNodeTarget struct {
+	node  string
+	files []string
+}
+
+// runValidate writes its findings to out and the notes about files it
+// skipped to progress.
+func runValidate(out, progress io.Writer) error {
+	files, skipped, err := scanPruneNodeFiles(Config.RootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range skipped {
+		ui.Infof(progress, "Note: %s has no talm modeline and is not a node file", path)
+	}
+
+	duplicates := findDuplicateNodeTargets(files)
+	printDuplicateNodeTargets(out, duplicates)
+
+	drifts := collectEndpointDrift(Config.RootDir, files, progress)
+	printEndpointDrift(out, drifts)
+
+	pinned, versionProblems, err := collectNodeTalosVersions(Config.RootDir, files, Config.TemplateOptions.TalosVersion)
+	if err != nil {
+		return err
+	}
+
+	printNodeTalosVersions(out, pinned, versionProblems, Config.TemplateOptions.TalosVersion)
+
+	if len(duplicates) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("%d node address(es) targeted by more than one node file", len(duplicates)),
+			"keep each node in exactly one file under nodes/; fix the copied modeline's nodes=[…]",
+		)
+	}
+
+	if len(versionProblems) > 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("%d node file(s) target nodes pinned to different Talos versions", len(versionProblems)),
+			"a node file renders once for all its nodes; split it, or align %s.<node>.%s in %s", valuesNodesKey, nodeTalosVersionKey, valuesYamlName,
+		)
+	}
+
+	if len(drifts) > 0 {
+		fmt.Fprintf(out, "%d node file(s) checked, %d endpoint warning(s)\n", len(files), len(drifts))
+
+		return nil
+	}
+
+	fmt.Fprintf(out, "%d node file(s) checked, no problems found\n", len(files))
+
+	return nil
+}
+
+// warnDuplicateNodeTargets prints the project's duplicate node targets
+// to w before an apply. It never fails the apply: a project that
+// cannot be scanned still applies, as it did before this check.
+func warnDuplicateNodeTargets(rootDir string, w io.Writer) {
+	files, _, err := scanPruneNodeFiles(rootDir)
+	if err != nil {
+		ui.Warnf(w, "skipping the duplicate node check: %v", err)
+
+		return
+	}
+
+	duplicates := findDuplicateNodeTargets(files)
+	if len(duplicates) == 0 {
+		return
+	}
+
+	var details strings.Builder
+
+	printDuplicateNodeTargets(&details, duplicates)
+	ui.Warnf(w, "%sRun `talm validate` after fixing the modelines.", details.String())
+}
+
+// findDuplicateNodeTargets returns every node address that more than
+// one node file targets, sorted by address, with the files sorted.
+// Addresses are compared in canonical form, so `2001:db8::1` and
+// `2001:0db8::1` collide; a file that lists an address twice is not a
+// duplicate by itself.
+func findDuplicateNodeTargets(files []pruneNodeFile) []duplicateNodeTarget {
+	byNode := map[string][]string{}
+	display := map[string]string{}
+
+	for _, file := range files {
+		seen := map[string]bool{}
+
+		for _, node := range file.nodes {
+			key := canonicalNodeTarget(node)
+			if key == "" || seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			if _, ok := display[key]; !ok {
+				display[key] = strings.TrimSpace(node)
+			}
+
+			byNode[key] = append(byNode[key], file.path)
+		}
+	}
+
+	var duplicates []duplicateNodeTarget
+
+	for key, paths := range byNode {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Strings(paths)
+		duplicates = append(duplicates, duplicateNodeTarget{node: display[key], files: paths})
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].node < duplicates[j].node })
+
+	return duplicates
+}
+
+// canonicalNodeTarget normalizes an IP address to its canonical text;
+// hostnames compare case-insensitively.
+func canonicalNodeTarget(node string) string {
+	node = strings.TrimSpace(node)
+
+	if addr, err := netip.ParseAddr(node); err == nil {
+		return addr.String()
+	}
+
+	return strings.ToLower(node)
+}
+
+func printDuplicateNodeTargets(w io.Writer, duplicates []duplicateNodeTarget) {
+	if len(duplicates) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "node addresses targeted by more than one node file:")
+
+	for _, dup := range duplicates {
+		fmt.Fprintf(w, "  %s: %s\n", dup.node, strings.Join(dup.files, ", "))
+	}
+}
+
+func init() {
+	addCommand(validateCmd)
+}
@@ -0,0 +1,239 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// valuesEndpoints are the cluster addresses values.yaml declares: the
+// Kubernetes API endpoint URL and the floating IP (VIP) the control
+// plane shares.
+type valuesEndpoints struct {
+	Endpoint   string `yaml:"endpoint"`
+	FloatingIP string `yaml:"floatingIP"`
+}
+
+// talosconfigEndpoints are the endpoints of the talosconfig context
+// talm talks to when a modeline names none.
+type talosconfigEndpoints struct {
+	context   string
+	endpoints []string
+}
+
+// endpointDrift is one endpoint that no other source of the project
+// accounts for.
+type endpointDrift struct {
+	source  string
+	message string
+}
+
+// loadValuesEndpoints reads the endpoint and floatingIP of values.yaml.
+// A project without values.yaml declares neither.
+func loadValuesEndpoints(rootDir string) (valuesEndpoints, error) {
+	valuesPath := filepath.Join(rootDir, valuesYamlName)
+
+	var values valuesEndpoints
+
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return values, nil
+		}
+
+		return values, errors.Wrapf(err, "reading %s", valuesPath)
+	}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return values, errors.Wrapf(err, "parsing %s", valuesPath)
+	}
+
+	return values, nil
+}
+
+// loadTalosconfigEndpoints reads the endpoints of the talosconfig
+// context talm uses: --context, or the config's default. The path is
+// --talosconfig, or the project's talosconfig; a project with neither
+// it nor its encrypted form has no talosconfig endpoints to compare.
+func loadTalosconfigEndpoints(rootDir string) (*talosconfigEndpoints, error) {
+	path := GlobalArgs.Talosconfig
+	if path == "" {
+		path = filepath.Join(rootDir, cmp.Or(Config.GlobalOptions.Talosconfig, talosconfigFlagName))
+	}
+
+	if !fileExists(path) && !fileExists(path+encryptedTalosconfigSuffix) {
+		return nil, nil //nolint:nilnil // no talosconfig is not an error here.
+	}
+
+	cfg, err := loadTalosconfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	contextName, configContext, err := selectConfigContext(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &talosconfigEndpoints{context: contextName, endpoints: configContext.Endpoints}, nil
+}
+
+// endpointHost returns the host an endpoint names, without the scheme
+// of a URL or the port, in canonical form.
+func endpointHost(endpoint string) string {
+	endpoint = strings.TrimSpace(endpoint)
+
+	if strings.Contains(endpoint, "://") {
+		if parsed, err := url.Parse(endpoint); err == nil {
+			return canonicalNodeTarget(parsed.Hostname())
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return canonicalNodeTarget(host)
+	}
+
+	return canonicalNodeTarget(strings.Trim(endpoint, "[]"))
+}
+
+// findEndpointDrift compares the endpoints of the node file modelines
+// and of the talosconfig context with the addresses values.yaml
+// declares. An endpoint is accounted for when it is a node address of
+// some node file, the host of the values endpoint, or the floatingIP;
+// anything else is usually left over from a change made in one place
+// only, like a VIP moved in values.yaml while the node files and the
+// talosconfig still name the old one. Without a values endpoint there
+// is nothing to anchor the comparison, so only the endpoint/floatingIP
+// agreement is checked, and that needs both.
+func findEndpointDrift(files []pruneNodeFile, values valuesEndpoints, talosconfig *talosconfigEndpoints) []endpointDrift {
+	var drifts []endpointDrift
+
+	clusterHost := endpointHost(values.Endpoint)
+	floatingIP := canonicalNodeTarget(values.FloatingIP)
+
+	if clusterHost != "" && floatingIP != "" && clusterHost != floatingIP {
+		drifts = append(drifts, endpointDrift{
+			source:  valuesYamlName,
+			message: fmt.Sprintf("endpoint %s does not point at floatingIP %s", values.Endpoint, values.FloatingIP),
+		})
+	}
+
+	if clusterHost == "" {
+		return drifts
+	}
+
+	known := map[string]bool{clusterHost: true}
+	if floatingIP != "" {
+		known[floatingIP] = true
+	}
+
+	for _, file := range files {
+		for _, node := range file.nodes {
+			known[canonicalNodeTarget(node)] = true
+		}
+	}
+
+	unknown := func(source string, endpoints []string) {
+		for _, endpoint := range endpoints {
+			if host := endpointHost(endpoint); host != "" && !known[host] {
+				drifts = append(drifts, endpointDrift{
+					source:  source,
+					message: fmt.Sprintf("endpoint %s is neither a node address, the %s endpoint nor its floatingIP", strings.TrimSpace(endpoint), valuesYamlName),
+				})
+			}
+		}
+	}
+
+	for _, file := range files {
+		unknown(file.path, file.endpoints)
+	}
+
+	if talosconfig != nil {
+		unknown(fmt.Sprintf("talosconfig context %q", talosconfig.context), talosconfig.endpoints)
+	}
+
+	return drifts
+}
+
+// collectEndpointDrift loads values.yaml and the talosconfig of the
+// project at rootDir and compares their endpoints with those of files.
+// A source that cannot be read is left out of the comparison, with a
+// note on progress.
+func collectEndpointDrift(rootDir string, files []pruneNodeFile, progress io.Writer) []endpointDrift {
+	values, err := loadValuesEndpoints(rootDir)
+	if err != nil {
+		ui.Warnf(progress, "skipping the endpoint check: %v", err)
+
+		return nil
+	}
+
+	talosconfig, err := loadTalosconfigEndpoints(rootDir)
+	if err != nil {
+		ui.Warnf(progress, "leaving the talosconfig out of the endpoint check: %v", err)
+	}
+
+	return findEndpointDrift(files, values, talosconfig)
+}
+
+// warnEndpointDrift prints the project's endpoint drift to w before an
+// apply. Like warnDuplicateNodeTargets it never fails the apply.
+func warnEndpointDrift(rootDir string, w io.Writer) {
+	files, _, err := scanPruneNodeFiles(rootDir)
+	if err != nil {
+		ui.Warnf(w, "skipping the endpoint check: %v", err)
+
+		return
+	}
+
+	var notes strings.Builder
+
+	drifts := collectEndpointDrift(rootDir, files, &notes)
+	if notes.Len() > 0 {
+		fmt.Fprint(w, notes.String())
+	}
+
+	if len(drifts) == 0 {
+		return
+	}
+
+	var details strings.Builder
+
+	printEndpointDrift(&details, drifts)
+	ui.Warnf(w, "%sUpdate the stale endpoints, then run `talm validate`.", details.String())
+}
+
+func printEndpointDrift(w io.Writer, drifts []endpointDrift) {
+	if len(drifts) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "endpoints that disagree with the rest of the project:")
+
+	for _, drift := range drifts {
+		fmt.Fprintf(w, "  %s: %s\n", drift.source, drift.message)
+	}
+}
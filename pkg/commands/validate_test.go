@@ -0,0 +1,234 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// TestFindDuplicateNodeTargets pins the uniqueness rule: an address in
+// two files is reported with both files, IPv6 spellings and hostname
+// case collapse, and an address repeated within one file is not a
+// duplicate.
+func TestFindDuplicateNodeTargets(t *testing.T) {
+	t.Parallel()
+
+	files := []pruneNodeFile{
+		{path: "nodes/cp2.yaml", nodes: []string{"192.0.2.10", "2001:db8::1"}},
+		{path: "nodes/cp1.yaml", nodes: []string{"192.0.2.10", "192.0.2.10"}},
+		{path: "nodes/cp3.yaml", nodes: []string{"2001:0db8:0::1", "CP3.example.com"}},
+		{path: "nodes/w1.yaml", nodes: []string{"cp3.example.com", "192.0.2.20"}},
+	}
+
+	got := findDuplicateNodeTargets(files)
+	want := []duplicateNodeTarget{
+		{node: "192.0.2.10", files: []string{"nodes/cp1.yaml", "nodes/cp2.yaml"}},
+		{node: "2001:db8::1", files: []string{"nodes/cp2.yaml", "nodes/cp3.yaml"}},
+		{node: "CP3.example.com", files: []string{"nodes/cp3.yaml", "nodes/w1.yaml"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicates = %+v, want %+v", got, want)
+	}
+
+	if got := findDuplicateNodeTargets(files[3:]); len(got) != 0 {
+		t.Errorf("a single file cannot duplicate itself, got %+v", got)
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	origRoot := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = origRoot })
+
+	Config.RootDir = writePruneProject(t, map[string]string{
+		"nodes/cp1.yaml":   "# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		"nodes/cp2.yaml":   "# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/controlplane.yaml\"]\n",
+		"nodes/notes.yaml": "foo: bar\n",
+	})
+
+	var out, progress bytes.Buffer
+
+	err := runValidate(&out, &progress)
+	if err == nil {
+		t.Fatal("expected an error for a duplicate node target")
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "exactly one file") {
+		t.Errorf("hint = %q", hints)
+	}
+
+	wantLine := "192.0.2.10: " + filepath.Join("nodes", "cp1.yaml") + ", " + filepath.Join("nodes", "cp2.yaml")
+	if !strings.Contains(out.String(), wantLine) {
+		t.Errorf("output missing %q:\n%s", wantLine, out.String())
+	}
+
+	// The note about a skipped file is a log line, not a finding.
+	wantNote := filepath.Join("nodes", "notes.yaml") + " has no talm modeline"
+	if !strings.Contains(progress.String(), wantNote) || strings.Contains(out.String(), wantNote) {
+		t.Errorf("note %q must go to progress only; output:\n%s\nprogress:\n%s", wantNote, out.String(), progress.String())
+	}
+
+	var warn bytes.Buffer
+
+	warnDuplicateNodeTargets(Config.RootDir, &warn)
+
+	if !strings.HasPrefix(warn.String(), "Warning: ") || !strings.Contains(warn.String(), wantLine) {
+		t.Errorf("pre-apply warning = %q", warn.String())
+	}
+}
+
+func TestRunValidate_Clean(t *testing.T) {
+	origRoot := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = origRoot })
+
+	Config.RootDir = writePruneProject(t, map[string]string{
+		"nodes/cp1.yaml": "# talm: nodes=[\"192.0.2.10\"]\n",
+		"nodes/cp2.yaml": "# talm: nodes=[\"192.0.2.11\"]\n",
+	})
+
+	var out bytes.Buffer
+	if err := runValidate(&out, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "2 node file(s) checked, no problems found") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	var warn bytes.Buffer
+	if warnDuplicateNodeTargets(Config.RootDir, &warn); warn.Len() != 0 {
+		t.Errorf("a clean project must not warn, got %q", warn.String())
+	}
+
+	if warnEndpointDrift(Config.RootDir, &warn); warn.Len() != 0 {
+		t.Errorf("a clean project must not warn about endpoints, got %q", warn.String())
+	}
+}
+
+func TestEndpointHost(t *testing.T) {
+	t.Parallel()
+
+	for endpoint, want := range map[string]string{
+		"https://192.0.2.100:6443":    "192.0.2.100",
+		"https://[2001:0db8::1]:6443": "2001:db8::1",
+		"192.0.2.4:50000":             "192.0.2.4",
+		" 192.0.2.4 ":                 "192.0.2.4",
+		"[2001:db8::1]":               "2001:db8::1",
+		"API.Example.com":             "api.example.com",
+		"https://lb.example.com":      "lb.example.com",
+		"":                            "",
+	} {
+		if got := endpointHost(endpoint); got != want {
+			t.Errorf("endpointHost(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+// TestFindEndpointDrift pins which endpoints count as accounted for: a
+// node address of any file, the values endpoint host and floatingIP.
+// A VIP changed in values.yaml only leaves the old one flagged in the
+// modelines and in the talosconfig.
+func TestFindEndpointDrift(t *testing.T) {
+	t.Parallel()
+
+	files := []pruneNodeFile{
+		{path: "nodes/cp1.yaml", nodes: []string{"192.0.2.10"}, endpoints: []string{"192.0.2.10", "192.0.2.200"}},
+		{path: "nodes/cp2.yaml", nodes: []string{"192.0.2.11"}, endpoints: []string{"192.0.2.10:50000"}},
+		{path: "nodes/w1.yaml", nodes: []string{"192.0.2.20"}, endpoints: []string{"192.0.2.100"}},
+	}
+	values := valuesEndpoints{Endpoint: "https://192.0.2.100:6443", FloatingIP: "192.0.2.100"}
+	talosconfig := &talosconfigEndpoints{context: "prod", endpoints: []string{"192.0.2.100", "192.0.2.200"}}
+
+	got := findEndpointDrift(files, values, talosconfig)
+	want := []endpointDrift{
+		{source: "nodes/cp1.yaml", message: "endpoint 192.0.2.200 is neither a node address, the values.yaml endpoint nor its floatingIP"},
+		{source: `talosconfig context "prod"`, message: "endpoint 192.0.2.200 is neither a node address, the values.yaml endpoint nor its floatingIP"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("drift = %+v, want %+v", got, want)
+	}
+
+	if got := findEndpointDrift(files, valuesEndpoints{}, talosconfig); len(got) != 0 {
+		t.Errorf("without a values endpoint nothing anchors the check, got %+v", got)
+	}
+}
+
+func TestFindEndpointDrift_FloatingIP(t *testing.T) {
+	t.Parallel()
+
+	values := valuesEndpoints{Endpoint: "https://192.0.2.100:6443", FloatingIP: "192.0.2.101"}
+
+	got := findEndpointDrift(nil, values, nil)
+	if len(got) != 1 || got[0].source != "values.yaml" || !strings.Contains(got[0].message, "does not point at floatingIP 192.0.2.101") {
+		t.Errorf("drift = %+v", got)
+	}
+
+	values.FloatingIP = ""
+	if got := findEndpointDrift(nil, values, nil); len(got) != 0 {
+		t.Errorf("an endpoint without a floatingIP is not drift, got %+v", got)
+	}
+}
+
+// TestRunValidate_EndpointDrift pins that drift is reported as a
+// warning: listed in the output and counted in the summary, without
+// failing validate, and repeated before an apply.
+func TestRunValidate_EndpointDrift(t *testing.T) {
+	origRoot, origTalosconfig, origContext := Config.RootDir, GlobalArgs.Talosconfig, GlobalArgs.CmdContext
+	t.Cleanup(func() {
+		Config.RootDir, GlobalArgs.Talosconfig, GlobalArgs.CmdContext = origRoot, origTalosconfig, origContext
+	})
+
+	GlobalArgs.Talosconfig, GlobalArgs.CmdContext = "", ""
+	Config.RootDir = writePruneProject(t, map[string]string{
+		"values.yaml":    "endpoint: https://192.0.2.200:6443\nfloatingIP: 192.0.2.200\n",
+		"talosconfig":    "context: prod\ncontexts:\n  prod:\n    endpoints:\n      - 192.0.2.100\n",
+		"nodes/cp1.yaml": "# talm: nodes=[\"192.0.2.10\"], endpoints=[\"192.0.2.100\"]\n",
+		"nodes/cp2.yaml": "# talm: nodes=[\"192.0.2.11\"], endpoints=[\"192.0.2.200\"]\n",
+	})
+
+	var out bytes.Buffer
+	if err := runValidate(&out, &bytes.Buffer{}); err != nil {
+		t.Fatalf("endpoint drift must not fail validate: %v", err)
+	}
+
+	for _, want := range []string{
+		filepath.Join("nodes", "cp1.yaml") + ": endpoint 192.0.2.100 is neither",
+		`talosconfig context "prod": endpoint 192.0.2.100 is neither`,
+		"2 node file(s) checked, 2 endpoint warning(s)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "cp2.yaml") {
+		t.Errorf("cp2 names the current VIP and must not be flagged:\n%s", out.String())
+	}
+
+	var warn bytes.Buffer
+
+	warnEndpointDrift(Config.RootDir, &warn)
+
+	if !strings.HasPrefix(warn.String(), "Warning: ") || !strings.Contains(warn.String(), `talosconfig context "prod"`) {
+		t.Errorf("pre-apply warning = %q", warn.String())
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/term"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+// valuePrompter answers engine.PromptRequest on the operator's
+// terminal. Answers are remembered by values path for the lifetime of
+// the process: apply and template -I render once per node file, and
+// the operator should type a bootstrap token once, not once per node.
+type valuePrompter struct {
+	out        io.Writer
+	in         *bufio.Reader
+	readSecret func() ([]byte, error)
+	answers    map[string]string
+}
+
+// sessionValuePrompter is the process-wide prompter, created on first
+// use so the answer cache spans every render of one invocation.
+//
+//nolint:gochecknoglobals // per-process answer cache shared by every render of one invocation.
+var sessionValuePrompter *valuePrompter
+
+// interactiveValuePrompt returns the engine.PromptFunc for this
+// invocation, or nil when stdin is not a terminal. A nil PromptFunc
+// keeps CI and piped runs on the pre-prompting behaviour: missing
+// values stay missing and `required` in the chart fails the render.
+func interactiveValuePrompt() engine.PromptFunc {
+	if !stdinIsTTY() {
+		return nil
+	}
+
+	if sessionValuePrompter == nil {
+		sessionValuePrompter = &valuePrompter{
+			out: os.Stderr,
+			in:  bufio.NewReader(os.Stdin),
+			readSecret: func() ([]byte, error) {
+				//nolint:wrapcheck // wrapped by valuePrompter.prompt with the value path.
+				return term.ReadPassword(int(os.Stdin.Fd()))
+			},
+			answers: map[string]string{},
+		}
+	}
+
+	return sessionValuePrompter.prompt
+}
+
+// prompt asks for req on the terminal. Secret values are read without
+// echo. An empty answer is an error rather than an empty value: the
+// schema marked the value as required enough to prompt for.
+func (p *valuePrompter) prompt(req engine.PromptRequest) (string, error) {
+	if answer, ok := p.answers[req.Path]; ok {
+		return answer, nil
+	}
+
+	label := req.Path
+	if req.Description != "" {
+		label = fmt.Sprintf("%s (%s)", req.Path, req.Description)
+	}
+
+	fmt.Fprintf(p.out, "Enter %s: ", label)
+
+	var (
+		answer string
+		err    error
+	)
+
+	if req.Secret {
+		var raw []byte
+
+		raw, err = p.readSecret()
+		// ReadPassword swallows the newline; keep the next prompt on
+		// its own line.
+		fmt.Fprintln(p.out)
+
+		answer = string(raw)
+	} else {
+		answer, err = p.in.ReadString('\n')
+		if errors.Is(err, io.EOF) && answer != "" {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "reading answer")
+	}
+
+	answer = strings.TrimRight(answer, "\r\n")
+	if answer == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHintf(
+			errors.Newf("no value entered for %s", req.Path),
+			"enter a value, or set it non-interactively with --set-string %s=<value>", req.Path,
+		)
+	}
+
+	p.answers[req.Path] = answer
+
+	return answer, nil
+}
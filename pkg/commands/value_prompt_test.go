@@ -0,0 +1,104 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cozystack/talm/pkg/engine"
+)
+
+func newTestValuePrompter(input string, secret string) (*valuePrompter, *bytes.Buffer, *int) {
+	out := &bytes.Buffer{}
+	secretReads := 0
+
+	return &valuePrompter{
+		out: out,
+		in:  bufio.NewReader(strings.NewReader(input)),
+		readSecret: func() ([]byte, error) {
+			secretReads++
+
+			return []byte(secret), nil
+		},
+		answers: map[string]string{},
+	}, out, &secretReads
+}
+
+// TestValuePrompter_PlainAndSecret pins that plain values are read
+// from the line reader, secret values through the no-echo reader, and
+// that the prompt names the path and description.
+func TestValuePrompter_PlainAndSecret(t *testing.T) {
+	t.Parallel()
+
+	p, out, secretReads := newTestValuePrompter("cozy.local\n", "s3cret")
+
+	domain, err := p.prompt(engine.PromptRequest{Path: "clusterDomain", Description: "cluster DNS domain"})
+	if err != nil || domain != "cozy.local" {
+		t.Fatalf("plain prompt = %q, %v", domain, err)
+	}
+
+	token, err := p.prompt(engine.PromptRequest{Path: "bootstrap.token", Secret: true})
+	if err != nil || token != "s3cret" {
+		t.Fatalf("secret prompt = %q, %v", token, err)
+	}
+
+	if *secretReads != 1 {
+		t.Errorf("secret must be read without echo exactly once, got %d reads", *secretReads)
+	}
+
+	if !strings.Contains(out.String(), "Enter clusterDomain (cluster DNS domain): ") {
+		t.Errorf("prompt must name the path and description, got %q", out.String())
+	}
+
+	if strings.Contains(out.String(), "s3cret") {
+		t.Error("the secret answer must never be echoed")
+	}
+}
+
+// TestValuePrompter_RemembersAnswers pins the per-process cache: a
+// value asked for while rendering the first node file is reused for
+// the next one.
+func TestValuePrompter_RemembersAnswers(t *testing.T) {
+	t.Parallel()
+
+	p, _, secretReads := newTestValuePrompter("", "s3cret")
+	req := engine.PromptRequest{Path: "bootstrap.token", Secret: true}
+
+	for range 3 {
+		if _, err := p.prompt(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if *secretReads != 1 {
+		t.Errorf("expected one read across renders, got %d", *secretReads)
+	}
+}
+
+// TestValuePrompter_EmptyAnswerFails pins that an empty answer fails
+// with a hint toward --set-string instead of rendering an empty value.
+func TestValuePrompter_EmptyAnswerFails(t *testing.T) {
+	t.Parallel()
+
+	p, _, _ := newTestValuePrompter("\n", "")
+
+	_, err := p.prompt(engine.PromptRequest{Path: "clusterDomain"})
+	if err == nil || !strings.Contains(err.Error(), "no value entered for clusterDomain") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -15,7 +15,6 @@
 package commands
 
 import (
-	"fmt"
 	"io"
 	"os"
 	"slices"
@@ -24,6 +23,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cozystack/talm/pkg/age"
 	"github.com/cozystack/talm/pkg/engine"
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 // sealRenderedSecrets removes or hides values that came from encrypted value
@@ -40,7 +40,7 @@ import (
 // -I time without that file in Chart.yaml would silently drop the field from
 // the applied config. warnUnpersistedEncryptedFiles surfaces that foot-gun.
 func sealRenderedSecrets(rendered []byte, valueFiles, persistedValueFiles []string, rootDir string, inplace, showSecrets bool) ([]byte, error) {
-	secrets, err := collectEncryptedValueLeaves(valueFiles, rootDir)
+	secrets, err := collectSecretLeaves(valueFiles, rootDir)
 	if err != nil {
 		return nil, err
 	}
@@ -90,10 +90,10 @@ func warnUnpersistedEncryptedFiles(valueFiles, persistedValueFiles []string, w i
 			continue
 		}
 
-		fmt.Fprintf(w, "warning: %s holds encrypted values that were omitted from the rendered node file, "+
+		ui.Warnf(w, "%s holds encrypted values that were omitted from the rendered node file, "+
 			"but it is not in Chart.yaml templateOptions.valueFiles; `talm apply` will re-render WITHOUT these "+
 			"secrets and the fields will be absent from the applied config. Add it to templateOptions.valueFiles "+
-			"(or re-pass --values %s to apply).\n", filePath, filePath)
+			"(or re-pass --values %s to apply).", filePath, filePath)
 	}
 }
 
@@ -133,6 +133,23 @@ func collectEncryptedValueLeaves(valueFiles []string, rootDir string) (map[strin
 	return secrets, nil
 }
 
+// collectSecretLeaves returns the secret set of a render: the string
+// leaves of the encrypted value files in valueFiles, and of the Chart.yaml
+// templateOptions.secretStore when a render of this process read it for
+// the chart's secretRef.
+func collectSecretLeaves(valueFiles []string, rootDir string) (map[string]struct{}, error) {
+	secrets, err := collectEncryptedValueLeaves(valueFiles, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if store, ok := engine.LoadedSecretStore(Config.TemplateOptions.SecretStore, rootDir); ok {
+		collectStringLeaves(store, secrets)
+	}
+
+	return secrets, nil
+}
+
 // collectStringLeaves walks an arbitrary decoded YAML value and adds every
 // string leaf to secrets. Empty strings are skipped — sealing on an empty
 // value would match unrelated empty fields across the rendered config.
@@ -0,0 +1,460 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+//nolint:gochecknoglobals // cobra command flag struct, idiomatic for cobra-based CLIs
+var valuesCmdFlags struct {
+	file     string
+	asString bool
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var valuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Read and edit keys of the project values files",
+	Long: `Read and change single keys of values.yaml (or another values file of the
+project, see --file) from scripts. Edits go through the YAML document tree, so
+comments, key order and the rest of the file are kept.
+
+A path is a dot-separated list of keys; a number selects a list item. Keys that
+contain dots, such as node addresses under nodes, are matched against the keys
+already in the file, so nodes.192.0.2.10.disk finds the 192.0.2.10 entry. To
+create such a key, escape its dots: nodes.192\.0\.2\.10.disk.`,
+	Args: cobra.NoArgs,
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var valuesGetCmd = &cobra.Command{
+	Use:   "get <path>",
+	Short: "Print the value at a path",
+	Long: `Print the value at path. A scalar is printed as is, a map or list as YAML.
+A missing path is an error, so scripts can tell it from an empty value.`,
+	Example: `  talm values get endpoint
+  talm values get nodes.192.0.2.10.labels`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runValuesGet(cmd.OutOrStdout(), valuesFilePath(valuesCmdFlags.file), args[0])
+	},
+}
+
+//nolint:gochecknoglobals // cobra command, idiomatic for cobra-based CLIs
+var valuesSetCmd = &cobra.Command{
+	Use:   "set <path> <value>",
+	Short: "Set the value at a path",
+	Long: `Set the value at path, creating missing maps on the way. The value is read
+as YAML, so true, 3, [a, b] and {k: v} keep their types; pass --string to store
+it verbatim as a string. A list index one past the end appends an item.`,
+	Example: `  talm values set nodes.node1.disk /dev/nvme0n1
+  talm values set floatingIP 192.0.2.100
+  talm values set --string nodes.node1.labels.rack 12`,
+	Args: cobra.ExactArgs(2), //nolint:mnd // <path> <value>
+	RunE: func(_ *cobra.Command, args []string) error {
+		file := valuesFilePath(valuesCmdFlags.file)
+
+		changed, err := runValuesSet(file, args[0], args[1], valuesCmdFlags.asString)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			ui.Successf(os.Stderr, "Set %s in %s", args[0], file)
+		}
+
+		return nil
+	},
+}
+
+// valuesFilePath resolves --file against the project root.
+func valuesFilePath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(Config.RootDir, file)
+}
+
+// runValuesGet writes the value at path in the values file to w.
+func runValuesGet(w io.Writer, file, path string) error {
+	segments, err := parseValuesPath(path)
+	if err != nil {
+		return err
+	}
+
+	if err := refuseEncryptedValuesFile(file); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+
+	docs, err := decodeAllYAMLDocs(data)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", file)
+	}
+
+	if len(docs) == 0 || len(docs[0].Content) == 0 {
+		return valuesPathNotFound(path, file)
+	}
+
+	node, err := lookupValuesPath(docs[0].Content[0], segments)
+	if err != nil {
+		return errors.Wrapf(err, "%s in %s", path, file)
+	}
+
+	if node == nil {
+		return valuesPathNotFound(path, file)
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		_, err = fmt.Fprintln(w, node.Value)
+
+		return errors.Wrap(err, "writing value")
+	}
+
+	out, err := encodeAllYAMLDocs([]*yaml.Node{node})
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s", path)
+	}
+
+	_, err = w.Write(out)
+
+	return errors.Wrap(err, "writing value")
+}
+
+// runValuesSet sets the value at path in the values file, creating the
+// file if it does not exist. It reports whether the file changed; an
+// unchanged file is not rewritten.
+func runValuesSet(file, path, raw string, asString bool) (bool, error) {
+	segments, err := parseValuesPath(path)
+	if err != nil {
+		return false, err
+	}
+
+	if err := refuseEncryptedValuesFile(file); err != nil {
+		return false, err
+	}
+
+	value, err := parseValuesArgument(raw, asString)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "reading %s", file)
+	}
+
+	docs, err := decodeAllYAMLDocs(toLF(data))
+	if err != nil {
+		return false, errors.Wrapf(err, "parsing %s", file)
+	}
+
+	if len(docs) == 0 {
+		docs = []*yaml.Node{{Kind: yaml.DocumentNode}}
+	}
+
+	if len(docs[0].Content) == 0 {
+		docs[0].Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	if err := setValuesPath(docs[0].Content[0], segments, value); err != nil {
+		return false, errors.Wrapf(err, "%s in %s", path, file)
+	}
+
+	out, err := encodeAllYAMLDocs(docs)
+	if err != nil {
+		return false, errors.Wrapf(err, "encoding %s", file)
+	}
+
+	out = keepLineEnding(out, data)
+
+	if bytes.Equal(out, data) {
+		return false, nil
+	}
+
+	mode := presetFileMode
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(file, out, mode); err != nil {
+		return false, errors.Wrapf(err, "writing %s", file)
+	}
+
+	return true, nil
+}
+
+// refuseEncryptedValuesFile rejects an age-encrypted values file: a
+// plaintext edit would land next to ciphertext and be committed as is.
+func refuseEncryptedValuesFile(file string) error {
+	if !strings.HasSuffix(file, age.EncryptedFileSuffix) {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("%s is encrypted", file),
+		"edit the plaintext file (%s) and run `talm init --encrypt`", strings.TrimSuffix(filepath.Base(file), age.EncryptedFileSuffix)+".yaml",
+	)
+}
+
+func valuesPathNotFound(path, file string) error {
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Newf("%s is not set in %s", path, file),
+		"escape dots that are part of a key (nodes.192\\.0\\.2\\.10) only when the key does not exist yet; existing keys are matched as written",
+	)
+}
+
+// parseValuesPath splits a dotted values path into keys. A backslash
+// escapes the next character, so `\.` is a literal dot inside a key.
+func parseValuesPath(path string) ([]string, error) {
+	var (
+		segments []string
+		current  strings.Builder
+		escaped  bool
+	)
+
+	for _, r := range path {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '.':
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	segments = append(segments, current.String())
+
+	if escaped || path == "" || strings.HasPrefix(path, ".") || hasEmptySegment(segments) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("invalid values path %q", path),
+			"use dot-separated keys such as nodes.node1.disk, with `\\.` for a dot inside a key",
+		)
+	}
+
+	return segments, nil
+}
+
+func hasEmptySegment(segments []string) bool {
+	for _, segment := range segments {
+		if segment == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseValuesArgument turns the value argument of `talm values set`
+// into a YAML node: parsed as YAML, or a string scalar with asString.
+func parseValuesArgument(raw string, asString bool) (*yaml.Node, error) {
+	if asString {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: raw}, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(raw), &doc); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrapf(err, "parsing value %q as YAML", raw),
+			"pass --string to store the value verbatim",
+		)
+	}
+
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: raw}, nil
+	}
+
+	return doc.Content[0], nil
+}
+
+// lookupValuesPath returns the node at segments below root, or nil
+// when a key or index along the way is absent. Walking into a scalar
+// is an error.
+func lookupValuesPath(root *yaml.Node, segments []string) (*yaml.Node, error) {
+	node := resolveYAMLAlias(root)
+
+	for len(segments) > 0 {
+		child, consumed, err := valuesChild(node, segments)
+		if err != nil || child == nil {
+			return nil, err
+		}
+
+		node = resolveYAMLAlias(child)
+		segments = segments[consumed:]
+	}
+
+	return node, nil
+}
+
+// setValuesPath stores value at segments below root, creating missing
+// mapping keys. An existing node is replaced in place and keeps its
+// comments, and a quoted string stays quoted.
+func setValuesPath(root *yaml.Node, segments []string, value *yaml.Node) error {
+	node := root
+
+	for len(segments) > 0 {
+		if node.Kind == yaml.AliasNode {
+			return errors.New("the path goes through a YAML alias; edit the anchored value instead")
+		}
+
+		// `nodes:` with nothing after it is null, not an empty map;
+		// setting a key below it turns it into one.
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+			node.Kind, node.Tag, node.Value, node.Style = yaml.MappingNode, "!!map", "", 0
+		}
+
+		child, consumed, err := valuesChild(node, segments)
+		if err != nil {
+			return err
+		}
+
+		if child == nil {
+			return addValuesChild(node, segments, value)
+		}
+
+		node = child
+		segments = segments[consumed:]
+	}
+
+	replaceYAMLNode(node, value)
+
+	return nil
+}
+
+// valuesChild returns the child of node named by the leading path
+// segments and how many segments it took. A mapping key may contain
+// dots, so when segments[0] is not a key the joined segments[0..n]
+// are tried in turn. It returns a nil child when nothing matches.
+func valuesChild(node *yaml.Node, segments []string) (*yaml.Node, int, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for n := 1; n <= len(segments); n++ {
+			child, ok, err := childByKey(node, strings.Join(segments[:n], "."))
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if ok {
+				return child, n, nil
+			}
+		}
+
+		return nil, 0, nil
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(segments[0])
+		if err != nil || index < 0 {
+			return nil, 0, errors.Newf("%q is not a list index", segments[0])
+		}
+
+		if index >= len(node.Content) {
+			return nil, 0, nil
+		}
+
+		return node.Content[index], 1, nil
+	default:
+		return nil, 0, errors.Newf("cannot descend into %q: the value above it is a %s", segments[0], yamlKindName(node.Kind))
+	}
+}
+
+// addValuesChild attaches value below node at segments, none of which
+// exist yet: a mapping gets the key, with maps for the remaining
+// segments, and a list gets a new last item.
+func addValuesChild(node *yaml.Node, segments []string, value *yaml.Node) error {
+	leaf := value
+
+	for i := len(segments) - 1; i > 0; i-- {
+		leaf = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{valuesKeyNode(segments[i]), leaf}}
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		if index, _ := strconv.Atoi(segments[0]); index != len(node.Content) {
+			return errors.Newf("list index %d is out of range: the list has %d items, use %d to append", index, len(node.Content), len(node.Content))
+		}
+
+		node.Content = append(node.Content, leaf)
+
+		return nil
+	}
+
+	node.Content = append(node.Content, valuesKeyNode(segments[0]), leaf)
+
+	return nil
+}
+
+func valuesKeyNode(key string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+}
+
+// replaceYAMLNode overwrites old with value in place, so the parent
+// keeps pointing at it, carrying over old's comments and the quoting
+// of a string that stays a string.
+func replaceYAMLNode(old, value *yaml.Node) {
+	replacement := *value
+
+	replacement.HeadComment = old.HeadComment
+	replacement.LineComment = old.LineComment
+	replacement.FootComment = old.FootComment
+
+	if old.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode && old.ShortTag() == "!!str" && value.ShortTag() == "!!str" {
+		replacement.Style = old.Style
+	}
+
+	*old = replacement
+}
+
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+func init() {
+	valuesCmd.PersistentFlags().StringVarP(&valuesCmdFlags.file, "file", "f", valuesYamlName, "values file to read or edit, relative to the project root")
+	valuesSetCmd.Flags().BoolVar(&valuesCmdFlags.asString, "string", false, "store the value as a string instead of parsing it as YAML")
+
+	valuesCmd.AddCommand(valuesGetCmd, valuesSetCmd)
+	addCommand(valuesCmd)
+}
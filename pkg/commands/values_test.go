@@ -0,0 +1,248 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const valuesEditFixture = `# Cluster endpoint.
+endpoint: "https://192.0.2.1:6443" # keep quoted
+podSubnets:
+  - 10.244.0.0/16
+nodes:
+  192.0.2.10:
+    # Boot disk.
+    disk: /dev/sda
+    labels:
+      rack: "1"
+empty:
+`
+
+func writeValuesFixture(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), valuesYamlName)
+	if err := os.WriteFile(path, []byte(valuesEditFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseValuesPath(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		path string
+		want []string
+	}{
+		{"endpoint", []string{"endpoint"}},
+		{"nodes.node1.disk", []string{"nodes", "node1", "disk"}},
+		{`nodes.192\.0\.2\.10.disk`, []string{"nodes", "192.0.2.10", "disk"}},
+		{`a\\b`, []string{`a\b`}},
+		{"", nil},
+		{"a..b", nil},
+		{".a", nil},
+		{"a.", nil},
+		{`a\`, nil},
+	} {
+		got, err := parseValuesPath(tc.path)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.path, got)
+			}
+
+			continue
+		}
+
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %v, %v; want %v", tc.path, got, err, tc.want)
+		}
+	}
+}
+
+func TestRunValuesGet(t *testing.T) {
+	t.Parallel()
+
+	file := writeValuesFixture(t)
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"endpoint", "https://192.0.2.1:6443\n"},
+		{"podSubnets.0", "10.244.0.0/16\n"},
+		{"nodes.192.0.2.10.disk", "/dev/sda\n"},
+		{`nodes.192\.0\.2\.10.labels`, "rack: \"1\"\n"},
+	} {
+		var out bytes.Buffer
+		if err := runValuesGet(&out, file, tc.path); err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+
+			continue
+		}
+
+		if out.String() != tc.want {
+			t.Errorf("%s = %q, want %q", tc.path, out.String(), tc.want)
+		}
+	}
+
+	for _, path := range []string{"missing", "nodes.192.0.2.11.disk", "podSubnets.3"} {
+		if err := runValuesGet(&bytes.Buffer{}, file, path); err == nil || !strings.Contains(err.Error(), "is not set") {
+			t.Errorf("%s: error = %v, want not set", path, err)
+		}
+	}
+
+	if err := runValuesGet(&bytes.Buffer{}, file, "endpoint.host"); err == nil || !strings.Contains(err.Error(), "scalar") {
+		t.Errorf("descending into a scalar: error = %v", err)
+	}
+}
+
+// TestRunValuesSet_PreservesFile pins that an edit changes only the
+// edited value: comments, quoting and the other keys survive.
+func TestRunValuesSet_PreservesFile(t *testing.T) {
+	t.Parallel()
+
+	file := writeValuesFixture(t)
+
+	changed, err := runValuesSet(file, "nodes.192.0.2.10.disk", "/dev/nvme0n1", false)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v err=%v", changed, err)
+	}
+
+	if _, err := runValuesSet(file, "endpoint", "https://192.0.2.2:6443", false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.NewReplacer("/dev/sda", "/dev/nvme0n1", "192.0.2.1:", "192.0.2.2:").Replace(valuesEditFixture)
+	if string(data) != want {
+		t.Errorf("file after edit:\n%s\nwant:\n%s", data, want)
+	}
+
+	changed, err = runValuesSet(file, "endpoint", "https://192.0.2.2:6443", false)
+	if err != nil || changed {
+		t.Errorf("setting the current value must not rewrite the file: changed=%v err=%v", changed, err)
+	}
+}
+
+// TestRunValuesSet_KeepsCRLF pins that editing a values file checked
+// out with CRLF line endings keeps them, and that a no-op set does not
+// rewrite it.
+func TestRunValuesSet_KeepsCRLF(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(file, []byte("# cluster\r\nendpoint: https://192.0.2.1:6443\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if changed, err := runValuesSet(file, "endpoint", "https://192.0.2.1:6443", false); err != nil || changed {
+		t.Errorf("an unchanged CRLF file must not be rewritten: changed=%v err=%v", changed, err)
+	}
+
+	if _, err := runValuesSet(file, "floatingIP", "192.0.2.100", true); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "# cluster\r\nendpoint: https://192.0.2.1:6443\r\nfloatingIP: 192.0.2.100\r\n"; string(data) != want {
+		t.Errorf("file = %q, want %q", data, want)
+	}
+}
+
+func TestRunValuesSet_Creates(t *testing.T) {
+	t.Parallel()
+
+	file := writeValuesFixture(t)
+
+	for _, set := range [][2]string{
+		{`nodes.192\.0\.2\.11.disk`, "/dev/sdb"},
+		{"empty.enabled", "true"},
+		{"podSubnets.1", "10.245.0.0/16"},
+		{"extra.list", "[a, b]"},
+	} {
+		if _, err := runValuesSet(file, set[0], set[1], false); err != nil {
+			t.Fatalf("%s: %v", set[0], err)
+		}
+	}
+
+	if _, err := runValuesSet(file, "nodes.192.0.2.10.labels.rack", "12", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"nodes.192.0.2.11.disk":        "/dev/sdb\n",
+		"empty.enabled":                "true\n",
+		"podSubnets.1":                 "10.245.0.0/16\n",
+		"extra.list.1":                 "b\n",
+		"nodes.192.0.2.10.labels.rack": "12\n",
+	} {
+		var out bytes.Buffer
+		if err := runValuesGet(&out, file, path); err != nil || out.String() != want {
+			t.Errorf("%s = %q, %v; want %q", path, out.String(), err, want)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `rack: "12"`) {
+		t.Errorf("--string must keep a numeric-looking value a string:\n%s", data)
+	}
+
+	if _, err := runValuesSet(file, "podSubnets.5", "x", false); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("a gap in a list: error = %v", err)
+	}
+
+	if _, err := runValuesSet(file, "endpoint.host", "x", false); err == nil {
+		t.Error("setting below a scalar must fail")
+	}
+}
+
+func TestRunValuesSet_NewFileAndEncrypted(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "values-extra.yaml")
+
+	if _, err := runValuesSet(file, "a.b", "1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(file); string(data) != "a:\n  b: 1\n" {
+		t.Errorf("new file = %q", data)
+	}
+
+	if _, err := runValuesSet(filepath.Join(dir, "values-secret.encrypted.yaml"), "a", "1", false); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("encrypted file: error = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build e2e
+
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCluster runs the whole suite against a real cluster. It needs
+// docker (or the provisioner named by TALM_E2E_PROVISIONER) and
+// talosctl, so it only builds under the e2e tag: `make e2e`.
+//
+// Environment:
+//
+//	TALM_E2E_BINARY         talm binary under test (default: built from this tree)
+//	TALM_E2E_TALOSCTL       talosctl binary (default: talosctl on PATH)
+//	TALM_E2E_PROVISIONER    talosctl provisioner (default: docker)
+//	TALM_E2E_CREATE_ARGS    extra `talosctl cluster create` arguments, space-separated
+//	TALM_E2E_UPGRADE_IMAGE  installer image for the upgrade step (VM provisioners only)
+//	TALM_E2E_KEEP           non-empty keeps the cluster and work directory
+func TestCluster(t *testing.T) {
+	talm := os.Getenv("TALM_E2E_BINARY")
+	if talm == "" {
+		talm = buildTalm(t)
+	}
+
+	var log bytes.Buffer
+
+	results, err := Run(context.Background(), Options{
+		Talm:         talm,
+		Talosctl:     os.Getenv("TALM_E2E_TALOSCTL"),
+		Provisioner:  os.Getenv("TALM_E2E_PROVISIONER"),
+		CreateArgs:   strings.Fields(os.Getenv("TALM_E2E_CREATE_ARGS")),
+		UpgradeImage: os.Getenv("TALM_E2E_UPGRADE_IMAGE"),
+		Keep:         os.Getenv("TALM_E2E_KEEP") != "",
+		Log:          &log,
+	})
+
+	t.Log("\n" + log.String())
+
+	var summary bytes.Buffer
+	WriteSummary(&summary, results)
+	t.Log("\n" + summary.String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildTalm(t *testing.T) string {
+	t.Helper()
+
+	bin := filepath.Join(t.TempDir(), "talm")
+
+	// The module root is two levels up from pkg/e2e.
+	cmd := exec.Command("go", "build", "-o", bin, ".")
+	cmd.Dir = filepath.Join("..", "..")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building talm: %v\n%s", err, out)
+	}
+
+	return bin
+}
@@ -0,0 +1,620 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package e2e runs talm end to end against a throwaway Talos cluster
+// provisioned by `talosctl cluster create`.
+//
+// The suite drives a talm binary as an operator would: it initializes
+// a project, renders the machine configs offline, boots a one-node
+// cluster from them (talosctl's --input-dir, so the cluster trusts the
+// project's PKI), re-templates the node file against the live node,
+// applies a patch, fetches the kubeconfig, waits for `talm
+// healthcheck` to pass and, when an installer image is given, upgrades
+// the node. Each step asserts the node state it produced.
+//
+// The same suite backs `make e2e` (through the e2e-tagged test in this
+// package) and `talm selftest`, which operators run to check that
+// their machine can provision and manage a cluster.
+package e2e
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Defaults for Options fields left empty.
+const (
+	DefaultClusterName = "talm-e2e"
+	DefaultProvisioner = "docker"
+	// DefaultCIDR is talosctl's own default; the first control-plane
+	// node gets the second address of the range.
+	DefaultCIDR   = "10.5.0.0/24"
+	DefaultPreset = "generic"
+)
+
+// healthyWithin bounds how long the healthcheck step waits for the
+// fresh node to report healthy; kubelet takes a few minutes to turn
+// Ready on a cold cluster.
+const (
+	healthyWithin   = 10 * time.Minute
+	healthyInterval = 15 * time.Second
+)
+
+// nodeLabelKey is the label the apply step patches onto the node and
+// then reads back from the running machine config.
+const nodeLabelKey = "talm.cozystack.io/e2e-run"
+
+// outputTail caps how much of a failed command's output an error
+// carries.
+const outputTail = 4096
+
+// Options configures a suite run.
+type Options struct {
+	// Talm is the talm binary under test.
+	Talm string
+	// Talosctl is the talosctl binary that provisions the cluster;
+	// empty means "talosctl" on PATH.
+	Talosctl string
+	// WorkDir holds the project and talosctl state; empty means a new
+	// temporary directory, removed after the run unless Keep is set.
+	WorkDir string
+	// ClusterName, Provisioner, CIDR and Preset default to the
+	// Default* constants.
+	ClusterName string
+	Provisioner string
+	CIDR        string
+	Preset      string
+	// UpgradeImage enables the upgrade step. Container nodes cannot
+	// be upgraded, so the step needs a VM provisioner such as qemu.
+	UpgradeImage string
+	// CreateArgs are appended to `talosctl cluster create`, e.g.
+	// --image or the qemu provisioner's resource flags.
+	CreateArgs []string
+	// Keep leaves the cluster and the work directory in place for
+	// inspection.
+	Keep bool
+	// Log receives step banners and the commands run; nil discards.
+	Log io.Writer
+}
+
+// Result is the outcome of one step. A step either passed (Err nil,
+// Skipped empty), failed (Err set) or was skipped with a reason.
+type Result struct {
+	Step     string
+	Duration time.Duration
+	Skipped  string
+	Err      error
+}
+
+// suite is the state shared by the steps of one run.
+type suite struct {
+	opts       Options
+	workDir    string
+	projectDir string
+	inputDir   string
+	// talosctlConfig keeps talosctl's cluster context out of the
+	// operator's ~/.talos/config.
+	talosctlConfig string
+	node           string
+	runID          string
+	created        bool
+	log            io.Writer
+}
+
+type step struct {
+	name string
+	run  func(s *suite, ctx context.Context) (skipped string, err error)
+}
+
+// steps run in order; a failure skips the rest, and the cluster is
+// destroyed afterwards whatever happened.
+//
+//nolint:gochecknoglobals // immutable step table.
+var steps = []step{
+	{"preflight", (*suite).preflight},
+	{"init", (*suite).initProject},
+	{"render", (*suite).render},
+	{"create", (*suite).createCluster},
+	{"template", (*suite).templateNode},
+	{"apply", (*suite).apply},
+	{"kubeconfig", (*suite).kubeconfig},
+	{"healthcheck", (*suite).healthcheck},
+	{"upgrade", (*suite).upgrade},
+}
+
+// Run provisions the cluster, runs every step and tears the cluster
+// down. It returns a result per step plus the destroy step, and the
+// first failure.
+func Run(ctx context.Context, opts Options) ([]Result, error) {
+	s, cleanup, err := newSuite(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	results := make([]Result, 0, len(steps)+1)
+
+	var firstErr error
+
+	for _, st := range steps {
+		if firstErr != nil {
+			results = append(results, Result{Step: st.name, Skipped: "an earlier step failed"})
+
+			continue
+		}
+
+		res := s.runStep(ctx, st)
+		results = append(results, res)
+
+		if res.Err != nil {
+			firstErr = errors.Wrapf(res.Err, "e2e step %s", st.name)
+		}
+	}
+
+	// Tear down with a fresh context: a cancelled run must still
+	// remove its containers.
+	destroyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+	defer cancel()
+
+	destroy := s.runStep(destroyCtx, step{"destroy", (*suite).destroyCluster})
+	results = append(results, destroy)
+
+	if firstErr == nil && destroy.Err != nil {
+		firstErr = errors.Wrap(destroy.Err, "e2e step destroy")
+	}
+
+	return results, firstErr
+}
+
+func newSuite(opts Options) (*suite, func(), error) {
+	if opts.Talm == "" {
+		return nil, nil, errors.New("e2e: the talm binary under test is not set")
+	}
+
+	if opts.Talosctl == "" {
+		opts.Talosctl = "talosctl"
+	}
+
+	opts.ClusterName = cmp.Or(opts.ClusterName, DefaultClusterName)
+	opts.Provisioner = cmp.Or(opts.Provisioner, DefaultProvisioner)
+	opts.CIDR = cmp.Or(opts.CIDR, DefaultCIDR)
+	opts.Preset = cmp.Or(opts.Preset, DefaultPreset)
+
+	node, err := controlPlaneAddress(opts.CIDR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log := opts.Log
+	if log == nil {
+		log = io.Discard
+	}
+
+	cleanup := func() {}
+
+	workDir := opts.WorkDir
+	if workDir == "" {
+		workDir, err = os.MkdirTemp("", "talm-e2e-")
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "creating the e2e work directory")
+		}
+
+		if !opts.Keep {
+			cleanup = func() { _ = os.RemoveAll(workDir) }
+		}
+	}
+
+	s := &suite{
+		opts:           opts,
+		workDir:        workDir,
+		projectDir:     filepath.Join(workDir, "project"),
+		inputDir:       filepath.Join(workDir, "input"),
+		talosctlConfig: filepath.Join(workDir, "talosctl-config"),
+		node:           node,
+		runID:          time.Now().UTC().Format("20060102T150405Z"),
+		log:            log,
+	}
+
+	for _, dir := range []string{s.projectDir, s.inputDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			cleanup()
+
+			return nil, nil, errors.Wrap(err, "creating the e2e work directory")
+		}
+	}
+
+	fmt.Fprintf(log, "e2e work directory: %s\n", workDir)
+
+	return s, cleanup, nil
+}
+
+// controlPlaneAddress returns the address talosctl gives the first
+// control-plane node of cidr: the first address is the bridge gateway,
+// the second the node.
+func controlPlaneAddress(cidr string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Wrapf(err, "invalid cluster CIDR %q", cidr),
+			"pass a network such as "+DefaultCIDR,
+		)
+	}
+
+	addr := prefix.Masked().Addr().Next().Next()
+	if !addr.IsValid() || !prefix.Contains(addr) {
+		return "", errors.Newf("cluster CIDR %s is too small for a node", cidr)
+	}
+
+	return addr.String(), nil
+}
+
+func (s *suite) runStep(ctx context.Context, st step) Result {
+	fmt.Fprintf(s.log, "==> %s\n", st.name)
+
+	start := time.Now()
+	skipped, err := st.run(s, ctx)
+	res := Result{Step: st.name, Duration: time.Since(start).Round(time.Second), Skipped: skipped, Err: err}
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(s.log, "    FAIL: %v\n", err)
+	case skipped != "":
+		fmt.Fprintf(s.log, "    skipped: %s\n", skipped)
+	}
+
+	return res
+}
+
+func (s *suite) preflight(ctx context.Context) (string, error) {
+	if _, err := exec.LookPath(s.opts.Talm); err != nil {
+		return "", errors.Wrapf(err, "talm binary %s", s.opts.Talm)
+	}
+
+	if _, err := exec.LookPath(s.opts.Talosctl); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Wrap(err, "talosctl is required to provision the test cluster"),
+			"install talosctl (https://www.talos.dev/latest/talos-guides/install/talosctl/) or point --talosctl at it",
+		)
+	}
+
+	if s.opts.Provisioner != DefaultProvisioner {
+		return "", nil
+	}
+
+	if _, err := s.command(ctx, "", "docker", "info"); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return "", errors.WithHint(
+			errors.Wrap(err, "docker is not reachable"),
+			"start the docker daemon and make sure the current user can talk to it (docker info)",
+		)
+	}
+
+	return "", nil
+}
+
+func (s *suite) initProject(ctx context.Context) (string, error) {
+	_, err := s.talm(ctx, "init",
+		"--preset", s.opts.Preset,
+		"--name", s.opts.ClusterName,
+		"--endpoints", s.node,
+		"--cluster-endpoint", "https://"+net.JoinHostPort(s.node, "6443"),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range []string{"Chart.yaml", "secrets.yaml", "talosconfig", filepath.Join("templates", "controlplane.yaml")} {
+		if _, err := os.Stat(filepath.Join(s.projectDir, name)); err != nil {
+			return "", errors.Wrapf(err, "talm init did not create %s", name)
+		}
+	}
+
+	return "", nil
+}
+
+// render writes the full configs talosctl boots the cluster from. They
+// are rendered offline, as for a node that does not exist yet; the
+// template step later re-renders against the live node.
+func (s *suite) render(ctx context.Context) (string, error) {
+	for _, kind := range []string{"controlplane", "worker"} {
+		out, err := s.talm(ctx, "template", "--offline", "--full",
+			"--template", filepath.Join("templates", kind+".yaml"),
+			"--nodes", s.node, "--endpoints", s.node,
+		)
+		if err != nil {
+			return "", err
+		}
+
+		if !strings.Contains(out, "machine:") {
+			return "", errors.Newf("rendered %s config has no machine section", kind)
+		}
+
+		if err := os.WriteFile(filepath.Join(s.inputDir, kind+".yaml"), []byte(out), 0o600); err != nil {
+			return "", errors.Wrapf(err, "writing the %s config", kind)
+		}
+	}
+
+	talosconfig, err := os.ReadFile(filepath.Join(s.projectDir, "talosconfig"))
+	if err != nil {
+		return "", errors.Wrap(err, "reading the project talosconfig")
+	}
+
+	return "", errors.Wrap(os.WriteFile(filepath.Join(s.inputDir, "talosconfig"), talosconfig, 0o600), "writing the talosconfig")
+}
+
+func (s *suite) createCluster(ctx context.Context) (string, error) {
+	// Mark the cluster as created up front: a create that fails
+	// halfway still leaves containers and networks to destroy.
+	s.created = true
+
+	_, err := s.command(ctx, "", s.opts.Talosctl, s.createArgs()...)
+
+	return "", err
+}
+
+func (s *suite) createArgs() []string {
+	args := []string{
+		"cluster", "create",
+		"--talosconfig", s.talosctlConfig,
+		"--name", s.opts.ClusterName,
+		"--provisioner", s.opts.Provisioner,
+		"--cidr", s.opts.CIDR,
+		"--input-dir", s.inputDir,
+		"--workers", "0",
+	}
+
+	return append(args, s.opts.CreateArgs...)
+}
+
+func (s *suite) destroyArgs() []string {
+	return []string{
+		"cluster", "destroy",
+		"--talosconfig", s.talosctlConfig,
+		"--name", s.opts.ClusterName,
+		"--provisioner", s.opts.Provisioner,
+	}
+}
+
+func (s *suite) nodeFile() string {
+	return filepath.Join("nodes", "cp1.yaml")
+}
+
+// templateNode writes a modeline-only node file and lets `talm
+// template -I` fill it from the live node, which exercises the
+// discovery lookups the offline render skipped.
+func (s *suite) templateNode(ctx context.Context) (string, error) {
+	path := filepath.Join(s.projectDir, s.nodeFile())
+
+	modeline := fmt.Sprintf("# talm: nodes=[%q], endpoints=[%q], templates=[%q]\n", s.node, s.node, "templates/controlplane.yaml")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", errors.Wrap(err, "creating nodes/")
+	}
+
+	if err := os.WriteFile(path, []byte(modeline), 0o600); err != nil {
+		return "", errors.Wrap(err, "writing the node file")
+	}
+
+	if _, err := s.talm(ctx, "template", "--file", s.nodeFile(), "--in-place"); err != nil {
+		return "", err
+	}
+
+	rendered, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "reading the node file")
+	}
+
+	switch {
+	case !strings.HasPrefix(string(rendered), "# talm: "):
+		return "", errors.New("talm template -I dropped the modeline")
+	case !strings.Contains(string(rendered), "machine:"):
+		return "", errors.New("talm template -I wrote no machine config")
+	}
+
+	return "", nil
+}
+
+// apply stacks a side-patch with a run-specific node label on the node
+// file, applies it and reads the label back from the running config.
+func (s *suite) apply(ctx context.Context) (string, error) {
+	patch := filepath.Join("patches", "e2e.yaml")
+	body := fmt.Sprintf("machine:\n  nodeLabels:\n    %s: %q\n", nodeLabelKey, s.runID)
+
+	if err := os.MkdirAll(filepath.Join(s.projectDir, "patches"), 0o700); err != nil {
+		return "", errors.Wrap(err, "creating patches/")
+	}
+
+	if err := os.WriteFile(filepath.Join(s.projectDir, patch), []byte(body), 0o600); err != nil {
+		return "", errors.Wrap(err, "writing the patch")
+	}
+
+	if _, err := s.talm(ctx, "apply", "--file", s.nodeFile(), "--file", patch); err != nil {
+		return "", err
+	}
+
+	out, err := s.talm(ctx, "get", "machineconfig", "--file", s.nodeFile(), "--output", "yaml")
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.Contains(out, nodeLabelKey) || !strings.Contains(out, s.runID) {
+		return "", errors.Newf("the running machine config lacks the applied label %s=%s", nodeLabelKey, s.runID)
+	}
+
+	return "", nil
+}
+
+func (s *suite) kubeconfig(ctx context.Context) (string, error) {
+	if _, err := s.talm(ctx, "kubeconfig", "--file", s.nodeFile()); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(filepath.Join(s.projectDir, "kubeconfig")); err != nil {
+		return "", errors.Wrap(err, "talm kubeconfig did not write the project kubeconfig")
+	}
+
+	return "", nil
+}
+
+// healthcheck polls `talm healthcheck` until every check passes. A
+// fresh node needs a few minutes before kubelet reports Ready.
+func (s *suite) healthcheck(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthyWithin)
+	defer cancel()
+
+	for {
+		_, err := s.talm(ctx, "healthcheck")
+		if err == nil {
+			return "", nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(err, "node not healthy within %s", healthyWithin)
+		case <-time.After(healthyInterval):
+		}
+	}
+}
+
+func (s *suite) upgrade(ctx context.Context) (string, error) {
+	switch {
+	case s.opts.UpgradeImage == "":
+		return "no upgrade image given", nil
+	case s.opts.Provisioner == DefaultProvisioner:
+		return "container nodes cannot be upgraded; use a VM provisioner", nil
+	}
+
+	if _, err := s.talm(ctx, "upgrade", "--yes", "--file", s.nodeFile(), "--image", s.opts.UpgradeImage); err != nil {
+		return "", err
+	}
+
+	out, err := s.talm(ctx, "version", "--file", s.nodeFile())
+	if err != nil {
+		return "", err
+	}
+
+	if tag := imageTag(s.opts.UpgradeImage); tag != "" && !strings.Contains(out, tag) {
+		return "", errors.Newf("node does not report %s after the upgrade:\n%s", tag, out)
+	}
+
+	return "", nil
+}
+
+// imageTag returns the tag of an image reference, or "" for a digest
+// or untagged reference.
+func imageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+
+	idx := strings.LastIndex(image, ":")
+	if idx < 0 || strings.Contains(image[idx:], "/") {
+		return ""
+	}
+
+	return image[idx+1:]
+}
+
+func (s *suite) destroyCluster(ctx context.Context) (string, error) {
+	switch {
+	case !s.created:
+		return "no cluster was created", nil
+	case s.opts.Keep:
+		return fmt.Sprintf("kept; remove it with: %s %s", s.opts.Talosctl, strings.Join(s.destroyArgs(), " ")), nil
+	}
+
+	_, err := s.command(ctx, "", s.opts.Talosctl, s.destroyArgs()...)
+
+	return "", err
+}
+
+// talm runs the binary under test in the project directory.
+func (s *suite) talm(ctx context.Context, args ...string) (string, error) {
+	return s.command(ctx, s.projectDir, s.opts.Talm, args...)
+}
+
+// command runs name and returns its stdout. The command line goes to
+// the log; on failure the error carries the tail of stdout and stderr.
+func (s *suite) command(ctx context.Context, dir, name string, args ...string) (string, error) {
+	fmt.Fprintf(s.log, "    $ %s %s\n", filepath.Base(name), strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+
+	var stdout, combined bytes.Buffer
+
+	cmd.Stdout = io.MultiWriter(&stdout, &combined)
+	cmd.Stderr = &combined
+
+	if err := cmd.Run(); err != nil {
+		out := combined.String()
+		if len(out) > outputTail {
+			out = "…" + out[len(out)-outputTail:]
+		}
+
+		return stdout.String(), errors.Wrapf(err, "%s %s\n%s", filepath.Base(name), strings.Join(args, " "), strings.TrimSpace(out))
+	}
+
+	return stdout.String(), nil
+}
+
+// WriteSummary prints one line per result and a closing verdict.
+func WriteSummary(w io.Writer, results []Result) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	failed := 0
+
+	for _, res := range results {
+		status, detail := "PASS", ""
+
+		switch {
+		case res.Err != nil:
+			status, detail = "FAIL", firstLine(res.Err.Error())
+			failed++
+		case res.Skipped != "":
+			status, detail = "SKIP", res.Skipped
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status, res.Step, res.Duration, detail)
+	}
+
+	_ = tw.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(w, "%d step(s) failed\n", failed)
+
+		return
+	}
+
+	fmt.Fprintln(w, "all steps passed")
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+
+	return line
+}
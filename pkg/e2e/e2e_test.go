@@ -0,0 +1,185 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestControlPlaneAddress(t *testing.T) {
+	t.Parallel()
+
+	for cidr, want := range map[string]string{
+		DefaultCIDR:       "10.5.0.2",
+		"10.6.0.17/24":    "10.6.0.2",
+		"fd00:5::/64":     "fd00:5::2",
+		"192.0.2.128/25":  "192.0.2.130",
+		"192.0.2.1/32":    "",
+		"not-a-cidr":      "",
+		"192.0.2.0/24/ab": "",
+	} {
+		got, err := controlPlaneAddress(cidr)
+		if want == "" {
+			if err == nil {
+				t.Errorf("controlPlaneAddress(%q) = %q, want an error", cidr, got)
+			}
+
+			continue
+		}
+
+		if err != nil || got != want {
+			t.Errorf("controlPlaneAddress(%q) = %q, %v, want %q", cidr, got, err, want)
+		}
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	t.Parallel()
+
+	for image, want := range map[string]string{
+		"ghcr.io/siderolabs/installer:v1.13.7":               "v1.13.7",
+		"localhost:5000/installer:v1.13.7":                   "v1.13.7",
+		"localhost:5000/installer":                           "",
+		"ghcr.io/siderolabs/installer@sha256:abcd":           "",
+		"factory.talos.dev/installer/abc:v1.13.7@sha256:abc": "v1.13.7",
+	} {
+		if got := imageTag(image); got != want {
+			t.Errorf("imageTag(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+// TestCreateArgs pins that talosctl boots the cluster from the
+// rendered configs under a private talosconfig, and that extra create
+// arguments come last so they can override the defaults.
+func TestCreateArgs(t *testing.T) {
+	t.Parallel()
+
+	s, cleanup, err := newSuite(Options{Talm: "talm", CreateArgs: []string{"--image", "example/talos:v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	args := s.createArgs()
+
+	for _, pair := range [][2]string{
+		{"--input-dir", s.inputDir},
+		{"--talosconfig", s.talosctlConfig},
+		{"--name", DefaultClusterName},
+		{"--provisioner", DefaultProvisioner},
+		{"--cidr", DefaultCIDR},
+		{"--workers", "0"},
+	} {
+		idx := slices.Index(args, pair[0])
+		if idx < 0 || idx+1 >= len(args) || args[idx+1] != pair[1] {
+			t.Errorf("create args %v: want %s %s", args, pair[0], pair[1])
+		}
+	}
+
+	if tail := args[len(args)-2:]; !slices.Equal(tail, []string{"--image", "example/talos:v1"}) {
+		t.Errorf("extra create args must come last, got %v", args)
+	}
+
+	if s.node != "10.5.0.2" {
+		t.Errorf("node = %q", s.node)
+	}
+}
+
+// TestRun_PreflightFailure pins the failure path: a missing talosctl
+// fails preflight, every later step is skipped and no cluster is
+// destroyed, so the suite never touches docker.
+func TestRun_PreflightFailure(t *testing.T) {
+	t.Parallel()
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+
+	results, err := Run(context.Background(), Options{
+		Talm:     self,
+		Talosctl: "talm-e2e-no-such-talosctl",
+		WorkDir:  t.TempDir(),
+		Log:      &log,
+	})
+	if err == nil || !strings.Contains(err.Error(), "e2e step preflight") {
+		t.Fatalf("err = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "install talosctl") {
+		t.Errorf("hint = %q", hints)
+	}
+
+	if len(results) != len(steps)+1 {
+		t.Fatalf("got %d results, want %d", len(results), len(steps)+1)
+	}
+
+	if results[0].Err == nil {
+		t.Error("preflight must fail")
+	}
+
+	for _, res := range results[1 : len(results)-1] {
+		if res.Skipped != "an earlier step failed" {
+			t.Errorf("step %s: skipped = %q, err = %v", res.Step, res.Skipped, res.Err)
+		}
+	}
+
+	if last := results[len(results)-1]; last.Step != "destroy" || last.Skipped != "no cluster was created" {
+		t.Errorf("destroy = %+v", last)
+	}
+
+	if !strings.Contains(log.String(), "==> preflight") {
+		t.Errorf("log = %q", log.String())
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	WriteSummary(&out, []Result{
+		{Step: "init"},
+		{Step: "upgrade", Skipped: "no upgrade image given"},
+		{Step: "apply", Err: errors.New("talm apply\nlong output")},
+	})
+
+	for _, want := range []string{"PASS  init", "SKIP  upgrade", "no upgrade image given", "FAIL  apply", "talm apply", "1 step(s) failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "long output") {
+		t.Errorf("summary must show only the first error line:\n%s", out.String())
+	}
+
+	out.Reset()
+	WriteSummary(&out, []Result{{Step: "init"}})
+
+	if !strings.Contains(out.String(), "all steps passed") {
+		t.Errorf("summary = %q", out.String())
+	}
+}
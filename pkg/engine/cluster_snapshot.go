@@ -0,0 +1,194 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"gopkg.in/yaml.v3"
+)
+
+// CommandNameSnapshotCluster names `talm snapshot cluster` in lookup
+// errors.
+const CommandNameSnapshotCluster = "talm snapshot cluster"
+
+// snapshotFileMode is the mode of a node snapshot. It holds what any
+// authenticated client can read from the node, no secrets.
+const snapshotFileMode os.FileMode = 0o644
+
+// DefaultSnapshotKinds are the lookup kinds `talm snapshot cluster`
+// records: those the bundled charts and the library helpers look up,
+// except machineconfig, which carries the cluster secrets.
+//
+//nolint:gochecknoglobals // immutable default list, read by the snapshot command.
+var DefaultSnapshotKinds = []string{
+	"addresses",
+	"cpus",
+	"disks",
+	"hostname",
+	"links",
+	"machinetype",
+	"memorymodules",
+	"nodeaddress",
+	"nodenames",
+	"pcidevices",
+	"resolvers",
+	"routes",
+	"systemdisk",
+}
+
+// NodeSnapshot is a recording of the chart lookups of one node: for
+// every kind, every resource a lookup of that kind returned, in the
+// shape the lookup returns it. Lookup answers from it as the node
+// would have when it was taken.
+type NodeSnapshot struct {
+	Node       string    `yaml:"node"`
+	CapturedAt time.Time `yaml:"capturedAt"`
+	// Resources maps a lookup kind, prefixed with "<namespace>/" when
+	// the lookup names one, to its resources.
+	Resources map[string][]map[string]any `yaml:"resources"`
+}
+
+// snapshotKey is the Resources key of a lookup.
+func snapshotKey(kind, namespace string) string {
+	if namespace == "" {
+		return kind
+	}
+
+	return namespace + "/" + kind
+}
+
+// CaptureNodeSnapshot records the resources of kinds on node through
+// c, with the same lookups a render makes. ctx must target node, as
+// for Render.
+func CaptureNodeSnapshot(ctx context.Context, c *client.Client, node string, kinds, endpoints []string) (*NodeSnapshot, error) {
+	lookup := newLookupFunction(ctx, c, CommandNameSnapshotCluster, endpoints)
+
+	snapshot := &NodeSnapshot{
+		Node:       node,
+		CapturedAt: time.Now().UTC().Truncate(time.Second),
+		Resources:  map[string][]map[string]any{},
+	}
+
+	for _, kind := range kinds {
+		result, err := lookup(kind, "", "")
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Resources[kind] = lookupItems(result)
+	}
+
+	return snapshot, nil
+}
+
+// Lookup implements the chart `lookup` function over the snapshot,
+// returning what the live lookup returned: the resource of id, or a
+// List of every resource of kind when id is empty, and an empty map
+// when there is none. A kind the snapshot did not record has no
+// resources, as in an offline render.
+func (s *NodeSnapshot) Lookup(kind, namespace, id string) (map[string]any, error) {
+	return answerLookup(s.Resources[snapshotKey(kind, namespace)], id), nil
+}
+
+// copyValue deep-copies a decoded resource or values map, so a template
+// that modifies what a lookup returned does not change later lookups,
+// and a render does not change the values cached for the next one.
+func copyValue(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(typed))
+		for key, item := range typed {
+			copied[key] = copyValue(item)
+		}
+
+		return copied
+	case []any:
+		copied := make([]any, len(typed))
+		for i, item := range typed {
+			copied[i] = copyValue(item)
+		}
+
+		return copied
+	default:
+		return value
+	}
+}
+
+// SnapshotPath returns the file of node's snapshot in dir. The colons
+// of an IPv6 address become dashes, which every filesystem accepts.
+func SnapshotPath(dir, node string) string {
+	return filepath.Join(dir, strings.ReplaceAll(node, ":", "-")+".yaml")
+}
+
+// WriteNodeSnapshot writes snapshot to its file in dir, creating dir,
+// and returns the path.
+func WriteNodeSnapshot(dir string, snapshot *NodeSnapshot) (string, error) {
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return "", errors.Wrapf(err, "encoding the snapshot of %s", snapshot.Node)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:mnd // conventional directory mode.
+		return "", errors.Wrapf(err, "creating %s", dir)
+	}
+
+	path := SnapshotPath(dir, snapshot.Node)
+
+	if err := os.WriteFile(path, data, snapshotFileMode); err != nil { //nolint:gosec // G306: a snapshot holds no secrets, see snapshotFileMode.
+		return "", errors.Wrapf(err, "writing %s", path)
+	}
+
+	return path, nil
+}
+
+// LoadNodeSnapshot reads the snapshot of node from dir.
+func LoadNodeSnapshot(dir, node string) (*NodeSnapshot, error) {
+	path := SnapshotPath(dir, node)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("no snapshot of node %s in %s", node, dir),
+				"record one with `talm snapshot cluster %s --nodes %s`", dir, node,
+			)
+		}
+
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	var snapshot NodeSnapshot
+	if err := yaml.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	if snapshot.Node != node {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("%s is the snapshot of node %q, not of %s", path, snapshot.Node, node),
+			"record the node again with `talm snapshot cluster %s --nodes %s`", dir, node,
+		)
+	}
+
+	return &snapshot, nil
+}
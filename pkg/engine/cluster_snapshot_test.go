@@ -0,0 +1,210 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+func testNodeSnapshot() *NodeSnapshot {
+	link := func(id string, mtu int) map[string]any {
+		return map[string]any{
+			"metadata": map[string]any{"namespace": "network", "type": "LinkStatuses.net.talos.dev", "id": id},
+			"spec":     map[string]any{"kind": "physical", "mtu": mtu},
+		}
+	}
+
+	return &NodeSnapshot{
+		Node:       "192.0.2.10",
+		CapturedAt: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		Resources: map[string][]map[string]any{
+			"links":    {link("eth0", 1500), link("eth1", 9000)},
+			"hostname": {{"metadata": map[string]any{"id": "hostname"}, "spec": map[string]any{"hostname": "cp01"}}},
+			"routes":   {},
+		},
+	}
+}
+
+// TestNodeSnapshot_Lookup pins that a snapshot answers lookups in the
+// shapes the live lookup returns them.
+func TestNodeSnapshot_Lookup(t *testing.T) {
+	snapshot := testNodeSnapshot()
+
+	list, err := snapshot.Lookup("links", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list[k8sKeyKind] != cosiKindList {
+		t.Errorf("a lookup without an id must return a List, got %v", list)
+	}
+
+	if items, _ := list[k8sKeyItems].([]any); len(items) != 2 {
+		t.Errorf("List items = %v, want both links", list[k8sKeyItems])
+	}
+
+	eth1, _ := snapshot.Lookup("links", "", "eth1")
+	if got := eth1["spec"].(map[string]any)["mtu"]; got != 9000 {
+		t.Errorf("eth1 mtu = %v, want 9000", got)
+	}
+
+	for _, lookup := range [][3]string{
+		{"links", "", "eth9"},
+		{"routes", "", ""},
+		{"blockdevices", "", ""},
+		{"links", "other", ""},
+	} {
+		got, err := snapshot.Lookup(lookup[0], lookup[1], lookup[2])
+		if err != nil || len(got) != 0 {
+			t.Errorf("Lookup%v = %v, %v; want an empty map", lookup, got, err)
+		}
+	}
+}
+
+// TestNodeSnapshot_LookupCopies pins that a template modifying what a
+// lookup returned does not change what the next lookup sees.
+func TestNodeSnapshot_LookupCopies(t *testing.T) {
+	snapshot := testNodeSnapshot()
+
+	eth0, _ := snapshot.Lookup("links", "", "eth0")
+	eth0["spec"].(map[string]any)["mtu"] = 1
+
+	again, _ := snapshot.Lookup("links", "", "eth0")
+	if got := again["spec"].(map[string]any)["mtu"]; got != 1500 {
+		t.Errorf("mtu after a modified lookup = %v, want 1500", got)
+	}
+}
+
+func TestNodeSnapshot_WriteLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	snapshot := testNodeSnapshot()
+
+	path, err := WriteNodeSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "192.0.2.10.yaml"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+
+	loaded, err := LoadNodeSnapshot(dir, "192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded, snapshot) {
+		t.Errorf("loaded snapshot = %#v, want %#v", loaded, snapshot)
+	}
+
+	_, err = LoadNodeSnapshot(dir, "192.0.2.11")
+	if err == nil || !strings.Contains(err.Error(), "no snapshot of node 192.0.2.11") {
+		t.Errorf("a missing snapshot must fail naming the node, got %v", err)
+	}
+
+	if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "talm snapshot cluster") {
+		t.Errorf("hints = %v, want one naming talm snapshot cluster", hints)
+	}
+}
+
+func TestNodeSnapshot_IPv6FileName(t *testing.T) {
+	dir := t.TempDir()
+
+	snapshot := testNodeSnapshot()
+	snapshot.Node = "2001:db8::10"
+
+	path, err := WriteNodeSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "2001-db8--10.yaml"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+
+	if _, err := LoadNodeSnapshot(dir, "2001:db8::10"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNodeSnapshot_LoadOtherNode pins that a snapshot copied under
+// another node's name is refused rather than rendered for the wrong
+// node.
+func TestNodeSnapshot_LoadOtherNode(t *testing.T) {
+	dir := t.TempDir()
+
+	path, err := WriteNodeSnapshot(dir, testNodeSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, SnapshotPath(dir, "192.0.2.20"), string(data))
+
+	_, err = LoadNodeSnapshot(dir, "192.0.2.20")
+	if err == nil || !strings.Contains(err.Error(), `is the snapshot of node "192.0.2.10"`) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestRender_Snapshot pins that an offline render with a snapshot
+// answers lookups from it, and that the lookup of later offline
+// renders is left as it was.
+func TestRender_Snapshot(t *testing.T) {
+	origLookup := helmEngine.LookupFunc
+	t.Cleanup(func() { helmEngine.LookupFunc = origLookup })
+
+	helmEngine.LookupFunc = func(string, string, string) (map[string]any, error) {
+		return map[string]any{"sentinel": true}, nil
+	}
+
+	chartRoot := createTestChart(t, "tc", "config.yaml",
+		"machine:\n  type: worker\n  network:\n    hostname: {{ (lookup \"hostname\" \"\" \"hostname\").spec.hostname }}\n    interfaces:\n{{- range (lookup \"links\" \"\" \"\").items }}\n      - interface: {{ .metadata.id }}\n        mtu: {{ .spec.mtu }}\n{{- end }}\n")
+
+	out, err := Render(context.Background(), nil, Options{
+		Offline:       true,
+		Root:          chartRoot,
+		TemplateFiles: []string{"templates/config.yaml"},
+		Snapshot:      testNodeSnapshot(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"hostname: cp01", "interface: eth0", "mtu: 9000"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("render lacks %q:\n%s", want, out)
+		}
+	}
+
+	res, _ := helmEngine.LookupFunc("links", "", "")
+	if _, ok := res["sentinel"]; !ok {
+		t.Error("a snapshot render must put the previous LookupFunc back")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Contract: YAML anchors, aliases and `<<` merge keys in value files and
+// node bodies are expanded on load. A value file means the same whether
+// it is read plain or through encrypt -> decrypt, and a node body built
+// on anchors patches the rendered config like its written-out form.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/yamltools"
+)
+
+const anchorHeavyValueFile = `defaults: &defaults
+  disk: /dev/sda
+  labels: &labels
+    zone: a
+nodes:
+  cp1:
+    <<: *defaults
+    labels:
+      <<: *labels
+      rack: "2"
+  cp2: *defaults
+registry: &registry
+  password: s3cr3t
+mirrors: [*registry, *registry]
+`
+
+func TestContract_LoadValues_Anchors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "values-anchors.yaml")
+	if err := os.WriteFile(path, []byte(anchorHeavyValueFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := loadValues(Options{ValueFiles: []string{path}})
+	if err != nil {
+		t.Fatalf("loadValues: %v", err)
+	}
+
+	nodes, _ := out["nodes"].(map[string]any)
+	want := map[string]any{"disk": "/dev/sda", "labels": map[string]any{"zone": "a", "rack": "2"}}
+	if !reflect.DeepEqual(nodes["cp1"], want) {
+		t.Errorf("nodes.cp1 = %v, want %v", nodes["cp1"], want)
+	}
+
+	if !reflect.DeepEqual(nodes["cp2"], out["defaults"]) {
+		t.Errorf("nodes.cp2 = %v, want the defaults %v", nodes["cp2"], out["defaults"])
+	}
+}
+
+// TestContract_LoadValues_AnchorsEncryptedRoundTrip pins that an
+// anchor-heavy value file loads to the same values after encrypt ->
+// decrypt as it does plain.
+func TestContract_LoadValues_AnchorsEncryptedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "values-secret.yaml"), []byte(anchorHeavyValueFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := age.EncryptYAMLFile(dir, "values-secret.yaml", "values-secret.encrypted.yaml"); err != nil {
+		t.Fatalf("EncryptYAMLFile: %v", err)
+	}
+
+	plain, err := loadValues(Options{Root: dir, ValueFiles: []string{filepath.Join(dir, "values-secret.yaml")}})
+	if err != nil {
+		t.Fatalf("loadValues plain: %v", err)
+	}
+
+	decrypted, err := loadValues(Options{Root: dir, ValueFiles: []string{filepath.Join(dir, "values-secret.encrypted.yaml")}})
+	if err != nil {
+		t.Fatalf("loadValues encrypted: %v", err)
+	}
+
+	if !reflect.DeepEqual(plain, decrypted) {
+		t.Errorf("encrypted round-trip changed the values\nplain:     %v\ndecrypted: %v", plain, decrypted)
+	}
+}
+
+func TestContract_LoadValues_AnchorCycle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "values-cycle.yaml")
+	if err := os.WriteFile(path, []byte("a: &x\n  b: *x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loadValues(Options{ValueFiles: []string{path}})
+	if !errors.Is(err, yamltools.ErrAnchorCycle) {
+		t.Fatalf("want ErrAnchorCycle, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), path) {
+		t.Errorf("the error must name the value file, got %v", err)
+	}
+}
+
+// TestContract_MergeFileAsPatch_AnchoredBody pins that a node body
+// using an anchor and a merge key patches the rendered config with the
+// expanded values.
+func TestContract_MergeFileAsPatch_AnchoredBody(t *testing.T) {
+	rendered := []byte("version: v1alpha1\nmachine:\n  type: worker\n")
+	bodyFile := filepath.Join(t.TempDir(), "w1.yaml")
+	body := `# talm: nodes=["192.0.2.11"], templates=["templates/worker.yaml"]
+machine:
+  nodeLabels: &labels
+    zone: a
+  nodeAnnotations:
+    <<: *labels
+    rack: r1
+`
+	if err := os.WriteFile(bodyFile, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := MergeFileAsPatch(rendered, bodyFile)
+	if err != nil {
+		t.Fatalf("MergeFileAsPatch: %v", err)
+	}
+
+	var cfg struct {
+		Machine struct {
+			NodeLabels      map[string]string `yaml:"nodeLabels"`
+			NodeAnnotations map[string]string `yaml:"nodeAnnotations"`
+		} `yaml:"machine"`
+	}
+
+	if err := yaml.Unmarshal(merged, &cfg); err != nil {
+		t.Fatalf("parse merged: %v", err)
+	}
+
+	if !reflect.DeepEqual(cfg.Machine.NodeLabels, map[string]string{"zone": "a"}) ||
+		!reflect.DeepEqual(cfg.Machine.NodeAnnotations, map[string]string{"zone": "a", "rack": "r1"}) {
+		t.Errorf("merged config:\n%s", merged)
+	}
+}
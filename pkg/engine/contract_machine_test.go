@@ -28,6 +28,7 @@
 package engine
 
 import (
+	"maps"
 	"strings"
 	"testing"
 
@@ -112,9 +113,10 @@ func TestContract_Machine_NodeLabels_PatchDelete_Cozystack(t *testing.T) {
 	}
 }
 
-// Contract: generic chart never emits nodeLabels (it does not have
-// the cozystack-specific exclude-from-LB removal). Pinning the
-// absence prevents accidental copy-paste from cozystack into generic.
+// Contract: generic chart never emits nodeLabels on its defaults (it
+// does not have the cozystack-specific exclude-from-LB removal).
+// Pinning the absence prevents accidental copy-paste from cozystack
+// into generic.
 func TestContract_Machine_NodeLabels_AbsentOnGeneric(t *testing.T) {
 	for _, cell := range genericCells() {
 		t.Run(cell.name, func(t *testing.T) {
@@ -125,6 +127,119 @@ func TestContract_Machine_NodeLabels_AbsentOnGeneric(t *testing.T) {
 	}
 }
 
+// === Node labels, annotations and taints (nodeLabels / nodeAnnotations / nodeTaints) ===
+//
+// Both presets render the values keys into machine.nodeLabels,
+// machine.nodeAnnotations and machine.nodeTaints as string maps, and
+// fail the render on an entry the kubelet would reject.
+
+// Contract: values.nodeLabels/nodeAnnotations/nodeTaints render into
+// the matching machine maps on both presets, values stringified. On
+// cozystack the controlplane keeps its exclude-from-LB removal next to
+// the operator labels.
+func TestContract_Machine_NodeMetadata_Rendered(t *testing.T) {
+	overrides := map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"nodeLabels": map[string]any{
+			"topology.kubernetes.io/zone": "eu-1a",
+			"example.com/rack":            12,
+		},
+		"nodeAnnotations": map[string]any{"example.com/owner": "team storage"},
+		"nodeTaints":      map[string]any{"dedicated": "storage:NoSchedule"},
+	}
+
+	for name, out := range map[string]string{
+		"cozystack": renderCozystackWith(t, helmEngineEmptyLookup, overrides),
+		"generic":   renderGenericWith(t, helmEngineEmptyLookup, overrides),
+	} {
+		t.Run(name, func(t *testing.T) {
+			labels := decodeMachineSubMap(t, out, "nodeLabels")
+			if labels["topology.kubernetes.io/zone"] != "eu-1a" || labels["example.com/rack"] != "12" {
+				t.Errorf("nodeLabels = %v", labels)
+			}
+
+			if annotations := decodeMachineSubMap(t, out, "nodeAnnotations"); annotations["example.com/owner"] != "team storage" {
+				t.Errorf("nodeAnnotations = %v", annotations)
+			}
+
+			if taints := decodeMachineSubMap(t, out, "nodeTaints"); taints["dedicated"] != "storage:NoSchedule" {
+				t.Errorf("nodeTaints = %v", taints)
+			}
+
+			_, deleted := labels["node.kubernetes.io/exclude-from-external-load-balancers"]
+			if deleted != (name == "cozystack") {
+				t.Errorf("exclude-from-external-load-balancers removal present = %v on %s", deleted, name)
+			}
+		})
+	}
+}
+
+// Contract: a nodeLabels entry named after the label the cozystack
+// controlplane removes replaces the removal, so an operator can keep
+// the control plane out of external load balancers.
+func TestContract_Machine_NodeLabels_OverrideExcludeFromLB_Cozystack(t *testing.T) {
+	out := renderCozystackWith(t, helmEngineEmptyLookup, map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"nodeLabels":        map[string]any{"node.kubernetes.io/exclude-from-external-load-balancers": ""},
+	})
+
+	labels := decodeMachineSubMap(t, out, "nodeLabels")
+	if value, ok := labels["node.kubernetes.io/exclude-from-external-load-balancers"]; !ok || value != "" {
+		t.Errorf("nodeLabels = %v, want the label set to the empty string", labels)
+	}
+
+	assertNotContains(t, out, "$patch: delete")
+}
+
+// Contract: an entry that breaks the Kubernetes syntax fails the render
+// on both presets, naming the values key and the offending entry.
+func TestContract_Machine_NodeMetadata_RejectsInvalid(t *testing.T) {
+	cases := []struct {
+		name      string
+		overrides map[string]any
+		want      string
+	}{
+		{"label key", map[string]any{"nodeLabels": map[string]any{"-zone": "a"}}, `nodeLabels key "-zone" is not a valid Kubernetes qualified name`},
+		{"label key prefix", map[string]any{"nodeLabels": map[string]any{"Example.com/zone": "a"}}, `nodeLabels key "Example.com/zone"`},
+		{"label key too long", map[string]any{"nodeLabels": map[string]any{strings.Repeat("a", 64): "a"}}, "is not a valid Kubernetes qualified name"},
+		{"label value", map[string]any{"nodeLabels": map[string]any{"zone": "eu 1a"}}, `nodeLabels.zone has the value "eu 1a"`},
+		{"annotation key", map[string]any{"nodeAnnotations": map[string]any{"a/b/c": "x"}}, `nodeAnnotations key "a/b/c"`},
+		{"taint effect", map[string]any{"nodeTaints": map[string]any{"dedicated": "storage:NoRun"}}, `nodeTaints.dedicated="storage:NoRun" must be written as "<value>:<effect>"`},
+		{"taint without effect", map[string]any{"nodeTaints": map[string]any{"dedicated": "storage"}}, "nodeTaints.dedicated"},
+		{"taint value", map[string]any{"nodeTaints": map[string]any{"dedicated": "a b:NoSchedule"}}, `nodeTaints.dedicated has the value "a b"`},
+		{"not a map", map[string]any{"nodeLabels": []any{"zone=a"}}, "nodeLabels must be a map"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			overrides := map[string]any{"advertisedSubnets": []any{testAdvertisedSubnet}}
+			maps.Copy(overrides, tc.overrides)
+
+			for preset, err := range map[string]error{
+				"cozystack": renderCozystackExpectError(t, helmEngineEmptyLookup, overrides),
+				"generic":   renderGenericExpectError(t, helmEngineEmptyLookup, overrides),
+			} {
+				if err == nil || !strings.Contains(err.Error(), tc.want) {
+					t.Errorf("%s: error = %v, want it to contain %q", preset, err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// Contract: an annotation value is free text, and annotation keys are
+// checked lowercased, as the apiserver does.
+func TestContract_Machine_NodeAnnotations_FreeFormValues(t *testing.T) {
+	out := renderGenericWith(t, helmEngineEmptyLookup, map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"nodeAnnotations":   map[string]any{"Example.com/Note": "drained for maintenance: see #42"},
+	})
+
+	if annotations := decodeMachineSubMap(t, out, "nodeAnnotations"); annotations["Example.com/Note"] != "drained for maintenance: see #42" {
+		t.Errorf("nodeAnnotations = %v", annotations)
+	}
+}
+
 // Contract: nodeLabels never appears on worker templates (cozystack
 // or generic). Worker nodes do not need the LB-exclusion patch.
 func TestContract_Machine_NodeLabels_AbsentOnWorker(t *testing.T) {
@@ -713,6 +828,31 @@ func TestContract_Machine_ExtraMachineFiles_Generic_NonEmptyEmitsBlock(t *testin
 	assertContains(t, out, "path: /etc/example/operator.conf")
 }
 
+// Contract: generic preset resolves values.extensions into the Image
+// Factory installer image and appends the kernel modules and sysctls
+// the extensions need. An operator's own extraKernelModules entry for
+// the same module wins, parameters and all, instead of being listed
+// twice.
+func TestContract_Machine_Extensions_Generic_ResolvesInstallerAndModules(t *testing.T) {
+	out := renderGenericWithVersion(t, helmEngineEmptyLookup, "v1.12.1", map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"extensions":        []any{"siderolabs/drbd", "siderolabs/nvidia-open-gpu-kernel-modules-production", "siderolabs/nvidia-container-toolkit-production"},
+		"extraKernelModules": []any{
+			map[string]any{"name": "drbd", "parameters": []any{"usermode_helper=disabled", "disable_sendpage=1"}},
+		},
+	})
+	assertContains(t, out, "image: factory.talos.dev/installer/")
+	assertContains(t, out, ":v1.12.1")
+	assertContains(t, out, "- name: drbd_transport_tcp")
+	assertContains(t, out, "- name: nvidia_uvm")
+	assertContains(t, out, "disable_sendpage=1")
+	assertContains(t, out, "net.core.bpf_jit_harden:")
+
+	if n := strings.Count(out, "- name: drbd\n"); n != 1 {
+		t.Errorf("drbd listed %d times, want once:\n%s", n, out)
+	}
+}
+
 // Contract: cozystack always prepends 127.0.0.1 to machine.certSANs
 // (separate from the controlplane-only cluster.apiServer.certSANs
 // pinned in contract_cluster_test.go). machine-level certSANs control
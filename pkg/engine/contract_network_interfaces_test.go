@@ -0,0 +1,213 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Contract: the typed nodeInterfaces value. Bonds, VLANs and bridges
+// declared there render as BondConfig, VLANConfig and BridgeConfig
+// documents in place of what discovery would emit for the same links,
+// and a declaration Talos would reject, or that names a link the node
+// does not have, fails the render instead of the apply.
+//
+// Uses multiNicLookup from render_test.go: eth0 carries the default
+// route and 192.168.201.10/24, eth1 carries 10.0.0.5/24.
+
+package engine
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+// nodeInterfacesOverrides returns the render overrides with
+// nodeInterfaces set to spec.
+func nodeInterfacesOverrides(spec map[string]any) map[string]any {
+	return map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"nodeInterfaces":    spec,
+	}
+}
+
+// Contract: a declared bond renders as a BondConfig taking over its
+// members. The members lose their LinkConfig documents, their
+// discovered addresses move to the bond, and the default route follows
+// the gateway link into it.
+func TestContract_NodeInterfaces_BondTakesOverMembers(t *testing.T) {
+	spec := map[string]any{
+		"bonds": []any{map[string]any{
+			"name":           "bond0",
+			"links":          []any{"eth0", "eth1"},
+			"mode":           "802.3ad",
+			"xmitHashPolicy": "layer3+4",
+			"miimon":         100,
+			"mtu":            9000,
+		}},
+	}
+
+	for name, out := range map[string]string{
+		"cozystack": renderCozystackWith(t, multiNicLookup(), nodeInterfacesOverrides(spec)),
+		"generic":   renderGenericWith(t, multiNicLookup(), nodeInterfacesOverrides(spec)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertContains(t, out, "kind: BondConfig\nname: bond0\nlinks:\n  - eth0\n  - eth1\nbondMode: 802.3ad\nxmitHashPolicy: layer3+4\nmiimon: 100")
+			assertContains(t, out, "addresses:\n  - address: 192.168.201.10/24\n  - address: 10.0.0.5/24\nroutes:\n  - gateway: 192.168.201.1\nmtu: 9000")
+			assertNotContains(t, out, "kind: LinkConfig")
+		})
+	}
+}
+
+// Contract: a declared VLAN is named <parent>.<vlanID> unless named,
+// declared addresses replace discovered ones, and a bridge can take a
+// declared VLAN as a port. Links nodeInterfaces does not touch keep
+// their discovered documents.
+func TestContract_NodeInterfaces_VLANAndBridge(t *testing.T) {
+	out := renderCozystackWith(t, multiNicLookup(), nodeInterfacesOverrides(map[string]any{
+		"vlans": []any{map[string]any{"parent": "eth1", "vlanID": 100, "addresses": []any{"10.1.0.5/24"}}},
+		"bridges": []any{map[string]any{
+			"name":  "br0",
+			"links": []any{"eth1.100"},
+			"stp":   true,
+		}},
+	}))
+
+	assertContains(t, out, "kind: VLANConfig\nname: eth1.100\nvlanID: 100\nparent: eth1\naddresses:\n  - address: 10.1.0.5/24")
+	assertContains(t, out, "kind: BridgeConfig\nname: br0\nlinks:\n  - eth1.100\nstp:\n  enabled: true")
+	assertContains(t, out, "kind: LinkConfig\nname: eth0")
+	assertContains(t, out, "kind: LinkConfig\nname: eth1")
+}
+
+// Contract: a declared link that discovery also reports is rendered
+// once, from the declaration.
+func TestContract_NodeInterfaces_ReplacesDiscoveredBond(t *testing.T) {
+	out := renderCozystackWith(t, bondTopologyLookup(), nodeInterfacesOverrides(map[string]any{
+		"bonds": []any{map[string]any{"name": "bond0", "links": []any{"eth0", "eth1"}, "mode": "active-backup"}},
+	}))
+
+	if got := strings.Count(out, "kind: BondConfig"); got != 1 {
+		t.Fatalf("want one BondConfig, got %d:\n%s", got, out)
+	}
+
+	assertContains(t, out, "bondMode: active-backup")
+	assertNotContains(t, out, "bondMode: 802.3ad")
+}
+
+// Contract: the floatingIP follows a gateway link that becomes a bond
+// member, like the default route.
+func TestContract_NodeInterfaces_VIPFollowsBond(t *testing.T) {
+	overrides := nodeInterfacesOverrides(map[string]any{
+		"bonds": []any{map[string]any{"name": "bond0", "links": []any{"eth0"}, "mode": "active-backup"}},
+	})
+	overrides["floatingIP"] = "192.168.201.100"
+
+	out := renderCozystackWith(t, multiNicLookup(), overrides)
+	assertContains(t, out, "kind: Layer2VIPConfig\nname: \"192.168.201.100\"\nlink: bond0")
+}
+
+// Contract: without discovery, as in an offline render, member and
+// parent existence is not checked.
+func TestContract_NodeInterfaces_OfflineSkipsExistence(t *testing.T) {
+	out := renderGenericWith(t, helmEngineEmptyLookup, nodeInterfacesOverrides(map[string]any{
+		"bonds": []any{map[string]any{"name": "bond0", "links": []any{"enp1s0f0", "enp1s0f1"}, "mode": "802.3ad", "addresses": []any{"192.0.2.10/24"}}},
+	}))
+	assertContains(t, out, "  - enp1s0f0\n  - enp1s0f1")
+}
+
+// Contract: every malformed declaration fails the render on both
+// presets, naming the entry and what is wrong with it.
+func TestContract_NodeInterfaces_RejectsInvalid(t *testing.T) {
+	bond := func(fields map[string]any) map[string]any {
+		entry := map[string]any{"name": "bond0", "links": []any{"eth0"}, "mode": "802.3ad"}
+		maps.Copy(entry, fields)
+
+		return map[string]any{"bonds": []any{entry}}
+	}
+
+	cases := []struct {
+		name string
+		spec any
+		want string
+	}{
+		{"not a map", []any{}, "nodeInterfaces must be a map"},
+		{"unknown kind", map[string]any{"bond": []any{}}, "nodeInterfaces.bond is not a known key"},
+		{"kind not a list", map[string]any{"bonds": map[string]any{}}, "nodeInterfaces.bonds must be a list"},
+		{"unknown field", bond(map[string]any{"mtuu": 9000}), "nodeInterfaces.bonds[0].mtuu is not a field of a bond"},
+		{"missing name", bond(map[string]any{"name": ""}), "nodeInterfaces.bonds[0].name must be set"},
+		{"long name", bond(map[string]any{"name": "bond-to-the-top-of-rack"}), "a link name is at most 15 characters"},
+		{"name of a NIC", bond(map[string]any{"name": "eth1", "links": []any{"eth0"}}), `declares a bond named "eth1", but the node has a physical link of that name`},
+		{"no members", bond(map[string]any{"links": []any{}}), "links must list at least one link"},
+		{"unknown member", bond(map[string]any{"links": []any{"eth9"}}), `lists "eth9", which the node does not have (discovered links: eth0, eth1)`},
+		{"bond mode", bond(map[string]any{"mode": "lacp"}), `mode="lacp" must be one of`},
+		{"mtu", bond(map[string]any{"mtu": 10}), "nodeInterfaces.bonds[0].mtu=10 must be an integer from 68 to 65535"},
+		{"quoted mtu", bond(map[string]any{"mtu": "9000"}), "mtu=9000 must be an integer"},
+		{"address", bond(map[string]any{"addresses": []any{"10.0.0.1"}}), `addresses has "10.0.0.1", which is not an address in CIDR form`},
+		{"port reuse", map[string]any{
+			"bridges": []any{
+				map[string]any{"name": "br0", "links": []any{"eth1"}},
+				map[string]any{"name": "br1", "links": []any{"eth1"}},
+			},
+		}, `nodeInterfaces.bridges[1].links lists "eth1", which is already a port of br0`},
+		{"bond member in a bridge", map[string]any{
+			"bonds":   []any{map[string]any{"name": "bond0", "links": []any{"eth0", "eth1"}, "mode": "802.3ad"}},
+			"bridges": []any{map[string]any{"name": "br0", "links": []any{"eth1"}}},
+		}, `"eth1", which is already a port of bond0`},
+		{"stp", map[string]any{"bridges": []any{map[string]any{"name": "br0", "links": []any{"eth1"}, "stp": "yes"}}}, "nodeInterfaces.bridges[0].stp must be true or false"},
+		{"vlan id zero", map[string]any{"vlans": []any{map[string]any{"parent": "eth0", "vlanID": 0}}}, "nodeInterfaces.vlans[0].vlanID=0 must be an integer from 1 to 4094"},
+		{"vlan id too large", map[string]any{"vlans": []any{map[string]any{"parent": "eth0", "vlanID": 4095}}}, "must be an integer from 1 to 4094"},
+		{"vlan without parent", map[string]any{"vlans": []any{map[string]any{"vlanID": 5}}}, "nodeInterfaces.vlans[0].parent must name the link"},
+		{"vlan on unknown parent", map[string]any{"vlans": []any{map[string]any{"parent": "eth7", "vlanID": 5}}}, `parent is "eth7", which is neither a link of the node`},
+		{"vlan twice", map[string]any{
+			"vlans": []any{
+				map[string]any{"parent": "eth0", "vlanID": 5},
+				map[string]any{"name": "storage", "parent": "eth0", "vlanID": 5},
+			},
+		}, "nodeInterfaces.vlans[1] declares VLAN 5 on eth0 a second time"},
+		{"vlan on a port", map[string]any{
+			"bonds": []any{map[string]any{"name": "bond0", "links": []any{"eth0"}, "mode": "802.3ad"}},
+			"vlans": []any{map[string]any{"parent": "eth0", "vlanID": 5}},
+		}, `parent is "eth0", a port of bond0; put the VLAN on bond0 instead`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			overrides := map[string]any{"nodeInterfaces": tc.spec}
+
+			for preset, err := range map[string]error{
+				"cozystack": renderCozystackExpectError(t, multiNicLookup(), overrides),
+				"generic":   renderGenericExpectError(t, multiNicLookup(), overrides),
+			} {
+				if err == nil || !strings.Contains(err.Error(), tc.want) {
+					t.Errorf("%s: error = %v, want it to contain %q", preset, err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// Contract: the legacy schema has no typed link documents, so a legacy
+// render that declares nodeInterfaces fails and says how to proceed.
+func TestContract_NodeInterfaces_LegacyRefused(t *testing.T) {
+	overrides := nodeInterfacesOverrides(map[string]any{
+		"bonds": []any{map[string]any{"name": "bond0", "links": []any{"eth0"}, "mode": "802.3ad"}},
+	})
+
+	err := renderCozystackExpectError(t, multiNicLookup(), overrides, "v1.11.0")
+	if err == nil || !strings.Contains(err.Error(), "set templateOptions.talosVersion in Chart.yaml to v1.12 or later") {
+		t.Errorf("error = %v", err)
+	}
+
+	out := renderLegacyCozystackControlplane(t, multiNicLookup(), map[string]any{
+		"advertisedSubnets": []any{testAdvertisedSubnet},
+		"nodeInterfaces":    map[string]any{"bonds": []any{}},
+	})
+	assertContains(t, out, "interfaces:")
+}
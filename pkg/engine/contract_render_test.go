@@ -28,8 +28,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
 	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"gopkg.in/yaml.v3"
 )
 
 // === Render: error surfaces ===
@@ -365,3 +368,59 @@ func TestContract_Render_OfflineSkipsMultiNodeCheck(t *testing.T) {
 	}
 	_ = os.Stdout // keep imports stable for future expansion
 }
+
+// === CertificateExpiry render context ===
+
+// Contract: loadCertificateExpiry yields an empty map when no bundle
+// is configured or the file does not exist yet, so charts can guard
+// with `with .CertificateExpiry.talosCA` instead of failing.
+func TestContract_LoadCertificateExpiry_AbsentBundleIsEmpty(t *testing.T) {
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "secrets.yaml")} {
+		got, err := loadCertificateExpiry(path, time.Now())
+		if err != nil {
+			t.Fatalf("path %q: unexpected error: %v", path, err)
+		}
+		if len(got) != 0 {
+			t.Errorf("path %q: expected empty map, got %v", path, got)
+		}
+	}
+}
+
+// Contract: a real bundle exposes every CA with notAfter and
+// daysLeft under its stable key.
+func TestContract_LoadCertificateExpiry_ExposesCAs(t *testing.T) {
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), nil)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+
+	data, err := yaml.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadCertificateExpiry(path, time.Now())
+	if err != nil {
+		t.Fatalf("loadCertificateExpiry: %v", err)
+	}
+
+	for _, key := range []string{"talosCA", "kubernetesCA", "kubernetesAggregatorCA", "etcdCA"} {
+		entry, ok := got[key].(map[string]any)
+		if !ok {
+			t.Errorf("missing %s in %v", key, got)
+
+			continue
+		}
+		if _, ok := entry["notAfter"].(time.Time); !ok {
+			t.Errorf("%s.notAfter must be a time.Time, got %T", key, entry["notAfter"])
+		}
+		if days, _ := entry["daysLeft"].(int); days <= 0 {
+			t.Errorf("%s.daysLeft must be positive for a fresh bundle, got %v", key, entry["daysLeft"])
+		}
+	}
+}
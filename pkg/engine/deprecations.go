@@ -0,0 +1,163 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// Keys of the values.schema.json annotations that declare deprecated
+// values. `deprecated` is the standard JSON Schema keyword;
+// `replacedBy` names the dotted path of the value that supersedes the
+// deprecated one.
+const (
+	schemaKeyDeprecated = "deprecated"
+	schemaKeyReplacedBy = "replacedBy"
+)
+
+// deprecationWarningWriter is the sink for deprecated-value warnings.
+// Defaulted to os.Stderr; redirected in tests.
+//
+//nolint:gochecknoglobals // package-level writer is the standard Go pattern for test-overridable side-channel output, same as setValueWarningWriter.
+var deprecationWarningWriter io.Writer = os.Stderr
+
+// deprecatedValue is one value the chart schema marks deprecated and
+// the merged values set.
+type deprecatedValue struct {
+	// Path is the dotted values path, e.g. `floatingIP`.
+	Path string
+	// ReplacedBy is the dotted path of the replacement, empty when the
+	// chart names none.
+	ReplacedBy string
+	// Description is the schema description, empty when absent. Charts
+	// use it to explain a migration that is more than a rename.
+	Description string
+}
+
+// String is the operator-facing warning line.
+func (d deprecatedValue) String() string {
+	msg := fmt.Sprintf("value `%s` is deprecated", d.Path)
+	if d.ReplacedBy != "" {
+		msg += fmt.Sprintf("; set `%s` instead", d.ReplacedBy)
+	}
+
+	if d.Description != "" {
+		msg += ": " + d.Description
+	}
+
+	return msg
+}
+
+// findDeprecatedValues walks the chart's values.schema.json and
+// returns every property marked `"deprecated": true` that is set in
+// values, in sorted path order. Like prompting, only nested
+// `properties` objects are followed. A value left empty does not
+// count: charts keep deprecated keys in values.yaml with an empty
+// default so older templates still render.
+func findDeprecatedValues(schema []byte, values map[string]any) ([]deprecatedValue, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrap(err, "parsing values.schema.json"),
+			"values.schema.json must be a JSON object; validate it with any JSON Schema linter",
+		)
+	}
+
+	var found []deprecatedValue
+
+	collectDeprecatedValues(root, nil, values, &found)
+
+	return found, nil
+}
+
+func collectDeprecatedValues(node map[string]any, prefix []string, values map[string]any, found *[]deprecatedValue) {
+	properties, _ := node[schemaKeyProperties].(map[string]any)
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		child, ok := properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		path := append(append([]string(nil), prefix...), name)
+
+		if marked, _ := child[schemaKeyDeprecated].(bool); marked && !valueMissing(values, path) {
+			replacedBy, _ := child[schemaKeyReplacedBy].(string)
+			description, _ := child[schemaKeyDescription].(string)
+
+			*found = append(*found, deprecatedValue{
+				Path:        strings.Join(path, "."),
+				ReplacedBy:  replacedBy,
+				Description: description,
+			})
+		}
+
+		collectDeprecatedValues(child, path, values, found)
+	}
+}
+
+// checkDeprecatedValues warns about every deprecated value set in
+// values, or, with strict, fails with all of them at once so one run
+// lists the whole migration.
+func checkDeprecatedValues(schema []byte, values map[string]any, strict bool) error {
+	found, err := findDeprecatedValues(schema, values)
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	if strict {
+		lines := make([]string, 0, len(found))
+		for _, d := range found {
+			lines = append(lines, "  - "+d.String())
+		}
+
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("deprecated values are set:\n%s", strings.Join(lines, "\n")),
+			"move each value to its replacement in values.yaml (or the --values file or --set flag that sets it), or drop --strict to render with warnings",
+		)
+	}
+
+	for _, d := range found {
+		ui.Warnf(deprecationWarningWriter, "%s", d)
+	}
+
+	return nil
+}
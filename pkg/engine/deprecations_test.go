@@ -0,0 +1,151 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+const deprecationTestSchema = `{
+  "type": "object",
+  "properties": {
+    "floatingIP": {"type": "string", "deprecated": true, "replacedBy": "vip.address"},
+    "vip": {
+      "type": "object",
+      "properties": {
+        "address": {"type": "string"},
+        "link": {"type": "string", "deprecated": true, "description": "the link is now detected from vip.address"}
+      }
+    },
+    "oldFlag": {"type": "boolean", "deprecated": true, "replacedBy": "newFlag"},
+    "unused": {"type": "string", "deprecated": true, "replacedBy": "other"}
+  }
+}`
+
+func withCapturedDeprecationWarnings(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	prev := deprecationWarningWriter
+	deprecationWarningWriter = buf
+
+	t.Cleanup(func() {
+		deprecationWarningWriter = prev
+	})
+
+	return buf
+}
+
+// TestFindDeprecatedValues pins which deprecated properties count as
+// set: present and non-empty, at any nesting depth, with false still
+// counting as a value.
+func TestFindDeprecatedValues(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]any{
+		"floatingIP": "192.0.2.100",
+		"vip":        map[string]any{"link": "eth0"},
+		"oldFlag":    false,
+		"unused":     "",
+	}
+
+	got, err := findDeprecatedValues([]byte(deprecationTestSchema), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []deprecatedValue{
+		{Path: "floatingIP", ReplacedBy: "vip.address"},
+		{Path: "oldFlag", ReplacedBy: "newFlag"},
+		{Path: "vip.link", Description: "the link is now detected from vip.address"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findDeprecatedValues = %+v, want %+v", got, want)
+	}
+
+	if got, err := findDeprecatedValues(nil, values); err != nil || got != nil {
+		t.Errorf("a chart without a schema has no deprecations, got %v, %v", got, err)
+	}
+
+	if _, err := findDeprecatedValues([]byte("["), values); err == nil {
+		t.Error("a malformed schema must fail")
+	}
+}
+
+func TestCheckDeprecatedValues(t *testing.T) {
+	warnings := withCapturedDeprecationWarnings(t)
+	values := map[string]any{"floatingIP": "192.0.2.100", "vip": map[string]any{"link": "eth0"}}
+
+	if err := checkDeprecatedValues([]byte(deprecationTestSchema), values, false); err != nil {
+		t.Fatalf("without strict the render must go on: %v", err)
+	}
+
+	wantWarnings := "Warning: value `floatingIP` is deprecated; set `vip.address` instead\n" +
+		"Warning: value `vip.link` is deprecated: the link is now detected from vip.address\n"
+	if warnings.String() != wantWarnings {
+		t.Errorf("warnings = %q, want %q", warnings.String(), wantWarnings)
+	}
+
+	warnings.Reset()
+
+	err := checkDeprecatedValues([]byte(deprecationTestSchema), values, true)
+	if err == nil {
+		t.Fatal("strict must fail")
+	}
+
+	for _, want := range []string{"`floatingIP` is deprecated; set `vip.address` instead", "`vip.link` is deprecated"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("strict error lacks %q: %v", want, err)
+		}
+	}
+
+	if len(errors.GetAllHints(err)) == 0 {
+		t.Error("strict error must carry a migration hint")
+	}
+
+	if warnings.Len() != 0 {
+		t.Errorf("strict must not warn as well, got %q", warnings.String())
+	}
+}
+
+// TestEffectiveValues_Deprecations pins that the check sees the merged
+// values: a deprecated key set only through --set is caught, and one
+// the chart keeps with an empty default is not.
+func TestEffectiveValues_Deprecations(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "config.yaml", "machine:\n  type: worker\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.yaml"), "floatingIP: \"\"\nvip:\n  address: 192.0.2.100\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.schema.json"), deprecationTestSchema)
+
+	warnings := withCapturedDeprecationWarnings(t)
+
+	if _, err := EffectiveValues(Options{Root: chartRoot}); err != nil {
+		t.Fatal(err)
+	}
+
+	if warnings.Len() != 0 {
+		t.Errorf("empty deprecated defaults must not warn, got %q", warnings.String())
+	}
+
+	_, err := EffectiveValues(Options{Root: chartRoot, StringValues: []string{"floatingIP=192.0.2.200"}, StrictDeprecations: true})
+	if err == nil || !strings.Contains(err.Error(), "`floatingIP` is deprecated") {
+		t.Errorf("a deprecated value set through --set-string must fail a strict render, got %v", err)
+	}
+}
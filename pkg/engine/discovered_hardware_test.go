@@ -0,0 +1,112 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// hardwareChart creates a chart rendering talm.discovered.hardware
+// through the library helpers.
+func hardwareChart(t *testing.T) string {
+	t.Helper()
+
+	root := createTestChart(t, "tc", "out.yaml", `hardware={{ include "talm.discovered.hardware" . }}`+"\n")
+
+	helpers, err := os.ReadFile("../../charts/talm/templates/_helpers.tpl")
+	if err != nil {
+		t.Fatalf("read helpers: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "templates", "_helpers.tpl"), helpers, 0o644); err != nil {
+		t.Fatalf("write vendored helpers: %v", err)
+	}
+
+	return root
+}
+
+func pciDevice(address, classID, vendor, product, driver string) map[string]any {
+	return map[string]any{
+		"metadata": map[string]any{"id": address},
+		"spec": map[string]any{
+			"class_id":   classID,
+			"vendor":     vendor,
+			"product":    product,
+			"vendor_id":  "0x10de",
+			"product_id": "0x20b5",
+			"driver":     driver,
+		},
+	}
+}
+
+// hardwareLookup answers the lookups of a two-socket node with a GPU,
+// an SR-IOV NIC, a NIC without SR-IOV and an empty socket. Counts come
+// as float64 and int, as from JSON and YAML.
+func hardwareLookup(kind, _, _ string) (map[string]any, error) {
+	switch kind {
+	case "pcidevices":
+		return map[string]any{"items": []any{
+			pciDevice("0000:00:02.0", "0x06", "Intel Corporation", "Host bridge", ""),
+			pciDevice("0000:17:00.0", "0x03", "NVIDIA Corporation", "GA100 [A100 PCIe 80GB]", "nvidia"),
+			pciDevice("0000:3b:00.0", "0x02", "Mellanox Technologies", "MT2892 Family [ConnectX-6 Dx]", "mlx5_core"),
+			pciDevice("0000:3c:00.0", "0x02", "Realtek", "RTL8111", "r8169"),
+		}}, nil
+	case "cpus":
+		return map[string]any{"items": []any{
+			map[string]any{"spec": map[string]any{"socket": "CPU0", "coreCount": float64(32), "coreEnabled": float64(30), "threadCount": float64(60)}},
+			map[string]any{"spec": map[string]any{"socket": "CPU1", "coreCount": 32, "threadCount": 64}},
+			map[string]any{"spec": map[string]any{"socket": "CPU2"}},
+		}}, nil
+	case "memorymodules":
+		return map[string]any{"items": []any{
+			map[string]any{"spec": map[string]any{"sizeMiB": float64(65536)}},
+			map[string]any{"spec": map[string]any{"sizeMiB": 65536}},
+			map[string]any{"spec": map[string]any{"deviceLocator": "DIMM_B2"}},
+		}}, nil
+	}
+
+	return map[string]any{}, nil
+}
+
+// TestDiscoveredHardware pins the facts talm.discovered.hardware
+// derives from the PCI devices, processors and memory modules.
+func TestDiscoveredHardware(t *testing.T) {
+	output := renderChartTemplateWithLookup(t, hardwareChart(t), "templates/out.yaml", hardwareLookup)
+
+	for _, want := range []string{
+		`"gpus":[{"address":"0000:17:00.0","driver":"nvidia","product":"GA100 [A100 PCIe 80GB]","productID":"0x20b5","vendor":"NVIDIA Corporation","vendorID":"0x10de"}]`,
+		`"sriovNics":[{"address":"0000:3b:00.0","driver":"mlx5_core"`,
+		`"cpuSockets":2`,
+		`"cpuCores":62`,
+		`"cpuThreads":124`,
+		`"memoryMiB":131072`,
+	} {
+		assertContains(t, output, want)
+	}
+
+	assertNotContains(t, output, "r8169")
+	assertNotContains(t, output, "Host bridge")
+	assertNotContains(t, output, "<nil>")
+}
+
+// TestDiscoveredHardware_Offline pins the facts of a render without
+// lookups: empty lists and zero counts, so a chart's gate stays off.
+func TestDiscoveredHardware_Offline(t *testing.T) {
+	output := renderChartTemplateWithLookup(t, hardwareChart(t), "templates/out.yaml", nil)
+
+	assertContains(t, output, `hardware={"cpuCores":0,"cpuSockets":0,"cpuThreads":0,"gpus":[],"memoryMiB":0,"sriovNics":[]}`)
+}
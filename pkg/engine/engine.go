@@ -6,13 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"maps"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
@@ -23,7 +23,9 @@ import (
 	"github.com/cosi-project/runtime/pkg/resource"
 	"github.com/cosi-project/runtime/pkg/resource/meta"
 	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/certexpiry"
 	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+	"github.com/cozystack/talm/pkg/modeline"
 	"github.com/cozystack/talm/pkg/yamltools"
 	"github.com/hashicorp/go-multierror"
 	"helm.sh/helm/v4/pkg/chart/v2/loader"
@@ -67,6 +69,53 @@ type Options struct {
 	// lookups name the endpoints the operator actually targeted, instead
 	// of forcing them to reconstruct from CLI flags / modeline.
 	TalosEndpoints []string
+	// AllowEnv is the Chart.yaml templateOptions.allowEnv allowlist of
+	// environment variable names the chart `env` function may read.
+	AllowEnv []string
+	// SecretStore is the Chart.yaml templateOptions.secretStore the
+	// chart `secretRef` function reads from.
+	SecretStore SecretStore
+	// Prompt, when set, is called for every values.schema.json
+	// property marked `"prompt": true` that the merged values leave
+	// empty. Callers set it only for interactive sessions.
+	Prompt PromptFunc `yaml:"-"`
+	// MergeRules is the Chart.yaml templateOptions.mergeRules list of
+	// per-path list merge strategies applied when value layers merge.
+	MergeRules []MergeRule
+	// ValuesLock is the path of a values.lock.yaml written by
+	// `talm snapshot values`. When set, its content is the complete
+	// .Values of the render: the chart's values.yaml, ValueFiles and
+	// every --set* source are ignored.
+	ValuesLock string
+	// StrictDeprecations fails the render when a value the chart's
+	// values.schema.json marks `"deprecated": true` is set, instead of
+	// warning about it.
+	StrictDeprecations bool
+	// Profile, when set, collects the time Render spends per phase,
+	// per template and per lookup kind.
+	Profile *RenderProfile `yaml:"-"`
+	// Ownership, when set, records which template file and named
+	// template produced each document of the rendered config.
+	Ownership *Ownership `yaml:"-"`
+	// AnnotateSources puts a `# Source:` comment naming what produced
+	// it above every document of the rendered config.
+	AnnotateSources bool
+	// Snapshot, when set on an Offline render, answers the chart
+	// lookups from a snapshot `talm snapshot cluster` recorded of the
+	// node, instead of returning nothing.
+	Snapshot *NodeSnapshot `yaml:"-"`
+	// PrewarmLookups are the lookup kinds an online render lists from
+	// the node together before the templates run, instead of one
+	// request at a time on first use.
+	PrewarmLookups []string
+	// Role, when set, renders the templates for one machine type,
+	// controlplane or worker: it is the .MachineType of the render, and
+	// the rendered config must declare it.
+	Role string
+	// Roles is the Chart.yaml templateOptions.roles matrix of the
+	// templates each role renders. With a Role set, every template of
+	// the render must be one the matrix lists for it.
+	Roles map[string][]string
 }
 
 // NormalizeTemplatePath converts OS-specific path separators to forward slash.
@@ -265,24 +314,79 @@ func SerializeConfiguration(configBundle *bundle.Bundle, machineType machine.Typ
 // ApplyConfiguration) but unsuitable for human-facing output such as
 // `talm template` — which is why the template subcommand does not call
 // this helper.
-//
-//nolint:funlen // 73 lines: each step (read, hint, strip three classes of patch directives, prune identities, apply, decode, encode) is a single linear pipeline; extracting helpers would scatter the contextual error wrapping across files without simplifying the algorithm.
 func MergeFileAsPatch(rendered []byte, patchFile string) ([]byte, error) {
-	patchBytes, err := os.ReadFile(patchFile)
+	cleanedRendered, prunedBytes, err := nodeBodyPatch(rendered, patchFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if prunedBytes == nil {
+		return cleanedRendered, nil
+	}
+
+	patch, err := configpatcher.LoadPatch(prunedBytes)
+	if err != nil {
+		return nil, errors.Wrapf(
+			errors.WithHint(err, "the node body must be a Talos config (full or partial), a JSON Patch list, or a YAML patch list — see https://www.talos.dev/latest/talos-guides/configuration/patching/"),
+			"loading patch from %q", patchFile,
+		)
+	}
+
+	out, err := configpatcher.Apply(configpatcher.WithBytes(cleanedRendered), []configpatcher.Patch{patch})
+	if err != nil {
+		return nil, errors.Wrapf(
+			errors.WithHintf(err, "the patch references a path the rendered template does not contain; check the output of: talm template -f %q", patchFile),
+			"applying patch from %q", patchFile,
+		)
+	}
+
+	merged, err := out.Bytes()
 	if err != nil {
 		return nil, errors.Wrapf(
+			errors.WithHint(err, "configpatcher.Apply succeeded but the result could not be serialised back to YAML; this is internal — file an issue if reproducible"),
+			"encoding merged config from %q", patchFile,
+		)
+	}
+
+	return merged, nil
+}
+
+// nodeBodyPatch prepares the body of the node file patchFile to be
+// merged onto rendered, the way MergeFileAsPatch merges it: it returns
+// rendered without its $patch:delete directives, and the body without
+// the directives and the values rendered already holds. The body is
+// nil when nothing of it is left to merge, and rendered is then
+// returned as MergeFileAsPatch returns it.
+//
+//nolint:funlen // the read, hint, strip and prune steps of MergeFileAsPatch, each with its own contextual error wrapping.
+func nodeBodyPatch(rendered []byte, patchFile string) ([]byte, []byte, error) {
+	patchBytes, err := os.ReadFile(patchFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(
 			errors.WithHint(err, "verify the path is correct and the file is readable by the user running talm"),
 			"reading patch %q", patchFile,
 		)
 	}
 
+	patchBytes, err = modeline.NodeFileBody(patchBytes)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading patch %q", patchFile)
+	}
+
 	if isEffectivelyEmptyYAML(patchBytes) {
-		return rendered, nil
+		return rendered, nil, nil
+	}
+
+	// The strip and prune walks below compare yaml.Node trees and do
+	// not follow aliases or merge keys.
+	patchBytes, err = yamltools.ExpandAnchors(patchBytes)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "expanding anchors in patch %q", patchFile)
 	}
 
 	cleanedRendered, renderedDirectivePaths, err := stripAllPatchDeleteDirectives(rendered)
 	if err != nil {
-		return nil, errors.Wrap(
+		return nil, nil, errors.Wrap(
 			errors.WithHint(err, "the rendered template did not parse as YAML; this points at a chart-helper bug, not a user input issue"),
 			"stripping $patch:delete directives from rendered",
 		)
@@ -290,7 +394,7 @@ func MergeFileAsPatch(rendered []byte, patchFile string) ([]byte, error) {
 
 	cleanedPatch, err := stripPatchDeleteDirectivesAtPaths(patchBytes, renderedDirectivePaths)
 	if err != nil {
-		return nil, errors.Wrapf(
+		return nil, nil, errors.Wrapf(
 			errors.WithHintf(err, "the node body did not parse as YAML; verify %q is well-formed", patchFile),
 			"stripping redundant $patch:delete directives from %q", patchFile,
 		)
@@ -298,7 +402,7 @@ func MergeFileAsPatch(rendered []byte, patchFile string) ([]byte, error) {
 
 	cleanedPatch, err = stripPatchDeleteDirectivesAbsentInTarget(cleanedPatch, cleanedRendered)
 	if err != nil {
-		return nil, errors.Wrapf(
+		return nil, nil, errors.Wrapf(
 			errors.WithHintf(err, "the node body did not parse as YAML; verify %q is well-formed", patchFile),
 			"stripping no-op $patch:delete directives from %q", patchFile,
 		)
@@ -306,41 +410,17 @@ func MergeFileAsPatch(rendered []byte, patchFile string) ([]byte, error) {
 
 	prunedBytes, allPruned, err := pruneBodyIdentitiesAgainstRendered(cleanedPatch, cleanedRendered)
 	if err != nil {
-		return nil, errors.Wrapf(
+		return nil, nil, errors.Wrapf(
 			errors.WithHintf(err, "the prune walk failed; the input is likely malformed YAML or has an unexpected document shape; inspect %q", patchFile),
 			"pruning identity overlap in %q", patchFile,
 		)
 	}
 
 	if allPruned {
-		return cleanedRendered, nil
+		return cleanedRendered, nil, nil
 	}
 
-	patch, err := configpatcher.LoadPatch(prunedBytes)
-	if err != nil {
-		return nil, errors.Wrapf(
-			errors.WithHint(err, "the node body must be a Talos config (full or partial), a JSON Patch list, or a YAML patch list — see https://www.talos.dev/latest/talos-guides/configuration/patching/"),
-			"loading patch from %q", patchFile,
-		)
-	}
-
-	out, err := configpatcher.Apply(configpatcher.WithBytes(cleanedRendered), []configpatcher.Patch{patch})
-	if err != nil {
-		return nil, errors.Wrapf(
-			errors.WithHintf(err, "the patch references a path the rendered template does not contain; check the output of: talm template -f %q", patchFile),
-			"applying patch from %q", patchFile,
-		)
-	}
-
-	merged, err := out.Bytes()
-	if err != nil {
-		return nil, errors.Wrapf(
-			errors.WithHint(err, "configpatcher.Apply succeeded but the result could not be serialised back to YAML; this is internal — file an issue if reproducible"),
-			"encoding merged config from %q", patchFile,
-		)
-	}
-
-	return merged, nil
+	return cleanedRendered, prunedBytes, nil
 }
 
 // stripAllPatchDeleteDirectives walks every YAML document in `data` and
@@ -1401,6 +1481,9 @@ const (
 	helmKeyValues = "Values"
 	// helmKeyTalosVer is the chart-rendering top-level TalosVersion context key.
 	helmKeyTalosVer = "TalosVersion"
+	// helmKeyCertExpiry is the chart-rendering top-level context key
+	// carrying the secrets bundle CA expiry dates.
+	helmKeyCertExpiry = "CertificateExpiry"
 	// cosiKindList is the COSI Kind value emitted when newLookupFunction
 	// wraps multi-item lookups into a List envelope for template iteration.
 	cosiKindList = "List"
@@ -1540,6 +1623,11 @@ func NodeFileHasOverlay(patchFile string) (bool, error) {
 		return false, errors.Wrapf(err, "reading node file %s", patchFile)
 	}
 
+	data, err = modeline.NodeFileBody(data)
+	if err != nil {
+		return false, errors.Wrapf(err, "reading node file %s", patchFile)
+	}
+
 	return !isEffectivelyEmptyYAML(data), nil
 }
 
@@ -1576,19 +1664,57 @@ func isEffectivelyEmptyYAML(data []byte) bool {
 
 // Render executes the rendering of templates based on the provided options.
 //
-//nolint:funlen,gocritic // funlen: 75-line linear dispatch over (Full ? FullConfigProcess : ApplyPatches) with per-branch cluster-meta hydration and per-mode serialisation; splitting either branch would scatter the shared FailIfMultiNodes/loadValues/SerializeConfiguration steps across helpers without simplifying control flow. hugeParam: Options is the package's public configuration carrier; passing by pointer would propagate across pkg/commands and external consumers.
+//nolint:gocritic // hugeParam: Options is the package's public configuration carrier; passing by pointer would propagate across pkg/commands and external consumers.
 func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error) {
+	requestedTemplates, configPatches, ownership, err := renderTemplates(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	finalConfig, err := applyPatchesAndRenderConfig(opts, configPatches)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Profile.phase(phaseApplyPatches, start)
+
+	ownership.record(requestedTemplates, configPatches)
+
+	if opts.AnnotateSources {
+		finalConfig = annotateSources(finalConfig, ownership.Documents())
+	}
+
+	return finalConfig, nil
+}
+
+// renderTemplates runs the chart for opts and returns the output of
+// each template file of opts.TemplateFiles, with the chart paths of
+// those templates, before any of it is merged into a config. The
+// ownership is the one Render records the documents in, nil when
+// neither opts.Ownership nor opts.AnnotateSources asks for one.
+//
+//nolint:funlen,gocritic // the render front half moved out of Render as is; hugeParam: Options is passed by value like Render takes it.
+func renderTemplates(ctx context.Context, c *client.Client, opts Options) ([]string, []string, *Ownership, error) {
 	// Validate TalosVersion early so malformed values surface a user-friendly
 	// error instead of an opaque "semverCompare: invalid semantic version" from
 	// inside template rendering.
 	if opts.TalosVersion != "" {
 		_, err := config.ParseContractFromVersion(opts.TalosVersion)
 		if err != nil {
-			return nil, errors.Wrap(err, "invalid talos-version")
+			return nil, nil, nil, errors.Wrap(err, "invalid talos-version")
 		}
 	}
 
-	// Gather facts and enable lookup options
+	if err := validateRole(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Gather facts and enable lookup options. An online render gets a
+	// lookup cache of its own, so its lookups see one state of the node.
+	var lookups *lookupCache
+
 	if !opts.Offline {
 		cmdName := opts.CommandName
 		if cmdName == "" {
@@ -1597,10 +1723,19 @@ func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error)
 
 		err := helpers.FailIfMultiNodes(ctx, cmdName)
 		if err != nil {
-			return nil, errors.Wrap(err, "checking node selector")
+			return nil, nil, nil, errors.Wrap(err, "checking node selector")
 		}
 
-		helmEngine.LookupFunc = newLookupFunction(ctx, c, cmdName, opts.TalosEndpoints)
+		lookups = newLookupCache(newLookupFunction(ctx, c, cmdName, opts.TalosEndpoints))
+		helmEngine.LookupFunc = opts.Profile.timeLookup(lookups.Lookup)
+	} else if opts.Snapshot != nil {
+		// Put back the lookup of plain offline renders afterwards: the
+		// snapshot belongs to this render's node only.
+		defer func(previous func(string, string, string) (map[string]any, error)) {
+			helmEngine.LookupFunc = previous
+		}(helmEngine.LookupFunc)
+
+		helmEngine.LookupFunc = opts.Profile.timeLookup(opts.Snapshot.Lookup)
 	}
 
 	// Require at least one template before loading and rendering the chart.
@@ -1611,61 +1746,135 @@ func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error)
 	// runs after the online multi-node guard, which is a cheaper precondition
 	// with no network I/O.
 	if len(opts.TemplateFiles) == 0 {
-		return nil, errors.New("templates are not set for the command: please use `--file` or `--template` flag")
+		return nil, nil, nil, errors.New("templates are not set for the command: please use `--file` or `--template` flag")
+	}
+
+	if lookups != nil && len(opts.PrewarmLookups) > 0 {
+		start := time.Now()
+
+		lookups.prewarm(opts.PrewarmLookups)
+		opts.Profile.phase(phasePrewarmLookups, start)
 	}
 
 	chartPath, err := os.Getwd()
 	if err != nil {
-		return nil, errors.Wrap(err, "resolving working directory")
+		return nil, nil, nil, errors.Wrap(err, "resolving working directory")
 	}
 
 	if opts.Root != "" {
 		chartPath = opts.Root
 	}
 
+	start := time.Now()
+
 	chrt, err := loader.LoadDir(chartPath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "loading chart from %q", chartPath)
+		return nil, nil, nil, errors.Wrapf(err, "loading chart from %q", chartPath)
 	}
 
-	values, err := loadValues(opts)
+	opts.Profile.phase(phaseLoadChart, start)
+
+	if err := ValidateMergeRules(opts.MergeRules); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := opts.SecretStore.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	start = time.Now()
+
+	mergedValues, err := effectiveValues(chrt, chartPath, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
+	certExpiry, err := loadCertificateExpiry(opts.WithSecrets, time.Now())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	opts.Profile.phase(phaseValues, start)
+
 	rootValues := map[string]any{
-		helmKeyValues:   mergeMaps(chrt.Values, values),
-		helmKeyTalosVer: opts.TalosVersion,
+		helmKeyValues:     mergedValues,
+		helmKeyTalosVer:   opts.TalosVersion,
+		helmKeyCertExpiry: certExpiry,
 	}
 
-	eng := helmEngine.Engine{}
+	if opts.Role != "" {
+		rootValues[helmKeyMachineType] = opts.Role
+	}
+
+	ownership := opts.Ownership
+	if ownership == nil && opts.AnnotateSources {
+		ownership = NewOwnership()
+	}
+
+	eng := helmEngine.Engine{
+		AllowEnv:  opts.AllowEnv,
+		ChartDir:  chartPath,
+		Timer:     opts.Profile.timer(),
+		Tracer:    ownership.tracer(),
+		SecretRef: opts.SecretStore.resolver(ctx, opts.Root),
+	}
+
+	start = time.Now()
 
 	out, err := eng.Render(chrt, rootValues)
 	if err != nil {
-		return nil, errors.Wrap(err, "rendering chart")
+		return nil, nil, nil, errors.Wrap(err, "rendering chart")
 	}
 
+	opts.Profile.phase(phaseRenderTemplates, start)
+
 	configPatches := []string{}
+	requestedTemplates := []string{}
 
 	for _, templateFile := range opts.TemplateFiles {
 		// Use path.Join (not filepath.Join) because helm engine keys always use forward slashes
 		requestedTemplate := path.Join(chrt.Name(), NormalizeTemplatePath(templateFile))
+		requestedTemplates = append(requestedTemplates, requestedTemplate)
 
 		configPatch, ok := out[requestedTemplate]
 		if !ok {
 			//nolint:wrapcheck // cockroachdb/errors.Newf produces a stable typed error; wrapcheck's default ignore-sigs cover .New() but not .Newf().
-			return nil, errors.Newf("template %s not found", templateFile)
+			return nil, nil, nil, errors.Newf("template %s not found", templateFile)
 		}
 
 		configPatches = append(configPatches, configPatch)
 	}
 
-	finalConfig, err := applyPatchesAndRenderConfig(opts, configPatches)
+	return requestedTemplates, configPatches, ownership, nil
+}
+
+// loadCertificateExpiry returns the CertificateExpiry render context:
+// the CA expiry dates of the secrets bundle at secretsPath, keyed by
+// certexpiry.Entry.Key. A render without a bundle (no --with-secrets,
+// or the file not generated yet) gets an empty map, so charts can
+// guard with `with .CertificateExpiry.talosCA`. daysLeft is computed
+// against now; a template that prints it renders differently from day
+// to day.
+func loadCertificateExpiry(secretsPath string, now time.Time) (map[string]any, error) {
+	if secretsPath == "" {
+		return map[string]any{}, nil
+	}
+
+	if _, err := os.Stat(secretsPath); os.IsNotExist(err) {
+		return map[string]any{}, nil
+	}
+
+	bundle, err := secrets.LoadBundle(secretsPath)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "failed to load secrets bundle")
 	}
 
-	return finalConfig, nil
+	entries, err := certexpiry.FromBundle(bundle)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading certificate expiry from %s", secretsPath)
+	}
+
+	return certexpiry.TemplateValues(entries, now), nil
 }
 
 // loadValueFile reads a single --values / templateOptions.valueFiles entry
@@ -1690,6 +1899,11 @@ func loadValueFile(rootDir, filePath string) (map[string]any, error) {
 		return nil, errors.Wrapf(err, "failed to read values file %s", filePath)
 	}
 
+	buf, err = yamltools.ExpandAnchors(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to expand anchors in values file %s", filePath)
+	}
+
 	currentMap := make(map[string]any)
 	if err := yaml.Unmarshal(buf, &currentMap); err != nil {
 		return nil, errors.Wrapf(err, "failed to unmarshal values from file %s", filePath)
@@ -1708,12 +1922,16 @@ func loadValues(opts Options) (map[string]any, error) {
 
 	// Load values from files specified with -f or --values.
 	for _, filePath := range opts.ValueFiles {
-		currentMap, err := loadValueFile(opts.Root, filePath)
+		currentMap, err := cachedValueFile(opts.Root, filePath)
 		if err != nil {
 			return nil, err
 		}
 
-		base = mergeMaps(base, currentMap)
+		if err := checkMergeMarkers(currentMap, filePath); err != nil {
+			return nil, err
+		}
+
+		base = mergeValues(base, currentMap, opts.MergeRules)
 	}
 
 	// Parse and merge values from --set-json
@@ -1725,7 +1943,11 @@ func loadValues(opts Options) (map[string]any, error) {
 			return nil, errors.Wrapf(err, "failed to unmarshal JSON value '%s'", value)
 		}
 
-		base = mergeMaps(base, currentMap)
+		if err := checkMergeMarkers(currentMap, "--set-json"); err != nil {
+			return nil, err
+		}
+
+		base = mergeValues(base, currentMap, opts.MergeRules)
 	}
 
 	// Screen --set values for IP / CIDR / version literals BEFORE
@@ -1775,27 +1997,12 @@ func loadValues(opts Options) (map[string]any, error) {
 	return base, nil
 }
 
-// Imported from Helm
+// mergeMaps deep-merges b onto a without merge rules. Adapted from
+// Helm
 // https://github.com/helm/helm/blob/c6beb169d26751efd8131a5d65abe75c81a334fb/pkg/cli/values/options.go#L108
+// with list strategies layered on top; see valuesMerger.
 func mergeMaps(a, b map[string]any) map[string]any {
-	out := make(map[string]any, len(a))
-	maps.Copy(out, a)
-
-	for key, val := range b {
-		if vm, ok := val.(map[string]any); ok {
-			if bv, ok := out[key]; ok {
-				if bvm, ok := bv.(map[string]any); ok {
-					out[key] = mergeMaps(bvm, vm)
-
-					continue
-				}
-			}
-		}
-
-		out[key] = val
-	}
-
-	return out
+	return mergeValues(a, b, nil)
 }
 
 // isTalosConfigPatch checks if a YAML document is a Talos config patch.
@@ -1818,22 +2025,31 @@ func isTalosConfigPatch(doc string) (bool, error) {
 // Handles variations like "---", "--- ", "---\n" regardless of preceding content.
 var yamlDocSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
 
+// splitTemplateDocuments splits the output of a template file into
+// its non-empty YAML documents, trimmed.
+func splitTemplateDocuments(patch string) []string {
+	var docs []string
+
+	// Normalize CRLF to LF for consistent splitting
+	patch = strings.ReplaceAll(patch, "\r\n", "\n")
+	// Split by YAML document separator (--- at start of line)
+	for _, doc := range yamlDocSeparator.Split(patch, -1) {
+		doc = strings.TrimSpace(doc)
+		if doc != "" {
+			docs = append(docs, doc)
+		}
+	}
+
+	return docs
+}
+
 // extractExtraDocuments separates Talos config patches from other YAML documents.
 // Returns the Talos patches to be processed, extra documents to be appended to output, and any error.
 func extractExtraDocuments(patches []string) ([]string, []string, error) {
 	var talosPatches, extraDocs []string
 
 	for _, patch := range patches {
-		// Normalize CRLF to LF for consistent splitting
-		patch = strings.ReplaceAll(patch, "\r\n", "\n")
-		// Split by YAML document separator (--- at start of line)
-		docs := yamlDocSeparator.Split(patch, -1)
-		for _, doc := range docs {
-			doc = strings.TrimSpace(doc)
-			if doc == "" {
-				continue
-			}
-
+		for _, doc := range splitTemplateDocuments(patch) {
 			isTalos, parseErr := isTalosConfigPatch(doc)
 			if parseErr != nil {
 				return nil, nil, errors.Wrapf(parseErr, "invalid YAML in template output\n\nTemplate output:\n%s", doc)
@@ -1926,6 +2142,10 @@ func applyPatchesAndRenderConfig(opts Options, configPatches []string) ([]byte,
 		machineType = machine.TypeWorker
 	}
 
+	if err := checkRenderedRole(opts.Role, machineType); err != nil {
+		return nil, err
+	}
+
 	if opts.Debug {
 		debugPhase(opts, configPatches, clusterName, clusterEndpoint.String(), machineType)
 	}
@@ -0,0 +1,91 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"strings"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// ExportedPatch is one config patch of a node, as plain talosctl
+// takes it with --config-patch.
+type ExportedPatch struct {
+	// Source is the chart path of the template that rendered the
+	// patch, or the node file the patch is the body of.
+	Source string
+	// Data holds the YAML documents of the patch.
+	Data []byte
+}
+
+// ExportPatches renders opts like Render, but returns the patches the
+// config is merged from instead of the config: the output of every
+// template file that rendered a document, in the order of
+// opts.TemplateFiles, then the body of nodeFile, when it is set and
+// has anything to add. The body is prepared as MergeFileAsPatch
+// prepares it, so it does not repeat what the templates set. Applied
+// in order on top of the config talosctl gen config makes from the same
+// secrets, the patches make the config talm apply sends.
+//
+//nolint:gocritic // hugeParam: Options is passed by value like Render takes it.
+func ExportPatches(ctx context.Context, c *client.Client, opts Options, nodeFile string) ([]ExportedPatch, error) {
+	templates, outputs, _, err := renderTemplates(ctx, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The YAML of every document is checked here, with the error a
+	// render gives, rather than by talosctl in an emergency.
+	if _, _, err := extractExtraDocuments(outputs); err != nil {
+		return nil, err
+	}
+
+	var patches []ExportedPatch
+
+	for i, output := range outputs {
+		docs := splitTemplateDocuments(output)
+		if len(docs) == 0 {
+			continue
+		}
+
+		patches = append(patches, ExportedPatch{
+			Source: templates[i],
+			Data:   []byte(strings.Join(docs, "\n---\n") + "\n"),
+		})
+	}
+
+	if nodeFile == "" {
+		return patches, nil
+	}
+
+	opts.Full = true
+
+	rendered, err := applyPatchesAndRenderConfig(opts, outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, body, err := nodeBodyPatch(rendered, nodeFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		patches = append(patches, ExportedPatch{Source: nodeFile, Data: body})
+	}
+
+	return patches, nil
+}
@@ -0,0 +1,114 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const exportPatchesTemplate = `machine:
+  type: worker
+  network:
+    hostname: node1
+---
+apiVersion: v1alpha1
+kind: LinkConfig
+name: eth0
+mtu: 9000
+`
+
+// exportPatchesChart creates a chart with config.yaml and empty.yaml,
+// a template that renders nothing.
+func exportPatchesChart(t *testing.T) string {
+	t.Helper()
+
+	root := createTestChart(t, "tc", "config.yaml", exportPatchesTemplate)
+	if err := os.WriteFile(filepath.Join(root, "templates", "empty.yaml"), []byte("{{- /* nothing */ -}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func writeExportNodeFile(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "node1.yaml")
+	if err := os.WriteFile(path, []byte("# talm: nodes=[\"192.0.2.10\"], templates=[\"templates/config.yaml\"]\n"+body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestExportPatches pins the export: one patch per template that
+// rendered a document, in the template order, then the node body
+// without what the templates already set.
+func TestExportPatches(t *testing.T) {
+	root := exportPatchesChart(t)
+	nodeFile := writeExportNodeFile(t, "machine:\n  network:\n    hostname: node1\n  install:\n    disk: /dev/sda\n")
+
+	patches, err := ExportPatches(context.Background(), nil, Options{
+		Offline:       true,
+		Root:          root,
+		TemplateFiles: []string{"templates/empty.yaml", "templates/config.yaml"},
+	}, nodeFile)
+	if err != nil {
+		t.Fatalf("ExportPatches: %v", err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("got %d patches, want the config template and the node body: %+v", len(patches), patches)
+	}
+
+	if patches[0].Source != "tc/templates/config.yaml" || string(patches[0].Data) != strings.TrimSpace(exportPatchesTemplate)+"\n" {
+		t.Errorf("template patch = %s:\n%s", patches[0].Source, patches[0].Data)
+	}
+
+	body := string(patches[1].Data)
+	if patches[1].Source != nodeFile || !strings.Contains(body, "disk: /dev/sda") {
+		t.Errorf("body patch = %s:\n%s", patches[1].Source, body)
+	}
+
+	if strings.Contains(body, "hostname") || strings.Contains(body, "talm:") {
+		t.Errorf("the body patch repeats the template or the modeline:\n%s", body)
+	}
+}
+
+// TestExportPatches_NoBody pins that a node file whose body only
+// repeats the render, and no node file at all, add no patch.
+func TestExportPatches_NoBody(t *testing.T) {
+	root := exportPatchesChart(t)
+	opts := Options{
+		Offline:       true,
+		Root:          root,
+		TemplateFiles: []string{"templates/config.yaml"},
+	}
+
+	for _, nodeFile := range []string{"", writeExportNodeFile(t, "machine:\n  network:\n    hostname: node1\n")} {
+		patches, err := ExportPatches(context.Background(), nil, opts, nodeFile)
+		if err != nil {
+			t.Fatalf("ExportPatches(%q): %v", nodeFile, err)
+		}
+
+		if len(patches) != 1 {
+			t.Errorf("ExportPatches(%q) = %+v, want the template patch only", nodeFile, patches)
+		}
+	}
+}
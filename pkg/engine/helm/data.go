@@ -0,0 +1,122 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+
+	"helm.sh/helm/v4/pkg/chart/common"
+)
+
+// dataDir is the chart directory holding structured data files, such
+// as inventories exported from a CMDB, that templates read through
+// .Data.
+const dataDir = "data/"
+
+const helmFuncFromCSV = "fromCsv"
+
+// dataFiles is the .Data accessor: the chart files under data/, keyed
+// by their path inside it. Unlike .Files.Get, Get fails the render on a
+// missing file, so a renamed inventory cannot render as an empty one.
+type dataFiles struct {
+	files
+}
+
+// newDataFiles returns the .Data accessor for the chart files from.
+func newDataFiles(from []*common.File) dataFiles {
+	data := dataFiles{files: make(files)}
+
+	for _, f := range from {
+		if name, ok := strings.CutPrefix(f.Name, dataDir); ok {
+			data.files[name] = f.Data
+		}
+	}
+
+	return data
+}
+
+// Get returns the content of the data file name.
+//
+//	{{ range fromCsv (.Data.Get "inventory.csv") }}
+func (d dataFiles) Get(name string) (string, error) {
+	data, ok := d.files[name]
+	if !ok {
+		return "", errors.New(warnWrap(fmt.Sprintf("%s%s does not exist in the chart directory", dataDir, name)))
+	}
+
+	return string(data), nil
+}
+
+// Has reports whether the data file name exists, for optional files.
+func (d dataFiles) Has(name string) bool {
+	_, ok := d.files[name]
+
+	return ok
+}
+
+// fromCSV parses CSV text whose first record is the header into a list
+// of dicts keyed by the header, one per remaining record. Values stay
+// strings. A byte order mark, as spreadsheet exports write, and
+// surrounding spaces in header names are dropped. Unlike fromJson, a
+// malformed document fails the render: an inventory that silently
+// parses to nothing would render every node without its data.
+//
+// This is designed to be called from a template.
+func fromCSV(str string) ([]any, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(str, "\ufeff")))
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return []any{}, nil
+	}
+
+	if err != nil {
+		return nil, errors.New(warnWrap(helmFuncFromCSV + ": " + err.Error()))
+	}
+
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+
+		if header[i] == "" || slices.Contains(header[:i], header[i]) {
+			return nil, errors.New(warnWrap(fmt.Sprintf("%s: column %d of the header is empty or repeated: %q", helmFuncFromCSV, i+1, header[i])))
+		}
+	}
+
+	rows := []any{}
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		if err != nil {
+			return nil, errors.New(warnWrap(helmFuncFromCSV + ": " + err.Error()))
+		}
+
+		row := make(map[string]any, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+
+		rows = append(rows, row)
+	}
+}
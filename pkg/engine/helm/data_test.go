@@ -0,0 +1,118 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v4/pkg/chart/common"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+func TestFromCSV(t *testing.T) {
+	t.Parallel()
+
+	got, err := fromCSV("\ufeffserial, rack ,ip\r\nSN1,r1,192.0.2.11\r\n\"SN,2\",r2,192.0.2.12\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []any{
+		map[string]any{"serial": "SN1", "rack": "r1", "ip": "192.0.2.11"},
+		map[string]any{"serial": "SN,2", "rack": "r2", "ip": "192.0.2.12"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fromCsv = %v, want %v", got, want)
+	}
+
+	if got, err := fromCSV(""); err != nil || len(got) != 0 {
+		t.Errorf("empty input = %v, %v", got, err)
+	}
+
+	for input, want := range map[string]string{
+		"a,b\n1\n":   "wrong number of fields",
+		"a,a\n1,2\n": `repeated: "a"`,
+		"a,\n1,2\n":  "empty or repeated",
+	} {
+		if _, err := fromCSV(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("fromCsv(%q) error = %v, want %q", input, err, want)
+		}
+	}
+}
+
+// TestDataFiles renders a chart reading an inventory from data/: the
+// row of the node is picked by serial, and a missing file fails the
+// render instead of rendering empty.
+func TestDataFiles(t *testing.T) {
+	render := func(tpl string) (string, error) {
+		chrt := &chart.Chart{
+			Metadata:  &chart.Metadata{Name: "datatest"},
+			Templates: []*common.File{{Name: "templates/out.yaml", Data: []byte(tpl)}},
+			Files: []*common.File{
+				{Name: "data/inventory.csv", Data: []byte("serial,ip\nSN1,192.0.2.11\nSN2,192.0.2.12\n")},
+				{Name: "data/racks.json", Data: []byte(`{"r1": {"vlan": 100}}`)},
+				{Name: "README.md", Data: []byte("not data")},
+			},
+			Values: map[string]any{},
+		}
+
+		out, err := new(Engine).Render(chrt, common.Values{helmKeyValues: map[string]any{"serial": "SN2"}})
+		if err != nil {
+			return "", err
+		}
+
+		return out["datatest/templates/out.yaml"], nil
+	}
+
+	got, err := render(`{{- range fromCsv (.Data.Get "inventory.csv") }}{{ if eq .serial $.Values.serial }}ip: {{ .ip }}{{ end }}{{ end }}
+vlan: {{ (fromJson (.Data.Get "racks.json")).r1.vlan }}
+has: {{ .Data.Has "README.md" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "ip: 192.0.2.12\nvlan: 100\nhas: false"; got != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+
+	_, err = render(`{{ .Data.Get "inventroy.csv" }}`)
+	if err == nil || !strings.Contains(err.Error(), "data/inventroy.csv does not exist") {
+		t.Errorf("a missing data file must fail the render, got %v", err)
+	}
+}
+
+// TestRenderPassesCertificateExpiry pins that the CertificateExpiry
+// context the caller builds reaches the templates.
+func TestRenderPassesCertificateExpiry(t *testing.T) {
+	chrt := &chart.Chart{
+		Metadata:  &chart.Metadata{Name: "certtest"},
+		Templates: []*common.File{{Name: "templates/out.yaml", Data: []byte(`{{ .CertificateExpiry.talosCA.daysLeft }}`)}},
+		Values:    map[string]any{},
+	}
+
+	out, err := new(Engine).Render(chrt, common.Values{
+		helmKeyValues:            map[string]any{},
+		helmKeyCertificateExpiry: map[string]any{"talosCA": map[string]any{"daysLeft": 42}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out["certtest/templates/out.yaml"]; got != "42" {
+		t.Errorf("render = %q", got)
+	}
+}
@@ -17,20 +17,29 @@ limitations under the License.
 package engine
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/netip"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/cockroachdb/errors"
 
 	"helm.sh/helm/v4/pkg/chart/common"
 	chart "helm.sh/helm/v4/pkg/chart/v2"
+
+	"github.com/cozystack/talm/pkg/extensions"
 )
 
 // Disks is a package-level lookup table consulted by chart templates that
@@ -58,6 +67,54 @@ type Engine struct {
 	LintMode bool
 	// EnableDNS tells the engine to allow DNS lookups when rendering templates
 	EnableDNS bool
+	// AllowEnv lists the environment variable names the `env` template
+	// function may read. Any other name fails the render so a chart
+	// cannot silently pick up arbitrary process environment.
+	AllowEnv []string
+	// ChartDir is the directory the chart was loaded from. The
+	// `fileContent` template function resolves its paths against it;
+	// empty disables the function.
+	ChartDir string
+	// Timer, when set, is called with the time every template file and
+	// every `include`d named template took to execute. An include's time
+	// counts the templates it includes in turn.
+	Timer Timer
+	// Tracer, when set, is called with the output of every template file
+	// and every `include`d named template that executed without error.
+	// The includes a template file reaches are reported before the file.
+	Tracer Tracer
+	// SecretRef resolves the reference of the `secretRef` template
+	// function against the project's secret store; nil fails every
+	// reference.
+	SecretRef func(ref string) (string, error)
+}
+
+// Timer receives the execution time of one template. kind is
+// TimingTemplate for a template file and TimingInclude for a named
+// template reached through `include`.
+type Timer func(kind, name string, elapsed time.Duration)
+
+// Timer kinds.
+const (
+	TimingTemplate = "template"
+	TimingInclude  = "include"
+)
+
+// observe reports to the timer, if any, the time since start.
+func (t Timer) observe(kind, name string, start time.Time) {
+	if t != nil {
+		t(kind, name, time.Since(start))
+	}
+}
+
+// Tracer receives the output of one template; kind is as for Timer.
+type Tracer func(kind, name, output string)
+
+// trace reports output to the tracer, if any.
+func (t Tracer) trace(kind, name, output string) {
+	if t != nil {
+		t(kind, name, output)
+	}
 }
 
 // Render takes a chart, optional values, and value overrides, and attempts to render the Go templates.
@@ -108,30 +165,52 @@ const (
 
 	// Helm template function names registered both in initFunMap
 	// and re-injected per render in tplFun for the closure capture.
-	helmFuncInclude  = "include"
-	helmFuncTpl      = "tpl"
-	helmFuncRequired = "required"
-	helmFuncLookup   = "lookup"
-	helmFuncToToml   = "toToml"
-	helmFuncToYAML   = "toYaml"
-	helmFuncFromYAML = "fromYaml"
-	helmFuncToJSON   = "toJson"
+	helmFuncInclude     = "include"
+	helmFuncTpl         = "tpl"
+	helmFuncRequired    = "required"
+	helmFuncFail        = "fail"
+	helmFuncLookup      = "lookup"
+	helmFuncToToml      = "toToml"
+	helmFuncToYAML      = "toYaml"
+	helmFuncFromYAML    = "fromYaml"
+	helmFuncToJSON      = "toJson"
+	helmFuncEnv         = "env"
+	helmFuncFileContent = "fileContent"
+	helmFuncSecretRef   = "secretRef"
+
+	helmFuncTalosExtensions = "talosExtensions"
 
 	// helmKeyTalosVersion is the engine-injected template key
 	// for the Talos version of the cluster being rendered.
 	helmKeyTalosVersion = "TalosVersion"
+	// helmKeyCertificateExpiry is the engine-injected template key
+	// for the CA expiry dates of the secrets bundle.
+	helmKeyCertificateExpiry = "CertificateExpiry"
+	// helmKeyMachineType is the template key a role render sets to
+	// the machine type, controlplane or worker.
+	helmKeyMachineType = "MachineType"
+	// helmKeyData is the template key of the data/ file accessor.
+	helmKeyData = "Data"
 )
 
 var warnRegex = regexp.MustCompile(warnStartDelim + `((?s).*)` + warnEndDelim)
 
+// execFrameRegex matches one "template: <file>:<line>[:<col>]: executing"
+// frame of a text/template ExecError. A `required` or `fail` reached
+// through include or tpl produces one frame per nesting level, the
+// outermost first.
+var execFrameRegex = regexp.MustCompile(`template: (\S+?:\d+(?::\d+)?): executing `)
+
 func warnWrap(warn string) string {
 	return warnStartDelim + warn + warnEndDelim
 }
 
 // 'include' needs to be defined in the scope of a 'tpl' template as
 // well as regular file-loaded templates.
-func includeFun(tmpl *template.Template, includedNames map[string]int) func(string, any) (string, error) {
+func includeFun(tmpl *template.Template, includedNames map[string]int, timer Timer, tracer Tracer) func(string, any) (string, error) {
 	return func(name string, data any) (string, error) {
+		defer timer.observe(TimingInclude, name, time.Now())
+
 		var buf strings.Builder
 
 		if v, ok := includedNames[name]; ok {
@@ -147,13 +226,17 @@ func includeFun(tmpl *template.Template, includedNames map[string]int) func(stri
 		err := tmpl.ExecuteTemplate(&buf, name, data)
 		includedNames[name]--
 
+		if err == nil {
+			tracer.trace(TimingInclude, name, buf.String())
+		}
+
 		return buf.String(), err
 	}
 }
 
 // As does 'tpl', so that nested calls to 'tpl' see the templates
 // defined by their enclosing contexts.
-func tplFun(parent *template.Template, includedNames map[string]int, strict bool) func(string, any) (string, error) {
+func tplFun(parent *template.Template, includedNames map[string]int, strict bool, timer Timer, tracer Tracer) func(string, any) (string, error) {
 	return func(tpl string, vals any) (string, error) {
 		tmpl, err := parent.Clone()
 		if err != nil {
@@ -173,8 +256,8 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 		// Re-inject 'include' so that it can close over our clone of tmpl;
 		// this lets any 'define's inside tpl be 'include'd.
 		tmpl.Funcs(template.FuncMap{
-			helmFuncInclude: includeFun(tmpl, includedNames),
-			helmFuncTpl:     tplFun(tmpl, includedNames, strict),
+			helmFuncInclude: includeFun(tmpl, includedNames, timer, tracer),
+			helmFuncTpl:     tplFun(tmpl, includedNames, strict, timer, tracer),
 		})
 
 		// We need a .New template, as template text which is just blanks
@@ -202,12 +285,18 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 
 // initFunMap creates the Engine's FuncMap and adds context-specific functions.
 func (e Engine) initFunMap(tmpl *template.Template) {
+	tmpl.Funcs(e.templateFuncs(tmpl))
+}
+
+// templateFuncs returns every function a template of tmpl can call:
+// Sprig's, Helm's and talm's, with the late-bound ones bound to tmpl.
+func (e Engine) templateFuncs(tmpl *template.Template) template.FuncMap {
 	funcMap := funcMap()
 	includedNames := make(map[string]int)
 
 	// Add the template-rendering functions here so we can close over tmpl.
-	funcMap[helmFuncInclude] = includeFun(tmpl, includedNames)
-	funcMap[helmFuncTpl] = tplFun(tmpl, includedNames, e.Strict)
+	funcMap[helmFuncInclude] = includeFun(tmpl, includedNames, e.Timer, e.Tracer)
+	funcMap[helmFuncTpl] = tplFun(tmpl, includedNames, e.Strict, e.Timer, e.Tracer)
 
 	// Add the `required` function here so we can use lintMode
 	funcMap[helmFuncRequired] = func(warn string, val any) (any, error) {
@@ -235,7 +324,7 @@ func (e Engine) initFunMap(tmpl *template.Template) {
 	}
 
 	// Override sprig fail function for linting and wrapping message
-	funcMap["fail"] = func(msg string) (string, error) {
+	funcMap[helmFuncFail] = func(msg string) (string, error) {
 		if e.LintMode {
 			// Don't fail when linting
 			log.Printf("[INFO] Fail: %s", msg)
@@ -260,12 +349,172 @@ func (e Engine) initFunMap(tmpl *template.Template) {
 		}
 	}
 
+	funcMap[helmFuncEnv] = e.envFun()
+	funcMap[helmFuncFileContent] = e.fileContentFun()
+	funcMap[helmFuncSecretRef] = e.secretRefFun()
+
 	funcMap["cidrNetwork"] = cidrNetwork
 	funcMap["cidrContains"] = cidrContains
 	funcMap["cidrPrefixLen"] = cidrPrefixLen
 	funcMap["ipIsValid"] = ipIsValid
+	funcMap[helmFuncTalosExtensions] = talosExtensions
+
+	return funcMap
+}
+
+// envFun returns the allowlist-gated replacement for sprig's `env`.
+// Sprig's version is removed from funcMap because it exposes the whole
+// process environment to chart authors; this one only reads names the
+// project opted into via templateOptions.allowEnv in Chart.yaml. A name
+// outside the allowlist is a render error rather than an empty string so
+// a typo or a missing allowlist entry does not quietly produce a config
+// with a blank field. An allowlisted but unset variable renders as "",
+// matching sprig; pair it with `required` when the value is mandatory.
+func (e Engine) envFun() func(string) (string, error) {
+	allowed := make(map[string]struct{}, len(e.AllowEnv))
+	for _, name := range e.AllowEnv {
+		allowed[name] = struct{}{}
+	}
+
+	return func(name string) (string, error) {
+		if _, ok := allowed[name]; !ok {
+			return "", errors.New(warnWrap(fmt.Sprintf(
+				"env: variable %q is not listed in templateOptions.allowEnv in Chart.yaml", name)))
+		}
+
+		return os.Getenv(name), nil
+	}
+}
+
+// secretRefFun returns the `secretRef` template function, which reads a
+// secret from the store configured in Chart.yaml templateOptions.secretStore
+// by its dotted path there:
+//
+//	password: {{ secretRef "db.password" | quote }}
+//
+// A reference the store does not hold fails the render, as does any
+// reference without a store.
+func (e Engine) secretRefFun() func(string) (string, error) {
+	return func(ref string) (string, error) {
+		if e.SecretRef == nil {
+			return "", errors.New(warnWrap(fmt.Sprintf(
+				"secretRef: cannot resolve %q, no templateOptions.secretStore is configured in Chart.yaml", ref)))
+		}
+
+		value, err := e.SecretRef(ref)
+		if err != nil {
+			return "", errors.New(warnWrap(helmFuncSecretRef + ": " + err.Error()))
+		}
+
+		return value, nil
+	}
+}
+
+// fileContentFun returns the `fileContent` template function used to
+// embed local files into machine.files without --set-file. The path is
+// resolved relative to the chart directory and must stay inside it, so
+// a chart renders the same on every checkout. The result is a complete
+// YAML scalar followed by a comment carrying the file's sha256, meant
+// to sit directly after a key:
+//
+//	content: {{ fileContent "files/ca.pem" }}
+//
+// Text files are embedded verbatim; content that is not valid UTF-8 or
+// contains NUL bytes is base64-encoded and the comment says so. A
+// missing file fails the render instead of producing an empty entry.
+func (e Engine) fileContentFun() func(string) (string, error) {
+	return func(name string) (string, error) {
+		data, err := e.readChartFile(name)
+		if err != nil {
+			return "", errors.New(warnWrap(helmFuncFileContent + ": " + err.Error()))
+		}
+
+		content, encoding := string(data), ""
+		if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			content, encoding = base64.StdEncoding.EncodeToString(data), " (base64)"
+		}
+
+		// A JSON string is a valid YAML double-quoted scalar, which
+		// keeps multi-line content independent of the caller's
+		// indentation.
+		var quoted bytes.Buffer
+
+		enc := json.NewEncoder(&quoted)
+		enc.SetEscapeHTML(false)
 
-	tmpl.Funcs(funcMap)
+		if err := enc.Encode(content); err != nil {
+			return "", errors.Wrapf(err, "quoting %s", name)
+		}
+
+		return fmt.Sprintf("%s # sha256:%x %s%s", strings.TrimSuffix(quoted.String(), "\n"), sha256.Sum256(data), name, encoding), nil
+	}
+}
+
+// readChartFile reads name relative to ChartDir, rejecting absolute
+// paths and paths that climb out of the chart.
+func (e Engine) readChartFile(name string) ([]byte, error) {
+	if e.ChartDir == "" {
+		return nil, errors.Newf("cannot read %q: the chart directory is unknown", name)
+	}
+
+	if filepath.IsAbs(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return nil, errors.Newf("path %q must be relative to the chart directory and stay inside it", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(e.ChartDir, filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Newf("file %q does not exist in the chart directory", name)
+		}
+
+		return nil, errors.Wrapf(err, "reading %q", name)
+	}
+
+	return data, nil
+}
+
+// talosExtensions resolves the official Talos system extensions listed
+// in names for talosVersion into the Image Factory installer image and
+// the kernel modules and sysctls they need, as extensions.TemplateValues
+// shapes them:
+//
+//	{{- $ext := talosExtensions .TalosVersion .Values.extensions }}
+//	image: {{ $ext.image }}
+//
+// An unknown name, an extension the Talos release does not ship, or a
+// version that is not a full release fails the render, so the mismatch
+// shows up here rather than as an installer image that does not exist.
+func talosExtensions(talosVersion string, names any) (map[string]any, error) {
+	var list []string
+
+	switch names := names.(type) {
+	case nil:
+	case []string:
+		list = names
+	case []any:
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return nil, errors.New(warnWrap(fmt.Sprintf("%s: extension names must be strings, got %v", helmFuncTalosExtensions, name)))
+			}
+
+			list = append(list, s)
+		}
+	default:
+		return nil, errors.New(warnWrap(fmt.Sprintf("%s: expected a list of extension names, got %T", helmFuncTalosExtensions, names)))
+	}
+
+	res, err := extensions.Resolve(talosVersion, list)
+	if err != nil {
+		msg := err.Error()
+		if hint := errors.FlattenHints(err); hint != "" {
+			msg += "; " + hint
+		}
+
+		return nil, errors.New(warnWrap(helmFuncTalosExtensions + ": " + msg))
+	}
+
+	return extensions.TemplateValues(res), nil
 }
 
 // cidrNetwork returns the network portion of a CIDR (host bits zeroed). The
@@ -361,28 +610,13 @@ func (e Engine) render(tpls map[string]renderable) (_ map[string]string, err err
 		}
 	}()
 
-	tmpl := template.New("gotpl")
-	if e.Strict {
-		tmpl.Option("missingkey=error")
-	} else {
-		// Not that zero will attempt to add default values for types it knows,
-		// but will still emit <no value> for others. We mitigate that later.
-		tmpl.Option("missingkey=zero")
-	}
-
-	e.initFunMap(tmpl)
-
 	// We want to parse the templates in a predictable order. The order favors
 	// higher-level (in file system) templates over deeply nested templates.
 	keys := sortTemplates(tpls)
 
-	for _, filename := range keys {
-		r := tpls[filename]
-
-		_, err := tmpl.New(filename).Parse(r.tpl)
-		if err != nil {
-			return map[string]string{}, cleanupParseError(filename, err)
-		}
+	tmpl, err := e.parseTemplates(tpls, keys)
+	if err != nil {
+		return map[string]string{}, err
 	}
 
 	rendered := make(map[string]string, len(keys))
@@ -398,7 +632,11 @@ func (e Engine) render(tpls map[string]renderable) (_ map[string]string, err err
 
 		var buf strings.Builder
 
+		start := time.Now()
 		err := tmpl.ExecuteTemplate(&buf, filename, vals)
+
+		e.Timer.observe(TimingTemplate, filename, start)
+
 		if err != nil {
 			return map[string]string{}, cleanupExecError(filename, err)
 		}
@@ -407,6 +645,8 @@ func (e Engine) render(tpls map[string]renderable) (_ map[string]string, err err
 		// is set. Since missing=error will never get here, we do not need to handle
 		// the Strict case.
 		rendered[filename] = strings.ReplaceAll(buf.String(), "<no value>", "")
+
+		e.Tracer.trace(TimingTemplate, filename, rendered[filename])
 	}
 
 	return rendered, nil
@@ -460,12 +700,33 @@ func cleanupExecError(filename string, err error) error {
 
 	parts := warnRegex.FindStringSubmatch(tokens[2])
 	if len(parts) >= 2 {
-		return fmt.Errorf("%w at (%s): %s", errExecTemplate, location, parts[1])
+		return fmt.Errorf("%w at (%s): %s", errExecTemplate, nestedExecLocation(err.Error(), location), parts[1])
 	}
 
 	return err
 }
 
+// nestedExecLocation names where a `required` or `fail` actually fired.
+// text/template reports the outermost frame first, which for a check
+// inside a helper is the include call in the rendered template rather
+// than the helper line that failed. When the error crossed include or
+// tpl, the innermost frame leads and the rendered template follows, so
+// both the failing line and the file being rendered are visible.
+// Only the text before the wrapped message is scanned, so a message
+// that itself mentions "template: …" cannot be mistaken for a frame.
+func nestedExecLocation(msg, location string) string {
+	if i := strings.Index(msg, warnStartDelim); i >= 0 {
+		msg = msg[:i]
+	}
+
+	frames := execFrameRegex.FindAllStringSubmatch(msg, -1)
+	if len(frames) < 2 {
+		return location
+	}
+
+	return frames[len(frames)-1][1] + ", included from " + location
+}
+
 func sortTemplates(tpls map[string]renderable) []string {
 	keys := make([]string, len(tpls))
 
@@ -526,6 +787,16 @@ func recAllTpls(c *chart.Chart, templates map[string]renderable, vals common.Val
 		"Subcharts":         subCharts,
 		"Disks":             Disks,
 		helmKeyTalosVersion: vals[helmKeyTalosVersion],
+		helmKeyData:         newDataFiles(c.Files),
+		// Passed down as is, like TalosVersion: the render context
+		// the caller built for the root chart applies to subcharts.
+		helmKeyCertificateExpiry: vals[helmKeyCertificateExpiry],
+	}
+
+	// Only a role render sets MachineType; without a role the
+	// templates set it themselves, with set . "MachineType".
+	if machineType, ok := vals[helmKeyMachineType]; ok {
+		next[helmKeyMachineType] = machineType
 	}
 
 	// If there is a {{.Values.ThisChart}} in the parent metadata,
@@ -17,14 +17,20 @@ limitations under the License.
 package engine
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
 
 	"helm.sh/helm/v4/pkg/chart/common"
 	"helm.sh/helm/v4/pkg/chart/common/util"
@@ -335,6 +341,22 @@ more info after the break`,
 			expected: `execution error at (issue9981:1:2): something is wrong
 linebreak`,
 		},
+		{
+			name: "RequiredInsideInclude",
+			tpls: map[string]renderable{
+				"templates/_helpers.tpl":      {tpl: "{{- define \"endpoint\" }}\nendpoint: {{ required \"endpoint must be set\" .Values.endpoint }}\n{{- end }}", vals: vals},
+				"templates/controlplane.yaml": {tpl: "machine: {}\n{{ include \"endpoint\" . }}", vals: vals},
+			},
+			expected: `execution error at (templates/_helpers.tpl:2:13, included from templates/controlplane.yaml:2:3): endpoint must be set`,
+		},
+		{
+			name: "FailInsideNestedInclude",
+			tpls: map[string]renderable{
+				"templates/_helpers.tpl": {tpl: `{{- define "outer" }}{{ include "inner" . }}{{ end }}{{- define "inner" }}{{ fail "template: looks like a frame: executing " }}{{ end }}`, vals: vals},
+				"templates/worker.yaml":  {tpl: `{{ include "outer" . }}`, vals: vals},
+			},
+			expected: `execution error at (templates/_helpers.tpl:1:77, included from templates/worker.yaml:1:3): template: looks like a frame: executing `,
+		},
 	}
 
 	for _, tt := range cases {
@@ -379,6 +401,158 @@ func TestFailErrors(t *testing.T) {
 	}
 }
 
+// TestEnvAllowlist pins the contract of the `env` template function:
+// allowlisted names read the process environment, anything else fails
+// the render with a message naming the missing allowlist entry.
+func TestEnvAllowlist(t *testing.T) {
+	t.Setenv("TALM_TEST_ALLOWED", "prod")
+	t.Setenv("TALM_TEST_DENIED", "leak")
+
+	vals := common.Values{helmKeyValues: map[string]any{}}
+	eng := Engine{AllowEnv: []string{"TALM_TEST_ALLOWED", "TALM_TEST_UNSET"}}
+
+	out, err := eng.render(map[string]renderable{
+		"allowed": {tpl: `{{ env "TALM_TEST_ALLOWED" }}`, vals: vals},
+		"unset":   {tpl: `[{{ env "TALM_TEST_UNSET" }}]`, vals: vals},
+	})
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	if got := out["allowed"]; got != "prod" {
+		t.Errorf("allowed: expected %q, got %q", "prod", got)
+	}
+	if got := out["unset"]; got != "[]" {
+		t.Errorf("unset: expected %q, got %q", "[]", got)
+	}
+
+	_, err = eng.render(map[string]renderable{
+		"denied": {tpl: `{{ env "TALM_TEST_DENIED" }}`, vals: vals},
+	})
+	if err == nil {
+		t.Fatal("expected render error for a name outside allowEnv")
+	}
+	if !strings.Contains(err.Error(), `"TALM_TEST_DENIED"`) || !strings.Contains(err.Error(), "allowEnv") {
+		t.Errorf("error must name the variable and allowEnv, got %q", err.Error())
+	}
+	if strings.Contains(err.Error(), "leak") {
+		t.Errorf("error must not leak the variable value, got %q", err.Error())
+	}
+
+	_, err = new(Engine).render(map[string]renderable{
+		"empty": {tpl: `{{ env "TALM_TEST_ALLOWED" }}`, vals: vals},
+	})
+	if err == nil {
+		t.Fatal("expected render error with an empty allowlist")
+	}
+}
+
+// TestSecretRef pins the `secretRef` template function: a reference
+// resolves through the engine's resolver, and a failed one or one
+// without a store fails the render naming the reference.
+func TestSecretRef(t *testing.T) {
+	vals := common.Values{helmKeyValues: map[string]any{}}
+	eng := Engine{SecretRef: func(ref string) (string, error) {
+		if ref == "db.password" {
+			return "s3cret", nil
+		}
+
+		return "", errors.Newf("%q is not set", ref)
+	}}
+
+	out, err := eng.render(map[string]renderable{
+		"ref": {tpl: `password: {{ secretRef "db.password" | quote }}`, vals: vals},
+	})
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	if got := out["ref"]; got != `password: "s3cret"` {
+		t.Errorf("expected the resolved secret, got %q", got)
+	}
+
+	_, err = eng.render(map[string]renderable{
+		"missing": {tpl: `{{ secretRef "db.user" }}`, vals: vals},
+	})
+	if err == nil || !strings.Contains(err.Error(), `secretRef: "db.user" is not set`) {
+		t.Errorf("expected the resolver error, got %v", err)
+	}
+
+	_, err = new(Engine).render(map[string]renderable{
+		"nostore": {tpl: `{{ secretRef "db.password" }}`, vals: vals},
+	})
+	if err == nil || !strings.Contains(err.Error(), "templateOptions.secretStore") {
+		t.Errorf("expected a render error naming the missing store, got %v", err)
+	}
+}
+
+// TestFileContent pins the `fileContent` template function: text is
+// embedded as a YAML scalar that round-trips to the file bytes, binary
+// content is base64-encoded, every result carries a sha256 comment,
+// and missing or escaping paths fail the render.
+func TestFileContent(t *testing.T) {
+	chartDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(chartDir, "files"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM := "-----BEGIN CERTIFICATE-----\nMIIB<x>&\"q\"\n-----END CERTIFICATE-----\n"
+	binary := []byte{0x00, 0xff, 0x10}
+
+	if err := os.WriteFile(filepath.Join(chartDir, "files", "ca.pem"), []byte(caPEM), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(chartDir, "files", "license.bin"), binary, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	vals := common.Values{helmKeyValues: map[string]any{}}
+	eng := Engine{ChartDir: chartDir}
+
+	out, err := eng.render(map[string]renderable{
+		"text":   {tpl: `content: {{ fileContent "files/ca.pem" }}`, vals: vals},
+		"binary": {tpl: `content: {{ fileContent "files/license.bin" }}`, vals: vals},
+	})
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+
+	var text struct {
+		Content string `yaml:"content"`
+	}
+	if err := yaml.Unmarshal([]byte(out["text"]), &text); err != nil {
+		t.Fatalf("rendered text is not valid YAML: %v\n%s", err, out["text"])
+	}
+	if text.Content != caPEM {
+		t.Errorf("text content = %q, want %q", text.Content, caPEM)
+	}
+	if want := fmt.Sprintf("# sha256:%x files/ca.pem", sha256.Sum256([]byte(caPEM))); !strings.HasSuffix(out["text"], want) {
+		t.Errorf("text must end with checksum comment %q, got %q", want, out["text"])
+	}
+
+	var bin struct {
+		Content string `yaml:"content"`
+	}
+	if err := yaml.Unmarshal([]byte(out["binary"]), &bin); err != nil {
+		t.Fatalf("rendered binary is not valid YAML: %v\n%s", err, out["binary"])
+	}
+	if bin.Content != base64.StdEncoding.EncodeToString(binary) {
+		t.Errorf("binary content = %q, want base64 of the file", bin.Content)
+	}
+	if !strings.HasSuffix(out["binary"], "files/license.bin (base64)") {
+		t.Errorf("binary comment must mark base64, got %q", out["binary"])
+	}
+
+	for name, tpl := range map[string]string{
+		"missing": `{{ fileContent "files/absent.pem" }}`,
+		"escape":  `{{ fileContent "../secrets.yaml" }}`,
+		"abs":     `{{ fileContent "/etc/passwd" }}`,
+	} {
+		_, err := eng.render(map[string]renderable{name: {tpl: tpl, vals: vals}})
+		if err == nil || !strings.Contains(err.Error(), "fileContent") {
+			t.Errorf("%s: expected a fileContent render error, got %v", name, err)
+		}
+	}
+}
+
 func TestAllTemplates(t *testing.T) {
 	ch1 := &chart.Chart{
 		Metadata: &chart.Metadata{Name: "ch1"},
@@ -864,6 +1038,88 @@ func TestRenderRecursionLimit(t *testing.T) {
 	}
 }
 
+// TestRenderTimer pins the --profile hook: every rendered file and
+// every include reach the timer, partials only through their include.
+func TestRenderTimer(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "timed"},
+		Templates: []*common.File{
+			{Name: "templates/node", Data: []byte(`{{include "timed.helper" . }}{{include "timed.helper" . }}`)},
+			{Name: "templates/_helpers", Data: []byte(`{{define "timed.helper"}}{{tpl "{{ include \"timed.inner\" . }}" .}}{{end}}{{define "timed.inner"}}x{{end}}`)},
+		},
+	}
+	v := common.Values{
+		helmKeyValues: "",
+		helmKeyChart:  c.Metadata,
+		helmKeyRelease: common.Values{
+			helmKeyName: helmFixtureTestRelease,
+		},
+	}
+
+	calls := map[string]int{}
+	e := Engine{Timer: func(kind, name string, elapsed time.Duration) {
+		if elapsed < 0 {
+			t.Errorf("%s %s: negative elapsed %s", kind, name, elapsed)
+		}
+
+		calls[kind+" "+name]++
+	}}
+
+	out, err := e.Render(c, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out["timed/templates/node"]; got != "xx" {
+		t.Errorf("render = %q", got)
+	}
+
+	want := map[string]int{
+		TimingTemplate + " timed/templates/node": 1,
+		TimingInclude + " timed.helper":          2,
+		TimingInclude + " timed.inner":           2,
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("timer calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRenderTracer(t *testing.T) {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "traced"},
+		Templates: []*common.File{
+			{Name: "templates/node", Data: []byte(`a:{{include "traced.helper" . }}`)},
+			{Name: "templates/_helpers", Data: []byte(`{{define "traced.helper"}}b{{tpl "{{ include \"traced.inner\" . }}" .}}{{end}}{{define "traced.inner"}}c{{end}}`)},
+		},
+	}
+	v := common.Values{
+		helmKeyValues: "",
+		helmKeyChart:  c.Metadata,
+		helmKeyRelease: common.Values{
+			helmKeyName: helmFixtureTestRelease,
+		},
+	}
+
+	var calls []string
+
+	e := Engine{Tracer: func(kind, name, output string) {
+		calls = append(calls, kind+" "+name+" "+output)
+	}}
+
+	if _, err := e.Render(c, v); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		TimingInclude + " traced.inner c",
+		TimingInclude + " traced.helper bc",
+		TimingTemplate + " traced/templates/node a:bc",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("tracer calls = %q, want %q", calls, want)
+	}
+}
+
 func TestRenderLoadTemplateForTplFromFile(t *testing.T) {
 	c := &chart.Chart{
 		Metadata: &chart.Metadata{Name: "TplLoadFromFile"},
@@ -1145,6 +1401,43 @@ func TestTalosVersionInTemplateContext(t *testing.T) {
 	}
 }
 
+// TestMachineTypeInTemplateContext pins that the MachineType a role
+// render sets reaches the templates, and that a template may still set
+// its own when the render has none.
+func TestMachineTypeInTemplateContext(t *testing.T) {
+	t.Parallel()
+
+	newChart := func(data string) *chart.Chart {
+		return &chart.Chart{
+			Metadata: &chart.Metadata{
+				Name:    "testchart",
+				Version: "0.1.0",
+			},
+			Templates: []*common.File{
+				{Name: "templates/node.yaml", Data: []byte(data)},
+			},
+		}
+	}
+
+	out, err := Render(newChart("type: {{ .MachineType }}"), common.Values{helmKeyValues: common.Values{}, helmKeyMachineType: "controlplane"})
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	if got := strings.TrimSpace(out["testchart/templates/node.yaml"]); got != "type: controlplane" {
+		t.Errorf("role render: got %q", got)
+	}
+
+	out, err = Render(newChart(`{{- $_ := set . "MachineType" "worker" -}}type: {{ .MachineType }}`), common.Values{helmKeyValues: common.Values{}})
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	if got := strings.TrimSpace(out["testchart/templates/node.yaml"]); got != "type: worker" {
+		t.Errorf("template set: got %q", got)
+	}
+}
+
 func TestTalosVersionEmpty(t *testing.T) {
 	t.Parallel()
 
@@ -1174,6 +1467,38 @@ func TestTalosVersionEmpty(t *testing.T) {
 	}
 }
 
+func TestCertificateExpiryInTemplateContext(t *testing.T) {
+	t.Parallel()
+
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{
+			Name:    "testchart",
+			Version: "0.1.0",
+		},
+		Templates: []*common.File{
+			{Name: "templates/test.yaml", Data: []byte("talosCA: {{ .CertificateExpiry.talosCA.daysLeft }}{{ with .CertificateExpiry.etcdCA }} etcd{{ end }}")},
+		},
+	}
+
+	vals := common.Values{
+		helmKeyValues: common.Values{},
+		"CertificateExpiry": map[string]any{
+			"talosCA": map[string]any{"daysLeft": 3650},
+		},
+	}
+
+	out, err := Render(c, vals)
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	result := out["testchart/templates/test.yaml"]
+	expected := "talosCA: 3650"
+	if strings.TrimSpace(result) != expected {
+		t.Errorf("expected %q, got %q", expected, strings.TrimSpace(result))
+	}
+}
+
 func TestTalosVersionConcurrentRender(t *testing.T) {
 	t.Parallel()
 
@@ -1270,6 +1595,48 @@ func TestCidrNetworkTemplateFunc(t *testing.T) {
 	}
 }
 
+// TestTalosExtensionsTemplateFunc pins the template side of extension
+// resolution: the values list goes in, the installer image and kernel
+// modules come out, and a mismatch fails the render with its reason.
+func TestTalosExtensionsTemplateFunc(t *testing.T) {
+	render := func(talosVersion string, names []any) (string, error) {
+		chrt := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "exttest"},
+			Templates: []*common.File{{Name: "templates/out.yaml", Data: []byte(
+				`{{- $ext := talosExtensions .TalosVersion .Values.extensions }}image: {{ $ext.image }}
+modules: {{ range $ext.kernelModules }}{{ .name }} {{ end }}`)}},
+			Values: map[string]any{},
+		}
+		var eng Engine
+		out, err := eng.Render(chrt, common.Values{
+			helmKeyValues:       map[string]any{"extensions": names},
+			helmKeyTalosVersion: talosVersion,
+		})
+		if err != nil {
+			return "", err
+		}
+		return out["exttest/templates/out.yaml"], nil
+	}
+
+	got, err := render("v1.12.1", []any{"siderolabs/iscsi-tools", "siderolabs/zfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "image: factory.talos.dev/installer/") || !strings.HasSuffix(got, ":v1.12.1\nmodules: zfs ") {
+		t.Errorf("unexpected render: %q", got)
+	}
+
+	_, err = render("v1.12", []any{"siderolabs/zfs"})
+	if err == nil || !strings.Contains(err.Error(), "templateOptions.talosVersion") {
+		t.Errorf("a version without a patch release must fail with the hint, got %v", err)
+	}
+
+	_, err = render("v1.12.1", []any{"siderolabs/zfz"})
+	if err == nil || !strings.Contains(err.Error(), `unknown Talos extension "siderolabs/zfz"`) {
+		t.Errorf("an unknown extension must fail the render, got %v", err)
+	}
+}
+
 // TestCidrContainsTemplateFunc exercises the cidrContains template
 // function directly. Used by talm.discovered.link_name_for_address
 // to pick the link whose subnet hosts a floatingIP. Both IPv4 and
@@ -150,7 +150,8 @@ func (f files) AsSecrets() string {
 }
 
 // Lines returns each line of a named file (split by "\n") as a slice, so it can
-// be ranged over in your templates.
+// be ranged over in your templates. CRLF line endings, as in a file saved on
+// Windows, are split the same way, so no line keeps a trailing "\r".
 //
 // This is designed to be called from a template.
 //
@@ -161,7 +162,7 @@ func (f files) Lines(name string) []string {
 		return []string{}
 	}
 
-	content := string(f[name])
+	content := strings.ReplaceAll(string(f[name]), "\r\n", "\n")
 	if content == "" {
 		return []string{}
 	}
@@ -140,3 +140,13 @@ func TestLines_EmptyContent(t *testing.T) {
 	as.Equal([]string{""}, f.Lines("only_newline.txt"), "single trailing newline strips to one empty line")
 	as.Equal([]string{"only"}, f.Lines("single_line.txt"), "no-trailing-newline content is preserved as a single line")
 }
+
+// TestLines_CRLF pins that a file with Windows line endings yields the
+// same lines as its LF twin, without a trailing "\r" on each.
+func TestLines_CRLF(t *testing.T) {
+	as := assert.New(t)
+
+	f := files{"inventory.csv": []byte("rack,ip\r\nr1,192.0.2.10\r\n")}
+
+	as.Equal([]string{"rack,ip", "r1,192.0.2.10"}, f.Lines("inventory.csv"))
+}
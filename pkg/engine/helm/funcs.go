@@ -20,8 +20,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"maps"
+	"math"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Masterminds/sprig/v3"
@@ -33,6 +35,9 @@ import (
 // rebinds them per render.
 const notImplementedSentinel = "not implemented"
 
+// hoursPerDay converts the duration daysUntil measures to days.
+const hoursPerDay = 24
+
 // funcMap returns a mapping of all of the functions that Engine has.
 //
 // Because some functions are late-bound (e.g. contain context-sensitive
@@ -60,6 +65,8 @@ func funcMap() template.FuncMap {
 		helmFuncToJSON:   toJSON,
 		"fromJson":       fromJSON,
 		"fromJsonArray":  fromJSONArray,
+		helmFuncFromCSV:  fromCSV,
+		"daysUntil":      daysUntil,
 
 		// This is a placeholder for the "include" function, which is
 		// late-bound to a template. By declaring it here, we preserve the
@@ -79,6 +86,15 @@ func funcMap() template.FuncMap {
 	return funcs
 }
 
+// daysUntil returns the whole days from now until t, rounded down and
+// negative once t has passed. Pairs with the CertificateExpiry render
+// context: `{{ daysUntil .CertificateExpiry.talosCA.notAfter }}`.
+//
+// This is designed to be called from a template.
+func daysUntil(t time.Time) int {
+	return int(math.Floor(time.Until(t).Hours() / hoursPerDay))
+}
+
 // toYAML takes an interface, marshals it to yaml, and returns a string. It will
 // always return a string, even on marshal error (empty string).
 //
@@ -20,6 +20,7 @@ import (
 	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -104,6 +105,15 @@ func TestFuncs(t *testing.T) {
 		vars:   `["one", "two"]`,
 	}}
 
+	tests = append(tests, struct {
+		tpl, expect string
+		vars        any
+	}{
+		tpl:    `{{ daysUntil . }}`,
+		expect: "2",
+		vars:   time.Now().Add(49*time.Hour + time.Minute),
+	})
+
 	for _, tt := range tests {
 		var b strings.Builder
 		err := template.Must(template.New("test").Funcs(funcMap()).Parse(tt.tpl)).Execute(&b, tt.vars)
@@ -0,0 +1,162 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"cmp"
+	"slices"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// FunctionDoc documents a template function the engine adds to Sprig's,
+// or one whose Sprig behavior it changes.
+type FunctionDoc struct {
+	Name string
+	// Usage is the call with its arguments, as a template writes it.
+	Usage string
+	// Description says what the function returns and when it fails
+	// the render.
+	Description string
+}
+
+// functionDocs documents the functions templateFuncs registers beyond
+// plain Sprig. TestFunctions_DocumentsEveryFunction keeps it complete.
+//
+//nolint:gochecknoglobals // immutable documentation table, read by Functions.
+var functionDocs = map[string]FunctionDoc{
+	helmFuncInclude: {
+		Usage:       "include NAME CONTEXT",
+		Description: "Renders the named template with CONTEXT and returns it as a string, so the result can be piped to other functions.",
+	},
+	helmFuncTpl: {
+		Usage:       "tpl TEXT CONTEXT",
+		Description: "Renders TEXT as a template with CONTEXT.",
+	},
+	helmFuncRequired: {
+		Usage:       "required MESSAGE VALUE",
+		Description: "Returns VALUE, and fails the render with MESSAGE when VALUE is nil or an empty string.",
+	},
+	helmFuncFail: {
+		Usage:       "fail MESSAGE",
+		Description: "Fails the render with MESSAGE.",
+	},
+	helmFuncLookup: {
+		Usage: "lookup KIND NAMESPACE ID",
+		Description: "Reads Talos resources from the node being rendered, as talosctl get KIND --namespace NAMESPACE ID does. " +
+			"An empty NAMESPACE is the default namespace of the kind. With an ID, returns that resource; with an empty ID, " +
+			"a List whose items are every resource of the kind. Returns an empty map when there is none, and in an offline " +
+			"render, unless --snapshot answers it. talm docs lookups lists the kinds a node serves.",
+	},
+	helmFuncEnv: {
+		Usage:       "env NAME",
+		Description: "Returns the environment variable NAME, which must be listed in Chart.yaml templateOptions.allowEnv; any other name fails the render. An unset variable is an empty string.",
+	},
+	helmFuncFileContent: {
+		Usage:       "fileContent PATH",
+		Description: "Returns the chart file PATH as a complete YAML scalar followed by a comment with its sha256, to follow a key directly. Content that is not text is base64-encoded. A missing file, or one outside the chart directory, fails the render.",
+	},
+	helmFuncSecretRef: {
+		Usage:       "secretRef PATH",
+		Description: "Returns the secret at the dotted PATH in the store of Chart.yaml templateOptions.secretStore. A secret the store does not hold fails the render.",
+	},
+	helmFuncTalosExtensions: {
+		Usage:       "talosExtensions TALOS_VERSION NAMES",
+		Description: "Resolves the official system extensions NAMES for TALOS_VERSION into a map with the Image Factory installer image and the kernel modules and sysctls they need. An unknown extension, or one the release does not ship, fails the render.",
+	},
+	"cidrNetwork": {
+		Usage:       "cidrNetwork CIDR",
+		Description: "Returns the network of CIDR with the host bits zeroed, e.g. 192.0.2.0/24 for 192.0.2.10/24. An invalid CIDR fails the render.",
+	},
+	"cidrContains": {
+		Usage:       "cidrContains CIDR IP",
+		Description: "Reports whether CIDR contains IP, for IPv4 and IPv6. Either one not parsing is false.",
+	},
+	"cidrPrefixLen": {
+		Usage:       "cidrPrefixLen CIDR",
+		Description: "Returns the prefix length of CIDR, or -1 when it does not parse.",
+	},
+	"ipIsValid": {
+		Usage:       "ipIsValid TEXT",
+		Description: "Reports whether TEXT is an IPv4 or IPv6 address.",
+	},
+	"daysUntil": {
+		Usage:       "daysUntil TIME",
+		Description: "Returns the whole days from now until TIME, negative once it has passed, e.g. daysUntil .CertificateExpiry.talosCA.notAfter.",
+	},
+	helmFuncFromCSV: {
+		Usage:       "fromCsv TEXT",
+		Description: "Parses CSV whose first record is the header into a list of dicts keyed by the header. A malformed document fails the render.",
+	},
+	helmFuncToYAML: {
+		Usage:       "toYaml VALUE",
+		Description: "Encodes VALUE as YAML, without the trailing newline.",
+	},
+	helmFuncFromYAML: {
+		Usage:       "fromYaml TEXT",
+		Description: "Decodes a YAML mapping; a parse error is returned under the Error key.",
+	},
+	"fromYamlArray": {
+		Usage:       "fromYamlArray TEXT",
+		Description: "Decodes a YAML list; a parse error is returned as its only item.",
+	},
+	helmFuncToJSON: {
+		Usage:       "toJson VALUE",
+		Description: "Encodes VALUE as JSON.",
+	},
+	"fromJson": {
+		Usage:       "fromJson TEXT",
+		Description: "Decodes a JSON object; a parse error is returned under the Error key.",
+	},
+	"fromJsonArray": {
+		Usage:       "fromJsonArray TEXT",
+		Description: "Decodes a JSON array; a parse error is returned as its only item.",
+	},
+	helmFuncToToml: {
+		Usage:       "toToml VALUE",
+		Description: "Encodes VALUE as TOML.",
+	},
+	"getHostByName": {
+		Usage:       "getHostByName NAME",
+		Description: "Returns an empty string: renders do not resolve names, so a config does not depend on the DNS of the machine that rendered it.",
+	},
+}
+
+// Functions documents the template functions of the engine that plain
+// Sprig does not have or that behave differently from Sprig's, sorted
+// by name. The names come from the engine's function map, so the list
+// is the one a chart actually renders with.
+func Functions() []FunctionDoc {
+	sprigFuncs := sprig.TxtFuncMap()
+
+	funcs := Engine{}.templateFuncs(template.New(""))
+
+	docs := make([]FunctionDoc, 0, len(functionDocs))
+
+	for name := range funcs {
+		doc, documented := functionDocs[name]
+		if _, inSprig := sprigFuncs[name]; inSprig && !documented {
+			continue
+		}
+
+		doc.Name = name
+		docs = append(docs, doc)
+	}
+
+	slices.SortFunc(docs, func(a, b FunctionDoc) int { return cmp.Compare(a.Name, b.Name) })
+
+	return docs
+}
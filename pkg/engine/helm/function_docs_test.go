@@ -0,0 +1,79 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// TestFunctions_DocumentsEveryFunction pins that a function added to
+// the engine cannot ship without documentation: every function
+// Functions lists, which is every one beyond Sprig's, has a usage
+// naming it and a description.
+func TestFunctions_DocumentsEveryFunction(t *testing.T) {
+	t.Parallel()
+
+	for _, doc := range Functions() {
+		if doc.Description == "" || !strings.HasPrefix(doc.Usage, doc.Name) {
+			t.Errorf("%s is not documented in functionDocs: %+v", doc.Name, doc)
+		}
+	}
+}
+
+// TestFunctions_NoStaleDocs pins that every documented function is
+// one a template can call.
+func TestFunctions_NoStaleDocs(t *testing.T) {
+	t.Parallel()
+
+	funcs := Engine{}.templateFuncs(template.New(""))
+
+	for name := range functionDocs {
+		if _, ok := funcs[name]; !ok {
+			t.Errorf("functionDocs documents %s, which the engine does not register", name)
+		}
+	}
+}
+
+// TestFunctions_Listed pins the selection: talm's functions and
+// Sprig's overridden ones are listed, sorted, plain Sprig is not.
+func TestFunctions_Listed(t *testing.T) {
+	t.Parallel()
+
+	docs := Functions()
+
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.Name
+	}
+
+	if !slices.IsSorted(names) {
+		t.Errorf("names are not sorted: %v", names)
+	}
+
+	for _, want := range []string{helmFuncLookup, helmFuncEnv, helmFuncFail, helmFuncTalosExtensions, "cidrContains"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("%s is not listed: %v", want, names)
+		}
+	}
+
+	for _, sprigOnly := range []string{"upper", "b64enc", "semverCompare"} {
+		if slices.Contains(names, sprigOnly) {
+			t.Errorf("plain Sprig function %s is listed", sprigOnly)
+		}
+	}
+}
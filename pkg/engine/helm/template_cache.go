@@ -0,0 +1,127 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"github.com/cockroachdb/errors"
+)
+
+// maxCachedTemplateSets bounds the parsed template sets kept in memory.
+// One invocation renders one chart, so a handful covers it; the bound
+// only matters to long-lived callers rendering many charts.
+const maxCachedTemplateSets = 8
+
+// parsedTemplates caches the parsed template sets of the charts
+// rendered in this process, keyed on the template sources. `talm
+// template -f nodes/` renders the same chart once per node file, and
+// parsing every template again for each file is the bulk of the
+// render's fixed cost.
+//
+//nolint:gochecknoglobals // process-wide cache shared by every Engine value, like LookupFunc.
+var parsedTemplates = struct {
+	mu   sync.Mutex
+	sets map[string]*template.Template
+}{sets: map[string]*template.Template{}}
+
+// parseTemplates returns the template set of tpls, parsed in the order
+// of keys, with the functions of e bound to it. A set parsed before
+// for the same sources is cloned rather than parsed again; the cached
+// set itself is never executed, so the clones share nothing mutable.
+func (e Engine) parseTemplates(tpls map[string]renderable, keys []string) (*template.Template, error) {
+	key := e.templateSetKey(tpls, keys)
+
+	parsedTemplates.mu.Lock()
+	cached := parsedTemplates.sets[key]
+	parsedTemplates.mu.Unlock()
+
+	if cached == nil {
+		parsed, err := e.parseTemplateSet(tpls, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedTemplates.mu.Lock()
+		if len(parsedTemplates.sets) >= maxCachedTemplateSets {
+			clear(parsedTemplates.sets)
+		}
+
+		parsedTemplates.sets[key] = parsed
+		parsedTemplates.mu.Unlock()
+
+		cached = parsed
+	}
+
+	tmpl, err := cached.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "cloning the parsed templates")
+	}
+
+	// The functions close over the template they are bound to and over
+	// this render's engine: the timer, the tracer, the lookup in effect.
+	e.initFunMap(tmpl)
+
+	return tmpl, nil
+}
+
+// parseTemplateSet parses tpls into a fresh template set.
+func (e Engine) parseTemplateSet(tpls map[string]renderable, keys []string) (*template.Template, error) {
+	tmpl := template.New("gotpl")
+	if e.Strict {
+		tmpl.Option("missingkey=error")
+	} else {
+		// Not that zero will attempt to add default values for types it knows,
+		// but will still emit <no value> for others. We mitigate that later.
+		tmpl.Option("missingkey=zero")
+	}
+
+	e.initFunMap(tmpl)
+
+	for _, filename := range keys {
+		_, err := tmpl.New(filename).Parse(tpls[filename].tpl)
+		if err != nil {
+			return nil, cleanupParseError(filename, err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// templateSetKey identifies a template set by everything its parse
+// depends on: the file names and sources, and the engine settings that
+// change the options or the function names known at parse time.
+func (e Engine) templateSetKey(tpls map[string]renderable, keys []string) string {
+	hash := sha256.New()
+
+	for _, flag := range []bool{e.Strict, e.LintMode, e.EnableDNS} {
+		hash.Write([]byte(strconv.FormatBool(flag)))
+		hash.Write([]byte{0})
+	}
+
+	for _, filename := range keys {
+		hash.Write([]byte(filename))
+		hash.Write([]byte{0})
+		hash.Write([]byte(strconv.Itoa(len(tpls[filename].tpl))))
+		hash.Write([]byte{0})
+		hash.Write([]byte(tpls[filename].tpl))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,129 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"helm.sh/helm/v4/pkg/chart/common"
+)
+
+// cachedTemplateSet returns the cached parse of tpls, or nil.
+func cachedTemplateSet(e Engine, tpls map[string]renderable) any {
+	parsedTemplates.mu.Lock()
+	defer parsedTemplates.mu.Unlock()
+
+	set, ok := parsedTemplates.sets[e.templateSetKey(tpls, sortTemplates(tpls))]
+	if !ok {
+		return nil
+	}
+
+	return set
+}
+
+// TestParseTemplates_ReusesParse pins that a second render of the same
+// sources reuses the parsed set, yet executes with its own values and
+// its own engine's functions.
+func TestParseTemplates_ReusesParse(t *testing.T) {
+	tpls := func(name string) map[string]renderable {
+		vals := common.Values{helmKeyValues: map[string]any{"name": name}}
+
+		return map[string]renderable{
+			"cached/templates/node":     {tpl: `node: {{ include "cached.name" . }}`, vals: vals},
+			"cached/templates/_helpers": {tpl: `{{ define "cached.name" }}{{ .Values.name }}{{ end }}`, vals: vals},
+		}
+	}
+
+	var first, second []string
+
+	e := Engine{Tracer: func(kind, name, _ string) { first = append(first, kind+" "+name) }}
+
+	out, err := e.render(tpls("cp1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out["cached/templates/node"] != "node: cp1" {
+		t.Errorf("first render = %q", out)
+	}
+
+	parsed := cachedTemplateSet(e, tpls("cp1"))
+	if parsed == nil {
+		t.Fatal("the parsed set was not cached")
+	}
+
+	e = Engine{Tracer: func(kind, name, _ string) { second = append(second, kind+" "+name) }}
+
+	out, err = e.render(tpls("cp2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out["cached/templates/node"] != "node: cp2" {
+		t.Errorf("second render = %q", out)
+	}
+
+	if cachedTemplateSet(e, tpls("cp2")) != parsed {
+		t.Error("the second render parsed the templates again")
+	}
+
+	if fmt.Sprint(first) != fmt.Sprint(second) || len(second) != 2 {
+		t.Errorf("first tracer = %q, second tracer = %q", first, second)
+	}
+}
+
+// TestParseTemplates_KeyedOnSources pins that an edited template, or a
+// setting that changes the parse, gets a parse of its own.
+func TestParseTemplates_KeyedOnSources(t *testing.T) {
+	t.Parallel()
+
+	tpls := map[string]renderable{"keyed/templates/node": {tpl: `a: 1`}}
+	edited := map[string]renderable{"keyed/templates/node": {tpl: `a: 2`}}
+	renamed := map[string]renderable{"keyed/templates/other": {tpl: `a: 1`}}
+
+	key := Engine{}.templateSetKey(tpls, sortTemplates(tpls))
+
+	for name, other := range map[string]string{
+		"edited":  Engine{}.templateSetKey(edited, sortTemplates(edited)),
+		"renamed": Engine{}.templateSetKey(renamed, sortTemplates(renamed)),
+		"strict":  Engine{Strict: true}.templateSetKey(tpls, sortTemplates(tpls)),
+		"lint":    Engine{LintMode: true}.templateSetKey(tpls, sortTemplates(tpls)),
+	} {
+		if other == key {
+			t.Errorf("%s: same key as the original sources", name)
+		}
+	}
+
+	if again := (Engine{AllowEnv: []string{"HOME"}}).templateSetKey(tpls, sortTemplates(tpls)); again != key {
+		t.Error("a setting read at execution only must not change the key")
+	}
+}
+
+// TestParseTemplates_ErrorNotCached pins that a parse error is reported
+// on every render rather than remembered as a set.
+func TestParseTemplates_ErrorNotCached(t *testing.T) {
+	tpls := map[string]renderable{"broken/templates/node": {tpl: `{{ nosuchfunc }}`}}
+
+	for range 2 {
+		if _, err := new(Engine).render(tpls); err == nil {
+			t.Fatal("expected a parse error")
+		}
+	}
+
+	if cachedTemplateSet(Engine{}, tpls) != nil {
+		t.Error("a set that failed to parse was cached")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"sync"
+)
+
+// lookupCache answers the chart lookups of one render. The first
+// lookup of a kind lists every resource of that kind on the node;
+// later lookups of the kind, by id or not, are filtered from that list,
+// so a template that looks up each of many links makes one request.
+// The resources are those of the moment of the first lookup, as in a
+// render that took no time.
+type lookupCache struct {
+	live func(kind, namespace, id string) (map[string]any, error)
+
+	mu    sync.Mutex
+	kinds map[string]*lookupCacheEntry
+}
+
+// lookupCacheEntry is the listing of one kind. A failed listing is
+// kept too: the live lookup already retried it, and the render reports
+// it on every lookup of the kind.
+type lookupCacheEntry struct {
+	once      sync.Once
+	resources []map[string]any
+	err       error
+}
+
+func newLookupCache(live func(kind, namespace, id string) (map[string]any, error)) *lookupCache {
+	return &lookupCache{live: live, kinds: map[string]*lookupCacheEntry{}}
+}
+
+// Lookup implements the chart `lookup` function over the cache.
+func (c *lookupCache) Lookup(kind, namespace, id string) (map[string]any, error) {
+	resources, err := c.list(kind, namespace)
+	if err != nil {
+		return map[string]any{}, err
+	}
+
+	return answerLookup(resources, id), nil
+}
+
+// list returns the resources of kind, listing them on the first call.
+func (c *lookupCache) list(kind, namespace string) ([]map[string]any, error) {
+	key := snapshotKey(kind, namespace)
+
+	c.mu.Lock()
+
+	entry, ok := c.kinds[key]
+	if !ok {
+		entry = &lookupCacheEntry{}
+		c.kinds[key] = entry
+	}
+
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		var result map[string]any
+
+		result, entry.err = c.live(kind, namespace, "")
+		entry.resources = lookupItems(result)
+	})
+
+	return entry.resources, entry.err
+}
+
+// prewarm lists kinds concurrently, so the lookups a chart is known to
+// make cost one round trip instead of one per kind. Failures are left
+// for the lookups that need the kind to report.
+func (c *lookupCache) prewarm(kinds []string) {
+	var wg sync.WaitGroup
+
+	for _, kind := range kinds {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, _ = c.list(kind, "")
+		}()
+	}
+
+	wg.Wait()
+}
+
+// lookupItems returns the resources of a List a lookup without an id
+// returned, and none for the empty map of a kind without resources.
+func lookupItems(result map[string]any) []map[string]any {
+	items, _ := result[k8sKeyItems].([]any)
+
+	resources := make([]map[string]any, 0, len(items))
+
+	for _, item := range items {
+		if res, ok := item.(map[string]any); ok {
+			resources = append(resources, res)
+		}
+	}
+
+	return resources
+}
+
+// answerLookup answers a lookup from the resources of its kind: the
+// resource of id, or a List of every resource when id is empty, and an
+// empty map when there is none. Resources are deep-copied, so a
+// template that modifies what a lookup returned does not change later
+// lookups.
+func answerLookup(resources []map[string]any, id string) map[string]any {
+	if id != "" {
+		for _, res := range resources {
+			if meta, _ := res["metadata"].(map[string]any); meta != nil && meta[cosiMetaKeyID] == id {
+				copied, _ := copyValue(res).(map[string]any)
+
+				return copied
+			}
+		}
+
+		return map[string]any{}
+	}
+
+	if len(resources) == 0 {
+		return map[string]any{}
+	}
+
+	items := make([]any, len(resources))
+	for i, res := range resources {
+		items[i] = copyValue(res)
+	}
+
+	return map[string]any{
+		k8sKeyAPIVersion: k8sAPIVersionV1,
+		k8sKeyKind:       cosiKindList,
+		k8sKeyItems:      items,
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// fakeLiveLookup stands in for newLookupFunction: it answers a listing
+// of every kind in resources and records each request.
+type fakeLiveLookup struct {
+	mu        sync.Mutex
+	resources map[string][]map[string]any
+	failing   map[string]bool
+	requests  []string
+}
+
+func (f *fakeLiveLookup) lookup(kind, namespace, id string) (map[string]any, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, snapshotKey(kind, namespace)+"/"+id)
+	f.mu.Unlock()
+
+	if f.failing[kind] {
+		return map[string]any{}, errors.Newf("lookup %s: connection refused", kind)
+	}
+
+	return answerLookup(f.resources[snapshotKey(kind, namespace)], id), nil
+}
+
+func newFakeLiveLookup() *fakeLiveLookup {
+	return &fakeLiveLookup{
+		resources: map[string][]map[string]any{
+			"links": {
+				{"metadata": map[string]any{"id": "eth0"}, "spec": map[string]any{"hardwareAddr": "aa:bb:cc:00:00:01"}},
+				{"metadata": map[string]any{"id": "eth1"}, "spec": map[string]any{"hardwareAddr": "aa:bb:cc:00:00:02"}},
+			},
+			"network/nodeaddresses": {
+				{"metadata": map[string]any{"id": "default"}, "spec": map[string]any{"addresses": []any{"192.0.2.10/24"}}},
+			},
+		},
+		failing: map[string]bool{},
+	}
+}
+
+// TestLookupCache_ListsEachKindOnce pins the batching: lookups by id
+// and the listing of a kind cost one request for the kind.
+func TestLookupCache_ListsEachKindOnce(t *testing.T) {
+	t.Parallel()
+
+	live := newFakeLiveLookup()
+	cache := newLookupCache(live.lookup)
+
+	for _, id := range []string{"eth0", "eth1", "eth0", "eth9", ""} {
+		if _, err := cache.Lookup("links", "", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := cache.Lookup("nodeaddresses", "network", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec, _ := res["spec"].(map[string]any); spec == nil {
+		t.Errorf("nodeaddresses = %v", res)
+	}
+
+	if want := []string{"links/", "network/nodeaddresses/"}; !slices.Equal(live.requests, want) {
+		t.Errorf("requests = %v, want %v", live.requests, want)
+	}
+}
+
+// TestLookupCache_AnswersAsLiveLookup pins that a cached answer is the
+// one the live lookup would have given for the same arguments.
+func TestLookupCache_AnswersAsLiveLookup(t *testing.T) {
+	t.Parallel()
+
+	live := newFakeLiveLookup()
+	cache := newLookupCache(live.lookup)
+
+	for _, tc := range []struct{ kind, namespace, id string }{
+		{"links", "", "eth1"},
+		{"links", "", "eth9"},
+		{"links", "", ""},
+		{"disks", "", ""},
+		{"disks", "", "sda"},
+		{"nodeaddresses", "network", "default"},
+	} {
+		got, err := cache.Lookup(tc.kind, tc.namespace, tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, _ := live.lookup(tc.kind, tc.namespace, tc.id)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got %v, want %v", tc, got, want)
+		}
+	}
+}
+
+// TestLookupCache_CopiesResources pins that a template modifying what
+// a lookup returned does not change the next lookup.
+func TestLookupCache_CopiesResources(t *testing.T) {
+	t.Parallel()
+
+	cache := newLookupCache(newFakeLiveLookup().lookup)
+
+	res, err := cache.Lookup("links", "", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res["spec"].(map[string]any)["hardwareAddr"] = "changed"
+
+	again, err := cache.Lookup("links", "", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := again["spec"].(map[string]any)["hardwareAddr"]; got != "aa:bb:cc:00:00:01" {
+		t.Errorf("hardwareAddr = %v after a template changed an earlier answer", got)
+	}
+}
+
+// TestLookupCache_KeepsFailure pins that a failed listing is reported
+// on every lookup of the kind without asking the node again.
+func TestLookupCache_KeepsFailure(t *testing.T) {
+	t.Parallel()
+
+	live := newFakeLiveLookup()
+	live.failing["disks"] = true
+
+	cache := newLookupCache(live.lookup)
+
+	for range 2 {
+		res, err := cache.Lookup("disks", "", "sda")
+		if err == nil || len(res) != 0 {
+			t.Errorf("res = %v, err = %v, want an empty map and the error", res, err)
+		}
+	}
+
+	if len(live.requests) != 1 {
+		t.Errorf("requests = %v, want one", live.requests)
+	}
+}
+
+// TestLookupCache_Prewarm pins that prewarmed kinds are answered
+// without more requests, and that a failed prewarm only surfaces when
+// the kind is looked up.
+func TestLookupCache_Prewarm(t *testing.T) {
+	t.Parallel()
+
+	live := newFakeLiveLookup()
+	live.failing["disks"] = true
+
+	cache := newLookupCache(live.lookup)
+	cache.prewarm([]string{"links", "disks", "addresses"})
+
+	if len(live.requests) != 3 {
+		t.Fatalf("requests = %v, want one per kind", live.requests)
+	}
+
+	if _, err := cache.Lookup("links", "", "eth1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Lookup("addresses", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Lookup("disks", "", ""); err == nil {
+		t.Error("expected the failed prewarm of disks on lookup")
+	}
+
+	if len(live.requests) != 3 {
+		t.Errorf("requests = %v after the lookups, want no more", live.requests)
+	}
+}
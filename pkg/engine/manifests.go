@@ -0,0 +1,220 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys of the v1alpha1 document that hold the inline manifests.
+const (
+	inlineManifestsClusterKey  = "cluster"
+	inlineManifestsKey         = "inlineManifests"
+	inlineManifestNameKey      = "name"
+	inlineManifestContentsKey  = "contents"
+	inlineManifestsMachineKey  = "machine"
+	inlineManifestsDocumentKey = "kind"
+)
+
+// InlineManifest is one cluster.inlineManifests entry of a machine
+// config: the Kubernetes manifests Talos applies at bootstrap, as one
+// multi-document YAML string, under a name.
+type InlineManifest struct {
+	Name     string
+	Contents string
+}
+
+// SplitInlineManifests removes cluster.inlineManifests from the
+// v1alpha1 document of config, and returns the config without them
+// and the manifests it removed. A config without inline manifests is
+// returned as is.
+func SplitInlineManifests(config []byte) ([]byte, []InlineManifest, error) {
+	docs, err := decodeAllYAMLDocuments(config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decoding config before splitting inline manifests")
+	}
+
+	cluster := clusterMapping(docs, false)
+	if cluster == nil {
+		return config, nil, nil
+	}
+
+	var manifests []InlineManifest
+
+	for i := 0; i+1 < len(cluster.Content); i += 2 {
+		if cluster.Content[i].Value != inlineManifestsKey {
+			continue
+		}
+
+		manifests, err = decodeInlineManifests(cluster.Content[i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cluster.Content = append(cluster.Content[:i], cluster.Content[i+2:]...)
+
+		break
+	}
+
+	if len(manifests) == 0 {
+		return config, nil, nil
+	}
+
+	out, err := encodeAllYAMLDocuments(docs)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "re-encoding config after splitting inline manifests")
+	}
+
+	return out, manifests, nil
+}
+
+// InlineManifests sets manifests as cluster.inlineManifests of the
+// v1alpha1 document of config: a manifest replaces the entry of the
+// same name, and the others are appended in order.
+func InlineManifests(config []byte, manifests []InlineManifest) ([]byte, error) {
+	if len(manifests) == 0 {
+		return config, nil
+	}
+
+	docs, err := decodeAllYAMLDocuments(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding config before inlining manifests")
+	}
+
+	cluster := clusterMapping(docs, true)
+	if cluster == nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.New("the config has no v1alpha1 document to inline the manifests into"),
+			"inline manifests go into the machine config document, the one with machine: and cluster:",
+		)
+	}
+
+	list := mappingValue(cluster, inlineManifestsKey)
+	if list == nil {
+		list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		cluster.Content = append(cluster.Content, scalarNode(inlineManifestsKey), list)
+	}
+
+	if list.Kind != yaml.SequenceNode {
+		return nil, errors.Newf("cluster.%s is not a list", inlineManifestsKey)
+	}
+
+	for _, manifest := range manifests {
+		entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			scalarNode(inlineManifestNameKey), scalarNode(manifest.Name),
+			scalarNode(inlineManifestContentsKey), {Kind: yaml.ScalarNode, Tag: "!!str", Value: manifest.Contents, Style: yaml.LiteralStyle},
+		}}
+
+		replaced := false
+
+		for i, existing := range list.Content {
+			if name := mappingValue(existing, inlineManifestNameKey); name != nil && name.Value == manifest.Name {
+				list.Content[i] = entry
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			list.Content = append(list.Content, entry)
+		}
+	}
+
+	out, err := encodeAllYAMLDocuments(docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "re-encoding config after inlining manifests")
+	}
+
+	return out, nil
+}
+
+// SplitManifestDocuments splits the contents of an inline manifest
+// into its non-empty YAML documents, each ending with a newline.
+func SplitManifestDocuments(contents string) []string {
+	documents := splitTemplateDocuments(contents)
+	for i := range documents {
+		documents[i] += "\n"
+	}
+
+	return documents
+}
+
+// clusterMapping returns the cluster mapping of the v1alpha1 document
+// of docs, the one without a kind. With create it adds an empty one to
+// a v1alpha1 document that has none; nil means there is no such
+// document, or no cluster mapping and create is false.
+func clusterMapping(docs []*yaml.Node, create bool) *yaml.Node {
+	for _, doc := range docs {
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+			continue
+		}
+
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode || mappingValue(root, inlineManifestsDocumentKey) != nil {
+			continue
+		}
+
+		cluster := mappingValue(root, inlineManifestsClusterKey)
+		if cluster != nil && cluster.Kind == yaml.MappingNode {
+			return cluster
+		}
+
+		if cluster != nil || !create || mappingValue(root, inlineManifestsMachineKey) == nil {
+			return nil
+		}
+
+		cluster = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, scalarNode(inlineManifestsClusterKey), cluster)
+
+		return cluster
+	}
+
+	return nil
+}
+
+// decodeInlineManifests reads the cluster.inlineManifests list.
+func decodeInlineManifests(list *yaml.Node) ([]InlineManifest, error) {
+	var manifests []InlineManifest
+
+	if err := list.Decode(&manifests); err != nil {
+		return nil, errors.Wrapf(err, "decoding cluster.%s", inlineManifestsKey)
+	}
+
+	return manifests, nil
+}
+
+// mappingValue returns the value of key in the mapping node, nil when
+// node is not a mapping or lacks key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// scalarNode is a plain string scalar.
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -0,0 +1,163 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const manifestsTestConfig = `version: v1alpha1
+machine:
+  type: controlplane
+cluster:
+  clusterName: test
+  inlineManifests:
+    - name: cilium
+      contents: |
+        apiVersion: v1
+        kind: Namespace
+        metadata:
+          name: cilium
+        ---
+        apiVersion: v1
+        kind: ServiceAccount
+        metadata:
+          name: cilium
+          namespace: cilium
+---
+apiVersion: v1alpha1
+kind: HostnameConfig
+hostname: node1
+`
+
+// TestSplitInlineManifests pins that the manifests leave the config,
+// and only them: the rest of the v1alpha1 document and the other
+// documents stay.
+func TestSplitInlineManifests(t *testing.T) {
+	t.Parallel()
+
+	config, manifests, err := SplitInlineManifests([]byte(manifestsTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifests) != 1 || manifests[0].Name != "cilium" {
+		t.Fatalf("manifests = %+v, want cilium", manifests)
+	}
+
+	if strings.Contains(string(config), "inlineManifests") || strings.Contains(string(config), "ServiceAccount") {
+		t.Errorf("config keeps the manifests:\n%s", config)
+	}
+
+	for _, want := range []string{"clusterName: test", "kind: HostnameConfig"} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("config lacks %q:\n%s", want, config)
+		}
+	}
+
+	documents := SplitManifestDocuments(manifests[0].Contents)
+	if len(documents) != 2 || !strings.HasPrefix(documents[1], "apiVersion: v1\nkind: ServiceAccount") {
+		t.Errorf("documents = %q", documents)
+	}
+}
+
+// TestSplitInlineManifests_None pins that a config without inline
+// manifests comes back byte for byte.
+func TestSplitInlineManifests_None(t *testing.T) {
+	t.Parallel()
+
+	input := "# keep me\nversion: v1alpha1\nmachine:\n    type: worker\n"
+
+	config, manifests, err := SplitInlineManifests([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(config) != input || manifests != nil {
+		t.Errorf("config = %q, manifests = %+v", config, manifests)
+	}
+}
+
+// TestInlineManifests_RoundTrip pins that inlining what was split gives
+// the manifests back.
+func TestInlineManifests_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	config, manifests, err := SplitInlineManifests([]byte(manifestsTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inlined, err := InlineManifests(config, manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, again, err := SplitInlineManifests(inlined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(again, manifests) {
+		t.Errorf("round trip = %+v, want %+v", again, manifests)
+	}
+}
+
+// TestInlineManifests_Merge pins that a manifest replaces the entry of
+// its name, a new one is appended, and a cluster mapping is created
+// for a config without one.
+func TestInlineManifests_Merge(t *testing.T) {
+	t.Parallel()
+
+	inlined, err := InlineManifests([]byte(manifestsTestConfig), []InlineManifest{
+		{Name: "cilium", Contents: "kind: ConfigMap\n"},
+		{Name: "extra", Contents: "kind: Secret\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, manifests, err := SplitInlineManifests(inlined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []InlineManifest{{Name: "cilium", Contents: "kind: ConfigMap\n"}, {Name: "extra", Contents: "kind: Secret\n"}}
+	if !reflect.DeepEqual(manifests, want) {
+		t.Errorf("manifests = %+v, want %+v", manifests, want)
+	}
+
+	inlined, err = InlineManifests([]byte("version: v1alpha1\nmachine:\n  type: worker\n"), want[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(inlined), "cluster:\n  inlineManifests:\n    - name: cilium\n      contents: |\n        kind: ConfigMap\n") {
+		t.Errorf("inlined =\n%s", inlined)
+	}
+}
+
+// TestInlineManifests_NoMachineConfig pins the error for a config with
+// no v1alpha1 document to inline into.
+func TestInlineManifests_NoMachineConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := InlineManifests([]byte("apiVersion: v1alpha1\nkind: HostnameConfig\nhostname: node1\n"), []InlineManifest{{Name: "a", Contents: "kind: A\n"}})
+	if err == nil || !strings.Contains(err.Error(), "no v1alpha1 document") {
+		t.Errorf("err = %v", err)
+	}
+}
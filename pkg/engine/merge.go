@@ -0,0 +1,377 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// List merge strategies, selectable per values path through
+// Chart.yaml templateOptions.mergeRules or inline `$patch` markers.
+const (
+	// MergeReplace replaces the earlier list wholesale. It is the
+	// default and matches Helm.
+	MergeReplace = "replace"
+	// MergeAppend appends the later list to the earlier one.
+	MergeAppend = "append"
+	// MergeByKey merges list items that carry the same value under
+	// the rule's key (e.g. `interface` for network interfaces) and
+	// appends the rest.
+	MergeByKey = "merge"
+)
+
+// Inline marker keys. A list whose first element is a map holding only
+// `$patch` (and `$key` for MergeByKey) takes its strategy from that
+// element; a map holding `$patch: replace` replaces the earlier map
+// instead of merging into it; a list item holding `$patch: delete`
+// removes the earlier item with the same key under MergeByKey.
+const (
+	mergeMarkerPatch = "$patch"
+	mergeMarkerKey   = "$key"
+	mergeDelete      = "delete"
+)
+
+// MergeRule sets the strategy for the lists at Path, a dotted values
+// path where `*` matches any single map key. Items of a list share the
+// list's path, so a list nested in interface items is addressed as
+// `interfaces.addresses`.
+type MergeRule struct {
+	Path     string `yaml:"path"`
+	Strategy string `yaml:"strategy"`
+	// Key is the item field MergeByKey matches on.
+	Key string `yaml:"key"`
+}
+
+// ValidateMergeRules rejects rules with an unknown strategy, an empty
+// path or a MergeByKey rule without a key.
+func ValidateMergeRules(rules []MergeRule) error {
+	for i, rule := range rules {
+		if rule.Path == "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.Newf("templateOptions.mergeRules[%d]: path is empty", i),
+				"set path to the dotted values path of the list, e.g. nodes.*.interfaces",
+			)
+		}
+
+		if err := validateListStrategy(rule.Strategy, rule.Key); err != nil {
+			return errors.Wrapf(err, "templateOptions.mergeRules[%d] (%s)", i, rule.Path)
+		}
+	}
+
+	return nil
+}
+
+func validateListStrategy(strategy, key string) error {
+	switch strategy {
+	case MergeReplace, MergeAppend:
+		return nil
+	case MergeByKey:
+		if key == "" {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHint(
+				errors.New("strategy merge needs a key"),
+				"name the item field to match on, e.g. key: interface",
+			)
+		}
+
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Newf("unknown merge strategy %q", strategy),
+		"use one of: replace, append, merge",
+	)
+}
+
+// checkMergeMarkers validates the `$patch` markers in one values
+// source, so a typo fails the render instead of silently replacing.
+func checkMergeMarkers(value any, source string) error {
+	return errors.Wrapf(walkMergeMarkers(value, nil), "merge markers in %s", source)
+}
+
+func walkMergeMarkers(value any, path []string) error {
+	switch v := value.(type) {
+	case map[string]any:
+		if patch, ok := v[mergeMarkerPatch]; ok && patch != MergeReplace && patch != mergeDelete {
+			return errors.Newf("%s: map marker `$patch: %v` must be replace or delete", displayValuesPath(path), patch)
+		}
+
+		for key, child := range v {
+			if err := walkMergeMarkers(child, append(slices.Clone(path), key)); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if marker, ok := listMarkerElement(v); ok {
+			strategy, _ := marker[mergeMarkerPatch].(string)
+			key, _ := marker[mergeMarkerKey].(string)
+
+			if err := validateListStrategy(strategy, key); err != nil {
+				return errors.Wrap(err, displayValuesPath(path))
+			}
+
+			v = v[1:]
+		}
+
+		for _, item := range v {
+			if err := walkMergeMarkers(item, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func displayValuesPath(path []string) string {
+	if len(path) == 0 {
+		return "(root)"
+	}
+
+	return strings.Join(path, ".")
+}
+
+// listMarkerElement returns the first element of list when it is a
+// strategy marker: a map whose only keys are `$patch` and `$key`.
+func listMarkerElement(list []any) (map[string]any, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+
+	m, ok := list[0].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	if _, ok := m[mergeMarkerPatch]; !ok {
+		return nil, false
+	}
+
+	for key := range m {
+		if key != mergeMarkerPatch && key != mergeMarkerKey {
+			return nil, false
+		}
+	}
+
+	return m, true
+}
+
+// valuesMerger deep-merges values layers. Maps merge recursively and
+// later scalars win, as in Helm; lists follow the inline marker of the
+// later layer, then the first matching rule, then MergeReplace.
+//
+// Markers are kept in the merged result rather than applied once and
+// dropped: the value files are merged with each other before they are
+// merged onto the chart's values.yaml, and an `append` in a value file
+// must still append to the chart default at that second step. A
+// merged list keeps the earlier layer's marker, so the result relates
+// to the layers below it the same way the earlier layer did.
+// stripMergeMarkers removes them once all layers are merged.
+type valuesMerger struct {
+	rules []MergeRule
+}
+
+// mergeValues merges b onto a under rules. Neither input is modified.
+func mergeValues(a, b map[string]any, rules []MergeRule) map[string]any {
+	return valuesMerger{rules: rules}.mergeMap(a, b, nil)
+}
+
+func (m valuesMerger) mergeMap(a, b map[string]any, path []string) map[string]any {
+	if b[mergeMarkerPatch] == MergeReplace {
+		return maps.Clone(b)
+	}
+
+	out := make(map[string]any, len(a))
+	maps.Copy(out, a)
+
+	for key, val := range b {
+		childPath := append(slices.Clone(path), key)
+
+		switch bv := val.(type) {
+		case map[string]any:
+			if av, ok := out[key].(map[string]any); ok {
+				out[key] = m.mergeMap(av, bv, childPath)
+
+				continue
+			}
+		case []any:
+			if av, ok := out[key].([]any); ok {
+				out[key] = m.mergeList(av, bv, childPath)
+
+				continue
+			}
+		}
+
+		out[key] = val
+	}
+
+	return out
+}
+
+func (m valuesMerger) mergeList(a, b []any, path []string) []any {
+	strategy, key := m.ruleFor(path)
+
+	bItems := b
+	if marker, ok := listMarkerElement(b); ok {
+		strategy, _ = marker[mergeMarkerPatch].(string)
+		key, _ = marker[mergeMarkerKey].(string)
+		bItems = b[1:]
+	}
+
+	var merged []any
+
+	aItems := a
+	if marker, ok := listMarkerElement(a); ok {
+		merged = append(merged, marker)
+		aItems = a[1:]
+	}
+
+	switch strategy {
+	case MergeAppend:
+		merged = append(merged, aItems...)
+		merged = append(merged, bItems...)
+	case MergeByKey:
+		merged = append(merged, m.mergeByKey(aItems, bItems, key, path)...)
+	default:
+		return b
+	}
+
+	return merged
+}
+
+// mergeByKey merges each item of b into the item of a with the same
+// key value, deletes it for a `$patch: delete` item, and appends items
+// that match nothing. An unmatched delete is kept so it can still
+// apply to a layer further below; stripMergeMarkers drops it at the
+// end.
+func (m valuesMerger) mergeByKey(a, b []any, key string, path []string) []any {
+	out := slices.Clone(a)
+
+	for _, item := range b {
+		id, ok := mergeItemKey(item, key)
+		if !ok {
+			out = append(out, item)
+
+			continue
+		}
+
+		idx := slices.IndexFunc(out, func(existing any) bool {
+			existingID, ok := mergeItemKey(existing, key)
+
+			return ok && existingID == id
+		})
+
+		bm, _ := item.(map[string]any)
+
+		switch {
+		case idx < 0:
+			out = append(out, item)
+		case bm[mergeMarkerPatch] == mergeDelete:
+			out = slices.Delete(out, idx, idx+1)
+		case out[idx].(map[string]any)[mergeMarkerPatch] == mergeDelete: //nolint:forcetypeassert // mergeItemKey matched only maps.
+			out[idx] = item
+		default:
+			out[idx] = m.mergeMap(out[idx].(map[string]any), bm, path) //nolint:forcetypeassert // mergeItemKey matched only maps.
+		}
+	}
+
+	return out
+}
+
+// mergeItemKey returns the key value of a map item as a string, so
+// items compare regardless of the YAML scalar type. Items that are not
+// maps or lack a scalar key value have no key.
+func mergeItemKey(item any, key string) (string, bool) {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return "", false
+	}
+
+	switch v := m[key].(type) {
+	case nil, map[string]any, []any:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// ruleFor returns the strategy and key of the first rule matching
+// path, or MergeReplace.
+func (m valuesMerger) ruleFor(path []string) (string, string) {
+	for _, rule := range m.rules {
+		if mergePathMatches(strings.Split(rule.Path, "."), path) {
+			return rule.Strategy, rule.Key
+		}
+	}
+
+	return MergeReplace, ""
+}
+
+func mergePathMatches(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+
+	for i, segment := range pattern {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stripMergeMarkers returns value without merge markers: `$patch`
+// keys are removed from maps, and list marker elements and unmatched
+// `$patch: delete` items are dropped from lists.
+func stripMergeMarkers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+
+		for key, child := range v {
+			if key != mergeMarkerPatch {
+				out[key] = stripMergeMarkers(child)
+			}
+		}
+
+		return out
+	case []any:
+		if _, ok := listMarkerElement(v); ok {
+			v = v[1:]
+		}
+
+		out := make([]any, 0, len(v))
+
+		for _, item := range v {
+			if m, ok := item.(map[string]any); ok && m[mergeMarkerPatch] == mergeDelete {
+				continue
+			}
+
+			out = append(out, stripMergeMarkers(item))
+		}
+
+		return out
+	}
+
+	return value
+}
@@ -0,0 +1,297 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func parseValues(t *testing.T, doc string) map[string]any {
+	t.Helper()
+
+	out := map[string]any{}
+	if err := yaml.Unmarshal([]byte(doc), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+// mergeLayers merges value layers the way Render does: the overlays
+// onto each other first, then the result onto the chart defaults, and
+// finally strips the markers.
+func mergeLayers(t *testing.T, rules []MergeRule, chart string, overlays ...string) map[string]any {
+	t.Helper()
+
+	values := map[string]any{}
+	for _, doc := range overlays {
+		layer := parseValues(t, doc)
+		if err := checkMergeMarkers(layer, "overlay"); err != nil {
+			t.Fatal(err)
+		}
+
+		values = mergeValues(values, layer, rules)
+	}
+
+	out, _ := stripMergeMarkers(mergeValues(parseValues(t, chart), values, rules)).(map[string]any)
+
+	return out
+}
+
+func TestMergeValues_RuleStrategies(t *testing.T) {
+	t.Parallel()
+
+	rules := []MergeRule{
+		{Path: "certSANs", Strategy: MergeAppend},
+		{Path: "nodes.*.interfaces", Strategy: MergeByKey, Key: "interface"},
+	}
+
+	got := mergeLayers(t, rules, `
+certSANs: [a.example.com]
+endpoints: [10.0.0.1]
+nodes:
+  cp1:
+    interfaces:
+      - interface: eth0
+        dhcp: true
+      - interface: eth1
+        mtu: 1500
+`, `
+certSANs: [b.example.com]
+endpoints: [10.0.0.2]
+nodes:
+  cp1:
+    interfaces:
+      - interface: eth1
+        mtu: 9000
+      - interface: bond0
+        bond: {mode: 802.3ad}
+`)
+
+	want := parseValues(t, `
+certSANs: [a.example.com, b.example.com]
+endpoints: [10.0.0.2]
+nodes:
+  cp1:
+    interfaces:
+      - interface: eth0
+        dhcp: true
+      - interface: eth1
+        mtu: 9000
+      - interface: bond0
+        bond: {mode: 802.3ad}
+`)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+// TestMergeValues_MarkersReachChartDefaults pins the two-step merge:
+// a marker in a value file still applies to the chart's values.yaml
+// after the value files were merged with each other, and a later file
+// without a marker replaces what the earlier ones built.
+func TestMergeValues_MarkersReachChartDefaults(t *testing.T) {
+	t.Parallel()
+
+	chart := `certSANs: [chart.example.com]`
+
+	got := mergeLayers(t, nil, chart,
+		"certSANs: [{$patch: append}, one.example.com]",
+		"certSANs: [{$patch: append}, two.example.com]",
+	)
+	if want := []any{"chart.example.com", "one.example.com", "two.example.com"}; !reflect.DeepEqual(got["certSANs"], want) {
+		t.Errorf("append through both layers = %v, want %v", got["certSANs"], want)
+	}
+
+	got = mergeLayers(t, nil, chart,
+		"certSANs: [{$patch: append}, one.example.com]",
+		"certSANs: [two.example.com]",
+	)
+	if want := []any{"two.example.com"}; !reflect.DeepEqual(got["certSANs"], want) {
+		t.Errorf("a later plain list must replace = %v, want %v", got["certSANs"], want)
+	}
+
+	got = mergeLayers(t, nil, chart,
+		"certSANs: [one.example.com]",
+		"certSANs: [{$patch: append}, two.example.com]",
+	)
+	if want := []any{"one.example.com", "two.example.com"}; !reflect.DeepEqual(got["certSANs"], want) {
+		t.Errorf("append onto a replacing layer = %v, want %v", got["certSANs"], want)
+	}
+}
+
+// TestMergeValues_MarkerOverridesRule pins that an inline marker wins
+// over a Chart.yaml rule for the same path.
+func TestMergeValues_MarkerOverridesRule(t *testing.T) {
+	t.Parallel()
+
+	rules := []MergeRule{{Path: "certSANs", Strategy: MergeAppend}}
+
+	got := mergeLayers(t, rules, "certSANs: [a]", "certSANs: [{$patch: replace}, b]")
+	if want := []any{"b"}; !reflect.DeepEqual(got["certSANs"], want) {
+		t.Errorf("got %v, want %v", got["certSANs"], want)
+	}
+}
+
+func TestMergeValues_DeleteAndMapReplace(t *testing.T) {
+	t.Parallel()
+
+	got := mergeLayers(t, nil, `
+interfaces:
+  - interface: eth0
+  - interface: eth1
+kubelet:
+  extraArgs: {rotate-server-certificates: "true"}
+  nodeIP: 10.0.0.1
+`, `
+interfaces:
+  - {$patch: merge, $key: interface}
+  - {interface: eth1, $patch: delete}
+  - {interface: eth9, $patch: delete}
+kubelet:
+  $patch: replace
+  nodeIP: 10.0.0.2
+`)
+
+	want := parseValues(t, `
+interfaces:
+  - interface: eth0
+kubelet:
+  nodeIP: 10.0.0.2
+`)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+// TestMergeValues_ByKeyTreatsScalarTypesAlike pins that items match
+// on the key's text, so `vlanId: 10` and `vlanId: "10"` are the same
+// item (the later layer's value wins, as for any scalar), and that
+// items without the key are appended.
+func TestMergeValues_ByKeyTreatsScalarTypesAlike(t *testing.T) {
+	t.Parallel()
+
+	rules := []MergeRule{{Path: "vlans", Strategy: MergeByKey, Key: "vlanId"}}
+
+	got := mergeLayers(t, rules, `vlans: [{vlanId: 10, mtu: 1500}]`, `vlans: [{vlanId: "10", mtu: 9000}, {mtu: 1400}]`)
+
+	want := []any{map[string]any{"vlanId": "10", "mtu": 9000}, map[string]any{"mtu": 1400}}
+	if !reflect.DeepEqual(got["vlans"], want) {
+		t.Errorf("got %v, want %v", got["vlans"], want)
+	}
+}
+
+func TestMergeValues_DoesNotModifyInputs(t *testing.T) {
+	t.Parallel()
+
+	a := parseValues(t, "list: [{k: a, v: 1}]")
+	b := parseValues(t, "list: [{$patch: merge, $key: k}, {k: a, v: 2}]")
+
+	_ = mergeValues(a, b, nil)
+
+	if want := parseValues(t, "list: [{k: a, v: 1}]"); !reflect.DeepEqual(a, want) {
+		t.Errorf("base modified: %v", a)
+	}
+}
+
+func TestValidateMergeRules(t *testing.T) {
+	t.Parallel()
+
+	valid := []MergeRule{{Path: "a", Strategy: MergeAppend}, {Path: "b.*.c", Strategy: MergeByKey, Key: "name"}}
+	if err := ValidateMergeRules(valid); err != nil {
+		t.Errorf("valid rules rejected: %v", err)
+	}
+
+	for _, tc := range []struct {
+		rule MergeRule
+		want string
+	}{
+		{MergeRule{Strategy: MergeAppend}, "path is empty"},
+		{MergeRule{Path: "a", Strategy: "prepend"}, `unknown merge strategy "prepend"`},
+		{MergeRule{Path: "a", Strategy: MergeByKey}, "needs a key"},
+	} {
+		err := ValidateMergeRules([]MergeRule{tc.rule})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error = %v, want %q", tc.rule, err, tc.want)
+		}
+	}
+}
+
+func TestCheckMergeMarkers(t *testing.T) {
+	t.Parallel()
+
+	for _, doc := range []string{
+		"a: [{$patch: appnd}, x]",
+		"a: [{$patch: merge}, {k: x}]",
+		"a: {b: {$patch: merge}}",
+	} {
+		if err := checkMergeMarkers(parseValues(t, doc), "values.yaml"); err == nil {
+			t.Errorf("%s: expected an error", doc)
+		} else if !strings.Contains(err.Error(), "values.yaml") {
+			t.Errorf("%s: error must name the source, got %v", doc, err)
+		}
+	}
+
+	if err := checkMergeMarkers(parseValues(t, "a: [{$patch: merge, $key: k}, {k: x, $patch: delete}]"), "values.yaml"); err != nil {
+		t.Errorf("valid markers rejected: %v", err)
+	}
+}
+
+// TestLoadValues_HonoursMergeRules pins that value files merge under
+// Options.MergeRules and that a malformed marker fails the load with
+// the file named.
+func TestLoadValues_HonoursMergeRules(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	bad := filepath.Join(dir, "bad.yaml")
+
+	for path, content := range map[string]string{
+		first:  "certSANs: [a]\n",
+		second: "certSANs: [b]\n",
+		bad:    "certSANs: [{$patch: appnd}, c]\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := loadValues(Options{ValueFiles: []string{first, second}, MergeRules: []MergeRule{{Path: "certSANs", Strategy: MergeAppend}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []any{"a", "b"}; !reflect.DeepEqual(out["certSANs"], want) {
+		t.Errorf("certSANs = %v, want %v", out["certSANs"], want)
+	}
+
+	_, err = loadValues(Options{ValueFiles: []string{bad}})
+	if err == nil || !strings.Contains(err.Error(), "bad.yaml") {
+		t.Errorf("malformed marker error = %v", err)
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "replace, append, merge") {
+		t.Errorf("hint must list the strategies, got %q", hints)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+// MachineConfigKind is the kind an Ownership reports for the v1alpha1
+// machine config document, which has no kind field of its own.
+const MachineConfigKind = "v1alpha1"
+
+// sourceCommentPrefix starts the comment Options.AnnotateSources puts
+// above every rendered document, after helm's `# Source:` header.
+const sourceCommentPrefix = "# Source: "
+
+// DocumentSource is what produced a rendered document: the chart
+// template file, and the innermost named template whose output holds
+// the whole document, if any.
+type DocumentSource struct {
+	Template string
+	Helper   string
+}
+
+func (s DocumentSource) String() string {
+	if s.Helper == "" {
+		return s.Template
+	}
+
+	return s.Template + " (" + s.Helper + ")"
+}
+
+// DocumentOrigin is where one document of a rendered config came from.
+type DocumentOrigin struct {
+	// Kind is the document's kind, MachineConfigKind for the machine
+	// config.
+	Kind string
+	// Name is the document's name field, empty when it has none.
+	Name string
+	// Sources lists what produced the document. The machine config is
+	// the merge of every machine/cluster patch the templates render, so
+	// it may have several.
+	Sources []DocumentSource
+}
+
+// String names the document as `kind` or `kind/name`.
+func (d DocumentOrigin) String() string {
+	if d.Name == "" {
+		return d.Kind
+	}
+
+	return d.Kind + "/" + d.Name
+}
+
+// Ownership records which template produced each document of a render.
+// A nil Ownership records nothing.
+type Ownership struct {
+	mu        sync.Mutex
+	pending   []tracedOutput
+	includes  map[string][]tracedOutput
+	documents []DocumentOrigin
+}
+
+// tracedOutput is the output of one `include` call.
+type tracedOutput struct {
+	name, output string
+}
+
+// NewOwnership returns an empty ownership to set as Options.Ownership.
+func NewOwnership() *Ownership {
+	return &Ownership{includes: map[string][]tracedOutput{}}
+}
+
+// Documents returns the origins of the documents of the last render,
+// in the order Render writes them: the machine config first.
+func (o *Ownership) Documents() []DocumentOrigin {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return slices.Clone(o.documents)
+}
+
+// tracer returns the helm engine hook that files the output of every
+// include under the template file that reached it, or nil without an
+// ownership.
+func (o *Ownership) tracer() helmEngine.Tracer {
+	if o == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	o.pending = nil
+	o.includes = map[string][]tracedOutput{}
+	o.mu.Unlock()
+
+	return func(kind, name, output string) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		if kind == helmEngine.TimingInclude {
+			o.pending = append(o.pending, tracedOutput{name: name, output: output})
+
+			return
+		}
+
+		o.includes[name] = o.pending
+		o.pending = nil
+	}
+}
+
+// record attributes the documents of patches, the outputs of the
+// template files templates, in the order applyPatchesAndRenderConfig
+// writes them.
+func (o *Ownership) record(templates, patches []string) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	machineConfig := DocumentOrigin{Kind: MachineConfigKind}
+
+	var extra []DocumentOrigin
+
+	for i, patch := range patches {
+		for _, doc := range splitTemplateDocuments(patch) {
+			source := o.source(templates[i], doc)
+
+			if isTalos, _ := isTalosConfigPatch(doc); isTalos {
+				if !slices.Contains(machineConfig.Sources, source) {
+					machineConfig.Sources = append(machineConfig.Sources, source)
+				}
+
+				continue
+			}
+
+			origin := DocumentOrigin{Sources: []DocumentSource{source}}
+
+			var fields map[string]any
+			if yaml.Unmarshal([]byte(doc), &fields) == nil {
+				origin.Kind, _ = fields[k8sKeyKind].(string)
+				origin.Name, _ = fields["name"].(string)
+			}
+
+			extra = append(extra, origin)
+		}
+	}
+
+	o.documents = append([]DocumentOrigin{machineConfig}, extra...)
+}
+
+// source returns what produced doc in the output of template: the
+// innermost include whose output holds all of doc, or the template
+// file alone. Includes are traced innermost first, so on outputs of
+// equal length the first one wins.
+func (o *Ownership) source(template, doc string) DocumentSource {
+	source := DocumentSource{Template: template}
+	shortest := -1
+
+	for _, include := range o.includes[template] {
+		if (shortest < 0 || len(include.output) < shortest) && strings.Contains(include.output, doc) {
+			source.Helper = include.name
+			shortest = len(include.output)
+		}
+	}
+
+	return source
+}
+
+// annotateSources puts a `# Source:` comment per source above every
+// document of config. config is left as is when its documents do not
+// line up with documents.
+func annotateSources(config []byte, documents []DocumentOrigin) []byte {
+	parts := yamlDocSeparator.Split(string(config), -1)
+	if len(parts) != len(documents) {
+		return config
+	}
+
+	var buf strings.Builder
+
+	for i, part := range parts {
+		if i > 0 {
+			buf.WriteString("---\n")
+
+			part = strings.TrimPrefix(part, "\n")
+		}
+
+		for _, source := range documents[i].Sources {
+			fmt.Fprintf(&buf, "%s%s\n", sourceCommentPrefix, source)
+		}
+
+		buf.WriteString(part)
+	}
+
+	return []byte(buf.String())
+}
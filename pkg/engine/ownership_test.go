@@ -0,0 +1,158 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+// TestOwnership_Record pins the attribution: a document belongs to the
+// innermost include whose output holds all of it, the machine config
+// to every source of a machine/cluster patch, and a document no
+// include holds to the template file alone.
+func TestOwnership_Record(t *testing.T) {
+	t.Parallel()
+
+	const (
+		machine = "machine:\n  type: controlplane"
+		link    = "apiVersion: v1alpha1\nkind: LinkConfig\nname: eth0\nmtu: 9000"
+		network = "\n" + link + "\n---\napiVersion: v1alpha1\nkind: HostnameConfig\nhostname: cp01"
+		cluster = "cluster:\n  clusterName: test"
+	)
+
+	ownership := NewOwnership()
+	tracer := ownership.tracer()
+
+	tracer(helmEngine.TimingInclude, "tc.machine", machine)
+	tracer(helmEngine.TimingInclude, "tc.link", "\n"+link)
+	tracer(helmEngine.TimingInclude, "tc.network", network)
+	tracer(helmEngine.TimingInclude, "tc.config", machine+"\n---"+network)
+	tracer(helmEngine.TimingTemplate, "tc/templates/controlplane.yaml", "")
+	tracer(helmEngine.TimingInclude, "tc.machine", "machine:\n  type: worker")
+	tracer(helmEngine.TimingTemplate, "tc/templates/worker.yaml", "")
+
+	ownership.record(
+		[]string{"tc/templates/controlplane.yaml", "tc/templates/extra.yaml"},
+		[]string{machine + "\n---" + network, cluster + "\n---\nkind: Extra\n"},
+	)
+
+	want := []DocumentOrigin{
+		{Kind: MachineConfigKind, Sources: []DocumentSource{
+			{Template: "tc/templates/controlplane.yaml", Helper: "tc.machine"},
+			{Template: "tc/templates/extra.yaml"},
+		}},
+		{Kind: "LinkConfig", Name: "eth0", Sources: []DocumentSource{{Template: "tc/templates/controlplane.yaml", Helper: "tc.link"}}},
+		{Kind: "HostnameConfig", Sources: []DocumentSource{{Template: "tc/templates/controlplane.yaml", Helper: "tc.network"}}},
+		{Kind: "Extra", Sources: []DocumentSource{{Template: "tc/templates/extra.yaml"}}},
+	}
+	if got := ownership.Documents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("documents =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := want[1].String() + " " + want[1].Sources[0].String(); got != "LinkConfig/eth0 tc/templates/controlplane.yaml (tc.link)" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+func TestOwnership_Nil(t *testing.T) {
+	t.Parallel()
+
+	var ownership *Ownership
+
+	if ownership.tracer() != nil {
+		t.Error("a nil ownership must not install a tracer")
+	}
+
+	ownership.record([]string{"tc/templates/a.yaml"}, []string{"machine: {}"})
+}
+
+func TestAnnotateSources(t *testing.T) {
+	t.Parallel()
+
+	config := "machine:\n  type: worker\n---\napiVersion: v1alpha1\nkind: LinkConfig\nname: eth0\n"
+	documents := []DocumentOrigin{
+		{Kind: MachineConfigKind, Sources: []DocumentSource{{Template: "tc/templates/a.yaml", Helper: "tc.machine"}, {Template: "tc/templates/b.yaml"}}},
+		{Kind: "LinkConfig", Name: "eth0", Sources: []DocumentSource{{Template: "tc/templates/a.yaml", Helper: "tc.link"}}},
+	}
+
+	want := `# Source: tc/templates/a.yaml (tc.machine)
+# Source: tc/templates/b.yaml
+machine:
+  type: worker
+---
+# Source: tc/templates/a.yaml (tc.link)
+apiVersion: v1alpha1
+kind: LinkConfig
+name: eth0
+`
+	if got := string(annotateSources([]byte(config), documents)); got != want {
+		t.Errorf("annotated =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := string(annotateSources([]byte(config), documents[:1])); got != config {
+		t.Errorf("documents that do not line up must leave the config as is, got\n%s", got)
+	}
+}
+
+// TestContract_Render_AnnotateSources pins the end-to-end path: the
+// helm tracer feeds the ownership and Render heads every document with
+// its source.
+func TestContract_Render_AnnotateSources(t *testing.T) {
+	tmpl := `{{- define "tc.machine" }}
+machine:
+  type: worker
+{{- end }}
+{{- define "tc.link" }}
+apiVersion: v1alpha1
+kind: LinkConfig
+name: eth0
+mtu: 9000
+{{- end }}
+{{- include "tc.machine" . }}
+---
+{{- include "tc.link" . }}
+`
+	chartRoot := createTestChart(t, "tc", "config.yaml", tmpl)
+	ownership := NewOwnership()
+
+	out, err := Render(context.Background(), nil, Options{
+		Offline:         true,
+		Root:            chartRoot,
+		TemplateFiles:   []string{"templates/config.yaml"},
+		Ownership:       ownership,
+		AnnotateSources: true,
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	got := string(out)
+	if !strings.HasPrefix(got, "# Source: tc/templates/config.yaml (tc.machine)\n") {
+		t.Errorf("the machine config must be headed by its source, got:\n%s", got)
+	}
+
+	if !strings.Contains(got, "---\n# Source: tc/templates/config.yaml (tc.link)\napiVersion: v1alpha1\nkind: LinkConfig\n") {
+		t.Errorf("the LinkConfig must be headed by its source, got:\n%s", got)
+	}
+
+	if documents := ownership.Documents(); len(documents) != 2 || documents[1].String() != "LinkConfig/eth0" {
+		t.Errorf("documents = %+v", documents)
+	}
+}
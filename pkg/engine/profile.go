@@ -0,0 +1,198 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+// Render phases a RenderProfile times.
+const (
+	phasePrewarmLookups  = "prewarm lookups"
+	phaseLoadChart       = "load chart"
+	phaseValues          = "merge values"
+	phaseRenderTemplates = "render templates"
+	phaseApplyPatches    = "apply patches"
+)
+
+// profileDurationPrecision rounds the durations of the report.
+const profileDurationPrecision = 10 * time.Microsecond
+
+// RenderProfile collects where a render spends its time: the phases of
+// Render, the execution of every template file and `include`d helper,
+// and the chart's lookups by resource kind. One profile may span
+// several renders, as template --file renders one per node file; its
+// report sums them. A nil profile records nothing.
+type RenderProfile struct {
+	mu         sync.Mutex
+	phaseOrder []string
+	phases     map[string]*profileEntry
+	templates  map[string]*profileEntry
+	lookups    map[string]*profileEntry
+}
+
+// profileEntry sums the timings of one phase, template or lookup kind.
+type profileEntry struct {
+	calls  int
+	failed int
+	total  time.Duration
+	max    time.Duration
+}
+
+func (e *profileEntry) add(elapsed time.Duration, failed bool) {
+	e.calls++
+	e.total += elapsed
+	e.max = max(e.max, elapsed)
+
+	if failed {
+		e.failed++
+	}
+}
+
+// NewRenderProfile returns an empty profile to set as Options.Profile.
+func NewRenderProfile() *RenderProfile {
+	return &RenderProfile{
+		phases:    map[string]*profileEntry{},
+		templates: map[string]*profileEntry{},
+		lookups:   map[string]*profileEntry{},
+	}
+}
+
+// record adds one timing to the entry named name of section, creating
+// it on first use, and reports whether it did.
+func (p *RenderProfile) record(section map[string]*profileEntry, name string, elapsed time.Duration, failed bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := section[name]
+	if !ok {
+		entry = &profileEntry{}
+		section[name] = entry
+	}
+
+	entry.add(elapsed, failed)
+
+	return !ok
+}
+
+// phase records the time since start against a Render phase.
+func (p *RenderProfile) phase(name string, start time.Time) {
+	if p == nil {
+		return
+	}
+
+	if p.record(p.phases, name, time.Since(start), false) {
+		p.mu.Lock()
+		p.phaseOrder = append(p.phaseOrder, name)
+		p.mu.Unlock()
+	}
+}
+
+// timer returns the helm engine hook that records template and
+// include times, or nil without a profile.
+func (p *RenderProfile) timer() helmEngine.Timer {
+	if p == nil {
+		return nil
+	}
+
+	return func(kind, name string, elapsed time.Duration) {
+		p.record(p.templates, kind+" "+name, elapsed, false)
+	}
+}
+
+// timeLookup wraps a chart `lookup` implementation so every call is
+// recorded against its resource kind.
+func (p *RenderProfile) timeLookup(lookup func(string, string, string) (map[string]any, error)) func(string, string, string) (map[string]any, error) {
+	if p == nil {
+		return lookup
+	}
+
+	return func(kind, namespace, id string) (map[string]any, error) {
+		start := time.Now()
+		res, err := lookup(kind, namespace, id)
+
+		p.record(p.lookups, kind, time.Since(start), err != nil)
+
+		return res, err
+	}
+}
+
+// WriteReport writes the profile as three tables: the phases in the
+// order they ran, then the templates and the lookup kinds, slowest
+// first. An include's time counts the helpers it includes in turn, so
+// the templates table reads as a call tree flattened by total.
+func (p *RenderProfile) WriteReport(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(tw, "PHASE\tRUNS\tTOTAL")
+
+	for _, name := range p.phaseOrder {
+		entry := p.phases[name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", name, entry.calls, entry.total.Round(profileDurationPrecision))
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "TEMPLATE\tCALLS\tTOTAL\tMAX")
+
+	for _, name := range slowestFirst(p.templates) {
+		entry := p.templates[name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", name, entry.calls, entry.total.Round(profileDurationPrecision), entry.max.Round(profileDurationPrecision))
+	}
+
+	fmt.Fprintln(tw)
+
+	if len(p.lookups) == 0 {
+		fmt.Fprintln(tw, "No lookups.")
+
+		return errors.Wrap(tw.Flush(), "writing the render profile")
+	}
+
+	fmt.Fprintln(tw, "LOOKUP KIND\tCALLS\tTOTAL\tMAX\tFAILED")
+
+	for _, name := range slowestFirst(p.lookups) {
+		entry := p.lookups[name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\n", name, entry.calls, entry.total.Round(profileDurationPrecision), entry.max.Round(profileDurationPrecision), entry.failed)
+	}
+
+	return errors.Wrap(tw.Flush(), "writing the render profile")
+}
+
+// slowestFirst returns the names of section by total time, descending,
+// names breaking ties.
+func slowestFirst(section map[string]*profileEntry) []string {
+	names := make([]string, 0, len(section))
+	for name := range section {
+		names = append(names, name)
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(section[b].total, section[a].total), cmp.Compare(a, b))
+	})
+
+	return names
+}
@@ -0,0 +1,133 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	helmEngine "github.com/cozystack/talm/pkg/engine/helm"
+)
+
+func TestRenderProfile_Report(t *testing.T) {
+	t.Parallel()
+
+	profile := NewRenderProfile()
+
+	start := time.Now().Add(-time.Second)
+	profile.phase(phaseLoadChart, start)
+	profile.phase(phaseRenderTemplates, start)
+	profile.phase(phaseLoadChart, start)
+
+	timer := profile.timer()
+	timer(helmEngine.TimingTemplate, "talm/templates/controlplane.yaml", 4*time.Second)
+	timer(helmEngine.TimingInclude, "talm.discovered.disks", 2*time.Second)
+	timer(helmEngine.TimingInclude, "talm.discovered.disks", time.Second)
+
+	lookup := profile.timeLookup(func(kind, _, _ string) (map[string]any, error) {
+		if kind == "routes" {
+			return nil, errors.New("connection refused")
+		}
+
+		return map[string]any{}, nil
+	})
+
+	for _, kind := range []string{"disks", "routes", "disks"} {
+		_, _ = lookup(kind, "", "")
+	}
+
+	var out bytes.Buffer
+	if err := profile.WriteReport(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows [][]string
+	for line := range strings.Lines(out.String()) {
+		rows = append(rows, strings.Fields(line))
+	}
+
+	// Only the template timings are fixed; the phases and lookups
+	// took real time. Compare their name, count and failures.
+	section := ""
+	for i, row := range rows {
+		switch {
+		case len(row) == 0 || strings.ToUpper(row[0]) == row[0]:
+			section = strings.Join(row, " ")
+		case strings.HasPrefix(section, "PHASE"):
+			rows[i] = row[:3]
+		case strings.HasPrefix(section, "LOOKUP"):
+			rows[i] = []string{row[0], row[1], row[4]}
+		}
+	}
+
+	want := [][]string{
+		{"PHASE", "RUNS", "TOTAL"},
+		{"load", "chart", "2"},
+		{"render", "templates", "1"},
+		{},
+		{"TEMPLATE", "CALLS", "TOTAL", "MAX"},
+		{"template", "talm/templates/controlplane.yaml", "1", "4s", "4s"},
+		{"include", "talm.discovered.disks", "2", "3s", "2s"},
+		{},
+		{"LOOKUP", "KIND", "CALLS", "TOTAL", "MAX", "FAILED"},
+		{"disks", "2", "0"},
+		{"routes", "1", "1"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("report =\n%s", out.String())
+	}
+}
+
+func TestRenderProfile_NoLookups(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	if err := NewRenderProfile().WriteReport(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(out.String(), "No lookups.\n") {
+		t.Errorf("report = %q", out.String())
+	}
+}
+
+// TestRenderProfile_Nil pins that a render without --profile pays
+// nothing: the nil profile hands back the lookup as is and no timer.
+func TestRenderProfile_Nil(t *testing.T) {
+	t.Parallel()
+
+	var profile *RenderProfile
+
+	profile.phase(phaseApplyPatches, time.Now())
+
+	if profile.timer() != nil {
+		t.Error("a nil profile must not install a timer")
+	}
+
+	called := false
+	lookup := profile.timeLookup(func(string, string, string) (map[string]any, error) {
+		called = true
+
+		return nil, nil
+	})
+
+	if _, err := lookup("disks", "", ""); err != nil || !called {
+		t.Errorf("lookup: called = %v, err = %v", called, err)
+	}
+}
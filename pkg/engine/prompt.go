@@ -0,0 +1,201 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys of the values.schema.json annotations that drive prompting.
+const (
+	// schemaKeyPrompt marks a property as prompt-on-missing.
+	schemaKeyPrompt = "prompt"
+	// schemaKeyWriteOnly and schemaKeyFormat with schemaFormatPassword
+	// are the standard JSON Schema ways to flag a secret; either one
+	// switches the prompt to hidden input.
+	schemaKeyWriteOnly   = "writeOnly"
+	schemaKeyFormat      = "format"
+	schemaFormatPassword = "password"
+	schemaKeyDescription = "description"
+	schemaKeyType        = "type"
+	schemaKeyProperties  = "properties"
+	schemaTypeString     = "string"
+)
+
+// PromptRequest describes one value the chart schema marks with
+// `"prompt": true` and the merged values leave empty.
+type PromptRequest struct {
+	// Path is the dotted values path, e.g. `bootstrap.token`.
+	Path string
+	// Description is the schema description, empty when absent.
+	Description string
+	// Secret requests hidden input: the schema marks the property
+	// `writeOnly: true` or `format: password`.
+	Secret bool
+}
+
+// PromptFunc asks the operator for the value of req and returns the
+// raw answer. Set Options.Prompt only when the session is interactive;
+// a nil PromptFunc leaves missing values missing, so non-interactive
+// renders behave exactly as before (a `required` in the template
+// still fails them).
+type PromptFunc func(req PromptRequest) (string, error)
+
+// promptMissingValues walks the chart's values.schema.json and fills
+// every `"prompt": true` property that is absent, null or an empty
+// string in values by calling prompt. Only nested `properties` objects
+// are followed; properties under arrays cannot be addressed by a
+// single path and are not prompted for. Properties are visited in
+// sorted order so prompts appear in a stable sequence.
+func promptMissingValues(schema []byte, values map[string]any, prompt PromptFunc) error {
+	if prompt == nil || len(schema) == 0 {
+		return nil
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Wrap(err, "parsing values.schema.json"),
+			"values.schema.json must be a JSON object; validate it with any JSON Schema linter",
+		)
+	}
+
+	return promptSchemaProperties(root, nil, values, prompt)
+}
+
+func promptSchemaProperties(node map[string]any, prefix []string, values map[string]any, prompt PromptFunc) error {
+	properties, _ := node[schemaKeyProperties].(map[string]any)
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		child, ok := properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		path := append(append([]string(nil), prefix...), name)
+
+		if marked, _ := child[schemaKeyPrompt].(bool); marked && valueMissing(values, path) {
+			if err := promptOne(child, path, values, prompt); err != nil {
+				return err
+			}
+		}
+
+		if err := promptSchemaProperties(child, path, values, prompt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func promptOne(property map[string]any, path []string, values map[string]any, prompt PromptFunc) error {
+	description, _ := property[schemaKeyDescription].(string)
+	writeOnly, _ := property[schemaKeyWriteOnly].(bool)
+	format, _ := property[schemaKeyFormat].(string)
+
+	req := PromptRequest{
+		Path:        strings.Join(path, "."),
+		Description: description,
+		Secret:      writeOnly || format == schemaFormatPassword,
+	}
+
+	answer, err := prompt(req)
+	if err != nil {
+		return errors.Wrapf(err, "prompting for %s", req.Path)
+	}
+
+	value, err := coercePromptAnswer(property, answer)
+	if err != nil {
+		return errors.Wrapf(err, "value for %s", req.Path)
+	}
+
+	setValuePath(values, path, value)
+
+	return nil
+}
+
+// coercePromptAnswer keeps the answer a string for string (or untyped)
+// properties, so a token like `0123` or `true` stays verbatim, and
+// parses it as a YAML scalar for every other declared type.
+func coercePromptAnswer(property map[string]any, answer string) (any, error) {
+	typ, _ := property[schemaKeyType].(string)
+	if typ == "" || typ == schemaTypeString {
+		return answer, nil
+	}
+
+	var value any
+	if err := yaml.Unmarshal([]byte(answer), &value); err != nil {
+		return nil, errors.Wrapf(err, "parsing %q as %s", answer, typ)
+	}
+
+	return value, nil
+}
+
+// valueMissing reports whether path is absent, null or an empty
+// string in values.
+func valueMissing(values map[string]any, path []string) bool {
+	var current any = values
+
+	for _, key := range path {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return true
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return true
+		}
+	}
+
+	if current == nil {
+		return true
+	}
+
+	s, isString := current.(string)
+
+	return isString && s == ""
+}
+
+// setValuePath sets value at path, creating intermediate maps and
+// replacing non-map intermediates.
+func setValuePath(values map[string]any, path []string, value any) {
+	current := values
+
+	for _, key := range path[:len(path)-1] {
+		next, ok := current[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[key] = next
+		}
+
+		current = next
+	}
+
+	current[path[len(path)-1]] = value
+}
@@ -0,0 +1,126 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const promptTestSchema = `{
+  "type": "object",
+  "properties": {
+    "bootstrap": {
+      "type": "object",
+      "properties": {
+        "token": {"type": "string", "prompt": true, "writeOnly": true, "description": "one-time bootstrap token"},
+        "replicas": {"type": "integer", "prompt": true}
+      }
+    },
+    "adminPassword": {"type": "string", "prompt": true, "format": "password"},
+    "clusterDomain": {"type": "string", "prompt": true},
+    "notPrompted": {"type": "string"}
+  }
+}`
+
+// TestPromptMissingValues pins which properties are prompted for
+// (marked and empty), in which order, with which hidden-input flag,
+// and how answers are typed.
+func TestPromptMissingValues(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]any{
+		"clusterDomain": "cozy.local",
+		"adminPassword": "",
+	}
+
+	var asked []PromptRequest
+
+	answers := map[string]string{
+		"adminPassword":      "s3cret",
+		"bootstrap.replicas": "3",
+		"bootstrap.token":    "0123",
+	}
+
+	err := promptMissingValues([]byte(promptTestSchema), values, func(req PromptRequest) (string, error) {
+		asked = append(asked, req)
+
+		return answers[req.Path], nil
+	})
+	if err != nil {
+		t.Fatalf("promptMissingValues: %v", err)
+	}
+
+	want := []PromptRequest{
+		{Path: "adminPassword", Secret: true},
+		{Path: "bootstrap.replicas"},
+		{Path: "bootstrap.token", Description: "one-time bootstrap token", Secret: true},
+	}
+	if !reflect.DeepEqual(asked, want) {
+		t.Errorf("prompts = %+v, want %+v", asked, want)
+	}
+
+	wantValues := map[string]any{
+		"clusterDomain": "cozy.local",
+		"adminPassword": "s3cret",
+		"bootstrap":     map[string]any{"replicas": 3, "token": "0123"},
+	}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("values = %#v, want %#v", values, wantValues)
+	}
+}
+
+// TestPromptMissingValues_NilPromptIsNoop pins the non-interactive
+// path: without a PromptFunc, values are left untouched.
+func TestPromptMissingValues_NilPromptIsNoop(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]any{}
+	if err := promptMissingValues([]byte(promptTestSchema), values, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(values) != 0 {
+		t.Errorf("values must stay empty, got %v", values)
+	}
+}
+
+// TestPromptMissingValues_Errors pins that a prompt failure (EOF,
+// ^C) names the value being asked for and a mistyped answer for a
+// typed property is rejected.
+func TestPromptMissingValues_Errors(t *testing.T) {
+	t.Parallel()
+
+	err := promptMissingValues([]byte(promptTestSchema), map[string]any{}, func(PromptRequest) (string, error) {
+		return "", errors.New("EOF")
+	})
+	if err == nil || err.Error() != "prompting for adminPassword: EOF" {
+		t.Errorf("error = %v", err)
+	}
+
+	if err := promptMissingValues([]byte(`{"properties": [}`), map[string]any{}, func(PromptRequest) (string, error) {
+		return "", nil
+	}); err == nil {
+		t.Error("malformed schema must be rejected")
+	}
+
+	typed := `{"properties": {"replicas": {"type": "integer", "prompt": true}}}`
+	if err := promptMissingValues([]byte(typed), map[string]any{}, func(PromptRequest) (string, error) {
+		return "[", nil
+	}); err == nil {
+		t.Error("unparseable answer for a typed property must be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+)
+
+// maxCachedValueFiles bounds the decoded value files kept in memory.
+const maxCachedValueFiles = 64
+
+// valueFileCache keeps the value files decoded in this process, keyed
+// on the path and a SHA-256 of the content. Rendering a directory of
+// node files loads the same values.yaml, encrypted values and values
+// lock once per file; decrypting and decoding them again each time
+// adds up on large projects. A file edited between two renders hashes
+// differently and is decoded afresh.
+//
+//nolint:gochecknoglobals // process-wide cache shared by every Render call, like helmEngine.LookupFunc.
+var valueFileCache = struct {
+	mu    sync.Mutex
+	files map[string]map[string]any
+}{files: map[string]map[string]any{}}
+
+// cachedValueFile returns loadValueFile(rootDir, filePath), decoding the
+// file only when its content was not decoded before. The caller gets a
+// copy it may modify: --set values are merged into it in place.
+func cachedValueFile(rootDir, filePath string) (map[string]any, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		// loadValueFile reports the failure with the wording of the
+		// file's kind.
+		return loadValueFile(rootDir, filePath)
+	}
+
+	sum := sha256.Sum256(data)
+	key := filePath + "\x00" + hex.EncodeToString(sum[:])
+
+	valueFileCache.mu.Lock()
+	cached, ok := valueFileCache.files[key]
+	valueFileCache.mu.Unlock()
+
+	if !ok {
+		cached, err = loadValueFile(rootDir, filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		valueFileCache.mu.Lock()
+		if len(valueFileCache.files) >= maxCachedValueFiles {
+			clear(valueFileCache.files)
+		}
+
+		valueFileCache.files[key] = cached
+		valueFileCache.mu.Unlock()
+	}
+
+	values, _ := copyValue(cached).(map[string]any)
+
+	return values, nil
+}
@@ -0,0 +1,110 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cachedValueFileEntries counts the cached decodes of filePath.
+func cachedValueFileEntries(filePath string) int {
+	valueFileCache.mu.Lock()
+	defer valueFileCache.mu.Unlock()
+
+	count := 0
+
+	for key := range valueFileCache.files {
+		if strings.HasPrefix(key, filePath+"\x00") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// TestCachedValueFile pins that a value file is decoded once per
+// content, and that a caller modifying the values it got does not
+// change what the next render reads.
+func TestCachedValueFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(path, []byte("cluster:\n  name: demo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := cachedValueFile("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, _ := first["cluster"].(map[string]any)
+	cluster["name"] = "changed by --set"
+
+	second, err := cachedValueFile("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{"cluster": map[string]any{"name": "demo"}}
+	if !reflect.DeepEqual(second, want) {
+		t.Errorf("second read = %v, want %v", second, want)
+	}
+
+	if n := cachedValueFileEntries(path); n != 1 {
+		t.Errorf("cached decodes = %d, want 1", n)
+	}
+
+	if err := os.WriteFile(path, []byte("cluster:\n  name: edited\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := cachedValueFile("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = map[string]any{"cluster": map[string]any{"name": "edited"}}
+	if !reflect.DeepEqual(edited, want) {
+		t.Errorf("edited read = %v, want %v", edited, want)
+	}
+}
+
+// TestCachedValueFile_Errors pins that a missing or broken file fails
+// as loadValueFile reports it, and is not cached.
+func TestCachedValueFile_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := cachedValueFile("", filepath.Join(dir, "absent.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "failed to read values file") {
+		t.Errorf("missing file: err = %v", err)
+	}
+
+	broken := filepath.Join(dir, "broken.yaml")
+	if err := os.WriteFile(broken, []byte("- a list\n- not a map\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cachedValueFile("", broken)
+	if err == nil || !strings.Contains(err.Error(), "failed to unmarshal values from file") {
+		t.Errorf("broken file: err = %v", err)
+	}
+
+	if n := cachedValueFileEntries(broken); n != 0 {
+		t.Errorf("cached decodes of a broken file = %d", n)
+	}
+}
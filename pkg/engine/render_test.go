@@ -4066,6 +4066,16 @@ machine:
 			content: "# talm: nodes=[\"a\",\"b\"]\nmachine:\n  ---\n",
 			want:    true,
 		},
+		{
+			name:    "JSON node file without documents",
+			content: `{"talm": {"nodes": ["a", "b"]}, "documents": []}` + "\n",
+			want:    false,
+		},
+		{
+			name:    "JSON node file with a body",
+			content: `{"talm": {"nodes": ["a"]}, "documents": [{"machine": {"network": {"hostname": "node0"}}}]}`,
+			want:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -6949,6 +6959,14 @@ func renderGenericExpectError(t *testing.T, lookup func(string, string, string)
 // renderGenericWith is the generic-preset counterpart of renderCozystackWith.
 func renderGenericWith(t *testing.T, lookup func(string, string, string) (map[string]any, error), overrides map[string]any) string {
 	t.Helper()
+
+	return renderGenericWithVersion(t, lookup, "v1.12", overrides)
+}
+
+// renderGenericWithVersion is renderGenericWith for a given Talos
+// version, for the templates that need a full release.
+func renderGenericWithVersion(t *testing.T, lookup func(string, string, string) (map[string]any, error), talosVersion string, overrides map[string]any) string {
+	t.Helper()
 	origLookup := helmEngine.LookupFunc
 	t.Cleanup(func() { helmEngine.LookupFunc = origLookup })
 	helmEngine.LookupFunc = lookup
@@ -6968,7 +6986,7 @@ func renderGenericWith(t *testing.T, lookup func(string, string, string) (map[st
 	eng := helmEngine.Engine{}
 	out, err := eng.Render(chrt, common.Values{
 		"Values":       values,
-		"TalosVersion": "v1.12",
+		"TalosVersion": talosVersion,
 	})
 	if err != nil {
 		t.Fatalf("render: %v", err)
@@ -0,0 +1,148 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+// helmKeyMachineType is the chart-rendering top-level context key a
+// role render sets to the role, the key the charts branch on.
+const helmKeyMachineType = "MachineType"
+
+// renderRoles are the roles a render can be asked for: the machine
+// types a node config declares.
+//
+//nolint:gochecknoglobals // read-only lookup table.
+var renderRoles = []string{machine.TypeControlPlane.String(), machine.TypeWorker.String()}
+
+// RenderRoles returns the roles a render can be asked for.
+func RenderRoles() []string {
+	return slices.Clone(renderRoles)
+}
+
+// RoleTemplates returns the templates the Chart.yaml
+// templateOptions.roles matrix lists for role.
+func RoleTemplates(roles map[string][]string, role string) ([]string, error) {
+	if err := validateRoleName(role); err != nil {
+		return nil, err
+	}
+
+	templates, ok := roles[role]
+	if !ok || len(templates) == 0 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("the chart declares no templates for role %s", role),
+			"list them in Chart.yaml under templateOptions.roles.%s, or pass --template", role,
+		)
+	}
+
+	return slices.Clone(templates), nil
+}
+
+// validateRole checks the role of a render against opts: the role is
+// a machine type, and every template of the render is one the roles
+// matrix lists for it. A chart without a matrix accepts any template.
+//
+//nolint:gocritic // hugeParam: Options is passed by value like Render takes it.
+func validateRole(opts Options) error {
+	if opts.Role == "" {
+		return nil
+	}
+
+	if err := validateRoleName(opts.Role); err != nil {
+		return err
+	}
+
+	if len(opts.Roles) == 0 {
+		return nil
+	}
+
+	allowed, ok := opts.Roles[opts.Role]
+	if !ok {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("the chart declares no templates for role %s", opts.Role),
+			"the roles of the chart are %s; add %s to templateOptions.roles in Chart.yaml",
+			strings.Join(sortedRoles(opts.Roles), ", "), opts.Role,
+		)
+	}
+
+	for _, templateFile := range opts.TemplateFiles {
+		if !slices.ContainsFunc(allowed, func(listed string) bool {
+			return sameTemplate(listed, templateFile)
+		}) {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Newf("template %s is not a %s template", templateFile, opts.Role),
+				"the chart lists %s for role %s in templateOptions.roles of Chart.yaml",
+				strings.Join(allowed, ", "), opts.Role,
+			)
+		}
+	}
+
+	return nil
+}
+
+// validateRoleName checks that role is one of renderRoles.
+func validateRoleName(role string) error {
+	if slices.Contains(renderRoles, role) {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHintf(
+		errors.Newf("unknown role %q", role),
+		"a role is one of %s", strings.Join(renderRoles, ", "),
+	)
+}
+
+// checkRenderedRole fails a role render whose config declares another
+// machine type, a conditional block of the template gone wrong.
+func checkRenderedRole(role string, machineType machine.Type) error {
+	if role == "" || machineType.String() == role {
+		return nil
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return errors.WithHint(
+		errors.Newf("rendered for role %s, the config declares machine.type %s", role, machineType),
+		"set machine.type from .MachineType in the template, e.g. type: {{ .MachineType }}",
+	)
+}
+
+// sameTemplate reports whether two chart template paths name the same
+// file, whatever the separators or a leading ./ say.
+func sameTemplate(a, b string) bool {
+	return path.Clean(NormalizeTemplatePath(a)) == path.Clean(NormalizeTemplatePath(b))
+}
+
+// sortedRoles returns the roles of the matrix in order.
+func sortedRoles(roles map[string][]string) []string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,184 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+// roleTestTemplate is one template for both roles: the machine type
+// and the control plane only block follow .MachineType.
+const roleTestTemplate = `machine:
+  type: {{ .MachineType }}
+{{- if eq .MachineType "controlplane" }}
+cluster:
+  allowSchedulingOnControlPlanes: true
+{{- end }}
+`
+
+// TestRoleTemplates pins the lookup of the templates of a role in the
+// Chart.yaml matrix, and the refusal of unknown or undeclared roles.
+func TestRoleTemplates(t *testing.T) {
+	t.Parallel()
+
+	roles := map[string][]string{"controlplane": {"templates/node.yaml"}}
+
+	got, err := RoleTemplates(roles, "controlplane")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(got, ",") != "templates/node.yaml" {
+		t.Errorf("templates = %v", got)
+	}
+
+	for role, wantErr := range map[string]string{
+		"worker": "declares no templates for role worker",
+		"init":   `unknown role "init"`,
+	} {
+		if _, err := RoleTemplates(roles, role); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("RoleTemplates(%q) error = %v, want %q", role, err, wantErr)
+		}
+	}
+}
+
+// TestValidateRole pins that a role render takes only the templates
+// the matrix lists for the role, and that a chart without a matrix
+// takes any.
+func TestValidateRole(t *testing.T) {
+	t.Parallel()
+
+	roles := map[string][]string{
+		"controlplane": {"templates/node.yaml", "templates/controlplane-extra.yaml"},
+		"worker":       {"templates/node.yaml"},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{name: "no role", opts: Options{Roles: roles, TemplateFiles: []string{"templates/anything.yaml"}}},
+		{name: "listed", opts: Options{Role: "worker", Roles: roles, TemplateFiles: []string{"./templates/node.yaml"}}},
+		{name: "no matrix", opts: Options{Role: "worker", TemplateFiles: []string{"templates/anything.yaml"}}},
+		{
+			name:    "not listed",
+			opts:    Options{Role: "worker", Roles: roles, TemplateFiles: []string{"templates/node.yaml", "templates/controlplane-extra.yaml"}},
+			wantErr: "template templates/controlplane-extra.yaml is not a worker template",
+		},
+		{
+			name:    "undeclared role",
+			opts:    Options{Role: "worker", Roles: map[string][]string{"controlplane": {"templates/node.yaml"}}},
+			wantErr: "declares no templates for role worker",
+		},
+		{name: "unknown role", opts: Options{Role: "master"}, wantErr: `unknown role "master"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateRole(tc.opts)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want one mentioning %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestCheckRenderedRole pins the comparison of the role with the
+// machine type of the rendered config.
+func TestCheckRenderedRole(t *testing.T) {
+	t.Parallel()
+
+	if err := checkRenderedRole("", machine.TypeControlPlane); err != nil {
+		t.Errorf("no role: %v", err)
+	}
+
+	if err := checkRenderedRole("worker", machine.TypeWorker); err != nil {
+		t.Errorf("matching role: %v", err)
+	}
+
+	err := checkRenderedRole("worker", machine.TypeControlPlane)
+	if err == nil || !strings.Contains(err.Error(), "declares machine.type controlplane") {
+		t.Errorf("error = %v, want a machine type mismatch", err)
+	}
+}
+
+// TestRender_Role pins that one template renders both roles from
+// .MachineType, and that a template declaring another machine type
+// than the role fails the render.
+func TestRender_Role(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "node.yaml", roleTestTemplate)
+	roles := map[string][]string{
+		"controlplane": {"templates/node.yaml"},
+		"worker":       {"templates/node.yaml"},
+	}
+
+	for role, want := range map[string]string{
+		"controlplane": "allowSchedulingOnControlPlanes: true",
+		"worker":       "type: worker",
+	} {
+		out, err := Render(context.Background(), nil, Options{
+			Offline:       true,
+			Root:          chartRoot,
+			TemplateFiles: []string{"templates/node.yaml"},
+			Role:          role,
+			Roles:         roles,
+		})
+		if err != nil {
+			t.Fatalf("role %s: %v", role, err)
+		}
+
+		if !strings.Contains(string(out), want) {
+			t.Errorf("role %s render lacks %q:\n%s", role, want, out)
+		}
+	}
+
+	out, err := Render(context.Background(), nil, Options{
+		Offline:       true,
+		Root:          chartRoot,
+		TemplateFiles: []string{"templates/node.yaml"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(out), "allowSchedulingOnControlPlanes") {
+		t.Errorf("a render without a role took the control plane block:\n%s", out)
+	}
+
+	pinned := createTestChart(t, "tc", "node.yaml", "machine:\n  type: controlplane\n")
+
+	_, err = Render(context.Background(), nil, Options{
+		Offline:       true,
+		Root:          pinned,
+		TemplateFiles: []string{"templates/node.yaml"},
+		Role:          "worker",
+	})
+	if err == nil || !strings.Contains(err.Error(), "rendered for role worker") {
+		t.Errorf("error = %v, want a machine type mismatch", err)
+	}
+}
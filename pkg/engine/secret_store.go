@@ -0,0 +1,385 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cozystack/talm/pkg/age"
+	"github.com/cozystack/talm/pkg/httpclient"
+)
+
+// Secret store providers of Chart.yaml templateOptions.secretStore.
+const (
+	// SecretStoreAge reads a YAML file whose values are encrypted with
+	// the project's age key, as the *.encrypted.yaml value files are.
+	SecretStoreAge = "age"
+	// SecretStoreSOPS reads a YAML or JSON file encrypted with SOPS,
+	// through the sops binary.
+	SecretStoreSOPS = "sops"
+	// SecretStoreVault reads one HashiCorp Vault KV version 2 secret.
+	SecretStoreVault = "vault"
+)
+
+const (
+	// sopsBinary is the SOPS command line the sops provider runs.
+	sopsBinary = "sops"
+	// defaultVaultMount is the mount of the KV engine Vault enables by
+	// default.
+	defaultVaultMount = "secret"
+	// vaultTimeout bounds the one request the vault provider makes.
+	vaultTimeout = 30 * time.Second
+)
+
+// SecretStore is the Chart.yaml templateOptions.secretStore entry: the
+// backend the chart `secretRef` function reads secrets from. Every
+// provider yields one document, and a reference is a dotted path into
+// it, so a chart reads `secretRef "db.password"` whatever the project
+// keeps its secrets in.
+type SecretStore struct {
+	// Provider is age, sops or vault; empty means no store.
+	Provider string `yaml:"provider"`
+	// File is the store of the age and sops providers, relative to
+	// the project root unless absolute.
+	File string `yaml:"file"`
+	// Address is the Vault server; empty means VAULT_ADDR. The token
+	// is read from VAULT_TOKEN, then ~/.vault-token, as by the vault
+	// command line.
+	Address string `yaml:"address"`
+	// Mount is the KV version 2 mount, "secret" when empty.
+	Mount string `yaml:"mount"`
+	// Path is the secret under Mount whose data is the document.
+	Path string `yaml:"path"`
+}
+
+// Validate rejects an unknown provider and a store missing what its
+// provider reads.
+func (s SecretStore) Validate() error {
+	switch s.Provider {
+	case "":
+		return nil
+	case SecretStoreAge, SecretStoreSOPS:
+		if s.File == "" {
+			return errors.Newf("templateOptions.secretStore: the %s provider needs a file", s.Provider)
+		}
+	case SecretStoreVault:
+		if s.Path == "" {
+			return errors.New("templateOptions.secretStore: the vault provider needs the path of a secret")
+		}
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHintf(
+			errors.Newf("templateOptions.secretStore: unknown provider %q", s.Provider),
+			"use one of %s, %s or %s", SecretStoreAge, SecretStoreSOPS, SecretStoreVault,
+		)
+	}
+
+	return nil
+}
+
+// String names the store in messages.
+func (s SecretStore) String() string {
+	if s.Provider == SecretStoreVault {
+		return fmt.Sprintf("vault secret %s/%s", s.mount(), s.Path)
+	}
+
+	return fmt.Sprintf("%s secret store %s", s.Provider, s.File)
+}
+
+// Load reads the whole store document. root resolves a relative File
+// and locates the project's age key.
+func (s SecretStore) Load(ctx context.Context, root string) (map[string]any, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch s.Provider {
+	case SecretStoreAge:
+		doc, err := age.DecryptYAMLToMap(root, s.file(root))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading the %s", s)
+		}
+
+		return doc, nil
+	case SecretStoreSOPS:
+		return s.loadSOPS(ctx, root)
+	case SecretStoreVault:
+		return s.loadVault(ctx)
+	default:
+		return nil, errors.New("templateOptions.secretStore: no provider is set")
+	}
+}
+
+// file resolves File against root.
+func (s SecretStore) file(root string) string {
+	if filepath.IsAbs(s.File) {
+		return s.File
+	}
+
+	return filepath.Join(root, s.File)
+}
+
+func (s SecretStore) mount() string {
+	if s.Mount == "" {
+		return defaultVaultMount
+	}
+
+	return strings.Trim(s.Mount, "/")
+}
+
+// loadSOPS decrypts File with sops, which finds the keys the file was
+// encrypted to on its own.
+func (s SecretStore) loadSOPS(ctx context.Context, root string) (map[string]any, error) {
+	tool, err := exec.LookPath(sopsBinary)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrapf(err, "reading the %s", s),
+			"install sops, or move the secrets to the age provider",
+		)
+	}
+
+	var stderr strings.Builder
+
+	//nolint:gosec // the tool is the operator's sops; the argument is the store file of Chart.yaml.
+	cmd := exec.CommandContext(ctx, tool, "--decrypt", s.file(root))
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the %s: %s", s, strings.TrimSpace(stderr.String()))
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(out, &doc); err != nil {
+		return nil, errors.Wrapf(err, "parsing the %s", s)
+	}
+
+	return doc, nil
+}
+
+// loadVault reads the data of the secret at Path.
+func (s SecretStore) loadVault(ctx context.Context) (map[string]any, error) {
+	address := s.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+
+	if address == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("reading the %s: no Vault address", s),
+			"set templateOptions.secretStore.address in Chart.yaml, or export VAULT_ADDR",
+		)
+	}
+
+	token, err := vaultToken()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the %s", s)
+	}
+
+	endpoint, err := url.JoinPath(address, "v1", s.mount(), "data", strings.Trim(s.Path, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "templateOptions.secretStore: Vault address %q", address)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vaultTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the %s", s)
+	}
+
+	req.Header.Set("X-Vault-Token", token)
+
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := httpclient.New(vaultTimeout).Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the %s", s)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the %s", s)
+	}
+
+	var secret struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+
+	// A failed request answers with its reasons in the same JSON, so
+	// a body that does not parse matters only on success.
+	decodeErr := json.Unmarshal(body, &secret)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Newf("reading the %s: Vault answered %s %s", s, resp.Status, strings.Join(secret.Errors, "; "))
+	}
+
+	if decodeErr != nil {
+		return nil, errors.Wrapf(decodeErr, "parsing the %s", s)
+	}
+
+	return secret.Data.Data, nil
+}
+
+// vaultToken returns the token the vault command line would use.
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		if data, readErr := os.ReadFile(filepath.Join(home, ".vault-token")); readErr == nil {
+			if token := strings.TrimSpace(string(data)); token != "" {
+				return token, nil
+			}
+		}
+	}
+
+	//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+	return "", errors.WithHint(
+		errors.New("no Vault token"),
+		"export VAULT_TOKEN, or log in with vault login",
+	)
+}
+
+// SecretAt returns the scalar at the dotted path ref of a store
+// document, as text.
+func SecretAt(doc map[string]any, ref string) (string, error) {
+	var value any = doc
+
+	for _, key := range strings.Split(ref, ".") {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return "", errors.Newf("%q is not set", ref)
+		}
+
+		if value, ok = fields[key]; !ok {
+			return "", errors.Newf("%q is not set", ref)
+		}
+	}
+
+	switch value.(type) {
+	case nil, map[string]any, []any:
+		return "", errors.Newf("%q is not a single value", ref)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// secretStoreKey identifies a store document read by a render.
+type secretStoreKey struct {
+	store SecretStore
+	root  string
+}
+
+// loadedSecretStores holds the store documents renders read, so the
+// renders of one command read a store once and the command can seal
+// what they resolved out of its output.
+//
+//nolint:gochecknoglobals // process-wide cache of store documents, guarded by loadedSecretStoresMu.
+var (
+	loadedSecretStores   = map[secretStoreKey]map[string]any{}
+	loadedSecretStoresMu sync.Mutex
+)
+
+// LoadedSecretStore returns the document of the store as a render in
+// this process read it. It reports false when no render did, as when
+// the chart references no secret: then none can be in the output.
+func LoadedSecretStore(store SecretStore, root string) (map[string]any, bool) {
+	loadedSecretStoresMu.Lock()
+	defer loadedSecretStoresMu.Unlock()
+
+	doc, ok := loadedSecretStores[secretStoreKey{store, root}]
+
+	return doc, ok
+}
+
+// ForgetSecretStores drops the store documents read so far, so the next
+// render reads its store afresh. A process running several commands,
+// each against its own project, calls it in between.
+func ForgetSecretStores() {
+	loadedSecretStoresMu.Lock()
+	defer loadedSecretStoresMu.Unlock()
+
+	clear(loadedSecretStores)
+}
+
+// loadOnce returns the document of the store, reading it on the first
+// call for the store and root.
+func (s SecretStore) loadOnce(ctx context.Context, root string) (map[string]any, error) {
+	loadedSecretStoresMu.Lock()
+	defer loadedSecretStoresMu.Unlock()
+
+	key := secretStoreKey{s, root}
+	if doc, ok := loadedSecretStores[key]; ok {
+		return doc, nil
+	}
+
+	doc, err := s.Load(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	loadedSecretStores[key] = doc
+
+	return doc, nil
+}
+
+// resolver returns the function resolving `secretRef` references for
+// one render, or nil without a store. The store is read on the first
+// reference, so a chart that makes none renders without it.
+func (s SecretStore) resolver(ctx context.Context, root string) func(string) (string, error) {
+	if s.Provider == "" {
+		return nil
+	}
+
+	return func(ref string) (string, error) {
+		doc, err := s.loadOnce(ctx, root)
+		if err != nil {
+			return "", err
+		}
+
+		value, err := SecretAt(doc, ref)
+		if err != nil {
+			return "", errors.Wrapf(err, "reading the %s", s)
+		}
+
+		return value, nil
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSecretStore_Validate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		store SecretStore
+		wants string
+	}{
+		{"none", SecretStore{}, ""},
+		{"age", SecretStore{Provider: SecretStoreAge, File: "secrets/store.encrypted.yaml"}, ""},
+		{"vault", SecretStore{Provider: SecretStoreVault, Path: "talm/prod"}, ""},
+		{"age without file", SecretStore{Provider: SecretStoreAge}, "the age provider needs a file"},
+		{"sops without file", SecretStore{Provider: SecretStoreSOPS}, "the sops provider needs a file"},
+		{"vault without path", SecretStore{Provider: SecretStoreVault}, "needs the path of a secret"},
+		{"unknown", SecretStore{Provider: "keepass"}, `unknown provider "keepass"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.store.Validate()
+
+			switch {
+			case tc.wants == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wants != "" && (err == nil || !strings.Contains(err.Error(), tc.wants)):
+				t.Errorf("err = %v, want it to mention %q", err, tc.wants)
+			}
+		})
+	}
+}
+
+func TestSecretAt(t *testing.T) {
+	t.Parallel()
+
+	doc := map[string]any{
+		"db":    map[string]any{"password": "s3cret", "port": float64(5432), "hosts": []any{"a"}},
+		"token": "t0ken",
+	}
+
+	for ref, want := range map[string]string{"db.password": "s3cret", "db.port": "5432", "token": "t0ken"} {
+		if got, err := SecretAt(doc, ref); err != nil || got != want {
+			t.Errorf("SecretAt(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+
+	for ref, wants := range map[string]string{
+		"db.user":           `"db.user" is not set`,
+		"token.inner":       `"token.inner" is not set`,
+		"db":                `"db" is not a single value`,
+		"db.hosts":          `"db.hosts" is not a single value`,
+		"missing.key.below": `"missing.key.below" is not set`,
+	} {
+		if _, err := SecretAt(doc, ref); err == nil || !strings.Contains(err.Error(), wants) {
+			t.Errorf("SecretAt(%q) err = %v, want %q", ref, err, wants)
+		}
+	}
+}
+
+func TestSecretStore_LoadAge(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := encryptValuesFileInDir(t, dir, map[string]any{"db": map[string]any{"password": "s3cret"}})
+
+	store := SecretStore{Provider: SecretStoreAge, File: filepath.Base(file)}
+
+	doc, err := store.Load(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := SecretAt(doc, "db.password"); err != nil || got != "s3cret" {
+		t.Errorf("db.password = %q, %v", got, err)
+	}
+}
+
+// TestSecretStore_LoadSOPS runs a stand-in sops that prints a decrypted
+// document for the file it is given.
+func TestSecretStore_LoadSOPS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in sops is a shell script")
+	}
+
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = --decrypt ] || exit 2\necho \"file: $2\"\necho 'db: {password: s3cret}'\n"
+
+	if err := os.WriteFile(filepath.Join(bin, sopsBinary), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin)
+
+	doc, err := SecretStore{Provider: SecretStoreSOPS, File: "store.sops.yaml"}.Load(context.Background(), "/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc["file"] != filepath.Join("/project", "store.sops.yaml") {
+		t.Errorf("sops decrypted %v, want the file resolved against the root", doc["file"])
+	}
+
+	if got, err := SecretAt(doc, "db.password"); err != nil || got != "s3cret" {
+		t.Errorf("db.password = %q, %v", got, err)
+	}
+}
+
+func TestSecretStore_LoadVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+
+			return
+		}
+
+		if r.URL.Path != "/v1/kv/data/talm/prod" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"data":{"data":{"db":{"password":"s3cret"}},"metadata":{"version":3}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "root-token")
+
+	doc, err := SecretStore{Provider: SecretStoreVault, Mount: "kv", Path: "talm/prod"}.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := SecretAt(doc, "db.password"); err != nil || got != "s3cret" {
+		t.Errorf("db.password = %q, %v", got, err)
+	}
+
+	_, err = SecretStore{Provider: SecretStoreVault, Path: "talm/prod"}.Load(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "vault secret secret/talm/prod") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("a missing secret must name it and the status, got %v", err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+
+	_, err = SecretStore{Provider: SecretStoreVault, Mount: "kv", Path: "talm/prod"}.Load(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("a refused token must carry Vault's reason, got %v", err)
+	}
+}
+
+// TestSecretStore_Resolver pins that a render reads the store once, on
+// its first reference, and that the document is then available for
+// sealing the output.
+func TestSecretStore_Resolver(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"data":{"data":{"token":"t0ken"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("VAULT_TOKEN", "root-token")
+
+	store := SecretStore{Provider: SecretStoreVault, Address: server.URL, Path: "talm/resolver"}
+
+	if resolve := (SecretStore{}).resolver(context.Background(), "/project"); resolve != nil {
+		t.Error("no store must leave secretRef without a resolver")
+	}
+
+	resolve := store.resolver(context.Background(), "/project")
+
+	if _, ok := LoadedSecretStore(store, "/project"); ok {
+		t.Fatal("the store must not be read before a reference")
+	}
+
+	for range 2 {
+		if got, err := resolve("token"); err != nil || got != "t0ken" {
+			t.Errorf("token = %q, %v", got, err)
+		}
+	}
+
+	if _, err := resolve("db.password"); err == nil || !strings.Contains(err.Error(), `"db.password" is not set`) {
+		t.Errorf("err = %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("Vault was asked %d times, want once", requests)
+	}
+
+	if doc, ok := LoadedSecretStore(store, "/project"); !ok || doc["token"] != "t0ken" {
+		t.Errorf("loaded store = %v, %v", doc, ok)
+	}
+}
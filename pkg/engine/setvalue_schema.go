@@ -0,0 +1,260 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"helm.sh/helm/v4/pkg/strvals"
+)
+
+// JSON Schema scalar types a `--set` value is coerced to.
+const (
+	schemaTypeBoolean = "boolean"
+	schemaTypeInteger = "integer"
+	schemaTypeNumber  = "number"
+	schemaTypeNull    = "null"
+)
+
+// coerceSetValues retypes the `--set` values whose path the chart's
+// values.schema.json declares as a boolean, integer, number or string.
+// strvals guesses the type from the text alone: `--set token=1234`
+// becomes an integer and `--set nrHugepages=1e3` a string, whatever
+// the chart expects. With a declared type the raw text is parsed as
+// that type instead, and text that is not one fails the render rather
+// than reaching a template as the wrong kind of value.
+//
+// values is the result of loadValues and is changed in place. Paths
+// also set by --set-string, --set-file or --set-literal are left alone:
+// those flags come later and win. Like prompting, only nested
+// `properties` are followed, so list entries (`--set a[0]=x`) and
+// properties without a scalar type keep the strvals typing.
+//
+//nolint:gocritic // hugeParam: Options is passed by value throughout the engine.
+func coerceSetValues(schema []byte, opts Options, values map[string]any) error {
+	if len(schema) == 0 || len(opts.Values) == 0 {
+		return nil
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Wrap(err, "parsing values.schema.json"),
+			"values.schema.json must be a JSON object; validate it with any JSON Schema linter",
+		)
+	}
+
+	raw := map[string]any{}
+
+	for _, value := range opts.Values {
+		if err := strvals.ParseIntoString(value, raw); err != nil {
+			return errors.Wrapf(err, "failed to parse set value '%s'", value)
+		}
+	}
+
+	overridden, err := laterSetPaths(opts)
+	if err != nil {
+		return err
+	}
+
+	var leaves [][]string
+
+	collectStringLeaves(raw, nil, &leaves)
+
+	for _, path := range leaves {
+		if _, set := lookupValuePath(overridden, path); set {
+			continue
+		}
+
+		types := schemaTypesAt(root, path)
+		if len(types) == 0 {
+			continue
+		}
+
+		found, _ := lookupValuePath(raw, path)
+		text, _ := found.(string)
+
+		value, err := coerceSetValue(text, types)
+		if err != nil {
+			key := strings.Join(path, ".")
+
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return errors.WithHintf(
+				errors.Wrapf(err, "--set %s=%s", key, text),
+				"values.schema.json declares %s as %s; pass a value of that type, or fix the schema if the chart takes something else",
+				key, strings.Join(types, " or "),
+			)
+		}
+
+		setValuePath(values, path, value)
+	}
+
+	return nil
+}
+
+// laterSetPaths parses the value flags applied after --set into one
+// map, so coerceSetValues can tell which --set paths they override.
+// Only the keys matter; --set-file values are not read.
+//
+//nolint:gocritic // hugeParam: see coerceSetValues.
+func laterSetPaths(opts Options) (map[string]any, error) {
+	later := map[string]any{}
+
+	for _, value := range append(append([]string(nil), opts.StringValues...), opts.LiteralValues...) {
+		if err := strvals.ParseIntoString(value, later); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse set value '%s'", value)
+		}
+	}
+
+	for _, file := range opts.FileValues {
+		if err := strvals.ParseIntoString(file+"=file", later); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse set-file value '%s'", file)
+		}
+	}
+
+	return later, nil
+}
+
+// collectStringLeaves appends the path of every string leaf under m,
+// in sorted order, descending into nested maps only.
+func collectStringLeaves(m map[string]any, prefix []string, leaves *[][]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := append(append([]string(nil), prefix...), key)
+
+		switch v := m[key].(type) {
+		case map[string]any:
+			collectStringLeaves(v, path, leaves)
+		case string:
+			*leaves = append(*leaves, path)
+		}
+	}
+}
+
+// lookupValuePath returns the value at path and whether it is set.
+func lookupValuePath(values map[string]any, path []string) (any, bool) {
+	var current any = values
+
+	for _, key := range path {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// schemaTypesAt returns the scalar types the schema declares for path,
+// following nested `properties`. The declared `type` may be a name or
+// a list of names; object and array types are dropped, and a path the
+// schema does not describe has no types.
+func schemaTypesAt(root map[string]any, path []string) []string {
+	node := root
+
+	for _, key := range path {
+		properties, _ := node[schemaKeyProperties].(map[string]any)
+
+		child, ok := properties[key].(map[string]any)
+		if !ok {
+			return nil
+		}
+
+		node = child
+	}
+
+	var declared []string
+
+	switch typ := node[schemaKeyType].(type) {
+	case string:
+		declared = []string{typ}
+	case []any:
+		for _, t := range typ {
+			if s, ok := t.(string); ok {
+				declared = append(declared, s)
+			}
+		}
+	}
+
+	var types []string
+
+	for _, t := range declared {
+		switch t {
+		case schemaTypeBoolean, schemaTypeInteger, schemaTypeNumber, schemaTypeString, schemaTypeNull:
+			types = append(types, t)
+		}
+	}
+
+	// A lone null gives nothing to coerce to.
+	if len(types) == 1 && types[0] == schemaTypeNull {
+		return nil
+	}
+
+	return types
+}
+
+// coerceSetValue parses text as the first of types it is valid for,
+// trying null, boolean, integer and number before string, so
+// `["integer", "string"]` still reads `1024` as a number.
+func coerceSetValue(text string, types []string) (any, error) {
+	if slices.Contains(types, schemaTypeNull) && strings.EqualFold(text, "null") {
+		//nolint:nilnil // null is the value the schema allows here.
+		return nil, nil
+	}
+
+	if slices.Contains(types, schemaTypeBoolean) {
+		switch {
+		case strings.EqualFold(text, "true"):
+			return true, nil
+		case strings.EqualFold(text, "false"):
+			return false, nil
+		}
+	}
+
+	if slices.Contains(types, schemaTypeInteger) || slices.Contains(types, schemaTypeNumber) {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+
+	if slices.Contains(types, schemaTypeNumber) {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, nil
+		}
+	}
+
+	if slices.Contains(types, schemaTypeString) {
+		return text, nil
+	}
+
+	return nil, errors.Newf("%q is not %s", text, strings.Join(types, " or "))
+}
@@ -0,0 +1,161 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+const setValueTestSchema = `{
+  "type": "object",
+  "properties": {
+    "token": {"type": "string"},
+    "nrHugepages": {"type": "integer"},
+    "ratio": {"type": "number"},
+    "enabled": {"type": "boolean"},
+    "port": {"type": ["integer", "string"]},
+    "label": {"type": ["string", "null"]},
+    "untyped": {}
+  }
+}`
+
+// TestCoerceSetValues pins that a declared scalar type decides how a
+// --set value is read, in place of the strvals guess, and that paths
+// the schema does not type keep that guess.
+func TestCoerceSetValues(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{Values: []string{
+		"token=1234",
+		"nrHugepages=1024",
+		"ratio=1.5",
+		"enabled=TRUE",
+		"port=8080",
+		"label=null",
+		"untyped=42",
+		"other=7",
+	}}
+
+	values, err := loadValues(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := coerceSetValues([]byte(setValueTestSchema), opts, values); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"token":       "1234",
+		"nrHugepages": int64(1024),
+		"ratio":       1.5,
+		"enabled":     true,
+		"port":        int64(8080),
+		"label":       nil,
+		"untyped":     int64(42),
+		"other":       int64(7),
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %#v, want %#v", values, want)
+	}
+}
+
+// TestCoerceSetValues_Mismatch pins that text that is not the declared
+// type fails naming the flag and the declared type.
+func TestCoerceSetValues_Mismatch(t *testing.T) {
+	t.Parallel()
+
+	for _, set := range []string{"nrHugepages=lots", "enabled=yes", "ratio=fast", "nrHugepages=1.5"} {
+		opts := Options{Values: []string{set}}
+
+		values, err := loadValues(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = coerceSetValues([]byte(setValueTestSchema), opts, values)
+		if err == nil || !strings.Contains(err.Error(), "--set "+set) {
+			t.Errorf("%s: error = %v, want it to name the flag", set, err)
+
+			continue
+		}
+
+		if hints := errors.GetAllHints(err); len(hints) == 0 || !strings.Contains(hints[0], "values.schema.json declares") {
+			t.Errorf("%s: hints = %q, want the declared type", set, hints)
+		}
+	}
+}
+
+// TestCoerceSetValues_LaterFlagsWin pins that --set-string and
+// --set-literal values for the same path are not retyped: they are
+// applied after --set and replace it.
+func TestCoerceSetValues_LaterFlagsWin(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{
+		Values:        []string{"nrHugepages=lots", "enabled=maybe"},
+		StringValues:  []string{"nrHugepages=lots"},
+		LiteralValues: []string{"enabled=maybe"},
+	}
+
+	values, err := loadValues(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := coerceSetValues([]byte(setValueTestSchema), opts, values); err != nil {
+		t.Errorf("an overridden --set must not be checked, got %v", err)
+	}
+}
+
+func TestCoerceSetValues_NoSchema(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]any{"token": int64(1234)}
+
+	if err := coerceSetValues(nil, Options{Values: []string{"token=1234"}}, values); err != nil || values["token"] != int64(1234) {
+		t.Errorf("without a schema values keep the strvals typing, got %v, %v", values, err)
+	}
+
+	if err := coerceSetValues([]byte("["), Options{Values: []string{"token=1234"}}, values); err == nil {
+		t.Error("a malformed schema must fail")
+	}
+}
+
+// TestEffectiveValues_SetValueTypes pins the render path: a --set value
+// reaches the merged values with its declared type.
+func TestEffectiveValues_SetValueTypes(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "config.yaml", "machine:\n  type: worker\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.schema.json"), setValueTestSchema)
+
+	got, err := EffectiveValues(Options{Root: chartRoot, Values: []string{"token=0123", "nrHugepages=1024"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got["token"] != "0123" || got["nrHugepages"] != int64(1024) {
+		t.Errorf("token = %#v, nrHugepages = %#v", got["token"], got["nrHugepages"])
+	}
+
+	_, err = EffectiveValues(Options{Root: chartRoot, Values: []string{"nrHugepages=all"}})
+	if err == nil || !strings.Contains(err.Error(), "--set nrHugepages=all") {
+		t.Errorf("a --set value of the wrong type must fail the render, got %v", err)
+	}
+}
@@ -15,11 +15,12 @@
 package engine
 
 import (
-	"fmt"
 	"io"
 	"os"
 	"regexp"
 	"strings"
+
+	"github.com/cozystack/talm/pkg/ui"
 )
 
 // setValueWarningWriter is the sink for the operator-facing warning
@@ -89,15 +90,14 @@ func splitChainedSetValue(value string) []string {
 }
 
 // emitSetValueCoercionWarning writes the operator-facing warning to
-// setValueWarningWriter for the offending pair. Format matches the
-// rest of the cobra error / hint conventions ("talm:" prefix, one
-// line per warning).
+// setValueWarningWriter for the offending pair, one line per warning
+// with the `Warning: ` prefix every talm warning carries.
 func emitSetValueCoercionWarning(pair string) {
-	fmt.Fprintf(setValueWarningWriter,
-		"talm: --set %s looks like an IP / CIDR / version literal; "+
+	ui.Warnf(setValueWarningWriter,
+		"--set %s looks like an IP / CIDR / version literal; "+
 			"strvals.ParseInto interprets dots as YAML key nesting, "+
 			"so the rendered value will be a nested map. Pass --set-string %s "+
-			"to keep the literal verbatim.\n",
+			"to keep the literal verbatim.",
 		pair, pair,
 	)
 }
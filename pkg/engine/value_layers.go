@@ -0,0 +1,92 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	"helm.sh/helm/v4/pkg/chart/v2/loader"
+)
+
+// SetValuesLayer names the layer of the --set* sources in ValueLayers.
+const SetValuesLayer = "--set"
+
+// ValueLayer is one source of the values a render merges.
+type ValueLayer struct {
+	// Name is the file the layer was read from, or SetValuesLayer.
+	Name   string
+	Values map[string]any
+}
+
+// ValueLayers returns the layers EffectiveValues merges, in merge
+// order: the chart's values.yaml, every ValueFiles entry, then the
+// --set* sources as one layer when there are any. With
+// opts.ValuesLock set it is the lock file alone. Telling which layer a
+// value of the merge came from is up to the caller: the last layer
+// carrying it set it.
+//
+//nolint:gocritic // hugeParam: see EffectiveValues.
+func ValueLayers(opts Options) ([]ValueLayer, error) {
+	chartPath := opts.Root
+	if chartPath == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, errors.Wrap(err, "resolving working directory")
+		}
+
+		chartPath = wd
+	}
+
+	if opts.ValuesLock != "" {
+		locked, err := loadValueFile(opts.Root, opts.ValuesLock)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading the values lock")
+		}
+
+		return []ValueLayer{{Name: opts.ValuesLock, Values: locked}}, nil
+	}
+
+	chrt, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading chart from %q", chartPath)
+	}
+
+	layers := []ValueLayer{{Name: filepath.Join(chartPath, "values.yaml"), Values: chrt.Values}}
+
+	for _, file := range opts.ValueFiles {
+		values, err := loadValueFile(opts.Root, file)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, ValueLayer{Name: file, Values: values})
+	}
+
+	if len(opts.Values)+len(opts.StringValues)+len(opts.FileValues)+len(opts.JsonValues)+len(opts.LiteralValues) == 0 {
+		return layers, nil
+	}
+
+	setOpts := opts
+	setOpts.ValueFiles = nil
+
+	values, err := loadValues(setOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, ValueLayer{Name: SetValuesLayer, Values: values}), nil
+}
@@ -0,0 +1,73 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValueLayers(t *testing.T) {
+	t.Parallel()
+
+	root := createTestChart(t, "tc", "config.yaml", "machine: {}\n")
+	if err := os.WriteFile(filepath.Join(root, "values.yaml"), []byte("endpoint: https://192.0.2.1:6443\nmtu: 1500\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	prod := filepath.Join(root, "values-prod.yaml")
+	if err := os.WriteFile(prod, []byte("mtu: 9000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := ValueLayers(Options{Root: root, ValueFiles: []string{prod}, StringValues: []string{"floatingIP=192.0.2.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ValueLayer{
+		{Name: filepath.Join(root, "values.yaml"), Values: map[string]any{"endpoint": "https://192.0.2.1:6443", "mtu": float64(1500)}},
+		{Name: prod, Values: map[string]any{"mtu": 9000}},
+		{Name: SetValuesLayer, Values: map[string]any{"floatingIP": "192.0.2.5"}},
+	}
+	if !reflect.DeepEqual(layers, want) {
+		t.Errorf("layers =\n%#v\nwant\n%#v", layers, want)
+	}
+
+	layers, err = ValueLayers(Options{Root: root})
+	if err != nil || len(layers) != 1 {
+		t.Errorf("without value files and --set: layers = %v, err = %v", layers, err)
+	}
+}
+
+func TestValueLayers_Lock(t *testing.T) {
+	t.Parallel()
+
+	lock := filepath.Join(t.TempDir(), "values.lock.yaml")
+	if err := os.WriteFile(lock, []byte("mtu: 9000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := ValueLayers(Options{Root: t.TempDir(), ValuesLock: lock, Values: []string{"mtu=1500"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []ValueLayer{{Name: lock, Values: map[string]any{"mtu": 9000}}}; !reflect.DeepEqual(layers, want) {
+		t.Errorf("layers = %#v, want the lock alone", layers)
+	}
+}
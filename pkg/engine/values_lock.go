@@ -0,0 +1,101 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	"helm.sh/helm/v4/pkg/chart/v2/loader"
+)
+
+// EffectiveValues returns the .Values a render with opts would see: the
+// chart's values.yaml with ValueFiles and the --set* sources merged on
+// top, merge markers stripped and prompt-on-missing values asked for.
+// With opts.ValuesLock set it is the content of the lock file. `talm
+// snapshot values` writes this map to a lock file so a later render can
+// reproduce it even after the chart defaults change.
+//
+//nolint:gocritic // hugeParam: Options is the public configuration carrier, passed by value like Render.
+func EffectiveValues(opts Options) (map[string]any, error) {
+	chartPath := opts.Root
+	if chartPath == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, errors.Wrap(err, "resolving working directory")
+		}
+
+		chartPath = wd
+	}
+
+	chrt, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading chart from %q", chartPath)
+	}
+
+	if err := ValidateMergeRules(opts.MergeRules); err != nil {
+		return nil, err
+	}
+
+	return effectiveValues(chrt, chartPath, opts)
+}
+
+// effectiveValues merges the value layers of opts onto the values of
+// chrt loaded from chartPath, or reads opts.ValuesLock instead. A lock
+// is taken verbatim: it was written from a complete merge, so neither
+// the current chart defaults nor prompting apply to it.
+//
+//nolint:gocritic // hugeParam: see EffectiveValues.
+func effectiveValues(chrt *chart.Chart, chartPath string, opts Options) (map[string]any, error) {
+	if opts.ValuesLock != "" {
+		locked, err := cachedValueFile(opts.Root, opts.ValuesLock)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading the values lock")
+		}
+
+		if err := checkDeprecatedValues(chrt.Schema, locked, opts.StrictDeprecations); err != nil {
+			return nil, err
+		}
+
+		return locked, nil
+	}
+
+	if err := checkMergeMarkers(chrt.Values, filepath.Join(chartPath, "values.yaml")); err != nil {
+		return nil, err
+	}
+
+	values, err := loadValues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := coerceSetValues(chrt.Schema, opts, values); err != nil {
+		return nil, err
+	}
+
+	merged, _ := stripMergeMarkers(mergeValues(chrt.Values, values, opts.MergeRules)).(map[string]any)
+
+	if err := checkDeprecatedValues(chrt.Schema, merged, opts.StrictDeprecations); err != nil {
+		return nil, err
+	}
+
+	if err := promptMissingValues(chrt.Schema, merged, opts.Prompt); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
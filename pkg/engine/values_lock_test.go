@@ -0,0 +1,113 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveValues(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "config.yaml", "machine:\n  type: worker\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.yaml"), "endpoint: https://192.0.2.1:6443\nimage: installer:v1\nnodes: {}\n")
+	writeTestFile(t, filepath.Join(chartRoot, "prod.yaml"), "image: installer:v2\n")
+
+	got, err := EffectiveValues(Options{
+		Root:         chartRoot,
+		ValueFiles:   []string{filepath.Join(chartRoot, "prod.yaml")},
+		StringValues: []string{"floatingIP=192.0.2.100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"endpoint":   "https://192.0.2.1:6443",
+		"image":      "installer:v2",
+		"nodes":      map[string]any{},
+		"floatingIP": "192.0.2.100",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EffectiveValues = %v, want %v", got, want)
+	}
+}
+
+// TestEffectiveValues_Lock pins that a values lock replaces every
+// other layer, so a changed chart default does not leak into a render
+// of an older release.
+func TestEffectiveValues_Lock(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "config.yaml", "machine:\n  type: worker\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.yaml"), "image: installer:v3\nadded: later\n")
+
+	lock := filepath.Join(chartRoot, "releases", "v1", "values.lock.yaml")
+	writeTestFile(t, lock, "image: installer:v1\n")
+
+	got, err := EffectiveValues(Options{
+		Root:       chartRoot,
+		ValuesLock: lock,
+		Values:     []string{"image=ignored"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, map[string]any{"image": "installer:v1"}) {
+		t.Errorf("EffectiveValues = %v", got)
+	}
+
+	_, err = EffectiveValues(Options{Root: chartRoot, ValuesLock: filepath.Join(chartRoot, "missing.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "values lock") {
+		t.Errorf("a missing lock must fail naming the lock, got %v", err)
+	}
+}
+
+// TestRender_ValuesLock pins that Render feeds the lock to the chart.
+func TestRender_ValuesLock(t *testing.T) {
+	chartRoot := createTestChart(t, "tc", "config.yaml", "machine:\n  type: worker\n  install:\n    image: {{ .Values.image }}\n")
+	writeTestFile(t, filepath.Join(chartRoot, "values.yaml"), "image: installer:v3\n")
+
+	lock := filepath.Join(chartRoot, "values.lock.yaml")
+	writeTestFile(t, lock, "image: installer:v1\n")
+
+	out, err := Render(context.Background(), nil, Options{
+		Offline:       true,
+		Root:          chartRoot,
+		TemplateFiles: []string{"templates/config.yaml"},
+		ValuesLock:    lock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), "installer:v1") || strings.Contains(string(out), "installer:v3") {
+		t.Errorf("render must use the locked image:\n%s", out)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extensions resolves official Talos system extensions, named
+// the way the Image Factory names them (siderolabs/drbd), into the
+// installer image that carries them and the machine config they need.
+// The schematic ID is computed offline, the same way the Image Factory
+// computes it, and a vendored catalog holds what the factory metadata
+// does not say: the kernel modules and sysctls an extension needs and
+// the Talos releases that ship it. The chart engine exposes Resolve to
+// templates as `talosExtensions`.
+package extensions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// FactoryHost serves the installer images built from a schematic.
+const FactoryHost = "factory.talos.dev"
+
+// factorySince is the first Talos release the Image Factory builds
+// installer images for.
+const factorySince = "v1.5.0"
+
+// KernelModule is a machine.kernel.modules entry an extension needs.
+type KernelModule struct {
+	Name       string
+	Parameters []string
+}
+
+// Extension is a catalog entry.
+type Extension struct {
+	// Name is the official extension name, as the Image Factory lists it.
+	Name string
+	// Since is the first Talos release that ships the extension; empty
+	// means every release the Image Factory serves.
+	Since string
+	// Until is the first Talos release that no longer ships it; empty
+	// means it is still shipped.
+	Until string
+	// Requires lists extensions of which at least one must be installed
+	// alongside, such as a driver for a container toolkit.
+	Requires []string
+	// KernelModules and Sysctls are the machine config the extension
+	// needs to be of any use.
+	KernelModules []KernelModule
+	Sysctls       map[string]string
+}
+
+//nolint:gochecknoglobals // shared by the NVIDIA driver catalog entries.
+var nvidiaModules = []KernelModule{
+	{Name: "nvidia"},
+	{Name: "nvidia_uvm"},
+	{Name: "nvidia_drm"},
+	{Name: "nvidia_modeset"},
+}
+
+//nolint:gochecknoglobals // shared by the NVIDIA toolkit catalog entries.
+var nvidiaSysctls = map[string]string{"net.core.bpf_jit_harden": "1"}
+
+// catalog is the vendored list of official extensions talm knows.
+// Extensions that only ship firmware or binaries need no entry fields
+// beyond the name.
+//
+//nolint:gochecknoglobals // vendored catalog, read-only.
+var catalog = []Extension{
+	{Name: "siderolabs/amd-ucode"},
+	{Name: "siderolabs/bnx2-bnx2x"},
+	{Name: "siderolabs/drbd", KernelModules: []KernelModule{
+		{Name: "drbd", Parameters: []string{"usermode_helper=disabled"}},
+		{Name: "drbd_transport_tcp"},
+	}},
+	{Name: "siderolabs/gvisor"},
+	{Name: "siderolabs/intel-ice-firmware"},
+	{Name: "siderolabs/intel-ucode"},
+	{Name: "siderolabs/iscsi-tools"},
+	{Name: "siderolabs/nvidia-container-toolkit", Until: "v1.8.0", Sysctls: nvidiaSysctls,
+		Requires: []string{"siderolabs/nvidia-open-gpu-kernel-modules", "siderolabs/nonfree-kmod-nvidia"}},
+	{Name: "siderolabs/nvidia-container-toolkit-lts", Since: "v1.8.0", Sysctls: nvidiaSysctls,
+		Requires: []string{"siderolabs/nvidia-open-gpu-kernel-modules-lts", "siderolabs/nonfree-kmod-nvidia-lts"}},
+	{Name: "siderolabs/nvidia-container-toolkit-production", Since: "v1.8.0", Sysctls: nvidiaSysctls,
+		Requires: []string{"siderolabs/nvidia-open-gpu-kernel-modules-production", "siderolabs/nonfree-kmod-nvidia-production"}},
+	{Name: "siderolabs/nonfree-kmod-nvidia", Until: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/nonfree-kmod-nvidia-lts", Since: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/nonfree-kmod-nvidia-production", Since: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/nvidia-open-gpu-kernel-modules", Until: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/nvidia-open-gpu-kernel-modules-lts", Since: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/nvidia-open-gpu-kernel-modules-production", Since: "v1.8.0", KernelModules: nvidiaModules},
+	{Name: "siderolabs/qemu-guest-agent"},
+	{Name: "siderolabs/realtek-firmware"},
+	{Name: "siderolabs/spin"},
+	{Name: "siderolabs/thunderbolt"},
+	{Name: "siderolabs/util-linux-tools"},
+	{Name: "siderolabs/vmtoolsd-guest-agent"},
+	{Name: "siderolabs/wasmedge"},
+	{Name: "siderolabs/zfs", KernelModules: []KernelModule{{Name: "zfs"}}},
+}
+
+// Names returns the names of the extensions in the catalog, sorted.
+func Names() []string {
+	names := make([]string, 0, len(catalog))
+	for _, ext := range catalog {
+		names = append(names, ext.Name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Lookup returns the catalog entry for name.
+func Lookup(name string) (Extension, bool) {
+	for _, ext := range catalog {
+		if ext.Name == name {
+			return ext, true
+		}
+	}
+
+	return Extension{}, false
+}
+
+// Resolved is the result of Resolve.
+type Resolved struct {
+	// Extensions are the requested names, sorted and deduplicated.
+	Extensions []string
+	// Schematic is the Image Factory schematic, as YAML, and
+	// SchematicID its ID.
+	Schematic   string
+	SchematicID string
+	// Image is the installer image for the Talos release.
+	Image string
+	// KernelModules and Sysctls are what the extensions need, merged.
+	KernelModules []KernelModule
+	Sysctls       map[string]string
+}
+
+// Resolve validates names against the catalog and talosVersion, and
+// returns the installer image and machine config they add up to.
+// talosVersion must be a full release (v1.12.1): it is the installer
+// image tag, and it picks the extension versions the factory builds
+// in. The names are sorted first, so the same set always gives the
+// same schematic ID.
+func Resolve(talosVersion string, names []string) (*Resolved, error) {
+	version, err := parseVersion(talosVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if version.less(mustParseVersion(factorySince)) {
+		return nil, errors.Newf("the Image Factory builds no installer for Talos %s; it starts at %s", version, factorySince)
+	}
+
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	res := &Resolved{Extensions: names, Sysctls: map[string]string{}}
+
+	for _, name := range names {
+		ext, ok := Lookup(name)
+		if !ok {
+			//nolint:wrapcheck // cockroachdb/errors.WithHintf at boundary.
+			return nil, errors.WithHintf(
+				errors.Newf("unknown Talos extension %q", name),
+				"known extensions: %s", strings.Join(Names(), ", "),
+			)
+		}
+
+		if ext.Since != "" && version.less(mustParseVersion(ext.Since)) {
+			return nil, errors.Newf("extension %s needs Talos %s or later, the cluster runs %s", name, ext.Since, version)
+		}
+
+		if ext.Until != "" && !version.less(mustParseVersion(ext.Until)) {
+			return nil, errors.Newf("extension %s is not shipped since Talos %s, the cluster runs %s", name, ext.Until, version)
+		}
+
+		if len(ext.Requires) > 0 && !slices.ContainsFunc(ext.Requires, func(req string) bool { return slices.Contains(names, req) }) {
+			return nil, errors.Newf("extension %s needs one of %s alongside", name, strings.Join(ext.Requires, ", "))
+		}
+
+		for _, mod := range ext.KernelModules {
+			if !slices.ContainsFunc(res.KernelModules, func(m KernelModule) bool { return m.Name == mod.Name }) {
+				res.KernelModules = append(res.KernelModules, mod)
+			}
+		}
+
+		for key, value := range ext.Sysctls {
+			res.Sysctls[key] = value
+		}
+	}
+
+	res.Schematic = Schematic(names)
+
+	sum := sha256.Sum256([]byte(res.Schematic))
+	res.SchematicID = hex.EncodeToString(sum[:])
+	res.Image = FactoryHost + "/installer/" + res.SchematicID + ":" + version.String()
+
+	return res, nil
+}
+
+// Schematic returns the Image Factory schematic installing names, in
+// the exact form the factory hashes into the schematic ID: the
+// yaml.v3 encoding with its default four-space indent.
+func Schematic(names []string) string {
+	if len(names) == 0 {
+		return "customization: {}\n"
+	}
+
+	var b strings.Builder
+
+	b.WriteString("customization:\n    systemExtensions:\n        officialExtensions:\n")
+
+	for _, name := range names {
+		b.WriteString("            - " + name + "\n")
+	}
+
+	return b.String()
+}
+
+// TemplateValues returns res in the shape templates see: lowercase
+// keys and generic slices and maps, so sprig's list and dict functions
+// apply, and kernel modules in the machine.kernel.modules form.
+func TemplateValues(res *Resolved) map[string]any {
+	extensions := make([]any, 0, len(res.Extensions))
+	for _, name := range res.Extensions {
+		extensions = append(extensions, name)
+	}
+
+	modules := make([]any, 0, len(res.KernelModules))
+
+	for _, mod := range res.KernelModules {
+		entry := map[string]any{"name": mod.Name}
+
+		if len(mod.Parameters) > 0 {
+			params := make([]any, 0, len(mod.Parameters))
+			for _, p := range mod.Parameters {
+				params = append(params, p)
+			}
+
+			entry["parameters"] = params
+		}
+
+		modules = append(modules, entry)
+	}
+
+	sysctls := make(map[string]any, len(res.Sysctls))
+	for key, value := range res.Sysctls {
+		sysctls[key] = value
+	}
+
+	return map[string]any{
+		"extensions":    extensions,
+		"schematic":     res.Schematic,
+		"schematicID":   res.SchematicID,
+		"image":         res.Image,
+		"kernelModules": modules,
+		"sysctls":       sysctls,
+	}
+}
+
+// version is a Talos release, vMAJOR.MINOR.PATCH with an optional
+// pre-release suffix.
+type version struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseVersion(s string) (version, error) {
+	raw := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	core, pre, _ := strings.Cut(s, "-")
+	parts := strings.Split(core, ".")
+
+	if raw == "" || len(parts) != 3 {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return version{}, errors.WithHint(
+			errors.Newf("Talos extensions need a full Talos release to pick the installer image, got %q", raw),
+			"set templateOptions.talosVersion in Chart.yaml, or pass --talos-version, to a release such as v1.12.1",
+		)
+	}
+
+	var nums [3]int
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version{}, errors.Newf("invalid Talos version %q", raw)
+		}
+
+		nums[i] = n
+	}
+
+	return version{major: nums[0], minor: nums[1], patch: nums[2], pre: pre}, nil
+}
+
+func mustParseVersion(s string) version {
+	v, err := parseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// less compares releases. It ignores the pre-release suffix, so a
+// v1.8.0-beta.0 cluster already ships what the catalog says v1.8.0 does.
+func (v version) less(o version) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+
+	return v.patch < o.patch
+}
+
+func (v version) String() string {
+	s := "v" + strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+
+	return s
+}
@@ -0,0 +1,135 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extensions_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/extensions"
+)
+
+// TestResolve_SchematicID pins the offline schematic IDs to the ones
+// factory.talos.dev returns for the same schematics.
+func TestResolve_SchematicID(t *testing.T) {
+	t.Parallel()
+
+	for want, names := range map[string][]string{
+		"376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba": nil,
+		"613e1592b2da41ae5e265e8789429f22e121aab91cb4deb6bc3c0b6262961245": {"siderolabs/util-linux-tools", "siderolabs/iscsi-tools"},
+		"ce4c980550dd2ab1b17bbf2b08801c7eb59418eafe8f279833297925d67c7515": {"siderolabs/qemu-guest-agent"},
+	} {
+		res, err := extensions.Resolve("v1.12.1", names)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.SchematicID != want {
+			t.Errorf("schematic ID of %v = %s, want %s", names, res.SchematicID, want)
+		}
+
+		if res.Image != "factory.talos.dev/installer/"+want+":v1.12.1" {
+			t.Errorf("image = %s", res.Image)
+		}
+	}
+}
+
+func TestResolve_MachineConfig(t *testing.T) {
+	t.Parallel()
+
+	res, err := extensions.Resolve("1.12.1", []string{
+		"siderolabs/zfs", "siderolabs/drbd", "siderolabs/zfs",
+		"siderolabs/nvidia-container-toolkit-production", "siderolabs/nvidia-open-gpu-kernel-modules-production",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var modules []string
+	for _, mod := range res.KernelModules {
+		modules = append(modules, mod.Name)
+	}
+
+	want := []string{"drbd", "drbd_transport_tcp", "nvidia", "nvidia_uvm", "nvidia_drm", "nvidia_modeset", "zfs"}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("kernel modules = %v, want %v", modules, want)
+	}
+
+	if !reflect.DeepEqual(res.KernelModules[0].Parameters, []string{"usermode_helper=disabled"}) {
+		t.Errorf("drbd parameters = %v", res.KernelModules[0].Parameters)
+	}
+
+	if res.Sysctls["net.core.bpf_jit_harden"] != "1" {
+		t.Errorf("sysctls = %v", res.Sysctls)
+	}
+
+	if len(res.Extensions) != 4 || !strings.Contains(res.Schematic, "            - siderolabs/drbd\n") {
+		t.Errorf("extensions = %v, schematic:\n%s", res.Extensions, res.Schematic)
+	}
+}
+
+func TestResolve_Rejects(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		version string
+		names   []string
+		want    string
+	}{
+		{"v1.12", []string{"siderolabs/zfs"}, "full Talos release"},
+		{"", []string{"siderolabs/zfs"}, "full Talos release"},
+		{"v1.4.8", []string{"siderolabs/zfs"}, "starts at v1.5.0"},
+		{"v1.12.1", []string{"siderolabs/zfz"}, `unknown Talos extension "siderolabs/zfz"`},
+		{"v1.7.6", []string{"siderolabs/nvidia-open-gpu-kernel-modules-lts"}, "needs Talos v1.8.0 or later"},
+		{"v1.8.0-beta.0", []string{"siderolabs/nvidia-open-gpu-kernel-modules"}, "not shipped since Talos v1.8.0"},
+		{"v1.12.1", []string{"siderolabs/nvidia-container-toolkit-lts"}, "needs one of"},
+	} {
+		_, err := extensions.Resolve(tc.version, tc.names)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Resolve(%q, %v) = %v, want an error containing %q", tc.version, tc.names, err, tc.want)
+		}
+	}
+
+	_, err := extensions.Resolve("v1.12.1", []string{"siderolabs/zfz"})
+	if !strings.Contains(strings.Join(errors.GetAllHints(err), " "), "siderolabs/zfs") {
+		t.Errorf("the hint must list the known extensions: %v", errors.GetAllHints(err))
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	t.Parallel()
+
+	res, err := extensions.Resolve("v1.12.1", []string{"siderolabs/drbd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extensions.TemplateValues(res)
+
+	wantModules := []any{
+		map[string]any{"name": "drbd", "parameters": []any{"usermode_helper=disabled"}},
+		map[string]any{"name": "drbd_transport_tcp"},
+	}
+	if !reflect.DeepEqual(got["kernelModules"], wantModules) {
+		t.Errorf("kernelModules = %#v", got["kernelModules"])
+	}
+
+	if got["image"] != res.Image || got["schematicID"] != res.SchematicID {
+		t.Errorf("values = %v", got)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpclient builds the HTTP clients of every outbound fetch
+// talm makes: registries, object stores, Vault, NetBox, GitHub keys
+// and the Kubernetes API. All of them go through HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY, and trust the project CA bundle
+// (Chart.yaml globalOptions.caBundle) on top of the system roots, so a
+// network that intercepts TLS with a corporate CA does not break them.
+//
+// The bundle is process-wide: SetCABundle is called once Chart.yaml is
+// loaded, before any client is built.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+//nolint:gochecknoglobals // process-wide CA bundle, set once from Chart.yaml.
+var bundle struct {
+	sync.RWMutex
+
+	pem  []byte
+	pool *x509.CertPool
+}
+
+// SetCABundle makes every client built afterwards trust the PEM
+// certificates in path besides the system roots. An empty path goes
+// back to the system roots alone.
+func SetCABundle(path string) error {
+	bundle.Lock()
+	defer bundle.Unlock()
+
+	if path == "" {
+		bundle.pem, bundle.pool = nil, nil
+
+		return nil
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading the CA bundle")
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return errors.WithHint(
+			errors.Newf("the CA bundle %s holds no PEM certificate", path),
+			"point globalOptions.caBundle at a file of -----BEGIN CERTIFICATE----- blocks, such as the corporate root CA exported from the browser",
+		)
+	}
+
+	bundle.pem, bundle.pool = pem, pool
+
+	return nil
+}
+
+// CABundle returns the PEM certificates SetCABundle loaded, nil when
+// there are none, for clients that take a PEM rather than a transport.
+func CABundle() []byte {
+	bundle.RLock()
+	defer bundle.RUnlock()
+
+	return bundle.pem
+}
+
+// Transport returns a new transport with the defaults of net/http,
+// the proxy from the environment and the CA bundle trusted.
+func Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // net/http guarantees the type.
+	transport.Proxy = http.ProxyFromEnvironment
+
+	bundle.RLock()
+	defer bundle.RUnlock()
+
+	if bundle.pool != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: bundle.pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return transport
+}
+
+// New returns a client over Transport whose requests time out after
+// timeout; zero leaves them to the caller's context.
+func New(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport()}
+}
@@ -0,0 +1,121 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeServerCA writes the certificate of server as a PEM bundle.
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// resetCABundle goes back to the system roots after the test.
+func resetCABundle(t *testing.T) {
+	t.Helper()
+
+	t.Cleanup(func() { _ = SetCABundle("") })
+}
+
+// TestNew_TrustsCABundle pins that a server signed by the bundle is
+// trusted once the bundle is set, and not before.
+func TestNew_TrustsCABundle(t *testing.T) {
+	resetCABundle(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if resp, err := New(5 * time.Second).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("a server outside the system roots was trusted without a bundle")
+	}
+
+	path := writeServerCA(t, server)
+	if err := SetCABundle(path); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := New(5 * time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("the bundle was not trusted: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+
+	if !strings.Contains(string(CABundle()), "BEGIN CERTIFICATE") {
+		t.Errorf("CABundle() = %q", CABundle())
+	}
+
+	if err := SetCABundle(""); err != nil || CABundle() != nil || (Transport().TLSClientConfig != nil && Transport().TLSClientConfig.RootCAs != nil) {
+		t.Errorf("an empty path must go back to the system roots: %v", err)
+	}
+}
+
+// TestSetCABundle_Errors pins the errors of a missing bundle and of a
+// file without certificates, which leave the bundle as it was.
+func TestSetCABundle_Errors(t *testing.T) {
+	resetCABundle(t)
+
+	if err := SetCABundle(filepath.Join(t.TempDir(), "missing.pem")); err == nil || !strings.Contains(err.Error(), "reading the CA bundle") {
+		t.Errorf("missing file: err = %v", err)
+	}
+
+	junk := filepath.Join(t.TempDir(), "junk.pem")
+	if err := os.WriteFile(junk, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetCABundle(junk); err == nil || !strings.Contains(err.Error(), "holds no PEM certificate") {
+		t.Errorf("junk file: err = %v", err)
+	}
+
+	if CABundle() != nil {
+		t.Error("a failed load changed the bundle")
+	}
+}
+
+// TestTransport_Proxy pins that the transport takes the proxy from
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY. net/http reads them once per
+// process, so the function is compared rather than the proxy it picks.
+func TestTransport_Proxy(t *testing.T) {
+	t.Parallel()
+
+	if got, want := reflect.ValueOf(Transport().Proxy).Pointer(), reflect.ValueOf(http.ProxyFromEnvironment).Pointer(); got != want {
+		t.Error("the transport does not take the proxy from the environment")
+	}
+}
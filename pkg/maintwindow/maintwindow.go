@@ -0,0 +1,329 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maintwindow evaluates the maintenance windows declared for
+// nodes. A window opens at every minute its cron schedule matches, in
+// its own time zone, and stays open for a fixed duration. talm refuses
+// reboot-requiring commands on a node outside all of its windows; this
+// package answers whether a window is open and when the next one opens.
+package maintwindow
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	// Windows name IANA zones; the embedded database makes them
+	// resolve the same way on hosts without /usr/share/zoneinfo.
+	_ "time/tzdata"
+
+	"github.com/cockroachdb/errors"
+)
+
+// MaxDuration bounds how long a window stays open. Longer windows are
+// better written as a schedule that matches more often.
+const MaxDuration = 7 * 24 * time.Hour
+
+// horizon is how far ahead Next looks for the next opening.
+const horizon = 366 * 24 * time.Hour
+
+// Indexes of the five cron fields.
+const (
+	fieldMinute = iota
+	fieldHour
+	fieldDayOfMonth
+	fieldMonth
+	fieldDayOfWeek
+	fieldCount
+)
+
+//nolint:gochecknoglobals // immutable lookup table.
+var fieldBounds = [fieldCount]struct {
+	name     string
+	min, max int
+	names    []string
+}{
+	fieldMinute:     {name: "minute", min: 0, max: 59},
+	fieldHour:       {name: "hour", min: 0, max: 23},
+	fieldDayOfMonth: {name: "day of month", min: 1, max: 31},
+	fieldMonth: {name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+	}},
+	// 7 is accepted for Sunday, as in most crons, and folded onto 0.
+	fieldDayOfWeek: {name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat",
+	}},
+}
+
+// Window is one parsed maintenance window.
+type Window struct {
+	// Schedule is the five-field cron expression the window opens on:
+	// minute, hour, day of month, month, day of week.
+	Schedule string
+	// Duration is how long the window stays open after each start.
+	Duration time.Duration
+	// Location is the time zone the schedule is read in.
+	Location *time.Location
+
+	fields [fieldCount]uint64
+	// anyDayOfMonth and anyDayOfWeek record which day fields were
+	// `*`. When both are restricted a day matches either, as in cron.
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// Parse reads a window from its cron schedule, a Go duration such as
+// `4h` and an IANA time zone name; an empty zone is UTC.
+func Parse(schedule, duration, timezone string) (Window, error) {
+	w := Window{Schedule: schedule, Location: time.UTC}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != fieldCount {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return Window{}, errors.WithHint(
+			errors.Newf("schedule %q has %d fields, want 5", schedule, len(fields)),
+			"write the schedule as `minute hour day-of-month month day-of-week`, e.g. `0 2 * * sat` for every Saturday at 02:00",
+		)
+	}
+
+	for i, field := range fields {
+		bits, err := parseField(field, i)
+		if err != nil {
+			return Window{}, errors.Wrapf(err, "schedule %q", schedule)
+		}
+
+		w.fields[i] = bits
+	}
+
+	// Sunday is 0 and 7.
+	if w.fields[fieldDayOfWeek]&(1<<7) != 0 {
+		w.fields[fieldDayOfWeek] |= 1
+	}
+
+	w.anyDayOfMonth = strings.HasPrefix(fields[fieldDayOfMonth], "*")
+	w.anyDayOfWeek = strings.HasPrefix(fields[fieldDayOfWeek], "*")
+
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return Window{}, errors.WithHint(
+			errors.Wrapf(err, "duration %q", duration),
+			"write the duration as a Go duration, e.g. 90m or 4h",
+		)
+	}
+
+	if d <= 0 || d > MaxDuration {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return Window{}, errors.WithHintf(
+			errors.Newf("duration %s is out of range", d),
+			"a window lasts more than zero and at most %s; for longer ones, make the schedule match more often", MaxDuration,
+		)
+	}
+
+	w.Duration = d
+
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return Window{}, errors.WithHint(
+				errors.Wrapf(err, "time zone %q", timezone),
+				"use an IANA time zone name such as Europe/Berlin or UTC",
+			)
+		}
+
+		w.Location = loc
+	}
+
+	return w, nil
+}
+
+// parseField reads one cron field into a bit set of the values it
+// matches: a comma-separated list of `*`, a value, or a range `a-b`,
+// each optionally stepped with `/n`.
+func parseField(field string, index int) (uint64, error) {
+	bounds := fieldBounds[index]
+
+	var bits uint64
+
+	for item := range strings.SplitSeq(field, ",") {
+		rangePart, stepPart, stepped := strings.Cut(item, "/")
+
+		step := 1
+
+		if stepped {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, errors.Newf("%s field %q: step %q is not a positive number", bounds.name, field, stepPart)
+			}
+
+			step = n
+		}
+
+		lo, hi := bounds.min, bounds.max
+
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+
+			if lo, err = parseValue(from, index); err != nil {
+				return 0, errors.Wrapf(err, "%s field %q", bounds.name, field)
+			}
+
+			hi = lo
+
+			switch {
+			case isRange:
+				if hi, err = parseValue(to, index); err != nil {
+					return 0, errors.Wrapf(err, "%s field %q", bounds.name, field)
+				}
+			case stepped:
+				// `a/n` runs from a to the end of the field.
+				hi = bounds.max
+			}
+
+			if hi < lo {
+				return 0, errors.Newf("%s field %q: range %s runs backwards", bounds.name, field, rangePart)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// parseValue reads a number or, for months and weekdays, a
+// three-letter English name.
+func parseValue(s string, index int) (int, error) {
+	bounds := fieldBounds[index]
+
+	for i, name := range bounds.names {
+		if strings.EqualFold(s, name) {
+			return i + bounds.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Newf("%q is not a %s", s, bounds.name)
+	}
+
+	if v < bounds.min || v > bounds.max {
+		return 0, errors.Newf("%d is out of range %d-%d", v, bounds.min, bounds.max)
+	}
+
+	return v, nil
+}
+
+// starts reports whether the window opens at the minute of t.
+func (w Window) starts(t time.Time) bool {
+	t = t.In(w.Location)
+
+	if !w.has(fieldMinute, t.Minute()) || !w.has(fieldHour, t.Hour()) || !w.has(fieldMonth, int(t.Month())) {
+		return false
+	}
+
+	dom, dow := w.has(fieldDayOfMonth, t.Day()), w.has(fieldDayOfWeek, int(t.Weekday()))
+
+	if w.anyDayOfMonth || w.anyDayOfWeek {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+func (w Window) has(field, v int) bool {
+	return w.fields[field]&(1<<v) != 0
+}
+
+// OpenUntil reports whether the window is open at now and, if so, when
+// it closes. Overlapping openings extend each other's close.
+func (w Window) OpenUntil(now time.Time) (time.Time, bool) {
+	for start := now.Truncate(time.Minute); now.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.starts(start) {
+			return start.Add(w.Duration), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Next returns the first opening after now, within a year. ok is
+// false for a schedule that does not match in that time, such as
+// February 30th.
+func (w Window) Next(now time.Time) (time.Time, bool) {
+	for start := now.Truncate(time.Minute).Add(time.Minute); start.Sub(now) <= horizon; start = start.Add(time.Minute) {
+		if w.starts(start) {
+			return start, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// String renders the window as `<schedule> for <duration> (<zone>)`.
+func (w Window) String() string {
+	return strings.Join(strings.Fields(w.Schedule), " ") + " for " + formatDuration(w.Duration) + " (" + w.Location.String() + ")"
+}
+
+// formatDuration drops the zero units time.Duration.String prints, so
+// 4h reads 4h rather than 4h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+// OpenUntil reports whether any of windows is open at now and, if so,
+// the latest close among the open ones.
+func OpenUntil(windows []Window, now time.Time) (time.Time, bool) {
+	var (
+		until time.Time
+		open  bool
+	)
+
+	for _, w := range windows {
+		if end, ok := w.OpenUntil(now); ok && end.After(until) {
+			until, open = end, true
+		}
+	}
+
+	return until, open
+}
+
+// Next returns the earliest opening of any of windows after now.
+func Next(windows []Window, now time.Time) (time.Time, bool) {
+	var (
+		next  time.Time
+		found bool
+	)
+
+	for _, w := range windows {
+		if start, ok := w.Next(now); ok && (!found || start.Before(next)) {
+			next, found = start, true
+		}
+	}
+
+	return next, found
+}
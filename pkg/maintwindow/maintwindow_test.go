@@ -0,0 +1,152 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maintwindow_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozystack/talm/pkg/maintwindow"
+)
+
+func mustParse(t *testing.T, schedule, duration, timezone string) maintwindow.Window {
+	t.Helper()
+
+	w, err := maintwindow.Parse(schedule, duration, timezone)
+	if err != nil {
+		t.Fatalf("Parse(%q, %q, %q): %v", schedule, duration, timezone, err)
+	}
+
+	return w
+}
+
+func TestParse_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		schedule, duration, timezone string
+		want                         string
+	}{
+		{"0 2 * *", "4h", "", "has 4 fields"},
+		{"60 2 * * *", "4h", "", "out of range 0-59"},
+		{"0 2 * * funday", "4h", "", "is not a day of week"},
+		{"0 5-2 * * *", "4h", "", "runs backwards"},
+		{"*/0 2 * * *", "4h", "", "step"},
+		{"0 2 * * *", "four hours", "", "duration"},
+		{"0 2 * * *", "0s", "", "out of range"},
+		{"0 2 * * *", "200h", "", "out of range"},
+		{"0 2 * * *", "4h", "Mars/Olympus", "time zone"},
+	} {
+		_, err := maintwindow.Parse(tc.schedule, tc.duration, tc.timezone)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q, %q, %q) = %v, want an error mentioning %q", tc.schedule, tc.duration, tc.timezone, err, tc.want)
+		}
+	}
+}
+
+// TestWindow_OpenUntil pins that a window is open from each start for
+// its duration, read in its own time zone, and closed outside.
+func TestWindow_OpenUntil(t *testing.T) {
+	t.Parallel()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saturdays 02:00-06:00 Berlin time.
+	w := mustParse(t, "0 2 * * sat", "4h", "Europe/Berlin")
+
+	for _, tc := range []struct {
+		now       time.Time
+		wantOpen  bool
+		wantUntil time.Time
+	}{
+		{time.Date(2026, 10, 17, 1, 59, 0, 0, berlin), false, time.Time{}},
+		{time.Date(2026, 10, 17, 2, 0, 0, 0, berlin), true, time.Date(2026, 10, 17, 6, 0, 0, 0, berlin)},
+		{time.Date(2026, 10, 17, 5, 59, 59, 0, berlin), true, time.Date(2026, 10, 17, 6, 0, 0, 0, berlin)},
+		{time.Date(2026, 10, 17, 6, 0, 0, 0, berlin), false, time.Time{}},
+		// 01:30 UTC is 03:30 in Berlin summer time.
+		{time.Date(2026, 8, 1, 1, 30, 0, 0, time.UTC), true, time.Date(2026, 8, 1, 6, 0, 0, 0, berlin)},
+		{time.Date(2026, 10, 18, 3, 0, 0, 0, berlin), false, time.Time{}},
+	} {
+		until, open := w.OpenUntil(tc.now)
+		if open != tc.wantOpen || !until.Equal(tc.wantUntil) {
+			t.Errorf("OpenUntil(%v) = %v, %v, want %v, %v", tc.now, until, open, tc.wantUntil, tc.wantOpen)
+		}
+	}
+}
+
+func TestWindow_Next(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		schedule string
+		want     time.Time
+		wantOK   bool
+	}{
+		{"0 2 * * sat", time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC), true},
+		{"30 22 * * mon-fri", time.Date(2026, 10, 16, 22, 30, 0, 0, time.UTC), true},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 12, 15, 0, 0, time.UTC), true},
+		// Both day fields restricted: the 1st of the month or a Sunday.
+		{"0 0 1 * 0", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), true},
+		{"0 0 30 feb *", time.Time{}, false},
+	} {
+		got, ok := mustParse(t, tc.schedule, "1h", "").Next(now)
+		if ok != tc.wantOK || !got.Equal(tc.want) {
+			t.Errorf("%q.Next = %v, %v, want %v, %v", tc.schedule, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestOpenUntilAndNext_Several(t *testing.T) {
+	t.Parallel()
+
+	windows := []maintwindow.Window{
+		mustParse(t, "0 1 * * *", "2h", ""),
+		mustParse(t, "0 2 * * *", "3h", ""),
+	}
+
+	until, open := maintwindow.OpenUntil(windows, time.Date(2026, 10, 16, 2, 30, 0, 0, time.UTC))
+	if !open || !until.Equal(time.Date(2026, 10, 16, 5, 0, 0, 0, time.UTC)) {
+		t.Errorf("OpenUntil = %v, %v, want the later close of the overlapping windows", until, open)
+	}
+
+	next, ok := maintwindow.Next(windows, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	if !ok || !next.Equal(time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Next = %v, %v, want the earliest opening", next, ok)
+	}
+
+	if _, open := maintwindow.OpenUntil(nil, time.Now()); open {
+		t.Error("no windows are never open")
+	}
+}
+
+func TestWindow_String(t *testing.T) {
+	t.Parallel()
+
+	if got, want := mustParse(t, "0  2 * * sat", "4h", "Europe/Berlin").String(), "0 2 * * sat for 4h (Europe/Berlin)"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	if got, want := mustParse(t, "0 2 * * *", "90m", "").String(), "0 2 * * * for 1h30m (UTC)"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
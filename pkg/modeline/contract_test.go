@@ -328,3 +328,48 @@ func TestContract_GenerateModeline_KeyOrder(t *testing.T) {
 		t.Errorf("key order mismatch: nodes=%d endpoints=%d templates=%d in %q", nodesIdx, endpointsIdx, templatesIdx, line)
 	}
 }
+
+// Contract: role takes a single-element array, is emitted after
+// templates only when set, and round-trips. A modeline without it
+// keeps the three-key form older talm versions write.
+func TestContract_GenerateRoleModeline_RoundTrip(t *testing.T) {
+	line, err := GenerateRoleModeline([]string{testNodeIP1}, nil, []string{"templates/node.yaml"}, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(line, `, templates=["templates/node.yaml"], role=["worker"]`) {
+		t.Errorf("role not emitted after templates: %q", line)
+	}
+
+	parsed, err := ParseModeline(line)
+	if err != nil {
+		t.Fatalf("parse generated modeline %q: %v", line, err)
+	}
+
+	if parsed.Role != "worker" {
+		t.Errorf("role = %q, want worker", parsed.Role)
+	}
+
+	line, err = GenerateRoleModeline([]string{testNodeIP1}, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(line, "role=") {
+		t.Errorf("empty role emitted: %q", line)
+	}
+}
+
+// Contract: a role array that does not hold exactly one machine type
+// is rejected rather than cut down to its first element.
+func TestContract_ParseModeline_RoleTakesOneValue(t *testing.T) {
+	for _, line := range []string{
+		`# talm: nodes=["1.2.3.4"], role=[]`,
+		`# talm: nodes=["1.2.3.4"], role=["controlplane", "worker"]`,
+	} {
+		if _, err := ParseModeline(line); err == nil || !strings.Contains(err.Error(), "role takes one value") {
+			t.Errorf("ParseModeline(%q) error = %v, want one about the role", line, err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package modeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// JSON node files carry the modeline and the config documents as one
+// JSON object:
+//
+//	{
+//	  "talm": {"nodes": ["1.2.3.4"], "templates": ["templates/worker.yaml"]},
+//	  "documents": [{"machine": {...}}, {"apiVersion": "v1alpha1", ...}]
+//	}
+//
+// The "talm" key is the JSON form of the `# talm: …` line and is what
+// tells a node file apart from a plain JSON patch. Each element of
+// "documents" is one document of the YAML form, in order.
+const (
+	jsonModelineKey  = "talm"
+	jsonDocumentsKey = "documents"
+)
+
+// jsonModeline is the "talm" object of a JSON node file. Field names
+// match the modeline keys.
+type jsonModeline struct {
+	Nodes     []string `json:"nodes,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	Templates []string `json:"templates,omitempty"`
+	Role      string   `json:"role,omitempty"`
+}
+
+// IsJSONNodeFile reports whether data is a JSON node file: a JSON
+// object with a top-level "talm" key. A JSON document without that key
+// is an ordinary patch (JSON is YAML) and keeps being read as one.
+func IsJSONNodeFile(data []byte) bool {
+	trimmed := bytes.TrimSpace(trimBOM(data))
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &top); err != nil {
+		return false
+	}
+
+	_, ok := top[jsonModelineKey]
+
+	return ok
+}
+
+// ParseJSONNodeFile reads the modeline and the config body of a JSON
+// node file. The body comes back as multi-document YAML in the order of
+// "documents", with the key order of each object kept, so every
+// consumer of the YAML form (patch merge, overlay detection, apply)
+// reads a JSON node file unchanged.
+func ParseJSONNodeFile(data []byte) (*Config, []byte, error) {
+	var top struct {
+		Talm      *jsonModeline   `json:"talm"`
+		Documents json.RawMessage `json:"documents"`
+	}
+
+	if err := json.Unmarshal(trimBOM(data), &top); err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint is the project's wrapping/hinting idiom
+		return nil, nil, errors.WithHint(
+			errors.Wrap(err, "error parsing JSON node file"),
+			`a JSON node file is an object with a "talm" modeline object and a "documents" array`,
+		)
+	}
+
+	if top.Talm == nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint is the project's wrapping/hinting idiom
+		return nil, nil, errors.WithHint(
+			ErrModelineNotFound,
+			`a JSON node file must carry a "talm": {"nodes": […]} object`,
+		)
+	}
+
+	config := &Config{Nodes: top.Talm.Nodes, Endpoints: top.Talm.Endpoints, Templates: normalizeTemplatePaths(top.Talm.Templates), Role: top.Talm.Role}
+
+	body, err := jsonDocumentsToYAML(top.Documents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, body, nil
+}
+
+// jsonDocumentsToYAML turns the "documents" array into multi-document
+// YAML. It decodes through yaml.Node rather than a Go map because JSON
+// is valid YAML and the node tree keeps the key order a map would lose.
+func jsonDocumentsToYAML(raw json.RawMessage) ([]byte, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil, nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, errors.Wrap(err, "error parsing the documents of a JSON node file")
+	}
+
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.SequenceNode {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint is the project's wrapping/hinting idiom
+		return nil, errors.WithHint(
+			errors.New(`"documents" of a JSON node file is not an array`),
+			`list the config documents as "documents": [{…}, {…}]`,
+		)
+	}
+
+	var buf bytes.Buffer
+
+	for i, doc := range root.Content[0].Content {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+
+		clearStyle(doc)
+
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+
+		if err := enc.Encode(doc); err != nil {
+			return nil, errors.Wrapf(err, "error encoding document %d of a JSON node file", i)
+		}
+
+		if err := enc.Close(); err != nil {
+			return nil, errors.Wrapf(err, "error encoding document %d of a JSON node file", i)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// clearStyle drops the flow and quoting style the JSON source gives
+// every node, so the YAML body reads like one talm renders itself.
+// Strings keep their tag, so "8080" stays a string.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// EncodeJSONNodeFile writes config and the multi-document YAML body as
+// a JSON node file, indented by two spaces and ending in a newline.
+// Key order follows the body. Comments have no JSON form and are
+// dropped, as are empty documents.
+func EncodeJSONNodeFile(config *Config, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(`{"` + jsonModelineKey + `":`)
+
+	if err := writeJSONValue(&buf, jsonModeline{Nodes: config.Nodes, Endpoints: config.Endpoints, Templates: config.Templates, Role: config.Role}); err != nil {
+		return nil, errors.Wrap(err, "error encoding the JSON modeline")
+	}
+
+	buf.WriteString(`,"` + jsonDocumentsKey + `":[`)
+
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	first := true
+
+	for {
+		var doc yaml.Node
+
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing the rendered config")
+		}
+
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+
+		first = false
+
+		if err := writeJSONNode(&buf, doc.Content[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteString("]}")
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, errors.Wrap(err, "error indenting the JSON node file")
+	}
+
+	out.WriteByte('\n')
+
+	return out.Bytes(), nil
+}
+
+// writeJSONNode writes one YAML node as JSON, keeping mapping key
+// order. Scalars are resolved by their YAML tag, so `port: 8080` stays
+// a number and `port: "8080"` a string.
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := writeJSONValue(buf, node.Content[i].Value); err != nil {
+				return errors.Wrap(err, "error encoding a mapping key")
+			}
+
+			buf.WriteByte(':')
+
+			if err := writeJSONNode(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := writeJSONNode(buf, item); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(']')
+	default:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return errors.Wrapf(err, "error decoding the value at line %d", node.Line)
+		}
+
+		if err := writeJSONValue(buf, value); err != nil {
+			return errors.Wrapf(err, "error encoding the value at line %d as JSON", node.Line)
+		}
+	}
+
+	return nil
+}
+
+// writeJSONValue writes one JSON value without the HTML escaping of
+// json.Marshal, so a `&` in a kernel argument stays readable.
+func writeJSONValue(buf *bytes.Buffer, value any) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(value); err != nil {
+		return errors.Wrap(err, "error encoding JSON")
+	}
+
+	// Encode terminates the value with a newline.
+	buf.Truncate(buf.Len() - 1)
+
+	return nil
+}
+
+// NodeFileBody returns the config part of a node file's contents: the
+// documents of a JSON node file as YAML, any other file unchanged but
+// for a leading byte order mark.
+func NodeFileBody(data []byte) ([]byte, error) {
+	data = trimBOM(data)
+
+	if !IsJSONNodeFile(data) {
+		return data, nil
+	}
+
+	_, body, err := ParseJSONNodeFile(data)
+
+	return body, err
+}
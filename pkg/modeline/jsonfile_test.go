@@ -0,0 +1,215 @@
+package modeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testJSONNodeFile = `{
+  "talm": {
+    "nodes": ["1.2.3.4"],
+    "templates": ["templates/controlplane.yaml"]
+  },
+  "documents": [
+    {"machine": {"type": "controlplane", "network": {"hostname": "cp1"}, "kubelet": {"extraArgs": {"port": "8080"}}}},
+    {"apiVersion": "v1alpha1", "kind": "LinkConfig", "mtu": 9000, "up": true}
+  ]
+}
+`
+
+func TestIsJSONNodeFile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want bool
+	}{
+		{name: "node file", data: testJSONNodeFile, want: true},
+		{name: "leading whitespace", data: "\n  {\"talm\": {}}", want: true},
+		{name: "plain JSON patch", data: `{"machine": {"type": "worker"}}`},
+		{name: "YAML node file", data: "# talm: nodes=[\"1.2.3.4\"]\nmachine: {}\n"},
+		{name: "YAML flow mapping", data: "{talm: x}"},
+		{name: "empty", data: ""},
+	} {
+		if got := IsJSONNodeFile([]byte(tc.data)); got != tc.want {
+			t.Errorf("%s: IsJSONNodeFile = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseJSONNodeFile(t *testing.T) {
+	config, body, err := ParseJSONNodeFile([]byte(testJSONNodeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{Nodes: []string{testNodeIP1}, Templates: []string{testTemplateControlPln}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+
+	// Key order is the JSON order, and "8080" stays a string.
+	wantBody := `machine:
+  type: controlplane
+  network:
+    hostname: cp1
+  kubelet:
+    extraArgs:
+      port: "8080"
+---
+apiVersion: v1alpha1
+kind: LinkConfig
+mtu: 9000
+up: true
+`
+	if string(body) != wantBody {
+		t.Errorf("body =\n%s\nwant\n%s", body, wantBody)
+	}
+}
+
+func TestParseJSONNodeFile_Errors(t *testing.T) {
+	if _, _, err := ParseJSONNodeFile([]byte(`{"talm": {"nodes": "1.2.3.4"}}`)); err == nil {
+		t.Error("nodes must be an array")
+	}
+
+	if _, _, err := ParseJSONNodeFile([]byte(`{"talm": {}, "documents": {"machine": {}}}`)); err == nil || !strings.Contains(err.Error(), "not an array") {
+		t.Errorf("documents must be an array, got %v", err)
+	}
+
+	if _, _, err := ParseJSONNodeFile([]byte(`{"documents": []}`)); !errors.Is(err, ErrModelineNotFound) {
+		t.Errorf("a missing talm object is ErrModelineNotFound, got %v", err)
+	}
+}
+
+// TestEncodeJSONNodeFile_RoundTrip pins that encoding the YAML form and
+// reading it back gives the same documents, comments aside.
+func TestEncodeJSONNodeFile_RoundTrip(t *testing.T) {
+	yamlBody := `# talm: nodes=["1.2.3.4"]
+# THIS FILE IS AUTOGENERATED. PREFER TEMPLATE EDITS OVER MANUAL ONES.
+machine:
+  type: controlplane # trailing comment
+  install:
+    extraKernelArgs:
+      - console=ttyS0
+      - a&b
+---
+---
+apiVersion: v1alpha1
+kind: LinkConfig
+mtu: 9000
+`
+	config := &Config{Nodes: []string{testNodeIP1}}
+
+	data, err := EncodeJSONNodeFile(config, []byte(yamlBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantJSON := `{
+  "talm": {
+    "nodes": [
+      "1.2.3.4"
+    ]
+  },
+  "documents": [
+    {
+      "machine": {
+        "type": "controlplane",
+        "install": {
+          "extraKernelArgs": [
+            "console=ttyS0",
+            "a&b"
+          ]
+        }
+      }
+    },
+    {
+      "apiVersion": "v1alpha1",
+      "kind": "LinkConfig",
+      "mtu": 9000
+    }
+  ]
+}
+`
+	if string(data) != wantJSON {
+		t.Fatalf("JSON =\n%s\nwant\n%s", data, wantJSON)
+	}
+
+	gotConfig, body, err := ParseJSONNodeFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotConfig, config) {
+		t.Errorf("config = %+v", gotConfig)
+	}
+
+	wantBody := "machine:\n  type: controlplane\n  install:\n    extraKernelArgs:\n      - console=ttyS0\n      - a&b\n---\napiVersion: v1alpha1\nkind: LinkConfig\nmtu: 9000\n"
+	if string(body) != wantBody {
+		t.Errorf("body =\n%s\nwant\n%s", body, wantBody)
+	}
+}
+
+// TestEncodeJSONNodeFile_Role pins the role of the modeline as the
+// "role" string of the "talm" object.
+func TestEncodeJSONNodeFile_Role(t *testing.T) {
+	config := &Config{Nodes: []string{testNodeIP1}, Templates: []string{"templates/node.yaml"}, Role: "controlplane"}
+
+	data, err := EncodeJSONNodeFile(config, []byte("machine:\n  type: controlplane\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"role": "controlplane"`) {
+		t.Errorf("JSON lacks the role:\n%s", data)
+	}
+
+	gotConfig, _, err := ParseJSONNodeFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotConfig, config) {
+		t.Errorf("config = %+v, want %+v", gotConfig, config)
+	}
+}
+
+func TestFindAndParseModeline_JSONNodeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cp1.json")
+	if err := os.WriteFile(path, []byte(testJSONNodeFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	leading, config, err := FindAndParseModeline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leading != nil || !reflect.DeepEqual(config.Nodes, []string{testNodeIP1}) {
+		t.Errorf("leading = %q, config = %+v", leading, config)
+	}
+
+	// A JSON patch without the talm object is an orphan, as before.
+	if err := os.WriteFile(path, []byte(`{"machine": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := FindAndParseModeline(path); !errors.Is(err, ErrModelineNotFound) {
+		t.Errorf("plain JSON patch: error = %v, want ErrModelineNotFound", err)
+	}
+}
+
+func TestNodeFileBody(t *testing.T) {
+	yamlFile := []byte("# talm: nodes=[\"1.2.3.4\"]\nmachine: {}\n")
+	if got, err := NodeFileBody(yamlFile); err != nil || string(got) != string(yamlFile) {
+		t.Errorf("a YAML node file is returned as is, got %q, %v", got, err)
+	}
+
+	got, err := NodeFileBody([]byte(`{"talm": {"nodes": ["1.2.3.4"]}, "documents": [{"machine": {"type": "worker"}}]}`))
+	if err != nil || string(got) != "machine:\n  type: worker\n" {
+		t.Errorf("JSON node file body = %q, %v", got, err)
+	}
+}
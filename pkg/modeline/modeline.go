@@ -2,6 +2,7 @@ package modeline
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -24,7 +25,7 @@ import (
 //
 // Scope: JSON-array values only. The splitter does NOT track `{`/`}`
 // nesting because every modeline key in the current contract (nodes,
-// endpoints, templates) is a JSON array — a `{` at depth 0 will fall
+// endpoints, templates, role) is a JSON array — a `{` at depth 0 will fall
 // through to the downstream json.Unmarshal which rejects non-array
 // inputs. If a future modeline key takes a JSON-object value, extend
 // the depth counter to track `{`/`}` too.
@@ -78,6 +79,31 @@ type Config struct {
 	Nodes     []string
 	Endpoints []string
 	Templates []string
+	// Role is the machine type the templates render for, set when
+	// one template serves both controlplane and worker nodes.
+	Role string
+}
+
+// utf8BOM is the byte order mark some Windows editors, Notepad among
+// them, write at the start of a UTF-8 file. It is not part of the
+// content: a BOM in front of `# talm:` would hide the modeline.
+const utf8BOM = "\ufeff"
+
+// trimBOM drops a leading UTF-8 byte order mark from data.
+func trimBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, []byte(utf8BOM))
+}
+
+// normalizeTemplatePaths turns backslashes in modeline template paths
+// into slashes. Templates are chart paths, which helm keys with
+// forward slashes on every OS; a modeline hand-written on Windows as
+// templates\controlplane.yaml must resolve on Linux too.
+func normalizeTemplatePaths(templates []string) []string {
+	for i, template := range templates {
+		templates[i] = strings.ReplaceAll(template, `\`, "/")
+	}
+
+	return templates
 }
 
 // ErrModelineNotFound is the sentinel cause FindAndParseModeline
@@ -128,7 +154,17 @@ func ParseModeline(line string) (*Config, error) {
 			case "endpoints":
 				config.Endpoints = arr
 			case "templates":
-				config.Templates = arr
+				config.Templates = normalizeTemplatePaths(arr)
+			case "role":
+				if len(arr) != 1 {
+					//nolint:wrapcheck // cockroachdb/errors.WithHintf is the project's wrapping/hinting idiom
+					return nil, errors.WithHintf(
+						errors.Newf("role takes one value, got %d", len(arr)),
+						"name the machine type the templates render for, e.g. role=[\"worker\"]",
+					)
+				}
+
+				config.Role = arr[0]
 				// Ignore unknown keys
 			}
 		}
@@ -156,8 +192,11 @@ func ParseModeline(line string) (*Config, error) {
 // workflow that consumes node files (apply, upgrade, completion,
 // wrapped talosctl commands) calls this function too so the
 // file-shape contract is uniform across the surface.
+//
+// A JSON node file (see IsJSONNodeFile) carries its modeline as the
+// "talm" object instead and has no leading comments.
 func FindAndParseModeline(filePath string) ([]string, *Config, error) {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		//nolint:wrapcheck // cockroachdb/errors.WithHintf is the project's wrapping/hinting idiom
 		return nil, nil, errors.WithHintf(
@@ -165,11 +204,18 @@ func FindAndParseModeline(filePath string) ([]string, *Config, error) {
 			"check that %s exists and is readable", filePath,
 		)
 	}
-	defer func() { _ = file.Close() }()
+
+	data = trimBOM(data)
+
+	if IsJSONNodeFile(data) {
+		config, _, err := ParseJSONNodeFile(data)
+
+		return nil, config, err
+	}
 
 	var leading []string
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		line := scanner.Text()
 		trim := strings.TrimSpace(line)
@@ -256,6 +302,12 @@ func classifyNoLeadingModeline(scanner *bufio.Scanner, firstNonComment string) e
 
 // GenerateModeline creates a modeline string using JSON formatting for values.
 func GenerateModeline(nodes, endpoints, templates []string) (string, error) {
+	return GenerateRoleModeline(nodes, endpoints, templates, "")
+}
+
+// GenerateRoleModeline is GenerateModeline for a node file rendered
+// for role; an empty role leaves the role key out.
+func GenerateRoleModeline(nodes, endpoints, templates []string, role string) (string, error) {
 	// Convert Nodes to JSON
 	nodesJSON, err := json.Marshal(nodes)
 	if err != nil {
@@ -277,5 +329,14 @@ func GenerateModeline(nodes, endpoints, templates []string) (string, error) {
 	// Form the final modeline string
 	modeline := fmt.Sprintf(`# talm: nodes=%s, endpoints=%s, templates=%s`, string(nodesJSON), string(endpointsJSON), string(templatesJSON))
 
+	if role != "" {
+		roleJSON, err := json.Marshal([]string{role})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to marshal role")
+		}
+
+		modeline += ", role=" + string(roleJSON)
+	}
+
 	return modeline, nil
 }
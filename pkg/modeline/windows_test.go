@@ -0,0 +1,57 @@
+package modeline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFindAndParseModeline_WindowsFile pins that a node file saved by a
+// Windows editor, with a byte order mark, CRLF line endings and a
+// backslashed template path, parses like its POSIX twin.
+func TestFindAndParseModeline_WindowsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cp1.yaml")
+	content := "\ufeff# operator note\r\n" +
+		`# talm: nodes=["1.2.3.4"], templates=["templates\\controlplane.yaml"]` + "\r\n" +
+		"machine:\r\n  type: controlplane\r\n"
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	leading, config, err := FindAndParseModeline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(leading, []string{"# operator note"}) {
+		t.Errorf("leading = %q", leading)
+	}
+
+	want := &Config{Nodes: []string{testNodeIP1}, Templates: []string{testTemplateControlPln}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+}
+
+func TestJSONNodeFile_BOM(t *testing.T) {
+	data := []byte(utf8BOM + `{"talm": {"nodes": ["1.2.3.4"], "templates": ["templates\\controlplane.yaml"]}, "documents": [{"machine": {}}]}`)
+
+	if !IsJSONNodeFile(data) {
+		t.Fatal("a byte order mark must not hide a JSON node file")
+	}
+
+	config, _, err := ParseJSONNodeFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config.Templates, []string{testTemplateControlPln}) {
+		t.Errorf("templates = %q", config.Templates)
+	}
+
+	if body, err := NodeFileBody([]byte("\ufeffmachine: {}\n")); err != nil || string(body) != "machine: {}\n" {
+		t.Errorf("NodeFileBody = %q, %v", body, err)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package run executes talm commands inside the calling process, for Go
+// programs and test suites that drive talm without building the binary
+// and parsing what it prints.
+//
+// An invocation behaves as the binary would when started in
+// Options.Dir: the same root detection, Chart.yaml loading and exit
+// codes. Its standard streams are captured into the Result, and the
+// state a command leaves behind, flags and loaded configuration alike,
+// is reset before the next one runs, so invocations do not see each
+// other.
+//
+// talm's commands keep that state in package variables, and a command
+// writes to the process's standard streams, so invocations run one at
+// a time: for the duration of one, the working directory, the
+// environment entries of Options.Env and os.Stdin, os.Stdout and
+// os.Stderr are the invocation's. Code running concurrently in the same
+// process sees them too.
+package run
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/cli"
+)
+
+// Options configure one invocation.
+type Options struct {
+	// Dir is the directory talm runs in, as the working directory of
+	// the binary: root detection and relative paths start there. Empty
+	// means the current directory.
+	Dir string
+	// Env holds KEY=VALUE entries set for the invocation only, such as
+	// TALM_ROOT or TALOSCONFIG.
+	Env []string
+	// Stdin is read as the standard input; nil means an empty one.
+	Stdin io.Reader
+	// Stdout and Stderr, when set, receive the output as it is written,
+	// besides the Result.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Result is the outcome of one invocation.
+type Result struct {
+	// Stdout and Stderr are what the command wrote to each stream.
+	Stdout string
+	Stderr string
+	// Err is the error the command failed with, nil on success.
+	Err error
+	// ExitCode is the code the binary would have exited with.
+	ExitCode int
+}
+
+// mu serializes invocations; see the package documentation.
+//
+//nolint:gochecknoglobals // guards the process-wide state an invocation takes over.
+var mu sync.Mutex
+
+// execute runs one command.
+//
+//nolint:gochecknoglobals // function-type indirection for test injection.
+var execute = cli.Execute
+
+// Talm runs talm with args, without the program name, and returns the
+// outcome. ctx is the context the command runs under. A command that
+// panics leaves the process as the invocation found it before the
+// panic reaches the caller.
+func Talm(ctx context.Context, opts Options, args ...string) (result Result) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	restore, err := enter(opts)
+	if err != nil {
+		return Result{Err: err, ExitCode: cli.ExitCode(err)}
+	}
+	defer restore()
+
+	stdin, err := feedStdin(opts.Stdin)
+	if err != nil {
+		return Result{Err: err, ExitCode: cli.ExitCode(err)}
+	}
+	defer stdin()
+
+	stdout, err := capture(&os.Stdout, opts.Stdout)
+	if err != nil {
+		return Result{Err: err, ExitCode: cli.ExitCode(err)}
+	}
+	defer func() { result.Stdout = stdout.finish() }()
+
+	stderr, err := capture(&os.Stderr, opts.Stderr)
+	if err != nil {
+		return Result{Err: err, ExitCode: cli.ExitCode(err)}
+	}
+	defer func() { result.Stderr = stderr.finish() }()
+
+	defer cli.Reset()
+
+	// A nil slice would make cobra fall back to os.Args.
+	err = execute(ctx, append([]string{}, args...))
+
+	return Result{Err: err, ExitCode: cli.ExitCode(err)}
+}
+
+// enter switches to the directory and environment of opts. The returned
+// function switches back.
+func enter(opts Options) (func(), error) {
+	var undo []func()
+
+	restore := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	for _, entry := range opts.Env {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			restore()
+
+			return nil, errors.Newf("run: environment entry %q is not KEY=VALUE", entry)
+		}
+
+		previous, set := os.LookupEnv(key)
+		if err := os.Setenv(key, value); err != nil {
+			restore()
+
+			return nil, errors.Wrapf(err, "run: setting %s", key)
+		}
+
+		undo = append(undo, func() {
+			if set {
+				_ = os.Setenv(key, previous)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+
+	if opts.Dir != "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			restore()
+
+			return nil, errors.Wrap(err, "run: reading the working directory")
+		}
+
+		if err := os.Chdir(opts.Dir); err != nil {
+			restore()
+
+			return nil, errors.Wrapf(err, "run: entering %s", opts.Dir)
+		}
+
+		undo = append(undo, func() { _ = os.Chdir(wd) })
+	}
+
+	return restore, nil
+}
+
+// feedStdin replaces os.Stdin with a pipe fed from in. The returned
+// function puts the process's back.
+func feedStdin(in io.Reader) (func(), error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "run: creating the stdin pipe")
+	}
+
+	go func() {
+		if in != nil {
+			_, _ = io.Copy(writer, in)
+		}
+
+		_ = writer.Close()
+	}()
+
+	saved := os.Stdin
+	os.Stdin = reader
+
+	return func() {
+		os.Stdin = saved
+		// Unblocks the copy above when the command did not read all of in.
+		_ = reader.Close()
+	}, nil
+}
+
+// stream is a standard stream redirected into a buffer.
+type stream struct {
+	target **os.File
+	saved  *os.File
+	writer *os.File
+	buf    bytes.Buffer
+	done   chan struct{}
+}
+
+// capture redirects *target, os.Stdout or os.Stderr, into a buffer and
+// tee, when set.
+func capture(target **os.File, tee io.Writer) (*stream, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "run: creating an output pipe")
+	}
+
+	s := &stream{target: target, saved: *target, writer: writer, done: make(chan struct{})}
+
+	dst := io.Writer(&s.buf)
+	if tee != nil {
+		dst = io.MultiWriter(&s.buf, tee)
+	}
+
+	go func() {
+		_, _ = io.Copy(dst, reader)
+		_ = reader.Close()
+
+		close(s.done)
+	}()
+
+	*target = writer
+
+	return s, nil
+}
+
+// finish puts the process's stream back and returns what was written.
+func (s *stream) finish() string {
+	*s.target = s.saved
+	_ = s.writer.Close()
+
+	<-s.done
+
+	return s.buf.String()
+}
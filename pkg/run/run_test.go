@@ -0,0 +1,229 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTalm_Version(t *testing.T) {
+	result := Talm(context.Background(), Options{}, "--version")
+	if result.Err != nil || result.ExitCode != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	if !strings.Contains(result.Stdout, "talm version") {
+		t.Errorf("stdout = %q, want the version", result.Stdout)
+	}
+}
+
+func TestTalm_UnknownCommand(t *testing.T) {
+	result := Talm(context.Background(), Options{}, "no-such-command")
+	if result.Err == nil || result.ExitCode != 1 {
+		t.Fatalf("result = %+v, want a failure", result)
+	}
+
+	if !strings.Contains(result.Stderr, "unknown command") {
+		t.Errorf("stderr = %q, want the error", result.Stderr)
+	}
+}
+
+// TestTalm_PanicRestoresProcess pins that a command that panics
+// hands the process streams, working directory and lock back before
+// the panic reaches the caller, so the next invocation still runs.
+func TestTalm_PanicRestoresProcess(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := execute
+	t.Cleanup(func() { execute = orig })
+
+	execute = func(context.Context, []string) error {
+		fmt.Println("partial output")
+		panic("command failed")
+	}
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "command failed" {
+				t.Errorf("recovered = %v, want the command's panic", recovered)
+			}
+		}()
+
+		Talm(context.Background(), Options{Dir: t.TempDir()}, "apply")
+	}()
+
+	if os.Stdout != stdout || os.Stderr != stderr {
+		t.Error("the process streams must be restored after a panic")
+	}
+
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("working directory = %s, want %s", got, wd)
+	}
+
+	execute = orig
+
+	if result := Talm(context.Background(), Options{}, "--version"); result.Err != nil || !strings.Contains(result.Stdout, "talm version") {
+		t.Errorf("the invocation after a panic = %+v", result)
+	}
+}
+
+// TestTalm_ProjectsDoNotLeak pins that an invocation sees neither the
+// flags nor the Chart.yaml of the one before it.
+func TestTalm_ProjectsDoNotLeak(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{"alpha", "beta"} {
+		dir := t.TempDir()
+
+		result := Talm(ctx, Options{Dir: dir}, "init", "--preset", "generic", "--name", name, "--cluster-endpoint", "https://192.0.2.10:6443")
+		if result.Err != nil {
+			t.Fatalf("init %s: %v\n%s", name, result.Err, result.Stderr)
+		}
+
+		result = Talm(ctx, Options{Dir: dir}, "template", "--offline",
+			"--template", filepath.Join("templates", "controlplane.yaml"),
+			"--nodes", "192.0.2.10", "--endpoints", "192.0.2.10",
+			"--set", "advertisedSubnets={192.0.2.0/24}",
+		)
+		if result.Err != nil {
+			t.Fatalf("template %s: %v\n%s", name, result.Err, result.Stderr)
+		}
+
+		if want := "clusterName: " + name; !strings.Contains(result.Stdout, want) {
+			t.Errorf("template %s: stdout has no %q", name, want)
+		}
+	}
+
+	result := Talm(ctx, Options{Dir: t.TempDir()}, "template", "--offline", "--template", "templates/controlplane.yaml")
+	if result.Err == nil {
+		t.Error("a directory without a project must fail, as if Chart.yaml had not been loaded before")
+	}
+}
+
+func TestTalm_StreamsAndTee(t *testing.T) {
+	var tee bytes.Buffer
+
+	result := Talm(context.Background(), Options{Stdout: &tee, Stdin: strings.NewReader("ignored")}, "--version")
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	if tee.String() != result.Stdout {
+		t.Errorf("tee = %q, want the captured stdout %q", tee.String(), result.Stdout)
+	}
+
+	if os.Stdout == nil || os.Stderr == nil || os.Stdin == nil {
+		t.Fatal("the process streams must be put back")
+	}
+}
+
+func TestEnter(t *testing.T) {
+	t.Setenv("TALM_RUN_TEST_SET", "before")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore, err := enter(Options{Dir: dir, Env: []string{"TALM_RUN_TEST_SET=during", "TALM_RUN_TEST_NEW=a=b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.Getwd(); got != dir {
+		t.Errorf("working directory = %s, want %s", got, dir)
+	}
+
+	if os.Getenv("TALM_RUN_TEST_SET") != "during" || os.Getenv("TALM_RUN_TEST_NEW") != "a=b" {
+		t.Error("the environment of the options must be set")
+	}
+
+	restore()
+
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("working directory = %s, want %s back", got, wd)
+	}
+
+	if os.Getenv("TALM_RUN_TEST_SET") != "before" {
+		t.Error("a variable set before must get its value back")
+	}
+
+	if _, set := os.LookupEnv("TALM_RUN_TEST_NEW"); set {
+		t.Error("a variable not set before must be unset again")
+	}
+}
+
+func TestEnter_Invalid(t *testing.T) {
+	t.Setenv("TALM_RUN_TEST_SET", "before")
+
+	if _, err := enter(Options{Env: []string{"TALM_RUN_TEST_SET=during", "NOEQUALS"}}); err == nil {
+		t.Fatal("an entry without = must be refused")
+	}
+
+	if os.Getenv("TALM_RUN_TEST_SET") != "before" {
+		t.Error("a refused environment must leave the process one as it was")
+	}
+
+	if _, err := enter(Options{Dir: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("a missing directory must be refused")
+	}
+}
+
+func TestCaptureAndFeedStdin(t *testing.T) {
+	saved := os.Stdout
+
+	var tee bytes.Buffer
+
+	out, err := capture(&os.Stdout, &tee)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore, err := feedStdin(strings.NewReader("from stdin"))
+	if err != nil {
+		out.finish()
+		t.Fatal(err)
+	}
+
+	in, err := io.ReadAll(os.Stdin)
+	restore()
+
+	fmt.Printf("read %s", in)
+
+	if got := out.finish(); got != "read from stdin" || tee.String() != got {
+		t.Errorf("captured = %q, tee = %q (err %v)", got, tee.String(), err)
+	}
+
+	if os.Stdout != saved {
+		t.Error("os.Stdout must be put back")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// Fallback puts a remote backend in front of the local directory. A
+// remote write that fails lands locally with a warning, so an outage of
+// the bucket never loses an apply record; reads merge both sides, so
+// records written during an outage stay visible. PutIfAbsent,
+// PutIfVersion, GetVersion and Delete are remote-only: a lock taken
+// locally while the bucket is unreachable would exclude nobody, so lock
+// operations fail instead.
+type Fallback struct {
+	Remote Backend
+	Local  Backend
+	Warn   io.Writer
+}
+
+// Describe implements Backend.
+func (f *Fallback) Describe() string {
+	return f.Remote.Describe()
+}
+
+func (f *Fallback) warn(action, key string, err error) {
+	if f.Warn != nil {
+		ui.Warnf(f.Warn, "%s %s in %s failed, using %s: %v", action, key, f.Remote.Describe(), f.Local.Describe(), err)
+	}
+}
+
+// Get implements Backend. The remote copy wins; the local copy is read
+// when the remote has none or cannot be reached.
+func (f *Fallback) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := f.Remote.Get(ctx, key)
+	if err == nil {
+		return data, nil
+	}
+
+	if !errors.Is(err, ErrNotFound) {
+		f.warn("reading", key, err)
+	}
+
+	return f.Local.Get(ctx, key)
+}
+
+// Put implements Backend.
+func (f *Fallback) Put(ctx context.Context, key string, data []byte) error {
+	err := f.Remote.Put(ctx, key, data)
+	if err == nil {
+		return nil
+	}
+
+	f.warn("writing", key, err)
+
+	return f.Local.Put(ctx, key, data)
+}
+
+// PutIfAbsent implements Backend against the remote only.
+func (f *Fallback) PutIfAbsent(ctx context.Context, key string, data []byte) error {
+	return f.Remote.PutIfAbsent(ctx, key, data) //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// GetVersion implements Backend against the remote only.
+func (f *Fallback) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	return f.Remote.GetVersion(ctx, key) //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// PutIfVersion implements Backend against the remote only.
+func (f *Fallback) PutIfVersion(ctx context.Context, key string, data []byte, version string) error {
+	return f.Remote.PutIfVersion(ctx, key, data, version) //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// DeleteIfVersion implements Backend against the remote only.
+func (f *Fallback) DeleteIfVersion(ctx context.Context, key, version string) error {
+	return f.Remote.DeleteIfVersion(ctx, key, version) //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// Delete implements Backend against the remote only.
+func (f *Fallback) Delete(ctx context.Context, key string) error {
+	return f.Remote.Delete(ctx, key) //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// List implements Backend, merging remote and local keys. A listing
+// the remote cannot serve degrades to the local keys with a warning.
+func (f *Fallback) List(ctx context.Context, prefix string) ([]string, error) {
+	remote, err := f.Remote.List(ctx, prefix)
+	if err != nil {
+		f.warn("listing", prefix, err)
+	}
+
+	local, localErr := f.Local.List(ctx, prefix)
+	if localErr != nil {
+		return nil, localErr
+	}
+
+	seen := make(map[string]struct{}, len(remote)+len(local))
+	keys := make([]string, 0, len(remote)+len(local))
+
+	for _, key := range append(remote, local...) {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
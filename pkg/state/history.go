@@ -0,0 +1,145 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// HistoryPrefix is the key prefix of apply history records.
+const HistoryPrefix = "history/"
+
+// historyKeyTimeFormat sorts lexically in time order, so List returns
+// records oldest first without reading them.
+const historyKeyTimeFormat = "20060102T150405.000000000Z"
+
+// historySuffixBytes disambiguates records written in the same
+// nanosecond by different operators.
+const historySuffixBytes = 4
+
+// Record is one entry of the apply history.
+type Record struct {
+	// ID is derived from the storage key by ReadHistory; it is not
+	// stored in the record itself.
+	ID string `json:"-"`
+	// Time is when the operation finished.
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Operator  string        `json:"operator"`
+	Operation string        `json:"operation"`
+	File      string        `json:"file,omitempty"`
+	Nodes     []string      `json:"nodes,omitempty"`
+	// Image is the installer image an upgrade targeted.
+	Image string `json:"image,omitempty"`
+	// Changes summarises what the operation did per node, as far as
+	// talm observed it.
+	Changes []NodeChange `json:"changes,omitempty"`
+	// Error is the failure message; empty for a successful apply.
+	Error string `json:"error,omitempty"`
+}
+
+// NodeChange is the per-node part of a Record: the Talos version seen
+// before and after the operation, a count of the MachineConfig
+// differences the drift preview found and how the node's apply ended.
+// Only counts are kept; the diff itself can carry secrets and stays
+// out of the history.
+type NodeChange struct {
+	Node          string `json:"node"`
+	VersionBefore string `json:"versionBefore,omitempty"`
+	VersionAfter  string `json:"versionAfter,omitempty"`
+	// Image is the installer image the node was upgraded to.
+	Image string `json:"image,omitempty"`
+	// ConfigSHA256, Mode, Details and Warnings are what the node
+	// answered to the apply: the config it was sent, identified by its
+	// hash, and the mode it applied it in.
+	ConfigSHA256 string   `json:"configSHA256,omitempty"`
+	Mode         string   `json:"mode,omitempty"`
+	Details      string   `json:"details,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
+	// Previewed is set when a drift preview ran, so "no differences"
+	// can be told apart from "not compared".
+	Previewed bool `json:"previewed,omitempty"`
+	Added     int  `json:"added,omitempty"`
+	Removed   int  `json:"removed,omitempty"`
+	Updated   int  `json:"updated,omitempty"`
+	Fields    int  `json:"fields,omitempty"`
+	// Applied is set once the node's apply completed, in this
+	// operation or, when Resumed is also set, in the one it resumed.
+	Applied bool `json:"applied,omitempty"`
+	Resumed bool `json:"resumed,omitempty"`
+	// Error is the failure of the node's apply.
+	Error string `json:"error,omitempty"`
+}
+
+// Started is when the operation began.
+func (r Record) Started() time.Time {
+	return r.Time.Add(-r.Duration)
+}
+
+// AppendHistory stores rec under a new, time-ordered key.
+func AppendHistory(ctx context.Context, backend Backend, rec Record) error {
+	suffix := make([]byte, historySuffixBytes)
+	if _, err := rand.Read(suffix); err != nil {
+		return errors.Wrap(err, "generating history key")
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "encoding history record")
+	}
+
+	key := HistoryPrefix + rec.Time.UTC().Format(historyKeyTimeFormat) + "-" + hex.EncodeToString(suffix) + ".json"
+
+	return errors.Wrap(backend.Put(ctx, key, data), "recording apply history")
+}
+
+// ReadHistory returns the last limit records, oldest first; limit <= 0
+// returns all of them.
+func ReadHistory(ctx context.Context, backend Backend, limit int) ([]Record, error) {
+	keys, err := backend.List(ctx, HistoryPrefix)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+	}
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[len(keys)-limit:]
+	}
+
+	records := make([]Record, 0, len(keys))
+
+	for _, key := range keys {
+		data, err := backend.Get(ctx, key)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+		}
+
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", key)
+		}
+
+		rec.ID = strings.TrimSuffix(strings.TrimPrefix(key, HistoryPrefix), ".json")
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
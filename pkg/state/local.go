@@ -0,0 +1,291 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/secureperm"
+)
+
+// localDirMode is the permission of directories the local backend
+// creates. The state names operators and nodes, so it is kept private
+// like the rest of the project's generated artefacts.
+const localDirMode = 0o700
+
+// localFileMode is the creation mode of PutIfAbsent; secureperm.LockDown
+// then applies the platform's owner-only policy.
+const localFileMode = 0o600
+
+// localSwapSuffix names the guard file PutIfVersion and DeleteIfVersion
+// hold next to the object they replace or remove.
+const localSwapSuffix = ".swap"
+
+// localSwapStaleAfter is the age past which a guard file is taken to
+// be left behind by a writer that crashed while holding it. A guard is
+// held for one read and one write of a small file, so a live one never
+// gets anywhere near this old.
+const localSwapStaleAfter = 10 * time.Second
+
+// Local stores objects as files under Dir.
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a Local backend rooted at dir.
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+// Describe implements Backend.
+func (l *Local) Describe() string {
+	return l.Dir
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", errors.Newf("invalid state key %q", key)
+	}
+
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Get implements Backend.
+func (l *Local) Get(_ context.Context, key string) ([]byte, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrapf(ErrNotFound, "%s", key)
+	}
+
+	return data, errors.Wrapf(err, "reading %s", p)
+}
+
+// Put implements Backend.
+func (l *Local) Put(_ context.Context, key string, data []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), localDirMode); err != nil {
+		return errors.Wrapf(err, "creating %s", filepath.Dir(p))
+	}
+
+	return errors.Wrapf(secureperm.WriteFile(p, data), "writing %s", p)
+}
+
+// PutIfAbsent implements Backend with O_EXCL, which is atomic on a
+// local filesystem.
+func (l *Local) PutIfAbsent(_ context.Context, key string, data []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), localDirMode); err != nil {
+		return errors.Wrapf(err, "creating %s", filepath.Dir(p))
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, localFileMode)
+	if errors.Is(err, fs.ErrExist) {
+		return errors.Wrapf(ErrExists, "%s", key)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", p)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+
+		return errors.Wrapf(err, "writing %s", p)
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "closing %s", p)
+	}
+
+	return errors.Wrapf(secureperm.LockDown(p), "restricting %s", p)
+}
+
+// GetVersion implements Backend. The version is the SHA-256 of the
+// content; a lock carries a fresh random ID, so a retaken lock never
+// comes back to a version seen before.
+func (l *Local) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, contentVersion(data), nil
+}
+
+// PutIfVersion implements Backend. Writers serialize on a guard file
+// next to the object, so the compare and the replace of one writer
+// cannot interleave with another's.
+func (l *Local) PutIfVersion(_ context.Context, key string, data []byte, version string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	return l.ifVersion(p, key, version, func() error {
+		return errors.Wrapf(secureperm.WriteFile(p, data), "writing %s", p)
+	})
+}
+
+// DeleteIfVersion implements Backend under the same guard file as
+// PutIfVersion.
+func (l *Local) DeleteIfVersion(_ context.Context, key, version string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	return l.ifVersion(p, key, version, func() error {
+		return errors.Wrapf(os.Remove(p), "removing %s", p)
+	})
+}
+
+// ifVersion runs change while holding the guard of the object at p and
+// only if the object is still at version; it returns ErrChanged
+// otherwise, and when another writer holds the guard.
+func (l *Local) ifVersion(p, key, version string, change func() error) error {
+	release, err := takeSwapGuard(p + localSwapSuffix)
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrapf(ErrChanged, "%s", key)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", p+localSwapSuffix)
+	}
+
+	defer release()
+
+	current, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrapf(ErrChanged, "%s", key)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", p)
+	}
+
+	if contentVersion(current) != version {
+		return errors.Wrapf(ErrChanged, "%s", key)
+	}
+
+	return change()
+}
+
+// takeSwapGuard creates the guard file guard with O_EXCL and returns
+// the function that removes it. A guard older than localSwapStaleAfter
+// was left behind by a crashed writer; it is removed and taken once
+// more rather than failing every conditional write from then on. A
+// guard held by a live writer fails with fs.ErrExist.
+func takeSwapGuard(guard string) (func(), error) {
+	f, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, localFileMode)
+	if errors.Is(err, fs.ErrExist) {
+		if info, statErr := os.Stat(guard); statErr == nil && time.Since(info.ModTime()) > localSwapStaleAfter {
+			_ = os.Remove(guard)
+
+			f, err = os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, localFileMode)
+		}
+	}
+
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the caller wraps it with the key.
+	}
+
+	_ = f.Close()
+
+	return func() {
+		_ = os.Remove(guard) // best effort: the guard only matters while it is held
+	}, nil
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Delete implements Backend.
+func (l *Local) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrapf(err, "removing %s", p)
+	}
+
+	return nil
+}
+
+// List implements Backend.
+func (l *Local) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(l.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+
+		key := path.Clean(filepath.ToSlash(rel))
+		if strings.HasPrefix(key, prefix) && !strings.HasSuffix(key, localSwapSuffix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %s", l.Dir)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
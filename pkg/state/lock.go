@@ -0,0 +1,242 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrLocked is returned by AcquireLock when another operator holds an
+// unexpired lock.
+var ErrLocked = errors.New("state is locked")
+
+// ErrLockLost is returned by Renew when the lock expired and was taken
+// over by another operator, or was removed.
+var ErrLockLost = errors.New("lock is no longer held")
+
+// lockIDBytes is the entropy of a lock's ID; release compares it so an
+// operator never deletes a lock that expired and was taken over.
+const lockIDBytes = 8
+
+// LockInfo is the content of a lock object.
+type LockInfo struct {
+	ID        string    `json:"id"`
+	Operator  string    `json:"operator"`
+	Operation string    `json:"operation"`
+	Acquired  time.Time `json:"acquired"`
+	Expires   time.Time `json:"expires"`
+}
+
+// LockKey is the backend key of the lock called name.
+func LockKey(name string) string {
+	return "locks/" + name + ".json"
+}
+
+// Lock is a held lock.
+type Lock struct {
+	backend Backend
+	name    string
+	info    LockInfo
+}
+
+// AcquireLock takes the lock called name for operator and operation.
+// The lock expires after ttl, so a talm that crashed while holding it
+// blocks others for at most that long: an expired lock is taken over
+// by replacing it only while it is still the version read. When another
+// operator holds a live lock the error is marked ErrLocked and names
+// the holder.
+func AcquireLock(ctx context.Context, backend Backend, name, operator, operation string, ttl time.Duration, now time.Time) (*Lock, error) {
+	id := make([]byte, lockIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "generating lock id")
+	}
+
+	info := LockInfo{
+		ID:        hex.EncodeToString(id),
+		Operator:  operator,
+		Operation: operation,
+		Acquired:  now.UTC(),
+		Expires:   now.Add(ttl).UTC(),
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding lock")
+	}
+
+	key := LockKey(name)
+
+	// Two attempts: the second one follows a lost takeover of an
+	// expired lock. Operators taking over the same expired lock all
+	// replace the version they read, so one of them wins and the others
+	// find a live lock on the next attempt.
+	for range 2 {
+		err = backend.PutIfAbsent(ctx, key, data)
+		if err == nil {
+			return &Lock{backend: backend, name: name, info: info}, nil
+		}
+
+		if !errors.Is(err, ErrExists) {
+			return nil, errors.Wrapf(err, "taking lock %s in %s", name, backend.Describe())
+		}
+
+		current, version, readErr := backend.GetVersion(ctx, key)
+		if readErr != nil {
+			if errors.Is(readErr, ErrNotFound) {
+				continue
+			}
+
+			return nil, readErr //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+		}
+
+		holder, readErr := decodeLock(name, current)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		if now.Before(holder.Expires) {
+			return nil, errors.Mark(
+				errors.Newf("%s in %s is held by %s for %s since %s (expires %s)",
+					key, backend.Describe(), holder.Operator, holder.Operation,
+					holder.Acquired.Format(time.RFC3339), holder.Expires.Format(time.RFC3339)),
+				ErrLocked,
+			)
+		}
+
+		err = backend.PutIfVersion(ctx, key, data, version)
+		if err == nil {
+			return &Lock{backend: backend, name: name, info: info}, nil
+		}
+
+		if !errors.Is(err, ErrChanged) {
+			return nil, errors.Wrapf(err, "replacing expired lock %s", name)
+		}
+	}
+
+	return nil, errors.Mark(errors.Newf("%s in %s was taken by another operator while replacing an expired lock", key, backend.Describe()), ErrLocked)
+}
+
+// ReadLock returns the current holder of the lock called name, or
+// ErrNotFound.
+func ReadLock(ctx context.Context, backend Backend, name string) (*LockInfo, error) {
+	data, err := backend.Get(ctx, LockKey(name))
+	if err != nil {
+		return nil, err //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+	}
+
+	return decodeLock(name, data)
+}
+
+func decodeLock(name string, data []byte) (*LockInfo, error) {
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.Wrapf(err, "decoding lock %s", name)
+	}
+
+	return &info, nil
+}
+
+// Info returns the content of the held lock.
+func (l *Lock) Info() LockInfo {
+	return l.info
+}
+
+// Renew extends the held lock to expire ttl after now, so an operation
+// that outlives the TTL is not taken over while it runs. The lock is
+// replaced only while it is still the version read; a lock that
+// expired and was taken over in the meantime is left alone and Renew
+// fails with ErrLockLost. Renew and Release must not run concurrently.
+func (l *Lock) Renew(ctx context.Context, ttl time.Duration, now time.Time) error {
+	key := LockKey(l.name)
+
+	version, err := l.version(ctx)
+	if err != nil {
+		return err
+	}
+
+	info := l.info
+	info.Expires = now.Add(ttl).UTC()
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "encoding lock")
+	}
+
+	err = l.backend.PutIfVersion(ctx, key, data, version)
+	if errors.Is(err, ErrChanged) {
+		return errors.Wrapf(ErrLockLost, "%s in %s", key, l.backend.Describe())
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "renewing lock %s", l.name)
+	}
+
+	l.info = info
+
+	return nil
+}
+
+// Release deletes the lock if it is still ours. A lock that expired
+// and was taken over by someone else, before or while it is released,
+// is left alone.
+func (l *Lock) Release(ctx context.Context) error {
+	version, err := l.version(ctx)
+	if errors.Is(err, ErrLockLost) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = l.backend.DeleteIfVersion(ctx, LockKey(l.name), version)
+	if errors.Is(err, ErrChanged) {
+		return nil
+	}
+
+	return err //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+}
+
+// version returns the version of the lock object while it is still
+// ours, and ErrLockLost once it is gone or held by someone else.
+func (l *Lock) version(ctx context.Context) (string, error) {
+	key := LockKey(l.name)
+
+	data, version, err := l.backend.GetVersion(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return "", errors.Wrapf(ErrLockLost, "%s in %s", key, l.backend.Describe())
+	}
+
+	if err != nil {
+		return "", err //nolint:wrapcheck // Backend errors are already wrapped with the key and location.
+	}
+
+	current, err := decodeLock(l.name, data)
+	if err != nil {
+		return "", err
+	}
+
+	if current.ID != l.info.ID {
+		return "", errors.Wrapf(ErrLockLost, "%s in %s is now held by %s", key, l.backend.Describe(), current.Operator)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,311 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// TestAcquireLock_Exclusive pins that a live lock blocks a second
+// operator with an error naming the holder, and that releasing it lets
+// the next operator in.
+func TestAcquireLock_Exclusive(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	held, err := AcquireLock(ctx, backend, "apply", "alice", "apply nodes/cp1.yaml", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = AcquireLock(ctx, backend, "apply", "bob", "apply nodes/cp2.yaml", time.Hour, now.Add(time.Minute))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second acquire = %v, want ErrLocked", err)
+	}
+
+	if !strings.Contains(err.Error(), "alice") || !strings.Contains(err.Error(), "nodes/cp1.yaml") {
+		t.Errorf("error must name the holder and operation, got %v", err)
+	}
+
+	if err := held.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AcquireLock(ctx, backend, "apply", "bob", "apply", time.Hour, now.Add(time.Minute)); err != nil {
+		t.Errorf("acquire after release = %v", err)
+	}
+}
+
+// TestAcquireLock_TakesOverExpired pins the crash recovery path: an
+// expired lock is replaced, and the original holder's late Release does
+// not delete the new holder's lock.
+func TestAcquireLock_TakesOverExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	stale, err := AcquireLock(ctx, backend, "apply", "alice", "apply", time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := AcquireLock(ctx, backend, "apply", "bob", "apply", time.Minute, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("expired lock must be taken over: %v", err)
+	}
+
+	if err := stale.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	holder, err := ReadLock(ctx, backend, "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if holder.ID != fresh.Info().ID || holder.Operator != "bob" {
+		t.Errorf("stale release removed the new lock; holder = %+v", holder)
+	}
+}
+
+// interleavingBackend runs afterRead once, right after serving the
+// first read, so another operator can act between an operator's read
+// of a lock and its write.
+type interleavingBackend struct {
+	Backend
+
+	once      sync.Once
+	afterRead func()
+}
+
+func (b *interleavingBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := b.Backend.Get(ctx, key)
+	b.once.Do(b.afterRead)
+
+	return data, err //nolint:wrapcheck // test wrapper.
+}
+
+func (b *interleavingBackend) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, version, err := b.Backend.GetVersion(ctx, key)
+	b.once.Do(b.afterRead)
+
+	return data, version, err //nolint:wrapcheck // test wrapper.
+}
+
+// TestAcquireLock_TakeoverRaceHasOneWinner pins the race on an expired
+// lock: bob takes it over after alice read it and before she writes.
+// Alice's takeover replaces only the version she read, so she finds
+// bob's live lock instead of replacing it.
+func TestAcquireLock_TakeoverRaceHasOneWinner(t *testing.T) {
+	backends := map[string]func(t *testing.T) Backend{
+		KindLocal: func(t *testing.T) Backend { return NewLocal(t.TempDir()) },
+		KindS3:    func(t *testing.T) Backend { store, _ := newTestObjectStore(t, KindS3); return store },
+		KindGCS:   func(t *testing.T) Backend { store, _ := newTestObjectStore(t, KindGCS); return store },
+	}
+
+	for kind, open := range backends {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			backend := open(t)
+			now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+			later := now.Add(2 * time.Minute)
+
+			if _, err := AcquireLock(ctx, backend, "apply", "crashed", "apply", time.Minute, now); err != nil {
+				t.Fatal(err)
+			}
+
+			var bobErr error
+
+			alice := &interleavingBackend{Backend: backend, afterRead: func() {
+				_, bobErr = AcquireLock(ctx, backend, "apply", "bob", "apply", time.Hour, later)
+			}}
+
+			_, err := AcquireLock(ctx, alice, "apply", "alice", "apply", time.Hour, later)
+			if bobErr != nil {
+				t.Fatalf("bob's takeover: %v", bobErr)
+			}
+
+			if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), "bob") {
+				t.Errorf("alice's takeover = %v, want ErrLocked naming bob", err)
+			}
+
+			holder, err := ReadLock(ctx, backend, "apply")
+			if err != nil || holder.Operator != "bob" {
+				t.Errorf("holder = %+v, %v; want bob", holder, err)
+			}
+		})
+	}
+}
+
+// TestLock_ReleaseRaceKeepsNewHolder pins that Release deletes only
+// the version of the lock it read: bob takes over alice's expired lock
+// after she read it and before she deletes it, and his lock survives.
+func TestLock_ReleaseRaceKeepsNewHolder(t *testing.T) {
+	backends := map[string]func(t *testing.T) Backend{
+		KindLocal: func(t *testing.T) Backend { return NewLocal(t.TempDir()) },
+		KindS3:    func(t *testing.T) Backend { store, _ := newTestObjectStore(t, KindS3); return store },
+		KindGCS:   func(t *testing.T) Backend { store, _ := newTestObjectStore(t, KindGCS); return store },
+	}
+
+	for kind, open := range backends {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			backend := open(t)
+			now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+			var bobErr error
+
+			alice := &interleavingBackend{Backend: backend, afterRead: func() {
+				_, bobErr = AcquireLock(ctx, backend, "apply", "bob", "apply", time.Hour, now.Add(2*time.Minute))
+			}}
+
+			held, err := AcquireLock(ctx, alice, "apply", "alice", "apply", time.Minute, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := held.Release(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if bobErr != nil {
+				t.Fatalf("bob's takeover: %v", bobErr)
+			}
+
+			holder, err := ReadLock(ctx, backend, "apply")
+			if err != nil || holder.Operator != "bob" {
+				t.Errorf("holder = %+v, %v; want bob", holder, err)
+			}
+		})
+	}
+}
+
+// TestLock_Renew pins that a renewed lock is not taken over at its
+// original expiry, and that renewing a lock taken over since fails
+// with ErrLockLost and leaves the new holder alone.
+func TestLock_Renew(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	held, err := AcquireLock(ctx, backend, "apply", "alice", "apply", time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := held.Renew(ctx, time.Minute, now.Add(50*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := now.Add(110 * time.Second); !held.Info().Expires.Equal(want) {
+		t.Errorf("expires = %s, want %s", held.Info().Expires, want)
+	}
+
+	if _, err := AcquireLock(ctx, backend, "apply", "bob", "apply", time.Minute, now.Add(90*time.Second)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("a renewed lock must not be taken over, got %v", err)
+	}
+
+	if _, err := AcquireLock(ctx, backend, "apply", "bob", "apply", time.Hour, now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := held.Renew(ctx, time.Minute, now.Add(3*time.Minute)); !errors.Is(err, ErrLockLost) {
+		t.Errorf("renewing a lock taken over = %v, want ErrLockLost", err)
+	}
+
+	if holder, err := ReadLock(ctx, backend, "apply"); err != nil || holder.Operator != "bob" {
+		t.Errorf("holder = %+v, %v; want bob", holder, err)
+	}
+}
+
+func TestHistory_Limit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, file := range []string{"nodes/a.yaml", "nodes/b.yaml", "nodes/c.yaml"} {
+		rec := Record{Time: start.Add(time.Duration(i) * time.Second), Operator: "alice", Operation: "apply", File: file}
+		if err := AppendHistory(ctx, backend, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadHistory(ctx, backend, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[0].File != "nodes/b.yaml" || records[1].File != "nodes/c.yaml" {
+		t.Errorf("ReadHistory(2) = %+v, want the last two records oldest first", records)
+	}
+}
+
+func TestHistory_RoundTripsChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+
+	rec := Record{
+		Time:      time.Date(2026, 5, 1, 12, 0, 30, 0, time.UTC),
+		Duration:  30 * time.Second,
+		Operator:  "alice",
+		Operation: "upgrade",
+		Image:     "ghcr.io/siderolabs/installer:v1.13.1",
+		Changes: []NodeChange{
+			{Node: "192.0.2.10", VersionBefore: "v1.13.0", VersionAfter: "v1.13.1"},
+			{Node: "192.0.2.11", Previewed: true, Updated: 1, Fields: 3, Applied: true, Resumed: true},
+			{Node: "192.0.2.12", Error: "applying new configuration: timeout"},
+		},
+	}
+	if err := AppendHistory(ctx, backend, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadHistory(ctx, backend, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	got := records[0]
+	if !strings.HasPrefix(got.ID, "20260501T120030.000000000Z-") {
+		t.Errorf("ID = %q, want the key without prefix and extension", got.ID)
+	}
+
+	got.ID = ""
+	if !reflect.DeepEqual(got, rec) {
+		t.Errorf("round trip = %+v\nwant %+v", got, rec)
+	}
+
+	if want := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC); !got.Started().Equal(want) {
+		t.Errorf("Started = %v, want %v", got.Started(), want)
+	}
+}
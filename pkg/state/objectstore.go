@@ -0,0 +1,564 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/httpclient"
+)
+
+const (
+	defaultS3Region    = "us-east-1"
+	gcsSigningRegion   = "auto"
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+
+	defaultS3AccessKeyEnv  = "AWS_ACCESS_KEY_ID"
+	defaultS3SecretKeyEnv  = "AWS_SECRET_ACCESS_KEY"
+	s3SessionTokenEnv      = "AWS_SESSION_TOKEN"
+	defaultGCSAccessKeyEnv = "GCS_HMAC_ACCESS_ID"
+	defaultGCSSecretKeyEnv = "GCS_HMAC_SECRET"
+
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Service   = "s3"
+	amzDateFormat  = "20060102T150405Z"
+	amzDayFormat   = "20060102"
+
+	// objectStoreTimeout bounds one request so an unreachable bucket
+	// falls back to the local directory instead of hanging the apply.
+	objectStoreTimeout = 30 * time.Second
+
+	// errorBodyLimit caps how much of an error response is quoted in
+	// the returned error.
+	errorBodyLimit = 512
+)
+
+// ObjectStore is a Backend over the S3 REST API. GCS is reached
+// through its S3-compatible XML API with HMAC keys, so one client
+// covers both. Requests are signed with AWS Signature Version 4 and use
+// path-style addressing (endpoint/bucket/key), which S3, GCS and the
+// common S3-compatible stores all accept. There is deliberately no
+// cloud SDK behind it: talm only needs five object operations.
+type ObjectStore struct {
+	kind         string
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+// NewObjectStore builds the s3 or gcs backend described by cfg,
+// reading credentials from the environment variables it names.
+func NewObjectStore(cfg Config) (*ObjectStore, error) {
+	if cfg.Bucket == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("state.backend %q needs state.bucket", cfg.Backend),
+			"set state.bucket in Chart.yaml to the %s bucket holding the project state", cfg.Backend,
+		)
+	}
+
+	store := &ObjectStore{
+		kind:   cfg.Backend,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+		client: httpclient.New(objectStoreTimeout),
+		now:    time.Now,
+	}
+
+	endpoint, accessEnv, secretEnv := cfg.Endpoint, cfg.AccessKeyEnv, cfg.SecretKeyEnv
+
+	switch cfg.Backend {
+	case KindGCS:
+		store.region = gcsSigningRegion
+		endpoint = firstNonEmpty(endpoint, defaultGCSEndpoint)
+		accessEnv = firstNonEmpty(accessEnv, defaultGCSAccessKeyEnv)
+		secretEnv = firstNonEmpty(secretEnv, defaultGCSSecretKeyEnv)
+	default:
+		store.region = firstNonEmpty(cfg.Region, defaultS3Region)
+		endpoint = firstNonEmpty(endpoint, "https://s3."+store.region+".amazonaws.com")
+		accessEnv = firstNonEmpty(accessEnv, defaultS3AccessKeyEnv)
+		secretEnv = firstNonEmpty(secretEnv, defaultS3SecretKeyEnv)
+		store.sessionToken = os.Getenv(s3SessionTokenEnv)
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("invalid state.endpoint %q", endpoint),
+			"state.endpoint must be an absolute URL such as https://minio.example.com:9000",
+		)
+	}
+
+	store.endpoint = parsed
+	store.accessKey = os.Getenv(accessEnv)
+	store.secretKey = os.Getenv(secretEnv)
+
+	if store.accessKey == "" || store.secretKey == "" {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHintf(
+			errors.Newf("credentials for the %s state backend are not set", cfg.Backend),
+			"export %s and %s, or name other variables with state.accessKeyEnv / state.secretKeyEnv in Chart.yaml", accessEnv, secretEnv,
+		)
+	}
+
+	return store, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Describe implements Backend.
+func (s *ObjectStore) Describe() string {
+	scheme := "s3"
+	if s.kind == KindGCS {
+		scheme = "gs"
+	}
+
+	if s.prefix == "" {
+		return fmt.Sprintf("%s://%s", scheme, s.bucket)
+	}
+
+	return fmt.Sprintf("%s://%s/%s", scheme, s.bucket, s.prefix)
+}
+
+func (s *ObjectStore) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+
+	return s.prefix + "/" + key
+}
+
+// Get implements Backend.
+func (s *ObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := s.get(ctx, key)
+
+	return data, err
+}
+
+// GetVersion implements Backend. The version is the ETag on S3 and the
+// object generation on GCS, the values their conditional writes
+// compare against.
+func (s *ObjectStore) GetVersion(ctx context.Context, key string) ([]byte, string, error) {
+	data, header, err := s.get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := header.Get("ETag")
+	if s.kind == KindGCS {
+		version = header.Get("X-Goog-Generation")
+	}
+
+	if version == "" {
+		return nil, "", errors.Newf("reading %s from %s: the response carries no object version", key, s.Describe())
+	}
+
+	return data, version, nil
+}
+
+func (s *ObjectStore) get(ctx context.Context, key string) ([]byte, http.Header, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectKey(key), nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, errors.Wrapf(ErrNotFound, "%s", key)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, s.statusError(resp, "reading", key)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+
+	return data, resp.Header, errors.Wrapf(err, "reading %s from %s", key, s.Describe())
+}
+
+// Put implements Backend.
+func (s *ObjectStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.put(ctx, key, data, nil, nil)
+}
+
+// PutIfAbsent implements Backend with a conditional write: S3 honours
+// If-None-Match: *, the GCS XML API x-goog-if-generation-match: 0.
+// Both answer 412 when the object already exists.
+func (s *ObjectStore) PutIfAbsent(ctx context.Context, key string, data []byte) error {
+	header := http.Header{}
+	if s.kind == KindGCS {
+		header.Set("X-Goog-If-Generation-Match", "0")
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+
+	return s.put(ctx, key, data, header, ErrExists)
+}
+
+// PutIfVersion implements Backend with a conditional write: S3 honours
+// If-Match: <etag>, the GCS XML API x-goog-if-generation-match:
+// <generation>. Both answer 412 for an object replaced since; S3
+// answers 404 for one deleted since.
+func (s *ObjectStore) PutIfVersion(ctx context.Context, key string, data []byte, version string) error {
+	header := http.Header{}
+	if s.kind == KindGCS {
+		header.Set("X-Goog-If-Generation-Match", version)
+	} else {
+		header.Set("If-Match", version)
+	}
+
+	return s.put(ctx, key, data, header, ErrChanged)
+}
+
+// put writes data under key. header carries the precondition of a
+// conditional write, and failed is the error its failure returns.
+func (s *ObjectStore) put(ctx context.Context, key string, data []byte, header http.Header, failed error) error {
+	resp, err := s.do(ctx, http.MethodPut, s.objectKey(key), nil, header, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		if failed != nil {
+			return errors.Wrapf(failed, "%s", key)
+		}
+	case http.StatusNotFound:
+		if errors.Is(failed, ErrChanged) {
+			return errors.Wrapf(failed, "%s", key)
+		}
+	}
+
+	return s.statusError(resp, "writing", key)
+}
+
+// Delete implements Backend.
+func (s *ObjectStore) Delete(ctx context.Context, key string) error {
+	return s.delete(ctx, key, nil)
+}
+
+// DeleteIfVersion implements Backend with a conditional delete, with
+// the same preconditions as PutIfVersion. A 412, or S3's 404 for an
+// object deleted since, is ErrChanged.
+func (s *ObjectStore) DeleteIfVersion(ctx context.Context, key, version string) error {
+	header := http.Header{}
+	if s.kind == KindGCS {
+		header.Set("X-Goog-If-Generation-Match", version)
+	} else {
+		header.Set("If-Match", version)
+	}
+
+	return s.delete(ctx, key, header)
+}
+
+// delete removes key. header carries the precondition of a
+// conditional delete.
+func (s *ObjectStore) delete(ctx context.Context, key string, header http.Header) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectKey(key), nil, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		if header == nil {
+			return nil
+		}
+
+		return errors.Wrapf(ErrChanged, "%s", key)
+	case http.StatusPreconditionFailed:
+		if header != nil {
+			return errors.Wrapf(ErrChanged, "%s", key)
+		}
+	}
+
+	return s.statusError(resp, "deleting", key)
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response talm
+// reads.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List implements Backend with ListObjectsV2, following continuation
+// tokens until the listing is complete.
+func (s *ObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var (
+		keys  []string
+		token string
+	)
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.objectKey(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		page, err := s.listPage(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Contents {
+			key := item.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+
+			keys = append(keys, key)
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+
+		token = page.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *ObjectStore) listPage(ctx context.Context, query url.Values) (*listBucketResult, error) {
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.statusError(resp, "listing", query.Get("prefix"))
+	}
+
+	var page listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, errors.Wrapf(err, "decoding listing of %s", s.Describe())
+	}
+
+	return &page, nil
+}
+
+func (s *ObjectStore) statusError(resp *http.Response, action, key string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit)) //nolint:errcheck // best-effort detail for the error message
+
+	return errors.Newf("%s %s in %s: %s: %s", action, key, s.Describe(), resp.Status, strings.TrimSpace(string(body)))
+}
+
+// do sends one signed request for objectKey (empty for bucket-level
+// requests such as listing).
+func (s *ObjectStore) do(ctx context.Context, method, objectKey string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + s.bucket
+	if objectKey != "" {
+		target.Path += "/" + objectKey
+	}
+
+	target.RawPath = escapePath(target.Path)
+	target.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "building request for %s", s.Describe())
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "contacting %s", s.Describe())
+	}
+
+	return resp, nil
+}
+
+// sign adds the SigV4 Authorization header. The payload hash is part
+// of the signature, so a request altered in transit is rejected.
+func (s *ObjectStore) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	day := now.Format(amzDayFormat)
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "x-goog-") || lower == "if-none-match" || lower == "if-match" {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{day, s.region, sigV4Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, sigV4Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes every byte of p except the RFC 3986
+// unreserved characters and '/', which is the URI encoding SigV4
+// expects in the canonical request.
+func escapePath(p string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(p) {
+		if c == '/' || isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key with SigV4 escaping; the
+// same string is sent on the wire so it matches the signature.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escapeQueryComponent(k)+"="+escapeQueryComponent(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func escapeQueryComponent(s string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
@@ -0,0 +1,339 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// fakeBucket is an in-memory S3 endpoint for one bucket. It checks that
+// every request is SigV4-signed, honours the conditional-create and
+// conditional-replace headers of both providers against a generation
+// per write, and pages listings two keys at a time.
+type fakeBucket struct {
+	t           *testing.T
+	bucket      string
+	mu          sync.Mutex
+	objects     map[string][]byte
+	generations map[string]int64
+	generation  int64
+}
+
+func (f *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") || !strings.Contains(auth, "SignedHeaders=host;") || !strings.Contains(auth, "x-amz-content-sha256;x-amz-date") {
+		f.t.Errorf("unsigned or malformed request %s %s: %q", r.Method, r.URL, auth)
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	key = strings.TrimPrefix(key, "/")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		generation := strconv.FormatInt(f.generations[key], 10)
+		w.Header().Set("ETag", `"`+generation+`"`)
+		w.Header().Set("X-Goog-Generation", generation)
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPut:
+		if status := f.precondition(r, key); status != 0 {
+			w.WriteHeader(status)
+
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		f.generation++
+		f.objects[key] = data
+		f.generations[key] = f.generation
+	case r.Method == http.MethodDelete:
+		if status := f.precondition(r, key); status != 0 {
+			w.WriteHeader(status)
+
+			return
+		}
+
+		delete(f.objects, key)
+		delete(f.generations, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// precondition returns the status a conditional PUT or DELETE of key
+// fails with,
+// or 0 when it may proceed: S3 answers 404 for If-Match on a missing
+// object, everything else that does not hold is 412.
+func (f *fakeBucket) precondition(r *http.Request, key string) int {
+	_, exists := f.objects[key]
+	generation := strconv.FormatInt(f.generations[key], 10)
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !strings.Contains(r.Header.Get("Authorization"), "if-match") {
+			f.t.Errorf("If-Match must be signed")
+		}
+
+		switch {
+		case !exists:
+			return http.StatusNotFound
+		case match != `"`+generation+`"`:
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		return http.StatusPreconditionFailed
+	}
+
+	switch match := r.Header.Get("X-Goog-If-Generation-Match"); {
+	case match == "":
+	case match == "0":
+		if exists {
+			return http.StatusPreconditionFailed
+		}
+	case !exists || match != generation:
+		return http.StatusPreconditionFailed
+	}
+
+	return 0
+}
+
+func (f *fakeBucket) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+
+	var keys []string
+
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token)
+	}
+
+	type content struct {
+		Key string `xml:"Key"`
+	}
+
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+
+	end := min(start+2, len(keys))
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{Key: key})
+	}
+
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[end]
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func newTestObjectStore(t *testing.T, kind string) (*ObjectStore, *fakeBucket) {
+	t.Helper()
+
+	fake := &fakeBucket{t: t, bucket: "talm-state", objects: map[string][]byte{}, generations: map[string]int64{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("TEST_STATE_ACCESS", "AKIDTEST")
+	t.Setenv("TEST_STATE_SECRET", "secret")
+
+	store, err := NewObjectStore(Config{
+		Backend:      kind,
+		Bucket:       "talm-state",
+		Prefix:       "clusters/prod",
+		Endpoint:     server.URL,
+		AccessKeyEnv: "TEST_STATE_ACCESS",
+		SecretKeyEnv: "TEST_STATE_SECRET",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, fake
+}
+
+// TestObjectStore_Operations pins the backend contract over the S3
+// REST API for both providers: prefixed keys, conditional create,
+// paginated listing, conditional replace, idempotent delete and
+// conditional delete.
+func TestObjectStore_Operations(t *testing.T) {
+	for _, kind := range []string{KindS3, KindGCS} {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			store, fake := newTestObjectStore(t, kind)
+
+			for _, key := range []string{"history/1.json", "history/2.json", "history/3.json", "locks/apply.json"} {
+				if err := store.Put(ctx, key, []byte(key)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fake.mu.Lock()
+			_, prefixed := fake.objects["clusters/prod/history/1.json"]
+			fake.mu.Unlock()
+
+			if !prefixed {
+				t.Error("keys must be stored under the prefix")
+			}
+
+			data, err := store.Get(ctx, "history/2.json")
+			if err != nil || string(data) != "history/2.json" {
+				t.Errorf("Get = %q, %v", data, err)
+			}
+
+			if _, err := store.Get(ctx, "history/9.json"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+			}
+
+			keys, err := store.List(ctx, HistoryPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"history/1.json", "history/2.json", "history/3.json"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List = %v, want %v across pages", keys, want)
+			}
+
+			if err := store.PutIfAbsent(ctx, "locks/apply.json", nil); !errors.Is(err, ErrExists) {
+				t.Errorf("PutIfAbsent on an existing key = %v, want ErrExists", err)
+			}
+
+			if err := store.Delete(ctx, "locks/apply.json"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.PutIfAbsent(ctx, "locks/apply.json", []byte("x")); err != nil {
+				t.Errorf("PutIfAbsent after delete = %v", err)
+			}
+
+			_, version, err := store.GetVersion(ctx, "locks/apply.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.PutIfVersion(ctx, "locks/apply.json", []byte("y"), version); err != nil {
+				t.Errorf("PutIfVersion at the read version = %v", err)
+			}
+
+			if err := store.PutIfVersion(ctx, "locks/apply.json", []byte("z"), version); !errors.Is(err, ErrChanged) {
+				t.Errorf("PutIfVersion at a replaced version = %v, want ErrChanged", err)
+			}
+
+			if err := store.Delete(ctx, "locks/apply.json"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.PutIfVersion(ctx, "locks/apply.json", []byte("z"), version); !errors.Is(err, ErrChanged) {
+				t.Errorf("PutIfVersion of a deleted object = %v, want ErrChanged", err)
+			}
+
+			if err := store.PutIfAbsent(ctx, "locks/apply.json", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+
+			_, version, err = store.GetVersion(ctx, "locks/apply.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Put(ctx, "locks/apply.json", []byte("y")); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.DeleteIfVersion(ctx, "locks/apply.json", version); !errors.Is(err, ErrChanged) {
+				t.Errorf("DeleteIfVersion at a replaced version = %v, want ErrChanged", err)
+			}
+
+			_, version, err = store.GetVersion(ctx, "locks/apply.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.DeleteIfVersion(ctx, "locks/apply.json", version); err != nil {
+				t.Errorf("DeleteIfVersion at the read version = %v", err)
+			}
+
+			if err := store.DeleteIfVersion(ctx, "locks/apply.json", version); !errors.Is(err, ErrChanged) {
+				t.Errorf("DeleteIfVersion of a deleted object = %v, want ErrChanged", err)
+			}
+		})
+	}
+}
+
+func TestNewObjectStore_RequiresCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	_, err := NewObjectStore(Config{Backend: KindS3, Bucket: "b"})
+	if err == nil {
+		t.Fatal("expected an error without credentials")
+	}
+
+	if hints := strings.Join(errors.GetAllHints(err), "\n"); !strings.Contains(hints, "AWS_ACCESS_KEY_ID") {
+		t.Errorf("hint must name the credential variables, got %q", hints)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	t.Parallel()
+
+	if got := escapePath("/bucket/history/a b+c.json"); got != "/bucket/history/a%20b%2Bc.json" {
+		t.Errorf("escapePath = %q", got)
+	}
+}
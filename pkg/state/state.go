@@ -0,0 +1,156 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state stores the project state talm keeps outside the chart:
+// the apply history and the locks that stop two operators from applying
+// to the same cluster at once. By default the state lives in the
+// project directory under .talm/state and travels with git like the
+// rest of the project. Teams that do not want it in git configure an
+// S3 or GCS bucket under `state:` in Chart.yaml; writes that cannot
+// reach the bucket fall back to the local directory, locks do not.
+package state
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Backend kinds accepted in Chart.yaml `state.backend`.
+const (
+	KindLocal = "local"
+	KindS3    = "s3"
+	KindGCS   = "gcs"
+)
+
+// LocalDir is the project-relative directory of the local backend.
+const LocalDir = ".talm/state"
+
+var (
+	// ErrNotFound is returned by Get for a key that does not exist.
+	ErrNotFound = errors.New("state object not found")
+
+	// ErrExists is returned by PutIfAbsent when the key already exists.
+	ErrExists = errors.New("state object already exists")
+
+	// ErrChanged is returned by PutIfVersion and DeleteIfVersion when
+	// the object was replaced or deleted since its version was read.
+	ErrChanged = errors.New("state object changed")
+)
+
+// Backend is a flat key/value object store. Keys are slash-separated
+// relative paths such as "history/<id>.json"; values are opaque bytes.
+type Backend interface {
+	// Get returns the object stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores data under key, replacing any previous object.
+	Put(ctx context.Context, key string, data []byte) error
+	// PutIfAbsent stores data under key only when no object exists
+	// there yet, atomically with respect to other writers; it returns
+	// ErrExists otherwise. Locks are built on it.
+	PutIfAbsent(ctx context.Context, key string, data []byte) error
+	// GetVersion returns the object stored under key with its version,
+	// an opaque token for PutIfVersion, or ErrNotFound.
+	GetVersion(ctx context.Context, key string) ([]byte, string, error)
+	// PutIfVersion replaces the object under key only while it is
+	// still at version, atomically with respect to other writers; it
+	// returns ErrChanged otherwise. Taking over an expired lock is
+	// built on it.
+	PutIfVersion(ctx context.Context, key string, data []byte, version string) error
+	// DeleteIfVersion removes key only while it is still at version,
+	// atomically with respect to other writers; it returns ErrChanged
+	// otherwise. Releasing a lock is built on it.
+	DeleteIfVersion(ctx context.Context, key, version string) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys under prefix in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Describe names the backend location for operator messages.
+	Describe() string
+}
+
+// Config is the Chart.yaml `state:` section.
+type Config struct {
+	// Backend is "local" (default), "s3" or "gcs".
+	Backend string `yaml:"backend"`
+	// Bucket is the bucket name for the s3 and gcs backends.
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to every key, so one bucket can hold the
+	// state of several projects.
+	Prefix string `yaml:"prefix"`
+	// Region is the S3 signing region; gcs always signs with "auto".
+	Region string `yaml:"region"`
+	// Endpoint overrides the service URL, e.g. for MinIO or another
+	// S3-compatible store. Requests use path-style addressing.
+	Endpoint string `yaml:"endpoint"`
+	// AccessKeyEnv and SecretKeyEnv name the environment variables
+	// holding the credentials, so no secret lands in Chart.yaml.
+	// Defaults: AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY for s3,
+	// GCS_HMAC_ACCESS_ID / GCS_HMAC_SECRET for gcs (HMAC keys of the
+	// XML interoperability API).
+	AccessKeyEnv string `yaml:"accessKeyEnv"`
+	SecretKeyEnv string `yaml:"secretKeyEnv"`
+	// LockTTL bounds how long a lock left behind by a crashed talm
+	// blocks other operators. Empty means DefaultLockTTL.
+	LockTTL string `yaml:"lockTTL"`
+}
+
+// DefaultLockTTL is the lifetime of a lock when Config.LockTTL is unset.
+const DefaultLockTTL = 30 * time.Minute
+
+// LockTTLDuration parses LockTTL, defaulting to DefaultLockTTL.
+func (c Config) LockTTLDuration() (time.Duration, error) {
+	if c.LockTTL == "" {
+		return DefaultLockTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(c.LockTTL)
+	if err != nil {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return 0, errors.WithHint(
+			errors.Wrapf(err, "parsing state.lockTTL %q", c.LockTTL),
+			"state.lockTTL in Chart.yaml must be a Go duration literal (e.g. \"30m\", \"1h\")",
+		)
+	}
+
+	return ttl, nil
+}
+
+// Open returns the backend configured by cfg for the project at
+// rootDir. A remote backend is wrapped in Fallback over the local
+// directory; warnings about falling back go to warn.
+func Open(cfg Config, rootDir string, warn io.Writer) (Backend, error) {
+	local := NewLocal(filepath.Join(rootDir, filepath.FromSlash(LocalDir)))
+
+	switch cfg.Backend {
+	case "", KindLocal:
+		return local, nil
+	case KindS3, KindGCS:
+		remote, err := NewObjectStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Fallback{Remote: remote, Local: local, Warn: warn}, nil
+	default:
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Newf("unknown state.backend %q", cfg.Backend),
+			"state.backend in Chart.yaml must be one of: local, s3, gcs",
+		)
+	}
+}
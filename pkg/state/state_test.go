@@ -0,0 +1,230 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestLocal_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(filepath.Join(t.TempDir(), "state"))
+
+	if keys, err := backend.List(ctx, ""); err != nil || len(keys) != 0 {
+		t.Fatalf("listing a missing directory = %v, %v; want empty", keys, err)
+	}
+
+	if _, err := backend.Get(ctx, "history/a.json"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing key = %v, want ErrNotFound", err)
+	}
+
+	for _, key := range []string{"history/b.json", "history/a.json", "locks/apply.json"} {
+		if err := backend.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := backend.Get(ctx, "history/a.json")
+	if err != nil || string(data) != "history/a.json" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+
+	keys, err := backend.List(ctx, HistoryPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"history/a.json", "history/b.json"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := backend.PutIfAbsent(ctx, "locks/apply.json", nil); !errors.Is(err, ErrExists) {
+		t.Errorf("PutIfAbsent on an existing key = %v, want ErrExists", err)
+	}
+
+	if err := backend.Delete(ctx, "locks/apply.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Delete(ctx, "locks/apply.json"); err != nil {
+		t.Errorf("deleting a missing key must succeed, got %v", err)
+	}
+
+	if err := backend.PutIfAbsent(ctx, "locks/apply.json", []byte("x")); err != nil {
+		t.Errorf("PutIfAbsent after delete = %v", err)
+	}
+}
+
+// TestLocal_ReclaimsStaleSwapGuard pins that a guard file left behind
+// by a writer that crashed while holding it blocks conditional writes
+// only until it is stale, not forever.
+func TestLocal_ReclaimsStaleSwapGuard(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := NewLocal(t.TempDir())
+
+	if err := backend.Put(ctx, "locks/apply.json", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, version, err := backend.GetVersion(ctx, "locks/apply.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guard := filepath.Join(backend.Dir, "locks", "apply.json"+localSwapSuffix)
+	if err := os.WriteFile(guard, nil, localFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.PutIfVersion(ctx, "locks/apply.json", []byte("y"), version); !errors.Is(err, ErrChanged) {
+		t.Fatalf("PutIfVersion under a live guard = %v, want ErrChanged", err)
+	}
+
+	stale := time.Now().Add(-2 * localSwapStaleAfter)
+	if err := os.Chtimes(guard, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.PutIfVersion(ctx, "locks/apply.json", []byte("y"), version); err != nil {
+		t.Fatalf("PutIfVersion under a stale guard = %v", err)
+	}
+
+	if _, err := os.Stat(guard); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("the reclaimed guard must be removed after the write, stat = %v", err)
+	}
+
+	if data, _ := backend.Get(ctx, "locks/apply.json"); string(data) != "y" {
+		t.Errorf("object = %q, want the replacement", data)
+	}
+}
+
+// TestLocal_RejectsEscapingKeys pins that a key cannot address a file
+// outside the state directory.
+func TestLocal_RejectsEscapingKeys(t *testing.T) {
+	t.Parallel()
+
+	backend := NewLocal(t.TempDir())
+	if err := backend.Put(context.Background(), "../outside.json", nil); err == nil {
+		t.Error("expected an error for a key leaving the state directory")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	backend, err := Open(Config{}, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(root, ".talm", "state"); backend.Describe() != want {
+		t.Errorf("default backend = %s, want %s", backend.Describe(), want)
+	}
+
+	if _, err := Open(Config{Backend: "ftp"}, root, nil); err == nil || !strings.Contains(err.Error(), `"ftp"`) {
+		t.Errorf("unknown backend error = %v", err)
+	}
+
+	if _, err := Open(Config{Backend: KindS3}, root, nil); err == nil || !strings.Contains(err.Error(), "state.bucket") {
+		t.Errorf("missing bucket error = %v", err)
+	}
+}
+
+func TestLockTTLDuration(t *testing.T) {
+	t.Parallel()
+
+	if ttl, err := (Config{}).LockTTLDuration(); err != nil || ttl != DefaultLockTTL {
+		t.Errorf("default = %v, %v", ttl, err)
+	}
+
+	if ttl, err := (Config{LockTTL: "5m"}).LockTTLDuration(); err != nil || ttl != 5*time.Minute {
+		t.Errorf("5m = %v, %v", ttl, err)
+	}
+
+	if _, err := (Config{LockTTL: "soon"}).LockTTLDuration(); err == nil {
+		t.Error("expected an error for a malformed lockTTL")
+	}
+}
+
+// failingBackend fails every operation, standing in for an unreachable
+// bucket.
+type failingBackend struct{}
+
+var errUnreachable = errors.New("bucket unreachable")
+
+func (failingBackend) Get(context.Context, string) ([]byte, error)       { return nil, errUnreachable }
+func (failingBackend) Put(context.Context, string, []byte) error         { return errUnreachable }
+func (failingBackend) PutIfAbsent(context.Context, string, []byte) error { return errUnreachable }
+func (failingBackend) Delete(context.Context, string) error              { return errUnreachable }
+func (failingBackend) List(context.Context, string) ([]string, error)    { return nil, errUnreachable }
+func (failingBackend) Describe() string                                  { return "s3://down" }
+
+func (failingBackend) GetVersion(context.Context, string) ([]byte, string, error) {
+	return nil, "", errUnreachable
+}
+
+func (failingBackend) PutIfVersion(context.Context, string, []byte, string) error {
+	return errUnreachable
+}
+
+func (failingBackend) DeleteIfVersion(context.Context, string, string) error {
+	return errUnreachable
+}
+
+// TestFallback_WritesLocallyButNeverLocks pins the outage behaviour:
+// history writes and reads degrade to the local directory with a
+// warning, while lock operations fail.
+func TestFallback_WritesLocallyButNeverLocks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	warn := &bytes.Buffer{}
+	local := NewLocal(t.TempDir())
+	backend := &Fallback{Remote: failingBackend{}, Local: local, Warn: warn}
+
+	rec := Record{Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Operator: "alice", Operation: "apply"}
+	if err := AppendHistory(ctx, backend, rec); err != nil {
+		t.Fatalf("AppendHistory must fall back to local: %v", err)
+	}
+
+	if !strings.Contains(warn.String(), "s3://down") {
+		t.Errorf("warning must name the remote, got %q", warn.String())
+	}
+
+	records, err := ReadHistory(ctx, backend, 0)
+	if err != nil || len(records) != 1 || records[0].Operator != "alice" {
+		t.Fatalf("ReadHistory = %+v, %v", records, err)
+	}
+
+	if _, err := AcquireLock(ctx, backend, "apply", "alice", "apply", time.Minute, time.Now()); !errors.Is(err, errUnreachable) {
+		t.Errorf("locking must not fall back to local, got %v", err)
+	}
+}
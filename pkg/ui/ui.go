@@ -0,0 +1,146 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ui renders talm's messages to the operator: progress lines,
+// successes, warnings and errors. Every level has one look across
+// commands — warnings carry a `Warning: ` prefix, errors are followed
+// by their `hint: ` lines — and is colored when written to a terminal.
+//
+// The root --quiet flag keeps only errors: progress, successes and
+// warnings are dropped, while the output a command exists to produce
+// (rendered configs, tables, JSON) is written to stdout as before.
+// --no-color and the NO_COLOR environment variable turn colors off.
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/term"
+)
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// warningPrefix starts every warning line.
+const warningPrefix = "Warning: "
+
+// hintPrefix starts every hint line printed under an error.
+const hintPrefix = "hint: "
+
+//nolint:gochecknoglobals // bound once from the root persistent flags; atomic so parallel tests may read it.
+var quiet, noColor atomic.Bool
+
+// Configure sets the output mode from the root --quiet and --no-color
+// flags. It is called once, before a command runs.
+func Configure(quietMode, disableColor bool) {
+	quiet.Store(quietMode)
+	noColor.Store(disableColor)
+}
+
+// Quiet reports whether only errors are printed.
+func Quiet() bool {
+	return quiet.Load()
+}
+
+// Progress returns w, or a writer that drops everything in quiet mode.
+// Commands hand it to helpers that take a progress writer.
+func Progress(w io.Writer) io.Writer {
+	if Quiet() {
+		return io.Discard
+	}
+
+	return w
+}
+
+// ColorEnabled reports whether w may be written ANSI colors: colors are
+// not turned off by --no-color or NO_COLOR, and w is a terminal.
+func ColorEnabled(w io.Writer) bool {
+	if noColor.Load() || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	f, ok := w.(*os.File)
+
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// Infof prints a progress line. Quiet mode drops it.
+func Infof(w io.Writer, format string, args ...any) {
+	if Quiet() {
+		return
+	}
+
+	fmt.Fprintf(w, format+"\n", args...)
+}
+
+// Successf prints the line that closes a command that worked, in green
+// on a terminal. Quiet mode drops it.
+func Successf(w io.Writer, format string, args ...any) {
+	if Quiet() {
+		return
+	}
+
+	fmt.Fprintln(w, colorize(w, ansiGreen, fmt.Sprintf(format, args...)))
+}
+
+// Warnf prints a warning line behind the `Warning: ` prefix, yellow on
+// a terminal. Quiet mode drops it.
+func Warnf(w io.Writer, format string, args ...any) {
+	if Quiet() {
+		return
+	}
+
+	fmt.Fprintln(w, colorize(w, ansiYellow, warningPrefix)+fmt.Sprintf(format, args...))
+}
+
+// WarnError prints err as a warning, followed by its hint lines, for a
+// failure a command goes on past. Quiet mode drops it.
+func WarnError(w io.Writer, err error) {
+	if Quiet() {
+		return
+	}
+
+	fmt.Fprintln(w, colorize(w, ansiYellow, warningPrefix)+err.Error())
+	writeHints(w, err)
+}
+
+// Error prints err, red on a terminal, followed by one `hint: ` line
+// per hint attached with cockroachdb/errors. It prints in quiet mode
+// too.
+func Error(w io.Writer, err error) {
+	fmt.Fprintln(w, colorize(w, ansiRed, err.Error()))
+	writeHints(w, err)
+}
+
+func writeHints(w io.Writer, err error) {
+	for _, hint := range errors.GetAllHints(err) {
+		fmt.Fprintln(w, hintPrefix+hint)
+	}
+}
+
+func colorize(w io.Writer, color, s string) string {
+	if !ColorEnabled(w) {
+		return s
+	}
+
+	return color + s + ansiReset
+}
@@ -0,0 +1,150 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ui_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/cozystack/talm/pkg/ui"
+)
+
+// configure sets the output mode for one test and restores the
+// default afterwards. Tests that call it must not run in parallel.
+func configure(t *testing.T, quiet, noColor bool) {
+	t.Helper()
+
+	ui.Configure(quiet, noColor)
+	t.Cleanup(func() { ui.Configure(false, false) })
+}
+
+// TestLevels pins the rendering of every level on a writer that is not
+// a terminal: plain text, one line each, warnings behind the shared
+// prefix and errors followed by their hints.
+func TestLevels(t *testing.T) {
+	configure(t, false, false)
+
+	var out bytes.Buffer
+
+	ui.Infof(&out, "Updating %s", "values.yaml")
+	ui.Successf(&out, "Encrypted %d file(s)", 2)
+	ui.Warnf(&out, "%s is still at its default location", "talm.key")
+	ui.WarnError(&out, errors.WithHint(errors.New("sync failed"), "run it again"))
+	ui.Error(&out, errors.WithHint(errors.New("no nodes to target"), "pass --nodes"))
+
+	want := "Updating values.yaml\n" +
+		"Encrypted 2 file(s)\n" +
+		"Warning: talm.key is still at its default location\n" +
+		"Warning: sync failed\n" +
+		"hint: run it again\n" +
+		"no nodes to target\n" +
+		"hint: pass --nodes\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+// TestQuiet pins that quiet mode keeps errors only, and that progress
+// writers handed to helpers drop everything.
+func TestQuiet(t *testing.T) {
+	configure(t, true, false)
+
+	var out bytes.Buffer
+
+	ui.Infof(&out, "progress")
+	ui.Successf(&out, "done")
+	ui.Warnf(&out, "careful")
+	ui.WarnError(&out, errors.New("careful"))
+	_, _ = io.WriteString(ui.Progress(&out), "helper progress\n")
+
+	if out.Len() != 0 {
+		t.Errorf("quiet mode printed %q", out.String())
+	}
+
+	ui.Error(&out, errors.New("failed"))
+
+	if out.String() != "failed\n" {
+		t.Errorf("quiet mode must still print errors, got %q", out.String())
+	}
+
+	if !ui.Quiet() {
+		t.Error("Quiet must report the configured mode")
+	}
+}
+
+func TestProgress_NotQuiet(t *testing.T) {
+	configure(t, false, false)
+
+	var out bytes.Buffer
+
+	if ui.Progress(&out) != &out {
+		t.Error("outside quiet mode the progress writer is the one passed in")
+	}
+}
+
+// TestColorEnabled pins that only terminals get colors: buffers and
+// regular files never do, whatever the flags.
+func TestColorEnabled(t *testing.T) {
+	configure(t, false, false)
+
+	if ui.ColorEnabled(&bytes.Buffer{}) {
+		t.Error("a buffer is not a terminal")
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if ui.ColorEnabled(f) {
+		t.Error("a regular file is not a terminal")
+	}
+
+	ui.Warnf(f, "plain")
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "Warning: plain\n" {
+		t.Errorf("file output = %q, want no escape codes", data)
+	}
+}
+
+func TestColorEnabled_NoColor(t *testing.T) {
+	configure(t, false, true)
+
+	if ui.ColorEnabled(os.Stderr) {
+		t.Error("--no-color must turn colors off")
+	}
+
+	ui.Configure(false, false)
+	t.Setenv("NO_COLOR", "1")
+
+	if ui.ColorEnabled(os.Stderr) {
+		t.Error("NO_COLOR must turn colors off")
+	}
+}
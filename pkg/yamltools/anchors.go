@@ -0,0 +1,251 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yamltools
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// MaxExpandedNodes bounds the number of YAML nodes ExpandAnchors may
+// produce. Nested aliases of aliases grow exponentially (the "billion
+// laughs" document); a million nodes leaves ample room for real values
+// files while stopping those long before memory runs out.
+const MaxExpandedNodes = 1 << 20
+
+// mergeKeyTag is the resolved tag of a `<<` merge key.
+const mergeKeyTag = "!!merge"
+
+// ErrAnchorCycle is returned for an alias inside the node its anchor
+// names.
+var ErrAnchorCycle = errors.New("YAML anchor contains itself")
+
+// ErrAnchorExpansionTooLarge is returned when expanding the aliases of
+// a document exceeds MaxExpandedNodes.
+var ErrAnchorExpansionTooLarge = errors.New("expanding the YAML aliases exceeds the node limit")
+
+// ExpandAnchors replaces every alias in the YAML documents of data with
+// a copy of the node its anchor names, resolves `<<` merge keys, and
+// drops the anchors. The expanded documents mean the same as the input
+// to any YAML reader, but the code walking yaml.Node trees and the
+// value encryption see each value where it is used. Data without
+// anchors, aliases or merge keys is returned unchanged.
+func ExpandAnchors(data []byte) ([]byte, error) {
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !documentsContainAnchors(docs) {
+		return data, nil
+	}
+
+	expander := anchorExpander{inProgress: map[*yaml.Node]bool{}}
+
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+
+	for _, doc := range docs {
+		expanded, err := expander.expand(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := encoder.Encode(expanded); err != nil {
+			return nil, errors.Wrap(err, "encode expanded YAML")
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, errors.Wrap(err, "encode expanded YAML")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeDocuments(data []byte) ([]*yaml.Node, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	var docs []*yaml.Node
+
+	for {
+		var doc yaml.Node
+
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "parse YAML")
+		}
+
+		docs = append(docs, &doc)
+	}
+}
+
+func documentsContainAnchors(docs []*yaml.Node) bool {
+	for _, doc := range docs {
+		if containsAnchors(doc) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsAnchors reports whether the tree below node defines an
+// anchor, uses an alias or has a merge key.
+func containsAnchors(node *yaml.Node) bool {
+	if node.Anchor != "" || node.Kind == yaml.AliasNode || isMergeKey(node) {
+		return true
+	}
+
+	for _, child := range node.Content {
+		if containsAnchors(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == mergeKeyTag
+}
+
+// anchorExpander copies node trees with their aliases expanded.
+// inProgress holds the anchored nodes whose alias is being expanded, so
+// an alias met again inside one is a cycle.
+type anchorExpander struct {
+	inProgress map[*yaml.Node]bool
+	nodes      int
+}
+
+func (e *anchorExpander) expand(node *yaml.Node) (*yaml.Node, error) {
+	if node.Kind == yaml.AliasNode {
+		target := node.Alias
+		if e.inProgress[target] {
+			//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+			return nil, errors.WithHintf(
+				errors.Wrapf(ErrAnchorCycle, "alias *%s at line %d", node.Value, node.Line),
+				"an alias cannot point at the node that contains it; move it out of the anchored &%s value", node.Value,
+			)
+		}
+
+		e.inProgress[target] = true
+		defer delete(e.inProgress, target)
+
+		return e.expand(target)
+	}
+
+	e.nodes++
+	if e.nodes > MaxExpandedNodes {
+		//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+		return nil, errors.WithHint(
+			errors.Wrapf(ErrAnchorExpansionTooLarge, "more than %d nodes", MaxExpandedNodes),
+			"aliases nested inside anchored values multiply on expansion; write the repeated values out or flatten the anchors",
+		)
+	}
+
+	out := *node
+	out.Anchor = ""
+	out.Alias = nil
+	out.Content = make([]*yaml.Node, 0, len(node.Content))
+
+	for _, child := range node.Content {
+		expanded, err := e.expand(child)
+		if err != nil {
+			return nil, err
+		}
+
+		out.Content = append(out.Content, expanded)
+	}
+
+	if out.Kind == yaml.MappingNode {
+		if err := resolveMergeKeys(&out); err != nil {
+			return nil, err
+		}
+	}
+
+	return &out, nil
+}
+
+// resolveMergeKeys replaces the `<<` keys of an expanded mapping with
+// the entries of the mappings they merge. As in YAML 1.1, a key set in
+// the mapping itself wins over a merged one, and an earlier merged
+// mapping wins over a later one.
+func resolveMergeKeys(mapping *yaml.Node) error {
+	hasMerge := false
+	explicit := map[string]bool{}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if isMergeKey(mapping.Content[i]) {
+			hasMerge = true
+		} else {
+			explicit[mapping.Content[i].Value] = true
+		}
+	}
+
+	if !hasMerge {
+		return nil
+	}
+
+	merged := map[string]bool{}
+	content := make([]*yaml.Node, 0, len(mapping.Content))
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if !isMergeKey(key) {
+			content = append(content, key, value)
+
+			continue
+		}
+
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+
+		for _, source := range sources {
+			if source.Kind != yaml.MappingNode {
+				//nolint:wrapcheck // cockroachdb/errors.WithHint at boundary.
+				return errors.WithHint(
+					errors.Newf("the << merge key at line %d merges a non-mapping value", key.Line),
+					"merge only mappings, e.g. <<: *defaults or <<: [*a, *b]",
+				)
+			}
+
+			for j := 0; j+1 < len(source.Content); j += 2 {
+				name := source.Content[j].Value
+				if explicit[name] || merged[name] {
+					continue
+				}
+
+				merged[name] = true
+				content = append(content, source.Content[j], source.Content[j+1])
+			}
+		}
+	}
+
+	mapping.Content = content
+
+	return nil
+}
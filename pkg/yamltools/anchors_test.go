@@ -0,0 +1,121 @@
+// Copyright Cozystack Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yamltools
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const anchorHeavyYAML = `defaults: &defaults
+  disk: /dev/sda
+  labels: &labels
+    zone: a
+    rack: "1"
+nodes:
+  cp1:
+    <<: *defaults
+    labels:
+      <<: *labels
+      rack: "2"
+  cp2:
+    <<: [*defaults, {disk: /dev/nvme0n1, extra: true}]
+    disk: /dev/vda
+  w1: *defaults
+`
+
+func TestExpandAnchors_PreservesSemantics(t *testing.T) {
+	t.Parallel()
+
+	expanded, err := ExpandAnchors([]byte(anchorHeavyYAML))
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(expanded), "&")
+	assert.NotContains(t, string(expanded), "*")
+	assert.NotContains(t, string(expanded), "<<")
+
+	var want, got map[string]any
+
+	require.NoError(t, yaml.Unmarshal([]byte(anchorHeavyYAML), &want))
+	require.NoError(t, yaml.Unmarshal(expanded, &got))
+	assert.Equal(t, want, got)
+
+	nodes, ok := got["nodes"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, map[string]any{"disk": "/dev/sda", "labels": map[string]any{"zone": "a", "rack": "2"}}, nodes["cp1"])
+	assert.Equal(t, map[string]any{"disk": "/dev/vda", "labels": map[string]any{"zone": "a", "rack": "1"}, "extra": true}, nodes["cp2"])
+}
+
+func TestExpandAnchors_MultiDocument(t *testing.T) {
+	t.Parallel()
+
+	in := "machine: &m\n  type: worker\nextra: *m\n---\napiVersion: v1alpha1\nkind: HostnameConfig\nhostname: w1\n"
+
+	expanded, err := ExpandAnchors([]byte(in))
+	require.NoError(t, err)
+	assert.Equal(t, "machine:\n  type: worker\nextra:\n  type: worker\n---\napiVersion: v1alpha1\nkind: HostnameConfig\nhostname: w1\n", string(expanded))
+}
+
+// TestExpandAnchors_UnchangedWithoutAnchors pins that plain YAML keeps
+// its bytes, comments and formatting included.
+func TestExpandAnchors_UnchangedWithoutAnchors(t *testing.T) {
+	t.Parallel()
+
+	in := "# values\nendpoint:   https://192.0.2.1:6443 # VIP\nemail: ops@example.com\n"
+
+	expanded, err := ExpandAnchors([]byte(in))
+	require.NoError(t, err)
+	assert.Equal(t, in, string(expanded))
+}
+
+func TestExpandAnchors_Cycle(t *testing.T) {
+	t.Parallel()
+
+	_, err := ExpandAnchors([]byte("a: &x\n  b: *x\n"))
+	require.ErrorIs(t, err, ErrAnchorCycle)
+	assert.Contains(t, err.Error(), "alias *x at line 2")
+	assert.Contains(t, strings.Join(errors.GetAllHints(err), "\n"), "move it out of the anchored &x value")
+}
+
+// TestExpandAnchors_TooLarge pins that a document whose aliases
+// multiply past MaxExpandedNodes fails instead of exhausting memory.
+func TestExpandAnchors_TooLarge(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+
+	b.WriteString("l0: &l0 [x, x, x, x, x, x, x, x, x, x]\n")
+
+	for i := 1; i <= 7; i++ {
+		fmt.Fprintf(&b, "l%[1]d: &l%[1]d [*l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d, *l%[2]d]\n", i, i-1)
+	}
+
+	_, err := ExpandAnchors([]byte(b.String()))
+	require.ErrorIs(t, err, ErrAnchorExpansionTooLarge)
+}
+
+func TestExpandAnchors_MergeNonMapping(t *testing.T) {
+	t.Parallel()
+
+	_, err := ExpandAnchors([]byte("list: &l [a, b]\nnode:\n  <<: *l\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the << merge key at line 3 merges a non-mapping value")
+}